@@ -0,0 +1,117 @@
+// Package bench provides reproducible benchmarks for the hot paths of gmqtt.
+//
+// The benchmarks can be run by "go test -bench . -benchmem ./bench",
+// or by the harness in bench/cmd/gmqttbench which emits the results in JSON format,
+// so that the results of different commits can be compared.
+package bench
+
+import (
+	"regexp"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// Benchmark is a named benchmark function.
+type Benchmark struct {
+	Name string
+	F    func(b *testing.B)
+}
+
+// Benchmarks is the list of all benchmarks.
+var Benchmarks = []Benchmark{
+	{Name: "PublishEncode", F: PublishEncode},
+	{Name: "PublishDecode", F: PublishDecode},
+	{Name: "TopicMatch", F: TopicMatch},
+	{Name: "TopicMatchWildcard", F: TopicMatchWildcard},
+	{Name: "SubscribeChurn", F: SubscribeChurn},
+	{Name: "QueueAddRead", F: QueueAddRead},
+	{Name: "ThroughputQos0", F: ThroughputQos0},
+	{Name: "ThroughputQos1", F: ThroughputQos1},
+}
+
+// Result is the result of a benchmark.
+type Result struct {
+	Name        string  `json:"name"`
+	N           int     `json:"n"`
+	NsPerOp     int64   `json:"ns_per_op"`
+	AllocsPerOp int64   `json:"allocs_per_op"`
+	BytesPerOp  int64   `json:"bytes_per_op"`
+	MBPerSec    float64 `json:"mb_per_sec,omitempty"`
+}
+
+// Report is the output of the harness.
+type Report struct {
+	Commit    string    `json:"commit,omitempty"`
+	GoVersion string    `json:"go_version"`
+	GOOS      string    `json:"goos"`
+	GOARCH    string    `json:"goarch"`
+	NumCPU    int       `json:"num_cpu"`
+	Time      time.Time `json:"time"`
+	Results   []Result  `json:"results"`
+}
+
+// Run runs the benchmarks whose name match the filter.
+// If filter is nil, all benchmarks will be run.
+func Run(commit string, filter *regexp.Regexp) *Report {
+	r := &Report{
+		Commit:    commit,
+		GoVersion: runtime.Version(),
+		GOOS:      runtime.GOOS,
+		GOARCH:    runtime.GOARCH,
+		NumCPU:    runtime.NumCPU(),
+		Time:      time.Now(),
+	}
+	for _, v := range Benchmarks {
+		if filter != nil && !filter.MatchString(v.Name) {
+			continue
+		}
+		rs := testing.Benchmark(v.F)
+		result := Result{
+			Name:        v.Name,
+			N:           rs.N,
+			NsPerOp:     rs.NsPerOp(),
+			AllocsPerOp: rs.AllocsPerOp(),
+			BytesPerOp:  rs.AllocedBytesPerOp(),
+		}
+		if rs.Bytes > 0 && rs.T > 0 {
+			result.MBPerSec = float64(rs.Bytes) * float64(rs.N) / 1e6 / rs.T.Seconds()
+		}
+		r.Results = append(r.Results, result)
+	}
+	return r
+}
+
+// Regression is a benchmark that is slower than the baseline.
+type Regression struct {
+	Name     string
+	Baseline int64
+	Current  int64
+	// Delta is the ratio of the increment of ns/op.
+	Delta float64
+}
+
+// Compare returns the benchmarks whose ns/op are increased more than the threshold ratio compared to the baseline.
+func Compare(baseline, current *Report, threshold float64) []Regression {
+	base := make(map[string]Result)
+	for _, v := range baseline.Results {
+		base[v.Name] = v
+	}
+	var rs []Regression
+	for _, v := range current.Results {
+		b, ok := base[v.Name]
+		if !ok || b.NsPerOp == 0 {
+			continue
+		}
+		delta := float64(v.NsPerOp-b.NsPerOp) / float64(b.NsPerOp)
+		if delta > threshold {
+			rs = append(rs, Regression{
+				Name:     v.Name,
+				Baseline: b.NsPerOp,
+				Current:  v.NsPerOp,
+				Delta:    delta,
+			})
+		}
+	}
+	return rs
+}
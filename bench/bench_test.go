@@ -0,0 +1,41 @@
+package bench
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func BenchmarkPublishEncode(b *testing.B)      { PublishEncode(b) }
+func BenchmarkPublishDecode(b *testing.B)      { PublishDecode(b) }
+func BenchmarkTopicMatch(b *testing.B)         { TopicMatch(b) }
+func BenchmarkTopicMatchWildcard(b *testing.B) { TopicMatchWildcard(b) }
+func BenchmarkSubscribeChurn(b *testing.B)     { SubscribeChurn(b) }
+func BenchmarkQueueAddRead(b *testing.B)       { QueueAddRead(b) }
+func BenchmarkThroughputQos0(b *testing.B)     { ThroughputQos0(b) }
+func BenchmarkThroughputQos1(b *testing.B)     { ThroughputQos1(b) }
+
+func TestCompare(t *testing.T) {
+	a := assert.New(t)
+	baseline := &Report{
+		Results: []Result{
+			{Name: "a", NsPerOp: 100},
+			{Name: "b", NsPerOp: 100},
+			{Name: "c", NsPerOp: 100},
+		},
+	}
+	current := &Report{
+		Results: []Result{
+			{Name: "a", NsPerOp: 105},
+			{Name: "b", NsPerOp: 150},
+			{Name: "d", NsPerOp: 1000},
+		},
+	}
+	rs := Compare(baseline, current, 0.1)
+	if a.Len(rs, 1) {
+		a.Equal("b", rs[0].Name)
+		a.EqualValues(100, rs[0].Baseline)
+		a.EqualValues(150, rs[0].Current)
+		a.Equal(0.5, rs[0].Delta)
+	}
+}
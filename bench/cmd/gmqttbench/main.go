@@ -0,0 +1,85 @@
+// gmqttbench runs the gmqtt benchmarks and emits the results in JSON format.
+//
+// Usage:
+//
+//	gmqttbench -commit $(git rev-parse HEAD) -o result.json
+//	gmqttbench -baseline result.json -threshold 0.1
+//
+// If baseline is set, gmqttbench exits with status 1 when any benchmark is slower than the baseline
+// by more than the threshold.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"testing"
+
+	"github.com/DrmagicE/gmqtt/bench"
+)
+
+var (
+	run       = flag.String("run", "", "run only the benchmarks matching the regular expression")
+	commit    = flag.String("commit", "", "the commit id which will be recorded in the result")
+	output    = flag.String("o", "", "write the result to the file instead of stdout")
+	baseline  = flag.String("baseline", "", "the result file to compare with")
+	threshold = flag.Float64("threshold", 0.1, "the maximum allowed increment ratio of ns/op compared to the baseline")
+	benchtime = flag.String("benchtime", "1s", "run enough iterations of each benchmark to take t, specified as a time.Duration or Nx")
+)
+
+func must(err error) {
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func readReport(file string) (*bench.Report, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	r := &bench.Report{}
+	return r, json.Unmarshal(b, r)
+}
+
+func main() {
+	testing.Init()
+	flag.Parse()
+	must(flag.Set("test.benchtime", *benchtime))
+
+	var filter *regexp.Regexp
+	if *run != "" {
+		var err error
+		filter, err = regexp.Compile(*run)
+		must(err)
+	}
+	var base *bench.Report
+	if *baseline != "" {
+		var err error
+		base, err = readReport(*baseline)
+		must(err)
+	}
+
+	report := bench.Run(*commit, filter)
+	b, err := json.MarshalIndent(report, "", "  ")
+	must(err)
+	if *output != "" {
+		must(ioutil.WriteFile(*output, b, 0644))
+	} else {
+		fmt.Println(string(b))
+	}
+
+	if base != nil {
+		regressions := bench.Compare(base, report, *threshold)
+		for _, v := range regressions {
+			fmt.Fprintf(os.Stderr, "%s: %d ns/op -> %d ns/op (+%.2f%%)\n", v.Name, v.Baseline, v.Current, v.Delta*100)
+		}
+		if len(regressions) != 0 {
+			os.Exit(1)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package bench
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+
+	"github.com/DrmagicE/gmqtt/pkg/packets"
+)
+
+const payloadSize = 256
+
+func newPublish() *packets.Publish {
+	return &packets.Publish{
+		Version:   packets.Version5,
+		Qos:       packets.Qos1,
+		PacketID:  1,
+		TopicName: []byte("bench/device/1/telemetry"),
+		Payload:   make([]byte, payloadSize),
+		Properties: &packets.Properties{
+			User: []packets.UserProperty{
+				{K: []byte("key"), V: []byte("value")},
+			},
+		},
+	}
+}
+
+// PublishEncode benchmarks the encoding of a v5 PUBLISH packet.
+func PublishEncode(b *testing.B) {
+	pub := newPublish()
+	buf := &bytes.Buffer{}
+	b.ReportAllocs()
+	b.SetBytes(payloadSize)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		buf.Reset()
+		if err := pub.Pack(buf); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// PublishDecode benchmarks the decoding of a v5 PUBLISH packet.
+func PublishDecode(b *testing.B) {
+	buf := &bytes.Buffer{}
+	if err := newPublish().Pack(buf); err != nil {
+		b.Fatal(err)
+	}
+	raw := buf.Bytes()
+	rd := bytes.NewReader(raw)
+	br := bufio.NewReader(rd)
+	r := packets.NewReader(br)
+	r.SetVersion(packets.Version5)
+	b.ReportAllocs()
+	b.SetBytes(payloadSize)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		rd.Reset(raw)
+		br.Reset(rd)
+		if _, err := r.ReadPacket(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package bench
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DrmagicE/gmqtt"
+	"github.com/DrmagicE/gmqtt/persistence/queue"
+	"github.com/DrmagicE/gmqtt/persistence/queue/mem"
+	"github.com/DrmagicE/gmqtt/pkg/packets"
+)
+
+type noopNotifier struct{}
+
+func (noopNotifier) NotifyDropped(elem *queue.Elem, err error) {}
+func (noopNotifier) NotifyInflightAdded(delta int)             {}
+func (noopNotifier) NotifyMsgQueueAdded(delta int)             {}
+
+const queueBatchSize = 100
+
+// QueueAddRead benchmarks adding messages into the memory queue and reading them out in batches.
+func QueueAddRead(b *testing.B) {
+	q, err := mem.New(mem.Options{
+		MaxQueuedMsg:    queueBatchSize,
+		ClientID:        "bench",
+		DefaultNotifier: noopNotifier{},
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+	err = q.Init(&queue.InitOptions{
+		CleanStart:     true,
+		Version:        packets.Version5,
+		ReadBytesLimit: packets.MaximumSize,
+		Notifier:       noopNotifier{},
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+	if _, err = q.ReadInflight(queueBatchSize); err != nil {
+		b.Fatal(err)
+	}
+	msg := &gmqtt.Message{
+		Topic:   "bench/device/1/telemetry",
+		Payload: make([]byte, payloadSize),
+	}
+	pids := make([]packets.PacketID, queueBatchSize)
+	b.ReportAllocs()
+	b.SetBytes(payloadSize)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		err = q.Add(&queue.Elem{
+			At: time.Now(),
+			MessageWithID: &queue.Publish{
+				Message: msg,
+			},
+		})
+		if err != nil {
+			b.Fatal(err)
+		}
+		if (i+1)%queueBatchSize == 0 || i == b.N-1 {
+			if _, err = q.Read(pids); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package bench
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/DrmagicE/gmqtt/config"
+	_ "github.com/DrmagicE/gmqtt/persistence"
+	"github.com/DrmagicE/gmqtt/pkg/packets"
+	"github.com/DrmagicE/gmqtt/server"
+	_ "github.com/DrmagicE/gmqtt/topicalias/fifo"
+)
+
+// window is the maximum number of messages that have been published but not yet received by the subscriber.
+// It must be less than the server receive maximum and the max queued messages to avoid message dropping.
+const window = 50
+
+const throughputTopic = "bench/throughput"
+
+type benchClient struct {
+	conn net.Conn
+	r    *packets.Reader
+	w    *packets.Writer
+}
+
+func dial(b *testing.B, addr string, clientID string) *benchClient {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		b.Fatal(err)
+	}
+	c := &benchClient{
+		conn: conn,
+		r:    packets.NewReader(bufio.NewReader(conn)),
+		w:    packets.NewWriter(bufio.NewWriter(conn)),
+	}
+	c.r.SetVersion(packets.Version311)
+	err = c.w.WriteAndFlush(&packets.Connect{
+		Version:       packets.Version311,
+		ProtocolName:  []byte("MQTT"),
+		ProtocolLevel: packets.Version311,
+		ClientID:      []byte(clientID),
+		CleanStart:    true,
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+	p, err := c.r.ReadPacket()
+	if err != nil {
+		b.Fatal(err)
+	}
+	if ack, ok := p.(*packets.Connack); !ok || ack.Code != 0 {
+		b.Fatalf("unexpected connack: %v", p)
+	}
+	return c
+}
+
+func startServer(b *testing.B) (server.Server, string) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatal(err)
+	}
+	cfg := config.DefaultConfig()
+	cfg.API = config.API{}
+	srv := server.New(
+		server.WithConfig(cfg),
+		server.WithTCPListener(ln),
+		server.WithLogger(zap.NewNop()),
+	)
+	go func() {
+		_ = srv.Run()
+	}()
+	return srv, ln.Addr().String()
+}
+
+func benchmarkThroughput(b *testing.B, qos uint8) {
+	srv, addr := startServer(b)
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = srv.Stop(ctx)
+	}()
+	// wait for the server to be ready
+	for i := 0; ; i++ {
+		if c, err := net.Dial("tcp", addr); err == nil {
+			_ = c.Close()
+			break
+		}
+		if i == 50 {
+			b.Fatal("server not ready")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	sub := dial(b, addr, "sub")
+	defer sub.conn.Close()
+	err := sub.w.WriteAndFlush(&packets.Subscribe{
+		Version:  packets.Version311,
+		PacketID: 1,
+		Topics: []packets.Topic{
+			{Name: throughputTopic, SubOptions: packets.SubOptions{Qos: qos}},
+		},
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+	if _, err = sub.r.ReadPacket(); err != nil {
+		b.Fatal(err)
+	}
+	pub := dial(b, addr, "pub")
+	defer pub.conn.Close()
+
+	payload := make([]byte, payloadSize)
+	// tokens limits the number of messages on the fly.
+	tokens := make(chan struct{}, window)
+	done := make(chan error, 2)
+	go func() {
+		for i := 0; i < b.N; i++ {
+			p, err := sub.r.ReadPacket()
+			if err != nil {
+				done <- err
+				return
+			}
+			if p, ok := p.(*packets.Publish); ok && p.Qos == packets.Qos1 {
+				if err = sub.w.WriteAndFlush(p.NewPuback(0, nil)); err != nil {
+					done <- err
+					return
+				}
+			}
+			<-tokens
+		}
+		done <- nil
+	}()
+	if qos == packets.Qos1 {
+		go func() {
+			for i := 0; i < b.N; i++ {
+				if _, err := pub.r.ReadPacket(); err != nil {
+					done <- err
+					return
+				}
+			}
+			done <- nil
+		}()
+	} else {
+		done <- nil
+	}
+
+	b.ReportAllocs()
+	b.SetBytes(payloadSize)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		tokens <- struct{}{}
+		err = pub.w.WriteAndFlush(&packets.Publish{
+			Version:   packets.Version311,
+			Qos:       qos,
+			PacketID:  packets.PacketID(i%int(packets.MaxPacketID) + 1),
+			TopicName: []byte(throughputTopic),
+			Payload:   payload,
+		})
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		if err = <-done; err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// ThroughputQos0 benchmarks the end-to-end throughput of QoS 0 messages over localhost.
+func ThroughputQos0(b *testing.B) {
+	benchmarkThroughput(b, packets.Qos0)
+}
+
+// ThroughputQos1 benchmarks the end-to-end throughput of QoS 1 messages over localhost.
+func ThroughputQos1(b *testing.B) {
+	benchmarkThroughput(b, packets.Qos1)
+}
@@ -0,0 +1,96 @@
+package bench
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/DrmagicE/gmqtt"
+	"github.com/DrmagicE/gmqtt/persistence/subscription"
+	"github.com/DrmagicE/gmqtt/persistence/subscription/mem"
+)
+
+const deviceNum = 10000
+
+func benchmarkTopicMatch(b *testing.B, filter func(i int) string) {
+	db := mem.NewStore()
+	for i := 0; i < deviceNum; i++ {
+		_, err := db.Subscribe(strconv.Itoa(i), &gmqtt.Subscription{
+			TopicFilter: filter(i),
+			QoS:         1,
+		})
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+	topics := make([]string, deviceNum)
+	for i := range topics {
+		topics[i] = "bench/device/" + strconv.Itoa(i) + "/telemetry"
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var matched int
+		db.Iterate(func(clientID string, sub *gmqtt.Subscription) bool {
+			matched++
+			return true
+		}, subscription.IterationOptions{
+			Type:      subscription.TypeAll,
+			TopicName: topics[i%deviceNum],
+			MatchType: subscription.MatchFilter,
+		})
+		if matched == 0 {
+			b.Fatal("no subscription matched")
+		}
+	}
+}
+
+// TopicMatch benchmarks matching a topic name against a trie of exact topic filters.
+func TopicMatch(b *testing.B) {
+	benchmarkTopicMatch(b, func(i int) string {
+		return "bench/device/" + strconv.Itoa(i) + "/telemetry"
+	})
+}
+
+// TopicMatchWildcard benchmarks matching a topic name against a trie of wildcard topic filters.
+func TopicMatchWildcard(b *testing.B) {
+	benchmarkTopicMatch(b, func(i int) string {
+		switch i % 3 {
+		case 0:
+			return "bench/device/" + strconv.Itoa(i) + "/#"
+		case 1:
+			return "bench/+/" + strconv.Itoa(i) + "/telemetry"
+		default:
+			return "bench/+/" + strconv.Itoa(i) + "/#"
+		}
+	})
+}
+
+// subscriberNum is the number of the subscribers of the filter in SubscribeChurn.
+const subscriberNum = 100000
+
+// SubscribeChurn benchmarks subscribing and unsubscribing a client on a filter which has 100k subscribers,
+// as the clients do in a reconnect storm.
+func SubscribeChurn(b *testing.B) {
+	db := mem.NewStore()
+	sub := &gmqtt.Subscription{
+		TopicFilter: "bench/broadcast",
+		QoS:         1,
+	}
+	for i := 0; i < subscriberNum; i++ {
+		_, err := db.Subscribe(strconv.Itoa(i), sub)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		clientID := strconv.Itoa(i % subscriberNum)
+		if err := db.Unsubscribe(clientID, sub.TopicFilter); err != nil {
+			b.Fatal(err)
+		}
+		if _, err := db.Subscribe(clientID, sub); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -1,10 +1,8 @@
 package main
 
 import (
-	"crypto/tls"
 	"fmt"
 	"net"
-	"net/http"
 	"os"
 	"path/filepath"
 
@@ -16,6 +14,8 @@ import (
 	_ "github.com/DrmagicE/gmqtt/plugin/prometheus"
 	"github.com/DrmagicE/gmqtt/server"
 	_ "github.com/DrmagicE/gmqtt/topicalias/fifo"
+	_ "github.com/DrmagicE/gmqtt/topicalias/lfu"
+	_ "github.com/DrmagicE/gmqtt/topicalias/lru"
 	"github.com/kardianos/service"
 	"github.com/lupc/go_service"
 )
@@ -71,30 +71,15 @@ func main() {
 func GetListeners(c config.Config) (tcpListeners []net.Listener, websockets []*server.WsServer, err error) {
 	for _, v := range c.Listeners {
 		var ln net.Listener
-		if v.Websocket != nil {
-			ws := &server.WsServer{
-				Server: &http.Server{Addr: v.Address},
-				Path:   v.Websocket.Path,
-			}
-			if v.TLSOptions != nil {
-				ws.KeyFile = v.Key
-				ws.CertFile = v.Cert
-			}
+		var ws *server.WsServer
+		ln, ws, err = server.NewListenerFromConfig(*v, c.Crypto)
+		if err != nil {
+			return nil, nil, err
+		}
+		if ws != nil {
 			websockets = append(websockets, ws)
 			continue
 		}
-		if v.TLSOptions != nil {
-			var cert tls.Certificate
-			cert, err = tls.LoadX509KeyPair(v.Cert, v.Key)
-			if err != nil {
-				return
-			}
-			ln, err = tls.Listen("tcp", v.Address, &tls.Config{
-				Certificates: []tls.Certificate{cert},
-			})
-		} else {
-			ln, err = net.Listen("tcp", v.Address)
-		}
 		tcpListeners = append(tcpListeners, ln)
 	}
 	return
@@ -0,0 +1,173 @@
+package command
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/DrmagicE/gmqtt/config"
+	"github.com/DrmagicE/gmqtt/persistence"
+	"github.com/DrmagicE/gmqtt/persistence/queue"
+	"github.com/DrmagicE/gmqtt/persistence/subscription"
+)
+
+func openInspector() (*persistence.RedisInspector, error) {
+	c, err := config.ParseConfig(ConfigFile)
+	if err != nil {
+		return nil, err
+	}
+	if c.Persistence.Type != config.PersistenceTypeRedis {
+		return nil, fmt.Errorf("the %s persistence has no persistent state to inspect", c.Persistence.Type)
+	}
+	return persistence.NewRedisInspector(c)
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Format(time.RFC3339)
+}
+
+func printSessionInfo(info *persistence.SessionInfo) {
+	if info.Err != nil {
+		fmt.Printf("%s\tinvalid session: %s\n", info.ClientID, info.Err)
+		return
+	}
+	fmt.Printf("%s\t%s\t%d\t%d\t%d\t%d\n", info.ClientID, formatTime(info.Session.ConnectedAt), info.Session.ExpiryInterval,
+		info.QueueLen, info.SubscriptionLen, info.UnackLen)
+}
+
+func printElem(index int, elem *queue.Elem) {
+	switch m := elem.MessageWithID.(type) {
+	case *queue.Publish:
+		fmt.Printf("%d\t%d\tpublish\t%s\t%d\t%d\t%s\t%s\n", index, m.PacketID, m.Topic, m.QoS, len(m.Payload),
+			formatTime(elem.At), formatTime(elem.Expiry))
+	case *queue.Pubrel:
+		fmt.Printf("%d\t%d\tpubrel\t-\t-\t-\t%s\t%s\n", index, m.PacketID, formatTime(elem.At), formatTime(elem.Expiry))
+	}
+}
+
+// NewInspectCmd creates a *cobra.Command object for inspect command.
+func NewInspectCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "inspect",
+		Short: "Inspect the persistent state for the post-mortem analysis",
+		Long: "Inspect the sessions, subscriptions and queues in the redis persistence directly, without the broker running.\n" +
+			"It never modifies the state. Stop the broker first to get a consistent view.\n" +
+			"The retained messages are kept in memory only, they are not available offline.",
+	}
+	sessions := &cobra.Command{
+		Use:   "sessions",
+		Short: "List all sessions with the queue depths",
+		Long: "List all sessions, the columns are:\n" +
+			"client id, connected at, expiry interval, queue length (including inflight), subscriptions, unacknowledged QoS 2 packet ids",
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			r, err := openInspector()
+			must(err)
+			defer r.Close()
+			must(r.Sessions(func(info *persistence.SessionInfo) bool {
+				printSessionInfo(info)
+				return true
+			}))
+		},
+	}
+
+	session := &cobra.Command{
+		Use:   "session [client id]",
+		Short: "Print the session and the subscriptions of the client",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			r, err := openInspector()
+			must(err)
+			defer r.Close()
+			info, err := r.Session(args[0])
+			must(err)
+			if info == nil {
+				must(fmt.Errorf("session not found: %s", args[0]))
+			}
+			if info.Err != nil {
+				must(fmt.Errorf("invalid session: %s", info.Err))
+			}
+			s := info.Session
+			fmt.Printf("client id:\t%s\n", s.ClientID)
+			fmt.Printf("connected at:\t%s\n", formatTime(s.ConnectedAt))
+			fmt.Printf("expiry interval:\t%d\n", s.ExpiryInterval)
+			if s.Will != nil {
+				fmt.Printf("will:\t%s (qos %d, %d bytes, delay %d)\n", s.Will.Topic, s.Will.QoS, len(s.Will.Payload), s.WillDelayInterval)
+			}
+			var keys []string
+			for k := range s.Attributes {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				fmt.Printf("attribute:\t%s=%s\n", k, s.Attributes[k])
+			}
+			fmt.Printf("queue length:\t%d\n", info.QueueLen)
+			fmt.Printf("unacknowledged:\t%d\n", info.UnackLen)
+			subs, err := r.Subscriptions(args[0])
+			must(err)
+			sort.Slice(subs, func(i, j int) bool {
+				return subscription.GetFullTopicName(subs[i].ShareName, subs[i].TopicFilter) <
+					subscription.GetFullTopicName(subs[j].ShareName, subs[j].TopicFilter)
+			})
+			for _, v := range subs {
+				fmt.Printf("subscription:\t%s (qos %d)\n", subscription.GetFullTopicName(v.ShareName, v.TopicFilter), v.QoS)
+			}
+		},
+	}
+
+	var limit int
+	queueCmd := &cobra.Command{
+		Use:   "queue [client id]",
+		Short: "Print the queued messages of the client",
+		Long: "Print the queued messages of the client in order, the columns are:\n" +
+			"index, packet id (0 if not inflight), type, topic, qos, payload size, entry time, expiry time",
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			r, err := openInspector()
+			must(err)
+			defer r.Close()
+			var index int
+			must(r.Queue(args[0], func(elem *queue.Elem, err error) bool {
+				if err != nil {
+					fmt.Printf("%d\tinvalid elem: %s\n", index, err)
+				} else {
+					printElem(index, elem)
+				}
+				index++
+				return limit <= 0 || index < limit
+			}))
+		},
+	}
+	queueCmd.Flags().IntVarP(&limit, "limit", "n", 100, "The maximum number of messages to print, 0 means no limit")
+
+	check := &cobra.Command{
+		Use:   "check",
+		Short: "Check the persistent state for corruptions",
+		Long: "Check the persistent state for the entries which can not be decoded, the misplaced or duplicated inflight messages, " +
+			"and the queues, subscriptions and unacknowledged packet ids without session.\n" +
+			"It exits with status 1 if any problem is found.",
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			r, err := openInspector()
+			must(err)
+			defer r.Close()
+			var n int
+			must(r.Check(func(p persistence.Problem) {
+				n++
+				fmt.Printf("%s\t%s\n", p.Key, p.Description)
+			}))
+			if n != 0 {
+				must(fmt.Errorf("%d problems found", n))
+			}
+			fmt.Println("no problem found")
+		},
+	}
+	cmd.AddCommand(sessions, session, queueCmd, check)
+	return cmd
+}
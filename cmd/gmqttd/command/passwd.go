@@ -0,0 +1,42 @@
+package command
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/DrmagicE/gmqtt/plugin/auth"
+)
+
+// NewPasswdCmd creates a *cobra.Command object for passwd command.
+func NewPasswdCmd() *cobra.Command {
+	var hash string
+	cmd := &cobra.Command{
+		Use:   "passwd [password]",
+		Short: "Generate the hashed password for the auth plugin",
+		Long: "Generate the hashed password for the auth plugin.\n" +
+			"If the password is not given by argument, it will be read from the standard input.",
+		Args: cobra.MaximumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			var password string
+			if len(args) == 1 {
+				password = args[0]
+			} else {
+				line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+				if line == "" {
+					must(err)
+				}
+				password = strings.TrimRight(line, "\r\n")
+			}
+			hashed, err := auth.GeneratePassword(hash, password)
+			must(err)
+			fmt.Println(hashed)
+		},
+	}
+	cmd.Flags().StringVarP(&hash, "hash", "t", auth.Argon2id,
+		"The password hash type: "+strings.Join(auth.ValidateHashType, " | "))
+	return cmd
+}
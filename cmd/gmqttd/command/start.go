@@ -2,10 +2,8 @@ package command
 
 import (
 	"context"
-	"crypto/tls"
 	"fmt"
 	"net"
-	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
@@ -64,30 +62,15 @@ func installSignal(srv server.Server) {
 func GetListeners(c config.Config) (tcpListeners []net.Listener, websockets []*server.WsServer, err error) {
 	for _, v := range c.Listeners {
 		var ln net.Listener
-		if v.Websocket != nil {
-			ws := &server.WsServer{
-				Server: &http.Server{Addr: v.Address},
-				Path:   v.Websocket.Path,
-			}
-			if v.TLSOptions != nil {
-				ws.KeyFile = v.Key
-				ws.CertFile = v.Cert
-			}
+		var ws *server.WsServer
+		ln, ws, err = server.NewListenerFromConfig(*v, c.Crypto)
+		if err != nil {
+			return nil, nil, err
+		}
+		if ws != nil {
 			websockets = append(websockets, ws)
 			continue
 		}
-		if v.TLSOptions != nil {
-			var cert tls.Certificate
-			cert, err = tls.LoadX509KeyPair(v.Cert, v.Key)
-			if err != nil {
-				return
-			}
-			ln, err = tls.Listen("tcp", v.Address, &tls.Config{
-				Certificates: []tls.Certificate{cert},
-			})
-		} else {
-			ln, err = net.Listen("tcp", v.Address)
-		}
 		tcpListeners = append(tcpListeners, ln)
 	}
 	return
@@ -136,6 +119,8 @@ func NewStartCmd() *cobra.Command {
 			}
 			go installSignal(s)
 			err = s.Run()
+			// flush the buffered log records of the log sinks.
+			_ = l.Sync()
 			if err != nil {
 				fmt.Fprint(os.Stderr, err.Error())
 				os.Exit(1)
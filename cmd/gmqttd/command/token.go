@@ -0,0 +1,78 @@
+package command
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/DrmagicE/gmqtt/config"
+	"github.com/DrmagicE/gmqtt/plugin/admin"
+)
+
+func openTokenStore() (*admin.TokenStore, error) {
+	c, err := config.ParseConfig(ConfigFile)
+	if err != nil {
+		return nil, err
+	}
+	cfg := c.Plugins[admin.Name].(*admin.Config)
+	return admin.OpenTokenStore(admin.TokenFile(cfg.TokenAuth.TokenFile, c.ConfigDir))
+}
+
+// NewTokenCmd creates a *cobra.Command object for token command.
+func NewTokenCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "token",
+		Short: "Manage the admin API tokens",
+		Long: "Manage the admin API tokens in the token file of the admin plugin.\n" +
+			"The running server picks up the changes without restart.",
+	}
+	var description string
+	var ttl time.Duration
+	create := &cobra.Command{
+		Use:   "create",
+		Short: "Create a new token and print the access token",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			s, err := openTokenStore()
+			must(err)
+			_, accessToken, err := s.Create(description, ttl)
+			must(err)
+			fmt.Println(accessToken)
+		},
+	}
+	create.Flags().StringVarP(&description, "description", "d", "", "The description of the token")
+	create.Flags().DurationVarP(&ttl, "ttl", "t", 30*24*time.Hour, "The time to live of the token, 0 means never expire")
+
+	list := &cobra.Command{
+		Use:   "list",
+		Short: "List all tokens",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			s, err := openTokenStore()
+			must(err)
+			tokens, err := s.List()
+			must(err)
+			for _, v := range tokens {
+				expireAt := "never"
+				if v.ExpireAt != nil {
+					expireAt = v.ExpireAt.AsTime().Format(time.RFC3339)
+				}
+				fmt.Printf("%s\t%s\t%s\t%s\n", v.Id, v.CreatedAt.AsTime().Format(time.RFC3339), expireAt, v.Description)
+			}
+		},
+	}
+
+	revoke := &cobra.Command{
+		Use:   "revoke [id]",
+		Short: "Revoke the token for given id",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			s, err := openTokenStore()
+			must(err)
+			must(s.Revoke(args[0]))
+		},
+	}
+	cmd.AddCommand(create, list, revoke)
+	return cmd
+}
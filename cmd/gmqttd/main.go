@@ -12,6 +12,8 @@ import (
 	_ "github.com/DrmagicE/gmqtt/persistence"
 	_ "github.com/DrmagicE/gmqtt/plugin/prometheus"
 	_ "github.com/DrmagicE/gmqtt/topicalias/fifo"
+	_ "github.com/DrmagicE/gmqtt/topicalias/lfu"
+	_ "github.com/DrmagicE/gmqtt/topicalias/lru"
 )
 
 var (
@@ -37,6 +39,9 @@ func init() {
 	command.ConfigFile = path.Join(configDir, "gmqttd.yml")
 	rootCmd.PersistentFlags().StringVarP(&command.ConfigFile, "config", "c", command.ConfigFile, "The configuration file path")
 	rootCmd.AddCommand(command.NewStartCmd())
+	rootCmd.AddCommand(command.NewPasswdCmd())
+	rootCmd.AddCommand(command.NewTokenCmd())
+	rootCmd.AddCommand(command.NewInspectCmd())
 	//rootCmd.AddCommand(command.NewReloadCommand())
 }
 
@@ -6,6 +6,20 @@ package main
 import (
 	_ "github.com/DrmagicE/gmqtt/plugin/admin"
 	_ "github.com/DrmagicE/gmqtt/plugin/auth"
+	_ "github.com/DrmagicE/gmqtt/plugin/bridge"
+	_ "github.com/DrmagicE/gmqtt/plugin/certns"
+	_ "github.com/DrmagicE/gmqtt/plugin/deadletter"
+	_ "github.com/DrmagicE/gmqtt/plugin/dedup"
+	_ "github.com/DrmagicE/gmqtt/plugin/devreg"
 	_ "github.com/DrmagicE/gmqtt/plugin/federation"
+	_ "github.com/DrmagicE/gmqtt/plugin/history"
+	_ "github.com/DrmagicE/gmqtt/plugin/httpgw"
+	_ "github.com/DrmagicE/gmqtt/plugin/mdns"
+	_ "github.com/DrmagicE/gmqtt/plugin/migration"
 	_ "github.com/DrmagicE/gmqtt/plugin/prometheus"
+	_ "github.com/DrmagicE/gmqtt/plugin/psk"
+	_ "github.com/DrmagicE/gmqtt/plugin/scheduler"
+	_ "github.com/DrmagicE/gmqtt/plugin/schema"
+	_ "github.com/DrmagicE/gmqtt/plugin/transform"
+	_ "github.com/DrmagicE/gmqtt/plugin/vhost"
 )
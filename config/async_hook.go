@@ -0,0 +1,49 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	// AsyncHookBlock blocks the caller until the worker queue has room.
+	AsyncHookBlock = "block"
+	// AsyncHookDrop drops the hook call if the worker queue is full.
+	AsyncHookDrop = "drop"
+)
+
+var (
+	// DefaultAsyncHookConfig is the default value of AsyncHook
+	DefaultAsyncHookConfig = AsyncHook{
+		Workers:    8,
+		QueueSize:  1024,
+		FullPolicy: AsyncHookBlock,
+	}
+)
+
+// AsyncHook is the config of the worker pool which executes the hooks that plugins registered as asynchronous.
+// The pool is created only if any of the loaded plugins registers asynchronous hooks.
+type AsyncHook struct {
+	// Workers is the number of workers.
+	// Hooks of the same client are always executed by the same worker, so the order of them is kept.
+	Workers int `yaml:"workers"`
+	// QueueSize is the maximum number of pending hook calls of each worker.
+	QueueSize int `yaml:"queue_size"`
+	// FullPolicy is the behavior when the queue of the worker is full, the possible value can be "block" or "drop".
+	// "block" blocks the caller until the queue has room, which applies backpressure to the client.
+	// "drop" drops the hook call with a warning log.
+	FullPolicy string `yaml:"full_policy"`
+}
+
+func (a AsyncHook) Validate() error {
+	if a.Workers <= 0 {
+		return errors.New("invalid async_hook.workers: must be greater than 0")
+	}
+	if a.QueueSize < 0 {
+		return errors.New("invalid async_hook.queue_size: must be greater than or equal to 0")
+	}
+	if a.FullPolicy != AsyncHookBlock && a.FullPolicy != AsyncHookDrop {
+		return fmt.Errorf("invalid async_hook.full_policy: %s", a.FullPolicy)
+	}
+	return nil
+}
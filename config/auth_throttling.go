@@ -0,0 +1,56 @@
+package config
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	// DefaultAuthThrottling is the default value of AuthThrottling
+	DefaultAuthThrottling = AuthThrottling{
+		Enable:          false,
+		Window:          5 * time.Minute,
+		MaxFailures:     5,
+		LockoutDuration: 15 * time.Minute,
+		Delay:           500 * time.Millisecond,
+		MaxDelay:        5 * time.Second,
+	}
+)
+
+// AuthThrottling is the config of the authentication failure throttling.
+// The failed authentications are tracked per username and per remote IP in a sliding window.
+// Each failure delays the CONNACK, and the username or IP is locked out temporarily
+// once the number of failures in the window reaches MaxFailures.
+type AuthThrottling struct {
+	Enable bool `yaml:"enable"`
+	// Window is the sliding window to count the failures.
+	Window time.Duration `yaml:"window"`
+	// MaxFailures is the number of failures in Window to trigger the lockout.
+	MaxFailures int `yaml:"max_failures"`
+	// LockoutDuration is the lockout duration.
+	LockoutDuration time.Duration `yaml:"lockout_duration"`
+	// Delay is the delay injected before responding to a failed authentication,
+	// it is multiplied by the number of failures in the window.
+	Delay time.Duration `yaml:"delay"`
+	// MaxDelay is the maximum injected delay.
+	MaxDelay time.Duration `yaml:"max_delay"`
+}
+
+func (a AuthThrottling) Validate() error {
+	if !a.Enable {
+		return nil
+	}
+	if a.Window <= 0 {
+		return errors.New("invalid auth_throttling.window: must be greater than 0")
+	}
+	if a.MaxFailures <= 0 {
+		return errors.New("invalid auth_throttling.max_failures: must be greater than 0")
+	}
+	if a.LockoutDuration <= 0 {
+		return errors.New("invalid auth_throttling.lockout_duration: must be greater than 0")
+	}
+	if a.Delay < 0 || a.MaxDelay < 0 {
+		return errors.New("invalid auth_throttling delay: must be greater than or equal to 0")
+	}
+	return nil
+}
@@ -6,12 +6,18 @@ import (
 	"io/ioutil"
 	"os"
 	"path"
+	"reflect"
+	"strings"
 	"time"
 
 	rotatelogs "github.com/lestrrat-go/file-rotatelogs"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 	"gopkg.in/yaml.v2"
+
+	"github.com/DrmagicE/gmqtt/pkg/logsink"
+	"github.com/DrmagicE/gmqtt/pkg/proxy"
+	"github.com/DrmagicE/gmqtt/pkg/tlspsk"
 )
 
 var (
@@ -47,10 +53,24 @@ func DefaultConfig() Config {
 		Log: LogConfig{
 			Level:  "info",
 			Format: "text",
+			Sinks:  DefaultLogSinks,
 		},
-		Plugins:           make(pluginConfig),
-		Persistence:       DefaultPersistenceConfig,
-		TopicAliasManager: DefaultTopicAliasManager,
+		Plugins:            make(pluginConfig),
+		Persistence:        DefaultPersistenceConfig,
+		TopicAliasManager:  DefaultTopicAliasManager,
+		ConnectionEngine:   DefaultConnectionEngine,
+		Delivery:           DefaultDeliveryConfig,
+		AsyncHook:          DefaultAsyncHookConfig,
+		HookTiming:         DefaultHookTiming,
+		PluginSandbox:      DefaultPluginSandbox,
+		OverloadProtection: DefaultOverloadProtection,
+		Runtime:            DefaultRuntimeConfig,
+		ConnectionQuota:    DefaultConnectionQuota,
+		FlappingDetection:  DefaultFlappingDetection,
+		AuthThrottling:     DefaultAuthThrottling,
+		Handshake:          DefaultHandshake,
+		Crypto:             DefaultCrypto(),
+		StatsPersistence:   DefaultStatsPersistence,
 	}
 
 	for name, v := range defaultPluginConfig {
@@ -81,6 +101,29 @@ type LogConfig struct {
 	Format string `yaml:"format"`
 	// DumpPacket indicates whether to dump MQTT packet in debug level.
 	DumpPacket bool `yaml:"dump_packet"`
+	// RedactUsername indicates whether to replace the usernames in the logs with their salted hashes.
+	RedactUsername bool `yaml:"redact_username"`
+	// RedactRemoteAddr indicates whether to replace the remote addresses in the logs with their salted hashes.
+	RedactRemoteAddr bool `yaml:"redact_remote_addr"`
+	// AttributeFields is the client attributes which are added to the client log records as "attributes.<key>" fields,
+	// so that the log sinks can label the events by them, e.g. tenant, model.
+	AttributeFields []string `yaml:"attribute_fields"`
+	// Dump is the sampling and output setting of the packet dump, it takes effect when DumpPacket is true.
+	Dump PacketDump `yaml:"dump"`
+	// Sinks is the setting of the log sinks which ship the log records to the remote collectors.
+	Sinks LogSinks `yaml:"sinks"`
+}
+
+// PacketDump is the sampling and output setting of the packet dump.
+type PacketDump struct {
+	// SampleRate dumps 1 in every SampleRate packets, 0 or 1 means dumping all packets.
+	SampleRate int `yaml:"sample_rate"`
+	// ClientIDs is the allowlist of the client ids. If it is not empty, only the packets of these clients will be dumped.
+	ClientIDs []string `yaml:"client_ids"`
+	// File is the path of the dedicated rotating dump file.
+	// If it is set, the packets are dumped to the file in both hex and decoded form regardless of the log level,
+	// otherwise, the packets are dumped to the main log in debug level.
+	File string `yaml:"file"`
 }
 
 func (l LogConfig) Validate() error {
@@ -90,7 +133,10 @@ func (l LogConfig) Validate() error {
 	if l.Format != "json" && l.Format != "text" {
 		return fmt.Errorf("invalid log format: %s", l.Format)
 	}
-	return nil
+	if l.Dump.SampleRate < 0 {
+		return fmt.Errorf("invalid log.dump.sample_rate: %d", l.Dump.SampleRate)
+	}
+	return l.Sinks.Validate()
 }
 
 // pluginConfig stores the plugin default configuration, key by the plugin name.
@@ -120,9 +166,26 @@ type Config struct {
 	// PluginOrder is a slice that contains the name of the plugin which will be loaded.
 	// Giving a correct order to the slice is significant,
 	// because it represents the loading order which affect the behavior of the broker.
-	PluginOrder       []string          `yaml:"plugin_order"`
-	Persistence       Persistence       `yaml:"persistence"`
-	TopicAliasManager TopicAliasManager `yaml:"topic_alias_manager"`
+	PluginOrder        []string           `yaml:"plugin_order"`
+	Persistence        Persistence        `yaml:"persistence"`
+	TopicAliasManager  TopicAliasManager  `yaml:"topic_alias_manager"`
+	ConnectionEngine   ConnectionEngine   `yaml:"connection_engine"`
+	Delivery           Delivery           `yaml:"delivery"`
+	AsyncHook          AsyncHook          `yaml:"async_hook"`
+	HookTiming         HookTiming         `yaml:"hook_timing"`
+	PluginSandbox      PluginSandbox      `yaml:"plugin_sandbox"`
+	OverloadProtection OverloadProtection `yaml:"overload_protection"`
+	Runtime            Runtime            `yaml:"runtime"`
+	ConnectionQuota    ConnectionQuota    `yaml:"connection_quota"`
+	FlappingDetection  FlappingDetection  `yaml:"flapping_detection"`
+	AuthThrottling     AuthThrottling     `yaml:"auth_throttling"`
+	Handshake          Handshake          `yaml:"handshake"`
+	Crypto             Crypto             `yaml:"crypto"`
+	StatsPersistence   StatsPersistence   `yaml:"stats_persistence"`
+	// Proxy is the URL of the HTTP CONNECT or SOCKS5 forward proxy of the outbound connections of the plugins,
+	// e.g. the bridges and the webhooks, which can be overridden by their own proxy settings.
+	// Empty means connecting directly, or using the proxy environment variables for the HTTP clients.
+	Proxy string `yaml:"proxy"`
 }
 
 type GRPC struct {
@@ -138,12 +201,135 @@ type TLSOptions struct {
 	Key string `yaml:"key"`
 	// Verify indicates whether to verify client cert.
 	Verify bool `yaml:"verify"`
+	// SessionTicket is the TLS session resumption setting.
+	SessionTicket SessionTicket `yaml:"session_ticket"`
+}
+
+// SessionTicket is the setting of the TLS session resumption by session tickets.
+// It cuts the reconnect handshake CPU when a large number of clients reconnect at the same time.
+type SessionTicket struct {
+	// Disable disables the session resumption.
+	Disable bool `yaml:"disable"`
+	// RotationInterval is the interval to rotate the session ticket key.
+	// If zero, the keys are managed by crypto/tls.
+	RotationInterval time.Duration `yaml:"rotation_interval"`
+	// RetainedKeys is the number of previous keys that are still accepted after rotation,
+	// so that the clients holding the tickets issued before the rotation can still resume.
+	RetainedKeys int `yaml:"retained_keys"`
+}
+
+// PSKOptions is the TLS-PSK setting of the listener, for the constrained devices that can't handle X.509.
+// The PSK identities sent by the clients are resolved to the keys by the OnPSKLookup hook at handshake time.
+// Only TLS 1.2 with the AEAD PSK cipher suites is supported.
+type PSKOptions struct {
+	// IdentityHint is sent to the clients to help them select the identity, optional.
+	IdentityHint string `yaml:"identity_hint"`
+	// CipherSuites is the enabled cipher suites in the order of preference, defaults to all supported suites.
+	// Possible values: TLS_PSK_WITH_AES_128_GCM_SHA256, TLS_PSK_WITH_AES_256_GCM_SHA384,
+	// TLS_PSK_WITH_AES_128_CCM, TLS_PSK_WITH_AES_128_CCM_8.
+	CipherSuites []string `yaml:"cipher_suites"`
+}
+
+// CipherSuiteIDs returns the ids of the cipher suites.
+func (p *PSKOptions) CipherSuiteIDs() ([]uint16, error) {
+	var ids []uint16
+	for _, name := range p.CipherSuites {
+		var id uint16
+		for _, v := range tlspsk.CipherSuites() {
+			if v.Name == name {
+				id = v.ID
+				break
+			}
+		}
+		if id == 0 {
+			return nil, fmt.Errorf("unsupported psk cipher suite: %s", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// ProbeOptions is the broker-side liveness probing of the TCP connections of the listener, which detects the half-open
+// connections (e.g. the device lost power or its NAT mapping expired) faster than the keepalive (1.5 times the keep alive time),
+// so that the session slots are freed sooner. MQTT has no server-initiated PINGREQ, so the probing relies on the TCP keepalive
+// probes sent by the OS on the idle connections, and the timeout of the written data which is not acknowledged by the peer.
+// The connections closed by the probing are reported with the probe_timeout close reason.
+type ProbeOptions struct {
+	// Idle is the idle time before the first keepalive probe is sent, 0 means the system default.
+	Idle time.Duration `yaml:"idle"`
+	// Interval is the interval between the unacknowledged keepalive probes, 0 means the same as Idle.
+	// Only supported on linux.
+	Interval time.Duration `yaml:"interval"`
+	// Count is the number of the unacknowledged keepalive probes before the connection is closed, 0 means the system default.
+	// Only supported on linux.
+	Count int `yaml:"count"`
+	// WriteTimeout is the maximum time that the written data can stay unacknowledged by the peer before the connection is closed
+	// (TCP_USER_TIMEOUT), which detects the connections that die while the broker is sending to them, 0 means the system default.
+	// Only supported on linux.
+	WriteTimeout time.Duration `yaml:"write_timeout"`
+}
+
+func (p *ProbeOptions) validate() error {
+	if p.Idle != 0 && p.Idle < time.Second {
+		return fmt.Errorf("invalid probe.idle: %s, must be at least 1s", p.Idle)
+	}
+	if p.Interval != 0 && p.Interval < time.Second {
+		return fmt.Errorf("invalid probe.interval: %s, must be at least 1s", p.Interval)
+	}
+	if p.Count < 0 {
+		return fmt.Errorf("invalid probe.count: %d", p.Count)
+	}
+	if p.WriteTimeout < 0 {
+		return fmt.Errorf("invalid probe.write_timeout: %s", p.WriteTimeout)
+	}
+	return nil
 }
 
 type ListenerConfig struct {
 	Address     string `yaml:"address"`
 	*TLSOptions `yaml:"tls"`
-	Websocket   *WebsocketOptions `yaml:"websocket"`
+	// PSK enables TLS-PSK on the listener, it can't be used with tls or websocket.
+	PSK       *PSKOptions       `yaml:"psk"`
+	Websocket *WebsocketOptions `yaml:"websocket"`
+	// AllowAnonymous overrides the mqtt.allow_anonymous setting for the listener if it is set.
+	AllowAnonymous *bool `yaml:"allow_anonymous"`
+	// Label is the name of the listener which is carried in the context of the hooks, defaults to the address.
+	Label string `yaml:"label"`
+	// MountPoint is transparently prefixed to all topics the clients of the listener publish and subscribe,
+	// e.g. "tenants/{username}/". The placeholders {username} and {client_id} are replaced for each client.
+	MountPoint string `yaml:"mount_point"`
+	// RetainAsPublished forces (true) or forbids (false) the Retain As Published option of the subscriptions
+	// of the clients of the listener if it is set, regardless of what the clients request.
+	RetainAsPublished *bool `yaml:"retain_as_published"`
+	// NoLocal forces (true) or forbids (false) the No Local option of the subscriptions
+	// of the clients of the listener if it is set, regardless of what the clients request.
+	NoLocal *bool `yaml:"no_local"`
+	// Codec is the name of the connection codec which handles the proprietary framing or header of the connections
+	// before the MQTT packets are parsed, empty means none. The codecs are registered by server.RegisterConnectionCodec.
+	// It is not supported by the websocket listeners.
+	Codec string `yaml:"codec"`
+	// Probe is the broker-side liveness probing of the connections, nil means the system defaults.
+	Probe *ProbeOptions `yaml:"probe"`
+}
+
+func (l *ListenerConfig) Validate() error {
+	if strings.ContainsAny(l.MountPoint, "+#\x00") {
+		return fmt.Errorf("invalid mount_point of listener %s: %s", l.Address, l.MountPoint)
+	}
+	if l.PSK != nil {
+		if l.TLSOptions != nil || l.Websocket != nil {
+			return fmt.Errorf("invalid psk of listener %s: cannot be used with tls or websocket", l.Address)
+		}
+		if _, err := l.PSK.CipherSuiteIDs(); err != nil {
+			return fmt.Errorf("invalid psk of listener %s: %s", l.Address, err)
+		}
+	}
+	if l.Probe != nil {
+		if err := l.Probe.validate(); err != nil {
+			return fmt.Errorf("listener %s: %s", l.Address, err)
+		}
+	}
+	return nil
 }
 
 type WebsocketOptions struct {
@@ -156,8 +342,7 @@ func (c *Config) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	if err := unmarshal(&raw); err != nil {
 		return err
 	}
-	emptyMQTT := MQTT{}
-	if raw.MQTT == emptyMQTT {
+	if reflect.DeepEqual(raw.MQTT, MQTT{}) {
 		raw.MQTT = DefaultMQTTConfig
 	}
 	if len(raw.Plugins) == 0 {
@@ -181,6 +366,12 @@ func (c Config) Validate() (err error) {
 	if err != nil {
 		return err
 	}
+	for _, v := range c.Listeners {
+		err = v.Validate()
+		if err != nil {
+			return err
+		}
+	}
 	err = c.API.Validate()
 	if err != nil {
 		return err
@@ -193,6 +384,62 @@ func (c Config) Validate() (err error) {
 	if err != nil {
 		return err
 	}
+	err = c.ConnectionEngine.Validate()
+	if err != nil {
+		return err
+	}
+	err = c.Delivery.Validate()
+	if err != nil {
+		return err
+	}
+	err = c.AsyncHook.Validate()
+	if err != nil {
+		return err
+	}
+	err = c.HookTiming.Validate()
+	if err != nil {
+		return err
+	}
+	err = c.PluginSandbox.Validate()
+	if err != nil {
+		return err
+	}
+	err = c.OverloadProtection.Validate()
+	if err != nil {
+		return err
+	}
+	err = c.Runtime.Validate()
+	if err != nil {
+		return err
+	}
+	err = c.ConnectionQuota.Validate()
+	if err != nil {
+		return err
+	}
+	err = c.FlappingDetection.Validate()
+	if err != nil {
+		return err
+	}
+	err = c.AuthThrottling.Validate()
+	if err != nil {
+		return err
+	}
+	err = c.Handshake.Validate()
+	if err != nil {
+		return err
+	}
+	err = c.StatsPersistence.Validate()
+	if err != nil {
+		return err
+	}
+	err = proxy.Validate(c.Proxy)
+	if err != nil {
+		return fmt.Errorf("invalid proxy: %s", err)
+	}
+	err = c.Crypto.Validate()
+	if err != nil {
+		return err
+	}
 	for _, conf := range c.Plugins {
 		err := conf.Validate()
 		if err != nil {
@@ -229,7 +476,7 @@ func (c Config) GetLogger(config LogConfig) (l *zap.Logger, err error) {
 	if err != nil {
 		return
 	}
-	warnIoWriter := getWriter("./logs/%Y-%m/gmqtt.log")
+	warnIoWriter := getWriter("./logs/%Y-%m/gmqtt.log", "./logs/current.log")
 	_ = os.Mkdir("./logs", 0755)
 	// var writer = getLogWriter()
 	var encoder = zapcore.NewConsoleEncoder(zap.NewDevelopmentEncoderConfig())
@@ -242,11 +489,39 @@ func (c Config) GetLogger(config LogConfig) (l *zap.Logger, err error) {
 	var coreFile = zapcore.NewCore(encoder, zapcore.AddSync(warnIoWriter), logLevel)
 	var coreConsole = zapcore.NewCore(encoder, os.Stdout, logLevel)
 
-	var core = zapcore.NewTee(coreFile, coreConsole)
+	var cores = []zapcore.Core{coreFile, coreConsole}
+	// the log sinks always ship the records in json format.
+	jsonEncoder := zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig())
+	if s := config.Sinks.Kafka; s.Enable {
+		shipper := logsink.NewKafka(s.Brokers, s.Topic, s.Partition, s.Timeout)
+		cores = append(cores, zapcore.NewCore(jsonEncoder, logsink.New(shipper, s.LogSink.options()), logLevel))
+	}
+	if s := config.Sinks.Fluentd; s.Enable {
+		shipper := logsink.NewFluentd(s.Address, s.Tag, s.Timeout)
+		cores = append(cores, zapcore.NewCore(jsonEncoder, logsink.New(shipper, s.LogSink.options()), logLevel))
+	}
+	var core = zapcore.NewTee(cores...)
 	zaplog := zap.New(core, zap.AddStacktrace(zap.ErrorLevel), zap.AddCaller())
 	return zaplog, nil
 }
 
+// GetPacketDumpLogger returns the logger which writes to the dedicated packet dump file.
+// It returns nil if the dump file is not set.
+func (l LogConfig) GetPacketDumpLogger() (*zap.Logger, error) {
+	if l.Dump.File == "" {
+		return nil, nil
+	}
+	if err := os.MkdirAll(path.Dir(l.Dump.File), 0755); err != nil {
+		return nil, err
+	}
+	var encoder = zapcore.NewConsoleEncoder(zap.NewDevelopmentEncoderConfig())
+	if l.Format == "json" {
+		encoder = zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig())
+	}
+	core := zapcore.NewCore(encoder, zapcore.AddSync(getWriter(l.Dump.File, l.Dump.File)), zapcore.DebugLevel)
+	return zap.New(core), nil
+}
+
 // func getLogWriter() zapcore.WriteSyncer {
 // 	lumberJackLogger := &lumberjack.Logger{
 // 		Filename:   "./log/test.log",
@@ -259,15 +534,15 @@ func (c Config) GetLogger(config LogConfig) (l *zap.Logger, err error) {
 // }
 
 // 日志文件切割
-func getWriter(logFile string) io.Writer {
+func getWriter(logFile string, linkName string) io.Writer {
 	// 保存30天内的日志，每24小时(整点)分割一次日志
 	writer, err := rotatelogs.New(
-		logFile+".%Y%m%d",                             //每天
-		rotatelogs.WithLinkName("./logs/current.log"), //生成软链，指向最新日志文件
-		rotatelogs.WithRotationTime(24*time.Hour),     //最小为1分钟轮询。默认60s  低于1分钟就按1分钟来
-		rotatelogs.WithRotationCount(0),               //设置3份 大于3份 或到了清理时间 开始清理 0不启用
-		rotatelogs.WithMaxAge(30*24*time.Hour),        //保留30天日志
-		rotatelogs.WithRotationSize(100*1024*1024),    //设置100MB大小,当大于这个容量时，创建新的日志文件
+		logFile+".%Y%m%d",                          //每天
+		rotatelogs.WithLinkName(linkName),          //生成软链，指向最新日志文件
+		rotatelogs.WithRotationTime(24*time.Hour),  //最小为1分钟轮询。默认60s  低于1分钟就按1分钟来
+		rotatelogs.WithRotationCount(0),            //设置3份 大于3份 或到了清理时间 开始清理 0不启用
+		rotatelogs.WithMaxAge(30*24*time.Hour),     //保留30天日志
+		rotatelogs.WithRotationSize(100*1024*1024), //设置100MB大小,当大于这个容量时，创建新的日志文件
 	)
 
 	if err != nil {
@@ -2,8 +2,11 @@ package config
 
 import (
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+
+	"github.com/DrmagicE/gmqtt/pkg/tlspsk"
 )
 
 func TestParseConfig(t *testing.T) {
@@ -34,3 +37,434 @@ func TestParseConfig(t *testing.T) {
 		})
 	}
 }
+
+func TestMQTT_MaxPayloadSize(t *testing.T) {
+	a := assert.New(t)
+	c := DefaultMQTTConfig
+	a.EqualValues(0, c.MaxPayloadSize("telemetry/a"))
+	c.TopicPayloadLimits = []TopicPayloadLimit{
+		{TopicFilter: "telemetry/#", MaxPayloadSize: 1024},
+		{TopicFilter: "#", MaxPayloadSize: 4096},
+	}
+	a.Nil(c.Validate())
+	a.EqualValues(1024, c.MaxPayloadSize("telemetry/a"))
+	a.EqualValues(4096, c.MaxPayloadSize("ota/a"))
+
+	c.TopicPayloadLimits = []TopicPayloadLimit{
+		{TopicFilter: "a/#/b", MaxPayloadSize: 1024},
+	}
+	a.NotNil(c.Validate())
+}
+
+func TestMQTT_ReportNoSubscriber(t *testing.T) {
+	a := assert.New(t)
+	c := DefaultMQTTConfig
+	a.False(c.ReportNoSubscriber("alarm/a"))
+	c.ReportNoSubscriberTopics = []string{"alarm/#"}
+	a.Nil(c.Validate())
+	a.True(c.ReportNoSubscriber("alarm/a"))
+	a.False(c.ReportNoSubscriber("telemetry/a"))
+
+	c.ReportNoSubscriberTopics = []string{"a/#/b"}
+	a.NotNil(c.Validate())
+}
+
+func TestMQTT_PropertyMapping(t *testing.T) {
+	a := assert.New(t)
+	c := DefaultMQTTConfig
+	a.Equal(PropertyMappingDrop, c.PropertyMapping.UserProperties)
+	c.PropertyMapping.UserProperties = PropertyMappingEnvelope
+	c.PropertyMapping.ResponseTopic = PropertyMappingReject
+	a.Nil(c.Validate())
+	c.PropertyMapping.ContentType = ""
+	a.NotNil(c.Validate())
+}
+
+func TestHookTiming_Validate(t *testing.T) {
+	a := assert.New(t)
+	h := DefaultHookTiming
+	a.Nil(h.Validate())
+	h.Timeouts = []HookTimeout{{Hook: "OnBasicAuth", Timeout: time.Second, Policy: HookFailClosed}}
+	a.Nil(h.Validate())
+	h.Timeouts = append(h.Timeouts, HookTimeout{Hook: "OnBasicAuth", Timeout: time.Second, Policy: HookFailOpen})
+	a.NotNil(h.Validate())
+	h.Timeouts = []HookTimeout{{Hook: "OnBasicAuth", Timeout: time.Second, Policy: "ignore"}}
+	a.NotNil(h.Validate())
+	h.Timeouts = []HookTimeout{{Hook: "OnBasicAuth", Policy: HookFailOpen}}
+	a.NotNil(h.Validate())
+}
+
+func TestPluginSandbox_Validate(t *testing.T) {
+	a := assert.New(t)
+	p := DefaultPluginSandbox
+	p.Enable = true
+	a.Nil(p.Validate())
+	p.Policy = "ignore"
+	a.NotNil(p.Validate())
+	p.Policy = HookFailOpen
+	p.Window = 0
+	a.NotNil(p.Validate())
+	// never disabled
+	p.MaxPanics = 0
+	a.Nil(p.Validate())
+	p.Cooldown = -time.Second
+	a.NotNil(p.Validate())
+}
+
+func TestPersistence_Preload(t *testing.T) {
+	a := assert.New(t)
+	p := DefaultPersistenceConfig
+	p.Preload.Enable = true
+	a.Nil(p.Validate())
+	p.Preload.Timeout = 0
+	a.Nil(p.Validate())
+	p.Preload.BatchSize = 0
+	a.NotNil(p.Validate())
+	p = DefaultPersistenceConfig
+	p.Preload.Enable = true
+	p.Preload.ProgressInterval = 0
+	a.NotNil(p.Validate())
+}
+
+func TestHandshake_Validate(t *testing.T) {
+	a := assert.New(t)
+	h := DefaultHandshake
+	a.Nil(h.Validate())
+	h.MaxConcurrent = 100
+	h.ConnectRate = 500
+	h.QueueTimeout = 2 * time.Second
+	a.Nil(h.Validate())
+	h.QueueTimeout = h.Timeout
+	a.NotNil(h.Validate())
+	h = DefaultHandshake
+	h.Timeout = 0
+	a.NotNil(h.Validate())
+	h = DefaultHandshake
+	h.ConnectRate = -1
+	a.NotNil(h.Validate())
+}
+
+func TestStatsPersistence_Validate(t *testing.T) {
+	a := assert.New(t)
+	s := DefaultStatsPersistence
+	s.File = ""
+	a.Nil(s.Validate())
+	s.Enable = true
+	a.NotNil(s.Validate())
+	s = DefaultStatsPersistence
+	s.Enable = true
+	a.Nil(s.Validate())
+	s.Interval = 0
+	a.NotNil(s.Validate())
+}
+
+func TestListenerConfig_Validate(t *testing.T) {
+	a := assert.New(t)
+	l := &ListenerConfig{Address: ":1883"}
+	a.Nil(l.Validate())
+	l.MountPoint = "tenants/{username}/"
+	a.Nil(l.Validate())
+	l.MountPoint = "tenants/+/"
+	a.NotNil(l.Validate())
+	l.MountPoint = "tenants/#"
+	a.NotNil(l.Validate())
+
+	l = &ListenerConfig{Address: ":8883", PSK: &PSKOptions{CipherSuites: []string{"TLS_PSK_WITH_AES_128_CCM_8"}}}
+	a.Nil(l.Validate())
+	ids, err := l.PSK.CipherSuiteIDs()
+	a.Nil(err)
+	a.Equal([]uint16{tlspsk.TLS_PSK_WITH_AES_128_CCM_8}, ids)
+	l.PSK.CipherSuites = []string{"TLS_RSA_WITH_AES_128_GCM_SHA256"}
+	a.NotNil(l.Validate())
+	l.PSK.CipherSuites = nil
+	l.TLSOptions = &TLSOptions{}
+	a.NotNil(l.Validate())
+
+	l = &ListenerConfig{Address: ":1883", Probe: &ProbeOptions{Idle: 10 * time.Second, Count: 3, WriteTimeout: 30 * time.Second}}
+	a.Nil(l.Validate())
+	l.Probe.Interval = 500 * time.Millisecond
+	a.NotNil(l.Validate())
+	l.Probe.Interval = 0
+	l.Probe.Count = -1
+	a.NotNil(l.Validate())
+}
+
+func TestRedelivery(t *testing.T) {
+	a := assert.New(t)
+	c := DefaultMQTTConfig
+	a.False(c.Redelivery.Enabled())
+	c.Redelivery.Interval = time.Second
+	c.Redelivery.Backoff = 2
+	c.Redelivery.MaxInterval = 5 * time.Second
+	a.Nil(c.Validate())
+	a.True(c.Redelivery.Enabled())
+	a.Equal(time.Second, c.Redelivery.NextInterval(0))
+	a.Equal(4*time.Second, c.Redelivery.NextInterval(2))
+	a.Equal(5*time.Second, c.Redelivery.NextInterval(3))
+
+	c.Redelivery.Backoff = 0.5
+	a.NotNil(c.Validate())
+	c.Redelivery.Backoff = 1
+	c.Redelivery.ExhaustedAction = "retry"
+	a.NotNil(c.Validate())
+}
+
+func TestOutboundBandwidth(t *testing.T) {
+	a := assert.New(t)
+	c := DefaultMQTTConfig
+	a.False(c.OutboundBandwidth.Enabled())
+	c.OutboundBandwidth.BytesPerSecond = 1024
+	a.Nil(c.Validate())
+	a.True(c.OutboundBandwidth.Enabled())
+	c.OutboundBandwidth.Burst = -1
+	a.NotNil(c.Validate())
+}
+
+func TestWriteBuffer(t *testing.T) {
+	a := assert.New(t)
+	c := DefaultMQTTConfig
+	a.False(c.WriteBuffer.Enabled())
+	c.WriteBuffer.LowWatermark = 1024
+	c.WriteBuffer.HighWatermark = 4096
+	c.WriteBuffer.HardLimit = 8192
+	a.Nil(c.Validate())
+	a.True(c.WriteBuffer.Enabled())
+
+	c.WriteBuffer.LowWatermark = 4096
+	a.NotNil(c.Validate())
+	c.WriteBuffer.LowWatermark = 1024
+	c.WriteBuffer.HardLimit = 2048
+	a.NotNil(c.Validate())
+	c.WriteBuffer.HardLimit = 8192
+	c.WriteBuffer.HardLimitTimeout = 0
+	a.NotNil(c.Validate())
+
+	// the hard limit only
+	c.WriteBuffer = WriteBuffer{HardLimit: 8192, HardLimitTimeout: time.Second}
+	a.Nil(c.Validate())
+	a.True(c.WriteBuffer.Enabled())
+}
+
+func TestDeliveryReceipts(t *testing.T) {
+	a := assert.New(t)
+	c := DefaultMQTTConfig
+	a.False(c.DeliveryReceipts.Enabled())
+	c.DeliveryReceipts.Rules = []ReceiptRule{
+		{TopicFilter: "cmd/#", ReplyTopic: "receipts/{client_id}"},
+		{TopicFilter: "#", ReplyTopic: "receipts"},
+	}
+	a.Nil(c.Validate())
+	a.True(c.DeliveryReceipts.Enabled())
+	a.Equal("receipts/c", c.DeliveryReceipts.ReplyTopic("cmd/a", "c"))
+	a.Equal("", c.DeliveryReceipts.ReplyTopic("cmd/a", ""))
+	a.Equal("receipts", c.DeliveryReceipts.ReplyTopic("a", ""))
+
+	c.DeliveryReceipts.Rules = []ReceiptRule{{TopicFilter: "cmd/#", ReplyTopic: "receipts/#"}}
+	a.NotNil(c.Validate())
+	c.DeliveryReceipts.Rules = []ReceiptRule{{TopicFilter: "cmd/#/a", ReplyTopic: "receipts"}}
+	a.NotNil(c.Validate())
+	c.DeliveryReceipts.Rules = nil
+	c.DeliveryReceipts.UserProperty = "receipt-topic"
+	c.DeliveryReceipts.Timeout = 0
+	a.NotNil(c.Validate())
+}
+
+func TestReceiveAnnotations(t *testing.T) {
+	a := assert.New(t)
+	c := DefaultMQTTConfig
+	a.Nil(c.ReceiveAnnotation("a"))
+	c.ReceiveAnnotations = []ReceiveAnnotation{
+		{TopicFilter: "telemetry/#", ReceivedAt: "received-at", ClientID: "publisher"},
+		{TopicFilter: "cmd/#", ClientID: "publisher"},
+		{TopicFilter: "#", Username: "publisher-username"},
+	}
+	a.Nil(c.Validate())
+	a.Equal(&c.ReceiveAnnotations[0], c.ReceiveAnnotation("telemetry/a"))
+	a.Equal(&c.ReceiveAnnotations[1], c.ReceiveAnnotation("cmd/a"))
+	a.Equal(&c.ReceiveAnnotations[2], c.ReceiveAnnotation("a"))
+
+	c.ReceiveAnnotations = []ReceiveAnnotation{{TopicFilter: "a/#/b", ClientID: "publisher"}}
+	a.NotNil(c.Validate())
+	c.ReceiveAnnotations = []ReceiveAnnotation{{TopicFilter: "a/#"}}
+	a.NotNil(c.Validate())
+	c.ReceiveAnnotations = []ReceiveAnnotation{{TopicFilter: "a/#", ReceivedAt: "p", ClientID: "p"}}
+	a.NotNil(c.Validate())
+	c.ReceiveAnnotations = []ReceiveAnnotation{{TopicFilter: "a/#", ClientID: "p", Username: "p"}}
+	a.NotNil(c.Validate())
+}
+
+func TestSessionExpiryClasses(t *testing.T) {
+	a := assert.New(t)
+	c := DefaultMQTTConfig
+	c.SessionExpiryClasses = []SessionExpiryClass{
+		{Name: "sensor", Username: "sensor-*", Max: time.Hour, Default: time.Minute},
+		{Name: "backend", Username: "svc-*", Max: 7 * 24 * time.Hour},
+		{Name: "premium", Max: 24 * time.Hour},
+	}
+	a.Nil(c.Validate())
+	a.Equal(&c.SessionExpiryClasses[0], c.SessionExpiryClassOf("sensor-1"))
+	a.Equal(&c.SessionExpiryClasses[1], c.SessionExpiryClassOf("svc-a"))
+	a.Nil(c.SessionExpiryClassOf("user"))
+	a.Nil(c.SessionExpiryClassOf(""))
+	a.Equal(&c.SessionExpiryClasses[2], c.SessionExpiryClass("premium"))
+	a.Nil(c.SessionExpiryClass("unknown"))
+	a.Equal(time.Minute, c.SessionExpiryClasses[0].DefaultExpiry())
+	a.Equal(7*24*time.Hour, c.SessionExpiryClasses[1].DefaultExpiry())
+
+	for _, v := range [][]SessionExpiryClass{
+		{{Max: time.Hour}},
+		{{Name: "a", Max: time.Hour}, {Name: "a", Max: time.Hour}},
+		{{Name: "a", Username: "[", Max: time.Hour}},
+		{{Name: "a", Max: -1}},
+		{{Name: "a", Max: time.Minute, Default: time.Hour}},
+	} {
+		c.SessionExpiryClasses = v
+		a.NotNil(c.Validate())
+	}
+}
+
+func TestTakeover(t *testing.T) {
+	a := assert.New(t)
+	c := DefaultMQTTConfig
+	a.Equal(TakeoverDupStrict, c.Takeover.DupFlag)
+	c.Takeover.DupFlag = TakeoverDupAlways
+	a.Nil(c.Validate())
+	c.Takeover.DupFlag = ""
+	a.NotNil(c.Validate())
+}
+
+func TestSharedSubStrategy(t *testing.T) {
+	a := assert.New(t)
+	c := DefaultMQTTConfig
+	a.Equal(SharedSubStrategyRandom, c.SharedSubStrategy)
+	for _, v := range []string{"", SharedSubStrategyRoundRobin, SharedSubStrategySticky, SharedSubStrategyLeastInflight} {
+		c.SharedSubStrategy = v
+		a.Nil(c.Validate())
+	}
+	c.SharedSubStrategy = ""
+	a.Equal(SharedSubStrategyRandom, c.SharedSubscriptionStrategy())
+	for k, v := range map[string]string{
+		"round-robin":        SharedSubStrategyRoundRobin,
+		"sticky_by_clientid": SharedSubStrategySticky,
+		"least_inflight":     SharedSubStrategyLeastInflight,
+	} {
+		c.SharedSubStrategy = k
+		a.Nil(c.Validate())
+		a.Equal(v, c.SharedSubscriptionStrategy())
+	}
+	c.SharedSubStrategy = "sticky"
+	a.NotNil(c.Validate())
+}
+
+func TestPropertyRouting(t *testing.T) {
+	a := assert.New(t)
+	c := DefaultMQTTConfig
+	a.Nil(c.PropertyRoutingRule("telemetry/a"))
+	c.PropertyRouting = []PropertyRoutingRule{
+		{TopicFilter: "telemetry/#", UserProperty: "region"},
+		{TopicFilter: "#", UserProperty: "tenant"},
+	}
+	a.Nil(c.Validate())
+	a.Equal("region", c.PropertyRoutingRule("telemetry/a").UserProperty)
+	a.Equal("tenant", c.PropertyRoutingRule("cmd/a").UserProperty)
+
+	c.PropertyRouting = []PropertyRoutingRule{{TopicFilter: "telemetry/#"}}
+	a.NotNil(c.Validate())
+	c.PropertyRouting = []PropertyRoutingRule{{TopicFilter: "telemetry/#/a", UserProperty: "region"}}
+	a.NotNil(c.Validate())
+}
+
+func TestRetainedFeed(t *testing.T) {
+	a := assert.New(t)
+	c := DefaultMQTTConfig
+	a.False(c.RetainedFeed.Enabled())
+	c.RetainedFeed.Topic = "$SYS/retained/changes"
+	c.RetainedFeed.QoS = 1
+	a.True(c.RetainedFeed.Enabled())
+	a.Nil(c.Validate())
+	c.RetainedFeed.QoS = 3
+	a.NotNil(c.Validate())
+	c.RetainedFeed.QoS = 0
+	c.RetainedFeed.Topic = "$SYS/retained/#"
+	a.NotNil(c.Validate())
+}
+
+func TestNoSubscriberCache(t *testing.T) {
+	a := assert.New(t)
+	c := DefaultMQTTConfig
+	a.False(c.NoSubscriberCache.Enabled())
+	c.NoSubscriberCache.Size = 10000
+	a.True(c.NoSubscriberCache.Enabled())
+	a.Nil(c.Validate())
+	c.NoSubscriberCache.Size = -1
+	a.NotNil(c.Validate())
+}
+
+func TestOfflineBacklog(t *testing.T) {
+	a := assert.New(t)
+	c := DefaultMQTTConfig
+	a.False(c.OfflineBacklog.Enabled())
+	c.OfflineBacklog.Threshold = 1000
+	a.True(c.OfflineBacklog.Enabled())
+	a.Nil(c.Validate())
+	c.OfflineBacklog.Threshold = -1
+	a.NotNil(c.Validate())
+}
+
+func TestTopicMessageExpiry(t *testing.T) {
+	a := assert.New(t)
+	c := DefaultMQTTConfig
+	c.TopicMessageExpiry = []TopicMessageExpiry{
+		{TopicFilter: "telemetry/#", Expiry: time.Minute},
+		{TopicFilter: "cmd/#", Expiry: 0},
+	}
+	a.Nil(c.Validate())
+	a.Equal(time.Minute, c.DefaultMessageExpiry("telemetry/a"))
+	a.Equal(time.Duration(0), c.DefaultMessageExpiry("cmd/a"))
+	a.Equal(time.Duration(0), c.DefaultMessageExpiry("a"))
+
+	c.TopicMessageExpiry = []TopicMessageExpiry{{TopicFilter: "a/#/b", Expiry: time.Minute}}
+	a.NotNil(c.Validate())
+	c.TopicMessageExpiry = []TopicMessageExpiry{{TopicFilter: "a", Expiry: -time.Minute}}
+	a.NotNil(c.Validate())
+}
+
+func TestBlackholeTopics(t *testing.T) {
+	a := assert.New(t)
+	c := DefaultMQTTConfig
+	a.False(c.Blackhole("legacy/a"))
+	c.BlackholeTopics = []string{"legacy/#"}
+	a.Nil(c.Validate())
+	a.True(c.Blackhole("legacy/a"))
+	a.False(c.Blackhole("a"))
+	c.BlackholeTopics = []string{"legacy/#/a"}
+	a.NotNil(c.Validate())
+}
+
+func TestOrderedTopics(t *testing.T) {
+	a := assert.New(t)
+	c := DefaultMQTTConfig
+	a.False(c.Ordered("sensor/a"))
+	c.OrderedTopics = []string{"sensor/+"}
+	a.Nil(c.Validate())
+	a.True(c.Ordered("sensor/a"))
+	a.False(c.Ordered("sensor/a/b"))
+	c.OrderedTopics = []string{"sensor/#/a"}
+	a.NotNil(c.Validate())
+}
+
+func TestReconnectAdvice(t *testing.T) {
+	a := assert.New(t)
+	c := DefaultMQTTConfig
+	a.False(c.ReconnectAdvice.Enabled())
+	c.ReconnectAdvice.RetryAfter = time.Minute
+	a.Nil(c.Validate())
+	a.True(c.ReconnectAdvice.Enabled())
+	c.ReconnectAdvice.UserProperty = ""
+	a.NotNil(c.Validate())
+	c.ReconnectAdvice.RetryAfter = -time.Minute
+	a.NotNil(c.Validate())
+	c.ReconnectAdvice = ReconnectAdvice{ServerReference: "backup:1883"}
+	a.Nil(c.Validate())
+	a.True(c.ReconnectAdvice.Enabled())
+}
@@ -0,0 +1,39 @@
+package config
+
+import (
+	"fmt"
+)
+
+type ConnectionEngineType = string
+
+const (
+	// ConnectionEngineGoroutine serves every connection with a dedicated reader goroutine.
+	ConnectionEngineGoroutine ConnectionEngineType = "goroutine"
+	// ConnectionEngineNetpoll uses an event-driven poller to read from raw TCP connections,
+	// the reader goroutine is only started when the connection becomes readable.
+	// It only saves the reader goroutine of the idle connections, the writer, the packet handler and the queue
+	// goroutines and the read/write buffers are still allocated per connection.
+	// Only available on linux. TLS and websocket connections always use the goroutine engine.
+	ConnectionEngineNetpoll ConnectionEngineType = "netpoll"
+)
+
+var (
+	// DefaultConnectionEngine is the default value of ConnectionEngine
+	DefaultConnectionEngine = ConnectionEngine{
+		Type: ConnectionEngineGoroutine,
+	}
+)
+
+// ConnectionEngine is the config of the connection engine.
+type ConnectionEngine struct {
+	// Type is the engine type. Possible values: goroutine, netpoll.
+	// If empty, use "goroutine" as default.
+	Type ConnectionEngineType `yaml:"type"`
+}
+
+func (c ConnectionEngine) Validate() error {
+	if c.Type != "" && c.Type != ConnectionEngineGoroutine && c.Type != ConnectionEngineNetpoll {
+		return fmt.Errorf("invalid connection_engine type: %s", c.Type)
+	}
+	return nil
+}
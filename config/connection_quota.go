@@ -0,0 +1,34 @@
+package config
+
+import (
+	"errors"
+)
+
+var (
+	// DefaultConnectionQuota is the default value of ConnectionQuota
+	DefaultConnectionQuota = ConnectionQuota{
+		MaxPerUsername: 0,
+		MaxPerIP:       0,
+	}
+)
+
+// ConnectionQuota limits the number of simultaneous connections.
+// The CONNECT packet that exceeds the quota will be rejected with 0x97 (Quota exceeded).
+// A connection that takes over the registered client of the same client id is not counted twice.
+type ConnectionQuota struct {
+	// MaxPerUsername is the maximum number of simultaneous connections per username.
+	// Connections with empty username are not limited. 0 means no limit.
+	MaxPerUsername int `yaml:"max_per_username"`
+	// MaxPerIP is the maximum number of simultaneous connections per remote IP. 0 means no limit.
+	MaxPerIP int `yaml:"max_per_ip"`
+}
+
+func (c ConnectionQuota) Validate() error {
+	if c.MaxPerUsername < 0 {
+		return errors.New("invalid connection_quota.max_per_username: must be greater than or equal to 0")
+	}
+	if c.MaxPerIP < 0 {
+		return errors.New("invalid connection_quota.max_per_ip: must be greater than or equal to 0")
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package config
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+)
+
+const (
+	// CryptoPolicyDefault uses the default settings of crypto/tls and allows all password hash types.
+	CryptoPolicyDefault = "default"
+	// CryptoPolicyFIPS restricts the TLS parameters and the password hash types to the FIPS-approved ones.
+	CryptoPolicyFIPS = "fips"
+)
+
+// fipsBuild indicates whether the binary is built with the FIPS validated crypto module,
+// see crypto_boringcrypto.go.
+var fipsBuild bool
+
+// FIPSBuild reports whether the binary is built with the boringcrypto build tag.
+func FIPSBuild() bool {
+	return fipsBuild
+}
+
+// DefaultCrypto returns the default crypto policy, which is fips in a FIPS build.
+func DefaultCrypto() Crypto {
+	if fipsBuild {
+		return Crypto{Policy: CryptoPolicyFIPS}
+	}
+	return Crypto{Policy: CryptoPolicyDefault}
+}
+
+// fipsCipherSuites are the FIPS-approved TLS 1.2 cipher suites.
+var fipsCipherSuites = []uint16{
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+}
+
+// fipsCurves are the FIPS-approved elliptic curves for key exchange.
+var fipsCurves = []tls.CurveID{tls.CurveP256, tls.CurveP384}
+
+// Crypto is the crypto policy of the broker.
+type Crypto struct {
+	// Policy is the crypto policy. Possible values: default, fips.
+	// The fips policy restricts all TLS listeners (including the API endpoints) to TLS 1.2,
+	// the FIPS-approved cipher suites and curves, and certificates with RSA >= 2048 bits or ECDSA P-256/P-384 keys.
+	// It also restricts the auth plugin to the FIPS-approved password hash types.
+	Policy string `yaml:"policy"`
+}
+
+// FIPS reports whether the fips policy is in use.
+func (c Crypto) FIPS() bool {
+	return c.Policy == CryptoPolicyFIPS
+}
+
+func (c Crypto) Validate() error {
+	if c.Policy != CryptoPolicyDefault && c.Policy != CryptoPolicyFIPS {
+		return fmt.Errorf("invalid crypto.policy: %s", c.Policy)
+	}
+	if fipsBuild && !c.FIPS() {
+		return fmt.Errorf("invalid crypto.policy: %s, the binary is built with boringcrypto and requires the fips policy", c.Policy)
+	}
+	return nil
+}
+
+// ApplyTLS restricts the TLS config to the crypto policy,
+// it returns an error if any of the certificates is not allowed by the policy.
+func (c Crypto) ApplyTLS(cfg *tls.Config) error {
+	if !c.FIPS() {
+		return nil
+	}
+	for _, cert := range cfg.Certificates {
+		if len(cert.Certificate) == 0 {
+			continue
+		}
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			return err
+		}
+		if err = fipsPublicKey(leaf); err != nil {
+			return fmt.Errorf("certificate %s is not allowed by the fips crypto policy: %s", leaf.Subject, err)
+		}
+	}
+	cfg.MinVersion = tls.VersionTLS12
+	if !fipsBuild {
+		// The TLS 1.3 cipher suites are not configurable and include ChaCha20-Poly1305.
+		// In a FIPS build, crypto/tls/fipsonly restricts them instead.
+		cfg.MaxVersion = tls.VersionTLS12
+	}
+	cfg.CipherSuites = fipsCipherSuites
+	cfg.CurvePreferences = fipsCurves
+	return nil
+}
+
+func fipsPublicKey(cert *x509.Certificate) error {
+	switch pub := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		if pub.N.BitLen() < 2048 {
+			return fmt.Errorf("rsa key size %d is less than 2048", pub.N.BitLen())
+		}
+	case *ecdsa.PublicKey:
+		if pub.Curve != elliptic.P256() && pub.Curve != elliptic.P384() {
+			return fmt.Errorf("unsupported ecdsa curve: %s", pub.Curve.Params().Name)
+		}
+	default:
+		return fmt.Errorf("unsupported public key type: %s", cert.PublicKeyAlgorithm)
+	}
+	return nil
+}
@@ -0,0 +1,13 @@
+//go:build boringcrypto
+// +build boringcrypto
+
+package config
+
+import (
+	// restricts crypto/tls to the FIPS-approved settings.
+	_ "crypto/tls/fipsonly"
+)
+
+func init() {
+	fipsBuild = true
+}
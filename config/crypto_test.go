@@ -0,0 +1,71 @@
+package config
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func selfSignedCert(t *testing.T, key crypto.Signer) tls.Certificate {
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "gmqtt"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestCrypto_Validate(t *testing.T) {
+	a := assert.New(t)
+	a.NoError(DefaultCrypto().Validate())
+	a.NoError(Crypto{Policy: CryptoPolicyFIPS}.Validate())
+	a.Error(Crypto{Policy: "unknown"}.Validate())
+}
+
+func TestCrypto_ApplyTLS(t *testing.T) {
+	a := assert.New(t)
+	rsa1024, err := rsa.GenerateKey(rand.Reader, 1024)
+	a.NoError(err)
+	rsa2048, err := rsa.GenerateKey(rand.Reader, 2048)
+	a.NoError(err)
+	p256, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	a.NoError(err)
+	p521, err := ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	a.NoError(err)
+	_, ed, err := ed25519.GenerateKey(rand.Reader)
+	a.NoError(err)
+
+	cfg := &tls.Config{Certificates: []tls.Certificate{selfSignedCert(t, rsa1024)}}
+	a.NoError(Crypto{Policy: CryptoPolicyDefault}.ApplyTLS(cfg))
+	a.Zero(cfg.MinVersion)
+	a.Nil(cfg.CipherSuites)
+
+	fips := Crypto{Policy: CryptoPolicyFIPS}
+	for _, v := range []crypto.Signer{rsa2048, p256} {
+		cfg := &tls.Config{Certificates: []tls.Certificate{selfSignedCert(t, v)}}
+		a.NoError(fips.ApplyTLS(cfg))
+		a.EqualValues(tls.VersionTLS12, cfg.MinVersion)
+		a.Equal(fipsCipherSuites, cfg.CipherSuites)
+		a.Equal(fipsCurves, cfg.CurvePreferences)
+	}
+	for _, v := range []crypto.Signer{rsa1024, p521, ed} {
+		cfg := &tls.Config{Certificates: []tls.Certificate{selfSignedCert(t, v)}}
+		a.Error(fips.ApplyTLS(cfg))
+	}
+}
@@ -0,0 +1,44 @@
+package config
+
+import (
+	"errors"
+)
+
+var (
+	// DefaultDeliveryConfig is the default value of Delivery
+	DefaultDeliveryConfig = Delivery{
+		Workers:   0,
+		BatchSize: 100,
+		QueueSize: 1024,
+	}
+)
+
+// Delivery is the config of the message delivery worker pool.
+type Delivery struct {
+	// Workers is the number of delivery workers.
+	// If greater than zero, the matched messages will be added into subscriber queues by the worker pool
+	// instead of the publisher's goroutine. Messages to the same subscriber are always handled by the same worker,
+	// so the message order of the subscriber is kept.
+	// 0 means disable the worker pool.
+	Workers int `yaml:"workers"`
+	// BatchSize is the maximum number of deliveries that will be sent to a worker at once.
+	BatchSize int `yaml:"batch_size"`
+	// QueueSize is the maximum number of pending batches of each worker.
+	// The publisher will be blocked if the queue is full.
+	QueueSize int `yaml:"queue_size"`
+}
+
+func (d Delivery) Validate() error {
+	if d.Workers < 0 {
+		return errors.New("invalid delivery.workers: must be greater than or equal to 0")
+	}
+	if d.Workers > 0 {
+		if d.BatchSize <= 0 {
+			return errors.New("invalid delivery.batch_size: must be greater than 0")
+		}
+		if d.QueueSize < 0 {
+			return errors.New("invalid delivery.queue_size: must be greater than or equal to 0")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	BanByClientID = "client_id"
+	BanByIP       = "ip"
+)
+
+var (
+	// DefaultFlappingDetection is the default value of FlappingDetection
+	DefaultFlappingDetection = FlappingDetection{
+		Enable:         false,
+		Window:         time.Minute,
+		MaxConnects:    15,
+		BanDuration:    time.Minute,
+		MaxBanDuration: time.Hour,
+		BanBy:          BanByClientID,
+	}
+)
+
+// FlappingDetection is the config of the connection flapping detection.
+// A client that connects more than MaxConnects times in Window will be banned temporarily,
+// CONNECT packets from banned clients are rejected with 0x8A (Banned).
+type FlappingDetection struct {
+	Enable bool `yaml:"enable"`
+	// Window is the time window to count the connects.
+	Window time.Duration `yaml:"window"`
+	// MaxConnects is the maximum number of connects allowed in Window.
+	MaxConnects int `yaml:"max_connects"`
+	// BanDuration is the duration of the first ban.
+	// The duration is doubled for each subsequent ban, up to MaxBanDuration.
+	BanDuration time.Duration `yaml:"ban_duration"`
+	// MaxBanDuration is the maximum ban duration.
+	// The ban duration is reset if the client has not been banned for MaxBanDuration.
+	MaxBanDuration time.Duration `yaml:"max_ban_duration"`
+	// BanBy specifies how to identify a client, the possible value can be "client_id" or "ip".
+	BanBy string `yaml:"ban_by"`
+}
+
+func (f FlappingDetection) Validate() error {
+	if !f.Enable {
+		return nil
+	}
+	if f.Window <= 0 {
+		return errors.New("invalid flapping_detection.window: must be greater than 0")
+	}
+	if f.MaxConnects <= 0 {
+		return errors.New("invalid flapping_detection.max_connects: must be greater than 0")
+	}
+	if f.BanDuration <= 0 {
+		return errors.New("invalid flapping_detection.ban_duration: must be greater than 0")
+	}
+	if f.MaxBanDuration < f.BanDuration {
+		return errors.New("invalid flapping_detection.max_ban_duration: must be greater than or equal to ban_duration")
+	}
+	if f.BanBy != BanByClientID && f.BanBy != BanByIP {
+		return fmt.Errorf("invalid flapping_detection.ban_by: %s", f.BanBy)
+	}
+	return nil
+}
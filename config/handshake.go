@@ -0,0 +1,55 @@
+package config
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	// DefaultHandshake is the default value of Handshake
+	DefaultHandshake = Handshake{
+		Timeout:       5 * time.Second,
+		MaxConcurrent: 0,
+		ConnectRate:   0,
+		ConnectBurst:  0,
+		QueueTimeout:  0,
+	}
+)
+
+// Handshake is the config of the CONNECT handshake.
+// It limits the concurrent handshakes and the rate of the CONNECT packets across the broker,
+// so that a mass reconnect, e.g. after a network outage, degrades gracefully instead of
+// spiking the latency for the connected clients.
+// The CONNECT packets beyond the limits wait in the queue for up to QueueTimeout,
+// and are rejected with 0x89 (Server busy) or 0x9F (Connection rate exceeded) (v3: 0x03 Server unavailable) after that.
+type Handshake struct {
+	// Timeout is the time for the new connections to complete the CONNECT handshake,
+	// including the enhanced authentication. The connection is closed if it is exceeded.
+	Timeout time.Duration `yaml:"timeout"`
+	// MaxConcurrent is the maximum number of the CONNECT packets being processed (e.g. by the auth hooks) at the same time.
+	// 0 means no limit.
+	MaxConcurrent int `yaml:"max_concurrent"`
+	// ConnectRate is the maximum number of the CONNECT packets processed per second. 0 means no limit.
+	ConnectRate int `yaml:"connect_rate"`
+	// ConnectBurst is the burst of ConnectRate. If zero, use ConnectRate as default.
+	ConnectBurst int `yaml:"connect_burst"`
+	// QueueTimeout is the maximum time that the CONNECT packets wait for the limits.
+	// 0 means the CONNECT packets beyond the limits are rejected immediately.
+	QueueTimeout time.Duration `yaml:"queue_timeout"`
+}
+
+func (h Handshake) Validate() error {
+	if h.Timeout <= 0 {
+		return errors.New("invalid handshake.timeout: must be greater than 0")
+	}
+	if h.MaxConcurrent < 0 {
+		return errors.New("invalid handshake.max_concurrent: must be greater than or equal to 0")
+	}
+	if h.ConnectRate < 0 || h.ConnectBurst < 0 {
+		return errors.New("invalid handshake connect rate: must be greater than or equal to 0")
+	}
+	if h.QueueTimeout < 0 || h.QueueTimeout >= h.Timeout {
+		return errors.New("invalid handshake.queue_timeout: must be greater than or equal to 0 and less than handshake.timeout")
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	// HookFailOpen treats the timed out hook as success.
+	HookFailOpen = "fail_open"
+	// HookFailClosed treats the timed out hook as failure.
+	HookFailClosed = "fail_closed"
+)
+
+var (
+	// DefaultHookTiming is the default value of HookTiming
+	DefaultHookTiming = HookTiming{
+		Metrics: false,
+	}
+)
+
+// HookTiming is the config of the hook latency metrics and timeouts.
+type HookTiming struct {
+	// Metrics indicates whether to record the latency histogram of each hook of each plugin.
+	// The latency of a plugin does not include the time spent by the plugins before it in the hook chain.
+	Metrics bool `yaml:"metrics"`
+	// Timeouts is the timeouts of the hook chains.
+	// Only the hooks which decide the result of CONNECT, AUTH, SUBSCRIBE, UNSUBSCRIBE, PUBLISH packets and new connections can have a timeout,
+	// which are OnAccept, OnBasicAuth, OnEnhancedAuth, OnReAuth, OnSubscribe, OnUnsubscribe and OnMsgArrived.
+	Timeouts []HookTimeout `yaml:"timeouts"`
+}
+
+// HookTimeout is the timeout setting of a hook chain.
+type HookTimeout struct {
+	// Hook is the hook name, e.g. OnBasicAuth.
+	Hook string `yaml:"hook"`
+	// Timeout is the maximum time that the hook chain can take.
+	// The context passed to the hooks is cancelled after the timeout,
+	// the modifications made to the request by the hooks are discarded.
+	Timeout time.Duration `yaml:"timeout"`
+	// Policy is the behavior after the timeout, the possible value can be "fail_open" or "fail_closed".
+	// "fail_open" treats the hook as success, e.g. allows the connection for OnBasicAuth.
+	// "fail_closed" treats the hook as failure, e.g. rejects the connection with 0x88 (Server unavailable) for OnBasicAuth.
+	Policy string `yaml:"policy"`
+}
+
+func (h HookTiming) Validate() error {
+	hooks := make(map[string]struct{})
+	for _, v := range h.Timeouts {
+		if _, ok := hooks[v.Hook]; ok {
+			return fmt.Errorf("invalid hook_timing.timeouts: duplicated hook %s", v.Hook)
+		}
+		hooks[v.Hook] = struct{}{}
+		if v.Timeout <= 0 {
+			return fmt.Errorf("invalid hook_timing.timeouts.timeout of %s: must be greater than 0", v.Hook)
+		}
+		if v.Policy != HookFailOpen && v.Policy != HookFailClosed {
+			return fmt.Errorf("invalid hook_timing.timeouts.policy of %s: %s", v.Hook, v.Policy)
+		}
+	}
+	return nil
+}
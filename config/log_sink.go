@@ -0,0 +1,128 @@
+package config
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/DrmagicE/gmqtt/pkg/logsink"
+)
+
+var (
+	// DefaultLogSink is the default buffering setting of the log sinks.
+	DefaultLogSink = LogSink{
+		Enable:        false,
+		BufferSize:    10000,
+		BatchSize:     100,
+		FlushInterval: time.Second,
+		Timeout:       5 * time.Second,
+		DropOnFull:    true,
+	}
+	// DefaultLogSinks is the default value of LogSinks.
+	DefaultLogSinks = LogSinks{
+		Kafka: KafkaLogSink{
+			LogSink: DefaultLogSink,
+			Topic:   "gmqtt-logs",
+		},
+		Fluentd: FluentdLogSink{
+			LogSink: DefaultLogSink,
+			Address: "127.0.0.1:24224",
+			Tag:     "gmqtt",
+		},
+	}
+)
+
+// LogSinks is the config of the log sinks, which ship the structured JSON log records to the remote collectors.
+type LogSinks struct {
+	Kafka   KafkaLogSink   `yaml:"kafka"`
+	Fluentd FluentdLogSink `yaml:"fluentd"`
+}
+
+// LogSink is the common buffering setting of the log sinks.
+type LogSink struct {
+	Enable bool `yaml:"enable"`
+	// BufferSize is the max number of the log records buffered in memory.
+	BufferSize int `yaml:"buffer_size"`
+	// BatchSize is the max number of the log records in one shipping.
+	BatchSize int `yaml:"batch_size"`
+	// FlushInterval is the interval to ship the buffered records, it is also the retry interval after a failure.
+	FlushInterval time.Duration `yaml:"flush_interval"`
+	// Timeout is the timeout of the network operations.
+	Timeout time.Duration `yaml:"timeout"`
+	// DropOnFull indicates whether to drop the log records when the buffer is full.
+	// If it is false, the logging goroutine will be blocked until the buffer is available.
+	DropOnFull bool `yaml:"drop_on_full"`
+}
+
+// KafkaLogSink is the config of the Kafka log sink.
+type KafkaLogSink struct {
+	LogSink `yaml:",inline"`
+	// Brokers is the bootstrap broker addresses.
+	Brokers []string `yaml:"brokers"`
+	// Topic is the topic to produce.
+	Topic string `yaml:"topic"`
+	// Partition is the partition to produce.
+	Partition int32 `yaml:"partition"`
+}
+
+// FluentdLogSink is the config of the Fluent Forward log sink.
+type FluentdLogSink struct {
+	LogSink `yaml:",inline"`
+	// Address is the address of the Fluent Forward endpoint.
+	Address string `yaml:"address"`
+	// Tag is the tag of the log records.
+	Tag string `yaml:"tag"`
+}
+
+func (l LogSink) options() logsink.Options {
+	return logsink.Options{
+		BufferSize:    l.BufferSize,
+		BatchSize:     l.BatchSize,
+		FlushInterval: l.FlushInterval,
+		DropOnFull:    l.DropOnFull,
+	}
+}
+
+func (l LogSink) validate(name string) error {
+	if l.BufferSize <= 0 {
+		return fmt.Errorf("invalid log.sinks.%s.buffer_size: must be greater than 0", name)
+	}
+	if l.BatchSize <= 0 {
+		return fmt.Errorf("invalid log.sinks.%s.batch_size: must be greater than 0", name)
+	}
+	if l.FlushInterval <= 0 {
+		return fmt.Errorf("invalid log.sinks.%s.flush_interval: must be greater than 0", name)
+	}
+	if l.Timeout <= 0 {
+		return fmt.Errorf("invalid log.sinks.%s.timeout: must be greater than 0", name)
+	}
+	return nil
+}
+
+func (l LogSinks) Validate() error {
+	if l.Kafka.Enable {
+		if err := l.Kafka.validate("kafka"); err != nil {
+			return err
+		}
+		if len(l.Kafka.Brokers) == 0 {
+			return fmt.Errorf("invalid log.sinks.kafka.brokers: must not be empty")
+		}
+		if l.Kafka.Topic == "" {
+			return fmt.Errorf("invalid log.sinks.kafka.topic: must not be empty")
+		}
+		if l.Kafka.Partition < 0 {
+			return fmt.Errorf("invalid log.sinks.kafka.partition: must be greater than or equal to 0")
+		}
+	}
+	if l.Fluentd.Enable {
+		if err := l.Fluentd.validate("fluentd"); err != nil {
+			return err
+		}
+		if l.Fluentd.Address == "" {
+			return fmt.Errorf("invalid log.sinks.fluentd.address: must not be empty")
+		}
+		if l.Fluentd.Tag == "" {
+			return fmt.Errorf("invalid log.sinks.fluentd.tag: must not be empty")
+		}
+	}
+	return nil
+}
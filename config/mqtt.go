@@ -2,6 +2,9 @@ package config
 
 import (
 	"fmt"
+	"math"
+	"path"
+	"strings"
 	"time"
 
 	"github.com/DrmagicE/gmqtt/pkg/packets"
@@ -12,6 +15,42 @@ const (
 	OnlyOnce = "onlyonce"
 )
 
+const (
+	// SharedSubStrategyRandom dispatches the message to a random member of the shared subscription group.
+	SharedSubStrategyRandom = "random"
+	// SharedSubStrategyRoundRobin dispatches the messages to the members of the shared subscription group in turn.
+	SharedSubStrategyRoundRobin = "round_robin"
+	// SharedSubStrategySticky dispatches the messages from the same publisher to the same member of the shared subscription group,
+	// as long as the members of the group do not change.
+	SharedSubStrategySticky = "sticky-by-clientid"
+	// SharedSubStrategyLeastInflight dispatches the message to the online member of the shared subscription group
+	// which has the fewest inflight messages.
+	SharedSubStrategyLeastInflight = "least-inflight"
+)
+
+// sharedSubStrategyAliases is the alternative spellings of the shared subscription strategies.
+var sharedSubStrategyAliases = map[string]string{
+	"round-robin":        SharedSubStrategyRoundRobin,
+	"sticky_by_clientid": SharedSubStrategySticky,
+	"least_inflight":     SharedSubStrategyLeastInflight,
+}
+
+const (
+	// PropertyMappingDrop drops the V5 properties when delivering to the V3 clients.
+	PropertyMappingDrop = "drop"
+	// PropertyMappingEnvelope encodes the V5 properties and the payload into a JSON envelope as the payload.
+	PropertyMappingEnvelope = "envelope"
+	// PropertyMappingReject does not deliver the message to the V3 clients.
+	PropertyMappingReject = "reject"
+)
+
+const (
+	// RedeliveryExhaustedDrop removes the message from the session and reports it as dropped.
+	RedeliveryExhaustedDrop = "drop"
+	// RedeliveryExhaustedDisconnect closes the connection, the message is kept in the session.
+	RedeliveryExhaustedDisconnect = "disconnect"
+)
+
 var (
 	// DefaultMQTTConfig
 	DefaultMQTTConfig = MQTT{
@@ -25,6 +64,7 @@ var (
 		TopicAliasMax:              10,
 		SubscriptionIDAvailable:    true,
 		SharedSubAvailable:         true,
+		SharedSubStrategy:          SharedSubStrategyRandom,
 		WildcardAvailable:          true,
 		RetainAvailable:            true,
 		MaxQueuedMsg:               1000,
@@ -33,12 +73,42 @@ var (
 		QueueQos0Msg:               true,
 		DeliveryMode:               OnlyOnce,
 		AllowZeroLenClientID:       true,
+		AllowAnonymous:             true,
+		MaxRetainedPerSubscribe:    0,
+		RetainedBatchInterval:      100 * time.Millisecond,
+		PropertyMapping: PropertyMapping{
+			UserProperties: PropertyMappingDrop,
+			ContentType:    PropertyMappingDrop,
+			ResponseTopic:  PropertyMappingDrop,
+		},
+		Redelivery: Redelivery{
+			Backoff:         1,
+			ExhaustedAction: RedeliveryExhaustedDrop,
+		},
+		WriteBuffer: WriteBuffer{
+			HardLimitTimeout: 10 * time.Second,
+		},
+		DeliveryReceipts: DeliveryReceipts{
+			Timeout: 5 * time.Minute,
+		},
+		ReconnectAdvice: ReconnectAdvice{
+			UserProperty: "retry-after",
+		},
+		Takeover: Takeover{
+			DupFlag: TakeoverDupStrict,
+		},
 	}
 )
 
 type MQTT struct {
 	// SessionExpiry is the maximum session expiry interval in seconds.
+	// It is also the session expiry interval of the V3 clients which connect with the clean session flag unset.
 	SessionExpiry time.Duration `yaml:"session_expiry"`
+	// SessionExpiryClasses overrides SessionExpiry for the classes of the users,
+	// e.g. the backend services keep their sessions for days while the battery sensors keep them for an hour.
+	// The class of a client is the first class whose username pattern matches the username,
+	// the auth hooks can select another one by the name, see server.AuthOptions.
+	SessionExpiryClasses []SessionExpiryClass `yaml:"session_expiry_classes"`
 	// SessionExpiryCheckInterval is the interval time for session expiry checker to check whether there
 	// are expired sessions.
 	SessionExpiryCheckInterval time.Duration `yaml:"session_expiry_check_interval"`
@@ -66,6 +136,10 @@ type MQTT struct {
 	SubscriptionIDAvailable bool `yaml:"subscription_identifier_available"`
 	// SharedSubAvailable indicates whether the server supports Shared Subscriptions.
 	SharedSubAvailable bool `yaml:"shared_subscription_available"`
+	// SharedSubStrategy is the strategy to pick the member of the shared subscription group to deliver the message to.
+	// The possible value can be "random", "round_robin", "sticky-by-clientid" or "least-inflight".
+	// "round-robin", "sticky_by_clientid" and "least_inflight" are accepted as aliases. Empty means "random".
+	SharedSubStrategy string `yaml:"shared_subscription_strategy"`
 	// WildcardSubAvailable indicates whether the server supports Wildcard Subscriptions.
 	WildcardAvailable bool `yaml:"wildcard_subscription_available"`
 	// RetainAvailable indicates whether the server supports retained messages.
@@ -89,6 +163,597 @@ type MQTT struct {
 	DeliveryMode string `yaml:"delivery_mode"`
 	// AllowZeroLenClientID indicates whether to allow a client to connect with empty client id.
 	AllowZeroLenClientID bool `yaml:"allow_zero_length_clientid"`
+	// AllowAnonymous indicates whether to allow a client to connect without username.
+	// It can be overridden by the allow_anonymous setting of the listener.
+	// V5 clients using enhanced authentication are not treated as anonymous.
+	AllowAnonymous bool `yaml:"allow_anonymous"`
+	// MaxRetainedPerSubscribe is the maximum number of retained messages that will be sent for a single subscription.
+	// The exceeded retained messages will be ignored. 0 means no limit.
+	MaxRetainedPerSubscribe int `yaml:"max_retained_per_subscribe"`
+	// RetainedBatchInterval is the interval to send the next batch of retained messages.
+	// The retained messages that match a subscription are sent in batches,
+	// each batch is no more than the client's Receive Maximum and
+	// the next batch will not be sent until the client has consumed the previous one.
+	// The rest of the batches are added into the session queue if the client disconnects,
+	// and discarded if the subscription is removed or replaced.
+	RetainedBatchInterval time.Duration `yaml:"retained_batch_interval"`
+	// RetainedSeed is the retained messages which are loaded at startup.
+	RetainedSeed RetainedSeed `yaml:"retained_seed"`
+	// TopicPayloadLimits limits the payload size of the messages by topic namespaces.
+	// The first limit that matches the topic name takes effect,
+	// the client which publishes a message exceeding the limit is disconnected,
+	// with DISCONNECT 0x95 (Packet too large) if it is a V5 client.
+	TopicPayloadLimits []TopicPayloadLimit `yaml:"topic_payload_limits"`
+	// ReportNoSubscriberTopics is the topic filters of the messages that are reported as dropped
+	// (with the no_subscriber reason) if there is no matching subscriber for them.
+	ReportNoSubscriberTopics []string `yaml:"report_no_subscriber_topics"`
+	// BlackholeTopics is the topic filters of the messages that are acknowledged as usual but never routed or retained.
+	// They are reported as dropped with the blackhole reason.
+	BlackholeTopics []string `yaml:"blackhole_topics"`
+	// OrderedTopics is the topic filters of the messages that are delivered to each subscriber in strict FIFO order
+	// across QoS levels, redeliveries and reconnects.
+	// At most one QoS 1 or QoS 2 message per topic name is inflight for each subscriber,
+	// the following messages of the same topic name are held until it is acknowledged.
+	OrderedTopics []string `yaml:"ordered_topics"`
+	// PropertyMapping is the conversion of the V5 properties between the V5 and V3 clients.
+	PropertyMapping PropertyMapping `yaml:"property_mapping"`
+	// Redelivery is the redelivery policy of the unacknowledged QoS 1 and QoS 2 messages while the connection stays alive.
+	Redelivery Redelivery `yaml:"redelivery"`
+	// OutboundBandwidth limits the bytes per second of the messages written to each client.
+	OutboundBandwidth Bandwidth `yaml:"outbound_bandwidth"`
+	// WriteBuffer limits the bytes of the messages which are waiting to be written to each client.
+	WriteBuffer WriteBuffer `yaml:"write_buffer"`
+	// DeliveryReceipts is the setting of the delivery receipts which are published to the reply topics.
+	DeliveryReceipts DeliveryReceipts `yaml:"delivery_receipts"`
+	// TopicMessageExpiry is the default lifetime of the queued messages by topic namespaces,
+	// which applies to the messages that the publisher does not set the message expiry for.
+	// The first rule that matches the topic name takes effect, MessageExpiry is still the upper limit.
+	TopicMessageExpiry []TopicMessageExpiry `yaml:"topic_message_expiry"`
+	// ReconnectAdvice is the advice carried in the CONNACK to the V5 clients which are refused because of
+	// the overload, the bans or the connection quota, so that they back off instead of reconnecting immediately.
+	ReconnectAdvice ReconnectAdvice `yaml:"reconnect_advice"`
+	// ReceiveAnnotations stamps the messages published by the clients with the broker receive time and the publisher
+	// client id and username in the user properties by topic namespaces, so that the consumers can measure the latency without
+	// trusting the device clocks and attribute the messages to the publishers. The first rule that matches the topic name takes effect.
+	ReceiveAnnotations []ReceiveAnnotation `yaml:"receive_annotations"`
+	// Takeover is the handling of the inflight messages when a client connects with the client id of an online client
+	// and resumes the session.
+	Takeover Takeover `yaml:"takeover"`
+	// RetainedFeed publishes an event whenever a retained message is set, updated or cleared,
+	// so that the external caches can stay in sync without polling.
+	RetainedFeed RetainedFeed `yaml:"retained_feed"`
+	// PropertyRouting scopes the delivery of the messages by the user properties in addition to the topic,
+	// e.g. by tenant or region, without encoding them into the topic names. The first rule that matches the topic name takes effect.
+	PropertyRouting []PropertyRoutingRule `yaml:"property_routing"`
+	// NoSubscriberCache caches the topics which have no subscribers, so that the publishes to them skip
+	// the subscription matching. It is invalidated whenever a subscription is added.
+	NoSubscriberCache NoSubscriberCache `yaml:"no_subscriber_cache"`
+	// OfflineBacklog is the alerting of the offline sessions which have too many undelivered messages.
+	OfflineBacklog OfflineBacklog `yaml:"offline_backlog"`
+}
+
+// SessionExpiryClass is the session expiry setting of a class of the users.
+type SessionExpiryClass struct {
+	// Name is the unique name of the class.
+	Name string `yaml:"name"`
+	// Username is the pattern of the usernames in the class, see path.Match for the syntax.
+	// Empty means that the class can only be selected by the auth hooks.
+	Username string `yaml:"username"`
+	// Max is the maximum session expiry interval of the clients in the class.
+	Max time.Duration `yaml:"max"`
+	// Default is the session expiry interval of the V3 clients in the class which connect with the clean session flag unset,
+	// which have no way to request one. 0 means Max.
+	Default time.Duration `yaml:"default"`
+}
+
+// DefaultExpiry returns the session expiry interval of the V3 clients in the class.
+func (s *SessionExpiryClass) DefaultExpiry() time.Duration {
+	if s.Default == 0 {
+		return s.Max
+	}
+	return s.Default
+}
+
+// SessionExpiryClass returns the session expiry class of the name, nil if not found.
+func (c MQTT) SessionExpiryClass(name string) *SessionExpiryClass {
+	for k, v := range c.SessionExpiryClasses {
+		if v.Name == name {
+			return &c.SessionExpiryClasses[k]
+		}
+	}
+	return nil
+}
+
+// SessionExpiryClassOf returns the first session expiry class whose username pattern matches the username, nil if none matches.
+func (c MQTT) SessionExpiryClassOf(username string) *SessionExpiryClass {
+	for k, v := range c.SessionExpiryClasses {
+		if v.Username == "" {
+			continue
+		}
+		if ok, _ := path.Match(v.Username, username); ok {
+			return &c.SessionExpiryClasses[k]
+		}
+	}
+	return nil
+}
+
+// OfflineBacklog is the alerting threshold of the messages queued for an offline session.
+type OfflineBacklog struct {
+	// Threshold is the number of the messages queued since the client disconnected,
+	// which triggers the OnOfflineBacklog hook once per disconnection. 0 means disabled.
+	Threshold int `yaml:"threshold"`
+}
+
+// Enabled returns whether the offline backlog alerting is enabled.
+func (o OfflineBacklog) Enabled() bool {
+	return o.Threshold > 0
+}
+
+// NoSubscriberCache is the negative cache of the topics which have no subscribers.
+type NoSubscriberCache struct {
+	// Size is the maximum number of the cached topics, the cache is reset when it is full. 0 means disabled.
+	Size int `yaml:"size"`
+}
+
+// Enabled returns whether the no subscriber cache is enabled.
+func (n NoSubscriberCache) Enabled() bool {
+	return n.Size > 0
+}
+
+// PropertyRoutingRule scopes the delivery of the messages whose topic name matches the topic filter by the user property.
+// The subscriptions which declare the UserProperty in the user properties of the V5 SUBSCRIBE packet only receive the messages
+// which carry the user property with one of the declared values. The subscriptions which do not declare it are not affected.
+type PropertyRoutingRule struct {
+	TopicFilter  string `yaml:"topic_filter"`
+	UserProperty string `yaml:"user_property"`
+}
+
+// RetainedFeed is the change feed of the retained messages. The events are published as JSON to the Topic,
+// they are not retained and do not trigger any hooks. The retained messages which are dropped for expiry have no event.
+type RetainedFeed struct {
+	// Topic is the topic which the events are published to, e.g. $SYS/retained/changes. Empty means the feed is disabled.
+	Topic string `yaml:"topic"`
+	// QoS is the QoS level of the events.
+	QoS uint8 `yaml:"qos"`
+	// IncludePayload indicates whether the payload of the retained message is carried in the events,
+	// otherwise the subscribers have to read the retained message by the topic.
+	IncludePayload bool `yaml:"include_payload"`
+}
+
+// Enabled returns whether the retained feed is enabled.
+func (r RetainedFeed) Enabled() bool {
+	return r.Topic != ""
+}
+
+func (r RetainedFeed) validate() error {
+	if !r.Enabled() {
+		return nil
+	}
+	if !packets.ValidTopicName(true, []byte(r.Topic)) {
+		return fmt.Errorf("invalid retained_feed.topic: %s", r.Topic)
+	}
+	if r.QoS > packets.Qos2 {
+		return fmt.Errorf("invalid retained_feed.qos: %d", r.QoS)
+	}
+	return nil
+}
+
+const (
+	// TakeoverDupStrict resends the inflight PUBLISH packets which have never been written to the previous connection
+	// without the DUP flag, and the others with the DUP flag.
+	TakeoverDupStrict = "strict"
+	// TakeoverDupAlways resends all inflight PUBLISH packets with the DUP flag.
+	TakeoverDupAlways = "always"
+)
+
+// Takeover is the handling of the inflight messages on the session takeover.
+// The previous connection is closed before the session is handed off to the new connection,
+// the inflight QoS 1 and QoS 2 messages are resent to the new connection with the same packet ids before any new message.
+type Takeover struct {
+	// DupFlag is the DUP flag of the resent inflight PUBLISH packets, the possible value can be "strict" or "always".
+	// With "strict", the messages which were queued for the previous connection but never written to it
+	// (e.g. waiting in the write buffer when it is closed) are resent without the DUP flag.
+	DupFlag string `yaml:"dup_flag"`
+}
+
+func (t Takeover) validate() error {
+	if t.DupFlag != TakeoverDupStrict && t.DupFlag != TakeoverDupAlways {
+		return fmt.Errorf("invalid takeover.dup_flag: %s", t.DupFlag)
+	}
+	return nil
+}
+
+// ReconnectAdvice is the back off advice to the refused V5 clients.
+// It applies to the CONNACK with the Server busy (0x89), Banned (0x8A), Quota exceeded (0x97)
+// and Connection rate exceeded (0x9F) reason codes.
+type ReconnectAdvice struct {
+	// RetryAfter is the time in seconds that the clients are advised to wait before reconnecting, 0 means no advice.
+	// It is carried in the user property.
+	RetryAfter time.Duration `yaml:"retry_after"`
+	// UserProperty is the name of the user property which carries the RetryAfter.
+	UserProperty string `yaml:"user_property"`
+	// ServerReference is the other server that the clients are advised to use, carried in the Server Reference property.
+	ServerReference string `yaml:"server_reference"`
+}
+
+// Enabled returns whether the reconnect advice is enabled.
+func (r ReconnectAdvice) Enabled() bool {
+	return r.RetryAfter > 0 || r.ServerReference != ""
+}
+
+func (r ReconnectAdvice) validate() error {
+	if r.RetryAfter < 0 {
+		return fmt.Errorf("invalid reconnect_advice.retry_after: %s", r.RetryAfter)
+	}
+	if r.RetryAfter > 0 && r.UserProperty == "" {
+		return fmt.Errorf("reconnect_advice.user_property must not be empty")
+	}
+	return nil
+}
+
+// DeliveryReceipts controls the delivery receipts, which are the delivery-status messages published by the broker to a reply topic
+// once a QoS 1 or QoS 2 message has been acknowledged by all matching subscribers, or has been dropped or expired for some of them.
+// A receipt is requested either by the publisher with the user property, or by the rules for the topic.
+type DeliveryReceipts struct {
+	// UserProperty is the name of the user property by which the V5 publishers request the receipts, the value is the reply topic.
+	// The user property is removed before the message is delivered. Empty means the receipts can not be requested by the publishers.
+	UserProperty string `yaml:"user_property"`
+	// Rules request the receipts for the messages by the topic, the first rule that matches the topic takes effect.
+	Rules []ReceiptRule `yaml:"rules"`
+	// Timeout is the maximum time to wait for the acknowledgements, the message expiry interval is used if it is shorter.
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// ReceiptRule requests the delivery receipts for the messages whose topic matches the TopicFilter.
+type ReceiptRule struct {
+	TopicFilter string `yaml:"topic_filter"`
+	// ReplyTopic is the topic to publish the receipts, "{client_id}" is replaced by the client id of the publisher.
+	ReplyTopic string `yaml:"reply_topic"`
+}
+
+// ReceiptClientID is the placeholder of the publisher client id in the ReceiptRule.ReplyTopic.
+const ReceiptClientID = "{client_id}"
+
+// Enabled returns whether the delivery receipts are enabled.
+func (d DeliveryReceipts) Enabled() bool {
+	return d.UserProperty != "" || len(d.Rules) != 0
+}
+
+// ReplyTopic returns the reply topic of the first rule that matches the topic, empty if none matches
+// or the reply topic requires the client id which is empty.
+func (d DeliveryReceipts) ReplyTopic(topic string, clientID string) string {
+	for _, v := range d.Rules {
+		if packets.TopicMatch([]byte(topic), []byte(v.TopicFilter)) {
+			if clientID == "" && strings.Contains(v.ReplyTopic, ReceiptClientID) {
+				return ""
+			}
+			return strings.ReplaceAll(v.ReplyTopic, ReceiptClientID, clientID)
+		}
+	}
+	return ""
+}
+
+func (d DeliveryReceipts) validate() error {
+	if !d.Enabled() {
+		return nil
+	}
+	if d.Timeout <= 0 {
+		return fmt.Errorf("invalid delivery_receipts.timeout: %s", d.Timeout)
+	}
+	for _, v := range d.Rules {
+		if !packets.ValidTopicFilter(true, []byte(v.TopicFilter)) {
+			return fmt.Errorf("invalid delivery_receipts.rules.topic_filter: %s", v.TopicFilter)
+		}
+		if !packets.ValidTopicName(true, []byte(strings.ReplaceAll(v.ReplyTopic, ReceiptClientID, "x"))) {
+			return fmt.Errorf("invalid delivery_receipts.rules.reply_topic: %s", v.ReplyTopic)
+		}
+	}
+	return nil
+}
+
+// Bandwidth is a byte-rate limit enforced by a token bucket.
+// When the limit is reached, the messages to the client are held in the session queue
+// instead of piling up in the write buffer of the connection.
+type Bandwidth struct {
+	// BytesPerSecond is the sustained rate, 0 means unlimited.
+	BytesPerSecond int `yaml:"bytes_per_second"`
+	// Burst is the maximum bytes that can be written at once after an idle period.
+	// It defaults to BytesPerSecond if it is 0.
+	Burst int `yaml:"burst"`
+}
+
+// Enabled returns whether the bandwidth is limited.
+func (b Bandwidth) Enabled() bool {
+	return b.BytesPerSecond > 0
+}
+
+func (b Bandwidth) validate() error {
+	if b.BytesPerSecond < 0 {
+		return fmt.Errorf("invalid outbound_bandwidth.bytes_per_second: %d", b.BytesPerSecond)
+	}
+	if b.Burst < 0 {
+		return fmt.Errorf("invalid outbound_bandwidth.burst: %d", b.Burst)
+	}
+	return nil
+}
+
+// WriteBuffer limits the bytes of the PUBLISH packets which are waiting to be written to the connection of a client.
+// When the buffered bytes reach the HighWatermark, the replay of the queued and retained messages is paused
+// until the buffered bytes fall to the LowWatermark, the paused messages stay in the session queue.
+// If the buffered bytes stay above the HardLimit for longer than the HardLimitTimeout, the client is considered
+// as a slow consumer and is disconnected with 0x96 (Message rate too high).
+type WriteBuffer struct {
+	// LowWatermark is the buffered bytes at which the paused replay resumes.
+	LowWatermark int `yaml:"low_watermark"`
+	// HighWatermark is the buffered bytes at which the replay is paused, 0 means never paused.
+	HighWatermark int `yaml:"high_watermark"`
+	// HardLimit is the buffered bytes above which the client is disconnected after the HardLimitTimeout, 0 means no limit.
+	HardLimit int `yaml:"hard_limit"`
+	// HardLimitTimeout is how long the buffered bytes can stay above the HardLimit.
+	HardLimitTimeout time.Duration `yaml:"hard_limit_timeout"`
+}
+
+// Enabled returns whether the write buffer is tracked.
+func (w WriteBuffer) Enabled() bool {
+	return w.HighWatermark > 0 || w.HardLimit > 0
+}
+
+func (w WriteBuffer) validate() error {
+	if w.LowWatermark < 0 {
+		return fmt.Errorf("invalid write_buffer.low_watermark: %d", w.LowWatermark)
+	}
+	if w.HighWatermark < 0 {
+		return fmt.Errorf("invalid write_buffer.high_watermark: %d", w.HighWatermark)
+	}
+	if w.HighWatermark > 0 && w.LowWatermark >= w.HighWatermark {
+		return fmt.Errorf("write_buffer.low_watermark must be less than write_buffer.high_watermark")
+	}
+	if w.HardLimit < 0 {
+		return fmt.Errorf("invalid write_buffer.hard_limit: %d", w.HardLimit)
+	}
+	if w.HardLimit > 0 && w.HardLimit < w.HighWatermark {
+		return fmt.Errorf("write_buffer.hard_limit must not be less than write_buffer.high_watermark")
+	}
+	if w.HardLimit > 0 && w.HardLimitTimeout <= 0 {
+		return fmt.Errorf("invalid write_buffer.hard_limit_timeout: %s", w.HardLimitTimeout)
+	}
+	return nil
+}
+
+// RetainedSeed loads an initial set of retained messages from a YAML or JSON file at startup,
+// e.g. the configuration topics which must exist before any device publishes.
+type RetainedSeed struct {
+	// File is the path of the seed file, empty means disabled.
+	// If it is a relative path, it locates in the same directory as the config file.
+	// The file is parsed as JSON if the extension is ".json", otherwise as YAML.
+	File string `yaml:"file"`
+	// Overwrite indicates whether to replace the retained messages which already exist in the retained store.
+	Overwrite bool `yaml:"overwrite"`
+}
+
+// Redelivery controls how the outgoing QoS 1 and QoS 2 messages (or the PUBRELs) which are not acknowledged
+// are resent to the connected client.
+// The MQTT specification only allows resending on reconnection, so it is disabled by default
+// and should only be enabled for the clients that tolerate it.
+type Redelivery struct {
+	// Interval is the time to wait for the acknowledgement before the first redelivery. 0 means disabled.
+	Interval time.Duration `yaml:"interval"`
+	// Backoff is the multiplier applied to the interval after each redelivery, 1 means the fixed interval.
+	Backoff float64 `yaml:"backoff"`
+	// MaxInterval caps the interval grown by the backoff, 0 means no cap.
+	MaxInterval time.Duration `yaml:"max_interval"`
+	// MaxAttempts is the maximum number of redeliveries of a message, 0 means no limit.
+	MaxAttempts int `yaml:"max_attempts"`
+	// ExhaustedAction is the action when the MaxAttempts is reached. Possible values are "drop" and "disconnect".
+	ExhaustedAction string `yaml:"exhausted_action"`
+}
+
+// Enabled returns whether the redelivery is enabled.
+func (r Redelivery) Enabled() bool {
+	return r.Interval > 0
+}
+
+// NextInterval returns the interval to wait after the given number of redeliveries.
+func (r Redelivery) NextInterval(attempts int) time.Duration {
+	d := float64(r.Interval)
+	for i := 0; i < attempts; i++ {
+		d *= r.Backoff
+		if r.MaxInterval > 0 && d >= float64(r.MaxInterval) {
+			return r.MaxInterval
+		}
+	}
+	return time.Duration(d)
+}
+
+func (r Redelivery) validate() error {
+	if r.Interval < 0 {
+		return fmt.Errorf("invalid redelivery.interval: %s", r.Interval)
+	}
+	if r.Backoff < 1 {
+		return fmt.Errorf("invalid redelivery.backoff: %v, must be greater than or equal to 1", r.Backoff)
+	}
+	if r.MaxInterval < 0 {
+		return fmt.Errorf("invalid redelivery.max_interval: %s", r.MaxInterval)
+	}
+	if r.MaxAttempts < 0 {
+		return fmt.Errorf("invalid redelivery.max_attempts: %d", r.MaxAttempts)
+	}
+	if r.ExhaustedAction != RedeliveryExhaustedDrop && r.ExhaustedAction != RedeliveryExhaustedDisconnect {
+		return fmt.Errorf("invalid redelivery.exhausted_action: %s", r.ExhaustedAction)
+	}
+	return nil
+}
+
+// PropertyMapping controls how the V5 properties are handled when delivering the V5 messages to the V3 clients,
+// which have no way to carry them. Possible values are "drop", "envelope" and "reject".
+type PropertyMapping struct {
+	// UserProperties is the handling of the user properties.
+	UserProperties string `yaml:"user_properties"`
+	// ContentType is the handling of the content type and the payload format indicator.
+	ContentType string `yaml:"content_type"`
+	// ResponseTopic is the handling of the response topic and the correlation data.
+	ResponseTopic string `yaml:"response_topic"`
+	// UnwrapEnvelope indicates whether to convert the envelope published by the V3 clients back into the V5 properties,
+	// so that the request/response and the user properties work across the versions.
+	UnwrapEnvelope bool `yaml:"unwrap_envelope"`
+}
+
+func (p PropertyMapping) validate() error {
+	for k, v := range map[string]string{
+		"user_properties": p.UserProperties,
+		"content_type":    p.ContentType,
+		"response_topic":  p.ResponseTopic,
+	} {
+		switch v {
+		case PropertyMappingDrop, PropertyMappingEnvelope, PropertyMappingReject:
+		default:
+			return fmt.Errorf("invalid property_mapping.%s: %s", k, v)
+		}
+	}
+	return nil
+}
+
+// TopicPayloadLimit is the maximum payload size of the messages whose topic name matches the topic filter.
+type TopicPayloadLimit struct {
+	TopicFilter    string `yaml:"topic_filter"`
+	MaxPayloadSize uint32 `yaml:"max_payload_size"`
+}
+
+// MaxPayloadSize returns the maximum payload size of the given topic name, 0 means no limit.
+func (c MQTT) MaxPayloadSize(topicName string) uint32 {
+	if len(c.TopicPayloadLimits) == 0 {
+		return 0
+	}
+	topic := []byte(topicName)
+	for _, v := range c.TopicPayloadLimits {
+		if packets.TopicMatch(topic, []byte(v.TopicFilter)) {
+			return v.MaxPayloadSize
+		}
+	}
+	return 0
+}
+
+// TopicMessageExpiry is the default lifetime of the messages whose topic name matches the topic filter.
+type TopicMessageExpiry struct {
+	TopicFilter string `yaml:"topic_filter"`
+	// Expiry is the default lifetime, 0 means no default, so that the matched messages only expire by MessageExpiry.
+	Expiry time.Duration `yaml:"expiry"`
+}
+
+// DefaultMessageExpiry returns the default message expiry of the given topic name, 0 means no default.
+func (c MQTT) DefaultMessageExpiry(topicName string) time.Duration {
+	if len(c.TopicMessageExpiry) == 0 {
+		return 0
+	}
+	topic := []byte(topicName)
+	for _, v := range c.TopicMessageExpiry {
+		if packets.TopicMatch(topic, []byte(v.TopicFilter)) {
+			return v.Expiry
+		}
+	}
+	return 0
+}
+
+// ReceiveAnnotation is the user properties stamped on the messages whose topic name matches the topic filter.
+// The user properties of the same names set by the publisher are replaced.
+type ReceiveAnnotation struct {
+	TopicFilter string `yaml:"topic_filter"`
+	// ReceivedAt is the name of the user property of the broker receive time in unix milliseconds, empty means not stamped.
+	ReceivedAt string `yaml:"received_at"`
+	// ClientID is the name of the user property of the publisher client id, empty means not stamped.
+	ClientID string `yaml:"client_id"`
+	// Username is the name of the user property of the publisher username, empty means not stamped.
+	// It is not stamped for the anonymous publishers, but the user property set by them is still removed.
+	Username string `yaml:"username"`
+}
+
+// names returns the non-empty names of the user properties stamped by the rule.
+func (r ReceiveAnnotation) names() []string {
+	var names []string
+	for _, v := range []string{r.ReceivedAt, r.ClientID, r.Username} {
+		if v != "" {
+			names = append(names, v)
+		}
+	}
+	return names
+}
+
+// ReceiveAnnotation returns the first receive annotation rule that matches the topic name, nil if none matches.
+func (c MQTT) ReceiveAnnotation(topicName string) *ReceiveAnnotation {
+	if len(c.ReceiveAnnotations) == 0 {
+		return nil
+	}
+	topic := []byte(topicName)
+	for k, v := range c.ReceiveAnnotations {
+		if packets.TopicMatch(topic, []byte(v.TopicFilter)) {
+			return &c.ReceiveAnnotations[k]
+		}
+	}
+	return nil
+}
+
+// PropertyRoutingRule returns the first property routing rule that matches the topic name, nil if none matches.
+func (c MQTT) PropertyRoutingRule(topicName string) *PropertyRoutingRule {
+	if len(c.PropertyRouting) == 0 {
+		return nil
+	}
+	topic := []byte(topicName)
+	for k, v := range c.PropertyRouting {
+		if packets.TopicMatch(topic, []byte(v.TopicFilter)) {
+			return &c.PropertyRouting[k]
+		}
+	}
+	return nil
+}
+
+// Blackhole returns whether the topic name matches the blackhole topics.
+func (c MQTT) Blackhole(topicName string) bool {
+	if len(c.BlackholeTopics) == 0 {
+		return false
+	}
+	topic := []byte(topicName)
+	for _, v := range c.BlackholeTopics {
+		if packets.TopicMatch(topic, []byte(v)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Ordered returns whether the topic name matches the ordered topics.
+func (c MQTT) Ordered(topicName string) bool {
+	if len(c.OrderedTopics) == 0 {
+		return false
+	}
+	topic := []byte(topicName)
+	for _, v := range c.OrderedTopics {
+		if packets.TopicMatch(topic, []byte(v)) {
+			return true
+		}
+	}
+	return false
+}
+
+// SharedSubscriptionStrategy returns the shared subscription strategy with the aliases resolved.
+func (c MQTT) SharedSubscriptionStrategy() string {
+	if c.SharedSubStrategy == "" {
+		return SharedSubStrategyRandom
+	}
+	if v, ok := sharedSubStrategyAliases[c.SharedSubStrategy]; ok {
+		return v
+	}
+	return c.SharedSubStrategy
+}
+
+// ReportNoSubscriber returns whether to report the message as dropped if there is no matching subscriber for the topic name.
+func (c MQTT) ReportNoSubscriber(topicName string) bool {
+	topic := []byte(topicName)
+	for _, v := range c.ReportNoSubscriberTopics {
+		if packets.TopicMatch(topic, []byte(v)) {
+			return true
+		}
+	}
+	return false
 }
 
 func (c MQTT) Validate() error {
@@ -110,7 +775,122 @@ func (c MQTT) Validate() error {
 	if c.DeliveryMode != Overlap && c.DeliveryMode != OnlyOnce {
 		return fmt.Errorf("invalid delivery_mode: %s", c.DeliveryMode)
 	}
+	switch c.SharedSubscriptionStrategy() {
+	case SharedSubStrategyRandom, SharedSubStrategyRoundRobin, SharedSubStrategySticky, SharedSubStrategyLeastInflight:
+	default:
+		return fmt.Errorf("invalid shared_subscription_strategy: %s", c.SharedSubStrategy)
+	}
 
+	if c.MaxRetainedPerSubscribe < 0 {
+		return fmt.Errorf("invalid max_retained_per_subscribe: %d", c.MaxRetainedPerSubscribe)
+	}
+	if c.RetainedBatchInterval <= 0 {
+		return fmt.Errorf("retained_batch_interval must be greater than 0")
+	}
+	for _, v := range c.TopicPayloadLimits {
+		if !packets.ValidTopicFilter(true, []byte(v.TopicFilter)) {
+			return fmt.Errorf("invalid topic_payload_limits.topic_filter: %s", v.TopicFilter)
+		}
+		if v.MaxPayloadSize == 0 {
+			return fmt.Errorf("invalid topic_payload_limits.max_payload_size of %s: must be greater than 0", v.TopicFilter)
+		}
+	}
+	for _, v := range c.ReportNoSubscriberTopics {
+		if !packets.ValidTopicFilter(true, []byte(v)) {
+			return fmt.Errorf("invalid report_no_subscriber_topics: %s", v)
+		}
+	}
+	for _, v := range c.BlackholeTopics {
+		if !packets.ValidTopicFilter(true, []byte(v)) {
+			return fmt.Errorf("invalid blackhole_topics: %s", v)
+		}
+	}
+	for _, v := range c.OrderedTopics {
+		if !packets.ValidTopicFilter(true, []byte(v)) {
+			return fmt.Errorf("invalid ordered_topics: %s", v)
+		}
+	}
+	for _, v := range c.TopicMessageExpiry {
+		if !packets.ValidTopicFilter(true, []byte(v.TopicFilter)) {
+			return fmt.Errorf("invalid topic_message_expiry.topic_filter: %s", v.TopicFilter)
+		}
+		if v.Expiry < 0 {
+			return fmt.Errorf("invalid topic_message_expiry.expiry of %s: %s", v.TopicFilter, v.Expiry)
+		}
+	}
+	for _, v := range c.ReceiveAnnotations {
+		if !packets.ValidTopicFilter(true, []byte(v.TopicFilter)) {
+			return fmt.Errorf("invalid receive_annotations.topic_filter: %s", v.TopicFilter)
+		}
+		names := v.names()
+		if len(names) == 0 {
+			return fmt.Errorf("receive_annotations of %s: received_at, client_id and username must not be all empty", v.TopicFilter)
+		}
+		seen := make(map[string]struct{}, len(names))
+		for _, n := range names {
+			if _, ok := seen[n]; ok {
+				return fmt.Errorf("receive_annotations of %s: received_at, client_id and username must be different", v.TopicFilter)
+			}
+			seen[n] = struct{}{}
+		}
+	}
+	for _, v := range c.PropertyRouting {
+		if !packets.ValidTopicFilter(true, []byte(v.TopicFilter)) {
+			return fmt.Errorf("invalid property_routing.topic_filter: %s", v.TopicFilter)
+		}
+		if v.UserProperty == "" {
+			return fmt.Errorf("property_routing of %s: user_property must not be empty", v.TopicFilter)
+		}
+	}
+	if c.NoSubscriberCache.Size < 0 {
+		return fmt.Errorf("invalid no_subscriber_cache.size: %d", c.NoSubscriberCache.Size)
+	}
+	if c.OfflineBacklog.Threshold < 0 {
+		return fmt.Errorf("invalid offline_backlog.threshold: %d", c.OfflineBacklog.Threshold)
+	}
+	classes := make(map[string]struct{}, len(c.SessionExpiryClasses))
+	for _, v := range c.SessionExpiryClasses {
+		if v.Name == "" {
+			return fmt.Errorf("session_expiry_classes.name must not be empty")
+		}
+		if _, ok := classes[v.Name]; ok {
+			return fmt.Errorf("duplicated session_expiry_classes.name: %s", v.Name)
+		}
+		classes[v.Name] = struct{}{}
+		if _, err := path.Match(v.Username, ""); err != nil {
+			return fmt.Errorf("invalid session_expiry_classes.username of %s: %s", v.Name, err)
+		}
+		if v.Max < 0 || v.Max.Seconds() > math.MaxUint32 {
+			return fmt.Errorf("invalid session_expiry_classes.max of %s: %s", v.Name, v.Max)
+		}
+		if v.Default < 0 || v.Default > v.Max {
+			return fmt.Errorf("invalid session_expiry_classes.default of %s: %s", v.Name, v.Default)
+		}
+	}
+	if err := c.PropertyMapping.validate(); err != nil {
+		return err
+	}
+	if err := c.Redelivery.validate(); err != nil {
+		return err
+	}
+	if err := c.OutboundBandwidth.validate(); err != nil {
+		return err
+	}
+	if err := c.WriteBuffer.validate(); err != nil {
+		return err
+	}
+	if err := c.DeliveryReceipts.validate(); err != nil {
+		return err
+	}
+	if err := c.ReconnectAdvice.validate(); err != nil {
+		return err
+	}
+	if err := c.Takeover.validate(); err != nil {
+		return err
+	}
+	if err := c.RetainedFeed.validate(); err != nil {
+		return err
+	}
 	if c.MaxQueuedMsg < int(c.MaxInflight) {
 		return fmt.Errorf("max_queued_message cannot be less than max_inflight")
 	}
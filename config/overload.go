@@ -0,0 +1,51 @@
+package config
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	// DefaultOverloadProtection is the default value of OverloadProtection
+	DefaultOverloadProtection = OverloadProtection{
+		Enable:                false,
+		CheckInterval:         time.Second,
+		MaxHeapInuse:          0,
+		MaxQueuedMessages:     0,
+		RejectConnectionRatio: 0.8,
+		RejectQos2Ratio:       0.9,
+		DropQos0Ratio:         1,
+	}
+)
+
+// OverloadProtection is the config of the overload protection.
+// The broker compares the heap usage and the total number of queued messages against the limits,
+// and sheds load progressively when the usage ratio reaches the corresponding threshold.
+type OverloadProtection struct {
+	Enable bool `yaml:"enable"`
+	// CheckInterval is the interval to check the resource usage.
+	CheckInterval time.Duration `yaml:"check_interval"`
+	// MaxHeapInuse is the heap usage limit in bytes. 0 means no limit.
+	MaxHeapInuse uint64 `yaml:"max_heap_inuse"`
+	// MaxQueuedMessages is the limit of the total number of messages in all client queues. 0 means no limit.
+	MaxQueuedMessages uint64 `yaml:"max_queued_messages"`
+	// RejectConnectionRatio is the usage ratio to stop accepting new connections.
+	RejectConnectionRatio float64 `yaml:"reject_connection_ratio"`
+	// RejectQos2Ratio is the usage ratio to reject QoS 2 publishes.
+	RejectQos2Ratio float64 `yaml:"reject_qos2_ratio"`
+	// DropQos0Ratio is the usage ratio to drop QoS 0 messages for slow subscribers.
+	DropQos0Ratio float64 `yaml:"drop_qos0_ratio"`
+}
+
+func (o OverloadProtection) Validate() error {
+	if !o.Enable {
+		return nil
+	}
+	if o.CheckInterval <= 0 {
+		return errors.New("invalid overload_protection.check_interval: must be greater than 0")
+	}
+	if o.RejectConnectionRatio <= 0 || o.RejectQos2Ratio <= 0 || o.DropQos0Ratio <= 0 {
+		return errors.New("invalid overload_protection ratio: must be greater than 0")
+	}
+	return nil
+}
@@ -28,6 +28,11 @@ var (
 			MaxActive:   &defaultMaxActive,
 			IdleTimeout: 240 * time.Second,
 		},
+		Preload: Preload{
+			Timeout:          5 * time.Minute,
+			ProgressInterval: 5 * time.Second,
+			BatchSize:        1000,
+		},
 	}
 )
 
@@ -38,6 +43,23 @@ type Persistence struct {
 	Type PersistenceType `yaml:"type"`
 	// Redis is the redis configuration and must be set when Type ==  "redis".
 	Redis RedisPersistence `yaml:"redis"`
+	// Preload is the setting of the preloading of the persistent state at startup.
+	Preload Preload `yaml:"preload"`
+}
+
+// Preload is the setting of the preloading of the persistent state before accepting connections.
+// When it is enabled, the session metadata is kept in an in-memory cache in front of the session store,
+// so that a large number of clients reconnecting at the same time do not hit the persistence for the session lookups.
+type Preload struct {
+	// Enable enables the preloading.
+	Enable bool `yaml:"enable"`
+	// Timeout is the maximum time of the preloading, the server fails to start if it is exceeded.
+	// If zero, there is no timeout.
+	Timeout time.Duration `yaml:"timeout"`
+	// ProgressInterval is the interval of the progress logs.
+	ProgressInterval time.Duration `yaml:"progress_interval"`
+	// BatchSize is the number of clients whose subscriptions are loaded into the subscription trie at once.
+	BatchSize int `yaml:"batch_size"`
 }
 
 // RedisPersistence is the configuration of redis persistence.
@@ -77,5 +99,16 @@ func (p *Persistence) Validate() error {
 	if p.Redis.Database < 0 {
 		return errors.New("invalid redis database number")
 	}
+	if p.Preload.Enable {
+		if p.Preload.Timeout < 0 {
+			return errors.New("invalid preload timeout")
+		}
+		if p.Preload.ProgressInterval <= 0 {
+			return errors.New("invalid preload progress_interval")
+		}
+		if p.Preload.BatchSize <= 0 {
+			return errors.New("invalid preload batch_size")
+		}
+	}
 	return nil
 }
@@ -0,0 +1,56 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	// DefaultPluginSandbox is the default value of PluginSandbox
+	DefaultPluginSandbox = PluginSandbox{
+		Enable:    false,
+		Policy:    HookFailClosed,
+		MaxPanics: 5,
+		Window:    time.Minute,
+		Cooldown:  5 * time.Minute,
+	}
+)
+
+// PluginSandbox is the config of the panic isolation of the plugin hooks.
+// If enabled, the panics raised by the hooks of each plugin are recovered and counted,
+// and the plugin is disabled by the circuit breaker after repeated panics.
+type PluginSandbox struct {
+	Enable bool `yaml:"enable"`
+	// Policy is the result of the hooks which decide the result of the connections and packets (e.g. OnBasicAuth, OnMsgArrived)
+	// when the hook panics or the plugin is disabled, the possible value can be "fail_open" or "fail_closed".
+	// "fail_open" treats the panicked hook as success and skips the hooks of the disabled plugin.
+	// "fail_closed" treats the panicked hook and the hooks of the disabled plugin as failure,
+	// e.g. rejects the connection with 0x88 (Server unavailable) for OnBasicAuth.
+	// The other hooks of the disabled plugin are always skipped.
+	Policy string `yaml:"policy"`
+	// MaxPanics is the number of panics within the Window that disables the plugin, 0 means never disabled.
+	MaxPanics int           `yaml:"max_panics"`
+	Window    time.Duration `yaml:"window"`
+	// Cooldown is the time after which the disabled plugin is enabled again, 0 means disabled until restart.
+	Cooldown time.Duration `yaml:"cooldown"`
+}
+
+func (p PluginSandbox) Validate() error {
+	if !p.Enable {
+		return nil
+	}
+	if p.Policy != HookFailOpen && p.Policy != HookFailClosed {
+		return fmt.Errorf("invalid plugin_sandbox.policy: %s", p.Policy)
+	}
+	if p.MaxPanics < 0 {
+		return errors.New("invalid plugin_sandbox.max_panics: must be greater than or equal to 0")
+	}
+	if p.MaxPanics > 0 && p.Window <= 0 {
+		return errors.New("invalid plugin_sandbox.window: must be greater than 0")
+	}
+	if p.Cooldown < 0 {
+		return errors.New("invalid plugin_sandbox.cooldown: must be greater than or equal to 0")
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package config
+
+import (
+	"errors"
+)
+
+var (
+	// DefaultRuntimeConfig is the default value of Runtime, which leaves the Go runtime settings unchanged.
+	DefaultRuntimeConfig = Runtime{
+		GOGC:           0,
+		MemoryLimit:    0,
+		MaxProcs:       0,
+		ContainerAware: false,
+	}
+)
+
+// Runtime is the config of the Go runtime, it is applied when the server starts.
+// The settings in this section take precedence over the GOGC, GOMEMLIMIT and GOMAXPROCS environment variables.
+type Runtime struct {
+	// GOGC sets the garbage collection target percentage. A negative value disables the garbage collection.
+	// 0 means leaving it unchanged.
+	GOGC int `yaml:"gogc"`
+	// MemoryLimit sets the soft memory limit of the runtime in bytes. 0 means leaving it unchanged.
+	// It requires the server to be built with go1.19 or later.
+	MemoryLimit int64 `yaml:"memory_limit"`
+	// MaxProcs sets GOMAXPROCS. 0 means leaving it unchanged.
+	MaxProcs int `yaml:"max_procs"`
+	// ContainerAware indicates whether to set GOMAXPROCS according to the cgroup cpu quota.
+	// It only takes effect when MaxProcs is 0.
+	ContainerAware bool `yaml:"container_aware"`
+}
+
+func (r Runtime) Validate() error {
+	if r.MemoryLimit < 0 {
+		return errors.New("invalid runtime.memory_limit: must not be negative")
+	}
+	if r.MaxProcs < 0 {
+		return errors.New("invalid runtime.max_procs: must not be negative")
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package config
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	// DefaultStatsPersistence is the default value of StatsPersistence
+	DefaultStatsPersistence = StatsPersistence{
+		Enable:   false,
+		File:     "./gmqtt_stats.json",
+		Interval: time.Minute,
+	}
+)
+
+// StatsPersistence is the config of persisting the cumulative statistics across restarts.
+// When it is enabled, the lifetime counters (total messages, bytes, connections...) are saved to File every Interval and on shutdown,
+// and are restored at startup, so that they do not reset to zero on every restart.
+type StatsPersistence struct {
+	Enable bool `yaml:"enable"`
+	// File is the path of the file to save the lifetime counters.
+	// If it is a relative path, it locates in the same directory as the config file.
+	File string `yaml:"file"`
+	// Interval is the interval to save the lifetime counters.
+	// The counters since the last save are lost if the broker crashes.
+	Interval time.Duration `yaml:"interval"`
+}
+
+func (s StatsPersistence) Validate() error {
+	if !s.Enable {
+		return nil
+	}
+	if s.File == "" {
+		return errors.New("invalid stats_persistence.file: cannot be empty")
+	}
+	if s.Interval <= 0 {
+		return errors.New("invalid stats_persistence.interval: must be greater than 0")
+	}
+	return nil
+}
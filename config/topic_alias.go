@@ -4,6 +4,8 @@ type TopicAliasType = string
 
 const (
 	TopicAliasMgrTypeFIFO TopicAliasType = "fifo"
+	TopicAliasMgrTypeLRU  TopicAliasType = "lru"
+	TopicAliasMgrTypeLFU  TopicAliasType = "lfu"
 )
 
 var (
@@ -0,0 +1,126 @@
+package conformance
+
+import (
+	"fmt"
+
+	"github.com/DrmagicE/gmqtt/pkg/codes"
+	"github.com/DrmagicE/gmqtt/pkg/packets"
+)
+
+// malformedCases are the cases of the malformed packet handling in both versions.
+// The broker must close the connection on receiving a malformed packet, the V5 broker may send DISCONNECT with
+// Malformed Packet (0x81) or Protocol Error (0x82) before closing.
+var malformedCases = concat(
+	malformed(packets.Version311),
+	malformed(packets.Version5),
+)
+
+func malformed(version packets.Version) []Case {
+	suffix := "V3"
+	if packets.IsVersion5(version) {
+		suffix = "V5"
+	}
+	cases := []Case{
+		{Name: "MalformedRemainingLength", Ref: "MQTT-2.2.3", check: malformedRemainingLength},
+		{Name: "MalformedTruncatedTopic", Ref: "MQTT-1.5.3", check: malformedPublish(func(e *env) []byte {
+			// the topic length exceeds the remaining length.
+			return []byte{0x00, 0xff, 'a'}
+		})},
+		{Name: "MalformedTopicUTF8", Ref: "MQTT-1.5.3-1", check: malformedPublish(func(e *env) []byte {
+			return append(str(e.topic("\xc3\x28")), emptyProperties(e.version)...)
+		})},
+		{Name: "MalformedTopicNullCharacter", Ref: "MQTT-1.5.3-2", check: malformedPublish(func(e *env) []byte {
+			return append(str(e.topic("a\x00b")), emptyProperties(e.version)...)
+		})},
+		{Name: "MalformedPublishQos3", Ref: "MQTT-3.3.1-4", check: malformedPacket(func(e *env) []byte {
+			body := append(str(e.topic("a")), 0x00, 0x01)
+			return rawPacket(0x36, append(body, emptyProperties(e.version)...))
+		})},
+		{Name: "MalformedPublishPacketID0", Ref: "MQTT-2.3.1-1", check: malformedPacket(func(e *env) []byte {
+			body := append(str(e.topic("a")), 0x00, 0x00)
+			return rawPacket(0x32, append(body, emptyProperties(e.version)...))
+		})},
+		{Name: "MalformedSubscribeNoTopics", Ref: "MQTT-3.8.3-3", check: malformedPacket(func(e *env) []byte {
+			return rawPacket(0x82, append([]byte{0x00, 0x01}, emptyProperties(e.version)...))
+		})},
+		{Name: "MalformedUnsubscribeNoTopics", Ref: "MQTT-3.10.3-2", check: malformedPacket(func(e *env) []byte {
+			return rawPacket(0xa2, append([]byte{0x00, 0x01}, emptyProperties(e.version)...))
+		})},
+		{Name: "MalformedPubrelFlags", Ref: "MQTT-3.6.1-1", check: malformedPacket(func(e *env) []byte {
+			return rawPacket(0x60, []byte{0x00, 0x01})
+		})},
+		{Name: "MalformedReservedPacketType", Ref: "MQTT-2.2.1", check: malformedPacket(func(e *env) []byte {
+			return rawPacket(0x00, nil)
+		})},
+	}
+	for i := range cases {
+		cases[i].Name += suffix
+		cases[i].Version = version
+	}
+	return cases
+}
+
+// rawPacket returns the bytes of the packet with the given first byte of the fixed header and the rest of the packet.
+func rawPacket(first byte, body []byte) []byte {
+	l, _ := packets.DecodeRemainLength(len(body))
+	return append(append([]byte{first}, l...), body...)
+}
+
+// str returns the UTF-8 encoded string with the two byte length prefix, the string is not validated.
+func str(s string) []byte {
+	return append([]byte{byte(len(s) >> 8), byte(len(s))}, s...)
+}
+
+// emptyProperties returns the zero length properties for V5 packets, nil for V3.
+func emptyProperties(version packets.Version) []byte {
+	if packets.IsVersion5(version) {
+		return []byte{0x00}
+	}
+	return nil
+}
+
+// expectMalformedClosed returns nil if the broker closes the connection,
+// the DISCONNECT packet sent by the V5 broker before closing must carry an error code.
+func expectMalformedClosed(c *client) error {
+	d, err := c.expectClosed()
+	if err != nil {
+		return err
+	}
+	if d != nil && d.Code != codes.MalformedPacket && d.Code != codes.ProtocolError {
+		return fmt.Errorf("expected DISCONNECT %#x or %#x, got %#x", codes.MalformedPacket, codes.ProtocolError, d.Code)
+	}
+	return nil
+}
+
+// malformedPacket returns the check which sends the malformed packet after connected.
+func malformedPacket(packet func(e *env) []byte) func(e *env) error {
+	return func(e *env) error {
+		c, _, err := e.mustConnect(e.clientID("c"))
+		if err != nil {
+			return err
+		}
+		if err = c.writeRaw(packet(e)); err != nil {
+			return err
+		}
+		return expectMalformedClosed(c)
+	}
+}
+
+// malformedPublish returns the check which sends the QoS 0 PUBLISH packet with the malformed variable header.
+func malformedPublish(header func(e *env) []byte) func(e *env) error {
+	return malformedPacket(func(e *env) []byte {
+		return rawPacket(0x30, append(header(e), "payload"...))
+	})
+}
+
+func malformedRemainingLength(e *env) error {
+	c, _, err := e.mustConnect(e.clientID("c"))
+	if err != nil {
+		return err
+	}
+	// the remaining length is encoded in more than 4 bytes.
+	if err = c.writeRaw([]byte{0x30, 0xff, 0xff, 0xff, 0xff, 0x01}); err != nil {
+		return err
+	}
+	return expectMalformedClosed(c)
+}
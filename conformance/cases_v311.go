@@ -0,0 +1,611 @@
+package conformance
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/DrmagicE/gmqtt/pkg/codes"
+	"github.com/DrmagicE/gmqtt/pkg/packets"
+)
+
+// v311Cases are the cases of the MQTT 3.1.1 specification, see http://docs.oasis-open.org/mqtt/mqtt/v3.1.1/os/mqtt-v3.1.1-os.html
+var v311Cases = []Case{
+	{Name: "ConnectFirstPacket", Ref: "MQTT-3.1.0-1", Version: packets.Version311, check: connectFirstPacket},
+	{Name: "ConnectTwice", Ref: "MQTT-3.1.0-2", Version: packets.Version311, check: connectTwice},
+	{Name: "ConnectProtocolName", Ref: "MQTT-3.1.2-1", Version: packets.Version311, check: connectProtocolName},
+	{Name: "ConnectProtocolLevel", Ref: "MQTT-3.1.2-2", Version: packets.Version311, check: connectProtocolLevel},
+	{Name: "ConnectReservedFlag", Ref: "MQTT-3.1.2-3", Version: packets.Version311, check: connectReservedFlag},
+	{Name: "ConnectEmptyClientID", Ref: "MQTT-3.1.3-6", Version: packets.Version311, Level: Optional, check: connectEmptyClientID},
+	{Name: "ConnectEmptyClientIDPersistent", Ref: "MQTT-3.1.3-8", Version: packets.Version311, check: connectEmptyClientIDPersistent},
+	{Name: "SessionPresent", Ref: "MQTT-3.2.2-1, MQTT-3.2.2-2", Version: packets.Version311, check: sessionPresent},
+	{Name: "SessionTakeover", Ref: "MQTT-3.1.4-2", Version: packets.Version311, check: sessionTakeover},
+	{Name: "PersistentSession", Ref: "MQTT-3.1.2-4, MQTT-3.1.2-5", Version: packets.Version311, check: persistentSession},
+	{Name: "KeepAliveTimeout", Ref: "MQTT-3.1.2-24", Version: packets.Version311, check: keepAliveTimeout},
+	{Name: "WillPublished", Ref: "MQTT-3.1.2-8", Version: packets.Version311, check: willPublished},
+	{Name: "WillDiscardedOnDisconnect", Ref: "MQTT-3.1.2-10", Version: packets.Version311, check: willDiscardedOnDisconnect},
+	{Name: "PublishQos0", Ref: "MQTT-4.3.1", Version: packets.Version311, check: publishQos(packets.Qos0)},
+	{Name: "PublishQos1", Ref: "MQTT-4.3.2-2, MQTT-3.3.4-1", Version: packets.Version311, check: publishQos(packets.Qos1)},
+	{Name: "PublishQos2", Ref: "MQTT-4.3.3-2, MQTT-3.3.4-1", Version: packets.Version311, check: publishQos(packets.Qos2)},
+	{Name: "PublishWildcardTopic", Ref: "MQTT-3.3.2-2", Version: packets.Version311, check: publishWildcardTopic},
+	{Name: "QosDowngrade", Ref: "MQTT-3.8.4-6", Version: packets.Version311, check: qosDowngrade},
+	{Name: "MessageOrdering", Ref: "MQTT-4.6.0-5", Version: packets.Version311, check: messageOrdering},
+	{Name: "RetainedDelivered", Ref: "MQTT-3.3.1-6", Version: packets.Version311, check: retainedDelivered},
+	{Name: "RetainedCleared", Ref: "MQTT-3.3.1-10, MQTT-3.3.1-11", Version: packets.Version311, check: retainedCleared},
+	{Name: "RetainFlagOnLiveMessage", Ref: "MQTT-3.3.1-9", Version: packets.Version311, check: retainFlagOnLiveMessage},
+	{Name: "SubscribeReservedFlags", Ref: "MQTT-3.8.1-1", Version: packets.Version311, check: subscribeReservedFlags},
+	{Name: "Suback", Ref: "MQTT-3.8.4-2, MQTT-3.8.4-5", Version: packets.Version311, check: suback},
+	{Name: "SubscribeInvalidFilter", Ref: "MQTT-4.7.1-2, MQTT-3.8.4-5", Version: packets.Version311, check: subscribeInvalidFilter},
+	{Name: "WildcardNotMatchDollarTopics", Ref: "MQTT-4.7.2-1", Version: packets.Version311, check: wildcardNotMatchDollarTopics},
+	{Name: "Unsubscribe", Ref: "MQTT-3.10.4-1, MQTT-3.10.4-4", Version: packets.Version311, check: unsubscribe},
+	{Name: "Pingreq", Ref: "MQTT-3.12.4-1", Version: packets.Version311, check: pingreq},
+}
+
+// v3SubscribeFailure is the return code of the failed subscription in the MQTT 3.1.1 SUBACK.
+const v3SubscribeFailure codes.Code = 0x80
+
+// expectClosed returns nil if the broker closes the connection, with or without a DISCONNECT packet.
+func expectClosed(c *client) error {
+	_, err := c.expectClosed()
+	return err
+}
+
+func connectFirstPacket(e *env) error {
+	c, err := e.dial()
+	if err != nil {
+		return err
+	}
+	if err = c.write(&packets.Pingreq{}); err != nil {
+		return err
+	}
+	return expectClosed(c)
+}
+
+func connectTwice(e *env) error {
+	c, _, err := e.mustConnect(e.clientID("c"))
+	if err != nil {
+		return err
+	}
+	if err = c.write(e.newConnect(e.clientID("c"))); err != nil {
+		return err
+	}
+	return expectClosed(c)
+}
+
+func connectProtocolName(e *env) error {
+	c, err := e.dial()
+	if err != nil {
+		return err
+	}
+	body := append(str("MQTX"), e.version, 0x02, 0x00, 0x3c)
+	if err = c.writeRaw(rawPacket(0x10, append(body, str(e.clientID("c"))...))); err != nil {
+		return err
+	}
+	return expectClosed(c)
+}
+
+func connectProtocolLevel(e *env) error {
+	connect := e.newConnect(e.clientID("c"))
+	connect.ProtocolLevel = 0x06
+	c, ack, err := e.connect(connect)
+	if err != nil {
+		return err
+	}
+	if ack.Code != codes.V3UnacceptableProtocolVersion {
+		return fmt.Errorf("expected CONNACK %#x, got %#x", codes.V3UnacceptableProtocolVersion, ack.Code)
+	}
+	return expectClosed(c)
+}
+
+func connectReservedFlag(e *env) error {
+	c, err := e.dial()
+	if err != nil {
+		return err
+	}
+	// the reserved bit 0 of the connect flags is set.
+	body := append(str("MQTT"), e.version, 0x03, 0x00, 0x3c)
+	if err = c.writeRaw(rawPacket(0x10, append(body, str(e.clientID("c"))...))); err != nil {
+		return err
+	}
+	return expectClosed(c)
+}
+
+func connectEmptyClientID(e *env) error {
+	_, _, err := e.mustConnect("")
+	return err
+}
+
+func connectEmptyClientIDPersistent(e *env) error {
+	connect := e.newConnect("")
+	connect.CleanStart = false
+	c, ack, err := e.connect(connect)
+	if err != nil {
+		return err
+	}
+	if ack.Code != codes.V3IdentifierRejected {
+		return fmt.Errorf("expected CONNACK %#x, got %#x", codes.V3IdentifierRejected, ack.Code)
+	}
+	return expectClosed(c)
+}
+
+// persistent sets clean start to false and keeps the session after disconnected.
+func persistent(connect *packets.Connect) {
+	connect.CleanStart = false
+	if packets.IsVersion5(connect.Version) {
+		expiry := uint32(60)
+		connect.Properties = &packets.Properties{SessionExpiryInterval: &expiry}
+	}
+}
+
+// disconnect sends the DISCONNECT packet and closes the connection [MQTT-3.14.4-1].
+func disconnect(c *client, version packets.Version) error {
+	if err := c.write(&packets.Disconnect{Version: version}); err != nil {
+		return err
+	}
+	return c.conn.Close()
+}
+
+// clearRetained removes the retained message of the topic, so that it is not delivered to the other cases.
+func clearRetained(c *client, version packets.Version, topic string) {
+	_ = c.publish(&packets.Publish{Version: version, Qos: packets.Qos1, PacketID: 100, Retain: true, TopicName: []byte(topic)})
+}
+
+func sessionPresent(e *env) error {
+	c, ack, err := e.mustConnect(e.clientID("c"))
+	if err != nil {
+		return err
+	}
+	if ack.SessionPresent {
+		return errors.New("session present is set for the clean session")
+	}
+	if err = disconnect(c, e.version); err != nil {
+		return err
+	}
+	c, ack, err = e.mustConnect(e.clientID("c"), persistent)
+	if err != nil {
+		return err
+	}
+	if ack.SessionPresent {
+		return errors.New("session present is set for the new session")
+	}
+	if err = disconnect(c, e.version); err != nil {
+		return err
+	}
+	_, ack, err = e.mustConnect(e.clientID("c"), persistent)
+	if err != nil {
+		return err
+	}
+	if !ack.SessionPresent {
+		return errors.New("session present is not set for the resumed session")
+	}
+	return nil
+}
+
+func sessionTakeover(e *env) error {
+	c1, _, err := e.mustConnect(e.clientID("c"))
+	if err != nil {
+		return err
+	}
+	if _, _, err = e.mustConnect(e.clientID("c")); err != nil {
+		return err
+	}
+	return expectClosed(c1)
+}
+
+func persistentSession(e *env) error {
+	sub, _, err := e.mustConnect(e.clientID("sub"), persistent)
+	if err != nil {
+		return err
+	}
+	topic := e.topic("a")
+	if _, err = sub.subscribe(e.version, 1, packets.Topic{Name: topic, SubOptions: packets.SubOptions{Qos: packets.Qos1}}); err != nil {
+		return err
+	}
+	if err = disconnect(sub, e.version); err != nil {
+		return err
+	}
+	pub, _, err := e.mustConnect(e.clientID("pub"))
+	if err != nil {
+		return err
+	}
+	if err = pub.publish(&packets.Publish{Version: e.version, Qos: packets.Qos1, PacketID: 1, TopicName: []byte(topic), Payload: []byte("queued")}); err != nil {
+		return err
+	}
+	sub, _, err = e.mustConnect(e.clientID("sub"), persistent)
+	if err != nil {
+		return err
+	}
+	_, err = sub.expectPublish(topic, "queued")
+	return err
+}
+
+func keepAliveTimeout(e *env) error {
+	c, _, err := e.mustConnect(e.clientID("c"), func(connect *packets.Connect) {
+		connect.KeepAlive = 1
+	})
+	if err != nil {
+		return err
+	}
+	return expectClosed(c)
+}
+
+func withWill(topic string) func(connect *packets.Connect) {
+	return func(connect *packets.Connect) {
+		connect.WillFlag = true
+		connect.WillTopic = []byte(topic)
+		connect.WillMsg = []byte("will")
+	}
+}
+
+func willPublished(e *env) error {
+	sub, _, err := e.mustConnect(e.clientID("sub"))
+	if err != nil {
+		return err
+	}
+	topic := e.topic("will")
+	if _, err = sub.subscribe(e.version, 1, packets.Topic{Name: topic}); err != nil {
+		return err
+	}
+	c, _, err := e.mustConnect(e.clientID("c"), withWill(topic))
+	if err != nil {
+		return err
+	}
+	// close the network connection without DISCONNECT.
+	_ = c.conn.Close()
+	_, err = sub.expectPublish(topic, "will")
+	return err
+}
+
+func willDiscardedOnDisconnect(e *env) error {
+	sub, _, err := e.mustConnect(e.clientID("sub"))
+	if err != nil {
+		return err
+	}
+	topic := e.topic("will")
+	if _, err = sub.subscribe(e.version, 1, packets.Topic{Name: topic}); err != nil {
+		return err
+	}
+	c, _, err := e.mustConnect(e.clientID("c"), withWill(topic))
+	if err != nil {
+		return err
+	}
+	if err = disconnect(c, e.version); err != nil {
+		return err
+	}
+	return sub.expectNothing()
+}
+
+func publishQos(qos uint8) func(e *env) error {
+	return func(e *env) error {
+		sub, _, err := e.mustConnect(e.clientID("sub"))
+		if err != nil {
+			return err
+		}
+		topic := e.topic("a")
+		if _, err = sub.subscribe(e.version, 1, packets.Topic{Name: topic, SubOptions: packets.SubOptions{Qos: qos}}); err != nil {
+			return err
+		}
+		pub, _, err := e.mustConnect(e.clientID("pub"))
+		if err != nil {
+			return err
+		}
+		p := &packets.Publish{Version: e.version, Qos: qos, TopicName: []byte(topic), Payload: []byte("msg")}
+		if qos > packets.Qos0 {
+			p.PacketID = 10
+		}
+		if err = pub.publish(p); err != nil {
+			return err
+		}
+		recv, err := sub.expectPublish(topic, "msg")
+		if err != nil {
+			return err
+		}
+		if recv.Qos != qos {
+			return fmt.Errorf("expected QoS %d, got %d", qos, recv.Qos)
+		}
+		switch qos {
+		case packets.Qos1:
+			return sub.write(recv.NewPuback(codes.Success, nil))
+		case packets.Qos2:
+			if err = sub.write(recv.NewPubrec(codes.Success, nil)); err != nil {
+				return err
+			}
+			var rel *packets.Pubrel
+			if err = sub.expect(&rel); err != nil {
+				return err
+			}
+			if rel.PacketID != recv.PacketID {
+				return fmt.Errorf("expected PUBREL with packet id %d, got %d", recv.PacketID, rel.PacketID)
+			}
+			return sub.write(rel.NewPubcomp())
+		}
+		return nil
+	}
+}
+
+func publishWildcardTopic(e *env) error {
+	c, _, err := e.mustConnect(e.clientID("c"))
+	if err != nil {
+		return err
+	}
+	if err = c.write(&packets.Publish{Version: e.version, TopicName: []byte(e.topic("+")), Payload: []byte("msg")}); err != nil {
+		return err
+	}
+	return expectClosed(c)
+}
+
+func qosDowngrade(e *env) error {
+	sub, _, err := e.mustConnect(e.clientID("sub"))
+	if err != nil {
+		return err
+	}
+	topic := e.topic("a")
+	if _, err = sub.subscribe(e.version, 1, packets.Topic{Name: topic, SubOptions: packets.SubOptions{Qos: packets.Qos0}}); err != nil {
+		return err
+	}
+	pub, _, err := e.mustConnect(e.clientID("pub"))
+	if err != nil {
+		return err
+	}
+	if err = pub.publish(&packets.Publish{Version: e.version, Qos: packets.Qos2, PacketID: 1, TopicName: []byte(topic), Payload: []byte("msg")}); err != nil {
+		return err
+	}
+	recv, err := sub.expectPublish(topic, "msg")
+	if err != nil {
+		return err
+	}
+	if recv.Qos != packets.Qos0 {
+		return fmt.Errorf("expected QoS 0, got %d", recv.Qos)
+	}
+	return nil
+}
+
+func messageOrdering(e *env) error {
+	sub, _, err := e.mustConnect(e.clientID("sub"))
+	if err != nil {
+		return err
+	}
+	topic := e.topic("a")
+	if _, err = sub.subscribe(e.version, 1, packets.Topic{Name: topic, SubOptions: packets.SubOptions{Qos: packets.Qos1}}); err != nil {
+		return err
+	}
+	pub, _, err := e.mustConnect(e.clientID("pub"))
+	if err != nil {
+		return err
+	}
+	for i := 1; i <= 10; i++ {
+		err = pub.publish(&packets.Publish{Version: e.version, Qos: packets.Qos1, PacketID: packets.PacketID(i), TopicName: []byte(topic), Payload: []byte(fmt.Sprint(i))})
+		if err != nil {
+			return err
+		}
+	}
+	for i := 1; i <= 10; i++ {
+		recv, err := sub.expectPublish(topic, fmt.Sprint(i))
+		if err != nil {
+			return err
+		}
+		if err = sub.write(recv.NewPuback(codes.Success, nil)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func retainedDelivered(e *env) error {
+	pub, _, err := e.mustConnect(e.clientID("pub"))
+	if err != nil {
+		return err
+	}
+	topic := e.topic("retained")
+	if err = pub.publish(&packets.Publish{Version: e.version, Qos: packets.Qos1, PacketID: 1, Retain: true, TopicName: []byte(topic), Payload: []byte("retained")}); err != nil {
+		return err
+	}
+	defer clearRetained(pub, e.version, topic)
+	sub, _, err := e.mustConnect(e.clientID("sub"))
+	if err != nil {
+		return err
+	}
+	if _, err = sub.subscribe(e.version, 1, packets.Topic{Name: e.topic("#")}); err != nil {
+		return err
+	}
+	recv, err := sub.expectPublish(topic, "retained")
+	if err != nil {
+		return err
+	}
+	if !recv.Retain {
+		return errors.New("the retain flag of the retained message is not set")
+	}
+	return nil
+}
+
+func retainedCleared(e *env) error {
+	pub, _, err := e.mustConnect(e.clientID("pub"))
+	if err != nil {
+		return err
+	}
+	topic := e.topic("retained")
+	if err = pub.publish(&packets.Publish{Version: e.version, Qos: packets.Qos1, PacketID: 1, Retain: true, TopicName: []byte(topic), Payload: []byte("retained")}); err != nil {
+		return err
+	}
+	if err = pub.publish(&packets.Publish{Version: e.version, Qos: packets.Qos1, PacketID: 2, Retain: true, TopicName: []byte(topic)}); err != nil {
+		return err
+	}
+	sub, _, err := e.mustConnect(e.clientID("sub"))
+	if err != nil {
+		return err
+	}
+	if _, err = sub.subscribe(e.version, 1, packets.Topic{Name: topic}); err != nil {
+		return err
+	}
+	return sub.expectNothing()
+}
+
+func retainFlagOnLiveMessage(e *env) error {
+	sub, _, err := e.mustConnect(e.clientID("sub"))
+	if err != nil {
+		return err
+	}
+	topic := e.topic("retained")
+	if _, err = sub.subscribe(e.version, 1, packets.Topic{Name: topic}); err != nil {
+		return err
+	}
+	pub, _, err := e.mustConnect(e.clientID("pub"))
+	if err != nil {
+		return err
+	}
+	if err = pub.publish(&packets.Publish{Version: e.version, Qos: packets.Qos1, PacketID: 1, Retain: true, TopicName: []byte(topic), Payload: []byte("live")}); err != nil {
+		return err
+	}
+	defer clearRetained(pub, e.version, topic)
+	recv, err := sub.expectPublish(topic, "live")
+	if err != nil {
+		return err
+	}
+	if recv.Retain {
+		return errors.New("the retain flag of the live message is set")
+	}
+	return nil
+}
+
+func subscribeReservedFlags(e *env) error {
+	c, _, err := e.mustConnect(e.clientID("c"))
+	if err != nil {
+		return err
+	}
+	body := append([]byte{0x00, 0x01}, str(e.topic("a"))...)
+	if packets.IsVersion5(e.version) {
+		// zero length properties
+		body = append([]byte{0x00, 0x01, 0x00}, str(e.topic("a"))...)
+	}
+	// the flags of SUBSCRIBE must be 0010.
+	if err = c.writeRaw(rawPacket(0x80, append(body, 0x00))); err != nil {
+		return err
+	}
+	return expectClosed(c)
+}
+
+func suback(e *env) error {
+	c, _, err := e.mustConnect(e.clientID("c"))
+	if err != nil {
+		return err
+	}
+	ack, err := c.subscribe(e.version, 7,
+		packets.Topic{Name: e.topic("0"), SubOptions: packets.SubOptions{Qos: packets.Qos0}},
+		packets.Topic{Name: e.topic("1"), SubOptions: packets.SubOptions{Qos: packets.Qos1}},
+		packets.Topic{Name: e.topic("2"), SubOptions: packets.SubOptions{Qos: packets.Qos2}},
+	)
+	if err != nil {
+		return err
+	}
+	if len(ack.Payload) != 3 {
+		return fmt.Errorf("expected 3 return codes, got %v", ack.Payload)
+	}
+	for i, v := range ack.Payload {
+		if v > codes.Code(i) {
+			return fmt.Errorf("granted QoS %d is higher than the requested QoS %d", v, i)
+		}
+	}
+	return nil
+}
+
+func subscribeInvalidFilter(e *env) error {
+	c, _, err := e.mustConnect(e.clientID("c"))
+	if err != nil {
+		return err
+	}
+	if err = c.write(&packets.Subscribe{
+		Version:  e.version,
+		PacketID: 1,
+		Topics: []packets.Topic{
+			{Name: e.topic("a")},
+			{Name: e.topic("#/a")},
+		},
+	}); err != nil {
+		return err
+	}
+	var ack *packets.Suback
+	if err = c.expect(&ack); err != nil {
+		// the server is allowed to close the connection.
+		return expectClosed(c)
+	}
+	failure := v3SubscribeFailure
+	if packets.IsVersion5(e.version) {
+		failure = codes.TopicFilterInvalid
+	}
+	if len(ack.Payload) != 2 || ack.Payload[0] != codes.GrantedQoS0 || ack.Payload[1] != failure {
+		return fmt.Errorf("expected return codes [0 %#x], got %v", failure, ack.Payload)
+	}
+	return nil
+}
+
+func wildcardNotMatchDollarTopics(e *env) error {
+	sub, _, err := e.mustConnect(e.clientID("sub"))
+	if err != nil {
+		return err
+	}
+	if _, err = sub.subscribe(e.version, 1, packets.Topic{Name: "#"}, packets.Topic{Name: "+/" + e.name}); err != nil {
+		return err
+	}
+	pub, _, err := e.mustConnect(e.clientID("pub"))
+	if err != nil {
+		return err
+	}
+	if err = pub.publish(&packets.Publish{Version: e.version, Qos: packets.Qos1, PacketID: 1, TopicName: []byte("$conformance/" + e.name), Payload: []byte("msg")}); err != nil {
+		return err
+	}
+	// the retained messages of other topics may be received, only the topics starting with '$' are checked.
+	for {
+		pkt, err := sub.read(quietPeriod)
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if p, ok := pkt.(*packets.Publish); ok && strings.HasPrefix(string(p.TopicName), "$") {
+			return fmt.Errorf("unexpected packet: %s", p)
+		}
+	}
+}
+
+func unsubscribe(e *env) error {
+	sub, _, err := e.mustConnect(e.clientID("sub"))
+	if err != nil {
+		return err
+	}
+	topic := e.topic("a")
+	if _, err = sub.subscribe(e.version, 1, packets.Topic{Name: topic}); err != nil {
+		return err
+	}
+	if err = sub.write(&packets.Unsubscribe{Version: e.version, PacketID: 2, Topics: []string{topic}}); err != nil {
+		return err
+	}
+	var ack *packets.Unsuback
+	if err = sub.expect(&ack); err != nil {
+		return err
+	}
+	if ack.PacketID != 2 {
+		return fmt.Errorf("expected UNSUBACK with packet id 2, got %d", ack.PacketID)
+	}
+	pub, _, err := e.mustConnect(e.clientID("pub"))
+	if err != nil {
+		return err
+	}
+	if err = pub.publish(&packets.Publish{Version: e.version, TopicName: []byte(topic), Payload: []byte("msg")}); err != nil {
+		return err
+	}
+	return sub.expectNothing()
+}
+
+func pingreq(e *env) error {
+	c, _, err := e.mustConnect(e.clientID("c"))
+	if err != nil {
+		return err
+	}
+	if err = c.write(&packets.Pingreq{}); err != nil {
+		return err
+	}
+	var resp *packets.Pingresp
+	return c.expect(&resp)
+}
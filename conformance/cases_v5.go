@@ -0,0 +1,547 @@
+package conformance
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+
+	"github.com/DrmagicE/gmqtt/pkg/codes"
+	"github.com/DrmagicE/gmqtt/pkg/packets"
+)
+
+// v5Cases are the cases of the MQTT 5.0 specification, see https://docs.oasis-open.org/mqtt/mqtt/v5.0/os/mqtt-v5.0-os.html
+// The cases of 3.1.1 which are also applicable to 5.0 are run with V5 clients as well.
+var v5Cases = concat(v5Common(), []Case{
+	{Name: "AssignedClientID", Ref: "MQTT-3.2.2-16", Version: packets.Version5, check: assignedClientID},
+	{Name: "SessionExpiryZero", Ref: "MQTT-3.1.2-11", Version: packets.Version5, check: sessionExpiryZero},
+	{Name: "SessionTakenOverReason", Ref: "MQTT-3.1.4-3", Version: packets.Version5, Level: Optional,
+		Known: "the connection being taken over is closed without waiting for the DISCONNECT to be sent", check: sessionTakenOverReason},
+	{Name: "DisconnectWithWillMessage", Ref: "MQTT-3.14.2.1", Version: packets.Version5, check: disconnectWithWillMessage},
+	{Name: "NoMatchingSubscribers", Ref: "MQTT-3.4.2.1", Version: packets.Version5, Level: Optional, check: noMatchingSubscribers},
+	{Name: "NoSubscriptionExisted", Ref: "MQTT-3.11.3", Version: packets.Version5,
+		Known: "UNSUBACK always returns Success", check: noSubscriptionExisted},
+	{Name: "PubrelPacketIDNotFound", Ref: "MQTT-3.7.2.1", Version: packets.Version5,
+		Known: "unack.Store does not report whether the packet identifier exists", check: pubrelPacketIDNotFound},
+	{Name: "TopicAlias", Ref: "MQTT-3.3.2-12", Version: packets.Version5, check: topicAlias},
+	{Name: "TopicAliasInvalid", Ref: "MQTT-3.3.2-9", Version: packets.Version5, check: topicAliasInvalid},
+	{Name: "ReceiveMaximumExceeded", Ref: "MQTT-3.3.4-9", Version: packets.Version5, check: receiveMaximumExceeded},
+	{Name: "NoLocal", Ref: "MQTT-3.8.3-3", Version: packets.Version5, check: noLocal},
+	{Name: "SharedSubscriptionNoLocal", Ref: "MQTT-3.8.3-4", Version: packets.Version5, check: sharedSubscriptionNoLocal},
+	{Name: "SharedSubscription", Ref: "MQTT-4.8.2", Version: packets.Version5, check: sharedSubscription},
+	{Name: "RetainAsPublished", Ref: "MQTT-3.3.1-12, MQTT-3.3.1-13", Version: packets.Version5, check: retainAsPublished},
+	{Name: "RetainHandling", Ref: "MQTT-3.3.1-9, MQTT-3.3.1-10, MQTT-3.3.1-11", Version: packets.Version5, check: retainHandling},
+	{Name: "SubscriptionIdentifier", Ref: "MQTT-3.3.4-3", Version: packets.Version5, check: subscriptionIdentifier},
+	{Name: "PropertiesForwarded", Ref: "MQTT-3.3.2-15, MQTT-3.3.2-16, MQTT-3.3.2-17, MQTT-3.3.2-18", Version: packets.Version5, check: propertiesForwarded},
+})
+
+// v5Common returns the cases of 3.1.1 which are also applicable to 5.0.
+func v5Common() []Case {
+	var rs []Case
+	for _, v := range v311Cases {
+		switch v.Name {
+		case "ConnectProtocolLevel", "ConnectEmptyClientIDPersistent":
+			// the CONNACK for the unsupported protocol version is optional in 5.0, see AssignedClientID for the empty client id.
+			continue
+		}
+		v.Name += "V5"
+		v.Version = packets.Version5
+		rs = append(rs, v)
+	}
+	return rs
+}
+
+func assignedClientID(e *env) error {
+	_, ack, err := e.mustConnect("")
+	if err != nil {
+		return err
+	}
+	if ack.Properties == nil || len(ack.Properties.AssignedClientID) == 0 {
+		return errors.New("the assigned client identifier is missing")
+	}
+	return nil
+}
+
+func sessionExpiryZero(e *env) error {
+	c, _, err := e.mustConnect(e.clientID("c"), func(connect *packets.Connect) {
+		connect.CleanStart = false
+	})
+	if err != nil {
+		return err
+	}
+	if err = disconnect(c, e.version); err != nil {
+		return err
+	}
+	_, ack, err := e.mustConnect(e.clientID("c"), func(connect *packets.Connect) {
+		connect.CleanStart = false
+	})
+	if err != nil {
+		return err
+	}
+	if ack.SessionPresent {
+		return errors.New("the session with zero expiry interval is kept")
+	}
+	return nil
+}
+
+func sessionTakenOverReason(e *env) error {
+	c1, _, err := e.mustConnect(e.clientID("c"))
+	if err != nil {
+		return err
+	}
+	if _, _, err = e.mustConnect(e.clientID("c")); err != nil {
+		return err
+	}
+	return c1.expectDisconnect(codes.SessionTakenOver)
+}
+
+func disconnectWithWillMessage(e *env) error {
+	sub, _, err := e.mustConnect(e.clientID("sub"))
+	if err != nil {
+		return err
+	}
+	topic := e.topic("will")
+	if _, err = sub.subscribe(e.version, 1, packets.Topic{Name: topic}); err != nil {
+		return err
+	}
+	c, _, err := e.mustConnect(e.clientID("c"), withWill(topic))
+	if err != nil {
+		return err
+	}
+	if err = c.write(&packets.Disconnect{Version: e.version, Code: codes.DisconnectWithWillMessage}); err != nil {
+		return err
+	}
+	_ = c.conn.Close()
+	_, err = sub.expectPublish(topic, "will")
+	return err
+}
+
+func noMatchingSubscribers(e *env) error {
+	c, _, err := e.mustConnect(e.clientID("c"))
+	if err != nil {
+		return err
+	}
+	if err = c.write(&packets.Publish{Version: e.version, Qos: packets.Qos1, PacketID: 1, TopicName: []byte(e.topic("nobody")), Payload: []byte("msg")}); err != nil {
+		return err
+	}
+	var ack *packets.Puback
+	if err = c.expect(&ack); err != nil {
+		return err
+	}
+	if ack.Code != codes.NotMatchingSubscribers {
+		return fmt.Errorf("expected PUBACK %#x, got %#x", codes.NotMatchingSubscribers, ack.Code)
+	}
+	return nil
+}
+
+func noSubscriptionExisted(e *env) error {
+	c, _, err := e.mustConnect(e.clientID("c"))
+	if err != nil {
+		return err
+	}
+	if _, err = c.subscribe(e.version, 1, packets.Topic{Name: e.topic("a")}); err != nil {
+		return err
+	}
+	if err = c.write(&packets.Unsubscribe{Version: e.version, PacketID: 2, Topics: []string{e.topic("a"), e.topic("b")}}); err != nil {
+		return err
+	}
+	var ack *packets.Unsuback
+	if err = c.expect(&ack); err != nil {
+		return err
+	}
+	if !bytes.Equal(ack.Payload, []byte{codes.Success, codes.NoSubscriptionExisted}) {
+		return fmt.Errorf("expected reason codes [0 %#x], got %v", codes.NoSubscriptionExisted, ack.Payload)
+	}
+	return nil
+}
+
+func pubrelPacketIDNotFound(e *env) error {
+	c, _, err := e.mustConnect(e.clientID("c"))
+	if err != nil {
+		return err
+	}
+	if err = c.write(&packets.Pubrel{PacketID: 100}); err != nil {
+		return err
+	}
+	var comp *packets.Pubcomp
+	if err = c.expect(&comp); err != nil {
+		return err
+	}
+	if comp.PacketID != 100 || comp.Code != codes.PacketIDNotFound {
+		return fmt.Errorf("expected PUBCOMP with packet id 100 and reason code %#x, got %s", codes.PacketIDNotFound, comp)
+	}
+	return nil
+}
+
+func uint16Ptr(v uint16) *uint16 {
+	return &v
+}
+
+func topicAlias(e *env) error {
+	sub, _, err := e.mustConnect(e.clientID("sub"))
+	if err != nil {
+		return err
+	}
+	topic := e.topic("alias")
+	if _, err = sub.subscribe(e.version, 1, packets.Topic{Name: topic}); err != nil {
+		return err
+	}
+	pub, ack, err := e.mustConnect(e.clientID("pub"))
+	if err != nil {
+		return err
+	}
+	if ack.Properties == nil || ack.Properties.TopicAliasMaximum == nil || *ack.Properties.TopicAliasMaximum == 0 {
+		return errors.New("topic alias is not supported")
+	}
+	err = pub.publish(&packets.Publish{Version: e.version, TopicName: []byte(topic), Payload: []byte("1"),
+		Properties: &packets.Properties{TopicAlias: uint16Ptr(1)}})
+	if err != nil {
+		return err
+	}
+	err = pub.publish(&packets.Publish{Version: e.version, Payload: []byte("2"),
+		Properties: &packets.Properties{TopicAlias: uint16Ptr(1)}})
+	if err != nil {
+		return err
+	}
+	for _, v := range []string{"1", "2"} {
+		if _, err = sub.expectPublish(topic, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func topicAliasInvalid(e *env) error {
+	c, ack, err := e.mustConnect(e.clientID("c"))
+	if err != nil {
+		return err
+	}
+	var max uint16
+	if ack.Properties != nil && ack.Properties.TopicAliasMaximum != nil {
+		max = *ack.Properties.TopicAliasMaximum
+	}
+	err = c.write(&packets.Publish{Version: e.version, TopicName: []byte(e.topic("a")), Payload: []byte("msg"),
+		Properties: &packets.Properties{TopicAlias: uint16Ptr(max + 1)}})
+	if err != nil {
+		return err
+	}
+	return c.expectDisconnect(codes.TopicAliasInvalid)
+}
+
+func receiveMaximumExceeded(e *env) error {
+	c, ack, err := e.mustConnect(e.clientID("c"))
+	if err != nil {
+		return err
+	}
+	max := uint16(65535)
+	if ack.Properties != nil && ack.Properties.ReceiveMaximum != nil {
+		max = *ack.Properties.ReceiveMaximum
+	}
+	// send QoS 2 messages without PUBREL, so that they are never completed.
+	for i := 1; i <= int(max)+1; i++ {
+		err = c.write(&packets.Publish{Version: e.version, Qos: packets.Qos2, PacketID: packets.PacketID(i), TopicName: []byte(e.topic("a")), Payload: []byte("msg")})
+		if err != nil {
+			return err
+		}
+	}
+	// the PUBREC packets of the accepted messages may be dropped as the connection is closed.
+	var disconnect *packets.Disconnect
+	for {
+		pkt, err := c.read(c.timeout)
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			return errors.New("the connection is not closed")
+		}
+		if err != nil {
+			break
+		}
+		switch p := pkt.(type) {
+		case *packets.Pubrec:
+		case *packets.Disconnect:
+			disconnect = p
+		default:
+			return fmt.Errorf("unexpected packet: %s", p)
+		}
+	}
+	if disconnect == nil || disconnect.Code != codes.RecvMaxExceeded {
+		return fmt.Errorf("expected DISCONNECT %#x, got %v", codes.RecvMaxExceeded, disconnect)
+	}
+	return nil
+}
+
+func noLocal(e *env) error {
+	c, _, err := e.mustConnect(e.clientID("c"))
+	if err != nil {
+		return err
+	}
+	topic := e.topic("a")
+	if _, err = c.subscribe(e.version, 1, packets.Topic{Name: topic, SubOptions: packets.SubOptions{NoLocal: true}}); err != nil {
+		return err
+	}
+	if err = c.publish(&packets.Publish{Version: e.version, Qos: packets.Qos1, PacketID: 2, TopicName: []byte(topic), Payload: []byte("msg")}); err != nil {
+		return err
+	}
+	return c.expectNothing()
+}
+
+func sharedSubscriptionNoLocal(e *env) error {
+	c, _, err := e.mustConnect(e.clientID("c"))
+	if err != nil {
+		return err
+	}
+	err = c.write(&packets.Subscribe{Version: e.version, PacketID: 1, Topics: []packets.Topic{
+		{Name: "$share/g/" + e.topic("a"), SubOptions: packets.SubOptions{NoLocal: true}},
+	}})
+	if err != nil {
+		return err
+	}
+	return c.expectDisconnect(codes.ProtocolError)
+}
+
+func sharedSubscription(e *env) error {
+	topic := e.topic("a")
+	var members []*client
+	for _, v := range []string{"m1", "m2"} {
+		c, _, err := e.mustConnect(e.clientID(v))
+		if err != nil {
+			return err
+		}
+		if _, err = c.subscribe(e.version, 1, packets.Topic{Name: "$share/g/" + topic}); err != nil {
+			return err
+		}
+		members = append(members, c)
+	}
+	pub, _, err := e.mustConnect(e.clientID("pub"))
+	if err != nil {
+		return err
+	}
+	if err = pub.publish(&packets.Publish{Version: e.version, Qos: packets.Qos1, PacketID: 1, TopicName: []byte(topic), Payload: []byte("msg")}); err != nil {
+		return err
+	}
+	// the message is delivered to exactly one member.
+	received := 0
+	for _, c := range members {
+		if c.expectNothing() != nil {
+			received++
+		}
+	}
+	if received != 1 {
+		return fmt.Errorf("expected the message to be delivered to 1 member, got %d", received)
+	}
+	return nil
+}
+
+func retainAsPublished(e *env) error {
+	pub, _, err := e.mustConnect(e.clientID("pub"))
+	if err != nil {
+		return err
+	}
+	sub, _, err := e.mustConnect(e.clientID("sub"))
+	if err != nil {
+		return err
+	}
+	if _, err = sub.subscribe(e.version, 1,
+		packets.Topic{Name: e.topic("rap"), SubOptions: packets.SubOptions{RetainAsPublished: true}},
+		packets.Topic{Name: e.topic("default")},
+	); err != nil {
+		return err
+	}
+	for _, v := range []struct {
+		topic  string
+		retain bool
+	}{
+		{topic: e.topic("rap"), retain: true},
+		{topic: e.topic("default"), retain: false},
+	} {
+		if err = pub.publish(&packets.Publish{Version: e.version, Qos: packets.Qos1, PacketID: 1, Retain: true, TopicName: []byte(v.topic), Payload: []byte("msg")}); err != nil {
+			return err
+		}
+		recv, err := sub.expectPublish(v.topic, "msg")
+		if err != nil {
+			return err
+		}
+		if recv.Retain != v.retain {
+			return fmt.Errorf("expected the retain flag of %s to be %v", v.topic, v.retain)
+		}
+		if err = pub.publish(&packets.Publish{Version: e.version, Qos: packets.Qos1, PacketID: 2, Retain: true, TopicName: []byte(v.topic)}); err != nil {
+			return err
+		}
+		// the message which clears the retained message is forwarded as well.
+		if _, err = sub.expectPublish(v.topic, ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func retainHandling(e *env) error {
+	pub, _, err := e.mustConnect(e.clientID("pub"))
+	if err != nil {
+		return err
+	}
+	topic := e.topic("retained")
+	if err = pub.publish(&packets.Publish{Version: e.version, Qos: packets.Qos1, PacketID: 1, Retain: true, TopicName: []byte(topic), Payload: []byte("retained")}); err != nil {
+		return err
+	}
+	defer clearRetained(pub, e.version, topic)
+	sub, _, err := e.mustConnect(e.clientID("sub"))
+	if err != nil {
+		return err
+	}
+	// 2: do not send the retained messages.
+	if _, err = sub.subscribe(e.version, 1, packets.Topic{Name: topic, SubOptions: packets.SubOptions{RetainHandling: 2}}); err != nil {
+		return err
+	}
+	if err = sub.expectNothing(); err != nil {
+		return err
+	}
+	// 1: send the retained messages only if the subscription does not exist.
+	if _, err = sub.subscribe(e.version, 2, packets.Topic{Name: topic, SubOptions: packets.SubOptions{RetainHandling: 1}}); err != nil {
+		return err
+	}
+	if err = sub.expectNothing(); err != nil {
+		return err
+	}
+	if _, err = sub.subscribe(e.version, 3, packets.Topic{Name: e.topic("+"), SubOptions: packets.SubOptions{RetainHandling: 1}}); err != nil {
+		return err
+	}
+	if _, err = sub.expectPublish(topic, "retained"); err != nil {
+		return err
+	}
+	// 0: send the retained messages at the time of the subscribe.
+	if _, err = sub.subscribe(e.version, 4, packets.Topic{Name: topic, SubOptions: packets.SubOptions{RetainHandling: 0}}); err != nil {
+		return err
+	}
+	_, err = sub.expectPublish(topic, "retained")
+	return err
+}
+
+func subscriptionIdentifier(e *env) error {
+	sub, _, err := e.mustConnect(e.clientID("sub"))
+	if err != nil {
+		return err
+	}
+	topic := e.topic("a")
+	for i, v := range []string{topic, e.topic("+")} {
+		err = sub.write(&packets.Subscribe{
+			Version:    e.version,
+			PacketID:   packets.PacketID(i + 1),
+			Topics:     []packets.Topic{{Name: v}},
+			Properties: &packets.Properties{SubscriptionIdentifier: []uint32{uint32(i + 1)}},
+		})
+		if err != nil {
+			return err
+		}
+		var ack *packets.Suback
+		if err = sub.expect(&ack); err != nil {
+			return err
+		}
+	}
+	pub, _, err := e.mustConnect(e.clientID("pub"))
+	if err != nil {
+		return err
+	}
+	if err = pub.publish(&packets.Publish{Version: e.version, TopicName: []byte(topic), Payload: []byte("msg")}); err != nil {
+		return err
+	}
+	// the overlapping subscriptions may be delivered as one message with both identifiers or as two messages.
+	ids := make(map[uint32]bool)
+	for len(ids) < 2 {
+		recv, err := readSubscriptionIDs(sub, topic)
+		if err != nil {
+			return err
+		}
+		if len(recv) == 0 {
+			return errors.New("the subscription identifier is missing")
+		}
+		for _, id := range recv {
+			ids[id] = true
+		}
+	}
+	if !ids[1] || !ids[2] {
+		return fmt.Errorf("expected subscription identifiers 1 and 2, got %v", ids)
+	}
+	return nil
+}
+
+// readSubscriptionIDs reads the next QoS 0 PUBLISH packet of the topic and returns its subscription identifiers.
+// The packet is decoded by hand, because the packets package only accepts the subscription identifier in SUBSCRIBE,
+// which is the packet received by the broker.
+func readSubscriptionIDs(c *client, topic string) ([]uint32, error) {
+	first, body, err := c.readRaw()
+	if err != nil {
+		return nil, fmt.Errorf("expected Publish: %w", err)
+	}
+	if first != 0x30 {
+		return nil, fmt.Errorf("expected QoS 0 Publish, got the fixed header %#x", first)
+	}
+	r := bytes.NewReader(body)
+	name := make([]byte, 2+len(topic))
+	if _, err = io.ReadFull(r, name); err != nil || string(name[2:]) != topic {
+		return nil, fmt.Errorf("expected Publish %s", topic)
+	}
+	l, err := packets.EncodeRemainLength(r)
+	if err != nil {
+		return nil, err
+	}
+	ppt := make([]byte, l)
+	if _, err = io.ReadFull(r, ppt); err != nil {
+		return nil, err
+	}
+	pr := bytes.NewReader(ppt)
+	var ids []uint32
+	for pr.Len() != 0 {
+		typ, _ := pr.ReadByte()
+		if typ != packets.PropSubscriptionIdentifier {
+			return nil, fmt.Errorf("unexpected property %#x", typ)
+		}
+		id, err := packets.EncodeRemainLength(pr)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, uint32(id))
+	}
+	return ids, nil
+}
+
+func propertiesForwarded(e *env) error {
+	sub, _, err := e.mustConnect(e.clientID("sub"))
+	if err != nil {
+		return err
+	}
+	topic := e.topic("a")
+	if _, err = sub.subscribe(e.version, 1, packets.Topic{Name: topic}); err != nil {
+		return err
+	}
+	pub, _, err := e.mustConnect(e.clientID("pub"))
+	if err != nil {
+		return err
+	}
+	format := byte(1)
+	ppt := &packets.Properties{
+		PayloadFormat:   &format,
+		ContentType:     []byte("text/plain"),
+		ResponseTopic:   []byte(e.topic("response")),
+		CorrelationData: []byte("correlation"),
+		User:            []packets.UserProperty{{K: []byte("k"), V: []byte("v1")}, {K: []byte("k"), V: []byte("v2")}},
+	}
+	if err = pub.publish(&packets.Publish{Version: e.version, TopicName: []byte(topic), Payload: []byte("msg"), Properties: ppt}); err != nil {
+		return err
+	}
+	recv, err := sub.expectPublish(topic, "msg")
+	if err != nil {
+		return err
+	}
+	got := recv.Properties
+	if got == nil || got.PayloadFormat == nil || *got.PayloadFormat != format ||
+		!bytes.Equal(got.ContentType, ppt.ContentType) ||
+		!bytes.Equal(got.ResponseTopic, ppt.ResponseTopic) ||
+		!bytes.Equal(got.CorrelationData, ppt.CorrelationData) {
+		return fmt.Errorf("the properties are not forwarded unaltered, got %s", got)
+	}
+	// the order of the user properties must be maintained.
+	if len(got.User) != 2 || string(got.User[0].V) != "v1" || string(got.User[1].V) != "v2" {
+		return fmt.Errorf("the user properties are not forwarded in order, got %v", got.User)
+	}
+	return nil
+}
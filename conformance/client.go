@@ -0,0 +1,274 @@
+package conformance
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"time"
+
+	"github.com/DrmagicE/gmqtt/pkg/codes"
+	"github.com/DrmagicE/gmqtt/pkg/packets"
+)
+
+// quietPeriod is the time to wait for the packets that must not be sent.
+const quietPeriod = 300 * time.Millisecond
+
+// env is the environment of a running case.
+// The client ids and the topics are prefixed by the case name, so that the cases do not interfere with each other.
+type env struct {
+	addr    string
+	name    string
+	version packets.Version
+	timeout time.Duration
+	clients []*client
+}
+
+func (e *env) close() {
+	for _, c := range e.clients {
+		_ = c.conn.Close()
+	}
+}
+
+func (e *env) clientID(suffix string) string {
+	return e.name + "-" + suffix
+}
+
+func (e *env) topic(suffix string) string {
+	return "conformance/" + e.name + "/" + suffix
+}
+
+// newConnect returns the CONNECT packet of the version of the case with clean start set.
+func (e *env) newConnect(clientID string) *packets.Connect {
+	return &packets.Connect{
+		Version:       e.version,
+		ProtocolName:  []byte("MQTT"),
+		ProtocolLevel: e.version,
+		ClientID:      []byte(clientID),
+		CleanStart:    true,
+		KeepAlive:     60,
+	}
+}
+
+// dial opens a network connection to the broker without sending the CONNECT packet.
+func (e *env) dial() (*client, error) {
+	conn, err := net.DialTimeout("tcp", e.addr, e.timeout)
+	if err != nil {
+		return nil, err
+	}
+	bufr := bufio.NewReader(conn)
+	c := &client{
+		conn:    conn,
+		bufr:    bufr,
+		r:       packets.NewReader(bufr),
+		w:       packets.NewWriter(bufio.NewWriter(conn)),
+		timeout: e.timeout,
+	}
+	c.r.SetVersion(e.version)
+	e.clients = append(e.clients, c)
+	return c, nil
+}
+
+// connect sends the CONNECT packet and returns the CONNACK packet, the CONNACK may be a refusal.
+func (e *env) connect(connect *packets.Connect) (*client, *packets.Connack, error) {
+	c, err := e.dial()
+	if err != nil {
+		return nil, nil, err
+	}
+	c.r.SetVersion(connect.Version)
+	if err = c.write(connect); err != nil {
+		return nil, nil, err
+	}
+	var ack *packets.Connack
+	if err = c.expect(&ack); err != nil {
+		return nil, nil, err
+	}
+	return c, ack, nil
+}
+
+// mustConnect connects the client with the given client id, the modify function can be used to change the CONNECT packet.
+func (e *env) mustConnect(clientID string, modify ...func(connect *packets.Connect)) (*client, *packets.Connack, error) {
+	connect := e.newConnect(clientID)
+	for _, fn := range modify {
+		fn(connect)
+	}
+	c, ack, err := e.connect(connect)
+	if err != nil {
+		return nil, nil, err
+	}
+	if ack.Code != codes.Success {
+		return nil, nil, fmt.Errorf("connection refused: %#x", ack.Code)
+	}
+	return c, ack, nil
+}
+
+type client struct {
+	conn    net.Conn
+	bufr    *bufio.Reader
+	r       *packets.Reader
+	w       *packets.Writer
+	timeout time.Duration
+}
+
+func (c *client) write(p packets.Packet) error {
+	_ = c.conn.SetWriteDeadline(time.Now().Add(c.timeout))
+	return c.w.WriteAndFlush(p)
+}
+
+func (c *client) writeRaw(b []byte) error {
+	_ = c.conn.SetWriteDeadline(time.Now().Add(c.timeout))
+	_, err := c.conn.Write(b)
+	return err
+}
+
+func (c *client) read(timeout time.Duration) (packets.Packet, error) {
+	_ = c.conn.SetReadDeadline(time.Now().Add(timeout))
+	return c.r.ReadPacket()
+}
+
+// readRaw reads the next packet without decoding, it returns the first byte of the fixed header and the rest of the packet.
+func (c *client) readRaw() (byte, []byte, error) {
+	_ = c.conn.SetReadDeadline(time.Now().Add(c.timeout))
+	first, err := c.bufr.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	l, err := packets.EncodeRemainLength(c.bufr)
+	if err != nil {
+		return 0, nil, err
+	}
+	body := make([]byte, l)
+	_, err = io.ReadFull(c.bufr, body)
+	return first, body, err
+}
+
+// expect reads the next packet into p, which must be a pointer to a packet pointer, e.g. **packets.Suback.
+// It returns an error if the next packet is of another type.
+func (c *client) expect(p interface{}) error {
+	pkt, err := c.read(c.timeout)
+	if err != nil {
+		return fmt.Errorf("expected %s: %w", reflect.TypeOf(p).Elem().Elem().Name(), err)
+	}
+	v := reflect.ValueOf(p).Elem()
+	if reflect.TypeOf(pkt) != v.Type() {
+		return fmt.Errorf("expected %s, got %s", v.Type().Elem().Name(), pkt)
+	}
+	v.Set(reflect.ValueOf(pkt))
+	return nil
+}
+
+// expectNothing returns an error if any packet is received in the quiet period.
+func (c *client) expectNothing() error {
+	pkt, err := c.read(quietPeriod)
+	if err == nil {
+		return fmt.Errorf("unexpected packet: %s", pkt)
+	}
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		return nil
+	}
+	return fmt.Errorf("unexpected error: %w", err)
+}
+
+// expectClosed returns nil if the broker closes the connection.
+// The V5 broker may send a DISCONNECT packet before closing, which is returned.
+func (c *client) expectClosed() (*packets.Disconnect, error) {
+	var disconnect *packets.Disconnect
+	for {
+		pkt, err := c.read(c.timeout)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				return nil, errors.New("the connection is not closed")
+			}
+			return disconnect, nil
+		}
+		if d, ok := pkt.(*packets.Disconnect); ok {
+			disconnect = d
+			continue
+		}
+		return nil, fmt.Errorf("expected the connection to be closed, got %s", pkt)
+	}
+}
+
+// expectDisconnect returns nil if the broker sends DISCONNECT with the code and closes the connection.
+func (c *client) expectDisconnect(code codes.Code) error {
+	d, err := c.expectClosed()
+	if err != nil {
+		return err
+	}
+	if d == nil {
+		return fmt.Errorf("the connection is closed without DISCONNECT %#x", code)
+	}
+	if d.Code != code {
+		return fmt.Errorf("expected DISCONNECT %#x, got %#x", code, d.Code)
+	}
+	return nil
+}
+
+func (c *client) subscribe(version packets.Version, id packets.PacketID, topics ...packets.Topic) (*packets.Suback, error) {
+	err := c.write(&packets.Subscribe{
+		Version:  version,
+		PacketID: id,
+		Topics:   topics,
+	})
+	if err != nil {
+		return nil, err
+	}
+	var ack *packets.Suback
+	if err = c.expect(&ack); err != nil {
+		return nil, err
+	}
+	if ack.PacketID != id {
+		return nil, fmt.Errorf("expected SUBACK with packet id %d, got %d", id, ack.PacketID)
+	}
+	return ack, nil
+}
+
+// publish publishes the message and completes the QoS 1/2 flow of the publisher.
+func (c *client) publish(p *packets.Publish) error {
+	if err := c.write(p); err != nil {
+		return err
+	}
+	switch p.Qos {
+	case packets.Qos1:
+		var ack *packets.Puback
+		if err := c.expect(&ack); err != nil {
+			return err
+		}
+		if ack.PacketID != p.PacketID {
+			return fmt.Errorf("expected PUBACK with packet id %d, got %d", p.PacketID, ack.PacketID)
+		}
+	case packets.Qos2:
+		var rec *packets.Pubrec
+		if err := c.expect(&rec); err != nil {
+			return err
+		}
+		if rec.PacketID != p.PacketID {
+			return fmt.Errorf("expected PUBREC with packet id %d, got %d", p.PacketID, rec.PacketID)
+		}
+		if err := c.write(rec.NewPubrel()); err != nil {
+			return err
+		}
+		var comp *packets.Pubcomp
+		if err := c.expect(&comp); err != nil {
+			return err
+		}
+		if comp.PacketID != p.PacketID {
+			return fmt.Errorf("expected PUBCOMP with packet id %d, got %d", p.PacketID, comp.PacketID)
+		}
+	}
+	return nil
+}
+
+// expectPublish reads the next PUBLISH packet and checks its topic and payload.
+func (c *client) expectPublish(topic string, payload string) (*packets.Publish, error) {
+	var p *packets.Publish
+	if err := c.expect(&p); err != nil {
+		return nil, err
+	}
+	if string(p.TopicName) != topic || string(p.Payload) != payload {
+		return nil, fmt.Errorf("expected PUBLISH %s with payload %q, got %s", topic, payload, p)
+	}
+	return p, nil
+}
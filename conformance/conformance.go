@@ -0,0 +1,112 @@
+// Package conformance provides the MQTT 3.1.1 and 5.0 protocol conformance suite of gmqtt.
+//
+// Each Case drives a broker over the network through a behavior required (or allowed) by the specification,
+// including the handling of malformed packets and the correctness of the reason codes.
+// The suite runs against an in-process broker by "go test ./conformance",
+// and can be run against any broker by calling Case.Run with its address.
+package conformance
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/DrmagicE/gmqtt/pkg/packets"
+)
+
+// Level is the requirement level of the behavior checked by a Case.
+type Level int
+
+const (
+	// Mandatory is the behavior that the specification says MUST or MUST NOT.
+	Mandatory Level = iota
+	// Optional is the behavior that the specification says SHOULD or MAY, the choice of gmqtt is checked.
+	Optional
+)
+
+func (l Level) String() string {
+	if l == Mandatory {
+		return "mandatory"
+	}
+	return "optional"
+}
+
+// Case is a conformance test case.
+type Case struct {
+	// Name is the unique name of the case.
+	Name string
+	// Ref is the normative statement or the section of the specification checked by the case.
+	Ref string
+	// Version is the protocol version of the clients used by the case.
+	Version packets.Version
+	Level   Level
+	// Known describes the known deviation of gmqtt from the case, the failure of the case does not fail "go test".
+	Known string
+	check func(e *env) error
+}
+
+// Run runs the case against the broker listening on the addr, it returns nil if the broker conforms.
+func (c Case) Run(addr string) error {
+	e := &env{
+		addr:    addr,
+		name:    c.Name,
+		version: c.Version,
+		timeout: 2 * time.Second,
+	}
+	defer e.close()
+	if err := c.check(e); err != nil {
+		return fmt.Errorf("[%s] %w", c.Ref, err)
+	}
+	return nil
+}
+
+// Cases is the list of all cases.
+var Cases = concat(v311Cases, v5Cases, malformedCases)
+
+func concat(cases ...[]Case) (rs []Case) {
+	for _, v := range cases {
+		rs = append(rs, v...)
+	}
+	return rs
+}
+
+// Result is the result of a case.
+type Result struct {
+	Name    string `json:"name"`
+	Ref     string `json:"ref"`
+	Version string `json:"version"`
+	Level   string `json:"level"`
+	Known   string `json:"known,omitempty"`
+	// Error is the reason of the failure, empty if the broker conforms.
+	Error string `json:"error,omitempty"`
+}
+
+// Run runs the cases whose name match the filter against the broker listening on the addr.
+// If filter is nil, all cases will be run.
+func Run(addr string, filter *regexp.Regexp) []Result {
+	var rs []Result
+	for _, c := range Cases {
+		if filter != nil && !filter.MatchString(c.Name) {
+			continue
+		}
+		r := Result{
+			Name:    c.Name,
+			Ref:     c.Ref,
+			Version: versionName(c.Version),
+			Level:   c.Level.String(),
+			Known:   c.Known,
+		}
+		if err := c.Run(addr); err != nil {
+			r.Error = err.Error()
+		}
+		rs = append(rs, r)
+	}
+	return rs
+}
+
+func versionName(v packets.Version) string {
+	if packets.IsVersion5(v) {
+		return "5.0"
+	}
+	return "3.1.1"
+}
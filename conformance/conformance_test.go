@@ -0,0 +1,72 @@
+package conformance
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/DrmagicE/gmqtt/config"
+	_ "github.com/DrmagicE/gmqtt/persistence"
+	"github.com/DrmagicE/gmqtt/server"
+	_ "github.com/DrmagicE/gmqtt/topicalias/fifo"
+)
+
+func startServer(t *testing.T) (server.Server, string) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.DefaultConfig()
+	cfg.API = config.API{}
+	srv := server.New(
+		server.WithConfig(cfg),
+		server.WithTCPListener(ln),
+		server.WithLogger(zap.NewNop()),
+	)
+	go func() {
+		_ = srv.Run()
+	}()
+	// wait for the server to be ready
+	for i := 0; ; i++ {
+		if c, err := net.Dial("tcp", ln.Addr().String()); err == nil {
+			_ = c.Close()
+			break
+		}
+		if i == 50 {
+			t.Fatal("server not ready")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return srv, ln.Addr().String()
+}
+
+func TestConformance(t *testing.T) {
+	srv, addr := startServer(t)
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = srv.Stop(ctx)
+	}()
+	names := make(map[string]bool)
+	for _, c := range Cases {
+		if names[c.Name] {
+			t.Fatalf("duplicated case name: %s", c.Name)
+		}
+		names[c.Name] = true
+		c := c
+		t.Run(c.Name, func(t *testing.T) {
+			err := c.Run(addr)
+			switch {
+			case c.Known != "" && err != nil:
+				t.Skipf("known deviation (%s): %v", c.Known, err)
+			case c.Known != "":
+				t.Errorf("the known deviation is fixed, remove the mark: %s", c.Known)
+			case err != nil:
+				t.Errorf("%s: %v", c.Level, err)
+			}
+		})
+	}
+}
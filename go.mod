@@ -3,6 +3,7 @@ module github.com/DrmagicE/gmqtt
 go 1.14
 
 require (
+	github.com/alicebob/miniredis/v2 v2.16.0
 	github.com/golang/mock v1.6.0
 	github.com/golang/protobuf v1.5.2
 	github.com/gomodule/redigo v1.8.2
@@ -15,12 +16,13 @@ require (
 	github.com/hashicorp/logutils v1.0.0
 	github.com/hashicorp/serf v0.9.5
 	github.com/iancoleman/strcase v0.1.2
-	github.com/kardianos/service v1.2.2 // indirect
+	github.com/kardianos/service v1.2.2
 	github.com/lestrrat-go/file-rotatelogs v2.4.0+incompatible
 	github.com/lestrrat-go/strftime v1.0.6 // indirect
 	github.com/lestrrat/go-file-rotatelogs v0.0.0-20180223000712-d3151e2a480f // indirect
 	github.com/lestrrat/go-strftime v0.0.0-20180220042222-ba3bf9c1d042 // indirect
-	github.com/lupc/go_service v0.0.0-20230818145336-e469a5033191 // indirect
+	github.com/lupc/go_service v0.0.0-20230818145336-e469a5033191
+	github.com/miekg/dns v1.1.26
 	github.com/natefinch/lumberjack v2.0.0+incompatible // indirect
 	github.com/pkg/errors v0.9.1
 	github.com/prometheus/client_golang v1.4.0
@@ -28,6 +30,7 @@ require (
 	github.com/stretchr/testify v1.7.0
 	go.uber.org/zap v1.13.0
 	golang.org/x/crypto v0.0.0-20210921155107-089bfa567519
+	golang.org/x/net v0.0.0-20221014081412-f15817d10f9b
 	golang.org/x/sys v0.0.0-20220728004956-3c1f35247d10
 	google.golang.org/genproto v0.0.0-20221201204527-e3fa12d562f3
 	google.golang.org/grpc v1.50.1
@@ -61,6 +61,31 @@ func (m *Message) Copy() *Message {
 
 }
 
+// ShallowCopy copies the Message but shares the Payload, CorrelationData and UserProperties with the original one.
+// It is used to route one message to multiple subscribers without holding a copy of the payload for each of them,
+// which matters for large payloads. The shared fields must be treated as read-only.
+func (m *Message) ShallowCopy() *Message {
+	newMsg := &Message{
+		Dup:             m.Dup,
+		QoS:             m.QoS,
+		Retained:        m.Retained,
+		Topic:           m.Topic,
+		Payload:         m.Payload,
+		PacketID:        m.PacketID,
+		ContentType:     m.ContentType,
+		CorrelationData: m.CorrelationData,
+		MessageExpiry:   m.MessageExpiry,
+		PayloadFormat:   m.PayloadFormat,
+		ResponseTopic:   m.ResponseTopic,
+		UserProperties:  m.UserProperties,
+	}
+	if len(m.SubscriptionIdentifier) != 0 {
+		newMsg.SubscriptionIdentifier = make([]uint32, len(m.SubscriptionIdentifier))
+		copy(newMsg.SubscriptionIdentifier, m.SubscriptionIdentifier)
+	}
+	return newMsg
+}
+
 func getVariablelenght(l int) int {
 	if l <= 127 {
 		return 1
@@ -0,0 +1,283 @@
+package persistence
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	redigo "github.com/gomodule/redigo/redis"
+
+	"github.com/DrmagicE/gmqtt"
+	"github.com/DrmagicE/gmqtt/config"
+	"github.com/DrmagicE/gmqtt/persistence/queue"
+	redis_queue "github.com/DrmagicE/gmqtt/persistence/queue/redis"
+	redis_sess "github.com/DrmagicE/gmqtt/persistence/session/redis"
+	redis_sub "github.com/DrmagicE/gmqtt/persistence/subscription/redis"
+	redis_unack "github.com/DrmagicE/gmqtt/persistence/unack/redis"
+)
+
+// inspectPageSize is the number of keys or queue elems to read at once.
+const inspectPageSize = 100
+
+// RedisInspector reads the state of the redis persistence directly without the broker,
+// which is used for the post-mortem analysis after a crash. It never modifies the state.
+type RedisInspector struct {
+	pool *redigo.Pool
+}
+
+// NewRedisInspector returns the RedisInspector of the redis persistence in the config.
+func NewRedisInspector(config config.Config) (*RedisInspector, error) {
+	r := &RedisInspector{
+		pool: newPool(config),
+	}
+	c := r.pool.Get()
+	defer c.Close()
+	if _, err := c.Do("PING"); err != nil {
+		r.pool.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// Close closes the connections to redis.
+func (r *RedisInspector) Close() error {
+	return r.pool.Close()
+}
+
+// SessionInfo is the summary of a persistent session.
+type SessionInfo struct {
+	ClientID string
+	// Session is nil if the session can not be decoded.
+	Session *gmqtt.Session
+	// Err is the error of decoding the session.
+	Err error
+	// QueueLen is the number of the elems in the queue, including the inflight ones.
+	QueueLen int
+	// SubscriptionLen is the number of the subscriptions.
+	SubscriptionLen int
+	// UnackLen is the number of the QoS 2 packet ids received but not released.
+	UnackLen int
+}
+
+// scan calls fn for each key that has the prefix, until fn returns false.
+func scan(c redigo.Conn, prefix string, fn func(key string) (bool, error)) error {
+	iter := 0
+	// SCAN may return a key multiple times.
+	seen := make(map[string]struct{})
+	for {
+		arr, err := redigo.Values(c.Do("SCAN", iter, "MATCH", prefix+"*", "COUNT", inspectPageSize))
+		if err != nil {
+			return err
+		}
+		keys, err := redigo.Strings(arr[1], nil)
+		if err != nil {
+			return err
+		}
+		for _, k := range keys {
+			if _, ok := seen[k]; ok {
+				continue
+			}
+			seen[k] = struct{}{}
+			cont, err := fn(k)
+			if err != nil || !cont {
+				return err
+			}
+		}
+		iter, _ = redigo.Int(arr[0], nil)
+		if iter == 0 {
+			return nil
+		}
+	}
+}
+
+func (r *RedisInspector) sessionInfo(c redigo.Conn, clientID string) (*SessionInfo, error) {
+	info := &SessionInfo{
+		ClientID: clientID,
+	}
+	info.Session, info.Err = redis_sess.ReadSession(c, redis_sess.KeyPrefix+clientID)
+	if info.Err != nil {
+		info.Session = nil
+	}
+	var err error
+	if info.QueueLen, err = redigo.Int(c.Do("LLEN", redis_queue.KeyPrefix+clientID)); err != nil {
+		return nil, err
+	}
+	if info.SubscriptionLen, err = redigo.Int(c.Do("HLEN", redis_sub.KeyPrefix+clientID)); err != nil {
+		return nil, err
+	}
+	if info.UnackLen, err = redigo.Int(c.Do("HLEN", redis_unack.KeyPrefix+clientID)); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// Sessions calls fn for each session in no particular order, until fn returns false.
+func (r *RedisInspector) Sessions(fn func(info *SessionInfo) bool) error {
+	c := r.pool.Get()
+	defer c.Close()
+	return scan(c, redis_sess.KeyPrefix, func(key string) (bool, error) {
+		info, err := r.sessionInfo(c, strings.TrimPrefix(key, redis_sess.KeyPrefix))
+		if err != nil {
+			return false, err
+		}
+		return fn(info), nil
+	})
+}
+
+// Session returns the session of the client id, nil if the session does not exist.
+func (r *RedisInspector) Session(clientID string) (*SessionInfo, error) {
+	c := r.pool.Get()
+	defer c.Close()
+	exists, err := redigo.Bool(c.Do("EXISTS", redis_sess.KeyPrefix+clientID))
+	if err != nil || !exists {
+		return nil, err
+	}
+	return r.sessionInfo(c, clientID)
+}
+
+// Subscriptions returns the subscriptions of the client id.
+func (r *RedisInspector) Subscriptions(clientID string) ([]*gmqtt.Subscription, error) {
+	c := r.pool.Get()
+	defer c.Close()
+	rs, err := redigo.ByteSlices(c.Do("HVALS", redis_sub.KeyPrefix+clientID))
+	if err != nil {
+		return nil, err
+	}
+	subs := make([]*gmqtt.Subscription, 0, len(rs))
+	for _, v := range rs {
+		sub, err := redis_sub.DecodeSubscription(v)
+		if err != nil {
+			return nil, err
+		}
+		subs = append(subs, sub)
+	}
+	return subs, nil
+}
+
+// Queue calls fn for each elem in the queue of the client id in order, until fn returns false.
+// err is the error of decoding the elem, and elem is nil if err is not nil.
+func (r *RedisInspector) Queue(clientID string, fn func(elem *queue.Elem, err error) bool) error {
+	c := r.pool.Get()
+	defer c.Close()
+	return r.queue(c, redis_queue.KeyPrefix+clientID, fn)
+}
+
+func (r *RedisInspector) queue(c redigo.Conn, key string, fn func(elem *queue.Elem, err error) bool) error {
+	for start := 0; ; start += inspectPageSize {
+		rs, err := redigo.ByteSlices(c.Do("LRANGE", key, start, start+inspectPageSize-1))
+		if err != nil {
+			return err
+		}
+		for _, v := range rs {
+			e := &queue.Elem{}
+			if err := e.Decode(v); err != nil {
+				if !fn(nil, err) {
+					return nil
+				}
+				continue
+			}
+			if !fn(e, nil) {
+				return nil
+			}
+		}
+		if len(rs) < inspectPageSize {
+			return nil
+		}
+	}
+}
+
+// Problem is an inconsistency of the persistent state found by Check.
+type Problem struct {
+	// Key is the redis key where the problem is found.
+	Key string
+	// Description describes the problem.
+	Description string
+}
+
+// Check scans all keys of the persistence and calls fn for each problem found, it reports:
+//   - the sessions, subscriptions, queue elems and unacknowledged packet ids which can not be decoded;
+//   - the inflight queue elems which are not in the front of the queue, or have duplicated packet ids;
+//   - the queues, subscriptions and unacknowledged packet ids whose session does not exist.
+//
+// False problems may be reported if the broker is modifying the state while checking, stop the broker first.
+func (r *RedisInspector) Check(fn func(p Problem)) error {
+	c := r.pool.Get()
+	defer c.Close()
+	sessions := make(map[string]struct{})
+	err := scan(c, redis_sess.KeyPrefix, func(key string) (bool, error) {
+		sessions[strings.TrimPrefix(key, redis_sess.KeyPrefix)] = struct{}{}
+		if _, err := redis_sess.ReadSession(c, key); err != nil {
+			fn(Problem{Key: key, Description: fmt.Sprintf("invalid session: %s", err)})
+		}
+		return true, nil
+	})
+	if err != nil {
+		return err
+	}
+	orphan := func(prefix string, key string) {
+		if _, ok := sessions[strings.TrimPrefix(key, prefix)]; !ok {
+			fn(Problem{Key: key, Description: "session not found"})
+		}
+	}
+	err = scan(c, redis_queue.KeyPrefix, func(key string) (bool, error) {
+		orphan(redis_queue.KeyPrefix, key)
+		var index int
+		var inflightEnd bool
+		ids := make(map[uint16]struct{})
+		err := r.queue(c, key, func(elem *queue.Elem, err error) bool {
+			defer func() {
+				index++
+			}()
+			if err != nil {
+				fn(Problem{Key: key, Description: fmt.Sprintf("invalid elem %d: %s", index, err)})
+				return true
+			}
+			id := elem.ID()
+			if id == 0 {
+				inflightEnd = true
+				return true
+			}
+			if inflightEnd {
+				fn(Problem{Key: key, Description: fmt.Sprintf("inflight elem %d (packet id %d) is after the queued elems", index, id)})
+			}
+			if _, ok := ids[id]; ok {
+				fn(Problem{Key: key, Description: fmt.Sprintf("duplicated packet id %d of elem %d", id, index)})
+			}
+			ids[id] = struct{}{}
+			return true
+		})
+		return true, err
+	})
+	if err != nil {
+		return err
+	}
+	err = scan(c, redis_sub.KeyPrefix, func(key string) (bool, error) {
+		orphan(redis_sub.KeyPrefix, key)
+		rs, err := redigo.StringMap(c.Do("HGETALL", key))
+		if err != nil {
+			return false, err
+		}
+		for topic, v := range rs {
+			if _, err := redis_sub.DecodeSubscription([]byte(v)); err != nil {
+				fn(Problem{Key: key, Description: fmt.Sprintf("invalid subscription %s: %s", topic, err)})
+			}
+		}
+		return true, nil
+	})
+	if err != nil {
+		return err
+	}
+	return scan(c, redis_unack.KeyPrefix, func(key string) (bool, error) {
+		orphan(redis_unack.KeyPrefix, key)
+		ids, err := redigo.Strings(c.Do("HKEYS", key))
+		if err != nil {
+			return false, err
+		}
+		for _, v := range ids {
+			if id, err := strconv.ParseUint(v, 10, 16); err != nil || id == 0 {
+				fn(Problem{Key: key, Description: fmt.Sprintf("invalid packet id: %s", v)})
+			}
+		}
+		return true, nil
+	})
+}
@@ -10,6 +10,20 @@ var (
 	ErrDropQueueFull            = errors.New("the message queue is full")
 	ErrDropExpired              = errors.New("the message is expired")
 	ErrDropExpiredInflight      = errors.New("the inflight message is expired")
+	ErrDropOverloaded           = errors.New("the server is overloaded")
+	// ErrDropQos0NotQueued indicates that the QoS 0 message is not queued for the offline client,
+	// it is only used when queue_qos0_messages is disabled.
+	ErrDropQos0NotQueued = errors.New("the qos 0 message is not queued for the offline client")
+	// ErrDropNoSubscriber indicates that the message has no matching subscriber.
+	// It is only reported for the topics that match the report_no_subscriber_topics setting.
+	ErrDropNoSubscriber = errors.New("no matching subscriber")
+	// ErrDropIncompatible indicates that the V5 message is rejected for the V3 client by the property_mapping setting.
+	ErrDropIncompatible = errors.New("the message is incompatible with the protocol version of the client")
+	// ErrDropRedeliveryExhausted indicates that the inflight message is not acknowledged after the maximum redelivery attempts.
+	// It is only used when the redelivery is enabled and the exhausted_action is drop.
+	ErrDropRedeliveryExhausted = errors.New("the inflight message is not acknowledged after the maximum redelivery attempts")
+	// ErrDropBlackhole indicates that the message is accepted but discarded because the topic matches the blackhole_topics setting.
+	ErrDropBlackhole = errors.New("the message is published to a blackhole topic")
 )
 
 // InternalError wraps the error of the backend storage.
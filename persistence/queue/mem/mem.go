@@ -13,6 +13,7 @@ import (
 )
 
 var _ queue.Store = (*Queue)(nil)
+var _ queue.Inspector = (*Queue)(nil)
 
 type Options struct {
 	MaxQueuedMsg    int
@@ -276,3 +277,33 @@ func (q *Queue) Remove(pid packets.PacketID) error {
 	}
 	return nil
 }
+
+func (q *Queue) Inspect(fn func(elem *queue.Elem) bool) error {
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+	for e := q.l.Front(); e != nil; e = e.Next() {
+		if !fn(e.Value.(*queue.Elem)) {
+			break
+		}
+	}
+	return nil
+}
+
+func (q *Queue) Purge() (int, error) {
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+	var n int
+	for e := q.l.Front(); e != nil; {
+		next := e.Next()
+		if e.Value.(*queue.Elem).ID() == 0 {
+			if e == q.current {
+				q.current = next
+			}
+			q.l.Remove(e)
+			n++
+		}
+		e = next
+	}
+	q.notifier.NotifyMsgQueueAdded(-n)
+	return n, nil
+}
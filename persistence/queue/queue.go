@@ -49,6 +49,9 @@ type Store interface {
 	// The caller must call ReadInflight first to read all inflight message before calling this method.
 	// Calling this method will be blocked until there are any new messages can be read or the store has been closed.
 	// If the store has been closed, returns nil, ErrClosed.
+	// The caller passes as many packet ids as the free slots of the inflight window,
+	// so the implementation backed by an external store should only load the requested batch rather than the whole queue,
+	// which bounds the memory usage when a client returns with a huge backlog.
 	Read(pids []packets.PacketID) ([]*Elem, error)
 
 	// ReadInflight reads at most maxSize inflight messages.
@@ -60,6 +63,18 @@ type Store interface {
 	Remove(pid packets.PacketID) error
 }
 
+// Inspector is an optional interface of Store, which provides the ability to inspect and purge the queue
+// for the operational debugging.
+type Inspector interface {
+	// Inspect calls fn for each elem in the queue in order without removing it, until fn returns false.
+	// The inflight elems, whose packet id is not 0, are in the front of the queue.
+	// The elem is only valid in fn, fn must not modify it and must copy it to retain it.
+	Inspect(fn func(elem *Elem) bool) error
+	// Purge removes all non-inflight elems from the queue and returns the number of removed elems.
+	// The removed elems are not notified as dropped.
+	Purge() (int, error)
+}
+
 type Notifier interface {
 	// NotifyDropped will be called when the element in the queue is dropped.
 	// The err indicates the reason of why it is dropped.
@@ -15,13 +15,63 @@ import (
 )
 
 const (
-	queuePrefix = "queue:"
+	// KeyPrefix is the prefix of the redis keys of the queues, the key is KeyPrefix + client id.
+	KeyPrefix = "queue:"
+	// Qos0KeyPrefix is the prefix of the redis keys of the lists which index the queued (non-inflight) QoS 0 elems in order,
+	// the key is Qos0KeyPrefix + client id.
+	Qos0KeyPrefix = "queue_qos0:"
+	// ExpiryKeyPrefix is the prefix of the redis keys of the sorted sets which index the queued (non-inflight) elems
+	// with expiry by the expiry time, the key is ExpiryKeyPrefix + client id.
+	ExpiryKeyPrefix = "queue_expiry:"
+	// scanPageSize is the number of elements to load at once when scanning the queue.
+	// The queue of a client that has been offline for a long time can be huge,
+	// scan it page by page to avoid loading the whole queue into memory.
+	scanPageSize = 100
 )
 
 var _ queue.Store = (*Queue)(nil)
+var _ queue.Inspector = (*Queue)(nil)
 
 func getKey(clientID string) string {
-	return queuePrefix + clientID
+	return KeyPrefix + clientID
+}
+
+func getQos0Key(clientID string) string {
+	return Qos0KeyPrefix + clientID
+}
+
+func getExpiryKey(clientID string) string {
+	return ExpiryKeyPrefix + clientID
+}
+
+// index adds the queued elem into the indexes, which are used to find the elem to drop when the queue is full
+// without scanning the queue.
+// The elems of the queues which are created by the previous versions are not indexed,
+// in which case the front elem is dropped instead.
+func (q *Queue) index(conn redigo.Conn, e *queue.Elem, b []byte) error {
+	if e.MessageWithID.(*queue.Publish).QoS == packets.Qos0 {
+		if err := conn.Send("rpush", getQos0Key(q.clientID), b); err != nil {
+			return err
+		}
+	}
+	if !e.Expiry.IsZero() {
+		return conn.Send("zadd", getExpiryKey(q.clientID), e.Expiry.Unix(), b)
+	}
+	return nil
+}
+
+// unindex removes the queued elem from the indexes, it is called once the elem is removed from the queue
+// or becomes inflight.
+func (q *Queue) unindex(conn redigo.Conn, e *queue.Elem, b []byte) error {
+	if e.MessageWithID.(*queue.Publish).QoS == packets.Qos0 {
+		if err := conn.Send("lrem", getQos0Key(q.clientID), 1, b); err != nil {
+			return err
+		}
+	}
+	if !e.Expiry.IsZero() {
+		return conn.Send("zrem", getExpiryKey(q.clientID), b)
+	}
+	return nil
 }
 
 type Options struct {
@@ -105,7 +155,7 @@ func (q *Queue) Init(opts *queue.InitOptions) error {
 	defer conn.Close()
 
 	if opts.CleanStart {
-		_, err := conn.Do("del", getKey(q.clientID))
+		_, err := conn.Do("del", getKey(q.clientID), getQos0Key(q.clientID), getExpiryKey(q.clientID))
 		if err != nil {
 			return wrapError(err)
 		}
@@ -128,7 +178,7 @@ func (q *Queue) Init(opts *queue.InitOptions) error {
 func (q *Queue) Clean() error {
 	conn := q.pool.Get()
 	defer conn.Close()
-	_, err := conn.Do("del", getKey(q.clientID))
+	_, err := conn.Do("del", getKey(q.clientID), getQos0Key(q.clientID), getExpiryKey(q.clientID))
 	return err
 }
 
@@ -148,94 +198,107 @@ func (q *Queue) Add(elem *queue.Elem) (err error) {
 
 	defer func() {
 		if drop {
+			if dropBytes == nil {
+				q.notifier.NotifyDropped(elem, dropErr)
+				return
+			}
 			if dropErr == queue.ErrDropExpiredInflight {
 				q.notifier.NotifyInflightAdded(-1)
 				q.current--
+				delete(q.readCache, dropElem.ID())
+			} else if err = q.unindex(conn, dropElem, dropBytes); err != nil {
+				return
 			}
-			if dropBytes == nil {
-				q.notifier.NotifyDropped(elem, dropErr)
+			if err = conn.Send("lrem", getKey(q.clientID), 1, dropBytes); err != nil {
 				return
-			} else {
-				err = conn.Send("lrem", getKey(q.clientID), 1, dropBytes)
 			}
 			q.notifier.NotifyDropped(dropElem, dropErr)
 		} else {
 			q.notifier.NotifyMsgQueueAdded(1)
 			q.len++
 		}
-		_ = conn.Send("rpush", getKey(q.clientID), elem.Encode())
+		b := elem.Encode()
+		_ = conn.Send("rpush", getKey(q.clientID), b)
+		if err = q.index(conn, elem, b); err != nil {
+			return
+		}
 		err = conn.Flush()
 	}()
-	if q.len >= q.max {
-		// set default drop error
-		dropErr = queue.ErrDropQueueFull
-		drop = true
-		var rs []interface{}
-		// drop expired inflight message
-		rs, err = redigo.Values(conn.Do("lrange", getKey(q.clientID), 0, q.len))
+	if q.len < q.max {
+		return nil
+	}
+	// set default drop error
+	dropErr = queue.ErrDropQueueFull
+	drop = true
+	// drop expired inflight message, the inflight messages are in the front of the queue
+	// and the number of them is limited by the receive maximum.
+	if q.current > 0 {
+		var rs [][]byte
+		rs, err = redigo.ByteSlices(conn.Do("lrange", getKey(q.clientID), 0, q.current-1))
 		if err != nil {
 			return
 		}
-		var frontBytes []byte
-		var frontElem *queue.Elem
-		for i := 0; i < len(rs); i++ {
-			b := rs[i].([]byte)
+		for _, b := range rs {
 			e := &queue.Elem{}
-			err = e.Decode(b)
-			if err != nil {
+			if err = e.Decode(b); err != nil {
 				return
 			}
-			// inflight message
-			if i < q.current && queue.ElemExpiry(now, e) {
+			if queue.ElemExpiry(now, e) {
 				dropBytes = b
 				dropElem = e
 				dropErr = queue.ErrDropExpiredInflight
 				return
 			}
-			// non-inflight message
-			if i >= q.current {
-				if i == q.current {
-					frontBytes = b
-					frontElem = e
-				}
-				// drop qos0 message in the queue
-				pub := e.MessageWithID.(*queue.Publish)
-				// drop expired non-inflight message
-				if pub.ID() == 0 && queue.ElemExpiry(now, e) {
-					dropBytes = b
-					dropElem = e
-					dropErr = queue.ErrDropExpired
-					return
-				}
-				if pub.ID() == 0 && pub.QoS == packets.Qos0 && dropElem == nil {
-					dropBytes = b
-					dropElem = e
-				}
-			}
 		}
-		// drop the current elem if there is no more non-inflight messages.
-		if q.inflightDrained && q.current >= q.len {
-			return
-		}
-		rs, err = redigo.Values(conn.Do("lrange", getKey(q.clientID), q.current, q.len))
-		if err != nil {
-			return err
-		}
-		if dropElem != nil {
+	}
+	// drop expired non-inflight message
+	var rs [][]byte
+	rs, err = redigo.ByteSlices(conn.Do("zrangebyscore", getExpiryKey(q.clientID), "-inf", now.Unix(), "limit", 0, 1))
+	if err != nil {
+		return
+	}
+	if len(rs) != 0 {
+		e := &queue.Elem{}
+		if err = e.Decode(rs[0]); err != nil {
 			return
 		}
-		if elem.MessageWithID.(*queue.Publish).QoS == packets.Qos0 {
+		if queue.ElemExpiry(now, e) {
+			dropBytes = rs[0]
+			dropElem = e
+			dropErr = queue.ErrDropExpired
 			return
 		}
-		if frontElem != nil {
-			// drop the front message
-			dropBytes = frontBytes
-			dropElem = frontElem
-		}
-		// the the messages in the queue are all inflight messages, drop the current elem
+	}
+	// drop the current elem if there is no more non-inflight messages.
+	if q.inflightDrained && q.current >= q.len {
 		return
 	}
-	return nil
+	// drop qos0 message in the queue
+	if dropBytes, dropElem, err = q.lindex(conn, getQos0Key(q.clientID), 0); err != nil || dropElem != nil {
+		return
+	}
+	if elem.MessageWithID.(*queue.Publish).QoS == packets.Qos0 {
+		return
+	}
+	// drop the front message, or the current elem if the messages in the queue are all inflight messages.
+	dropBytes, dropElem, err = q.lindex(conn, getKey(q.clientID), q.current)
+	return
+}
+
+// lindex returns the elem at the index of the list, or nil if the index is out of range.
+func (q *Queue) lindex(conn redigo.Conn, key string, index int) ([]byte, *queue.Elem, error) {
+	b, err := redigo.Bytes(conn.Do("lindex", key, index))
+	if err == redigo.ErrNil {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	e := &queue.Elem{}
+	if err = e.Decode(b); err != nil {
+		return nil, nil, err
+	}
+	return b, e, nil
 }
 
 func (q *Queue) Replace(elem *queue.Elem) (replaced bool, err error) {
@@ -303,6 +366,10 @@ func (q *Queue) Read(pids []packets.PacketID) (elems []*queue.Elem, err error) {
 		if err != nil {
 			return nil, err
 		}
+		// the elem is either removed or becomes inflight.
+		if err = q.unindex(conn, e, b); err != nil {
+			return nil, err
+		}
 		// remove expired message
 		if queue.ElemExpiry(now, e) {
 			err = conn.Send("lrem", getKey(q.clientID), 1, b)
@@ -416,3 +483,72 @@ func (q *Queue) Remove(pid packets.PacketID) error {
 	}
 	return nil
 }
+
+func (q *Queue) Inspect(fn func(elem *queue.Elem) bool) error {
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+	conn := q.pool.Get()
+	defer conn.Close()
+	for start := 0; ; start += scanPageSize {
+		rs, err := redigo.Values(conn.Do("lrange", getKey(q.clientID), start, start+scanPageSize-1))
+		if err != nil {
+			return wrapError(err)
+		}
+		for _, v := range rs {
+			e := &queue.Elem{}
+			if err := e.Decode(v.([]byte)); err != nil {
+				return err
+			}
+			if !fn(e) {
+				return nil
+			}
+		}
+		if len(rs) < scanPageSize {
+			return nil
+		}
+	}
+}
+
+func (q *Queue) Purge() (int, error) {
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+	conn := q.pool.Get()
+	defer conn.Close()
+	// the inflight elems are in the front of the queue, keep them and trim the rest.
+	var inflight int
+	for start := 0; inflight == start; start += scanPageSize {
+		rs, err := redigo.Values(conn.Do("lrange", getKey(q.clientID), start, start+scanPageSize-1))
+		if err != nil {
+			return 0, wrapError(err)
+		}
+		for _, v := range rs {
+			e := &queue.Elem{}
+			if err := e.Decode(v.([]byte)); err != nil {
+				return 0, err
+			}
+			if e.ID() == 0 {
+				break
+			}
+			inflight++
+		}
+		if len(rs) < scanPageSize {
+			break
+		}
+	}
+	var err error
+	if inflight == 0 {
+		_, err = conn.Do("del", getKey(q.clientID), getQos0Key(q.clientID), getExpiryKey(q.clientID))
+	} else {
+		_, err = conn.Do("ltrim", getKey(q.clientID), 0, inflight-1)
+		if err == nil {
+			_, err = conn.Do("del", getQos0Key(q.clientID), getExpiryKey(q.clientID))
+		}
+	}
+	if err != nil {
+		return 0, wrapError(err)
+	}
+	n := q.len - inflight
+	q.len = inflight
+	q.notifier.NotifyMsgQueueAdded(-n)
+	return n, nil
+}
@@ -0,0 +1,264 @@
+package redis
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	redigo "github.com/gomodule/redigo/redis"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DrmagicE/gmqtt"
+	"github.com/DrmagicE/gmqtt/persistence/queue"
+	"github.com/DrmagicE/gmqtt/pkg/packets"
+)
+
+// fakeRedis implements the list and sorted set commands used by the queue.
+type fakeRedis struct {
+	lists map[string][][]byte
+	zsets map[string]map[string]float64
+	// cmds records the commands that have been executed.
+	cmds []string
+}
+
+func newFakeRedis() *fakeRedis {
+	return &fakeRedis{
+		lists: make(map[string][][]byte),
+		zsets: make(map[string]map[string]float64),
+	}
+}
+
+func (f *fakeRedis) pool() *redigo.Pool {
+	return &redigo.Pool{
+		Dial: func() (redigo.Conn, error) {
+			return &fakeConn{f: f}, nil
+		},
+	}
+}
+
+type fakeConn struct {
+	f       *fakeRedis
+	pending []interface{}
+}
+
+func (c *fakeConn) Close() error { return nil }
+func (c *fakeConn) Err() error   { return nil }
+func (c *fakeConn) Flush() error { return nil }
+func (c *fakeConn) Send(cmd string, args ...interface{}) error {
+	rs, err := c.Do(cmd, args...)
+	if err != nil {
+		return err
+	}
+	c.pending = append(c.pending, rs)
+	return nil
+}
+func (c *fakeConn) Receive() (interface{}, error) {
+	rs := c.pending[0]
+	c.pending = c.pending[1:]
+	return rs, nil
+}
+
+// listIndex returns the index of the list, or -1 if out of range.
+func listIndex(l [][]byte, i int) int {
+	if i < 0 {
+		i += len(l)
+	}
+	if i < 0 || i >= len(l) {
+		return -1
+	}
+	return i
+}
+
+func (c *fakeConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	f := c.f
+	if cmd == "" {
+		// flush the pending replies, see redigo.Conn.Do
+		c.pending = nil
+		return nil, nil
+	}
+	cmd = strings.ToLower(cmd)
+	f.cmds = append(f.cmds, cmd)
+	str := func(i int) string {
+		if b, ok := args[i].([]byte); ok {
+			return string(b)
+		}
+		return fmt.Sprint(args[i])
+	}
+	num := func(i int) int {
+		n, _ := strconv.Atoi(str(i))
+		return n
+	}
+	key := str(0)
+	l := f.lists[key]
+	switch cmd {
+	case "del":
+		for i := range args {
+			delete(f.lists, str(i))
+			delete(f.zsets, str(i))
+		}
+		return int64(1), nil
+	case "llen":
+		return int64(len(l)), nil
+	case "rpush":
+		f.lists[key] = append(l, []byte(str(1)))
+		return int64(len(f.lists[key])), nil
+	case "lindex":
+		if i := listIndex(l, num(1)); i >= 0 {
+			return l[i], nil
+		}
+		return nil, nil
+	case "lset":
+		l[listIndex(l, num(1))] = []byte(str(2))
+		return "OK", nil
+	case "lrange":
+		var rs []interface{}
+		for i := num(1); i <= num(2) && i < len(l); i++ {
+			rs = append(rs, l[i])
+		}
+		return rs, nil
+	case "ltrim":
+		f.lists[key] = l[num(1) : num(2)+1]
+		return "OK", nil
+	case "lrem":
+		for i, v := range l {
+			if bytes.Equal(v, []byte(str(2))) {
+				f.lists[key] = append(l[:i:i], l[i+1:]...)
+				return int64(1), nil
+			}
+		}
+		return int64(0), nil
+	case "zadd":
+		if f.zsets[key] == nil {
+			f.zsets[key] = make(map[string]float64)
+		}
+		f.zsets[key][str(2)] = float64(num(1))
+		return int64(1), nil
+	case "zrem":
+		delete(f.zsets[key], str(1))
+		return int64(1), nil
+	case "zrangebyscore":
+		var members []string
+		for m, score := range f.zsets[key] {
+			if score <= float64(num(2)) {
+				members = append(members, m)
+			}
+		}
+		sort.Slice(members, func(i, j int) bool {
+			return f.zsets[key][members[i]] < f.zsets[key][members[j]]
+		})
+		var rs []interface{}
+		for i := 0; i < len(members) && i < num(5); i++ {
+			rs = append(rs, []byte(members[i]))
+		}
+		return rs, nil
+	}
+	return nil, fmt.Errorf("unknown command: %s", cmd)
+}
+
+type testNotifier struct {
+	dropped []*queue.Elem
+	errs    []error
+}
+
+func (t *testNotifier) NotifyDropped(elem *queue.Elem, err error) {
+	t.dropped = append(t.dropped, elem)
+	t.errs = append(t.errs, err)
+}
+func (t *testNotifier) NotifyInflightAdded(delta int) {}
+func (t *testNotifier) NotifyMsgQueueAdded(delta int) {}
+
+func newTestElem(topic string, qos uint8, expiry time.Time) *queue.Elem {
+	return &queue.Elem{
+		At:     time.Now(),
+		Expiry: expiry,
+		MessageWithID: &queue.Publish{
+			Message: &gmqtt.Message{
+				Topic: topic,
+				QoS:   qos,
+			},
+		},
+	}
+}
+
+func topics(f *fakeRedis, key string) (rs []string) {
+	for _, v := range f.lists[key] {
+		e := &queue.Elem{}
+		_ = e.Decode(v)
+		rs = append(rs, e.MessageWithID.(*queue.Publish).Topic)
+	}
+	return rs
+}
+
+func TestQueue_Add_full(t *testing.T) {
+	a := assert.New(t)
+	f := newFakeRedis()
+	n := &testNotifier{}
+	q, _ := New(Options{
+		MaxQueuedMsg:   4,
+		ClientID:       "cid",
+		InflightExpiry: time.Minute,
+		Pool:           f.pool(),
+	})
+	a.NoError(q.Init(&queue.InitOptions{
+		CleanStart:     true,
+		Version:        packets.Version5,
+		ReadBytesLimit: packets.MaximumSize,
+		Notifier:       n,
+	}))
+	_, err := q.ReadInflight(10)
+	a.NoError(err)
+	past := time.Now().Add(-time.Hour)
+	for _, v := range []*queue.Elem{
+		newTestElem("inflight", packets.Qos1, time.Time{}),
+		newTestElem("qos1", packets.Qos1, time.Time{}),
+		newTestElem("qos0", packets.Qos0, time.Time{}),
+		newTestElem("expired", packets.Qos1, past),
+	} {
+		a.NoError(q.Add(v))
+	}
+	elems, err := q.Read([]packets.PacketID{1})
+	a.NoError(err)
+	a.Len(elems, 1)
+	a.Equal([]string{"inflight", "qos1", "qos0", "expired"}, topics(f, getKey("cid")))
+	a.Equal([]string{"qos0"}, topics(f, getQos0Key("cid")))
+
+	// the expired message is dropped first, without scanning the queue, only the inflight message is read.
+	f.cmds = nil
+	a.NoError(q.Add(newTestElem("a", packets.Qos1, time.Time{})))
+	a.Equal([]string{"lrange", "zrangebyscore", "zrem", "lrem", "rpush"}, f.cmds)
+	a.Equal(queue.ErrDropExpired, n.errs[0])
+	a.Equal([]string{"inflight", "qos1", "qos0", "a"}, topics(f, getKey("cid")))
+	a.Empty(f.zsets[getExpiryKey("cid")])
+
+	// then the qos0 message.
+	a.NoError(q.Add(newTestElem("b", packets.Qos1, time.Time{})))
+	a.Equal(queue.ErrDropQueueFull, n.errs[1])
+	a.Equal("qos0", n.dropped[1].MessageWithID.(*queue.Publish).Topic)
+	a.Equal([]string{"inflight", "qos1", "a", "b"}, topics(f, getKey("cid")))
+	a.Empty(f.lists[getQos0Key("cid")])
+
+	// the new qos0 message is dropped if there is no qos0 message in the queue.
+	a.NoError(q.Add(newTestElem("c", packets.Qos0, time.Time{})))
+	a.Equal("c", n.dropped[2].MessageWithID.(*queue.Publish).Topic)
+	a.Equal([]string{"inflight", "qos1", "a", "b"}, topics(f, getKey("cid")))
+
+	// then the front non-inflight message.
+	a.NoError(q.Add(newTestElem("d", packets.Qos1, time.Time{})))
+	a.Equal("qos1", n.dropped[3].MessageWithID.(*queue.Publish).Topic)
+	a.Equal([]string{"inflight", "a", "b", "d"}, topics(f, getKey("cid")))
+
+	// the expired inflight message is dropped first.
+	inflight := newTestElem("inflight", packets.Qos1, past)
+	inflight.MessageWithID.SetID(1)
+	q.readCache[1] = inflight.Encode()
+	f.lists[getKey("cid")][0] = q.readCache[1]
+	a.NoError(q.Add(newTestElem("e", packets.Qos1, time.Time{})))
+	a.Equal(queue.ErrDropExpiredInflight, n.errs[4])
+	a.Equal([]string{"a", "b", "d", "e"}, topics(f, getKey("cid")))
+	a.Empty(q.readCache)
+	a.Equal(0, q.current)
+}
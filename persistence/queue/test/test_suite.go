@@ -217,6 +217,9 @@ func TestQueue(t *testing.T, store queue.Store) {
 	testCleanStart(a, store)
 	testReadExceedsDrop(a, store)
 	testClose(a, store)
+	if i, ok := store.(queue.Inspector); ok {
+		testInspect(a, store, i)
+	}
 }
 
 func testDrop(a *assert.Assertions, store queue.Store) {
@@ -670,3 +673,44 @@ func testClose(a *assert.Assertions, store queue.Store) {
 		a.Equal(queue.ErrClosed, r.err)
 	}
 }
+
+func testInspect(a *assert.Assertions, store queue.Store, inspector queue.Inspector) {
+	reconnect(a, true, store)
+	initNotifierLen()
+	a.NoError(add(store))
+	var elems []*queue.Elem
+	a.NoError(inspector.Inspect(func(elem *queue.Elem) bool {
+		elems = append(elems, elem)
+		return true
+	}))
+	a.Len(elems, len(initElems))
+	for k, v := range elems {
+		assertMsgEqual(a, initElems[k], v)
+	}
+
+	elems = nil
+	a.NoError(inspector.Inspect(func(elem *queue.Elem) bool {
+		elems = append(elems, elem)
+		return len(elems) < 2
+	}))
+	a.Len(elems, 2)
+
+	// only the inflight messages are kept.
+	n, err := inspector.Purge()
+	a.NoError(err)
+	a.Equal(3, n)
+	assertQueueLen(a, 2, 2)
+	elems = nil
+	a.NoError(inspector.Inspect(func(elem *queue.Elem) bool {
+		elems = append(elems, elem)
+		return true
+	}))
+	a.Len(elems, 2)
+	assertMsgEqual(a, initElems[0], elems[0])
+	assertMsgEqual(a, initElems[1], elems[1])
+	e, err := store.ReadInflight(10)
+	a.NoError(err)
+	a.Len(e, 2)
+	a.Len(TestNotifier.dropElem, 0)
+	initNotifierLen()
+}
@@ -7,15 +7,19 @@ import (
 	"testing"
 	"time"
 
+	"github.com/alicebob/miniredis/v2"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/suite"
 
+	"github.com/DrmagicE/gmqtt"
 	"github.com/DrmagicE/gmqtt/config"
+	"github.com/DrmagicE/gmqtt/persistence/queue"
 	queue_test "github.com/DrmagicE/gmqtt/persistence/queue/test"
 	sess_test "github.com/DrmagicE/gmqtt/persistence/session/test"
 	"github.com/DrmagicE/gmqtt/persistence/subscription"
 	sub_test "github.com/DrmagicE/gmqtt/persistence/subscription/test"
 	unack_test "github.com/DrmagicE/gmqtt/persistence/unack/test"
+	"github.com/DrmagicE/gmqtt/pkg/packets"
 	"github.com/DrmagicE/gmqtt/server"
 )
 
@@ -37,19 +41,33 @@ func init() {
 type RedisSuite struct {
 	suite.Suite
 	p server.Persistence
+	// miniredis indicates whether to run the tests against an in-process miniredis server instead of the redis container.
+	miniredis bool
+	mr        *miniredis.Miniredis
+	cfg       config.RedisPersistence
 }
 
 func (s *RedisSuite) SetupTest() {
-	_, err := runContainer()
-	if err != nil {
-		s.Suite.T().Fatalf("fail to start redis container: %s", err)
+	s.cfg = redisConfig
+	if s.miniredis {
+		mr, err := miniredis.Run()
+		if err != nil {
+			s.Suite.T().Fatalf("fail to start miniredis: %s", err)
+		}
+		s.mr = mr
+		s.cfg.Addr = mr.Addr()
+	} else {
+		_, err := runContainer()
+		if err != nil {
+			s.Suite.T().Fatalf("fail to start redis container: %s", err)
+		}
+		time.Sleep(2 * time.Second) // wait for redis start
 	}
-	time.Sleep(2 * time.Second) // wait for redis start
 
 	p, err := NewRedis(config.Config{
 		Persistence: config.Persistence{
 			Type:  config.PersistenceTypeRedis,
-			Redis: redisConfig,
+			Redis: s.cfg,
 		},
 	})
 	if err != nil {
@@ -62,14 +80,23 @@ func (s *RedisSuite) SetupTest() {
 	s.p = p
 }
 
+func (s *RedisSuite) TearDownTest() {
+	if s.mr != nil {
+		s.mr.Close()
+		s.mr = nil
+	}
+}
+
 func (s *RedisSuite) TearDownSuite() {
-	stopContainer()
+	if !s.miniredis {
+		stopContainer()
+	}
 }
 
 func (s *RedisSuite) TestQueue() {
 	a := assert.New(s.T())
 	cfg := queue_test.TestServerConfig
-	cfg.Persistence.Redis = redisConfig
+	cfg.Persistence.Redis = s.cfg
 	qs, err := s.p.NewQueueStore(cfg, queue_test.TestNotifier, queue_test.TestClientID)
 	a.Nil(err)
 	queue_test.TestQueue(s.T(), qs)
@@ -100,10 +127,95 @@ func (s *RedisSuite) TestUnack() {
 	unack_test.TestSuite(s.T(), st)
 }
 
+func (s *RedisSuite) TestInspector() {
+	a := assert.New(s.T())
+	cfg := config.Config{
+		Persistence: config.Persistence{
+			Type:  config.PersistenceTypeRedis,
+			Redis: s.cfg,
+		},
+	}
+	sessStore, err := s.p.NewSessionStore(cfg)
+	a.Nil(err)
+	a.Nil(sessStore.Set(&gmqtt.Session{ClientID: "id1", ConnectedAt: time.Unix(1000, 0), ExpiryInterval: 10}))
+	subStore, err := s.p.NewSubscriptionStore(cfg)
+	a.Nil(err)
+	_, err = subStore.Subscribe("id1", &gmqtt.Subscription{TopicFilter: "a/b", QoS: packets.Qos1})
+	a.Nil(err)
+	// the subscription without session.
+	_, err = subStore.Subscribe("id2", &gmqtt.Subscription{TopicFilter: "a/b", QoS: packets.Qos1})
+	a.Nil(err)
+
+	r := s.p.(*redis)
+	c := r.pool.Get()
+	defer c.Close()
+	_, err = c.Do("rpush", "queue:id1",
+		(&queue.Elem{At: time.Now(), MessageWithID: &queue.Publish{Message: &gmqtt.Message{Topic: "a/b", QoS: packets.Qos1, PacketID: 1}}}).Encode(),
+		(&queue.Elem{At: time.Now(), MessageWithID: &queue.Publish{Message: &gmqtt.Message{Topic: "a/b", QoS: packets.Qos1}}}).Encode(),
+		(&queue.Elem{At: time.Now(), MessageWithID: &queue.Pubrel{PacketID: 1}}).Encode(),
+		[]byte("corrupted"),
+	)
+	a.Nil(err)
+
+	ins, err := NewRedisInspector(cfg)
+	a.Nil(err)
+	defer ins.Close()
+	info, err := ins.Session("id1")
+	a.Nil(err)
+	a.Nil(info.Err)
+	a.Equal(uint32(10), info.Session.ExpiryInterval)
+	a.Equal(4, info.QueueLen)
+	a.Equal(1, info.SubscriptionLen)
+	info, err = ins.Session("id2")
+	a.Nil(err)
+	a.Nil(info)
+
+	var ids []string
+	a.Nil(ins.Sessions(func(info *SessionInfo) bool {
+		ids = append(ids, info.ClientID)
+		return true
+	}))
+	a.Equal([]string{"id1"}, ids)
+
+	subs, err := ins.Subscriptions("id1")
+	a.Nil(err)
+	a.Len(subs, 1)
+	a.Equal("a/b", subs[0].TopicFilter)
+
+	var elems int
+	var errs int
+	a.Nil(ins.Queue("id1", func(elem *queue.Elem, err error) bool {
+		if err != nil {
+			errs++
+		} else {
+			elems++
+		}
+		return true
+	}))
+	a.Equal(3, elems)
+	a.Equal(1, errs)
+
+	var problems []Problem
+	a.Nil(ins.Check(func(p Problem) {
+		problems = append(problems, p)
+	}))
+	a.Len(problems, 4)
+	a.Contains(problems, Problem{Key: "queue:id1", Description: "inflight elem 2 (packet id 1) is after the queued elems"})
+	a.Contains(problems, Problem{Key: "queue:id1", Description: "duplicated packet id 1 of elem 2"})
+	a.Contains(problems, Problem{Key: "sub:id2", Description: "session not found"})
+}
+
 func TestRedis(t *testing.T) {
+	if _, err := exec.LookPath("docker"); err != nil {
+		t.Skip("docker is not installed, the suite is covered by TestMiniredis")
+	}
 	suite.Run(t, &RedisSuite{})
 }
 
+func TestMiniredis(t *testing.T) {
+	suite.Run(t, &RedisSuite{miniredis: true})
+}
+
 func runContainer() (string, error) {
 	_ = exec.Command("/bin/sh", "-c", "docker rm -f gmqtt-testing").Run()
 	cmd := exec.Command("/bin/sh", "-c", "docker run -d --name gmqtt-testing -p 6379:6379 redis")
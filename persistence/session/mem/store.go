@@ -55,6 +55,18 @@ func (s *Store) SetSessionExpiry(clientID string, expiry uint32) error {
 	return nil
 }
 
+// SetAttributes replaces the session with a copy, because the session may be read by others concurrently.
+func (s *Store) SetAttributes(clientID string, attrs map[string]string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if sess, ok := s.sess[clientID]; ok {
+		c := *sess
+		c.Attributes = attrs
+		s.sess[clientID] = &c
+	}
+	return nil
+}
+
 func (s *Store) Iterate(fn session.IterateFn) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -2,6 +2,7 @@ package redis
 
 import (
 	"bytes"
+	"encoding/json"
 	"sync"
 	"time"
 
@@ -13,7 +14,8 @@ import (
 )
 
 const (
-	sessPrefix = "session:"
+	// KeyPrefix is the prefix of the redis keys of the sessions, the key is KeyPrefix + client id.
+	KeyPrefix = "session:"
 )
 
 var _ session.Store = (*Store)(nil)
@@ -31,7 +33,7 @@ func New(pool *redis.Pool) *Store {
 }
 
 func getKey(clientID string) string {
-	return sessPrefix + clientID
+	return KeyPrefix + clientID
 }
 func (s *Store) Set(session *gmqtt.Session) error {
 	s.mu.Lock()
@@ -40,16 +42,29 @@ func (s *Store) Set(session *gmqtt.Session) error {
 	defer c.Close()
 	b := &bytes.Buffer{}
 	encoding.EncodeMessage(session.Will, b)
-	_, err := c.Do("hset", getKey(session.ClientID),
+	attrs, err := encodeAttributes(session.Attributes)
+	if err != nil {
+		return err
+	}
+	_, err = c.Do("hset", getKey(session.ClientID),
 		"client_id", session.ClientID,
 		"will", b.Bytes(),
 		"will_delay_interval", session.WillDelayInterval,
 		"connected_at", session.ConnectedAt.Unix(),
 		"expiry_interval", session.ExpiryInterval,
+		"attributes", attrs,
 	)
 	return err
 }
 
+// encodeAttributes encodes the attributes in JSON, the empty attributes are encoded as empty bytes.
+func encodeAttributes(attrs map[string]string) ([]byte, error) {
+	if len(attrs) == 0 {
+		return []byte{}, nil
+	}
+	return json.Marshal(attrs)
+}
+
 func (s *Store) Remove(clientID string) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -67,18 +82,30 @@ func (s *Store) Get(clientID string) (*gmqtt.Session, error) {
 	return getSessionLocked(getKey(clientID), c)
 }
 
+// ReadSession reads the session of the redis key by the given connection,
+// which is used to inspect the redis database without the Store.
+func ReadSession(c redis.Conn, key string) (*gmqtt.Session, error) {
+	return getSessionLocked(key, c)
+}
+
 func getSessionLocked(key string, c redis.Conn) (*gmqtt.Session, error) {
-	replay, err := redis.Values(c.Do("hmget", key, "client_id", "will", "will_delay_interval", "connected_at", "expiry_interval"))
+	replay, err := redis.Values(c.Do("hmget", key, "client_id", "will", "will_delay_interval", "connected_at", "expiry_interval", "attributes"))
 	if err != nil {
 		return nil, err
 	}
 	sess := &gmqtt.Session{}
 	var connectedAt uint32
-	var will []byte
-	_, err = redis.Scan(replay, &sess.ClientID, &will, &sess.WillDelayInterval, &connectedAt, &sess.ExpiryInterval)
+	var will, attrs []byte
+	_, err = redis.Scan(replay, &sess.ClientID, &will, &sess.WillDelayInterval, &connectedAt, &sess.ExpiryInterval, &attrs)
 	if err != nil {
 		return nil, err
 	}
+	// the sessions stored by the old versions have no attributes field.
+	if len(attrs) != 0 {
+		if err = json.Unmarshal(attrs, &sess.Attributes); err != nil {
+			return nil, err
+		}
+	}
 	sess.ConnectedAt = time.Unix(int64(connectedAt), 0)
 	sess.Will, err = encoding.DecodeMessageFromBytes(will)
 	if err != nil {
@@ -98,6 +125,23 @@ func (s *Store) SetSessionExpiry(clientID string, expiry uint32) error {
 	return err
 }
 
+func (s *Store) SetAttributes(clientID string, attrs map[string]string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c := s.pool.Get()
+	defer c.Close()
+	exists, err := redis.Bool(c.Do("exists", getKey(clientID)))
+	if err != nil || !exists {
+		return err
+	}
+	b, err := encodeAttributes(attrs)
+	if err != nil {
+		return err
+	}
+	_, err = c.Do("hset", getKey(clientID), "attributes", b)
+	return err
+}
+
 func (s *Store) Iterate(fn session.IterateFn) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -105,7 +149,7 @@ func (s *Store) Iterate(fn session.IterateFn) error {
 	defer c.Close()
 	iter := 0
 	for {
-		arr, err := redis.Values(c.Do("SCAN", iter, "MATCH", sessPrefix+"*"))
+		arr, err := redis.Values(c.Do("SCAN", iter, "MATCH", KeyPrefix+"*"))
 		if err != nil {
 			return err
 		}
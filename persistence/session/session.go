@@ -14,4 +14,6 @@ type Store interface {
 	Get(clientID string) (*gmqtt.Session, error)
 	Iterate(fn IterateFn) error
 	SetSessionExpiry(clientID string, expiry uint32) error
+	// SetAttributes replaces the attributes of the session, it is a no-op if the session does not exist.
+	SetAttributes(clientID string, attrs map[string]string) error
 }
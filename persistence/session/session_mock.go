@@ -103,3 +103,17 @@ func (mr *MockStoreMockRecorder) SetSessionExpiry(clientID, expiry interface{})
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetSessionExpiry", reflect.TypeOf((*MockStore)(nil).SetSessionExpiry), clientID, expiry)
 }
+
+// SetAttributes mocks base method
+func (m *MockStore) SetAttributes(clientID string, attrs map[string]string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetAttributes", clientID, attrs)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetAttributes indicates an expected call of SetAttributes
+func (mr *MockStoreMockRecorder) SetAttributes(clientID, attrs interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetAttributes", reflect.TypeOf((*MockStore)(nil).SetAttributes), clientID, attrs)
+}
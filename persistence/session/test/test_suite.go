@@ -25,6 +25,7 @@ func TestSuite(t *testing.T, store session.Store) {
 			WillDelayInterval: 1,
 			ConnectedAt:       time.Unix(1, 0),
 			ExpiryInterval:    2,
+			Attributes:        map[string]string{"model": "m1", "firmware": "1.0.0"},
 		}, {
 			ClientID:          "client2",
 			Will:              nil,
@@ -48,4 +49,22 @@ func TestSuite(t *testing.T, store session.Store) {
 	})
 	a.Nil(err)
 	a.ElementsMatch(sess, tt)
+
+	attrs := map[string]string{"model": "m2"}
+	a.Nil(store.SetAttributes("client2", attrs))
+	s, err := store.Get("client2")
+	a.Nil(err)
+	a.Equal(attrs, s.Attributes)
+	a.Nil(store.SetAttributes("client2", nil))
+	s, err = store.Get("client2")
+	a.Nil(err)
+	a.Nil(s.Attributes)
+	// no-op for the non-existing session
+	a.Nil(store.SetAttributes("not_exist", attrs))
+	var n int
+	a.Nil(store.Iterate(func(session *gmqtt.Session) bool {
+		n++
+		return true
+	}))
+	a.Equal(len(tt), n)
 }
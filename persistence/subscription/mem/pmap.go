@@ -0,0 +1,234 @@
+package mem
+
+import (
+	"math/bits"
+)
+
+const (
+	pmapBits  = 5
+	pmapMask  = 1<<pmapBits - 1
+	pmapDepth = 64 // the bits of the hash
+)
+
+// pmap is a persistent hash map (hash array mapped trie) with string keys.
+// set and delete copy the nodes along the path of the key only and return a new map,
+// the old map remains unchanged, so it is safe to read it without locking.
+// The zero value is an empty map.
+type pmap struct {
+	root *pmapNode
+	size int
+}
+
+// pmapNode is the inner node of the map.
+// The node whose shift is greater than or equal to pmapDepth is a collision node,
+// which stores the entries of the same hash in a list.
+type pmapNode struct {
+	bitmap  uint32
+	entries []pmapEntry
+}
+
+// pmapEntry is either a leaf (node is nil) or a sub node.
+type pmapEntry struct {
+	node  *pmapNode
+	hash  uint64
+	key   string
+	value interface{}
+}
+
+func pmapHash(key string) uint64 {
+	// fnv-1a
+	h := uint64(14695981039346656037)
+	for i := 0; i < len(key); i++ {
+		h ^= uint64(key[i])
+		h *= 1099511628211
+	}
+	return h
+}
+
+func (m pmap) len() int {
+	return m.size
+}
+
+func (m pmap) get(key string) (interface{}, bool) {
+	hash := pmapHash(key)
+	n := m.root
+	for shift := uint(0); n != nil; shift += pmapBits {
+		if shift >= pmapDepth {
+			for _, e := range n.entries {
+				if e.key == key {
+					return e.value, true
+				}
+			}
+			return nil, false
+		}
+		bit := uint32(1) << ((hash >> shift) & pmapMask)
+		if n.bitmap&bit == 0 {
+			return nil, false
+		}
+		e := &n.entries[bits.OnesCount32(n.bitmap&(bit-1))]
+		if e.node == nil {
+			if e.key == key {
+				return e.value, true
+			}
+			return nil, false
+		}
+		n = e.node
+	}
+	return nil, false
+}
+
+// set returns a new map with the key set to v.
+func (m pmap) set(key string, v interface{}) pmap {
+	if m.root == nil {
+		m.root = &pmapNode{}
+	}
+	root, added := m.root.set(0, pmapEntry{hash: pmapHash(key), key: key, value: v})
+	m.root = root
+	if added {
+		m.size++
+	}
+	return m
+}
+
+func (n *pmapNode) set(shift uint, leaf pmapEntry) (*pmapNode, bool) {
+	if shift >= pmapDepth {
+		c := &pmapNode{entries: make([]pmapEntry, len(n.entries), len(n.entries)+1)}
+		copy(c.entries, n.entries)
+		for i := range c.entries {
+			if c.entries[i].key == leaf.key {
+				c.entries[i] = leaf
+				return c, false
+			}
+		}
+		c.entries = append(c.entries, leaf)
+		return c, true
+	}
+	bit := uint32(1) << ((leaf.hash >> shift) & pmapMask)
+	pos := bits.OnesCount32(n.bitmap & (bit - 1))
+	if n.bitmap&bit == 0 {
+		c := &pmapNode{bitmap: n.bitmap | bit, entries: make([]pmapEntry, len(n.entries)+1)}
+		copy(c.entries, n.entries[:pos])
+		c.entries[pos] = leaf
+		copy(c.entries[pos+1:], n.entries[pos:])
+		return c, true
+	}
+	c := &pmapNode{bitmap: n.bitmap, entries: make([]pmapEntry, len(n.entries))}
+	copy(c.entries, n.entries)
+	e := n.entries[pos]
+	switch {
+	case e.node != nil:
+		var added bool
+		c.entries[pos].node, added = e.node.set(shift+pmapBits, leaf)
+		return c, added
+	case e.key == leaf.key:
+		c.entries[pos] = leaf
+		return c, false
+	default:
+		c.entries[pos] = pmapEntry{node: mergeLeaves(shift+pmapBits, e, leaf)}
+		return c, true
+	}
+}
+
+// mergeLeaves returns the node which contains the two leaves of different keys.
+func mergeLeaves(shift uint, a, b pmapEntry) *pmapNode {
+	if shift >= pmapDepth {
+		return &pmapNode{entries: []pmapEntry{a, b}}
+	}
+	ia, ib := (a.hash>>shift)&pmapMask, (b.hash>>shift)&pmapMask
+	if ia == ib {
+		return &pmapNode{bitmap: 1 << ia, entries: []pmapEntry{{node: mergeLeaves(shift+pmapBits, a, b)}}}
+	}
+	if ia > ib {
+		a, b = b, a
+	}
+	return &pmapNode{bitmap: 1<<ia | 1<<ib, entries: []pmapEntry{a, b}}
+}
+
+// delete returns a new map without the key, and whether the key has been found.
+func (m pmap) delete(key string) (pmap, bool) {
+	if m.root == nil {
+		return m, false
+	}
+	root, found := m.root.delete(0, pmapHash(key), key)
+	if !found {
+		return m, false
+	}
+	m.root = root
+	m.size--
+	return m, true
+}
+
+// delete returns the new node which is nil if it becomes empty.
+func (n *pmapNode) delete(shift uint, hash uint64, key string) (*pmapNode, bool) {
+	if shift >= pmapDepth {
+		for i, e := range n.entries {
+			if e.key == key {
+				return n.without(i, 0), true
+			}
+		}
+		return n, false
+	}
+	bit := uint32(1) << ((hash >> shift) & pmapMask)
+	if n.bitmap&bit == 0 {
+		return n, false
+	}
+	pos := bits.OnesCount32(n.bitmap & (bit - 1))
+	e := n.entries[pos]
+	if e.node == nil {
+		if e.key != key {
+			return n, false
+		}
+		return n.without(pos, bit), true
+	}
+	child, found := e.node.delete(shift+pmapBits, hash, key)
+	if !found {
+		return n, false
+	}
+	if child == nil {
+		return n.without(pos, bit), true
+	}
+	c := &pmapNode{bitmap: n.bitmap, entries: make([]pmapEntry, len(n.entries))}
+	copy(c.entries, n.entries)
+	if len(child.entries) == 1 && child.entries[0].node == nil {
+		// pull up the only leaf of the child.
+		c.entries[pos] = child.entries[0]
+	} else {
+		c.entries[pos].node = child
+	}
+	return c, true
+}
+
+// without returns a copy of n without the entry at pos, or nil if no entry is left.
+func (n *pmapNode) without(pos int, bit uint32) *pmapNode {
+	if len(n.entries) == 1 {
+		return nil
+	}
+	c := &pmapNode{bitmap: n.bitmap &^ bit, entries: make([]pmapEntry, 0, len(n.entries)-1)}
+	c.entries = append(c.entries, n.entries[:pos]...)
+	c.entries = append(c.entries, n.entries[pos+1:]...)
+	return c
+}
+
+// iterate calls fn for each entry in the map, the iteration stops if fn returns false.
+// It returns false if the iteration is stopped by fn.
+func (m pmap) iterate(fn func(key string, v interface{}) bool) bool {
+	if m.root == nil {
+		return true
+	}
+	return m.root.iterate(fn)
+}
+
+func (n *pmapNode) iterate(fn func(key string, v interface{}) bool) bool {
+	for _, e := range n.entries {
+		if e.node != nil {
+			if !e.node.iterate(fn) {
+				return false
+			}
+			continue
+		}
+		if !fn(e.key, e.value) {
+			return false
+		}
+	}
+	return true
+}
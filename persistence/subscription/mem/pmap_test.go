@@ -0,0 +1,90 @@
+package mem
+
+import (
+	"math/rand"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func pmapToMap(m pmap) map[string]interface{} {
+	rs := make(map[string]interface{})
+	m.iterate(func(key string, v interface{}) bool {
+		rs[key] = v
+		return true
+	})
+	return rs
+}
+
+func TestPmap(t *testing.T) {
+	a := assert.New(t)
+	var m pmap
+	want := make(map[string]interface{})
+	r := rand.New(rand.NewSource(1))
+	var versions []pmap
+	var wants []map[string]interface{}
+	for i := 0; i < 5000; i++ {
+		key := strconv.Itoa(r.Intn(2000))
+		if r.Intn(3) == 0 {
+			var found bool
+			m, found = m.delete(key)
+			_, ok := want[key]
+			a.Equal(ok, found)
+			delete(want, key)
+		} else {
+			m = m.set(key, i)
+			want[key] = i
+		}
+		if i%500 == 0 {
+			versions = append(versions, m)
+			c := make(map[string]interface{}, len(want))
+			for k, v := range want {
+				c[k] = v
+			}
+			wants = append(wants, c)
+		}
+	}
+	a.Equal(len(want), m.len())
+	a.Equal(want, pmapToMap(m))
+	for k, v := range want {
+		got, ok := m.get(k)
+		a.True(ok)
+		a.Equal(v, got)
+	}
+	_, ok := m.get("unknown")
+	a.False(ok)
+	// the old versions must not be affected.
+	for i, v := range versions {
+		a.Equal(wants[i], pmapToMap(v))
+		a.Equal(len(wants[i]), v.len())
+	}
+	// delete all
+	for k := range want {
+		m, ok = m.delete(k)
+		a.True(ok)
+	}
+	a.Zero(m.len())
+	a.Nil(m.root)
+}
+
+func TestPmap_collision(t *testing.T) {
+	a := assert.New(t)
+	n := &pmapNode{}
+	for _, k := range []string{"a", "b", "c"} {
+		n, _ = n.set(0, pmapEntry{hash: 1, key: k, value: k})
+	}
+	m := pmap{root: n, size: 3}
+	a.Equal(map[string]interface{}{"a": "a", "b": "b", "c": "c"}, pmapToMap(m))
+
+	n, found := m.root.delete(0, 1, "b")
+	a.True(found)
+	n, found = n.delete(0, 1, "a")
+	a.True(found)
+	// the only leaf is pulled up.
+	a.Len(n.entries, 1)
+	a.Nil(n.entries[0].node)
+	a.Equal("c", n.entries[0].key)
+	_, found = n.delete(0, 1, "d")
+	a.False(found)
+}
@@ -10,20 +10,19 @@ import (
 // topicTrie
 type topicTrie = topicNode
 
-// children
-type children = map[string]*topicNode
-
-type clientOpts map[string]*gmqtt.Subscription
-
 // topicNode
+// The trie is immutable once it has been built, subscribe and unsubscribe copy the nodes along the path
+// and return a new root, which makes it safe to read the old trie without locking.
+// The children and the subscriptions of a node are stored in persistent maps, so that the copy does not grow
+// with the number of the children or the subscribers.
 type topicNode struct {
-	children children
-	// clients store non-share subscription
-	clients   clientOpts
-	parent    *topicNode // pointer of parent node
+	// children is the child nodes, key by the topic level, value is *topicNode.
+	children pmap
+	// clients store non-share subscription, key by client id, value is *gmqtt.Subscription.
+	clients   pmap
 	topicName string
-	// shared store shared subscription, key by ShareName
-	shared map[string]clientOpts
+	// shared store shared subscription, key by ShareName, value is the pmap of the subscriptions key by client id.
+	shared pmap
 }
 
 // newTopicTrie create a new trie tree
@@ -33,53 +32,96 @@ func newTopicTrie() *topicTrie {
 
 // newNode create a new trie node
 func newNode() *topicNode {
-	return &topicNode{
-		children: children{},
-		clients:  make(clientOpts),
-		shared:   make(map[string]clientOpts),
+	return &topicNode{}
+}
+
+// clone returns a shallow copy of t.
+func (t *topicNode) clone() *topicNode {
+	n := *t
+	return &n
+}
+
+func (t *topicNode) isEmpty() bool {
+	return t.clients.len() == 0 && t.shared.len() == 0 && t.children.len() == 0
+}
+
+// child returns the child node of the topic level, nil if not exists.
+func (t *topicNode) child(level string) *topicNode {
+	if v, ok := t.children.get(level); ok {
+		return v.(*topicNode)
 	}
+	return nil
 }
 
-// newChild create a child node of t
-func (t *topicNode) newChild() *topicNode {
-	n := newNode()
-	n.parent = t
-	return n
+// client returns the non-shared subscription of the client.
+func (t *topicNode) client(clientID string) (*gmqtt.Subscription, bool) {
+	if v, ok := t.clients.get(clientID); ok {
+		return v.(*gmqtt.Subscription), true
+	}
+	return nil, false
 }
 
-// subscribe add a subscription and return the added node
-func (t *topicTrie) subscribe(clientID string, s *gmqtt.Subscription) *topicNode {
-	topicSlice := strings.Split(s.TopicFilter, "/")
-	var pNode = t
-	for _, lv := range topicSlice {
-		if _, ok := pNode.children[lv]; !ok {
-			pNode.children[lv] = pNode.newChild()
-		}
-		pNode = pNode.children[lv]
+// sharedGroup returns the subscriptions of the share name.
+func (t *topicNode) sharedGroup(shareName string) pmap {
+	if v, ok := t.shared.get(shareName); ok {
+		return v.(pmap)
 	}
-	// shared subscription
-	if s.ShareName != "" {
-		if pNode.shared[s.ShareName] == nil {
-			pNode.shared[s.ShareName] = make(clientOpts)
+	return pmap{}
+}
+
+// iterateClients calls fn for each subscription in c, it returns false if the iteration is stopped by fn.
+func iterateClients(c pmap, fn subscription.IterateFn) bool {
+	return c.iterate(func(clientID string, v interface{}) bool {
+		return fn(clientID, v.(*gmqtt.Subscription))
+	})
+}
+
+// iterateShared calls fn for each shared subscription of the node.
+func (t *topicNode) iterateShared(fn subscription.IterateFn) bool {
+	return t.shared.iterate(func(_ string, v interface{}) bool {
+		return iterateClients(v.(pmap), fn)
+	})
+}
+
+// iterateAll calls fn for each subscription of the node, including the shared subscriptions.
+func (t *topicNode) iterateAll(fn subscription.IterateFn) bool {
+	return iterateClients(t.clients, fn) && t.iterateShared(fn)
+}
+
+// subscribe add a subscription and return the new trie.
+func (t *topicTrie) subscribe(clientID string, s *gmqtt.Subscription) *topicTrie {
+	return t.subscribeLevel(strings.Split(s.TopicFilter, "/"), clientID, s)
+}
+
+func (t *topicNode) subscribeLevel(topicSlice []string, clientID string, s *gmqtt.Subscription) *topicNode {
+	n := t.clone()
+	if len(topicSlice) == 0 {
+		// shared subscription
+		if s.ShareName != "" {
+			n.shared = t.shared.set(s.ShareName, t.sharedGroup(s.ShareName).set(clientID, s))
+		} else {
+			// non-shared
+			n.clients = t.clients.set(clientID, s)
 		}
-		pNode.shared[s.ShareName][clientID] = s
-	} else {
-		// non-shared
-		pNode.clients[clientID] = s
+		n.topicName = s.TopicFilter
+		return n
+	}
+	child := t.child(topicSlice[0])
+	if child == nil {
+		child = newNode()
 	}
-	pNode.topicName = s.TopicFilter
-	return pNode
+	n.children = t.children.set(topicSlice[0], child.subscribeLevel(topicSlice[1:], clientID, s))
+	return n
 }
 
 // find walk through the tire and return the node that represent the topicFilter.
 // Return nil if not found
 func (t *topicTrie) find(topicFilter string) *topicNode {
-	topicSlice := strings.Split(topicFilter, "/")
 	var pNode = t
-	for _, lv := range topicSlice {
-		if _, ok := pNode.children[lv]; ok {
-			pNode = pNode.children[lv]
-		} else {
+	for rest, last := topicFilter, false; !last; {
+		var lv string
+		lv, rest, last = nextLevel(rest)
+		if pNode = pNode.child(lv); pNode == nil {
 			return nil
 		}
 	}
@@ -89,83 +131,101 @@ func (t *topicTrie) find(topicFilter string) *topicNode {
 	return nil
 }
 
-// unsubscribe
-func (t *topicTrie) unsubscribe(clientID string, topicName string, shareName string) {
-	topicSlice := strings.Split(topicName, "/")
-	l := len(topicSlice)
-	var pNode = t
-	for _, lv := range topicSlice {
-		if _, ok := pNode.children[lv]; ok {
-			pNode = pNode.children[lv]
-		} else {
-			return
-		}
-	}
-	if shareName != "" {
-		if c := pNode.shared[shareName]; c != nil {
-			delete(c, clientID)
-			if len(pNode.shared[shareName]) == 0 {
-				delete(pNode.shared, shareName)
+// unsubscribe removes the subscription and return the new trie.
+func (t *topicTrie) unsubscribe(clientID string, topicName string, shareName string) *topicTrie {
+	n, _ := t.unsubscribeLevel(strings.Split(topicName, "/"), clientID, shareName)
+	return n
+}
+
+// unsubscribeLevel returns the new node and whether the subscription has been found.
+func (t *topicNode) unsubscribeLevel(topicSlice []string, clientID string, shareName string) (*topicNode, bool) {
+	if len(topicSlice) == 0 {
+		n := t.clone()
+		if shareName != "" {
+			c, ok := t.sharedGroup(shareName).delete(clientID)
+			if !ok {
+				return t, false
+			}
+			if c.len() == 0 {
+				n.shared, _ = t.shared.delete(shareName)
+			} else {
+				n.shared = t.shared.set(shareName, c)
 			}
-			if len(pNode.shared) == 0 && len(pNode.children) == 0 {
-				delete(pNode.parent.children, topicSlice[l-1])
+		} else {
+			var ok bool
+			if n.clients, ok = t.clients.delete(clientID); !ok {
+				return t, false
 			}
 		}
+		return n, true
+	}
+	child := t.child(topicSlice[0])
+	if child == nil {
+		return t, false
+	}
+	nc, found := child.unsubscribeLevel(topicSlice[1:], clientID, shareName)
+	if !found {
+		return t, false
+	}
+	n := t.clone()
+	if nc.isEmpty() {
+		n.children, _ = t.children.delete(topicSlice[0])
 	} else {
-		delete(pNode.clients, clientID)
-		if len(pNode.clients) == 0 && len(pNode.children) == 0 {
-			delete(pNode.parent.children, topicSlice[l-1])
-		}
+		n.children = t.children.set(topicSlice[0], nc)
 	}
-
+	return n, true
 }
 
 // setRs set the node subscription info into rs
 func setRs(node *topicNode, rs subscription.ClientSubscriptions) {
-	for cid, subOpts := range node.clients {
+	node.iterateAll(func(cid string, subOpts *gmqtt.Subscription) bool {
 		rs[cid] = append(rs[cid], subOpts)
-	}
+		return true
+	})
+}
 
-	for _, c := range node.shared {
-		for cid, subOpts := range c {
-			rs[cid] = append(rs[cid], subOpts)
-		}
+// nextLevel returns the first level of the topic and the remaining part.
+// last reports whether the returned level is the last level.
+// The levels are sliced from the original string to avoid allocations in the matching path.
+func nextLevel(topic string) (level, rest string, last bool) {
+	if i := strings.IndexByte(topic, '/'); i >= 0 {
+		return topic[:i], topic[i+1:], false
 	}
+	return topic, "", true
 }
 
-// matchTopic get all matched topic for given topicSlice, and set into rs
-func (t *topicTrie) matchTopic(topicSlice []string, rs subscription.ClientSubscriptions) {
-	endFlag := len(topicSlice) == 1
-	if cnode := t.children["#"]; cnode != nil {
+// matchTopic get all matched topic for given topicName, and set into rs
+func (t *topicTrie) matchTopic(topicName string, rs subscription.ClientSubscriptions) {
+	level, rest, endFlag := nextLevel(topicName)
+	if cnode := t.child("#"); cnode != nil {
 		setRs(cnode, rs)
 	}
-	if cnode := t.children["+"]; cnode != nil {
+	if cnode := t.child("+"); cnode != nil {
 		if endFlag {
 			setRs(cnode, rs)
-			if n := cnode.children["#"]; n != nil {
+			if n := cnode.child("#"); n != nil {
 				setRs(n, rs)
 			}
 		} else {
-			cnode.matchTopic(topicSlice[1:], rs)
+			cnode.matchTopic(rest, rs)
 		}
 	}
-	if cnode := t.children[topicSlice[0]]; cnode != nil {
+	if cnode := t.child(level); cnode != nil {
 		if endFlag {
 			setRs(cnode, rs)
-			if n := cnode.children["#"]; n != nil {
+			if n := cnode.child("#"); n != nil {
 				setRs(n, rs)
 			}
 		} else {
-			cnode.matchTopic(topicSlice[1:], rs)
+			cnode.matchTopic(rest, rs)
 		}
 	}
 }
 
 // getMatchedTopicFilter return a map key by clientID that contain all matched topic for the given topicName.
 func (t *topicTrie) getMatchedTopicFilter(topicName string) subscription.ClientSubscriptions {
-	topicLv := strings.Split(topicName, "/")
 	subs := make(subscription.ClientSubscriptions)
-	t.matchTopic(topicLv, subs)
+	t.matchTopic(topicName, subs)
 	return subs
 }
 
@@ -178,24 +238,11 @@ func (t *topicTrie) preOrderTraverse(fn subscription.IterateFn) bool {
 		return false
 	}
 	if t.topicName != "" {
-		for clientID, subOpts := range t.clients {
-			if !fn(clientID, subOpts) {
-				return false
-			}
-		}
-
-		for _, c := range t.shared {
-			for clientID, subOpts := range c {
-				if !fn(clientID, subOpts) {
-					return false
-				}
-			}
-		}
-	}
-	for _, c := range t.children {
-		if !c.preOrderTraverse(fn) {
+		if !t.iterateAll(fn) {
 			return false
 		}
 	}
-	return true
+	return t.children.iterate(func(_ string, v interface{}) bool {
+		return v.(*topicNode).preOrderTraverse(fn)
+	})
 }
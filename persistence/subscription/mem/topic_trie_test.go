@@ -203,7 +203,7 @@ func TestTopicTrie_matchedClients(t *testing.T) {
 	a := assert.New(t)
 	for _, v := range testTopicMatch {
 		trie := newTopicTrie()
-		trie.subscribe("cid", &gmqtt.Subscription{
+		trie = trie.subscribe("cid", &gmqtt.Subscription{
 			TopicFilter: v.subTopic,
 		})
 		qos := trie.getMatchedTopicFilter(v.topic)
@@ -221,7 +221,7 @@ func TestTopicTrie_matchedClients_Qos(t *testing.T) {
 	for _, v := range topicMatchQosTest {
 		trie := newTopicTrie()
 		for _, tt := range v.topics {
-			trie.subscribe("cid", &gmqtt.Subscription{
+			trie = trie.subscribe("cid", &gmqtt.Subscription{
 				TopicFilter: tt.Name,
 				QoS:         tt.Qos,
 			})
@@ -236,7 +236,7 @@ func TestTopicTrie_subscribeAndFind(t *testing.T) {
 	trie := newTopicTrie()
 	for cid, v := range testSubscribeAndFind.subTopics {
 		for _, topic := range v {
-			trie.subscribe(cid, &gmqtt.Subscription{
+			trie = trie.subscribe(cid, &gmqtt.Subscription{
 				TopicFilter: topic.Name,
 				QoS:         topic.Qos,
 			})
@@ -246,10 +246,11 @@ func TestTopicTrie_subscribeAndFind(t *testing.T) {
 		for _, tt := range v {
 			node := trie.find(tt.topicName)
 			if tt.exist {
-				a.Equal(tt.wantQos, node.clients[cid].QoS)
+				sub, _ := node.client(cid)
+				a.Equal(tt.wantQos, sub.QoS)
 			} else {
 				if node != nil {
-					_, ok := node.clients[cid]
+					_, ok := node.client(cid)
 					a.False(ok)
 				}
 			}
@@ -262,7 +263,7 @@ func TestTopicTrie_unsubscribe(t *testing.T) {
 	trie := newTopicTrie()
 	for cid, v := range testUnsubscribe.subTopics {
 		for _, topic := range v {
-			trie.subscribe(cid, &gmqtt.Subscription{
+			trie = trie.subscribe(cid, &gmqtt.Subscription{
 				TopicFilter: topic.Name,
 				QoS:         topic.Qos,
 			})
@@ -270,7 +271,7 @@ func TestTopicTrie_unsubscribe(t *testing.T) {
 	}
 	for cid, v := range testUnsubscribe.unsubscribe {
 		for _, tt := range v {
-			trie.unsubscribe(cid, tt, "")
+			trie = trie.unsubscribe(cid, tt, "")
 		}
 	}
 	for cid, v := range testUnsubscribe.afterUnsub {
@@ -289,7 +290,7 @@ func TestTopicTrie_preOrderTraverse(t *testing.T) {
 	a := assert.New(t)
 	trie := newTopicTrie()
 	for _, v := range testPreOrderTraverse.topics {
-		trie.subscribe(testPreOrderTraverse.clientID, &gmqtt.Subscription{
+		trie = trie.subscribe(testPreOrderTraverse.clientID, &gmqtt.Subscription{
 			TopicFilter: v.Name,
 			QoS:         v.Qos,
 		})
@@ -307,3 +308,49 @@ func TestTopicTrie_preOrderTraverse(t *testing.T) {
 	})
 	a.ElementsMatch(testPreOrderTraverse.topics, rs)
 }
+
+func TestTopicTrie_copyOnWrite(t *testing.T) {
+	a := assert.New(t)
+	trie := newTopicTrie()
+	trie = trie.subscribe("cid1", &gmqtt.Subscription{
+		TopicFilter: "a/b",
+		QoS:         packets.Qos1,
+	})
+	snapshot := trie
+	trie = trie.subscribe("cid2", &gmqtt.Subscription{
+		TopicFilter: "a/+",
+		QoS:         packets.Qos2,
+	})
+	trie = trie.unsubscribe("cid1", "a/b", "")
+
+	// the old trie must not be affected.
+	rs := snapshot.getMatchedTopicFilter("a/b")
+	a.Len(rs, 1)
+	a.EqualValues(packets.Qos1, rs["cid1"][0].QoS)
+
+	rs = trie.getMatchedTopicFilter("a/b")
+	a.Len(rs, 1)
+	a.EqualValues(packets.Qos2, rs["cid2"][0].QoS)
+	// empty node should be removed
+	a.Nil(trie.child("a").child("b"))
+}
+
+func TestNextLevel(t *testing.T) {
+	a := assert.New(t)
+	var levels []string
+	for rest, last := "/a//b/", false; !last; {
+		var lv string
+		lv, rest, last = nextLevel(rest)
+		levels = append(levels, lv)
+	}
+	a.Equal([]string{"", "a", "", "b", ""}, levels)
+}
+
+func TestTopicTrie_find_allocs(t *testing.T) {
+	a := assert.New(t)
+	trie := newTopicTrie().subscribe("cid", &gmqtt.Subscription{TopicFilter: "a/+/c/#"})
+	allocs := testing.AllocsPerRun(100, func() {
+		a.NotNil(trie.find("a/+/c/#"))
+	})
+	a.Zero(allocs)
+}
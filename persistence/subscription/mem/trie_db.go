@@ -3,6 +3,7 @@ package mem
 import (
 	"strings"
 	"sync"
+	"sync/atomic"
 
 	"github.com/DrmagicE/gmqtt"
 	"github.com/DrmagicE/gmqtt/persistence/subscription"
@@ -11,25 +12,46 @@ import (
 var _ subscription.Store = (*TrieDB)(nil)
 
 // TrieDB implement the subscription.Interface, it use trie tree to store topics.
+// The tries are copy-on-write, every modification publishes a new snapshot of the tries,
+// so that the topic matching process (Iterate with TopicName) can read the snapshot without holding the lock.
 type TrieDB struct {
 	sync.RWMutex
-	userIndex map[string]map[string]*topicNode // [clientID][topicFilter]
+	userIndex map[string]map[string]*gmqtt.Subscription // [clientID][topicFilter]
 	userTrie  *topicTrie
 
 	// system topic which begin with "$"
-	systemIndex map[string]map[string]*topicNode // [clientID][topicFilter]
+	systemIndex map[string]map[string]*gmqtt.Subscription // [clientID][topicFilter]
 	systemTrie  *topicTrie
 
 	// shared subscription which begin with "$share"
-	sharedIndex map[string]map[string]*topicNode // [clientID][shareName/topicFilter]
+	sharedIndex map[string]map[string]*gmqtt.Subscription // [clientID][$share/shareName/topicFilter]
 	sharedTrie  *topicTrie
 
+	// snapshot stores the *tries that is used by lock-free readers.
+	snapshot atomic.Value
+
 	// statistics of the server and each client
 	stats       subscription.Stats
 	clientStats map[string]*subscription.Stats // [clientID]
 
 }
 
+// tries is an immutable snapshot of the tries.
+type tries struct {
+	user   *topicTrie
+	system *topicTrie
+	shared *topicTrie
+}
+
+// publishLocked publishes the current tries to lock-free readers.
+func (db *TrieDB) publishLocked() {
+	db.snapshot.Store(&tries{
+		user:   db.userTrie,
+		system: db.systemTrie,
+		shared: db.sharedTrie,
+	})
+}
+
 func (db *TrieDB) Init(clientIDs []string) error {
 	return nil
 }
@@ -38,7 +60,7 @@ func (db *TrieDB) Close() error {
 	return nil
 }
 
-func iterateShared(fn subscription.IterateFn, options subscription.IterationOptions, index map[string]map[string]*topicNode, trie *topicTrie) bool {
+func iterateShared(fn subscription.IterateFn, options subscription.IterationOptions, index map[string]map[string]*gmqtt.Subscription, trie *topicTrie) bool {
 	// 查询指定topicFilter
 	if options.TopicName != "" && options.MatchType == subscription.MatchName { //寻找指定topicName
 		var shareName string
@@ -54,21 +76,16 @@ func iterateShared(fn subscription.IterateFn, options subscription.IterationOpti
 		if node == nil {
 			return true
 		}
+		c := node.sharedGroup(shareName)
 		if options.ClientID != "" { // 指定topicName & 指定clientID
-			if c := node.shared[shareName]; c != nil {
-				if sub, ok := c[options.ClientID]; ok {
-					if !fn(options.ClientID, sub) {
-						return false
-					}
+			if sub, ok := c.get(options.ClientID); ok {
+				if !fn(options.ClientID, sub.(*gmqtt.Subscription)) {
+					return false
 				}
 			}
 		} else {
-			if c := node.shared[shareName]; c != nil {
-				for clientID, sub := range c {
-					if !fn(clientID, sub) {
-						return false
-					}
-				}
+			if !iterateClients(c, fn) {
+				return false
 			}
 		}
 		return true
@@ -98,13 +115,9 @@ func iterateShared(fn subscription.IterateFn, options subscription.IterationOpti
 	}
 	// 查询指定clientID下的所有topic
 	if options.ClientID != "" {
-		for _, v := range index[options.ClientID] {
-			for _, c := range v.shared {
-				if sub, ok := c[options.ClientID]; ok {
-					if !fn(options.ClientID, sub) {
-						return false
-					}
-				}
+		for _, sub := range index[options.ClientID] {
+			if !fn(options.ClientID, sub) {
+				return false
 			}
 		}
 		return true
@@ -113,7 +126,7 @@ func iterateShared(fn subscription.IterateFn, options subscription.IterationOpti
 	return trie.preOrderTraverse(fn)
 }
 
-func iterateNonShared(fn subscription.IterateFn, options subscription.IterationOptions, index map[string]map[string]*topicNode, trie *topicTrie) bool {
+func iterateNonShared(fn subscription.IterateFn, options subscription.IterationOptions, index map[string]map[string]*gmqtt.Subscription, trie *topicTrie) bool {
 	// 查询指定topicFilter
 	if options.TopicName != "" && options.MatchType == subscription.MatchName { //寻找指定topicName
 		node := trie.find(options.TopicName)
@@ -121,33 +134,26 @@ func iterateNonShared(fn subscription.IterateFn, options subscription.IterationO
 			return true
 		}
 		if options.ClientID != "" { // 指定topicName & 指定clientID
-			if sub, ok := node.clients[options.ClientID]; ok {
+			if sub, ok := node.client(options.ClientID); ok {
 				if !fn(options.ClientID, sub) {
 					return false
 				}
 			}
 
-			for _, v := range node.shared {
-				if sub, ok := v[options.ClientID]; ok {
-					if !fn(options.ClientID, sub) {
-						return false
-					}
+			cont := node.shared.iterate(func(_ string, v interface{}) bool {
+				if sub, ok := v.(pmap).get(options.ClientID); ok {
+					return fn(options.ClientID, sub.(*gmqtt.Subscription))
 				}
+				return true
+			})
+			if !cont {
+				return false
 			}
 
 		} else {
 			// 指定topic name 不指定clientid
-			for clientID, sub := range node.clients {
-				if !fn(clientID, sub) {
-					return false
-				}
-			}
-			for _, c := range node.shared {
-				for clientID, sub := range c {
-					if !fn(clientID, sub) {
-						return false
-					}
-				}
+			if !node.iterateAll(fn) {
+				return false
 			}
 
 		}
@@ -178,8 +184,7 @@ func iterateNonShared(fn subscription.IterateFn, options subscription.IterationO
 	}
 	// 查询指定clientID下的所有topic
 	if options.ClientID != "" {
-		for _, v := range index[options.ClientID] {
-			sub := v.clients[options.ClientID]
+		for _, sub := range index[options.ClientID] {
 			if !fn(options.ClientID, sub) {
 				return false
 			}
@@ -193,15 +198,23 @@ func iterateNonShared(fn subscription.IterateFn, options subscription.IterationO
 
 // IterateLocked is the non thread-safe version of Iterate
 func (db *TrieDB) IterateLocked(fn subscription.IterateFn, options subscription.IterationOptions) {
+	db.iterate(fn, options, &tries{
+		user:   db.userTrie,
+		system: db.systemTrie,
+		shared: db.sharedTrie,
+	})
+}
+
+func (db *TrieDB) iterate(fn subscription.IterateFn, options subscription.IterationOptions, t *tries) {
 	if options.Type&subscription.TypeShared == subscription.TypeShared {
-		if !iterateShared(fn, options, db.sharedIndex, db.sharedTrie) {
+		if !iterateShared(fn, options, db.sharedIndex, t.shared) {
 			return
 		}
 	}
 	if options.Type&subscription.TypeNonShared == subscription.TypeNonShared {
 		// The Server MUST NOT match Topic Filters starting with a wildcard character (# or +) with Topic Names beginning with a $ character [MQTT-4.7.2-1]
 		if !(options.TopicName != "" && isSystemTopic(options.TopicName)) {
-			if !iterateNonShared(fn, options, db.userIndex, db.userTrie) {
+			if !iterateNonShared(fn, options, db.userIndex, t.user) {
 				return
 			}
 		}
@@ -210,12 +223,19 @@ func (db *TrieDB) IterateLocked(fn subscription.IterateFn, options subscription.
 		if options.TopicName != "" && !isSystemTopic(options.TopicName) {
 			return
 		}
-		if !iterateNonShared(fn, options, db.systemIndex, db.systemTrie) {
+		if !iterateNonShared(fn, options, db.systemIndex, t.system) {
 			return
 		}
 	}
 }
+
+// Iterate iterates all subscriptions.
+// If options.TopicName is set, the iteration only reads the trie snapshot and does not acquire the lock.
 func (db *TrieDB) Iterate(fn subscription.IterateFn, options subscription.IterationOptions) {
+	if options.TopicName != "" {
+		db.iterate(fn, options, db.snapshot.Load().(*tries))
+		return
+	}
 	db.RLock()
 	defer db.RUnlock()
 	db.IterateLocked(fn, options)
@@ -250,45 +270,47 @@ func (db *TrieDB) GetClientStats(clientID string) (subscription.Stats, error) {
 
 // NewStore create a new TrieDB instance
 func NewStore() *TrieDB {
-	return &TrieDB{
-		userIndex: make(map[string]map[string]*topicNode),
+	db := &TrieDB{
+		userIndex: make(map[string]map[string]*gmqtt.Subscription),
 		userTrie:  newTopicTrie(),
 
-		systemIndex: make(map[string]map[string]*topicNode),
+		systemIndex: make(map[string]map[string]*gmqtt.Subscription),
 		systemTrie:  newTopicTrie(),
 
-		sharedIndex: make(map[string]map[string]*topicNode),
+		sharedIndex: make(map[string]map[string]*gmqtt.Subscription),
 		sharedTrie:  newTopicTrie(),
 
 		clientStats: make(map[string]*subscription.Stats),
 	}
+	db.publishLocked()
+	return db
 }
 
 // SubscribeLocked is the non thread-safe version of Subscribe
 func (db *TrieDB) SubscribeLocked(clientID string, subscriptions ...*gmqtt.Subscription) subscription.SubscribeResult {
-	var node *topicNode
-	var index map[string]map[string]*topicNode
+	var index map[string]map[string]*gmqtt.Subscription
 	rs := make(subscription.SubscribeResult, len(subscriptions))
 	for k, sub := range subscriptions {
 		topicName := sub.TopicFilter
 		rs[k].Subscription = sub
 		if sub.ShareName != "" {
-			node = db.sharedTrie.subscribe(clientID, sub)
+			db.sharedTrie = db.sharedTrie.subscribe(clientID, sub)
 			index = db.sharedIndex
 		} else if isSystemTopic(topicName) {
-			node = db.systemTrie.subscribe(clientID, sub)
+			db.systemTrie = db.systemTrie.subscribe(clientID, sub)
 			index = db.systemIndex
 		} else {
-			node = db.userTrie.subscribe(clientID, sub)
+			db.userTrie = db.userTrie.subscribe(clientID, sub)
 			index = db.userIndex
 		}
 		if index[clientID] == nil {
-			index[clientID] = make(map[string]*topicNode)
+			index[clientID] = make(map[string]*gmqtt.Subscription)
 			if db.clientStats[clientID] == nil {
 				db.clientStats[clientID] = &subscription.Stats{}
 			}
 		}
-		if _, ok := index[clientID][topicName]; !ok {
+		key := sub.GetFullTopicName()
+		if _, ok := index[clientID][key]; !ok {
 			db.stats.SubscriptionsTotal++
 			db.stats.SubscriptionsCurrent++
 			db.clientStats[clientID].SubscriptionsTotal++
@@ -296,8 +318,9 @@ func (db *TrieDB) SubscribeLocked(clientID string, subscriptions ...*gmqtt.Subsc
 		} else {
 			rs[k].AlreadyExisted = true
 		}
-		index[clientID][topicName] = node
+		index[clientID][key] = sub
 	}
+	db.publishLocked()
 	return rs
 }
 
@@ -310,30 +333,28 @@ func (db *TrieDB) Subscribe(clientID string, subscriptions ...*gmqtt.Subscriptio
 
 // UnsubscribeLocked is the non thread-safe version of Unsubscribe
 func (db *TrieDB) UnsubscribeLocked(clientID string, topics ...string) {
-	var index map[string]map[string]*topicNode
-	var topicTrie *topicTrie
-	for _, topic := range topics {
-		var shareName string
-		shareName, topic := subscription.SplitTopic(topic)
+	var index map[string]map[string]*gmqtt.Subscription
+	for _, fullTopic := range topics {
+		shareName, topic := subscription.SplitTopic(fullTopic)
 		if shareName != "" {
-			topicTrie = db.sharedTrie
+			db.sharedTrie = db.sharedTrie.unsubscribe(clientID, topic, shareName)
 			index = db.sharedIndex
 		} else if isSystemTopic(topic) {
+			db.systemTrie = db.systemTrie.unsubscribe(clientID, topic, shareName)
 			index = db.systemIndex
-			topicTrie = db.systemTrie
 		} else {
+			db.userTrie = db.userTrie.unsubscribe(clientID, topic, shareName)
 			index = db.userIndex
-			topicTrie = db.userTrie
 		}
 		if _, ok := index[clientID]; ok {
-			if _, ok := index[clientID][topic]; ok {
+			if _, ok := index[clientID][fullTopic]; ok {
 				db.stats.SubscriptionsCurrent--
 				db.clientStats[clientID].SubscriptionsCurrent--
 			}
-			delete(index[clientID], topic)
+			delete(index[clientID], fullTopic)
 		}
-		topicTrie.unsubscribe(clientID, topic, shareName)
 	}
+	db.publishLocked()
 }
 
 // Unsubscribe remove subscriptions for the client
@@ -344,26 +365,24 @@ func (db *TrieDB) Unsubscribe(clientID string, topics ...string) error {
 	return nil
 }
 
-func (db *TrieDB) unsubscribeAll(index map[string]map[string]*topicNode, clientID string) {
+func (db *TrieDB) unsubscribeAll(index map[string]map[string]*gmqtt.Subscription, trie *topicTrie, clientID string) *topicTrie {
 	db.stats.SubscriptionsCurrent -= uint64(len(index[clientID]))
 	if db.clientStats[clientID] != nil {
 		db.clientStats[clientID].SubscriptionsCurrent -= uint64(len(index[clientID]))
 	}
-	for topicName, node := range index[clientID] {
-		delete(node.clients, clientID)
-		if len(node.clients) == 0 && len(node.children) == 0 {
-			ss := strings.Split(topicName, "/")
-			delete(node.parent.children, ss[len(ss)-1])
-		}
+	for _, sub := range index[clientID] {
+		trie = trie.unsubscribe(clientID, sub.TopicFilter, sub.ShareName)
 	}
 	delete(index, clientID)
+	return trie
 }
 
 // UnsubscribeAllLocked is the non thread-safe version of UnsubscribeAll
 func (db *TrieDB) UnsubscribeAllLocked(clientID string) {
-	db.unsubscribeAll(db.userIndex, clientID)
-	db.unsubscribeAll(db.systemIndex, clientID)
-	db.unsubscribeAll(db.sharedIndex, clientID)
+	db.userTrie = db.unsubscribeAll(db.userIndex, db.userTrie, clientID)
+	db.systemTrie = db.unsubscribeAll(db.systemIndex, db.systemTrie, clientID)
+	db.sharedTrie = db.unsubscribeAll(db.sharedIndex, db.sharedTrie, clientID)
+	db.publishLocked()
 }
 
 // UnsubscribeAll delete all subscriptions of the client
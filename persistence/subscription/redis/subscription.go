@@ -11,10 +11,12 @@ import (
 	"github.com/DrmagicE/gmqtt/persistence/encoding"
 	"github.com/DrmagicE/gmqtt/persistence/subscription"
 	"github.com/DrmagicE/gmqtt/persistence/subscription/mem"
+	"github.com/DrmagicE/gmqtt/pkg/packets"
 )
 
 const (
-	subPrefix = "sub:"
+	// KeyPrefix is the prefix of the redis keys of the subscriptions, the key is KeyPrefix + client id.
+	KeyPrefix = "sub:"
 )
 
 var _ subscription.Store = (*sub)(nil)
@@ -28,6 +30,14 @@ func EncodeSubscription(sub *gmqtt.Subscription) []byte {
 	encoding.WriteBool(w, sub.NoLocal)
 	encoding.WriteBool(w, sub.RetainAsPublished)
 	w.WriteByte(sub.RetainHandling)
+	// the user properties are appended, so that the subscriptions encoded by the previous versions can be decoded.
+	if len(sub.UserProperties) != 0 {
+		encoding.WriteUint16(w, uint16(len(sub.UserProperties)))
+		for _, v := range sub.UserProperties {
+			encoding.WriteString(w, v.K)
+			encoding.WriteString(w, v.V)
+		}
+	}
 	return w.Bytes()
 }
 
@@ -64,6 +74,24 @@ func DecodeSubscription(b []byte) (*gmqtt.Subscription, error) {
 	if err != nil {
 		return nil, err
 	}
+	if r.Len() == 0 {
+		return sub, nil
+	}
+	n, err := encoding.ReadUint16(r)
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i < int(n); i++ {
+		k, err := encoding.ReadString(r)
+		if err != nil {
+			return nil, err
+		}
+		v, err := encoding.ReadString(r)
+		if err != nil {
+			return nil, err
+		}
+		sub.UserProperties = append(sub.UserProperties, packets.UserProperty{K: k, V: v})
+	}
 	return sub, nil
 }
 
@@ -91,7 +119,7 @@ func (s *sub) Init(clientIDs []string) error {
 	c := s.pool.Get()
 	defer c.Close()
 	for _, v := range clientIDs {
-		rs, err := redigo.Values(c.Do("hgetall", subPrefix+v))
+		rs, err := redigo.Values(c.Do("hgetall", KeyPrefix+v))
 		if err != nil {
 			return err
 		}
@@ -100,7 +128,7 @@ func (s *sub) Init(clientIDs []string) error {
 			if err != nil {
 				return err
 			}
-			s.memStore.SubscribeLocked(strings.TrimLeft(v, subPrefix), sub)
+			s.memStore.SubscribeLocked(strings.TrimLeft(v, KeyPrefix), sub)
 		}
 	}
 	return nil
@@ -118,7 +146,7 @@ func (s *sub) Subscribe(clientID string, subscriptions ...*gmqtt.Subscription) (
 	defer c.Close()
 	// hset sub:clientID topicFilter xxx
 	for _, v := range subscriptions {
-		err = c.Send("hset", subPrefix+clientID, subscription.GetFullTopicName(v.ShareName, v.TopicFilter), EncodeSubscription(v))
+		err = c.Send("hset", KeyPrefix+clientID, subscription.GetFullTopicName(v.ShareName, v.TopicFilter), EncodeSubscription(v))
 		if err != nil {
 			return nil, err
 		}
@@ -136,7 +164,7 @@ func (s *sub) Unsubscribe(clientID string, topics ...string) error {
 	defer s.mu.Unlock()
 	c := s.pool.Get()
 	defer c.Close()
-	_, err := c.Do("hdel", subPrefix+clientID, topics)
+	_, err := c.Do("hdel", KeyPrefix+clientID, topics)
 	if err != nil {
 		return err
 	}
@@ -149,7 +177,7 @@ func (s *sub) UnsubscribeAll(clientID string) error {
 	defer s.mu.Unlock()
 	c := s.pool.Get()
 	defer c.Close()
-	_, err := c.Do("del", subPrefix+clientID)
+	_, err := c.Do("del", KeyPrefix+clientID)
 	if err != nil {
 		return err
 	}
@@ -158,6 +186,11 @@ func (s *sub) UnsubscribeAll(clientID string) error {
 }
 
 func (s *sub) Iterate(fn subscription.IterateFn, options subscription.IterationOptions) {
+	// topic matching reads the copy-on-write snapshot of the memory store, no need to lock.
+	if options.TopicName != "" {
+		s.memStore.Iterate(fn, options)
+		return
+	}
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	s.memStore.IterateLocked(fn, options)
@@ -6,6 +6,7 @@ import (
 	"github.com/stretchr/testify/assert"
 
 	"github.com/DrmagicE/gmqtt"
+	"github.com/DrmagicE/gmqtt/pkg/packets"
 )
 
 func TestEncodeDecodeSubscription(t *testing.T) {
@@ -27,6 +28,13 @@ func TestEncodeDecodeSubscription(t *testing.T) {
 			NoLocal:           false,
 			RetainAsPublished: true,
 			RetainHandling:    1,
+		}, {
+			TopicFilter: "telemetry/#",
+			QoS:         1,
+			UserProperties: []packets.UserProperty{
+				{K: []byte("region"), V: []byte("eu")},
+				{K: []byte("region"), V: []byte("us")},
+			},
 		},
 	}
 
@@ -1,14 +1,19 @@
 package mem
 
 import (
+	"sync"
+
 	"github.com/DrmagicE/gmqtt/persistence/unack"
 	"github.com/DrmagicE/gmqtt/pkg/packets"
 )
 
 var _ unack.Store = (*Store)(nil)
+var _ unack.Inspector = (*Store)(nil)
 
 type Store struct {
-	clientID     string
+	clientID string
+	// mu guards unackpublish, because PacketIDs can be called by other goroutines.
+	mu           sync.Mutex
 	unackpublish map[packets.PacketID]struct{}
 }
 
@@ -24,6 +29,8 @@ func New(opts Options) *Store {
 }
 
 func (s *Store) Init(cleanStart bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	if cleanStart {
 		s.unackpublish = make(map[packets.PacketID]struct{})
 	}
@@ -31,6 +38,8 @@ func (s *Store) Init(cleanStart bool) error {
 }
 
 func (s *Store) Set(id packets.PacketID) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	if _, ok := s.unackpublish[id]; ok {
 		return true, nil
 	}
@@ -39,6 +48,18 @@ func (s *Store) Set(id packets.PacketID) (bool, error) {
 }
 
 func (s *Store) Remove(id packets.PacketID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	delete(s.unackpublish, id)
 	return nil
 }
+
+func (s *Store) PacketIDs() ([]packets.PacketID, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ids := make([]packets.PacketID, 0, len(s.unackpublish))
+	for k := range s.unackpublish {
+		ids = append(ids, k)
+	}
+	return ids, nil
+}
@@ -8,10 +8,12 @@ import (
 )
 
 const (
-	unackPrefix = "unack:"
+	// KeyPrefix is the prefix of the redis keys of the unacknowledged packet ids, the key is KeyPrefix + client id.
+	KeyPrefix = "unack:"
 )
 
 var _ unack.Store = (*Store)(nil)
+var _ unack.Inspector = (*Store)(nil)
 
 type Store struct {
 	clientID     string
@@ -33,7 +35,7 @@ func New(opts Options) *Store {
 }
 
 func getKey(clientID string) string {
-	return unackPrefix + clientID
+	return KeyPrefix + clientID
 }
 func (s *Store) Init(cleanStart bool) error {
 	if cleanStart {
@@ -73,3 +75,19 @@ func (s *Store) Remove(id packets.PacketID) error {
 	delete(s.unackpublish, id)
 	return nil
 }
+
+// PacketIDs reads the packet ids from redis rather than the cache, because the cache is not loaded in Init
+// and it must not be accessed by other goroutines.
+func (s *Store) PacketIDs() ([]packets.PacketID, error) {
+	c := s.pool.Get()
+	defer c.Close()
+	keys, err := redis.Ints(c.Do("hkeys", getKey(s.clientID)))
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]packets.PacketID, 0, len(keys))
+	for _, v := range keys {
+		ids = append(ids, packets.PacketID(v))
+	}
+	return ids, nil
+}
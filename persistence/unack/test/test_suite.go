@@ -50,5 +50,9 @@ func TestSuite(t *testing.T, store unack.Store) {
 		a.Nil(err)
 		a.False(rs)
 	}
-
+	if i, ok := store.(unack.Inspector); ok {
+		ids, err := i.PacketIDs()
+		a.Nil(err)
+		a.ElementsMatch([]packets.PacketID{1, 2, 3, 4, 5, 6, 7, 8, 9}, ids)
+	}
 }
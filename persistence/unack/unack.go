@@ -17,3 +17,10 @@ type Store interface {
 	// Remove removes the given id from store.
 	Remove(id packets.PacketID) error
 }
+
+// Inspector is an optional interface of Store, which provides the ability to inspect the store
+// for the operational debugging.
+type Inspector interface {
+	// PacketIDs returns the packet ids of the unacknowledged qos2 messages.
+	PacketIDs() ([]packets.PacketID, error)
+}
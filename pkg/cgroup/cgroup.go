@@ -0,0 +1,77 @@
+// Package cgroup reads the resource limits of the container that the process is running in.
+package cgroup
+
+import (
+	"errors"
+	"io/ioutil"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// ErrNoQuota is returned by CPUQuota when there is no cpu quota.
+var ErrNoQuota = errors.New("cgroup: no cpu quota")
+
+// parseCPUMax parses the content of the cgroup v2 cpu.max file, which is in the format of "$MAX $PERIOD".
+func parseCPUMax(content string) (float64, error) {
+	fields := strings.Fields(content)
+	if len(fields) == 0 || len(fields) > 2 {
+		return 0, errors.New("cgroup: invalid cpu.max format")
+	}
+	if fields[0] == "max" {
+		return 0, ErrNoQuota
+	}
+	quota, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return 0, err
+	}
+	period := 100000.0
+	if len(fields) == 2 {
+		period, err = strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			return 0, err
+		}
+	}
+	if period <= 0 {
+		return 0, errors.New("cgroup: invalid cpu period")
+	}
+	return quota / period, nil
+}
+
+// parseCFS parses the content of the cgroup v1 cpu.cfs_quota_us and cpu.cfs_period_us files.
+func parseCFS(quotaContent, periodContent string) (float64, error) {
+	quota, err := strconv.ParseFloat(strings.TrimSpace(quotaContent), 64)
+	if err != nil {
+		return 0, err
+	}
+	if quota < 0 {
+		return 0, ErrNoQuota
+	}
+	period, err := strconv.ParseFloat(strings.TrimSpace(periodContent), 64)
+	if err != nil {
+		return 0, err
+	}
+	if period <= 0 {
+		return 0, errors.New("cgroup: invalid cpu period")
+	}
+	return quota / period, nil
+}
+
+func readFile(name string) (string, error) {
+	b, err := ioutil.ReadFile(name)
+	return string(b), err
+}
+
+// MaxProcs returns the number of CPUs that the process is allowed to use according to the cpu quota,
+// rounded down and at least 1.
+func MaxProcs() (int, error) {
+	quota, err := CPUQuota()
+	if err != nil {
+		return 0, err
+	}
+	n := int(math.Floor(quota))
+	if n < 1 {
+		n = 1
+	}
+	return n, nil
+}
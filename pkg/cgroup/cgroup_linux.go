@@ -0,0 +1,37 @@
+package cgroup
+
+import (
+	"os"
+)
+
+const (
+	cpuMaxFile    = "/sys/fs/cgroup/cpu.max"
+	cfsQuotaFile  = "/sys/fs/cgroup/cpu/cpu.cfs_quota_us"
+	cfsPeriodFile = "/sys/fs/cgroup/cpu/cpu.cfs_period_us"
+)
+
+// CPUQuota returns the cpu quota in number of CPUs.
+// It returns ErrNoQuota if the process is not limited by the cgroup cpu controller.
+func CPUQuota() (float64, error) {
+	// cgroup v2
+	content, err := readFile(cpuMaxFile)
+	if err == nil {
+		return parseCPUMax(content)
+	}
+	if !os.IsNotExist(err) {
+		return 0, err
+	}
+	// cgroup v1
+	quota, err := readFile(cfsQuotaFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, ErrNoQuota
+		}
+		return 0, err
+	}
+	period, err := readFile(cfsPeriodFile)
+	if err != nil {
+		return 0, err
+	}
+	return parseCFS(quota, period)
+}
@@ -0,0 +1,9 @@
+// +build !linux
+
+package cgroup
+
+// CPUQuota returns the cpu quota in number of CPUs.
+// cgroup is only available on linux, so it always returns ErrNoQuota.
+func CPUQuota() (float64, error) {
+	return 0, ErrNoQuota
+}
@@ -0,0 +1,37 @@
+package cgroup
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseCPUMax(t *testing.T) {
+	a := assert.New(t)
+	q, err := parseCPUMax("200000 100000\n")
+	a.Nil(err)
+	a.Equal(2.0, q)
+
+	q, err = parseCPUMax("50000")
+	a.Nil(err)
+	a.Equal(0.5, q)
+
+	_, err = parseCPUMax("max 100000\n")
+	a.Equal(ErrNoQuota, err)
+
+	_, err = parseCPUMax("")
+	a.NotNil(err)
+}
+
+func TestParseCFS(t *testing.T) {
+	a := assert.New(t)
+	q, err := parseCFS("150000\n", "100000\n")
+	a.Nil(err)
+	a.Equal(1.5, q)
+
+	_, err = parseCFS("-1\n", "100000\n")
+	a.Equal(ErrNoQuota, err)
+
+	_, err = parseCFS("100000\n", "0\n")
+	a.NotNil(err)
+}
@@ -45,6 +45,7 @@ const (
 	ServerUnavailable           Code = 0x88
 	ServerBusy                  Code = 0x89
 	Banned                      Code = 0x8A
+	ServerShuttingDown          Code = 0x8B
 	BadAuthMethod               Code = 0x8C
 	KeepAliveTimeout            Code = 0x8D
 	SessionTakenOver            Code = 0x8E
@@ -98,3 +99,19 @@ func (e *Error) Error() string {
 func NewError(code Code) *Error {
 	return &Error{Code: code}
 }
+
+// WithReasonString sets the reason string and returns the error, e.g.
+//	codes.NewError(codes.NotAuthorized).WithReasonString("firmware too old").WithUserProperty("min-firmware", "2.1")
+func (e *Error) WithReasonString(reason string) *Error {
+	e.ReasonString = []byte(reason)
+	return e
+}
+
+// WithUserProperty appends the user property and returns the error.
+func (e *Error) WithUserProperty(k, v string) *Error {
+	e.UserProperties = append(e.UserProperties, struct {
+		K []byte
+		V []byte
+	}{K: []byte(k), V: []byte(v)})
+	return e
+}
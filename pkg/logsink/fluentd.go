@@ -0,0 +1,175 @@
+package logsink
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"net"
+	"sort"
+	"time"
+)
+
+// fluentd ships the records to the Fluent Forward endpoint in forward mode: [tag, [[time, record], ...]].
+type fluentd struct {
+	address string
+	tag     string
+	timeout time.Duration
+	conn    net.Conn
+}
+
+// NewFluentd returns the Shipper which ships the records to the Fluent Forward endpoint,
+// e.g. fluentd in_forward or fluent-bit forward input.
+func NewFluentd(address string, tag string, timeout time.Duration) Shipper {
+	return &fluentd{
+		address: address,
+		tag:     tag,
+		timeout: timeout,
+	}
+}
+
+func (f *fluentd) Name() string {
+	return "fluentd"
+}
+
+func (f *fluentd) Ship(records []Record) error {
+	b := &bytes.Buffer{}
+	encodeForward(b, f.tag, records)
+	if f.conn == nil {
+		conn, err := net.DialTimeout("tcp", f.address, f.timeout)
+		if err != nil {
+			return err
+		}
+		f.conn = conn
+	}
+	_ = f.conn.SetWriteDeadline(time.Now().Add(f.timeout))
+	_, err := f.conn.Write(b.Bytes())
+	if err != nil {
+		_ = f.Close()
+	}
+	return err
+}
+
+func (f *fluentd) Close() error {
+	if f.conn == nil {
+		return nil
+	}
+	err := f.conn.Close()
+	f.conn = nil
+	return err
+}
+
+// encodeForward encodes the records into the forward mode message.
+// The records which are not valid JSON objects are shipped as {"message": "<data>"}.
+func encodeForward(b *bytes.Buffer, tag string, records []Record) {
+	writeArrayLen(b, 2)
+	writeValue(b, tag)
+	writeArrayLen(b, len(records))
+	for _, r := range records {
+		var record map[string]interface{}
+		d := json.NewDecoder(bytes.NewReader(r.Data))
+		d.UseNumber()
+		if err := d.Decode(&record); err != nil || record == nil {
+			record = map[string]interface{}{
+				"message": string(bytes.TrimSpace(r.Data)),
+			}
+		}
+		writeArrayLen(b, 2)
+		writeValue(b, r.Time.Unix())
+		writeValue(b, record)
+	}
+}
+
+func writeArrayLen(b *bytes.Buffer, n int) {
+	switch {
+	case n < 16:
+		b.WriteByte(0x90 | byte(n))
+	case n <= math.MaxUint16:
+		b.WriteByte(0xdc)
+		writeUint(b, uint64(n), 2)
+	default:
+		b.WriteByte(0xdd)
+		writeUint(b, uint64(n), 4)
+	}
+}
+
+func writeMapLen(b *bytes.Buffer, n int) {
+	switch {
+	case n < 16:
+		b.WriteByte(0x80 | byte(n))
+	case n <= math.MaxUint16:
+		b.WriteByte(0xde)
+		writeUint(b, uint64(n), 2)
+	default:
+		b.WriteByte(0xdf)
+		writeUint(b, uint64(n), 4)
+	}
+}
+
+func writeUint(b *bytes.Buffer, v uint64, size int) {
+	for i := size - 1; i >= 0; i-- {
+		b.WriteByte(byte(v >> (uint(i) * 8)))
+	}
+}
+
+// writeValue encodes the value decoded by encoding/json into msgpack format.
+func writeValue(b *bytes.Buffer, v interface{}) {
+	switch v := v.(type) {
+	case nil:
+		b.WriteByte(0xc0)
+	case bool:
+		if v {
+			b.WriteByte(0xc3)
+		} else {
+			b.WriteByte(0xc2)
+		}
+	case int64:
+		if v >= 0 && v < 128 {
+			b.WriteByte(byte(v))
+			return
+		}
+		b.WriteByte(0xd3)
+		writeUint(b, uint64(v), 8)
+	case float64:
+		b.WriteByte(0xcb)
+		writeUint(b, math.Float64bits(v), 8)
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			writeValue(b, i)
+			return
+		}
+		f, _ := v.Float64()
+		writeValue(b, f)
+	case string:
+		n := len(v)
+		switch {
+		case n < 32:
+			b.WriteByte(0xa0 | byte(n))
+		case n <= math.MaxUint8:
+			b.WriteByte(0xd9)
+			writeUint(b, uint64(n), 1)
+		case n <= math.MaxUint16:
+			b.WriteByte(0xda)
+			writeUint(b, uint64(n), 2)
+		default:
+			b.WriteByte(0xdb)
+			writeUint(b, uint64(n), 4)
+		}
+		b.WriteString(v)
+	case []interface{}:
+		writeArrayLen(b, len(v))
+		for _, e := range v {
+			writeValue(b, e)
+		}
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		writeMapLen(b, len(keys))
+		for _, k := range keys {
+			writeValue(b, k)
+			writeValue(b, v[k])
+		}
+	}
+}
@@ -0,0 +1,406 @@
+package logsink
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+const (
+	kafkaClientID = "gmqtt"
+
+	kafkaAPIProduce  = 0
+	kafkaAPIMetadata = 3
+	// Produce v3 is the first version which supports the record batch (magic v2).
+	kafkaProduceVersion  = 3
+	kafkaMetadataVersion = 4
+)
+
+var crc32c = crc32.MakeTable(crc32.Castagnoli)
+
+// kafka is a minimal Kafka producer which produces the records to one partition of the topic with acks=1.
+type kafka struct {
+	brokers   []string
+	topic     string
+	partition int32
+	timeout   time.Duration
+
+	conn          net.Conn
+	correlationID int32
+}
+
+// NewKafka returns the Shipper which produces the records to the given partition of the Kafka topic.
+// The leader of the partition is discovered from the brokers.
+func NewKafka(brokers []string, topic string, partition int32, timeout time.Duration) Shipper {
+	return &kafka{
+		brokers:   brokers,
+		topic:     topic,
+		partition: partition,
+		timeout:   timeout,
+	}
+}
+
+func (k *kafka) Name() string {
+	return "kafka"
+}
+
+func (k *kafka) Close() error {
+	if k.conn == nil {
+		return nil
+	}
+	err := k.conn.Close()
+	k.conn = nil
+	return err
+}
+
+func (k *kafka) Ship(records []Record) error {
+	if k.conn == nil {
+		if err := k.connectLeader(); err != nil {
+			return err
+		}
+	}
+	err := k.produce(records)
+	if err != nil {
+		_ = k.Close()
+	}
+	return err
+}
+
+// connectLeader connects to the leader of the partition.
+func (k *kafka) connectLeader() error {
+	var lastErr error
+	for _, addr := range k.brokers {
+		conn, err := net.DialTimeout("tcp", addr, k.timeout)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		k.conn = conn
+		leader, err := k.leader()
+		if err != nil {
+			_ = k.Close()
+			lastErr = err
+			continue
+		}
+		if leader == addr {
+			return nil
+		}
+		_ = k.Close()
+		conn, err = net.DialTimeout("tcp", leader, k.timeout)
+		if err != nil {
+			return err
+		}
+		k.conn = conn
+		return nil
+	}
+	if lastErr == nil {
+		lastErr = errors.New("no brokers")
+	}
+	return lastErr
+}
+
+// leader returns the address of the partition leader by the metadata request.
+func (k *kafka) leader() (string, error) {
+	req := &kafkaEncoder{}
+	req.putArrayLen(1)
+	req.putString(k.topic)
+	// allow_auto_topic_creation
+	req.putInt8(0)
+	resp, err := k.roundTrip(kafkaAPIMetadata, kafkaMetadataVersion, req.Bytes())
+	if err != nil {
+		return "", err
+	}
+	d := &kafkaDecoder{b: resp}
+	// throttle_time_ms
+	d.int32()
+	brokers := make(map[int32]string)
+	for i, n := 0, d.arrayLen(); i < n; i++ {
+		nodeID := d.int32()
+		host := d.string()
+		port := d.int32()
+		// rack
+		d.string()
+		brokers[nodeID] = net.JoinHostPort(host, strconv.Itoa(int(port)))
+	}
+	// cluster_id, controller_id
+	d.string()
+	d.int32()
+	for i, n := 0, d.arrayLen(); i < n; i++ {
+		code := d.int16()
+		name := d.string()
+		// is_internal
+		d.int8()
+		for j, m := 0, d.arrayLen(); j < m; j++ {
+			// error_code
+			d.int16()
+			partition := d.int32()
+			leader := d.int32()
+			// replica_nodes, isr_nodes
+			for l := 0; l < 2; l++ {
+				for r, c := 0, d.arrayLen(); r < c; r++ {
+					d.int32()
+				}
+			}
+			if d.err != nil {
+				return "", d.err
+			}
+			if name != k.topic || partition != k.partition {
+				continue
+			}
+			if code != 0 {
+				return "", fmt.Errorf("metadata error code: %d", code)
+			}
+			if addr, ok := brokers[leader]; ok {
+				return addr, nil
+			}
+			return "", fmt.Errorf("leader of partition %d not available", k.partition)
+		}
+		if d.err == nil && name == k.topic && code != 0 {
+			return "", fmt.Errorf("metadata error code: %d", code)
+		}
+	}
+	if d.err != nil {
+		return "", d.err
+	}
+	return "", fmt.Errorf("partition %d of topic %s not found", k.partition, k.topic)
+}
+
+func (k *kafka) produce(records []Record) error {
+	req := &kafkaEncoder{}
+	// transactional_id
+	req.putInt16(-1)
+	// acks
+	req.putInt16(1)
+	req.putInt32(int32(k.timeout / time.Millisecond))
+	req.putArrayLen(1)
+	req.putString(k.topic)
+	req.putArrayLen(1)
+	req.putInt32(k.partition)
+	batch := encodeRecordBatch(records)
+	req.putInt32(int32(len(batch)))
+	req.Write(batch)
+	resp, err := k.roundTrip(kafkaAPIProduce, kafkaProduceVersion, req.Bytes())
+	if err != nil {
+		return err
+	}
+	d := &kafkaDecoder{b: resp}
+	for i, n := 0, d.arrayLen(); i < n; i++ {
+		d.string()
+		for j, m := 0, d.arrayLen(); j < m; j++ {
+			// index
+			d.int32()
+			code := d.int16()
+			// base_offset, log_append_time_ms
+			d.int64()
+			d.int64()
+			if d.err == nil && code != 0 {
+				return fmt.Errorf("produce error code: %d", code)
+			}
+		}
+	}
+	return d.err
+}
+
+// roundTrip sends the request and returns the response body.
+func (k *kafka) roundTrip(apiKey, apiVersion int16, body []byte) ([]byte, error) {
+	k.correlationID++
+	req := &kafkaEncoder{}
+	req.putInt32(0)
+	req.putInt16(apiKey)
+	req.putInt16(apiVersion)
+	req.putInt32(k.correlationID)
+	req.putString(kafkaClientID)
+	req.Write(body)
+	b := req.Bytes()
+	binary.BigEndian.PutUint32(b, uint32(len(b)-4))
+	_ = k.conn.SetDeadline(time.Now().Add(k.timeout))
+	if _, err := k.conn.Write(b); err != nil {
+		return nil, err
+	}
+	var size int32
+	if err := binary.Read(k.conn, binary.BigEndian, &size); err != nil {
+		return nil, err
+	}
+	if size < 4 {
+		return nil, fmt.Errorf("invalid response size: %d", size)
+	}
+	resp := make([]byte, size)
+	if _, err := io.ReadFull(k.conn, resp); err != nil {
+		return nil, err
+	}
+	if id := int32(binary.BigEndian.Uint32(resp)); id != k.correlationID {
+		return nil, fmt.Errorf("unexpected correlation id: %d", id)
+	}
+	return resp[4:], nil
+}
+
+// encodeRecordBatch encodes the records into the record batch (magic v2) without compression.
+func encodeRecordBatch(records []Record) []byte {
+	first := records[0].Time.UnixNano() / int64(time.Millisecond)
+	maxTS := first
+	rs := &kafkaEncoder{}
+	for i, r := range records {
+		ts := r.Time.UnixNano() / int64(time.Millisecond)
+		if ts > maxTS {
+			maxTS = ts
+		}
+		value := bytes.TrimRight(r.Data, "\n")
+		rec := &kafkaEncoder{}
+		// attributes
+		rec.putInt8(0)
+		rec.putVarint(ts - first)
+		rec.putVarint(int64(i))
+		// key
+		rec.putVarint(-1)
+		rec.putVarint(int64(len(value)))
+		rec.Write(value)
+		// headers
+		rec.putVarint(0)
+		rs.putVarint(int64(rec.Len()))
+		rs.Write(rec.Bytes())
+	}
+	// the part covered by the crc
+	body := &kafkaEncoder{}
+	// attributes
+	body.putInt16(0)
+	// last_offset_delta
+	body.putInt32(int32(len(records) - 1))
+	body.putInt64(first)
+	body.putInt64(maxTS)
+	// producer_id, producer_epoch, base_sequence
+	body.putInt64(-1)
+	body.putInt16(-1)
+	body.putInt32(-1)
+	body.putInt32(int32(len(records)))
+	body.Write(rs.Bytes())
+
+	b := &kafkaEncoder{}
+	// base_offset
+	b.putInt64(0)
+	// batch_length: partition_leader_epoch + magic + crc + body
+	b.putInt32(int32(4 + 1 + 4 + body.Len()))
+	// partition_leader_epoch
+	b.putInt32(-1)
+	// magic
+	b.putInt8(2)
+	b.putInt32(int32(crc32.Checksum(body.Bytes(), crc32c)))
+	b.Write(body.Bytes())
+	return b.Bytes()
+}
+
+type kafkaEncoder struct {
+	bytes.Buffer
+}
+
+func (e *kafkaEncoder) putInt8(v int8) {
+	e.WriteByte(byte(v))
+}
+
+func (e *kafkaEncoder) putInt16(v int16) {
+	var b [2]byte
+	binary.BigEndian.PutUint16(b[:], uint16(v))
+	e.Write(b[:])
+}
+
+func (e *kafkaEncoder) putInt32(v int32) {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], uint32(v))
+	e.Write(b[:])
+}
+
+func (e *kafkaEncoder) putInt64(v int64) {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], uint64(v))
+	e.Write(b[:])
+}
+
+func (e *kafkaEncoder) putArrayLen(n int) {
+	e.putInt32(int32(n))
+}
+
+func (e *kafkaEncoder) putString(s string) {
+	e.putInt16(int16(len(s)))
+	e.WriteString(s)
+}
+
+// putVarint writes the zigzag encoded varint.
+func (e *kafkaEncoder) putVarint(v int64) {
+	var b [binary.MaxVarintLen64]byte
+	n := binary.PutVarint(b[:], v)
+	e.Write(b[:n])
+}
+
+// kafkaDecoder decodes the response, the first error is kept in err and the following reads return zero values.
+type kafkaDecoder struct {
+	b   []byte
+	err error
+}
+
+func (d *kafkaDecoder) next(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if len(d.b) < n {
+		d.err = io.ErrUnexpectedEOF
+		return nil
+	}
+	b := d.b[:n]
+	d.b = d.b[n:]
+	return b
+}
+
+func (d *kafkaDecoder) int8() int8 {
+	if b := d.next(1); b != nil {
+		return int8(b[0])
+	}
+	return 0
+}
+
+func (d *kafkaDecoder) int16() int16 {
+	if b := d.next(2); b != nil {
+		return int16(binary.BigEndian.Uint16(b))
+	}
+	return 0
+}
+
+func (d *kafkaDecoder) int32() int32 {
+	if b := d.next(4); b != nil {
+		return int32(binary.BigEndian.Uint32(b))
+	}
+	return 0
+}
+
+func (d *kafkaDecoder) int64() int64 {
+	if b := d.next(8); b != nil {
+		return int64(binary.BigEndian.Uint64(b))
+	}
+	return 0
+}
+
+func (d *kafkaDecoder) arrayLen() int {
+	n := d.int32()
+	if n < 0 {
+		return 0
+	}
+	// every element takes at least 1 byte.
+	if int(n) > len(d.b) {
+		d.err = io.ErrUnexpectedEOF
+		return 0
+	}
+	return int(n)
+}
+
+// string reads the string or nullable string.
+func (d *kafkaDecoder) string() string {
+	n := d.int16()
+	if n <= 0 {
+		return ""
+	}
+	return string(d.next(int(n)))
+}
@@ -0,0 +1,193 @@
+// Package logsink ships the structured JSON log records to the remote log collectors.
+package logsink
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// syncTimeout is the max time that Sync and Close wait for the buffered records to be shipped.
+const syncTimeout = 5 * time.Second
+
+// Record is a log record.
+type Record struct {
+	Time time.Time
+	// Data is the JSON encoded log record.
+	Data []byte
+}
+
+// Shipper ships the log records to the remote collector.
+// The methods are called in the goroutine of the Sink, so they are not required to be goroutine-safe.
+type Shipper interface {
+	// Name returns the name of the shipper, which is used in the error messages.
+	Name() string
+	// Ship ships the records. The Sink will retry the same records later if it returns an error.
+	Ship(records []Record) error
+	// Close closes the underlying connection.
+	Close() error
+}
+
+// Options is the buffering options of the Sink.
+type Options struct {
+	// BufferSize is the max number of the records buffered in memory.
+	BufferSize int
+	// BatchSize is the max number of the records in one shipping.
+	BatchSize int
+	// FlushInterval is the interval to ship the buffered records, it is also the retry interval after a failure.
+	FlushInterval time.Duration
+	// DropOnFull indicates whether to drop the records when the buffer is full.
+	// If it is false, the writer is blocked until the buffer is available.
+	DropOnFull bool
+}
+
+// Sink is a zapcore.WriteSyncer which buffers the log records and ships them by the Shipper in batch.
+type Sink struct {
+	shipper Shipper
+	opts    Options
+	records chan Record
+	flush   chan chan struct{}
+	dropped uint64
+
+	closeOnce sync.Once
+	closing   chan struct{}
+	closed    chan struct{}
+}
+
+// New creates the Sink and starts the shipping goroutine.
+func New(shipper Shipper, opts Options) *Sink {
+	s := &Sink{
+		shipper: shipper,
+		opts:    opts,
+		records: make(chan Record, opts.BufferSize),
+		flush:   make(chan chan struct{}),
+		closing: make(chan struct{}),
+		closed:  make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+// Write buffers a copy of p as a log record.
+func (s *Sink) Write(p []byte) (n int, err error) {
+	r := Record{
+		Time: time.Now(),
+		Data: append([]byte(nil), p...),
+	}
+	if s.opts.DropOnFull {
+		select {
+		case s.records <- r:
+		case <-s.closing:
+		default:
+			atomic.AddUint64(&s.dropped, 1)
+		}
+		return len(p), nil
+	}
+	select {
+	case s.records <- r:
+	case <-s.closing:
+	}
+	return len(p), nil
+}
+
+// Sync ships the buffered records.
+func (s *Sink) Sync() error {
+	done := make(chan struct{})
+	timeout := time.NewTimer(syncTimeout)
+	defer timeout.Stop()
+	select {
+	case s.flush <- done:
+	case <-s.closed:
+		return nil
+	case <-timeout.C:
+		return fmt.Errorf("%s log sink: sync timeout", s.shipper.Name())
+	}
+	select {
+	case <-done:
+		return nil
+	case <-timeout.C:
+		return fmt.Errorf("%s log sink: sync timeout", s.shipper.Name())
+	}
+}
+
+// Close ships the buffered records and closes the Shipper.
+func (s *Sink) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.closing)
+	})
+	select {
+	case <-s.closed:
+		return nil
+	case <-time.After(syncTimeout):
+		return fmt.Errorf("%s log sink: close timeout", s.shipper.Name())
+	}
+}
+
+// Dropped returns the number of the dropped records.
+func (s *Sink) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+func (s *Sink) run() {
+	ticker := time.NewTicker(s.opts.FlushInterval)
+	defer ticker.Stop()
+	var (
+		batch []Record
+		// retryAt is the time to retry after a failure.
+		retryAt time.Time
+	)
+	ship := func() {
+		for len(batch) != 0 {
+			n := len(batch)
+			if n > s.opts.BatchSize {
+				n = s.opts.BatchSize
+			}
+			if err := s.shipper.Ship(batch[:n]); err != nil {
+				// the logger can not be used here, otherwise the error logs will be shipped again.
+				fmt.Fprintf(os.Stderr, "%s log sink: fail to ship log records: %s\n", s.shipper.Name(), err)
+				retryAt = time.Now().Add(s.opts.FlushInterval)
+				return
+			}
+			batch = batch[n:]
+		}
+		batch = nil
+	}
+	drain := func() {
+		for len(batch) < s.opts.BufferSize {
+			select {
+			case r := <-s.records:
+				batch = append(batch, r)
+			default:
+				return
+			}
+		}
+	}
+	for {
+		in := s.records
+		// stop receiving records if the buffer is full, the writers will be blocked or the records will be dropped.
+		if len(batch) >= s.opts.BufferSize {
+			in = nil
+		}
+		select {
+		case r := <-in:
+			batch = append(batch, r)
+			if len(batch) >= s.opts.BatchSize && time.Now().After(retryAt) {
+				ship()
+			}
+		case <-ticker.C:
+			ship()
+		case done := <-s.flush:
+			drain()
+			ship()
+			close(done)
+		case <-s.closing:
+			drain()
+			ship()
+			_ = s.shipper.Close()
+			close(s.closed)
+			return
+		}
+	}
+}
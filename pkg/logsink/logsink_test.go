@@ -0,0 +1,278 @@
+package logsink
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type mockShipper struct {
+	mu      sync.Mutex
+	err     error
+	block   chan struct{}
+	shipped [][]byte
+	closed  bool
+}
+
+func (m *mockShipper) Name() string {
+	return "mock"
+}
+
+func (m *mockShipper) Ship(records []Record) error {
+	if m.block != nil {
+		<-m.block
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.err != nil {
+		return m.err
+	}
+	for _, v := range records {
+		m.shipped = append(m.shipped, v.Data)
+	}
+	return nil
+}
+
+func (m *mockShipper) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.closed = true
+	return nil
+}
+
+func (m *mockShipper) records() [][]byte {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.shipped
+}
+
+func TestSink(t *testing.T) {
+	a := assert.New(t)
+	m := &mockShipper{err: errors.New("unavailable")}
+	s := New(m, Options{
+		BufferSize:    10,
+		BatchSize:     2,
+		FlushInterval: time.Hour,
+		DropOnFull:    true,
+	})
+	for i := 0; i < 3; i++ {
+		_, err := s.Write([]byte(strconv.Itoa(i)))
+		a.NoError(err)
+	}
+	a.NoError(s.Sync())
+	a.Len(m.records(), 0)
+
+	// the failed records are kept and retried.
+	m.mu.Lock()
+	m.err = nil
+	m.mu.Unlock()
+	a.NoError(s.Sync())
+	a.Equal([][]byte{[]byte("0"), []byte("1"), []byte("2")}, m.records())
+
+	a.NoError(s.Close())
+	a.True(m.closed)
+	// the records are discarded after closed.
+	_, err := s.Write([]byte("3"))
+	a.NoError(err)
+	a.Len(m.records(), 3)
+}
+
+func TestSink_DropOnFull(t *testing.T) {
+	a := assert.New(t)
+	m := &mockShipper{block: make(chan struct{})}
+	s := New(m, Options{
+		BufferSize:    1,
+		BatchSize:     1,
+		FlushInterval: time.Hour,
+		DropOnFull:    true,
+	})
+	// the first record blocks the shipper, the second one is buffered in the channel and the others are dropped.
+	for i := 0; i < 5; i++ {
+		_, _ = s.Write([]byte(strconv.Itoa(i)))
+		time.Sleep(10 * time.Millisecond)
+	}
+	a.EqualValues(3, s.Dropped())
+	close(m.block)
+	a.NoError(s.Close())
+	a.Equal([][]byte{[]byte("0"), []byte("1")}, m.records())
+}
+
+func TestFluentd(t *testing.T) {
+	a := assert.New(t)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	a.NoError(err)
+	defer ln.Close()
+	received := make(chan []byte, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		b, _ := ioutil.ReadAll(conn)
+		received <- b
+	}()
+	f := NewFluentd(ln.Addr().String(), "gmqtt", time.Second)
+	now := time.Unix(1, 0)
+	a.NoError(f.Ship([]Record{
+		{Time: now, Data: []byte(`{"level":"info","n":1,"ok":true}` + "\n")},
+		{Time: now, Data: []byte("plain\n")},
+	}))
+	a.NoError(f.Close())
+	expected := []byte{
+		0x92, 0xa5, 'g', 'm', 'q', 't', 't',
+		0x92,
+		0x92, 0x01, 0x83,
+		0xa5, 'l', 'e', 'v', 'e', 'l', 0xa4, 'i', 'n', 'f', 'o',
+		0xa1, 'n', 0x01,
+		0xa2, 'o', 'k', 0xc3,
+		0x92, 0x01, 0x81,
+		0xa7, 'm', 'e', 's', 's', 'a', 'g', 'e', 0xa5, 'p', 'l', 'a', 'i', 'n',
+	}
+	select {
+	case b := <-received:
+		a.Equal(expected, b)
+	case <-time.After(time.Second):
+		t.Fatal("timeout")
+	}
+}
+
+// fakeKafka is a single node kafka which is the leader of partition 0 of all topics.
+func fakeKafka(ln net.Listener, produced chan<- []byte) {
+	conn, err := ln.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	host, portStr, _ := net.SplitHostPort(ln.Addr().String())
+	port, _ := strconv.Atoi(portStr)
+	for {
+		var size int32
+		if err := binary.Read(conn, binary.BigEndian, &size); err != nil {
+			return
+		}
+		b := make([]byte, size)
+		if _, err := io.ReadFull(conn, b); err != nil {
+			return
+		}
+		d := &kafkaDecoder{b: b}
+		apiKey := d.int16()
+		d.int16()
+		correlationID := d.int32()
+		d.string()
+		resp := &kafkaEncoder{}
+		resp.putInt32(0)
+		resp.putInt32(correlationID)
+		switch apiKey {
+		case kafkaAPIMetadata:
+			d.arrayLen()
+			topic := d.string()
+			resp.putInt32(0)
+			resp.putArrayLen(1)
+			resp.putInt32(1)
+			resp.putString(host)
+			resp.putInt32(int32(port))
+			resp.putInt16(-1)
+			resp.putInt16(-1)
+			resp.putInt32(1)
+			resp.putArrayLen(1)
+			resp.putInt16(0)
+			resp.putString(topic)
+			resp.putInt8(0)
+			resp.putArrayLen(1)
+			resp.putInt16(0)
+			resp.putInt32(0)
+			resp.putInt32(1)
+			resp.putArrayLen(0)
+			resp.putArrayLen(0)
+		case kafkaAPIProduce:
+			d.int16()
+			d.int16()
+			d.int32()
+			d.arrayLen()
+			topic := d.string()
+			d.arrayLen()
+			partition := d.int32()
+			produced <- d.next(int(d.int32()))
+			resp.putArrayLen(1)
+			resp.putString(topic)
+			resp.putArrayLen(1)
+			resp.putInt32(partition)
+			resp.putInt16(0)
+			resp.putInt64(0)
+			resp.putInt64(-1)
+			resp.putInt32(0)
+		}
+		out := resp.Bytes()
+		binary.BigEndian.PutUint32(out, uint32(len(out)-4))
+		if _, err := conn.Write(out); err != nil {
+			return
+		}
+	}
+}
+
+func TestKafka(t *testing.T) {
+	a := assert.New(t)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	a.NoError(err)
+	defer ln.Close()
+	produced := make(chan []byte, 1)
+	go fakeKafka(ln, produced)
+
+	k := NewKafka([]string{ln.Addr().String()}, "logs", 0, time.Second)
+	now := time.Unix(1, 0)
+	a.NoError(k.Ship([]Record{
+		{Time: now, Data: []byte("a\n")},
+		{Time: now.Add(time.Millisecond), Data: []byte("bc\n")},
+	}))
+	a.NoError(k.Close())
+
+	batch := <-produced
+	d := &kafkaDecoder{b: batch}
+	a.EqualValues(0, d.int64())
+	a.EqualValues(len(batch)-12, d.int32())
+	a.EqualValues(-1, d.int32())
+	a.EqualValues(2, d.int8())
+	crc := uint32(d.int32())
+	a.Equal(crc32.Checksum(d.b, crc32.MakeTable(crc32.Castagnoli)), crc)
+	// attributes, last_offset_delta, first_timestamp, max_timestamp
+	a.EqualValues(0, d.int16())
+	a.EqualValues(1, d.int32())
+	a.EqualValues(1000, d.int64())
+	a.EqualValues(1001, d.int64())
+	// producer_id, producer_epoch, base_sequence
+	d.int64()
+	d.int16()
+	d.int32()
+	a.EqualValues(2, d.int32())
+	r := bytes.NewReader(d.b)
+	var values []string
+	for i := 0; i < 2; i++ {
+		_, _ = binary.ReadVarint(r)
+		_, _ = r.ReadByte()
+		tsDelta, _ := binary.ReadVarint(r)
+		a.EqualValues(i, tsDelta)
+		offsetDelta, _ := binary.ReadVarint(r)
+		a.EqualValues(i, offsetDelta)
+		keyLen, _ := binary.ReadVarint(r)
+		a.EqualValues(-1, keyLen)
+		valueLen, _ := binary.ReadVarint(r)
+		v := make([]byte, valueLen)
+		_, _ = io.ReadFull(r, v)
+		values = append(values, string(v))
+		headers, _ := binary.ReadVarint(r)
+		a.EqualValues(0, headers)
+	}
+	a.Equal([]string{"a", "bc"}, values)
+	a.Equal(0, r.Len())
+}
@@ -0,0 +1,41 @@
+// Package netpoll provides an event-driven readiness poller for raw network connections.
+// It enables the broker to wait for the readable connections without parking a reader goroutine on each of them.
+package netpoll
+
+import (
+	"errors"
+	"net"
+	"syscall"
+)
+
+// ErrUnsupported will be returned by New if the poller is not supported on the running platform.
+var ErrUnsupported = errors.New("netpoll: unsupported platform")
+
+// ErrClosed will be returned when operating on a closed poller.
+var ErrClosed = errors.New("netpoll: poller closed")
+
+// ReadyFunc is the callback that will be invoked when the registered connection becomes readable.
+// The registration is one-shot, the caller must call Rearm to receive the next notification.
+// ReadyFunc is called on the poller goroutine, it must not block.
+type ReadyFunc func()
+
+// FD returns the underlying file descriptor of the given connection.
+// Only connections that implement syscall.Conn (e.g. *net.TCPConn) are supported,
+// for others (TLS, websocket...) ok will be false.
+func FD(conn net.Conn) (fd int, ok bool) {
+	sc, isSC := conn.(syscall.Conn)
+	if !isSC {
+		return 0, false
+	}
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return 0, false
+	}
+	err = rc.Control(func(f uintptr) {
+		fd = int(f)
+	})
+	if err != nil {
+		return 0, false
+	}
+	return fd, true
+}
@@ -0,0 +1,135 @@
+// +build linux
+
+package netpoll
+
+import (
+	"sync"
+
+	"golang.org/x/sys/unix"
+)
+
+// Poller is an epoll based readiness poller.
+type Poller struct {
+	epfd   int
+	wakefd int
+	mu     sync.RWMutex
+	fns    map[int]ReadyFunc
+	closed bool
+	done   chan struct{}
+}
+
+// New creates and starts a poller.
+func New() (*Poller, error) {
+	epfd, err := unix.EpollCreate1(unix.EPOLL_CLOEXEC)
+	if err != nil {
+		return nil, err
+	}
+	wakefd, err := unix.Eventfd(0, unix.EFD_NONBLOCK|unix.EFD_CLOEXEC)
+	if err != nil {
+		_ = unix.Close(epfd)
+		return nil, err
+	}
+	err = unix.EpollCtl(epfd, unix.EPOLL_CTL_ADD, wakefd, &unix.EpollEvent{
+		Events: unix.EPOLLIN,
+		Fd:     int32(wakefd),
+	})
+	if err != nil {
+		_ = unix.Close(wakefd)
+		_ = unix.Close(epfd)
+		return nil, err
+	}
+	p := &Poller{
+		epfd:   epfd,
+		wakefd: wakefd,
+		fns:    make(map[int]ReadyFunc),
+		done:   make(chan struct{}),
+	}
+	go p.run()
+	return p, nil
+}
+
+// Add registers the fd into the poller, fn will be called once the fd becomes readable.
+func (p *Poller) Add(fd int, fn ReadyFunc) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return ErrClosed
+	}
+	err := unix.EpollCtl(p.epfd, unix.EPOLL_CTL_ADD, fd, &unix.EpollEvent{
+		Events: unix.EPOLLIN | unix.EPOLLRDHUP | unix.EPOLLONESHOT,
+		Fd:     int32(fd),
+	})
+	if err != nil {
+		return err
+	}
+	p.fns[fd] = fn
+	return nil
+}
+
+// Rearm re-enables the notification for the fd after the callback has been invoked.
+func (p *Poller) Rearm(fd int) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed {
+		return ErrClosed
+	}
+	return unix.EpollCtl(p.epfd, unix.EPOLL_CTL_MOD, fd, &unix.EpollEvent{
+		Events: unix.EPOLLIN | unix.EPOLLRDHUP | unix.EPOLLONESHOT,
+		Fd:     int32(fd),
+	})
+}
+
+// Remove removes the fd from the poller.
+// It must be called before the connection is closed, otherwise the fd may be reused by a new connection.
+func (p *Poller) Remove(fd int) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return ErrClosed
+	}
+	delete(p.fns, fd)
+	return unix.EpollCtl(p.epfd, unix.EPOLL_CTL_DEL, fd, nil)
+}
+
+// Close stops the poller. Registered callbacks will not be called anymore.
+func (p *Poller) Close() error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil
+	}
+	p.closed = true
+	p.mu.Unlock()
+	var b [8]byte
+	b[7] = 1
+	_, _ = unix.Write(p.wakefd, b[:])
+	<-p.done
+	_ = unix.Close(p.wakefd)
+	return unix.Close(p.epfd)
+}
+
+func (p *Poller) run() {
+	defer close(p.done)
+	events := make([]unix.EpollEvent, 128)
+	for {
+		n, err := unix.EpollWait(p.epfd, events, -1)
+		if err != nil {
+			if err == unix.EINTR {
+				continue
+			}
+			return
+		}
+		for i := 0; i < n; i++ {
+			fd := int(events[i].Fd)
+			if fd == p.wakefd {
+				return
+			}
+			p.mu.RLock()
+			fn := p.fns[fd]
+			p.mu.RUnlock()
+			if fn != nil {
+				fn()
+			}
+		}
+	}
+}
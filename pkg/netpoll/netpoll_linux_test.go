@@ -0,0 +1,63 @@
+// +build linux
+
+package netpoll
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPoller(t *testing.T) {
+	a := assert.New(t)
+	p, err := New()
+	a.Nil(err)
+	defer p.Close()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	a.Nil(err)
+	defer ln.Close()
+
+	client, err := net.Dial("tcp", ln.Addr().String())
+	a.Nil(err)
+	defer client.Close()
+	server, err := ln.Accept()
+	a.Nil(err)
+	defer server.Close()
+
+	fd, ok := FD(server)
+	a.True(ok)
+	ready := make(chan struct{}, 1)
+	a.Nil(p.Add(fd, func() {
+		ready <- struct{}{}
+	}))
+
+	_, err = client.Write([]byte("a"))
+	a.Nil(err)
+	select {
+	case <-ready:
+	case <-time.After(time.Second):
+		t.Fatal("poller not notified")
+	}
+	b := make([]byte, 1)
+	_, err = server.Read(b)
+	a.Nil(err)
+
+	// one-shot, must not be notified before rearm
+	_, err = client.Write([]byte("b"))
+	a.Nil(err)
+	select {
+	case <-ready:
+		t.Fatal("unexpected notification")
+	case <-time.After(100 * time.Millisecond):
+	}
+	a.Nil(p.Rearm(fd))
+	select {
+	case <-ready:
+	case <-time.After(time.Second):
+		t.Fatal("poller not notified after rearm")
+	}
+	a.Nil(p.Remove(fd))
+}
@@ -0,0 +1,31 @@
+// +build !linux
+
+package netpoll
+
+// Poller is not supported on this platform.
+type Poller struct{}
+
+// New always returns ErrUnsupported on non-linux platforms.
+func New() (*Poller, error) {
+	return nil, ErrUnsupported
+}
+
+// Add is a no-op on non-linux platforms.
+func (p *Poller) Add(fd int, fn ReadyFunc) error {
+	return ErrUnsupported
+}
+
+// Rearm is a no-op on non-linux platforms.
+func (p *Poller) Rearm(fd int) error {
+	return ErrUnsupported
+}
+
+// Remove is a no-op on non-linux platforms.
+func (p *Poller) Remove(fd int) error {
+	return ErrUnsupported
+}
+
+// Close is a no-op on non-linux platforms.
+func (p *Poller) Close() error {
+	return nil
+}
@@ -46,8 +46,7 @@ func (c *Connack) Pack(w io.Writer) error {
 
 // Unpack read the packet bytes from io.Reader and decodes it into the packet struct
 func (c *Connack) Unpack(r io.Reader) error {
-	restBuffer := make([]byte, c.FixHeader.RemainLength)
-	_, err := io.ReadFull(r, restBuffer)
+	restBuffer, err := readRest(r, c.FixHeader.RemainLength)
 	if err != nil {
 		return codes.ErrMalformed
 	}
@@ -77,7 +76,7 @@ func (c *Connack) Unpack(r io.Reader) error {
 
 // NewConnackPacket returns a Connack instance by the given FixHeader and io.Reader
 func NewConnackPacket(fh *FixHeader, version Version, r io.Reader) (*Connack, error) {
-	p := &Connack{FixHeader: fh, Version: Version5}
+	p := &Connack{FixHeader: fh, Version: version}
 	if fh.Flags != FlagReserved {
 		return nil, codes.ErrMalformed
 	}
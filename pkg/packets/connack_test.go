@@ -101,6 +101,7 @@ func TestReadConnackPacket_V311(t *testing.T) {
 	packet, err := NewReader(connackPacketBytes).ReadPacket()
 	a.Nil(err)
 	if cp, ok := packet.(*Connack); ok {
+		a.Equal(Version311, cp.Version)
 		a.False(cp.SessionPresent)
 		a.EqualValues(1, cp.Code)
 	} else {
@@ -46,7 +46,7 @@ func (c *Connect) Pack(w io.Writer) error {
 	c.FixHeader = &FixHeader{PacketType: CONNECT, Flags: FlagReserved}
 
 	bufw := &bytes.Buffer{}
-	bufw.Write([]byte{0x00, 0x04})
+	writeUint16(bufw, uint16(len(c.ProtocolName)))
 	bufw.Write(c.ProtocolName)
 	bufw.WriteByte(c.ProtocolLevel)
 	// write flag
@@ -134,8 +134,7 @@ func (c *Connect) Pack(w io.Writer) error {
 
 // Unpack read the packet bytes from io.Reader and decodes it into the packet struct.
 func (c *Connect) Unpack(r io.Reader) (err error) {
-	restBuffer := make([]byte, c.FixHeader.RemainLength)
-	_, err = io.ReadFull(r, restBuffer)
+	restBuffer, err := readRest(r, c.FixHeader.RemainLength)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,157 @@
+//go:build go1.18
+// +build go1.18
+
+package packets
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+// fuzzRoundTrip decodes the packet of the version from b, and checks that the decoded packet
+// can be encoded and decoded back to the same packet.
+func fuzzRoundTrip(t *testing.T, version Version, b []byte) {
+	r := NewReader(bytes.NewBuffer(b))
+	r.SetVersion(version)
+	p, err := r.ReadPacket()
+	if err != nil {
+		return
+	}
+	if c, ok := p.(*Connect); ok {
+		version = c.Version
+	}
+	buf := &bytes.Buffer{}
+	if err = NewWriter(buf).WriteAndFlush(p); err != nil {
+		t.Fatalf("fail to encode the decoded packet %s: %v", p, err)
+	}
+	r = NewReader(bytes.NewBuffer(buf.Bytes()))
+	r.SetVersion(version)
+	p2, err := r.ReadPacket()
+	if err != nil {
+		t.Fatalf("fail to decode the encoded packet %s: %v", p, err)
+	}
+	if !reflect.DeepEqual(p, p2) {
+		t.Fatalf("round trip mismatch, decoded: %s, re-decoded: %s", p, p2)
+	}
+}
+
+// withFixHeader returns the packet bytes with the first byte of the fixed header and the remaining length of body.
+func withFixHeader(first byte, body []byte) []byte {
+	l, _ := DecodeRemainLength(len(body))
+	return append(append([]byte{first}, l...), body...)
+}
+
+func fuzzVersion(v5 bool) Version {
+	if v5 {
+		return Version5
+	}
+	return Version311
+}
+
+func encode(t testing.TB, p Packet) []byte {
+	buf := &bytes.Buffer{}
+	if err := NewWriter(buf).WriteAndFlush(p); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// FuzzReadPacket feeds arbitrary bytes to the decoder, as a connection does.
+func FuzzReadPacket(f *testing.F) {
+	f.Add(Version311, []byte{0xc0, 0})
+	f.Add(Version5, []byte{0x30, 0xff, 0xff, 0xff, 0xff, 0x01})
+	f.Add(Version5, encode(f, &Disconnect{Version: Version5, Code: 0x81, Properties: &Properties{ReasonString: []byte("a")}}))
+	f.Add(Version5, encode(f, &Auth{Code: 0x18, Properties: &Properties{AuthMethod: []byte("m"), AuthData: []byte("d")}}))
+	f.Fuzz(func(t *testing.T, version byte, b []byte) {
+		// the version of the reader is always a valid one, as it is set by the CONNECT packet.
+		fuzzRoundTrip(t, []Version{Version31, Version311, Version5}[version%3], b)
+	})
+}
+
+// FuzzConnect fuzzes the CONNECT packet, including the will message and the will properties.
+func FuzzConnect(f *testing.F) {
+	for _, v := range []*Connect{
+		{Version: Version311, ProtocolLevel: Version311, ProtocolName: []byte("MQTT"), CleanStart: true, ClientID: []byte("cid"), KeepAlive: 60},
+		{Version: Version31, ProtocolLevel: Version31, ProtocolName: []byte("MQIsdp"), ClientID: []byte("cid"),
+			UsernameFlag: true, Username: []byte("u"), PasswordFlag: true, Password: []byte("p")},
+		{Version: Version5, ProtocolLevel: Version5, ProtocolName: []byte("MQTT"), CleanStart: true, ClientID: []byte("cid"),
+			WillFlag: true, WillQos: Qos1, WillRetain: true, WillTopic: []byte("will"), WillMsg: []byte("msg"),
+			Properties:     &Properties{User: []UserProperty{{K: []byte("k"), V: []byte("v")}}},
+			WillProperties: &Properties{ContentType: []byte("text/plain")}},
+	} {
+		b := encode(f, v)
+		// skip the fixed header
+		f.Add(b[2:])
+	}
+	f.Fuzz(func(t *testing.T, body []byte) {
+		fuzzRoundTrip(t, 0, withFixHeader(0x10, body))
+	})
+}
+
+// FuzzPublish fuzzes the PUBLISH packet with the flags of the fixed header.
+func FuzzPublish(f *testing.F) {
+	for _, v := range []*Publish{
+		{Version: Version311, Qos: Qos1, PacketID: 1, TopicName: []byte("a/b"), Payload: []byte("payload")},
+		{Version: Version5, Qos: Qos2, Dup: true, Retain: true, PacketID: 10, TopicName: []byte("a/b"), Payload: []byte("payload"),
+			Properties: &Properties{ResponseTopic: []byte("r"), CorrelationData: []byte("c"), User: []UserProperty{{K: []byte("k"), V: []byte("v")}}}},
+	} {
+		b := encode(f, v)
+		f.Add(IsVersion5(v.Version), b[0]&0x0f, b[2:])
+	}
+	f.Fuzz(func(t *testing.T, v5 bool, flags byte, body []byte) {
+		fuzzRoundTrip(t, fuzzVersion(v5), withFixHeader(PUBLISH<<4|flags&0x0f, body))
+	})
+}
+
+// FuzzSubscribe fuzzes the SUBSCRIBE and the UNSUBSCRIBE packet.
+func FuzzSubscribe(f *testing.F) {
+	sub := &Subscribe{Version: Version5, PacketID: 1, Topics: []Topic{
+		{Name: "a/+", SubOptions: SubOptions{Qos: Qos1, NoLocal: true, RetainAsPublished: true, RetainHandling: 2}},
+		{Name: "$share/g/#"},
+	}, Properties: &Properties{SubscriptionIdentifier: []uint32{1}}}
+	f.Add(true, false, encode(f, sub)[2:])
+	sub.Version = Version311
+	f.Add(false, false, encode(f, sub)[2:])
+	f.Add(true, true, encode(f, &Unsubscribe{Version: Version5, PacketID: 1, Topics: []string{"a/+", "b"}, Properties: &Properties{}})[2:])
+	f.Fuzz(func(t *testing.T, v5 bool, unsubscribe bool, body []byte) {
+		first := byte(SUBSCRIBE<<4 | FlagSubscribe)
+		if unsubscribe {
+			first = UNSUBSCRIBE<<4 | FlagUnsubscribe
+		}
+		fuzzRoundTrip(t, fuzzVersion(v5), withFixHeader(first, body))
+	})
+}
+
+// FuzzProperties fuzzes the properties of the packet type.
+func FuzzProperties(f *testing.F) {
+	expiry := uint32(10)
+	for _, v := range []struct {
+		packetType byte
+		p          *Properties
+	}{
+		{packetType: CONNECT, p: &Properties{SessionExpiryInterval: &expiry, AuthMethod: []byte("m"), AuthData: []byte("d")}},
+		{packetType: PUBLISH, p: &Properties{MessageExpiry: &expiry, ContentType: []byte("c"), User: []UserProperty{{K: []byte("k"), V: []byte("v")}}}},
+		{packetType: SUBSCRIBE, p: &Properties{SubscriptionIdentifier: []uint32{268435455}}},
+		{packetType: DISCONNECT, p: &Properties{ServerReference: []byte("s"), ReasonString: []byte("r")}},
+	} {
+		buf := &bytes.Buffer{}
+		v.p.Pack(buf, v.packetType)
+		f.Add(v.packetType, buf.Bytes())
+	}
+	f.Fuzz(func(t *testing.T, packetType byte, b []byte) {
+		p := &Properties{}
+		if err := p.Unpack(bytes.NewBuffer(b), packetType); err != nil {
+			return
+		}
+		buf := &bytes.Buffer{}
+		p.Pack(buf, packetType)
+		p2 := &Properties{}
+		if err := p2.Unpack(buf, packetType); err != nil {
+			t.Fatalf("fail to decode the encoded properties %s: %v", p, err)
+		}
+		if !reflect.DeepEqual(p, p2) {
+			t.Fatalf("round trip mismatch, decoded: %s, re-decoded: %s", p, p2)
+		}
+	})
+}
@@ -292,7 +292,11 @@ func EncodeRemainLength(r io.ByteReader) (int, error) {
 	var multiplier uint32
 	for {
 		digit, err := r.ReadByte()
-		if err != nil && err != io.EOF {
+		if err == io.EOF {
+			// the variable byte integer is truncated.
+			return 0, codes.ErrMalformed
+		}
+		if err != nil {
 			return 0, err
 		}
 		vbi |= uint32(digit&127) << multiplier
@@ -320,6 +324,26 @@ func EncodeUTF8String(buf []byte) (b []byte, size int, err error) {
 	return bufw, 2 + buflen, nil
 }
 
+// preallocLimit is the maximum size of the buffer allocated before the rest of the packet is read.
+const preallocLimit = 64 * 1024
+
+// readRest reads the rest of the packet whose remaining length is n.
+// The buffer of the larger packet grows with the bytes actually read,
+// so that a forged remaining length does not make the reader allocate the memory in advance.
+func readRest(r io.Reader, n int) ([]byte, error) {
+	if n <= preallocLimit {
+		b := make([]byte, n)
+		_, err := io.ReadFull(r, b)
+		return b, err
+	}
+	buf := bytes.NewBuffer(make([]byte, 0, preallocLimit))
+	_, err := buf.ReadFrom(io.LimitReader(r, int64(n)))
+	if err == nil && buf.Len() != n {
+		err = io.ErrUnexpectedEOF
+	}
+	return buf.Bytes(), err
+}
+
 func readUint16(r *bytes.Buffer) (uint16, error) {
 	if r.Len() < 2 {
 		return 0, codes.ErrMalformed
@@ -331,6 +331,10 @@ func (p *Properties) UnpackWillProperties(bufr *bytes.Buffer) error {
 // filling in the appropriate entries in the struct, it returns the number
 // of bytes used to store the Prop data and any error in decoding them
 func (p *Properties) Unpack(bufr *bytes.Buffer, packetType byte) error {
+	// the property length can be omitted if there are no properties, e.g. PUBACK, DISCONNECT.
+	if bufr.Len() == 0 {
+		return nil
+	}
 	var err error
 	length, err := EncodeRemainLength(bufr)
 	// 整个buffer最多只能读到length这么长
@@ -83,8 +83,7 @@ func (p *Publish) Pack(w io.Writer) error {
 // Unpack read the packet bytes from io.Reader and decodes it into the packet struct.
 func (p *Publish) Unpack(r io.Reader) error {
 	var err error
-	restBuffer := make([]byte, p.FixHeader.RemainLength)
-	_, err = io.ReadFull(r, restBuffer)
+	restBuffer, err := readRest(r, p.FixHeader.RemainLength)
 	if err != nil {
 		return codes.ErrMalformed
 	}
@@ -101,6 +100,9 @@ func (p *Publish) Unpack(r io.Reader) error {
 		if err != nil {
 			return err
 		}
+		if p.PacketID == 0 { //[MQTT-2.3.1-1]
+			return codes.ErrMalformed
+		}
 	}
 	if p.Version == Version5 {
 		p.Properties = &Properties{}
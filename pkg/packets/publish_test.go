@@ -142,6 +142,15 @@ func TestReadPublishPacket_V5(t *testing.T) {
 	})
 }
 
+func TestReadPublishPacket_PacketID0(t *testing.T) {
+	a := assert.New(t)
+	topicNameBytes, _, _ := EncodeUTF8String([]byte("a"))
+	// qos=1 with packet id 0 [MQTT-2.3.1-1]
+	pb := appendPacket(0x32, topicNameBytes, []byte{0, 0}, []byte("payload"))
+	_, err := NewReader(bytes.NewBuffer(pb)).ReadPacket()
+	a.Equal(codes.ErrMalformed, err)
+}
+
 func TestReadWritePublishPacket_V311(t *testing.T) {
 	a := assert.New(t)
 	var tt = []struct {
@@ -59,8 +59,7 @@ func (p *Pubrec) Pack(w io.Writer) error {
 
 // Unpack read the packet bytes from io.Reader and decodes it into the packet struct.
 func (p *Pubrec) Unpack(r io.Reader) error {
-	restBuffer := make([]byte, p.FixHeader.RemainLength)
-	_, err := io.ReadFull(r, restBuffer)
+	restBuffer, err := readRest(r, p.FixHeader.RemainLength)
 	if err != nil {
 		return codes.ErrMalformed
 	}
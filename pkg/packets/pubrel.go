@@ -24,6 +24,10 @@ func (p *Pubrel) String() string {
 // NewPubrelPacket returns a Pubrel instance by the given FixHeader and io.Reader.
 func NewPubrelPacket(fh *FixHeader, r io.Reader) (*Pubrel, error) {
 	p := &Pubrel{FixHeader: fh}
+	//判断 标志位 flags 是否合法[MQTT-3.6.1-1]
+	if fh.Flags != FlagPubrel {
+		return nil, codes.ErrMalformed
+	}
 	err := p.Unpack(r)
 	if err != nil {
 		return nil, err
@@ -58,8 +62,7 @@ func (p *Pubrel) Pack(w io.Writer) error {
 
 // Unpack read the packet bytes from io.Reader and decodes it into the packet struct.
 func (p *Pubrel) Unpack(r io.Reader) error {
-	restBuffer := make([]byte, p.FixHeader.RemainLength)
-	_, err := io.ReadFull(r, restBuffer)
+	restBuffer, err := readRest(r, p.FixHeader.RemainLength)
 	if err != nil {
 		return codes.ErrMalformed
 	}
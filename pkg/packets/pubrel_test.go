@@ -69,6 +69,13 @@ func TestReadWritePubrelPacket(t *testing.T) {
 
 }
 
+func TestReadPubrelPacket_InvalidFlags(t *testing.T) {
+	a := assert.New(t)
+	// the fixed header flags must be 0010 [MQTT-3.6.1-1]
+	_, err := NewReader(bytes.NewBuffer([]byte{0x60, 2, 0, 10})).ReadPacket()
+	a.Equal(codes.ErrMalformed, err)
+}
+
 func TestPubrel_NewPubcomp(t *testing.T) {
 	a := assert.New(t)
 	pid := uint16(10)
@@ -93,8 +93,7 @@ func (p *Subscribe) Pack(w io.Writer) error {
 
 // Unpack read the packet bytes from io.Reader and decodes it into the packet struct.
 func (p *Subscribe) Unpack(r io.Reader) (err error) {
-	restBuffer := make([]byte, p.FixHeader.RemainLength)
-	_, err = io.ReadFull(r, restBuffer)
+	restBuffer, err := readRest(r, p.FixHeader.RemainLength)
 	if err != nil {
 		return codes.ErrMalformed
 	}
@@ -0,0 +1,148 @@
+// Package timingwheel implements a hashed timing wheel.
+// It is designed for a large number of coarse-grained timers, e.g. the keepalive timers of hundreds of thousands of connections,
+// which put much less pressure on the runtime timer heap than the same number of time.Timer.
+package timingwheel
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// Wheel is a hashed timing wheel.
+// The expiration of the timers is accurate to one tick.
+type Wheel struct {
+	tick  time.Duration
+	mu    sync.Mutex
+	slots []*list.List
+	// pos is the current slot position.
+	pos       int
+	close     chan struct{}
+	closeOnce sync.Once
+}
+
+// Timer represents a single event in the Wheel.
+type Timer struct {
+	w *Wheel
+	f func()
+	// rounds is the number of remaining rotations before expiration.
+	rounds int
+	slot   int
+	elem   *list.Element
+}
+
+// New creates and starts a Wheel with the given tick duration and number of slots.
+func New(tick time.Duration, slotNum int) *Wheel {
+	w := &Wheel{
+		tick:  tick,
+		slots: make([]*list.List, slotNum),
+		close: make(chan struct{}),
+	}
+	for i := range w.slots {
+		w.slots[i] = list.New()
+	}
+	go w.run()
+	return w
+}
+
+func (w *Wheel) run() {
+	t := time.NewTicker(w.tick)
+	defer t.Stop()
+	for {
+		select {
+		case <-w.close:
+			return
+		case <-t.C:
+			w.advance()
+		}
+	}
+}
+
+// advance moves the wheel forward by one tick and fires the expired timers.
+func (w *Wheel) advance() {
+	var expired []func()
+	w.mu.Lock()
+	w.pos = (w.pos + 1) % len(w.slots)
+	l := w.slots[w.pos]
+	for e := l.Front(); e != nil; {
+		next := e.Next()
+		t := e.Value.(*Timer)
+		if t.rounds > 0 {
+			t.rounds--
+		} else {
+			l.Remove(e)
+			t.elem = nil
+			expired = append(expired, t.f)
+		}
+		e = next
+	}
+	w.mu.Unlock()
+	for _, f := range expired {
+		go f()
+	}
+}
+
+// NewTimer creates a Timer that will call f in its own goroutine when it expires.
+// The timer is not started until Reset is called.
+func (w *Wheel) NewTimer(f func()) *Timer {
+	return &Timer{
+		w: w,
+		f: f,
+	}
+}
+
+// AfterFunc waits for the duration to elapse and then calls f in its own goroutine.
+// It returns a Timer that can be used to cancel the call using its Stop method.
+func (w *Wheel) AfterFunc(d time.Duration, f func()) *Timer {
+	t := &Timer{
+		w: w,
+		f: f,
+	}
+	w.mu.Lock()
+	w.addLocked(t, d)
+	w.mu.Unlock()
+	return t
+}
+
+func (w *Wheel) addLocked(t *Timer, d time.Duration) {
+	ticks := int((d + w.tick - 1) / w.tick)
+	if ticks <= 0 {
+		ticks = 1
+	}
+	t.rounds = (ticks - 1) / len(w.slots)
+	t.slot = (w.pos + ticks) % len(w.slots)
+	t.elem = w.slots[t.slot].PushBack(t)
+}
+
+// Stop prevents the Timer from firing.
+// It returns true if the call stops the timer, false if the timer has already expired or been stopped.
+func (t *Timer) Stop() bool {
+	t.w.mu.Lock()
+	defer t.w.mu.Unlock()
+	if t.elem == nil {
+		return false
+	}
+	t.w.slots[t.slot].Remove(t.elem)
+	t.elem = nil
+	return true
+}
+
+// Reset changes the timer to expire after duration d.
+// It returns true if the timer had been active, false if the timer had expired or been stopped.
+func (t *Timer) Reset(d time.Duration) bool {
+	t.w.mu.Lock()
+	defer t.w.mu.Unlock()
+	active := t.elem != nil
+	if active {
+		t.w.slots[t.slot].Remove(t.elem)
+	}
+	t.w.addLocked(t, d)
+	return active
+}
+
+// Stop stops the wheel, the pending timers will never fire.
+func (w *Wheel) Stop() {
+	w.closeOnce.Do(func() {
+		close(w.close)
+	})
+}
@@ -0,0 +1,68 @@
+package timingwheel
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWheel_AfterFunc(t *testing.T) {
+	a := assert.New(t)
+	w := New(10*time.Millisecond, 8)
+	defer w.Stop()
+
+	start := time.Now()
+	fired := make(chan time.Duration, 1)
+	// longer than one rotation
+	w.AfterFunc(150*time.Millisecond, func() {
+		fired <- time.Since(start)
+	})
+	select {
+	case d := <-fired:
+		a.True(d >= 140*time.Millisecond, d)
+	case <-time.After(time.Second):
+		t.Fatal("timer not fired")
+	}
+}
+
+func TestTimer_Stop(t *testing.T) {
+	a := assert.New(t)
+	w := New(10*time.Millisecond, 8)
+	defer w.Stop()
+
+	var fired int32
+	timer := w.AfterFunc(20*time.Millisecond, func() {
+		atomic.StoreInt32(&fired, 1)
+	})
+	a.True(timer.Stop())
+	a.False(timer.Stop())
+	time.Sleep(50 * time.Millisecond)
+	a.EqualValues(0, atomic.LoadInt32(&fired))
+}
+
+func TestTimer_Reset(t *testing.T) {
+	a := assert.New(t)
+	w := New(10*time.Millisecond, 8)
+	defer w.Stop()
+
+	start := time.Now()
+	fired := make(chan time.Duration, 1)
+	timer := w.AfterFunc(20*time.Millisecond, func() {
+		fired <- time.Since(start)
+	})
+	a.True(timer.Reset(100 * time.Millisecond))
+	select {
+	case d := <-fired:
+		a.True(d >= 90*time.Millisecond, d)
+	case <-time.After(time.Second):
+		t.Fatal("timer not fired")
+	}
+	a.False(timer.Reset(10 * time.Millisecond))
+	select {
+	case <-fired:
+	case <-time.After(time.Second):
+		t.Fatal("timer not fired after reset")
+	}
+}
@@ -0,0 +1,184 @@
+package tlspsk
+
+import (
+	"crypto/cipher"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+)
+
+// ccm implements the Counter with CBC-MAC mode (RFC 3610) of the 128-bit block ciphers.
+type ccm struct {
+	b         cipher.Block
+	nonceSize int
+	tagSize   int
+}
+
+var errOpen = errors.New("tlspsk: message authentication failed")
+
+func newCCM(b cipher.Block, nonceSize, tagSize int) (cipher.AEAD, error) {
+	if b.BlockSize() != 16 {
+		return nil, errors.New("tlspsk: CCM requires 128-bit block cipher")
+	}
+	if nonceSize < 7 || nonceSize > 13 {
+		return nil, errors.New("tlspsk: invalid CCM nonce size")
+	}
+	if tagSize < 4 || tagSize > 16 || tagSize%2 != 0 {
+		return nil, errors.New("tlspsk: invalid CCM tag size")
+	}
+	return &ccm{b: b, nonceSize: nonceSize, tagSize: tagSize}, nil
+}
+
+func (c *ccm) NonceSize() int {
+	return c.nonceSize
+}
+
+func (c *ccm) Overhead() int {
+	return c.tagSize
+}
+
+// maxLength returns the maximum length of the plaintext, which is limited by the size of the length field.
+func (c *ccm) maxLength() uint64 {
+	l := 15 - c.nonceSize
+	if l >= 8 {
+		return 1<<64 - 1
+	}
+	return 1<<(8*uint(l)) - 1
+}
+
+// counterBlock returns the counter block A_0.
+func (c *ccm) counterBlock(nonce []byte) [16]byte {
+	var a [16]byte
+	a[0] = byte(15 - c.nonceSize - 1)
+	copy(a[1:], nonce)
+	return a
+}
+
+// tag computes the CBC-MAC of the plaintext and the additional data.
+func (c *ccm) tag(nonce, plaintext, additionalData []byte) [16]byte {
+	var x, b [16]byte
+	l := 15 - c.nonceSize
+	b[0] = byte((c.tagSize-2)/2<<3 | (l - 1))
+	if len(additionalData) > 0 {
+		b[0] |= 0x40
+	}
+	copy(b[1:], nonce)
+	var length [8]byte
+	binary.BigEndian.PutUint64(length[:], uint64(len(plaintext)))
+	copy(b[16-l:], length[8-l:])
+	c.b.Encrypt(x[:], b[:])
+
+	mac := func(data []byte) {
+		for len(data) > 0 {
+			n := copy(b[:], data)
+			for i := n; i < 16; i++ {
+				b[i] = 0
+			}
+			data = data[n:]
+			xorBytes(x[:], x[:], b[:])
+			c.b.Encrypt(x[:], x[:])
+		}
+	}
+	if n := len(additionalData); n > 0 {
+		var ad []byte
+		switch {
+		case n < 0xff00:
+			ad = make([]byte, 2, 2+n)
+			binary.BigEndian.PutUint16(ad, uint16(n))
+		case uint64(n) <= 0xffffffff:
+			ad = make([]byte, 6, 6+n)
+			ad[0], ad[1] = 0xff, 0xfe
+			binary.BigEndian.PutUint32(ad[2:], uint32(n))
+		default:
+			ad = make([]byte, 10, 10+n)
+			ad[0], ad[1] = 0xff, 0xff
+			binary.BigEndian.PutUint64(ad[2:], uint64(n))
+		}
+		mac(append(ad, additionalData...))
+	}
+	mac(plaintext)
+	return x
+}
+
+// ctr encrypts or decrypts the src by the counter blocks starting from A_1.
+func (c *ccm) ctr(dst, src []byte, a [16]byte) {
+	var s [16]byte
+	l := 15 - c.nonceSize
+	for counter := uint64(1); len(src) > 0; counter++ {
+		var ctr [8]byte
+		binary.BigEndian.PutUint64(ctr[:], counter)
+		copy(a[16-l:], ctr[8-l:])
+		c.b.Encrypt(s[:], a[:])
+		n := xorBytes(dst, src, s[:])
+		dst, src = dst[n:], src[n:]
+	}
+}
+
+func (c *ccm) Seal(dst, nonce, plaintext, additionalData []byte) []byte {
+	if len(nonce) != c.nonceSize {
+		panic("tlspsk: incorrect nonce length given to CCM")
+	}
+	if uint64(len(plaintext)) > c.maxLength() {
+		panic("tlspsk: message too large for CCM")
+	}
+	ret, out := sliceForAppend(dst, len(plaintext)+c.tagSize)
+	tag := c.tag(nonce, plaintext, additionalData)
+	a := c.counterBlock(nonce)
+	c.ctr(out, plaintext, a)
+	var s0 [16]byte
+	c.b.Encrypt(s0[:], a[:])
+	xorBytes(out[len(plaintext):], tag[:c.tagSize], s0[:c.tagSize])
+	return ret
+}
+
+func (c *ccm) Open(dst, nonce, ciphertext, additionalData []byte) ([]byte, error) {
+	if len(nonce) != c.nonceSize {
+		panic("tlspsk: incorrect nonce length given to CCM")
+	}
+	if len(ciphertext) < c.tagSize || uint64(len(ciphertext)-c.tagSize) > c.maxLength() {
+		return nil, errOpen
+	}
+	n := len(ciphertext) - c.tagSize
+	var receivedTag [16]byte
+	copy(receivedTag[:], ciphertext[n:])
+
+	ret, out := sliceForAppend(dst, n)
+	a := c.counterBlock(nonce)
+	c.ctr(out, ciphertext[:n], a)
+	tag := c.tag(nonce, out, additionalData)
+	var s0 [16]byte
+	c.b.Encrypt(s0[:], a[:])
+	xorBytes(tag[:c.tagSize], tag[:c.tagSize], s0[:c.tagSize])
+	if subtle.ConstantTimeCompare(tag[:c.tagSize], receivedTag[:c.tagSize]) != 1 {
+		for i := range out {
+			out[i] = 0
+		}
+		return nil, errOpen
+	}
+	return ret, nil
+}
+
+// xorBytes sets dst[i] = x[i] ^ y[i] for i < n = min(len(x), len(y)), and returns n.
+func xorBytes(dst, x, y []byte) int {
+	n := len(x)
+	if len(y) < n {
+		n = len(y)
+	}
+	for i := 0; i < n; i++ {
+		dst[i] = x[i] ^ y[i]
+	}
+	return n
+}
+
+// sliceForAppend extends the input slice by n bytes. head is the full extended slice,
+// and tail is the appended part.
+func sliceForAppend(in []byte, n int) (head, tail []byte) {
+	if total := len(in) + n; cap(in) >= total {
+		head = in[:total]
+	} else {
+		head = make([]byte, total)
+		copy(head, in)
+	}
+	tail = head[len(in):]
+	return
+}
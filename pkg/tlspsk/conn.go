@@ -0,0 +1,570 @@
+package tlspsk
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	recordTypeChangeCipherSpec uint8 = 20
+	recordTypeAlert            uint8 = 21
+	recordTypeHandshake        uint8 = 22
+	recordTypeApplicationData  uint8 = 23
+)
+
+const (
+	recordHeaderLen = 5
+	// maxPlaintext is the maximum length of the plaintext of a record.
+	maxPlaintext = 16384
+	// maxCiphertext is the maximum length of the payload of a record.
+	maxCiphertext = maxPlaintext + 2048
+	// maxHandshake is the maximum length of a handshake message.
+	maxHandshake = 65536
+	// versionTLS12 is the only supported protocol version.
+	versionTLS12 = 0x0303
+)
+
+var errClosed = errors.New("tlspsk: use of closed connection")
+
+type alert uint8
+
+const (
+	alertCloseNotify          alert = 0
+	alertUnexpectedMessage    alert = 10
+	alertBadRecordMAC         alert = 20
+	alertRecordOverflow       alert = 22
+	alertHandshakeFailure     alert = 40
+	alertIllegalParameter     alert = 47
+	alertDecodeError          alert = 50
+	alertDecryptError         alert = 51
+	alertProtocolVersion      alert = 70
+	alertInternalError        alert = 80
+	alertNoRenegotiation      alert = 100
+	alertUnsupportedExtension alert = 110
+	alertUnknownPSKIdentity   alert = 115
+)
+
+var alertText = map[alert]string{
+	alertCloseNotify:          "close notify",
+	alertUnexpectedMessage:    "unexpected message",
+	alertBadRecordMAC:         "bad record MAC",
+	alertRecordOverflow:       "record overflow",
+	alertHandshakeFailure:     "handshake failure",
+	alertIllegalParameter:     "illegal parameter",
+	alertDecodeError:          "error decoding message",
+	alertDecryptError:         "error decrypting message",
+	alertProtocolVersion:      "protocol version not supported",
+	alertInternalError:        "internal error",
+	alertNoRenegotiation:      "no renegotiation",
+	alertUnsupportedExtension: "unsupported extension",
+	alertUnknownPSKIdentity:   "unknown PSK identity",
+}
+
+func (e alert) String() string {
+	if s, ok := alertText[e]; ok {
+		return s
+	}
+	return fmt.Sprintf("alert(%d)", uint8(e))
+}
+
+func (e alert) Error() string {
+	return "tlspsk: " + e.String()
+}
+
+// Conn is a TLS-PSK connection, it implements net.Conn.
+type Conn struct {
+	conn     net.Conn
+	config   *Config
+	isClient bool
+
+	handshakeMutex sync.Mutex
+	handshakeErr   error
+	// handshakeStatus is 1 if the handshake has completed.
+	handshakeStatus uint32
+	suite           *cipherSuite
+	identity        string
+	// maxFragment is the maximum length of the plaintext of the outgoing records,
+	// which can be reduced by the max_fragment_length extension.
+	maxFragment int
+
+	in, out halfConn
+	// raw is the raw input from the underlying connection, which may contain a partial record.
+	raw []byte
+	// input is the application data that is decrypted but not read yet.
+	input []byte
+	// hand is the handshake data that is decrypted but not processed yet.
+	hand bytes.Buffer
+	// transcript is all handshake messages sent and received.
+	transcript bytes.Buffer
+
+	// activeCall is an atomic int32, the low bit is whether Close has been called,
+	// the rest of the bits are the number of goroutines in Write.
+	activeCall      int32
+	closeNotifySent bool
+}
+
+// halfConn is the state of one direction of the connection.
+type halfConn struct {
+	sync.Mutex
+	// err is the permanent error.
+	err  error
+	aead cipher.AEAD
+	salt []byte
+	seq  uint64
+	// buf is the pending records to send.
+	buf []byte
+}
+
+func newConn(conn net.Conn, config *Config, isClient bool) *Conn {
+	return &Conn{
+		conn:        conn,
+		config:      config,
+		isClient:    isClient,
+		maxFragment: maxPlaintext,
+	}
+}
+
+func (hc *halfConn) setCipher(aead cipher.AEAD, salt []byte) {
+	hc.aead = aead
+	hc.salt = salt
+	hc.seq = 0
+}
+
+func (hc *halfConn) nonce(explicit []byte) []byte {
+	nonce := make([]byte, 0, implicitNonceLength+explicitNonceLength)
+	nonce = append(nonce, hc.salt...)
+	return append(nonce, explicit...)
+}
+
+func (hc *halfConn) additionalData(typ uint8, n int) []byte {
+	ad := make([]byte, 13)
+	binary.BigEndian.PutUint64(ad, hc.seq)
+	ad[8] = typ
+	binary.BigEndian.PutUint16(ad[9:], versionTLS12)
+	binary.BigEndian.PutUint16(ad[11:], uint16(n))
+	return ad
+}
+
+// seal appends the record of the payload to hc.buf.
+func (hc *halfConn) seal(typ uint8, payload []byte) {
+	start := len(hc.buf)
+	hc.buf = append(hc.buf, typ, versionTLS12>>8, versionTLS12&0xff, 0, 0)
+	if hc.aead == nil {
+		hc.buf = append(hc.buf, payload...)
+	} else {
+		var explicit [explicitNonceLength]byte
+		binary.BigEndian.PutUint64(explicit[:], hc.seq)
+		hc.buf = append(hc.buf, explicit[:]...)
+		hc.buf = hc.aead.Seal(hc.buf, hc.nonce(explicit[:]), payload, hc.additionalData(typ, len(payload)))
+		hc.seq++
+	}
+	binary.BigEndian.PutUint16(hc.buf[start+3:], uint16(len(hc.buf)-start-recordHeaderLen))
+}
+
+// open decrypts the payload of the record in place.
+func (hc *halfConn) open(typ uint8, payload []byte) ([]byte, error) {
+	if hc.aead == nil {
+		return payload, nil
+	}
+	if len(payload) < explicitNonceLength+hc.aead.Overhead() {
+		return nil, alertBadRecordMAC
+	}
+	explicit, ciphertext := payload[:explicitNonceLength], payload[explicitNonceLength:]
+	ad := hc.additionalData(typ, len(ciphertext)-hc.aead.Overhead())
+	plaintext, err := hc.aead.Open(ciphertext[:0], hc.nonce(explicit), ciphertext, ad)
+	if err != nil {
+		return nil, alertBadRecordMAC
+	}
+	hc.seq++
+	return plaintext, nil
+}
+
+// readFromUntil reads from the underlying connection until c.raw has at least n bytes.
+// The partial record is kept in c.raw if it fails, e.g. due to the read deadline, so that the next read can resume.
+func (c *Conn) readFromUntil(n int) error {
+	if cap(c.raw) < n {
+		raw := make([]byte, len(c.raw), n+maxCiphertext)
+		copy(raw, c.raw)
+		c.raw = raw
+	}
+	for len(c.raw) < n {
+		m, err := c.conn.Read(c.raw[len(c.raw):cap(c.raw)])
+		c.raw = c.raw[:len(c.raw)+m]
+		if err != nil && len(c.raw) < n {
+			if err == io.EOF && len(c.raw) > 0 {
+				err = io.ErrUnexpectedEOF
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// readRecord reads and decrypts the next record. c.in must be held.
+func (c *Conn) readRecord() (typ uint8, data []byte, err error) {
+	if err = c.readFromUntil(recordHeaderLen); err != nil {
+		return 0, nil, err
+	}
+	typ = c.raw[0]
+	if c.raw[1] != 3 {
+		return 0, nil, c.sendAlert(alertProtocolVersion)
+	}
+	n := int(binary.BigEndian.Uint16(c.raw[3:]))
+	if n > maxCiphertext {
+		return 0, nil, c.sendAlert(alertRecordOverflow)
+	}
+	if err = c.readFromUntil(recordHeaderLen + n); err != nil {
+		return 0, nil, err
+	}
+	payload := make([]byte, n)
+	copy(payload, c.raw[recordHeaderLen:])
+	c.raw = c.raw[:copy(c.raw, c.raw[recordHeaderLen+n:])]
+
+	data, err = c.in.open(typ, payload)
+	if err != nil {
+		return 0, nil, c.sendAlert(err.(alert))
+	}
+	if len(data) > maxPlaintext {
+		return 0, nil, c.sendAlert(alertRecordOverflow)
+	}
+	return typ, data, nil
+}
+
+// alertError handles the alert received from the peer.
+// It returns io.EOF for close_notify, nil for the warnings and the error for the fatal alerts.
+func alertError(data []byte) error {
+	if len(data) != 2 {
+		return alertDecodeError
+	}
+	if alert(data[1]) == alertCloseNotify {
+		return io.EOF
+	}
+	if data[0] == 1 {
+		return nil
+	}
+	return fmt.Errorf("tlspsk: remote error: %s", alert(data[1]).String())
+}
+
+// readHandshake reads the next handshake message. c.in must be held.
+func (c *Conn) readHandshake() ([]byte, error) {
+	for {
+		if b := c.hand.Bytes(); len(b) >= 4 {
+			n := int(b[1])<<16 | int(b[2])<<8 | int(b[3])
+			if n > maxHandshake {
+				return nil, c.sendAlert(alertIllegalParameter)
+			}
+			if len(b) >= 4+n {
+				msg := make([]byte, 4+n)
+				c.hand.Read(msg)
+				c.transcript.Write(msg)
+				return msg, nil
+			}
+		}
+		typ, data, err := c.readRecord()
+		if err != nil {
+			return nil, err
+		}
+		switch typ {
+		case recordTypeHandshake:
+			c.hand.Write(data)
+		case recordTypeAlert:
+			if err = alertError(data); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, c.sendAlert(alertUnexpectedMessage)
+		}
+	}
+}
+
+// readChangeCipherSpec reads the ChangeCipherSpec message and switches to the aead. c.in must be held.
+func (c *Conn) readChangeCipherSpec(aead cipher.AEAD, salt []byte) error {
+	typ, data, err := c.readRecord()
+	if err != nil {
+		return err
+	}
+	if typ == recordTypeAlert {
+		if err = alertError(data); err == nil {
+			err = c.sendAlert(alertUnexpectedMessage)
+		}
+		return err
+	}
+	// the ChangeCipherSpec must be on the handshake message boundary.
+	if typ != recordTypeChangeCipherSpec || len(data) != 1 || data[0] != 1 || c.hand.Len() != 0 {
+		return c.sendAlert(alertUnexpectedMessage)
+	}
+	c.in.setCipher(aead, salt)
+	return nil
+}
+
+// writeHandshake buffers the handshake message, it is sent by flush.
+func (c *Conn) writeHandshake(msg []byte) {
+	c.transcript.Write(msg)
+	c.out.Lock()
+	defer c.out.Unlock()
+	c.writeRecordLocked(recordTypeHandshake, msg)
+}
+
+// writeChangeCipherSpec buffers the ChangeCipherSpec message and switches to the aead.
+func (c *Conn) writeChangeCipherSpec(aead cipher.AEAD, salt []byte) {
+	c.out.Lock()
+	defer c.out.Unlock()
+	c.writeRecordLocked(recordTypeChangeCipherSpec, []byte{1})
+	c.out.setCipher(aead, salt)
+}
+
+// writeRecordLocked fragments the data into records and buffers them. c.out must be held.
+func (c *Conn) writeRecordLocked(typ uint8, data []byte) {
+	for len(data) > 0 {
+		n := len(data)
+		if n > c.maxFragment {
+			n = c.maxFragment
+		}
+		c.out.seal(typ, data[:n])
+		data = data[n:]
+	}
+}
+
+func (c *Conn) flush() error {
+	c.out.Lock()
+	defer c.out.Unlock()
+	return c.flushLocked()
+}
+
+// flushLocked sends the buffered records. c.out must be held.
+func (c *Conn) flushLocked() error {
+	if c.out.err != nil {
+		return c.out.err
+	}
+	if len(c.out.buf) == 0 {
+		return nil
+	}
+	_, err := c.conn.Write(c.out.buf)
+	c.out.buf = c.out.buf[:0]
+	if err != nil {
+		// a partial record cannot be resumed.
+		c.out.err = err
+	}
+	return err
+}
+
+// sendAlert sends the alert and returns it as the error.
+func (c *Conn) sendAlert(a alert) error {
+	c.out.Lock()
+	defer c.out.Unlock()
+	return c.sendAlertLocked(a)
+}
+
+func (c *Conn) sendAlertLocked(a alert) error {
+	level := uint8(2)
+	if a == alertCloseNotify || a == alertNoRenegotiation {
+		level = 1
+	}
+	c.writeRecordLocked(recordTypeAlert, []byte{level, uint8(a)})
+	err := c.flushLocked()
+	if a == alertCloseNotify {
+		return err
+	}
+	if level == 2 && c.out.err == nil {
+		c.out.err = a
+	}
+	return a
+}
+
+func (c *Conn) handshakeComplete() bool {
+	return atomic.LoadUint32(&c.handshakeStatus) == 1
+}
+
+// Handshake runs the handshake if it has not yet been run.
+// Most uses of this package need not call Handshake explicitly, the first Read or Write will call it automatically.
+func (c *Conn) Handshake() error {
+	c.handshakeMutex.Lock()
+	defer c.handshakeMutex.Unlock()
+	if c.handshakeErr != nil {
+		return c.handshakeErr
+	}
+	if c.handshakeComplete() {
+		return nil
+	}
+	c.in.Lock()
+	defer c.in.Unlock()
+	if c.isClient {
+		c.handshakeErr = c.clientHandshake()
+	} else {
+		c.handshakeErr = c.serverHandshake()
+	}
+	c.transcript = bytes.Buffer{}
+	if c.handshakeErr == nil {
+		atomic.StoreUint32(&c.handshakeStatus, 1)
+	}
+	return c.handshakeErr
+}
+
+// ConnectionState returns the state of the connection.
+func (c *Conn) ConnectionState() ConnectionState {
+	c.handshakeMutex.Lock()
+	defer c.handshakeMutex.Unlock()
+	state := ConnectionState{
+		HandshakeComplete: c.handshakeComplete(),
+	}
+	if state.HandshakeComplete {
+		state.CipherSuite = c.suite.id
+		state.Identity = c.identity
+	}
+	return state
+}
+
+// Read reads the application data from the connection.
+func (c *Conn) Read(b []byte) (int, error) {
+	if err := c.Handshake(); err != nil {
+		return 0, err
+	}
+	if len(b) == 0 {
+		return 0, nil
+	}
+	c.in.Lock()
+	defer c.in.Unlock()
+	for len(c.input) == 0 {
+		if c.in.err != nil {
+			return 0, c.in.err
+		}
+		if err := c.readApplicationData(); err != nil {
+			if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+				c.in.err = err
+			}
+			return 0, err
+		}
+	}
+	n := copy(b, c.input)
+	c.input = c.input[n:]
+	return n, nil
+}
+
+// readApplicationData reads the next record after the handshake. c.in must be held.
+func (c *Conn) readApplicationData() error {
+	typ, data, err := c.readRecord()
+	if err != nil {
+		return err
+	}
+	switch typ {
+	case recordTypeApplicationData:
+		c.input = data
+	case recordTypeAlert:
+		return alertError(data)
+	case recordTypeHandshake:
+		// the renegotiation is not supported.
+		c.hand.Write(data)
+		if b := c.hand.Bytes(); len(b) >= 4 && len(b) >= 4+(int(b[1])<<16|int(b[2])<<8|int(b[3])) {
+			c.hand.Reset()
+			_ = c.sendAlert(alertNoRenegotiation)
+		}
+	default:
+		return c.sendAlert(alertUnexpectedMessage)
+	}
+	return nil
+}
+
+// Write writes the application data to the connection.
+func (c *Conn) Write(b []byte) (int, error) {
+	for {
+		x := atomic.LoadInt32(&c.activeCall)
+		if x&1 != 0 {
+			return 0, errClosed
+		}
+		if atomic.CompareAndSwapInt32(&c.activeCall, x, x+2) {
+			break
+		}
+	}
+	defer atomic.AddInt32(&c.activeCall, -2)
+
+	if err := c.Handshake(); err != nil {
+		return 0, err
+	}
+	c.out.Lock()
+	defer c.out.Unlock()
+	if c.out.err != nil {
+		return 0, c.out.err
+	}
+	c.writeRecordLocked(recordTypeApplicationData, b)
+	if err := c.flushLocked(); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// Close sends the close_notify alert if the handshake has completed, and closes the underlying connection.
+func (c *Conn) Close() error {
+	var x int32
+	for {
+		x = atomic.LoadInt32(&c.activeCall)
+		if x&1 != 0 {
+			return errClosed
+		}
+		if atomic.CompareAndSwapInt32(&c.activeCall, x, x|1) {
+			break
+		}
+	}
+	if x != 0 {
+		// Close is called while Write is in-flight, which is usually to break the Write,
+		// so don't wait for the Write to send the close_notify.
+		return c.conn.Close()
+	}
+	var alertErr error
+	if c.handshakeComplete() {
+		alertErr = c.closeNotify()
+	}
+	if err := c.conn.Close(); err != nil {
+		return err
+	}
+	return alertErr
+}
+
+func (c *Conn) closeNotify() error {
+	c.out.Lock()
+	defer c.out.Unlock()
+	if c.closeNotifySent || c.out.err != nil {
+		return nil
+	}
+	c.closeNotifySent = true
+	_ = c.conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+	return c.sendAlertLocked(alertCloseNotify)
+}
+
+// NetConn returns the underlying connection.
+func (c *Conn) NetConn() net.Conn {
+	return c.conn
+}
+
+// LocalAddr returns the local network address.
+func (c *Conn) LocalAddr() net.Addr {
+	return c.conn.LocalAddr()
+}
+
+// RemoteAddr returns the remote network address.
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// SetDeadline sets the read and write deadlines of the underlying connection.
+func (c *Conn) SetDeadline(t time.Time) error {
+	return c.conn.SetDeadline(t)
+}
+
+// SetReadDeadline sets the read deadline of the underlying connection.
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+// SetWriteDeadline sets the write deadline of the underlying connection.
+// A Write that is timed out leaves the connection in an undefined state, all future writes will fail.
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	return c.conn.SetWriteDeadline(t)
+}
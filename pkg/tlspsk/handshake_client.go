@@ -0,0 +1,152 @@
+package tlspsk
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+
+	"golang.org/x/crypto/cryptobyte"
+)
+
+func (c *Conn) clientHandshake() error {
+	if len(c.config.Key) == 0 {
+		return errors.New("tlspsk: missing key")
+	}
+	suites := c.config.cipherSuites()
+	clientRandom := make([]byte, 32)
+	if _, err := rand.Read(clientRandom); err != nil {
+		return err
+	}
+	c.writeHandshake(handshakeMessage(typeClientHello, func(b *cryptobyte.Builder) {
+		b.AddUint16(versionTLS12)
+		b.AddBytes(clientRandom)
+		b.AddUint8(0)
+		b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+			for _, id := range suites {
+				b.AddUint16(id)
+			}
+			b.AddUint16(scsvRenegotiation)
+		})
+		b.AddUint8LengthPrefixed(func(b *cryptobyte.Builder) {
+			b.AddUint8(0)
+		})
+		b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+			b.AddUint16(extensionExtendedMasterSecret)
+			b.AddUint16(0)
+		})
+	}))
+	if err := c.flush(); err != nil {
+		return err
+	}
+
+	msg, err := c.readHandshake()
+	if err != nil {
+		return err
+	}
+	if msg[0] != typeServerHello {
+		return c.sendAlert(alertUnexpectedMessage)
+	}
+	s := cryptobyte.String(msg[4:])
+	var (
+		vers, suite           uint16
+		compression           uint8
+		serverRandom          []byte
+		sessionID, extensions cryptobyte.String
+		ems                   bool
+	)
+	if !s.ReadUint16(&vers) || !s.ReadBytes(&serverRandom, 32) || !s.ReadUint8LengthPrefixed(&sessionID) ||
+		!s.ReadUint16(&suite) || !s.ReadUint8(&compression) {
+		return c.sendAlert(alertDecodeError)
+	}
+	if !s.Empty() && (!s.ReadUint16LengthPrefixed(&extensions) || !s.Empty()) {
+		return c.sendAlert(alertDecodeError)
+	}
+	for !extensions.Empty() {
+		var ext uint16
+		var data cryptobyte.String
+		if !extensions.ReadUint16(&ext) || !extensions.ReadUint16LengthPrefixed(&data) {
+			return c.sendAlert(alertDecodeError)
+		}
+		switch ext {
+		case extensionExtendedMasterSecret:
+			ems = true
+		case extensionRenegotiationInfo:
+			var info cryptobyte.String
+			if !data.ReadUint8LengthPrefixed(&info) || !info.Empty() {
+				return c.sendAlert(alertHandshakeFailure)
+			}
+		default:
+			return c.sendAlert(alertUnsupportedExtension)
+		}
+	}
+	if vers != versionTLS12 {
+		return c.sendAlert(alertProtocolVersion)
+	}
+	if compression != 0 {
+		return c.sendAlert(alertIllegalParameter)
+	}
+	for _, id := range suites {
+		if id == suite {
+			c.suite = cipherSuiteByID(id)
+		}
+	}
+	if c.suite == nil {
+		return c.sendAlert(alertIllegalParameter)
+	}
+
+	msg, err = c.readHandshake()
+	if err != nil {
+		return err
+	}
+	if msg[0] == typeServerKeyExchange {
+		// the identity hint is ignored, the identity is given by the config.
+		msg, err = c.readHandshake()
+		if err != nil {
+			return err
+		}
+	}
+	if msg[0] != typeServerHelloDone {
+		return c.sendAlert(alertUnexpectedMessage)
+	}
+
+	c.identity = c.config.Identity
+	c.writeHandshake(handshakeMessage(typeClientKeyExchange, func(b *cryptobyte.Builder) {
+		b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+			b.AddBytes([]byte(c.identity))
+		})
+	}))
+	master := masterSecret(c.suite, premasterSecret(c.config.Key), clientRandom, serverRandom,
+		c.transcriptHash(), ems)
+	clientKey, serverKey, clientIV, serverIV := keysFromMasterSecret(c.suite, master, clientRandom, serverRandom)
+	clientAEAD, err := c.suite.aead(clientKey)
+	if err != nil {
+		return err
+	}
+	serverAEAD, err := c.suite.aead(serverKey)
+	if err != nil {
+		return err
+	}
+	c.writeChangeCipherSpec(clientAEAD, clientIV)
+	c.writeHandshake(handshakeMessage(typeFinished, func(b *cryptobyte.Builder) {
+		b.AddBytes(finishedHash(c.suite, master, labelClientFinished, c.transcript.Bytes()))
+	}))
+	if err = c.flush(); err != nil {
+		return err
+	}
+
+	if err = c.readChangeCipherSpec(serverAEAD, serverIV); err != nil {
+		return err
+	}
+	expected := finishedHash(c.suite, master, labelServerFinished, c.transcript.Bytes())
+	msg, err = c.readHandshake()
+	if err != nil {
+		return err
+	}
+	if msg[0] != typeFinished {
+		return c.sendAlert(alertUnexpectedMessage)
+	}
+	if len(msg) != 4+finishedLength || subtle.ConstantTimeCompare(msg[4:], expected) != 1 {
+		return c.sendAlert(alertDecryptError)
+	}
+	return nil
+}
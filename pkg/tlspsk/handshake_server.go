@@ -0,0 +1,250 @@
+package tlspsk
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"fmt"
+
+	"golang.org/x/crypto/cryptobyte"
+)
+
+const (
+	typeClientHello       uint8 = 1
+	typeServerHello       uint8 = 2
+	typeServerKeyExchange uint8 = 12
+	typeServerHelloDone   uint8 = 14
+	typeClientKeyExchange uint8 = 16
+	typeFinished          uint8 = 20
+)
+
+const (
+	extensionMaxFragmentLength    uint16 = 1
+	extensionExtendedMasterSecret uint16 = 23
+	extensionRenegotiationInfo    uint16 = 0xff01
+)
+
+// scsvRenegotiation is the TLS_EMPTY_RENEGOTIATION_INFO_SCSV (RFC 5746).
+const scsvRenegotiation uint16 = 0x00ff
+
+type clientHello struct {
+	vers                uint16
+	random              []byte
+	cipherSuites        []uint16
+	nullCompression     bool
+	secureRenegotiation bool
+	ems                 bool
+	// maxFragmentLength is the code of the max_fragment_length extension (RFC 6066), 0 means not requested.
+	maxFragmentLength uint8
+}
+
+func parseClientHello(msg []byte) (*clientHello, bool) {
+	hello := &clientHello{}
+	s := cryptobyte.String(msg[4:])
+	var sessionID, suites, compressions cryptobyte.String
+	if !s.ReadUint16(&hello.vers) || !s.ReadBytes(&hello.random, 32) ||
+		!s.ReadUint8LengthPrefixed(&sessionID) || len(sessionID) > 32 ||
+		!s.ReadUint16LengthPrefixed(&suites) || len(suites) == 0 || len(suites)%2 != 0 ||
+		!s.ReadUint8LengthPrefixed(&compressions) || len(compressions) == 0 {
+		return nil, false
+	}
+	for !suites.Empty() {
+		var id uint16
+		suites.ReadUint16(&id)
+		if id == scsvRenegotiation {
+			hello.secureRenegotiation = true
+		}
+		hello.cipherSuites = append(hello.cipherSuites, id)
+	}
+	for _, v := range compressions {
+		if v == 0 {
+			hello.nullCompression = true
+		}
+	}
+	if s.Empty() {
+		return hello, true
+	}
+	var extensions cryptobyte.String
+	if !s.ReadUint16LengthPrefixed(&extensions) || !s.Empty() {
+		return nil, false
+	}
+	for !extensions.Empty() {
+		var ext uint16
+		var data cryptobyte.String
+		if !extensions.ReadUint16(&ext) || !extensions.ReadUint16LengthPrefixed(&data) {
+			return nil, false
+		}
+		switch ext {
+		case extensionRenegotiationInfo:
+			var info cryptobyte.String
+			// the initial handshake must carry an empty renegotiated_connection.
+			if !data.ReadUint8LengthPrefixed(&info) || !info.Empty() || !data.Empty() {
+				return nil, false
+			}
+			hello.secureRenegotiation = true
+		case extensionExtendedMasterSecret:
+			if !data.Empty() {
+				return nil, false
+			}
+			hello.ems = true
+		case extensionMaxFragmentLength:
+			if !data.ReadUint8(&hello.maxFragmentLength) || !data.Empty() {
+				return nil, false
+			}
+		}
+	}
+	return hello, true
+}
+
+// handshakeMessage returns the handshake message of the type with the body built by f.
+func handshakeMessage(typ uint8, f cryptobyte.BuilderContinuation) []byte {
+	var b cryptobyte.Builder
+	b.AddUint8(typ)
+	b.AddUint24LengthPrefixed(f)
+	return b.BytesOrPanic()
+}
+
+func (c *Conn) serverHandshake() error {
+	msg, err := c.readHandshake()
+	if err != nil {
+		return err
+	}
+	if msg[0] != typeClientHello {
+		return c.sendAlert(alertUnexpectedMessage)
+	}
+	hello, ok := parseClientHello(msg)
+	if !ok {
+		return c.sendAlert(alertDecodeError)
+	}
+	if hello.vers < versionTLS12 {
+		return c.sendAlert(alertProtocolVersion)
+	}
+	if !hello.nullCompression {
+		return c.sendAlert(alertIllegalParameter)
+	}
+	if hello.maxFragmentLength > 4 {
+		return c.sendAlert(alertIllegalParameter)
+	}
+	// server preference
+	for _, id := range c.config.cipherSuites() {
+		for _, v := range hello.cipherSuites {
+			if id == v {
+				c.suite = cipherSuiteByID(id)
+				break
+			}
+		}
+		if c.suite != nil {
+			break
+		}
+	}
+	if c.suite == nil {
+		return c.sendAlert(alertHandshakeFailure)
+	}
+
+	serverRandom := make([]byte, 32)
+	if _, err = rand.Read(serverRandom); err != nil {
+		c.sendAlert(alertInternalError)
+		return err
+	}
+	c.writeHandshake(handshakeMessage(typeServerHello, func(b *cryptobyte.Builder) {
+		b.AddUint16(versionTLS12)
+		b.AddBytes(serverRandom)
+		// empty session id, the session resumption is not supported.
+		b.AddUint8(0)
+		b.AddUint16(c.suite.id)
+		b.AddUint8(0)
+		if !hello.secureRenegotiation && !hello.ems && hello.maxFragmentLength == 0 {
+			return
+		}
+		b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+			if hello.secureRenegotiation {
+				b.AddUint16(extensionRenegotiationInfo)
+				b.AddUint16(1)
+				b.AddUint8(0)
+			}
+			if hello.ems {
+				b.AddUint16(extensionExtendedMasterSecret)
+				b.AddUint16(0)
+			}
+			if hello.maxFragmentLength != 0 {
+				b.AddUint16(extensionMaxFragmentLength)
+				b.AddUint16(1)
+				b.AddUint8(hello.maxFragmentLength)
+			}
+		})
+	}))
+	if c.config.IdentityHint != "" {
+		c.writeHandshake(handshakeMessage(typeServerKeyExchange, func(b *cryptobyte.Builder) {
+			b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+				b.AddBytes([]byte(c.config.IdentityHint))
+			})
+		}))
+	}
+	c.writeHandshake(handshakeMessage(typeServerHelloDone, func(b *cryptobyte.Builder) {}))
+	if err = c.flush(); err != nil {
+		return err
+	}
+	if hello.maxFragmentLength != 0 {
+		c.maxFragment = 1 << (8 + hello.maxFragmentLength)
+	}
+
+	msg, err = c.readHandshake()
+	if err != nil {
+		return err
+	}
+	if msg[0] != typeClientKeyExchange {
+		return c.sendAlert(alertUnexpectedMessage)
+	}
+	s := cryptobyte.String(msg[4:])
+	var identity cryptobyte.String
+	if !s.ReadUint16LengthPrefixed(&identity) || !s.Empty() {
+		return c.sendAlert(alertDecodeError)
+	}
+	c.identity = string(identity)
+	key, err := c.config.GetKey(c.identity)
+	if err != nil {
+		c.sendAlert(alertInternalError)
+		return fmt.Errorf("tlspsk: failed to get the key of identity %q: %s", c.identity, err)
+	}
+	if len(key) == 0 {
+		c.sendAlert(alertUnknownPSKIdentity)
+		return fmt.Errorf("tlspsk: unknown PSK identity %q", c.identity)
+	}
+
+	master := masterSecret(c.suite, premasterSecret(key), hello.random, serverRandom,
+		c.transcriptHash(), hello.ems)
+	clientKey, serverKey, clientIV, serverIV := keysFromMasterSecret(c.suite, master, hello.random, serverRandom)
+	clientAEAD, err := c.suite.aead(clientKey)
+	if err != nil {
+		return err
+	}
+	serverAEAD, err := c.suite.aead(serverKey)
+	if err != nil {
+		return err
+	}
+	if err = c.readChangeCipherSpec(clientAEAD, clientIV); err != nil {
+		return err
+	}
+	expected := finishedHash(c.suite, master, labelClientFinished, c.transcript.Bytes())
+	msg, err = c.readHandshake()
+	if err != nil {
+		return err
+	}
+	if msg[0] != typeFinished {
+		return c.sendAlert(alertUnexpectedMessage)
+	}
+	if len(msg) != 4+finishedLength || subtle.ConstantTimeCompare(msg[4:], expected) != 1 {
+		return c.sendAlert(alertDecryptError)
+	}
+
+	c.writeChangeCipherSpec(serverAEAD, serverIV)
+	c.writeHandshake(handshakeMessage(typeFinished, func(b *cryptobyte.Builder) {
+		b.AddBytes(finishedHash(c.suite, master, labelServerFinished, c.transcript.Bytes()))
+	}))
+	return c.flush()
+}
+
+func (c *Conn) transcriptHash() []byte {
+	h := c.suite.hash()
+	h.Write(c.transcript.Bytes())
+	return h.Sum(nil)
+}
@@ -0,0 +1,147 @@
+package tlspsk
+
+import (
+	"bufio"
+	"context"
+	"encoding/hex"
+	"net"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// opensslCiphers maps the cipher suites to the OpenSSL cipher names.
+var opensslCiphers = map[uint16]string{
+	TLS_PSK_WITH_AES_128_GCM_SHA256: "PSK-AES128-GCM-SHA256",
+	TLS_PSK_WITH_AES_256_GCM_SHA384: "PSK-AES256-GCM-SHA384",
+	TLS_PSK_WITH_AES_128_CCM:        "PSK-AES128-CCM",
+	TLS_PSK_WITH_AES_128_CCM_8:      "PSK-AES128-CCM8",
+}
+
+func lookPathOpenSSL(t *testing.T) string {
+	path, err := exec.LookPath("openssl")
+	if err != nil {
+		t.Skip("openssl is not installed, skip the interoperability tests")
+	}
+	return path
+}
+
+// TestOpenSSL_client runs the OpenSSL client against the Server.
+func TestOpenSSL_client(t *testing.T) {
+	openssl := lookPathOpenSSL(t)
+	for _, suite := range CipherSuites() {
+		t.Run(suite.Name, func(t *testing.T) {
+			a := assert.New(t)
+			ln, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer ln.Close()
+			done := make(chan error, 1)
+			var state ConnectionState
+			go func() {
+				c, err := ln.Accept()
+				if err != nil {
+					done <- err
+					return
+				}
+				sc := Server(c, &Config{
+					IdentityHint: "gmqtt",
+					GetKey:       testGetKey,
+					CipherSuites: []uint16{suite.ID},
+				})
+				defer sc.Close()
+				sc.SetDeadline(time.Now().Add(10 * time.Second))
+				line, err := bufio.NewReader(sc).ReadString('\n')
+				if err != nil {
+					done <- err
+					return
+				}
+				state = sc.ConnectionState()
+				_, err = sc.Write([]byte("pong " + line))
+				done <- err
+			}()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			// -quiet implies -ign_eof, the client exits after the server closes the connection.
+			cmd := exec.CommandContext(ctx, openssl, "s_client", "-tls1_2", "-quiet",
+				"-psk", hex.EncodeToString(testKey), "-psk_identity", "dev1",
+				"-cipher", opensslCiphers[suite.ID]+":@SECLEVEL=0",
+				"-connect", ln.Addr().String())
+			cmd.Stdin = strings.NewReader("ping\n")
+			out, err := cmd.Output()
+			a.NoError(<-done)
+			a.NoError(err)
+			a.Equal("pong ping\n", string(out))
+			a.True(state.HandshakeComplete)
+			a.Equal(suite.ID, state.CipherSuite)
+			a.Equal("dev1", state.Identity)
+		})
+	}
+}
+
+// TestOpenSSL_server runs the Client against the OpenSSL server.
+func TestOpenSSL_server(t *testing.T) {
+	openssl := lookPathOpenSSL(t)
+	for _, suite := range CipherSuites() {
+		t.Run(suite.Name, func(t *testing.T) {
+			a := assert.New(t)
+			ln, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			addr := ln.Addr().String()
+			ln.Close()
+			_, port, _ := net.SplitHostPort(addr)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			// -rev sends back the received lines reversed.
+			cmd := exec.CommandContext(ctx, openssl, "s_server", "-tls1_2", "-nocert", "-naccept", "1", "-rev",
+				"-psk", hex.EncodeToString(testKey), "-psk_identity", "dev1", "-psk_hint", "gmqtt",
+				"-cipher", opensslCiphers[suite.ID]+":@SECLEVEL=0",
+				"-accept", "127.0.0.1:"+port)
+			stdout, err := cmd.StdoutPipe()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := cmd.Start(); err != nil {
+				t.Fatal(err)
+			}
+			defer cmd.Wait()
+			defer cancel()
+			// wait for the server to listen.
+			s := bufio.NewScanner(stdout)
+			for s.Scan() && s.Text() != "ACCEPT" {
+			}
+			go func() {
+				for s.Scan() {
+				}
+			}()
+
+			c, err := net.Dial("tcp", addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cc := Client(c, &Config{
+				Identity:     "dev1",
+				Key:          testKey,
+				CipherSuites: []uint16{suite.ID},
+			})
+			defer cc.Close()
+			cc.SetDeadline(time.Now().Add(10 * time.Second))
+			_, err = cc.Write([]byte("ping\n"))
+			a.NoError(err)
+			line, err := bufio.NewReader(cc).ReadString('\n')
+			a.NoError(err)
+			a.Equal("gnip\n", line)
+			state := cc.ConnectionState()
+			a.True(state.HandshakeComplete)
+			a.Equal(suite.ID, state.CipherSuite)
+		})
+	}
+}
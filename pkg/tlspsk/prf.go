@@ -0,0 +1,91 @@
+package tlspsk
+
+import (
+	"crypto/hmac"
+	"encoding/binary"
+	"hash"
+)
+
+const (
+	masterSecretLength = 48
+	finishedLength     = 12
+	// implicitNonceLength is the length of the salt part of the AEAD nonce, which is derived from the key block.
+	implicitNonceLength = 4
+	// explicitNonceLength is the length of the nonce part carried in each record.
+	explicitNonceLength = 8
+)
+
+const (
+	labelMasterSecret         = "master secret"
+	labelExtendedMasterSecret = "extended master secret"
+	labelKeyExpansion         = "key expansion"
+	labelClientFinished       = "client finished"
+	labelServerFinished       = "server finished"
+)
+
+// prf is the TLS 1.2 pseudo-random function (RFC 5246, Section 5).
+func prf(h func() hash.Hash, secret []byte, label string, seed []byte, n int) []byte {
+	labelAndSeed := make([]byte, 0, len(label)+len(seed))
+	labelAndSeed = append(labelAndSeed, label...)
+	labelAndSeed = append(labelAndSeed, seed...)
+
+	result := make([]byte, n)
+	mac := hmac.New(h, secret)
+	mac.Write(labelAndSeed)
+	a := mac.Sum(nil)
+	for j := 0; j < n; {
+		mac.Reset()
+		mac.Write(a)
+		mac.Write(labelAndSeed)
+		j += copy(result[j:], mac.Sum(nil))
+
+		mac.Reset()
+		mac.Write(a)
+		a = mac.Sum(nil)
+	}
+	return result
+}
+
+// premasterSecret returns the premaster secret of the plain PSK key exchange (RFC 4279, Section 2),
+// which is the key prefixed by the same number of zeros.
+func premasterSecret(key []byte) []byte {
+	n := len(key)
+	b := make([]byte, 2+n+2+n)
+	binary.BigEndian.PutUint16(b, uint16(n))
+	binary.BigEndian.PutUint16(b[2+n:], uint16(n))
+	copy(b[4+n:], key)
+	return b
+}
+
+// masterSecret derives the master secret. If the extended master secret (RFC 7627) is negotiated,
+// the sessionHash is the hash of the handshake messages up to and including the ClientKeyExchange.
+func masterSecret(suite *cipherSuite, premaster, clientRandom, serverRandom, sessionHash []byte, ems bool) []byte {
+	if ems {
+		return prf(suite.hash, premaster, labelExtendedMasterSecret, sessionHash, masterSecretLength)
+	}
+	seed := make([]byte, 0, len(clientRandom)+len(serverRandom))
+	seed = append(seed, clientRandom...)
+	seed = append(seed, serverRandom...)
+	return prf(suite.hash, premaster, labelMasterSecret, seed, masterSecretLength)
+}
+
+// keysFromMasterSecret returns the write keys and the implicit nonces of the AEAD cipher suites.
+func keysFromMasterSecret(suite *cipherSuite, master, clientRandom, serverRandom []byte) (clientKey, serverKey, clientIV, serverIV []byte) {
+	seed := make([]byte, 0, len(serverRandom)+len(clientRandom))
+	seed = append(seed, serverRandom...)
+	seed = append(seed, clientRandom...)
+	n := 2*suite.keyLen + 2*implicitNonceLength
+	block := prf(suite.hash, master, labelKeyExpansion, seed, n)
+	clientKey, block = block[:suite.keyLen], block[suite.keyLen:]
+	serverKey, block = block[:suite.keyLen], block[suite.keyLen:]
+	clientIV, block = block[:implicitNonceLength], block[implicitNonceLength:]
+	serverIV = block[:implicitNonceLength]
+	return
+}
+
+// finishedHash returns the verify_data of the Finished message.
+func finishedHash(suite *cipherSuite, master []byte, label string, transcript []byte) []byte {
+	h := suite.hash()
+	h.Write(transcript)
+	return prf(suite.hash, master, label, h.Sum(nil), finishedLength)
+}
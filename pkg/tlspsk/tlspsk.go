@@ -0,0 +1,125 @@
+// Package tlspsk implements the TLS 1.2 pre-shared key cipher suites (RFC 4279, RFC 5487, RFC 6655),
+// which are not supported by crypto/tls. It is intended for the constrained devices that can't handle X.509.
+// Only the AEAD cipher suites are implemented, the session resumption and the renegotiation are not supported.
+package tlspsk
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"crypto/sha512"
+	"hash"
+	"net"
+)
+
+// The supported cipher suites.
+const (
+	TLS_PSK_WITH_AES_128_GCM_SHA256 uint16 = 0x00a8
+	TLS_PSK_WITH_AES_256_GCM_SHA384 uint16 = 0x00a9
+	TLS_PSK_WITH_AES_128_CCM        uint16 = 0xc0a4
+	TLS_PSK_WITH_AES_128_CCM_8      uint16 = 0xc0a8
+)
+
+// CipherSuite is a cipher suite supported by the package.
+type CipherSuite struct {
+	ID   uint16
+	Name string
+}
+
+type cipherSuite struct {
+	id     uint16
+	name   string
+	keyLen int
+	hash   func() hash.Hash
+	aead   func(key []byte) (cipher.AEAD, error)
+}
+
+// cipherSuites is ordered by the default preference.
+var cipherSuites = []*cipherSuite{
+	{TLS_PSK_WITH_AES_128_GCM_SHA256, "TLS_PSK_WITH_AES_128_GCM_SHA256", 16, sha256.New, aeadAESGCM},
+	{TLS_PSK_WITH_AES_256_GCM_SHA384, "TLS_PSK_WITH_AES_256_GCM_SHA384", 32, sha512.New384, aeadAESGCM},
+	{TLS_PSK_WITH_AES_128_CCM, "TLS_PSK_WITH_AES_128_CCM", 16, sha256.New, aeadAESCCM(16)},
+	{TLS_PSK_WITH_AES_128_CCM_8, "TLS_PSK_WITH_AES_128_CCM_8", 16, sha256.New, aeadAESCCM(8)},
+}
+
+func aeadAESGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func aeadAESCCM(tagSize int) func(key []byte) (cipher.AEAD, error) {
+	return func(key []byte) (cipher.AEAD, error) {
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		return newCCM(block, 12, tagSize)
+	}
+}
+
+func cipherSuiteByID(id uint16) *cipherSuite {
+	for _, v := range cipherSuites {
+		if v.id == id {
+			return v
+		}
+	}
+	return nil
+}
+
+// CipherSuites returns the supported cipher suites in the order of the default preference.
+func CipherSuites() []CipherSuite {
+	var rs []CipherSuite
+	for _, v := range cipherSuites {
+		rs = append(rs, CipherSuite{ID: v.id, Name: v.name})
+	}
+	return rs
+}
+
+// Config is the configuration of the TLS-PSK client or server.
+type Config struct {
+	// CipherSuites is the enabled cipher suites in the order of preference, defaults to all supported suites.
+	CipherSuites []uint16
+	// IdentityHint is sent to the client to help it select the identity, optional. It is only used by the server.
+	IdentityHint string
+	// GetKey returns the key of the identity sent by the client, or nil if the identity is unknown.
+	// It is called in the handshake and only used by the server.
+	GetKey func(identity string) ([]byte, error)
+	// Identity is the PSK identity of the client, it is only used by the client.
+	Identity string
+	// Key is the PSK of the client, it is only used by the client.
+	Key []byte
+}
+
+func (c *Config) cipherSuites() []uint16 {
+	if len(c.CipherSuites) != 0 {
+		return c.CipherSuites
+	}
+	ids := make([]uint16, 0, len(cipherSuites))
+	for _, v := range cipherSuites {
+		ids = append(ids, v.id)
+	}
+	return ids
+}
+
+// ConnectionState is the state of the TLS-PSK connection.
+type ConnectionState struct {
+	HandshakeComplete bool
+	CipherSuite       uint16
+	// Identity is the PSK identity sent by the client.
+	Identity string
+}
+
+// Server returns a new TLS-PSK server side connection using conn as the underlying transport.
+// The handshake is performed on the first Read or Write, or by calling Handshake explicitly.
+func Server(conn net.Conn, config *Config) *Conn {
+	return newConn(conn, config, false)
+}
+
+// Client returns a new TLS-PSK client side connection using conn as the underlying transport.
+// The handshake is performed on the first Read or Write, or by calling Handshake explicitly.
+func Client(conn net.Conn, config *Config) *Conn {
+	return newConn(conn, config, true)
+}
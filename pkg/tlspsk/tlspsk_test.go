@@ -0,0 +1,331 @@
+package tlspsk
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testKey = []byte("0123456789abcdef")
+
+func testGetKey(identity string) ([]byte, error) {
+	switch identity {
+	case "dev1":
+		return testKey, nil
+	case "broken":
+		return nil, errors.New("store unavailable")
+	}
+	return nil, nil
+}
+
+// pipe returns the connected client and server over the loopback.
+func pipe(t *testing.T, client, server *Config) (*Conn, *Conn) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		c, _ := ln.Accept()
+		accepted <- c
+	}()
+	c, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return Client(c, client), Server(<-accepted, server)
+}
+
+// handshake runs the handshake of both sides and returns the errors.
+func handshake(cc, sc *Conn) (clientErr, serverErr error) {
+	done := make(chan error, 1)
+	go func() {
+		done <- sc.Handshake()
+	}()
+	clientErr = cc.Handshake()
+	if clientErr != nil {
+		// unblock the server if the client fails first.
+		cc.conn.Close()
+	}
+	return clientErr, <-done
+}
+
+func TestConn(t *testing.T) {
+	for _, suite := range CipherSuites() {
+		t.Run(suite.Name, func(t *testing.T) {
+			a := assert.New(t)
+			cc, sc := pipe(t, &Config{
+				Identity:     "dev1",
+				Key:          testKey,
+				CipherSuites: []uint16{suite.ID},
+			}, &Config{
+				IdentityHint: "gmqtt",
+				GetKey:       testGetKey,
+			})
+			defer cc.Close()
+			defer sc.Close()
+			clientErr, serverErr := handshake(cc, sc)
+			a.NoError(clientErr)
+			a.NoError(serverErr)
+			a.Equal(ConnectionState{HandshakeComplete: true, CipherSuite: suite.ID, Identity: "dev1"}, sc.ConnectionState())
+
+			// larger than a record.
+			payload := bytes.Repeat([]byte("0123456789"), 5000)
+			go func() {
+				cc.Write(payload)
+			}()
+			b := make([]byte, len(payload))
+			_, err := io.ReadFull(sc, b)
+			a.NoError(err)
+			a.Equal(payload, b)
+
+			_, err = sc.Write([]byte("pong"))
+			a.NoError(err)
+			_, err = io.ReadFull(cc, b[:4])
+			a.NoError(err)
+			a.Equal("pong", string(b[:4]))
+
+			a.NoError(cc.Close())
+			_, err = sc.Read(b)
+			a.Equal(io.EOF, err)
+		})
+	}
+}
+
+func TestConn_serverPreference(t *testing.T) {
+	a := assert.New(t)
+	cc, sc := pipe(t, &Config{
+		Identity: "dev1",
+		Key:      testKey,
+	}, &Config{
+		GetKey:       testGetKey,
+		CipherSuites: []uint16{TLS_PSK_WITH_AES_128_CCM_8, TLS_PSK_WITH_AES_128_GCM_SHA256},
+	})
+	defer cc.Close()
+	defer sc.Close()
+	clientErr, serverErr := handshake(cc, sc)
+	a.NoError(clientErr)
+	a.NoError(serverErr)
+	a.Equal(TLS_PSK_WITH_AES_128_CCM_8, cc.ConnectionState().CipherSuite)
+}
+
+func TestConn_handshakeFailure(t *testing.T) {
+	var tt = []struct {
+		name   string
+		client *Config
+		server *Config
+	}{
+		{
+			name:   "unknown_identity",
+			client: &Config{Identity: "dev2", Key: testKey},
+			server: &Config{GetKey: testGetKey},
+		},
+		{
+			name:   "wrong_key",
+			client: &Config{Identity: "dev1", Key: []byte("fedcba9876543210")},
+			server: &Config{GetKey: testGetKey},
+		},
+		{
+			name:   "get_key_error",
+			client: &Config{Identity: "broken", Key: testKey},
+			server: &Config{GetKey: testGetKey},
+		},
+		{
+			name:   "no_common_suite",
+			client: &Config{Identity: "dev1", Key: testKey, CipherSuites: []uint16{TLS_PSK_WITH_AES_128_CCM}},
+			server: &Config{GetKey: testGetKey, CipherSuites: []uint16{TLS_PSK_WITH_AES_128_GCM_SHA256}},
+		},
+	}
+	for _, v := range tt {
+		t.Run(v.name, func(t *testing.T) {
+			a := assert.New(t)
+			cc, sc := pipe(t, v.client, v.server)
+			defer cc.Close()
+			defer sc.Close()
+			clientErr, serverErr := handshake(cc, sc)
+			a.Error(clientErr)
+			a.Error(serverErr)
+			// the handshake error is permanent.
+			_, err := sc.Read(make([]byte, 1))
+			a.Equal(serverErr, err)
+			a.False(sc.ConnectionState().HandshakeComplete)
+		})
+	}
+}
+
+func TestConn_readDeadline(t *testing.T) {
+	a := assert.New(t)
+	cc, sc := pipe(t, &Config{Identity: "dev1", Key: testKey}, &Config{GetKey: testGetKey})
+	defer cc.Close()
+	defer sc.Close()
+	clientErr, serverErr := handshake(cc, sc)
+	a.NoError(clientErr)
+	a.NoError(serverErr)
+
+	a.NoError(sc.SetReadDeadline(time.Now().Add(10 * time.Millisecond)))
+	b := make([]byte, 4)
+	_, err := sc.Read(b)
+	a.True(err.(net.Error).Timeout())
+
+	// the connection can be read after the timeout.
+	a.NoError(sc.SetReadDeadline(time.Time{}))
+	_, err = cc.Write([]byte("ping"))
+	a.NoError(err)
+	_, err = io.ReadFull(sc, b)
+	a.NoError(err)
+	a.Equal("ping", string(b))
+}
+
+func TestCCM(t *testing.T) {
+	var tt = []struct {
+		name      string
+		key       string
+		nonce     string
+		ad        string
+		plaintext string
+		tagSize   int
+		expected  string
+	}{
+		{
+			name:      "RFC 3610 Packet Vector #1",
+			key:       "c0c1c2c3c4c5c6c7c8c9cacbcccdcecf",
+			nonce:     "00000003020100a0a1a2a3a4a5",
+			ad:        "0001020304050607",
+			plaintext: "08090a0b0c0d0e0f101112131415161718191a1b1c1d1e",
+			tagSize:   8,
+			expected:  "588c979a61c663d2f066d0c2c0f989806d5f6b61dac38417e8d12cfdf926e0",
+		},
+		{
+			name:      "SP 800-38C Example 1",
+			key:       "404142434445464748494a4b4c4d4e4f",
+			nonce:     "10111213141516",
+			ad:        "0001020304050607",
+			plaintext: "20212223",
+			tagSize:   4,
+			expected:  "7162015b4dac255d",
+		},
+		{
+			name:      "SP 800-38C Example 2",
+			key:       "404142434445464748494a4b4c4d4e4f",
+			nonce:     "1011121314151617",
+			ad:        "000102030405060708090a0b0c0d0e0f",
+			plaintext: "202122232425262728292a2b2c2d2e2f",
+			tagSize:   6,
+			expected:  "d2a1f0e051ea5f62081a7792073d593d1fc64fbfaccd",
+		},
+		{
+			name:      "SP 800-38C Example 3",
+			key:       "404142434445464748494a4b4c4d4e4f",
+			nonce:     "101112131415161718191a1b",
+			ad:        "000102030405060708090a0b0c0d0e0f10111213",
+			plaintext: "202122232425262728292a2b2c2d2e2f3031323334353637",
+			tagSize:   8,
+			expected:  "e3b201a9f5b71a7a9b1ceaeccd97e70b6176aad9a4428aa5484392fbc1b09951",
+		},
+		// The following vectors have the shape of the TLS records (12 bytes nonce, 13 bytes additional data)
+		// and are generated by the AES-CCM of OpenSSL.
+		{
+			name:      "TLS record, CCM",
+			key:       "000102030405060708090a0b0c0d0e0f",
+			nonce:     "a0a1a2a3a4a5a6a7a8a9aaab",
+			ad:        "00000000000000011703030028",
+			plaintext: "54686520717569636b2062726f776e20666f78206a756d7073206f76657220746865206c617a7920646f67212121",
+			tagSize:   16,
+			expected:  "fadb0df1d44aa3e2b9e6628ff547b7c32d9857e9dbfeb770fcf75f7ac05ec8b1448a485dc404bddea7845f35c002cdf713895fc51ab0042e3a5a734a1dee",
+		},
+		{
+			name:     "TLS record, CCM_8, empty plaintext",
+			key:      "000102030405060708090a0b0c0d0e0f",
+			nonce:    "a0a1a2a3a4a5a6a7a8a9aaab",
+			ad:       "00000000000000011703030000",
+			tagSize:  8,
+			expected: "27e4f9ca036d5cc7",
+		},
+	}
+	for _, v := range tt {
+		t.Run(v.name, func(t *testing.T) {
+			a := assert.New(t)
+			key, _ := hex.DecodeString(v.key)
+			nonce, _ := hex.DecodeString(v.nonce)
+			ad, _ := hex.DecodeString(v.ad)
+			plaintext, _ := hex.DecodeString(v.plaintext)
+			expected, _ := hex.DecodeString(v.expected)
+
+			block, err := aes.NewCipher(key)
+			a.NoError(err)
+			aead, err := newCCM(block, len(nonce), v.tagSize)
+			a.NoError(err)
+			ciphertext := aead.Seal(nil, nonce, plaintext, ad)
+			a.Equal(expected, ciphertext)
+
+			b, err := aead.Open(nil, nonce, ciphertext, ad)
+			a.NoError(err)
+			a.True(bytes.Equal(plaintext, b))
+
+			ciphertext[0] ^= 1
+			_, err = aead.Open(nil, nonce, ciphertext, ad)
+			a.Error(err)
+
+			ciphertext[0] ^= 1
+			ad[0] ^= 1
+			_, err = aead.Open(nil, nonce, ciphertext, ad)
+			a.Error(err)
+		})
+	}
+}
+
+func TestPRF(t *testing.T) {
+	var tt = []struct {
+		name     string
+		hash     func() hash.Hash
+		secret   string
+		label    string
+		seed     string
+		expected string
+	}{
+		{
+			name:     "SHA256",
+			hash:     sha256.New,
+			secret:   "9bbe436ba940f017b17652849a71db35",
+			label:    "test label",
+			seed:     "a0ba9f936cda311827a6f796ffd5198c",
+			expected: "e3f229ba727be17b8d122620557cd453c2aab21d07c3d495329b52d4e61edb5a6b301791e90d35c9c9a46b4e14baf9af0fa022f7077def17abfd3797c0564bab4fbc91666e9def9b97fce34f796789baa48082d122ee42c5a72e5a5110fff70187347b66",
+		},
+		// The following vectors are generated by the TLS1-PRF of OpenSSL.
+		{
+			name:     "SHA256, master secret",
+			hash:     sha256.New,
+			secret:   "7365637265742d6b65792d30313233343536373839",
+			label:    labelMasterSecret,
+			seed:     "636c69656e742d72616e646f6d2d616e642d7365727665722d72616e646f6d",
+			expected: "7ea77aff873060356cbc3207068b5012e8c61b22e9d8029ad8237334e777e3d462d94af99a20b90f07fcf3707ef08077",
+		},
+		{
+			name:     "SHA384, master secret",
+			hash:     sha512.New384,
+			secret:   "7365637265742d6b65792d30313233343536373839",
+			label:    labelMasterSecret,
+			seed:     "636c69656e742d72616e646f6d2d616e642d7365727665722d72616e646f6d",
+			expected: "5761f17e3dcac9ff81a13d642a5f3093f589902da115a0db9d5cae7df38cb5eb4bc61eeec67ff24f724e301f1c14d2b1162421e7ebce48bce87aba925a3480f0845d8d5f1b465c19e3384b7ef009799bdaff121cf15e2954b0a47fb387e5821421af8181",
+		},
+	}
+	for _, v := range tt {
+		t.Run(v.name, func(t *testing.T) {
+			secret, _ := hex.DecodeString(v.secret)
+			seed, _ := hex.DecodeString(v.seed)
+			expected, _ := hex.DecodeString(v.expected)
+			assert.Equal(t, expected, prf(v.hash, secret, v.label, seed, len(expected)))
+		})
+	}
+}
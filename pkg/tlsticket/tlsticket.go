@@ -0,0 +1,80 @@
+// Package tlsticket provides the session ticket key rotation for TLS listeners.
+package tlsticket
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"io"
+	"sync"
+	"time"
+)
+
+// Rotator rotates the session ticket keys of a tls.Config periodically.
+// The newest key is used to encrypt new tickets, and the retained previous keys are still accepted to decrypt tickets,
+// so that clients which got the tickets before the rotation can still resume their sessions.
+type Rotator struct {
+	cfg      *tls.Config
+	interval time.Duration
+	retained int
+	mu       sync.Mutex
+	// keys is ordered from the newest to the oldest.
+	keys      [][32]byte
+	close     chan struct{}
+	closeOnce sync.Once
+}
+
+// NewRotator sets a new session ticket key to the cfg and starts rotating the keys every interval.
+// retained is the number of previous keys that are still accepted.
+// The cfg must not be cloned after calling this method, because the clone will not be rotated.
+func NewRotator(cfg *tls.Config, interval time.Duration, retained int) (*Rotator, error) {
+	r := &Rotator{
+		cfg:      cfg,
+		interval: interval,
+		retained: retained,
+		close:    make(chan struct{}),
+	}
+	if err := r.Rotate(); err != nil {
+		return nil, err
+	}
+	go r.run()
+	return r, nil
+}
+
+func (r *Rotator) run() {
+	t := time.NewTicker(r.interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-r.close:
+			return
+		case <-t.C:
+			// keep using the current keys if it fails to generate a new one.
+			_ = r.Rotate()
+		}
+	}
+}
+
+// Rotate generates a new key and drops the keys that exceed the retained number.
+func (r *Rotator) Rotate() error {
+	var key [32]byte
+	if _, err := io.ReadFull(rand.Reader, key[:]); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	keys := make([][32]byte, 0, r.retained+1)
+	keys = append(keys, key)
+	for i := 0; i < len(r.keys) && i < r.retained; i++ {
+		keys = append(keys, r.keys[i])
+	}
+	r.keys = keys
+	r.cfg.SetSessionTicketKeys(keys)
+	return nil
+}
+
+// Stop stops the rotation. The current keys are still in use.
+func (r *Rotator) Stop() {
+	r.closeOnce.Do(func() {
+		close(r.close)
+	})
+}
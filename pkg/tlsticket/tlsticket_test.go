@@ -0,0 +1,28 @@
+package tlsticket
+
+import (
+	"crypto/tls"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRotator_Rotate(t *testing.T) {
+	a := assert.New(t)
+	r, err := NewRotator(&tls.Config{}, time.Hour, 2)
+	a.Nil(err)
+	defer r.Stop()
+	a.Len(r.keys, 1)
+	first := r.keys[0]
+
+	a.Nil(r.Rotate())
+	a.Len(r.keys, 2)
+	a.Equal(first, r.keys[1])
+	a.NotEqual(first, r.keys[0])
+
+	a.Nil(r.Rotate())
+	a.Nil(r.Rotate())
+	a.Len(r.keys, 3)
+	a.NotContains(r.keys, first)
+}
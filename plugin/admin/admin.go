@@ -1,6 +1,8 @@
 package admin
 
 import (
+	"path"
+
 	"go.uber.org/zap"
 
 	"github.com/DrmagicE/gmqtt/config"
@@ -13,20 +15,28 @@ const Name = "admin"
 
 func init() {
 	server.RegisterPlugin(Name, New)
+	config.RegisterDefaultPluginConfig(Name, &DefaultConfig)
 }
 
 func New(config config.Config) (server.Plugin, error) {
-	return &Admin{}, nil
+	return &Admin{
+		config:    config.Plugins[Name].(*Config),
+		configDir: config.ConfigDir,
+	}, nil
 }
 
 var log *zap.Logger
 
 // Admin providers gRPC and HTTP API that enables the external system to interact with the broker.
 type Admin struct {
-	statsReader   server.StatsReader
-	publisher     server.Publisher
-	clientService server.ClientService
-	store         *store
+	config          *Config
+	configDir       string
+	tokens          *TokenStore
+	statsReader     server.StatsReader
+	publisher       server.Publisher
+	clientService   server.ClientService
+	listenerService server.ListenerService
+	store           *store
 }
 
 func (a *Admin) registerHTTP(g server.APIRegistrar) (err error) {
@@ -42,15 +52,33 @@ func (a *Admin) registerHTTP(g server.APIRegistrar) (err error) {
 	if err != nil {
 		return err
 	}
+	err = g.RegisterHTTPHandler(RegisterListenerServiceHandlerFromEndpoint)
+	if err != nil {
+		return err
+	}
+	if a.tokens != nil {
+		return g.RegisterHTTPHandler(RegisterTokenServiceHandlerFromEndpoint)
+	}
 	return nil
 }
 
 func (a *Admin) Load(service server.Server) error {
 	log = server.LoggerWithField(zap.String("plugin", Name))
 	apiRegistrar := service.APIRegistrar()
+	if a.config.TokenAuth.Enable {
+		tokens, err := OpenTokenStore(TokenFile(a.config.TokenAuth.TokenFile, a.configDir))
+		if err != nil {
+			return err
+		}
+		a.tokens = tokens
+		apiRegistrar.RegisterUnaryInterceptor(tokens.UnaryInterceptor)
+		RegisterTokenServiceServer(apiRegistrar, &tokenService{a: a})
+		log.Info("token authentication enabled", zap.String("token_file", tokens.file))
+	}
 	RegisterClientServiceServer(apiRegistrar, &clientService{a: a})
 	RegisterSubscriptionServiceServer(apiRegistrar, &subscriptionService{a: a})
-	RegisterPublishServiceServer(apiRegistrar, &publisher{a: a})
+	RegisterPublishServiceServer(apiRegistrar, newPublisher(a))
+	RegisterListenerServiceServer(apiRegistrar, &listenerService{a: a})
 	err := a.registerHTTP(apiRegistrar)
 	if err != nil {
 		return err
@@ -60,9 +88,18 @@ func (a *Admin) Load(service server.Server) error {
 	a.store.subscriptionService = service.SubscriptionService()
 	a.publisher = service.Publisher()
 	a.clientService = service.ClientService()
+	a.listenerService = service.ListenerService()
 	return nil
 }
 
+// TokenFile returns the path of the token file, the relative path is resolved against the configDir.
+func TokenFile(tokenFile string, configDir string) string {
+	if path.IsAbs(tokenFile) {
+		return tokenFile
+	}
+	return path.Join(configDir, tokenFile)
+}
+
 func (a *Admin) Unload() error {
 	return nil
 }
@@ -0,0 +1,237 @@
+package admin
+
+import (
+	"container/list"
+	"context"
+	"net"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/golang/protobuf/ptypes/empty"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/DrmagicE/gmqtt"
+	"github.com/DrmagicE/gmqtt/persistence/subscription"
+	"github.com/DrmagicE/gmqtt/pkg/packets"
+)
+
+// clientMatcher matches the clients by the ClientFilter.
+type clientMatcher struct {
+	username string
+	ipNet    *net.IPNet
+	// subscribed is the client ids which have the matched subscriptions, nil if the subscription condition is not set.
+	subscribed map[string]struct{}
+}
+
+// parseIPRange parses the IP address or the CIDR.
+func parseIPRange(s string) (*net.IPNet, error) {
+	if strings.Contains(s, "/") {
+		_, ipNet, err := net.ParseCIDR(s)
+		return ipNet, err
+	}
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil, &net.ParseError{Type: "IP address", Text: s}
+	}
+	if v4 := ip.To4(); v4 != nil {
+		return &net.IPNet{IP: v4, Mask: net.CIDRMask(32, 32)}, nil
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+}
+
+func (c *clientService) newClientMatcher(f *ClientFilter) (*clientMatcher, error) {
+	if f == nil || (f.Username == "" && f.IpRange == "" && f.TopicName == "" && f.MatchType == 0) {
+		return nil, ErrInvalidArgument("filter", "at least one of username, ip_range and topic_name is required")
+	}
+	m := &clientMatcher{
+		username: f.Username,
+	}
+	if f.Username != "" {
+		if _, err := path.Match(f.Username, ""); err != nil {
+			return nil, ErrInvalidArgument("username", err.Error())
+		}
+	}
+	if f.IpRange != "" {
+		ipNet, err := parseIPRange(f.IpRange)
+		if err != nil {
+			return nil, ErrInvalidArgument("ip_range", err.Error())
+		}
+		m.ipNet = ipNet
+	}
+	if f.TopicName == "" && f.MatchType == 0 {
+		return m, nil
+	}
+	iterOpts := subscription.IterationOptions{
+		Type:      subscription.TypeAll,
+		TopicName: f.TopicName,
+	}
+	switch f.MatchType {
+	case SubMatchType_SUB_MATCH_TYPE_MATCH_NAME:
+		iterOpts.MatchType = subscription.MatchName
+	case SubMatchType_SUB_MATCH_TYPE_MATCH_FILTER:
+		iterOpts.MatchType = subscription.MatchFilter
+	default:
+		return nil, ErrInvalidArgument("match_type", "cannot be empty while topic_name Set")
+	}
+	if f.TopicName == "" {
+		return nil, ErrInvalidArgument("topic_name", "cannot be empty while match_type Set")
+	}
+	if !packets.ValidV5Topic([]byte(f.TopicName)) {
+		return nil, ErrInvalidArgument("topic_name", "")
+	}
+	m.subscribed = make(map[string]struct{})
+	c.a.store.subscriptionService.Iterate(func(clientID string, sub *gmqtt.Subscription) bool {
+		m.subscribed[clientID] = struct{}{}
+		return true
+	}, iterOpts)
+	return m, nil
+}
+
+func (m *clientMatcher) match(c *Client) bool {
+	if m.username != "" {
+		if ok, _ := path.Match(m.username, c.Username); !ok {
+			return false
+		}
+	}
+	if m.ipNet != nil {
+		host, _, err := net.SplitHostPort(c.RemoteAddr)
+		if err != nil {
+			host = c.RemoteAddr
+		}
+		ip := net.ParseIP(host)
+		if ip == nil || !m.ipNet.Contains(ip) {
+			return false
+		}
+	}
+	if m.subscribed != nil {
+		if _, ok := m.subscribed[c.ClientId]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// bulkTarget is a client selected by the bulk operations.
+type bulkTarget struct {
+	clientID  string
+	connected bool
+}
+
+// matchClients returns the clients (both connected and disconnected) which are matched by the matcher.
+func (s *store) matchClients(m *clientMatcher) (rs []bulkTarget) {
+	s.clientMu.RLock()
+	defer s.clientMu.RUnlock()
+	s.clientIndexer.Iterate(func(elem *list.Element) {
+		c := elem.Value.(*Client)
+		if m.match(c) {
+			rs = append(rs, bulkTarget{
+				clientID:  c.ClientId,
+				connected: c.DisconnectedAt == nil,
+			})
+		}
+	}, 0, uint(s.clientIndexer.Len()))
+	return rs
+}
+
+// closeClient disconnects the client if it is connected.
+func (c *clientService) closeClient(clientID string) {
+	if client := c.a.clientService.GetClient(clientID); client != nil {
+		client.Close()
+	}
+}
+
+// BulkDisconnect disconnects all connected clients matching the filter.
+func (c *clientService) BulkDisconnect(ctx context.Context, req *BulkClientRequest) (*BulkClientResponse, error) {
+	m, err := c.newClientMatcher(req.Filter)
+	if err != nil {
+		return nil, err
+	}
+	var matched uint32
+	for _, v := range c.a.store.matchClients(m) {
+		if !v.connected {
+			continue
+		}
+		matched++
+		if !req.DryRun {
+			c.closeClient(v.clientID)
+		}
+	}
+	if !req.DryRun {
+		log.Info("bulk disconnect", zap.Uint32("matched", matched))
+	}
+	return &BulkClientResponse{
+		Matched: matched,
+	}, nil
+}
+
+// BulkBan bans all clients matching the filter and disconnects the connected ones.
+func (c *clientService) BulkBan(ctx context.Context, req *BulkBanRequest) (*BulkClientResponse, error) {
+	m, err := c.newClientMatcher(req.Filter)
+	if err != nil {
+		return nil, err
+	}
+	targets := c.a.store.matchClients(m)
+	if !req.DryRun {
+		d := time.Duration(req.Duration) * time.Second
+		for _, v := range targets {
+			c.a.clientService.Ban(v.clientID, d)
+			c.closeClient(v.clientID)
+		}
+		log.Info("bulk ban", zap.Int("matched", len(targets)), zap.Duration("duration", d))
+	}
+	return &BulkClientResponse{
+		Matched: uint32(len(targets)),
+	}, nil
+}
+
+// BulkPurgeSession terminates the sessions of all clients matching the filter.
+func (c *clientService) BulkPurgeSession(ctx context.Context, req *BulkClientRequest) (*BulkClientResponse, error) {
+	m, err := c.newClientMatcher(req.Filter)
+	if err != nil {
+		return nil, err
+	}
+	targets := c.a.store.matchClients(m)
+	if !req.DryRun {
+		for _, v := range targets {
+			c.a.clientService.TerminateSession(v.clientID)
+		}
+		log.Info("bulk purge session", zap.Int("matched", len(targets)))
+	}
+	return &BulkClientResponse{
+		Matched: uint32(len(targets)),
+	}, nil
+}
+
+// ListBans lists the banned client ids sorted by the client id.
+func (c *clientService) ListBans(ctx context.Context, req *empty.Empty) (*ListBansResponse, error) {
+	resp := &ListBansResponse{
+		Bans: make([]*Ban, 0),
+	}
+	for k, v := range c.a.clientService.ListBans() {
+		b := &Ban{
+			ClientId: k,
+		}
+		if !v.IsZero() {
+			b.Expiry = timestamppb.New(v)
+		}
+		resp.Bans = append(resp.Bans, b)
+	}
+	sort.Slice(resp.Bans, func(i, j int) bool {
+		return resp.Bans[i].ClientId < resp.Bans[j].ClientId
+	})
+	return resp, nil
+}
+
+// Unban removes the ban of the given client id.
+func (c *clientService) Unban(ctx context.Context, req *UnbanRequest) (*empty.Empty, error) {
+	if req.ClientId == "" {
+		return nil, ErrInvalidArgument("client_id", "")
+	}
+	if !c.a.clientService.Unban(req.ClientId) {
+		return nil, ErrNotFound
+	}
+	return &empty.Empty{}, nil
+}
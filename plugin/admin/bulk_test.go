@@ -0,0 +1,187 @@
+package admin
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/golang/protobuf/ptypes/empty"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/DrmagicE/gmqtt"
+	"github.com/DrmagicE/gmqtt/persistence/subscription"
+	"github.com/DrmagicE/gmqtt/server"
+)
+
+func newBulkTestService(ctrl *gomock.Controller) (*clientService, *server.MockClientService) {
+	log = zap.NewNop()
+	cs := server.NewMockClientService(ctrl)
+	ss := server.NewMockSubscriptionService(ctrl)
+	ss.EXPECT().Iterate(gomock.Any(), gomock.Any()).DoAndReturn(
+		func(fn subscription.IterateFn, options subscription.IterationOptions) {
+			if options.TopicName == "cmd/#" && options.MatchType == subscription.MatchName {
+				fn("c1", &gmqtt.Subscription{TopicFilter: "cmd/#"})
+				fn("c3", &gmqtt.Subscription{TopicFilter: "cmd/#"})
+			}
+		}).AnyTimes()
+	admin := &Admin{
+		clientService: cs,
+		store:         newStore(nil, mockConfig),
+	}
+	admin.store.subscriptionService = ss
+	for _, v := range []*Client{
+		{ClientId: "c1", Username: "device-1", RemoteAddr: "10.0.0.1:1883"},
+		{ClientId: "c2", Username: "device-2", RemoteAddr: "10.0.1.2:1883"},
+		{ClientId: "c3", Username: "device-3", RemoteAddr: "10.0.0.3:1883", DisconnectedAt: timestamppb.Now()},
+		{ClientId: "c4", Username: "admin", RemoteAddr: "[::1]:1883"},
+	} {
+		admin.store.clientIndexer.Set(v.ClientId, v)
+	}
+	return &clientService{a: admin}, cs
+}
+
+func TestClientService_newClientMatcher(t *testing.T) {
+	a := assert.New(t)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	c, _ := newBulkTestService(ctrl)
+
+	var tt = []struct {
+		filter  *ClientFilter
+		matched []string
+	}{
+		{filter: &ClientFilter{Username: "device-*"}, matched: []string{"c1", "c2", "c3"}},
+		{filter: &ClientFilter{IpRange: "10.0.0.0/24"}, matched: []string{"c1", "c3"}},
+		{filter: &ClientFilter{IpRange: "::1"}, matched: []string{"c4"}},
+		{filter: &ClientFilter{MatchType: SubMatchType_SUB_MATCH_TYPE_MATCH_NAME, TopicName: "cmd/#"}, matched: []string{"c1", "c3"}},
+		{filter: &ClientFilter{Username: "device-*", IpRange: "10.0.0.1", MatchType: SubMatchType_SUB_MATCH_TYPE_MATCH_NAME, TopicName: "cmd/#"}, matched: []string{"c1"}},
+		{filter: &ClientFilter{Username: "none"}},
+	}
+	for _, v := range tt {
+		m, err := c.newClientMatcher(v.filter)
+		a.Nil(err)
+		var ids []string
+		for _, t := range c.a.store.matchClients(m) {
+			ids = append(ids, t.clientID)
+		}
+		a.Equal(v.matched, ids, v.filter.String())
+	}
+
+	for _, v := range []*ClientFilter{
+		nil,
+		{},
+		{Username: "["},
+		{IpRange: "10.0.0"},
+		{IpRange: "10.0.0.0/33"},
+		{TopicName: "cmd/#"},
+		{MatchType: SubMatchType_SUB_MATCH_TYPE_MATCH_FILTER},
+		{MatchType: SubMatchType_SUB_MATCH_TYPE_MATCH_NAME, TopicName: "a/#/b"},
+	} {
+		_, err := c.newClientMatcher(v)
+		a.NotNil(err, v.String())
+	}
+}
+
+func TestClientService_BulkDisconnect(t *testing.T) {
+	a := assert.New(t)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	c, cs := newBulkTestService(ctrl)
+
+	req := &BulkClientRequest{
+		Filter: &ClientFilter{Username: "device-*"},
+		DryRun: true,
+	}
+	resp, err := c.BulkDisconnect(context.Background(), req)
+	a.Nil(err)
+	// c3 is disconnected
+	a.EqualValues(2, resp.Matched)
+
+	req.DryRun = false
+	for _, v := range []string{"c1", "c2"} {
+		client := server.NewMockClient(ctrl)
+		client.EXPECT().Close()
+		cs.EXPECT().GetClient(v).Return(client)
+	}
+	resp, err = c.BulkDisconnect(context.Background(), req)
+	a.Nil(err)
+	a.EqualValues(2, resp.Matched)
+
+	_, err = c.BulkDisconnect(context.Background(), &BulkClientRequest{})
+	a.NotNil(err)
+}
+
+func TestClientService_BulkBan(t *testing.T) {
+	a := assert.New(t)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	c, cs := newBulkTestService(ctrl)
+
+	req := &BulkBanRequest{
+		Filter:   &ClientFilter{IpRange: "10.0.0.0/24"},
+		DryRun:   true,
+		Duration: 60,
+	}
+	resp, err := c.BulkBan(context.Background(), req)
+	a.Nil(err)
+	a.EqualValues(2, resp.Matched)
+
+	req.DryRun = false
+	client := server.NewMockClient(ctrl)
+	client.EXPECT().Close()
+	cs.EXPECT().Ban("c1", time.Minute)
+	cs.EXPECT().GetClient("c1").Return(client)
+	cs.EXPECT().Ban("c3", time.Minute)
+	cs.EXPECT().GetClient("c3").Return(nil)
+	resp, err = c.BulkBan(context.Background(), req)
+	a.Nil(err)
+	a.EqualValues(2, resp.Matched)
+}
+
+func TestClientService_BulkPurgeSession(t *testing.T) {
+	a := assert.New(t)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	c, cs := newBulkTestService(ctrl)
+
+	req := &BulkClientRequest{
+		Filter: &ClientFilter{MatchType: SubMatchType_SUB_MATCH_TYPE_MATCH_NAME, TopicName: "cmd/#"},
+	}
+	cs.EXPECT().TerminateSession("c1")
+	cs.EXPECT().TerminateSession("c3")
+	resp, err := c.BulkPurgeSession(context.Background(), req)
+	a.Nil(err)
+	a.EqualValues(2, resp.Matched)
+}
+
+func TestClientService_ListBans_Unban(t *testing.T) {
+	a := assert.New(t)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	c, cs := newBulkTestService(ctrl)
+
+	expiry := time.Unix(1600000000, 0)
+	cs.EXPECT().ListBans().Return(map[string]time.Time{
+		"b": {},
+		"a": expiry,
+	})
+	resp, err := c.ListBans(context.Background(), &empty.Empty{})
+	a.Nil(err)
+	a.Len(resp.Bans, 2)
+	a.Equal("a", resp.Bans[0].ClientId)
+	a.Equal(expiry.Unix(), resp.Bans[0].Expiry.AsTime().Unix())
+	a.Equal("b", resp.Bans[1].ClientId)
+	a.Nil(resp.Bans[1].Expiry)
+
+	cs.EXPECT().Unban("a").Return(true)
+	_, err = c.Unban(context.Background(), &UnbanRequest{ClientId: "a"})
+	a.Nil(err)
+	cs.EXPECT().Unban("a").Return(false)
+	_, err = c.Unban(context.Background(), &UnbanRequest{ClientId: "a"})
+	a.Equal(ErrNotFound, err)
+	_, err = c.Unban(context.Background(), &UnbanRequest{})
+	a.NotNil(err)
+}
@@ -2,8 +2,16 @@ package admin
 
 import (
 	"context"
+	"time"
 
 	"github.com/golang/protobuf/ptypes/empty"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/DrmagicE/gmqtt"
+	"github.com/DrmagicE/gmqtt/persistence/queue"
+	"github.com/DrmagicE/gmqtt/server"
 )
 
 type clientService struct {
@@ -56,3 +64,157 @@ func (c *clientService) Delete(ctx context.Context, req *DeleteClientRequest) (*
 	}
 	return &empty.Empty{}, nil
 }
+
+// maxDebugDuration is the max duration of the debug logging.
+const maxDebugDuration = 24 * time.Hour
+
+// EnableDebug enables the debug logging for the given client id or ip for a limited duration.
+func (c *clientService) EnableDebug(ctx context.Context, req *EnableDebugRequest) (*empty.Empty, error) {
+	if req.ClientId == "" && req.Ip == "" {
+		return nil, ErrInvalidArgument("client_id", "either client_id or ip is required")
+	}
+	d := time.Duration(req.Duration) * time.Second
+	if d <= 0 || d > maxDebugDuration {
+		return nil, ErrInvalidArgument("duration", "must be in the range of 1 to 86400")
+	}
+	c.a.clientService.EnableDebugLogging(req.ClientId, req.Ip, d)
+	return &empty.Empty{}, nil
+}
+
+// DisableDebug disables the debug logging for the given client id or ip.
+func (c *clientService) DisableDebug(ctx context.Context, req *DisableDebugRequest) (*empty.Empty, error) {
+	if req.ClientId == "" && req.Ip == "" {
+		return nil, ErrInvalidArgument("client_id", "either client_id or ip is required")
+	}
+	c.a.clientService.DisableDebugLogging(req.ClientId, req.Ip)
+	return &empty.Empty{}, nil
+}
+
+const (
+	defaultMaxQueued      = 100
+	defaultPayloadPreview = 128
+)
+
+func sessionMessage(msg *gmqtt.Message, payloadPreview uint32) *SessionMessage {
+	m := &SessionMessage{
+		PacketId:      uint32(msg.PacketID),
+		TopicName:     msg.Topic,
+		Payload:       msg.Payload,
+		PayloadSize:   uint32(len(msg.Payload)),
+		Qos:           uint32(msg.QoS),
+		Retained:      msg.Retained,
+		ContentType:   msg.ContentType,
+		MessageExpiry: msg.MessageExpiry,
+	}
+	if uint32(len(m.Payload)) > payloadPreview {
+		m.Payload = m.Payload[:payloadPreview]
+	}
+	return m
+}
+
+func sessionElem(elem *queue.Elem, payloadPreview uint32) *SessionMessage {
+	var m *SessionMessage
+	switch v := elem.MessageWithID.(type) {
+	case *queue.Publish:
+		m = sessionMessage(v.Message, payloadPreview)
+	default:
+		m = &SessionMessage{
+			PacketId: uint32(v.ID()),
+			Pubrel:   true,
+		}
+	}
+	if !elem.Expiry.IsZero() {
+		m.Expiry = timestamppb.New(elem.Expiry)
+	}
+	return m
+}
+
+// GetSession returns the session state for the given client id.
+func (c *clientService) GetSession(ctx context.Context, req *GetSessionRequest) (*GetSessionResponse, error) {
+	if req.ClientId == "" {
+		return nil, ErrInvalidArgument("client_id", "")
+	}
+	maxQueued, payloadPreview := req.MaxQueued, req.PayloadPreview
+	if maxQueued == 0 {
+		maxQueued = defaultMaxQueued
+	}
+	if payloadPreview == 0 {
+		payloadPreview = defaultPayloadPreview
+	}
+	state, err := c.a.clientService.InspectSession(req.ClientId, int(maxQueued))
+	if err == server.ErrInspectionNotSupported {
+		return nil, status.Error(codes.Unimplemented, err.Error())
+	}
+	if err != nil {
+		return nil, err
+	}
+	if state == nil {
+		return nil, ErrNotFound
+	}
+	sess := &Session{
+		ClientId:          state.Session.ClientID,
+		Connected:         state.Connected,
+		ConnectedAt:       timestamppb.New(state.Session.ConnectedAt),
+		ExpiryInterval:    state.Session.ExpiryInterval,
+		WillDelayInterval: state.Session.WillDelayInterval,
+		QueuedTotal:       uint32(state.QueuedTotal),
+		Attributes:        state.Session.Attributes,
+	}
+	if state.Session.Will != nil {
+		sess.Will = sessionMessage(state.Session.Will, payloadPreview)
+	}
+	if state.PendingWill != nil {
+		sess.PendingWill = sessionMessage(state.PendingWill, payloadPreview)
+		sess.PendingWillAt = timestamppb.New(state.PendingWillAt)
+	}
+	for _, v := range state.AwaitingRel {
+		sess.AwaitingRel = append(sess.AwaitingRel, uint32(v))
+	}
+	for _, v := range state.Inflight {
+		sess.Inflight = append(sess.Inflight, sessionElem(v, payloadPreview))
+	}
+	for _, v := range state.Queued {
+		sess.Queued = append(sess.Queued, sessionElem(v, payloadPreview))
+	}
+	return &GetSessionResponse{
+		Session: sess,
+	}, nil
+}
+
+// PurgeQueue removes the queued messages which are not inflight for the given client id.
+func (c *clientService) PurgeQueue(ctx context.Context, req *PurgeQueueRequest) (*PurgeQueueResponse, error) {
+	if req.ClientId == "" {
+		return nil, ErrInvalidArgument("client_id", "")
+	}
+	n, err := c.a.clientService.PurgeQueue(req.ClientId)
+	if err == server.ErrInspectionNotSupported {
+		return nil, status.Error(codes.Unimplemented, err.Error())
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &PurgeQueueResponse{
+		Purged: uint32(n),
+	}, nil
+}
+
+// SetAttributes replaces the attributes of the session for the given client id.
+func (c *clientService) SetAttributes(ctx context.Context, req *SetAttributesRequest) (*empty.Empty, error) {
+	if req.ClientId == "" {
+		return nil, ErrInvalidArgument("client_id", "")
+	}
+	for k := range req.Attributes {
+		if k == "" {
+			return nil, ErrInvalidArgument("attributes", "empty key")
+		}
+	}
+	err := c.a.clientService.SetAttributes(req.ClientId, req.Attributes)
+	if err == server.ErrSessionNotFound {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	c.a.store.setClientAttributes(req.ClientId, req.Attributes)
+	return &empty.Empty{}, nil
+}
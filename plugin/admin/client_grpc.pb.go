@@ -25,6 +25,31 @@ type ClientServiceClient interface {
 	Get(ctx context.Context, in *GetClientRequest, opts ...grpc.CallOption) (*GetClientResponse, error)
 	// Disconnect the client for given client id.
 	Delete(ctx context.Context, in *DeleteClientRequest, opts ...grpc.CallOption) (*empty.Empty, error)
+	// Enable the debug logging, including the packet dump, for the given client id or ip for a limited duration,
+	// regardless of the log level.
+	EnableDebug(ctx context.Context, in *EnableDebugRequest, opts ...grpc.CallOption) (*empty.Empty, error)
+	// Disable the debug logging for the given client id or ip.
+	DisableDebug(ctx context.Context, in *DisableDebugRequest, opts ...grpc.CallOption) (*empty.Empty, error)
+	// Get the session state for the given client id, including the will message, the inflight and queued messages.
+	// Return NotFound error when session not found.
+	GetSession(ctx context.Context, in *GetSessionRequest, opts ...grpc.CallOption) (*GetSessionResponse, error)
+	// Remove the queued messages which are not inflight for the given client id.
+	PurgeQueue(ctx context.Context, in *PurgeQueueRequest, opts ...grpc.CallOption) (*PurgeQueueResponse, error)
+	// Replace the attributes of the session for the given client id.
+	// Return NotFound error when session not found.
+	SetAttributes(ctx context.Context, in *SetAttributesRequest, opts ...grpc.CallOption) (*empty.Empty, error)
+	// Disconnect all connected clients matching the filter.
+	BulkDisconnect(ctx context.Context, in *BulkClientRequest, opts ...grpc.CallOption) (*BulkClientResponse, error)
+	// Ban all clients matching the filter by the client id, the connected ones are disconnected.
+	// The bans are kept in memory and do not survive restarts.
+	BulkBan(ctx context.Context, in *BulkBanRequest, opts ...grpc.CallOption) (*BulkClientResponse, error)
+	// Terminate the sessions of all clients matching the filter, the connected ones are disconnected.
+	BulkPurgeSession(ctx context.Context, in *BulkClientRequest, opts ...grpc.CallOption) (*BulkClientResponse, error)
+	// List the banned client ids.
+	ListBans(ctx context.Context, in *empty.Empty, opts ...grpc.CallOption) (*ListBansResponse, error)
+	// Remove the ban of the given client id.
+	// Return NotFound error when the client id is not banned.
+	Unban(ctx context.Context, in *UnbanRequest, opts ...grpc.CallOption) (*empty.Empty, error)
 }
 
 type clientServiceClient struct {
@@ -62,6 +87,96 @@ func (c *clientServiceClient) Delete(ctx context.Context, in *DeleteClientReques
 	return out, nil
 }
 
+func (c *clientServiceClient) EnableDebug(ctx context.Context, in *EnableDebugRequest, opts ...grpc.CallOption) (*empty.Empty, error) {
+	out := new(empty.Empty)
+	err := c.cc.Invoke(ctx, "/gmqtt.admin.api.ClientService/EnableDebug", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *clientServiceClient) DisableDebug(ctx context.Context, in *DisableDebugRequest, opts ...grpc.CallOption) (*empty.Empty, error) {
+	out := new(empty.Empty)
+	err := c.cc.Invoke(ctx, "/gmqtt.admin.api.ClientService/DisableDebug", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *clientServiceClient) GetSession(ctx context.Context, in *GetSessionRequest, opts ...grpc.CallOption) (*GetSessionResponse, error) {
+	out := new(GetSessionResponse)
+	err := c.cc.Invoke(ctx, "/gmqtt.admin.api.ClientService/GetSession", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *clientServiceClient) PurgeQueue(ctx context.Context, in *PurgeQueueRequest, opts ...grpc.CallOption) (*PurgeQueueResponse, error) {
+	out := new(PurgeQueueResponse)
+	err := c.cc.Invoke(ctx, "/gmqtt.admin.api.ClientService/PurgeQueue", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *clientServiceClient) SetAttributes(ctx context.Context, in *SetAttributesRequest, opts ...grpc.CallOption) (*empty.Empty, error) {
+	out := new(empty.Empty)
+	err := c.cc.Invoke(ctx, "/gmqtt.admin.api.ClientService/SetAttributes", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *clientServiceClient) BulkDisconnect(ctx context.Context, in *BulkClientRequest, opts ...grpc.CallOption) (*BulkClientResponse, error) {
+	out := new(BulkClientResponse)
+	err := c.cc.Invoke(ctx, "/gmqtt.admin.api.ClientService/BulkDisconnect", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *clientServiceClient) BulkBan(ctx context.Context, in *BulkBanRequest, opts ...grpc.CallOption) (*BulkClientResponse, error) {
+	out := new(BulkClientResponse)
+	err := c.cc.Invoke(ctx, "/gmqtt.admin.api.ClientService/BulkBan", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *clientServiceClient) BulkPurgeSession(ctx context.Context, in *BulkClientRequest, opts ...grpc.CallOption) (*BulkClientResponse, error) {
+	out := new(BulkClientResponse)
+	err := c.cc.Invoke(ctx, "/gmqtt.admin.api.ClientService/BulkPurgeSession", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *clientServiceClient) ListBans(ctx context.Context, in *empty.Empty, opts ...grpc.CallOption) (*ListBansResponse, error) {
+	out := new(ListBansResponse)
+	err := c.cc.Invoke(ctx, "/gmqtt.admin.api.ClientService/ListBans", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *clientServiceClient) Unban(ctx context.Context, in *UnbanRequest, opts ...grpc.CallOption) (*empty.Empty, error) {
+	out := new(empty.Empty)
+	err := c.cc.Invoke(ctx, "/gmqtt.admin.api.ClientService/Unban", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // ClientServiceServer is the server API for ClientService service.
 // All implementations must embed UnimplementedClientServiceServer
 // for forward compatibility
@@ -73,6 +188,31 @@ type ClientServiceServer interface {
 	Get(context.Context, *GetClientRequest) (*GetClientResponse, error)
 	// Disconnect the client for given client id.
 	Delete(context.Context, *DeleteClientRequest) (*empty.Empty, error)
+	// Enable the debug logging, including the packet dump, for the given client id or ip for a limited duration,
+	// regardless of the log level.
+	EnableDebug(context.Context, *EnableDebugRequest) (*empty.Empty, error)
+	// Disable the debug logging for the given client id or ip.
+	DisableDebug(context.Context, *DisableDebugRequest) (*empty.Empty, error)
+	// Get the session state for the given client id, including the will message, the inflight and queued messages.
+	// Return NotFound error when session not found.
+	GetSession(context.Context, *GetSessionRequest) (*GetSessionResponse, error)
+	// Remove the queued messages which are not inflight for the given client id.
+	PurgeQueue(context.Context, *PurgeQueueRequest) (*PurgeQueueResponse, error)
+	// Replace the attributes of the session for the given client id.
+	// Return NotFound error when session not found.
+	SetAttributes(context.Context, *SetAttributesRequest) (*empty.Empty, error)
+	// Disconnect all connected clients matching the filter.
+	BulkDisconnect(context.Context, *BulkClientRequest) (*BulkClientResponse, error)
+	// Ban all clients matching the filter by the client id, the connected ones are disconnected.
+	// The bans are kept in memory and do not survive restarts.
+	BulkBan(context.Context, *BulkBanRequest) (*BulkClientResponse, error)
+	// Terminate the sessions of all clients matching the filter, the connected ones are disconnected.
+	BulkPurgeSession(context.Context, *BulkClientRequest) (*BulkClientResponse, error)
+	// List the banned client ids.
+	ListBans(context.Context, *empty.Empty) (*ListBansResponse, error)
+	// Remove the ban of the given client id.
+	// Return NotFound error when the client id is not banned.
+	Unban(context.Context, *UnbanRequest) (*empty.Empty, error)
 	mustEmbedUnimplementedClientServiceServer()
 }
 
@@ -89,6 +229,36 @@ func (UnimplementedClientServiceServer) Get(context.Context, *GetClientRequest)
 func (UnimplementedClientServiceServer) Delete(context.Context, *DeleteClientRequest) (*empty.Empty, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method Delete not implemented")
 }
+func (UnimplementedClientServiceServer) EnableDebug(context.Context, *EnableDebugRequest) (*empty.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method EnableDebug not implemented")
+}
+func (UnimplementedClientServiceServer) DisableDebug(context.Context, *DisableDebugRequest) (*empty.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DisableDebug not implemented")
+}
+func (UnimplementedClientServiceServer) GetSession(context.Context, *GetSessionRequest) (*GetSessionResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetSession not implemented")
+}
+func (UnimplementedClientServiceServer) PurgeQueue(context.Context, *PurgeQueueRequest) (*PurgeQueueResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method PurgeQueue not implemented")
+}
+func (UnimplementedClientServiceServer) SetAttributes(context.Context, *SetAttributesRequest) (*empty.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SetAttributes not implemented")
+}
+func (UnimplementedClientServiceServer) BulkDisconnect(context.Context, *BulkClientRequest) (*BulkClientResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method BulkDisconnect not implemented")
+}
+func (UnimplementedClientServiceServer) BulkBan(context.Context, *BulkBanRequest) (*BulkClientResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method BulkBan not implemented")
+}
+func (UnimplementedClientServiceServer) BulkPurgeSession(context.Context, *BulkClientRequest) (*BulkClientResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method BulkPurgeSession not implemented")
+}
+func (UnimplementedClientServiceServer) ListBans(context.Context, *empty.Empty) (*ListBansResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListBans not implemented")
+}
+func (UnimplementedClientServiceServer) Unban(context.Context, *UnbanRequest) (*empty.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Unban not implemented")
+}
 func (UnimplementedClientServiceServer) mustEmbedUnimplementedClientServiceServer() {}
 
 // UnsafeClientServiceServer may be embedded to opt out of forward compatibility for this service.
@@ -156,6 +326,186 @@ func _ClientService_Delete_Handler(srv interface{}, ctx context.Context, dec fun
 	return interceptor(ctx, in, info, handler)
 }
 
+func _ClientService_EnableDebug_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(EnableDebugRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ClientServiceServer).EnableDebug(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/gmqtt.admin.api.ClientService/EnableDebug",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ClientServiceServer).EnableDebug(ctx, req.(*EnableDebugRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ClientService_DisableDebug_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DisableDebugRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ClientServiceServer).DisableDebug(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/gmqtt.admin.api.ClientService/DisableDebug",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ClientServiceServer).DisableDebug(ctx, req.(*DisableDebugRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ClientService_GetSession_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetSessionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ClientServiceServer).GetSession(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/gmqtt.admin.api.ClientService/GetSession",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ClientServiceServer).GetSession(ctx, req.(*GetSessionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ClientService_PurgeQueue_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PurgeQueueRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ClientServiceServer).PurgeQueue(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/gmqtt.admin.api.ClientService/PurgeQueue",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ClientServiceServer).PurgeQueue(ctx, req.(*PurgeQueueRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ClientService_SetAttributes_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SetAttributesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ClientServiceServer).SetAttributes(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/gmqtt.admin.api.ClientService/SetAttributes",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ClientServiceServer).SetAttributes(ctx, req.(*SetAttributesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ClientService_BulkDisconnect_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BulkClientRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ClientServiceServer).BulkDisconnect(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/gmqtt.admin.api.ClientService/BulkDisconnect",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ClientServiceServer).BulkDisconnect(ctx, req.(*BulkClientRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ClientService_BulkBan_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BulkBanRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ClientServiceServer).BulkBan(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/gmqtt.admin.api.ClientService/BulkBan",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ClientServiceServer).BulkBan(ctx, req.(*BulkBanRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ClientService_BulkPurgeSession_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BulkClientRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ClientServiceServer).BulkPurgeSession(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/gmqtt.admin.api.ClientService/BulkPurgeSession",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ClientServiceServer).BulkPurgeSession(ctx, req.(*BulkClientRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ClientService_ListBans_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(empty.Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ClientServiceServer).ListBans(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/gmqtt.admin.api.ClientService/ListBans",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ClientServiceServer).ListBans(ctx, req.(*empty.Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ClientService_Unban_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UnbanRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ClientServiceServer).Unban(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/gmqtt.admin.api.ClientService/Unban",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ClientServiceServer).Unban(ctx, req.(*UnbanRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 var _ClientService_serviceDesc = grpc.ServiceDesc{
 	ServiceName: "gmqtt.admin.api.ClientService",
 	HandlerType: (*ClientServiceServer)(nil),
@@ -172,6 +522,46 @@ var _ClientService_serviceDesc = grpc.ServiceDesc{
 			MethodName: "Delete",
 			Handler:    _ClientService_Delete_Handler,
 		},
+		{
+			MethodName: "EnableDebug",
+			Handler:    _ClientService_EnableDebug_Handler,
+		},
+		{
+			MethodName: "DisableDebug",
+			Handler:    _ClientService_DisableDebug_Handler,
+		},
+		{
+			MethodName: "GetSession",
+			Handler:    _ClientService_GetSession_Handler,
+		},
+		{
+			MethodName: "PurgeQueue",
+			Handler:    _ClientService_PurgeQueue_Handler,
+		},
+		{
+			MethodName: "SetAttributes",
+			Handler:    _ClientService_SetAttributes_Handler,
+		},
+		{
+			MethodName: "BulkDisconnect",
+			Handler:    _ClientService_BulkDisconnect_Handler,
+		},
+		{
+			MethodName: "BulkBan",
+			Handler:    _ClientService_BulkBan_Handler,
+		},
+		{
+			MethodName: "BulkPurgeSession",
+			Handler:    _ClientService_BulkPurgeSession_Handler,
+		},
+		{
+			MethodName: "ListBans",
+			Handler:    _ClientService_ListBans_Handler,
+		},
+		{
+			MethodName: "Unban",
+			Handler:    _ClientService_Unban_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "client.proto",
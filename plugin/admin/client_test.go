@@ -9,9 +9,13 @@ import (
 
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/known/timestamppb"
 
+	"github.com/DrmagicE/gmqtt"
 	"github.com/DrmagicE/gmqtt/config"
+	"github.com/DrmagicE/gmqtt/persistence/queue"
 	"github.com/DrmagicE/gmqtt/pkg/packets"
 	"github.com/DrmagicE/gmqtt/server"
 )
@@ -55,6 +59,7 @@ func TestClientService_List_Get(t *testing.T) {
 	client.EXPECT().Version().Return(packets.Version5).AnyTimes()
 	client.EXPECT().Connection().Return(&dummyConn{}).AnyTimes()
 	client.EXPECT().ConnectedAt().Return(now).AnyTimes()
+	client.EXPECT().Attributes().Return(nil).AnyTimes()
 	created := admin.OnSessionCreatedWrapper(func(ctx context.Context, client server.Client) {})
 	for i := 0; i < 10; i++ {
 		sr.EXPECT().GetClientStats(strconv.Itoa(i)).AnyTimes()
@@ -177,3 +182,141 @@ func TestClientService_Delete_CleanSession(t *testing.T) {
 	})
 	a.Nil(err)
 }
+
+func TestClientService_EnableDebug_DisableDebug(t *testing.T) {
+	a := assert.New(t)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	cs := server.NewMockClientService(ctrl)
+	c := &clientService{
+		a: &Admin{
+			clientService: cs,
+		},
+	}
+	cs.EXPECT().EnableDebugLogging("cid", "", time.Minute)
+	_, err := c.EnableDebug(context.Background(), &EnableDebugRequest{
+		ClientId: "cid",
+		Duration: 60,
+	})
+	a.NoError(err)
+
+	_, err = c.EnableDebug(context.Background(), &EnableDebugRequest{Duration: 60})
+	a.Error(err)
+	_, err = c.EnableDebug(context.Background(), &EnableDebugRequest{Ip: "127.0.0.1"})
+	a.Error(err)
+	_, err = c.EnableDebug(context.Background(), &EnableDebugRequest{Ip: "127.0.0.1", Duration: 86401})
+	a.Error(err)
+
+	cs.EXPECT().DisableDebugLogging("", "127.0.0.1")
+	_, err = c.DisableDebug(context.Background(), &DisableDebugRequest{Ip: "127.0.0.1"})
+	a.NoError(err)
+	_, err = c.DisableDebug(context.Background(), &DisableDebugRequest{})
+	a.Error(err)
+}
+
+func TestClientService_GetSession_PurgeQueue(t *testing.T) {
+	a := assert.New(t)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	cs := server.NewMockClientService(ctrl)
+	c := &clientService{
+		a: &Admin{clientService: cs},
+	}
+	_, err := c.GetSession(context.Background(), &GetSessionRequest{})
+	a.Equal(codes.InvalidArgument, status.Code(err))
+
+	cs.EXPECT().InspectSession("not_exist", defaultMaxQueued).Return(nil, nil)
+	_, err = c.GetSession(context.Background(), &GetSessionRequest{ClientId: "not_exist"})
+	a.Equal(ErrNotFound, err)
+
+	now := time.Now()
+	expiry := now.Add(time.Minute)
+	cs.EXPECT().InspectSession("cid", 1).Return(&server.SessionState{
+		Session: &gmqtt.Session{
+			ClientID:          "cid",
+			Will:              &gmqtt.Message{Topic: "will", Payload: []byte("bye")},
+			WillDelayInterval: 10,
+			ConnectedAt:       now,
+			ExpiryInterval:    100,
+		},
+		PendingWill:   &gmqtt.Message{Topic: "will", Payload: []byte("bye")},
+		PendingWillAt: now,
+		AwaitingRel:   []packets.PacketID{3},
+		Inflight: []*queue.Elem{
+			{Expiry: expiry, MessageWithID: &queue.Publish{Message: &gmqtt.Message{Topic: "a", QoS: 1, PacketID: 1, Payload: []byte("payload")}}},
+			{MessageWithID: &queue.Pubrel{PacketID: 2}},
+		},
+		Queued: []*queue.Elem{
+			{MessageWithID: &queue.Publish{Message: &gmqtt.Message{Topic: "b", Payload: []byte("payload")}}},
+		},
+		QueuedTotal: 5,
+	}, nil)
+	resp, err := c.GetSession(context.Background(), &GetSessionRequest{ClientId: "cid", MaxQueued: 1, PayloadPreview: 3})
+	a.NoError(err)
+	sess := resp.Session
+	a.Equal("cid", sess.ClientId)
+	a.False(sess.Connected)
+	a.EqualValues(100, sess.ExpiryInterval)
+	a.EqualValues(10, sess.WillDelayInterval)
+	a.Equal("will", sess.Will.TopicName)
+	a.Equal([]byte("bye"), sess.PendingWill.Payload)
+	a.Equal(timestamppb.New(now), sess.PendingWillAt)
+	a.Equal([]uint32{3}, sess.AwaitingRel)
+	a.Len(sess.Inflight, 2)
+	a.EqualValues(1, sess.Inflight[0].PacketId)
+	a.Equal([]byte("pay"), sess.Inflight[0].Payload)
+	a.EqualValues(7, sess.Inflight[0].PayloadSize)
+	a.Equal(timestamppb.New(expiry), sess.Inflight[0].Expiry)
+	a.True(sess.Inflight[1].Pubrel)
+	a.EqualValues(2, sess.Inflight[1].PacketId)
+	a.Len(sess.Queued, 1)
+	a.Equal("b", sess.Queued[0].TopicName)
+	a.EqualValues(5, sess.QueuedTotal)
+
+	cs.EXPECT().InspectSession("cid", defaultMaxQueued).Return(nil, server.ErrInspectionNotSupported)
+	_, err = c.GetSession(context.Background(), &GetSessionRequest{ClientId: "cid"})
+	a.Equal(codes.Unimplemented, status.Code(err))
+
+	cs.EXPECT().PurgeQueue("cid").Return(4, nil)
+	purged, err := c.PurgeQueue(context.Background(), &PurgeQueueRequest{ClientId: "cid"})
+	a.NoError(err)
+	a.EqualValues(4, purged.Purged)
+}
+
+func TestClientService_SetAttributes(t *testing.T) {
+	a := assert.New(t)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	cs := server.NewMockClientService(ctrl)
+	admin := &Admin{
+		clientService: cs,
+		store:         newStore(nil, mockConfig),
+	}
+	c := &clientService{
+		a: admin,
+	}
+	client := server.NewMockClient(ctrl)
+	client.EXPECT().Version().Return(packets.Version5).AnyTimes()
+	client.EXPECT().Connection().Return(&dummyConn{}).AnyTimes()
+	client.EXPECT().ConnectedAt().Return(time.Now()).AnyTimes()
+	client.EXPECT().ClientOptions().Return(&server.ClientOptions{ClientID: "cid"}).AnyTimes()
+	client.EXPECT().Attributes().Return(map[string]string{"model": "a"})
+	admin.store.addClient(client)
+	a.Equal(map[string]string{"model": "a"}, admin.store.getClientByIDLocked("cid").Attributes)
+
+	_, err := c.SetAttributes(context.Background(), &SetAttributesRequest{})
+	a.Equal(codes.InvalidArgument, status.Code(err))
+	_, err = c.SetAttributes(context.Background(), &SetAttributesRequest{ClientId: "cid", Attributes: map[string]string{"": "a"}})
+	a.Equal(codes.InvalidArgument, status.Code(err))
+
+	cs.EXPECT().SetAttributes("not_exist", nil).Return(server.ErrSessionNotFound)
+	_, err = c.SetAttributes(context.Background(), &SetAttributesRequest{ClientId: "not_exist"})
+	a.Equal(ErrNotFound, err)
+
+	attrs := map[string]string{"model": "b", "tenant": "acme"}
+	cs.EXPECT().SetAttributes("cid", attrs).Return(nil)
+	_, err = c.SetAttributes(context.Background(), &SetAttributesRequest{ClientId: "cid", Attributes: attrs})
+	a.NoError(err)
+	a.Equal(attrs, admin.store.getClientByIDLocked("cid").Attributes)
+}
@@ -2,52 +2,70 @@ package admin
 
 import (
 	"errors"
-	"net"
+	"time"
 )
 
 // Config is the configuration for the admin plugin.
 type Config struct {
-	HTTP HTTPConfig `yaml:"http"`
-	GRPC GRPCConfig `yaml:"grpc"`
+	// TokenAuth is the token authentication setting of the API.
+	TokenAuth TokenAuthConfig `yaml:"token_auth"`
+	// PublishBatch is the setting of the batched publish API.
+	PublishBatch PublishBatchConfig `yaml:"publish_batch"`
 }
 
-// HTTPConfig is the configuration for http endpoint.
-type HTTPConfig struct {
-	// Enable indicates whether to expose http endpoint.
-	Enable bool `yaml:"enable"`
-	// Addr is the address that the http server listen on.
-	Addr string `yaml:"http_addr"`
+// PublishBatchConfig is the configuration for the batched publish API.
+type PublishBatchConfig struct {
+	// MaxMessages is the maximum number of the messages in a batch.
+	MaxMessages int `yaml:"max_messages"`
+	// MaxBytes is the maximum total payload size of the messages in a batch.
+	MaxBytes int `yaml:"max_bytes"`
+	// MaxInflight is the maximum number of the batches which are published concurrently.
+	// The requests beyond it wait until a batch is done or their deadline is exceeded.
+	MaxInflight int `yaml:"max_inflight"`
 }
 
-// GRPCConfig is the configuration for gRPC endpoint.
-type GRPCConfig struct {
-	// Addr is the address that the gRPC server listen on.
-	Addr string `yaml:"http_addr"`
+// TokenAuthConfig is the configuration for the token authentication.
+// If enabled, all gRPC and HTTP API requests must carry a valid token in the "Authorization: Bearer <token>" header.
+type TokenAuthConfig struct {
+	// Enable indicates whether to enable the token authentication.
+	Enable bool `yaml:"enable"`
+	// TokenFile is the file to store the tokens.
+	// If it is a relative path, it locates in the same directory as the config file.
+	TokenFile string `yaml:"token_file"`
+	// DefaultTTL is the time to live of the token if it is not given in the create request, 0 means never expire.
+	DefaultTTL time.Duration `yaml:"default_ttl"`
+	// MaxTTL is the maximum time to live of the token, 0 means no limit.
+	MaxTTL time.Duration `yaml:"max_ttl"`
 }
 
 // Validate validates the configuration, and return an error if it is invalid.
 func (c *Config) Validate() error {
-	if c.HTTP.Enable {
-		_, _, err := net.SplitHostPort(c.HTTP.Addr)
-		if err != nil {
-			return errors.New("invalid http_addr")
-		}
+	if c.TokenAuth.Enable && c.TokenAuth.TokenFile == "" {
+		return errors.New("invalid token_file: cannot be empty")
+	}
+	if c.TokenAuth.DefaultTTL < 0 || c.TokenAuth.MaxTTL < 0 {
+		return errors.New("invalid ttl: cannot be negative")
 	}
-	_, _, err := net.SplitHostPort(c.GRPC.Addr)
-	if err != nil {
-		return errors.New("invalid grpc_addr")
+	if c.TokenAuth.MaxTTL != 0 && (c.TokenAuth.DefaultTTL == 0 || c.TokenAuth.DefaultTTL > c.TokenAuth.MaxTTL) {
+		return errors.New("invalid default_ttl: must be in (0, max_ttl]")
+	}
+	if c.PublishBatch.MaxMessages <= 0 || c.PublishBatch.MaxBytes <= 0 || c.PublishBatch.MaxInflight <= 0 {
+		return errors.New("invalid publish_batch: max_messages, max_bytes and max_inflight must be greater than 0")
 	}
 	return nil
 }
 
 // DefaultConfig is the default configuration.
 var DefaultConfig = Config{
-	HTTP: HTTPConfig{
-		Enable: true,
-		Addr:   "127.0.0.1:8083",
+	TokenAuth: TokenAuthConfig{
+		Enable:     false,
+		TokenFile:  "./gmqtt_admin_tokens.yml",
+		DefaultTTL: 30 * 24 * time.Hour,
 	},
-	GRPC: GRPCConfig{
-		Addr: "unix://./gmqttd.sock",
+	PublishBatch: PublishBatchConfig{
+		MaxMessages: 1000,
+		MaxBytes:    4 << 20,
+		MaxInflight: 4,
 	},
 }
 
@@ -61,14 +79,6 @@ func (c *Config) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	if err := unmarshal(v); err != nil {
 		return err
 	}
-	emptyGRPC := GRPCConfig{}
-	if v.Admin.GRPC == emptyGRPC {
-		v.Admin.GRPC = DefaultConfig.GRPC
-	}
-	emptyHTTP := HTTPConfig{}
-	if v.Admin.HTTP == emptyHTTP {
-		v.Admin.HTTP = DefaultConfig.HTTP
-	}
 	empty := cfg(Config{})
 	if v.Admin == empty {
 		v.Admin = cfg(DefaultConfig)
@@ -26,16 +26,16 @@ func (a *Admin) OnSessionCreatedWrapper(pre server.OnSessionCreated) server.OnSe
 }
 
 func (a *Admin) OnSessionResumedWrapper(pre server.OnSessionResumed) server.OnSessionResumed {
-	return func(ctx context.Context, client server.Client) {
-		pre(ctx, client)
+	return func(ctx context.Context, client server.Client, queued int) {
+		pre(ctx, client, queued)
 		a.store.addClient(client)
 	}
 }
 
 func (a *Admin) OnClosedWrapper(pre server.OnClosed) server.OnClosed {
-	return func(ctx context.Context, client server.Client, err error) {
-		pre(ctx, client, err)
-		a.store.setClientDisconnected(client.ClientOptions().ClientID)
+	return func(ctx context.Context, client server.Client, reason *server.CloseReason) {
+		pre(ctx, client, reason)
+		a.store.setClientDisconnected(client.ClientOptions().ClientID, reason)
 	}
 }
 
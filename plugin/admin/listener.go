@@ -0,0 +1,220 @@
+package admin
+
+import (
+	"context"
+	"errors"
+
+	"github.com/golang/protobuf/ptypes/empty"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/DrmagicE/gmqtt/config"
+	"github.com/DrmagicE/gmqtt/server"
+)
+
+type listenerService struct {
+	a *Admin
+}
+
+func (l *listenerService) mustEmbedUnimplementedListenerServiceServer() {
+	return
+}
+
+func boolPtr(b bool) *bool {
+	return &b
+}
+
+func listenerConfig(req *Listener) (config.ListenerConfig, error) {
+	if req == nil || req.Address == "" {
+		return config.ListenerConfig{}, ErrInvalidArgument("address", "")
+	}
+	cfg := config.ListenerConfig{
+		Address:    req.Address,
+		Label:      req.Label,
+		MountPoint: req.MountPoint,
+	}
+	if req.Tls != nil {
+		if req.Tls.Cert == "" || req.Tls.Key == "" {
+			return cfg, ErrInvalidArgument("tls", "cert and key are required")
+		}
+		cfg.TLSOptions = &config.TLSOptions{
+			CACert: req.Tls.Cacert,
+			Cert:   req.Tls.Cert,
+			Key:    req.Tls.Key,
+			Verify: req.Tls.Verify,
+		}
+	}
+	if req.WebsocketPath != "" {
+		cfg.Websocket = &config.WebsocketOptions{Path: req.WebsocketPath}
+	}
+	switch req.AllowAnonymous {
+	case AllowAnonymous_ALLOW_ANONYMOUS_UNSPECIFIED:
+	case AllowAnonymous_ALLOW_ANONYMOUS_TRUE:
+		cfg.AllowAnonymous = boolPtr(true)
+	case AllowAnonymous_ALLOW_ANONYMOUS_FALSE:
+		cfg.AllowAnonymous = boolPtr(false)
+	default:
+		return cfg, ErrInvalidArgument("allow_anonymous", "")
+	}
+	var err error
+	if cfg.RetainAsPublished, err = subOptionOverride(req.RetainAsPublished); err != nil {
+		return cfg, ErrInvalidArgument("retain_as_published", "")
+	}
+	if cfg.NoLocal, err = subOptionOverride(req.NoLocal); err != nil {
+		return cfg, ErrInvalidArgument("no_local", "")
+	}
+	if req.Codec != "" && cfg.Websocket != nil {
+		return cfg, ErrInvalidArgument("codec", "not supported by websocket listeners")
+	}
+	cfg.Codec = req.Codec
+	if err = cfg.Validate(); err != nil {
+		return cfg, ErrInvalidArgument("mount_point", err.Error())
+	}
+	return cfg, nil
+}
+
+func subOptionOverride(o SubOptionOverride) (*bool, error) {
+	switch o {
+	case SubOptionOverride_SUB_OPTION_OVERRIDE_UNSPECIFIED:
+		return nil, nil
+	case SubOptionOverride_SUB_OPTION_OVERRIDE_FORCE:
+		return boolPtr(true), nil
+	case SubOptionOverride_SUB_OPTION_OVERRIDE_FORBID:
+		return boolPtr(false), nil
+	}
+	return nil, errors.New("invalid subscription option override")
+}
+
+func subOptionOverrideToProto(b *bool) SubOptionOverride {
+	if b == nil {
+		return SubOptionOverride_SUB_OPTION_OVERRIDE_UNSPECIFIED
+	}
+	if *b {
+		return SubOptionOverride_SUB_OPTION_OVERRIDE_FORCE
+	}
+	return SubOptionOverride_SUB_OPTION_OVERRIDE_FORBID
+}
+
+func listenerToProto(cfg config.ListenerConfig) *Listener {
+	l := &Listener{
+		Address:           cfg.Address,
+		Label:             cfg.Label,
+		MountPoint:        cfg.MountPoint,
+		RetainAsPublished: subOptionOverrideToProto(cfg.RetainAsPublished),
+		NoLocal:           subOptionOverrideToProto(cfg.NoLocal),
+		Codec:             cfg.Codec,
+	}
+	if cfg.TLSOptions != nil {
+		l.Tls = &ListenerTLS{
+			Cert:   cfg.Cert,
+			Key:    cfg.Key,
+			Cacert: cfg.CACert,
+			Verify: cfg.Verify,
+		}
+	}
+	if cfg.Websocket != nil {
+		l.WebsocketPath = cfg.Websocket.Path
+	}
+	if cfg.AllowAnonymous != nil {
+		if *cfg.AllowAnonymous {
+			l.AllowAnonymous = AllowAnonymous_ALLOW_ANONYMOUS_TRUE
+		} else {
+			l.AllowAnonymous = AllowAnonymous_ALLOW_ANONYMOUS_FALSE
+		}
+	}
+	return l
+}
+
+func listenerError(err error) error {
+	switch err {
+	case nil:
+		return nil
+	case server.ErrListenerNotFound:
+		return ErrNotFound
+	case server.ErrListenerExists:
+		return status.Error(codes.AlreadyExists, err.Error())
+	}
+	return status.Error(codes.FailedPrecondition, err.Error())
+}
+
+// List lists all running listeners.
+func (l *listenerService) List(ctx context.Context, req *empty.Empty) (*ListListenersResponse, error) {
+	resp := &ListListenersResponse{}
+	for _, v := range l.a.listenerService.List() {
+		resp.Listeners = append(resp.Listeners, listenerToProto(v))
+	}
+	return resp, nil
+}
+
+// Add starts a new listener.
+func (l *listenerService) Add(ctx context.Context, req *AddListenerRequest) (*empty.Empty, error) {
+	cfg, err := listenerConfig(req.Listener)
+	if err != nil {
+		return nil, err
+	}
+	if err = l.a.listenerService.Add(cfg); err != nil {
+		return nil, listenerError(err)
+	}
+	return &empty.Empty{}, nil
+}
+
+// Update replaces the listener of the address.
+func (l *listenerService) Update(ctx context.Context, req *UpdateListenerRequest) (*empty.Empty, error) {
+	if req.Address == "" {
+		return nil, ErrInvalidArgument("address", "")
+	}
+	cfg, err := listenerConfig(req.Listener)
+	if err != nil {
+		return nil, err
+	}
+	if err = l.a.listenerService.Update(req.Address, cfg); err != nil {
+		return nil, listenerError(err)
+	}
+	return &empty.Empty{}, nil
+}
+
+// Remove stops the listener of the address.
+func (l *listenerService) Remove(ctx context.Context, req *RemoveListenerRequest) (*empty.Empty, error) {
+	if req.Address == "" {
+		return nil, ErrInvalidArgument("address", "")
+	}
+	if err := l.a.listenerService.Remove(req.Address); err != nil {
+		return nil, listenerError(err)
+	}
+	return &empty.Empty{}, nil
+}
+
+// Drain stops accepting on the listener of the address and optionally disconnects its clients.
+func (l *listenerService) Drain(ctx context.Context, req *DrainListenerRequest) (*empty.Empty, error) {
+	if req.Address == "" {
+		return nil, ErrInvalidArgument("address", "")
+	}
+	err := l.a.listenerService.Drain(req.Address, server.DrainOptions{
+		Migrate:         req.Migrate,
+		ServerReference: req.ServerReference,
+		Rate:            int(req.Rate),
+	})
+	if err != nil {
+		return nil, listenerError(err)
+	}
+	return &empty.Empty{}, nil
+}
+
+// GetDrainStatus returns the progress of the draining listener.
+func (l *listenerService) GetDrainStatus(ctx context.Context, req *GetDrainStatusRequest) (*DrainStatus, error) {
+	if req.Address == "" {
+		return nil, ErrInvalidArgument("address", "")
+	}
+	st, err := l.a.listenerService.DrainStatus(req.Address)
+	if err != nil {
+		return nil, listenerError(err)
+	}
+	return &DrainStatus{
+		Address:   st.Address,
+		StartedAt: timestamppb.New(st.StartedAt),
+		Migrating: st.Migrating,
+		Remaining: uint32(st.Remaining),
+		Migrated:  uint32(st.Migrated),
+	}, nil
+}
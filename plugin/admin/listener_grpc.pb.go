@@ -0,0 +1,290 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+
+package admin
+
+import (
+	context "context"
+	empty "github.com/golang/protobuf/ptypes/empty"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+const _ = grpc.SupportPackageIsVersion7
+
+// ListenerServiceClient is the client API for ListenerService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type ListenerServiceClient interface {
+	// List all running listeners.
+	List(ctx context.Context, in *empty.Empty, opts ...grpc.CallOption) (*ListListenersResponse, error)
+	// Add a new listener.
+	Add(ctx context.Context, in *AddListenerRequest, opts ...grpc.CallOption) (*empty.Empty, error)
+	// Update the listener of the address, the established connections are not affected.
+	Update(ctx context.Context, in *UpdateListenerRequest, opts ...grpc.CallOption) (*empty.Empty, error)
+	// Remove the listener of the address, the established connections are not affected.
+	Remove(ctx context.Context, in *RemoveListenerRequest, opts ...grpc.CallOption) (*empty.Empty, error)
+	// Stop accepting on the listener of the address and optionally disconnect its clients, so that the endpoint can be retired.
+	Drain(ctx context.Context, in *DrainListenerRequest, opts ...grpc.CallOption) (*empty.Empty, error)
+	// Get the progress of the draining listener.
+	GetDrainStatus(ctx context.Context, in *GetDrainStatusRequest, opts ...grpc.CallOption) (*DrainStatus, error)
+}
+
+type listenerServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewListenerServiceClient(cc grpc.ClientConnInterface) ListenerServiceClient {
+	return &listenerServiceClient{cc}
+}
+
+func (c *listenerServiceClient) List(ctx context.Context, in *empty.Empty, opts ...grpc.CallOption) (*ListListenersResponse, error) {
+	out := new(ListListenersResponse)
+	err := c.cc.Invoke(ctx, "/gmqtt.admin.api.ListenerService/List", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *listenerServiceClient) Add(ctx context.Context, in *AddListenerRequest, opts ...grpc.CallOption) (*empty.Empty, error) {
+	out := new(empty.Empty)
+	err := c.cc.Invoke(ctx, "/gmqtt.admin.api.ListenerService/Add", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *listenerServiceClient) Update(ctx context.Context, in *UpdateListenerRequest, opts ...grpc.CallOption) (*empty.Empty, error) {
+	out := new(empty.Empty)
+	err := c.cc.Invoke(ctx, "/gmqtt.admin.api.ListenerService/Update", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *listenerServiceClient) Remove(ctx context.Context, in *RemoveListenerRequest, opts ...grpc.CallOption) (*empty.Empty, error) {
+	out := new(empty.Empty)
+	err := c.cc.Invoke(ctx, "/gmqtt.admin.api.ListenerService/Remove", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *listenerServiceClient) Drain(ctx context.Context, in *DrainListenerRequest, opts ...grpc.CallOption) (*empty.Empty, error) {
+	out := new(empty.Empty)
+	err := c.cc.Invoke(ctx, "/gmqtt.admin.api.ListenerService/Drain", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *listenerServiceClient) GetDrainStatus(ctx context.Context, in *GetDrainStatusRequest, opts ...grpc.CallOption) (*DrainStatus, error) {
+	out := new(DrainStatus)
+	err := c.cc.Invoke(ctx, "/gmqtt.admin.api.ListenerService/GetDrainStatus", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ListenerServiceServer is the server API for ListenerService service.
+// All implementations must embed UnimplementedListenerServiceServer
+// for forward compatibility
+type ListenerServiceServer interface {
+	// List all running listeners.
+	List(context.Context, *empty.Empty) (*ListListenersResponse, error)
+	// Add a new listener.
+	Add(context.Context, *AddListenerRequest) (*empty.Empty, error)
+	// Update the listener of the address, the established connections are not affected.
+	Update(context.Context, *UpdateListenerRequest) (*empty.Empty, error)
+	// Remove the listener of the address, the established connections are not affected.
+	Remove(context.Context, *RemoveListenerRequest) (*empty.Empty, error)
+	// Stop accepting on the listener of the address and optionally disconnect its clients, so that the endpoint can be retired.
+	Drain(context.Context, *DrainListenerRequest) (*empty.Empty, error)
+	// Get the progress of the draining listener.
+	GetDrainStatus(context.Context, *GetDrainStatusRequest) (*DrainStatus, error)
+	mustEmbedUnimplementedListenerServiceServer()
+}
+
+// UnimplementedListenerServiceServer must be embedded to have forward compatible implementations.
+type UnimplementedListenerServiceServer struct {
+}
+
+func (UnimplementedListenerServiceServer) List(context.Context, *empty.Empty) (*ListListenersResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method List not implemented")
+}
+func (UnimplementedListenerServiceServer) Add(context.Context, *AddListenerRequest) (*empty.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Add not implemented")
+}
+func (UnimplementedListenerServiceServer) Update(context.Context, *UpdateListenerRequest) (*empty.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Update not implemented")
+}
+func (UnimplementedListenerServiceServer) Remove(context.Context, *RemoveListenerRequest) (*empty.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Remove not implemented")
+}
+func (UnimplementedListenerServiceServer) Drain(context.Context, *DrainListenerRequest) (*empty.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Drain not implemented")
+}
+func (UnimplementedListenerServiceServer) GetDrainStatus(context.Context, *GetDrainStatusRequest) (*DrainStatus, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetDrainStatus not implemented")
+}
+func (UnimplementedListenerServiceServer) mustEmbedUnimplementedListenerServiceServer() {}
+
+// UnsafeListenerServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to ListenerServiceServer will
+// result in compilation errors.
+type UnsafeListenerServiceServer interface {
+	mustEmbedUnimplementedListenerServiceServer()
+}
+
+func RegisterListenerServiceServer(s grpc.ServiceRegistrar, srv ListenerServiceServer) {
+	s.RegisterService(&_ListenerService_serviceDesc, srv)
+}
+
+func _ListenerService_List_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(empty.Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ListenerServiceServer).List(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/gmqtt.admin.api.ListenerService/List",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ListenerServiceServer).List(ctx, req.(*empty.Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ListenerService_Add_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AddListenerRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ListenerServiceServer).Add(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/gmqtt.admin.api.ListenerService/Add",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ListenerServiceServer).Add(ctx, req.(*AddListenerRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ListenerService_Update_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UpdateListenerRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ListenerServiceServer).Update(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/gmqtt.admin.api.ListenerService/Update",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ListenerServiceServer).Update(ctx, req.(*UpdateListenerRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ListenerService_Remove_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RemoveListenerRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ListenerServiceServer).Remove(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/gmqtt.admin.api.ListenerService/Remove",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ListenerServiceServer).Remove(ctx, req.(*RemoveListenerRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ListenerService_Drain_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DrainListenerRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ListenerServiceServer).Drain(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/gmqtt.admin.api.ListenerService/Drain",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ListenerServiceServer).Drain(ctx, req.(*DrainListenerRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ListenerService_GetDrainStatus_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetDrainStatusRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ListenerServiceServer).GetDrainStatus(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/gmqtt.admin.api.ListenerService/GetDrainStatus",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ListenerServiceServer).GetDrainStatus(ctx, req.(*GetDrainStatusRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+var _ListenerService_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "gmqtt.admin.api.ListenerService",
+	HandlerType: (*ListenerServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "List",
+			Handler:    _ListenerService_List_Handler,
+		},
+		{
+			MethodName: "Add",
+			Handler:    _ListenerService_Add_Handler,
+		},
+		{
+			MethodName: "Update",
+			Handler:    _ListenerService_Update_Handler,
+		},
+		{
+			MethodName: "Remove",
+			Handler:    _ListenerService_Remove_Handler,
+		},
+		{
+			MethodName: "Drain",
+			Handler:    _ListenerService_Drain_Handler,
+		},
+		{
+			MethodName: "GetDrainStatus",
+			Handler:    _ListenerService_GetDrainStatus_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "listener.proto",
+}
@@ -0,0 +1,141 @@
+package admin
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/DrmagicE/gmqtt/config"
+	"github.com/DrmagicE/gmqtt/server"
+)
+
+func TestListenerService(t *testing.T) {
+	a := assert.New(t)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	ls := server.NewMockListenerService(ctrl)
+	l := &listenerService{a: &Admin{listenerService: ls}}
+
+	allow := false
+	noLocal := true
+	cfg := config.ListenerConfig{
+		Address:        ":8883",
+		TLSOptions:     &config.TLSOptions{Cert: "cert.pem", Key: "key.pem"},
+		Websocket:      &config.WebsocketOptions{Path: "/mqtt"},
+		AllowAnonymous: &allow,
+		Label:          "web",
+		NoLocal:        &noLocal,
+	}
+	pb := &Listener{
+		Address:        ":8883",
+		Tls:            &ListenerTLS{Cert: "cert.pem", Key: "key.pem"},
+		WebsocketPath:  "/mqtt",
+		AllowAnonymous: AllowAnonymous_ALLOW_ANONYMOUS_FALSE,
+		Label:          "web",
+		NoLocal:        SubOptionOverride_SUB_OPTION_OVERRIDE_FORCE,
+	}
+
+	ls.EXPECT().Add(cfg).Return(nil)
+	_, err := l.Add(context.Background(), &AddListenerRequest{Listener: pb})
+	a.Nil(err)
+
+	ls.EXPECT().Add(cfg).Return(server.ErrListenerExists)
+	_, err = l.Add(context.Background(), &AddListenerRequest{Listener: pb})
+	a.Equal(codes.AlreadyExists, status.Code(err))
+
+	ls.EXPECT().List().Return([]config.ListenerConfig{cfg})
+	resp, err := l.List(context.Background(), nil)
+	a.Nil(err)
+	a.Equal([]*Listener{pb}, resp.Listeners)
+
+	tcp := config.ListenerConfig{Address: ":1884", Codec: "gateway"}
+	ls.EXPECT().Add(tcp).Return(nil)
+	_, err = l.Add(context.Background(), &AddListenerRequest{Listener: &Listener{Address: ":1884", Codec: "gateway"}})
+	a.Nil(err)
+
+	ls.EXPECT().Update(":1883", cfg).Return(errors.New("address already in use"))
+	_, err = l.Update(context.Background(), &UpdateListenerRequest{Address: ":1883", Listener: pb})
+	a.Equal(codes.FailedPrecondition, status.Code(err))
+
+	ls.EXPECT().Remove(":1883").Return(server.ErrListenerNotFound)
+	_, err = l.Remove(context.Background(), &RemoveListenerRequest{Address: ":1883"})
+	a.Equal(codes.NotFound, status.Code(err))
+}
+
+func TestListenerService_Drain(t *testing.T) {
+	a := assert.New(t)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	ls := server.NewMockListenerService(ctrl)
+	l := &listenerService{a: &Admin{listenerService: ls}}
+
+	_, err := l.Drain(context.Background(), &DrainListenerRequest{})
+	a.Equal(codes.InvalidArgument, status.Code(err))
+
+	opts := server.DrainOptions{Migrate: true, ServerReference: "broker-2:1883", Rate: 100}
+	ls.EXPECT().Drain(":1883", opts).Return(nil)
+	_, err = l.Drain(context.Background(), &DrainListenerRequest{
+		Address:         ":1883",
+		Migrate:         true,
+		ServerReference: "broker-2:1883",
+		Rate:            100,
+	})
+	a.Nil(err)
+
+	ls.EXPECT().Drain(":1883", opts).Return(server.ErrListenerMigrating)
+	_, err = l.Drain(context.Background(), &DrainListenerRequest{
+		Address:         ":1883",
+		Migrate:         true,
+		ServerReference: "broker-2:1883",
+		Rate:            100,
+	})
+	a.Equal(codes.FailedPrecondition, status.Code(err))
+
+	startedAt := time.Unix(1600000000, 0)
+	ls.EXPECT().DrainStatus(":1883").Return(server.DrainStatus{
+		Address:   ":1883",
+		StartedAt: startedAt,
+		Migrating: true,
+		Remaining: 2,
+		Migrated:  8,
+	}, nil)
+	st, err := l.GetDrainStatus(context.Background(), &GetDrainStatusRequest{Address: ":1883"})
+	a.Nil(err)
+	a.Equal(":1883", st.Address)
+	a.Equal(startedAt.Unix(), st.StartedAt.AsTime().Unix())
+	a.True(st.Migrating)
+	a.EqualValues(2, st.Remaining)
+	a.EqualValues(8, st.Migrated)
+
+	ls.EXPECT().DrainStatus(":1884").Return(server.DrainStatus{}, server.ErrListenerNotDraining)
+	_, err = l.GetDrainStatus(context.Background(), &GetDrainStatusRequest{Address: ":1884"})
+	a.Equal(codes.FailedPrecondition, status.Code(err))
+}
+
+func TestListenerService_InvalidArgument(t *testing.T) {
+	a := assert.New(t)
+	l := &listenerService{a: &Admin{}}
+	for _, v := range []*Listener{
+		nil,
+		{},
+		{Address: ":1883", Tls: &ListenerTLS{Cert: "cert.pem"}},
+		{Address: ":1883", AllowAnonymous: 3},
+		{Address: ":1883", RetainAsPublished: 3},
+		{Address: ":1883", NoLocal: 3},
+		{Address: ":1883", MountPoint: "a/#"},
+		{Address: ":1883", WebsocketPath: "/mqtt", Codec: "gateway"},
+	} {
+		_, err := l.Add(context.Background(), &AddListenerRequest{Listener: v})
+		a.Equal(codes.InvalidArgument, status.Code(err))
+	}
+	_, err := l.Update(context.Background(), &UpdateListenerRequest{Listener: &Listener{Address: ":1883"}})
+	a.Equal(codes.InvalidArgument, status.Code(err))
+	_, err = l.Remove(context.Background(), &RemoveListenerRequest{})
+	a.Equal(codes.InvalidArgument, status.Code(err))
+}
@@ -2,8 +2,11 @@ package admin
 
 import (
 	"context"
+	"fmt"
 
 	"github.com/golang/protobuf/ptypes/empty"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 
 	"github.com/DrmagicE/gmqtt"
 	"github.com/DrmagicE/gmqtt/pkg/packets"
@@ -11,25 +14,35 @@ import (
 
 type publisher struct {
 	a *Admin
+	// batches limits the number of the batches which are published concurrently.
+	batches chan struct{}
+}
+
+func newPublisher(a *Admin) *publisher {
+	return &publisher{
+		a:       a,
+		batches: make(chan struct{}, a.config.PublishBatch.MaxInflight),
+	}
 }
 
 func (p *publisher) mustEmbedUnimplementedPublishServiceServer() {
 	return
 }
 
-// Publish publishes a message into broker.
-func (p *publisher) Publish(ctx context.Context, req *PublishRequest) (resp *empty.Empty, err error) {
+// toMessage validates the publish request and converts it to the message.
+// The field is the prefix of the name of the invalid argument.
+func toMessage(req *PublishRequest, field string) (*gmqtt.Message, error) {
 	if !packets.ValidTopicName(false, []byte(req.TopicName)) {
-		return nil, ErrInvalidArgument("topic_name", "")
+		return nil, ErrInvalidArgument(field+"topic_name", "")
 	}
 	if req.Qos > uint32(packets.Qos2) {
-		return nil, ErrInvalidArgument("qos", "")
+		return nil, ErrInvalidArgument(field+"qos", "")
 	}
 	if req.PayloadFormat != 0 && req.PayloadFormat != 1 {
-		return nil, ErrInvalidArgument("payload_format", "")
+		return nil, ErrInvalidArgument(field+"payload_format", "")
 	}
 	if req.ResponseTopic != "" && !packets.ValidV5Topic([]byte(req.ResponseTopic)) {
-		return nil, ErrInvalidArgument("response_topic", "")
+		return nil, ErrInvalidArgument(field+"response_topic", "")
 	}
 	var userPpt []packets.UserProperty
 	for _, v := range req.UserProperties {
@@ -38,8 +51,7 @@ func (p *publisher) Publish(ctx context.Context, req *PublishRequest) (resp *emp
 			V: v.V,
 		})
 	}
-
-	p.a.publisher.Publish(&gmqtt.Message{
+	return &gmqtt.Message{
 		Dup:             false,
 		QoS:             byte(req.Qos),
 		Retained:        req.Retained,
@@ -51,6 +63,48 @@ func (p *publisher) Publish(ctx context.Context, req *PublishRequest) (resp *emp
 		PayloadFormat:   packets.PayloadFormat(req.PayloadFormat),
 		ResponseTopic:   req.ResponseTopic,
 		UserProperties:  userPpt,
-	})
+	}, nil
+}
+
+// Publish publishes a message into broker.
+func (p *publisher) Publish(ctx context.Context, req *PublishRequest) (resp *empty.Empty, err error) {
+	msg, err := toMessage(req, "")
+	if err != nil {
+		return nil, err
+	}
+	p.a.publisher.Publish(msg)
 	return &empty.Empty{}, nil
 }
+
+// PublishBatch publishes a batch of messages into broker.
+func (p *publisher) PublishBatch(ctx context.Context, req *PublishBatchRequest) (*PublishBatchResponse, error) {
+	cfg := p.a.config.PublishBatch
+	if len(req.Messages) == 0 || len(req.Messages) > cfg.MaxMessages {
+		return nil, ErrInvalidArgument("messages", fmt.Sprintf("the number of messages must be in [1, %d]", cfg.MaxMessages))
+	}
+	msgs := make([]*gmqtt.Message, len(req.Messages))
+	var size int
+	for k, v := range req.Messages {
+		msg, err := toMessage(v, fmt.Sprintf("messages[%d].", k))
+		if err != nil {
+			return nil, err
+		}
+		size += len(msg.Payload)
+		msgs[k] = msg
+	}
+	if size > cfg.MaxBytes {
+		return nil, ErrInvalidArgument("messages", fmt.Sprintf("the total payload size exceeds %d bytes", cfg.MaxBytes))
+	}
+	select {
+	case p.batches <- struct{}{}:
+	case <-ctx.Done():
+		return nil, status.Error(codes.ResourceExhausted, "too many batches in process")
+	}
+	defer func() {
+		<-p.batches
+	}()
+	for _, msg := range msgs {
+		p.a.publisher.Publish(msg)
+	}
+	return &PublishBatchResponse{Accepted: uint32(len(msgs))}, nil
+}
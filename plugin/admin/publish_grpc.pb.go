@@ -20,6 +20,10 @@ const _ = grpc.SupportPackageIsVersion7
 type PublishServiceClient interface {
 	// Publish message to broker
 	Publish(ctx context.Context, in *PublishRequest, opts ...grpc.CallOption) (*empty.Empty, error)
+	// Publish a batch of messages to broker with a single acknowledgment, e.g. for the uplinks of the edge gateways.
+	// The batch is rejected as a whole if any message is invalid.
+	// If there are too many batches in process, the request waits until its deadline and fails with RESOURCE_EXHAUSTED.
+	PublishBatch(ctx context.Context, in *PublishBatchRequest, opts ...grpc.CallOption) (*PublishBatchResponse, error)
 }
 
 type publishServiceClient struct {
@@ -39,12 +43,25 @@ func (c *publishServiceClient) Publish(ctx context.Context, in *PublishRequest,
 	return out, nil
 }
 
+func (c *publishServiceClient) PublishBatch(ctx context.Context, in *PublishBatchRequest, opts ...grpc.CallOption) (*PublishBatchResponse, error) {
+	out := new(PublishBatchResponse)
+	err := c.cc.Invoke(ctx, "/gmqtt.admin.api.PublishService/PublishBatch", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // PublishServiceServer is the server API for PublishService service.
 // All implementations must embed UnimplementedPublishServiceServer
 // for forward compatibility
 type PublishServiceServer interface {
 	// Publish message to broker
 	Publish(context.Context, *PublishRequest) (*empty.Empty, error)
+	// Publish a batch of messages to broker with a single acknowledgment, e.g. for the uplinks of the edge gateways.
+	// The batch is rejected as a whole if any message is invalid.
+	// If there are too many batches in process, the request waits until its deadline and fails with RESOURCE_EXHAUSTED.
+	PublishBatch(context.Context, *PublishBatchRequest) (*PublishBatchResponse, error)
 	mustEmbedUnimplementedPublishServiceServer()
 }
 
@@ -55,6 +72,9 @@ type UnimplementedPublishServiceServer struct {
 func (UnimplementedPublishServiceServer) Publish(context.Context, *PublishRequest) (*empty.Empty, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method Publish not implemented")
 }
+func (UnimplementedPublishServiceServer) PublishBatch(context.Context, *PublishBatchRequest) (*PublishBatchResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method PublishBatch not implemented")
+}
 func (UnimplementedPublishServiceServer) mustEmbedUnimplementedPublishServiceServer() {}
 
 // UnsafePublishServiceServer may be embedded to opt out of forward compatibility for this service.
@@ -86,6 +106,24 @@ func _PublishService_Publish_Handler(srv interface{}, ctx context.Context, dec f
 	return interceptor(ctx, in, info, handler)
 }
 
+func _PublishService_PublishBatch_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PublishBatchRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PublishServiceServer).PublishBatch(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/gmqtt.admin.api.PublishService/PublishBatch",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PublishServiceServer).PublishBatch(ctx, req.(*PublishBatchRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 var _PublishService_serviceDesc = grpc.ServiceDesc{
 	ServiceName: "gmqtt.admin.api.PublishService",
 	HandlerType: (*PublishServiceServer)(nil),
@@ -94,6 +132,10 @@ var _PublishService_serviceDesc = grpc.ServiceDesc{
 			MethodName: "Publish",
 			Handler:    _PublishService_Publish_Handler,
 		},
+		{
+			MethodName: "PublishBatch",
+			Handler:    _PublishService_PublishBatch_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "publish.proto",
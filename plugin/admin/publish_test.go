@@ -3,9 +3,11 @@ package admin
 import (
 	"context"
 	"testing"
+	"time"
 
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 
 	"github.com/DrmagicE/gmqtt"
@@ -123,3 +125,56 @@ func TestPublisher_Publish_InvalidArgument(t *testing.T) {
 	}
 
 }
+
+func TestPublisher_PublishBatch(t *testing.T) {
+	a := assert.New(t)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mp := server.NewMockPublisher(ctrl)
+	pub := newPublisher(&Admin{
+		config: &Config{
+			PublishBatch: PublishBatchConfig{
+				MaxMessages: 2,
+				MaxBytes:    4,
+				MaxInflight: 1,
+			},
+		},
+		publisher: mp,
+	})
+
+	gomock.InOrder(
+		mp.EXPECT().Publish(&gmqtt.Message{Topic: "a", Payload: []byte("ab"), QoS: 1, CorrelationData: []byte{}}),
+		mp.EXPECT().Publish(&gmqtt.Message{Topic: "b", Payload: []byte("cd"), CorrelationData: []byte{}}),
+	)
+	resp, err := pub.PublishBatch(context.Background(), &PublishBatchRequest{
+		Messages: []*PublishRequest{
+			{TopicName: "a", Payload: "ab", Qos: 1},
+			{TopicName: "b", Payload: "cd"},
+		},
+	})
+	a.NoError(err)
+	a.EqualValues(2, resp.Accepted)
+
+	// the batch is rejected as a whole.
+	for _, v := range []*PublishBatchRequest{
+		{},
+		{Messages: []*PublishRequest{{TopicName: "a"}, {TopicName: "b"}, {TopicName: "c"}}},
+		{Messages: []*PublishRequest{{TopicName: "a", Payload: "abc"}, {TopicName: "b", Payload: "cd"}}},
+		{Messages: []*PublishRequest{{TopicName: "a"}, {TopicName: "b/#"}}},
+	} {
+		_, err = pub.PublishBatch(context.Background(), v)
+		a.Equal(codes.InvalidArgument, status.Code(err))
+	}
+	_, err = pub.PublishBatch(context.Background(), &PublishBatchRequest{
+		Messages: []*PublishRequest{{TopicName: "a"}, {TopicName: "b/#"}},
+	})
+	a.Contains(status.Convert(err).Message(), "messages[1].topic_name")
+
+	// the request waits until its deadline if there are too many batches in process.
+	pub.batches <- struct{}{}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = pub.PublishBatch(ctx, &PublishBatchRequest{Messages: []*PublishRequest{{TopicName: "a"}}})
+	a.Equal(codes.ResourceExhausted, status.Code(err))
+}
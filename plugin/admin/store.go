@@ -61,14 +61,26 @@ func (s *store) addClient(client server.Client) {
 	s.clientMu.Unlock()
 }
 
-func (s *store) setClientDisconnected(clientID string) {
+func (s *store) setClientDisconnected(clientID string, reason *server.CloseReason) {
 	s.clientMu.Lock()
 	defer s.clientMu.Unlock()
 	l := s.clientIndexer.GetByID(clientID)
 	if l == nil {
 		return
 	}
-	l.Value.(*Client).DisconnectedAt = timestamppb.Now()
+	c := l.Value.(*Client)
+	c.DisconnectedAt = timestamppb.Now()
+	if reason != nil {
+		c.CloseReason = reason.String()
+	}
+}
+
+func (s *store) setClientAttributes(clientID string, attrs map[string]string) {
+	s.clientMu.Lock()
+	defer s.clientMu.Unlock()
+	if c := s.getClientByIDLocked(clientID); c != nil {
+		c.Attributes = attrs
+	}
 }
 
 func (s *store) removeClient(clientID string) {
@@ -100,6 +112,7 @@ func newClientInfo(client server.Client, maxQueue uint32) *Client {
 		SessionExpiry:  clientOptions.SessionExpiry,
 		MaxInflight:    uint32(clientOptions.MaxInflight),
 		MaxQueue:       maxQueue,
+		Attributes:     client.Attributes(),
 	}
 	return rs
 }
@@ -180,3 +180,92 @@ func (s *subscriptionService) Unsubscribe(ctx context.Context, req *UnsubscribeR
 	}
 	return &empty.Empty{}, nil
 }
+
+// ListTopicFilterStats lists the statistics of the topic filters which have subscribers.
+func (s *subscriptionService) ListTopicFilterStats(ctx context.Context, req *ListTopicFilterStatsRequest) (*ListTopicFilterStatsResponse, error) {
+	page, pageSize := GetPage(req.Page, req.PageSize)
+	offset, n := GetOffsetN(page, pageSize)
+	var filters []*TopicFilterStats
+	for _, v := range s.a.statsReader.GetTopicStats().TopicFilters {
+		if req.Namespace != "" && v.Namespace != req.Namespace {
+			continue
+		}
+		if req.Orphaned && v.Matched != 0 {
+			continue
+		}
+		filters = append(filters, &TopicFilterStats{
+			TopicFilter: v.TopicFilter,
+			Namespace:   v.Namespace,
+			Subscribers: v.Subscribers,
+			Matched:     v.Matched,
+		})
+	}
+	resp := &ListTopicFilterStatsResponse{
+		TotalCount: uint32(len(filters)),
+	}
+	if offset < uint(len(filters)) {
+		end := offset + n
+		if end > uint(len(filters)) {
+			end = uint(len(filters))
+		}
+		resp.TopicFilters = filters[offset:end]
+	}
+	return resp, nil
+}
+
+// ListNamespaceStats lists the statistics of the top-level namespaces of the topic filters.
+func (s *subscriptionService) ListNamespaceStats(ctx context.Context, req *empty.Empty) (*ListNamespaceStatsResponse, error) {
+	resp := &ListNamespaceStatsResponse{}
+	for _, v := range s.a.statsReader.GetTopicStats().Namespaces {
+		resp.Namespaces = append(resp.Namespaces, &NamespaceStats{
+			Namespace:    v.Namespace,
+			TopicFilters: v.TopicFilters,
+			Subscribers:  v.Subscribers,
+			Matched:      v.Matched,
+		})
+	}
+	return resp, nil
+}
+
+// ListSharedGroupStats lists the statistics of the shared subscription groups and of their members.
+func (s *subscriptionService) ListSharedGroupStats(ctx context.Context, req *ListSharedGroupStatsRequest) (*ListSharedGroupStatsResponse, error) {
+	resp := &ListSharedGroupStatsResponse{}
+	for _, v := range s.a.statsReader.GetSharedStats() {
+		if req.ShareName != "" && v.ShareName != req.ShareName {
+			continue
+		}
+		g := &SharedGroupStats{
+			TopicFilter: v.TopicFilter,
+			ShareName:   v.ShareName,
+			Filter:      v.Filter,
+			Delivered:   v.Delivered,
+			Redelivered: v.Redelivered,
+		}
+		for _, m := range v.Members {
+			g.Members = append(g.Members, &SharedMemberStats{
+				ClientId:    m.ClientID,
+				Delivered:   m.Delivered,
+				Redelivered: m.Redelivered,
+			})
+		}
+		resp.Groups = append(resp.Groups, g)
+	}
+	return resp, nil
+}
+
+// ListSubscriptionIDStats lists the delivery statistics of the subscription identifiers of the client.
+func (s *subscriptionService) ListSubscriptionIDStats(ctx context.Context, req *ListSubscriptionIDStatsRequest) (*ListSubscriptionIDStatsResponse, error) {
+	if req.ClientId == "" {
+		return nil, ErrInvalidArgument("client_id", "cannot be empty")
+	}
+	resp := &ListSubscriptionIDStatsResponse{}
+	for _, v := range s.a.statsReader.GetSubscriptionIDStats(req.ClientId) {
+		resp.Stats = append(resp.Stats, &SubscriptionIDStats{
+			Id:             v.ID,
+			TopicFilters:   v.TopicFilters,
+			Delivered:      v.Delivered,
+			DeliveredBytes: v.DeliveredBytes,
+		})
+	}
+	return resp, nil
+}
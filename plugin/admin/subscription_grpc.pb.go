@@ -26,6 +26,19 @@ type SubscriptionServiceClient interface {
 	Subscribe(ctx context.Context, in *SubscribeRequest, opts ...grpc.CallOption) (*SubscribeResponse, error)
 	// Unsubscribe topics for the client.
 	Unsubscribe(ctx context.Context, in *UnsubscribeRequest, opts ...grpc.CallOption) (*empty.Empty, error)
+	// List the statistics of the topic filters which have subscribers, to find the orphaned or over-shared topic filters.
+	// It walks through all subscriptions, do not call it frequently.
+	ListTopicFilterStats(ctx context.Context, in *ListTopicFilterStatsRequest, opts ...grpc.CallOption) (*ListTopicFilterStatsResponse, error)
+	// List the statistics of the top-level namespaces of the topic filters.
+	// It walks through all subscriptions, do not call it frequently.
+	ListNamespaceStats(ctx context.Context, in *empty.Empty, opts ...grpc.CallOption) (*ListNamespaceStatsResponse, error)
+	// List the statistics of the shared subscription groups and of their members, to find the uneven load distribution.
+	// It walks through all shared subscriptions, do not call it frequently.
+	ListSharedGroupStats(ctx context.Context, in *ListSharedGroupStatsRequest, opts ...grpc.CallOption) (*ListSharedGroupStatsResponse, error)
+	// List the delivery statistics of the subscription identifiers of the client,
+	// to see the volume of each logical stream when the client multiplexes many of them over one connection.
+	// The messages resent after reconnecting carry no identifier and are not counted.
+	ListSubscriptionIDStats(ctx context.Context, in *ListSubscriptionIDStatsRequest, opts ...grpc.CallOption) (*ListSubscriptionIDStatsResponse, error)
 }
 
 type subscriptionServiceClient struct {
@@ -72,6 +85,42 @@ func (c *subscriptionServiceClient) Unsubscribe(ctx context.Context, in *Unsubsc
 	return out, nil
 }
 
+func (c *subscriptionServiceClient) ListTopicFilterStats(ctx context.Context, in *ListTopicFilterStatsRequest, opts ...grpc.CallOption) (*ListTopicFilterStatsResponse, error) {
+	out := new(ListTopicFilterStatsResponse)
+	err := c.cc.Invoke(ctx, "/gmqtt.admin.api.SubscriptionService/ListTopicFilterStats", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *subscriptionServiceClient) ListNamespaceStats(ctx context.Context, in *empty.Empty, opts ...grpc.CallOption) (*ListNamespaceStatsResponse, error) {
+	out := new(ListNamespaceStatsResponse)
+	err := c.cc.Invoke(ctx, "/gmqtt.admin.api.SubscriptionService/ListNamespaceStats", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *subscriptionServiceClient) ListSharedGroupStats(ctx context.Context, in *ListSharedGroupStatsRequest, opts ...grpc.CallOption) (*ListSharedGroupStatsResponse, error) {
+	out := new(ListSharedGroupStatsResponse)
+	err := c.cc.Invoke(ctx, "/gmqtt.admin.api.SubscriptionService/ListSharedGroupStats", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *subscriptionServiceClient) ListSubscriptionIDStats(ctx context.Context, in *ListSubscriptionIDStatsRequest, opts ...grpc.CallOption) (*ListSubscriptionIDStatsResponse, error) {
+	out := new(ListSubscriptionIDStatsResponse)
+	err := c.cc.Invoke(ctx, "/gmqtt.admin.api.SubscriptionService/ListSubscriptionIDStats", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // SubscriptionServiceServer is the server API for SubscriptionService service.
 // All implementations must embed UnimplementedSubscriptionServiceServer
 // for forward compatibility
@@ -84,6 +133,19 @@ type SubscriptionServiceServer interface {
 	Subscribe(context.Context, *SubscribeRequest) (*SubscribeResponse, error)
 	// Unsubscribe topics for the client.
 	Unsubscribe(context.Context, *UnsubscribeRequest) (*empty.Empty, error)
+	// List the statistics of the topic filters which have subscribers, to find the orphaned or over-shared topic filters.
+	// It walks through all subscriptions, do not call it frequently.
+	ListTopicFilterStats(context.Context, *ListTopicFilterStatsRequest) (*ListTopicFilterStatsResponse, error)
+	// List the statistics of the top-level namespaces of the topic filters.
+	// It walks through all subscriptions, do not call it frequently.
+	ListNamespaceStats(context.Context, *empty.Empty) (*ListNamespaceStatsResponse, error)
+	// List the statistics of the shared subscription groups and of their members, to find the uneven load distribution.
+	// It walks through all shared subscriptions, do not call it frequently.
+	ListSharedGroupStats(context.Context, *ListSharedGroupStatsRequest) (*ListSharedGroupStatsResponse, error)
+	// List the delivery statistics of the subscription identifiers of the client,
+	// to see the volume of each logical stream when the client multiplexes many of them over one connection.
+	// The messages resent after reconnecting carry no identifier and are not counted.
+	ListSubscriptionIDStats(context.Context, *ListSubscriptionIDStatsRequest) (*ListSubscriptionIDStatsResponse, error)
 	mustEmbedUnimplementedSubscriptionServiceServer()
 }
 
@@ -103,6 +165,18 @@ func (UnimplementedSubscriptionServiceServer) Subscribe(context.Context, *Subscr
 func (UnimplementedSubscriptionServiceServer) Unsubscribe(context.Context, *UnsubscribeRequest) (*empty.Empty, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method Unsubscribe not implemented")
 }
+func (UnimplementedSubscriptionServiceServer) ListTopicFilterStats(context.Context, *ListTopicFilterStatsRequest) (*ListTopicFilterStatsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListTopicFilterStats not implemented")
+}
+func (UnimplementedSubscriptionServiceServer) ListNamespaceStats(context.Context, *empty.Empty) (*ListNamespaceStatsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListNamespaceStats not implemented")
+}
+func (UnimplementedSubscriptionServiceServer) ListSharedGroupStats(context.Context, *ListSharedGroupStatsRequest) (*ListSharedGroupStatsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListSharedGroupStats not implemented")
+}
+func (UnimplementedSubscriptionServiceServer) ListSubscriptionIDStats(context.Context, *ListSubscriptionIDStatsRequest) (*ListSubscriptionIDStatsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListSubscriptionIDStats not implemented")
+}
 func (UnimplementedSubscriptionServiceServer) mustEmbedUnimplementedSubscriptionServiceServer() {}
 
 // UnsafeSubscriptionServiceServer may be embedded to opt out of forward compatibility for this service.
@@ -188,6 +262,78 @@ func _SubscriptionService_Unsubscribe_Handler(srv interface{}, ctx context.Conte
 	return interceptor(ctx, in, info, handler)
 }
 
+func _SubscriptionService_ListTopicFilterStats_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListTopicFilterStatsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SubscriptionServiceServer).ListTopicFilterStats(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/gmqtt.admin.api.SubscriptionService/ListTopicFilterStats",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SubscriptionServiceServer).ListTopicFilterStats(ctx, req.(*ListTopicFilterStatsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _SubscriptionService_ListNamespaceStats_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(empty.Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SubscriptionServiceServer).ListNamespaceStats(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/gmqtt.admin.api.SubscriptionService/ListNamespaceStats",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SubscriptionServiceServer).ListNamespaceStats(ctx, req.(*empty.Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _SubscriptionService_ListSharedGroupStats_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListSharedGroupStatsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SubscriptionServiceServer).ListSharedGroupStats(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/gmqtt.admin.api.SubscriptionService/ListSharedGroupStats",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SubscriptionServiceServer).ListSharedGroupStats(ctx, req.(*ListSharedGroupStatsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _SubscriptionService_ListSubscriptionIDStats_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListSubscriptionIDStatsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SubscriptionServiceServer).ListSubscriptionIDStats(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/gmqtt.admin.api.SubscriptionService/ListSubscriptionIDStats",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SubscriptionServiceServer).ListSubscriptionIDStats(ctx, req.(*ListSubscriptionIDStatsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 var _SubscriptionService_serviceDesc = grpc.ServiceDesc{
 	ServiceName: "gmqtt.admin.api.SubscriptionService",
 	HandlerType: (*SubscriptionServiceServer)(nil),
@@ -208,6 +354,22 @@ var _SubscriptionService_serviceDesc = grpc.ServiceDesc{
 			MethodName: "Unsubscribe",
 			Handler:    _SubscriptionService_Unsubscribe_Handler,
 		},
+		{
+			MethodName: "ListTopicFilterStats",
+			Handler:    _SubscriptionService_ListTopicFilterStats_Handler,
+		},
+		{
+			MethodName: "ListNamespaceStats",
+			Handler:    _SubscriptionService_ListNamespaceStats_Handler,
+		},
+		{
+			MethodName: "ListSharedGroupStats",
+			Handler:    _SubscriptionService_ListSharedGroupStats_Handler,
+		},
+		{
+			MethodName: "ListSubscriptionIDStats",
+			Handler:    _SubscriptionService_ListSubscriptionIDStats_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "subscription.proto",
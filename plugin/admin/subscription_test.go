@@ -5,6 +5,7 @@ import (
 	"testing"
 
 	"github.com/golang/mock/gomock"
+	"github.com/golang/protobuf/ptypes/empty"
 	"github.com/stretchr/testify/assert"
 
 	"github.com/DrmagicE/gmqtt"
@@ -388,3 +389,118 @@ func TestSubscriptionService_Unsubscribe_InvalidArgument(t *testing.T) {
 	}
 
 }
+
+func TestSubscriptionService_TopicStats(t *testing.T) {
+	a := assert.New(t)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	sr := server.NewMockStatsReader(ctrl)
+	sub := &subscriptionService{
+		a: &Admin{statsReader: sr},
+	}
+	sr.EXPECT().GetTopicStats().Return(server.TopicStats{
+		TopicFilters: []server.TopicFilterStats{
+			{TopicFilter: "a/+", Namespace: "a", Subscribers: 3, Matched: 10},
+			{TopicFilter: "a/b", Namespace: "a", Subscribers: 2},
+			{TopicFilter: "b", Namespace: "b", Subscribers: 1},
+		},
+		Namespaces: []server.NamespaceStats{
+			{Namespace: "a", TopicFilters: 2, Subscribers: 5, Matched: 10},
+			{Namespace: "b", TopicFilters: 1, Subscribers: 1},
+		},
+	}).Times(4)
+
+	resp, err := sub.ListTopicFilterStats(context.Background(), &ListTopicFilterStatsRequest{PageSize: 2, Page: 2})
+	a.NoError(err)
+	a.EqualValues(3, resp.TotalCount)
+	a.Len(resp.TopicFilters, 1)
+	a.Equal("b", resp.TopicFilters[0].TopicFilter)
+
+	resp, err = sub.ListTopicFilterStats(context.Background(), &ListTopicFilterStatsRequest{Namespace: "a", Orphaned: true})
+	a.NoError(err)
+	a.EqualValues(1, resp.TotalCount)
+	a.Equal("a/b", resp.TopicFilters[0].TopicFilter)
+
+	resp, err = sub.ListTopicFilterStats(context.Background(), &ListTopicFilterStatsRequest{Page: 10})
+	a.NoError(err)
+	a.EqualValues(3, resp.TotalCount)
+	a.Empty(resp.TopicFilters)
+
+	ns, err := sub.ListNamespaceStats(context.Background(), &empty.Empty{})
+	a.NoError(err)
+	a.Len(ns.Namespaces, 2)
+	a.EqualValues(5, ns.Namespaces[0].Subscribers)
+	a.EqualValues(10, ns.Namespaces[0].Matched)
+}
+
+func TestSubscriptionService_ListSharedGroupStats(t *testing.T) {
+	a := assert.New(t)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	sr := server.NewMockStatsReader(ctrl)
+	sub := &subscriptionService{
+		a: &Admin{statsReader: sr},
+	}
+	sr.EXPECT().GetSharedStats().Return([]server.SharedGroupStats{
+		{
+			TopicFilter: "$share/g1/a",
+			ShareName:   "g1",
+			Filter:      "a",
+			Members: []server.SharedMemberStats{
+				{ClientID: "c1", Delivered: 8, Redelivered: 1},
+				{ClientID: "c2", Delivered: 2},
+			},
+			Delivered:   10,
+			Redelivered: 1,
+		},
+		{
+			TopicFilter: "$share/g2/a",
+			ShareName:   "g2",
+			Filter:      "a",
+			Members: []server.SharedMemberStats{
+				{ClientID: "c1"},
+			},
+		},
+	}).Times(2)
+
+	resp, err := sub.ListSharedGroupStats(context.Background(), &ListSharedGroupStatsRequest{})
+	a.NoError(err)
+	a.Len(resp.Groups, 2)
+	a.Equal("$share/g1/a", resp.Groups[0].TopicFilter)
+	a.EqualValues(10, resp.Groups[0].Delivered)
+	a.Len(resp.Groups[0].Members, 2)
+	a.Equal("c1", resp.Groups[0].Members[0].ClientId)
+	a.EqualValues(8, resp.Groups[0].Members[0].Delivered)
+	a.EqualValues(1, resp.Groups[0].Members[0].Redelivered)
+
+	resp, err = sub.ListSharedGroupStats(context.Background(), &ListSharedGroupStatsRequest{ShareName: "g2"})
+	a.NoError(err)
+	a.Len(resp.Groups, 1)
+	a.Equal("g2", resp.Groups[0].ShareName)
+}
+
+func TestSubscriptionService_ListSubscriptionIDStats(t *testing.T) {
+	a := assert.New(t)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	sr := server.NewMockStatsReader(ctrl)
+	sub := &subscriptionService{
+		a: &Admin{statsReader: sr},
+	}
+	sr.EXPECT().GetSubscriptionIDStats("c1").Return([]server.SubscriptionIDStats{
+		{ID: 1, TopicFilters: []string{"a/+", "b"}, Delivered: 10, DeliveredBytes: 100},
+		{ID: 2, TopicFilters: []string{"c"}},
+	})
+
+	resp, err := sub.ListSubscriptionIDStats(context.Background(), &ListSubscriptionIDStatsRequest{ClientId: "c1"})
+	a.NoError(err)
+	a.Len(resp.Stats, 2)
+	a.EqualValues(1, resp.Stats[0].Id)
+	a.Equal([]string{"a/+", "b"}, resp.Stats[0].TopicFilters)
+	a.EqualValues(10, resp.Stats[0].Delivered)
+	a.EqualValues(100, resp.Stats[0].DeliveredBytes)
+	a.EqualValues(2, resp.Stats[1].Id)
+
+	_, err = sub.ListSubscriptionIDStats(context.Background(), &ListSubscriptionIDStatsRequest{})
+	a.Error(err)
+}
@@ -0,0 +1,332 @@
+package admin
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/ptypes/empty"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"gopkg.in/yaml.v2"
+)
+
+// ErrUnauthenticated is returned when the request does not carry a valid token.
+var ErrUnauthenticated = status.Error(codes.Unauthenticated, "invalid or missing token")
+
+// tokenEntry is the persisted form of a token, only the digest of the secret is stored.
+type tokenEntry struct {
+	ID          string     `yaml:"id"`
+	Description string     `yaml:"description"`
+	Digest      string     `yaml:"digest"`
+	CreatedAt   time.Time  `yaml:"created_at"`
+	ExpireAt    *time.Time `yaml:"expire_at,omitempty"`
+}
+
+func (t *tokenEntry) expired(now time.Time) bool {
+	return t.ExpireAt != nil && !now.Before(*t.ExpireAt)
+}
+
+func (t *tokenEntry) proto() *Token {
+	token := &Token{
+		Id:          t.ID,
+		Description: t.Description,
+		CreatedAt:   timestamppb.New(t.CreatedAt),
+	}
+	if t.ExpireAt != nil {
+		token.ExpireAt = timestamppb.New(*t.ExpireAt)
+	}
+	return token
+}
+
+func digest(secret string) string {
+	d := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(d[:])
+}
+
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// TokenStore manages the API tokens which are persisted in the token file.
+// The access token is in the format of "<id>.<secret>".
+// Multiple tokens can be valid at the same time, which allows the tokens to be rotated without downtime.
+// The token file is reloaded when it is modified by other processes, e.g. the "gmqttd token" command.
+type TokenStore struct {
+	file string
+	mu   sync.Mutex
+	// modTime and size are the modification time and size of the token file when it is loaded.
+	modTime time.Time
+	size    int64
+	tokens  map[string]*tokenEntry
+}
+
+// OpenTokenStore loads the tokens from the token file, the file will be created if not exists.
+func OpenTokenStore(file string) (*TokenStore, error) {
+	s := &TokenStore{
+		file:   file,
+		tokens: make(map[string]*tokenEntry),
+	}
+	f, err := os.OpenFile(file, os.O_CREATE|os.O_RDONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	f.Close()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s, s.reloadLocked()
+}
+
+// reloadLocked reloads the token file if it has been modified.
+func (s *TokenStore) reloadLocked() error {
+	fi, err := os.Stat(s.file)
+	if err != nil {
+		return err
+	}
+	if fi.ModTime().Equal(s.modTime) && fi.Size() == s.size {
+		return nil
+	}
+	b, err := ioutil.ReadFile(s.file)
+	if err != nil {
+		return err
+	}
+	var entries []*tokenEntry
+	if err = yaml.Unmarshal(b, &entries); err != nil {
+		return err
+	}
+	tokens := make(map[string]*tokenEntry)
+	for _, v := range entries {
+		tokens[v.ID] = v
+	}
+	s.tokens = tokens
+	s.modTime = fi.ModTime()
+	s.size = fi.Size()
+	return nil
+}
+
+// saveLocked persists the tokens to the token file.
+func (s *TokenStore) saveLocked() error {
+	entries := s.sortedLocked()
+	b, err := yaml.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	tmpfile, err := ioutil.TempFile(filepath.Dir(s.file), "gmqtt_admin_tokens")
+	if err != nil {
+		return err
+	}
+	_, err = tmpfile.Write(b)
+	tmpfile.Close()
+	if err != nil {
+		os.Remove(tmpfile.Name())
+		return err
+	}
+	if err = os.Rename(tmpfile.Name(), s.file); err != nil {
+		return err
+	}
+	if fi, err := os.Stat(s.file); err == nil {
+		s.modTime = fi.ModTime()
+		s.size = fi.Size()
+	}
+	return nil
+}
+
+func (s *TokenStore) sortedLocked() []*tokenEntry {
+	entries := make([]*tokenEntry, 0, len(s.tokens))
+	for _, v := range s.tokens {
+		entries = append(entries, v)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].CreatedAt.Equal(entries[j].CreatedAt) {
+			return entries[i].ID < entries[j].ID
+		}
+		return entries[i].CreatedAt.Before(entries[j].CreatedAt)
+	})
+	return entries
+}
+
+// Create creates a new token that expires after ttl, 0 means never expire.
+// The expired tokens are removed from the token file.
+// It returns the created token and the access token which is used in the Authorization header.
+func (s *TokenStore) Create(description string, ttl time.Duration) (token *Token, accessToken string, err error) {
+	id, err := randomString(9)
+	if err != nil {
+		return nil, "", err
+	}
+	secret, err := randomString(32)
+	if err != nil {
+		return nil, "", err
+	}
+	now := time.Now()
+	entry := &tokenEntry{
+		ID:          id,
+		Description: description,
+		Digest:      digest(secret),
+		CreatedAt:   now.UTC().Truncate(time.Second),
+	}
+	if ttl != 0 {
+		expireAt := entry.CreatedAt.Add(ttl)
+		entry.ExpireAt = &expireAt
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err = s.reloadLocked(); err != nil {
+		return nil, "", err
+	}
+	for k, v := range s.tokens {
+		if v.expired(now) {
+			delete(s.tokens, k)
+		}
+	}
+	s.tokens[id] = entry
+	if err = s.saveLocked(); err != nil {
+		delete(s.tokens, id)
+		return nil, "", err
+	}
+	return entry.proto(), id + "." + secret, nil
+}
+
+// Revoke revokes the token for the given id.
+// Return ErrNotFound if the token not found.
+func (s *TokenStore) Revoke(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.reloadLocked(); err != nil {
+		return err
+	}
+	entry, ok := s.tokens[id]
+	if !ok {
+		return ErrNotFound
+	}
+	delete(s.tokens, id)
+	if err := s.saveLocked(); err != nil {
+		s.tokens[id] = entry
+		return err
+	}
+	return nil
+}
+
+// List returns all tokens in creation order, including the expired ones.
+func (s *TokenStore) List() ([]*Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.reloadLocked(); err != nil {
+		return nil, err
+	}
+	var tokens []*Token
+	for _, v := range s.sortedLocked() {
+		tokens = append(tokens, v.proto())
+	}
+	return tokens, nil
+}
+
+// Verify reports whether the access token is valid.
+func (s *TokenStore) Verify(accessToken string) bool {
+	parts := strings.SplitN(accessToken, ".", 2)
+	if len(parts) != 2 {
+		return false
+	}
+	s.mu.Lock()
+	err := s.reloadLocked()
+	entry := s.tokens[parts[0]]
+	s.mu.Unlock()
+	if err != nil {
+		log.Error("failed to reload token file", zap.Error(err))
+	}
+	if entry == nil || entry.expired(time.Now()) {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(entry.Digest), []byte(digest(parts[1]))) == 1
+}
+
+// UnaryInterceptor rejects the requests without a valid token with Unauthenticated error.
+func (s *TokenStore) UnaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, v := range md.Get("authorization") {
+		if len(v) > 7 && strings.EqualFold(v[:7], "bearer ") && s.Verify(v[7:]) {
+			return handler(ctx, req)
+		}
+	}
+	return nil, ErrUnauthenticated
+}
+
+type tokenService struct {
+	a *Admin
+}
+
+func (t *tokenService) mustEmbedUnimplementedTokenServiceServer() {
+	return
+}
+
+// List lists all tokens.
+func (t *tokenService) List(ctx context.Context, req *ListTokensRequest) (*ListTokensResponse, error) {
+	tokens, err := t.a.tokens.List()
+	if err != nil {
+		return nil, err
+	}
+	page, pageSize := GetPage(req.Page, req.PageSize)
+	offset, n := GetOffsetN(page, pageSize)
+	resp := &ListTokensResponse{
+		Tokens:     []*Token{},
+		TotalCount: uint32(len(tokens)),
+	}
+	if offset < uint(len(tokens)) {
+		end := offset + n
+		if end > uint(len(tokens)) {
+			end = uint(len(tokens))
+		}
+		resp.Tokens = tokens[offset:end]
+	}
+	return resp, nil
+}
+
+// Create creates a new token.
+func (t *tokenService) Create(ctx context.Context, req *CreateTokenRequest) (*CreateTokenResponse, error) {
+	cfg := t.a.config.TokenAuth
+	ttl := time.Duration(req.Ttl) * time.Second
+	if ttl == 0 {
+		ttl = cfg.DefaultTTL
+	}
+	if cfg.MaxTTL != 0 && ttl > cfg.MaxTTL {
+		return nil, ErrInvalidArgument("ttl", "exceeds the max_ttl")
+	}
+	token, accessToken, err := t.a.tokens.Create(req.Description, ttl)
+	if err != nil {
+		return nil, err
+	}
+	log.Info("admin token created", zap.String("id", token.Id), zap.String("description", token.Description))
+	return &CreateTokenResponse{
+		Token:       token,
+		AccessToken: accessToken,
+	}, nil
+}
+
+// Revoke revokes the token for given id.
+func (t *tokenService) Revoke(ctx context.Context, req *RevokeTokenRequest) (*empty.Empty, error) {
+	if req.Id == "" {
+		return nil, ErrInvalidArgument("id", "")
+	}
+	if err := t.a.tokens.Revoke(req.Id); err != nil {
+		return nil, err
+	}
+	log.Info("admin token revoked", zap.String("id", req.Id))
+	return &empty.Empty{}, nil
+}
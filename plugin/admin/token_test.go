@@ -0,0 +1,162 @@
+package admin
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+func newTestTokenStore(t *testing.T) (*TokenStore, string) {
+	log = zap.NewNop()
+	dir, err := ioutil.TempDir("", "gmqtt_admin_tokens")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		os.RemoveAll(dir)
+	})
+	file := path.Join(dir, "tokens.yml")
+	s, err := OpenTokenStore(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, file
+}
+
+func TestTokenStore(t *testing.T) {
+	a := assert.New(t)
+	s, file := newTestTokenStore(t)
+
+	t1, at1, err := s.Create("t1", 0)
+	a.NoError(err)
+	a.Nil(t1.ExpireAt)
+	t2, at2, err := s.Create("t2", time.Hour)
+	a.NoError(err)
+	a.NotNil(t2.ExpireAt)
+
+	// multiple tokens are valid at the same time
+	a.True(s.Verify(at1))
+	a.True(s.Verify(at2))
+	a.False(s.Verify(t1.Id + ".invalid"))
+	a.False(s.Verify("invalid"))
+
+	tokens, err := s.List()
+	a.NoError(err)
+	a.Len(tokens, 2)
+
+	// the token file does not contain the secret
+	b, err := ioutil.ReadFile(file)
+	a.NoError(err)
+	a.NotContains(string(b), at1[len(t1.Id)+1:])
+
+	// tokens are loaded from file
+	s2, err := OpenTokenStore(file)
+	a.NoError(err)
+	a.True(s2.Verify(at1))
+
+	// revoke is visible to the other store after reload
+	a.NoError(s2.Revoke(t1.Id))
+	a.Equal(ErrNotFound, s2.Revoke(t1.Id))
+	a.False(s.Verify(at1))
+	a.True(s.Verify(at2))
+}
+
+func TestTokenStore_expired(t *testing.T) {
+	a := assert.New(t)
+	s, _ := newTestTokenStore(t)
+	t1, at1, err := s.Create("t1", time.Hour)
+	a.NoError(err)
+	expired := time.Now().Add(-time.Second)
+	s.tokens[t1.Id].ExpireAt = &expired
+	a.False(s.Verify(at1))
+
+	// expired tokens are removed when creating new token
+	_, _, err = s.Create("t2", time.Hour)
+	a.NoError(err)
+	tokens, err := s.List()
+	a.NoError(err)
+	a.Len(tokens, 1)
+	a.Equal("t2", tokens[0].Description)
+}
+
+func TestTokenStore_UnaryInterceptor(t *testing.T) {
+	a := assert.New(t)
+	s, _ := newTestTokenStore(t)
+	_, at, err := s.Create("t1", time.Hour)
+	a.NoError(err)
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return "ok", nil
+	}
+	var tt = []struct {
+		md  metadata.MD
+		err error
+	}{
+		{md: nil, err: ErrUnauthenticated},
+		{md: metadata.Pairs("authorization", at), err: ErrUnauthenticated},
+		{md: metadata.Pairs("authorization", "Bearer invalid"), err: ErrUnauthenticated},
+		{md: metadata.Pairs("authorization", "Bearer "+at), err: nil},
+		{md: metadata.Pairs("authorization", "bearer "+at), err: nil},
+	}
+	for _, v := range tt {
+		ctx := metadata.NewIncomingContext(context.Background(), v.md)
+		rs, err := s.UnaryInterceptor(ctx, nil, &grpc.UnaryServerInfo{}, handler)
+		a.Equal(v.err, err)
+		if err == nil {
+			a.Equal("ok", rs)
+		}
+	}
+}
+
+func TestTokenService(t *testing.T) {
+	a := assert.New(t)
+	s, _ := newTestTokenStore(t)
+	ts := &tokenService{
+		a: &Admin{
+			config: &Config{
+				TokenAuth: TokenAuthConfig{
+					Enable:     true,
+					DefaultTTL: time.Hour,
+					MaxTTL:     2 * time.Hour,
+				},
+			},
+			tokens: s,
+		},
+	}
+	_, err := ts.Create(context.Background(), &CreateTokenRequest{Ttl: uint32((3 * time.Hour).Seconds())})
+	a.Error(err)
+
+	resp, err := ts.Create(context.Background(), &CreateTokenRequest{Description: "ci"})
+	a.NoError(err)
+	a.True(s.Verify(resp.AccessToken))
+	a.Equal(resp.Token.CreatedAt.AsTime().Add(time.Hour), resp.Token.ExpireAt.AsTime())
+
+	_, err = ts.Create(context.Background(), &CreateTokenRequest{Description: "ci2"})
+	a.NoError(err)
+
+	list, err := ts.List(context.Background(), &ListTokensRequest{PageSize: 1, Page: 2})
+	a.NoError(err)
+	a.EqualValues(2, list.TotalCount)
+	a.Len(list.Tokens, 1)
+
+	_, err = ts.Revoke(context.Background(), &RevokeTokenRequest{Id: resp.Token.Id})
+	a.NoError(err)
+	a.False(s.Verify(resp.AccessToken))
+	_, err = ts.Revoke(context.Background(), &RevokeTokenRequest{Id: resp.Token.Id})
+	a.Equal(ErrNotFound, err)
+}
+
+func TestConfig_Validate(t *testing.T) {
+	a := assert.New(t)
+	a.NoError(DefaultConfig.Validate())
+	a.Error((&Config{TokenAuth: TokenAuthConfig{Enable: true}}).Validate())
+	a.Error((&Config{TokenAuth: TokenAuthConfig{MaxTTL: time.Hour, DefaultTTL: 2 * time.Hour}}).Validate())
+	a.Error((&Config{TokenAuth: TokenAuthConfig{MaxTTL: time.Hour}}).Validate())
+}
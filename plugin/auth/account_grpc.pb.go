@@ -26,6 +26,9 @@ type AccountServiceClient interface {
 	// Update the password for the account.
 	// This API will create the account if not exists.
 	Update(ctx context.Context, in *UpdateAccountRequest, opts ...grpc.CallOption) (*empty.Empty, error)
+	// Set the access windows for the account, empty means no restriction.
+	// Return NotFound error when account not found.
+	SetSchedule(ctx context.Context, in *SetScheduleRequest, opts ...grpc.CallOption) (*empty.Empty, error)
 	// Delete the account for given username
 	Delete(ctx context.Context, in *DeleteAccountRequest, opts ...grpc.CallOption) (*empty.Empty, error)
 }
@@ -65,6 +68,15 @@ func (c *accountServiceClient) Update(ctx context.Context, in *UpdateAccountRequ
 	return out, nil
 }
 
+func (c *accountServiceClient) SetSchedule(ctx context.Context, in *SetScheduleRequest, opts ...grpc.CallOption) (*empty.Empty, error) {
+	out := new(empty.Empty)
+	err := c.cc.Invoke(ctx, "/gmqtt.auth.api.AccountService/SetSchedule", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func (c *accountServiceClient) Delete(ctx context.Context, in *DeleteAccountRequest, opts ...grpc.CallOption) (*empty.Empty, error) {
 	out := new(empty.Empty)
 	err := c.cc.Invoke(ctx, "/gmqtt.auth.api.AccountService/Delete", in, out, opts...)
@@ -86,6 +98,9 @@ type AccountServiceServer interface {
 	// Update the password for the account.
 	// This API will create the account if not exists.
 	Update(context.Context, *UpdateAccountRequest) (*empty.Empty, error)
+	// Set the access windows for the account, empty means no restriction.
+	// Return NotFound error when account not found.
+	SetSchedule(context.Context, *SetScheduleRequest) (*empty.Empty, error)
 	// Delete the account for given username
 	Delete(context.Context, *DeleteAccountRequest) (*empty.Empty, error)
 	mustEmbedUnimplementedAccountServiceServer()
@@ -104,6 +119,9 @@ func (UnimplementedAccountServiceServer) Get(context.Context, *GetAccountRequest
 func (UnimplementedAccountServiceServer) Update(context.Context, *UpdateAccountRequest) (*empty.Empty, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method Update not implemented")
 }
+func (UnimplementedAccountServiceServer) SetSchedule(context.Context, *SetScheduleRequest) (*empty.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SetSchedule not implemented")
+}
 func (UnimplementedAccountServiceServer) Delete(context.Context, *DeleteAccountRequest) (*empty.Empty, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method Delete not implemented")
 }
@@ -174,6 +192,24 @@ func _AccountService_Update_Handler(srv interface{}, ctx context.Context, dec fu
 	return interceptor(ctx, in, info, handler)
 }
 
+func _AccountService_SetSchedule_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SetScheduleRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AccountServiceServer).SetSchedule(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/gmqtt.auth.api.AccountService/SetSchedule",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AccountServiceServer).SetSchedule(ctx, req.(*SetScheduleRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 func _AccountService_Delete_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
 	in := new(DeleteAccountRequest)
 	if err := dec(in); err != nil {
@@ -208,6 +244,10 @@ var _AccountService_serviceDesc = grpc.ServiceDesc{
 			MethodName: "Update",
 			Handler:    _AccountService_Update_Handler,
 		},
+		{
+			MethodName: "SetSchedule",
+			Handler:    _AccountService_SetSchedule_Handler,
+		},
 		{
 			MethodName: "Delete",
 			Handler:    _AccountService_Delete_Handler,
@@ -1,19 +1,15 @@
 package auth
 
 import (
-	"crypto/md5"
-	"crypto/sha256"
-	"encoding/hex"
 	"errors"
 	"fmt"
-	"hash"
 	"io/ioutil"
 	"os"
 	"path"
 	"sync"
+	"time"
 
 	"go.uber.org/zap"
-	"golang.org/x/crypto/bcrypt"
 	"gopkg.in/yaml.v2"
 
 	"github.com/DrmagicE/gmqtt/config"
@@ -35,6 +31,11 @@ func New(config config.Config) (server.Plugin, error) {
 		config:  config.Plugins[Name].(*Config),
 		indexer: admin.NewIndexer(),
 		pwdDir:  config.ConfigDir,
+		fips:    config.Crypto.FIPS(),
+		now:     time.Now,
+	}
+	if a.fips && !fipsHashTypes[a.config.Hash] {
+		return nil, fmt.Errorf("hash type %s is not allowed by the fips crypto policy", a.config.Hash)
 	}
 	a.saveFile = a.saveFileHandler
 	return a, nil
@@ -47,37 +48,22 @@ var log *zap.Logger
 type Auth struct {
 	config *Config
 	pwdDir string
+	// fips indicates whether the fips crypto policy is in use,
+	// the passwords stored in the hash types which are not FIPS-approved are rejected.
+	fips bool
 	// gard indexer
 	mu sync.RWMutex
 	// store username/password
 	indexer *admin.Indexer
 	// saveFile persists the account data to password file.
 	saveFile func() error
+	// now returns the current time, which is checked against the schedule of the accounts.
+	now func() time.Time
 }
 
 // generatePassword generates the hashed password for the plain password.
 func (a *Auth) generatePassword(password string) (hashedPassword string, err error) {
-	var h hash.Hash
-	switch a.config.Hash {
-	case Plain:
-		return password, nil
-	case MD5:
-		h = md5.New()
-	case SHA256:
-		h = sha256.New()
-	case Bcrypt:
-		pwd, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
-		return string(pwd), err
-	default:
-		// just in case.
-		panic("invalid hash type")
-	}
-	_, err = h.Write([]byte(password))
-	if err != nil {
-		return "", err
-	}
-	rs := h.Sum(nil)
-	return hex.EncodeToString(rs), nil
+	return GeneratePassword(a.config.Hash, password)
 }
 
 func (a *Auth) mustEmbedUnimplementedAccountServiceServer() {
@@ -88,32 +74,40 @@ func (a *Auth) validate(username, password string) (permitted bool, err error) {
 	a.mu.RLock()
 	elem := a.indexer.GetByID(username)
 	a.mu.RUnlock()
-	var hashedPassword string
 	if elem == nil {
 		return false, nil
 	}
 	ac := elem.Value.(*Account)
-	hashedPassword = ac.Password
-	var h hash.Hash
-	switch a.config.Hash {
-	case Plain:
-		return hashedPassword == password, nil
-	case MD5:
-		h = md5.New()
-	case SHA256:
-		h = sha256.New()
-	case Bcrypt:
-		return bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password)) == nil, nil
-	default:
-		// just in case.
-		panic("invalid hash type")
+	if a.fips {
+		if hash := detectHash(a.config.Hash, ac.Password); !fipsHashTypes[hash] {
+			log.Warn("password hash type is not allowed by the fips crypto policy",
+				zap.String("username", username),
+				zap.String("hash", hash))
+			return false, nil
+		}
 	}
-	_, err = h.Write([]byte(password))
-	if err != nil {
-		return false, err
+	permitted, err = comparePassword(a.config.Hash, ac.Password, password)
+	if !permitted || err != nil {
+		return permitted, err
+	}
+	if len(ac.Schedule) != 0 && !inSchedule(ac.Schedule, a.now()) {
+		log.Debug("connection rejected outside the access windows", zap.String("username", username))
+		return false, nil
 	}
-	rs := h.Sum(nil)
-	return hashedPassword == hex.EncodeToString(rs), nil
+	return true, nil
+}
+
+// permittedNow returns whether the account of the username is permitted at the current time.
+// It returns true if the account does not exist.
+func (a *Auth) permittedNow(username string) bool {
+	a.mu.RLock()
+	elem := a.indexer.GetByID(username)
+	a.mu.RUnlock()
+	if elem == nil {
+		return true
+	}
+	schedule := elem.Value.(*Account).Schedule
+	return len(schedule) == 0 || inSchedule(schedule, a.now())
 }
 
 var registerAPI = func(service server.Server, a *Auth) error {
@@ -161,6 +155,9 @@ func (a *Auth) Load(service server.Server) error {
 			return fmt.Errorf("detect duplicated username in password file: %s", v.Username)
 		}
 		dup[v.Username] = struct{}{}
+		if err := validateSchedule(v.Schedule); err != nil {
+			return fmt.Errorf("invalid schedule of %s in password file: %s", v.Username, err)
+		}
 	}
 	a.mu.Lock()
 	defer a.mu.Unlock()
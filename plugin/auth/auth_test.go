@@ -6,6 +6,7 @@ import (
 
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
 
 	"github.com/DrmagicE/gmqtt/config"
 	"github.com/DrmagicE/gmqtt/plugin/admin"
@@ -39,6 +40,18 @@ func TestAuth_validate(t *testing.T) {
 			name:     Bcrypt,
 			username: "user",
 			password: "道路千万条，安全第一条，密码不规范，绩效两行泪",
+		}, {
+			name:     Argon2id,
+			username: "user",
+			password: "道路千万条，安全第一条，密码不规范，绩效两行泪",
+		}, {
+			name:     PBKDF2,
+			username: "user",
+			password: "道路千万条，安全第一条，密码不规范，绩效两行泪",
+		}, {
+			name:     SSHA256,
+			username: "user",
+			password: "道路千万条，安全第一条，密码不规范，绩效两行泪",
 		},
 	}
 	for _, v := range tt {
@@ -89,6 +102,39 @@ func TestAuth_EmptyPassword(t *testing.T) {
 	a.Nil(err)
 }
 
+func TestAuth_fips(t *testing.T) {
+	a := assert.New(t)
+	log = zap.NewNop()
+	cfg := config.DefaultConfig()
+	cfg.Crypto.Policy = config.CryptoPolicyFIPS
+	cfg.Plugins[Name] = &Config{Hash: MD5}
+	_, err := New(cfg)
+	a.Error(err)
+
+	cfg.Plugins[Name] = &Config{Hash: PBKDF2}
+	p, err := New(cfg)
+	a.NoError(err)
+	auth := p.(*Auth)
+
+	for _, v := range []string{Bcrypt, MD5, PBKDF2} {
+		hashed, err := GeneratePassword(v, "password")
+		a.NoError(err)
+		auth.indexer.Set(v, &Account{
+			Username: v,
+			Password: hashed,
+		})
+	}
+	ok, err := auth.validate(Bcrypt, "password")
+	a.False(ok)
+	a.NoError(err)
+	// the md5 password has no prefix, it is verified as the configured hash type.
+	ok, _ = auth.validate(MD5, "password")
+	a.False(ok)
+	ok, err = auth.validate(PBKDF2, "password")
+	a.True(ok)
+	a.NoError(err)
+}
+
 func TestAuth_Load_CreateFile(t *testing.T) {
 	a := assert.New(t)
 	ctrl := gomock.NewController(t)
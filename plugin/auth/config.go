@@ -8,22 +8,27 @@ import (
 type hashType = string
 
 const (
-	Plain  hashType = "plain"
-	MD5             = "md5"
-	SHA256          = "sha256"
-	Bcrypt          = "bcrypt"
+	Plain    hashType = "plain"
+	MD5               = "md5"
+	SHA256            = "sha256"
+	Bcrypt            = "bcrypt"
+	Argon2id          = "argon2id"
+	PBKDF2            = "pbkdf2"
+	SSHA256           = "ssha256"
 )
 
 var ValidateHashType = []string{
-	Plain, MD5, SHA256, Bcrypt,
+	Plain, MD5, SHA256, Bcrypt, Argon2id, PBKDF2, SSHA256,
 }
 
 // Config is the configuration for the auth plugin.
 type Config struct {
 	// PasswordFile is the file to store username and password.
 	PasswordFile string `yaml:"password_file"`
-	// Hash is the password hash algorithm.
-	// Possible values: plain | md5 | sha256 | bcrypt
+	// Hash is the password hash algorithm which is used to generate new passwords.
+	// Possible values: plain | md5 | sha256 | bcrypt | argon2id | pbkdf2 | ssha256, or the name of a registered Hasher.
+	// When validating, the algorithm is detected by the format prefix of the stored password,
+	// passwords without a known prefix are verified by this algorithm.
 	Hash string `yaml:"hash"`
 }
 
@@ -32,10 +37,8 @@ func (c *Config) Validate() error {
 	if c.PasswordFile == "" {
 		return errors.New("password_file must be set")
 	}
-	for _, v := range ValidateHashType {
-		if v == c.Hash {
-			return nil
-		}
+	if _, ok := hashers[c.Hash]; ok {
+		return nil
 	}
 	return fmt.Errorf("invalid hash type: %s", c.Hash)
 }
@@ -93,14 +93,17 @@ func (a *Auth) Update(ctx context.Context, req *UpdateAccountRequest) (resp *emp
 	a.mu.Lock()
 	defer a.mu.Unlock()
 	var oact *Account
+	act := &Account{
+		Username: req.Username,
+		Password: hashedPassword,
+	}
 	elem := a.indexer.GetByID(req.Username)
 	if elem != nil {
 		oact = elem.Value.(*Account)
+		// keep the access windows
+		act.Schedule = oact.Schedule
 	}
-	a.indexer.Set(req.Username, &Account{
-		Username: req.Username,
-		Password: hashedPassword,
-	})
+	a.indexer.Set(req.Username, act)
 	err = a.saveFile()
 	if err != nil {
 		// should rollback if failed to persist to file.
@@ -108,10 +111,7 @@ func (a *Auth) Update(ctx context.Context, req *UpdateAccountRequest) (resp *emp
 			a.indexer.Remove(req.Username)
 			return &empty.Empty{}, err
 		}
-		a.indexer.Set(req.Username, &Account{
-			Username: req.Username,
-			Password: oact.Password,
-		})
+		a.indexer.Set(req.Username, oact)
 	}
 	if oact == nil {
 		log.Info("new account created", zap.String("username", req.Username))
@@ -122,6 +122,38 @@ func (a *Auth) Update(ctx context.Context, req *UpdateAccountRequest) (resp *emp
 	return &empty.Empty{}, err
 }
 
+// SetSchedule sets the access windows for the account, empty means no restriction.
+// Return NotFound error when account not found.
+// SetSchedule will persist the account data to the password file.
+func (a *Auth) SetSchedule(ctx context.Context, req *SetScheduleRequest) (resp *empty.Empty, err error) {
+	if req.Username == "" {
+		return nil, admin.ErrInvalidArgument("username", "cannot be empty")
+	}
+	if err = validateSchedule(req.Schedule); err != nil {
+		return nil, admin.ErrInvalidArgument("schedule", err.Error())
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	elem := a.indexer.GetByID(req.Username)
+	if elem == nil {
+		return nil, admin.ErrNotFound
+	}
+	oact := elem.Value.(*Account)
+	a.indexer.Set(req.Username, &Account{
+		Username: req.Username,
+		Password: oact.Password,
+		Schedule: req.Schedule,
+	})
+	err = a.saveFile()
+	if err != nil {
+		// should rollback if failed to persist to file.
+		a.indexer.Set(req.Username, oact)
+		return &empty.Empty{}, err
+	}
+	log.Info("schedule updated", zap.String("username", req.Username), zap.Int("access_windows", len(req.Schedule)))
+	return &empty.Empty{}, nil
+}
+
 // Delete deletes the account for the username.
 func (a *Auth) Delete(ctx context.Context, req *DeleteAccountRequest) (resp *empty.Empty, err error) {
 	if req.Username == "" {
@@ -139,10 +171,7 @@ func (a *Auth) Delete(ctx context.Context, req *DeleteAccountRequest) (resp *emp
 	err = a.saveFile()
 	if err != nil {
 		// should rollback if failed to persist to file
-		a.indexer.Set(req.Username, &Account{
-			Username: req.Username,
-			Password: oact.(*Account).Password,
-		})
+		a.indexer.Set(req.Username, oact)
 		return &empty.Empty{}, err
 	}
 	log.Info("account deleted", zap.String("username", req.Username))
@@ -208,3 +208,68 @@ func TestAuth_saveFileHandler(t *testing.T) {
 	a.Equal("p11", rs[0].Password)
 
 }
+
+func TestAuth_SetSchedule(t *testing.T) {
+	a := assert.New(t)
+	path := "./testdata/gmqtt_password.yml"
+	cfg := DefaultConfig
+	cfg.PasswordFile = path
+	cfg.Hash = Plain
+	auth, err := New(config.Config{
+		Plugins: map[string]config.Configuration{
+			"auth": &cfg,
+		},
+	})
+	a.Nil(err)
+	err = auth.Load(nil)
+	a.Nil(err)
+	au := auth.(*Auth)
+	au.saveFile = func() error {
+		return nil
+	}
+	schedule := []*AccessWindow{{Start: "02:00", End: "04:00", Weekdays: []string{"sat"}}}
+	_, err = au.SetSchedule(context.Background(), &SetScheduleRequest{
+		Username: "u1",
+		Schedule: schedule,
+	})
+	a.Nil(err)
+	act := au.indexer.GetByID("u1").Value.(*Account)
+	a.Equal("p1", act.Password)
+	a.Equal(schedule, act.Schedule)
+
+	// the schedule is kept when the password is updated.
+	_, err = au.Update(context.Background(), &UpdateAccountRequest{
+		Username: "u1",
+		Password: "p2",
+	})
+	a.Nil(err)
+	act = au.indexer.GetByID("u1").Value.(*Account)
+	a.Equal("p2", act.Password)
+	a.Equal(schedule, act.Schedule)
+
+	_, err = au.SetSchedule(context.Background(), &SetScheduleRequest{
+		Username: "u1",
+		Schedule: []*AccessWindow{{Start: "02:00", End: "04:00", Weekdays: []string{"someday"}}},
+	})
+	s, ok := status.FromError(err)
+	a.True(ok)
+	a.Equal(codes.InvalidArgument, s.Code())
+
+	_, err = au.SetSchedule(context.Background(), &SetScheduleRequest{
+		Username: "u10",
+	})
+	s, ok = status.FromError(err)
+	a.True(ok)
+	a.Equal(codes.NotFound, s.Code())
+
+	// test rollback
+	au.saveFile = func() error {
+		return errors.New("some error")
+	}
+	_, err = au.SetSchedule(context.Background(), &SetScheduleRequest{
+		Username: "u1",
+	})
+	a.NotNil(err)
+	act = au.indexer.GetByID("u1").Value.(*Account)
+	a.Equal(schedule, act.Schedule)
+}
@@ -0,0 +1,296 @@
+package auth
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// Hasher generates and verifies the hashed passwords of a hash type.
+type Hasher interface {
+	// Generate returns the hashed password of the plain password.
+	Generate(password string) (string, error)
+	// Match reports whether the hashed password is in the format of the Hasher, which is detected by the format prefix.
+	// Hashed passwords without any known prefix are verified by the Hasher of the configured hash type.
+	Match(hashedPassword string) bool
+	// Compare reports whether the plain password matches the hashed password.
+	Compare(hashedPassword, password string) (bool, error)
+}
+
+var hashers = map[hashType]Hasher{
+	Plain:    &digestHasher{},
+	MD5:      &digestHasher{newHash: md5.New},
+	SHA256:   &digestHasher{newHash: sha256.New},
+	Bcrypt:   &bcryptHasher{},
+	Argon2id: &argon2idHasher{time: 1, memory: 64 * 1024, threads: 4, keyLen: 32},
+	PBKDF2:   &pbkdf2Hasher{iterations: 100000, keyLen: 32},
+	SSHA256:  &ssha256Hasher{},
+}
+
+// fipsHashTypes are the FIPS-approved hash types, which are the only hash types allowed by the fips crypto policy.
+var fipsHashTypes = map[hashType]bool{
+	SHA256:  true,
+	PBKDF2:  true,
+	SSHA256: true,
+}
+
+// RegisterHasher registers a Hasher with the hash type name, which can be used in the hash config.
+// It is not thread-safe and should be called in init function.
+func RegisterHasher(name string, hasher Hasher) {
+	if _, ok := hashers[name]; ok {
+		panic(fmt.Sprintf("duplicated hasher: %s", name))
+	}
+	hashers[name] = hasher
+}
+
+// GeneratePassword returns the hashed password of the plain password with the given hash type.
+func GeneratePassword(hash string, password string) (string, error) {
+	h, ok := hashers[hash]
+	if !ok {
+		return "", fmt.Errorf("invalid hash type: %s", hash)
+	}
+	return h.Generate(password)
+}
+
+// detectHash returns the hash type of the hashed password, which is detected by the format prefix.
+// If there is no known prefix, the defaultHash is returned.
+func detectHash(defaultHash string, hashedPassword string) string {
+	for name, h := range hashers {
+		if h.Match(hashedPassword) {
+			return name
+		}
+	}
+	return defaultHash
+}
+
+// comparePassword verifies the password, the hash type is detected by the format prefix of the hashed password.
+// If there is no known prefix, the defaultHash is used.
+func comparePassword(defaultHash string, hashedPassword, password string) (bool, error) {
+	name := detectHash(defaultHash, hashedPassword)
+	h, ok := hashers[name]
+	if !ok {
+		return false, fmt.Errorf("invalid hash type: %s", name)
+	}
+	return h.Compare(hashedPassword, password)
+}
+
+// ComparePassword verifies the password, it is the same as the verification of the auth plugin,
+// which can be used by other plugins to verify the password files in the same format.
+func ComparePassword(defaultHash string, hashedPassword, password string) (bool, error) {
+	return comparePassword(defaultHash, hashedPassword, password)
+}
+
+// HashRegistered reports whether the hash type is built-in or registered by RegisterHasher.
+func HashRegistered(hash string) bool {
+	_, ok := hashers[hash]
+	return ok
+}
+
+func constantTimeEqual(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+func randomSalt() ([]byte, error) {
+	salt := make([]byte, 16)
+	_, err := rand.Read(salt)
+	return salt, err
+}
+
+var b64 = base64.RawStdEncoding
+
+// digestHasher stores the password in hex encoded digest without salt, or in plain text if newHash is nil.
+// It is kept for compatibility, the hashed password has no format prefix.
+type digestHasher struct {
+	newHash func() hash.Hash
+}
+
+func (d *digestHasher) Generate(password string) (string, error) {
+	if d.newHash == nil {
+		return password, nil
+	}
+	h := d.newHash()
+	_, err := h.Write([]byte(password))
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func (d *digestHasher) Match(hashedPassword string) bool {
+	return false
+}
+
+func (d *digestHasher) Compare(hashedPassword, password string) (bool, error) {
+	rs, err := d.Generate(password)
+	if err != nil {
+		return false, err
+	}
+	return constantTimeEqual(hashedPassword, rs), nil
+}
+
+// bcryptHasher uses the standard bcrypt format: $2a$cost$saltAndHash
+type bcryptHasher struct{}
+
+func (bcryptHasher) Generate(password string) (string, error) {
+	pwd, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return string(pwd), err
+}
+
+func (bcryptHasher) Match(hashedPassword string) bool {
+	return strings.HasPrefix(hashedPassword, "$2a$") ||
+		strings.HasPrefix(hashedPassword, "$2b$") ||
+		strings.HasPrefix(hashedPassword, "$2y$")
+}
+
+func (bcryptHasher) Compare(hashedPassword, password string) (bool, error) {
+	return bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password)) == nil, nil
+}
+
+// argon2idHasher uses the PHC string format: $argon2id$v=19$m=65536,t=1,p=4$salt$hash
+type argon2idHasher struct {
+	time    uint32
+	memory  uint32
+	threads uint8
+	keyLen  uint32
+}
+
+const argon2idPrefix = "$argon2id$"
+
+func (a *argon2idHasher) Generate(password string) (string, error) {
+	salt, err := randomSalt()
+	if err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, a.time, a.memory, a.threads, a.keyLen)
+	return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s", argon2idPrefix, argon2.Version,
+		a.memory, a.time, a.threads, b64.EncodeToString(salt), b64.EncodeToString(key)), nil
+}
+
+func (a *argon2idHasher) Match(hashedPassword string) bool {
+	return strings.HasPrefix(hashedPassword, argon2idPrefix)
+}
+
+func (a *argon2idHasher) Compare(hashedPassword, password string) (bool, error) {
+	parts := strings.Split(hashedPassword, "$")
+	// ["", "argon2id", "v=19", "m=65536,t=1,p=4", salt, hash]
+	if len(parts) != 6 {
+		return false, errors.New("invalid argon2id hash format")
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return false, err
+	}
+	if version != argon2.Version {
+		return false, fmt.Errorf("unsupported argon2id version: %d", version)
+	}
+	var memory, time uint32
+	var threads uint8
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &time, &threads); err != nil {
+		return false, err
+	}
+	salt, err := b64.DecodeString(parts[4])
+	if err != nil {
+		return false, err
+	}
+	key, err := b64.DecodeString(parts[5])
+	if err != nil {
+		return false, err
+	}
+	rs := argon2.IDKey([]byte(password), salt, time, memory, threads, uint32(len(key)))
+	return subtle.ConstantTimeCompare(key, rs) == 1, nil
+}
+
+// pbkdf2Hasher uses the format: $pbkdf2-sha256$i=100000$salt$hash
+type pbkdf2Hasher struct {
+	iterations int
+	keyLen     int
+}
+
+const pbkdf2Prefix = "$pbkdf2-sha256$"
+
+func (p *pbkdf2Hasher) Generate(password string) (string, error) {
+	salt, err := randomSalt()
+	if err != nil {
+		return "", err
+	}
+	key := pbkdf2.Key([]byte(password), salt, p.iterations, p.keyLen, sha256.New)
+	return fmt.Sprintf("%si=%d$%s$%s", pbkdf2Prefix, p.iterations, b64.EncodeToString(salt), b64.EncodeToString(key)), nil
+}
+
+func (p *pbkdf2Hasher) Match(hashedPassword string) bool {
+	return strings.HasPrefix(hashedPassword, pbkdf2Prefix)
+}
+
+func (p *pbkdf2Hasher) Compare(hashedPassword, password string) (bool, error) {
+	parts := strings.Split(hashedPassword, "$")
+	// ["", "pbkdf2-sha256", "i=100000", salt, hash]
+	if len(parts) != 5 {
+		return false, errors.New("invalid pbkdf2 hash format")
+	}
+	var iterations int
+	if _, err := fmt.Sscanf(parts[2], "i=%d", &iterations); err != nil {
+		return false, err
+	}
+	salt, err := b64.DecodeString(parts[3])
+	if err != nil {
+		return false, err
+	}
+	key, err := b64.DecodeString(parts[4])
+	if err != nil {
+		return false, err
+	}
+	rs := pbkdf2.Key([]byte(password), salt, iterations, len(key), sha256.New)
+	return subtle.ConstantTimeCompare(key, rs) == 1, nil
+}
+
+// ssha256Hasher uses the salted sha256 format: $ssha256$salt$hash, where hash = sha256(password + salt).
+type ssha256Hasher struct{}
+
+const ssha256Prefix = "$ssha256$"
+
+func ssha256Sum(password string, salt []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte(password))
+	h.Write(salt)
+	return h.Sum(nil)
+}
+
+func (ssha256Hasher) Generate(password string) (string, error) {
+	salt, err := randomSalt()
+	if err != nil {
+		return "", err
+	}
+	return ssha256Prefix + b64.EncodeToString(salt) + "$" + b64.EncodeToString(ssha256Sum(password, salt)), nil
+}
+
+func (ssha256Hasher) Match(hashedPassword string) bool {
+	return strings.HasPrefix(hashedPassword, ssha256Prefix)
+}
+
+func (ssha256Hasher) Compare(hashedPassword, password string) (bool, error) {
+	parts := strings.Split(hashedPassword, "$")
+	// ["", "ssha256", salt, hash]
+	if len(parts) != 4 {
+		return false, errors.New("invalid ssha256 hash format")
+	}
+	salt, err := b64.DecodeString(parts[2])
+	if err != nil {
+		return false, err
+	}
+	key, err := b64.DecodeString(parts[3])
+	if err != nil {
+		return false, err
+	}
+	return subtle.ConstantTimeCompare(key, ssha256Sum(password, salt)) == 1, nil
+}
@@ -0,0 +1,68 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestComparePassword(t *testing.T) {
+	for _, hash := range ValidateHashType {
+		t.Run(hash, func(t *testing.T) {
+			a := assert.New(t)
+			hashed, err := GeneratePassword(hash, "password")
+			a.Nil(err)
+
+			// the default hash type is used only if there is no known prefix.
+			ok, err := comparePassword(MD5, hashed, "password")
+			if hash == Plain || hash == SHA256 {
+				a.False(ok)
+			} else {
+				a.True(ok)
+			}
+			a.Nil(err)
+
+			ok, err = comparePassword(hash, hashed, "password")
+			a.True(ok)
+			a.Nil(err)
+
+			ok, err = comparePassword(hash, hashed, "wrong password")
+			a.False(ok)
+			a.Nil(err)
+		})
+	}
+}
+
+func TestGeneratePassword_salted(t *testing.T) {
+	a := assert.New(t)
+	for _, hash := range []string{Argon2id, PBKDF2, SSHA256} {
+		h1, err := GeneratePassword(hash, "password")
+		a.Nil(err)
+		h2, err := GeneratePassword(hash, "password")
+		a.Nil(err)
+		a.NotEqual(h1, h2)
+	}
+	_, err := GeneratePassword("unknown", "password")
+	a.NotNil(err)
+}
+
+func TestGeneratePassword_bcryptCost(t *testing.T) {
+	a := assert.New(t)
+	hashed, err := GeneratePassword(Bcrypt, "password")
+	a.Nil(err)
+	a.True(strings.HasPrefix(hashed, "$2a$10$"))
+}
+
+func TestComparePassword_invalidFormat(t *testing.T) {
+	a := assert.New(t)
+	for _, v := range []string{
+		"$argon2id$v=19$m=65536,t=1,p=4$salt",
+		"$pbkdf2-sha256$i=abc$salt$hash",
+		"$ssha256$!!!$hash",
+	} {
+		ok, err := comparePassword(MD5, v, "password")
+		a.False(ok)
+		a.NotNil(err)
+	}
+}
@@ -12,7 +12,8 @@ import (
 
 func (a *Auth) HookWrapper() server.HookWrapper {
 	return server.HookWrapper{
-		OnBasicAuthWrapper: a.OnBasicAuthWrapper,
+		OnBasicAuthWrapper:  a.OnBasicAuthWrapper,
+		OnMsgArrivedWrapper: a.OnMsgArrivedWrapper,
 	}
 }
 
@@ -43,3 +44,25 @@ func (a *Auth) OnBasicAuthWrapper(pre server.OnBasicAuth) server.OnBasicAuth {
 		return nil
 	}
 }
+
+// OnMsgArrivedWrapper rejects the publishes of the accounts outside their access windows,
+// the clients connected within the window are not disconnected when it ends.
+// For v3 clients, the rejected publish is dropped silently.
+func (a *Auth) OnMsgArrivedWrapper(pre server.OnMsgArrived) server.OnMsgArrived {
+	return func(ctx context.Context, client server.Client, req *server.MsgArrivedRequest) error {
+		err := pre(ctx, client, req)
+		if err != nil {
+			return err
+		}
+		username := client.ClientOptions().Username
+		if a.permittedNow(username) {
+			return nil
+		}
+		log.Debug("publish rejected outside the access windows",
+			zap.String("username", username),
+			zap.String("client_id", client.ClientOptions().ClientID))
+		return &codes.Error{
+			Code: codes.NotAuthorized,
+		}
+	}
+}
@@ -3,11 +3,13 @@ package auth
 import (
 	"context"
 	"testing"
+	"time"
 
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/assert"
 
 	"github.com/DrmagicE/gmqtt/config"
+	"github.com/DrmagicE/gmqtt/pkg/codes"
 	"github.com/DrmagicE/gmqtt/pkg/packets"
 	"github.com/DrmagicE/gmqtt/server"
 )
@@ -55,3 +57,57 @@ func TestAuth_OnBasicAuthWrapper(t *testing.T) {
 
 	a.Nil(au.Unload())
 }
+
+func TestAuth_schedule(t *testing.T) {
+	a := assert.New(t)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	path := "./testdata/gmqtt_password.yml"
+	cfg := DefaultConfig
+	cfg.PasswordFile = path
+	cfg.Hash = Plain
+	auth, err := New(config.Config{
+		Plugins: map[string]config.Configuration{
+			"auth": &cfg,
+		},
+	})
+	a.Nil(err)
+	a.Nil(auth.Load(nil))
+	au := auth.(*Auth)
+	au.indexer.Set("u1", &Account{
+		Username: "u1",
+		Password: "p1",
+		Schedule: []*AccessWindow{{Start: "02:00", End: "04:00"}},
+	})
+	now := time.Date(2021, 1, 2, 3, 0, 0, 0, time.UTC)
+	au.now = func() time.Time {
+		return now
+	}
+	mockClient := server.NewMockClient(ctrl)
+	mockClient.EXPECT().Version().Return(packets.Version5).AnyTimes()
+	mockClient.EXPECT().ClientOptions().Return(&server.ClientOptions{
+		ClientID: "cid",
+		Username: "u1",
+	}).AnyTimes()
+	onBasicAuth := au.OnBasicAuthWrapper(func(ctx context.Context, client server.Client, req *server.ConnectRequest) (err error) {
+		return nil
+	})
+	onMsgArrived := au.OnMsgArrivedWrapper(func(ctx context.Context, client server.Client, req *server.MsgArrivedRequest) error {
+		return nil
+	})
+	connect := &server.ConnectRequest{
+		Connect: &packets.Connect{
+			Username: []byte("u1"),
+			Password: []byte("p1"),
+		},
+	}
+	a.Nil(onBasicAuth(context.Background(), mockClient, connect))
+	a.Nil(onMsgArrived(context.Background(), mockClient, &server.MsgArrivedRequest{}))
+
+	now = time.Date(2021, 1, 2, 4, 0, 0, 0, time.UTC)
+	err = onBasicAuth(context.Background(), mockClient, connect)
+	a.Equal(codes.NotAuthorized, err.(*codes.Error).Code)
+	err = onMsgArrived(context.Background(), mockClient, &server.MsgArrivedRequest{})
+	a.Equal(codes.NotAuthorized, err.(*codes.Error).Code)
+}
@@ -0,0 +1,91 @@
+package auth
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// minutesOfDay parses the time of day in HH:MM format.
+func minutesOfDay(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day: %q, must be in HH:MM format", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// validateSchedule returns an error if any access window of the schedule is invalid.
+func validateSchedule(schedule []*AccessWindow) error {
+	for _, v := range schedule {
+		if _, err := minutesOfDay(v.Start); err != nil {
+			return err
+		}
+		if _, err := minutesOfDay(v.End); err != nil {
+			return err
+		}
+		for _, d := range v.Weekdays {
+			if _, ok := weekdays[strings.ToLower(d)]; !ok {
+				return fmt.Errorf("invalid weekday: %q", d)
+			}
+		}
+	}
+	return nil
+}
+
+// startsOn returns whether the window starts on the weekday.
+func (w *AccessWindow) startsOn(day time.Weekday) bool {
+	if len(w.Weekdays) == 0 {
+		return true
+	}
+	for _, v := range w.Weekdays {
+		if weekdays[strings.ToLower(v)] == day {
+			return true
+		}
+	}
+	return false
+}
+
+// contains returns whether t is within the window.
+// The window which spans midnight is considered to start on the weekday of its start time.
+func (w *AccessWindow) contains(t time.Time) bool {
+	t = t.UTC()
+	start, err := minutesOfDay(w.Start)
+	if err != nil {
+		return false
+	}
+	end, err := minutesOfDay(w.End)
+	if err != nil {
+		return false
+	}
+	m := t.Hour()*60 + t.Minute()
+	if start < end {
+		return m >= start && m < end && w.startsOn(t.Weekday())
+	}
+	// spans midnight, the window started today or yesterday.
+	return (m >= start && w.startsOn(t.Weekday())) ||
+		(m < end && w.startsOn((t.Weekday()+6)%7))
+}
+
+// inSchedule returns whether t is within any access window of the schedule, the empty schedule has no restriction.
+func inSchedule(schedule []*AccessWindow, t time.Time) bool {
+	if len(schedule) == 0 {
+		return true
+	}
+	for _, v := range schedule {
+		if v.contains(t) {
+			return true
+		}
+	}
+	return false
+}
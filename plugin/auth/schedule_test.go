@@ -0,0 +1,104 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateSchedule(t *testing.T) {
+	a := assert.New(t)
+	a.NoError(validateSchedule(nil))
+	a.NoError(validateSchedule([]*AccessWindow{
+		{Start: "02:00", End: "04:00"},
+		{Start: "22:00", End: "02:00", Weekdays: []string{"Sat", "sun"}},
+	}))
+	a.Error(validateSchedule([]*AccessWindow{{Start: "2am", End: "04:00"}}))
+	a.Error(validateSchedule([]*AccessWindow{{Start: "02:00", End: "24:00"}}))
+	a.Error(validateSchedule([]*AccessWindow{{Start: "02:00", End: "04:00", Weekdays: []string{"sunday"}}}))
+}
+
+func TestInSchedule(t *testing.T) {
+	// 2021-01-02 is a Saturday.
+	at := func(s string) time.Time {
+		t, _ := time.Parse("2006-01-02 15:04", s)
+		return t
+	}
+	var tt = []struct {
+		name     string
+		window   *AccessWindow
+		t        time.Time
+		expected bool
+	}{
+		{
+			name:     "empty",
+			t:        at("2021-01-02 12:00"),
+			expected: true,
+		},
+		{
+			name:     "start_inclusive",
+			window:   &AccessWindow{Start: "02:00", End: "04:00"},
+			t:        at("2021-01-02 02:00"),
+			expected: true,
+		},
+		{
+			name:     "end_exclusive",
+			window:   &AccessWindow{Start: "02:00", End: "04:00"},
+			t:        at("2021-01-02 04:00"),
+			expected: false,
+		},
+		{
+			name:     "weekday",
+			window:   &AccessWindow{Start: "02:00", End: "04:00", Weekdays: []string{"sat"}},
+			t:        at("2021-01-02 03:00"),
+			expected: true,
+		},
+		{
+			name:     "other_weekday",
+			window:   &AccessWindow{Start: "02:00", End: "04:00", Weekdays: []string{"sun"}},
+			t:        at("2021-01-02 03:00"),
+			expected: false,
+		},
+		{
+			name:     "midnight_before",
+			window:   &AccessWindow{Start: "22:00", End: "02:00", Weekdays: []string{"sat"}},
+			t:        at("2021-01-02 23:00"),
+			expected: true,
+		},
+		{
+			// the window starts on Saturday.
+			name:     "midnight_after",
+			window:   &AccessWindow{Start: "22:00", End: "02:00", Weekdays: []string{"sat"}},
+			t:        at("2021-01-03 01:00"),
+			expected: true,
+		},
+		{
+			name:     "midnight_after_other_weekday",
+			window:   &AccessWindow{Start: "22:00", End: "02:00", Weekdays: []string{"sat"}},
+			t:        at("2021-01-02 01:00"),
+			expected: false,
+		},
+		{
+			name:     "whole_day",
+			window:   &AccessWindow{Start: "00:00", End: "00:00", Weekdays: []string{"sat"}},
+			t:        at("2021-01-02 13:00"),
+			expected: true,
+		},
+		{
+			name:     "utc",
+			window:   &AccessWindow{Start: "02:00", End: "04:00"},
+			t:        at("2021-01-02 03:00").In(time.FixedZone("UTC+8", 8*3600)),
+			expected: true,
+		},
+	}
+	for _, v := range tt {
+		t.Run(v.name, func(t *testing.T) {
+			var schedule []*AccessWindow
+			if v.window != nil {
+				schedule = append(schedule, v.window)
+			}
+			assert.Equal(t, v.expected, inSchedule(schedule, v.t))
+		})
+	}
+}
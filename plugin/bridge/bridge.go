@@ -0,0 +1,576 @@
+package bridge
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/DrmagicE/gmqtt"
+	"github.com/DrmagicE/gmqtt/config"
+	"github.com/DrmagicE/gmqtt/persistence/encoding"
+	"github.com/DrmagicE/gmqtt/pkg/codes"
+	"github.com/DrmagicE/gmqtt/pkg/packets"
+	"github.com/DrmagicE/gmqtt/pkg/proxy"
+	"github.com/DrmagicE/gmqtt/server"
+)
+
+var _ server.Plugin = (*Bridge)(nil)
+
+const Name = "bridge"
+
+// errCredentialsExpired is returned when the bridge reconnects to refresh the expiring credentials.
+var errCredentialsExpired = errors.New("credentials expired")
+
+func init() {
+	server.RegisterPlugin(Name, New)
+	config.RegisterDefaultPluginConfig(Name, &DefaultConfig)
+}
+
+func New(config config.Config) (server.Plugin, error) {
+	cfg := config.Plugins[Name].(*Config)
+	b := &Bridge{}
+	if cfg.Federation.NodeName != "" {
+		b.federation = &cfg.Federation
+	}
+	for k := range cfg.Bridges {
+		v := &cfg.Bridges[k]
+		tlsConfig, err := buildTLSConfig(v, config.ConfigDir, config.Crypto)
+		if err != nil {
+			return nil, fmt.Errorf("bridge %s: %s", v.Name, err)
+		}
+		nb := newBridge(v, tlsConfig, proxy.Resolve(v.Proxy, config.Proxy))
+		if v.StoreAndForward.Enable {
+			dir := v.StoreAndForward.Dir
+			if !path.IsAbs(dir) {
+				dir = path.Join(config.ConfigDir, dir)
+			}
+			nb.spoolDir = path.Join(dir, v.Name)
+		}
+		if v.Federation {
+			nb.federation = &cfg.Federation
+		}
+		b.bridges = append(b.bridges, nb)
+	}
+	return b, nil
+}
+
+var log *zap.Logger
+
+// Bridge forwards the messages between the local broker and the remote brokers,
+// with the compatibility profiles for the cloud IoT platforms which have extra constraints.
+type Bridge struct {
+	bridges []*bridge
+	// federation is not nil if the node name is set, the messages arrived from the federation links are checked against it.
+	federation *FederationConfig
+}
+
+func (b *Bridge) Load(service server.Server) error {
+	log = server.LoggerWithField(zap.String("plugin", Name))
+	for _, v := range b.bridges {
+		if err := v.start(service.NewLocalClient); err != nil {
+			return fmt.Errorf("bridge %s: %s", v.cfg.Name, err)
+		}
+	}
+	return nil
+}
+
+func (b *Bridge) Unload() error {
+	for _, v := range b.bridges {
+		v.stop()
+	}
+	return nil
+}
+
+func (b *Bridge) Name() string {
+	return Name
+}
+
+func buildTLSConfig(cfg *BridgeConfig, configDir string, crypto config.Crypto) (*tls.Config, error) {
+	if !cfg.TLS.Enable {
+		return nil, nil
+	}
+	resolve := func(file string) string {
+		if file == "" || path.IsAbs(file) {
+			return file
+		}
+		return path.Join(configDir, file)
+	}
+	c := &tls.Config{
+		ServerName:         cfg.TLS.ServerName,
+		NextProtos:         cfg.TLS.ALPN,
+		InsecureSkipVerify: cfg.TLS.InsecureSkipVerify,
+	}
+	if c.ServerName == "" {
+		// the SNI is required by the cloud platforms to route the connection to the endpoint.
+		c.ServerName, _, _ = net.SplitHostPort(cfg.Address)
+	}
+	if _, port, _ := net.SplitHostPort(cfg.Address); cfg.Profile == ProfileAWSIoT && port == "443" && len(c.NextProtos) == 0 && cfg.Username == "" {
+		c.NextProtos = []string{awsALPN}
+	}
+	if cfg.TLS.CACert != "" {
+		b, err := ioutil.ReadFile(resolve(cfg.TLS.CACert))
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(b) {
+			return nil, errors.New("invalid tls.cacert: no certificates found")
+		}
+		c.RootCAs = pool
+	}
+	if cfg.TLS.Cert != "" {
+		cert, err := tls.LoadX509KeyPair(resolve(cfg.TLS.Cert), resolve(cfg.TLS.Key))
+		if err != nil {
+			return nil, err
+		}
+		c.Certificates = []tls.Certificate{cert}
+	}
+	return c, crypto.ApplyTLS(c)
+}
+
+// queued is the message waiting to be relayed.
+type queued struct {
+	msg   *gmqtt.Message
+	topic *TopicConfig
+	// receivedAt is the time when the bridge received the message.
+	receivedAt time.Time
+}
+
+// encode encodes the message to be stored in the spool.
+func (q *queued) encode() []byte {
+	b := &bytes.Buffer{}
+	encoding.WriteString(b, []byte(q.topic.Filter))
+	var ts [8]byte
+	binary.BigEndian.PutUint64(ts[:], uint64(q.receivedAt.UnixNano()))
+	b.Write(ts[:])
+	encoding.EncodeMessage(q.msg, b)
+	return b.Bytes()
+}
+
+// decode decodes the message stored in the spool,
+// it returns nil if the topic filter has been removed from the configuration.
+func (b *bridge) decode(data []byte) (*queued, error) {
+	buf := bytes.NewBuffer(data)
+	filter, err := encoding.ReadString(buf)
+	if err != nil {
+		return nil, err
+	}
+	ts := buf.Next(8)
+	if len(ts) != 8 {
+		return nil, errSpoolCorrupt
+	}
+	msg, err := encoding.DecodeMessage(buf)
+	if err != nil {
+		return nil, err
+	}
+	for k := range b.cfg.Topics {
+		if b.cfg.Topics[k].Filter == string(filter) {
+			return &queued{
+				msg:        msg,
+				topic:      &b.cfg.Topics[k],
+				receivedAt: time.Unix(0, int64(binary.BigEndian.Uint64(ts))),
+			}, nil
+		}
+	}
+	return nil, nil
+}
+
+// bridge forwards the messages matched by the topic filters between the local broker and a remote broker.
+type bridge struct {
+	cfg       *BridgeConfig
+	profile   *profile
+	tlsConfig *tls.Config
+	// proxy is the forward proxy of the bridge, which is the global proxy if the bridge does not set it.
+	proxy  string
+	log    *zap.Logger
+	client server.LocalClient
+	queue  chan *queued
+	// spoolDir is the directory of the spool, the messages are buffered in memory if it is empty.
+	spoolDir string
+	spool    *spool
+	// federation is not nil if the bridge is a federation link.
+	federation *FederationConfig
+	done       chan struct{}
+	stopOnce   sync.Once
+	wg         sync.WaitGroup
+}
+
+func newBridge(cfg *BridgeConfig, tlsConfig *tls.Config, proxy string) *bridge {
+	return &bridge{
+		cfg:       cfg,
+		profile:   newProfile(cfg),
+		tlsConfig: tlsConfig,
+		proxy:     proxy,
+		queue:     make(chan *queued, cfg.QueueSize),
+		done:      make(chan struct{}),
+	}
+}
+
+// start subscribes the topic filters and starts relaying.
+func (b *bridge) start(newClient func(clientID string) (server.LocalClient, error)) (err error) {
+	b.log = log.With(zap.String("bridge", b.cfg.Name))
+	if b.spoolDir != "" {
+		sf := b.cfg.StoreAndForward
+		b.spool, err = openSpool(b.spoolDir, sf.MaxBytes, sf.SegmentSize, sf.Fsync)
+		if err != nil {
+			return err
+		}
+		if n := b.spool.bytes(); n > 0 {
+			b.log.Info("resuming the buffered messages", zap.Int64("bytes", n))
+		}
+	}
+	b.client, err = newClient("$bridge/" + b.cfg.Name)
+	if err != nil {
+		b.stop()
+		return err
+	}
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+		b.run()
+	}()
+	subscribe := b.client.Subscribe
+	if b.inbound() {
+		// the messages received from the remote broker are not relayed back.
+		subscribe = b.client.SubscribeNoLocal
+	}
+	for k := range b.cfg.Topics {
+		t := &b.cfg.Topics[k]
+		if !b.cfg.outbound(t) {
+			continue
+		}
+		err = subscribe(t.Filter, packets.Qos2, func(msg *gmqtt.Message) {
+			b.enqueue(msg, t)
+		})
+		if err != nil {
+			b.stop()
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *bridge) stop() {
+	b.stopOnce.Do(func() {
+		if b.client != nil {
+			b.client.Close()
+		}
+		close(b.done)
+		b.wg.Wait()
+		if b.spool != nil {
+			b.spool.close()
+		}
+	})
+}
+
+// enqueue queues the message, it is called in the goroutine of the publisher and must not block.
+// With the store and forward, the message is appended to the spool before the publisher is acknowledged.
+func (b *bridge) enqueue(msg *gmqtt.Message, topic *TopicConfig) {
+	if b.federation != nil && !b.federation.relayable(msg.UserProperties) {
+		b.log.Debug("maximum hops exceeded, message dropped", zap.String("topic", msg.Topic))
+		return
+	}
+	q := &queued{msg: msg, topic: topic, receivedAt: time.Now()}
+	if b.spool != nil {
+		if err := b.spool.append(q.encode()); err != nil {
+			b.log.Warn("fail to buffer the message, message dropped", zap.String("topic", msg.Topic), zap.Error(err))
+		}
+		return
+	}
+	select {
+	case b.queue <- q:
+	default:
+		b.log.Warn("queue is full, message dropped", zap.String("topic", msg.Topic))
+	}
+}
+
+// publishPacket builds the PUBLISH packet to relay,
+// it returns an error if the message violates the constraints of the remote broker.
+func (b *bridge) publishPacket(q *queued) (*packets.Publish, error) {
+	topic := q.topic.RemotePrefix + q.msg.Topic
+	if b.profile.remoteTopic != nil {
+		topic = b.profile.remoteTopic(topic)
+	}
+	ts := strconv.FormatInt(q.receivedAt.UnixNano()/int64(time.Millisecond), 10)
+	if b.cfg.TimestampProperty != "" && b.profile.propertyBag {
+		topic += "&" + url.QueryEscape(b.cfg.TimestampProperty) + "=" + ts
+	}
+	if err := b.profile.check(topic, q.msg.Payload); err != nil {
+		return nil, err
+	}
+	qos := q.msg.QoS
+	if qos > q.topic.QoS {
+		qos = q.topic.QoS
+	}
+	if qos > b.profile.maxQoS {
+		qos = b.profile.maxQoS
+	}
+	p := &packets.Publish{
+		Version:   packets.Version(b.cfg.ProtocolVersion),
+		Qos:       qos,
+		Retain:    q.msg.Retained && b.profile.retain,
+		TopicName: []byte(topic),
+		Payload:   q.msg.Payload,
+	}
+	if p.Version == packets.Version5 {
+		p.Properties = &packets.Properties{
+			User: q.msg.UserProperties,
+		}
+		if b.federation != nil {
+			p.Properties.User = b.federation.stamp(q.msg.UserProperties)
+		}
+		if b.cfg.TimestampProperty != "" {
+			p.Properties.User = append(p.Properties.User[:len(p.Properties.User):len(p.Properties.User)], packets.UserProperty{
+				K: []byte(b.cfg.TimestampProperty),
+				V: []byte(ts),
+			})
+		}
+	}
+	return p, nil
+}
+
+func (b *bridge) connect() (*conn, time.Time, error) {
+	clientID, username, password, expiry, err := credentials(b.cfg, time.Now())
+	if err != nil {
+		return nil, expiry, err
+	}
+	opts := &connectOptions{
+		address:      b.cfg.Address,
+		version:      packets.Version(b.cfg.ProtocolVersion),
+		tlsConfig:    b.tlsConfig,
+		proxy:        b.proxy,
+		clientID:     clientID,
+		username:     username,
+		password:     password,
+		cleanSession: b.cfg.CleanSession,
+		keepAlive:    b.cfg.KeepAlive,
+		timeout:      b.cfg.Timeout,
+	}
+	if b.inbound() {
+		opts.onPublish = b.receive
+	}
+	c, err := connect(opts)
+	return c, expiry, err
+}
+
+// run connects to the remote broker and relays the queued messages, it reconnects with backoff on failure.
+func (b *bridge) run() {
+	var pending *queued
+	interval := b.cfg.ReconnectInterval
+	for {
+		c, expiry, err := b.connect()
+		if err == nil {
+			b.log.Info("connected", zap.String("address", b.cfg.Address))
+			interval = b.cfg.ReconnectInterval
+			pending, err = b.serve(c, pending, expiry)
+			c.close(b.cfg.Timeout)
+			if err == nil {
+				return
+			}
+			if err == errCredentialsExpired {
+				b.log.Info("reconnecting to refresh the credentials")
+				continue
+			}
+		}
+		b.log.Warn("connection lost, reconnecting", zap.Error(err), zap.Duration("interval", interval))
+		timer := time.NewTimer(interval)
+		select {
+		case <-b.done:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		if interval *= 2; interval > b.cfg.MaxReconnectInterval {
+			interval = b.cfg.MaxReconnectInterval
+		}
+	}
+}
+
+// serve relays the messages until the connection fails or the bridge stops,
+// it returns the message that has not been acknowledged.
+func (b *bridge) serve(c *conn, pending *queued, expiry time.Time) (*queued, error) {
+	var refresh <-chan time.Time
+	if !expiry.IsZero() {
+		// reconnect before the credentials expire, the remote broker closes the connection on expiry.
+		timer := time.NewTimer(time.Until(expiry) * 9 / 10)
+		defer timer.Stop()
+		refresh = timer.C
+	}
+	var ping <-chan time.Time
+	if b.cfg.KeepAlive > 0 {
+		ticker := time.NewTicker(b.cfg.KeepAlive / 2)
+		defer ticker.Stop()
+		ping = ticker.C
+	}
+	var ready <-chan struct{}
+	if b.spool != nil {
+		ready = b.spool.ready
+	}
+	if b.inbound() {
+		if err := b.subscribeRemote(c); err != nil {
+			return pending, err
+		}
+	}
+	for {
+		if pending == nil && b.spool != nil {
+			var err error
+			if pending, err = b.next(); err != nil {
+				return nil, err
+			}
+		}
+		if pending != nil {
+			if err := b.relay(c, pending); err != nil {
+				return pending, err
+			}
+			pending = nil
+			if b.spool != nil {
+				if err := b.spool.ack(); err != nil {
+					return nil, err
+				}
+			}
+		}
+		select {
+		case <-b.done:
+			return nil, nil
+		case pending = <-b.queue:
+		case <-ready:
+		case now := <-ping:
+			if err := c.ping(now, b.cfg.Timeout); err != nil {
+				return nil, err
+			}
+		case <-c.done:
+			return nil, c.err
+		case <-refresh:
+			return nil, errCredentialsExpired
+		}
+	}
+}
+
+// next returns the first message in the spool, nil if it is empty.
+// The undecodable messages and the messages of the removed topic filters are skipped.
+func (b *bridge) next() (*queued, error) {
+	for {
+		data, err := b.spool.peek()
+		if err != nil || data == nil {
+			return nil, err
+		}
+		q, err := b.decode(data)
+		if err != nil {
+			b.log.Warn("fail to decode the buffered message, message dropped", zap.Error(err))
+		}
+		if q != nil {
+			return q, nil
+		}
+		if err = b.spool.ack(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// inbound reports whether any topic is subscribed on the remote broker.
+func (b *bridge) inbound() bool {
+	for k := range b.cfg.Topics {
+		if b.cfg.inbound(&b.cfg.Topics[k]) {
+			return true
+		}
+	}
+	return false
+}
+
+// subscribeRemote subscribes the inbound topics on the remote broker,
+// with no local so that the relayed messages are not sent back.
+func (b *bridge) subscribeRemote(c *conn) error {
+	var topics []packets.Topic
+	for k := range b.cfg.Topics {
+		v := &b.cfg.Topics[k]
+		if !b.cfg.inbound(v) {
+			continue
+		}
+		qos := v.QoS
+		if qos > b.profile.maxQoS {
+			qos = b.profile.maxQoS
+		}
+		topics = append(topics, packets.Topic{
+			Name: v.RemotePrefix + v.Filter,
+			SubOptions: packets.SubOptions{
+				Qos:               qos,
+				NoLocal:           true,
+				RetainAsPublished: true,
+			},
+		})
+	}
+	rs, err := c.subscribe(topics, b.cfg.Timeout)
+	if err != nil {
+		return err
+	}
+	for k, v := range rs {
+		if v >= codes.UnspecifiedError {
+			b.log.Warn("subscription rejected by the remote broker", zap.String("topic_filter", topics[k].Name), zap.Uint8("code", v))
+		}
+	}
+	return nil
+}
+
+// receive publishes the message received from the remote broker locally, it is called in the read loop of the connection.
+func (b *bridge) receive(p *packets.Publish) {
+	topic, ok := b.localTopic(string(p.TopicName))
+	if !ok {
+		b.log.Warn("unexpected message dropped", zap.String("topic", string(p.TopicName)))
+		return
+	}
+	msg := gmqtt.MessageFromPublish(p)
+	msg.Dup = false
+	msg.Topic = topic
+	if b.federation != nil {
+		origin, hops, ok := parseFederation(msg.UserProperties)
+		// receiving over the link is a hop too.
+		hops++
+		if !ok || !b.federation.accept(origin, hops) {
+			b.log.Debug("looped message dropped", zap.String("topic", topic), zap.String("origin", origin), zap.Int("hops", hops))
+			return
+		}
+		msg.UserProperties = withFederation(msg.UserProperties, origin, hops)
+	}
+	if err := b.client.Publish(msg); err != nil {
+		b.log.Warn("fail to publish the message", zap.String("topic", topic), zap.Error(err))
+	}
+}
+
+// localTopic returns the local topic of the remote topic by the remote prefix of the first matched inbound topic filter.
+func (b *bridge) localTopic(remote string) (string, bool) {
+	for k := range b.cfg.Topics {
+		v := &b.cfg.Topics[k]
+		if b.cfg.inbound(v) && strings.HasPrefix(remote, v.RemotePrefix) && packets.TopicMatch([]byte(remote), []byte(v.RemotePrefix+v.Filter)) {
+			return strings.TrimPrefix(remote, v.RemotePrefix), true
+		}
+	}
+	return "", false
+}
+
+func (b *bridge) relay(c *conn, q *queued) error {
+	p, err := b.publishPacket(q)
+	if err != nil {
+		b.log.Warn("message dropped", zap.String("topic", q.msg.Topic), zap.Error(err))
+		return nil
+	}
+	err = c.publish(p, b.cfg.Timeout)
+	var rejected *rejectedError
+	if errors.As(err, &rejected) {
+		b.log.Warn("message dropped", zap.String("topic", q.msg.Topic), zap.Error(err))
+		return nil
+	}
+	return err
+}
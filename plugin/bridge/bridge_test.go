@@ -0,0 +1,638 @@
+package bridge
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+
+	"github.com/DrmagicE/gmqtt"
+	"github.com/DrmagicE/gmqtt/config"
+	"github.com/DrmagicE/gmqtt/pkg/codes"
+	"github.com/DrmagicE/gmqtt/pkg/packets"
+	"github.com/DrmagicE/gmqtt/server"
+)
+
+// fakeLocalClient records the message handlers.
+type fakeLocalClient struct {
+	mu       sync.Mutex
+	handlers map[string]server.MessageHandler
+	// noLocal is the topic filters subscribed with no local.
+	noLocal map[string]bool
+	// published records the published messages.
+	published chan *gmqtt.Message
+}
+
+func newFakeLocalClient() *fakeLocalClient {
+	return &fakeLocalClient{
+		handlers:  make(map[string]server.MessageHandler),
+		noLocal:   make(map[string]bool),
+		published: make(chan *gmqtt.Message, 10),
+	}
+}
+
+func (f *fakeLocalClient) ClientID() string {
+	return "fake"
+}
+
+func (f *fakeLocalClient) Publish(msg *gmqtt.Message) error {
+	f.mu.Lock()
+	h := f.handlers[msg.Topic]
+	noLocal := f.noLocal[msg.Topic]
+	f.mu.Unlock()
+	select {
+	case f.published <- msg:
+	default:
+	}
+	if h != nil && !noLocal {
+		h(msg)
+	}
+	return nil
+}
+
+// deliver delivers the message published by other clients.
+func (f *fakeLocalClient) deliver(msg *gmqtt.Message) {
+	f.mu.Lock()
+	h := f.handlers[msg.Topic]
+	f.mu.Unlock()
+	if h != nil {
+		h(msg)
+	}
+}
+
+func (f *fakeLocalClient) Subscribe(topicFilter string, qos packets.QoS, handler server.MessageHandler) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.handlers[topicFilter] = handler
+	return nil
+}
+
+func (f *fakeLocalClient) SubscribeNoLocal(topicFilter string, qos packets.QoS, handler server.MessageHandler) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.handlers[topicFilter] = handler
+	f.noLocal[topicFilter] = true
+	return nil
+}
+
+func (f *fakeLocalClient) Unsubscribe(topicFilter string) error {
+	return nil
+}
+
+func (f *fakeLocalClient) Close() {}
+
+// fakeRemote is a remote broker which accepts the connections and records the CONNECT, PUBLISH and SUBSCRIBE packets.
+// The messages in send are sent to the client after the SUBACK.
+type fakeRemote struct {
+	ln        net.Listener
+	connects  chan *packets.Connect
+	publish   chan *packets.Publish
+	subscribe chan *packets.Subscribe
+	send      chan *packets.Publish
+}
+
+// newFakeRemote starts the fake remote broker,
+// if noAck is true, the first connection is closed on the first PUBLISH without acknowledging it.
+func newFakeRemote(t *testing.T, noAck bool) *fakeRemote {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	f := &fakeRemote{
+		ln:        ln,
+		connects:  make(chan *packets.Connect, 10),
+		publish:   make(chan *packets.Publish, 10),
+		subscribe: make(chan *packets.Subscribe, 10),
+		send:      make(chan *packets.Publish, 10),
+	}
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(c, noAck)
+			noAck = false
+		}
+	}()
+	t.Cleanup(func() {
+		ln.Close()
+	})
+	return f
+}
+
+func (f *fakeRemote) serve(c net.Conn, noAck bool) {
+	defer c.Close()
+	r := packets.NewReader(c)
+	w := packets.NewWriter(c)
+	p, err := r.ReadPacket()
+	if err != nil {
+		return
+	}
+	connect := p.(*packets.Connect)
+	f.connects <- connect
+	r.SetVersion(connect.Version)
+	_ = w.WriteAndFlush(&packets.Connack{Version: connect.Version, Code: codes.Success})
+	for {
+		p, err := r.ReadPacket()
+		if err != nil {
+			return
+		}
+		switch p := p.(type) {
+		case *packets.Publish:
+			if noAck {
+				return
+			}
+			f.publish <- p
+			if p.Qos == packets.Qos1 {
+				_ = w.WriteAndFlush(p.NewPuback(codes.Success, nil))
+			}
+		case *packets.Subscribe:
+			f.subscribe <- p
+			_ = w.WriteAndFlush(p.NewSuback())
+			for len(f.send) != 0 {
+				_ = w.WriteAndFlush(<-f.send)
+			}
+		case *packets.Pingreq:
+			_ = w.WriteAndFlush(p.NewPingresp())
+		case *packets.Disconnect:
+			return
+		}
+	}
+}
+
+func startBridge(t *testing.T, cfg *BridgeConfig) (*bridge, *fakeLocalClient) {
+	log = zap.NewNop()
+	local := newFakeLocalClient()
+	b := newBridge(cfg, nil, "")
+	assert.NoError(t, b.start(func(clientID string) (server.LocalClient, error) {
+		return local, nil
+	}))
+	t.Cleanup(b.stop)
+	return b, local
+}
+
+func receive(t *testing.T, ch <-chan *packets.Publish) *packets.Publish {
+	select {
+	case p := <-ch:
+		return p
+	case <-time.After(3 * time.Second):
+		t.Fatal("timeout")
+	}
+	return nil
+}
+
+func TestBridge_Relay(t *testing.T) {
+	a := assert.New(t)
+	remote := newFakeRemote(t, true)
+	cfg := DefaultBridgeConfig
+	cfg.Name = "test"
+	cfg.Address = remote.ln.Addr().String()
+	cfg.ClientID = "edge"
+	cfg.Username = "user"
+	cfg.Password = "pass"
+	cfg.ReconnectInterval = 10 * time.Millisecond
+	cfg.Topics = []TopicConfig{{Filter: "a/b", QoS: packets.Qos1, RemotePrefix: "site1/"}}
+	_, local := startBridge(t, &cfg)
+
+	connect := <-remote.connects
+	a.Equal("edge", string(connect.ClientID))
+	a.Equal("user", string(connect.Username))
+	a.Equal("pass", string(connect.Password))
+
+	a.NoError(local.Publish(&gmqtt.Message{Topic: "a/b", Payload: []byte("1"), QoS: packets.Qos2, Retained: true}))
+	// the message is not acknowledged by the first connection and is resent after reconnected.
+	<-remote.connects
+	p := receive(t, remote.publish)
+	a.Equal("site1/a/b", string(p.TopicName))
+	a.Equal([]byte("1"), p.Payload)
+	a.Equal(packets.Qos1, p.Qos)
+	a.True(p.Retain)
+
+	a.NoError(local.Publish(&gmqtt.Message{Topic: "a/b", Payload: []byte("2")}))
+	p = receive(t, remote.publish)
+	a.Equal([]byte("2"), p.Payload)
+	a.Equal(packets.Qos0, p.Qos)
+}
+
+func TestBridge_StoreAndForward(t *testing.T) {
+	a := assert.New(t)
+	dir, err := ioutil.TempDir("", "bridge")
+	a.NoError(err)
+	defer os.RemoveAll(dir)
+	// the uplink is down.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	a.NoError(err)
+	ln.Close()
+
+	cfg := DefaultBridgeConfig
+	cfg.Name = "edge"
+	cfg.Address = ln.Addr().String()
+	cfg.ClientID = "edge"
+	cfg.ProtocolVersion = 5
+	cfg.TimestampProperty = "received-at"
+	cfg.ReconnectInterval = 10 * time.Millisecond
+	cfg.Topics = []TopicConfig{{Filter: "a/b", QoS: packets.Qos1}}
+	log = zap.NewNop()
+	local := newFakeLocalClient()
+	newClient := func(clientID string) (server.LocalClient, error) {
+		return local, nil
+	}
+	b := newBridge(&cfg, nil, "")
+	b.spoolDir = dir
+	a.NoError(b.start(newClient))
+	before := time.Now().UnixNano() / int64(time.Millisecond)
+	for _, v := range []string{"1", "2"} {
+		a.NoError(local.Publish(&gmqtt.Message{
+			Topic:          "a/b",
+			Payload:        []byte(v),
+			QoS:            packets.Qos1,
+			UserProperties: []packets.UserProperty{{K: []byte("k"), V: []byte(v)}},
+		}))
+	}
+	after := time.Now().UnixNano() / int64(time.Millisecond)
+	b.stop()
+
+	// the buffered messages are relayed with the original timestamps after restarted and the uplink returns.
+	remote := newFakeRemote(t, false)
+	cfg.Address = remote.ln.Addr().String()
+	b = newBridge(&cfg, nil, "")
+	b.spoolDir = dir
+	a.NoError(b.start(newClient))
+	defer b.stop()
+	a.EqualValues(packets.Version5, (<-remote.connects).Version)
+	for _, v := range []string{"1", "2"} {
+		p := receive(t, remote.publish)
+		a.Equal(v, string(p.Payload))
+		a.Len(p.Properties.User, 2)
+		a.Equal("k", string(p.Properties.User[0].K))
+		a.Equal("received-at", string(p.Properties.User[1].K))
+		ts, err := strconv.ParseInt(string(p.Properties.User[1].V), 10, 64)
+		a.NoError(err)
+		a.True(ts >= before && ts <= after, ts)
+	}
+	// all messages are acknowledged.
+	for i := 0; i < 100 && b.spool.bytes() != 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	a.EqualValues(0, b.spool.bytes())
+}
+
+func TestBridge_AzureTokenRefresh(t *testing.T) {
+	a := assert.New(t)
+	remote := newFakeRemote(t, false)
+	cfg := DefaultBridgeConfig
+	cfg.Name = "azure"
+	cfg.Profile = ProfileAzureIoTHub
+	cfg.Address = remote.ln.Addr().String()
+	cfg.TLS.ServerName = "hub.azure-devices.net"
+	cfg.Azure.DeviceID = "dev1"
+	cfg.Azure.SharedAccessKey = base64.StdEncoding.EncodeToString([]byte("key"))
+	cfg.Azure.TokenTTL = 2 * time.Second
+	cfg.Topics = []TopicConfig{{Filter: "a/b", QoS: packets.Qos1}}
+	_, local := startBridge(t, &cfg)
+
+	connect := <-remote.connects
+	a.Equal("dev1", string(connect.ClientID))
+	a.Equal("hub.azure-devices.net/dev1/?api-version="+azureAPIVersion, string(connect.Username))
+	a.True(strings.HasPrefix(string(connect.Password), "SharedAccessSignature sr=hub.azure-devices.net%2Fdevices%2Fdev1&sig="))
+
+	a.NoError(local.Publish(&gmqtt.Message{Topic: "a/b", Payload: []byte("1"), QoS: packets.Qos1, Retained: true}))
+	p := receive(t, remote.publish)
+	a.Equal("devices/dev1/messages/events/mqtt-topic=a%2Fb", string(p.TopicName))
+	a.False(p.Retain)
+
+	// reconnect with a new token before the token expires.
+	select {
+	case c := <-remote.connects:
+		a.NotEqual(connect.Password, c.Password)
+	case <-time.After(3 * time.Second):
+		t.Fatal("timeout")
+	}
+}
+
+func TestBridge_Federation(t *testing.T) {
+	a := assert.New(t)
+	remote := newFakeRemote(t, false)
+	federated := func(topic, payload string, props ...string) *packets.Publish {
+		p := &packets.Publish{
+			Version:    packets.Version5,
+			Qos:        packets.Qos1,
+			PacketID:   1,
+			TopicName:  []byte(topic),
+			Payload:    []byte(payload),
+			Properties: &packets.Properties{},
+		}
+		for i := 0; i < len(props); i += 2 {
+			p.Properties.User = append(p.Properties.User, packets.UserProperty{K: []byte(props[i]), V: []byte(props[i+1])})
+		}
+		return p
+	}
+	// published by a client of the remote broker.
+	remote.send <- federated("site1/a/b", "1")
+	// routed back to the origin.
+	remote.send <- federated("site1/a/b", "2", OriginProperty, "local", HopsProperty, "1")
+	// exceeds the maximum hops.
+	remote.send <- federated("site1/a/b", "3", OriginProperty, "other", HopsProperty, "4")
+	remote.send <- federated("site1/a/b", "4", "k", "v", OriginProperty, "other", HopsProperty, "1")
+
+	cfg := DefaultBridgeConfig
+	cfg.Name = "site1"
+	cfg.Address = remote.ln.Addr().String()
+	cfg.ClientID = "local"
+	cfg.ProtocolVersion = 5
+	cfg.Federation = true
+	cfg.Topics = []TopicConfig{{Filter: "a/b", QoS: packets.Qos2, RemotePrefix: "site1/"}}
+	log = zap.NewNop()
+	local := newFakeLocalClient()
+	b := newBridge(&cfg, nil, "")
+	b.federation = &FederationConfig{NodeName: "local", MaxHops: 4}
+	a.NoError(b.start(func(clientID string) (server.LocalClient, error) {
+		return local, nil
+	}))
+	defer b.stop()
+
+	sub := <-remote.subscribe
+	a.Len(sub.Topics, 1)
+	a.Equal("site1/a/b", sub.Topics[0].Name)
+	a.Equal(packets.Qos1, sub.Topics[0].Qos)
+	a.True(sub.Topics[0].NoLocal)
+	a.True(sub.Topics[0].RetainAsPublished)
+
+	receiveLocal := func() *gmqtt.Message {
+		select {
+		case msg := <-local.published:
+			return msg
+		case <-time.After(3 * time.Second):
+			t.Fatal("timeout")
+		}
+		return nil
+	}
+	msg := receiveLocal()
+	a.Equal("a/b", msg.Topic)
+	a.Equal("1", string(msg.Payload))
+	a.Equal([]packets.UserProperty{{K: []byte(HopsProperty), V: []byte("1")}}, msg.UserProperties)
+	msg = receiveLocal()
+	a.Equal("4", string(msg.Payload))
+	a.Equal([]packets.UserProperty{
+		{K: []byte("k"), V: []byte("v")},
+		{K: []byte(OriginProperty), V: []byte("other")},
+		{K: []byte(HopsProperty), V: []byte("2")},
+	}, msg.UserProperties)
+
+	// this broker becomes the origin of the local messages.
+	local.deliver(&gmqtt.Message{Topic: "a/b", Payload: []byte("5"), QoS: packets.Qos1})
+	p := receive(t, remote.publish)
+	a.Equal("site1/a/b", string(p.TopicName))
+	a.Equal([]packets.UserProperty{
+		{K: []byte(OriginProperty), V: []byte("local")},
+		{K: []byte(HopsProperty), V: []byte("1")},
+	}, p.Properties.User)
+	// the messages which have reached the maximum hops are not relayed.
+	local.deliver(&gmqtt.Message{Topic: "a/b", Payload: []byte("6"), UserProperties: []packets.UserProperty{
+		{K: []byte(OriginProperty), V: []byte("other")},
+		{K: []byte(HopsProperty), V: []byte("4")},
+	}})
+	local.deliver(&gmqtt.Message{Topic: "a/b", Payload: []byte("7"), UserProperties: []packets.UserProperty{
+		{K: []byte(OriginProperty), V: []byte("other")},
+		{K: []byte(HopsProperty), V: []byte("2")},
+	}})
+	p = receive(t, remote.publish)
+	a.Equal("7", string(p.Payload))
+	a.Equal([]packets.UserProperty{
+		{K: []byte(OriginProperty), V: []byte("other")},
+		{K: []byte(HopsProperty), V: []byte("3")},
+	}, p.Properties.User)
+}
+
+func TestBridge_Inbound(t *testing.T) {
+	a := assert.New(t)
+	remote := newFakeRemote(t, false)
+	remote.send <- &packets.Publish{Version: packets.Version311, Qos: packets.Qos1, PacketID: 1, Retain: true,
+		TopicName: []byte("cloud/cmd/1"), Payload: []byte("1")}
+
+	cfg := DefaultBridgeConfig
+	cfg.Name = "cloud"
+	cfg.Address = remote.ln.Addr().String()
+	cfg.ClientID = "edge"
+	cfg.Topics = []TopicConfig{
+		{Filter: "cmd/#", QoS: packets.Qos2, RemotePrefix: "cloud/", Direction: DirectionIn},
+		{Filter: "a/b", QoS: packets.Qos1, RemotePrefix: "site1/"},
+	}
+	_, local := startBridge(t, &cfg)
+
+	sub := <-remote.subscribe
+	a.Len(sub.Topics, 1)
+	a.Equal("cloud/cmd/#", sub.Topics[0].Name)
+	a.Equal(packets.Qos1, sub.Topics[0].Qos)
+	select {
+	case msg := <-local.published:
+		a.Equal("cmd/1", msg.Topic)
+		a.Equal("1", string(msg.Payload))
+		a.Equal(packets.Qos1, msg.QoS)
+		a.True(msg.Retained)
+	case <-time.After(3 * time.Second):
+		t.Fatal("timeout")
+	}
+	// the inbound topics are not relayed.
+	local.deliver(&gmqtt.Message{Topic: "cmd/1", Payload: []byte("2")})
+	local.deliver(&gmqtt.Message{Topic: "a/b", Payload: []byte("3")})
+	p := receive(t, remote.publish)
+	a.Equal("site1/a/b", string(p.TopicName))
+	a.Equal("3", string(p.Payload))
+}
+
+func TestBridge_OnMsgArrivedWrapper(t *testing.T) {
+	a := assert.New(t)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	log = zap.NewNop()
+	b := &Bridge{federation: &FederationConfig{NodeName: "local", MaxHops: 2}}
+	client := server.NewMockClient(ctrl)
+	client.EXPECT().ClientOptions().Return(&server.ClientOptions{ClientID: "cid"}).AnyTimes()
+	fn := b.OnMsgArrivedWrapper(func(ctx context.Context, client server.Client, req *server.MsgArrivedRequest) error {
+		return nil
+	})
+	for _, v := range []struct {
+		props    []string
+		accepted bool
+	}{
+		{accepted: true},
+		{props: []string{OriginProperty, "other", HopsProperty, "2"}, accepted: true},
+		{props: []string{OriginProperty, "local", HopsProperty, "1"}},
+		{props: []string{OriginProperty, "other", HopsProperty, "3"}},
+		{props: []string{HopsProperty, "invalid"}},
+	} {
+		msg := &gmqtt.Message{Topic: "a"}
+		for i := 0; i < len(v.props); i += 2 {
+			msg.UserProperties = append(msg.UserProperties, packets.UserProperty{K: []byte(v.props[i]), V: []byte(v.props[i+1])})
+		}
+		req := &server.MsgArrivedRequest{Message: msg}
+		a.NoError(fn(context.Background(), client, req))
+		a.Equal(v.accepted, req.Message != nil, v.props)
+	}
+}
+
+func TestSASToken(t *testing.T) {
+	a := assert.New(t)
+	key := base64.StdEncoding.EncodeToString([]byte("secret"))
+	token, err := sasToken("hub.azure-devices.net/devices/dev1", key, "device", time.Unix(1600000000, 0))
+	a.NoError(err)
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write([]byte("hub.azure-devices.net%2Fdevices%2Fdev1\n1600000000"))
+	sig := url.QueryEscape(base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+	a.Equal("SharedAccessSignature sr=hub.azure-devices.net%2Fdevices%2Fdev1&sig="+sig+"&se=1600000000&skn=device", token)
+
+	_, err = sasToken("hub", "not base64!", "", time.Now())
+	a.Error(err)
+}
+
+func TestBridge_PublishPacket(t *testing.T) {
+	a := assert.New(t)
+	cfg := DefaultBridgeConfig
+	cfg.Profile = ProfileAWSIoT
+	b := newBridge(&cfg, nil, "")
+	topic := &TopicConfig{QoS: packets.Qos2}
+
+	p, err := b.publishPacket(&queued{msg: &gmqtt.Message{Topic: "a/b", QoS: packets.Qos2, Retained: true}, topic: topic})
+	a.NoError(err)
+	a.Equal(packets.Qos1, p.Qos)
+	a.True(p.Retain)
+
+	_, err = b.publishPacket(&queued{msg: &gmqtt.Message{Topic: "1/2/3/4/5/6/7/8/9"}, topic: topic})
+	a.Error(err)
+	_, err = b.publishPacket(&queued{msg: &gmqtt.Message{Topic: strings.Repeat("a", 257)}, topic: topic})
+	a.Error(err)
+	_, err = b.publishPacket(&queued{msg: &gmqtt.Message{Topic: "a", Payload: make([]byte, 128*1024+1)}, topic: topic})
+	a.Error(err)
+	_, err = b.publishPacket(&queued{msg: &gmqtt.Message{Topic: "$SYS/a"}, topic: topic})
+	a.Error(err)
+	_, err = b.publishPacket(&queued{msg: &gmqtt.Message{Topic: "$aws/things/a/shadow/update"}, topic: topic})
+	a.NoError(err)
+
+	cfg = DefaultBridgeConfig
+	cfg.Profile = ProfileAzureIoTHub
+	cfg.Azure.DeviceID = "dev1"
+	cfg.TimestampProperty = "received-at"
+	b = newBridge(&cfg, nil, "")
+	p, err = b.publishPacket(&queued{msg: &gmqtt.Message{Topic: "a/b"}, topic: topic, receivedAt: time.Unix(1600000000, 0)})
+	a.NoError(err)
+	a.Equal("devices/dev1/messages/events/mqtt-topic=a%2Fb&received-at=1600000000000", string(p.TopicName))
+}
+
+func TestConfig_Validate(t *testing.T) {
+	valid := func(profile string) BridgeConfig {
+		cfg := DefaultBridgeConfig
+		cfg.Name = "b"
+		cfg.Profile = profile
+		cfg.Address = "example.com:8883"
+		cfg.Topics = []TopicConfig{{Filter: "a/#", QoS: 1}}
+		switch profile {
+		case ProfileGeneric:
+			cfg.ClientID = "edge"
+		case ProfileAWSIoT:
+			cfg.ClientID = "edge"
+			cfg.TLS = TLSConfig{Enable: true, Cert: "cert.pem", Key: "key.pem"}
+		case ProfileAzureIoTHub:
+			cfg.TLS.Enable = true
+			cfg.Azure.DeviceID = "dev1"
+			cfg.Azure.SharedAccessKey = "a2V5"
+		}
+		return cfg
+	}
+	var tt = []struct {
+		name   string
+		modify func(b *BridgeConfig)
+		valid  bool
+	}{
+		{name: ProfileGeneric, modify: func(b *BridgeConfig) {}, valid: true},
+		{name: ProfileAWSIoT, modify: func(b *BridgeConfig) {}, valid: true},
+		{name: ProfileAzureIoTHub, modify: func(b *BridgeConfig) {}, valid: true},
+		{name: ProfileGeneric, modify: func(b *BridgeConfig) { b.Topics = nil }},
+		{name: ProfileGeneric, modify: func(b *BridgeConfig) { b.Topics[0].Filter = "$share/g/a" }},
+		{name: ProfileGeneric, modify: func(b *BridgeConfig) { b.Profile = "unknown" }},
+		{name: ProfileGeneric, modify: func(b *BridgeConfig) { b.Proxy = "socks5://proxy:1080" }, valid: true},
+		{name: ProfileGeneric, modify: func(b *BridgeConfig) { b.Proxy = "ftp://proxy:21" }},
+		{name: ProfileAWSIoT, modify: func(b *BridgeConfig) { b.TLS.Enable = false }},
+		{name: ProfileAWSIoT, modify: func(b *BridgeConfig) { b.KeepAlive = 10 * time.Second }},
+		{name: ProfileAWSIoT, modify: func(b *BridgeConfig) { b.TLS.Cert = "" }},
+		{name: ProfileAWSIoT, modify: func(b *BridgeConfig) { b.TLS.Cert = ""; b.Username = "authorizer" }, valid: true},
+		{name: ProfileAzureIoTHub, modify: func(b *BridgeConfig) { b.ClientID = "other" }},
+		{name: ProfileAzureIoTHub, modify: func(b *BridgeConfig) { b.Password = "pass" }},
+		{name: ProfileAzureIoTHub, modify: func(b *BridgeConfig) { b.Azure.SharedAccessKey = "not base64!" }},
+		{name: ProfileGeneric, modify: func(b *BridgeConfig) { b.ProtocolVersion = 3 }},
+		{name: ProfileGeneric, modify: func(b *BridgeConfig) { b.TimestampProperty = "ts" }},
+		{name: ProfileGeneric, modify: func(b *BridgeConfig) { b.TimestampProperty = "ts"; b.ProtocolVersion = 5 }, valid: true},
+		{name: ProfileAzureIoTHub, modify: func(b *BridgeConfig) { b.TimestampProperty = "ts" }, valid: true},
+		{name: ProfileAzureIoTHub, modify: func(b *BridgeConfig) { b.ProtocolVersion = 5 }},
+		{name: ProfileGeneric, modify: func(b *BridgeConfig) { b.StoreAndForward.Enable = true }, valid: true},
+		{name: ProfileGeneric, modify: func(b *BridgeConfig) { b.StoreAndForward.Enable = true; b.StoreAndForward.MaxBytes = 1024 }},
+		{name: ProfileGeneric, modify: func(b *BridgeConfig) { b.StoreAndForward.Enable = true; b.Name = "a/b" }},
+		{name: ProfileGeneric, modify: func(b *BridgeConfig) { b.Topics[0].Direction = DirectionIn }, valid: true},
+		{name: ProfileGeneric, modify: func(b *BridgeConfig) { b.Topics[0].Direction = "up" }},
+		{name: ProfileGeneric, modify: func(b *BridgeConfig) { b.Topics[0].Direction = DirectionBoth }},
+		{name: ProfileGeneric, modify: func(b *BridgeConfig) { b.Topics[0].Direction = DirectionBoth; b.ProtocolVersion = 5 }, valid: true},
+		{name: ProfileGeneric, modify: func(b *BridgeConfig) { b.Topics[0].Direction = DirectionIn; b.Topics[0].RemotePrefix = "+/" }},
+		{name: ProfileGeneric, modify: func(b *BridgeConfig) { b.Topics[0].Direction = DirectionIn; b.Topics[0].RemotePrefix = "a//" }, valid: true},
+		{name: ProfileAWSIoT, modify: func(b *BridgeConfig) { b.Topics[0].Direction = DirectionIn }, valid: true},
+		{name: ProfileAzureIoTHub, modify: func(b *BridgeConfig) { b.Topics[0].Direction = DirectionIn }},
+		{name: ProfileGeneric, modify: func(b *BridgeConfig) { b.Federation = true }},
+		{name: ProfileGeneric, modify: func(b *BridgeConfig) { b.Federation = true; b.ProtocolVersion = 5 }},
+		{name: ProfileAzureIoTHub, modify: func(b *BridgeConfig) {
+			b.Azure.SharedAccessKey = ""
+			b.TLS.Cert, b.TLS.Key = "cert.pem", "key.pem"
+		}, valid: true},
+	}
+	for k, v := range tt {
+		b := valid(v.name)
+		v.modify(&b)
+		c := &Config{Bridges: []BridgeConfig{b}}
+		if v.valid {
+			assert.NoError(t, c.Validate(), k)
+		} else {
+			assert.Error(t, c.Validate(), k)
+		}
+	}
+	b := valid(ProfileGeneric)
+	assert.Error(t, (&Config{Bridges: []BridgeConfig{b, b}}).Validate())
+	// the federation links require the node name.
+	b.Federation = true
+	b.ProtocolVersion = 5
+	assert.NoError(t, (&Config{Federation: FederationConfig{NodeName: "n", MaxHops: 1}, Bridges: []BridgeConfig{b}}).Validate())
+	assert.Error(t, (&Config{Federation: FederationConfig{NodeName: "n"}, Bridges: []BridgeConfig{b}}).Validate())
+}
+
+func TestBuildTLSConfig(t *testing.T) {
+	a := assert.New(t)
+	cfg := DefaultBridgeConfig
+	cfg.Profile = ProfileAWSIoT
+	cfg.Address = "abc-ats.iot.us-east-1.amazonaws.com:443"
+	cfg.TLS.Enable = true
+	c, err := buildTLSConfig(&cfg, "", config.DefaultCrypto())
+	a.NoError(err)
+	a.Equal("abc-ats.iot.us-east-1.amazonaws.com", c.ServerName)
+	a.Equal([]string{awsALPN}, c.NextProtos)
+
+	cfg.Address = "abc-ats.iot.us-east-1.amazonaws.com:8883"
+	cfg.TLS.ServerName = "custom.example.com"
+	c, err = buildTLSConfig(&cfg, "", config.DefaultCrypto())
+	a.NoError(err)
+	a.Equal("custom.example.com", c.ServerName)
+	a.Empty(c.NextProtos)
+
+	cfg.TLS.Enable = false
+	c, err = buildTLSConfig(&cfg, "", config.DefaultCrypto())
+	a.NoError(err)
+	a.Nil(c)
+}
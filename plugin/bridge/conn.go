@@ -0,0 +1,285 @@
+package bridge
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/DrmagicE/gmqtt/pkg/codes"
+	"github.com/DrmagicE/gmqtt/pkg/packets"
+	"github.com/DrmagicE/gmqtt/pkg/proxy"
+)
+
+var (
+	errAckTimeout    = errors.New("puback timeout")
+	errSubackTimeout = errors.New("suback timeout")
+)
+
+// rejectedError is returned by publish if the MQTT 5 remote broker rejects the message by the PUBACK.
+type rejectedError struct {
+	code codes.Code
+}
+
+func (e *rejectedError) Error() string {
+	return fmt.Sprintf("rejected by the remote broker, reason code: 0x%x", e.code)
+}
+
+// connectOptions is the options to connect to the remote broker.
+type connectOptions struct {
+	address string
+	// version is packets.Version311 or packets.Version5.
+	version   packets.Version
+	tlsConfig *tls.Config
+	// proxy is the forward proxy to connect through, see proxy.NewDialer.
+	proxy        string
+	clientID     string
+	username     string
+	password     string
+	cleanSession bool
+	keepAlive    time.Duration
+	timeout      time.Duration
+	// onPublish handles the messages of the subscriptions, the PUBLISH packets are unexpected if it is nil.
+	onPublish func(p *packets.Publish)
+}
+
+// conn is a minimal MQTT 3.1.1 or 5 client connection which publishes the messages with QoS 0 or 1,
+// and receives the messages of its subscriptions with QoS 0 or 1.
+// The publish, subscribe and ping methods must be called in the same goroutine.
+type conn struct {
+	version   packets.Version
+	rwc       net.Conn
+	r         *packets.Reader
+	w         *packets.Writer
+	keepAlive time.Duration
+	timeout   time.Duration
+	pid       packets.PacketID
+	onPublish func(p *packets.Publish)
+	// wmu guards w and lastWrite, the PUBACK of the received messages is written by the read loop.
+	wmu       sync.Mutex
+	lastWrite time.Time
+	acks      chan *packets.Puback
+	subacks   chan *packets.Suback
+	// done is closed when the read loop exits, err is set before that.
+	done chan struct{}
+	err  error
+}
+
+func connect(opts *connectOptions) (*conn, error) {
+	dialer, err := proxy.NewDialer(opts.proxy, opts.timeout)
+	if err != nil {
+		return nil, err
+	}
+	rwc, err := dialer.Dial("tcp", opts.address)
+	if err != nil {
+		return nil, err
+	}
+	_ = rwc.SetDeadline(time.Now().Add(opts.timeout))
+	if opts.tlsConfig != nil {
+		tlsConn := tls.Client(rwc, opts.tlsConfig)
+		if err = tlsConn.Handshake(); err != nil {
+			_ = rwc.Close()
+			return nil, err
+		}
+		rwc = tlsConn
+	}
+	c := &conn{
+		version:   opts.version,
+		rwc:       rwc,
+		r:         packets.NewReader(rwc),
+		w:         packets.NewWriter(rwc),
+		keepAlive: opts.keepAlive,
+		timeout:   opts.timeout,
+		onPublish: opts.onPublish,
+		acks:      make(chan *packets.Puback, 1),
+		subacks:   make(chan *packets.Suback, 1),
+		done:      make(chan struct{}),
+	}
+	c.r.SetVersion(opts.version)
+	err = c.w.WriteAndFlush(&packets.Connect{
+		Version:       opts.version,
+		ProtocolName:  []byte("MQTT"),
+		ProtocolLevel: opts.version,
+		CleanStart:    opts.cleanSession,
+		KeepAlive:     uint16(opts.keepAlive / time.Second),
+		ClientID:      []byte(opts.clientID),
+		UsernameFlag:  opts.username != "",
+		Username:      []byte(opts.username),
+		PasswordFlag:  opts.password != "",
+		Password:      []byte(opts.password),
+	})
+	if err == nil {
+		err = c.readConnack()
+	}
+	if err != nil {
+		_ = rwc.Close()
+		return nil, err
+	}
+	_ = rwc.SetDeadline(time.Time{})
+	c.lastWrite = time.Now()
+	go c.readLoop()
+	return c, nil
+}
+
+func (c *conn) readConnack() error {
+	p, err := c.r.ReadPacket()
+	if err != nil {
+		return err
+	}
+	connack, ok := p.(*packets.Connack)
+	if !ok {
+		return fmt.Errorf("unexpected packet: %s", p)
+	}
+	if connack.Code != 0 {
+		return fmt.Errorf("connection refused, return code: %d", connack.Code)
+	}
+	return nil
+}
+
+func (c *conn) readLoop() {
+	defer close(c.done)
+	for {
+		if c.keepAlive > 0 {
+			_ = c.rwc.SetReadDeadline(time.Now().Add(c.keepAlive * 3 / 2))
+		}
+		p, err := c.r.ReadPacket()
+		if err != nil {
+			c.err = err
+			return
+		}
+		switch p := p.(type) {
+		case *packets.Puback:
+			select {
+			case c.acks <- p:
+			default:
+				// the late ack of a timed out message.
+			}
+		case *packets.Suback:
+			select {
+			case c.subacks <- p:
+			default:
+			}
+		case *packets.Publish:
+			// the subscriptions are made with at most QoS 1.
+			if c.onPublish == nil || p.Qos > packets.Qos1 {
+				c.err = fmt.Errorf("unexpected packet: %s", p)
+				return
+			}
+			c.onPublish(p)
+			if p.Qos == packets.Qos1 {
+				if err = c.write(p.NewPuback(codes.Success, nil), c.timeout); err != nil {
+					c.err = err
+					return
+				}
+			}
+		case *packets.Pingresp:
+		case *packets.Disconnect:
+			c.err = fmt.Errorf("disconnected by the remote broker, reason code: 0x%x", p.Code)
+			return
+		default:
+			c.err = fmt.Errorf("unexpected packet: %s", p)
+			return
+		}
+	}
+}
+
+func (c *conn) nextPacketID() packets.PacketID {
+	c.pid++
+	if c.pid == 0 {
+		c.pid = 1
+	}
+	return c.pid
+}
+
+// publish publishes the message and waits for the PUBACK if the QoS is 1.
+func (c *conn) publish(p *packets.Publish, timeout time.Duration) error {
+	if p.Qos > packets.Qos0 {
+		p.PacketID = c.nextPacketID()
+	}
+	if err := c.write(p, timeout); err != nil {
+		return err
+	}
+	if p.Qos == packets.Qos0 {
+		return nil
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		select {
+		case ack := <-c.acks:
+			if ack.PacketID != p.PacketID {
+				continue
+			}
+			if ack.Code >= codes.UnspecifiedError {
+				return &rejectedError{code: ack.Code}
+			}
+			return nil
+		case <-c.done:
+			return c.err
+		case <-timer.C:
+			return errAckTimeout
+		}
+	}
+}
+
+// subscribe subscribes the topic filters and returns the reason codes of the SUBACK.
+func (c *conn) subscribe(topics []packets.Topic, timeout time.Duration) ([]codes.Code, error) {
+	p := &packets.Subscribe{
+		Version:    c.version,
+		PacketID:   c.nextPacketID(),
+		Topics:     topics,
+		Properties: &packets.Properties{},
+	}
+	if err := c.write(p, timeout); err != nil {
+		return nil, err
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		select {
+		case ack := <-c.subacks:
+			if ack.PacketID != p.PacketID {
+				continue
+			}
+			if len(ack.Payload) != len(topics) {
+				return nil, fmt.Errorf("invalid suback: %d reason codes for %d topic filters", len(ack.Payload), len(topics))
+			}
+			return ack.Payload, nil
+		case <-c.done:
+			return nil, c.err
+		case <-timer.C:
+			return nil, errSubackTimeout
+		}
+	}
+}
+
+// ping sends the PINGREQ if nothing is written in the last half keep alive interval.
+func (c *conn) ping(now time.Time, timeout time.Duration) error {
+	c.wmu.Lock()
+	lastWrite := c.lastWrite
+	c.wmu.Unlock()
+	if c.keepAlive == 0 || now.Sub(lastWrite) < c.keepAlive/2 {
+		return nil
+	}
+	return c.write(&packets.Pingreq{}, timeout)
+}
+
+func (c *conn) write(p packets.Packet, timeout time.Duration) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	_ = c.rwc.SetWriteDeadline(time.Now().Add(timeout))
+	if err := c.w.WriteAndFlush(p); err != nil {
+		return err
+	}
+	c.lastWrite = time.Now()
+	return nil
+}
+
+// close sends the DISCONNECT and closes the connection.
+func (c *conn) close(timeout time.Duration) {
+	_ = c.write(&packets.Disconnect{Version: c.version}, timeout)
+	_ = c.rwc.Close()
+	<-c.done
+}
@@ -0,0 +1,69 @@
+package bridge
+
+import (
+	"strconv"
+
+	"github.com/DrmagicE/gmqtt/pkg/packets"
+)
+
+const (
+	// OriginProperty is the user property which carries the node name of the broker where the message entered the federation.
+	OriginProperty = "federation-origin"
+	// HopsProperty is the user property which carries the number of the federation links that the message has traversed.
+	HopsProperty = "federation-hops"
+)
+
+// parseFederation returns the origin and the hop count of the message,
+// the origin is empty if the message has not been relayed by any federation link.
+// ok is false if the hop count is malformed.
+func parseFederation(props []packets.UserProperty) (origin string, hops int, ok bool) {
+	for _, v := range props {
+		switch string(v.K) {
+		case OriginProperty:
+			origin = string(v.V)
+		case HopsProperty:
+			n, err := strconv.Atoi(string(v.V))
+			if err != nil || n < 0 {
+				return origin, 0, false
+			}
+			hops = n
+		}
+	}
+	return origin, hops, true
+}
+
+// withFederation returns a copy of props with the origin and the hop count replaced.
+func withFederation(props []packets.UserProperty, origin string, hops int) []packets.UserProperty {
+	rs := make([]packets.UserProperty, 0, len(props)+2)
+	for _, v := range props {
+		if k := string(v.K); k != OriginProperty && k != HopsProperty {
+			rs = append(rs, v)
+		}
+	}
+	if origin != "" {
+		rs = append(rs, packets.UserProperty{K: []byte(OriginProperty), V: []byte(origin)})
+	}
+	return append(rs, packets.UserProperty{K: []byte(HopsProperty), V: []byte(strconv.Itoa(hops))})
+}
+
+// accept reports whether the message of the origin which has traversed hops federation links is accepted,
+// the messages routed back to this broker or exceeding the maximum hops are dropped.
+func (f *FederationConfig) accept(origin string, hops int) bool {
+	return origin != f.NodeName && hops <= f.MaxHops
+}
+
+// relayable reports whether the local message can be relayed over a federation link without exceeding the maximum hops.
+func (f *FederationConfig) relayable(props []packets.UserProperty) bool {
+	_, hops, ok := parseFederation(props)
+	return ok && hops < f.MaxHops
+}
+
+// stamp returns the user properties of the local message relayed over a federation link,
+// this broker becomes the origin if the message has not been relayed by any federation link.
+func (f *FederationConfig) stamp(props []packets.UserProperty) []packets.UserProperty {
+	origin, hops, _ := parseFederation(props)
+	if origin == "" {
+		origin = f.NodeName
+	}
+	return withFederation(props, origin, hops+1)
+}
@@ -0,0 +1,39 @@
+package bridge
+
+import (
+	"context"
+
+	"go.uber.org/zap"
+
+	"github.com/DrmagicE/gmqtt/server"
+)
+
+func (b *Bridge) HookWrapper() server.HookWrapper {
+	if b.federation == nil {
+		return server.HookWrapper{}
+	}
+	return server.HookWrapper{
+		OnMsgArrivedWrapper: b.OnMsgArrivedWrapper,
+	}
+}
+
+// OnMsgArrivedWrapper drops the messages published by the federation links of other brokers
+// which are routed back to this broker or exceed the maximum hops.
+func (b *Bridge) OnMsgArrivedWrapper(pre server.OnMsgArrived) server.OnMsgArrived {
+	return func(ctx context.Context, client server.Client, req *server.MsgArrivedRequest) error {
+		err := pre(ctx, client, req)
+		if err != nil || req.Message == nil {
+			return err
+		}
+		origin, hops, ok := parseFederation(req.Message.UserProperties)
+		if !ok || !b.federation.accept(origin, hops) {
+			log.Debug("looped message dropped",
+				zap.String("client_id", client.ClientOptions().ClientID),
+				zap.String("topic", req.Message.Topic),
+				zap.String("origin", origin),
+				zap.Int("hops", hops))
+			req.Drop()
+		}
+		return nil
+	}
+}
@@ -0,0 +1,133 @@
+package bridge
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/DrmagicE/gmqtt/pkg/packets"
+)
+
+const (
+	// awsALPN is the ALPN protocol required by AWS IoT for MQTT with the client certificate on port 443.
+	awsALPN = "x-amzn-mqtt-ca"
+	// azureAPIVersion is the api-version carried in the username of Azure IoT Hub.
+	azureAPIVersion = "2021-04-12"
+	// azureTopicProperty is the application property which carries the relayed topic in the Azure IoT Hub message.
+	azureTopicProperty = "mqtt-topic"
+)
+
+// profile describes the constraints of the remote broker.
+type profile struct {
+	// maxQoS is the maximum QoS the bridge publishes with, the bridge does not relay with QoS 2.
+	maxQoS uint8
+	// retain indicates whether the remote broker supports the retained messages.
+	retain bool
+	// maxTopicLength is the maximum length of the remote topic in bytes, 0 means no limit.
+	maxTopicLength int
+	// maxTopicLevels is the maximum number of the remote topic levels, 0 means no limit.
+	maxTopicLevels int
+	// maxPayloadSize is the maximum payload size in bytes, 0 means no limit.
+	maxPayloadSize int
+	// allowReserved reports whether the remote topic which starts with '$' is allowed.
+	allowReserved func(topic string) bool
+	// remoteTopic maps the topic to the remote topic, nil means no mapping.
+	remoteTopic func(topic string) string
+	// propertyBag indicates whether the application properties are appended to the remote topic.
+	propertyBag bool
+}
+
+func newProfile(cfg *BridgeConfig) *profile {
+	switch cfg.Profile {
+	case ProfileAWSIoT:
+		// https://docs.aws.amazon.com/general/latest/gr/iot-core.html#message-broker-limits
+		return &profile{
+			maxQoS:         packets.Qos1,
+			retain:         true,
+			maxTopicLength: 256,
+			maxTopicLevels: 8,
+			maxPayloadSize: 128 * 1024,
+			allowReserved: func(topic string) bool {
+				return strings.HasPrefix(topic, "$aws/")
+			},
+		}
+	case ProfileAzureIoTHub:
+		// https://docs.microsoft.com/azure/iot-hub/iot-hub-mqtt-support
+		prefix := "devices/" + cfg.Azure.DeviceID + "/messages/events/" + azureTopicProperty + "="
+		return &profile{
+			maxQoS:         packets.Qos1,
+			maxPayloadSize: 256 * 1024,
+			propertyBag:    true,
+			remoteTopic: func(topic string) string {
+				return prefix + url.QueryEscape(topic)
+			},
+		}
+	default:
+		return &profile{
+			maxQoS: packets.Qos1,
+			retain: true,
+		}
+	}
+}
+
+// check returns an error if the message violates the constraints of the remote broker.
+func (p *profile) check(topic string, payload []byte) error {
+	if p.maxTopicLength != 0 && len(topic) > p.maxTopicLength {
+		return fmt.Errorf("topic length %d exceeds the limit %d", len(topic), p.maxTopicLength)
+	}
+	if n := strings.Count(topic, "/") + 1; p.maxTopicLevels != 0 && n > p.maxTopicLevels {
+		return fmt.Errorf("topic levels %d exceeds the limit %d", n, p.maxTopicLevels)
+	}
+	if p.maxPayloadSize != 0 && len(payload) > p.maxPayloadSize {
+		return fmt.Errorf("payload size %d exceeds the limit %d", len(payload), p.maxPayloadSize)
+	}
+	if strings.HasPrefix(topic, "$") && (p.allowReserved == nil || !p.allowReserved(topic)) {
+		return fmt.Errorf("reserved topic")
+	}
+	return nil
+}
+
+// credentials returns the client id, username and password to connect,
+// and the time when the credentials expire, zero time means never.
+func credentials(cfg *BridgeConfig, now time.Time) (clientID, username, password string, expiry time.Time, err error) {
+	if cfg.Profile != ProfileAzureIoTHub {
+		return cfg.ClientID, cfg.Username, cfg.Password, time.Time{}, nil
+	}
+	host, _, _ := net.SplitHostPort(cfg.Address)
+	if cfg.TLS.ServerName != "" {
+		host = cfg.TLS.ServerName
+	}
+	clientID = cfg.Azure.DeviceID
+	username = host + "/" + clientID + "/?api-version=" + azureAPIVersion
+	if cfg.Azure.SharedAccessKey == "" {
+		return clientID, username, "", time.Time{}, nil
+	}
+	expiry = now.Add(cfg.Azure.TokenTTL)
+	password, err = sasToken(host+"/devices/"+clientID, cfg.Azure.SharedAccessKey, cfg.Azure.KeyName, expiry)
+	return clientID, username, password, expiry, err
+}
+
+// sasToken generates the Azure shared access signature token.
+// https://docs.microsoft.com/azure/iot-hub/iot-hub-dev-guide-sas#security-tokens
+func sasToken(resourceURI, key, keyName string, expiry time.Time) (string, error) {
+	k, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return "", err
+	}
+	sr := url.QueryEscape(resourceURI)
+	se := strconv.FormatInt(expiry.Unix(), 10)
+	mac := hmac.New(sha256.New, k)
+	mac.Write([]byte(sr + "\n" + se))
+	sig := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	token := "SharedAccessSignature sr=" + sr + "&sig=" + url.QueryEscape(sig) + "&se=" + se
+	if keyName != "" {
+		token += "&skn=" + url.QueryEscape(keyName)
+	}
+	return token, nil
+}
@@ -0,0 +1,322 @@
+package bridge
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	errSpoolFull    = errors.New("spool is full")
+	errSpoolCorrupt = errors.New("corrupt record")
+)
+
+const (
+	spoolSegmentExt = ".seg"
+	spoolAckFile    = "ack"
+	// spoolHeaderLen is the length of the record header: the uint32 length and the uint32 crc32 of the data.
+	spoolHeaderLen = 8
+	// spoolAckLen is the length of the ack file: the uint64 segment id, the uint64 offset and the uint32 crc32 of them.
+	spoolAckLen = 20
+)
+
+// spool is the durable FIFO of the messages waiting to be relayed.
+// The records are appended to the segment files, and the position of the first unacknowledged record is saved
+// in the ack file, the segments before it are removed. The records are delivered at least once,
+// the acknowledged records may be delivered again if the broker crashes before the ack file is saved.
+// The torn record at the end of the last segment is truncated on open.
+type spool struct {
+	dir         string
+	maxBytes    int64
+	segmentSize int64
+	fsync       bool
+
+	mu sync.Mutex
+	// segments is the ids of the segment files in order, the first one is being read and the last one is being written.
+	segments []uint64
+	w        *os.File
+	wOff     int64
+	r        *os.File
+	// rOff is the position of the first unacknowledged record in the first segment,
+	// next is the position after the record returned by peek.
+	rOff int64
+	next int64
+	// size is the total size of the unacknowledged records.
+	size    int64
+	ackFile *os.File
+	// ready is signaled when a record is appended.
+	ready chan struct{}
+}
+
+func segmentName(id uint64) string {
+	return fmt.Sprintf("%016x%s", id, spoolSegmentExt)
+}
+
+func openSpool(dir string, maxBytes, segmentSize int64, fsync bool) (s *spool, err error) {
+	if err = os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	s = &spool{
+		dir:         dir,
+		maxBytes:    maxBytes,
+		segmentSize: segmentSize,
+		fsync:       fsync,
+		ready:       make(chan struct{}, 1),
+	}
+	defer func() {
+		if err != nil {
+			s.close()
+		}
+	}()
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range files {
+		name := v.Name()
+		if !strings.HasSuffix(name, spoolSegmentExt) {
+			continue
+		}
+		id, err := strconv.ParseUint(strings.TrimSuffix(name, spoolSegmentExt), 16, 64)
+		if err != nil {
+			continue
+		}
+		s.segments = append(s.segments, id)
+	}
+	sort.Slice(s.segments, func(i, j int) bool {
+		return s.segments[i] < s.segments[j]
+	})
+	s.ackFile, err = os.OpenFile(filepath.Join(dir, spoolAckFile), os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+	ackSeg, ackOff := s.loadAck()
+	// remove the segments which have been acknowledged.
+	for len(s.segments) > 0 && s.segments[0] < ackSeg {
+		_ = os.Remove(filepath.Join(dir, segmentName(s.segments[0])))
+		s.segments = s.segments[1:]
+	}
+	if len(s.segments) == 0 {
+		s.segments = []uint64{ackSeg + 1}
+	}
+	if s.segments[0] == ackSeg {
+		s.rOff = ackOff
+	}
+	last := s.segments[len(s.segments)-1]
+	s.w, err = os.OpenFile(filepath.Join(dir, segmentName(last)), os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+	// find the end of the last complete record.
+	if len(s.segments) == 1 {
+		s.wOff = s.rOff
+	}
+	for {
+		_, n, err := readRecord(s.w, s.wOff, s.maxBytes)
+		if err != nil {
+			break
+		}
+		s.wOff += n
+	}
+	if err = s.w.Truncate(s.wOff); err != nil {
+		return nil, err
+	}
+	if _, err = s.w.Seek(s.wOff, io.SeekStart); err != nil {
+		return nil, err
+	}
+	s.r, err = os.Open(filepath.Join(dir, segmentName(s.segments[0])))
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range s.segments[:len(s.segments)-1] {
+		fi, err := os.Stat(filepath.Join(dir, segmentName(id)))
+		if err != nil {
+			return nil, err
+		}
+		s.size += fi.Size()
+	}
+	s.size += s.wOff - s.rOff
+	s.next = s.rOff
+	return s, nil
+}
+
+// readRecord reads the record at the offset, it returns the data and the length of the record.
+func readRecord(f *os.File, off int64, maxBytes int64) ([]byte, int64, error) {
+	var h [spoolHeaderLen]byte
+	if _, err := f.ReadAt(h[:], off); err != nil {
+		return nil, 0, io.EOF
+	}
+	l := int64(binary.BigEndian.Uint32(h[:4]))
+	if l > maxBytes {
+		return nil, 0, errSpoolCorrupt
+	}
+	data := make([]byte, l)
+	if _, err := f.ReadAt(data, off+spoolHeaderLen); err != nil {
+		return nil, 0, io.EOF
+	}
+	if crc32.ChecksumIEEE(data) != binary.BigEndian.Uint32(h[4:]) {
+		return nil, 0, errSpoolCorrupt
+	}
+	return data, spoolHeaderLen + l, nil
+}
+
+// loadAck returns the acknowledged position, it starts from the beginning if the ack file is missing or corrupt.
+func (s *spool) loadAck() (seg uint64, off int64) {
+	var b [spoolAckLen]byte
+	if _, err := s.ackFile.ReadAt(b[:], 0); err != nil {
+		return 0, 0
+	}
+	if crc32.ChecksumIEEE(b[:16]) != binary.BigEndian.Uint32(b[16:]) {
+		return 0, 0
+	}
+	return binary.BigEndian.Uint64(b[:8]), int64(binary.BigEndian.Uint64(b[8:16]))
+}
+
+func (s *spool) saveAck() error {
+	var b [spoolAckLen]byte
+	binary.BigEndian.PutUint64(b[:8], s.segments[0])
+	binary.BigEndian.PutUint64(b[8:16], uint64(s.rOff))
+	binary.BigEndian.PutUint32(b[16:], crc32.ChecksumIEEE(b[:16]))
+	if _, err := s.ackFile.WriteAt(b[:], 0); err != nil {
+		return err
+	}
+	if s.fsync {
+		return s.ackFile.Sync()
+	}
+	return nil
+}
+
+// append appends the record, it returns errSpoolFull if the total size exceeds the limit.
+func (s *spool) append(data []byte) error {
+	n := int64(spoolHeaderLen + len(data))
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.size+n > s.maxBytes {
+		return errSpoolFull
+	}
+	if s.wOff > 0 && s.wOff+n > s.segmentSize {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+	b := make([]byte, n)
+	binary.BigEndian.PutUint32(b[:4], uint32(len(data)))
+	binary.BigEndian.PutUint32(b[4:8], crc32.ChecksumIEEE(data))
+	copy(b[spoolHeaderLen:], data)
+	if _, err := s.w.Write(b); err != nil {
+		// drop the partially written record.
+		_ = s.w.Truncate(s.wOff)
+		_, _ = s.w.Seek(s.wOff, io.SeekStart)
+		return err
+	}
+	if s.fsync {
+		if err := s.w.Sync(); err != nil {
+			return err
+		}
+	}
+	s.wOff += n
+	s.size += n
+	select {
+	case s.ready <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// rotate starts a new segment to write.
+func (s *spool) rotate() error {
+	id := s.segments[len(s.segments)-1] + 1
+	f, err := os.OpenFile(filepath.Join(s.dir, segmentName(id)), os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	_ = s.w.Close()
+	s.w = f
+	s.wOff = 0
+	s.segments = append(s.segments, id)
+	return nil
+}
+
+// peek returns the first unacknowledged record, nil if the spool is empty.
+// It returns the same record until ack is called.
+func (s *spool) peek() ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for {
+		data, n, err := readRecord(s.r, s.rOff, s.maxBytes)
+		if err == nil {
+			s.next = s.rOff + n
+			return data, nil
+		}
+		if len(s.segments) == 1 {
+			return nil, nil
+		}
+		// the end of the segment, or the rest of it is unreadable.
+		if err = s.nextSegment(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// nextSegment removes the first segment and starts reading the next one.
+func (s *spool) nextSegment() error {
+	fi, err := s.r.Stat()
+	if err != nil {
+		return err
+	}
+	r, err := os.Open(filepath.Join(s.dir, segmentName(s.segments[1])))
+	if err != nil {
+		return err
+	}
+	old := s.segments[0]
+	_ = s.r.Close()
+	s.r = r
+	s.size -= fi.Size() - s.rOff
+	s.segments = s.segments[1:]
+	s.rOff, s.next = 0, 0
+	// a crash between saving and removing leaves the old segment, which is removed on open.
+	if err = s.saveAck(); err != nil {
+		return err
+	}
+	return os.Remove(filepath.Join(s.dir, segmentName(old)))
+}
+
+// ack acknowledges the record returned by peek.
+func (s *spool) ack() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.size -= s.next - s.rOff
+	s.rOff = s.next
+	if s.size > 0 {
+		// wake up the reader for the rest records.
+		select {
+		case s.ready <- struct{}{}:
+		default:
+		}
+	}
+	return s.saveAck()
+}
+
+// bytes returns the total size of the unacknowledged records.
+func (s *spool) bytes() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.size
+}
+
+func (s *spool) close() {
+	for _, f := range []*os.File{s.w, s.r, s.ackFile} {
+		if f != nil {
+			_ = f.Close()
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package bridge
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSpool(t *testing.T) {
+	a := assert.New(t)
+	dir, err := ioutil.TempDir("", "spool")
+	a.NoError(err)
+	defer os.RemoveAll(dir)
+
+	s, err := openSpool(dir, 100, 30, false)
+	a.NoError(err)
+	data, err := s.peek()
+	a.NoError(err)
+	a.Nil(data)
+
+	// each record takes 18 bytes, so that each segment holds one record.
+	for _, v := range []string{"0123456789", "abcdefghij", "ABCDEFGHIJ"} {
+		a.NoError(s.append([]byte(v)))
+	}
+	a.EqualValues(54, s.bytes())
+	files, _ := filepath.Glob(filepath.Join(dir, "*"+spoolSegmentExt))
+	a.Len(files, 3)
+
+	data, err = s.peek()
+	a.NoError(err)
+	a.Equal("0123456789", string(data))
+	// peek returns the same record until acknowledged.
+	data, _ = s.peek()
+	a.Equal("0123456789", string(data))
+	a.NoError(s.ack())
+	a.EqualValues(36, s.bytes())
+	data, _ = s.peek()
+	a.Equal("abcdefghij", string(data))
+	// the relayed segment is removed.
+	files, _ = filepath.Glob(filepath.Join(dir, "*"+spoolSegmentExt))
+	a.Len(files, 2)
+
+	// the unacknowledged record is delivered again after reopened.
+	s.close()
+	s, err = openSpool(dir, 100, 30, false)
+	a.NoError(err)
+	a.EqualValues(36, s.bytes())
+	data, _ = s.peek()
+	a.Equal("abcdefghij", string(data))
+	a.NoError(s.ack())
+
+	// the limit.
+	a.NoError(s.append(make([]byte, 64)))
+	a.Equal(errSpoolFull, s.append([]byte("xyz")))
+	s.close()
+
+	// the torn record at the end is truncated.
+	files, _ = filepath.Glob(filepath.Join(dir, "*"+spoolSegmentExt))
+	f, err := os.OpenFile(files[len(files)-1], os.O_APPEND|os.O_WRONLY, 0)
+	a.NoError(err)
+	_, err = f.Write([]byte{0, 0, 0, 10, 1})
+	a.NoError(err)
+	f.Close()
+	s, err = openSpool(dir, 100, 30, false)
+	a.NoError(err)
+	defer s.close()
+	data, _ = s.peek()
+	a.Equal("ABCDEFGHIJ", string(data))
+	a.NoError(s.ack())
+	data, _ = s.peek()
+	a.Len(data, 64)
+	a.NoError(s.ack())
+	data, err = s.peek()
+	a.NoError(err)
+	a.Nil(data)
+	a.EqualValues(0, s.bytes())
+}
@@ -0,0 +1,106 @@
+package certns
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"regexp"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"github.com/DrmagicE/gmqtt/config"
+	"github.com/DrmagicE/gmqtt/server"
+)
+
+var _ server.Plugin = (*Certns)(nil)
+
+const Name = "certns"
+
+func init() {
+	server.RegisterPlugin(Name, New)
+	config.RegisterDefaultPluginConfig(Name, &DefaultConfig)
+}
+
+func New(config config.Config) (server.Plugin, error) {
+	return &Certns{
+		config:          config.Plugins[Name].(*Config),
+		connectionState: server.TLSConnectionState,
+	}, nil
+}
+
+var log *zap.Logger
+
+var placeholderRegexp = regexp.MustCompile(`{[^{}]*}`)
+
+// placeholders maps the placeholder to the field of the certificate subject.
+var placeholders = map[string]func(cert *x509.Certificate) string{
+	"{CN}": func(cert *x509.Certificate) string {
+		return cert.Subject.CommonName
+	},
+	"{O}": func(cert *x509.Certificate) string {
+		return first(cert.Subject.Organization)
+	},
+	"{OU}": func(cert *x509.Certificate) string {
+		return first(cert.Subject.OrganizationalUnit)
+	},
+}
+
+func first(s []string) string {
+	if len(s) == 0 {
+		return ""
+	}
+	return s[0]
+}
+
+// Certns binds the client certificate to topic namespaces.
+// The client is only allowed to publish and subscribe to the topics in the namespaces derived from its certificate.
+type Certns struct {
+	config *Config
+	// connectionState returns the TLS connection state of the client connection.
+	connectionState func(conn net.Conn) (tls.ConnectionState, bool)
+}
+
+func (c *Certns) Load(service server.Server) error {
+	log = server.LoggerWithField(zap.String("plugin", Name))
+	return nil
+}
+
+func (c *Certns) Unload() error {
+	return nil
+}
+
+func (c *Certns) Name() string {
+	return Name
+}
+
+// namespaces returns the topic filters that the certificate is allowed to access.
+// A namespace is skipped if any of its placeholders is replaced by an empty value or a value that contains topic separators or wildcards,
+// so that the client can not escape from its namespace.
+func (c *Certns) namespaces(cert *x509.Certificate) []string {
+	var rs []string
+	for _, v := range c.config.Namespaces {
+		valid := true
+		filter := placeholderRegexp.ReplaceAllStringFunc(v, func(s string) string {
+			value := placeholders[s](cert)
+			if value == "" || strings.ContainsAny(value, "/+#") {
+				valid = false
+			}
+			return value
+		})
+		if valid {
+			rs = append(rs, filter)
+		}
+	}
+	return rs
+}
+
+// clientNamespaces returns the namespaces of the client.
+// If noCheck is true, the client is not restricted.
+func (c *Certns) clientNamespaces(client server.Client) (namespaces []string, noCheck bool) {
+	state, ok := c.connectionState(client.Connection())
+	if !ok || len(state.PeerCertificates) == 0 {
+		return nil, c.config.AllowNoCert
+	}
+	return c.namespaces(state.PeerCertificates[0]), false
+}
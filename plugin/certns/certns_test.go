@@ -0,0 +1,188 @@
+package certns
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+
+	"github.com/DrmagicE/gmqtt"
+	"github.com/DrmagicE/gmqtt/pkg/packets"
+	"github.com/DrmagicE/gmqtt/server"
+)
+
+func init() {
+	log = zap.NewNop()
+}
+
+func newCertns(cfg Config, cert *x509.Certificate) *Certns {
+	return &Certns{
+		config: &cfg,
+		connectionState: func(conn net.Conn) (tls.ConnectionState, bool) {
+			if cert == nil {
+				return tls.ConnectionState{}, false
+			}
+			return tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}, true
+		},
+	}
+}
+
+func newMockClient(ctrl *gomock.Controller) *server.MockClient {
+	client := server.NewMockClient(ctrl)
+	client.EXPECT().Connection().Return(nil).AnyTimes()
+	client.EXPECT().ClientOptions().Return(&server.ClientOptions{ClientID: "cid"}).AnyTimes()
+	return client
+}
+
+func TestConfig_Validate(t *testing.T) {
+	a := assert.New(t)
+	a.NoError(DefaultConfig.Validate())
+	a.NoError((&Config{Namespaces: []string{"{O}/{OU}/{CN}/+"}}).Validate())
+	a.Error((&Config{}).Validate())
+	a.Error((&Config{Namespaces: []string{"devices/{unknown}/#"}}).Validate())
+	a.Error((&Config{Namespaces: []string{"devices/#/{CN}"}}).Validate())
+}
+
+func TestCertns_namespaces(t *testing.T) {
+	a := assert.New(t)
+	c := newCertns(Config{Namespaces: []string{"devices/{CN}/#", "orgs/{O}/{OU}/#"}}, nil)
+	a.Equal([]string{"devices/dev1/#", "orgs/org/unit/#"}, c.namespaces(&x509.Certificate{
+		Subject: pkix.Name{
+			CommonName:         "dev1",
+			Organization:       []string{"org"},
+			OrganizationalUnit: []string{"unit"},
+		},
+	}))
+	// empty OU
+	a.Equal([]string{"devices/dev1/#"}, c.namespaces(&x509.Certificate{
+		Subject: pkix.Name{CommonName: "dev1", Organization: []string{"org"}},
+	}))
+	// wildcard in CN
+	a.Empty(c.namespaces(&x509.Certificate{
+		Subject: pkix.Name{CommonName: "#"},
+	}))
+	a.Empty(c.namespaces(&x509.Certificate{
+		Subject: pkix.Name{CommonName: "dev1/../dev2"},
+	}))
+}
+
+func TestCovered(t *testing.T) {
+	var tt = []struct {
+		namespace   string
+		topicFilter string
+		expected    bool
+	}{
+		{"devices/dev1/#", "devices/dev1/#", true},
+		{"devices/dev1/#", "devices/dev1", true},
+		{"devices/dev1/#", "devices/dev1/+/status", true},
+		{"devices/dev1/#", "devices/+/status", false},
+		{"devices/dev1/#", "devices/#", false},
+		{"devices/dev1/#", "devices/dev2/status", false},
+		{"devices/dev1/+", "devices/dev1/status", true},
+		{"devices/dev1/+", "devices/dev1/+", true},
+		{"devices/dev1/+", "devices/dev1/#", false},
+		{"devices/dev1/+", "devices/dev1/status/a", false},
+		{"devices/dev1/+", "devices/dev1", false},
+	}
+	for _, v := range tt {
+		assert.Equal(t, v.expected, covered(v.namespace, v.topicFilter), v.namespace+" "+v.topicFilter)
+	}
+}
+
+func TestCertns_OnSubscribeWrapper(t *testing.T) {
+	a := assert.New(t)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	c := newCertns(DefaultConfig, &x509.Certificate{Subject: pkix.Name{CommonName: "dev1"}})
+	fn := c.OnSubscribeWrapper(func(ctx context.Context, client server.Client, req *server.SubscribeRequest) error {
+		return nil
+	})
+	newSub := func(filter string) *struct {
+		Sub   *gmqtt.Subscription
+		Error error
+	} {
+		return &struct {
+			Sub   *gmqtt.Subscription
+			Error error
+		}{Sub: &gmqtt.Subscription{TopicFilter: filter}}
+	}
+	req := &server.SubscribeRequest{
+		Subscriptions: map[string]*struct {
+			Sub   *gmqtt.Subscription
+			Error error
+		}{
+			"devices/dev1/#":          newSub("devices/dev1/#"),
+			"$share/g/devices/dev1/a": newSub("devices/dev1/a"),
+			"devices/dev2/#":          newSub("devices/dev2/#"),
+			"#":                       newSub("#"),
+		},
+	}
+	a.NoError(fn(context.Background(), newMockClient(ctrl), req))
+	a.Nil(req.Subscriptions["devices/dev1/#"].Error)
+	a.Nil(req.Subscriptions["$share/g/devices/dev1/a"].Error)
+	a.Equal(errNotAuthorized, req.Subscriptions["devices/dev2/#"].Error)
+	a.Equal(errNotAuthorized, req.Subscriptions["#"].Error)
+}
+
+func TestCertns_OnMsgArrivedWrapper(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	var tt = []struct {
+		name   string
+		cfg    Config
+		cert   *x509.Certificate
+		topic  string
+		hasErr bool
+	}{
+		{
+			name:  "in_namespace",
+			cfg:   DefaultConfig,
+			cert:  &x509.Certificate{Subject: pkix.Name{CommonName: "dev1"}},
+			topic: "devices/dev1/status",
+		},
+		{
+			name:   "out_of_namespace",
+			cfg:    DefaultConfig,
+			cert:   &x509.Certificate{Subject: pkix.Name{CommonName: "dev1"}},
+			topic:  "devices/dev2/status",
+			hasErr: true,
+		},
+		{
+			name:   "no_cert",
+			cfg:    DefaultConfig,
+			topic:  "devices/dev1/status",
+			hasErr: true,
+		},
+		{
+			name: "allow_no_cert",
+			cfg: Config{
+				Namespaces:  DefaultConfig.Namespaces,
+				AllowNoCert: true,
+			},
+			topic: "any",
+		},
+	}
+	for _, v := range tt {
+		t.Run(v.name, func(t *testing.T) {
+			c := newCertns(v.cfg, v.cert)
+			fn := c.OnMsgArrivedWrapper(func(ctx context.Context, client server.Client, req *server.MsgArrivedRequest) error {
+				return nil
+			})
+			err := fn(context.Background(), newMockClient(ctrl), &server.MsgArrivedRequest{
+				Publish: &packets.Publish{TopicName: []byte(v.topic)},
+				Message: &gmqtt.Message{Topic: v.topic},
+			})
+			if v.hasErr {
+				assert.Equal(t, errNotAuthorized, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,58 @@
+package certns
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/DrmagicE/gmqtt/pkg/packets"
+)
+
+// Config is the configuration for the certns plugin.
+type Config struct {
+	// Namespaces is the topic filter templates that the client is allowed to publish and subscribe to.
+	// The placeholders {CN}, {O} and {OU} are replaced by the fields of the client certificate subject.
+	Namespaces []string `yaml:"namespaces"`
+	// AllowNoCert indicates whether to skip the check for the clients which are not connected with a client certificate.
+	AllowNoCert bool `yaml:"allow_no_cert"`
+}
+
+// Validate validates the configuration, and return an error if it is invalid.
+func (c *Config) Validate() error {
+	if len(c.Namespaces) == 0 {
+		return fmt.Errorf("invalid namespaces: at least one namespace is required")
+	}
+	for _, v := range c.Namespaces {
+		filter := placeholderRegexp.ReplaceAllStringFunc(v, func(s string) string {
+			if _, ok := placeholders[s]; ok {
+				return "x"
+			}
+			return s
+		})
+		if strings.ContainsAny(filter, "{}") || !packets.ValidTopicFilter(true, []byte(filter)) {
+			return fmt.Errorf("invalid namespace: %s", v)
+		}
+	}
+	return nil
+}
+
+// DefaultConfig is the default configuration.
+var DefaultConfig = Config{
+	Namespaces: []string{"devices/{CN}/#"},
+}
+
+func (c *Config) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type cfg Config
+	var v = &struct {
+		Certns cfg `yaml:"certns"`
+	}{
+		Certns: cfg(DefaultConfig),
+	}
+	if err := unmarshal(v); err != nil {
+		return err
+	}
+	if len(v.Certns.Namespaces) == 0 {
+		v.Certns.Namespaces = DefaultConfig.Namespaces
+	}
+	*c = Config(v.Certns)
+	return nil
+}
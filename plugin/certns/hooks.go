@@ -0,0 +1,107 @@
+package certns
+
+import (
+	"context"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"github.com/DrmagicE/gmqtt/pkg/codes"
+	"github.com/DrmagicE/gmqtt/pkg/packets"
+	"github.com/DrmagicE/gmqtt/server"
+)
+
+func (c *Certns) HookWrapper() server.HookWrapper {
+	return server.HookWrapper{
+		OnSubscribeWrapper:  c.OnSubscribeWrapper,
+		OnMsgArrivedWrapper: c.OnMsgArrivedWrapper,
+	}
+}
+
+// errNotAuthorized is returned for the publishes and subscriptions out of the namespaces.
+// For v3 clients, the rejected subscription gets 0x80 in SUBACK and the rejected publish is dropped silently.
+var errNotAuthorized = &codes.Error{
+	Code: codes.NotAuthorized,
+}
+
+func (c *Certns) OnSubscribeWrapper(pre server.OnSubscribe) server.OnSubscribe {
+	return func(ctx context.Context, client server.Client, req *server.SubscribeRequest) error {
+		err := pre(ctx, client, req)
+		if err != nil {
+			return err
+		}
+		namespaces, noCheck := c.clientNamespaces(client)
+		if noCheck {
+			return nil
+		}
+		for k, v := range req.Subscriptions {
+			if v.Error != nil || coveredByAny(namespaces, v.Sub.TopicFilter) {
+				continue
+			}
+			log.Debug("subscription rejected",
+				zap.String("client_id", client.ClientOptions().ClientID),
+				zap.String("topic_filter", v.Sub.TopicFilter))
+			req.Reject(k, errNotAuthorized)
+		}
+		return nil
+	}
+}
+
+func (c *Certns) OnMsgArrivedWrapper(pre server.OnMsgArrived) server.OnMsgArrived {
+	return func(ctx context.Context, client server.Client, req *server.MsgArrivedRequest) error {
+		err := pre(ctx, client, req)
+		if err != nil {
+			return err
+		}
+		namespaces, noCheck := c.clientNamespaces(client)
+		if noCheck || req.Message == nil {
+			return nil
+		}
+		for _, v := range namespaces {
+			if packets.TopicMatch([]byte(req.Message.Topic), []byte(v)) {
+				return nil
+			}
+		}
+		log.Debug("publish rejected",
+			zap.String("client_id", client.ClientOptions().ClientID),
+			zap.String("topic", req.Message.Topic))
+		return errNotAuthorized
+	}
+}
+
+func coveredByAny(namespaces []string, topicFilter string) bool {
+	for _, v := range namespaces {
+		if covered(v, topicFilter) {
+			return true
+		}
+	}
+	return false
+}
+
+// covered reports whether all topics matched by the topicFilter are also matched by the namespace.
+func covered(namespace, topicFilter string) bool {
+	ns := strings.Split(namespace, "/")
+	tf := strings.Split(topicFilter, "/")
+	for i, v := range ns {
+		if v == "#" {
+			// "#" also matches the parent level, e.g: "a/#" matches "a".
+			return true
+		}
+		if i >= len(tf) {
+			return false
+		}
+		switch tf[i] {
+		case "#":
+			return false
+		case "+":
+			if v != "+" {
+				return false
+			}
+		default:
+			if v != "+" && v != tf[i] {
+				return false
+			}
+		}
+	}
+	return len(tf) == len(ns)
+}
@@ -0,0 +1,81 @@
+package deadletter
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/DrmagicE/gmqtt/pkg/packets"
+	"github.com/DrmagicE/gmqtt/server"
+)
+
+// dropReasons is the set of the drop reasons that can be routed to the dead-letter topic.
+var dropReasons = map[server.DropReason]struct{}{
+	server.DropReasonInternal:            {},
+	server.DropReasonExpired:             {},
+	server.DropReasonInflightExpired:     {},
+	server.DropReasonQueueFull:           {},
+	server.DropReasonExceedsMaxSize:      {},
+	server.DropReasonOverloaded:          {},
+	server.DropReasonQos0NotQueued:       {},
+	server.DropReasonNoSubscriber:        {},
+	server.DropReasonIncompatible:        {},
+	server.DropReasonRedeliveryExhausted: {},
+	server.DropReasonBlackhole:           {},
+}
+
+// Config is the configuration for the deadletter plugin.
+type Config struct {
+	// Topic is the topic prefix of the dead letters, the dead letter of the message on "a/b" is published to "<topic>/a/b".
+	Topic string `yaml:"topic"`
+	// Reasons is the drop reasons of the messages that are routed to the dead-letter topic.
+	Reasons []server.DropReason `yaml:"reasons"`
+	// Validation indicates whether to route the messages that are rejected
+	// with the PayloadFormatInvalid reason code, e.g. by the schema plugin.
+	Validation bool `yaml:"validation"`
+	// QueueSize is the maximum number of the dead letters waiting to be published,
+	// the new dead letters are discarded if the queue is full.
+	QueueSize int `yaml:"queue_size"`
+}
+
+// Validate validates the configuration, and return an error if it is invalid.
+func (c *Config) Validate() error {
+	if !strings.HasPrefix(c.Topic, "$") || !packets.ValidTopicName(true, []byte(c.Topic)) {
+		return fmt.Errorf("invalid topic: %s, must be a topic name starting with $", c.Topic)
+	}
+	for _, v := range c.Reasons {
+		if _, ok := dropReasons[v]; !ok {
+			return fmt.Errorf("invalid reasons: %s", v)
+		}
+	}
+	if c.QueueSize <= 0 {
+		return errors.New("invalid queue_size: must be greater than 0")
+	}
+	return nil
+}
+
+// DefaultConfig is the default configuration.
+var DefaultConfig = Config{
+	Topic: "$deadletter",
+	Reasons: []server.DropReason{
+		server.DropReasonExpired,
+		server.DropReasonInflightExpired,
+		server.DropReasonQueueFull,
+	},
+	Validation: true,
+	QueueSize:  10000,
+}
+
+func (c *Config) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type cfg Config
+	var v = &struct {
+		Deadletter cfg `yaml:"deadletter"`
+	}{
+		Deadletter: cfg(DefaultConfig),
+	}
+	if err := unmarshal(v); err != nil {
+		return err
+	}
+	*c = Config(v.Deadletter)
+	return nil
+}
@@ -0,0 +1,136 @@
+package deadletter
+
+import (
+	"strings"
+	"sync"
+
+	"go.uber.org/zap"
+
+	"github.com/DrmagicE/gmqtt"
+	"github.com/DrmagicE/gmqtt/config"
+	"github.com/DrmagicE/gmqtt/pkg/packets"
+	"github.com/DrmagicE/gmqtt/server"
+)
+
+var _ server.Plugin = (*Deadletter)(nil)
+
+const Name = "deadletter"
+
+// The user properties of the dead letters.
+const (
+	// userPropertyTopic is the topic of the original message.
+	userPropertyTopic = "deadletter-topic"
+	// userPropertyReason is the drop reason, or "validation" if the message is rejected by the validation.
+	userPropertyReason = "deadletter-reason"
+	// userPropertyClientID is the subscriber which the message was going to be sent to,
+	// or the publisher if the message is rejected by the validation or has no subscriber.
+	userPropertyClientID = "deadletter-client-id"
+	// userPropertyError is the error message.
+	userPropertyError = "deadletter-error"
+)
+
+// reasonValidation is the reason of the messages rejected by the validation.
+const reasonValidation = "validation"
+
+func init() {
+	server.RegisterPlugin(Name, New)
+	config.RegisterDefaultPluginConfig(Name, &DefaultConfig)
+}
+
+func New(config config.Config) (server.Plugin, error) {
+	cfg := config.Plugins[Name].(*Config)
+	d := &Deadletter{
+		config:  cfg,
+		reasons: make(map[server.DropReason]struct{}),
+		queue:   make(chan *gmqtt.Message, cfg.QueueSize),
+		done:    make(chan struct{}),
+	}
+	for _, v := range cfg.Reasons {
+		d.reasons[v] = struct{}{}
+	}
+	return d, nil
+}
+
+var log *zap.Logger
+
+// Deadletter republishes the dropped and the rejected messages to the dead-letter topic,
+// with the user properties describing the original topic and the reason.
+type Deadletter struct {
+	config    *Config
+	reasons   map[server.DropReason]struct{}
+	publisher server.Publisher
+	// queue decouples the publishing from the drop notification,
+	// which can be called with the locks of the message queue or the session held.
+	queue chan *gmqtt.Message
+	done  chan struct{}
+	wg    sync.WaitGroup
+}
+
+func (d *Deadletter) Load(service server.Server) error {
+	log = server.LoggerWithField(zap.String("plugin", Name))
+	d.publisher = service.Publisher()
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		d.publishLoop()
+	}()
+	return nil
+}
+
+func (d *Deadletter) Unload() error {
+	close(d.done)
+	d.wg.Wait()
+	return nil
+}
+
+func (d *Deadletter) Name() string {
+	return Name
+}
+
+func (d *Deadletter) publishLoop() {
+	for {
+		select {
+		case <-d.done:
+			return
+		case msg := <-d.queue:
+			d.publisher.Publish(msg)
+		}
+	}
+}
+
+// isDeadletter returns whether the topic is the dead-letter topic, the dead letters are never dead-lettered again.
+func (d *Deadletter) isDeadletter(topic string) bool {
+	return topic == d.config.Topic || strings.HasPrefix(topic, d.config.Topic+"/")
+}
+
+// route queues the dead letter of the message.
+func (d *Deadletter) route(clientID string, msg *gmqtt.Message, reason string, err error) {
+	if d.isDeadletter(msg.Topic) {
+		return
+	}
+	dl := msg.ShallowCopy()
+	dl.Topic = d.config.Topic + "/" + msg.Topic
+	dl.Retained = false
+	dl.Dup = false
+	dl.PacketID = 0
+	dl.MessageExpiry = 0
+	dl.SubscriptionIdentifier = nil
+	dl.UserProperties = make([]packets.UserProperty, 0, len(msg.UserProperties)+4)
+	dl.UserProperties = append(dl.UserProperties, msg.UserProperties...)
+	dl.UserProperties = append(dl.UserProperties,
+		packets.UserProperty{K: []byte(userPropertyTopic), V: []byte(msg.Topic)},
+		packets.UserProperty{K: []byte(userPropertyReason), V: []byte(reason)},
+		packets.UserProperty{K: []byte(userPropertyClientID), V: []byte(clientID)},
+	)
+	if err != nil {
+		dl.UserProperties = append(dl.UserProperties, packets.UserProperty{K: []byte(userPropertyError), V: []byte(err.Error())})
+	}
+	select {
+	case d.queue <- dl:
+	default:
+		log.Warn("dead-letter queue is full, dead letter discarded",
+			zap.String("client_id", clientID),
+			zap.String("topic", msg.Topic),
+			zap.String("reason", reason))
+	}
+}
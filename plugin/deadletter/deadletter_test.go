@@ -0,0 +1,163 @@
+package deadletter
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+
+	"github.com/DrmagicE/gmqtt"
+	"github.com/DrmagicE/gmqtt/config"
+	"github.com/DrmagicE/gmqtt/persistence/queue"
+	"github.com/DrmagicE/gmqtt/pkg/codes"
+	"github.com/DrmagicE/gmqtt/pkg/packets"
+	"github.com/DrmagicE/gmqtt/server"
+)
+
+func init() {
+	log = zap.NewNop()
+}
+
+func newDeadletter(a *assert.Assertions, cfg Config) *Deadletter {
+	a.NoError(cfg.Validate())
+	c := config.DefaultConfig()
+	c.Plugins[Name] = &cfg
+	d, err := New(c)
+	a.NoError(err)
+	return d.(*Deadletter)
+}
+
+func userProperties(msg *gmqtt.Message) map[string]string {
+	m := make(map[string]string)
+	for _, v := range msg.UserProperties {
+		m[string(v.K)] = string(v.V)
+	}
+	return m
+}
+
+func TestConfig_Validate(t *testing.T) {
+	a := assert.New(t)
+	a.NoError(DefaultConfig.Validate())
+
+	var tt = []func(c *Config){
+		func(c *Config) { c.Topic = "deadletter" },
+		func(c *Config) { c.Topic = "$deadletter/#" },
+		func(c *Config) { c.Reasons = []server.DropReason{"unknown"} },
+		func(c *Config) { c.QueueSize = 0 },
+	}
+	for k, v := range tt {
+		c := DefaultConfig
+		v(&c)
+		a.Error(c.Validate(), k)
+	}
+}
+
+func TestDeadletter_OnMsgDroppedWrapper(t *testing.T) {
+	a := assert.New(t)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	d := newDeadletter(a, DefaultConfig)
+	pub := server.NewMockPublisher(ctrl)
+	srv := server.NewMockServer(ctrl)
+	srv.EXPECT().Publisher().Return(pub)
+
+	published := make(chan *gmqtt.Message, 10)
+	pub.EXPECT().Publish(gomock.Any()).Do(func(msg *gmqtt.Message) {
+		published <- msg
+	}).AnyTimes()
+	a.NoError(d.Load(srv))
+
+	onMsgDropped := d.OnMsgDroppedWrapper(func(ctx context.Context, clientID string, msg *gmqtt.Message, err error) {})
+	msg := &gmqtt.Message{
+		Topic:          "a/b",
+		Payload:        []byte("payload"),
+		QoS:            1,
+		Retained:       true,
+		PacketID:       10,
+		MessageExpiry:  10,
+		UserProperties: []packets.UserProperty{{K: []byte("k"), V: []byte("v")}},
+	}
+	onMsgDropped(context.Background(), "sub", msg, queue.ErrDropExpired)
+	// not configured reasons
+	onMsgDropped(context.Background(), "sub", msg, queue.ErrDropNoSubscriber)
+	// dead letters are not dead-lettered again
+	onMsgDropped(context.Background(), "sub", &gmqtt.Message{Topic: "$deadletter/a/b"}, queue.ErrDropQueueFull)
+
+	select {
+	case dl := <-published:
+		a.Equal("$deadletter/a/b", dl.Topic)
+		a.Equal(msg.Payload, dl.Payload)
+		a.EqualValues(1, dl.QoS)
+		a.False(dl.Retained)
+		a.EqualValues(0, dl.PacketID)
+		a.EqualValues(0, dl.MessageExpiry)
+		a.Equal(map[string]string{
+			"k":                  "v",
+			userPropertyTopic:    "a/b",
+			userPropertyReason:   string(server.DropReasonExpired),
+			userPropertyClientID: "sub",
+			userPropertyError:    queue.ErrDropExpired.Error(),
+		}, userProperties(dl))
+	case <-time.After(time.Second):
+		t.Fatal("dead letter not published")
+	}
+	a.NoError(d.Unload())
+	a.Len(published, 0)
+	// the original message is not modified
+	a.Equal("a/b", msg.Topic)
+	a.Len(msg.UserProperties, 1)
+}
+
+func TestDeadletter_OnMsgArrivedWrapper(t *testing.T) {
+	a := assert.New(t)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	d := newDeadletter(a, DefaultConfig)
+	client := server.NewMockClient(ctrl)
+	client.EXPECT().ClientOptions().Return(&server.ClientOptions{ClientID: "pub"}).AnyTimes()
+
+	var preErr error
+	onMsgArrived := d.OnMsgArrivedWrapper(func(ctx context.Context, client server.Client, req *server.MsgArrivedRequest) error {
+		return preErr
+	})
+	msg := &gmqtt.Message{Topic: "a/b", Payload: []byte("x")}
+	a.NoError(onMsgArrived(context.Background(), client, &server.MsgArrivedRequest{Message: msg}))
+
+	preErr = &codes.Error{Code: codes.NotAuthorized}
+	a.Equal(preErr, onMsgArrived(context.Background(), client, &server.MsgArrivedRequest{Message: msg}))
+	preErr = errors.New("error")
+	a.Equal(preErr, onMsgArrived(context.Background(), client, &server.MsgArrivedRequest{Message: msg}))
+	a.Len(d.queue, 0)
+
+	preErr = &codes.Error{Code: codes.PayloadFormatInvalid, ErrorDetails: codes.ErrorDetails{ReasonString: []byte("invalid")}}
+	a.Equal(preErr, onMsgArrived(context.Background(), client, &server.MsgArrivedRequest{Message: msg}))
+	a.Len(d.queue, 1)
+	dl := <-d.queue
+	a.Equal("$deadletter/a/b", dl.Topic)
+	a.Equal(map[string]string{
+		userPropertyTopic:    "a/b",
+		userPropertyReason:   reasonValidation,
+		userPropertyClientID: "pub",
+		userPropertyError:    "invalid",
+	}, userProperties(dl))
+
+	d.config.Validation = false
+	a.Equal(preErr, onMsgArrived(context.Background(), client, &server.MsgArrivedRequest{Message: msg}))
+	a.Len(d.queue, 0)
+}
+
+func TestDeadletter_queueFull(t *testing.T) {
+	a := assert.New(t)
+	cfg := DefaultConfig
+	cfg.QueueSize = 1
+	d := newDeadletter(a, cfg)
+	onMsgDropped := d.OnMsgDroppedWrapper(func(ctx context.Context, clientID string, msg *gmqtt.Message, err error) {})
+	onMsgDropped(context.Background(), "sub", &gmqtt.Message{Topic: "a"}, queue.ErrDropQueueFull)
+	onMsgDropped(context.Background(), "sub", &gmqtt.Message{Topic: "b"}, queue.ErrDropQueueFull)
+	a.Len(d.queue, 1)
+	a.Equal("$deadletter/a", (<-d.queue).Topic)
+}
@@ -0,0 +1,44 @@
+package deadletter
+
+import (
+	"context"
+	"errors"
+
+	"github.com/DrmagicE/gmqtt"
+	"github.com/DrmagicE/gmqtt/pkg/codes"
+	"github.com/DrmagicE/gmqtt/server"
+)
+
+func (d *Deadletter) HookWrapper() server.HookWrapper {
+	return server.HookWrapper{
+		OnMsgArrivedWrapper: d.OnMsgArrivedWrapper,
+		OnMsgDroppedWrapper: d.OnMsgDroppedWrapper,
+	}
+}
+
+func (d *Deadletter) OnMsgArrivedWrapper(pre server.OnMsgArrived) server.OnMsgArrived {
+	return func(ctx context.Context, client server.Client, req *server.MsgArrivedRequest) error {
+		err := pre(ctx, client, req)
+		if !d.config.Validation || err == nil || req.Message == nil {
+			return err
+		}
+		if codeErr, ok := err.(*codes.Error); ok && codeErr.Code == codes.PayloadFormatInvalid {
+			var reasonErr error
+			if len(codeErr.ReasonString) != 0 {
+				reasonErr = errors.New(string(codeErr.ReasonString))
+			}
+			d.route(client.ClientOptions().ClientID, req.Message, reasonValidation, reasonErr)
+		}
+		return err
+	}
+}
+
+func (d *Deadletter) OnMsgDroppedWrapper(pre server.OnMsgDropped) server.OnMsgDropped {
+	return func(ctx context.Context, clientID string, msg *gmqtt.Message, err error) {
+		pre(ctx, clientID, msg, err)
+		reason := server.DropReasonOf(err)
+		if _, ok := d.reasons[reason]; ok {
+			d.route(clientID, msg, string(reason), err)
+		}
+	}
+}
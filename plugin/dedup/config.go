@@ -0,0 +1,109 @@
+package dedup
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/DrmagicE/gmqtt/pkg/packets"
+)
+
+// The sources of the deduplication key.
+const (
+	// KeyUserProperty uses the value of the user property as the message id.
+	KeyUserProperty = "user_property"
+	// KeyPayloadHash uses the SHA-256 hash of the payload as the message id.
+	KeyPayloadHash = "payload_hash"
+)
+
+// Config is the configuration for the dedup plugin.
+type Config struct {
+	// Rules is the list of the deduplication rules.
+	// A message is deduplicated by the first rule whose topic filter matches its topic.
+	Rules []RuleConfig `yaml:"rules"`
+	// MaxEntries is the maximum number of the message ids remembered by each rule,
+	// the least recently seen one is evicted if it is exceeded.
+	MaxEntries int `yaml:"max_entries"`
+}
+
+// RuleConfig is the deduplication window of a topic filter.
+type RuleConfig struct {
+	TopicFilter string `yaml:"topic_filter"`
+	// Key is the source of the message id, possible values are "user_property" and "payload_hash".
+	Key string `yaml:"key"`
+	// UserProperty is the name of the user property which carries the message id, only used when the Key is "user_property".
+	// The messages without the user property are not deduplicated.
+	UserProperty string `yaml:"user_property"`
+	// Window is the duration to remember the message id after it is last seen on the topic.
+	Window time.Duration `yaml:"window"`
+	// PerClient indicates whether the message ids are scoped by the publisher client id in addition to the topic.
+	PerClient bool `yaml:"per_client"`
+}
+
+// DefaultRuleConfig is the default value of the rule configuration.
+var DefaultRuleConfig = RuleConfig{
+	Key:          KeyUserProperty,
+	UserProperty: "message-id",
+	Window:       time.Minute,
+}
+
+func (r *RuleConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type cfg RuleConfig
+	v := cfg(DefaultRuleConfig)
+	if err := unmarshal(&v); err != nil {
+		return err
+	}
+	*r = RuleConfig(v)
+	return nil
+}
+
+func (r *RuleConfig) validate() error {
+	if !packets.ValidTopicFilter(true, []byte(r.TopicFilter)) {
+		return fmt.Errorf("invalid topic_filter: %s", r.TopicFilter)
+	}
+	switch r.Key {
+	case KeyUserProperty:
+		if r.UserProperty == "" {
+			return errors.New("invalid user_property: cannot be empty")
+		}
+	case KeyPayloadHash:
+	default:
+		return fmt.Errorf("invalid key: %s", r.Key)
+	}
+	if r.Window <= 0 {
+		return errors.New("invalid window: must be greater than 0")
+	}
+	return nil
+}
+
+// Validate validates the configuration, and return an error if it is invalid.
+func (c *Config) Validate() error {
+	for k := range c.Rules {
+		if err := c.Rules[k].validate(); err != nil {
+			return fmt.Errorf("rule %s: %s", c.Rules[k].TopicFilter, err)
+		}
+	}
+	if c.MaxEntries <= 0 {
+		return errors.New("invalid max_entries: must be greater than 0")
+	}
+	return nil
+}
+
+// DefaultConfig is the default configuration.
+var DefaultConfig = Config{
+	MaxEntries: 100000,
+}
+
+func (c *Config) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type cfg Config
+	var v = &struct {
+		Dedup cfg `yaml:"dedup"`
+	}{
+		Dedup: cfg(DefaultConfig),
+	}
+	if err := unmarshal(v); err != nil {
+		return err
+	}
+	*c = Config(v.Dedup)
+	return nil
+}
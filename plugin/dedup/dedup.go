@@ -0,0 +1,132 @@
+package dedup
+
+import (
+	"crypto/sha256"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+
+	"github.com/DrmagicE/gmqtt"
+	"github.com/DrmagicE/gmqtt/config"
+	"github.com/DrmagicE/gmqtt/pkg/packets"
+	"github.com/DrmagicE/gmqtt/server"
+)
+
+var _ server.Plugin = (*Dedup)(nil)
+
+const Name = "dedup"
+
+func init() {
+	server.RegisterPlugin(Name, New)
+	config.RegisterDefaultPluginConfig(Name, &DefaultConfig)
+}
+
+func New(config config.Config) (server.Plugin, error) {
+	cfg := config.Plugins[Name].(*Config)
+	d := &Dedup{
+		config: cfg,
+	}
+	for k := range cfg.Rules {
+		d.rules = append(d.rules, &rule{
+			config: &cfg.Rules[k],
+			window: newWindow(cfg.Rules[k].Window, cfg.MaxEntries),
+		})
+	}
+	return d, nil
+}
+
+var log *zap.Logger
+
+type rule struct {
+	config     *RuleConfig
+	window     *window
+	duplicates uint64
+}
+
+// Dedup drops the duplicated messages published within the deduplication window before they fan out to the subscribers.
+type Dedup struct {
+	config *Config
+	rules  []*rule
+}
+
+func (d *Dedup) Load(service server.Server) error {
+	log = server.LoggerWithField(zap.String("plugin", Name))
+	return prometheus.DefaultRegisterer.Register(d)
+}
+
+func (d *Dedup) Unload() error {
+	prometheus.DefaultRegisterer.Unregister(d)
+	return nil
+}
+
+func (d *Dedup) Name() string {
+	return Name
+}
+
+// matchRule returns the first rule that matches the topic, nil if no rule matches.
+func (d *Dedup) matchRule(topic string) *rule {
+	for _, r := range d.rules {
+		if packets.TopicMatch([]byte(topic), []byte(r.config.TopicFilter)) {
+			return r
+		}
+	}
+	return nil
+}
+
+// messageID returns the message id of the message by the rule, empty if the message has no id.
+func (r *rule) messageID(msg *gmqtt.Message) string {
+	if r.config.Key == KeyPayloadHash {
+		sum := sha256.Sum256(msg.Payload)
+		return string(sum[:])
+	}
+	for _, v := range msg.UserProperties {
+		if string(v.K) == r.config.UserProperty {
+			return string(v.V)
+		}
+	}
+	return ""
+}
+
+// isDuplicate returns whether the message has been published within the window.
+func (d *Dedup) isDuplicate(clientID string, msg *gmqtt.Message, now time.Time) bool {
+	r := d.matchRule(msg.Topic)
+	if r == nil {
+		return false
+	}
+	id := r.messageID(msg)
+	if id == "" {
+		return false
+	}
+	// topic names can not contain the null character, so the key is unambiguous.
+	key := msg.Topic + "\x00" + id
+	if r.config.PerClient {
+		key = clientID + "\x00" + key
+	}
+	if !r.window.seen(key, now) {
+		return false
+	}
+	atomic.AddUint64(&r.duplicates, 1)
+	return true
+}
+
+var (
+	duplicatesDesc = prometheus.NewDesc("gmqtt_dedup_duplicates_total", "", []string{"topic_filter"}, nil)
+	entriesDesc    = prometheus.NewDesc("gmqtt_dedup_entries_current", "", []string{"topic_filter"}, nil)
+)
+
+// Describe implements prometheus.Collector, the metrics are exposed by the prometheus plugin.
+func (d *Dedup) Describe(desc chan<- *prometheus.Desc) {
+	prometheus.DescribeByCollect(d, desc)
+}
+
+// Collect implements prometheus.Collector.
+func (d *Dedup) Collect(m chan<- prometheus.Metric) {
+	for _, r := range d.rules {
+		m <- prometheus.MustNewConstMetric(duplicatesDesc, prometheus.CounterValue,
+			float64(atomic.LoadUint64(&r.duplicates)), r.config.TopicFilter)
+		m <- prometheus.MustNewConstMetric(entriesDesc, prometheus.GaugeValue,
+			float64(r.window.len()), r.config.TopicFilter)
+	}
+}
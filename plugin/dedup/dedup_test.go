@@ -0,0 +1,130 @@
+package dedup
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+
+	"github.com/DrmagicE/gmqtt"
+	"github.com/DrmagicE/gmqtt/config"
+	"github.com/DrmagicE/gmqtt/pkg/packets"
+	"github.com/DrmagicE/gmqtt/server"
+)
+
+func init() {
+	log = zap.NewNop()
+}
+
+func newDedup(t *testing.T, cfg Config) *Dedup {
+	c := config.DefaultConfig()
+	c.Plugins[Name] = &cfg
+	p, err := New(c)
+	assert.NoError(t, err)
+	return p.(*Dedup)
+}
+
+func msgWithID(topic, id string) *gmqtt.Message {
+	return &gmqtt.Message{
+		Topic:          topic,
+		Payload:        []byte("payload"),
+		UserProperties: []packets.UserProperty{{K: []byte("message-id"), V: []byte(id)}},
+	}
+}
+
+func TestConfig_Validate(t *testing.T) {
+	a := assert.New(t)
+	a.NoError(DefaultConfig.Validate())
+	rule := DefaultRuleConfig
+	rule.TopicFilter = "a/#"
+	a.NoError((&Config{MaxEntries: 1, Rules: []RuleConfig{rule}}).Validate())
+	a.Error((&Config{Rules: []RuleConfig{rule}}).Validate())
+
+	r := rule
+	r.TopicFilter = "a/#/b"
+	a.Error((&Config{MaxEntries: 1, Rules: []RuleConfig{r}}).Validate())
+	r = rule
+	r.Key = "unknown"
+	a.Error((&Config{MaxEntries: 1, Rules: []RuleConfig{r}}).Validate())
+	r = rule
+	r.UserProperty = ""
+	a.Error((&Config{MaxEntries: 1, Rules: []RuleConfig{r}}).Validate())
+	r.Key = KeyPayloadHash
+	a.NoError((&Config{MaxEntries: 1, Rules: []RuleConfig{r}}).Validate())
+	r.Window = 0
+	a.Error((&Config{MaxEntries: 1, Rules: []RuleConfig{r}}).Validate())
+}
+
+func TestWindow(t *testing.T) {
+	a := assert.New(t)
+	w := newWindow(time.Minute, 2)
+	now := time.Now()
+	a.False(w.seen("a", now))
+	a.True(w.seen("a", now.Add(30*time.Second)))
+	// the window slides from the last seen time.
+	a.True(w.seen("a", now.Add(80*time.Second)))
+	a.False(w.seen("a", now.Add(141*time.Second)))
+
+	// the least recently seen key is evicted if the max entries is exceeded.
+	a.False(w.seen("b", now.Add(142*time.Second)))
+	a.False(w.seen("c", now.Add(143*time.Second)))
+	a.Equal(2, w.len())
+	a.False(w.seen("a", now.Add(144*time.Second)))
+	a.True(w.seen("c", now.Add(145*time.Second)))
+}
+
+func TestDedup_isDuplicate(t *testing.T) {
+	a := assert.New(t)
+	d := newDedup(t, Config{
+		MaxEntries: 100,
+		Rules: []RuleConfig{
+			{TopicFilter: "id/#", Key: KeyUserProperty, UserProperty: "message-id", Window: time.Minute},
+			{TopicFilter: "hash/#", Key: KeyPayloadHash, Window: time.Minute, PerClient: true},
+		},
+	})
+	now := time.Now()
+	a.False(d.isDuplicate("c1", msgWithID("id/a", "1"), now))
+	a.True(d.isDuplicate("c2", msgWithID("id/a", "1"), now))
+	// the ids are scoped by the topic.
+	a.False(d.isDuplicate("c1", msgWithID("id/b", "1"), now))
+	a.False(d.isDuplicate("c1", msgWithID("id/a", "2"), now))
+	// the messages without the id are not deduplicated.
+	a.False(d.isDuplicate("c1", &gmqtt.Message{Topic: "id/a"}, now))
+	a.False(d.isDuplicate("c1", &gmqtt.Message{Topic: "id/a"}, now))
+	// no rule matches.
+	a.False(d.isDuplicate("c1", msgWithID("other", "1"), now))
+	a.False(d.isDuplicate("c1", msgWithID("other", "1"), now))
+
+	a.False(d.isDuplicate("c1", &gmqtt.Message{Topic: "hash/a", Payload: []byte("1")}, now))
+	a.True(d.isDuplicate("c1", &gmqtt.Message{Topic: "hash/a", Payload: []byte("1")}, now))
+	a.False(d.isDuplicate("c1", &gmqtt.Message{Topic: "hash/a", Payload: []byte("2")}, now))
+	// per client
+	a.False(d.isDuplicate("c2", &gmqtt.Message{Topic: "hash/a", Payload: []byte("1")}, now))
+
+	a.EqualValues(1, d.rules[0].duplicates)
+	a.EqualValues(1, d.rules[1].duplicates)
+}
+
+func TestDedup_OnMsgArrivedWrapper(t *testing.T) {
+	a := assert.New(t)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	d := newDedup(t, Config{
+		MaxEntries: 100,
+		Rules:      []RuleConfig{{TopicFilter: "#", Key: KeyUserProperty, UserProperty: "message-id", Window: time.Minute}},
+	})
+	client := server.NewMockClient(ctrl)
+	client.EXPECT().ClientOptions().Return(&server.ClientOptions{ClientID: "cid"}).AnyTimes()
+	fn := d.OnMsgArrivedWrapper(func(ctx context.Context, client server.Client, req *server.MsgArrivedRequest) error {
+		return nil
+	})
+	req := &server.MsgArrivedRequest{Message: msgWithID("a", "1")}
+	a.NoError(fn(context.Background(), client, req))
+	a.NotNil(req.Message)
+	req = &server.MsgArrivedRequest{Message: msgWithID("a", "1")}
+	a.NoError(fn(context.Background(), client, req))
+	a.Nil(req.Message)
+}
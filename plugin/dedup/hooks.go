@@ -0,0 +1,33 @@
+package dedup
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/DrmagicE/gmqtt/server"
+)
+
+func (d *Dedup) HookWrapper() server.HookWrapper {
+	return server.HookWrapper{
+		OnMsgArrivedWrapper: d.OnMsgArrivedWrapper,
+	}
+}
+
+func (d *Dedup) OnMsgArrivedWrapper(pre server.OnMsgArrived) server.OnMsgArrived {
+	return func(ctx context.Context, client server.Client, req *server.MsgArrivedRequest) error {
+		err := pre(ctx, client, req)
+		if err != nil || req.Message == nil {
+			return err
+		}
+		clientID := client.ClientOptions().ClientID
+		if d.isDuplicate(clientID, req.Message, time.Now()) {
+			log.Debug("duplicated message dropped",
+				zap.String("client_id", clientID),
+				zap.String("topic", req.Message.Topic))
+			req.Drop()
+		}
+		return nil
+	}
+}
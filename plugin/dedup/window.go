@@ -0,0 +1,69 @@
+package dedup
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// window remembers the message ids seen in the sliding time window.
+type window struct {
+	mu         sync.Mutex
+	duration   time.Duration
+	maxEntries int
+	// entries is the index of the elements in the lru list, the value of the element is *entry.
+	entries map[string]*list.Element
+	// lru is ordered by the last seen time, the front one is the least recently seen.
+	lru *list.List
+}
+
+type entry struct {
+	key      string
+	lastSeen time.Time
+}
+
+func newWindow(duration time.Duration, maxEntries int) *window {
+	return &window{
+		duration:   duration,
+		maxEntries: maxEntries,
+		entries:    make(map[string]*list.Element),
+		lru:        list.New(),
+	}
+}
+
+// seen records the key at the given time, and returns whether it has been seen within the window.
+func (w *window) seen(key string, now time.Time) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.expireLocked(now)
+	if e, ok := w.entries[key]; ok {
+		e.Value.(*entry).lastSeen = now
+		w.lru.MoveToBack(e)
+		return true
+	}
+	w.entries[key] = w.lru.PushBack(&entry{key: key, lastSeen: now})
+	if w.lru.Len() > w.maxEntries {
+		w.removeLocked(w.lru.Front())
+	}
+	return false
+}
+
+func (w *window) expireLocked(now time.Time) {
+	for e := w.lru.Front(); e != nil; e = w.lru.Front() {
+		if now.Sub(e.Value.(*entry).lastSeen) < w.duration {
+			return
+		}
+		w.removeLocked(e)
+	}
+}
+
+func (w *window) removeLocked(e *list.Element) {
+	w.lru.Remove(e)
+	delete(w.entries, e.Value.(*entry).key)
+}
+
+func (w *window) len() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.lru.Len()
+}
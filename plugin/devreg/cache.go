@@ -0,0 +1,81 @@
+package devreg
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// cache caches the lookup results of the client ids, including the ones that are not found (device is nil).
+type cache struct {
+	mu          sync.Mutex
+	ttl         time.Duration
+	negativeTTL time.Duration
+	maxEntries  int
+	// entries is the index of the elements in the lru list, the value of the element is *cacheEntry.
+	entries map[string]*list.Element
+	// lru is ordered by the last used time, the front one is the least recently used.
+	lru *list.List
+}
+
+type cacheEntry struct {
+	clientID string
+	device   *Device
+	expiry   time.Time
+}
+
+func newCache(config CacheConfig) *cache {
+	return &cache{
+		ttl:         config.TTL,
+		negativeTTL: config.NegativeTTL,
+		maxEntries:  config.MaxEntries,
+		entries:     make(map[string]*list.Element),
+		lru:         list.New(),
+	}
+}
+
+// get returns the cached device of the client id, ok is false if it is not cached or expired.
+func (c *cache) get(clientID string, now time.Time) (device *Device, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[clientID]
+	if !ok {
+		return nil, false
+	}
+	ce := e.Value.(*cacheEntry)
+	if !now.Before(ce.expiry) {
+		c.removeLocked(e)
+		return nil, false
+	}
+	c.lru.MoveToBack(e)
+	return ce.device, true
+}
+
+// set caches the device of the client id, a nil device means the client id is not found.
+func (c *cache) set(clientID string, device *Device, now time.Time) {
+	ttl := c.ttl
+	if device == nil {
+		ttl = c.negativeTTL
+	}
+	if ttl == 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[clientID]; ok {
+		c.removeLocked(e)
+	}
+	c.entries[clientID] = c.lru.PushBack(&cacheEntry{
+		clientID: clientID,
+		device:   device,
+		expiry:   now.Add(ttl),
+	})
+	if c.lru.Len() > c.maxEntries {
+		c.removeLocked(c.lru.Front())
+	}
+}
+
+func (c *cache) removeLocked(e *list.Element) {
+	c.lru.Remove(e)
+	delete(c.entries, e.Value.(*cacheEntry).clientID)
+}
@@ -0,0 +1,109 @@
+package devreg
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/DrmagicE/gmqtt/pkg/proxy"
+)
+
+// Config is the configuration for the devreg plugin.
+type Config struct {
+	// Registry is the name of the registry implementation, "http" is bundled.
+	// Other implementations can be registered by RegisterRegistry.
+	Registry string `yaml:"registry"`
+	// HTTP is the configuration of the http registry.
+	HTTP HTTPConfig `yaml:"http"`
+	// Cache is the configuration of the lookup result cache.
+	Cache CacheConfig `yaml:"cache"`
+	// AllowUnregistered indicates whether to accept the clients whose client id is not in the registry.
+	AllowUnregistered bool `yaml:"allow_unregistered"`
+	// FailOpen indicates whether to accept the clients without any check if the registry is unavailable.
+	// If false, the clients are refused with Server unavailable.
+	FailOpen bool `yaml:"fail_open"`
+	// TenantAttribute is the client attribute key that the tenant of the device is stored in.
+	TenantAttribute string `yaml:"tenant_attribute"`
+}
+
+// HTTPConfig is the configuration for the http registry.
+type HTTPConfig struct {
+	// URL is the URL template of the device, the placeholder {client_id} is replaced by the client id.
+	// e.g: http://127.0.0.1:8090/devices/{client_id}
+	URL string `yaml:"url"`
+	// Headers is the additional HTTP headers sent to the registry, e.g. Authorization.
+	Headers map[string]string `yaml:"headers"`
+	// Timeout is the timeout of the HTTP request.
+	Timeout time.Duration `yaml:"timeout"`
+	// Proxy is the forward proxy of the HTTP requests, e.g. http://proxy:3128 or socks5://proxy:1080.
+	// If empty, use the global proxy. "direct" connects directly regardless of the global proxy.
+	Proxy string `yaml:"proxy"`
+}
+
+// CacheConfig is the configuration for the lookup result cache.
+type CacheConfig struct {
+	// TTL is the time to live of the found devices, 0 means no cache.
+	TTL time.Duration `yaml:"ttl"`
+	// NegativeTTL is the time to live of the client ids that are not found, 0 means no cache.
+	NegativeTTL time.Duration `yaml:"negative_ttl"`
+	// MaxEntries is the maximum number of the cached results, the least recently used one is evicted if it is exceeded.
+	MaxEntries int `yaml:"max_entries"`
+}
+
+// Validate validates the configuration, and return an error if it is invalid.
+func (c *Config) Validate() error {
+	if _, ok := registries[c.Registry]; !ok {
+		return fmt.Errorf("invalid registry: %s", c.Registry)
+	}
+	if c.Registry == RegistryHTTP {
+		if !strings.Contains(c.HTTP.URL, "{client_id}") {
+			return errors.New("invalid http url: missing {client_id} placeholder")
+		}
+		if c.HTTP.Timeout <= 0 {
+			return errors.New("invalid http timeout: must be greater than 0")
+		}
+		if err := proxy.Validate(c.HTTP.Proxy); err != nil {
+			return fmt.Errorf("invalid http proxy: %s", err)
+		}
+	}
+	if c.Cache.TTL < 0 || c.Cache.NegativeTTL < 0 {
+		return errors.New("invalid cache ttl: cannot be negative")
+	}
+	if c.Cache.MaxEntries <= 0 {
+		return errors.New("invalid cache max_entries: must be greater than 0")
+	}
+	if c.TenantAttribute == "" {
+		return errors.New("invalid tenant_attribute: cannot be empty")
+	}
+	return nil
+}
+
+// DefaultConfig is the default configuration.
+var DefaultConfig = Config{
+	Registry: RegistryHTTP,
+	HTTP: HTTPConfig{
+		URL:     "http://127.0.0.1:8090/devices/{client_id}",
+		Timeout: 5 * time.Second,
+	},
+	Cache: CacheConfig{
+		TTL:         5 * time.Minute,
+		NegativeTTL: 30 * time.Second,
+		MaxEntries:  100000,
+	},
+	TenantAttribute: "tenant",
+}
+
+func (c *Config) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type cfg Config
+	var v = &struct {
+		Devreg cfg `yaml:"devreg"`
+	}{
+		Devreg: cfg(DefaultConfig),
+	}
+	if err := unmarshal(v); err != nil {
+		return err
+	}
+	*c = Config(v.Devreg)
+	return nil
+}
@@ -0,0 +1,99 @@
+package devreg
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"net"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/DrmagicE/gmqtt/config"
+	"github.com/DrmagicE/gmqtt/pkg/proxy"
+	"github.com/DrmagicE/gmqtt/server"
+)
+
+var _ server.Plugin = (*Devreg)(nil)
+
+const Name = "devreg"
+
+func init() {
+	server.RegisterPlugin(Name, New)
+	config.RegisterDefaultPluginConfig(Name, &DefaultConfig)
+}
+
+func New(config config.Config) (server.Plugin, error) {
+	cfg := *config.Plugins[Name].(*Config)
+	cfg.HTTP.Proxy = proxy.Resolve(cfg.HTTP.Proxy, config.Proxy)
+	registry, err := registries[cfg.Registry](&cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &Devreg{
+		config:          &cfg,
+		registry:        registry,
+		cache:           newCache(cfg.Cache),
+		connectionState: server.TLSConnectionState,
+	}, nil
+}
+
+var log *zap.Logger
+
+// Devreg resolves the client ids against the external device registry at CONNECT time,
+// and applies the expected certificate fingerprint, the tenant and the quotas of the devices.
+type Devreg struct {
+	config   *Config
+	registry Registry
+	cache    *cache
+	// connectionState returns the TLS connection state of the client connection.
+	connectionState func(conn net.Conn) (tls.ConnectionState, bool)
+}
+
+func (d *Devreg) Load(service server.Server) error {
+	log = server.LoggerWithField(zap.String("plugin", Name))
+	return nil
+}
+
+func (d *Devreg) Unload() error {
+	return nil
+}
+
+func (d *Devreg) Name() string {
+	return Name
+}
+
+// lookup returns the device of the client id from the cache or the registry, nil if the client id is not found.
+func (d *Devreg) lookup(ctx context.Context, clientID string) (*Device, error) {
+	now := time.Now()
+	if device, ok := d.cache.get(clientID, now); ok {
+		return device, nil
+	}
+	device, err := d.registry.Lookup(ctx, clientID)
+	if err == ErrNotFound {
+		device, err = nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	d.cache.set(clientID, device, now)
+	return device, nil
+}
+
+// normalizeFingerprint removes the colons of the hex encoded fingerprint and converts it to lower case.
+func normalizeFingerprint(fp string) string {
+	return strings.ToLower(strings.Replace(fp, ":", "", -1))
+}
+
+// certFingerprint returns the hex encoded SHA-256 fingerprint of the client certificate,
+// empty if the client is not connected with a client certificate.
+func (d *Devreg) certFingerprint(conn net.Conn) string {
+	state, ok := d.connectionState(conn)
+	if !ok || len(state.PeerCertificates) == 0 {
+		return ""
+	}
+	sum := sha256.Sum256(state.PeerCertificates[0].Raw)
+	return hex.EncodeToString(sum[:])
+}
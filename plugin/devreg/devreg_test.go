@@ -0,0 +1,256 @@
+package devreg
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+
+	"github.com/DrmagicE/gmqtt/pkg/codes"
+	"github.com/DrmagicE/gmqtt/pkg/packets"
+	"github.com/DrmagicE/gmqtt/server"
+)
+
+func init() {
+	log = zap.NewNop()
+}
+
+// fakeRegistry counts the lookups of the devices.
+type fakeRegistry struct {
+	devices map[string]*Device
+	err     error
+	lookups int
+}
+
+func (f *fakeRegistry) Lookup(ctx context.Context, clientID string) (*Device, error) {
+	f.lookups++
+	if f.err != nil {
+		return nil, f.err
+	}
+	if d, ok := f.devices[clientID]; ok {
+		return d, nil
+	}
+	return nil, ErrNotFound
+}
+
+func newDevreg(cfg Config, registry Registry, cert []byte) *Devreg {
+	return &Devreg{
+		config:   &cfg,
+		registry: registry,
+		cache:    newCache(cfg.Cache),
+		connectionState: func(conn net.Conn) (tls.ConnectionState, bool) {
+			if cert == nil {
+				return tls.ConnectionState{}, false
+			}
+			return tls.ConnectionState{PeerCertificates: []*x509.Certificate{{Raw: cert}}}, true
+		},
+	}
+}
+
+func newMockClient(ctrl *gomock.Controller, version packets.Version) *server.MockClient {
+	client := server.NewMockClient(ctrl)
+	client.EXPECT().Connection().Return(nil).AnyTimes()
+	client.EXPECT().Version().Return(version).AnyTimes()
+	return client
+}
+
+func connect(d *Devreg, client server.Client, clientID string) (*server.AuthOptions, error) {
+	req := &server.ConnectRequest{
+		Connect: &packets.Connect{ClientID: []byte(clientID)},
+		Options: &server.AuthOptions{MaxInflight: 100},
+	}
+	err := d.OnBasicAuthWrapper(func(ctx context.Context, client server.Client, req *server.ConnectRequest) error {
+		return nil
+	})(context.Background(), client, req)
+	return req.Options, err
+}
+
+func TestConfig_Validate(t *testing.T) {
+	a := assert.New(t)
+	a.NoError(DefaultConfig.Validate())
+
+	cfg := DefaultConfig
+	cfg.Registry = "unknown"
+	a.Error(cfg.Validate())
+
+	cfg = DefaultConfig
+	cfg.HTTP.URL = "http://127.0.0.1/devices"
+	a.Error(cfg.Validate())
+
+	cfg = DefaultConfig
+	cfg.Cache.MaxEntries = 0
+	a.Error(cfg.Validate())
+
+	cfg = DefaultConfig
+	cfg.Cache.NegativeTTL = -1
+	a.Error(cfg.Validate())
+
+	cfg = DefaultConfig
+	cfg.TenantAttribute = ""
+	a.Error(cfg.Validate())
+}
+
+func TestHTTPRegistry_Lookup(t *testing.T) {
+	a := assert.New(t)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		a.Equal("Bearer token", r.Header.Get("Authorization"))
+		switch r.URL.EscapedPath() {
+		case "/devices/dev1":
+			w.Write([]byte(`{"cert_fingerprint":"ab:cd","tenant":"acme","attributes":{"model":"x1"},"max_inflight":10}`))
+		case "/devices/a%2Fb":
+			w.Write([]byte(`{"disabled":true}`))
+		case "/devices/invalid":
+			w.Write([]byte(`{`))
+		case "/devices/error":
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+
+	cfg := DefaultConfig
+	cfg.HTTP.URL = ts.URL + "/devices/{client_id}"
+	cfg.HTTP.Headers = map[string]string{"Authorization": "Bearer token"}
+	r, err := newHTTPRegistry(&cfg)
+	a.NoError(err)
+
+	d, err := r.Lookup(context.Background(), "dev1")
+	a.NoError(err)
+	a.Equal(&Device{
+		CertFingerprint: "ab:cd",
+		Tenant:          "acme",
+		Attributes:      map[string]string{"model": "x1"},
+		MaxInflight:     10,
+	}, d)
+
+	d, err = r.Lookup(context.Background(), "a/b")
+	a.NoError(err)
+	a.True(d.Disabled)
+
+	_, err = r.Lookup(context.Background(), "unknown")
+	a.Equal(ErrNotFound, err)
+
+	_, err = r.Lookup(context.Background(), "invalid")
+	a.Error(err)
+
+	_, err = r.Lookup(context.Background(), "error")
+	a.Error(err)
+	a.NotEqual(ErrNotFound, err)
+}
+
+func TestCache(t *testing.T) {
+	a := assert.New(t)
+	c := newCache(CacheConfig{TTL: time.Minute, NegativeTTL: time.Second, MaxEntries: 2})
+	now := time.Now()
+	d := &Device{Tenant: "acme"}
+
+	c.set("a", d, now)
+	c.set("b", nil, now)
+	rs, ok := c.get("a", now)
+	a.True(ok)
+	a.Equal(d, rs)
+	rs, ok = c.get("b", now)
+	a.True(ok)
+	a.Nil(rs)
+
+	// the negative result expires earlier.
+	_, ok = c.get("b", now.Add(time.Second))
+	a.False(ok)
+	_, ok = c.get("a", now.Add(time.Second))
+	a.True(ok)
+
+	// the least recently used one is evicted.
+	c.set("b", nil, now)
+	c.get("a", now)
+	c.set("c", d, now)
+	_, ok = c.get("b", now)
+	a.False(ok)
+	_, ok = c.get("a", now)
+	a.True(ok)
+
+	// nothing is cached if the ttl is 0.
+	c = newCache(CacheConfig{TTL: time.Minute, MaxEntries: 2})
+	c.set("a", nil, now)
+	_, ok = c.get("a", now)
+	a.False(ok)
+}
+
+func TestDevreg_OnBasicAuthWrapper(t *testing.T) {
+	a := assert.New(t)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	cert := []byte("cert")
+	sum := sha256.Sum256(cert)
+	registry := &fakeRegistry{
+		devices: map[string]*Device{
+			"dev1": {
+				CertFingerprint:        hex.EncodeToString(sum[:]),
+				Tenant:                 "acme",
+				Attributes:             map[string]string{"model": "x1"},
+				MaxInflight:            10,
+				OutboundBytesPerSecond: 1024,
+			},
+			"dev2":     {CertFingerprint: "00:11"},
+			"disabled": {Disabled: true},
+		},
+	}
+	d := newDevreg(DefaultConfig, registry, cert)
+	v5 := newMockClient(ctrl, packets.Version5)
+	v3 := newMockClient(ctrl, packets.Version311)
+
+	opts, err := connect(d, v5, "dev1")
+	a.NoError(err)
+	a.Equal(map[string]string{"model": "x1", "tenant": "acme"}, opts.Attributes)
+	a.EqualValues(10, opts.MaxInflight)
+	a.Equal(1024, opts.OutboundBytesPerSecond)
+	// the result is cached.
+	_, err = connect(d, v5, "dev1")
+	a.NoError(err)
+	a.Equal(1, registry.lookups)
+
+	_, err = connect(d, v5, "dev2")
+	a.Equal(codes.NotAuthorized, err.(*codes.Error).Code)
+	_, err = connect(d, v3, "disabled")
+	a.EqualValues(codes.V3NotAuthorized, err.(*codes.Error).Code)
+	_, err = connect(d, v5, "unknown")
+	a.Equal(codes.NotAuthorized, err.(*codes.Error).Code)
+	_, err = connect(d, v5, "")
+	a.Equal(codes.ClientIdentifierNotValid, err.(*codes.Error).Code)
+
+	registry.err = errors.New("registry unavailable")
+	_, err = connect(d, v5, "dev3")
+	a.Equal(codes.ServerUnavailable, err.(*codes.Error).Code)
+	_, err = connect(d, v3, "dev3")
+	a.EqualValues(codes.V3ServerUnavaliable, err.(*codes.Error).Code)
+	// the cached results are still used.
+	_, err = connect(d, v5, "dev1")
+	a.NoError(err)
+
+	cfg := DefaultConfig
+	cfg.AllowUnregistered = true
+	cfg.FailOpen = true
+	d = newDevreg(cfg, registry, nil)
+	opts, err = connect(d, v5, "dev3")
+	a.NoError(err)
+	a.EqualValues(100, opts.MaxInflight)
+	registry.err = nil
+	opts, err = connect(d, v5, "unknown")
+	a.NoError(err)
+	a.Nil(opts.Attributes)
+	// the client without the client certificate does not match the fingerprint.
+	_, err = connect(d, v5, "dev1")
+	a.Equal(codes.NotAuthorized, err.(*codes.Error).Code)
+}
@@ -0,0 +1,85 @@
+package devreg
+
+import (
+	"context"
+
+	"go.uber.org/zap"
+
+	"github.com/DrmagicE/gmqtt/pkg/codes"
+	"github.com/DrmagicE/gmqtt/pkg/packets"
+	"github.com/DrmagicE/gmqtt/server"
+)
+
+func (d *Devreg) HookWrapper() server.HookWrapper {
+	return server.HookWrapper{
+		OnBasicAuthWrapper: d.OnBasicAuthWrapper,
+	}
+}
+
+// refuse returns the error to refuse the connection with the v5 code, or the v3 code for v3 clients.
+func refuse(client server.Client, code, v3Code codes.Code) error {
+	if packets.IsVersion3X(client.Version()) {
+		return &codes.Error{Code: v3Code}
+	}
+	return &codes.Error{Code: code}
+}
+
+func (d *Devreg) OnBasicAuthWrapper(pre server.OnBasicAuth) server.OnBasicAuth {
+	return func(ctx context.Context, client server.Client, req *server.ConnectRequest) (err error) {
+		err = pre(ctx, client, req)
+		if err != nil {
+			return err
+		}
+		clientID := string(req.Connect.ClientID)
+		if clientID == "" {
+			if d.config.AllowUnregistered {
+				return nil
+			}
+			return refuse(client, codes.ClientIdentifierNotValid, codes.V3IdentifierRejected)
+		}
+		device, err := d.lookup(ctx, clientID)
+		if err != nil {
+			log.Error("failed to lookup device", zap.String("client_id", clientID), zap.Error(err))
+			if d.config.FailOpen {
+				return nil
+			}
+			return refuse(client, codes.ServerUnavailable, codes.V3ServerUnavaliable)
+		}
+		if device == nil {
+			if d.config.AllowUnregistered {
+				return nil
+			}
+			log.Debug("unregistered device refused", zap.String("client_id", clientID))
+			return refuse(client, codes.NotAuthorized, codes.V3NotAuthorized)
+		}
+		if device.Disabled {
+			log.Debug("disabled device refused", zap.String("client_id", clientID))
+			return refuse(client, codes.NotAuthorized, codes.V3NotAuthorized)
+		}
+		if device.CertFingerprint != "" && normalizeFingerprint(device.CertFingerprint) != d.certFingerprint(client.Connection()) {
+			log.Debug("certificate fingerprint mismatch", zap.String("client_id", clientID))
+			return refuse(client, codes.NotAuthorized, codes.V3NotAuthorized)
+		}
+		applyDevice(req.Options, device, d.config.TenantAttribute)
+		return nil
+	}
+}
+
+// applyDevice applies the tenant, the attributes and the quotas of the device to the auth options.
+func applyDevice(opts *server.AuthOptions, device *Device, tenantAttribute string) {
+	if opts.Attributes == nil && (len(device.Attributes) != 0 || device.Tenant != "") {
+		opts.Attributes = make(map[string]string)
+	}
+	for k, v := range device.Attributes {
+		opts.Attributes[k] = v
+	}
+	if device.Tenant != "" {
+		opts.Attributes[tenantAttribute] = device.Tenant
+	}
+	if device.MaxInflight != 0 {
+		opts.MaxInflight = device.MaxInflight
+	}
+	if device.OutboundBytesPerSecond != 0 {
+		opts.OutboundBytesPerSecond = device.OutboundBytesPerSecond
+	}
+}
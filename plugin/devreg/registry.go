@@ -0,0 +1,112 @@
+package devreg
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/DrmagicE/gmqtt/pkg/proxy"
+)
+
+// RegistryHTTP is the name of the bundled http registry.
+const RegistryHTTP = "http"
+
+// maxDeviceSize is the maximum size of the device record fetched from the http registry.
+const maxDeviceSize = 1 << 20
+
+// ErrNotFound is returned by Registry.Lookup if the client id is not in the registry.
+var ErrNotFound = errors.New("device not found")
+
+// Device is the record of a device in the registry.
+type Device struct {
+	// CertFingerprint is the expected hex encoded SHA-256 fingerprint of the client certificate, empty means not checked.
+	// The colons and the case are ignored, e.g. "AB:CD:..." equals "abcd...".
+	CertFingerprint string `json:"cert_fingerprint"`
+	// Tenant is the tenant of the device, which is stored in the client attribute of Config.TenantAttribute.
+	Tenant string `json:"tenant"`
+	// Attributes is the additional client attributes, see server.AuthOptions.Attributes.
+	Attributes map[string]string `json:"attributes"`
+	// MaxInflight overrides server.AuthOptions.MaxInflight if it is not 0.
+	MaxInflight uint16 `json:"max_inflight"`
+	// OutboundBytesPerSecond overrides server.AuthOptions.OutboundBytesPerSecond if it is not 0.
+	OutboundBytesPerSecond int `json:"outbound_bytes_per_second"`
+	// Disabled indicates whether the device is not allowed to connect.
+	Disabled bool `json:"disabled"`
+}
+
+// Registry resolves the client ids against the external device registry.
+type Registry interface {
+	// Lookup returns the device of the client id, or ErrNotFound if the client id is not in the registry.
+	// Other errors indicate the registry is unavailable.
+	Lookup(ctx context.Context, clientID string) (*Device, error)
+}
+
+// NewRegistry creates a Registry from the plugin config.
+type NewRegistry func(config *Config) (Registry, error)
+
+var registries = map[string]NewRegistry{
+	RegistryHTTP: newHTTPRegistry,
+}
+
+// RegisterRegistry registers a Registry implementation with the name, which can be used in the registry config.
+// It is not thread-safe and should be called in init function.
+func RegisterRegistry(name string, new NewRegistry) {
+	if _, ok := registries[name]; ok {
+		panic(fmt.Sprintf("duplicated registry: %s", name))
+	}
+	registries[name] = new
+}
+
+// httpRegistry fetches the device by GET request, the response body is the JSON encoded Device.
+// The status code 404 means the client id is not in the registry.
+type httpRegistry struct {
+	config *HTTPConfig
+	client *http.Client
+}
+
+func newHTTPRegistry(config *Config) (Registry, error) {
+	transport, err := proxy.HTTPTransport(config.HTTP.Proxy)
+	if err != nil {
+		return nil, err
+	}
+	return &httpRegistry{
+		config: &config.HTTP,
+		client: &http.Client{
+			Transport: transport,
+			Timeout:   config.HTTP.Timeout,
+		},
+	}, nil
+}
+
+func (h *httpRegistry) Lookup(ctx context.Context, clientID string) (*Device, error) {
+	u := strings.Replace(h.config.URL, "{client_id}", url.PathEscape(clientID), -1)
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range h.config.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := h.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, ErrNotFound
+	default:
+		return nil, fmt.Errorf("unexpected status code from registry: %d", resp.StatusCode)
+	}
+	d := &Device{}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxDeviceSize)).Decode(d); err != nil {
+		return nil, fmt.Errorf("invalid device: %s", err)
+	}
+	return d, nil
+}
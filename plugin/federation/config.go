@@ -17,6 +17,8 @@ const (
 	DefaultGossipPort    = "8902"
 	DefaultRetryInterval = 5 * time.Second
 	DefaultRetryTimeout  = 1 * time.Minute
+	// DefaultSessionTakeoverTimeout is the default value of Config.SessionTakeoverTimeout.
+	DefaultSessionTakeoverTimeout = 3 * time.Second
 )
 
 // stub function for testing
@@ -60,6 +62,9 @@ type Config struct {
 	// the cluster until an explicit join is received. If this is set to
 	// true, we ignore the leave, and rejoin the cluster on start.
 	RejoinAfterLeave bool `yaml:"rejoin_after_leave"`
+	// SessionTakeoverTimeout is the timeout to take over the client from other nodes after its session is created or resumed on the node.
+	// Other nodes which do not respond in time are ignored, the client may connect to more than one node in that case. Defaults to 3s.
+	SessionTakeoverTimeout time.Duration `yaml:"session_takeover_timeout"`
 }
 
 func isPortNumber(port string) bool {
@@ -149,6 +154,9 @@ func (c *Config) Validate() (err error) {
 	if c.RetryTimeout <= 0 {
 		return fmt.Errorf("invalid retry_timeout: %d", c.RetryTimeout)
 	}
+	if c.SessionTakeoverTimeout <= 0 {
+		return fmt.Errorf("invalid session_takeover_timeout: %d", c.SessionTakeoverTimeout)
+	}
 	return nil
 }
 
@@ -161,12 +169,13 @@ func init() {
 		panic(err)
 	}
 	DefaultConfig = Config{
-		NodeName:      hostName,
-		FedAddr:       ":" + DefaultFedPort,
-		GossipAddr:    ":" + DefaultFedPort,
-		RetryJoin:     nil,
-		RetryInterval: DefaultRetryInterval,
-		RetryTimeout:  DefaultRetryTimeout,
+		NodeName:               hostName,
+		FedAddr:                ":" + DefaultFedPort,
+		GossipAddr:             ":" + DefaultFedPort,
+		RetryJoin:              nil,
+		RetryInterval:          DefaultRetryInterval,
+		RetryTimeout:           DefaultRetryTimeout,
+		SessionTakeoverTimeout: DefaultSessionTakeoverTimeout,
 	}
 }
 
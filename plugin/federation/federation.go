@@ -97,7 +97,8 @@ func New(config config.Config) (server.Plugin, error) {
 			TrieDB:     mem.NewStore(),
 			sharedSent: map[string]uint64{},
 		},
-		serfEventCh: make(chan serf.Event, 10000),
+		sessionOwners: newSessionOwners(),
+		serfEventCh:   make(chan serf.Event, 10000),
 		sessionMgr: &sessionMgr{
 			sessions: map[string]*session{},
 		},
@@ -135,10 +136,15 @@ type Federation struct {
 	// fedSubStore store federation subscription tree which take nodeName as the subscriber identifier.
 	// It is used to determine which node the incoming message should be routed to.
 	fedSubStore *fedSubStore
+	// sessionOwners store the nodes which have the session of the client.
+	// It is used to determine which nodes the client should be taken over from.
+	sessionOwners *sessionOwners
 	// retainedStore store is the retained store of the gmqtt core.
 	// Retained message will be broadcast to other nodes in the federation.
 	retainedStore retained.Store
 	publisher     server.Publisher
+	// clientService is used to take over the clients and their sessions from other nodes.
+	clientService server.ClientService
 	exit          chan struct{}
 	memberMu      sync.Mutex
 	peers         map[string]*peer
@@ -408,6 +414,7 @@ func (f *Federation) Hello(ctx context.Context, req *ClientHello) (resp *ServerH
 	cleanStart, nextID := f.sessionMgr.add(nodeName, req.SessionId)
 	if cleanStart {
 		_ = f.fedSubStore.UnsubscribeAll(nodeName)
+		f.sessionOwners.removeNode(nodeName)
 	}
 	resp = &ServerHello{
 		CleanStart:  cleanStart,
@@ -444,6 +451,14 @@ func (f *Federation) eventStreamHandler(sess *session, in *Event) (ack *Ack) {
 		_ = f.fedSubStore.Unsubscribe(sess.nodeName, unsub.TopicName)
 		return &Ack{EventId: eventID}
 	}
+	if created := in.GetSessionCreated(); created != nil {
+		f.sessionOwners.add(sess.nodeName, created.ClientId)
+		return &Ack{EventId: eventID}
+	}
+	if terminated := in.GetSessionTerminated(); terminated != nil {
+		f.sessionOwners.remove(sess.nodeName, terminated.ClientId)
+		return &Ack{EventId: eventID}
+	}
 	return nil
 }
 
@@ -532,6 +547,7 @@ func (f *Federation) Load(service server.Server) error {
 	f.localSubStore.init(service.SubscriptionService())
 	f.retainedStore = service.RetainedService()
 	f.publisher = service.Publisher()
+	f.clientService = service.ClientService()
 	srv := grpc.NewServer()
 	RegisterFederationServer(srv, f)
 	l, err := net.Listen("tcp", f.config.FedAddr)
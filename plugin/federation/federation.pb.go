@@ -1,26 +1,31 @@
 // Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.22.0
+// 	protoc        v3.13.0
 // source: federation.proto
 
 package federation
 
 import (
-	fmt "fmt"
 	proto "github.com/golang/protobuf/proto"
-	_ "github.com/golang/protobuf/ptypes/empty"
+	empty "github.com/golang/protobuf/ptypes/empty"
 	_ "google.golang.org/genproto/googleapis/api/annotations"
-	math "math"
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
 )
 
-// Reference imports to suppress errors if they are not otherwise used.
-var _ = proto.Marshal
-var _ = fmt.Errorf
-var _ = math.Inf
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
 
-// This is a compile-time assertion to ensure that this generated file
-// is compatible with the proto package it is being compiled against.
-// A compilation error at this line likely means your copy of the
-// proto package needs to be updated.
-const _ = proto.ProtoPackageIsVersion3 // please upgrade the proto package
+// This is a compile-time assertion that a sufficiently up-to-date version
+// of the legacy proto package is being used.
+const _ = proto.ProtoPackageIsVersion4
 
 type Status int32
 
@@ -32,72 +37,145 @@ const (
 	Status_STATUS_FAILED      Status = 4
 )
 
-var Status_name = map[int32]string{
-	0: "STATUS_UNSPECIFIED",
-	1: "STATUS_ALIVE",
-	2: "STATUS_LEAVING",
-	3: "STATUS_LEFT",
-	4: "STATUS_FAILED",
-}
+// Enum value maps for Status.
+var (
+	Status_name = map[int32]string{
+		0: "STATUS_UNSPECIFIED",
+		1: "STATUS_ALIVE",
+		2: "STATUS_LEAVING",
+		3: "STATUS_LEFT",
+		4: "STATUS_FAILED",
+	}
+	Status_value = map[string]int32{
+		"STATUS_UNSPECIFIED": 0,
+		"STATUS_ALIVE":       1,
+		"STATUS_LEAVING":     2,
+		"STATUS_LEFT":        3,
+		"STATUS_FAILED":      4,
+	}
+)
 
-var Status_value = map[string]int32{
-	"STATUS_UNSPECIFIED": 0,
-	"STATUS_ALIVE":       1,
-	"STATUS_LEAVING":     2,
-	"STATUS_LEFT":        3,
-	"STATUS_FAILED":      4,
+func (x Status) Enum() *Status {
+	p := new(Status)
+	*p = x
+	return p
 }
 
 func (x Status) String() string {
-	return proto.EnumName(Status_name, int32(x))
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (Status) Descriptor() protoreflect.EnumDescriptor {
+	return file_federation_proto_enumTypes[0].Descriptor()
+}
+
+func (Status) Type() protoreflect.EnumType {
+	return &file_federation_proto_enumTypes[0]
+}
+
+func (x Status) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
 }
 
+// Deprecated: Use Status.Descriptor instead.
 func (Status) EnumDescriptor() ([]byte, []int) {
-	return fileDescriptor_7217fe47f5d68a8f, []int{0}
+	return file_federation_proto_rawDescGZIP(), []int{0}
 }
 
 type Event struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
 	Id uint64 `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
-	// Types that are valid to be assigned to Event:
+	// Types that are assignable to Event:
 	//	*Event_Subscribe
 	//	*Event_Message
 	//	*Event_Unsubscribe
-	Event                isEvent_Event `protobuf_oneof:"Event"`
-	XXX_NoUnkeyedLiteral struct{}      `json:"-"`
-	XXX_unrecognized     []byte        `json:"-"`
-	XXX_sizecache        int32         `json:"-"`
+	//	*Event_SessionCreated
+	//	*Event_SessionTerminated
+	Event isEvent_Event `protobuf_oneof:"Event"`
+}
+
+func (x *Event) Reset() {
+	*x = Event{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_federation_proto_msgTypes[0]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *Event) String() string {
+	return protoimpl.X.MessageStringOf(x)
 }
 
-func (m *Event) Reset()         { *m = Event{} }
-func (m *Event) String() string { return proto.CompactTextString(m) }
-func (*Event) ProtoMessage()    {}
+func (*Event) ProtoMessage() {}
+
+func (x *Event) ProtoReflect() protoreflect.Message {
+	mi := &file_federation_proto_msgTypes[0]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Event.ProtoReflect.Descriptor instead.
 func (*Event) Descriptor() ([]byte, []int) {
-	return fileDescriptor_7217fe47f5d68a8f, []int{0}
+	return file_federation_proto_rawDescGZIP(), []int{0}
 }
 
-func (m *Event) XXX_Unmarshal(b []byte) error {
-	return xxx_messageInfo_Event.Unmarshal(m, b)
+func (x *Event) GetId() uint64 {
+	if x != nil {
+		return x.Id
+	}
+	return 0
 }
-func (m *Event) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
-	return xxx_messageInfo_Event.Marshal(b, m, deterministic)
+
+func (m *Event) GetEvent() isEvent_Event {
+	if m != nil {
+		return m.Event
+	}
+	return nil
 }
-func (m *Event) XXX_Merge(src proto.Message) {
-	xxx_messageInfo_Event.Merge(m, src)
+
+func (x *Event) GetSubscribe() *Subscribe {
+	if x, ok := x.GetEvent().(*Event_Subscribe); ok {
+		return x.Subscribe
+	}
+	return nil
 }
-func (m *Event) XXX_Size() int {
-	return xxx_messageInfo_Event.Size(m)
+
+func (x *Event) GetMessage() *Message {
+	if x, ok := x.GetEvent().(*Event_Message); ok {
+		return x.Message
+	}
+	return nil
 }
-func (m *Event) XXX_DiscardUnknown() {
-	xxx_messageInfo_Event.DiscardUnknown(m)
+
+func (x *Event) GetUnsubscribe() *Unsubscribe {
+	if x, ok := x.GetEvent().(*Event_Unsubscribe); ok {
+		return x.Unsubscribe
+	}
+	return nil
 }
 
-var xxx_messageInfo_Event proto.InternalMessageInfo
+func (x *Event) GetSessionCreated() *SessionCreated {
+	if x, ok := x.GetEvent().(*Event_SessionCreated); ok {
+		return x.SessionCreated
+	}
+	return nil
+}
 
-func (m *Event) GetId() uint64 {
-	if m != nil {
-		return m.Id
+func (x *Event) GetSessionTerminated() *SessionTerminated {
+	if x, ok := x.GetEvent().(*Event_SessionTerminated); ok {
+		return x.SessionTerminated
 	}
-	return 0
+	return nil
 }
 
 type isEvent_Event interface {
@@ -116,685 +194,1329 @@ type Event_Unsubscribe struct {
 	Unsubscribe *Unsubscribe `protobuf:"bytes,4,opt,name=unsubscribe,proto3,oneof"`
 }
 
+type Event_SessionCreated struct {
+	SessionCreated *SessionCreated `protobuf:"bytes,5,opt,name=session_created,json=sessionCreated,proto3,oneof"`
+}
+
+type Event_SessionTerminated struct {
+	SessionTerminated *SessionTerminated `protobuf:"bytes,6,opt,name=session_terminated,json=sessionTerminated,proto3,oneof"`
+}
+
 func (*Event_Subscribe) isEvent_Event() {}
 
 func (*Event_Message) isEvent_Event() {}
 
 func (*Event_Unsubscribe) isEvent_Event() {}
 
-func (m *Event) GetEvent() isEvent_Event {
-	if m != nil {
-		return m.Event
-	}
-	return nil
-}
+func (*Event_SessionCreated) isEvent_Event() {}
 
-func (m *Event) GetSubscribe() *Subscribe {
-	if x, ok := m.GetEvent().(*Event_Subscribe); ok {
-		return x.Subscribe
-	}
-	return nil
-}
+func (*Event_SessionTerminated) isEvent_Event() {}
 
-func (m *Event) GetMessage() *Message {
-	if x, ok := m.GetEvent().(*Event_Message); ok {
-		return x.Message
-	}
-	return nil
-}
+// Subscribe represents the subscription for a node, it is used to route message among nodes,
+// so only shared_name and topic_filter is required.
+type Subscribe struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
 
-func (m *Event) GetUnsubscribe() *Unsubscribe {
-	if x, ok := m.GetEvent().(*Event_Unsubscribe); ok {
-		return x.Unsubscribe
-	}
-	return nil
+	ShareName   string `protobuf:"bytes,1,opt,name=share_name,json=shareName,proto3" json:"share_name,omitempty"`
+	TopicFilter string `protobuf:"bytes,2,opt,name=topic_filter,json=topicFilter,proto3" json:"topic_filter,omitempty"`
 }
 
-// XXX_OneofWrappers is for the internal use of the proto package.
-func (*Event) XXX_OneofWrappers() []interface{} {
-	return []interface{}{
-		(*Event_Subscribe)(nil),
-		(*Event_Message)(nil),
-		(*Event_Unsubscribe)(nil),
+func (x *Subscribe) Reset() {
+	*x = Subscribe{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_federation_proto_msgTypes[1]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
 	}
 }
 
-// Subscribe represents the subscription for a node, it is used to route message among nodes,
-// so only shared_name and topic_filter is required.
-type Subscribe struct {
-	ShareName            string   `protobuf:"bytes,1,opt,name=share_name,json=shareName,proto3" json:"share_name,omitempty"`
-	TopicFilter          string   `protobuf:"bytes,2,opt,name=topic_filter,json=topicFilter,proto3" json:"topic_filter,omitempty"`
-	XXX_NoUnkeyedLiteral struct{} `json:"-"`
-	XXX_unrecognized     []byte   `json:"-"`
-	XXX_sizecache        int32    `json:"-"`
+func (x *Subscribe) String() string {
+	return protoimpl.X.MessageStringOf(x)
 }
 
-func (m *Subscribe) Reset()         { *m = Subscribe{} }
-func (m *Subscribe) String() string { return proto.CompactTextString(m) }
-func (*Subscribe) ProtoMessage()    {}
-func (*Subscribe) Descriptor() ([]byte, []int) {
-	return fileDescriptor_7217fe47f5d68a8f, []int{1}
-}
+func (*Subscribe) ProtoMessage() {}
 
-func (m *Subscribe) XXX_Unmarshal(b []byte) error {
-	return xxx_messageInfo_Subscribe.Unmarshal(m, b)
-}
-func (m *Subscribe) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
-	return xxx_messageInfo_Subscribe.Marshal(b, m, deterministic)
-}
-func (m *Subscribe) XXX_Merge(src proto.Message) {
-	xxx_messageInfo_Subscribe.Merge(m, src)
-}
-func (m *Subscribe) XXX_Size() int {
-	return xxx_messageInfo_Subscribe.Size(m)
-}
-func (m *Subscribe) XXX_DiscardUnknown() {
-	xxx_messageInfo_Subscribe.DiscardUnknown(m)
+func (x *Subscribe) ProtoReflect() protoreflect.Message {
+	mi := &file_federation_proto_msgTypes[1]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
 }
 
-var xxx_messageInfo_Subscribe proto.InternalMessageInfo
+// Deprecated: Use Subscribe.ProtoReflect.Descriptor instead.
+func (*Subscribe) Descriptor() ([]byte, []int) {
+	return file_federation_proto_rawDescGZIP(), []int{1}
+}
 
-func (m *Subscribe) GetShareName() string {
-	if m != nil {
-		return m.ShareName
+func (x *Subscribe) GetShareName() string {
+	if x != nil {
+		return x.ShareName
 	}
 	return ""
 }
 
-func (m *Subscribe) GetTopicFilter() string {
-	if m != nil {
-		return m.TopicFilter
+func (x *Subscribe) GetTopicFilter() string {
+	if x != nil {
+		return x.TopicFilter
 	}
 	return ""
 }
 
 type Message struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
 	TopicName string `protobuf:"bytes,1,opt,name=topic_name,json=topicName,proto3" json:"topic_name,omitempty"`
 	Payload   []byte `protobuf:"bytes,2,opt,name=payload,proto3" json:"payload,omitempty"`
 	Qos       uint32 `protobuf:"varint,3,opt,name=qos,proto3" json:"qos,omitempty"`
 	Retained  bool   `protobuf:"varint,4,opt,name=retained,proto3" json:"retained,omitempty"`
 	// the following fields are using in v5 client.
-	ContentType          string          `protobuf:"bytes,5,opt,name=content_type,json=contentType,proto3" json:"content_type,omitempty"`
-	CorrelationData      string          `protobuf:"bytes,6,opt,name=correlation_data,json=correlationData,proto3" json:"correlation_data,omitempty"`
-	MessageExpiry        uint32          `protobuf:"varint,7,opt,name=message_expiry,json=messageExpiry,proto3" json:"message_expiry,omitempty"`
-	PayloadFormat        uint32          `protobuf:"varint,8,opt,name=payload_format,json=payloadFormat,proto3" json:"payload_format,omitempty"`
-	ResponseTopic        string          `protobuf:"bytes,9,opt,name=response_topic,json=responseTopic,proto3" json:"response_topic,omitempty"`
-	UserProperties       []*UserProperty `protobuf:"bytes,10,rep,name=user_properties,json=userProperties,proto3" json:"user_properties,omitempty"`
-	XXX_NoUnkeyedLiteral struct{}        `json:"-"`
-	XXX_unrecognized     []byte          `json:"-"`
-	XXX_sizecache        int32           `json:"-"`
-}
-
-func (m *Message) Reset()         { *m = Message{} }
-func (m *Message) String() string { return proto.CompactTextString(m) }
-func (*Message) ProtoMessage()    {}
-func (*Message) Descriptor() ([]byte, []int) {
-	return fileDescriptor_7217fe47f5d68a8f, []int{2}
+	ContentType     string          `protobuf:"bytes,5,opt,name=content_type,json=contentType,proto3" json:"content_type,omitempty"`
+	CorrelationData string          `protobuf:"bytes,6,opt,name=correlation_data,json=correlationData,proto3" json:"correlation_data,omitempty"`
+	MessageExpiry   uint32          `protobuf:"varint,7,opt,name=message_expiry,json=messageExpiry,proto3" json:"message_expiry,omitempty"`
+	PayloadFormat   uint32          `protobuf:"varint,8,opt,name=payload_format,json=payloadFormat,proto3" json:"payload_format,omitempty"`
+	ResponseTopic   string          `protobuf:"bytes,9,opt,name=response_topic,json=responseTopic,proto3" json:"response_topic,omitempty"`
+	UserProperties  []*UserProperty `protobuf:"bytes,10,rep,name=user_properties,json=userProperties,proto3" json:"user_properties,omitempty"`
+}
+
+func (x *Message) Reset() {
+	*x = Message{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_federation_proto_msgTypes[2]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
 }
 
-func (m *Message) XXX_Unmarshal(b []byte) error {
-	return xxx_messageInfo_Message.Unmarshal(m, b)
-}
-func (m *Message) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
-	return xxx_messageInfo_Message.Marshal(b, m, deterministic)
-}
-func (m *Message) XXX_Merge(src proto.Message) {
-	xxx_messageInfo_Message.Merge(m, src)
-}
-func (m *Message) XXX_Size() int {
-	return xxx_messageInfo_Message.Size(m)
+func (x *Message) String() string {
+	return protoimpl.X.MessageStringOf(x)
 }
-func (m *Message) XXX_DiscardUnknown() {
-	xxx_messageInfo_Message.DiscardUnknown(m)
+
+func (*Message) ProtoMessage() {}
+
+func (x *Message) ProtoReflect() protoreflect.Message {
+	mi := &file_federation_proto_msgTypes[2]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
 }
 
-var xxx_messageInfo_Message proto.InternalMessageInfo
+// Deprecated: Use Message.ProtoReflect.Descriptor instead.
+func (*Message) Descriptor() ([]byte, []int) {
+	return file_federation_proto_rawDescGZIP(), []int{2}
+}
 
-func (m *Message) GetTopicName() string {
-	if m != nil {
-		return m.TopicName
+func (x *Message) GetTopicName() string {
+	if x != nil {
+		return x.TopicName
 	}
 	return ""
 }
 
-func (m *Message) GetPayload() []byte {
-	if m != nil {
-		return m.Payload
+func (x *Message) GetPayload() []byte {
+	if x != nil {
+		return x.Payload
 	}
 	return nil
 }
 
-func (m *Message) GetQos() uint32 {
-	if m != nil {
-		return m.Qos
+func (x *Message) GetQos() uint32 {
+	if x != nil {
+		return x.Qos
 	}
 	return 0
 }
 
-func (m *Message) GetRetained() bool {
-	if m != nil {
-		return m.Retained
+func (x *Message) GetRetained() bool {
+	if x != nil {
+		return x.Retained
 	}
 	return false
 }
 
-func (m *Message) GetContentType() string {
-	if m != nil {
-		return m.ContentType
+func (x *Message) GetContentType() string {
+	if x != nil {
+		return x.ContentType
 	}
 	return ""
 }
 
-func (m *Message) GetCorrelationData() string {
-	if m != nil {
-		return m.CorrelationData
+func (x *Message) GetCorrelationData() string {
+	if x != nil {
+		return x.CorrelationData
 	}
 	return ""
 }
 
-func (m *Message) GetMessageExpiry() uint32 {
-	if m != nil {
-		return m.MessageExpiry
+func (x *Message) GetMessageExpiry() uint32 {
+	if x != nil {
+		return x.MessageExpiry
 	}
 	return 0
 }
 
-func (m *Message) GetPayloadFormat() uint32 {
-	if m != nil {
-		return m.PayloadFormat
+func (x *Message) GetPayloadFormat() uint32 {
+	if x != nil {
+		return x.PayloadFormat
 	}
 	return 0
 }
 
-func (m *Message) GetResponseTopic() string {
-	if m != nil {
-		return m.ResponseTopic
+func (x *Message) GetResponseTopic() string {
+	if x != nil {
+		return x.ResponseTopic
 	}
 	return ""
 }
 
-func (m *Message) GetUserProperties() []*UserProperty {
-	if m != nil {
-		return m.UserProperties
+func (x *Message) GetUserProperties() []*UserProperty {
+	if x != nil {
+		return x.UserProperties
 	}
 	return nil
 }
 
 type UserProperty struct {
-	K                    []byte   `protobuf:"bytes,1,opt,name=K,proto3" json:"K,omitempty"`
-	V                    []byte   `protobuf:"bytes,2,opt,name=V,proto3" json:"V,omitempty"`
-	XXX_NoUnkeyedLiteral struct{} `json:"-"`
-	XXX_unrecognized     []byte   `json:"-"`
-	XXX_sizecache        int32    `json:"-"`
-}
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
 
-func (m *UserProperty) Reset()         { *m = UserProperty{} }
-func (m *UserProperty) String() string { return proto.CompactTextString(m) }
-func (*UserProperty) ProtoMessage()    {}
-func (*UserProperty) Descriptor() ([]byte, []int) {
-	return fileDescriptor_7217fe47f5d68a8f, []int{3}
+	K []byte `protobuf:"bytes,1,opt,name=K,proto3" json:"K,omitempty"`
+	V []byte `protobuf:"bytes,2,opt,name=V,proto3" json:"V,omitempty"`
 }
 
-func (m *UserProperty) XXX_Unmarshal(b []byte) error {
-	return xxx_messageInfo_UserProperty.Unmarshal(m, b)
-}
-func (m *UserProperty) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
-	return xxx_messageInfo_UserProperty.Marshal(b, m, deterministic)
-}
-func (m *UserProperty) XXX_Merge(src proto.Message) {
-	xxx_messageInfo_UserProperty.Merge(m, src)
+func (x *UserProperty) Reset() {
+	*x = UserProperty{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_federation_proto_msgTypes[3]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
 }
-func (m *UserProperty) XXX_Size() int {
-	return xxx_messageInfo_UserProperty.Size(m)
+
+func (x *UserProperty) String() string {
+	return protoimpl.X.MessageStringOf(x)
 }
-func (m *UserProperty) XXX_DiscardUnknown() {
-	xxx_messageInfo_UserProperty.DiscardUnknown(m)
+
+func (*UserProperty) ProtoMessage() {}
+
+func (x *UserProperty) ProtoReflect() protoreflect.Message {
+	mi := &file_federation_proto_msgTypes[3]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
 }
 
-var xxx_messageInfo_UserProperty proto.InternalMessageInfo
+// Deprecated: Use UserProperty.ProtoReflect.Descriptor instead.
+func (*UserProperty) Descriptor() ([]byte, []int) {
+	return file_federation_proto_rawDescGZIP(), []int{3}
+}
 
-func (m *UserProperty) GetK() []byte {
-	if m != nil {
-		return m.K
+func (x *UserProperty) GetK() []byte {
+	if x != nil {
+		return x.K
 	}
 	return nil
 }
 
-func (m *UserProperty) GetV() []byte {
-	if m != nil {
-		return m.V
+func (x *UserProperty) GetV() []byte {
+	if x != nil {
+		return x.V
 	}
 	return nil
 }
 
 type Unsubscribe struct {
-	TopicName            string   `protobuf:"bytes,1,opt,name=topic_name,json=topicName,proto3" json:"topic_name,omitempty"`
-	XXX_NoUnkeyedLiteral struct{} `json:"-"`
-	XXX_unrecognized     []byte   `json:"-"`
-	XXX_sizecache        int32    `json:"-"`
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	TopicName string `protobuf:"bytes,1,opt,name=topic_name,json=topicName,proto3" json:"topic_name,omitempty"`
+}
+
+func (x *Unsubscribe) Reset() {
+	*x = Unsubscribe{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_federation_proto_msgTypes[4]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
 }
 
-func (m *Unsubscribe) Reset()         { *m = Unsubscribe{} }
-func (m *Unsubscribe) String() string { return proto.CompactTextString(m) }
-func (*Unsubscribe) ProtoMessage()    {}
+func (x *Unsubscribe) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Unsubscribe) ProtoMessage() {}
+
+func (x *Unsubscribe) ProtoReflect() protoreflect.Message {
+	mi := &file_federation_proto_msgTypes[4]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Unsubscribe.ProtoReflect.Descriptor instead.
 func (*Unsubscribe) Descriptor() ([]byte, []int) {
-	return fileDescriptor_7217fe47f5d68a8f, []int{4}
+	return file_federation_proto_rawDescGZIP(), []int{4}
 }
 
-func (m *Unsubscribe) XXX_Unmarshal(b []byte) error {
-	return xxx_messageInfo_Unsubscribe.Unmarshal(m, b)
+func (x *Unsubscribe) GetTopicName() string {
+	if x != nil {
+		return x.TopicName
+	}
+	return ""
 }
-func (m *Unsubscribe) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
-	return xxx_messageInfo_Unsubscribe.Marshal(b, m, deterministic)
+
+// SessionCreated tells the node that the session of the client is created on the sender node,
+// it is used to find the nodes which the client should be taken over from.
+type SessionCreated struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	ClientId string `protobuf:"bytes,1,opt,name=client_id,json=clientId,proto3" json:"client_id,omitempty"`
 }
-func (m *Unsubscribe) XXX_Merge(src proto.Message) {
-	xxx_messageInfo_Unsubscribe.Merge(m, src)
+
+func (x *SessionCreated) Reset() {
+	*x = SessionCreated{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_federation_proto_msgTypes[5]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
 }
-func (m *Unsubscribe) XXX_Size() int {
-	return xxx_messageInfo_Unsubscribe.Size(m)
+
+func (x *SessionCreated) String() string {
+	return protoimpl.X.MessageStringOf(x)
 }
-func (m *Unsubscribe) XXX_DiscardUnknown() {
-	xxx_messageInfo_Unsubscribe.DiscardUnknown(m)
+
+func (*SessionCreated) ProtoMessage() {}
+
+func (x *SessionCreated) ProtoReflect() protoreflect.Message {
+	mi := &file_federation_proto_msgTypes[5]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
 }
 
-var xxx_messageInfo_Unsubscribe proto.InternalMessageInfo
+// Deprecated: Use SessionCreated.ProtoReflect.Descriptor instead.
+func (*SessionCreated) Descriptor() ([]byte, []int) {
+	return file_federation_proto_rawDescGZIP(), []int{5}
+}
 
-func (m *Unsubscribe) GetTopicName() string {
-	if m != nil {
-		return m.TopicName
+func (x *SessionCreated) GetClientId() string {
+	if x != nil {
+		return x.ClientId
 	}
 	return ""
 }
 
-type Ack struct {
-	EventId              uint64   `protobuf:"varint,1,opt,name=event_id,json=eventId,proto3" json:"event_id,omitempty"`
-	XXX_NoUnkeyedLiteral struct{} `json:"-"`
-	XXX_unrecognized     []byte   `json:"-"`
-	XXX_sizecache        int32    `json:"-"`
+// SessionTerminated tells the node that the session of the client is removed from the sender node.
+type SessionTerminated struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	ClientId string `protobuf:"bytes,1,opt,name=client_id,json=clientId,proto3" json:"client_id,omitempty"`
 }
 
-func (m *Ack) Reset()         { *m = Ack{} }
-func (m *Ack) String() string { return proto.CompactTextString(m) }
-func (*Ack) ProtoMessage()    {}
-func (*Ack) Descriptor() ([]byte, []int) {
-	return fileDescriptor_7217fe47f5d68a8f, []int{5}
+func (x *SessionTerminated) Reset() {
+	*x = SessionTerminated{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_federation_proto_msgTypes[6]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *SessionTerminated) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SessionTerminated) ProtoMessage() {}
+
+func (x *SessionTerminated) ProtoReflect() protoreflect.Message {
+	mi := &file_federation_proto_msgTypes[6]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
 }
 
-func (m *Ack) XXX_Unmarshal(b []byte) error {
-	return xxx_messageInfo_Ack.Unmarshal(m, b)
+// Deprecated: Use SessionTerminated.ProtoReflect.Descriptor instead.
+func (*SessionTerminated) Descriptor() ([]byte, []int) {
+	return file_federation_proto_rawDescGZIP(), []int{6}
 }
-func (m *Ack) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
-	return xxx_messageInfo_Ack.Marshal(b, m, deterministic)
+
+func (x *SessionTerminated) GetClientId() string {
+	if x != nil {
+		return x.ClientId
+	}
+	return ""
+}
+
+type Ack struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	EventId uint64 `protobuf:"varint,1,opt,name=event_id,json=eventId,proto3" json:"event_id,omitempty"`
 }
-func (m *Ack) XXX_Merge(src proto.Message) {
-	xxx_messageInfo_Ack.Merge(m, src)
+
+func (x *Ack) Reset() {
+	*x = Ack{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_federation_proto_msgTypes[7]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
 }
-func (m *Ack) XXX_Size() int {
-	return xxx_messageInfo_Ack.Size(m)
+
+func (x *Ack) String() string {
+	return protoimpl.X.MessageStringOf(x)
 }
-func (m *Ack) XXX_DiscardUnknown() {
-	xxx_messageInfo_Ack.DiscardUnknown(m)
+
+func (*Ack) ProtoMessage() {}
+
+func (x *Ack) ProtoReflect() protoreflect.Message {
+	mi := &file_federation_proto_msgTypes[7]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
 }
 
-var xxx_messageInfo_Ack proto.InternalMessageInfo
+// Deprecated: Use Ack.ProtoReflect.Descriptor instead.
+func (*Ack) Descriptor() ([]byte, []int) {
+	return file_federation_proto_rawDescGZIP(), []int{7}
+}
 
-func (m *Ack) GetEventId() uint64 {
-	if m != nil {
-		return m.EventId
+func (x *Ack) GetEventId() uint64 {
+	if x != nil {
+		return x.EventId
 	}
 	return 0
 }
 
 // ClientHello is the request message in handshake process.
 type ClientHello struct {
-	SessionId            string   `protobuf:"bytes,1,opt,name=session_id,json=sessionId,proto3" json:"session_id,omitempty"`
-	XXX_NoUnkeyedLiteral struct{} `json:"-"`
-	XXX_unrecognized     []byte   `json:"-"`
-	XXX_sizecache        int32    `json:"-"`
-}
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
 
-func (m *ClientHello) Reset()         { *m = ClientHello{} }
-func (m *ClientHello) String() string { return proto.CompactTextString(m) }
-func (*ClientHello) ProtoMessage()    {}
-func (*ClientHello) Descriptor() ([]byte, []int) {
-	return fileDescriptor_7217fe47f5d68a8f, []int{6}
+	SessionId string `protobuf:"bytes,1,opt,name=session_id,json=sessionId,proto3" json:"session_id,omitempty"`
 }
 
-func (m *ClientHello) XXX_Unmarshal(b []byte) error {
-	return xxx_messageInfo_ClientHello.Unmarshal(m, b)
-}
-func (m *ClientHello) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
-	return xxx_messageInfo_ClientHello.Marshal(b, m, deterministic)
-}
-func (m *ClientHello) XXX_Merge(src proto.Message) {
-	xxx_messageInfo_ClientHello.Merge(m, src)
+func (x *ClientHello) Reset() {
+	*x = ClientHello{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_federation_proto_msgTypes[8]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
 }
-func (m *ClientHello) XXX_Size() int {
-	return xxx_messageInfo_ClientHello.Size(m)
+
+func (x *ClientHello) String() string {
+	return protoimpl.X.MessageStringOf(x)
 }
-func (m *ClientHello) XXX_DiscardUnknown() {
-	xxx_messageInfo_ClientHello.DiscardUnknown(m)
+
+func (*ClientHello) ProtoMessage() {}
+
+func (x *ClientHello) ProtoReflect() protoreflect.Message {
+	mi := &file_federation_proto_msgTypes[8]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
 }
 
-var xxx_messageInfo_ClientHello proto.InternalMessageInfo
+// Deprecated: Use ClientHello.ProtoReflect.Descriptor instead.
+func (*ClientHello) Descriptor() ([]byte, []int) {
+	return file_federation_proto_rawDescGZIP(), []int{8}
+}
 
-func (m *ClientHello) GetSessionId() string {
-	if m != nil {
-		return m.SessionId
+func (x *ClientHello) GetSessionId() string {
+	if x != nil {
+		return x.SessionId
 	}
 	return ""
 }
 
 // ServerHello is the response message in handshake process.
 type ServerHello struct {
-	CleanStart           bool     `protobuf:"varint,1,opt,name=clean_start,json=cleanStart,proto3" json:"clean_start,omitempty"`
-	NextEventId          uint64   `protobuf:"varint,2,opt,name=next_event_id,json=nextEventId,proto3" json:"next_event_id,omitempty"`
-	XXX_NoUnkeyedLiteral struct{} `json:"-"`
-	XXX_unrecognized     []byte   `json:"-"`
-	XXX_sizecache        int32    `json:"-"`
-}
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
 
-func (m *ServerHello) Reset()         { *m = ServerHello{} }
-func (m *ServerHello) String() string { return proto.CompactTextString(m) }
-func (*ServerHello) ProtoMessage()    {}
-func (*ServerHello) Descriptor() ([]byte, []int) {
-	return fileDescriptor_7217fe47f5d68a8f, []int{7}
+	CleanStart  bool   `protobuf:"varint,1,opt,name=clean_start,json=cleanStart,proto3" json:"clean_start,omitempty"`
+	NextEventId uint64 `protobuf:"varint,2,opt,name=next_event_id,json=nextEventId,proto3" json:"next_event_id,omitempty"`
 }
 
-func (m *ServerHello) XXX_Unmarshal(b []byte) error {
-	return xxx_messageInfo_ServerHello.Unmarshal(m, b)
-}
-func (m *ServerHello) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
-	return xxx_messageInfo_ServerHello.Marshal(b, m, deterministic)
-}
-func (m *ServerHello) XXX_Merge(src proto.Message) {
-	xxx_messageInfo_ServerHello.Merge(m, src)
+func (x *ServerHello) Reset() {
+	*x = ServerHello{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_federation_proto_msgTypes[9]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
 }
-func (m *ServerHello) XXX_Size() int {
-	return xxx_messageInfo_ServerHello.Size(m)
+
+func (x *ServerHello) String() string {
+	return protoimpl.X.MessageStringOf(x)
 }
-func (m *ServerHello) XXX_DiscardUnknown() {
-	xxx_messageInfo_ServerHello.DiscardUnknown(m)
+
+func (*ServerHello) ProtoMessage() {}
+
+func (x *ServerHello) ProtoReflect() protoreflect.Message {
+	mi := &file_federation_proto_msgTypes[9]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
 }
 
-var xxx_messageInfo_ServerHello proto.InternalMessageInfo
+// Deprecated: Use ServerHello.ProtoReflect.Descriptor instead.
+func (*ServerHello) Descriptor() ([]byte, []int) {
+	return file_federation_proto_rawDescGZIP(), []int{9}
+}
 
-func (m *ServerHello) GetCleanStart() bool {
-	if m != nil {
-		return m.CleanStart
+func (x *ServerHello) GetCleanStart() bool {
+	if x != nil {
+		return x.CleanStart
 	}
 	return false
 }
 
-func (m *ServerHello) GetNextEventId() uint64 {
-	if m != nil {
-		return m.NextEventId
+func (x *ServerHello) GetNextEventId() uint64 {
+	if x != nil {
+		return x.NextEventId
 	}
 	return 0
 }
 
 type JoinRequest struct {
-	Hosts                []string `protobuf:"bytes,1,rep,name=hosts,proto3" json:"hosts,omitempty"`
-	XXX_NoUnkeyedLiteral struct{} `json:"-"`
-	XXX_unrecognized     []byte   `json:"-"`
-	XXX_sizecache        int32    `json:"-"`
-}
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
 
-func (m *JoinRequest) Reset()         { *m = JoinRequest{} }
-func (m *JoinRequest) String() string { return proto.CompactTextString(m) }
-func (*JoinRequest) ProtoMessage()    {}
-func (*JoinRequest) Descriptor() ([]byte, []int) {
-	return fileDescriptor_7217fe47f5d68a8f, []int{8}
+	Hosts []string `protobuf:"bytes,1,rep,name=hosts,proto3" json:"hosts,omitempty"`
 }
 
-func (m *JoinRequest) XXX_Unmarshal(b []byte) error {
-	return xxx_messageInfo_JoinRequest.Unmarshal(m, b)
-}
-func (m *JoinRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
-	return xxx_messageInfo_JoinRequest.Marshal(b, m, deterministic)
-}
-func (m *JoinRequest) XXX_Merge(src proto.Message) {
-	xxx_messageInfo_JoinRequest.Merge(m, src)
+func (x *JoinRequest) Reset() {
+	*x = JoinRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_federation_proto_msgTypes[10]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
 }
-func (m *JoinRequest) XXX_Size() int {
-	return xxx_messageInfo_JoinRequest.Size(m)
+
+func (x *JoinRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
 }
-func (m *JoinRequest) XXX_DiscardUnknown() {
-	xxx_messageInfo_JoinRequest.DiscardUnknown(m)
+
+func (*JoinRequest) ProtoMessage() {}
+
+func (x *JoinRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_federation_proto_msgTypes[10]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
 }
 
-var xxx_messageInfo_JoinRequest proto.InternalMessageInfo
+// Deprecated: Use JoinRequest.ProtoReflect.Descriptor instead.
+func (*JoinRequest) Descriptor() ([]byte, []int) {
+	return file_federation_proto_rawDescGZIP(), []int{10}
+}
 
-func (m *JoinRequest) GetHosts() []string {
-	if m != nil {
-		return m.Hosts
+func (x *JoinRequest) GetHosts() []string {
+	if x != nil {
+		return x.Hosts
 	}
 	return nil
 }
 
 type Member struct {
-	Name                 string            `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
-	Addr                 string            `protobuf:"bytes,2,opt,name=addr,proto3" json:"addr,omitempty"`
-	Tags                 map[string]string `protobuf:"bytes,3,rep,name=tags,proto3" json:"tags,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
-	Status               Status            `protobuf:"varint,4,opt,name=status,proto3,enum=gmqtt.federation.api.Status" json:"status,omitempty"`
-	XXX_NoUnkeyedLiteral struct{}          `json:"-"`
-	XXX_unrecognized     []byte            `json:"-"`
-	XXX_sizecache        int32             `json:"-"`
-}
-
-func (m *Member) Reset()         { *m = Member{} }
-func (m *Member) String() string { return proto.CompactTextString(m) }
-func (*Member) ProtoMessage()    {}
-func (*Member) Descriptor() ([]byte, []int) {
-	return fileDescriptor_7217fe47f5d68a8f, []int{9}
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Name   string            `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Addr   string            `protobuf:"bytes,2,opt,name=addr,proto3" json:"addr,omitempty"`
+	Tags   map[string]string `protobuf:"bytes,3,rep,name=tags,proto3" json:"tags,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	Status Status            `protobuf:"varint,4,opt,name=status,proto3,enum=gmqtt.federation.api.Status" json:"status,omitempty"`
+}
+
+func (x *Member) Reset() {
+	*x = Member{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_federation_proto_msgTypes[11]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
 }
 
-func (m *Member) XXX_Unmarshal(b []byte) error {
-	return xxx_messageInfo_Member.Unmarshal(m, b)
-}
-func (m *Member) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
-	return xxx_messageInfo_Member.Marshal(b, m, deterministic)
+func (x *Member) String() string {
+	return protoimpl.X.MessageStringOf(x)
 }
-func (m *Member) XXX_Merge(src proto.Message) {
-	xxx_messageInfo_Member.Merge(m, src)
-}
-func (m *Member) XXX_Size() int {
-	return xxx_messageInfo_Member.Size(m)
-}
-func (m *Member) XXX_DiscardUnknown() {
-	xxx_messageInfo_Member.DiscardUnknown(m)
+
+func (*Member) ProtoMessage() {}
+
+func (x *Member) ProtoReflect() protoreflect.Message {
+	mi := &file_federation_proto_msgTypes[11]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
 }
 
-var xxx_messageInfo_Member proto.InternalMessageInfo
+// Deprecated: Use Member.ProtoReflect.Descriptor instead.
+func (*Member) Descriptor() ([]byte, []int) {
+	return file_federation_proto_rawDescGZIP(), []int{11}
+}
 
-func (m *Member) GetName() string {
-	if m != nil {
-		return m.Name
+func (x *Member) GetName() string {
+	if x != nil {
+		return x.Name
 	}
 	return ""
 }
 
-func (m *Member) GetAddr() string {
-	if m != nil {
-		return m.Addr
+func (x *Member) GetAddr() string {
+	if x != nil {
+		return x.Addr
 	}
 	return ""
 }
 
-func (m *Member) GetTags() map[string]string {
-	if m != nil {
-		return m.Tags
+func (x *Member) GetTags() map[string]string {
+	if x != nil {
+		return x.Tags
 	}
 	return nil
 }
 
-func (m *Member) GetStatus() Status {
-	if m != nil {
-		return m.Status
+func (x *Member) GetStatus() Status {
+	if x != nil {
+		return x.Status
 	}
 	return Status_STATUS_UNSPECIFIED
 }
 
 type ListMembersResponse struct {
-	Members              []*Member `protobuf:"bytes,1,rep,name=members,proto3" json:"members,omitempty"`
-	XXX_NoUnkeyedLiteral struct{}  `json:"-"`
-	XXX_unrecognized     []byte    `json:"-"`
-	XXX_sizecache        int32     `json:"-"`
-}
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
 
-func (m *ListMembersResponse) Reset()         { *m = ListMembersResponse{} }
-func (m *ListMembersResponse) String() string { return proto.CompactTextString(m) }
-func (*ListMembersResponse) ProtoMessage()    {}
-func (*ListMembersResponse) Descriptor() ([]byte, []int) {
-	return fileDescriptor_7217fe47f5d68a8f, []int{10}
+	Members []*Member `protobuf:"bytes,1,rep,name=members,proto3" json:"members,omitempty"`
 }
 
-func (m *ListMembersResponse) XXX_Unmarshal(b []byte) error {
-	return xxx_messageInfo_ListMembersResponse.Unmarshal(m, b)
-}
-func (m *ListMembersResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
-	return xxx_messageInfo_ListMembersResponse.Marshal(b, m, deterministic)
-}
-func (m *ListMembersResponse) XXX_Merge(src proto.Message) {
-	xxx_messageInfo_ListMembersResponse.Merge(m, src)
+func (x *ListMembersResponse) Reset() {
+	*x = ListMembersResponse{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_federation_proto_msgTypes[12]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
 }
-func (m *ListMembersResponse) XXX_Size() int {
-	return xxx_messageInfo_ListMembersResponse.Size(m)
+
+func (x *ListMembersResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
 }
-func (m *ListMembersResponse) XXX_DiscardUnknown() {
-	xxx_messageInfo_ListMembersResponse.DiscardUnknown(m)
+
+func (*ListMembersResponse) ProtoMessage() {}
+
+func (x *ListMembersResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_federation_proto_msgTypes[12]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
 }
 
-var xxx_messageInfo_ListMembersResponse proto.InternalMessageInfo
+// Deprecated: Use ListMembersResponse.ProtoReflect.Descriptor instead.
+func (*ListMembersResponse) Descriptor() ([]byte, []int) {
+	return file_federation_proto_rawDescGZIP(), []int{12}
+}
 
-func (m *ListMembersResponse) GetMembers() []*Member {
-	if m != nil {
-		return m.Members
+func (x *ListMembersResponse) GetMembers() []*Member {
+	if x != nil {
+		return x.Members
 	}
 	return nil
 }
 
 type ForceLeaveRequest struct {
-	NodeName             string   `protobuf:"bytes,1,opt,name=node_name,json=nodeName,proto3" json:"node_name,omitempty"`
-	XXX_NoUnkeyedLiteral struct{} `json:"-"`
-	XXX_unrecognized     []byte   `json:"-"`
-	XXX_sizecache        int32    `json:"-"`
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	NodeName string `protobuf:"bytes,1,opt,name=node_name,json=nodeName,proto3" json:"node_name,omitempty"`
+}
+
+func (x *ForceLeaveRequest) Reset() {
+	*x = ForceLeaveRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_federation_proto_msgTypes[13]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *ForceLeaveRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ForceLeaveRequest) ProtoMessage() {}
+
+func (x *ForceLeaveRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_federation_proto_msgTypes[13]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
 }
 
-func (m *ForceLeaveRequest) Reset()         { *m = ForceLeaveRequest{} }
-func (m *ForceLeaveRequest) String() string { return proto.CompactTextString(m) }
-func (*ForceLeaveRequest) ProtoMessage()    {}
+// Deprecated: Use ForceLeaveRequest.ProtoReflect.Descriptor instead.
 func (*ForceLeaveRequest) Descriptor() ([]byte, []int) {
-	return fileDescriptor_7217fe47f5d68a8f, []int{11}
+	return file_federation_proto_rawDescGZIP(), []int{13}
 }
 
-func (m *ForceLeaveRequest) XXX_Unmarshal(b []byte) error {
-	return xxx_messageInfo_ForceLeaveRequest.Unmarshal(m, b)
+func (x *ForceLeaveRequest) GetNodeName() string {
+	if x != nil {
+		return x.NodeName
+	}
+	return ""
 }
-func (m *ForceLeaveRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
-	return xxx_messageInfo_ForceLeaveRequest.Marshal(b, m, deterministic)
+
+// TakeoverRequest is the request to take over the client from the node.
+type TakeoverRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	ClientId string `protobuf:"bytes,1,opt,name=client_id,json=clientId,proto3" json:"client_id,omitempty"`
+	// clean_start is the clean start flag of the new connection, the session will be discarded instead of being returned if it is true.
+	CleanStart bool `protobuf:"varint,2,opt,name=clean_start,json=cleanStart,proto3" json:"clean_start,omitempty"`
 }
-func (m *ForceLeaveRequest) XXX_Merge(src proto.Message) {
-	xxx_messageInfo_ForceLeaveRequest.Merge(m, src)
+
+func (x *TakeoverRequest) Reset() {
+	*x = TakeoverRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_federation_proto_msgTypes[14]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
 }
-func (m *ForceLeaveRequest) XXX_Size() int {
-	return xxx_messageInfo_ForceLeaveRequest.Size(m)
+
+func (x *TakeoverRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
 }
-func (m *ForceLeaveRequest) XXX_DiscardUnknown() {
-	xxx_messageInfo_ForceLeaveRequest.DiscardUnknown(m)
+
+func (*TakeoverRequest) ProtoMessage() {}
+
+func (x *TakeoverRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_federation_proto_msgTypes[14]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
 }
 
-var xxx_messageInfo_ForceLeaveRequest proto.InternalMessageInfo
+// Deprecated: Use TakeoverRequest.ProtoReflect.Descriptor instead.
+func (*TakeoverRequest) Descriptor() ([]byte, []int) {
+	return file_federation_proto_rawDescGZIP(), []int{14}
+}
 
-func (m *ForceLeaveRequest) GetNodeName() string {
-	if m != nil {
-		return m.NodeName
+func (x *TakeoverRequest) GetClientId() string {
+	if x != nil {
+		return x.ClientId
 	}
 	return ""
 }
 
-func init() {
-	proto.RegisterEnum("gmqtt.federation.api.Status", Status_name, Status_value)
-	proto.RegisterType((*Event)(nil), "gmqtt.federation.api.Event")
-	proto.RegisterType((*Subscribe)(nil), "gmqtt.federation.api.Subscribe")
-	proto.RegisterType((*Message)(nil), "gmqtt.federation.api.Message")
-	proto.RegisterType((*UserProperty)(nil), "gmqtt.federation.api.UserProperty")
-	proto.RegisterType((*Unsubscribe)(nil), "gmqtt.federation.api.Unsubscribe")
-	proto.RegisterType((*Ack)(nil), "gmqtt.federation.api.Ack")
-	proto.RegisterType((*ClientHello)(nil), "gmqtt.federation.api.ClientHello")
-	proto.RegisterType((*ServerHello)(nil), "gmqtt.federation.api.ServerHello")
-	proto.RegisterType((*JoinRequest)(nil), "gmqtt.federation.api.JoinRequest")
-	proto.RegisterType((*Member)(nil), "gmqtt.federation.api.Member")
-	proto.RegisterMapType((map[string]string)(nil), "gmqtt.federation.api.Member.TagsEntry")
-	proto.RegisterType((*ListMembersResponse)(nil), "gmqtt.federation.api.ListMembersResponse")
-	proto.RegisterType((*ForceLeaveRequest)(nil), "gmqtt.federation.api.ForceLeaveRequest")
-}
-
-func init() { proto.RegisterFile("federation.proto", fileDescriptor_7217fe47f5d68a8f) }
-
-var fileDescriptor_7217fe47f5d68a8f = []byte{
-	// 1008 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x84, 0x54, 0xdd, 0x6e, 0xe3, 0x44,
-	0x14, 0x8e, 0x93, 0xb4, 0x49, 0x8e, 0xd3, 0x6c, 0x76, 0xb6, 0xaa, 0xdc, 0x74, 0x97, 0x76, 0x8d,
-	0x16, 0xba, 0x15, 0x4a, 0x96, 0x82, 0xf8, 0x29, 0x17, 0x28, 0xbb, 0x75, 0x68, 0xe8, 0x0f, 0x2b,
-	0x27, 0xad, 0x10, 0x37, 0xd6, 0x34, 0x3e, 0x4d, 0xbd, 0x4d, 0x6c, 0x77, 0x66, 0x12, 0x6d, 0xee,
-	0x10, 0xaf, 0xc0, 0x5b, 0x70, 0xc7, 0xb3, 0x70, 0xc9, 0x25, 0x3c, 0x08, 0x9a, 0xf1, 0x24, 0x31,
-	0x25, 0x16, 0x77, 0x73, 0xbe, 0xf9, 0xce, 0x77, 0x7e, 0xe6, 0xcc, 0x81, 0xfa, 0x0d, 0xfa, 0xc8,
-	0xa8, 0x08, 0xa2, 0xb0, 0x19, 0xb3, 0x48, 0x44, 0x64, 0x73, 0x38, 0xbe, 0x17, 0xa2, 0x99, 0xc2,
-	0x69, 0x1c, 0x34, 0x9e, 0x0e, 0xa3, 0x68, 0x38, 0xc2, 0x16, 0x8d, 0x83, 0x16, 0x0d, 0xc3, 0x48,
-	0xa8, 0x2b, 0x9e, 0xf8, 0x34, 0x76, 0xf4, 0xad, 0xb2, 0xae, 0x27, 0x37, 0x2d, 0x1c, 0xc7, 0x62,
-	0x96, 0x5c, 0xda, 0x7f, 0x19, 0xb0, 0xe6, 0x4c, 0x31, 0x14, 0xa4, 0x06, 0xf9, 0xc0, 0xb7, 0x8c,
-	0x3d, 0x63, 0xbf, 0xe8, 0xe6, 0x03, 0x9f, 0x7c, 0x0b, 0x95, 0xde, 0xe4, 0x9a, 0x0f, 0x58, 0x70,
-	0x8d, 0x56, 0x7e, 0xcf, 0xd8, 0x37, 0x0f, 0x77, 0x9b, 0xab, 0xc2, 0x37, 0x17, 0xb4, 0x93, 0x9c,
-	0xbb, 0xf4, 0x21, 0x5f, 0x43, 0x69, 0x8c, 0x9c, 0xd3, 0x21, 0x5a, 0x05, 0xe5, 0xfe, 0x6c, 0xb5,
-	0xfb, 0x79, 0x42, 0x3a, 0xc9, 0xb9, 0x73, 0x3e, 0x71, 0xc0, 0x9c, 0x84, 0x7c, 0x11, 0xbd, 0xa8,
-	0xdc, 0x9f, 0xaf, 0x76, 0xbf, 0x5c, 0x12, 0x4f, 0x72, 0x6e, 0xda, 0xef, 0x75, 0x49, 0xd7, 0x66,
-	0x9f, 0xa7, 0x6a, 0x21, 0xcf, 0x00, 0xf8, 0x2d, 0x65, 0xe8, 0x85, 0x74, 0x8c, 0xaa, 0xe0, 0x8a,
-	0x5b, 0x51, 0xc8, 0x05, 0x1d, 0x23, 0x79, 0x0e, 0x55, 0x11, 0xc5, 0xc1, 0xc0, 0xbb, 0x09, 0x46,
-	0x02, 0x99, 0x2a, 0xbd, 0xe2, 0x9a, 0x0a, 0xeb, 0x28, 0xc8, 0xfe, 0xb9, 0x00, 0x25, 0x9d, 0xb5,
-	0x54, 0x4b, 0xe8, 0x69, 0x35, 0x85, 0x28, 0x35, 0x0b, 0x4a, 0x31, 0x9d, 0x8d, 0x22, 0xea, 0x2b,
-	0xa1, 0xaa, 0x3b, 0x37, 0x49, 0x1d, 0x0a, 0xf7, 0x11, 0x57, 0xad, 0xd9, 0x70, 0xe5, 0x91, 0x34,
-	0xa0, 0xcc, 0x50, 0xd0, 0x20, 0x44, 0x5f, 0x95, 0x5c, 0x76, 0x17, 0xb6, 0xcc, 0x6a, 0x10, 0x85,
-	0x02, 0x43, 0xe1, 0x89, 0x59, 0x8c, 0xd6, 0x5a, 0x92, 0x95, 0xc6, 0xfa, 0xb3, 0x18, 0xc9, 0x4b,
-	0xa8, 0x0f, 0x22, 0xc6, 0x70, 0xa4, 0x7a, 0xe3, 0xf9, 0x54, 0x50, 0x6b, 0x5d, 0xd1, 0x1e, 0xa5,
-	0xf0, 0x63, 0x2a, 0x28, 0x79, 0x01, 0x35, 0xdd, 0x6a, 0x0f, 0xdf, 0xc7, 0x01, 0x9b, 0x59, 0x25,
-	0x95, 0xc6, 0x86, 0x46, 0x1d, 0x05, 0x4a, 0x9a, 0xce, 0xd6, 0xbb, 0x89, 0xd8, 0x98, 0x0a, 0xab,
-	0x9c, 0xd0, 0x34, 0xda, 0x51, 0xa0, 0xa4, 0x31, 0xe4, 0x71, 0x14, 0x72, 0xf4, 0x54, 0xe5, 0x56,
-	0x45, 0x85, 0xdd, 0x98, 0xa3, 0x7d, 0x09, 0x92, 0x53, 0x78, 0x34, 0xe1, 0xc8, 0xbc, 0x98, 0x45,
-	0x31, 0x32, 0x11, 0x20, 0xb7, 0x60, 0xaf, 0xb0, 0x6f, 0x1e, 0xda, 0x19, 0x0f, 0xcb, 0x91, 0xbd,
-	0x4d, 0xb8, 0x33, 0xb7, 0x36, 0x59, 0x5a, 0x01, 0x72, 0xfb, 0x00, 0xaa, 0xe9, 0x7b, 0x52, 0x05,
-	0xe3, 0x54, 0x75, 0xbf, 0xea, 0x1a, 0xa7, 0xd2, 0xba, 0xd2, 0xfd, 0x36, 0xae, 0xec, 0x4f, 0xc0,
-	0x4c, 0x0d, 0xc9, 0xff, 0xbc, 0x98, 0xbd, 0x07, 0x85, 0xf6, 0xe0, 0x8e, 0x6c, 0x43, 0x19, 0xe5,
-	0xec, 0x78, 0x8b, 0x4f, 0x51, 0x52, 0x76, 0xd7, 0x97, 0x7a, 0x6f, 0x46, 0x01, 0x86, 0xe2, 0x04,
-	0x47, 0xa3, 0x48, 0xcd, 0x13, 0x72, 0x2e, 0x7b, 0xae, 0xb9, 0x72, 0x9e, 0x12, 0xa4, 0xeb, 0xdb,
-	0x2e, 0x98, 0x3d, 0x64, 0x53, 0x64, 0x09, 0x7b, 0x17, 0xcc, 0xc1, 0x08, 0x69, 0xe8, 0x71, 0x41,
-	0x99, 0x50, 0xf4, 0xb2, 0x0b, 0x0a, 0xea, 0x49, 0x84, 0xd8, 0xb0, 0x11, 0xe2, 0x7b, 0xe1, 0x2d,
-	0xa2, 0xe7, 0x55, 0x74, 0x53, 0x82, 0x8e, 0xce, 0xe0, 0x43, 0x30, 0xbf, 0x8f, 0x82, 0xd0, 0xc5,
-	0xfb, 0x09, 0x72, 0x41, 0x36, 0x61, 0xed, 0x36, 0xe2, 0x82, 0x5b, 0xc6, 0x5e, 0x61, 0xbf, 0xe2,
-	0x26, 0x86, 0xfd, 0xa7, 0x01, 0xeb, 0xe7, 0x38, 0xbe, 0x46, 0x46, 0x08, 0x14, 0x53, 0xc5, 0xaa,
-	0xb3, 0xc4, 0xa8, 0xef, 0xcf, 0xe7, 0x5b, 0x9d, 0xc9, 0x11, 0x14, 0x05, 0x1d, 0xca, 0xa1, 0x94,
-	0xef, 0xf2, 0x51, 0xd6, 0x7f, 0x95, 0x9a, 0xcd, 0x3e, 0x1d, 0x72, 0x27, 0x14, 0x6c, 0xe6, 0x2a,
-	0x1f, 0xf2, 0x39, 0xac, 0x73, 0x41, 0xc5, 0x84, 0xab, 0xd9, 0xad, 0x1d, 0x3e, 0xcd, 0x58, 0x16,
-	0x8a, 0xe3, 0x6a, 0x6e, 0xe3, 0x4b, 0xa8, 0x2c, 0x84, 0xe4, 0x97, 0xb8, 0xc3, 0x99, 0xce, 0x52,
-	0x1e, 0x65, 0x65, 0x53, 0x3a, 0x9a, 0xa0, 0xce, 0x32, 0x31, 0x8e, 0xf2, 0x5f, 0x19, 0xf6, 0x39,
-	0x3c, 0x39, 0x0b, 0xb8, 0x48, 0x92, 0xe1, 0xae, 0x9e, 0x34, 0xf2, 0x85, 0x5c, 0x3a, 0x0a, 0x52,
-	0xcd, 0x30, 0xb3, 0xd2, 0x48, 0xfc, 0xdc, 0x39, 0xd9, 0x7e, 0x05, 0x8f, 0x3b, 0x11, 0x1b, 0xe0,
-	0x19, 0xd2, 0x29, 0xce, 0xfb, 0xba, 0x03, 0x95, 0x30, 0xf2, 0xff, 0xb5, 0x28, 0xca, 0x12, 0x90,
-	0x73, 0x72, 0xf0, 0x0e, 0xd6, 0x93, 0x5a, 0xc8, 0x16, 0x90, 0x5e, 0xbf, 0xdd, 0xbf, 0xec, 0x79,
-	0x97, 0x17, 0xbd, 0xb7, 0xce, 0x9b, 0x6e, 0xa7, 0xeb, 0x1c, 0xd7, 0x73, 0xa4, 0x0e, 0x55, 0x8d,
-	0xb7, 0xcf, 0xba, 0x57, 0x4e, 0xdd, 0x20, 0x04, 0x6a, 0x1a, 0x39, 0x73, 0xda, 0x57, 0xdd, 0x8b,
-	0xef, 0xea, 0x79, 0xf2, 0x08, 0xcc, 0x05, 0xd6, 0xe9, 0xd7, 0x0b, 0xe4, 0x31, 0x6c, 0x68, 0xa0,
-	0xd3, 0xee, 0x9e, 0x39, 0xc7, 0xf5, 0xe2, 0xe1, 0xef, 0x05, 0x00, 0x5d, 0xe9, 0x6d, 0x10, 0x13,
-	0x0a, 0x45, 0xf9, 0xfc, 0x24, 0x63, 0x23, 0xa6, 0x46, 0xa3, 0xb1, 0xd5, 0x4c, 0xb6, 0x7f, 0x73,
-	0xbe, 0xfd, 0x9b, 0x8e, 0xdc, 0xfe, 0xf6, 0x07, 0xbf, 0xfc, 0xf1, 0xf7, 0xaf, 0x79, 0xcb, 0x7e,
-	0xd2, 0x9a, 0x7e, 0xda, 0x5a, 0xfa, 0xb7, 0xde, 0x45, 0x41, 0x78, 0x64, 0x1c, 0x90, 0x1f, 0x61,
-	0x4d, 0xb5, 0x82, 0x64, 0x08, 0x64, 0x0a, 0xef, 0x2a, 0xe1, 0x6d, 0x7b, 0xf3, 0x81, 0xf0, 0x48,
-	0xaa, 0x49, 0x65, 0x01, 0xb0, 0xec, 0x34, 0xf9, 0x78, 0x75, 0x09, 0xff, 0x79, 0x8b, 0xcc, 0x78,
-	0x2f, 0x54, 0xbc, 0x5d, 0xbb, 0xf1, 0x20, 0xde, 0x8d, 0x54, 0xf0, 0x16, 0x51, 0x63, 0x30, 0x53,
-	0xe3, 0x92, 0x59, 0xd5, 0xcb, 0xd5, 0xe9, 0xac, 0x98, 0xb4, 0x79, 0x07, 0xc9, 0xd6, 0x83, 0xc0,
-	0x7a, 0xa2, 0x0e, 0x7f, 0x33, 0x00, 0x3a, 0x0b, 0x98, 0xfc, 0x00, 0x6b, 0xc9, 0x02, 0xc8, 0x78,
-	0xb4, 0xd4, 0x46, 0x69, 0x64, 0x50, 0x52, 0x6b, 0xc4, 0xce, 0x91, 0x53, 0x30, 0xd5, 0x3a, 0xe8,
-	0x09, 0x86, 0x74, 0x4c, 0x76, 0x56, 0xfb, 0x28, 0x4a, 0x63, 0x7b, 0xf5, 0x65, 0x7b, 0x70, 0x67,
-	0xe7, 0xf6, 0x8d, 0x57, 0xc6, 0xeb, 0xda, 0x4f, 0xd5, 0xe6, 0x37, 0xcb, 0xdb, 0xeb, 0x75, 0xd5,
-	0x97, 0xcf, 0xfe, 0x09, 0x00, 0x00, 0xff, 0xff, 0x31, 0x1c, 0xfe, 0x7a, 0x82, 0x08, 0x00, 0x00,
+func (x *TakeoverRequest) GetCleanStart() bool {
+	if x != nil {
+		return x.CleanStart
+	}
+	return false
+}
+
+type TakeoverResponse struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// session is the JSON encoded server.SessionExport, it is empty if the node does not have the session of the client.
+	Session []byte `protobuf:"bytes,1,opt,name=session,proto3" json:"session,omitempty"`
+}
+
+func (x *TakeoverResponse) Reset() {
+	*x = TakeoverResponse{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_federation_proto_msgTypes[15]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *TakeoverResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TakeoverResponse) ProtoMessage() {}
+
+func (x *TakeoverResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_federation_proto_msgTypes[15]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TakeoverResponse.ProtoReflect.Descriptor instead.
+func (*TakeoverResponse) Descriptor() ([]byte, []int) {
+	return file_federation_proto_rawDescGZIP(), []int{15}
+}
+
+func (x *TakeoverResponse) GetSession() []byte {
+	if x != nil {
+		return x.Session
+	}
+	return nil
+}
+
+var File_federation_proto protoreflect.FileDescriptor
+
+var file_federation_proto_rawDesc = []byte{
+	0x0a, 0x10, 0x66, 0x65, 0x64, 0x65, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x2e, 0x70, 0x72, 0x6f,
+	0x74, 0x6f, 0x12, 0x14, 0x67, 0x6d, 0x71, 0x74, 0x74, 0x2e, 0x66, 0x65, 0x64, 0x65, 0x72, 0x61,
+	0x74, 0x69, 0x6f, 0x6e, 0x2e, 0x61, 0x70, 0x69, 0x1a, 0x1c, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65,
+	0x2f, 0x61, 0x70, 0x69, 0x2f, 0x61, 0x6e, 0x6e, 0x6f, 0x74, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x73,
+	0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x1a, 0x1b, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2f, 0x70,
+	0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2f, 0x65, 0x6d, 0x70, 0x74, 0x79, 0x2e, 0x70, 0x72,
+	0x6f, 0x74, 0x6f, 0x22, 0x8e, 0x03, 0x0a, 0x05, 0x45, 0x76, 0x65, 0x6e, 0x74, 0x12, 0x0e, 0x0a,
+	0x02, 0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x04, 0x52, 0x02, 0x69, 0x64, 0x12, 0x3f, 0x0a,
+	0x09, 0x53, 0x75, 0x62, 0x73, 0x63, 0x72, 0x69, 0x62, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0b,
+	0x32, 0x1f, 0x2e, 0x67, 0x6d, 0x71, 0x74, 0x74, 0x2e, 0x66, 0x65, 0x64, 0x65, 0x72, 0x61, 0x74,
+	0x69, 0x6f, 0x6e, 0x2e, 0x61, 0x70, 0x69, 0x2e, 0x53, 0x75, 0x62, 0x73, 0x63, 0x72, 0x69, 0x62,
+	0x65, 0x48, 0x00, 0x52, 0x09, 0x53, 0x75, 0x62, 0x73, 0x63, 0x72, 0x69, 0x62, 0x65, 0x12, 0x39,
+	0x0a, 0x07, 0x6d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0b, 0x32,
+	0x1d, 0x2e, 0x67, 0x6d, 0x71, 0x74, 0x74, 0x2e, 0x66, 0x65, 0x64, 0x65, 0x72, 0x61, 0x74, 0x69,
+	0x6f, 0x6e, 0x2e, 0x61, 0x70, 0x69, 0x2e, 0x4d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x48, 0x00,
+	0x52, 0x07, 0x6d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x12, 0x45, 0x0a, 0x0b, 0x75, 0x6e, 0x73,
+	0x75, 0x62, 0x73, 0x63, 0x72, 0x69, 0x62, 0x65, 0x18, 0x04, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x21,
+	0x2e, 0x67, 0x6d, 0x71, 0x74, 0x74, 0x2e, 0x66, 0x65, 0x64, 0x65, 0x72, 0x61, 0x74, 0x69, 0x6f,
+	0x6e, 0x2e, 0x61, 0x70, 0x69, 0x2e, 0x55, 0x6e, 0x73, 0x75, 0x62, 0x73, 0x63, 0x72, 0x69, 0x62,
+	0x65, 0x48, 0x00, 0x52, 0x0b, 0x75, 0x6e, 0x73, 0x75, 0x62, 0x73, 0x63, 0x72, 0x69, 0x62, 0x65,
+	0x12, 0x4f, 0x0a, 0x0f, 0x73, 0x65, 0x73, 0x73, 0x69, 0x6f, 0x6e, 0x5f, 0x63, 0x72, 0x65, 0x61,
+	0x74, 0x65, 0x64, 0x18, 0x05, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x24, 0x2e, 0x67, 0x6d, 0x71, 0x74,
+	0x74, 0x2e, 0x66, 0x65, 0x64, 0x65, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x2e, 0x61, 0x70, 0x69,
+	0x2e, 0x53, 0x65, 0x73, 0x73, 0x69, 0x6f, 0x6e, 0x43, 0x72, 0x65, 0x61, 0x74, 0x65, 0x64, 0x48,
+	0x00, 0x52, 0x0e, 0x73, 0x65, 0x73, 0x73, 0x69, 0x6f, 0x6e, 0x43, 0x72, 0x65, 0x61, 0x74, 0x65,
+	0x64, 0x12, 0x58, 0x0a, 0x12, 0x73, 0x65, 0x73, 0x73, 0x69, 0x6f, 0x6e, 0x5f, 0x74, 0x65, 0x72,
+	0x6d, 0x69, 0x6e, 0x61, 0x74, 0x65, 0x64, 0x18, 0x06, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x27, 0x2e,
+	0x67, 0x6d, 0x71, 0x74, 0x74, 0x2e, 0x66, 0x65, 0x64, 0x65, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e,
+	0x2e, 0x61, 0x70, 0x69, 0x2e, 0x53, 0x65, 0x73, 0x73, 0x69, 0x6f, 0x6e, 0x54, 0x65, 0x72, 0x6d,
+	0x69, 0x6e, 0x61, 0x74, 0x65, 0x64, 0x48, 0x00, 0x52, 0x11, 0x73, 0x65, 0x73, 0x73, 0x69, 0x6f,
+	0x6e, 0x54, 0x65, 0x72, 0x6d, 0x69, 0x6e, 0x61, 0x74, 0x65, 0x64, 0x42, 0x07, 0x0a, 0x05, 0x45,
+	0x76, 0x65, 0x6e, 0x74, 0x22, 0x4d, 0x0a, 0x09, 0x53, 0x75, 0x62, 0x73, 0x63, 0x72, 0x69, 0x62,
+	0x65, 0x12, 0x1d, 0x0a, 0x0a, 0x73, 0x68, 0x61, 0x72, 0x65, 0x5f, 0x6e, 0x61, 0x6d, 0x65, 0x18,
+	0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x09, 0x73, 0x68, 0x61, 0x72, 0x65, 0x4e, 0x61, 0x6d, 0x65,
+	0x12, 0x21, 0x0a, 0x0c, 0x74, 0x6f, 0x70, 0x69, 0x63, 0x5f, 0x66, 0x69, 0x6c, 0x74, 0x65, 0x72,
+	0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0b, 0x74, 0x6f, 0x70, 0x69, 0x63, 0x46, 0x69, 0x6c,
+	0x74, 0x65, 0x72, 0x22, 0x80, 0x03, 0x0a, 0x07, 0x4d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x12,
+	0x1d, 0x0a, 0x0a, 0x74, 0x6f, 0x70, 0x69, 0x63, 0x5f, 0x6e, 0x61, 0x6d, 0x65, 0x18, 0x01, 0x20,
+	0x01, 0x28, 0x09, 0x52, 0x09, 0x74, 0x6f, 0x70, 0x69, 0x63, 0x4e, 0x61, 0x6d, 0x65, 0x12, 0x18,
+	0x0a, 0x07, 0x70, 0x61, 0x79, 0x6c, 0x6f, 0x61, 0x64, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0c, 0x52,
+	0x07, 0x70, 0x61, 0x79, 0x6c, 0x6f, 0x61, 0x64, 0x12, 0x10, 0x0a, 0x03, 0x71, 0x6f, 0x73, 0x18,
+	0x03, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x03, 0x71, 0x6f, 0x73, 0x12, 0x1a, 0x0a, 0x08, 0x72, 0x65,
+	0x74, 0x61, 0x69, 0x6e, 0x65, 0x64, 0x18, 0x04, 0x20, 0x01, 0x28, 0x08, 0x52, 0x08, 0x72, 0x65,
+	0x74, 0x61, 0x69, 0x6e, 0x65, 0x64, 0x12, 0x21, 0x0a, 0x0c, 0x63, 0x6f, 0x6e, 0x74, 0x65, 0x6e,
+	0x74, 0x5f, 0x74, 0x79, 0x70, 0x65, 0x18, 0x05, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0b, 0x63, 0x6f,
+	0x6e, 0x74, 0x65, 0x6e, 0x74, 0x54, 0x79, 0x70, 0x65, 0x12, 0x29, 0x0a, 0x10, 0x63, 0x6f, 0x72,
+	0x72, 0x65, 0x6c, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x5f, 0x64, 0x61, 0x74, 0x61, 0x18, 0x06, 0x20,
+	0x01, 0x28, 0x09, 0x52, 0x0f, 0x63, 0x6f, 0x72, 0x72, 0x65, 0x6c, 0x61, 0x74, 0x69, 0x6f, 0x6e,
+	0x44, 0x61, 0x74, 0x61, 0x12, 0x25, 0x0a, 0x0e, 0x6d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x5f,
+	0x65, 0x78, 0x70, 0x69, 0x72, 0x79, 0x18, 0x07, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x0d, 0x6d, 0x65,
+	0x73, 0x73, 0x61, 0x67, 0x65, 0x45, 0x78, 0x70, 0x69, 0x72, 0x79, 0x12, 0x25, 0x0a, 0x0e, 0x70,
+	0x61, 0x79, 0x6c, 0x6f, 0x61, 0x64, 0x5f, 0x66, 0x6f, 0x72, 0x6d, 0x61, 0x74, 0x18, 0x08, 0x20,
+	0x01, 0x28, 0x0d, 0x52, 0x0d, 0x70, 0x61, 0x79, 0x6c, 0x6f, 0x61, 0x64, 0x46, 0x6f, 0x72, 0x6d,
+	0x61, 0x74, 0x12, 0x25, 0x0a, 0x0e, 0x72, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x5f, 0x74,
+	0x6f, 0x70, 0x69, 0x63, 0x18, 0x09, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0d, 0x72, 0x65, 0x73, 0x70,
+	0x6f, 0x6e, 0x73, 0x65, 0x54, 0x6f, 0x70, 0x69, 0x63, 0x12, 0x4b, 0x0a, 0x0f, 0x75, 0x73, 0x65,
+	0x72, 0x5f, 0x70, 0x72, 0x6f, 0x70, 0x65, 0x72, 0x74, 0x69, 0x65, 0x73, 0x18, 0x0a, 0x20, 0x03,
+	0x28, 0x0b, 0x32, 0x22, 0x2e, 0x67, 0x6d, 0x71, 0x74, 0x74, 0x2e, 0x66, 0x65, 0x64, 0x65, 0x72,
+	0x61, 0x74, 0x69, 0x6f, 0x6e, 0x2e, 0x61, 0x70, 0x69, 0x2e, 0x55, 0x73, 0x65, 0x72, 0x50, 0x72,
+	0x6f, 0x70, 0x65, 0x72, 0x74, 0x79, 0x52, 0x0e, 0x75, 0x73, 0x65, 0x72, 0x50, 0x72, 0x6f, 0x70,
+	0x65, 0x72, 0x74, 0x69, 0x65, 0x73, 0x22, 0x2a, 0x0a, 0x0c, 0x55, 0x73, 0x65, 0x72, 0x50, 0x72,
+	0x6f, 0x70, 0x65, 0x72, 0x74, 0x79, 0x12, 0x0c, 0x0a, 0x01, 0x4b, 0x18, 0x01, 0x20, 0x01, 0x28,
+	0x0c, 0x52, 0x01, 0x4b, 0x12, 0x0c, 0x0a, 0x01, 0x56, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0c, 0x52,
+	0x01, 0x56, 0x22, 0x2c, 0x0a, 0x0b, 0x55, 0x6e, 0x73, 0x75, 0x62, 0x73, 0x63, 0x72, 0x69, 0x62,
+	0x65, 0x12, 0x1d, 0x0a, 0x0a, 0x74, 0x6f, 0x70, 0x69, 0x63, 0x5f, 0x6e, 0x61, 0x6d, 0x65, 0x18,
+	0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x09, 0x74, 0x6f, 0x70, 0x69, 0x63, 0x4e, 0x61, 0x6d, 0x65,
+	0x22, 0x2d, 0x0a, 0x0e, 0x53, 0x65, 0x73, 0x73, 0x69, 0x6f, 0x6e, 0x43, 0x72, 0x65, 0x61, 0x74,
+	0x65, 0x64, 0x12, 0x1b, 0x0a, 0x09, 0x63, 0x6c, 0x69, 0x65, 0x6e, 0x74, 0x5f, 0x69, 0x64, 0x18,
+	0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x08, 0x63, 0x6c, 0x69, 0x65, 0x6e, 0x74, 0x49, 0x64, 0x22,
+	0x30, 0x0a, 0x11, 0x53, 0x65, 0x73, 0x73, 0x69, 0x6f, 0x6e, 0x54, 0x65, 0x72, 0x6d, 0x69, 0x6e,
+	0x61, 0x74, 0x65, 0x64, 0x12, 0x1b, 0x0a, 0x09, 0x63, 0x6c, 0x69, 0x65, 0x6e, 0x74, 0x5f, 0x69,
+	0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x08, 0x63, 0x6c, 0x69, 0x65, 0x6e, 0x74, 0x49,
+	0x64, 0x22, 0x20, 0x0a, 0x03, 0x41, 0x63, 0x6b, 0x12, 0x19, 0x0a, 0x08, 0x65, 0x76, 0x65, 0x6e,
+	0x74, 0x5f, 0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x04, 0x52, 0x07, 0x65, 0x76, 0x65, 0x6e,
+	0x74, 0x49, 0x64, 0x22, 0x2c, 0x0a, 0x0b, 0x43, 0x6c, 0x69, 0x65, 0x6e, 0x74, 0x48, 0x65, 0x6c,
+	0x6c, 0x6f, 0x12, 0x1d, 0x0a, 0x0a, 0x73, 0x65, 0x73, 0x73, 0x69, 0x6f, 0x6e, 0x5f, 0x69, 0x64,
+	0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x09, 0x73, 0x65, 0x73, 0x73, 0x69, 0x6f, 0x6e, 0x49,
+	0x64, 0x22, 0x52, 0x0a, 0x0b, 0x53, 0x65, 0x72, 0x76, 0x65, 0x72, 0x48, 0x65, 0x6c, 0x6c, 0x6f,
+	0x12, 0x1f, 0x0a, 0x0b, 0x63, 0x6c, 0x65, 0x61, 0x6e, 0x5f, 0x73, 0x74, 0x61, 0x72, 0x74, 0x18,
+	0x01, 0x20, 0x01, 0x28, 0x08, 0x52, 0x0a, 0x63, 0x6c, 0x65, 0x61, 0x6e, 0x53, 0x74, 0x61, 0x72,
+	0x74, 0x12, 0x22, 0x0a, 0x0d, 0x6e, 0x65, 0x78, 0x74, 0x5f, 0x65, 0x76, 0x65, 0x6e, 0x74, 0x5f,
+	0x69, 0x64, 0x18, 0x02, 0x20, 0x01, 0x28, 0x04, 0x52, 0x0b, 0x6e, 0x65, 0x78, 0x74, 0x45, 0x76,
+	0x65, 0x6e, 0x74, 0x49, 0x64, 0x22, 0x23, 0x0a, 0x0b, 0x4a, 0x6f, 0x69, 0x6e, 0x52, 0x65, 0x71,
+	0x75, 0x65, 0x73, 0x74, 0x12, 0x14, 0x0a, 0x05, 0x68, 0x6f, 0x73, 0x74, 0x73, 0x18, 0x01, 0x20,
+	0x03, 0x28, 0x09, 0x52, 0x05, 0x68, 0x6f, 0x73, 0x74, 0x73, 0x22, 0xdb, 0x01, 0x0a, 0x06, 0x4d,
+	0x65, 0x6d, 0x62, 0x65, 0x72, 0x12, 0x12, 0x0a, 0x04, 0x6e, 0x61, 0x6d, 0x65, 0x18, 0x01, 0x20,
+	0x01, 0x28, 0x09, 0x52, 0x04, 0x6e, 0x61, 0x6d, 0x65, 0x12, 0x12, 0x0a, 0x04, 0x61, 0x64, 0x64,
+	0x72, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x04, 0x61, 0x64, 0x64, 0x72, 0x12, 0x3a, 0x0a,
+	0x04, 0x74, 0x61, 0x67, 0x73, 0x18, 0x03, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x26, 0x2e, 0x67, 0x6d,
+	0x71, 0x74, 0x74, 0x2e, 0x66, 0x65, 0x64, 0x65, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x2e, 0x61,
+	0x70, 0x69, 0x2e, 0x4d, 0x65, 0x6d, 0x62, 0x65, 0x72, 0x2e, 0x54, 0x61, 0x67, 0x73, 0x45, 0x6e,
+	0x74, 0x72, 0x79, 0x52, 0x04, 0x74, 0x61, 0x67, 0x73, 0x12, 0x34, 0x0a, 0x06, 0x73, 0x74, 0x61,
+	0x74, 0x75, 0x73, 0x18, 0x04, 0x20, 0x01, 0x28, 0x0e, 0x32, 0x1c, 0x2e, 0x67, 0x6d, 0x71, 0x74,
+	0x74, 0x2e, 0x66, 0x65, 0x64, 0x65, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x2e, 0x61, 0x70, 0x69,
+	0x2e, 0x53, 0x74, 0x61, 0x74, 0x75, 0x73, 0x52, 0x06, 0x73, 0x74, 0x61, 0x74, 0x75, 0x73, 0x1a,
+	0x37, 0x0a, 0x09, 0x54, 0x61, 0x67, 0x73, 0x45, 0x6e, 0x74, 0x72, 0x79, 0x12, 0x10, 0x0a, 0x03,
+	0x6b, 0x65, 0x79, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x03, 0x6b, 0x65, 0x79, 0x12, 0x14,
+	0x0a, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x05, 0x76,
+	0x61, 0x6c, 0x75, 0x65, 0x3a, 0x02, 0x38, 0x01, 0x22, 0x4d, 0x0a, 0x13, 0x4c, 0x69, 0x73, 0x74,
+	0x4d, 0x65, 0x6d, 0x62, 0x65, 0x72, 0x73, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12,
+	0x36, 0x0a, 0x07, 0x6d, 0x65, 0x6d, 0x62, 0x65, 0x72, 0x73, 0x18, 0x01, 0x20, 0x03, 0x28, 0x0b,
+	0x32, 0x1c, 0x2e, 0x67, 0x6d, 0x71, 0x74, 0x74, 0x2e, 0x66, 0x65, 0x64, 0x65, 0x72, 0x61, 0x74,
+	0x69, 0x6f, 0x6e, 0x2e, 0x61, 0x70, 0x69, 0x2e, 0x4d, 0x65, 0x6d, 0x62, 0x65, 0x72, 0x52, 0x07,
+	0x6d, 0x65, 0x6d, 0x62, 0x65, 0x72, 0x73, 0x22, 0x30, 0x0a, 0x11, 0x46, 0x6f, 0x72, 0x63, 0x65,
+	0x4c, 0x65, 0x61, 0x76, 0x65, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x1b, 0x0a, 0x09,
+	0x6e, 0x6f, 0x64, 0x65, 0x5f, 0x6e, 0x61, 0x6d, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52,
+	0x08, 0x6e, 0x6f, 0x64, 0x65, 0x4e, 0x61, 0x6d, 0x65, 0x22, 0x4f, 0x0a, 0x0f, 0x54, 0x61, 0x6b,
+	0x65, 0x6f, 0x76, 0x65, 0x72, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x1b, 0x0a, 0x09,
+	0x63, 0x6c, 0x69, 0x65, 0x6e, 0x74, 0x5f, 0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52,
+	0x08, 0x63, 0x6c, 0x69, 0x65, 0x6e, 0x74, 0x49, 0x64, 0x12, 0x1f, 0x0a, 0x0b, 0x63, 0x6c, 0x65,
+	0x61, 0x6e, 0x5f, 0x73, 0x74, 0x61, 0x72, 0x74, 0x18, 0x02, 0x20, 0x01, 0x28, 0x08, 0x52, 0x0a,
+	0x63, 0x6c, 0x65, 0x61, 0x6e, 0x53, 0x74, 0x61, 0x72, 0x74, 0x22, 0x2c, 0x0a, 0x10, 0x54, 0x61,
+	0x6b, 0x65, 0x6f, 0x76, 0x65, 0x72, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x18,
+	0x0a, 0x07, 0x73, 0x65, 0x73, 0x73, 0x69, 0x6f, 0x6e, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0c, 0x52,
+	0x07, 0x73, 0x65, 0x73, 0x73, 0x69, 0x6f, 0x6e, 0x2a, 0x6a, 0x0a, 0x06, 0x53, 0x74, 0x61, 0x74,
+	0x75, 0x73, 0x12, 0x16, 0x0a, 0x12, 0x53, 0x54, 0x41, 0x54, 0x55, 0x53, 0x5f, 0x55, 0x4e, 0x53,
+	0x50, 0x45, 0x43, 0x49, 0x46, 0x49, 0x45, 0x44, 0x10, 0x00, 0x12, 0x10, 0x0a, 0x0c, 0x53, 0x54,
+	0x41, 0x54, 0x55, 0x53, 0x5f, 0x41, 0x4c, 0x49, 0x56, 0x45, 0x10, 0x01, 0x12, 0x12, 0x0a, 0x0e,
+	0x53, 0x54, 0x41, 0x54, 0x55, 0x53, 0x5f, 0x4c, 0x45, 0x41, 0x56, 0x49, 0x4e, 0x47, 0x10, 0x02,
+	0x12, 0x0f, 0x0a, 0x0b, 0x53, 0x54, 0x41, 0x54, 0x55, 0x53, 0x5f, 0x4c, 0x45, 0x46, 0x54, 0x10,
+	0x03, 0x12, 0x11, 0x0a, 0x0d, 0x53, 0x54, 0x41, 0x54, 0x55, 0x53, 0x5f, 0x46, 0x41, 0x49, 0x4c,
+	0x45, 0x44, 0x10, 0x04, 0x32, 0xb1, 0x03, 0x0a, 0x0a, 0x4d, 0x65, 0x6d, 0x62, 0x65, 0x72, 0x73,
+	0x68, 0x69, 0x70, 0x12, 0x61, 0x0a, 0x04, 0x4a, 0x6f, 0x69, 0x6e, 0x12, 0x21, 0x2e, 0x67, 0x6d,
+	0x71, 0x74, 0x74, 0x2e, 0x66, 0x65, 0x64, 0x65, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x2e, 0x61,
+	0x70, 0x69, 0x2e, 0x4a, 0x6f, 0x69, 0x6e, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x16,
+	0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66,
+	0x2e, 0x45, 0x6d, 0x70, 0x74, 0x79, 0x22, 0x1e, 0x82, 0xd3, 0xe4, 0x93, 0x02, 0x18, 0x22, 0x13,
+	0x2f, 0x76, 0x31, 0x2f, 0x66, 0x65, 0x64, 0x65, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x2f, 0x6a,
+	0x6f, 0x69, 0x6e, 0x3a, 0x01, 0x2a, 0x12, 0x58, 0x0a, 0x05, 0x4c, 0x65, 0x61, 0x76, 0x65, 0x12,
+	0x16, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75,
+	0x66, 0x2e, 0x45, 0x6d, 0x70, 0x74, 0x79, 0x1a, 0x16, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65,
+	0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x45, 0x6d, 0x70, 0x74, 0x79, 0x22,
+	0x1f, 0x82, 0xd3, 0xe4, 0x93, 0x02, 0x19, 0x22, 0x14, 0x2f, 0x76, 0x31, 0x2f, 0x66, 0x65, 0x64,
+	0x65, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x2f, 0x6c, 0x65, 0x61, 0x76, 0x65, 0x3a, 0x01, 0x2a,
+	0x12, 0x74, 0x0a, 0x0a, 0x46, 0x6f, 0x72, 0x63, 0x65, 0x4c, 0x65, 0x61, 0x76, 0x65, 0x12, 0x27,
+	0x2e, 0x67, 0x6d, 0x71, 0x74, 0x74, 0x2e, 0x66, 0x65, 0x64, 0x65, 0x72, 0x61, 0x74, 0x69, 0x6f,
+	0x6e, 0x2e, 0x61, 0x70, 0x69, 0x2e, 0x46, 0x6f, 0x72, 0x63, 0x65, 0x4c, 0x65, 0x61, 0x76, 0x65,
+	0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x16, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65,
+	0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x45, 0x6d, 0x70, 0x74, 0x79, 0x22,
+	0x25, 0x82, 0xd3, 0xe4, 0x93, 0x02, 0x1f, 0x22, 0x1a, 0x2f, 0x76, 0x31, 0x2f, 0x66, 0x65, 0x64,
+	0x65, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x2f, 0x66, 0x6f, 0x72, 0x63, 0x65, 0x5f, 0x6c, 0x65,
+	0x61, 0x76, 0x65, 0x3a, 0x01, 0x2a, 0x12, 0x70, 0x0a, 0x0b, 0x4c, 0x69, 0x73, 0x74, 0x4d, 0x65,
+	0x6d, 0x62, 0x65, 0x72, 0x73, 0x12, 0x16, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70,
+	0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x45, 0x6d, 0x70, 0x74, 0x79, 0x1a, 0x29, 0x2e,
+	0x67, 0x6d, 0x71, 0x74, 0x74, 0x2e, 0x66, 0x65, 0x64, 0x65, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e,
+	0x2e, 0x61, 0x70, 0x69, 0x2e, 0x4c, 0x69, 0x73, 0x74, 0x4d, 0x65, 0x6d, 0x62, 0x65, 0x72, 0x73,
+	0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x22, 0x1e, 0x82, 0xd3, 0xe4, 0x93, 0x02, 0x18,
+	0x12, 0x16, 0x2f, 0x76, 0x31, 0x2f, 0x66, 0x65, 0x64, 0x65, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e,
+	0x2f, 0x6d, 0x65, 0x6d, 0x62, 0x65, 0x72, 0x73, 0x32, 0x81, 0x02, 0x0a, 0x0a, 0x46, 0x65, 0x64,
+	0x65, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x12, 0x4d, 0x0a, 0x05, 0x48, 0x65, 0x6c, 0x6c, 0x6f,
+	0x12, 0x21, 0x2e, 0x67, 0x6d, 0x71, 0x74, 0x74, 0x2e, 0x66, 0x65, 0x64, 0x65, 0x72, 0x61, 0x74,
+	0x69, 0x6f, 0x6e, 0x2e, 0x61, 0x70, 0x69, 0x2e, 0x43, 0x6c, 0x69, 0x65, 0x6e, 0x74, 0x48, 0x65,
+	0x6c, 0x6c, 0x6f, 0x1a, 0x21, 0x2e, 0x67, 0x6d, 0x71, 0x74, 0x74, 0x2e, 0x66, 0x65, 0x64, 0x65,
+	0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x2e, 0x61, 0x70, 0x69, 0x2e, 0x53, 0x65, 0x72, 0x76, 0x65,
+	0x72, 0x48, 0x65, 0x6c, 0x6c, 0x6f, 0x12, 0x49, 0x0a, 0x0b, 0x45, 0x76, 0x65, 0x6e, 0x74, 0x53,
+	0x74, 0x72, 0x65, 0x61, 0x6d, 0x12, 0x1b, 0x2e, 0x67, 0x6d, 0x71, 0x74, 0x74, 0x2e, 0x66, 0x65,
+	0x64, 0x65, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x2e, 0x61, 0x70, 0x69, 0x2e, 0x45, 0x76, 0x65,
+	0x6e, 0x74, 0x1a, 0x19, 0x2e, 0x67, 0x6d, 0x71, 0x74, 0x74, 0x2e, 0x66, 0x65, 0x64, 0x65, 0x72,
+	0x61, 0x74, 0x69, 0x6f, 0x6e, 0x2e, 0x61, 0x70, 0x69, 0x2e, 0x41, 0x63, 0x6b, 0x28, 0x01, 0x30,
+	0x01, 0x12, 0x59, 0x0a, 0x08, 0x54, 0x61, 0x6b, 0x65, 0x6f, 0x76, 0x65, 0x72, 0x12, 0x25, 0x2e,
+	0x67, 0x6d, 0x71, 0x74, 0x74, 0x2e, 0x66, 0x65, 0x64, 0x65, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e,
+	0x2e, 0x61, 0x70, 0x69, 0x2e, 0x54, 0x61, 0x6b, 0x65, 0x6f, 0x76, 0x65, 0x72, 0x52, 0x65, 0x71,
+	0x75, 0x65, 0x73, 0x74, 0x1a, 0x26, 0x2e, 0x67, 0x6d, 0x71, 0x74, 0x74, 0x2e, 0x66, 0x65, 0x64,
+	0x65, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x2e, 0x61, 0x70, 0x69, 0x2e, 0x54, 0x61, 0x6b, 0x65,
+	0x6f, 0x76, 0x65, 0x72, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x42, 0x0e, 0x5a, 0x0c,
+	0x2e, 0x3b, 0x66, 0x65, 0x64, 0x65, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x62, 0x06, 0x70, 0x72,
+	0x6f, 0x74, 0x6f, 0x33,
+}
+
+var (
+	file_federation_proto_rawDescOnce sync.Once
+	file_federation_proto_rawDescData = file_federation_proto_rawDesc
+)
+
+func file_federation_proto_rawDescGZIP() []byte {
+	file_federation_proto_rawDescOnce.Do(func() {
+		file_federation_proto_rawDescData = protoimpl.X.CompressGZIP(file_federation_proto_rawDescData)
+	})
+	return file_federation_proto_rawDescData
+}
+
+var file_federation_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
+var file_federation_proto_msgTypes = make([]protoimpl.MessageInfo, 17)
+var file_federation_proto_goTypes = []interface{}{
+	(Status)(0),                 // 0: gmqtt.federation.api.Status
+	(*Event)(nil),               // 1: gmqtt.federation.api.Event
+	(*Subscribe)(nil),           // 2: gmqtt.federation.api.Subscribe
+	(*Message)(nil),             // 3: gmqtt.federation.api.Message
+	(*UserProperty)(nil),        // 4: gmqtt.federation.api.UserProperty
+	(*Unsubscribe)(nil),         // 5: gmqtt.federation.api.Unsubscribe
+	(*SessionCreated)(nil),      // 6: gmqtt.federation.api.SessionCreated
+	(*SessionTerminated)(nil),   // 7: gmqtt.federation.api.SessionTerminated
+	(*Ack)(nil),                 // 8: gmqtt.federation.api.Ack
+	(*ClientHello)(nil),         // 9: gmqtt.federation.api.ClientHello
+	(*ServerHello)(nil),         // 10: gmqtt.federation.api.ServerHello
+	(*JoinRequest)(nil),         // 11: gmqtt.federation.api.JoinRequest
+	(*Member)(nil),              // 12: gmqtt.federation.api.Member
+	(*ListMembersResponse)(nil), // 13: gmqtt.federation.api.ListMembersResponse
+	(*ForceLeaveRequest)(nil),   // 14: gmqtt.federation.api.ForceLeaveRequest
+	(*TakeoverRequest)(nil),     // 15: gmqtt.federation.api.TakeoverRequest
+	(*TakeoverResponse)(nil),    // 16: gmqtt.federation.api.TakeoverResponse
+	nil,                         // 17: gmqtt.federation.api.Member.TagsEntry
+	(*empty.Empty)(nil),         // 18: google.protobuf.Empty
+}
+var file_federation_proto_depIdxs = []int32{
+	2,  // 0: gmqtt.federation.api.Event.Subscribe:type_name -> gmqtt.federation.api.Subscribe
+	3,  // 1: gmqtt.federation.api.Event.message:type_name -> gmqtt.federation.api.Message
+	5,  // 2: gmqtt.federation.api.Event.unsubscribe:type_name -> gmqtt.federation.api.Unsubscribe
+	6,  // 3: gmqtt.federation.api.Event.session_created:type_name -> gmqtt.federation.api.SessionCreated
+	7,  // 4: gmqtt.federation.api.Event.session_terminated:type_name -> gmqtt.federation.api.SessionTerminated
+	4,  // 5: gmqtt.federation.api.Message.user_properties:type_name -> gmqtt.federation.api.UserProperty
+	17, // 6: gmqtt.federation.api.Member.tags:type_name -> gmqtt.federation.api.Member.TagsEntry
+	0,  // 7: gmqtt.federation.api.Member.status:type_name -> gmqtt.federation.api.Status
+	12, // 8: gmqtt.federation.api.ListMembersResponse.members:type_name -> gmqtt.federation.api.Member
+	11, // 9: gmqtt.federation.api.Membership.Join:input_type -> gmqtt.federation.api.JoinRequest
+	18, // 10: gmqtt.federation.api.Membership.Leave:input_type -> google.protobuf.Empty
+	14, // 11: gmqtt.federation.api.Membership.ForceLeave:input_type -> gmqtt.federation.api.ForceLeaveRequest
+	18, // 12: gmqtt.federation.api.Membership.ListMembers:input_type -> google.protobuf.Empty
+	9,  // 13: gmqtt.federation.api.Federation.Hello:input_type -> gmqtt.federation.api.ClientHello
+	1,  // 14: gmqtt.federation.api.Federation.EventStream:input_type -> gmqtt.federation.api.Event
+	15, // 15: gmqtt.federation.api.Federation.Takeover:input_type -> gmqtt.federation.api.TakeoverRequest
+	18, // 16: gmqtt.federation.api.Membership.Join:output_type -> google.protobuf.Empty
+	18, // 17: gmqtt.federation.api.Membership.Leave:output_type -> google.protobuf.Empty
+	18, // 18: gmqtt.federation.api.Membership.ForceLeave:output_type -> google.protobuf.Empty
+	13, // 19: gmqtt.federation.api.Membership.ListMembers:output_type -> gmqtt.federation.api.ListMembersResponse
+	10, // 20: gmqtt.federation.api.Federation.Hello:output_type -> gmqtt.federation.api.ServerHello
+	8,  // 21: gmqtt.federation.api.Federation.EventStream:output_type -> gmqtt.federation.api.Ack
+	16, // 22: gmqtt.federation.api.Federation.Takeover:output_type -> gmqtt.federation.api.TakeoverResponse
+	16, // [16:23] is the sub-list for method output_type
+	9,  // [9:16] is the sub-list for method input_type
+	9,  // [9:9] is the sub-list for extension type_name
+	9,  // [9:9] is the sub-list for extension extendee
+	0,  // [0:9] is the sub-list for field type_name
+}
+
+func init() { file_federation_proto_init() }
+func file_federation_proto_init() {
+	if File_federation_proto != nil {
+		return
+	}
+	if !protoimpl.UnsafeEnabled {
+		file_federation_proto_msgTypes[0].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Event); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_federation_proto_msgTypes[1].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Subscribe); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_federation_proto_msgTypes[2].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Message); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_federation_proto_msgTypes[3].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*UserProperty); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_federation_proto_msgTypes[4].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Unsubscribe); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_federation_proto_msgTypes[5].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*SessionCreated); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_federation_proto_msgTypes[6].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*SessionTerminated); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_federation_proto_msgTypes[7].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Ack); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_federation_proto_msgTypes[8].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*ClientHello); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_federation_proto_msgTypes[9].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*ServerHello); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_federation_proto_msgTypes[10].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*JoinRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_federation_proto_msgTypes[11].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Member); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_federation_proto_msgTypes[12].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*ListMembersResponse); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_federation_proto_msgTypes[13].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*ForceLeaveRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_federation_proto_msgTypes[14].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*TakeoverRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_federation_proto_msgTypes[15].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*TakeoverResponse); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+	}
+	file_federation_proto_msgTypes[0].OneofWrappers = []interface{}{
+		(*Event_Subscribe)(nil),
+		(*Event_Message)(nil),
+		(*Event_Unsubscribe)(nil),
+		(*Event_SessionCreated)(nil),
+		(*Event_SessionTerminated)(nil),
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: file_federation_proto_rawDesc,
+			NumEnums:      1,
+			NumMessages:   17,
+			NumExtensions: 0,
+			NumServices:   2,
+		},
+		GoTypes:           file_federation_proto_goTypes,
+		DependencyIndexes: file_federation_proto_depIdxs,
+		EnumInfos:         file_federation_proto_enumTypes,
+		MessageInfos:      file_federation_proto_msgTypes,
+	}.Build()
+	File_federation_proto = out.File
+	file_federation_proto_rawDesc = nil
+	file_federation_proto_goTypes = nil
+	file_federation_proto_depIdxs = nil
 }
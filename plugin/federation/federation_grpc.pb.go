@@ -239,6 +239,8 @@ var Membership_ServiceDesc = grpc.ServiceDesc{
 type FederationClient interface {
 	Hello(ctx context.Context, in *ClientHello, opts ...grpc.CallOption) (*ServerHello, error)
 	EventStream(ctx context.Context, opts ...grpc.CallOption) (Federation_EventStreamClient, error)
+	// Takeover disconnects the client from the node and removes its session, the session is returned to the caller.
+	Takeover(ctx context.Context, in *TakeoverRequest, opts ...grpc.CallOption) (*TakeoverResponse, error)
 }
 
 type federationClient struct {
@@ -289,12 +291,23 @@ func (x *federationEventStreamClient) Recv() (*Ack, error) {
 	return m, nil
 }
 
+func (c *federationClient) Takeover(ctx context.Context, in *TakeoverRequest, opts ...grpc.CallOption) (*TakeoverResponse, error) {
+	out := new(TakeoverResponse)
+	err := c.cc.Invoke(ctx, "/gmqtt.federation.api.Federation/Takeover", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // FederationServer is the server API for Federation service.
 // All implementations must embed UnimplementedFederationServer
 // for forward compatibility
 type FederationServer interface {
 	Hello(context.Context, *ClientHello) (*ServerHello, error)
 	EventStream(Federation_EventStreamServer) error
+	// Takeover disconnects the client from the node and removes its session, the session is returned to the caller.
+	Takeover(context.Context, *TakeoverRequest) (*TakeoverResponse, error)
 	mustEmbedUnimplementedFederationServer()
 }
 
@@ -308,6 +321,9 @@ func (UnimplementedFederationServer) Hello(context.Context, *ClientHello) (*Serv
 func (UnimplementedFederationServer) EventStream(Federation_EventStreamServer) error {
 	return status.Errorf(codes.Unimplemented, "method EventStream not implemented")
 }
+func (UnimplementedFederationServer) Takeover(context.Context, *TakeoverRequest) (*TakeoverResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Takeover not implemented")
+}
 func (UnimplementedFederationServer) mustEmbedUnimplementedFederationServer() {}
 
 // UnsafeFederationServer may be embedded to opt out of forward compatibility for this service.
@@ -365,6 +381,24 @@ func (x *federationEventStreamServer) Recv() (*Event, error) {
 	return m, nil
 }
 
+func _Federation_Takeover_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(TakeoverRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FederationServer).Takeover(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/gmqtt.federation.api.Federation/Takeover",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FederationServer).Takeover(ctx, req.(*TakeoverRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // Federation_ServiceDesc is the grpc.ServiceDesc for Federation service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -376,6 +410,10 @@ var Federation_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "Hello",
 			Handler:    _Federation_Hello_Handler,
 		},
+		{
+			MethodName: "Takeover",
+			Handler:    _Federation_Takeover_Handler,
+		},
 	},
 	Streams: []grpc.StreamDesc{
 		{
@@ -275,6 +275,18 @@ func TestFederation_eventStreamHandler(t *testing.T) {
 	sts, _ = f.fedSubStore.GetClientStats("node1")
 	a.EqualValues(0, sts.SubscriptionsCurrent)
 
+	ack = f.eventStreamHandler(sess, &Event{
+		Id:    3,
+		Event: sessionEvent("client1", true).Event,
+	})
+	a.EqualValues(3, ack.EventId)
+	a.Equal([]string{"node1"}, f.sessionOwners.get("client1"))
+	ack = f.eventStreamHandler(sess, &Event{
+		Id:    4,
+		Event: sessionEvent("client1", false).Event,
+	})
+	a.EqualValues(4, ack.EventId)
+	a.Empty(f.sessionOwners.get("client1"))
 }
 
 func TestFederation_getSerfConfig(t *testing.T) {
@@ -417,6 +429,7 @@ func TestFederation_Hello(t *testing.T) {
 	f.fedSubStore.Subscribe(clientNodeName, &gmqtt.Subscription{
 		TopicFilter: "topicA",
 	})
+	f.sessionOwners.add(clientNodeName, "client1")
 	ctx := mockMetaContext(clientNodeName)
 	resp, err := f.Hello(ctx, &ClientHello{
 		SessionId: clientSid,
@@ -425,8 +438,9 @@ func TestFederation_Hello(t *testing.T) {
 	// cleanStart == true on first time
 	a.True(resp.CleanStart)
 	a.Zero(resp.NextEventId)
-	// clean subscription tree if cleanStart == true
+	// clean subscription tree and sessions if cleanStart == true
 	a.EqualValues(0, f.fedSubStore.GetStats().SubscriptionsCurrent)
+	a.Empty(f.sessionOwners.get("client1"))
 
 	f.fedSubStore.Subscribe(clientNodeName, &gmqtt.Subscription{
 		TopicFilter: "topicA",
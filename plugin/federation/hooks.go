@@ -16,6 +16,56 @@ func (f *Federation) HookWrapper() server.HookWrapper {
 		OnMsgArrivedWrapper:        f.OnMsgArrivedWrapper,
 		OnSessionTerminatedWrapper: f.OnSessionTerminatedWrapper,
 		OnWillPublishWrapper:       f.OnWillPublishWrapper,
+		OnSessionCreatedWrapper:    f.OnSessionCreatedWrapper,
+		OnSessionResumedWrapper:    f.OnSessionResumedWrapper,
+	}
+}
+
+// OnSessionCreatedWrapper broadcasts the new session, and takes over the client from the nodes which have its session.
+func (f *Federation) OnSessionCreatedWrapper(pre server.OnSessionCreated) server.OnSessionCreated {
+	return func(ctx context.Context, client server.Client) {
+		pre(ctx, client)
+		opts := client.ClientOptions()
+		f.broadcastSession(opts.ClientID, true)
+		f.takeover(opts.ClientID, opts.CleanStart)
+	}
+}
+
+// OnSessionResumedWrapper takes over the client from the nodes which also have its session,
+// the local session takes precedence.
+func (f *Federation) OnSessionResumedWrapper(pre server.OnSessionResumed) server.OnSessionResumed {
+	return func(ctx context.Context, client server.Client, queued int) {
+		pre(ctx, client, queued)
+		f.takeover(client.ClientOptions().ClientID, true)
+	}
+}
+
+// broadcastSession tells all other nodes that the session of the client is created or terminated on the local node.
+func (f *Federation) broadcastSession(clientID string, created bool) {
+	f.memberMu.Lock()
+	defer f.memberMu.Unlock()
+	for _, v := range f.peers {
+		v.queue.add(sessionEvent(clientID, created))
+	}
+}
+
+// subscribe adds the subscription of the local client and broadcasts it to other nodes if it is new.
+func (f *Federation) subscribe(clientID string, subscription *gmqtt.Subscription) {
+	if !f.localSubStore.subscribe(clientID, subscription.GetFullTopicName()) {
+		return
+	}
+	// only send new subscription
+	f.memberMu.Lock()
+	defer f.memberMu.Unlock()
+	for _, v := range f.peers {
+		sub := &Subscribe{
+			ShareName:   subscription.ShareName,
+			TopicFilter: subscription.TopicFilter,
+		}
+		v.queue.add(&Event{
+			Event: &Event_Subscribe{
+				Subscribe: sub,
+			}})
 	}
 }
 
@@ -23,22 +73,7 @@ func (f *Federation) OnSubscribedWrapper(pre server.OnSubscribed) server.OnSubsc
 	return func(ctx context.Context, client server.Client, subscription *gmqtt.Subscription) {
 		pre(ctx, client, subscription)
 		if subscription != nil {
-			if !f.localSubStore.subscribe(client.ClientOptions().ClientID, subscription.GetFullTopicName()) {
-				return
-			}
-			// only send new subscription
-			f.memberMu.Lock()
-			defer f.memberMu.Unlock()
-			for _, v := range f.peers {
-				sub := &Subscribe{
-					ShareName:   subscription.ShareName,
-					TopicFilter: subscription.TopicFilter,
-				}
-				v.queue.add(&Event{
-					Event: &Event_Subscribe{
-						Subscribe: sub,
-					}})
-			}
+			f.subscribe(client.ClientOptions().ClientID, subscription)
 		}
 	}
 }
@@ -197,6 +232,7 @@ func (f *Federation) OnMsgArrivedWrapper(pre server.OnMsgArrived) server.OnMsgAr
 func (f *Federation) OnSessionTerminatedWrapper(pre server.OnSessionTerminated) server.OnSessionTerminated {
 	return func(ctx context.Context, clientID string, reason server.SessionTerminatedReason) {
 		pre(ctx, clientID, reason)
+		f.broadcastSession(clientID, false)
 		if unsubs := f.localSubStore.unsubscribeAll(clientID); len(unsubs) != 0 {
 			f.memberMu.Lock()
 			defer f.memberMu.Unlock()
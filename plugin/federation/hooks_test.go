@@ -328,8 +328,11 @@ func TestFederation_OnSessionTerminatedWrapper(t *testing.T) {
 		return
 	})
 
+	// the terminated session is broadcast.
+	mockQueue.EXPECT().add(sessionEvent("client1", false))
 	onSessionTerminated(context.Background(), "client1", 0)
 
+	mockQueue.EXPECT().add(sessionEvent("client2", false))
 	mockQueue.EXPECT().add(&Event{
 		Event: &Event_Unsubscribe{
 			Unsubscribe: &Unsubscribe{
@@ -340,6 +343,7 @@ func TestFederation_OnSessionTerminatedWrapper(t *testing.T) {
 	onSessionTerminated(context.Background(), "client2", 0)
 
 	var b, c bool
+	mockQueue.EXPECT().add(sessionEvent("client3", false))
 	mockQueue.EXPECT().add(gomock.Any()).Do(func(event *Event) {
 		if event.Event.(*Event_Unsubscribe).Unsubscribe.TopicName == "/topicB" {
 			b = true
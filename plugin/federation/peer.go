@@ -43,13 +43,15 @@ type peer struct {
 }
 
 type stream struct {
-	queue   queue
-	conn    *grpc.ClientConn
-	client  Federation_EventStreamClient
-	close   chan struct{}
-	errOnce sync.Once
-	err     error
-	wg      sync.WaitGroup
+	queue  queue
+	conn   *grpc.ClientConn
+	client Federation_EventStreamClient
+	// fedClient is the client of the Federation service which shares the connection with the stream.
+	fedClient FederationClient
+	close     chan struct{}
+	errOnce   sync.Once
+	err       error
+	wg        sync.WaitGroup
 }
 
 // interface for testing
@@ -192,6 +194,17 @@ func (p *peer) stop() {
 	}
 }
 
+// federationClient returns the client of the Federation service of the peer,
+// it returns nil if the event stream has not been established.
+func (p *peer) federationClient() FederationClient {
+	p.stateMu.Lock()
+	defer p.stateMu.Unlock()
+	if p.state != peerStateStreaming {
+		return nil
+	}
+	return p.stream.fedClient
+}
+
 func (p *peer) serveEventStream() {
 	timer := time.NewTimer(0)
 	var reconnectCount int
@@ -252,6 +265,11 @@ func (p *peer) initStream(client FederationClient, conn *grpc.ClientConn) (s *st
 		}
 		p.fed.localSubStore.Unlock()
 
+		_ = p.fed.clientService.IterateSession(func(session *gmqtt.Session) bool {
+			p.queue.add(sessionEvent(session.ClientID, true))
+			return true
+		})
+
 		p.fed.retainedStore.Iterate(func(message *gmqtt.Message) bool {
 			// TODO add timestamp to retained message and use Last Write Wins (LWW) to resolve write conflicts.
 			p.queue.add(&Event{
@@ -271,10 +289,11 @@ func (p *peer) initStream(client FederationClient, conn *grpc.ClientConn) (s *st
 	}
 	p.queue.open()
 	s = &stream{
-		queue:  p.queue,
-		conn:   conn,
-		client: c,
-		close:  make(chan struct{}),
+		queue:     p.queue,
+		conn:      conn,
+		client:    c,
+		fedClient: client,
+		close:     make(chan struct{}),
 	}
 	p.stream = s
 	return s, nil
@@ -12,6 +12,7 @@ import (
 	"github.com/DrmagicE/gmqtt/persistence/subscription/mem"
 	"github.com/DrmagicE/gmqtt/retained"
 	"github.com/DrmagicE/gmqtt/retained/trie"
+	"github.com/DrmagicE/gmqtt/server"
 )
 
 func TestPeer_initStream_CleanStart(t *testing.T) {
@@ -25,10 +26,12 @@ func TestPeer_initStream_CleanStart(t *testing.T) {
 	ls.init(mem.NewStore())
 
 	retained := trie.NewStore()
+	cs := server.NewMockClientService(ctrl)
 	p := &peer{
 		fed: &Federation{
 			localSubStore: ls,
 			retainedStore: retained,
+			clientService: cs,
 		},
 		localName: "",
 		member: serf.Member{
@@ -50,6 +53,10 @@ func TestPeer_initStream_CleanStart(t *testing.T) {
 	}
 	retained.AddOrReplace(m1)
 	retained.AddOrReplace(m2)
+	cs.EXPECT().IterateSession(gomock.Any()).DoAndReturn(func(fn func(*gmqtt.Session) bool) error {
+		fn(&gmqtt.Session{ClientID: "c1"})
+		return nil
+	})
 
 	client := NewMockFederationClient(ctrl)
 
@@ -70,6 +77,7 @@ func TestPeer_initStream_CleanStart(t *testing.T) {
 	// So we had to collect them into map.
 	subEvents := make(map[string]string)
 	msgEvents := make(map[string]string)
+	var sessEvents []string
 
 	expectedSubEvents := map[string]*Event{
 		"topicA": {
@@ -107,10 +115,12 @@ func TestPeer_initStream_CleanStart(t *testing.T) {
 		case *Event_Message:
 			msg := event.Event.(*Event_Message)
 			msgEvents[msg.Message.TopicName] = event.String()
+		case *Event_SessionCreated:
+			sessEvents = append(sessEvents, event.String())
 		default:
 			a.FailNow("unexpected event type: %s", reflect.TypeOf(event.Event))
 		}
-	}).Times(4)
+	}).Times(5)
 
 	client.EXPECT().EventStream(gomock.Any())
 	_, err := p.initStream(client, nil)
@@ -122,6 +132,7 @@ func TestPeer_initStream_CleanStart(t *testing.T) {
 	for k, v := range subEvents {
 		a.Equal(expectedSubEvents[k].String(), v)
 	}
+	a.Equal([]string{sessionEvent("c1", true).String()}, sessEvents)
 
 }
 
@@ -0,0 +1,253 @@
+package federation
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	mqttcodes "github.com/DrmagicE/gmqtt/pkg/codes"
+	"github.com/DrmagicE/gmqtt/pkg/packets"
+	"github.com/DrmagicE/gmqtt/server"
+)
+
+// takeoverPollInterval is the interval to check whether the client which is taken over has gone offline.
+var takeoverPollInterval = 10 * time.Millisecond
+
+// Takeover disconnects the client from the local node and removes its session, the session is returned to the caller.
+// It is called by the node which the client is connecting to.
+func (f *Federation) Takeover(ctx context.Context, req *TakeoverRequest) (resp *TakeoverResponse, err error) {
+	nodeName, err := getNodeNameFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if req.ClientId == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "Takeover: missing client_id")
+	}
+	if err = f.disconnect(ctx, req.ClientId); err != nil {
+		return nil, status.Errorf(codes.DeadlineExceeded, "Takeover: %s", err.Error())
+	}
+	resp = &TakeoverResponse{}
+	if req.CleanStart {
+		f.clientService.TerminateSession(req.ClientId)
+		return resp, nil
+	}
+	exp, err := f.clientService.ExportSession(req.ClientId, true)
+	if err == server.ErrSessionNotFound {
+		return resp, nil
+	}
+	if err != nil {
+		// drop the session which can not be handed over, the client must not have sessions on two nodes.
+		f.clientService.TerminateSession(req.ClientId)
+		return nil, status.Errorf(codes.Internal, "Takeover: %s", err.Error())
+	}
+	resp.Session, err = json.Marshal(exp)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "Takeover: %s", err.Error())
+	}
+	log.Info("session taken over by remote node",
+		zap.String("client_id", req.ClientId),
+		zap.String("remote_node", nodeName))
+	return resp, nil
+}
+
+// disconnect disconnects the local client and waits until it goes offline.
+func (f *Federation) disconnect(ctx context.Context, clientID string) error {
+	client := f.clientService.GetClient(clientID)
+	if client == nil {
+		return nil
+	}
+	if client.Version() == packets.Version5 {
+		client.Disconnect(&packets.Disconnect{
+			Version: packets.Version5,
+			Code:    mqttcodes.SessionTakenOver,
+		})
+	} else {
+		client.Close()
+	}
+	t := time.NewTicker(takeoverPollInterval)
+	defer t.Stop()
+	for f.clientService.GetClient(clientID) != nil {
+		select {
+		case <-ctx.Done():
+			client.Close()
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+	return nil
+}
+
+func sessionEvent(clientID string, created bool) *Event {
+	if created {
+		return &Event{
+			Event: &Event_SessionCreated{
+				SessionCreated: &SessionCreated{ClientId: clientID},
+			}}
+	}
+	return &Event{
+		Event: &Event_SessionTerminated{
+			SessionTerminated: &SessionTerminated{ClientId: clientID},
+		}}
+}
+
+// sessionOwners records the nodes which have the session of the client.
+// It is replicated by the SessionCreated and SessionTerminated events, so that the client is only taken over
+// from the nodes which have its session.
+type sessionOwners struct {
+	mu sync.Mutex
+	// nodes is the client ids of the sessions for each node, [nodeName][clientID]
+	nodes map[string]map[string]struct{}
+}
+
+func newSessionOwners() *sessionOwners {
+	return &sessionOwners{
+		nodes: make(map[string]map[string]struct{}),
+	}
+}
+
+func (s *sessionOwners) add(nodeName string, clientID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.nodes[nodeName]; !ok {
+		s.nodes[nodeName] = make(map[string]struct{})
+	}
+	s.nodes[nodeName][clientID] = struct{}{}
+}
+
+func (s *sessionOwners) remove(nodeName string, clientID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.nodes[nodeName], clientID)
+	if len(s.nodes[nodeName]) == 0 {
+		delete(s.nodes, nodeName)
+	}
+}
+
+// removeNode removes all sessions of the node.
+func (s *sessionOwners) removeNode(nodeName string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.nodes, nodeName)
+}
+
+// get returns the nodes which have the session of the client.
+func (s *sessionOwners) get(clientID string) (nodes []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for nodeName, v := range s.nodes {
+		if _, ok := v[clientID]; ok {
+			nodes = append(nodes, nodeName)
+		}
+	}
+	return nodes
+}
+
+// takeover takes over the client from the other nodes which have its session in the background,
+// it does not block the caller.
+// If the clean start flag is not set, the sessions taken over are merged into the local session of the client.
+func (f *Federation) takeover(clientID string, cleanStart bool) {
+	nodes := f.sessionOwners.get(clientID)
+	if len(nodes) == 0 {
+		return
+	}
+	clients := make(map[string]FederationClient)
+	f.memberMu.Lock()
+	for _, name := range nodes {
+		if p, ok := f.peers[name]; ok {
+			if c := p.federationClient(); c != nil {
+				clients[name] = c
+			}
+		}
+	}
+	f.memberMu.Unlock()
+	if len(clients) == 0 {
+		return
+	}
+	go func() {
+		if exp := f.takeoverFrom(clients, clientID, cleanStart); exp != nil {
+			f.mergeSession(exp)
+		}
+	}()
+}
+
+// takeoverFrom sends the Takeover request to the nodes and returns the latest session taken over,
+// or nil if there is none.
+func (f *Federation) takeoverFrom(clients map[string]FederationClient, clientID string, cleanStart bool) *server.SessionExport {
+	ctx, cancel := context.WithTimeout(context.Background(), f.config.SessionTakeoverTimeout)
+	defer cancel()
+	ctx = metadata.NewOutgoingContext(ctx, metadata.Pairs("node_name", f.nodeName))
+
+	var mu sync.Mutex
+	var exp *server.SessionExport
+	var wg sync.WaitGroup
+	for name, c := range clients {
+		wg.Add(1)
+		go func(nodeName string, c FederationClient) {
+			defer wg.Done()
+			resp, err := c.Takeover(ctx, &TakeoverRequest{
+				ClientId:   clientID,
+				CleanStart: cleanStart,
+			})
+			if err != nil {
+				log.Warn("fail to take over the client from remote node", zap.Error(err),
+					zap.String("client_id", clientID),
+					zap.String("remote_node", nodeName))
+				return
+			}
+			if len(resp.Session) == 0 {
+				return
+			}
+			e := &server.SessionExport{}
+			if err = json.Unmarshal(resp.Session, e); err != nil || e.Session == nil {
+				log.Error("invalid session from remote node", zap.Error(err),
+					zap.String("client_id", clientID),
+					zap.String("remote_node", nodeName))
+				return
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			// the client is not supposed to have sessions on more than one node, keep the latest one.
+			if exp == nil || e.Session.ConnectedAt.After(exp.Session.ConnectedAt) {
+				exp = e
+			}
+		}(name, c)
+	}
+	wg.Wait()
+	return exp
+}
+
+// mergeSession merges the session taken over from another node into the local session of the client.
+// The subscriptions are added and broadcast, and the queued messages are delivered to the matching subscriptions.
+// The inflight state can not be merged, the inflight messages are delivered as new messages
+// and the QoS 2 messages which are waiting for the PUBREL are discarded.
+func (f *Federation) mergeSession(exp *server.SessionExport) {
+	clientID := exp.Session.ClientID
+	if len(exp.Subscriptions) != 0 {
+		if _, err := f.localSubStore.localStore.Subscribe(clientID, exp.Subscriptions...); err != nil {
+			log.Warn("fail to merge the subscriptions taken over", zap.Error(err), zap.String("client_id", clientID))
+			return
+		}
+		for _, v := range exp.Subscriptions {
+			f.subscribe(clientID, v)
+		}
+	}
+	log.Info("session taken over from remote node",
+		zap.String("client_id", clientID),
+		zap.Int("subscriptions", len(exp.Subscriptions)),
+		zap.Int("queued", len(exp.Queue)))
+	now := time.Now()
+	for _, v := range exp.Queue {
+		if v.Message == nil || (!v.Expiry.IsZero() && now.After(v.Expiry)) {
+			continue
+		}
+		msg := v.Message.Copy()
+		msg.PacketID = 0
+		f.publisher.PublishTo(clientID, msg)
+	}
+}
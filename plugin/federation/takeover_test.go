@@ -0,0 +1,256 @@
+package federation
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/hashicorp/serf/serf"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DrmagicE/gmqtt"
+	"github.com/DrmagicE/gmqtt/persistence/subscription/mem"
+	mqttcodes "github.com/DrmagicE/gmqtt/pkg/codes"
+	"github.com/DrmagicE/gmqtt/pkg/packets"
+	"github.com/DrmagicE/gmqtt/server"
+)
+
+func TestFederation_Takeover(t *testing.T) {
+	a := assert.New(t)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	p, _ := New(testConfig)
+	f := p.(*Federation)
+	cs := server.NewMockClientService(ctrl)
+	f.clientService = cs
+	ctx := mockMetaContext("node1")
+
+	// the connected v5 client is disconnected with the session taken over reason code.
+	client := server.NewMockClient(ctrl)
+	client.EXPECT().Version().Return(packets.Version5)
+	client.EXPECT().Disconnect(&packets.Disconnect{
+		Version: packets.Version5,
+		Code:    mqttcodes.SessionTakenOver,
+	})
+	exp := &server.SessionExport{
+		Version: server.SessionExportVersion,
+		Session: &gmqtt.Session{
+			ClientID:       "client1",
+			ExpiryInterval: 100,
+		},
+		Subscriptions: []*gmqtt.Subscription{
+			{TopicFilter: "topicA", QoS: 1},
+		},
+	}
+	gomock.InOrder(
+		cs.EXPECT().GetClient("client1").Return(client),
+		cs.EXPECT().GetClient("client1").Return(client),
+		cs.EXPECT().GetClient("client1").Return(nil),
+		cs.EXPECT().ExportSession("client1", true).Return(exp, nil),
+	)
+	resp, err := f.Takeover(ctx, &TakeoverRequest{ClientId: "client1"})
+	a.NoError(err)
+	got := &server.SessionExport{}
+	a.NoError(json.Unmarshal(resp.Session, got))
+	a.Equal(exp.Session.ClientID, got.Session.ClientID)
+	a.Equal(exp.Subscriptions, got.Subscriptions)
+
+	// the session is discarded if the clean start flag is set.
+	cs.EXPECT().GetClient("client1").Return(nil)
+	cs.EXPECT().TerminateSession("client1")
+	resp, err = f.Takeover(ctx, &TakeoverRequest{ClientId: "client1", CleanStart: true})
+	a.NoError(err)
+	a.Empty(resp.Session)
+
+	// no session on the node.
+	cs.EXPECT().GetClient("client1").Return(nil)
+	cs.EXPECT().ExportSession("client1", true).Return(nil, server.ErrSessionNotFound)
+	resp, err = f.Takeover(ctx, &TakeoverRequest{ClientId: "client1"})
+	a.NoError(err)
+	a.Empty(resp.Session)
+
+	// the session which can not be exported is dropped.
+	cs.EXPECT().GetClient("client1").Return(nil)
+	cs.EXPECT().ExportSession("client1", true).Return(nil, errors.New("error"))
+	cs.EXPECT().TerminateSession("client1")
+	_, err = f.Takeover(ctx, &TakeoverRequest{ClientId: "client1"})
+	a.Error(err)
+
+	// the client does not go offline in time.
+	client.EXPECT().Version().Return(packets.Version311)
+	client.EXPECT().Close().Times(2)
+	cs.EXPECT().GetClient("client1").Return(client).AnyTimes()
+	timeoutCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	_, err = f.Takeover(timeoutCtx, &TakeoverRequest{ClientId: "client1"})
+	a.Error(err)
+}
+
+func TestSessionOwners(t *testing.T) {
+	a := assert.New(t)
+	s := newSessionOwners()
+	s.add("node1", "client1")
+	s.add("node2", "client1")
+	s.add("node2", "client2")
+	nodes := s.get("client1")
+	sort.Strings(nodes)
+	a.Equal([]string{"node1", "node2"}, nodes)
+	a.Equal([]string{"node2"}, s.get("client2"))
+	a.Empty(s.get("client3"))
+
+	s.remove("node1", "client1")
+	a.Equal([]string{"node2"}, s.get("client1"))
+	a.NotContains(s.nodes, "node1")
+
+	s.removeNode("node2")
+	a.Empty(s.get("client1"))
+	a.Empty(s.get("client2"))
+}
+
+func TestFederation_OnSessionCreatedWrapper(t *testing.T) {
+	a := assert.New(t)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	p, _ := New(testConfig)
+	f := p.(*Federation)
+	f.config.SessionTakeoverTimeout = time.Second
+	f.localSubStore.init(mem.NewStore())
+	pub := server.NewMockPublisher(ctrl)
+	f.publisher = pub
+	f.nodeJoin(serf.MemberEvent{
+		Members: []serf.Member{
+			{Name: "node1"},
+			{Name: "node2"},
+			{Name: "node3"},
+		},
+	})
+	fedClients := make(map[string]*MockFederationClient)
+	queues := make(map[string]*Mockqueue)
+	for _, v := range []string{"node1", "node2", "node3"} {
+		fedClients[v] = NewMockFederationClient(ctrl)
+		f.peers[v].state = peerStateStreaming
+		f.peers[v].stream = &stream{fedClient: fedClients[v]}
+		queues[v] = NewMockqueue(ctrl)
+		f.peers[v].queue = queues[v]
+	}
+	// node3 does not have the session of client1.
+	f.sessionOwners.add("node1", "client1")
+	f.sessionOwners.add("node2", "client1")
+
+	onSessionCreated := f.OnSessionCreatedWrapper(func(ctx context.Context, client server.Client) {})
+	newClient := func(clientID string, cleanStart bool) server.Client {
+		c := server.NewMockClient(ctrl)
+		c.EXPECT().ClientOptions().Return(&server.ClientOptions{
+			ClientID:   clientID,
+			CleanStart: cleanStart,
+		}).AnyTimes()
+		return c
+	}
+	expectSessionCreated := func(clientID string) {
+		for _, q := range queues {
+			q.EXPECT().add(sessionEvent(clientID, true))
+		}
+	}
+	exp := &server.SessionExport{
+		Version: server.SessionExportVersion,
+		Session: &gmqtt.Session{
+			ClientID:    "client1",
+			ConnectedAt: time.Unix(100, 0),
+		},
+		Subscriptions: []*gmqtt.Subscription{
+			{TopicFilter: "topicA", QoS: 1},
+		},
+		Queue: []*server.ExportedElem{
+			{Message: &gmqtt.Message{Topic: "topicA", QoS: 1, PacketID: 1}},
+			{Message: &gmqtt.Message{Topic: "topicA"}, Expiry: time.Now().Add(-time.Second)},
+			{PubrelID: 2},
+		},
+	}
+	b, _ := json.Marshal(exp)
+	expectSessionCreated("client1")
+	fedClients["node1"].EXPECT().Takeover(gomock.Any(), &TakeoverRequest{ClientId: "client1"}).Return(&TakeoverResponse{
+		Session: b,
+	}, nil)
+	fedClients["node2"].EXPECT().Takeover(gomock.Any(), &TakeoverRequest{ClientId: "client1"}).Return(&TakeoverResponse{}, nil)
+	// the merged subscriptions are broadcast.
+	for _, q := range queues {
+		q.EXPECT().add(&Event{
+			Event: &Event_Subscribe{
+				Subscribe: &Subscribe{
+					TopicFilter: "topicA",
+				},
+			},
+		})
+	}
+	// only the queued message which is not expired is delivered.
+	done := make(chan struct{})
+	pub.EXPECT().PublishTo("client1", gomock.Any()).Do(func(clientID string, msg *gmqtt.Message) {
+		a.Equal("topicA", msg.Topic)
+		a.Zero(msg.PacketID)
+		close(done)
+	})
+	onSessionCreated(context.Background(), newClient("client1", false))
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("the session is not merged")
+	}
+	a.EqualValues(1, f.localSubStore.topics["topicA"])
+
+	// the sessions are discarded if the clean start flag is set.
+	expectSessionCreated("client1")
+	var wg sync.WaitGroup
+	wg.Add(2)
+	for _, v := range []string{"node1", "node2"} {
+		fedClients[v].EXPECT().Takeover(gomock.Any(), &TakeoverRequest{ClientId: "client1", CleanStart: true}).
+			DoAndReturn(func(ctx context.Context, req *TakeoverRequest, opts ...interface{}) (*TakeoverResponse, error) {
+				wg.Done()
+				return &TakeoverResponse{}, nil
+			})
+	}
+	onSessionCreated(context.Background(), newClient("client1", true))
+	wg.Wait()
+
+	// do not take over the client which is not owned by other nodes.
+	expectSessionCreated("client2")
+	onSessionCreated(context.Background(), newClient("client2", false))
+}
+
+func TestFederation_OnSessionResumedWrapper(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	p, _ := New(testConfig)
+	f := p.(*Federation)
+	f.config.SessionTakeoverTimeout = time.Second
+	f.nodeJoin(serf.MemberEvent{
+		Members: []serf.Member{
+			{Name: "node1"},
+		},
+	})
+	fedClient := NewMockFederationClient(ctrl)
+	f.peers["node1"].state = peerStateStreaming
+	f.peers["node1"].stream = &stream{fedClient: fedClient}
+	f.sessionOwners.add("node1", "client1")
+
+	onSessionResumed := f.OnSessionResumedWrapper(func(ctx context.Context, client server.Client, queued int) {})
+	client := server.NewMockClient(ctrl)
+	client.EXPECT().ClientOptions().Return(&server.ClientOptions{ClientID: "client1"})
+	// the local session takes precedence, the remote session is discarded.
+	done := make(chan struct{})
+	fedClient.EXPECT().Takeover(gomock.Any(), &TakeoverRequest{ClientId: "client1", CleanStart: true}).
+		DoAndReturn(func(ctx context.Context, req *TakeoverRequest, opts ...interface{}) (*TakeoverResponse, error) {
+			close(done)
+			return &TakeoverResponse{}, nil
+		})
+	onSessionResumed(context.Background(), client, 0)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("the client is not taken over")
+	}
+}
@@ -0,0 +1,65 @@
+package history
+
+import (
+	"time"
+
+	"github.com/DrmagicE/gmqtt"
+)
+
+// entry is a message in the history buffer.
+type entry struct {
+	At      time.Time      `json:"at"`
+	Message *gmqtt.Message `json:"message"`
+}
+
+// buffer is the bounded history buffer of a topic filter, it is a ring of the most recent messages.
+// It is not goroutine safe.
+type buffer struct {
+	topicFilter string
+	maxAge      time.Duration
+	entries     []entry
+	// head is the index of the oldest entry.
+	head int
+	n    int
+}
+
+func newBuffer(cfg TopicConfig) *buffer {
+	return &buffer{
+		topicFilter: cfg.TopicFilter,
+		maxAge:      cfg.MaxAge,
+		entries:     make([]entry, cfg.MaxMessages),
+	}
+}
+
+// add appends the entry, the oldest entry is evicted if the buffer is full.
+func (b *buffer) add(e entry) {
+	if b.n == len(b.entries) {
+		b.entries[b.head] = e
+		b.head = (b.head + 1) % len(b.entries)
+		return
+	}
+	b.entries[(b.head+b.n)%len(b.entries)] = e
+	b.n++
+}
+
+// expire evicts the entries older than maxAge.
+func (b *buffer) expire(now time.Time) {
+	if b.maxAge == 0 {
+		return
+	}
+	deadline := now.Add(-b.maxAge)
+	for b.n > 0 && b.entries[b.head].At.Before(deadline) {
+		b.entries[b.head] = entry{}
+		b.head = (b.head + 1) % len(b.entries)
+		b.n--
+	}
+}
+
+// list returns the entries from the oldest to the newest.
+func (b *buffer) list() []entry {
+	rs := make([]entry, 0, b.n)
+	for i := 0; i < b.n; i++ {
+		rs = append(rs, b.entries[(b.head+i)%len(b.entries)])
+	}
+	return rs
+}
@@ -0,0 +1,95 @@
+package history
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/DrmagicE/gmqtt/pkg/packets"
+)
+
+// Config is the configuration for the history plugin.
+type Config struct {
+	// Topics is the list of the topic filters to retain the history for.
+	// A message is retained in the buffer of the first topic filter that matches its topic.
+	Topics []TopicConfig `yaml:"topics"`
+	// File is the file to persist the history, empty means the history is kept in memory only.
+	// If it is a relative path, it locates in the same directory as the config file.
+	File string `yaml:"file"`
+	// SaveInterval is the interval to save the history into the file.
+	// The history is also saved when the broker stops.
+	SaveInterval time.Duration `yaml:"save_interval"`
+}
+
+// TopicConfig is the retention of the history buffer of a topic filter.
+type TopicConfig struct {
+	TopicFilter string `yaml:"topic_filter"`
+	// MaxMessages is the maximum number of the messages retained in the buffer, the oldest one is evicted if the buffer is full.
+	MaxMessages int `yaml:"max_messages"`
+	// MaxAge is the maximum age of the retained messages, 0 means no limit.
+	MaxAge time.Duration `yaml:"max_age"`
+}
+
+// DefaultTopicConfig is the default value of the topic configuration.
+var DefaultTopicConfig = TopicConfig{
+	MaxMessages: 100,
+}
+
+func (t *TopicConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type cfg TopicConfig
+	v := cfg(DefaultTopicConfig)
+	if err := unmarshal(&v); err != nil {
+		return err
+	}
+	*t = TopicConfig(v)
+	return nil
+}
+
+func (t *TopicConfig) validate() error {
+	if !packets.ValidTopicFilter(true, []byte(t.TopicFilter)) {
+		return fmt.Errorf("invalid topic_filter: %s", t.TopicFilter)
+	}
+	if strings.HasPrefix(t.TopicFilter, replayPrefix) {
+		return fmt.Errorf("invalid topic_filter: %s is reserved", replayPrefix)
+	}
+	if t.MaxMessages <= 0 {
+		return errors.New("invalid max_messages: must be greater than 0")
+	}
+	if t.MaxAge < 0 {
+		return errors.New("invalid max_age: cannot be negative")
+	}
+	return nil
+}
+
+// Validate validates the configuration, and return an error if it is invalid.
+func (c *Config) Validate() error {
+	for k := range c.Topics {
+		if err := c.Topics[k].validate(); err != nil {
+			return fmt.Errorf("topic %s: %s", c.Topics[k].TopicFilter, err)
+		}
+	}
+	if c.File != "" && c.SaveInterval <= 0 {
+		return errors.New("invalid save_interval: must be greater than 0")
+	}
+	return nil
+}
+
+// DefaultConfig is the default configuration.
+var DefaultConfig = Config{
+	SaveInterval: time.Minute,
+}
+
+func (c *Config) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type cfg Config
+	var v = &struct {
+		History cfg `yaml:"history"`
+	}{
+		History: cfg(DefaultConfig),
+	}
+	if err := unmarshal(v); err != nil {
+		return err
+	}
+	*c = Config(v.History)
+	return nil
+}
@@ -0,0 +1,237 @@
+package history
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/DrmagicE/gmqtt"
+	"github.com/DrmagicE/gmqtt/config"
+	"github.com/DrmagicE/gmqtt/pkg/packets"
+	"github.com/DrmagicE/gmqtt/server"
+)
+
+var _ server.Plugin = (*History)(nil)
+
+const Name = "history"
+
+// replayPrefix is the topic prefix of the replay subscriptions and the replayed messages.
+// Subscribing to "$replay/<topic filter>" replays the retained history which matches the topic filter,
+// the replayed messages are delivered on "$replay/<topic name>".
+const replayPrefix = "$replay/"
+
+func init() {
+	server.RegisterPlugin(Name, New)
+	config.RegisterDefaultPluginConfig(Name, &DefaultConfig)
+}
+
+func New(config config.Config) (server.Plugin, error) {
+	cfg := config.Plugins[Name].(*Config)
+	h := &History{
+		config: cfg,
+		done:   make(chan struct{}),
+	}
+	if cfg.File != "" {
+		h.file = cfg.File
+		if !path.IsAbs(h.file) {
+			h.file = path.Join(config.ConfigDir, h.file)
+		}
+	}
+	for _, v := range cfg.Topics {
+		h.buffers = append(h.buffers, newBuffer(v))
+	}
+	return h, nil
+}
+
+var log *zap.Logger
+
+// History retains the recent messages of the configured topic filters,
+// so that the reconnecting clients can backfill the messages they missed by the replay subscriptions.
+type History struct {
+	config    *Config
+	file      string
+	publisher server.Publisher
+
+	mu      sync.Mutex
+	buffers []*buffer
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+func (h *History) Load(service server.Server) error {
+	log = server.LoggerWithField(zap.String("plugin", Name))
+	h.publisher = service.Publisher()
+	if h.file == "" {
+		return nil
+	}
+	if err := h.restore(); err != nil {
+		return err
+	}
+	h.wg.Add(1)
+	go func() {
+		defer h.wg.Done()
+		h.saveLoop()
+	}()
+	return nil
+}
+
+func (h *History) Unload() error {
+	if h.file == "" {
+		return nil
+	}
+	close(h.done)
+	h.wg.Wait()
+	return h.save()
+}
+
+func (h *History) Name() string {
+	return Name
+}
+
+// buffer returns the buffer for the topic, nil if the history of the topic is not retained.
+// This function must be guard by h.mu.
+func (h *History) buffer(topic string) *buffer {
+	for _, v := range h.buffers {
+		if packets.TopicMatch([]byte(topic), []byte(v.topicFilter)) {
+			return v
+		}
+	}
+	return nil
+}
+
+// record adds the message into the history buffer of its topic.
+func (h *History) record(msg *gmqtt.Message) {
+	now := time.Now()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	b := h.buffer(msg.Topic)
+	if b == nil {
+		return
+	}
+	b.expire(now)
+	b.add(entry{At: now, Message: msg.Copy()})
+}
+
+// entries returns the retained messages which match the topic filter, from the oldest to the newest.
+func (h *History) entries(topicFilter string) []entry {
+	now := time.Now()
+	var rs []entry
+	h.mu.Lock()
+	for _, b := range h.buffers {
+		b.expire(now)
+		for _, e := range b.list() {
+			if packets.TopicMatch([]byte(e.Message.Topic), []byte(topicFilter)) {
+				rs = append(rs, e)
+			}
+		}
+	}
+	h.mu.Unlock()
+	sort.SliceStable(rs, func(i, j int) bool {
+		return rs[i].At.Before(rs[j].At)
+	})
+	return rs
+}
+
+// replay publishes the retained messages matching the replay subscription to the client.
+func (h *History) replay(clientID string, sub *gmqtt.Subscription) {
+	now := time.Now()
+	entries := h.entries(strings.TrimPrefix(sub.TopicFilter, replayPrefix))
+	for _, e := range entries {
+		msg := e.Message.ShallowCopy()
+		if msg.MessageExpiry != 0 {
+			elapsed := uint32(now.Sub(e.At).Seconds())
+			if elapsed >= msg.MessageExpiry {
+				continue
+			}
+			msg.MessageExpiry -= elapsed
+		}
+		msg.Topic = replayPrefix + msg.Topic
+		msg.Retained = false
+		msg.Dup = false
+		h.publisher.PublishTo(clientID, msg)
+	}
+	log.Debug("history replayed",
+		zap.String("client_id", clientID),
+		zap.String("topic", sub.TopicFilter),
+		zap.Int("messages", len(entries)))
+}
+
+func (h *History) saveLoop() {
+	t := time.NewTicker(h.config.SaveInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-h.done:
+			return
+		case <-t.C:
+			if err := h.save(); err != nil {
+				log.Error("failed to save history", zap.String("file", h.file), zap.Error(err))
+			}
+		}
+	}
+}
+
+// snapshot is the persisted history.
+type snapshot struct {
+	Entries []entry `json:"entries"`
+}
+
+// save writes the history into the file, it writes a temporary file and renames it to keep the file intact if it fails.
+func (h *History) save() error {
+	var s snapshot
+	h.mu.Lock()
+	for _, v := range h.buffers {
+		s.Entries = append(s.Entries, v.list()...)
+	}
+	h.mu.Unlock()
+	b, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	tmp := h.file + ".tmp"
+	if err = ioutil.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, h.file)
+}
+
+// restore loads the history from the file, the messages on the topics which are no longer retained are discarded.
+func (h *History) restore() error {
+	b, err := ioutil.ReadFile(h.file)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var s snapshot
+	if err = json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	sort.SliceStable(s.Entries, func(i, j int) bool {
+		return s.Entries[i].At.Before(s.Entries[j].At)
+	})
+	now := time.Now()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, e := range s.Entries {
+		if e.Message == nil {
+			continue
+		}
+		if b := h.buffer(e.Message.Topic); b != nil {
+			b.add(e)
+		}
+	}
+	for _, v := range h.buffers {
+		v.expire(now)
+	}
+	return nil
+}
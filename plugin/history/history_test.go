@@ -0,0 +1,225 @@
+package history
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+
+	"github.com/DrmagicE/gmqtt"
+	"github.com/DrmagicE/gmqtt/config"
+	"github.com/DrmagicE/gmqtt/pkg/codes"
+	"github.com/DrmagicE/gmqtt/server"
+)
+
+func init() {
+	log = zap.NewNop()
+}
+
+func newMockClient(ctrl *gomock.Controller, clientID string) *server.MockClient {
+	client := server.NewMockClient(ctrl)
+	client.EXPECT().ClientOptions().Return(&server.ClientOptions{ClientID: clientID}).AnyTimes()
+	return client
+}
+
+func newHistory(a *assert.Assertions, cfg Config) *History {
+	a.NoError(cfg.Validate())
+	c := config.DefaultConfig()
+	c.Plugins[Name] = &cfg
+	h, err := New(c)
+	a.NoError(err)
+	return h.(*History)
+}
+
+func TestConfig_Validate(t *testing.T) {
+	a := assert.New(t)
+	a.NoError(DefaultConfig.Validate())
+
+	cfg := DefaultConfig
+	cfg.File = "history.json"
+	cfg.Topics = []TopicConfig{
+		{TopicFilter: "sensors/#", MaxMessages: 10, MaxAge: time.Minute},
+	}
+	a.NoError(cfg.Validate())
+
+	var tt = []func(c *Config){
+		func(c *Config) { c.Topics[0].TopicFilter = "a/#/b" },
+		func(c *Config) { c.Topics[0].TopicFilter = "$replay/a" },
+		func(c *Config) { c.Topics[0].MaxMessages = 0 },
+		func(c *Config) { c.Topics[0].MaxAge = -1 },
+		func(c *Config) { c.SaveInterval = 0 },
+	}
+	for k, v := range tt {
+		c := cfg
+		c.Topics = append([]TopicConfig{}, cfg.Topics...)
+		v(&c)
+		a.Error(c.Validate(), k)
+	}
+}
+
+func TestBuffer(t *testing.T) {
+	a := assert.New(t)
+	b := newBuffer(TopicConfig{TopicFilter: "#", MaxMessages: 3, MaxAge: time.Minute})
+	now := time.Now()
+	for i := 0; i < 5; i++ {
+		b.add(entry{At: now.Add(time.Duration(i) * time.Minute), Message: &gmqtt.Message{Payload: []byte{byte(i)}}})
+	}
+	var payloads []byte
+	for _, v := range b.list() {
+		payloads = append(payloads, v.Message.Payload[0])
+	}
+	a.Equal([]byte{2, 3, 4}, payloads)
+
+	b.expire(now.Add(4 * time.Minute))
+	a.Len(b.list(), 2)
+	b.expire(now.Add(10 * time.Minute))
+	a.Len(b.list(), 0)
+	b.add(entry{At: now, Message: &gmqtt.Message{}})
+	a.Len(b.list(), 1)
+}
+
+func TestHistory_replay(t *testing.T) {
+	a := assert.New(t)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	h := newHistory(a, Config{
+		Topics: []TopicConfig{
+			{TopicFilter: "sensors/+/temperature", MaxMessages: 2},
+			{TopicFilter: "sensors/#", MaxMessages: 10},
+		},
+	})
+	pub := server.NewMockPublisher(ctrl)
+	srv := server.NewMockServer(ctrl)
+	srv.EXPECT().Publisher().Return(pub)
+	a.NoError(h.Load(srv))
+
+	client := newMockClient(ctrl, "cid")
+	onMsgArrived := h.OnMsgArrivedWrapper(func(ctx context.Context, client server.Client, req *server.MsgArrivedRequest) error {
+		return nil
+	})
+	for _, v := range []*gmqtt.Message{
+		{Topic: "sensors/1/temperature", Payload: []byte("1")},
+		{Topic: "sensors/1/humidity", Payload: []byte("2"), QoS: 1, Retained: true},
+		{Topic: "sensors/2/temperature", Payload: []byte("3")},
+		{Topic: "sensors/3/temperature", Payload: []byte("4")},
+		{Topic: "other", Payload: []byte("5")},
+	} {
+		a.NoError(onMsgArrived(context.Background(), client, &server.MsgArrivedRequest{Message: v}))
+	}
+	onWillPublished := h.OnWillPublishedWrapper(func(ctx context.Context, clientID string, msg *gmqtt.Message) {})
+	onWillPublished(context.Background(), "cid", &gmqtt.Message{Topic: "sensors/1/status", Payload: []byte("6")})
+
+	// publishing to the replay topics is not allowed
+	err := onMsgArrived(context.Background(), client, &server.MsgArrivedRequest{Message: &gmqtt.Message{Topic: "$replay/sensors/1/temperature"}})
+	a.Error(err)
+	a.Equal(codes.TopicNameInvalid, err.(*codes.Error).Code)
+	onWillPublish := h.OnWillPublishWrapper(func(ctx context.Context, clientID string, req *server.WillMsgRequest) {})
+	req := &server.WillMsgRequest{Message: &gmqtt.Message{Topic: "$replay/a"}}
+	onWillPublish(context.Background(), "cid", req)
+	a.Nil(req.Message)
+
+	var replayed []*gmqtt.Message
+	pub.EXPECT().PublishTo("cid", gomock.Any()).Do(func(clientID string, msg *gmqtt.Message) {
+		replayed = append(replayed, msg)
+	}).AnyTimes()
+	onSubscribed := h.OnSubscribedWrapper(func(ctx context.Context, client server.Client, subscription *gmqtt.Subscription) {})
+	onSubscribed(context.Background(), client, &gmqtt.Subscription{TopicFilter: "$replay/sensors/#"})
+	var payloads []string
+	for _, v := range replayed {
+		payloads = append(payloads, string(v.Payload))
+	}
+	// the oldest temperature is evicted
+	a.Equal([]string{"2", "3", "4", "6"}, payloads)
+	a.Equal("$replay/sensors/1/humidity", replayed[0].Topic)
+	a.EqualValues(1, replayed[0].QoS)
+	a.False(replayed[0].Retained)
+
+	replayed = nil
+	onSubscribed(context.Background(), client, &gmqtt.Subscription{TopicFilter: "$replay/sensors/+/temperature"})
+	a.Len(replayed, 2)
+
+	// not replay subscriptions
+	replayed = nil
+	onSubscribed(context.Background(), client, &gmqtt.Subscription{TopicFilter: "sensors/#"})
+	onSubscribed(context.Background(), client, &gmqtt.Subscription{ShareName: "g", TopicFilter: "$replay/sensors/#"})
+	a.Len(replayed, 0)
+	a.NoError(h.Unload())
+}
+
+func TestHistory_maxAge(t *testing.T) {
+	a := assert.New(t)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	h := newHistory(a, Config{
+		Topics: []TopicConfig{
+			{TopicFilter: "#", MaxMessages: 10, MaxAge: time.Minute},
+		},
+	})
+	pub := server.NewMockPublisher(ctrl)
+	h.publisher = pub
+	now := time.Now()
+	h.buffers[0].add(entry{At: now.Add(-2 * time.Minute), Message: &gmqtt.Message{Topic: "a", Payload: []byte("old")}})
+	h.buffers[0].add(entry{At: now.Add(-30 * time.Second), Message: &gmqtt.Message{Topic: "a", Payload: []byte("expired"), MessageExpiry: 10}})
+	h.buffers[0].add(entry{At: now.Add(-30 * time.Second), Message: &gmqtt.Message{Topic: "a", Payload: []byte("new"), MessageExpiry: 60}})
+	pub.EXPECT().PublishTo("cid", gomock.Any()).Do(func(clientID string, msg *gmqtt.Message) {
+		a.Equal("new", string(msg.Payload))
+		a.True(msg.MessageExpiry <= 30)
+	})
+	h.replay("cid", &gmqtt.Subscription{TopicFilter: "$replay/#"})
+}
+
+func TestHistory_persistence(t *testing.T) {
+	a := assert.New(t)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	dir, err := ioutil.TempDir("", "gmqtt_history")
+	a.NoError(err)
+	defer os.RemoveAll(dir)
+
+	cfg := Config{
+		Topics: []TopicConfig{
+			{TopicFilter: "a/#", MaxMessages: 10},
+			{TopicFilter: "b/#", MaxMessages: 10},
+		},
+		File:         "history.json",
+		SaveInterval: 10 * time.Millisecond,
+	}
+	c := config.DefaultConfig()
+	c.ConfigDir = dir
+	c.Plugins[Name] = &cfg
+	p, err := New(c)
+	a.NoError(err)
+	h := p.(*History)
+	srv := server.NewMockServer(ctrl)
+	srv.EXPECT().Publisher().Return(server.NewMockPublisher(ctrl)).AnyTimes()
+	a.NoError(h.Load(srv))
+	h.record(&gmqtt.Message{Topic: "a/1", Payload: []byte("1"), QoS: 1})
+	h.record(&gmqtt.Message{Topic: "b/1", Payload: []byte("2")})
+	a.Eventually(func() bool {
+		_, err := os.Stat(path.Join(dir, "history.json"))
+		return err == nil
+	}, time.Second, 10*time.Millisecond)
+	a.NoError(h.Unload())
+
+	// the history of b/# is no longer retained
+	cfg.Topics = cfg.Topics[:1]
+	p, err = New(c)
+	a.NoError(err)
+	h = p.(*History)
+	a.NoError(h.Load(srv))
+	entries := h.entries("#")
+	a.Len(entries, 1)
+	a.Equal(&gmqtt.Message{Topic: "a/1", Payload: []byte("1"), QoS: 1}, entries[0].Message)
+	a.NoError(h.Unload())
+
+	a.NoError(ioutil.WriteFile(path.Join(dir, "history.json"), []byte("{"), 0600))
+	p, err = New(c)
+	a.NoError(err)
+	a.Error(p.(*History).Load(srv))
+}
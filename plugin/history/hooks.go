@@ -0,0 +1,64 @@
+package history
+
+import (
+	"context"
+	"strings"
+
+	"github.com/DrmagicE/gmqtt"
+	"github.com/DrmagicE/gmqtt/pkg/codes"
+	"github.com/DrmagicE/gmqtt/server"
+)
+
+func (h *History) HookWrapper() server.HookWrapper {
+	return server.HookWrapper{
+		OnMsgArrivedWrapper:    h.OnMsgArrivedWrapper,
+		OnWillPublishWrapper:   h.OnWillPublishWrapper,
+		OnWillPublishedWrapper: h.OnWillPublishedWrapper,
+		OnSubscribedWrapper:    h.OnSubscribedWrapper,
+	}
+}
+
+func (h *History) OnMsgArrivedWrapper(pre server.OnMsgArrived) server.OnMsgArrived {
+	return func(ctx context.Context, client server.Client, req *server.MsgArrivedRequest) error {
+		err := pre(ctx, client, req)
+		if err != nil || req.Message == nil {
+			return err
+		}
+		// the replay topics are reserved for the replayed messages.
+		if strings.HasPrefix(req.Message.Topic, replayPrefix) {
+			return &codes.Error{
+				Code: codes.TopicNameInvalid,
+				ErrorDetails: codes.ErrorDetails{
+					ReasonString: []byte(replayPrefix + " topics are reserved"),
+				},
+			}
+		}
+		h.record(req.Message)
+		return nil
+	}
+}
+
+func (h *History) OnWillPublishWrapper(pre server.OnWillPublish) server.OnWillPublish {
+	return func(ctx context.Context, clientID string, req *server.WillMsgRequest) {
+		pre(ctx, clientID, req)
+		if req.Message != nil && strings.HasPrefix(req.Message.Topic, replayPrefix) {
+			req.Drop()
+		}
+	}
+}
+
+func (h *History) OnWillPublishedWrapper(pre server.OnWillPublished) server.OnWillPublished {
+	return func(ctx context.Context, clientID string, msg *gmqtt.Message) {
+		pre(ctx, clientID, msg)
+		h.record(msg)
+	}
+}
+
+func (h *History) OnSubscribedWrapper(pre server.OnSubscribed) server.OnSubscribed {
+	return func(ctx context.Context, client server.Client, subscription *gmqtt.Subscription) {
+		pre(ctx, client, subscription)
+		if subscription.ShareName == "" && strings.HasPrefix(subscription.TopicFilter, replayPrefix) {
+			h.replay(client.ClientOptions().ClientID, subscription)
+		}
+	}
+}
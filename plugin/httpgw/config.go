@@ -0,0 +1,77 @@
+package httpgw
+
+import (
+	"errors"
+	"net"
+	"time"
+)
+
+// Config is the configuration for the httpgw plugin.
+type Config struct {
+	// ListenAddress is the address that the gateway will listen on.
+	ListenAddress string `yaml:"listen_address"`
+	// Tokens is the list of the static bearer tokens, empty means no authentication.
+	// The token is carried in the "Authorization: Bearer <token>" header or the "access_token" query parameter,
+	// the latter is for the EventSource API which can not set headers.
+	Tokens []string `yaml:"tokens"`
+	// AllowOrigins is the list of the origins allowed by CORS, "*" allows any origin, empty disables CORS.
+	AllowOrigins []string `yaml:"allow_origins"`
+	// BufferSize is the maximum number of the messages buffered for each SSE stream or long-poll session.
+	// The oldest message is dropped if the buffer is full.
+	BufferSize int `yaml:"buffer_size"`
+	// SessionTimeout is the time after which an idle long-poll session is removed.
+	SessionTimeout time.Duration `yaml:"session_timeout"`
+	// MaxPollTimeout is the maximum time that a long-poll request waits for messages.
+	MaxPollTimeout time.Duration `yaml:"max_poll_timeout"`
+	// KeepaliveInterval is the interval to send the SSE comment line to keep the connection alive through proxies.
+	KeepaliveInterval time.Duration `yaml:"keepalive_interval"`
+}
+
+// Validate validates the configuration, and return an error if it is invalid.
+func (c *Config) Validate() error {
+	_, _, err := net.SplitHostPort(c.ListenAddress)
+	if err != nil {
+		return errors.New("invalid listen_address")
+	}
+	for _, v := range c.Tokens {
+		if v == "" {
+			return errors.New("invalid tokens: token cannot be empty")
+		}
+	}
+	if c.BufferSize <= 0 {
+		return errors.New("invalid buffer_size: must be greater than 0")
+	}
+	if c.SessionTimeout <= 0 {
+		return errors.New("invalid session_timeout: must be greater than 0")
+	}
+	if c.MaxPollTimeout <= 0 {
+		return errors.New("invalid max_poll_timeout: must be greater than 0")
+	}
+	if c.KeepaliveInterval <= 0 {
+		return errors.New("invalid keepalive_interval: must be greater than 0")
+	}
+	return nil
+}
+
+// DefaultConfig is the default configuration.
+var DefaultConfig = Config{
+	ListenAddress:     ":8084",
+	BufferSize:        1000,
+	SessionTimeout:    2 * time.Minute,
+	MaxPollTimeout:    time.Minute,
+	KeepaliveInterval: 15 * time.Second,
+}
+
+func (c *Config) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type cfg Config
+	var v = &struct {
+		HTTPGW cfg `yaml:"httpgw"`
+	}{
+		HTTPGW: cfg(DefaultConfig),
+	}
+	if err := unmarshal(v); err != nil {
+		return err
+	}
+	*c = Config(v.HTTPGW)
+	return nil
+}
@@ -0,0 +1,9 @@
+package httpgw
+
+import (
+	"github.com/DrmagicE/gmqtt/server"
+)
+
+func (h *HTTPGW) HookWrapper() server.HookWrapper {
+	return server.HookWrapper{}
+}
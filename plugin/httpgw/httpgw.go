@@ -0,0 +1,296 @@
+package httpgw
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"go.uber.org/zap"
+
+	"github.com/DrmagicE/gmqtt"
+	"github.com/DrmagicE/gmqtt/config"
+	"github.com/DrmagicE/gmqtt/pkg/packets"
+	"github.com/DrmagicE/gmqtt/server"
+)
+
+var _ server.Plugin = (*HTTPGW)(nil)
+
+const Name = "httpgw"
+
+const (
+	// publisherClientID is the client id of the local client which publishes the messages received by POST.
+	publisherClientID = "$httpgw"
+	// maxRequestSize is the maximum size of the request body.
+	maxRequestSize = 1 << 20
+	// encodingBase64 indicates the payload is base64 encoded.
+	encodingBase64 = "base64"
+)
+
+func init() {
+	server.RegisterPlugin(Name, New)
+	config.RegisterDefaultPluginConfig(Name, &DefaultConfig)
+}
+
+func New(config config.Config) (server.Plugin, error) {
+	cfg := config.Plugins[Name].(*Config)
+	return newHTTPGW(cfg), nil
+}
+
+func newHTTPGW(cfg *Config) *HTTPGW {
+	return &HTTPGW{
+		config: cfg,
+		httpServer: &http.Server{
+			Addr: cfg.ListenAddress,
+		},
+		sessions: make(map[string]*stream),
+		done:     make(chan struct{}),
+	}
+}
+
+var log *zap.Logger
+
+// HTTPGW exposes the subscriptions as Server-Sent Events or long-poll HTTP endpoints and accepts publishes via POST,
+// for the web clients behind the proxies which block WebSockets.
+type HTTPGW struct {
+	config     *Config
+	httpServer *http.Server
+	newClient  func(clientID string) (server.LocalClient, error)
+	publisher  server.LocalClient
+
+	mu       sync.Mutex
+	sessions map[string]*stream
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+func (h *HTTPGW) Load(service server.Server) (err error) {
+	log = server.LoggerWithField(zap.String("plugin", Name))
+	h.newClient = service.NewLocalClient
+	h.publisher, err = h.newClient(publisherClientID)
+	if err != nil {
+		return err
+	}
+	h.httpServer.Handler = h.handler()
+	h.wg.Add(1)
+	go func() {
+		defer h.wg.Done()
+		h.expireSessions()
+	}()
+	go func() {
+		err := h.httpServer.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
+			panic(err.Error())
+		}
+	}()
+	return nil
+}
+
+func (h *HTTPGW) Unload() error {
+	// close done first to terminate the SSE streams, otherwise Shutdown waits for them forever.
+	close(h.done)
+	h.wg.Wait()
+	err := h.httpServer.Shutdown(context.Background())
+	h.mu.Lock()
+	for k, v := range h.sessions {
+		v.close()
+		delete(h.sessions, k)
+	}
+	h.mu.Unlock()
+	if h.publisher != nil {
+		h.publisher.Close()
+	}
+	return err
+}
+
+func (h *HTTPGW) Name() string {
+	return Name
+}
+
+func (h *HTTPGW) handler() http.Handler {
+	mu := http.NewServeMux()
+	mu.HandleFunc("/v1/publish", h.handlePublish)
+	mu.HandleFunc("/v1/sse", h.handleSSE)
+	mu.HandleFunc("/v1/poll", h.handleCreateSession)
+	mu.HandleFunc("/v1/poll/", h.handleSession)
+	return h.cors(h.auth(mu))
+}
+
+// auth checks the bearer token if the tokens are configured.
+func (h *HTTPGW) auth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(h.config.Tokens) == 0 || r.Method == http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+		token := r.URL.Query().Get("access_token")
+		if v := r.Header.Get("Authorization"); strings.HasPrefix(v, "Bearer ") {
+			token = strings.TrimPrefix(v, "Bearer ")
+		}
+		for _, v := range h.config.Tokens {
+			if subtle.ConstantTimeCompare([]byte(token), []byte(v)) == 1 {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+		writeError(w, http.StatusUnauthorized, "invalid token")
+	})
+}
+
+// cors sets the CORS headers if the origin is allowed and responds to the preflight requests.
+func (h *HTTPGW) cors(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin != "" && h.allowOrigin(origin) {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Add("Vary", "Origin")
+			if r.Method == http.MethodOptions {
+				w.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE")
+				w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (h *HTTPGW) allowOrigin(origin string) bool {
+	for _, v := range h.config.AllowOrigins {
+		if v == "*" || v == origin {
+			return true
+		}
+	}
+	return false
+}
+
+// Message is the JSON representation of the MQTT message.
+type Message struct {
+	Topic string `json:"topic"`
+	// Payload is the UTF-8 payload, or the base64 encoded payload if PayloadEncoding is "base64".
+	Payload         string `json:"payload"`
+	PayloadEncoding string `json:"payload_encoding,omitempty"`
+	QoS             uint8  `json:"qos"`
+	Retained        bool   `json:"retained"`
+}
+
+func messageFromGmqtt(msg *gmqtt.Message) *Message {
+	m := &Message{
+		Topic:    msg.Topic,
+		QoS:      msg.QoS,
+		Retained: msg.Retained,
+	}
+	if utf8.Valid(msg.Payload) {
+		m.Payload = string(msg.Payload)
+	} else {
+		m.Payload = base64.StdEncoding.EncodeToString(msg.Payload)
+		m.PayloadEncoding = encodingBase64
+	}
+	return m
+}
+
+// PublishRequest is the request body of POST /v1/publish.
+type PublishRequest struct {
+	Topic string `json:"topic"`
+	// Payload is the UTF-8 payload, or the base64 encoded payload if PayloadEncoding is "base64".
+	Payload         string `json:"payload"`
+	PayloadEncoding string `json:"payload_encoding"`
+	QoS             uint8  `json:"qos"`
+	Retain          bool   `json:"retain"`
+}
+
+func (h *HTTPGW) handlePublish(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	req := &PublishRequest{}
+	if err := readJSON(w, r, req); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	payload := []byte(req.Payload)
+	switch req.PayloadEncoding {
+	case "":
+	case encodingBase64:
+		b, err := base64.StdEncoding.DecodeString(req.Payload)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid payload: "+err.Error())
+			return
+		}
+		payload = b
+	default:
+		writeError(w, http.StatusBadRequest, "invalid payload_encoding: "+req.PayloadEncoding)
+		return
+	}
+	if req.QoS > packets.Qos2 {
+		writeError(w, http.StatusBadRequest, "invalid qos")
+		return
+	}
+	err := h.publisher.Publish(&gmqtt.Message{
+		Topic:    req.Topic,
+		Payload:  payload,
+		QoS:      req.QoS,
+		Retained: req.Retain,
+	})
+	if err == server.ErrInvalidTopic {
+		writeError(w, http.StatusBadRequest, "invalid topic")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func readJSON(w http.ResponseWriter, r *http.Request, v interface{}) error {
+	b, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxRequestSize))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, msg string) {
+	writeJSON(w, code, map[string]string{"error": msg})
+}
+
+// expireSessions removes the idle long-poll sessions periodically.
+func (h *HTTPGW) expireSessions() {
+	interval := h.config.SessionTimeout / 2
+	if interval <= 0 {
+		interval = h.config.SessionTimeout
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-h.done:
+			return
+		case now := <-ticker.C:
+			h.mu.Lock()
+			for k, v := range h.sessions {
+				if v.idle(now, h.config.SessionTimeout) {
+					v.close()
+					delete(h.sessions, k)
+					log.Debug("long-poll session expired", zap.String("session_id", k))
+				}
+			}
+			h.mu.Unlock()
+		}
+	}
+}
@@ -0,0 +1,275 @@
+package httpgw
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+
+	"github.com/DrmagicE/gmqtt"
+	"github.com/DrmagicE/gmqtt/pkg/packets"
+	"github.com/DrmagicE/gmqtt/server"
+)
+
+// fakeBroker delivers the messages to the local clients which subscribe the exact topic.
+type fakeBroker struct {
+	mu      sync.Mutex
+	clients map[string]*fakeClient
+}
+
+type fakeClient struct {
+	broker   *fakeBroker
+	clientID string
+	handlers map[string]server.MessageHandler
+}
+
+func (b *fakeBroker) newClient(clientID string) (server.LocalClient, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	c := &fakeClient{broker: b, clientID: clientID, handlers: make(map[string]server.MessageHandler)}
+	b.clients[clientID] = c
+	return c, nil
+}
+
+func (b *fakeBroker) len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.clients)
+}
+
+func (c *fakeClient) ClientID() string {
+	return c.clientID
+}
+
+func (c *fakeClient) Publish(msg *gmqtt.Message) error {
+	if !packets.ValidTopicName(true, []byte(msg.Topic)) {
+		return server.ErrInvalidTopic
+	}
+	var handlers []server.MessageHandler
+	c.broker.mu.Lock()
+	for _, v := range c.broker.clients {
+		if h := v.handlers[msg.Topic]; h != nil {
+			handlers = append(handlers, h)
+		}
+	}
+	c.broker.mu.Unlock()
+	for _, h := range handlers {
+		h(msg.Copy())
+	}
+	return nil
+}
+
+func (c *fakeClient) Subscribe(topicFilter string, qos packets.QoS, handler server.MessageHandler) error {
+	if !packets.ValidTopicFilter(true, []byte(topicFilter)) {
+		return server.ErrInvalidTopic
+	}
+	c.broker.mu.Lock()
+	defer c.broker.mu.Unlock()
+	c.handlers[topicFilter] = handler
+	return nil
+}
+
+func (c *fakeClient) SubscribeNoLocal(topicFilter string, qos packets.QoS, handler server.MessageHandler) error {
+	return c.Subscribe(topicFilter, qos, handler)
+}
+
+func (c *fakeClient) Unsubscribe(topicFilter string) error {
+	c.broker.mu.Lock()
+	defer c.broker.mu.Unlock()
+	delete(c.handlers, topicFilter)
+	return nil
+}
+
+func (c *fakeClient) Close() {
+	c.broker.mu.Lock()
+	defer c.broker.mu.Unlock()
+	delete(c.broker.clients, c.clientID)
+}
+
+func newTestGW(t *testing.T, cfg Config) (*HTTPGW, *fakeBroker, *httptest.Server) {
+	log = zap.NewNop()
+	b := &fakeBroker{clients: make(map[string]*fakeClient)}
+	h := newHTTPGW(&cfg)
+	h.newClient = b.newClient
+	h.publisher, _ = b.newClient(publisherClientID)
+	srv := httptest.NewServer(h.handler())
+	t.Cleanup(func() {
+		close(h.done)
+		srv.Close()
+	})
+	return h, b, srv
+}
+
+func publish(t *testing.T, url string, req *PublishRequest) int {
+	b, _ := json.Marshal(req)
+	resp, err := http.Post(url+"/v1/publish", "application/json", bytes.NewReader(b))
+	assert.NoError(t, err)
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestHTTPGW_Poll(t *testing.T) {
+	a := assert.New(t)
+	cfg := DefaultConfig
+	cfg.BufferSize = 2
+	h, b, srv := newTestGW(t, cfg)
+
+	resp, err := http.Post(srv.URL+"/v1/poll", "application/json", strings.NewReader(`{"topics":["a/b"]}`))
+	a.NoError(err)
+	a.Equal(http.StatusCreated, resp.StatusCode)
+	session := &CreateSessionResponse{}
+	a.NoError(json.NewDecoder(resp.Body).Decode(session))
+	resp.Body.Close()
+	a.NotEmpty(session.SessionID)
+
+	a.Equal(http.StatusNoContent, publish(t, srv.URL, &PublishRequest{Topic: "a/b", Payload: "1", QoS: 1}))
+	a.Equal(http.StatusNoContent, publish(t, srv.URL, &PublishRequest{Topic: "a/b", Payload: "/w==", PayloadEncoding: "base64"}))
+	a.Equal(http.StatusNoContent, publish(t, srv.URL, &PublishRequest{Topic: "a/b", Payload: "3", Retain: true}))
+	a.Equal(http.StatusBadRequest, publish(t, srv.URL, &PublishRequest{Topic: "a/+"}))
+
+	resp, err = http.Get(srv.URL + "/v1/poll/" + session.SessionID)
+	a.NoError(err)
+	poll := &PollResponse{}
+	a.NoError(json.NewDecoder(resp.Body).Decode(poll))
+	resp.Body.Close()
+	a.EqualValues(1, poll.Dropped)
+	a.Equal([]*Message{
+		{Topic: "a/b", Payload: "/w==", PayloadEncoding: "base64"},
+		{Topic: "a/b", Payload: "3", Retained: true},
+	}, poll.Messages)
+
+	// the poll returns empty messages after timeout.
+	start := time.Now()
+	resp, err = http.Get(srv.URL + "/v1/poll/" + session.SessionID + "?timeout=50ms")
+	a.NoError(err)
+	poll = &PollResponse{}
+	a.NoError(json.NewDecoder(resp.Body).Decode(poll))
+	resp.Body.Close()
+	a.Len(poll.Messages, 0)
+	a.True(time.Since(start) >= 50*time.Millisecond)
+
+	// the poll is woken up by the new message.
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		publish(t, srv.URL, &PublishRequest{Topic: "a/b", Payload: "4"})
+	}()
+	resp, err = http.Get(srv.URL + "/v1/poll/" + session.SessionID)
+	a.NoError(err)
+	poll = &PollResponse{}
+	a.NoError(json.NewDecoder(resp.Body).Decode(poll))
+	resp.Body.Close()
+	a.Equal([]*Message{{Topic: "a/b", Payload: "4"}}, poll.Messages)
+
+	req, _ := http.NewRequest(http.MethodDelete, srv.URL+"/v1/poll/"+session.SessionID, nil)
+	resp, err = http.DefaultClient.Do(req)
+	a.NoError(err)
+	resp.Body.Close()
+	a.Equal(http.StatusNoContent, resp.StatusCode)
+	a.Equal(1, b.len())
+	h.mu.Lock()
+	a.Len(h.sessions, 0)
+	h.mu.Unlock()
+
+	resp, err = http.Get(srv.URL + "/v1/poll/" + session.SessionID)
+	a.NoError(err)
+	resp.Body.Close()
+	a.Equal(http.StatusNotFound, resp.StatusCode)
+}
+
+func TestHTTPGW_SessionExpiry(t *testing.T) {
+	a := assert.New(t)
+	cfg := DefaultConfig
+	cfg.SessionTimeout = 20 * time.Millisecond
+	h, b, srv := newTestGW(t, cfg)
+	go h.expireSessions()
+
+	resp, err := http.Post(srv.URL+"/v1/poll", "application/json", strings.NewReader(`{"topics":["a/b"]}`))
+	a.NoError(err)
+	resp.Body.Close()
+	a.Equal(2, b.len())
+	time.Sleep(100 * time.Millisecond)
+	a.Equal(1, b.len())
+	h.mu.Lock()
+	a.Len(h.sessions, 0)
+	h.mu.Unlock()
+}
+
+func TestHTTPGW_SSE(t *testing.T) {
+	a := assert.New(t)
+	_, b, srv := newTestGW(t, DefaultConfig)
+
+	resp, err := http.Get(srv.URL + "/v1/sse?topic=a/b&topic=c")
+	a.NoError(err)
+	a.Equal("text/event-stream", resp.Header.Get("Content-Type"))
+	a.Equal(2, b.len())
+
+	a.Equal(http.StatusNoContent, publish(t, srv.URL, &PublishRequest{Topic: "c", Payload: "hello", QoS: 2}))
+	r := bufio.NewReader(resp.Body)
+	var lines []string
+	for i := 0; i < 3; i++ {
+		line, err := r.ReadString('\n')
+		a.NoError(err)
+		lines = append(lines, line)
+	}
+	a.Equal([]string{
+		"event: message\n",
+		`data: {"topic":"c","payload":"hello","qos":2,"retained":false}` + "\n",
+		"\n",
+	}, lines)
+	resp.Body.Close()
+
+	// the local client is closed after the connection is closed.
+	a.Eventually(func() bool {
+		return b.len() == 1
+	}, time.Second, 10*time.Millisecond)
+
+	resp, err = http.Get(srv.URL + "/v1/sse")
+	a.NoError(err)
+	resp.Body.Close()
+	a.Equal(http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestHTTPGW_Auth(t *testing.T) {
+	a := assert.New(t)
+	cfg := DefaultConfig
+	cfg.Tokens = []string{"secret"}
+	cfg.AllowOrigins = []string{"http://example.com"}
+	_, _, srv := newTestGW(t, cfg)
+
+	a.Equal(http.StatusUnauthorized, publish(t, srv.URL, &PublishRequest{Topic: "a"}))
+
+	req, _ := http.NewRequest(http.MethodPost, srv.URL+"/v1/publish", strings.NewReader(`{"topic":"a"}`))
+	req.Header.Set("Authorization", "Bearer secret")
+	resp, err := http.DefaultClient.Do(req)
+	a.NoError(err)
+	resp.Body.Close()
+	a.Equal(http.StatusNoContent, resp.StatusCode)
+
+	resp, err = http.Post(srv.URL+"/v1/publish?access_token=secret", "application/json", strings.NewReader(`{"topic":"a"}`))
+	a.NoError(err)
+	resp.Body.Close()
+	a.Equal(http.StatusNoContent, resp.StatusCode)
+
+	// the preflight request is not authenticated.
+	req, _ = http.NewRequest(http.MethodOptions, srv.URL+"/v1/publish", nil)
+	req.Header.Set("Origin", "http://example.com")
+	resp, err = http.DefaultClient.Do(req)
+	a.NoError(err)
+	resp.Body.Close()
+	a.Equal(http.StatusNoContent, resp.StatusCode)
+	a.Equal("http://example.com", resp.Header.Get("Access-Control-Allow-Origin"))
+
+	req.Header.Set("Origin", "http://evil.com")
+	resp, err = http.DefaultClient.Do(req)
+	a.NoError(err)
+	resp.Body.Close()
+	a.Empty(resp.Header.Get("Access-Control-Allow-Origin"))
+}
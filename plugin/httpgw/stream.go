@@ -0,0 +1,266 @@
+package httpgw
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+
+	"github.com/DrmagicE/gmqtt"
+	"github.com/DrmagicE/gmqtt/pkg/packets"
+	"github.com/DrmagicE/gmqtt/server"
+)
+
+// stream buffers the messages of the subscriptions for an SSE connection or a long-poll session.
+type stream struct {
+	client server.LocalClient
+	size   int
+	// notify is signaled when new messages arrive.
+	notify chan struct{}
+
+	mu         sync.Mutex
+	msgs       []*Message
+	dropped    uint64
+	polling    int
+	lastActive time.Time
+}
+
+// newStream creates a local client and subscribes the topic filters.
+// The subscriptions use QoS 2 so that the original QoS of the messages is reported.
+func (h *HTTPGW) newStream(topicFilters []string) (*stream, error) {
+	if len(topicFilters) == 0 {
+		return nil, server.ErrInvalidTopic
+	}
+	c, err := h.newClient("httpgw-" + uuid.New().String())
+	if err != nil {
+		return nil, err
+	}
+	s := &stream{
+		client:     c,
+		size:       h.config.BufferSize,
+		notify:     make(chan struct{}, 1),
+		lastActive: time.Now(),
+	}
+	for _, v := range topicFilters {
+		if err := c.Subscribe(v, packets.Qos2, s.push); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// push buffers the message, the oldest message is dropped if the buffer is full.
+func (s *stream) push(msg *gmqtt.Message) {
+	m := messageFromGmqtt(msg)
+	s.mu.Lock()
+	if len(s.msgs) >= s.size {
+		s.msgs = s.msgs[1:]
+		s.dropped++
+	}
+	s.msgs = append(s.msgs, m)
+	s.mu.Unlock()
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+}
+
+// pop returns and removes all buffered messages, and the number of dropped messages since the last pop.
+func (s *stream) pop() (msgs []*Message, dropped uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	msgs, dropped = s.msgs, s.dropped
+	s.msgs, s.dropped = nil, 0
+	s.lastActive = time.Now()
+	return msgs, dropped
+}
+
+// idle returns whether the stream has not been polled for the given timeout.
+func (s *stream) idle(now time.Time, timeout time.Duration) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.polling == 0 && now.Sub(s.lastActive) > timeout
+}
+
+func (s *stream) setPolling(delta int) {
+	s.mu.Lock()
+	s.polling += delta
+	s.lastActive = time.Now()
+	s.mu.Unlock()
+}
+
+func (s *stream) close() {
+	s.client.Close()
+}
+
+func writeStreamError(w http.ResponseWriter, err error) {
+	if err == server.ErrInvalidTopic {
+		writeError(w, http.StatusBadRequest, "invalid topic filter")
+		return
+	}
+	writeError(w, http.StatusServiceUnavailable, err.Error())
+}
+
+// handleSSE subscribes the topic filters given by the "topic" query parameters
+// and streams the messages as Server-Sent Events until the connection is closed.
+func (h *HTTPGW) handleSSE(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "streaming not supported")
+		return
+	}
+	s, err := h.newStream(r.URL.Query()["topic"])
+	if err != nil {
+		writeStreamError(w, err)
+		return
+	}
+	defer s.close()
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	// disable the response buffering of nginx.
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepalive := time.NewTicker(h.config.KeepaliveInterval)
+	defer keepalive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-h.done:
+			return
+		case <-keepalive.C:
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+		case <-s.notify:
+			msgs, dropped := s.pop()
+			if dropped != 0 {
+				fmt.Fprintf(w, "event: dropped\ndata: %d\n\n", dropped)
+			}
+			for _, v := range msgs {
+				b, _ := json.Marshal(v)
+				if _, err := fmt.Fprintf(w, "event: message\ndata: %s\n\n", b); err != nil {
+					return
+				}
+			}
+		}
+		flusher.Flush()
+	}
+}
+
+// CreateSessionRequest is the request body of POST /v1/poll.
+type CreateSessionRequest struct {
+	Topics []string `json:"topics"`
+}
+
+// CreateSessionResponse is the response body of POST /v1/poll.
+type CreateSessionResponse struct {
+	SessionID string `json:"session_id"`
+}
+
+// PollResponse is the response body of GET /v1/poll/{session_id}.
+type PollResponse struct {
+	Messages []*Message `json:"messages"`
+	// Dropped is the number of messages dropped due to the full buffer since the last poll.
+	Dropped uint64 `json:"dropped"`
+}
+
+// handleCreateSession creates a long-poll session which buffers the messages of the topic filters.
+func (h *HTTPGW) handleCreateSession(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	req := &CreateSessionRequest{}
+	if err := readJSON(w, r, req); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	s, err := h.newStream(req.Topics)
+	if err != nil {
+		writeStreamError(w, err)
+		return
+	}
+	id := s.client.ClientID()
+	h.mu.Lock()
+	h.sessions[id] = s
+	h.mu.Unlock()
+	log.Debug("long-poll session created", zap.String("session_id", id), zap.Strings("topics", req.Topics))
+	writeJSON(w, http.StatusCreated, &CreateSessionResponse{SessionID: id})
+}
+
+// handleSession polls (GET) or deletes (DELETE) the long-poll session.
+func (h *HTTPGW) handleSession(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/v1/poll/")
+	h.mu.Lock()
+	s := h.sessions[id]
+	if s != nil && r.Method == http.MethodDelete {
+		delete(h.sessions, id)
+	}
+	h.mu.Unlock()
+	if s == nil {
+		writeError(w, http.StatusNotFound, "session not found")
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		h.poll(w, r, s)
+	case http.MethodDelete:
+		s.close()
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// poll waits until there are messages in the session or the timeout given by the "timeout" query parameter expires.
+func (h *HTTPGW) poll(w http.ResponseWriter, r *http.Request, s *stream) {
+	timeout := h.config.MaxPollTimeout
+	if v := r.URL.Query().Get("timeout"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			writeError(w, http.StatusBadRequest, "invalid timeout")
+			return
+		}
+		if d < timeout {
+			timeout = d
+		}
+	}
+	s.setPolling(1)
+	defer s.setPolling(-1)
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	msgs, dropped := s.pop()
+	// the notification may be stale if the messages have been popped, so keep waiting until there are messages.
+	for len(msgs) == 0 && dropped == 0 {
+		select {
+		case <-s.notify:
+			msgs, dropped = s.pop()
+			continue
+		case <-timer.C:
+		case <-r.Context().Done():
+			return
+		case <-h.done:
+		}
+		break
+	}
+	if msgs == nil {
+		msgs = []*Message{}
+	}
+	writeJSON(w, http.StatusOK, &PollResponse{
+		Messages: msgs,
+		Dropped:  dropped,
+	})
+}
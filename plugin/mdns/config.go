@@ -0,0 +1,66 @@
+package mdns
+
+import (
+	"errors"
+	"net"
+	"time"
+)
+
+// Config is the configuration for the mdns plugin.
+type Config struct {
+	// Instance is the service instance name shown to the users, default to the host name.
+	Instance string `yaml:"instance"`
+	// HostName is the host name in the SRV records, default to "<host name>.local.".
+	HostName string `yaml:"host_name"`
+	// Interface is the name of the network interface to advertise on, empty means the system default.
+	Interface string `yaml:"interface"`
+	// Listeners is the addresses of the listeners to advertise, empty means all TCP listeners.
+	// The listeners with TLS are advertised as _secure-mqtt._tcp, others as _mqtt._tcp.
+	Listeners []string `yaml:"listeners"`
+	// TXT is the key/value pairs of the TXT records.
+	TXT map[string]string `yaml:"txt"`
+	// TTL is the TTL of the records.
+	TTL time.Duration `yaml:"ttl"`
+}
+
+// Validate validates the configuration, and return an error if it is invalid.
+func (c *Config) Validate() error {
+	if c.Interface != "" {
+		if _, err := net.InterfaceByName(c.Interface); err != nil {
+			return errors.New("invalid interface: " + err.Error())
+		}
+	}
+	for _, v := range c.Listeners {
+		if _, _, err := net.SplitHostPort(v); err != nil {
+			return errors.New("invalid listeners: " + err.Error())
+		}
+	}
+	for k := range c.TXT {
+		if k == "" {
+			return errors.New("invalid txt: key cannot be empty")
+		}
+	}
+	if c.TTL < time.Second {
+		return errors.New("invalid ttl: must be at least 1s")
+	}
+	return nil
+}
+
+// DefaultConfig is the default configuration.
+var DefaultConfig = Config{
+	TTL: 2 * time.Minute,
+}
+
+func (c *Config) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type cfg Config
+	var v = &struct {
+		MDNS cfg `yaml:"mdns"`
+	}{
+		MDNS: cfg(DefaultConfig),
+	}
+	if err := unmarshal(v); err != nil {
+		return err
+	}
+	*c = Config(v.MDNS)
+	return nil
+}
@@ -0,0 +1,9 @@
+package mdns
+
+import (
+	"github.com/DrmagicE/gmqtt/server"
+)
+
+func (m *MDNS) HookWrapper() server.HookWrapper {
+	return server.HookWrapper{}
+}
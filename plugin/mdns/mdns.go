@@ -0,0 +1,228 @@
+package mdns
+
+import (
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"go.uber.org/zap"
+	"golang.org/x/net/ipv4"
+
+	"github.com/DrmagicE/gmqtt/config"
+	"github.com/DrmagicE/gmqtt/server"
+)
+
+var _ server.Plugin = (*MDNS)(nil)
+
+const Name = "mdns"
+
+// announceInterval is the interval of the announcements at startup, see RFC 6762 section 8.3.
+const announceInterval = time.Second
+
+// mdnsGroup is the IPv4 multicast address of mDNS.
+var mdnsGroup = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+
+func init() {
+	server.RegisterPlugin(Name, New)
+	config.RegisterDefaultPluginConfig(Name, &DefaultConfig)
+}
+
+func New(config config.Config) (server.Plugin, error) {
+	cfg := *config.Plugins[Name].(*Config)
+	if cfg.Instance == "" || cfg.HostName == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return nil, err
+		}
+		hostname = strings.SplitN(hostname, ".", 2)[0]
+		if cfg.Instance == "" {
+			cfg.Instance = hostname
+		}
+		if cfg.HostName == "" {
+			cfg.HostName = hostname + "." + domain
+		}
+	}
+	cfg.HostName = dns.Fqdn(cfg.HostName)
+	m := &MDNS{
+		config: &cfg,
+		done:   make(chan struct{}),
+	}
+	if cfg.Interface != "" {
+		ifi, err := net.InterfaceByName(cfg.Interface)
+		if err != nil {
+			return nil, err
+		}
+		m.ifi = ifi
+	}
+	m.addrs = m.interfaceAddrs
+	return m, nil
+}
+
+var log *zap.Logger
+
+// MDNS advertises the TCP listeners of the broker via mDNS/DNS-SD, so that the devices on the local network
+// can discover the broker without the hardcoded addresses.
+type MDNS struct {
+	config *Config
+	ifi    *net.Interface
+	conn   *net.UDPConn
+	// listeners returns the running listeners.
+	listeners func() []config.ListenerConfig
+	// addrs returns the IPv4 addresses to advertise for the listeners which listen on the unspecified address.
+	addrs func() []net.IP
+	done  chan struct{}
+	wg    sync.WaitGroup
+}
+
+func (m *MDNS) Load(service server.Server) error {
+	log = server.LoggerWithField(zap.String("plugin", Name))
+	m.listeners = service.ListenerService().List
+	conn, err := net.ListenMulticastUDP("udp4", m.ifi, mdnsGroup)
+	if err != nil {
+		return err
+	}
+	// mDNS packets are sent with IP TTL 255, see RFC 6762 section 11.
+	p := ipv4.NewPacketConn(conn)
+	if err = p.SetMulticastTTL(255); err != nil {
+		conn.Close()
+		return err
+	}
+	if err = p.SetTTL(255); err != nil {
+		conn.Close()
+		return err
+	}
+	m.conn = conn
+	m.wg.Add(2)
+	go func() {
+		defer m.wg.Done()
+		m.serve()
+	}()
+	go func() {
+		defer m.wg.Done()
+		m.announce()
+	}()
+	return nil
+}
+
+func (m *MDNS) Unload() error {
+	close(m.done)
+	// say goodbye, so that the records are removed from the caches of the receivers immediately.
+	m.send(m.announcement(m.services(m.listeners()), 0), mdnsGroup)
+	m.conn.Close()
+	m.wg.Wait()
+	return nil
+}
+
+func (m *MDNS) Name() string {
+	return Name
+}
+
+// interfaceAddrs returns the IPv4 addresses of the configured interface,
+// or the addresses of all up and non-loopback interfaces if the interface is not configured.
+func (m *MDNS) interfaceAddrs() []net.IP {
+	var ifis []net.Interface
+	if m.ifi != nil {
+		ifis = []net.Interface{*m.ifi}
+	} else {
+		var err error
+		ifis, err = net.Interfaces()
+		if err != nil {
+			log.Error("failed to list interfaces", zap.Error(err))
+			return nil
+		}
+	}
+	var ips []net.IP
+	for _, ifi := range ifis {
+		if ifi.Flags&net.FlagUp == 0 || ifi.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		addrs, err := ifi.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			if ipnet, ok := addr.(*net.IPNet); ok && ipnet.IP.To4() != nil && !ipnet.IP.IsLinkLocalUnicast() {
+				ips = append(ips, ipnet.IP.To4())
+			}
+		}
+	}
+	return ips
+}
+
+// announce sends the unsolicited responses of the records twice at startup, see RFC 6762 section 8.3.
+// The listeners are started after the plugins are loaded, so the first announcement is delayed.
+func (m *MDNS) announce() {
+	for i := 0; i < 2; i++ {
+		select {
+		case <-m.done:
+			return
+		case <-time.After(announceInterval):
+		}
+		ss := m.services(m.listeners())
+		if len(ss) == 0 {
+			continue
+		}
+		m.send(m.announcement(ss, uint32(m.config.TTL.Seconds())), mdnsGroup)
+	}
+}
+
+// serve answers the queries until the connection is closed.
+func (m *MDNS) serve() {
+	buf := make([]byte, 9000)
+	for {
+		n, src, err := m.conn.ReadFromUDP(buf)
+		if err != nil {
+			select {
+			case <-m.done:
+			default:
+				log.Error("failed to read", zap.Error(err))
+			}
+			return
+		}
+		req := &dns.Msg{}
+		if err := req.Unpack(buf[:n]); err != nil {
+			log.Debug("invalid packet", zap.String("remote", src.String()), zap.Error(err))
+			continue
+		}
+		if req.Response || req.Opcode != dns.OpcodeQuery {
+			continue
+		}
+		legacy := src.Port != mdnsGroup.Port
+		resp := m.answer(req, m.services(m.listeners()), legacy)
+		if resp == nil {
+			continue
+		}
+		dst := mdnsGroup
+		if legacy || unicastRequested(req) {
+			dst = src
+		}
+		m.send(resp, dst)
+	}
+}
+
+// unicastRequested returns whether all questions request the unicast response.
+func unicastRequested(req *dns.Msg) bool {
+	for _, q := range req.Question {
+		if q.Qclass&unicastResponse == 0 {
+			return false
+		}
+	}
+	return len(req.Question) != 0
+}
+
+func (m *MDNS) send(msg *dns.Msg, dst *net.UDPAddr) {
+	if len(msg.Answer) == 0 {
+		return
+	}
+	b, err := msg.Pack()
+	if err != nil {
+		log.Error("failed to pack response", zap.Error(err))
+		return
+	}
+	if _, err = m.conn.WriteToUDP(b, dst); err != nil {
+		log.Warn("failed to send response", zap.String("remote", dst.String()), zap.Error(err))
+	}
+}
@@ -0,0 +1,164 @@
+package mdns
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+
+	"github.com/DrmagicE/gmqtt/config"
+)
+
+func init() {
+	log = zap.NewNop()
+}
+
+func newMDNS(cfg Config) *MDNS {
+	cfg.Instance = "gmqtt broker"
+	cfg.HostName = "broker.local."
+	return &MDNS{
+		config: &cfg,
+		addrs: func() []net.IP {
+			return []net.IP{net.IPv4(192, 168, 1, 10).To4()}
+		},
+		done: make(chan struct{}),
+	}
+}
+
+func TestConfig_Validate(t *testing.T) {
+	a := assert.New(t)
+	a.NoError(DefaultConfig.Validate())
+
+	cfg := DefaultConfig
+	cfg.TTL = 0
+	a.Error(cfg.Validate())
+
+	cfg = DefaultConfig
+	cfg.Listeners = []string{"1883"}
+	a.Error(cfg.Validate())
+
+	cfg = DefaultConfig
+	cfg.TXT = map[string]string{"": "a"}
+	a.Error(cfg.Validate())
+
+	cfg = DefaultConfig
+	cfg.Interface = "not_exist"
+	a.Error(cfg.Validate())
+}
+
+func TestMDNS_services(t *testing.T) {
+	a := assert.New(t)
+	m := newMDNS(DefaultConfig)
+	listeners := []config.ListenerConfig{
+		{Address: ":1883"},
+		{Address: ":8883", TLSOptions: &config.TLSOptions{}},
+		{Address: "10.0.0.1:1884"},
+		{Address: "127.0.0.1:1885"},
+		{Address: ":8083", Websocket: &config.WebsocketOptions{Path: "/"}},
+	}
+	ss := m.services(listeners)
+	a.Len(ss, 3)
+	a.Equal(mqttType, ss[0].typ)
+	a.Equal(`gmqtt\ broker\ \(1883\).`+mqttType, ss[0].name)
+	a.Equal(uint16(1883), ss[0].port)
+	a.Equal([]net.IP{net.IPv4(192, 168, 1, 10).To4()}, ss[0].ips)
+
+	a.Equal(secureType, ss[1].typ)
+	a.Equal(`gmqtt\ broker.`+secureType, ss[1].name)
+
+	a.Equal(`gmqtt\ broker\ \(1884\).`+mqttType, ss[2].name)
+	a.Equal([]net.IP{net.ParseIP("10.0.0.1")}, ss[2].ips)
+
+	m.config.Listeners = []string{":8883"}
+	ss = m.services(listeners)
+	a.Len(ss, 1)
+	a.Equal(secureType, ss[0].typ)
+}
+
+func TestMDNS_answer(t *testing.T) {
+	a := assert.New(t)
+	m := newMDNS(DefaultConfig)
+	m.config.TXT = map[string]string{"version": "5"}
+	ss := m.services([]config.ListenerConfig{{Address: ":1883"}})
+
+	req := &dns.Msg{}
+	req.SetQuestion(mqttType, dns.TypePTR)
+	// the names are packed and unpacked as they are on the wire.
+	b, err := req.Pack()
+	a.NoError(err)
+	a.NoError(req.Unpack(b))
+	resp := m.answer(req, ss, false)
+	a.NotNil(resp)
+	a.Len(resp.Answer, 1)
+	ptr := resp.Answer[0].(*dns.PTR)
+	a.Equal(`gmqtt\ broker.`+mqttType, ptr.Ptr)
+	a.Equal(uint32(120), ptr.Hdr.Ttl)
+	a.Len(resp.Extra, 3)
+	srv := resp.Extra[0].(*dns.SRV)
+	a.Equal(uint16(1883), srv.Port)
+	a.Equal("broker.local.", srv.Target)
+	a.Equal(uint16(dns.ClassINET|cacheFlush), srv.Hdr.Class)
+	a.Equal([]string{"version=5"}, resp.Extra[1].(*dns.TXT).Txt)
+	a.Equal("192.168.1.10", resp.Extra[2].(*dns.A).A.String())
+	a.Zero(resp.Id)
+	a.Empty(resp.Question)
+	_, err = resp.Pack()
+	a.NoError(err)
+
+	// the service instance
+	req.SetQuestion(ptr.Ptr, dns.TypeANY)
+	resp = m.answer(req, ss, false)
+	a.Len(resp.Answer, 2)
+	a.Len(resp.Extra, 1)
+
+	// enumerate the service types
+	req.SetQuestion(servicesName, dns.TypePTR)
+	resp = m.answer(req, ss, false)
+	a.Len(resp.Answer, 1)
+	a.Equal(mqttType, resp.Answer[0].(*dns.PTR).Ptr)
+
+	// the host name
+	req.SetQuestion("BROKER.local.", dns.TypeA)
+	resp = m.answer(req, ss, false)
+	a.Len(resp.Answer, 1)
+
+	// legacy unicast
+	req.SetQuestion(mqttType, dns.TypePTR)
+	req.Id = 100
+	resp = m.answer(req, ss, true)
+	a.Equal(uint16(100), resp.Id)
+	a.Equal(req.Question, resp.Question)
+	a.Equal(uint32(legacyTTL), resp.Answer[0].Header().Ttl)
+	a.Equal(uint16(dns.ClassINET), resp.Extra[0].Header().Class)
+
+	req.SetQuestion("_http._tcp.local.", dns.TypePTR)
+	a.Nil(m.answer(req, ss, false))
+	req.SetQuestion(mqttType, dns.TypeA)
+	a.Nil(m.answer(req, ss, false))
+}
+
+func TestMDNS_announcement(t *testing.T) {
+	a := assert.New(t)
+	m := newMDNS(DefaultConfig)
+	m.config.TTL = time.Minute
+	ss := m.services([]config.ListenerConfig{{Address: ":1883"}, {Address: ":8883", TLSOptions: &config.TLSOptions{}}})
+	msg := m.announcement(ss, 0)
+	// 2 service type PTR, 2 instance PTR, 2 SRV, 2 TXT and 1 A
+	a.Len(msg.Answer, 9)
+	for _, v := range msg.Answer {
+		a.Zero(v.Header().Ttl)
+	}
+}
+
+func TestUnicastRequested(t *testing.T) {
+	a := assert.New(t)
+	req := &dns.Msg{}
+	a.False(unicastRequested(req))
+	req.Question = []dns.Question{{Name: mqttType, Qtype: dns.TypePTR, Qclass: dns.ClassINET | unicastResponse}}
+	a.True(unicastRequested(req))
+	req.Question = append(req.Question, dns.Question{Name: secureType, Qtype: dns.TypePTR, Qclass: dns.ClassINET})
+	a.False(unicastRequested(req))
+}
@@ -0,0 +1,241 @@
+package mdns
+
+import (
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/miekg/dns"
+
+	"github.com/DrmagicE/gmqtt/config"
+)
+
+const (
+	domain = "local."
+	// servicesName is the name to enumerate the service types, see RFC 6763 section 9.
+	servicesName = "_services._dns-sd._udp." + domain
+	mqttType     = "_mqtt._tcp." + domain
+	secureType   = "_secure-mqtt._tcp." + domain
+	// cacheFlush is the cache-flush bit of the class of the unique records, see RFC 6762 section 10.2.
+	cacheFlush = 1 << 15
+	// unicastResponse is the unicast-response bit of the class of the questions, see RFC 6762 section 5.4.
+	unicastResponse = 1 << 15
+	// legacyTTL is the maximum TTL of the responses to the legacy unicast queries, see RFC 6762 section 6.7.
+	legacyTTL = 10
+)
+
+// service is an advertised listener.
+type service struct {
+	// typ is the service type, e.g. _mqtt._tcp.local.
+	typ string
+	// name is the service instance name, e.g. gmqtt._mqtt._tcp.local.
+	name string
+	port uint16
+	ips  []net.IP
+}
+
+// escapeLabel escapes the characters which are special in the domain names.
+func escapeLabel(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch r {
+		case '.', '\\', ' ', '(', ')', ';', '"', '@', '$':
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// services returns the services of the listeners to advertise.
+// The instance names of the same service type are suffixed with the port if there are more than one listener of the type.
+func (m *MDNS) services(listeners []config.ListenerConfig) []*service {
+	var ss []*service
+	count := make(map[string]int)
+	for _, v := range listeners {
+		if v.Websocket != nil || !m.advertised(v.Address) {
+			continue
+		}
+		host, p, err := net.SplitHostPort(v.Address)
+		if err != nil {
+			continue
+		}
+		port, err := strconv.ParseUint(p, 10, 16)
+		if err != nil || port == 0 {
+			continue
+		}
+		s := &service{
+			typ:  mqttType,
+			port: uint16(port),
+		}
+		if v.TLSOptions != nil {
+			s.typ = secureType
+		}
+		if ip := net.ParseIP(host); ip != nil && !ip.IsUnspecified() {
+			if ip.IsLoopback() || ip.To4() == nil {
+				continue
+			}
+			s.ips = []net.IP{ip}
+		} else {
+			s.ips = m.addrs()
+		}
+		count[s.typ]++
+		ss = append(ss, s)
+	}
+	for _, s := range ss {
+		instance := m.config.Instance
+		if count[s.typ] > 1 {
+			instance += " (" + strconv.Itoa(int(s.port)) + ")"
+		}
+		s.name = escapeLabel(instance) + "." + s.typ
+	}
+	return ss
+}
+
+// advertised returns whether the listener of the address is advertised.
+func (m *MDNS) advertised(address string) bool {
+	if len(m.config.Listeners) == 0 {
+		return true
+	}
+	for _, v := range m.config.Listeners {
+		if v == address {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *MDNS) header(name string, rrtype uint16, ttl uint32, unique bool) dns.RR_Header {
+	class := uint16(dns.ClassINET)
+	if unique {
+		class |= cacheFlush
+	}
+	return dns.RR_Header{Name: name, Rrtype: rrtype, Class: class, Ttl: ttl}
+}
+
+func (m *MDNS) txt() []string {
+	keys := make([]string, 0, len(m.config.TXT))
+	for k := range m.config.TXT {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	txt := make([]string, 0, len(keys))
+	for _, k := range keys {
+		txt = append(txt, k+"="+m.config.TXT[k])
+	}
+	if len(txt) == 0 {
+		// a TXT record must contain at least one string, see RFC 6763 section 6.1.
+		txt = append(txt, "")
+	}
+	return txt
+}
+
+func (m *MDNS) typePTR(s *service, ttl uint32) dns.RR {
+	return &dns.PTR{Hdr: m.header(servicesName, dns.TypePTR, ttl, false), Ptr: s.typ}
+}
+
+func (m *MDNS) instancePTR(s *service, ttl uint32) dns.RR {
+	return &dns.PTR{Hdr: m.header(s.typ, dns.TypePTR, ttl, false), Ptr: s.name}
+}
+
+func (m *MDNS) srv(s *service, ttl uint32, unique bool) dns.RR {
+	return &dns.SRV{Hdr: m.header(s.name, dns.TypeSRV, ttl, unique), Port: s.port, Target: m.config.HostName}
+}
+
+func (m *MDNS) txtRR(s *service, ttl uint32, unique bool) dns.RR {
+	return &dns.TXT{Hdr: m.header(s.name, dns.TypeTXT, ttl, unique), Txt: m.txt()}
+}
+
+func (m *MDNS) a(s *service, ttl uint32, unique bool) []dns.RR {
+	var rrs []dns.RR
+	for _, ip := range s.ips {
+		rrs = append(rrs, &dns.A{Hdr: m.header(m.config.HostName, dns.TypeA, ttl, unique), A: ip})
+	}
+	return rrs
+}
+
+func containsRR(rrs []dns.RR, rr dns.RR) bool {
+	for _, v := range rrs {
+		if dns.IsDuplicate(v, rr) {
+			return true
+		}
+	}
+	return false
+}
+
+// appendRR appends the records which are not in rrs.
+func appendRR(rrs []dns.RR, add ...dns.RR) []dns.RR {
+	for _, v := range add {
+		if !containsRR(rrs, v) {
+			rrs = append(rrs, v)
+		}
+	}
+	return rrs
+}
+
+// answer returns the response to the query, nil if there is nothing to answer.
+// legacy indicates the query is sent by a legacy unicast resolver, which is not from port 5353.
+func (m *MDNS) answer(req *dns.Msg, ss []*service, legacy bool) *dns.Msg {
+	ttl := uint32(m.config.TTL.Seconds())
+	unique := !legacy
+	if legacy && ttl > legacyTTL {
+		ttl = legacyTTL
+	}
+	resp := &dns.Msg{}
+	resp.Response = true
+	resp.Authoritative = true
+	for _, q := range req.Question {
+		if q.Qclass&^unicastResponse != dns.ClassINET && q.Qclass&^unicastResponse != dns.ClassANY {
+			continue
+		}
+		all := q.Qtype == dns.TypeANY
+		for _, s := range ss {
+			switch {
+			case strings.EqualFold(q.Name, servicesName) && (all || q.Qtype == dns.TypePTR):
+				resp.Answer = appendRR(resp.Answer, m.typePTR(s, ttl))
+			case strings.EqualFold(q.Name, s.typ) && (all || q.Qtype == dns.TypePTR):
+				resp.Answer = appendRR(resp.Answer, m.instancePTR(s, ttl))
+				resp.Extra = appendRR(resp.Extra, m.srv(s, ttl, unique), m.txtRR(s, ttl, unique))
+				resp.Extra = appendRR(resp.Extra, m.a(s, ttl, unique)...)
+			case strings.EqualFold(q.Name, s.name):
+				if all || q.Qtype == dns.TypeSRV {
+					resp.Answer = appendRR(resp.Answer, m.srv(s, ttl, unique))
+					resp.Extra = appendRR(resp.Extra, m.a(s, ttl, unique)...)
+				}
+				if all || q.Qtype == dns.TypeTXT {
+					resp.Answer = appendRR(resp.Answer, m.txtRR(s, ttl, unique))
+				}
+			case strings.EqualFold(q.Name, m.config.HostName) && (all || q.Qtype == dns.TypeA):
+				resp.Answer = appendRR(resp.Answer, m.a(s, ttl, unique)...)
+			}
+		}
+	}
+	if len(resp.Answer) == 0 {
+		return nil
+	}
+	var extra []dns.RR
+	for _, v := range resp.Extra {
+		if !containsRR(resp.Answer, v) {
+			extra = append(extra, v)
+		}
+	}
+	resp.Extra = extra
+	if legacy {
+		resp.Id = req.Id
+		resp.Question = req.Question
+	}
+	return resp
+}
+
+// announcement returns the unsolicited response of all records, the records are removed by the receivers if ttl is 0.
+func (m *MDNS) announcement(ss []*service, ttl uint32) *dns.Msg {
+	resp := &dns.Msg{}
+	resp.Response = true
+	resp.Authoritative = true
+	for _, s := range ss {
+		resp.Answer = appendRR(resp.Answer, m.typePTR(s, ttl), m.instancePTR(s, ttl), m.srv(s, ttl, true), m.txtRR(s, ttl, true))
+		resp.Answer = appendRR(resp.Answer, m.a(s, ttl, true)...)
+	}
+	return resp
+}
@@ -0,0 +1,108 @@
+package migration
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/DrmagicE/gmqtt/pkg/proxy"
+)
+
+// Config is the configuration for the migration plugin.
+type Config struct {
+	// ExportOnDisconnect indicates whether to export the persistent sessions to the sink when the clients disconnect.
+	ExportOnDisconnect bool `yaml:"export_on_disconnect"`
+	// ClientIDs is the regular expressions of the client ids whose sessions are exported, empty means all.
+	ClientIDs []string `yaml:"client_ids"`
+	// RemoveAfterExport indicates whether to remove the exported sessions from the broker,
+	// so that the sessions only live in the broker which imports them.
+	// The session is restored if it fails to be written to the sink.
+	RemoveAfterExport bool `yaml:"remove_after_export"`
+	// Sink is the name of the sink implementation, "file" and "webhook" are bundled.
+	// Other implementations can be registered by RegisterSink.
+	Sink string `yaml:"sink"`
+	// File is the configuration of the file sink.
+	File FileConfig `yaml:"file"`
+	// Webhook is the configuration of the webhook sink.
+	Webhook WebhookConfig `yaml:"webhook"`
+	// ImportDir is the directory of the exported sessions which are imported at startup, empty means disabled.
+	// The imported files are renamed with the .imported suffix.
+	// If it is a relative path, it locates in the same directory as the config file.
+	ImportDir string `yaml:"import_dir"`
+}
+
+// FileConfig is the configuration for the file sink.
+type FileConfig struct {
+	// Dir is the directory to write the exported sessions to, one file for each client id.
+	// If it is a relative path, it locates in the same directory as the config file.
+	Dir string `yaml:"dir"`
+}
+
+// WebhookConfig is the configuration for the webhook sink.
+type WebhookConfig struct {
+	// URL is the URL that the exported sessions are POSTed to,
+	// the placeholder {client_id} (if any) is replaced by the client id.
+	URL string `yaml:"url"`
+	// Headers is the additional HTTP headers, e.g. Authorization.
+	Headers map[string]string `yaml:"headers"`
+	// Timeout is the timeout of the HTTP request.
+	Timeout time.Duration `yaml:"timeout"`
+	// Proxy is the forward proxy of the HTTP requests, e.g. http://proxy:3128 or socks5://proxy:1080.
+	// If empty, use the global proxy. "direct" connects directly regardless of the global proxy.
+	Proxy string `yaml:"proxy"`
+}
+
+// Validate validates the configuration, and return an error if it is invalid.
+func (c *Config) Validate() error {
+	for _, v := range c.ClientIDs {
+		if _, err := regexp.Compile(v); err != nil {
+			return fmt.Errorf("invalid client_ids: %s", err)
+		}
+	}
+	if _, ok := sinks[c.Sink]; !ok {
+		return fmt.Errorf("invalid sink: %s", c.Sink)
+	}
+	switch c.Sink {
+	case SinkFile:
+		if c.File.Dir == "" {
+			return errors.New("invalid file dir: cannot be empty")
+		}
+	case SinkWebhook:
+		if c.Webhook.URL == "" {
+			return errors.New("invalid webhook url: cannot be empty")
+		}
+		if c.Webhook.Timeout <= 0 {
+			return errors.New("invalid webhook timeout: must be greater than 0")
+		}
+		if err := proxy.Validate(c.Webhook.Proxy); err != nil {
+			return fmt.Errorf("invalid webhook proxy: %s", err)
+		}
+	}
+	return nil
+}
+
+// DefaultConfig is the default configuration.
+var DefaultConfig = Config{
+	Sink: SinkFile,
+	File: FileConfig{
+		Dir: "./sessions",
+	},
+	Webhook: WebhookConfig{
+		Timeout: 5 * time.Second,
+	},
+}
+
+func (c *Config) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type cfg Config
+	var v = &struct {
+		Migration cfg `yaml:"migration"`
+	}{
+		Migration: cfg(DefaultConfig),
+	}
+	if err := unmarshal(v); err != nil {
+		return err
+	}
+	*c = Config(v.Migration)
+	return nil
+}
@@ -0,0 +1,31 @@
+package migration
+
+import (
+	"context"
+
+	"github.com/DrmagicE/gmqtt/server"
+)
+
+func (m *Migration) HookWrapper() server.HookWrapper {
+	if !m.config.ExportOnDisconnect {
+		return server.HookWrapper{}
+	}
+	return server.HookWrapper{
+		OnClosedWrapper: m.OnClosedWrapper,
+	}
+}
+
+func (m *Migration) OnClosedWrapper(pre server.OnClosed) server.OnClosed {
+	return func(ctx context.Context, client server.Client, reason *server.CloseReason) {
+		pre(ctx, client, reason)
+		clientID := client.ClientOptions().ClientID
+		if !m.match(clientID) {
+			return
+		}
+		m.wg.Add(1)
+		go func() {
+			defer m.wg.Done()
+			m.export(clientID)
+		}()
+	}
+}
@@ -0,0 +1,204 @@
+package migration
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/DrmagicE/gmqtt/config"
+	"github.com/DrmagicE/gmqtt/pkg/proxy"
+	"github.com/DrmagicE/gmqtt/server"
+)
+
+var _ server.Plugin = (*Migration)(nil)
+
+const Name = "migration"
+
+const (
+	// exportRetryInterval is the interval to retry the export until the disconnected client is unregistered,
+	// because OnClosed is called before the session is stored as an offline session.
+	exportRetryInterval = 100 * time.Millisecond
+	// exportMaxAttempts is the maximum attempts of the export, the export is abandoned if the client keeps connected,
+	// e.g. it reconnects immediately.
+	exportMaxAttempts = 50
+)
+
+// importedSuffix is appended to the name of the imported files.
+const importedSuffix = ".imported"
+
+func init() {
+	server.RegisterPlugin(Name, New)
+	config.RegisterDefaultPluginConfig(Name, &DefaultConfig)
+}
+
+func New(config config.Config) (server.Plugin, error) {
+	cfg := *config.Plugins[Name].(*Config)
+	cfg.File.Dir = resolvePath(cfg.File.Dir, config.ConfigDir)
+	if cfg.ImportDir != "" {
+		cfg.ImportDir = resolvePath(cfg.ImportDir, config.ConfigDir)
+	}
+	cfg.Webhook.Proxy = proxy.Resolve(cfg.Webhook.Proxy, config.Proxy)
+	m := &Migration{
+		config: &cfg,
+		done:   make(chan struct{}),
+	}
+	for _, v := range cfg.ClientIDs {
+		m.clientIDs = append(m.clientIDs, regexp.MustCompile(v))
+	}
+	if cfg.ExportOnDisconnect {
+		sink, err := sinks[cfg.Sink](&cfg)
+		if err != nil {
+			return nil, err
+		}
+		m.sink = sink
+	}
+	return m, nil
+}
+
+// resolvePath resolves the relative path against the configDir.
+func resolvePath(p string, configDir string) string {
+	if path.IsAbs(p) {
+		return p
+	}
+	return path.Join(configDir, p)
+}
+
+var log *zap.Logger
+
+// Migration exports the persistent sessions to the sink when the clients disconnect,
+// and imports the exported sessions at startup, which enables migrating the sessions between the brokers.
+type Migration struct {
+	config        *Config
+	clientIDs     []*regexp.Regexp
+	sink          Sink
+	clientService server.ClientService
+	done          chan struct{}
+	wg            sync.WaitGroup
+}
+
+func (m *Migration) Load(service server.Server) error {
+	log = server.LoggerWithField(zap.String("plugin", Name))
+	m.clientService = service.ClientService()
+	if m.config.ImportDir != "" {
+		return m.importDir()
+	}
+	return nil
+}
+
+func (m *Migration) Unload() error {
+	close(m.done)
+	m.wg.Wait()
+	return nil
+}
+
+func (m *Migration) Name() string {
+	return Name
+}
+
+// match returns whether the session of the client id is exported.
+func (m *Migration) match(clientID string) bool {
+	if len(m.clientIDs) == 0 {
+		return true
+	}
+	for _, v := range m.clientIDs {
+		if v.MatchString(clientID) {
+			return true
+		}
+	}
+	return false
+}
+
+// export exports the session of the disconnected client to the sink.
+func (m *Migration) export(clientID string) {
+	var exp *server.SessionExport
+	var err error
+	for i := 0; i < exportMaxAttempts; i++ {
+		exp, err = m.clientService.ExportSession(clientID, m.config.RemoveAfterExport)
+		if err != server.ErrSessionConnected {
+			break
+		}
+		select {
+		case <-m.done:
+			return
+		case <-time.After(exportRetryInterval):
+		}
+	}
+	switch err {
+	case nil:
+	case server.ErrSessionNotFound:
+		// the session is not persistent.
+		return
+	case server.ErrSessionConnected:
+		log.Debug("export abandoned, the client is connected", zap.String("client_id", clientID))
+		return
+	default:
+		log.Error("failed to export session", zap.String("client_id", clientID), zap.Error(err))
+		return
+	}
+	if err = m.write(exp); err != nil {
+		log.Error("failed to write exported session", zap.String("client_id", clientID), zap.Error(err))
+		if m.config.RemoveAfterExport {
+			if err = m.clientService.ImportSession(exp); err != nil {
+				log.Error("failed to restore session", zap.String("client_id", clientID), zap.Error(err))
+			}
+		}
+		return
+	}
+	log.Info("session exported",
+		zap.String("client_id", clientID),
+		zap.String("sink", m.config.Sink),
+		zap.Bool("removed", m.config.RemoveAfterExport))
+}
+
+func (m *Migration) write(exp *server.SessionExport) error {
+	data, err := json.Marshal(exp)
+	if err != nil {
+		return err
+	}
+	return m.sink.Write(context.Background(), exp.Session.ClientID, data)
+}
+
+// importDir imports the exported sessions in the import dir.
+// The files which fail to be imported, e.g. the session already exists, are left as they are.
+func (m *Migration) importDir() error {
+	if _, err := os.Stat(m.config.ImportDir); err != nil {
+		return err
+	}
+	files, err := filepath.Glob(filepath.Join(m.config.ImportDir, "*.json"))
+	if err != nil {
+		return err
+	}
+	var imported int
+	for _, f := range files {
+		if err = m.importFile(f); err != nil {
+			log.Error("failed to import session", zap.String("file", f), zap.Error(err))
+			continue
+		}
+		imported++
+	}
+	log.Info("sessions imported", zap.String("dir", m.config.ImportDir), zap.Int("imported", imported), zap.Int("failed", len(files)-imported))
+	return nil
+}
+
+func (m *Migration) importFile(file string) error {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	exp := &server.SessionExport{}
+	if err = json.Unmarshal(data, exp); err != nil {
+		return err
+	}
+	if err = m.clientService.ImportSession(exp); err != nil {
+		return err
+	}
+	return os.Rename(file, file+importedSuffix)
+}
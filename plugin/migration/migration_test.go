@@ -0,0 +1,205 @@
+package migration
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+
+	"github.com/DrmagicE/gmqtt"
+	"github.com/DrmagicE/gmqtt/server"
+)
+
+func init() {
+	log = zap.NewNop()
+}
+
+// fakeSink records the written sessions.
+type fakeSink struct {
+	written map[string][]byte
+	err     error
+}
+
+func (f *fakeSink) Write(ctx context.Context, clientID string, data []byte) error {
+	if f.err != nil {
+		return f.err
+	}
+	f.written[clientID] = data
+	return nil
+}
+
+func newMigration(cfg Config, cs server.ClientService, sink Sink) *Migration {
+	m := &Migration{
+		config:        &cfg,
+		sink:          sink,
+		clientService: cs,
+		done:          make(chan struct{}),
+	}
+	return m
+}
+
+func TestConfig_Validate(t *testing.T) {
+	a := assert.New(t)
+	a.NoError(DefaultConfig.Validate())
+
+	cfg := DefaultConfig
+	cfg.Sink = "s3"
+	a.Error(cfg.Validate())
+
+	cfg = DefaultConfig
+	cfg.File.Dir = ""
+	a.Error(cfg.Validate())
+
+	cfg = DefaultConfig
+	cfg.Sink = SinkWebhook
+	a.Error(cfg.Validate())
+	cfg.Webhook.URL = "http://127.0.0.1/sessions/{client_id}"
+	a.NoError(cfg.Validate())
+	cfg.Webhook.Proxy = "proxy:3128"
+	a.Error(cfg.Validate())
+
+	cfg = DefaultConfig
+	cfg.ClientIDs = []string{"("}
+	a.Error(cfg.Validate())
+}
+
+func TestMigration_match(t *testing.T) {
+	a := assert.New(t)
+	m := newMigration(DefaultConfig, nil, nil)
+	a.True(m.match("a"))
+	m.clientIDs = append(m.clientIDs, regexp.MustCompile("^sensor-"))
+	a.True(m.match("sensor-1"))
+	a.False(m.match("a"))
+}
+
+func TestMigration_export(t *testing.T) {
+	a := assert.New(t)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	cs := server.NewMockClientService(ctrl)
+	sink := &fakeSink{written: make(map[string][]byte)}
+	exp := &server.SessionExport{
+		Version: server.SessionExportVersion,
+		Session: &gmqtt.Session{ClientID: "a", ExpiryInterval: 100},
+	}
+
+	m := newMigration(DefaultConfig, cs, sink)
+	gomock.InOrder(
+		cs.EXPECT().ExportSession("a", false).Return(nil, server.ErrSessionConnected),
+		cs.EXPECT().ExportSession("a", false).Return(exp, nil),
+	)
+	m.export("a")
+	b, err := json.Marshal(exp)
+	a.NoError(err)
+	a.Equal(b, sink.written["a"])
+
+	// the session is not persistent.
+	cs.EXPECT().ExportSession("b", false).Return(nil, server.ErrSessionNotFound)
+	m.export("b")
+	a.NotContains(sink.written, "b")
+
+	// the removed session is restored if the sink fails.
+	cfg := DefaultConfig
+	cfg.RemoveAfterExport = true
+	sink.err = errors.New("sink error")
+	m = newMigration(cfg, cs, sink)
+	cs.EXPECT().ExportSession("a", true).Return(exp, nil)
+	cs.EXPECT().ImportSession(exp).Return(nil)
+	m.export("a")
+}
+
+func TestFileSink(t *testing.T) {
+	a := assert.New(t)
+	dir, err := ioutil.TempDir("", "migration")
+	a.NoError(err)
+	defer os.RemoveAll(dir)
+	cfg := DefaultConfig
+	cfg.File.Dir = filepath.Join(dir, "sessions")
+	s, err := newFileSink(&cfg)
+	a.NoError(err)
+	a.NoError(s.Write(context.Background(), "a/b", []byte("1")))
+	a.NoError(s.Write(context.Background(), "a/b", []byte("2")))
+	b, err := ioutil.ReadFile(filepath.Join(cfg.File.Dir, "a%2Fb.json"))
+	a.NoError(err)
+	a.Equal([]byte("2"), b)
+	files, err := ioutil.ReadDir(cfg.File.Dir)
+	a.NoError(err)
+	a.Len(files, 1)
+}
+
+func TestWebhookSink(t *testing.T) {
+	a := assert.New(t)
+	var body []byte
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		a.Equal(http.MethodPost, r.Method)
+		a.Equal("Bearer token", r.Header.Get("Authorization"))
+		a.Equal("application/json", r.Header.Get("Content-Type"))
+		if r.URL.EscapedPath() != "/sessions/a%2Fb" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		body, _ = ioutil.ReadAll(r.Body)
+	}))
+	defer ts.Close()
+	cfg := DefaultConfig
+	cfg.Webhook.URL = ts.URL + "/sessions/{client_id}"
+	cfg.Webhook.Headers = map[string]string{"Authorization": "Bearer token"}
+	s, err := newWebhookSink(&cfg)
+	a.NoError(err)
+	a.NoError(s.Write(context.Background(), "a/b", []byte("{}")))
+	a.Equal([]byte("{}"), body)
+	a.Error(s.Write(context.Background(), "c", []byte("{}")))
+}
+
+func TestMigration_importDir(t *testing.T) {
+	a := assert.New(t)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	dir, err := ioutil.TempDir("", "migration")
+	a.NoError(err)
+	defer os.RemoveAll(dir)
+	cs := server.NewMockClientService(ctrl)
+	cfg := DefaultConfig
+	cfg.ImportDir = dir
+	m := newMigration(cfg, cs, nil)
+
+	exp := &server.SessionExport{
+		Version: server.SessionExportVersion,
+		Session: &gmqtt.Session{ClientID: "a", ExpiryInterval: 100},
+	}
+	b, err := json.Marshal(exp)
+	a.NoError(err)
+	a.NoError(ioutil.WriteFile(filepath.Join(dir, "a.json"), b, 0600))
+	a.NoError(ioutil.WriteFile(filepath.Join(dir, "b.json"), b, 0600))
+	a.NoError(ioutil.WriteFile(filepath.Join(dir, "c.json"), []byte("{"), 0600))
+	a.NoError(ioutil.WriteFile(filepath.Join(dir, "d.txt"), b, 0600))
+
+	gomock.InOrder(
+		cs.EXPECT().ImportSession(gomock.Any()).DoAndReturn(func(got *server.SessionExport) error {
+			a.Equal("a", got.Session.ClientID)
+			return nil
+		}),
+		cs.EXPECT().ImportSession(gomock.Any()).Return(server.ErrSessionExists),
+	)
+	a.NoError(m.importDir())
+	_, err = os.Stat(filepath.Join(dir, "a.json"+importedSuffix))
+	a.NoError(err)
+	// the failed ones are left as they are.
+	_, err = os.Stat(filepath.Join(dir, "b.json"))
+	a.NoError(err)
+	_, err = os.Stat(filepath.Join(dir, "c.json"))
+	a.NoError(err)
+
+	m.config.ImportDir = filepath.Join(dir, "not_exist")
+	a.Error(m.importDir())
+}
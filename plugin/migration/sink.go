@@ -0,0 +1,120 @@
+package migration
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/DrmagicE/gmqtt/pkg/proxy"
+)
+
+// The names of the bundled sinks.
+const (
+	SinkFile    = "file"
+	SinkWebhook = "webhook"
+)
+
+// Sink stores the exported sessions outside of the broker.
+type Sink interface {
+	// Write writes the JSON encoded server.SessionExport of the client id.
+	Write(ctx context.Context, clientID string, data []byte) error
+}
+
+// NewSink creates a Sink from the plugin config.
+type NewSink func(config *Config) (Sink, error)
+
+var sinks = map[string]NewSink{
+	SinkFile:    newFileSink,
+	SinkWebhook: newWebhookSink,
+}
+
+// RegisterSink registers a Sink implementation with the name, which can be used in the sink config.
+// It is not thread-safe and should be called in init function.
+func RegisterSink(name string, new NewSink) {
+	if _, ok := sinks[name]; ok {
+		panic(fmt.Sprintf("duplicated sink: %s", name))
+	}
+	sinks[name] = new
+}
+
+// exportFileName returns the file name of the exported session of the client id.
+func exportFileName(clientID string) string {
+	return url.PathEscape(clientID) + ".json"
+}
+
+// fileSink writes the exported session of each client id to a file in the directory,
+// the previous export of the same client id is replaced.
+type fileSink struct {
+	dir string
+}
+
+func newFileSink(config *Config) (Sink, error) {
+	if err := os.MkdirAll(config.File.Dir, 0700); err != nil {
+		return nil, err
+	}
+	return &fileSink{dir: config.File.Dir}, nil
+}
+
+func (f *fileSink) Write(ctx context.Context, clientID string, data []byte) error {
+	tmp, err := ioutil.TempFile(f.dir, ".export-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	// rename is atomic, the importer never reads a partially written file.
+	return os.Rename(tmp.Name(), filepath.Join(f.dir, exportFileName(clientID)))
+}
+
+// webhookSink POSTs the exported sessions to the url.
+type webhookSink struct {
+	config *WebhookConfig
+	client *http.Client
+}
+
+func newWebhookSink(config *Config) (Sink, error) {
+	transport, err := proxy.HTTPTransport(config.Webhook.Proxy)
+	if err != nil {
+		return nil, err
+	}
+	return &webhookSink{
+		config: &config.Webhook,
+		client: &http.Client{
+			Transport: transport,
+			Timeout:   config.Webhook.Timeout,
+		},
+	}, nil
+}
+
+func (w *webhookSink) Write(ctx context.Context, clientID string, data []byte) error {
+	u := strings.Replace(w.config.URL, "{client_id}", url.PathEscape(clientID), -1)
+	req, err := http.NewRequest(http.MethodPost, u, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range w.config.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := w.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status code from webhook: %d", resp.StatusCode)
+	}
+	return nil
+}
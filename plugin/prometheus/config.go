@@ -11,6 +11,15 @@ type Config struct {
 	ListenAddress string `yaml:"listen_address"`
 	// Path is the exporter url path.
 	Path string `yaml:"path"`
+	// TopicStats indicates whether to export the statistics of the topic filters and their namespaces.
+	// It walks through all subscriptions on each scrape.
+	TopicStats bool `yaml:"topic_stats"`
+	// TopicStatsMaxFilters is the maximum number of the topic filters (with the most subscribers) to export,
+	// 0 means only the namespaces are exported.
+	TopicStatsMaxFilters int `yaml:"topic_stats_max_filters"`
+	// SharedStats indicates whether to export the statistics of the shared subscription groups and of their members.
+	// It walks through all shared subscriptions on each scrape.
+	SharedStats bool `yaml:"shared_stats"`
 }
 
 // Validate validates the configuration, and return an error if it is invalid.
@@ -19,13 +28,17 @@ func (c *Config) Validate() error {
 	if err != nil {
 		return errors.New("invalid listen_address")
 	}
+	if c.TopicStatsMaxFilters < 0 {
+		return errors.New("invalid topic_stats_max_filters")
+	}
 	return nil
 }
 
 // DefaultConfig is the default configuration.
 var DefaultConfig = Config{
-	ListenAddress: ":8082",
-	Path:          "/metrics",
+	ListenAddress:        ":8082",
+	Path:                 "/metrics",
+	TopicStatsMaxFilters: 100,
 }
 
 func (c *Config) UnmarshalYAML(unmarshal func(interface{}) error) error {
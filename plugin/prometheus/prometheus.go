@@ -3,6 +3,7 @@ package prometheus
 import (
 	"context"
 	"net/http"
+	"strconv"
 	"sync/atomic"
 
 	"github.com/prometheus/client_golang/prometheus"
@@ -34,6 +35,7 @@ func New(config config.Config) (server.Plugin, error) {
 	return &Prometheus{
 		httpServer: httpServer,
 		path:       cfg.Path,
+		config:     cfg,
 	}, nil
 }
 
@@ -44,6 +46,7 @@ type Prometheus struct {
 	statsManager server.StatsReader
 	httpServer   *http.Server
 	path         string
+	config       *Config
 }
 
 func (p *Prometheus) Load(service server.Server) error {
@@ -82,6 +85,72 @@ func (p *Prometheus) Collect(m chan<- prometheus.Metric) {
 	collectClientStats(&st.ConnectionStats, m)
 	collectSubscriptionStats(&st.SubscriptionStats, m)
 	collectMessageStats(&st.MessageStats, m)
+	collectRegistryStats(&st.RegistryStats, m)
+	collectHookStats(&st, m)
+	collectLifetimeStats(&st, m)
+	if p.config.TopicStats {
+		collectTopicStats(p.statsManager.GetTopicStats(), p.config.TopicStatsMaxFilters, m)
+	}
+	if p.config.SharedStats {
+		collectSharedStats(p.statsManager.GetSharedStats(), m)
+	}
+}
+
+func collectSharedStats(groups []server.SharedGroupStats, m chan<- prometheus.Metric) {
+	for _, g := range groups {
+		m <- prometheus.MustNewConstMetric(
+			prometheus.NewDesc(metricPrefix+"shared_group_members_current", "", []string{"topic_filter"}, nil),
+			prometheus.GaugeValue,
+			float64(len(g.Members)), g.TopicFilter,
+		)
+		for _, v := range g.Members {
+			m <- prometheus.MustNewConstMetric(
+				prometheus.NewDesc(metricPrefix+"shared_group_delivered_total", "", []string{"topic_filter", "client_id"}, nil),
+				prometheus.CounterValue,
+				float64(v.Delivered), g.TopicFilter, v.ClientID,
+			)
+			m <- prometheus.MustNewConstMetric(
+				prometheus.NewDesc(metricPrefix+"shared_group_redelivered_total", "", []string{"topic_filter", "client_id"}, nil),
+				prometheus.CounterValue,
+				float64(v.Redelivered), g.TopicFilter, v.ClientID,
+			)
+		}
+	}
+}
+
+func collectTopicStats(ts server.TopicStats, maxFilters int, m chan<- prometheus.Metric) {
+	for _, v := range ts.Namespaces {
+		m <- prometheus.MustNewConstMetric(
+			prometheus.NewDesc(metricPrefix+"namespace_topic_filters_current", "", []string{"namespace"}, nil),
+			prometheus.GaugeValue,
+			float64(v.TopicFilters), v.Namespace,
+		)
+		m <- prometheus.MustNewConstMetric(
+			prometheus.NewDesc(metricPrefix+"namespace_subscriptions_current", "", []string{"namespace"}, nil),
+			prometheus.GaugeValue,
+			float64(v.Subscribers), v.Namespace,
+		)
+		m <- prometheus.MustNewConstMetric(
+			prometheus.NewDesc(metricPrefix+"namespace_matched_total", "", []string{"namespace"}, nil),
+			prometheus.CounterValue,
+			float64(v.Matched), v.Namespace,
+		)
+	}
+	for k, v := range ts.TopicFilters {
+		if k >= maxFilters {
+			break
+		}
+		m <- prometheus.MustNewConstMetric(
+			prometheus.NewDesc(metricPrefix+"topic_filter_subscribers_current", "", []string{"topic_filter"}, nil),
+			prometheus.GaugeValue,
+			float64(v.Subscribers), v.TopicFilter,
+		)
+		m <- prometheus.MustNewConstMetric(
+			prometheus.NewDesc(metricPrefix+"topic_filter_matched_total", "", []string{"topic_filter"}, nil),
+			prometheus.CounterValue,
+			float64(v.Matched), v.TopicFilter,
+		)
+	}
 }
 
 func collectPacketsStats(ps *server.PacketStats, m chan<- prometheus.Metric) {
@@ -310,6 +379,21 @@ func collectClientStats(c *server.ConnectionStats, m chan<- prometheus.Metric) {
 		prometheus.CounterValue,
 		float64(atomic.LoadUint64(&c.DisconnectedTotal)),
 	)
+	m <- prometheus.MustNewConstMetric(
+		prometheus.NewDesc(metricPrefix+"auth_failed_total", "", nil, nil),
+		prometheus.CounterValue,
+		float64(atomic.LoadUint64(&c.AuthFailedTotal)),
+	)
+	m <- prometheus.MustNewConstMetric(
+		prometheus.NewDesc(metricPrefix+"auth_lockout_total", "", nil, nil),
+		prometheus.CounterValue,
+		float64(atomic.LoadUint64(&c.AuthLockoutTotal)),
+	)
+	m <- prometheus.MustNewConstMetric(
+		prometheus.NewDesc(metricPrefix+"handshake_rejected_total", "", nil, nil),
+		prometheus.CounterValue,
+		float64(atomic.LoadUint64(&c.HandshakeRejectedTotal)),
+	)
 }
 func collectMessageStats(ms *server.MessageStats, m chan<- prometheus.Metric) {
 	collectMessageStatsDropped(ms, m)
@@ -341,6 +425,48 @@ func collectQoSDropped(metricName string, qos string, stats *server.MessageQosSt
 		prometheus.CounterValue,
 		float64(atomic.LoadUint64(&stats.DroppedTotal.ExceedsMaxPacketSize)), qos, "exceeds_max_size",
 	)
+
+	m <- prometheus.MustNewConstMetric(
+		prometheus.NewDesc(metricName, "", []string{"qos", "type"}, nil),
+		prometheus.CounterValue,
+		float64(atomic.LoadUint64(&stats.DroppedTotal.Overloaded)), qos, "overloaded",
+	)
+
+	m <- prometheus.MustNewConstMetric(
+		prometheus.NewDesc(metricName, "", []string{"qos", "type"}, nil),
+		prometheus.CounterValue,
+		float64(atomic.LoadUint64(&stats.DroppedTotal.InflightExpired)), qos, "inflight_expired",
+	)
+
+	m <- prometheus.MustNewConstMetric(
+		prometheus.NewDesc(metricName, "", []string{"qos", "type"}, nil),
+		prometheus.CounterValue,
+		float64(atomic.LoadUint64(&stats.DroppedTotal.Qos0NotQueued)), qos, "qos0_not_queued",
+	)
+
+	m <- prometheus.MustNewConstMetric(
+		prometheus.NewDesc(metricName, "", []string{"qos", "type"}, nil),
+		prometheus.CounterValue,
+		float64(atomic.LoadUint64(&stats.DroppedTotal.NoSubscriber)), qos, "no_subscriber",
+	)
+
+	m <- prometheus.MustNewConstMetric(
+		prometheus.NewDesc(metricName, "", []string{"qos", "type"}, nil),
+		prometheus.CounterValue,
+		float64(atomic.LoadUint64(&stats.DroppedTotal.Incompatible)), qos, "incompatible",
+	)
+
+	m <- prometheus.MustNewConstMetric(
+		prometheus.NewDesc(metricName, "", []string{"qos", "type"}, nil),
+		prometheus.CounterValue,
+		float64(atomic.LoadUint64(&stats.DroppedTotal.RedeliveryExhausted)), qos, "redelivery_exhausted",
+	)
+
+	m <- prometheus.MustNewConstMetric(
+		prometheus.NewDesc(metricName, "", []string{"qos", "type"}, nil),
+		prometheus.CounterValue,
+		float64(atomic.LoadUint64(&stats.DroppedTotal.Blackhole)), qos, "blackhole",
+	)
 }
 
 func collectMessageStatsDropped(ms *server.MessageStats, m chan<- prometheus.Metric) {
@@ -357,6 +483,11 @@ func collectMessageStatsQueued(ms *server.MessageStats, m chan<- prometheus.Metr
 		prometheus.GaugeValue,
 		float64(atomic.LoadUint64(&ms.QueuedCurrent)),
 	)
+	m <- prometheus.MustNewConstMetric(
+		prometheus.NewDesc(metricPrefix+"messages_offline_queued_total", "", nil, nil),
+		prometheus.CounterValue,
+		float64(atomic.LoadUint64(&ms.OfflineQueuedTotal)),
+	)
 }
 func collectMessageStatsReceived(ms *server.MessageStats, m chan<- prometheus.Metric) {
 	metricName := metricPrefix + "messages_received_total"
@@ -407,3 +538,138 @@ func collectSubscriptionStats(s *subscription.Stats, m chan<- prometheus.Metric)
 		float64(atomic.LoadUint64(&s.SubscriptionsCurrent)),
 	)
 }
+
+func collectRegistryStats(r *server.RegistryStats, m chan<- prometheus.Metric) {
+	m <- prometheus.MustNewConstMetric(
+		prometheus.NewDesc(metricPrefix+"registry_shards", "", nil, nil),
+		prometheus.GaugeValue,
+		float64(r.Shards),
+	)
+	m <- prometheus.MustNewConstMetric(
+		prometheus.NewDesc(metricPrefix+"registry_lock_acquired_total", "", nil, nil),
+		prometheus.CounterValue,
+		float64(r.LockAcquiredTotal),
+	)
+	m <- prometheus.MustNewConstMetric(
+		prometheus.NewDesc(metricPrefix+"registry_lock_contended_total", "", nil, nil),
+		prometheus.CounterValue,
+		float64(r.LockContendedTotal),
+	)
+	m <- prometheus.MustNewConstMetric(
+		prometheus.NewDesc(metricPrefix+"registry_lock_wait_seconds_total", "", nil, nil),
+		prometheus.CounterValue,
+		r.LockWaitSeconds,
+	)
+}
+
+func collectLifetimeStats(st *server.GlobalStats, m chan<- prometheus.Metric) {
+	m <- prometheus.MustNewConstMetric(
+		prometheus.NewDesc(metricPrefix+"start_time_seconds", "", nil, nil),
+		prometheus.GaugeValue,
+		float64(st.StartedAt.Unix()),
+	)
+	l := st.Lifetime
+	if l == nil {
+		return
+	}
+	prefix := metricPrefix + "lifetime_"
+	m <- prometheus.MustNewConstMetric(
+		prometheus.NewDesc(prefix+"start_time_seconds", "", nil, nil),
+		prometheus.GaugeValue,
+		float64(l.Since.Unix()),
+	)
+	m <- prometheus.MustNewConstMetric(
+		prometheus.NewDesc(prefix+"clients_connected_total", "", nil, nil),
+		prometheus.CounterValue,
+		float64(l.ConnectionStats.ConnectedTotal),
+	)
+	m <- prometheus.MustNewConstMetric(
+		prometheus.NewDesc(prefix+"clients_disconnected_total", "", nil, nil),
+		prometheus.CounterValue,
+		float64(l.ConnectionStats.DisconnectedTotal),
+	)
+	m <- prometheus.MustNewConstMetric(
+		prometheus.NewDesc(prefix+"sessions_created_total", "", nil, nil),
+		prometheus.CounterValue,
+		float64(l.ConnectionStats.SessionCreatedTotal),
+	)
+	m <- prometheus.MustNewConstMetric(
+		prometheus.NewDesc(prefix+"packets_received_total", "", nil, nil),
+		prometheus.CounterValue,
+		float64(l.PacketStats.ReceivedTotal.Total),
+	)
+	m <- prometheus.MustNewConstMetric(
+		prometheus.NewDesc(prefix+"packets_received_bytes_total", "", nil, nil),
+		prometheus.CounterValue,
+		float64(l.PacketStats.BytesReceived.Total),
+	)
+	m <- prometheus.MustNewConstMetric(
+		prometheus.NewDesc(prefix+"packets_sent_total", "", nil, nil),
+		prometheus.CounterValue,
+		float64(l.PacketStats.SentTotal.Total),
+	)
+	m <- prometheus.MustNewConstMetric(
+		prometheus.NewDesc(prefix+"packets_sent_bytes_total", "", nil, nil),
+		prometheus.CounterValue,
+		float64(l.PacketStats.BytesSent.Total),
+	)
+	for qos, v := range []*server.MessageQosStats{&l.MessageStats.Qos0, &l.MessageStats.Qos1, &l.MessageStats.Qos2} {
+		m <- prometheus.MustNewConstMetric(
+			prometheus.NewDesc(prefix+"messages_received_total", "", []string{"qos"}, nil),
+			prometheus.CounterValue,
+			float64(v.ReceivedTotal), strconv.Itoa(qos),
+		)
+		m <- prometheus.MustNewConstMetric(
+			prometheus.NewDesc(prefix+"messages_sent_total", "", []string{"qos"}, nil),
+			prometheus.CounterValue,
+			float64(v.SentTotal), strconv.Itoa(qos),
+		)
+		m <- prometheus.MustNewConstMetric(
+			prometheus.NewDesc(prefix+"messages_dropped_total", "", []string{"qos"}, nil),
+			prometheus.CounterValue,
+			float64(v.GetDroppedTotal()), strconv.Itoa(qos),
+		)
+	}
+}
+
+func collectHookStats(st *server.GlobalStats, m chan<- prometheus.Metric) {
+	for _, v := range st.HookStats {
+		buckets := make(map[float64]uint64, len(server.HookLatencyBuckets))
+		for i, b := range server.HookLatencyBuckets {
+			buckets[b] = v.Buckets[i]
+		}
+		m <- prometheus.MustNewConstHistogram(
+			prometheus.NewDesc(metricPrefix+"hook_latency_seconds", "", []string{"plugin", "hook"}, nil),
+			v.Count,
+			v.SumSeconds,
+			buckets,
+			v.Plugin, v.Hook,
+		)
+	}
+	for hook, v := range st.HookTimeoutTotal {
+		m <- prometheus.MustNewConstMetric(
+			prometheus.NewDesc(metricPrefix+"hook_timeouts_total", "", []string{"hook"}, nil),
+			prometheus.CounterValue,
+			float64(v),
+			hook,
+		)
+	}
+	for _, v := range st.PluginFailures {
+		m <- prometheus.MustNewConstMetric(
+			prometheus.NewDesc(metricPrefix+"plugin_panics_total", "", []string{"plugin"}, nil),
+			prometheus.CounterValue,
+			float64(v.PanicTotal),
+			v.Plugin,
+		)
+		var disabled float64
+		if v.Disabled {
+			disabled = 1
+		}
+		m <- prometheus.MustNewConstMetric(
+			prometheus.NewDesc(metricPrefix+"plugin_disabled", "", []string{"plugin"}, nil),
+			prometheus.GaugeValue,
+			disabled,
+			v.Plugin,
+		)
+	}
+}
@@ -0,0 +1,87 @@
+package psk
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Config is the configuration for the psk plugin.
+type Config struct {
+	// Store is the name of the store of the PSK identities, "file" and "redis" are bundled.
+	// Other implementations can be registered by RegisterStore.
+	Store string `yaml:"store"`
+	// File is the configuration of the file store.
+	File FileConfig `yaml:"file"`
+	// Redis is the configuration of the redis store.
+	Redis RedisConfig `yaml:"redis"`
+}
+
+// FileConfig is the configuration for the file store.
+type FileConfig struct {
+	// Path is the path of the YAML file that contains the identities and the hex encoded keys.
+	// The relative path locates in the same directory as the config file.
+	Path string `yaml:"path"`
+}
+
+// RedisConfig is the configuration for the redis store.
+type RedisConfig struct {
+	Addr     string `yaml:"addr"`
+	Password string `yaml:"password"`
+	Database uint   `yaml:"database"`
+	// Key is the redis hash that maps the identities to the hex encoded keys.
+	Key string `yaml:"key"`
+	// Timeout is the timeout of connecting to redis and the lookups.
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// Validate validates the configuration, and return an error if it is invalid.
+func (c *Config) Validate() error {
+	if _, ok := stores[c.Store]; !ok {
+		return fmt.Errorf("invalid store: %s", c.Store)
+	}
+	switch c.Store {
+	case StoreFile:
+		if c.File.Path == "" {
+			return errors.New("invalid file path: cannot be empty")
+		}
+	case StoreRedis:
+		if c.Redis.Addr == "" {
+			return errors.New("invalid redis addr: cannot be empty")
+		}
+		if c.Redis.Key == "" {
+			return errors.New("invalid redis key: cannot be empty")
+		}
+		if c.Redis.Timeout <= 0 {
+			return errors.New("invalid redis timeout: must be greater than 0")
+		}
+	}
+	return nil
+}
+
+// DefaultConfig is the default configuration.
+var DefaultConfig = Config{
+	Store: StoreFile,
+	File: FileConfig{
+		Path: "./gmqtt_psk.yml",
+	},
+	Redis: RedisConfig{
+		Addr:    "127.0.0.1:6379",
+		Key:     "gmqtt:psk",
+		Timeout: 5 * time.Second,
+	},
+}
+
+func (c *Config) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type cfg Config
+	var v = &struct {
+		PSK cfg `yaml:"psk"`
+	}{
+		PSK: cfg(DefaultConfig),
+	}
+	if err := unmarshal(v); err != nil {
+		return err
+	}
+	*c = Config(v.PSK)
+	return nil
+}
@@ -0,0 +1,29 @@
+package psk
+
+import (
+	"context"
+
+	"go.uber.org/zap"
+
+	"github.com/DrmagicE/gmqtt/server"
+)
+
+func (p *PSK) HookWrapper() server.HookWrapper {
+	return server.HookWrapper{
+		OnPSKLookupWrapper: p.OnPSKLookupWrapper,
+	}
+}
+
+func (p *PSK) OnPSKLookupWrapper(pre server.OnPSKLookup) server.OnPSKLookup {
+	return func(ctx context.Context, identity string) ([]byte, error) {
+		key, err := p.store.Lookup(ctx, identity)
+		if err != nil {
+			log.Error("failed to lookup psk identity", zap.String("identity", identity), zap.Error(err))
+			return nil, err
+		}
+		if key == nil {
+			return pre(ctx, identity)
+		}
+		return key, nil
+	}
+}
@@ -0,0 +1,55 @@
+package psk
+
+import (
+	"path"
+
+	"go.uber.org/zap"
+
+	"github.com/DrmagicE/gmqtt/config"
+	"github.com/DrmagicE/gmqtt/server"
+)
+
+var _ server.Plugin = (*PSK)(nil)
+
+const Name = "psk"
+
+func init() {
+	server.RegisterPlugin(Name, New)
+	config.RegisterDefaultPluginConfig(Name, &DefaultConfig)
+}
+
+func New(config config.Config) (server.Plugin, error) {
+	cfg := *config.Plugins[Name].(*Config)
+	if !path.IsAbs(cfg.File.Path) {
+		cfg.File.Path = path.Join(config.ConfigDir, cfg.File.Path)
+	}
+	store, err := stores[cfg.Store](&cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &PSK{
+		config: &cfg,
+		store:  store,
+	}, nil
+}
+
+var log *zap.Logger
+
+// PSK resolves the PSK identities of the TLS-PSK listeners to the keys from the configured store.
+type PSK struct {
+	config *Config
+	store  Store
+}
+
+func (p *PSK) Load(service server.Server) error {
+	log = server.LoggerWithField(zap.String("plugin", Name))
+	return nil
+}
+
+func (p *PSK) Unload() error {
+	return p.store.Close()
+}
+
+func (p *PSK) Name() string {
+	return Name
+}
@@ -0,0 +1,200 @@
+package psk
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func init() {
+	log = zap.NewNop()
+}
+
+func TestConfig_Validate(t *testing.T) {
+	a := assert.New(t)
+	cfg := DefaultConfig
+	a.NoError(cfg.Validate())
+
+	cfg.Store = "unknown"
+	a.Error(cfg.Validate())
+
+	cfg = DefaultConfig
+	cfg.File.Path = ""
+	a.Error(cfg.Validate())
+
+	cfg = DefaultConfig
+	cfg.Store = StoreRedis
+	cfg.Redis.Key = ""
+	a.Error(cfg.Validate())
+
+	cfg = DefaultConfig
+	cfg.Store = StoreRedis
+	cfg.Redis.Timeout = 0
+	a.Error(cfg.Validate())
+}
+
+func TestFileStore(t *testing.T) {
+	a := assert.New(t)
+	dir, err := ioutil.TempDir("", "psk")
+	a.NoError(err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "psk.yml")
+	a.NoError(ioutil.WriteFile(file, []byte("dev1: 30313233\n"), 0600))
+
+	s, err := newFileStore(&Config{File: FileConfig{Path: file}})
+	a.NoError(err)
+	key, err := s.Lookup(context.Background(), "dev1")
+	a.NoError(err)
+	a.Equal([]byte("0123"), key)
+	key, err = s.Lookup(context.Background(), "dev2")
+	a.NoError(err)
+	a.Nil(key)
+
+	a.NoError(ioutil.WriteFile(file, []byte("dev1: xyz\n"), 0600))
+	_, err = newFileStore(&Config{File: FileConfig{Path: file}})
+	a.Error(err)
+}
+
+// fakeRedis serves HGET of the hash over the RESP protocol.
+func fakeRedis(t *testing.T, hash map[string]string) net.Listener {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				for {
+					args, err := readCommand(r)
+					if err != nil {
+						return
+					}
+					if strings.ToUpper(args[0]) != "HGET" || len(args) != 3 {
+						fmt.Fprint(conn, "+OK\r\n")
+						continue
+					}
+					if v, ok := hash[args[1]+"/"+args[2]]; ok {
+						fmt.Fprintf(conn, "$%d\r\n%s\r\n", len(v), v)
+					} else {
+						fmt.Fprint(conn, "$-1\r\n")
+					}
+				}
+			}()
+		}
+	}()
+	return ln
+}
+
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+	if err != nil || line[0] != '*' || n == 0 {
+		return nil, errors.New("invalid command")
+	}
+	args := make([]string, n)
+	for i := range args {
+		if _, err = r.ReadString('\n'); err != nil {
+			return nil, err
+		}
+		if args[i], err = r.ReadString('\n'); err != nil {
+			return nil, err
+		}
+		args[i] = strings.TrimSpace(args[i])
+	}
+	return args, nil
+}
+
+func TestRedisStore(t *testing.T) {
+	a := assert.New(t)
+	ln := fakeRedis(t, map[string]string{
+		"gmqtt:psk/dev1": "30313233",
+		"gmqtt:psk/dev2": "xyz",
+	})
+	defer ln.Close()
+	cfg := DefaultConfig
+	cfg.Store = StoreRedis
+	cfg.Redis.Addr = ln.Addr().String()
+	s, err := newRedisStore(&cfg)
+	a.NoError(err)
+	defer s.Close()
+
+	key, err := s.Lookup(context.Background(), "dev1")
+	a.NoError(err)
+	a.Equal([]byte("0123"), key)
+	key, err = s.Lookup(context.Background(), "dev3")
+	a.NoError(err)
+	a.Nil(key)
+	_, err = s.Lookup(context.Background(), "dev2")
+	a.Error(err)
+
+	ln.Close()
+	cfg.Redis.Addr = "127.0.0.1:1"
+	cfg.Redis.Timeout = time.Second
+	s, err = newRedisStore(&cfg)
+	a.NoError(err)
+	defer s.Close()
+	_, err = s.Lookup(context.Background(), "dev1")
+	a.Error(err)
+}
+
+type fakeStore struct {
+	keys map[string][]byte
+	err  error
+}
+
+func (f *fakeStore) Lookup(ctx context.Context, identity string) ([]byte, error) {
+	return f.keys[identity], f.err
+}
+
+func (f *fakeStore) Close() error {
+	return nil
+}
+
+func TestPSK_OnPSKLookupWrapper(t *testing.T) {
+	a := assert.New(t)
+	store := &fakeStore{keys: map[string][]byte{"dev1": []byte("0123")}}
+	p := &PSK{config: &DefaultConfig, store: store}
+	lookup := p.OnPSKLookupWrapper(func(ctx context.Context, identity string) ([]byte, error) {
+		if identity == "dev2" {
+			return []byte("4567"), nil
+		}
+		return nil, nil
+	})
+	key, err := lookup(context.Background(), "dev1")
+	a.NoError(err)
+	a.Equal([]byte("0123"), key)
+
+	// fallback to the next plugin if not found.
+	key, err = lookup(context.Background(), "dev2")
+	a.NoError(err)
+	a.Equal([]byte("4567"), key)
+
+	key, err = lookup(context.Background(), "dev3")
+	a.NoError(err)
+	a.Nil(key)
+
+	store.err = errors.New("store unavailable")
+	_, err = lookup(context.Background(), "dev1")
+	a.Error(err)
+}
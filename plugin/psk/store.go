@@ -0,0 +1,130 @@
+package psk
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/gomodule/redigo/redis"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// StoreFile is the name of the bundled file store.
+	StoreFile = "file"
+	// StoreRedis is the name of the bundled redis store.
+	StoreRedis = "redis"
+)
+
+// Store stores the pre-shared keys of the identities.
+type Store interface {
+	// Lookup returns the key of the identity, or nil if the identity is not found.
+	// The error indicates the store is unavailable.
+	Lookup(ctx context.Context, identity string) ([]byte, error)
+	// Close releases the resources of the store.
+	Close() error
+}
+
+// NewStore creates a Store from the plugin config.
+type NewStore func(config *Config) (Store, error)
+
+var stores = map[string]NewStore{
+	StoreFile:  newFileStore,
+	StoreRedis: newRedisStore,
+}
+
+// RegisterStore registers a Store implementation with the name, which can be used in the store config.
+// It is not thread-safe and should be called in init function.
+func RegisterStore(name string, new NewStore) {
+	if _, ok := stores[name]; ok {
+		panic(fmt.Sprintf("duplicated store: %s", name))
+	}
+	stores[name] = new
+}
+
+func decodeKey(identity, key string) ([]byte, error) {
+	b, err := hex.DecodeString(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key of identity %s: %s", identity, err)
+	}
+	return b, nil
+}
+
+// fileStore loads the identities from the YAML file which maps the identities to the hex encoded keys.
+type fileStore struct {
+	keys map[string][]byte
+}
+
+func newFileStore(config *Config) (Store, error) {
+	b, err := ioutil.ReadFile(config.File.Path)
+	if err != nil {
+		return nil, err
+	}
+	m := make(map[string]string)
+	if err = yaml.Unmarshal(b, &m); err != nil {
+		return nil, err
+	}
+	s := &fileStore{
+		keys: make(map[string][]byte, len(m)),
+	}
+	for identity, key := range m {
+		if s.keys[identity], err = decodeKey(identity, key); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func (f *fileStore) Lookup(ctx context.Context, identity string) ([]byte, error) {
+	return f.keys[identity], nil
+}
+
+func (f *fileStore) Close() error {
+	return nil
+}
+
+// redisStore fetches the hex encoded key by HGET <key> <identity>.
+type redisStore struct {
+	key  string
+	pool *redis.Pool
+}
+
+func newRedisStore(config *Config) (Store, error) {
+	c := config.Redis
+	return &redisStore{
+		key: c.Key,
+		pool: &redis.Pool{
+			Dial: func() (redis.Conn, error) {
+				return redis.Dial("tcp", c.Addr,
+					redis.DialPassword(c.Password),
+					redis.DialDatabase(int(c.Database)),
+					redis.DialConnectTimeout(c.Timeout),
+					redis.DialReadTimeout(c.Timeout),
+					redis.DialWriteTimeout(c.Timeout),
+				)
+			},
+			MaxIdle: 10,
+		},
+	}, nil
+}
+
+func (r *redisStore) Lookup(ctx context.Context, identity string) ([]byte, error) {
+	c, err := r.pool.GetContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+	key, err := redis.String(c.Do("HGET", r.key, identity))
+	if err == redis.ErrNil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return decodeKey(identity, key)
+}
+
+func (r *redisStore) Close() error {
+	return r.pool.Close()
+}
@@ -0,0 +1,92 @@
+package scheduler
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/DrmagicE/gmqtt/pkg/packets"
+)
+
+// Config is the configuration for the scheduler plugin.
+type Config struct {
+	// Schedules is the list of the schedules defined in the configuration file, they can not be modified by the API.
+	Schedules []ScheduleConfig `yaml:"schedules"`
+	// ScheduleFile is the file to persist the schedules which are created by the API.
+	// If it is a relative path, it locates in the same directory as the config file.
+	ScheduleFile string `yaml:"schedule_file"`
+	// Location is the time zone of the cron expressions, e.g. "UTC", "Asia/Shanghai".
+	// Empty means the local time zone.
+	Location string `yaml:"location"`
+}
+
+// ScheduleConfig is a message which is published on the cron expression.
+type ScheduleConfig struct {
+	// Name is the unique name of the schedule.
+	Name string `yaml:"name"`
+	// Cron is the cron expression, e.g. "0 * * * *", "@daily" or "@every 30s".
+	Cron          string `yaml:"cron"`
+	Topic         string `yaml:"topic"`
+	Payload       string `yaml:"payload"`
+	QoS           uint8  `yaml:"qos"`
+	Retained      bool   `yaml:"retained"`
+	ContentType   string `yaml:"content_type,omitempty"`
+	MessageExpiry uint32 `yaml:"message_expiry,omitempty"`
+}
+
+func (s *ScheduleConfig) validate() error {
+	if s.Name == "" || strings.Contains(s.Name, "/") {
+		return errors.New("invalid name: cannot be empty or contain /")
+	}
+	if _, err := parseCron(s.Cron); err != nil {
+		return fmt.Errorf("invalid cron: %s", err)
+	}
+	if !packets.ValidTopicName(true, []byte(s.Topic)) {
+		return fmt.Errorf("invalid topic: %s", s.Topic)
+	}
+	if s.QoS > packets.Qos2 {
+		return fmt.Errorf("invalid qos: %d", s.QoS)
+	}
+	return nil
+}
+
+// Validate validates the configuration, and return an error if it is invalid.
+func (c *Config) Validate() error {
+	names := make(map[string]struct{})
+	for k := range c.Schedules {
+		if err := c.Schedules[k].validate(); err != nil {
+			return fmt.Errorf("schedule %s: %s", c.Schedules[k].Name, err)
+		}
+		if _, ok := names[c.Schedules[k].Name]; ok {
+			return fmt.Errorf("duplicated schedule: %s", c.Schedules[k].Name)
+		}
+		names[c.Schedules[k].Name] = struct{}{}
+	}
+	if c.ScheduleFile == "" {
+		return errors.New("schedule_file must be set")
+	}
+	if _, err := time.LoadLocation(c.Location); err != nil {
+		return fmt.Errorf("invalid location: %s", err)
+	}
+	return nil
+}
+
+// DefaultConfig is the default configuration.
+var DefaultConfig = Config{
+	ScheduleFile: "./gmqtt_schedules.yml",
+}
+
+func (c *Config) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type cfg Config
+	var v = &struct {
+		Scheduler cfg `yaml:"scheduler"`
+	}{
+		Scheduler: cfg(DefaultConfig),
+	}
+	if err := unmarshal(v); err != nil {
+		return err
+	}
+	*c = Config(v.Scheduler)
+	return nil
+}
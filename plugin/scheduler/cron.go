@@ -0,0 +1,226 @@
+package scheduler
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// minEvery is the minimum interval of the "@every" expressions.
+const minEvery = time.Second
+
+// cronSpec is the parsed cron expression, each field is a bitmask of the allowed values.
+type cronSpec struct {
+	minute, hour, dom, month, dow uint64
+	// domStar and dowStar indicate whether the day of month or the day of week field starts with "*".
+	// If both fields are restricted, the day matches if either of them matches.
+	domStar, dowStar bool
+	// every is the fixed interval of the "@every" expressions.
+	every time.Duration
+}
+
+type bounds struct {
+	min, max int
+	names    map[string]int
+}
+
+var (
+	minuteBounds = bounds{min: 0, max: 59}
+	hourBounds   = bounds{min: 0, max: 23}
+	domBounds    = bounds{min: 1, max: 31}
+	monthBounds  = bounds{min: 1, max: 12, names: map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}}
+	// 7 is also Sunday.
+	dowBounds = bounds{min: 0, max: 7, names: map[string]int{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}}
+)
+
+var descriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// parseCron parses the standard 5-field cron expression: minute, hour, day of month, month and day of week.
+// Each field supports "*", lists, ranges and steps, e.g. "*/15", "1-5", "mon,wed,fri".
+// The descriptors: @yearly, @annually, @monthly, @weekly, @daily, @midnight, @hourly and "@every <duration>" are also supported.
+func parseCron(expr string) (*cronSpec, error) {
+	expr = strings.TrimSpace(expr)
+	if strings.HasPrefix(expr, "@every ") {
+		d, err := time.ParseDuration(strings.TrimSpace(expr[len("@every "):]))
+		if err != nil {
+			return nil, err
+		}
+		if d < minEvery {
+			return nil, fmt.Errorf("the interval must be at least %s", minEvery)
+		}
+		return &cronSpec{every: d}, nil
+	}
+	if d, ok := descriptors[expr]; ok {
+		expr = d
+	} else if strings.HasPrefix(expr, "@") {
+		return nil, fmt.Errorf("unknown descriptor: %s", expr)
+	}
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("expected 5 fields, got %d", len(fields))
+	}
+	var err error
+	c := &cronSpec{
+		domStar: strings.HasPrefix(fields[2], "*"),
+		dowStar: strings.HasPrefix(fields[4], "*"),
+	}
+	for k, v := range []struct {
+		name   string
+		bits   *uint64
+		bounds bounds
+	}{
+		{"minute", &c.minute, minuteBounds},
+		{"hour", &c.hour, hourBounds},
+		{"day of month", &c.dom, domBounds},
+		{"month", &c.month, monthBounds},
+		{"day of week", &c.dow, dowBounds},
+	} {
+		*v.bits, err = parseField(fields[k], v.bounds)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %s", v.name, err)
+		}
+	}
+	if c.dow&(1<<7) != 0 {
+		c.dow |= 1
+	}
+	return c, nil
+}
+
+func parseField(field string, b bounds) (uint64, error) {
+	var bits uint64
+	for _, v := range strings.Split(field, ",") {
+		rng, step := v, 1
+		if i := strings.IndexByte(v, '/'); i != -1 {
+			rng = v[:i]
+			s, err := strconv.Atoi(v[i+1:])
+			if err != nil || s <= 0 {
+				return 0, fmt.Errorf("invalid step: %s", v)
+			}
+			step = s
+		}
+		start, end := b.min, b.max
+		switch {
+		case rng == "*":
+		case strings.IndexByte(rng, '-') != -1:
+			i := strings.IndexByte(rng, '-')
+			var err error
+			if start, err = parseValue(rng[:i], b); err != nil {
+				return 0, err
+			}
+			if end, err = parseValue(rng[i+1:], b); err != nil {
+				return 0, err
+			}
+			if start > end {
+				return 0, fmt.Errorf("invalid range: %s", rng)
+			}
+		default:
+			var err error
+			if start, err = parseValue(rng, b); err != nil {
+				return 0, err
+			}
+			// "n/step" means from n to the maximum value.
+			if rng == v {
+				end = start
+			}
+		}
+		for i := start; i <= end; i += step {
+			bits |= 1 << uint(i)
+		}
+	}
+	return bits, nil
+}
+
+func parseValue(s string, b bounds) (int, error) {
+	if v, ok := b.names[strings.ToLower(s)]; ok {
+		return v, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value: %s", s)
+	}
+	if v < b.min || v > b.max {
+		return 0, fmt.Errorf("value %d out of range [%d, %d]", v, b.min, b.max)
+	}
+	return v, nil
+}
+
+var errNoNextRun = errors.New("the cron expression never matches")
+
+func (c *cronSpec) dayMatches(t time.Time) bool {
+	domMatch := c.dom&(1<<uint(t.Day())) != 0
+	dowMatch := c.dow&(1<<uint(t.Weekday())) != 0
+	if c.domStar || c.dowStar {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
+
+// next returns the next activation time after t, in the location of t.
+// It returns errNoNextRun if the expression does not match in 5 years, e.g. "0 0 30 2 *".
+func (c *cronSpec) next(t time.Time) (time.Time, error) {
+	if c.every != 0 {
+		return t.Add(c.every), nil
+	}
+	loc := t.Location()
+	t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), 0, 0, loc).Add(time.Minute)
+	yearLimit := t.Year() + 5
+	// added indicates whether a field has been incremented, the lower fields are reset to their minimum values at the first increment.
+	added := false
+WRAP:
+	if t.Year() > yearLimit {
+		return time.Time{}, errNoNextRun
+	}
+	for c.month&(1<<uint(t.Month())) == 0 {
+		if !added {
+			added = true
+			t = time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, loc)
+		}
+		t = t.AddDate(0, 1, 0)
+		if t.Month() == time.January {
+			goto WRAP
+		}
+	}
+	for !c.dayMatches(t) {
+		if !added {
+			added = true
+			t = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+		}
+		t = t.AddDate(0, 0, 1)
+		if t.Day() == 1 {
+			goto WRAP
+		}
+	}
+	for c.hour&(1<<uint(t.Hour())) == 0 {
+		if !added {
+			added = true
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, loc)
+		}
+		t = t.Add(time.Hour)
+		if t.Hour() == 0 {
+			goto WRAP
+		}
+	}
+	for c.minute&(1<<uint(t.Minute())) == 0 {
+		added = true
+		t = t.Add(time.Minute)
+		if t.Minute() == 0 {
+			goto WRAP
+		}
+	}
+	return t, nil
+}
@@ -0,0 +1,70 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseCron_Invalid(t *testing.T) {
+	for _, v := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"5-1 * * * *",
+		"a * * * *",
+		"@unknown",
+		"@every 1ms",
+		"@every abc",
+	} {
+		_, err := parseCron(v)
+		assert.Error(t, err, v)
+	}
+}
+
+func TestCronSpec_Next(t *testing.T) {
+	a := assert.New(t)
+	// Friday
+	now := time.Date(2021, 1, 1, 10, 30, 15, 0, time.UTC)
+	for _, v := range []struct {
+		expr string
+		next time.Time
+	}{
+		{"* * * * *", time.Date(2021, 1, 1, 10, 31, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2021, 1, 1, 10, 45, 0, 0, time.UTC)},
+		{"30 * * * *", time.Date(2021, 1, 1, 11, 30, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2021, 1, 1, 11, 0, 0, 0, time.UTC)},
+		{"@daily", time.Date(2021, 1, 2, 0, 0, 0, 0, time.UTC)},
+		{"@weekly", time.Date(2021, 1, 3, 0, 0, 0, 0, time.UTC)},
+		{"@monthly", time.Date(2021, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{"@yearly", time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 9 * * mon-fri", time.Date(2021, 1, 4, 9, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2021, 1, 3, 0, 0, 0, 0, time.UTC)},
+		{"0 12 1,15 * *", time.Date(2021, 1, 1, 12, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 8 10/10 jun *", time.Date(2021, 6, 10, 8, 0, 0, 0, time.UTC)},
+		// either the day of month or the day of week matches.
+		{"0 0 5 * mon", time.Date(2021, 1, 4, 0, 0, 0, 0, time.UTC)},
+		{"@every 90s", time.Date(2021, 1, 1, 10, 31, 45, 0, time.UTC)},
+	} {
+		c, err := parseCron(v.expr)
+		if !a.NoError(err, v.expr) {
+			continue
+		}
+		next, err := c.next(now)
+		a.NoError(err, v.expr)
+		a.Equal(v.next, next, v.expr)
+	}
+
+	c, err := parseCron("0 0 30 2 *")
+	a.NoError(err)
+	_, err = c.next(now)
+	a.Equal(errNoNextRun, err)
+}
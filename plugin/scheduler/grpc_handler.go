@@ -0,0 +1,118 @@
+package scheduler
+
+import (
+	"container/list"
+	"context"
+
+	"github.com/golang/protobuf/ptypes/empty"
+	"go.uber.org/zap"
+
+	"github.com/DrmagicE/gmqtt/plugin/admin"
+)
+
+var errReadOnly = admin.ErrInvalidArgument("name", "the schedule is defined in the configuration file")
+
+// List lists all schedules
+func (s *Scheduler) List(ctx context.Context, req *ListSchedulesRequest) (resp *ListSchedulesResponse, err error) {
+	page, pageSize := admin.GetPage(req.Page, req.PageSize)
+	offset, n := admin.GetOffsetN(page, pageSize)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	resp = &ListSchedulesResponse{
+		Schedules: []*Schedule{},
+	}
+	s.indexer.Iterate(func(elem *list.Element) {
+		resp.Schedules = append(resp.Schedules, elem.Value.(*entry).proto())
+	}, offset, n)
+	resp.TotalCount = uint32(s.indexer.Len())
+	return resp, nil
+}
+
+// Get gets the schedule for given name.
+// Return NotFound error when schedule not found.
+func (s *Scheduler) Get(ctx context.Context, req *GetScheduleRequest) (resp *GetScheduleResponse, err error) {
+	if req.Name == "" {
+		return nil, admin.ErrInvalidArgument("name", "cannot be empty")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e := s.indexer.GetByID(req.Name); e != nil {
+		return &GetScheduleResponse{Schedule: e.Value.(*entry).proto()}, nil
+	}
+	return nil, admin.ErrNotFound
+}
+
+// Update updates the schedule.
+// Create a new schedule if the schedule for the name is not exists.
+// Update will persist the schedule to the schedule file.
+func (s *Scheduler) Update(ctx context.Context, req *UpdateScheduleRequest) (resp *empty.Empty, err error) {
+	cfg := ScheduleConfig{
+		Name:          req.Name,
+		Cron:          req.Cron,
+		Topic:         req.TopicName,
+		Payload:       req.Payload,
+		QoS:           uint8(req.Qos),
+		Retained:      req.Retained,
+		ContentType:   req.ContentType,
+		MessageExpiry: req.MessageExpiry,
+	}
+	if req.Qos > 2 {
+		return nil, admin.ErrInvalidArgument("qos", "")
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, admin.ErrInvalidArgument("schedule", err.Error())
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var old *entry
+	if elem := s.indexer.GetByID(req.Name); elem != nil {
+		old = elem.Value.(*entry)
+		if old.readOnly {
+			return nil, errReadOnly
+		}
+	}
+	s.startLocked(cfg, false)
+	if err = s.saveFile(); err != nil {
+		// should rollback if failed to persist to file.
+		if old == nil {
+			s.stopLocked(req.Name)
+			s.indexer.Remove(req.Name)
+		} else {
+			s.startLocked(old.config, false)
+		}
+		return nil, err
+	}
+	if old == nil {
+		log.Info("new schedule created", zap.String("name", req.Name))
+	} else {
+		log.Info("schedule updated", zap.String("name", req.Name))
+	}
+	return &empty.Empty{}, nil
+}
+
+// Delete deletes the schedule for the name.
+func (s *Scheduler) Delete(ctx context.Context, req *DeleteScheduleRequest) (resp *empty.Empty, err error) {
+	if req.Name == "" {
+		return nil, admin.ErrInvalidArgument("name", "cannot be empty")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	elem := s.indexer.GetByID(req.Name)
+	if elem == nil {
+		// fast path
+		return &empty.Empty{}, nil
+	}
+	old := elem.Value.(*entry)
+	if old.readOnly {
+		return nil, errReadOnly
+	}
+	s.stopLocked(req.Name)
+	s.indexer.Remove(req.Name)
+	if err = s.saveFile(); err != nil {
+		// should rollback if failed to persist to file
+		s.startLocked(old.config, false)
+		return nil, err
+	}
+	log.Info("schedule deleted", zap.String("name", req.Name))
+	return &empty.Empty{}, nil
+}
@@ -0,0 +1,9 @@
+package scheduler
+
+import (
+	"github.com/DrmagicE/gmqtt/server"
+)
+
+func (s *Scheduler) HookWrapper() server.HookWrapper {
+	return server.HookWrapper{}
+}
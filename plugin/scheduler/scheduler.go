@@ -0,0 +1,271 @@
+package scheduler
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"gopkg.in/yaml.v2"
+
+	"github.com/DrmagicE/gmqtt"
+	"github.com/DrmagicE/gmqtt/config"
+	"github.com/DrmagicE/gmqtt/plugin/admin"
+	"github.com/DrmagicE/gmqtt/server"
+)
+
+var _ server.Plugin = (*Scheduler)(nil)
+
+const Name = "scheduler"
+
+func init() {
+	server.RegisterPlugin(Name, New)
+	config.RegisterDefaultPluginConfig(Name, &DefaultConfig)
+}
+
+func New(config config.Config) (server.Plugin, error) {
+	cfg := config.Plugins[Name].(*Config)
+	s := &Scheduler{
+		config:   cfg,
+		file:     cfg.ScheduleFile,
+		location: time.Local,
+		indexer:  admin.NewIndexer(),
+	}
+	if !path.IsAbs(s.file) {
+		s.file = path.Join(config.ConfigDir, s.file)
+	}
+	if cfg.Location != "" {
+		loc, err := time.LoadLocation(cfg.Location)
+		if err != nil {
+			return nil, err
+		}
+		s.location = loc
+	}
+	s.saveFile = s.saveFileHandler
+	return s, nil
+}
+
+var log *zap.Logger
+
+// entry is a running schedule.
+type entry struct {
+	config ScheduleConfig
+	spec   *cronSpec
+	// readOnly indicates whether the schedule is defined in the configuration file.
+	readOnly bool
+	// next is the next time to publish the message, guarded by Scheduler.mu.
+	next   time.Time
+	cancel context.CancelFunc
+}
+
+func (e *entry) message() *gmqtt.Message {
+	return &gmqtt.Message{
+		QoS:           e.config.QoS,
+		Retained:      e.config.Retained,
+		Topic:         e.config.Topic,
+		Payload:       []byte(e.config.Payload),
+		ContentType:   e.config.ContentType,
+		MessageExpiry: e.config.MessageExpiry,
+	}
+}
+
+func (e *entry) proto() *Schedule {
+	s := &Schedule{
+		Name:          e.config.Name,
+		Cron:          e.config.Cron,
+		TopicName:     e.config.Topic,
+		Payload:       e.config.Payload,
+		Qos:           uint32(e.config.QoS),
+		Retained:      e.config.Retained,
+		ContentType:   e.config.ContentType,
+		MessageExpiry: e.config.MessageExpiry,
+		ReadOnly:      e.readOnly,
+	}
+	if !e.next.IsZero() {
+		s.NextRun = timestamppb.New(e.next)
+	}
+	return s
+}
+
+// Scheduler publishes the configured messages on the cron expressions.
+// The schedules created by the API are persisted in the schedule file, so that they survive restarts.
+// The runs which are missed while the broker is down are not caught up.
+type Scheduler struct {
+	config    *Config
+	file      string
+	location  *time.Location
+	publisher server.Publisher
+
+	// mu guards indexer and the next field of the entries.
+	mu sync.Mutex
+	// indexer stores the *entry by the schedule name.
+	indexer *admin.Indexer
+	// saveFile persists the schedules which are created by the API, must call after mu is locked.
+	saveFile func() error
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func (s *Scheduler) mustEmbedUnimplementedScheduleServiceServer() {
+	return
+}
+
+var registerAPI = func(service server.Server, s *Scheduler) error {
+	apiRegistrar := service.APIRegistrar()
+	RegisterScheduleServiceServer(apiRegistrar, s)
+	return apiRegistrar.RegisterHTTPHandler(RegisterScheduleServiceHandlerFromEndpoint)
+}
+
+func (s *Scheduler) Load(service server.Server) error {
+	log = server.LoggerWithField(zap.String("plugin", Name))
+	s.publisher = service.Publisher()
+	if err := registerAPI(service, s); err != nil {
+		return err
+	}
+	schedules, err := s.loadFile()
+	if err != nil {
+		return err
+	}
+	dup := make(map[string]struct{})
+	for _, v := range s.config.Schedules {
+		dup[v.Name] = struct{}{}
+	}
+	for _, v := range schedules {
+		if err := v.validate(); err != nil {
+			return fmt.Errorf("invalid schedule %s in schedule file: %s", v.Name, err)
+		}
+		if _, ok := dup[v.Name]; ok {
+			return fmt.Errorf("detect duplicated schedule in schedule file: %s", v.Name)
+		}
+		dup[v.Name] = struct{}{}
+	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, v := range s.config.Schedules {
+		s.startLocked(v, true)
+	}
+	for _, v := range schedules {
+		s.startLocked(v, false)
+	}
+	log.Info("schedules loaded",
+		zap.Int("schedule_nums", s.indexer.Len()),
+		zap.String("schedule_file", s.file))
+	return nil
+}
+
+func (s *Scheduler) Unload() error {
+	if s.cancel == nil {
+		return nil
+	}
+	s.cancel()
+	s.wg.Wait()
+	return nil
+}
+
+func (s *Scheduler) Name() string {
+	return Name
+}
+
+// loadFile reads the schedules from the schedule file, the file will be created if not exists.
+func (s *Scheduler) loadFile() ([]ScheduleConfig, error) {
+	f, err := os.OpenFile(s.file, os.O_CREATE|os.O_RDONLY, 0666)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	b, err := ioutil.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	var schedules []ScheduleConfig
+	err = yaml.Unmarshal(b, &schedules)
+	return schedules, err
+}
+
+// saveFileHandler is the default handler for Scheduler.saveFile, must call after mu is locked.
+func (s *Scheduler) saveFileHandler() error {
+	var schedules []ScheduleConfig
+	s.indexer.Iterate(func(elem *list.Element) {
+		if e := elem.Value.(*entry); !e.readOnly {
+			schedules = append(schedules, e.config)
+		}
+	}, 0, uint(s.indexer.Len()))
+	b, err := yaml.Marshal(schedules)
+	if err != nil {
+		return err
+	}
+	tmpfile, err := ioutil.TempFile(filepath.Dir(s.file), "gmqtt_schedules")
+	if err != nil {
+		return err
+	}
+	_, err = tmpfile.Write(b)
+	tmpfile.Close()
+	if err != nil {
+		os.Remove(tmpfile.Name())
+		return err
+	}
+	return os.Rename(tmpfile.Name(), s.file)
+}
+
+// startLocked starts the schedule, the running schedule with the same name is stopped.
+// The schedule must be validated, must call after mu is locked.
+func (s *Scheduler) startLocked(cfg ScheduleConfig, readOnly bool) {
+	s.stopLocked(cfg.Name)
+	spec, _ := parseCron(cfg.Cron)
+	ctx, cancel := context.WithCancel(s.ctx)
+	e := &entry{
+		config:   cfg,
+		spec:     spec,
+		readOnly: readOnly,
+		cancel:   cancel,
+	}
+	s.indexer.Set(cfg.Name, e)
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.run(ctx, e)
+	}()
+}
+
+// stopLocked stops the schedule if it is running, must call after mu is locked.
+func (s *Scheduler) stopLocked(name string) {
+	if elem := s.indexer.GetByID(name); elem != nil {
+		elem.Value.(*entry).cancel()
+	}
+}
+
+// run publishes the message of the schedule at each activation time until ctx is done.
+func (s *Scheduler) run(ctx context.Context, e *entry) {
+	for {
+		now := time.Now().In(s.location)
+		next, err := e.spec.next(now)
+		if err != nil {
+			log.Warn("schedule stopped", zap.String("name", e.config.Name), zap.Error(err))
+			return
+		}
+		s.mu.Lock()
+		e.next = next
+		s.mu.Unlock()
+		timer := time.NewTimer(next.Sub(now))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		s.publisher.Publish(e.message())
+		log.Debug("scheduled message published",
+			zap.String("name", e.config.Name),
+			zap.String("topic", e.config.Topic))
+	}
+}
@@ -0,0 +1,193 @@
+package scheduler
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gopkg.in/yaml.v2"
+
+	"github.com/DrmagicE/gmqtt"
+	"github.com/DrmagicE/gmqtt/config"
+	"github.com/DrmagicE/gmqtt/server"
+)
+
+func init() {
+	log = zap.NewNop()
+	registerAPI = func(service server.Server, s *Scheduler) error {
+		return nil
+	}
+}
+
+func newTestScheduler(t *testing.T, ctrl *gomock.Controller, dir string, schedules ...ScheduleConfig) (*Scheduler, *server.MockPublisher) {
+	cfg := DefaultConfig
+	cfg.Location = "UTC"
+	cfg.Schedules = schedules
+	c := config.DefaultConfig()
+	c.ConfigDir = dir
+	c.Plugins[Name] = &cfg
+	p, err := New(c)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	pub := server.NewMockPublisher(ctrl)
+	srv := server.NewMockServer(ctrl)
+	srv.EXPECT().Publisher().Return(pub).AnyTimes()
+	if !assert.NoError(t, p.Load(srv)) {
+		t.FailNow()
+	}
+	t.Cleanup(func() {
+		p.Unload()
+	})
+	return p.(*Scheduler), pub
+}
+
+func TestScheduler_API(t *testing.T) {
+	a := assert.New(t)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	dir, err := ioutil.TempDir("", "scheduler")
+	a.NoError(err)
+	defer os.RemoveAll(dir)
+	s, _ := newTestScheduler(t, ctrl, dir, ScheduleConfig{
+		Name:  "heartbeat",
+		Cron:  "@hourly",
+		Topic: "heartbeat",
+	})
+
+	_, err = s.Update(context.Background(), &UpdateScheduleRequest{
+		Name:      "broadcast",
+		Cron:      "0 0 * * *",
+		TopicName: "config",
+		Payload:   "payload",
+		Qos:       1,
+		Retained:  true,
+	})
+	a.NoError(err)
+
+	resp, err := s.List(context.Background(), &ListSchedulesRequest{})
+	a.NoError(err)
+	a.EqualValues(2, resp.TotalCount)
+	a.Len(resp.Schedules, 2)
+	a.True(resp.Schedules[0].ReadOnly)
+
+	var get *GetScheduleResponse
+	a.Eventually(func() bool {
+		get, err = s.Get(context.Background(), &GetScheduleRequest{Name: "broadcast"})
+		return err == nil && get.Schedule.NextRun != nil
+	}, time.Second, 10*time.Millisecond)
+	a.Equal("config", get.Schedule.TopicName)
+	a.Equal("payload", get.Schedule.Payload)
+	a.EqualValues(1, get.Schedule.Qos)
+	a.True(get.Schedule.Retained)
+	a.False(get.Schedule.ReadOnly)
+	next := get.Schedule.NextRun.AsTime()
+	a.Equal(0, next.Hour())
+	a.Equal(0, next.Minute())
+
+	// the schedules in the configuration file are read-only.
+	_, err = s.Update(context.Background(), &UpdateScheduleRequest{
+		Name:      "heartbeat",
+		Cron:      "@daily",
+		TopicName: "heartbeat",
+	})
+	a.Equal(codes.InvalidArgument, status.Code(err))
+	_, err = s.Delete(context.Background(), &DeleteScheduleRequest{Name: "heartbeat"})
+	a.Equal(codes.InvalidArgument, status.Code(err))
+
+	_, err = s.Update(context.Background(), &UpdateScheduleRequest{
+		Name:      "invalid",
+		Cron:      "* * *",
+		TopicName: "topic",
+	})
+	a.Equal(codes.InvalidArgument, status.Code(err))
+
+	// the schedules created by the API are persisted.
+	b, err := ioutil.ReadFile(path.Join(dir, DefaultConfig.ScheduleFile))
+	a.NoError(err)
+	var saved []ScheduleConfig
+	a.NoError(yaml.Unmarshal(b, &saved))
+	a.Equal([]ScheduleConfig{{
+		Name:     "broadcast",
+		Cron:     "0 0 * * *",
+		Topic:    "config",
+		Payload:  "payload",
+		QoS:      1,
+		Retained: true,
+	}}, saved)
+
+	s2, _ := newTestScheduler(t, ctrl, dir)
+	get, err = s2.Get(context.Background(), &GetScheduleRequest{Name: "broadcast"})
+	a.NoError(err)
+	a.Equal("config", get.Schedule.TopicName)
+
+	_, err = s.Delete(context.Background(), &DeleteScheduleRequest{Name: "broadcast"})
+	a.NoError(err)
+	_, err = s.Get(context.Background(), &GetScheduleRequest{Name: "broadcast"})
+	a.Equal(codes.NotFound, status.Code(err))
+	b, err = ioutil.ReadFile(path.Join(dir, DefaultConfig.ScheduleFile))
+	a.NoError(err)
+	saved = nil
+	a.NoError(yaml.Unmarshal(b, &saved))
+	a.Empty(saved)
+}
+
+func TestScheduler_Run(t *testing.T) {
+	a := assert.New(t)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	dir, err := ioutil.TempDir("", "scheduler")
+	a.NoError(err)
+	defer os.RemoveAll(dir)
+	s, pub := newTestScheduler(t, ctrl, dir)
+
+	published := make(chan *gmqtt.Message, 10)
+	pub.EXPECT().Publish(gomock.Any()).Do(func(msg *gmqtt.Message) {
+		published <- msg
+	}).MinTimes(2)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		s.run(ctx, &entry{
+			config: ScheduleConfig{
+				Name:          "heartbeat",
+				Topic:         "heartbeat",
+				Payload:       "ping",
+				QoS:           1,
+				ContentType:   "text/plain",
+				MessageExpiry: 10,
+			},
+			spec: &cronSpec{every: 10 * time.Millisecond},
+		})
+		close(done)
+	}()
+	for i := 0; i < 2; i++ {
+		select {
+		case msg := <-published:
+			a.Equal(&gmqtt.Message{
+				QoS:           1,
+				Topic:         "heartbeat",
+				Payload:       []byte("ping"),
+				ContentType:   "text/plain",
+				MessageExpiry: 10,
+			}, msg)
+		case <-time.After(time.Second):
+			a.Fail("timeout")
+		}
+	}
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		a.Fail("run does not stop")
+	}
+}
@@ -0,0 +1,134 @@
+package schema
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/DrmagicE/gmqtt/pkg/packets"
+	"github.com/DrmagicE/gmqtt/pkg/proxy"
+)
+
+const (
+	// FormatJSON validates the payload against a JSON Schema.
+	FormatJSON = "json"
+	// FormatProtobuf validates the payload against a protobuf message descriptor.
+	FormatProtobuf = "protobuf"
+)
+
+// Config is the configuration for the schema plugin.
+type Config struct {
+	// Registry is the schema registry where the schemas are fetched from.
+	Registry RegistryConfig `yaml:"registry"`
+	// Schemas is the list of topics to be validated.
+	// The first schema whose topic filter matches the topic of the message is used.
+	Schemas []SchemaConfig `yaml:"schemas"`
+	// DeadLetterTopic is the topic prefix that the malformed messages are routed to.
+	// If set, the malformed message on topic "a/b" will be published to "<dead_letter_topic>/a/b".
+	// If empty, the malformed messages are dropped.
+	DeadLetterTopic string `yaml:"dead_letter_topic"`
+}
+
+// RegistryConfig is the configuration for the schema registry.
+type RegistryConfig struct {
+	// URL is the URL template of the schema, the placeholder {subject} is replaced by the subject of the schema.
+	// e.g: http://127.0.0.1:8081/schemas/{subject}
+	URL string `yaml:"url"`
+	// Headers is the additional HTTP headers sent to the registry, e.g. Authorization.
+	Headers map[string]string `yaml:"headers"`
+	// Timeout is the timeout of the HTTP request.
+	Timeout time.Duration `yaml:"timeout"`
+	// Proxy is the forward proxy of the HTTP requests, e.g. http://proxy:3128 or socks5://proxy:1080.
+	// If empty, use the global proxy. "direct" connects directly regardless of the global proxy.
+	Proxy string `yaml:"proxy"`
+	// RefreshInterval is the interval to refetch the schemas from the registry, 0 means never refresh.
+	RefreshInterval time.Duration `yaml:"refresh_interval"`
+}
+
+// SchemaConfig binds a schema to a topic filter.
+type SchemaConfig struct {
+	// TopicFilter is the topic filter that the schema applies to.
+	TopicFilter string `yaml:"topic_filter"`
+	// Format is the format of the schema, possible values are: json, protobuf.
+	Format string `yaml:"format"`
+	// Subject is the subject of the schema in the registry.
+	Subject string `yaml:"subject"`
+	// File is the local file of the schema, it is exclusive with Subject.
+	// If it is a relative path, it locates in the same directory as the config file.
+	File string `yaml:"file"`
+	// Message is the full name of the protobuf message, e.g: sensor.v1.Temperature.
+	// The protobuf schema must be a FileDescriptorSet which contains the message and all its dependencies.
+	Message string `yaml:"message"`
+	// AllowUnknownFields indicates whether to accept the protobuf payload with unknown fields.
+	AllowUnknownFields bool `yaml:"allow_unknown_fields"`
+}
+
+func (s *SchemaConfig) validate(registry *RegistryConfig) error {
+	if !packets.ValidTopicFilter(true, []byte(s.TopicFilter)) {
+		return fmt.Errorf("invalid topic_filter: %s", s.TopicFilter)
+	}
+	switch s.Format {
+	case FormatJSON:
+	case FormatProtobuf:
+		if s.Message == "" {
+			return fmt.Errorf("invalid message: cannot be empty for protobuf schema")
+		}
+	default:
+		return fmt.Errorf("invalid format: %s", s.Format)
+	}
+	if (s.Subject == "") == (s.File == "") {
+		return errors.New("invalid schema: either subject or file must be set")
+	}
+	if s.Subject != "" && registry.URL == "" {
+		return errors.New("invalid registry url: cannot be empty if subject is set")
+	}
+	return nil
+}
+
+// Validate validates the configuration, and return an error if it is invalid.
+func (c *Config) Validate() error {
+	if c.Registry.URL != "" && !strings.Contains(c.Registry.URL, "{subject}") {
+		return fmt.Errorf("invalid registry url: missing {subject} placeholder")
+	}
+	if c.Registry.Timeout <= 0 {
+		return errors.New("invalid registry timeout: must be greater than 0")
+	}
+	if err := proxy.Validate(c.Registry.Proxy); err != nil {
+		return fmt.Errorf("invalid registry proxy: %s", err)
+	}
+	if c.Registry.RefreshInterval < 0 {
+		return errors.New("invalid registry refresh_interval: cannot be negative")
+	}
+	for _, v := range c.Schemas {
+		if err := v.validate(&c.Registry); err != nil {
+			return fmt.Errorf("schema %s: %s", v.TopicFilter, err)
+		}
+	}
+	if c.DeadLetterTopic != "" && !packets.ValidTopicName(true, []byte(c.DeadLetterTopic)) {
+		return fmt.Errorf("invalid dead_letter_topic: %s", c.DeadLetterTopic)
+	}
+	return nil
+}
+
+// DefaultConfig is the default configuration.
+var DefaultConfig = Config{
+	Registry: RegistryConfig{
+		Timeout:         10 * time.Second,
+		RefreshInterval: 5 * time.Minute,
+	},
+}
+
+func (c *Config) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type cfg Config
+	var v = &struct {
+		Schema cfg `yaml:"schema"`
+	}{
+		Schema: cfg(DefaultConfig),
+	}
+	if err := unmarshal(v); err != nil {
+		return err
+	}
+	*c = Config(v.Schema)
+	return nil
+}
@@ -0,0 +1,79 @@
+package schema
+
+import (
+	"context"
+
+	"go.uber.org/zap"
+
+	"github.com/DrmagicE/gmqtt"
+	"github.com/DrmagicE/gmqtt/pkg/codes"
+	"github.com/DrmagicE/gmqtt/pkg/packets"
+	"github.com/DrmagicE/gmqtt/server"
+)
+
+// userPropertyError is the user property key that carries the validation error in the dead-letter message.
+const userPropertyError = "schema-error"
+
+func (s *Schema) HookWrapper() server.HookWrapper {
+	return server.HookWrapper{
+		OnMsgArrivedWrapper:  s.OnMsgArrivedWrapper,
+		OnWillPublishWrapper: s.OnWillPublishWrapper,
+	}
+}
+
+// validate validates the message, the malformed message is routed to the dead-letter topic if configured.
+func (s *Schema) validate(clientID string, msg *gmqtt.Message) error {
+	v := s.validator(msg.Topic)
+	if v == nil {
+		return nil
+	}
+	err := v.validate(msg.Payload)
+	if err == nil {
+		return nil
+	}
+	log.Debug("malformed message",
+		zap.String("client_id", clientID),
+		zap.String("topic", msg.Topic),
+		zap.Error(err))
+	if s.config.DeadLetterTopic != "" {
+		dl := msg.Copy()
+		dl.Topic = s.config.DeadLetterTopic + "/" + msg.Topic
+		dl.Retained = false
+		dl.UserProperties = append(dl.UserProperties, packets.UserProperty{
+			K: []byte(userPropertyError),
+			V: []byte(err.Error()),
+		})
+		s.publisher.Publish(dl)
+	}
+	return err
+}
+
+func (s *Schema) OnMsgArrivedWrapper(pre server.OnMsgArrived) server.OnMsgArrived {
+	return func(ctx context.Context, client server.Client, req *server.MsgArrivedRequest) error {
+		err := pre(ctx, client, req)
+		if err != nil || req.Message == nil {
+			return err
+		}
+		if err = s.validate(client.ClientOptions().ClientID, req.Message); err != nil {
+			return &codes.Error{
+				Code: codes.PayloadFormatInvalid,
+				ErrorDetails: codes.ErrorDetails{
+					ReasonString: []byte(err.Error()),
+				},
+			}
+		}
+		return nil
+	}
+}
+
+func (s *Schema) OnWillPublishWrapper(pre server.OnWillPublish) server.OnWillPublish {
+	return func(ctx context.Context, clientID string, req *server.WillMsgRequest) {
+		pre(ctx, clientID, req)
+		if req.Message == nil {
+			return
+		}
+		if err := s.validate(clientID, req.Message); err != nil {
+			req.Message = nil
+		}
+	}
+}
@@ -0,0 +1,725 @@
+package schema
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// jsonCheck checks the decoded JSON value v, path is the location of v which is used in the error message.
+type jsonCheck func(v interface{}, path string) error
+
+// jsonSchema is a compiled JSON Schema.
+// It supports a subset of the draft-07 keywords, the unknown keywords are ignored.
+type jsonSchema struct {
+	checks []jsonCheck
+}
+
+func (s *jsonSchema) validate(payload []byte) error {
+	v, err := decodeJSON(payload)
+	if err != nil {
+		return fmt.Errorf("invalid json: %s", err)
+	}
+	return s.check(v, "$")
+}
+
+func (s *jsonSchema) check(v interface{}, path string) error {
+	for _, c := range s.checks {
+		if err := c(v, path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// decodeJSON decodes the JSON document, the numbers are decoded as json.Number.
+func decodeJSON(b []byte) (interface{}, error) {
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	var v interface{}
+	if err := d.Decode(&v); err != nil {
+		return nil, err
+	}
+	if _, err := d.Token(); err != io.EOF {
+		return nil, errors.New("unexpected data after top-level value")
+	}
+	return v, nil
+}
+
+type jsonCompiler struct {
+	root interface{}
+	// refs caches the compiled schemas by JSON pointer, so that the recursive references can be resolved.
+	refs map[string]*jsonSchema
+}
+
+func compileJSONSchema(b []byte) (*jsonSchema, error) {
+	root, err := decodeJSON(b)
+	if err != nil {
+		return nil, fmt.Errorf("invalid json schema: %s", err)
+	}
+	c := &jsonCompiler{
+		root: root,
+		refs: make(map[string]*jsonSchema),
+	}
+	return c.compileRef("#")
+}
+
+// compileRef compiles the schema referenced by the local JSON pointer, e.g: #/definitions/address.
+func (c *jsonCompiler) compileRef(ref string) (*jsonSchema, error) {
+	if s, ok := c.refs[ref]; ok {
+		return s, nil
+	}
+	if !strings.HasPrefix(ref, "#") {
+		return nil, fmt.Errorf("unsupported $ref: %s, only local reference is supported", ref)
+	}
+	v := c.root
+	if ref != "#" {
+		if !strings.HasPrefix(ref, "#/") {
+			return nil, fmt.Errorf("invalid $ref: %s", ref)
+		}
+		for _, token := range strings.Split(ref[2:], "/") {
+			token = strings.Replace(strings.Replace(token, "~1", "/", -1), "~0", "~", -1)
+			obj, ok := v.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("invalid $ref: %s", ref)
+			}
+			if v, ok = obj[token]; !ok {
+				return nil, fmt.Errorf("invalid $ref: %s", ref)
+			}
+		}
+	}
+	s := &jsonSchema{}
+	c.refs[ref] = s
+	compiled, err := c.compile(v, ref)
+	if err != nil {
+		return nil, err
+	}
+	*s = *compiled
+	return s, nil
+}
+
+func (c *jsonCompiler) compile(v interface{}, ptr string) (*jsonSchema, error) {
+	s := &jsonSchema{}
+	switch v := v.(type) {
+	case bool:
+		if !v {
+			s.checks = append(s.checks, func(v interface{}, path string) error {
+				return fmt.Errorf("%s: not allowed", path)
+			})
+		}
+		return s, nil
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		// sort the keywords to make the error message stable.
+		sort.Strings(keys)
+		for _, k := range keys {
+			check, err := c.compileKeyword(v, k, ptr)
+			if err != nil {
+				return nil, err
+			}
+			if check != nil {
+				s.checks = append(s.checks, check)
+			}
+		}
+		return s, nil
+	default:
+		return nil, fmt.Errorf("invalid schema %s: must be an object or a boolean", ptr)
+	}
+}
+
+func (c *jsonCompiler) compileList(v interface{}, ptr string) ([]*jsonSchema, error) {
+	list, ok := v.([]interface{})
+	if !ok || len(list) == 0 {
+		return nil, fmt.Errorf("invalid %s: must be a non-empty array", ptr)
+	}
+	var schemas []*jsonSchema
+	for k, v := range list {
+		s, err := c.compile(v, ptr+"/"+strconv.Itoa(k))
+		if err != nil {
+			return nil, err
+		}
+		schemas = append(schemas, s)
+	}
+	return schemas, nil
+}
+
+func (c *jsonCompiler) compileKeyword(obj map[string]interface{}, keyword string, ptr string) (jsonCheck, error) {
+	value := obj[keyword]
+	kptr := ptr + "/" + keyword
+	switch keyword {
+	case "$ref":
+		ref, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("invalid %s: must be a string", kptr)
+		}
+		// s may be still compiling if the reference is recursive, so it must not be dereferenced here.
+		s, err := c.compileRef(ref)
+		if err != nil {
+			return nil, err
+		}
+		return s.check, nil
+	case "type":
+		var types []string
+		switch t := value.(type) {
+		case string:
+			types = []string{t}
+		case []interface{}:
+			for _, v := range t {
+				s, ok := v.(string)
+				if !ok {
+					return nil, fmt.Errorf("invalid %s: must be a string or an array of strings", kptr)
+				}
+				types = append(types, s)
+			}
+		default:
+			return nil, fmt.Errorf("invalid %s: must be a string or an array of strings", kptr)
+		}
+		for _, t := range types {
+			switch t {
+			case "null", "boolean", "object", "array", "number", "integer", "string":
+			default:
+				return nil, fmt.Errorf("invalid %s: unknown type %s", kptr, t)
+			}
+		}
+		return func(v interface{}, path string) error {
+			for _, t := range types {
+				if isJSONType(v, t) {
+					return nil
+				}
+			}
+			return fmt.Errorf("%s: expected %s, but got %s", path, strings.Join(types, " or "), jsonType(v))
+		}, nil
+	case "enum":
+		list, ok := value.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("invalid %s: must be an array", kptr)
+		}
+		return func(v interface{}, path string) error {
+			for _, e := range list {
+				if jsonEqual(v, e) {
+					return nil
+				}
+			}
+			return fmt.Errorf("%s: must be one of the enum values", path)
+		}, nil
+	case "const":
+		return func(v interface{}, path string) error {
+			if !jsonEqual(v, value) {
+				return fmt.Errorf("%s: must be equal to the const value", path)
+			}
+			return nil
+		}, nil
+	case "properties", "patternProperties", "additionalProperties":
+		// compiled together, see compileProperties.
+		if keyword != "properties" && obj["properties"] != nil {
+			return nil, nil
+		}
+		if keyword == "additionalProperties" && obj["patternProperties"] != nil {
+			return nil, nil
+		}
+		return c.compileProperties(obj, ptr)
+	case "required":
+		list, ok := value.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("invalid %s: must be an array of strings", kptr)
+		}
+		var required []string
+		for _, v := range list {
+			s, ok := v.(string)
+			if !ok {
+				return nil, fmt.Errorf("invalid %s: must be an array of strings", kptr)
+			}
+			required = append(required, s)
+		}
+		return func(v interface{}, path string) error {
+			obj, ok := v.(map[string]interface{})
+			if !ok {
+				return nil
+			}
+			for _, k := range required {
+				if _, ok := obj[k]; !ok {
+					return fmt.Errorf("%s: missing required property %q", path, k)
+				}
+			}
+			return nil
+		}, nil
+	case "minProperties", "maxProperties":
+		n, err := nonNegativeInt(value, kptr)
+		if err != nil {
+			return nil, err
+		}
+		return func(v interface{}, path string) error {
+			obj, ok := v.(map[string]interface{})
+			if !ok {
+				return nil
+			}
+			if keyword == "minProperties" && len(obj) < n {
+				return fmt.Errorf("%s: must have at least %d properties", path, n)
+			}
+			if keyword == "maxProperties" && len(obj) > n {
+				return fmt.Errorf("%s: must have at most %d properties", path, n)
+			}
+			return nil
+		}, nil
+	case "propertyNames":
+		s, err := c.compile(value, kptr)
+		if err != nil {
+			return nil, err
+		}
+		return func(v interface{}, path string) error {
+			obj, ok := v.(map[string]interface{})
+			if !ok {
+				return nil
+			}
+			for k := range obj {
+				if err := s.check(k, path); err != nil {
+					return fmt.Errorf("%s: invalid property name %q: %s", path, k, err)
+				}
+			}
+			return nil
+		}, nil
+	case "items", "additionalItems":
+		if keyword == "additionalItems" {
+			// compiled together with items, and it is ignored if items is not an array.
+			return nil, nil
+		}
+		return c.compileItems(obj, kptr)
+	case "minItems", "maxItems":
+		n, err := nonNegativeInt(value, kptr)
+		if err != nil {
+			return nil, err
+		}
+		return func(v interface{}, path string) error {
+			arr, ok := v.([]interface{})
+			if !ok {
+				return nil
+			}
+			if keyword == "minItems" && len(arr) < n {
+				return fmt.Errorf("%s: must have at least %d items", path, n)
+			}
+			if keyword == "maxItems" && len(arr) > n {
+				return fmt.Errorf("%s: must have at most %d items", path, n)
+			}
+			return nil
+		}, nil
+	case "uniqueItems":
+		unique, ok := value.(bool)
+		if !ok {
+			return nil, fmt.Errorf("invalid %s: must be a boolean", kptr)
+		}
+		if !unique {
+			return nil, nil
+		}
+		return func(v interface{}, path string) error {
+			arr, ok := v.([]interface{})
+			if !ok {
+				return nil
+			}
+			for i := range arr {
+				for j := i + 1; j < len(arr); j++ {
+					if jsonEqual(arr[i], arr[j]) {
+						return fmt.Errorf("%s: items must be unique", path)
+					}
+				}
+			}
+			return nil
+		}, nil
+	case "contains":
+		s, err := c.compile(value, kptr)
+		if err != nil {
+			return nil, err
+		}
+		return func(v interface{}, path string) error {
+			arr, ok := v.([]interface{})
+			if !ok {
+				return nil
+			}
+			for k, item := range arr {
+				if s.check(item, path+"["+strconv.Itoa(k)+"]") == nil {
+					return nil
+				}
+			}
+			return fmt.Errorf("%s: does not contain the required item", path)
+		}, nil
+	case "minimum", "maximum", "exclusiveMinimum", "exclusiveMaximum", "multipleOf":
+		limit, ok := jsonNumber(value)
+		if !ok {
+			return nil, fmt.Errorf("invalid %s: must be a number", kptr)
+		}
+		if keyword == "multipleOf" && limit <= 0 {
+			return nil, fmt.Errorf("invalid %s: must be greater than 0", kptr)
+		}
+		return func(v interface{}, path string) error {
+			if _, ok := v.(json.Number); !ok {
+				return nil
+			}
+			n, _ := jsonNumber(v)
+			var valid bool
+			switch keyword {
+			case "minimum":
+				valid = n >= limit
+			case "maximum":
+				valid = n <= limit
+			case "exclusiveMinimum":
+				valid = n > limit
+			case "exclusiveMaximum":
+				valid = n < limit
+			case "multipleOf":
+				q := n / limit
+				valid = !math.IsInf(q, 0) && q == math.Trunc(q)
+			}
+			if !valid {
+				return fmt.Errorf("%s: %v does not satisfy %s %v", path, v, keyword, value)
+			}
+			return nil
+		}, nil
+	case "minLength", "maxLength":
+		n, err := nonNegativeInt(value, kptr)
+		if err != nil {
+			return nil, err
+		}
+		return func(v interface{}, path string) error {
+			s, ok := v.(string)
+			if !ok {
+				return nil
+			}
+			l := utf8.RuneCountInString(s)
+			if keyword == "minLength" && l < n {
+				return fmt.Errorf("%s: length must be at least %d", path, n)
+			}
+			if keyword == "maxLength" && l > n {
+				return fmt.Errorf("%s: length must be at most %d", path, n)
+			}
+			return nil
+		}, nil
+	case "pattern":
+		p, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("invalid %s: must be a string", kptr)
+		}
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %s", kptr, err)
+		}
+		return func(v interface{}, path string) error {
+			s, ok := v.(string)
+			if ok && !re.MatchString(s) {
+				return fmt.Errorf("%s: does not match pattern %q", path, p)
+			}
+			return nil
+		}, nil
+	case "allOf", "anyOf", "oneOf":
+		schemas, err := c.compileList(value, kptr)
+		if err != nil {
+			return nil, err
+		}
+		return func(v interface{}, path string) error {
+			var matched int
+			for _, s := range schemas {
+				err := s.check(v, path)
+				if err != nil && keyword == "allOf" {
+					return err
+				}
+				if err == nil {
+					matched++
+				}
+			}
+			if keyword == "anyOf" && matched == 0 {
+				return fmt.Errorf("%s: does not match any of the schemas in anyOf", path)
+			}
+			if keyword == "oneOf" && matched != 1 {
+				return fmt.Errorf("%s: must match exactly one schema in oneOf, but matched %d", path, matched)
+			}
+			return nil
+		}, nil
+	case "not":
+		s, err := c.compile(value, kptr)
+		if err != nil {
+			return nil, err
+		}
+		return func(v interface{}, path string) error {
+			if s.check(v, path) == nil {
+				return fmt.Errorf("%s: must not match the schema in not", path)
+			}
+			return nil
+		}, nil
+	case "if":
+		ifSchema, err := c.compile(value, kptr)
+		if err != nil {
+			return nil, err
+		}
+		var thenSchema, elseSchema *jsonSchema
+		if v, ok := obj["then"]; ok {
+			if thenSchema, err = c.compile(v, ptr+"/then"); err != nil {
+				return nil, err
+			}
+		}
+		if v, ok := obj["else"]; ok {
+			if elseSchema, err = c.compile(v, ptr+"/else"); err != nil {
+				return nil, err
+			}
+		}
+		return func(v interface{}, path string) error {
+			if ifSchema.check(v, path) == nil {
+				if thenSchema != nil {
+					return thenSchema.check(v, path)
+				}
+				return nil
+			}
+			if elseSchema != nil {
+				return elseSchema.check(v, path)
+			}
+			return nil
+		}, nil
+	}
+	return nil, nil
+}
+
+// compileProperties compiles properties, patternProperties and additionalProperties into one check,
+// because additionalProperties depends on the other two.
+func (c *jsonCompiler) compileProperties(obj map[string]interface{}, ptr string) (jsonCheck, error) {
+	properties := make(map[string]*jsonSchema)
+	if v, ok := obj["properties"]; ok {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("invalid %s/properties: must be an object", ptr)
+		}
+		for k, v := range m {
+			s, err := c.compile(v, ptr+"/properties/"+k)
+			if err != nil {
+				return nil, err
+			}
+			properties[k] = s
+		}
+	}
+	type patternProperty struct {
+		re     *regexp.Regexp
+		schema *jsonSchema
+	}
+	var patterns []patternProperty
+	if v, ok := obj["patternProperties"]; ok {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("invalid %s/patternProperties: must be an object", ptr)
+		}
+		for k, v := range m {
+			re, err := regexp.Compile(k)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s/patternProperties: %s", ptr, err)
+			}
+			s, err := c.compile(v, ptr+"/patternProperties/"+k)
+			if err != nil {
+				return nil, err
+			}
+			patterns = append(patterns, patternProperty{re: re, schema: s})
+		}
+	}
+	var additional *jsonSchema
+	if v, ok := obj["additionalProperties"]; ok {
+		s, err := c.compile(v, ptr+"/additionalProperties")
+		if err != nil {
+			return nil, err
+		}
+		additional = s
+	}
+	return func(v interface{}, path string) error {
+		o, ok := v.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		keys := make([]string, 0, len(o))
+		for k := range o {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			p := path + "." + k
+			s, matched := properties[k]
+			if matched {
+				if err := s.check(o[k], p); err != nil {
+					return err
+				}
+			}
+			for _, pp := range patterns {
+				if pp.re.MatchString(k) {
+					matched = true
+					if err := pp.schema.check(o[k], p); err != nil {
+						return err
+					}
+				}
+			}
+			if !matched && additional != nil {
+				if err := additional.check(o[k], p); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}, nil
+}
+
+// compileItems compiles items and additionalItems into one check.
+func (c *jsonCompiler) compileItems(obj map[string]interface{}, ptr string) (jsonCheck, error) {
+	value := obj["items"]
+	if list, ok := value.([]interface{}); ok {
+		tuple, err := c.compileList(list, ptr)
+		if err != nil {
+			return nil, err
+		}
+		var additional *jsonSchema
+		if v, ok := obj["additionalItems"]; ok {
+			if additional, err = c.compile(v, strings.TrimSuffix(ptr, "/items")+"/additionalItems"); err != nil {
+				return nil, err
+			}
+		}
+		return func(v interface{}, path string) error {
+			arr, ok := v.([]interface{})
+			if !ok {
+				return nil
+			}
+			for k, item := range arr {
+				s := additional
+				if k < len(tuple) {
+					s = tuple[k]
+				}
+				if s == nil {
+					break
+				}
+				if err := s.check(item, path+"["+strconv.Itoa(k)+"]"); err != nil {
+					return err
+				}
+			}
+			return nil
+		}, nil
+	}
+	s, err := c.compile(value, ptr)
+	if err != nil {
+		return nil, err
+	}
+	return func(v interface{}, path string) error {
+		arr, ok := v.([]interface{})
+		if !ok {
+			return nil
+		}
+		for k, item := range arr {
+			if err := s.check(item, path+"["+strconv.Itoa(k)+"]"); err != nil {
+				return err
+			}
+		}
+		return nil
+	}, nil
+}
+
+func nonNegativeInt(v interface{}, ptr string) (int, error) {
+	n, ok := jsonNumber(v)
+	if !ok || n < 0 || n != math.Trunc(n) {
+		return 0, fmt.Errorf("invalid %s: must be a non-negative integer", ptr)
+	}
+	return int(n), nil
+}
+
+func jsonNumber(v interface{}) (float64, bool) {
+	n, ok := v.(json.Number)
+	if !ok {
+		return 0, false
+	}
+	f, err := n.Float64()
+	return f, err == nil
+}
+
+func jsonType(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case json.Number:
+		if isJSONType(v, "integer") {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	}
+	return "unknown"
+}
+
+func isJSONType(v interface{}, t string) bool {
+	switch t {
+	case "null":
+		return v == nil
+	case "boolean":
+		_, ok := v.(bool)
+		return ok
+	case "object":
+		_, ok := v.(map[string]interface{})
+		return ok
+	case "array":
+		_, ok := v.([]interface{})
+		return ok
+	case "number":
+		_, ok := v.(json.Number)
+		return ok
+	case "integer":
+		n, ok := jsonNumber(v)
+		return ok && n == math.Trunc(n) && !math.IsInf(n, 0)
+	case "string":
+		_, ok := v.(string)
+		return ok
+	}
+	return false
+}
+
+// jsonEqual reports whether the two decoded JSON values are equal, numbers are compared by value.
+func jsonEqual(a, b interface{}) bool {
+	switch a := a.(type) {
+	case json.Number:
+		bn, ok := b.(json.Number)
+		if !ok {
+			return false
+		}
+		x, _ := jsonNumber(a)
+		y, _ := jsonNumber(bn)
+		return x == y
+	case map[string]interface{}:
+		bm, ok := b.(map[string]interface{})
+		if !ok || len(a) != len(bm) {
+			return false
+		}
+		for k, v := range a {
+			bv, ok := bm[k]
+			if !ok || !jsonEqual(v, bv) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		ba, ok := b.([]interface{})
+		if !ok || len(a) != len(ba) {
+			return false
+		}
+		for k := range a {
+			if !jsonEqual(a[k], ba[k]) {
+				return false
+			}
+		}
+		return true
+	default:
+		return a == b
+	}
+}
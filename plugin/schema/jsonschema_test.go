@@ -0,0 +1,140 @@
+package schema
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompileJSONSchema(t *testing.T) {
+	var tt = []struct {
+		name    string
+		schema  string
+		valid   []string
+		invalid []string
+	}{
+		{
+			name:    "true",
+			schema:  `true`,
+			valid:   []string{`1`, `"a"`, `{}`},
+			invalid: []string{`{`, `1 2`},
+		},
+		{
+			name:    "false",
+			schema:  `false`,
+			invalid: []string{`1`, `{}`},
+		},
+		{
+			name:    "type",
+			schema:  `{"type":["integer","null"]}`,
+			valid:   []string{`1`, `1.0`, `null`},
+			invalid: []string{`1.5`, `"1"`, `true`},
+		},
+		{
+			name:    "enum and const",
+			schema:  `{"enum":[1,"a",{"b":[1]}],"not":{"const":"a"}}`,
+			valid:   []string{`1.0`, `{"b":[1]}`},
+			invalid: []string{`"a"`, `2`, `{"b":[2]}`},
+		},
+		{
+			name: "object",
+			schema: `{
+				"type":"object",
+				"properties":{"temp":{"type":"number","minimum":-50,"exclusiveMaximum":100}},
+				"patternProperties":{"^x-":{"type":"string"}},
+				"additionalProperties":false,
+				"required":["temp"],
+				"maxProperties":2
+			}`,
+			valid:   []string{`{"temp":20.5}`, `{"temp":-50,"x-unit":"c"}`},
+			invalid: []string{`{}`, `{"temp":100}`, `{"temp":-51}`, `{"temp":1,"unit":"c"}`, `{"temp":1,"x-unit":1}`, `{"temp":1,"x-a":"1","x-b":"2"}`, `[]`},
+		},
+		{
+			name:    "array",
+			schema:  `{"type":"array","items":{"type":"integer","multipleOf":2},"minItems":1,"maxItems":3,"uniqueItems":true,"contains":{"const":4}}`,
+			valid:   []string{`[4]`, `[2,4,6]`},
+			invalid: []string{`[]`, `[2]`, `[4,3]`, `[4,4]`, `[2,4,6,8]`},
+		},
+		{
+			name:    "tuple",
+			schema:  `{"items":[{"type":"string"},{"type":"number"}],"additionalItems":false}`,
+			valid:   []string{`["a"]`, `["a",1]`},
+			invalid: []string{`[1]`, `["a",1,2]`},
+		},
+		{
+			name:    "string",
+			schema:  `{"type":"string","minLength":2,"maxLength":3,"pattern":"^[a-z]+$"}`,
+			valid:   []string{`"ab"`, `"abc"`},
+			invalid: []string{`"a"`, `"abcd"`, `"AB"`},
+		},
+		{
+			name:    "combinators",
+			schema:  `{"anyOf":[{"type":"string"},{"type":"integer"}],"oneOf":[{"minimum":0},{"maximum":10}],"allOf":[{"not":{"const":"x"}}]}`,
+			valid:   []string{`-1`, `11`},
+			invalid: []string{`"a"`, `1.5`, `5`},
+		},
+		{
+			name:    "if then else",
+			schema:  `{"if":{"properties":{"unit":{"const":"c"}}},"then":{"properties":{"temp":{"maximum":100}}},"else":{"properties":{"temp":{"maximum":212}}}}`,
+			valid:   []string{`{"unit":"c","temp":100}`, `{"unit":"f","temp":200}`},
+			invalid: []string{`{"unit":"c","temp":200}`, `{"temp":213}`},
+		},
+		{
+			name: "ref",
+			schema: `{
+				"definitions":{"node":{"type":"object","properties":{"value":{"type":"integer"},"next":{"$ref":"#/definitions/node"}}}},
+				"$ref":"#/definitions/node"
+			}`,
+			valid:   []string{`{"value":1,"next":{"value":2,"next":{}}}`},
+			invalid: []string{`{"value":1,"next":{"value":"2"}}`},
+		},
+		{
+			name:    "propertyNames",
+			schema:  `{"propertyNames":{"maxLength":2}}`,
+			valid:   []string{`{"ab":1}`},
+			invalid: []string{`{"abc":1}`},
+		},
+	}
+	for _, v := range tt {
+		t.Run(v.name, func(t *testing.T) {
+			a := assert.New(t)
+			s, err := compileJSONSchema([]byte(v.schema))
+			a.NoError(err)
+			if err != nil {
+				return
+			}
+			for _, p := range v.valid {
+				a.NoError(s.validate([]byte(p)), p)
+			}
+			for _, p := range v.invalid {
+				a.Error(s.validate([]byte(p)), p)
+			}
+		})
+	}
+}
+
+func TestCompileJSONSchema_invalid(t *testing.T) {
+	a := assert.New(t)
+	for _, v := range []string{
+		`{`,
+		`1`,
+		`{"type":"unknown"}`,
+		`{"required":"a"}`,
+		`{"minLength":-1}`,
+		`{"pattern":"("}`,
+		`{"anyOf":[]}`,
+		`{"$ref":"#/definitions/missing"}`,
+		`{"$ref":"http://example.com/schema.json"}`,
+		`{"properties":{"a":1}}`,
+	} {
+		_, err := compileJSONSchema([]byte(v))
+		a.Error(err, v)
+	}
+}
+
+func TestJSONSchema_errorPath(t *testing.T) {
+	a := assert.New(t)
+	s, err := compileJSONSchema([]byte(`{"properties":{"a":{"items":{"type":"string"}}}}`))
+	a.NoError(err)
+	a.EqualError(s.validate([]byte(`{"a":["x",1]}`)), "$.a[1]: expected string, but got integer")
+}
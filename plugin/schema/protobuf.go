@@ -0,0 +1,80 @@
+package schema
+
+import (
+	"errors"
+	"fmt"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// protobufSchema validates the payload against a protobuf message descriptor.
+type protobufSchema struct {
+	desc               protoreflect.MessageDescriptor
+	allowUnknownFields bool
+}
+
+// compileProtobufSchema builds the schema from the serialized FileDescriptorSet,
+// which can be generated by: protoc --include_imports --descriptor_set_out=<file> <proto files>
+func compileProtobufSchema(b []byte, message string, allowUnknownFields bool) (*protobufSchema, error) {
+	fds := &descriptorpb.FileDescriptorSet{}
+	if err := proto.Unmarshal(b, fds); err != nil {
+		return nil, fmt.Errorf("invalid file descriptor set: %s", err)
+	}
+	files, err := protodesc.NewFiles(fds)
+	if err != nil {
+		return nil, fmt.Errorf("invalid file descriptor set: %s", err)
+	}
+	d, err := files.FindDescriptorByName(protoreflect.FullName(message))
+	if err != nil {
+		return nil, fmt.Errorf("message %s not found: %s", message, err)
+	}
+	md, ok := d.(protoreflect.MessageDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a message", message)
+	}
+	return &protobufSchema{
+		desc:               md,
+		allowUnknownFields: allowUnknownFields,
+	}, nil
+}
+
+func (p *protobufSchema) validate(payload []byte) error {
+	msg := dynamicpb.NewMessage(p.desc)
+	if err := proto.Unmarshal(payload, msg); err != nil {
+		return fmt.Errorf("invalid protobuf: %s", err)
+	}
+	if !p.allowUnknownFields && hasUnknownFields(msg) {
+		// almost any bytes can be decoded as unknown fields, so they are rejected by default.
+		return errors.New("invalid protobuf: unknown fields")
+	}
+	return nil
+}
+
+func hasUnknownFields(m protoreflect.Message) bool {
+	if len(m.GetUnknown()) != 0 {
+		return true
+	}
+	var unknown bool
+	m.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		switch {
+		case fd.IsList() && fd.Message() != nil:
+			l := v.List()
+			for i := 0; i < l.Len() && !unknown; i++ {
+				unknown = hasUnknownFields(l.Get(i).Message())
+			}
+		case fd.IsMap() && fd.MapValue().Message() != nil:
+			v.Map().Range(func(_ protoreflect.MapKey, v protoreflect.Value) bool {
+				unknown = hasUnknownFields(v.Message())
+				return !unknown
+			})
+		case !fd.IsList() && !fd.IsMap() && fd.Message() != nil:
+			unknown = hasUnknownFields(v.Message())
+		}
+		return !unknown
+	})
+	return unknown
+}
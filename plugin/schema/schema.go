@@ -0,0 +1,194 @@
+package schema
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/DrmagicE/gmqtt/config"
+	"github.com/DrmagicE/gmqtt/pkg/packets"
+	"github.com/DrmagicE/gmqtt/pkg/proxy"
+	"github.com/DrmagicE/gmqtt/server"
+)
+
+var _ server.Plugin = (*Schema)(nil)
+
+const Name = "schema"
+
+// maxSchemaSize is the maximum size of the schema fetched from the registry.
+const maxSchemaSize = 4 << 20
+
+func init() {
+	server.RegisterPlugin(Name, New)
+	config.RegisterDefaultPluginConfig(Name, &DefaultConfig)
+}
+
+func New(config config.Config) (server.Plugin, error) {
+	cfg := config.Plugins[Name].(*Config)
+	transport, err := proxy.HTTPTransport(proxy.Resolve(cfg.Registry.Proxy, config.Proxy))
+	if err != nil {
+		return nil, err
+	}
+	return &Schema{
+		config:    cfg,
+		configDir: config.ConfigDir,
+		client: &http.Client{
+			Transport: transport,
+			Timeout:   cfg.Registry.Timeout,
+		},
+	}, nil
+}
+
+var log *zap.Logger
+
+// validator validates the payload of the message.
+type validator interface {
+	validate(payload []byte) error
+}
+
+// Schema validates the payload of the messages against the schemas configured for their topics.
+type Schema struct {
+	config    *Config
+	configDir string
+	client    *http.Client
+	publisher server.Publisher
+
+	mu sync.RWMutex
+	// validators is index-aligned with config.Schemas.
+	validators []validator
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func (s *Schema) Load(service server.Server) error {
+	log = server.LoggerWithField(zap.String("plugin", Name))
+	s.publisher = service.Publisher()
+	s.validators = make([]validator, len(s.config.Schemas))
+	var refresh bool
+	for k := range s.config.Schemas {
+		sc := &s.config.Schemas[k]
+		v, err := s.loadSchema(context.Background(), sc)
+		if err != nil {
+			return fmt.Errorf("failed to load schema for %s: %s", sc.TopicFilter, err)
+		}
+		s.validators[k] = v
+		if sc.Subject != "" {
+			refresh = true
+		}
+	}
+	if refresh && s.config.Registry.RefreshInterval != 0 {
+		var ctx context.Context
+		ctx, s.cancel = context.WithCancel(context.Background())
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.refreshLoop(ctx)
+		}()
+	}
+	return nil
+}
+
+func (s *Schema) Unload() error {
+	if s.cancel != nil {
+		s.cancel()
+		s.wg.Wait()
+	}
+	return nil
+}
+
+func (s *Schema) Name() string {
+	return Name
+}
+
+// refreshLoop refetches the schemas from the registry periodically.
+// The schema in use is kept if it fails to fetch or compile the new one.
+func (s *Schema) refreshLoop(ctx context.Context) {
+	t := time.NewTicker(s.config.Registry.RefreshInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			for k := range s.config.Schemas {
+				sc := &s.config.Schemas[k]
+				if sc.Subject == "" {
+					continue
+				}
+				v, err := s.loadSchema(ctx, sc)
+				if err != nil {
+					log.Error("failed to refresh schema", zap.String("subject", sc.Subject), zap.Error(err))
+					continue
+				}
+				s.mu.Lock()
+				s.validators[k] = v
+				s.mu.Unlock()
+			}
+		}
+	}
+}
+
+// loadSchema reads the schema from the file or the registry and compiles it.
+func (s *Schema) loadSchema(ctx context.Context, sc *SchemaConfig) (validator, error) {
+	var b []byte
+	var err error
+	if sc.File != "" {
+		file := sc.File
+		if !path.IsAbs(file) {
+			file = path.Join(s.configDir, file)
+		}
+		b, err = ioutil.ReadFile(file)
+	} else {
+		b, err = s.fetch(ctx, sc.Subject)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if sc.Format == FormatProtobuf {
+		return compileProtobufSchema(b, sc.Message, sc.AllowUnknownFields)
+	}
+	return compileJSONSchema(b)
+}
+
+// fetch fetches the schema of the subject from the registry.
+func (s *Schema) fetch(ctx context.Context, subject string) ([]byte, error) {
+	u := strings.Replace(s.config.Registry.URL, "{subject}", url.PathEscape(subject), -1)
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range s.config.Registry.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := s.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code from registry: %d", resp.StatusCode)
+	}
+	return ioutil.ReadAll(io.LimitReader(resp.Body, maxSchemaSize))
+}
+
+// validator returns the validator for the topic, nil if no schema is configured for the topic.
+func (s *Schema) validator(topic string) validator {
+	for k, v := range s.config.Schemas {
+		if packets.TopicMatch([]byte(topic), []byte(v.TopicFilter)) {
+			s.mu.RLock()
+			defer s.mu.RUnlock()
+			return s.validators[k]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,300 @@
+package schema
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+
+	"github.com/DrmagicE/gmqtt"
+	"github.com/DrmagicE/gmqtt/pkg/codes"
+	"github.com/DrmagicE/gmqtt/server"
+)
+
+func init() {
+	log = zap.NewNop()
+}
+
+func newMockClient(ctrl *gomock.Controller) *server.MockClient {
+	client := server.NewMockClient(ctrl)
+	client.EXPECT().ClientOptions().Return(&server.ClientOptions{ClientID: "cid"}).AnyTimes()
+	return client
+}
+
+// positionDescriptor returns the serialized FileDescriptorSet of:
+//
+//	syntax = "proto3";
+//	package sensor.v1;
+//	message Position {
+//		double lat = 1;
+//		double lng = 2;
+//		Position prev = 3;
+//	}
+func positionDescriptor() []byte {
+	fds := &descriptorpb.FileDescriptorSet{
+		File: []*descriptorpb.FileDescriptorProto{
+			{
+				Name:    proto.String("sensor.proto"),
+				Package: proto.String("sensor.v1"),
+				Syntax:  proto.String("proto3"),
+				MessageType: []*descriptorpb.DescriptorProto{
+					{
+						Name: proto.String("Position"),
+						Field: []*descriptorpb.FieldDescriptorProto{
+							{
+								Name:   proto.String("lat"),
+								Number: proto.Int32(1),
+								Label:  descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+								Type:   descriptorpb.FieldDescriptorProto_TYPE_DOUBLE.Enum(),
+							},
+							{
+								Name:   proto.String("lng"),
+								Number: proto.Int32(2),
+								Label:  descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+								Type:   descriptorpb.FieldDescriptorProto_TYPE_DOUBLE.Enum(),
+							},
+							{
+								Name:     proto.String("prev"),
+								Number:   proto.Int32(3),
+								Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+								Type:     descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum(),
+								TypeName: proto.String(".sensor.v1.Position"),
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	b, err := proto.Marshal(fds)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+func TestConfig_Validate(t *testing.T) {
+	a := assert.New(t)
+	a.NoError(DefaultConfig.Validate())
+
+	cfg := DefaultConfig
+	cfg.Registry.URL = "http://127.0.0.1/schemas/{subject}"
+	cfg.Schemas = []SchemaConfig{
+		{TopicFilter: "a/+", Format: FormatJSON, Subject: "a"},
+		{TopicFilter: "b/#", Format: FormatProtobuf, File: "b.pb", Message: "b.B"},
+	}
+	cfg.DeadLetterTopic = "$dead_letter"
+	a.NoError(cfg.Validate())
+
+	var tt = []func(c *Config){
+		func(c *Config) { c.Registry.URL = "http://127.0.0.1/schemas" },
+		func(c *Config) { c.Registry.Timeout = 0 },
+		func(c *Config) { c.Registry.RefreshInterval = -1 },
+		func(c *Config) { c.DeadLetterTopic = "dead/#" },
+		func(c *Config) { c.Schemas[0].TopicFilter = "a/#/b" },
+		func(c *Config) { c.Schemas[0].Format = "xml" },
+		func(c *Config) { c.Schemas[0].File = "a.json" },
+		func(c *Config) { c.Schemas[1].File = "" },
+		func(c *Config) { c.Schemas[1].Message = "" },
+		func(c *Config) {
+			c.Registry.URL = ""
+		},
+	}
+	for k, v := range tt {
+		c := cfg
+		c.Schemas = append([]SchemaConfig{}, cfg.Schemas...)
+		v(&c)
+		a.Error(c.Validate(), k)
+	}
+}
+
+func TestProtobufSchema(t *testing.T) {
+	a := assert.New(t)
+	_, err := compileProtobufSchema(positionDescriptor(), "sensor.v1.Unknown", false)
+	a.Error(err)
+	_, err = compileProtobufSchema([]byte("invalid"), "sensor.v1.Position", false)
+	a.Error(err)
+
+	s, err := compileProtobufSchema(positionDescriptor(), "sensor.v1.Position", false)
+	a.NoError(err)
+	// lat = 1.0, prev = {lng = 1.0}
+	valid := []byte{0x09, 0, 0, 0, 0, 0, 0, 0xf0, 0x3f, 0x1a, 0x09, 0x11, 0, 0, 0, 0, 0, 0, 0xf0, 0x3f}
+	a.NoError(s.validate(valid))
+	a.NoError(s.validate(nil))
+	// wrong wire type for lat
+	a.Error(s.validate([]byte{0x08, 0x01}))
+	// truncated
+	a.Error(s.validate(valid[:5]))
+	// unknown field 4 in the nested message
+	unknown := []byte{0x1a, 0x02, 0x20, 0x01}
+	a.Error(s.validate(unknown))
+	a.Error(s.validate([]byte("hello")))
+
+	s, err = compileProtobufSchema(positionDescriptor(), "sensor.v1.Position", true)
+	a.NoError(err)
+	a.NoError(s.validate(unknown))
+}
+
+func TestSchema_Load(t *testing.T) {
+	a := assert.New(t)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	var fetched int32
+	var schema atomic.Value
+	schema.Store(`{"type":"object","required":["temp"]}`)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		atomic.AddInt32(&fetched, 1)
+		switch r.URL.Path {
+		case "/schemas/temperature":
+			w.Write([]byte(schema.Load().(string)))
+		case "/schemas/position":
+			w.Write(positionDescriptor())
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "gmqtt_schema")
+	a.NoError(err)
+	defer os.RemoveAll(dir)
+	a.NoError(ioutil.WriteFile(path.Join(dir, "humidity.json"), []byte(`{"type":"number"}`), 0600))
+
+	cfg := DefaultConfig
+	cfg.Registry.URL = ts.URL + "/schemas/{subject}"
+	cfg.Registry.Headers = map[string]string{"Authorization": "Bearer token"}
+	cfg.Registry.RefreshInterval = 10 * time.Millisecond
+	cfg.Schemas = []SchemaConfig{
+		{TopicFilter: "sensors/+/temperature", Format: FormatJSON, Subject: "temperature"},
+		{TopicFilter: "sensors/+/position", Format: FormatProtobuf, Subject: "position", Message: "sensor.v1.Position"},
+		{TopicFilter: "sensors/+/humidity", Format: FormatJSON, File: "humidity.json"},
+	}
+	a.NoError(cfg.Validate())
+
+	srv := server.NewMockServer(ctrl)
+	srv.EXPECT().Publisher().Return(server.NewMockPublisher(ctrl)).AnyTimes()
+	s := &Schema{
+		config:    &cfg,
+		configDir: dir,
+		client:    http.DefaultClient,
+	}
+	a.NoError(s.Load(srv))
+
+	a.Nil(s.validator("sensors/1/unknown"))
+	a.Error(s.validator("sensors/1/temperature").validate([]byte(`{}`)))
+	a.NoError(s.validator("sensors/1/temperature").validate([]byte(`{"temp":1}`)))
+	a.Error(s.validator("sensors/1/position").validate([]byte(`hello`)))
+	a.Error(s.validator("sensors/1/humidity").validate([]byte(`"1"`)))
+
+	// the schema is refreshed
+	schema.Store(`{"type":"object","required":["temperature"]}`)
+	a.Eventually(func() bool {
+		return s.validator("sensors/1/temperature").validate([]byte(`{"temperature":1}`)) == nil
+	}, time.Second, 10*time.Millisecond)
+
+	// the schema in use is kept if the refresh fails
+	schema.Store(`{`)
+	n := atomic.LoadInt32(&fetched)
+	a.Eventually(func() bool {
+		return atomic.LoadInt32(&fetched) > n+2
+	}, time.Second, 10*time.Millisecond)
+	a.NoError(s.validator("sensors/1/temperature").validate([]byte(`{"temperature":1}`)))
+	a.NoError(s.Unload())
+
+	// fails to load
+	cfg.Registry.Headers = nil
+	s = &Schema{
+		config:    &cfg,
+		configDir: dir,
+		client:    http.DefaultClient,
+	}
+	a.Error(s.Load(srv))
+}
+
+func TestSchema_OnMsgArrivedWrapper(t *testing.T) {
+	a := assert.New(t)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	number, err := compileJSONSchema([]byte(`{"type":"number"}`))
+	a.NoError(err)
+	pub := server.NewMockPublisher(ctrl)
+	s := &Schema{
+		config: &Config{
+			Schemas: []SchemaConfig{
+				{TopicFilter: "a/#", Format: FormatJSON, Subject: "a"},
+			},
+		},
+		validators: []validator{number},
+		publisher:  pub,
+	}
+	client := newMockClient(ctrl)
+	onMsgArrived := s.OnMsgArrivedWrapper(func(ctx context.Context, client server.Client, req *server.MsgArrivedRequest) error {
+		return nil
+	})
+
+	for _, v := range []*gmqtt.Message{
+		{Topic: "a/b", Payload: []byte("1")},
+		{Topic: "b", Payload: []byte("x")},
+	} {
+		a.NoError(onMsgArrived(context.Background(), client, &server.MsgArrivedRequest{Message: v}))
+	}
+
+	msg := &gmqtt.Message{Topic: "a/b", Payload: []byte("x"), QoS: 1, Retained: true}
+	err = onMsgArrived(context.Background(), client, &server.MsgArrivedRequest{Message: msg})
+	a.Error(err)
+	a.Equal(codes.PayloadFormatInvalid, err.(*codes.Error).Code)
+	a.NotEmpty(err.(*codes.Error).ReasonString)
+
+	// route to dead-letter topic
+	s.config.DeadLetterTopic = "$dead_letter"
+	pub.EXPECT().Publish(gomock.Any()).Do(func(dl *gmqtt.Message) {
+		a.Equal("$dead_letter/a/b", dl.Topic)
+		a.Equal(msg.Payload, dl.Payload)
+		a.EqualValues(1, dl.QoS)
+		a.False(dl.Retained)
+		a.Len(dl.UserProperties, 1)
+		a.Equal(userPropertyError, string(dl.UserProperties[0].K))
+	})
+	a.Error(onMsgArrived(context.Background(), client, &server.MsgArrivedRequest{Message: msg}))
+	a.Equal("a/b", msg.Topic)
+	a.Len(msg.UserProperties, 0)
+}
+
+func TestSchema_OnWillPublishWrapper(t *testing.T) {
+	a := assert.New(t)
+	number, err := compileJSONSchema([]byte(`{"type":"number"}`))
+	a.NoError(err)
+	s := &Schema{
+		config: &Config{
+			Schemas: []SchemaConfig{
+				{TopicFilter: "a/#", Format: FormatJSON, Subject: "a"},
+			},
+		},
+		validators: []validator{number},
+	}
+	onWillPublish := s.OnWillPublishWrapper(func(ctx context.Context, clientID string, req *server.WillMsgRequest) {})
+	req := &server.WillMsgRequest{Message: &gmqtt.Message{Topic: "a/b", Payload: []byte("1")}}
+	onWillPublish(context.Background(), "cid", req)
+	a.NotNil(req.Message)
+
+	req = &server.WillMsgRequest{Message: &gmqtt.Message{Topic: "a/b", Payload: []byte("x")}}
+	onWillPublish(context.Background(), "cid", req)
+	a.Nil(req.Message)
+}
@@ -0,0 +1,245 @@
+package transform
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// maxCBORDepth is the maximum nesting depth of the CBOR data items.
+const maxCBORDepth = 64
+
+var errCBORTruncated = errors.New("cbor: unexpected end of data")
+
+// cborToJSON converts the CBOR (RFC 8949) data item into JSON.
+// The byte strings are encoded as base64 strings, the non-string map keys are formatted as strings,
+// the tags are ignored and undefined is converted to null.
+func cborToJSON(b []byte) ([]byte, error) {
+	d := &cborDecoder{b: b}
+	v, err := d.decode(0)
+	if err != nil {
+		return nil, err
+	}
+	if len(d.b) != 0 {
+		return nil, errors.New("cbor: trailing data")
+	}
+	return json.Marshal(v)
+}
+
+type cborDecoder struct {
+	b []byte
+}
+
+func (d *cborDecoder) next(n uint64) ([]byte, error) {
+	if uint64(len(d.b)) < n {
+		return nil, errCBORTruncated
+	}
+	b := d.b[:n]
+	d.b = d.b[n:]
+	return b, nil
+}
+
+// head reads the initial byte and the argument, indefinite is true if the additional information is 31.
+func (d *cborDecoder) head() (major byte, info byte, arg uint64, indefinite bool, err error) {
+	b, err := d.next(1)
+	if err != nil {
+		return 0, 0, 0, false, err
+	}
+	major, info = b[0]>>5, b[0]&0x1f
+	switch {
+	case info < 24:
+		return major, info, uint64(info), false, nil
+	case info <= 27:
+		n := uint64(1) << (info - 24)
+		b, err = d.next(n)
+		if err != nil {
+			return 0, 0, 0, false, err
+		}
+		for _, v := range b {
+			arg = arg<<8 | uint64(v)
+		}
+		return major, info, arg, false, nil
+	case info == 31:
+		return major, info, 0, true, nil
+	}
+	return 0, 0, 0, false, fmt.Errorf("cbor: invalid additional information: %d", info)
+}
+
+// isBreak consumes the break stop code if it is the next byte.
+func (d *cborDecoder) isBreak() (bool, error) {
+	if len(d.b) == 0 {
+		return false, errCBORTruncated
+	}
+	if d.b[0] == 0xff {
+		d.b = d.b[1:]
+		return true, nil
+	}
+	return false, nil
+}
+
+// length checks the number of the items against the remaining data, each item takes at least 1 byte.
+func (d *cborDecoder) length(n uint64) (int, error) {
+	if n > uint64(len(d.b)) {
+		return 0, errCBORTruncated
+	}
+	return int(n), nil
+}
+
+func (d *cborDecoder) decode(depth int) (interface{}, error) {
+	if depth > maxCBORDepth {
+		return nil, errors.New("cbor: exceeds the maximum nesting depth")
+	}
+	major, info, arg, indefinite, err := d.head()
+	if err != nil {
+		return nil, err
+	}
+	if indefinite && (major < 2 || major == 6) {
+		return nil, errors.New("cbor: invalid indefinite length")
+	}
+	switch major {
+	case 0:
+		return json.Number(strconv.FormatUint(arg, 10)), nil
+	case 1:
+		if arg == math.MaxUint64 {
+			return json.Number("-18446744073709551616"), nil
+		}
+		return json.Number("-" + strconv.FormatUint(arg+1, 10)), nil
+	case 2, 3:
+		s, err := d.decodeString(major, arg, indefinite)
+		if err != nil {
+			return nil, err
+		}
+		if major == 2 {
+			return base64.StdEncoding.EncodeToString(s), nil
+		}
+		return string(s), nil
+	case 4:
+		var arr []interface{}
+		for i := uint64(0); indefinite || i < arg; i++ {
+			if indefinite {
+				if brk, err := d.isBreak(); err != nil || brk {
+					if arr == nil {
+						arr = []interface{}{}
+					}
+					return arr, err
+				}
+			} else if _, err := d.length(arg - i); err != nil {
+				return nil, err
+			}
+			v, err := d.decode(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			arr = append(arr, v)
+		}
+		if arr == nil {
+			arr = []interface{}{}
+		}
+		return arr, nil
+	case 5:
+		m := make(map[string]interface{})
+		for i := uint64(0); indefinite || i < arg; i++ {
+			if indefinite {
+				if brk, err := d.isBreak(); err != nil || brk {
+					return m, err
+				}
+			} else if _, err := d.length(arg - i); err != nil {
+				return nil, err
+			}
+			k, err := d.decode(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			v, err := d.decode(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			switch k := k.(type) {
+			case string:
+				m[k] = v
+			default:
+				b, _ := json.Marshal(k)
+				m[string(b)] = v
+			}
+		}
+		return m, nil
+	case 6:
+		return d.decode(depth + 1)
+	default:
+		return d.decodeSimple(info, arg)
+	}
+}
+
+func (d *cborDecoder) decodeString(major byte, n uint64, indefinite bool) ([]byte, error) {
+	if !indefinite {
+		return d.next(n)
+	}
+	var s []byte
+	for {
+		if brk, err := d.isBreak(); err != nil || brk {
+			return s, err
+		}
+		m, _, arg, ind, err := d.head()
+		if err != nil {
+			return nil, err
+		}
+		if m != major || ind {
+			return nil, errors.New("cbor: invalid chunk of indefinite length string")
+		}
+		b, err := d.next(arg)
+		if err != nil {
+			return nil, err
+		}
+		s = append(s, b...)
+	}
+}
+
+func (d *cborDecoder) decodeSimple(info byte, arg uint64) (interface{}, error) {
+	var f float64
+	switch info {
+	case 20:
+		return false, nil
+	case 21:
+		return true, nil
+	case 22, 23:
+		return nil, nil
+	case 25:
+		f = halfToFloat(uint16(arg))
+	case 26:
+		f = float64(math.Float32frombits(uint32(arg)))
+	case 27:
+		f = math.Float64frombits(arg)
+	default:
+		return nil, fmt.Errorf("cbor: unsupported simple value: %d", arg)
+	}
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return nil, errors.New("cbor: NaN and Infinity are not supported by JSON")
+	}
+	return f, nil
+}
+
+// halfToFloat converts the IEEE 754 half-precision float.
+func halfToFloat(h uint16) float64 {
+	exp := int(h>>10) & 0x1f
+	mant := float64(h & 0x3ff)
+	var f float64
+	switch exp {
+	case 0:
+		f = math.Ldexp(mant, -24)
+	case 31:
+		if mant == 0 {
+			f = math.Inf(1)
+		} else {
+			f = math.NaN()
+		}
+	default:
+		f = math.Ldexp(mant+1024, exp-25)
+	}
+	if h&0x8000 != 0 {
+		return -f
+	}
+	return f
+}
@@ -0,0 +1,100 @@
+package transform
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCBORToJSON(t *testing.T) {
+	a := assert.New(t)
+	// test vectors from RFC 8949 Appendix A
+	var tt = []struct {
+		cbor string
+		json string
+	}{
+		{cbor: "00", json: `0`},
+		{cbor: "17", json: `23`},
+		{cbor: "1818", json: `24`},
+		{cbor: "1903e8", json: `1000`},
+		{cbor: "1bffffffffffffffff", json: `18446744073709551615`},
+		{cbor: "20", json: `-1`},
+		{cbor: "3903e7", json: `-1000`},
+		{cbor: "3bffffffffffffffff", json: `-18446744073709551616`},
+		{cbor: "f93c00", json: `1`},
+		{cbor: "f90001", json: `5.960464477539063e-8`},
+		{cbor: "f9c400", json: `-4`},
+		{cbor: "fa47c35000", json: `100000`},
+		{cbor: "fb3ff199999999999a", json: `1.1`},
+		{cbor: "f4", json: `false`},
+		{cbor: "f5", json: `true`},
+		{cbor: "f6", json: `null`},
+		{cbor: "f7", json: `null`},
+		{cbor: "4401020304", json: `"AQIDBA=="`},
+		{cbor: "6449455446", json: `"IETF"`},
+		{cbor: "62c3bc", json: `"ü"`},
+		{cbor: "80", json: `[]`},
+		{cbor: "83010203", json: `[1,2,3]`},
+		{cbor: "8301820203820405", json: `[1,[2,3],[4,5]]`},
+		{cbor: "a0", json: `{}`},
+		{cbor: "a201020304", json: `{"1":2,"3":4}`},
+		{cbor: "a26161016162820203", json: `{"a":1,"b":[2,3]}`},
+		{cbor: "c074323031332d30332d32315432303a30343a30305a", json: `"2013-03-21T20:04:00Z"`},
+		{cbor: "5f42010243030405ff", json: `"AQIDBAU="`},
+		{cbor: "7f657374726561646d696e67ff", json: `"streaming"`},
+		{cbor: "9fff", json: `[]`},
+		{cbor: "9f018202039f0405ffff", json: `[1,[2,3],[4,5]]`},
+		{cbor: "bf61610161629f0203ffff", json: `{"a":1,"b":[2,3]}`},
+	}
+	for _, v := range tt {
+		b, err := hex.DecodeString(v.cbor)
+		a.NoError(err)
+		j, err := cborToJSON(b)
+		a.NoError(err, v.cbor)
+		a.JSONEq(v.json, string(j), v.cbor)
+	}
+
+	for _, v := range []string{
+		"",
+		// truncated
+		"19",
+		"830102",
+		"a2010203",
+		"6449",
+		// trailing data
+		"0000",
+		// NaN and Infinity
+		"f97e00",
+		"f97c00",
+		"fa7f800000",
+		// unsupported simple value
+		"f0",
+		// invalid additional information
+		"1c",
+		// indefinite integer
+		"1f",
+		// chunk of different type
+		"5f6161ff",
+		// missing break
+		"9f01",
+		// huge length
+		"9bffffffffffffffff",
+	} {
+		b, err := hex.DecodeString(v)
+		a.NoError(err)
+		_, err = cborToJSON(b)
+		a.Error(err, v)
+	}
+
+	// nesting depth
+	deep := make([]byte, maxCBORDepth+2)
+	for k := range deep {
+		deep[k] = 0x81
+	}
+	deep[len(deep)-1] = 0x00
+	_, err := cborToJSON(deep)
+	a.Error(err)
+	_, err = cborToJSON(deep[1:])
+	a.NoError(err)
+}
@@ -0,0 +1,145 @@
+package transform
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/DrmagicE/gmqtt/pkg/packets"
+)
+
+const (
+	// StepGzipDecompress decompresses the gzip payload.
+	StepGzipDecompress = "gzip_decompress"
+	// StepCBORToJSON converts the CBOR payload to JSON.
+	StepCBORToJSON = "cbor_to_json"
+	// StepJSONFilter keeps only the fields of the JSON payload selected by the JSONPath expressions.
+	StepJSONFilter = "json_filter"
+	// StepJSONToProtobuf converts the JSON payload to the protobuf message.
+	StepJSONToProtobuf = "json_to_protobuf"
+	// StepProtobufToJSON converts the protobuf payload to JSON.
+	StepProtobufToJSON = "protobuf_to_json"
+)
+
+const (
+	// OnErrorReject rejects the message, the V5 client receives the PayloadFormatInvalid reason code.
+	OnErrorReject = "reject"
+	// OnErrorDrop drops the message silently.
+	OnErrorDrop = "drop"
+	// OnErrorPass delivers the original message.
+	OnErrorPass = "pass"
+)
+
+// Config is the configuration for the transform plugin.
+type Config struct {
+	// Pipelines is the list of the transformation pipelines.
+	// The first pipeline whose topic filter matches the topic of the message is applied.
+	Pipelines []PipelineConfig `yaml:"pipelines"`
+}
+
+// PipelineConfig binds a chain of transformation steps to a topic filter.
+type PipelineConfig struct {
+	TopicFilter string `yaml:"topic_filter"`
+	// Steps are applied to the payload in order.
+	Steps []StepConfig `yaml:"steps"`
+	// OnError is the action if any step fails, possible values are: reject, drop, pass.
+	OnError string `yaml:"on_error"`
+}
+
+// StepConfig is the configuration of a transformation step.
+type StepConfig struct {
+	// Type is the type of the step, possible values are:
+	// gzip_decompress, cbor_to_json, json_filter, json_to_protobuf, protobuf_to_json.
+	Type string `yaml:"type"`
+	// Fields is the list of the JSONPath expressions of the fields to keep, for json_filter.
+	// e.g: $.temperature, $.meta.id, $.readings[*].value
+	Fields []string `yaml:"fields"`
+	// DescriptorFile is the serialized protobuf FileDescriptorSet, for json_to_protobuf and protobuf_to_json.
+	// If it is a relative path, it locates in the same directory as the config file.
+	DescriptorFile string `yaml:"descriptor_file"`
+	// Message is the full name of the protobuf message, for json_to_protobuf and protobuf_to_json.
+	Message string `yaml:"message"`
+	// DiscardUnknown indicates whether to ignore the unknown JSON fields in json_to_protobuf.
+	DiscardUnknown bool `yaml:"discard_unknown"`
+}
+
+func (s *StepConfig) validate() error {
+	switch s.Type {
+	case StepGzipDecompress, StepCBORToJSON:
+	case StepJSONFilter:
+		if len(s.Fields) == 0 {
+			return errors.New("invalid fields: cannot be empty")
+		}
+		for _, v := range s.Fields {
+			if _, err := parseJSONPath(v); err != nil {
+				return fmt.Errorf("invalid field %s: %s", v, err)
+			}
+		}
+	case StepJSONToProtobuf, StepProtobufToJSON:
+		if s.DescriptorFile == "" {
+			return errors.New("invalid descriptor_file: cannot be empty")
+		}
+		if s.Message == "" {
+			return errors.New("invalid message: cannot be empty")
+		}
+	default:
+		return fmt.Errorf("invalid type: %s", s.Type)
+	}
+	return nil
+}
+
+func (p *PipelineConfig) validate() error {
+	if !packets.ValidTopicFilter(true, []byte(p.TopicFilter)) {
+		return fmt.Errorf("invalid topic_filter: %s", p.TopicFilter)
+	}
+	switch p.OnError {
+	case OnErrorReject, OnErrorDrop, OnErrorPass:
+	default:
+		return fmt.Errorf("invalid on_error: %s", p.OnError)
+	}
+	if len(p.Steps) == 0 {
+		return errors.New("invalid steps: cannot be empty")
+	}
+	for k, v := range p.Steps {
+		if err := v.validate(); err != nil {
+			return fmt.Errorf("step %d: %s", k, err)
+		}
+	}
+	return nil
+}
+
+// Validate validates the configuration, and return an error if it is invalid.
+func (c *Config) Validate() error {
+	for _, v := range c.Pipelines {
+		if err := v.validate(); err != nil {
+			return fmt.Errorf("pipeline %s: %s", v.TopicFilter, err)
+		}
+	}
+	return nil
+}
+
+// DefaultConfig is the default configuration.
+var DefaultConfig = Config{}
+
+func (p *PipelineConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type cfg PipelineConfig
+	v := cfg{OnError: OnErrorReject}
+	if err := unmarshal(&v); err != nil {
+		return err
+	}
+	*p = PipelineConfig(v)
+	return nil
+}
+
+func (c *Config) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type cfg Config
+	var v = &struct {
+		Transform cfg `yaml:"transform"`
+	}{
+		Transform: cfg(DefaultConfig),
+	}
+	if err := unmarshal(v); err != nil {
+		return err
+	}
+	*c = Config(v.Transform)
+	return nil
+}
@@ -0,0 +1,72 @@
+package transform
+
+import (
+	"context"
+
+	"go.uber.org/zap"
+
+	"github.com/DrmagicE/gmqtt"
+	"github.com/DrmagicE/gmqtt/pkg/codes"
+	"github.com/DrmagicE/gmqtt/server"
+)
+
+func (t *Transform) HookWrapper() server.HookWrapper {
+	return server.HookWrapper{
+		OnMsgArrivedWrapper:  t.OnMsgArrivedWrapper,
+		OnWillPublishWrapper: t.OnWillPublishWrapper,
+	}
+}
+
+// transform applies the pipeline to the message, it returns the pipeline which fails to apply and the error.
+func (t *Transform) transform(clientID string, msg *gmqtt.Message) (*pipeline, error) {
+	p := t.pipeline(msg.Topic)
+	if p == nil {
+		return nil, nil
+	}
+	err := p.apply(msg)
+	if err != nil {
+		log.Debug("failed to transform message",
+			zap.String("client_id", clientID),
+			zap.String("topic", msg.Topic),
+			zap.String("on_error", p.onError),
+			zap.Error(err))
+	}
+	return p, err
+}
+
+func (t *Transform) OnMsgArrivedWrapper(pre server.OnMsgArrived) server.OnMsgArrived {
+	return func(ctx context.Context, client server.Client, req *server.MsgArrivedRequest) error {
+		err := pre(ctx, client, req)
+		if err != nil || req.Message == nil {
+			return err
+		}
+		p, err := t.transform(client.ClientOptions().ClientID, req.Message)
+		if err == nil {
+			return nil
+		}
+		switch p.onError {
+		case OnErrorDrop:
+			req.Drop()
+		case OnErrorReject:
+			return &codes.Error{
+				Code: codes.PayloadFormatInvalid,
+				ErrorDetails: codes.ErrorDetails{
+					ReasonString: []byte(err.Error()),
+				},
+			}
+		}
+		return nil
+	}
+}
+
+func (t *Transform) OnWillPublishWrapper(pre server.OnWillPublish) server.OnWillPublish {
+	return func(ctx context.Context, clientID string, req *server.WillMsgRequest) {
+		pre(ctx, clientID, req)
+		if req.Message == nil {
+			return
+		}
+		if p, err := t.transform(clientID, req.Message); err != nil && p.onError != OnErrorPass {
+			req.Drop()
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package transform
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type segmentKind int
+
+const (
+	segmentKey segmentKind = iota
+	segmentIndex
+	segmentWildcard
+)
+
+// segment is a step of the JSONPath expression.
+type segment struct {
+	kind  segmentKind
+	key   string
+	index int
+}
+
+// parseJSONPath parses the subset of JSONPath: $, .name, ['name'], [n], .* and [*].
+func parseJSONPath(p string) ([]segment, error) {
+	if !strings.HasPrefix(p, "$") {
+		return nil, errors.New("must start with $")
+	}
+	var segs []segment
+	for s := p[1:]; s != ""; {
+		switch s[0] {
+		case '.':
+			s = s[1:]
+			end := strings.IndexAny(s, ".[")
+			if end == -1 {
+				end = len(s)
+			}
+			name := s[:end]
+			s = s[end:]
+			if name == "" {
+				return nil, errors.New("empty field name")
+			}
+			if name == "*" {
+				segs = append(segs, segment{kind: segmentWildcard})
+			} else {
+				segs = append(segs, segment{kind: segmentKey, key: name})
+			}
+		case '[':
+			end := strings.IndexByte(s, ']')
+			if end == -1 {
+				return nil, errors.New("missing ]")
+			}
+			v := s[1:end]
+			s = s[end+1:]
+			switch {
+			case v == "*":
+				segs = append(segs, segment{kind: segmentWildcard})
+			case len(v) >= 2 && (v[0] == '\'' || v[0] == '"') && v[len(v)-1] == v[0]:
+				segs = append(segs, segment{kind: segmentKey, key: v[1 : len(v)-1]})
+			default:
+				i, err := strconv.Atoi(v)
+				if err != nil || i < 0 {
+					return nil, errors.New("invalid index: " + v)
+				}
+				segs = append(segs, segment{kind: segmentIndex, index: i})
+			}
+		default:
+			return nil, errors.New("unexpected character: " + s[:1])
+		}
+	}
+	return segs, nil
+}
+
+// selected is a value which is selected as a whole.
+type selected struct {
+	v interface{}
+}
+
+// arrayNode holds the selected elements of an array by their indexes.
+type arrayNode map[int]interface{}
+
+// pick merges the values selected by the segments from src into dst,
+// it returns the new dst and whether anything is selected.
+func pick(src interface{}, segs []segment, dst interface{}) (interface{}, bool) {
+	if s, ok := dst.(selected); ok {
+		return s, true
+	}
+	if len(segs) == 0 {
+		return selected{v: src}, true
+	}
+	seg, rest := segs[0], segs[1:]
+	var found bool
+	switch s := src.(type) {
+	case map[string]interface{}:
+		if seg.kind == segmentIndex {
+			return dst, false
+		}
+		d, _ := dst.(map[string]interface{})
+		if d == nil {
+			d = make(map[string]interface{})
+		}
+		for k, v := range s {
+			if seg.kind == segmentWildcard || k == seg.key {
+				if c, ok := pick(v, rest, d[k]); ok {
+					d[k] = c
+					found = true
+				}
+			}
+		}
+		if found {
+			return d, true
+		}
+	case []interface{}:
+		if seg.kind == segmentKey {
+			return dst, false
+		}
+		d, _ := dst.(arrayNode)
+		if d == nil {
+			d = make(arrayNode)
+		}
+		for i, v := range s {
+			if seg.kind == segmentWildcard || i == seg.index {
+				if c, ok := pick(v, rest, d[i]); ok {
+					d[i] = c
+					found = true
+				}
+			}
+		}
+		if found {
+			return d, true
+		}
+	}
+	return dst, false
+}
+
+// build converts the result of pick into the JSON value, the selected array elements keep their order.
+func build(v interface{}) interface{} {
+	switch v := v.(type) {
+	case selected:
+		return v.v
+	case map[string]interface{}:
+		for k, e := range v {
+			v[k] = build(e)
+		}
+		return v
+	case arrayNode:
+		idx := make([]int, 0, len(v))
+		for k := range v {
+			idx = append(idx, k)
+		}
+		sort.Ints(idx)
+		arr := make([]interface{}, 0, len(idx))
+		for _, k := range idx {
+			arr = append(arr, build(v[k]))
+		}
+		return arr
+	}
+	return v
+}
@@ -0,0 +1,269 @@
+package transform
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+
+	"github.com/DrmagicE/gmqtt"
+	"github.com/DrmagicE/gmqtt/config"
+	"github.com/DrmagicE/gmqtt/pkg/packets"
+	"github.com/DrmagicE/gmqtt/server"
+)
+
+var _ server.Plugin = (*Transform)(nil)
+
+const Name = "transform"
+
+const (
+	contentTypeJSON     = "application/json"
+	contentTypeProtobuf = "application/x-protobuf"
+)
+
+func init() {
+	server.RegisterPlugin(Name, New)
+	config.RegisterDefaultPluginConfig(Name, &DefaultConfig)
+}
+
+func New(config config.Config) (server.Plugin, error) {
+	return &Transform{
+		config:    config.Plugins[Name].(*Config),
+		configDir: config.ConfigDir,
+		maxSize:   int64(config.MQTT.MaxPacketSize),
+	}, nil
+}
+
+var log *zap.Logger
+
+// step transforms the payload.
+type step struct {
+	typ   string
+	apply func(payload []byte) ([]byte, error)
+	// contentType and payloadFormat are set to the message if contentType is not empty.
+	contentType   string
+	payloadFormat packets.PayloadFormat
+}
+
+// pipeline is the compiled PipelineConfig.
+type pipeline struct {
+	topicFilter string
+	onError     string
+	steps       []step
+}
+
+// Transform applies the transformation pipelines to the payload of the messages between arrival and routing.
+type Transform struct {
+	config    *Config
+	configDir string
+	// maxSize is the maximum size of the decompressed payload.
+	maxSize   int64
+	pipelines []*pipeline
+}
+
+func (t *Transform) Load(service server.Server) error {
+	log = server.LoggerWithField(zap.String("plugin", Name))
+	for _, v := range t.config.Pipelines {
+		p, err := t.compile(v)
+		if err != nil {
+			return fmt.Errorf("failed to compile pipeline for %s: %s", v.TopicFilter, err)
+		}
+		t.pipelines = append(t.pipelines, p)
+	}
+	return nil
+}
+
+func (t *Transform) Unload() error {
+	return nil
+}
+
+func (t *Transform) Name() string {
+	return Name
+}
+
+func (t *Transform) compile(pc PipelineConfig) (*pipeline, error) {
+	p := &pipeline{
+		topicFilter: pc.TopicFilter,
+		onError:     pc.OnError,
+	}
+	for k, v := range pc.Steps {
+		s, err := t.compileStep(v)
+		if err != nil {
+			return nil, fmt.Errorf("step %d: %s", k, err)
+		}
+		s.typ = v.Type
+		p.steps = append(p.steps, s)
+	}
+	return p, nil
+}
+
+func (t *Transform) compileStep(sc StepConfig) (step, error) {
+	switch sc.Type {
+	case StepGzipDecompress:
+		return step{apply: t.gzipDecompress}, nil
+	case StepCBORToJSON:
+		return step{
+			apply:         cborToJSON,
+			contentType:   contentTypeJSON,
+			payloadFormat: packets.PayloadFormatString,
+		}, nil
+	case StepJSONFilter:
+		var paths [][]segment
+		for _, v := range sc.Fields {
+			segs, err := parseJSONPath(v)
+			if err != nil {
+				return step{}, fmt.Errorf("invalid field %s: %s", v, err)
+			}
+			paths = append(paths, segs)
+		}
+		return step{
+			apply: func(payload []byte) ([]byte, error) {
+				return jsonFilter(payload, paths)
+			},
+			contentType:   contentTypeJSON,
+			payloadFormat: packets.PayloadFormatString,
+		}, nil
+	case StepJSONToProtobuf, StepProtobufToJSON:
+		md, err := t.loadMessageDescriptor(sc.DescriptorFile, sc.Message)
+		if err != nil {
+			return step{}, err
+		}
+		if sc.Type == StepJSONToProtobuf {
+			opts := protojson.UnmarshalOptions{DiscardUnknown: sc.DiscardUnknown}
+			return step{
+				apply: func(payload []byte) ([]byte, error) {
+					msg := dynamicpb.NewMessage(md)
+					if err := opts.Unmarshal(payload, msg); err != nil {
+						return nil, err
+					}
+					return proto.Marshal(msg)
+				},
+				contentType:   contentTypeProtobuf,
+				payloadFormat: packets.PayloadFormatBytes,
+			}, nil
+		}
+		opts := protojson.MarshalOptions{UseProtoNames: true}
+		return step{
+			apply: func(payload []byte) ([]byte, error) {
+				msg := dynamicpb.NewMessage(md)
+				if err := proto.Unmarshal(payload, msg); err != nil {
+					return nil, err
+				}
+				return opts.Marshal(msg)
+			},
+			contentType:   contentTypeJSON,
+			payloadFormat: packets.PayloadFormatString,
+		}, nil
+	}
+	return step{}, fmt.Errorf("invalid type: %s", sc.Type)
+}
+
+// loadMessageDescriptor reads the message descriptor from the serialized FileDescriptorSet,
+// which can be generated by: protoc --include_imports --descriptor_set_out=<file> <proto files>
+func (t *Transform) loadMessageDescriptor(file string, message string) (protoreflect.MessageDescriptor, error) {
+	if !path.IsAbs(file) {
+		file = path.Join(t.configDir, file)
+	}
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	fds := &descriptorpb.FileDescriptorSet{}
+	if err := proto.Unmarshal(b, fds); err != nil {
+		return nil, fmt.Errorf("invalid file descriptor set: %s", err)
+	}
+	files, err := protodesc.NewFiles(fds)
+	if err != nil {
+		return nil, fmt.Errorf("invalid file descriptor set: %s", err)
+	}
+	d, err := files.FindDescriptorByName(protoreflect.FullName(message))
+	if err != nil {
+		return nil, fmt.Errorf("message %s not found: %s", message, err)
+	}
+	md, ok := d.(protoreflect.MessageDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a message", message)
+	}
+	return md, nil
+}
+
+// gzipDecompress decompresses the payload, the decompressed payload must not exceed the maximum packet size.
+func (t *Transform) gzipDecompress(payload []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	b, err := ioutil.ReadAll(io.LimitReader(r, t.maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(b)) > t.maxSize {
+		return nil, errors.New("decompressed payload exceeds the maximum packet size")
+	}
+	return b, nil
+}
+
+// jsonFilter keeps only the fields selected by the paths, it returns an error if nothing is selected.
+func jsonFilter(payload []byte, paths [][]segment) ([]byte, error) {
+	d := json.NewDecoder(bytes.NewReader(payload))
+	d.UseNumber()
+	var src interface{}
+	if err := d.Decode(&src); err != nil {
+		return nil, err
+	}
+	if d.More() {
+		return nil, errors.New("invalid JSON: trailing data")
+	}
+	var dst interface{}
+	var found bool
+	for _, v := range paths {
+		var ok bool
+		if dst, ok = pick(src, v, dst); ok {
+			found = true
+		}
+	}
+	if !found {
+		return nil, errors.New("no field is selected")
+	}
+	return json.Marshal(build(dst))
+}
+
+// pipeline returns the pipeline for the topic, nil if no pipeline is configured for the topic.
+func (t *Transform) pipeline(topic string) *pipeline {
+	for _, v := range t.pipelines {
+		if packets.TopicMatch([]byte(topic), []byte(v.topicFilter)) {
+			return v
+		}
+	}
+	return nil
+}
+
+// apply applies the steps to the payload of the message, the message is not modified if any step fails.
+func (p *pipeline) apply(msg *gmqtt.Message) error {
+	payload, contentType, payloadFormat := msg.Payload, msg.ContentType, msg.PayloadFormat
+	var err error
+	for _, v := range p.steps {
+		payload, err = v.apply(payload)
+		if err != nil {
+			return fmt.Errorf("%s: %s", v.typ, err)
+		}
+		if v.contentType != "" {
+			contentType, payloadFormat = v.contentType, v.payloadFormat
+		}
+	}
+	msg.Payload, msg.ContentType, msg.PayloadFormat = payload, contentType, payloadFormat
+	return nil
+}
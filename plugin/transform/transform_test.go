@@ -0,0 +1,339 @@
+package transform
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+
+	"github.com/DrmagicE/gmqtt"
+	"github.com/DrmagicE/gmqtt/pkg/codes"
+	"github.com/DrmagicE/gmqtt/pkg/packets"
+	"github.com/DrmagicE/gmqtt/server"
+)
+
+func init() {
+	log = zap.NewNop()
+}
+
+// readingDescriptor returns the serialized FileDescriptorSet of:
+//
+//	syntax = "proto3";
+//	package sensor.v1;
+//	message Reading {
+//		string device_id = 1;
+//		double value = 2;
+//	}
+func readingDescriptor() []byte {
+	fds := &descriptorpb.FileDescriptorSet{
+		File: []*descriptorpb.FileDescriptorProto{
+			{
+				Name:    proto.String("sensor.proto"),
+				Package: proto.String("sensor.v1"),
+				Syntax:  proto.String("proto3"),
+				MessageType: []*descriptorpb.DescriptorProto{
+					{
+						Name: proto.String("Reading"),
+						Field: []*descriptorpb.FieldDescriptorProto{
+							{
+								Name:     proto.String("device_id"),
+								JsonName: proto.String("deviceId"),
+								Number:   proto.Int32(1),
+								Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+								Type:     descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum(),
+							},
+							{
+								Name:     proto.String("value"),
+								JsonName: proto.String("value"),
+								Number:   proto.Int32(2),
+								Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+								Type:     descriptorpb.FieldDescriptorProto_TYPE_DOUBLE.Enum(),
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	b, err := proto.Marshal(fds)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+func gzipBytes(b []byte) []byte {
+	buf := &bytes.Buffer{}
+	w := gzip.NewWriter(buf)
+	w.Write(b)
+	w.Close()
+	return buf.Bytes()
+}
+
+func newMockClient(ctrl *gomock.Controller) *server.MockClient {
+	client := server.NewMockClient(ctrl)
+	client.EXPECT().ClientOptions().Return(&server.ClientOptions{ClientID: "cid"}).AnyTimes()
+	return client
+}
+
+func TestConfig_Validate(t *testing.T) {
+	a := assert.New(t)
+	a.NoError(DefaultConfig.Validate())
+
+	cfg := Config{
+		Pipelines: []PipelineConfig{
+			{
+				TopicFilter: "a/#",
+				OnError:     OnErrorReject,
+				Steps: []StepConfig{
+					{Type: StepGzipDecompress},
+					{Type: StepCBORToJSON},
+					{Type: StepJSONFilter, Fields: []string{"$.a", "$.b[*].c"}},
+					{Type: StepJSONToProtobuf, DescriptorFile: "a.pb", Message: "a.A"},
+				},
+			},
+		},
+	}
+	a.NoError(cfg.Validate())
+
+	var tt = []func(c *PipelineConfig){
+		func(c *PipelineConfig) { c.TopicFilter = "a/#/b" },
+		func(c *PipelineConfig) { c.OnError = "ignore" },
+		func(c *PipelineConfig) { c.Steps = nil },
+		func(c *PipelineConfig) { c.Steps[0].Type = "zip" },
+		func(c *PipelineConfig) { c.Steps[2].Fields = nil },
+		func(c *PipelineConfig) { c.Steps[2].Fields = []string{"a"} },
+		func(c *PipelineConfig) { c.Steps[2].Fields = []string{"$.b[x]"} },
+		func(c *PipelineConfig) { c.Steps[3].DescriptorFile = "" },
+		func(c *PipelineConfig) { c.Steps[3].Message = "" },
+	}
+	for k, v := range tt {
+		p := cfg.Pipelines[0]
+		p.Steps = append([]StepConfig{}, p.Steps...)
+		v(&p)
+		a.Error((&Config{Pipelines: []PipelineConfig{p}}).Validate(), k)
+	}
+}
+
+func TestJSONFilter(t *testing.T) {
+	a := assert.New(t)
+	payload := []byte(`{"id":"d1","meta":{"fw":"1.0","site":"s1","big":12345678901234567890},` +
+		`"readings":[{"t":1,"v":1.5},{"t":2,"v":2.5}],"tags":["x","y","z"]}`)
+	var tt = []struct {
+		fields []string
+		json   string
+	}{
+		{fields: []string{"$"}, json: string(payload)},
+		{fields: []string{"$.id"}, json: `{"id":"d1"}`},
+		{fields: []string{"$.id", "$['meta'].site", "$.unknown"}, json: `{"id":"d1","meta":{"site":"s1"}}`},
+		{fields: []string{"$.meta.big"}, json: `{"meta":{"big":12345678901234567890}}`},
+		{fields: []string{"$.readings[*].v"}, json: `{"readings":[{"v":1.5},{"v":2.5}]}`},
+		{fields: []string{"$.tags[2]", "$.tags[0]"}, json: `{"tags":["x","z"]}`},
+		{fields: []string{"$.meta.fw", "$.meta"}, json: `{"meta":{"fw":"1.0","site":"s1","big":12345678901234567890}}`},
+		{fields: []string{"$.meta", "$.meta.fw"}, json: `{"meta":{"fw":"1.0","site":"s1","big":12345678901234567890}}`},
+		{fields: []string{"$.*.site"}, json: `{"meta":{"site":"s1"}}`},
+	}
+	for _, v := range tt {
+		var paths [][]segment
+		for _, f := range v.fields {
+			segs, err := parseJSONPath(f)
+			a.NoError(err)
+			paths = append(paths, segs)
+		}
+		b, err := jsonFilter(payload, paths)
+		a.NoError(err, v.fields)
+		a.JSONEq(v.json, string(b), v.fields)
+	}
+
+	segs, err := parseJSONPath("$.unknown")
+	a.NoError(err)
+	_, err = jsonFilter(payload, [][]segment{segs})
+	a.Error(err)
+	_, err = jsonFilter([]byte(`{`), [][]segment{segs})
+	a.Error(err)
+	_, err = jsonFilter([]byte(`{} {}`), [][]segment{{}})
+	a.Error(err)
+
+	for _, v := range []string{"", "a", "$.", "$..a", "$[", "$[-1]", "$[a]", "$a"} {
+		_, err := parseJSONPath(v)
+		a.Error(err, v)
+	}
+}
+
+// protoFields splits the protobuf encoded message into fields,
+// the fields of the dynamic message are not encoded in the order of the field numbers.
+func protoFields(a *assert.Assertions, b []byte) [][]byte {
+	var rs [][]byte
+	for len(b) > 0 {
+		_, _, n := protowire.ConsumeField(b)
+		if !a.True(n > 0) {
+			return rs
+		}
+		rs = append(rs, b[:n])
+		b = b[n:]
+	}
+	return rs
+}
+
+func newTransform(a *assert.Assertions, ctrl *gomock.Controller, pipelines ...PipelineConfig) *Transform {
+	dir, err := ioutil.TempDir("", "gmqtt_transform")
+	a.NoError(err)
+	a.NoError(ioutil.WriteFile(path.Join(dir, "sensor.pb"), readingDescriptor(), 0600))
+	defer os.RemoveAll(dir)
+	cfg := &Config{Pipelines: pipelines}
+	a.NoError(cfg.Validate())
+	tr := &Transform{
+		config:    cfg,
+		configDir: dir,
+		maxSize:   64,
+	}
+	a.NoError(tr.Load(server.NewMockServer(ctrl)))
+	return tr
+}
+
+func TestTransform_Load(t *testing.T) {
+	a := assert.New(t)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	for _, v := range []StepConfig{
+		{Type: StepJSONToProtobuf, DescriptorFile: "unknown.pb", Message: "sensor.v1.Reading"},
+		{Type: StepProtobufToJSON, DescriptorFile: "sensor.pb", Message: "sensor.v1.Unknown"},
+		{Type: StepProtobufToJSON, DescriptorFile: "sensor.pb", Message: "sensor.v1.Reading.value"},
+	} {
+		tr := &Transform{
+			config: &Config{Pipelines: []PipelineConfig{
+				{TopicFilter: "#", OnError: OnErrorReject, Steps: []StepConfig{v}},
+			}},
+		}
+		a.Error(tr.Load(server.NewMockServer(ctrl)))
+	}
+}
+
+func TestTransform_OnMsgArrivedWrapper(t *testing.T) {
+	a := assert.New(t)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	tr := newTransform(a, ctrl,
+		PipelineConfig{
+			TopicFilter: "sensors/+/cbor",
+			OnError:     OnErrorReject,
+			Steps: []StepConfig{
+				{Type: StepGzipDecompress},
+				{Type: StepCBORToJSON},
+				{Type: StepJSONFilter, Fields: []string{"$.device_id", "$.value"}},
+				{Type: StepJSONToProtobuf, DescriptorFile: "sensor.pb", Message: "sensor.v1.Reading"},
+			},
+		},
+		PipelineConfig{
+			TopicFilter: "sensors/+/pb",
+			OnError:     OnErrorDrop,
+			Steps: []StepConfig{
+				{Type: StepProtobufToJSON, DescriptorFile: "sensor.pb", Message: "sensor.v1.Reading"},
+			},
+		},
+		PipelineConfig{
+			TopicFilter: "sensors/#",
+			OnError:     OnErrorPass,
+			Steps: []StepConfig{
+				{Type: StepGzipDecompress},
+			},
+		},
+	)
+	client := newMockClient(ctrl)
+	onMsgArrived := tr.OnMsgArrivedWrapper(func(ctx context.Context, client server.Client, req *server.MsgArrivedRequest) error {
+		return nil
+	})
+
+	// {"device_id": "d1", "value": 1.5, "extra": 1}
+	cbor := []byte{0xa3, 0x69, 'd', 'e', 'v', 'i', 'c', 'e', '_', 'i', 'd', 0x62, 'd', '1',
+		0x65, 'v', 'a', 'l', 'u', 'e', 0xf9, 0x3e, 0x00, 0x65, 'e', 'x', 't', 'r', 'a', 0x01}
+	// device_id = "d1", value = 1.5
+	pb := []byte{0x0a, 0x02, 'd', '1', 0x11, 0, 0, 0, 0, 0, 0, 0xf8, 0x3f}
+
+	req := &server.MsgArrivedRequest{Message: &gmqtt.Message{Topic: "sensors/1/cbor", Payload: gzipBytes(cbor), QoS: 1}}
+	a.NoError(onMsgArrived(context.Background(), client, req))
+	a.ElementsMatch(protoFields(a, pb), protoFields(a, req.Message.Payload))
+	a.Equal(contentTypeProtobuf, req.Message.ContentType)
+	a.Equal(packets.PayloadFormatBytes, req.Message.PayloadFormat)
+	a.EqualValues(1, req.Message.QoS)
+
+	req = &server.MsgArrivedRequest{Message: &gmqtt.Message{Topic: "sensors/1/pb", Payload: pb}}
+	a.NoError(onMsgArrived(context.Background(), client, req))
+	a.JSONEq(`{"device_id":"d1","value":1.5}`, string(req.Message.Payload))
+	a.Equal(contentTypeJSON, req.Message.ContentType)
+	a.Equal(packets.PayloadFormatString, req.Message.PayloadFormat)
+
+	req = &server.MsgArrivedRequest{Message: &gmqtt.Message{Topic: "sensors/1/raw", Payload: gzipBytes([]byte("raw"))}}
+	a.NoError(onMsgArrived(context.Background(), client, req))
+	a.Equal("raw", string(req.Message.Payload))
+
+	// no pipeline
+	req = &server.MsgArrivedRequest{Message: &gmqtt.Message{Topic: "a", Payload: []byte("a")}}
+	a.NoError(onMsgArrived(context.Background(), client, req))
+	a.Equal("a", string(req.Message.Payload))
+
+	// reject
+	req = &server.MsgArrivedRequest{Message: &gmqtt.Message{Topic: "sensors/1/cbor", Payload: cbor, ContentType: "application/cbor"}}
+	err := onMsgArrived(context.Background(), client, req)
+	a.Error(err)
+	a.Equal(codes.PayloadFormatInvalid, err.(*codes.Error).Code)
+	a.NotEmpty(err.(*codes.Error).ReasonString)
+	a.Equal(cbor, req.Message.Payload)
+	a.Equal("application/cbor", req.Message.ContentType)
+
+	// the decompressed payload exceeds the maximum size
+	req = &server.MsgArrivedRequest{Message: &gmqtt.Message{Topic: "sensors/1/cbor", Payload: gzipBytes(make([]byte, 65))}}
+	a.Error(onMsgArrived(context.Background(), client, req))
+
+	// drop
+	req = &server.MsgArrivedRequest{Message: &gmqtt.Message{Topic: "sensors/1/pb", Payload: []byte("x")}}
+	a.NoError(onMsgArrived(context.Background(), client, req))
+	a.Nil(req.Message)
+
+	// pass
+	req = &server.MsgArrivedRequest{Message: &gmqtt.Message{Topic: "sensors/1/raw", Payload: []byte("raw")}}
+	a.NoError(onMsgArrived(context.Background(), client, req))
+	a.Equal("raw", string(req.Message.Payload))
+}
+
+func TestTransform_OnWillPublishWrapper(t *testing.T) {
+	a := assert.New(t)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	tr := newTransform(a, ctrl,
+		PipelineConfig{
+			TopicFilter: "a/#",
+			OnError:     OnErrorReject,
+			Steps:       []StepConfig{{Type: StepGzipDecompress}},
+		},
+		PipelineConfig{
+			TopicFilter: "b/#",
+			OnError:     OnErrorPass,
+			Steps:       []StepConfig{{Type: StepGzipDecompress}},
+		},
+	)
+	onWillPublish := tr.OnWillPublishWrapper(func(ctx context.Context, clientID string, req *server.WillMsgRequest) {})
+
+	req := &server.WillMsgRequest{Message: &gmqtt.Message{Topic: "a/b", Payload: gzipBytes([]byte("offline"))}}
+	onWillPublish(context.Background(), "cid", req)
+	a.Equal("offline", string(req.Message.Payload))
+
+	req = &server.WillMsgRequest{Message: &gmqtt.Message{Topic: "a/b", Payload: []byte("offline")}}
+	onWillPublish(context.Background(), "cid", req)
+	a.Nil(req.Message)
+
+	req = &server.WillMsgRequest{Message: &gmqtt.Message{Topic: "b/c", Payload: []byte("offline")}}
+	onWillPublish(context.Background(), "cid", req)
+	a.Equal("offline", string(req.Message.Payload))
+}
@@ -0,0 +1,121 @@
+package vhost
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/DrmagicE/gmqtt/plugin/auth"
+)
+
+// Config is the configuration for the vhost plugin.
+type Config struct {
+	VHosts []VHostConfig `yaml:"vhosts"`
+	// Default is the name of the vhost for the clients which match no vhost.
+	// If empty, the clients which match no vhost are refused.
+	Default string `yaml:"default"`
+	// Hash is the hash type of the passwords without a known format prefix in the password files, see the auth plugin for details.
+	Hash string `yaml:"hash"`
+}
+
+// VHostConfig is the configuration of a virtual host.
+// The vhost of a client is selected by the listener label, the TLS SNI and the domain of the username (the part after the last '@'),
+// in that order.
+type VHostConfig struct {
+	// Name is the unique name of the vhost.
+	Name string `yaml:"name"`
+	// Listeners is the labels of the listeners which are dedicated to the vhost.
+	Listeners []string `yaml:"listeners"`
+	// ServerNames is the TLS server names (SNI) of the vhost.
+	ServerNames []string `yaml:"server_names"`
+	// Domains is the username domains of the vhost, e.g. "acme.com" selects the user "alice@acme.com".
+	Domains []string `yaml:"domains"`
+	// MountPoint is the topic space of the vhost, which is prefixed to the mount point of the client.
+	// Defaults to "vhosts/{name}/".
+	MountPoint string `yaml:"mount_point"`
+	// PasswordFile is the auth realm of the vhost, in the same format as the password file of the auth plugin.
+	// If it is set, the clients of the vhost must provide the username and password in the file.
+	// If it is a relative path, it locates in the same directory as the config file.
+	PasswordFile string `yaml:"password_file"`
+	// MaxConnections is the maximum number of the connected clients of the vhost, 0 means no limit.
+	MaxConnections int `yaml:"max_connections"`
+}
+
+func (v *VHostConfig) mountPoint() string {
+	if v.MountPoint != "" {
+		return v.MountPoint
+	}
+	return "vhosts/" + v.Name + "/"
+}
+
+// Validate validates the configuration, and return an error if it is invalid.
+func (c *Config) Validate() error {
+	if !auth.HashRegistered(c.Hash) {
+		return fmt.Errorf("invalid hash type: %s", c.Hash)
+	}
+	names := make(map[string]struct{})
+	selectors := make(map[string]string)
+	for _, v := range c.VHosts {
+		if v.Name == "" || strings.ContainsAny(v.Name, "/+#") {
+			return fmt.Errorf("invalid vhost name: %s", v.Name)
+		}
+		if _, ok := names[v.Name]; ok {
+			return fmt.Errorf("duplicated vhost: %s", v.Name)
+		}
+		names[v.Name] = struct{}{}
+		if strings.ContainsAny(v.MountPoint, "+#") {
+			return fmt.Errorf("invalid mount_point of vhost %s: %s", v.Name, v.MountPoint)
+		}
+		if v.MaxConnections < 0 {
+			return fmt.Errorf("invalid max_connections of vhost %s: %d", v.Name, v.MaxConnections)
+		}
+		for _, s := range []struct {
+			kind   string
+			values []string
+		}{
+			{"listener", v.Listeners},
+			{"server name", v.ServerNames},
+			{"domain", v.Domains},
+		} {
+			for _, value := range s.values {
+				key := s.kind + " " + strings.ToLower(value)
+				if other, ok := selectors[key]; ok {
+					return fmt.Errorf("%s %s is used by both vhost %s and %s", s.kind, value, other, v.Name)
+				}
+				selectors[key] = v.Name
+			}
+		}
+	}
+	if _, ok := names[c.Default]; c.Default != "" && !ok {
+		return fmt.Errorf("default vhost %s is not defined", c.Default)
+	}
+	if len(c.VHosts) == 0 {
+		return errors.New("at least one vhost is required")
+	}
+	return nil
+}
+
+// DefaultConfig is the default configuration.
+var DefaultConfig = Config{
+	VHosts: []VHostConfig{
+		{
+			Name: "default",
+		},
+	},
+	Default: "default",
+	Hash:    auth.Bcrypt,
+}
+
+func (c *Config) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type cfg Config
+	var v = &struct {
+		VHost cfg `yaml:"vhost"`
+	}{
+		VHost: cfg(DefaultConfig),
+	}
+	if err := unmarshal(v); err != nil {
+		return err
+	}
+	*c = Config(v.VHost)
+	return nil
+}
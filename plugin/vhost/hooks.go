@@ -0,0 +1,165 @@
+package vhost
+
+import (
+	"context"
+
+	"go.uber.org/zap"
+
+	"github.com/DrmagicE/gmqtt"
+	"github.com/DrmagicE/gmqtt/pkg/codes"
+	"github.com/DrmagicE/gmqtt/pkg/packets"
+	"github.com/DrmagicE/gmqtt/server"
+)
+
+func (v *VHost) HookWrapper() server.HookWrapper {
+	return server.HookWrapper{
+		OnBasicAuthWrapper:         v.OnBasicAuthWrapper,
+		OnConnectedWrapper:         v.OnConnectedWrapper,
+		OnClosedWrapper:            v.OnClosedWrapper,
+		OnSessionCreatedWrapper:    v.OnSessionCreatedWrapper,
+		OnSessionResumedWrapper:    v.OnSessionResumedWrapper,
+		OnSessionTerminatedWrapper: v.OnSessionTerminatedWrapper,
+		OnMsgArrivedWrapper:        v.OnMsgArrivedWrapper,
+		OnDeliveredWrapper:         v.OnDeliveredWrapper,
+	}
+}
+
+// refuse returns the CONNACK error of the refuse reason for the client version.
+func refuse(client server.Client, reason string) error {
+	var v3Code, v5Code codes.Code = codes.V3NotAuthorized, codes.NotAuthorized
+	switch reason {
+	case refusedClientID:
+		v3Code, v5Code = codes.V3IdentifierRejected, codes.ClientIdentifierNotValid
+	case refusedQuota:
+		v3Code, v5Code = codes.V3ServerUnavaliable, codes.QuotaExceeded
+	}
+	if packets.IsVersion3X(client.Version()) {
+		return &codes.Error{Code: v3Code}
+	}
+	return &codes.Error{Code: v5Code}
+}
+
+func (v *VHost) OnBasicAuthWrapper(pre server.OnBasicAuth) server.OnBasicAuth {
+	return func(ctx context.Context, client server.Client, req *server.ConnectRequest) (err error) {
+		err = pre(ctx, client, req)
+		if err != nil {
+			return err
+		}
+		username := string(req.Connect.Username)
+		vh := v.selectVHost(listenerLabel(ctx), client, username)
+		if vh == nil {
+			log.Debug("no vhost matched", zap.String("username", username))
+			return refuse(client, refusedAuth)
+		}
+		if vh.accounts != nil {
+			ok, err := vh.validate(username, string(req.Connect.Password), v.config.Hash)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				log.Debug("authentication failed", zap.String("vhost", vh.config.Name), zap.String("username", username))
+				vh.stats.refused(refusedAuth)
+				return refuse(client, refusedAuth)
+			}
+		}
+		v.mu.Lock()
+		reason := ""
+		if other, ok := v.sessions[string(req.Connect.ClientID)]; ok && other != vh {
+			reason = refusedClientID
+		} else if max := vh.config.MaxConnections; max != 0 && vh.connected >= max {
+			reason = refusedQuota
+		}
+		v.mu.Unlock()
+		if reason != "" {
+			log.Debug("connection refused",
+				zap.String("vhost", vh.config.Name),
+				zap.String("client_id", string(req.Connect.ClientID)),
+				zap.String("reason", reason))
+			vh.stats.refused(reason)
+			return refuse(client, reason)
+		}
+		req.Options.MountPoint = vh.mountPoint + req.Options.MountPoint
+		return nil
+	}
+}
+
+func (v *VHost) OnConnectedWrapper(pre server.OnConnected) server.OnConnected {
+	return func(ctx context.Context, client server.Client) {
+		pre(ctx, client)
+		vh := v.selectVHost(listenerLabel(ctx), client, client.ClientOptions().Username)
+		if vh == nil {
+			return
+		}
+		v.mu.Lock()
+		v.clients[client] = vh
+		vh.connected++
+		v.mu.Unlock()
+		vh.stats.connected()
+	}
+}
+
+func (v *VHost) OnClosedWrapper(pre server.OnClosed) server.OnClosed {
+	return func(ctx context.Context, client server.Client, reason *server.CloseReason) {
+		pre(ctx, client, reason)
+		v.mu.Lock()
+		if vh, ok := v.clients[client]; ok {
+			delete(v.clients, client)
+			vh.connected--
+		}
+		v.mu.Unlock()
+	}
+}
+
+func (v *VHost) OnSessionCreatedWrapper(pre server.OnSessionCreated) server.OnSessionCreated {
+	return func(ctx context.Context, client server.Client) {
+		pre(ctx, client)
+		v.bindSession(ctx, client)
+	}
+}
+
+func (v *VHost) OnSessionResumedWrapper(pre server.OnSessionResumed) server.OnSessionResumed {
+	return func(ctx context.Context, client server.Client, queued int) {
+		pre(ctx, client, queued)
+		v.bindSession(ctx, client)
+	}
+}
+
+// bindSession binds the session to the vhost of the client.
+// OnSessionCreated and OnSessionResumed are called before OnConnected, so the vhost is selected again.
+func (v *VHost) bindSession(ctx context.Context, client server.Client) {
+	vh := v.selectVHost(listenerLabel(ctx), client, client.ClientOptions().Username)
+	if vh == nil {
+		return
+	}
+	v.mu.Lock()
+	v.sessions[client.ClientOptions().ClientID] = vh
+	v.mu.Unlock()
+}
+
+func (v *VHost) OnSessionTerminatedWrapper(pre server.OnSessionTerminated) server.OnSessionTerminated {
+	return func(ctx context.Context, clientID string, reason server.SessionTerminatedReason) {
+		pre(ctx, clientID, reason)
+		v.mu.Lock()
+		delete(v.sessions, clientID)
+		v.mu.Unlock()
+	}
+}
+
+func (v *VHost) OnMsgArrivedWrapper(pre server.OnMsgArrived) server.OnMsgArrived {
+	return func(ctx context.Context, client server.Client, req *server.MsgArrivedRequest) error {
+		err := pre(ctx, client, req)
+		if vh := v.clientVHost(client); vh != nil {
+			vh.stats.received()
+		}
+		return err
+	}
+}
+
+func (v *VHost) OnDeliveredWrapper(pre server.OnDelivered) server.OnDelivered {
+	return func(ctx context.Context, client server.Client, msg *gmqtt.Message) {
+		pre(ctx, client, msg)
+		if vh := v.clientVHost(client); vh != nil {
+			vh.stats.sent()
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package vhost
+
+import (
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const metricPrefix = "gmqtt_vhost_"
+
+// The reasons of the refused connections.
+const (
+	refusedAuth     = "auth"
+	refusedClientID = "client_id"
+	refusedQuota    = "quota"
+)
+
+type stats struct {
+	connectedTotal   uint64
+	refusedAuth      uint64
+	refusedClientID  uint64
+	refusedQuota     uint64
+	messagesReceived uint64
+	messagesSent     uint64
+}
+
+func (s *stats) connected() {
+	atomic.AddUint64(&s.connectedTotal, 1)
+}
+
+func (s *stats) refused(reason string) {
+	switch reason {
+	case refusedAuth:
+		atomic.AddUint64(&s.refusedAuth, 1)
+	case refusedClientID:
+		atomic.AddUint64(&s.refusedClientID, 1)
+	case refusedQuota:
+		atomic.AddUint64(&s.refusedQuota, 1)
+	}
+}
+
+func (s *stats) received() {
+	atomic.AddUint64(&s.messagesReceived, 1)
+}
+
+func (s *stats) sent() {
+	atomic.AddUint64(&s.messagesSent, 1)
+}
+
+var (
+	clientsConnectedCurrentDesc = prometheus.NewDesc(metricPrefix+"clients_connected_current", "", []string{"vhost"}, nil)
+	clientsConnectedTotalDesc   = prometheus.NewDesc(metricPrefix+"clients_connected_total", "", []string{"vhost"}, nil)
+	connectionsRefusedDesc      = prometheus.NewDesc(metricPrefix+"connections_refused_total", "", []string{"vhost", "reason"}, nil)
+	messagesReceivedDesc        = prometheus.NewDesc(metricPrefix+"messages_received_total", "", []string{"vhost"}, nil)
+	messagesSentDesc            = prometheus.NewDesc(metricPrefix+"messages_sent_total", "", []string{"vhost"}, nil)
+)
+
+// Describe implements prometheus.Collector, the metrics are exposed by the prometheus plugin.
+func (v *VHost) Describe(desc chan<- *prometheus.Desc) {
+	prometheus.DescribeByCollect(v, desc)
+}
+
+// Collect implements prometheus.Collector.
+func (v *VHost) Collect(m chan<- prometheus.Metric) {
+	for _, vh := range v.vhosts {
+		name := vh.config.Name
+		v.mu.Lock()
+		connected := vh.connected
+		v.mu.Unlock()
+		m <- prometheus.MustNewConstMetric(clientsConnectedCurrentDesc, prometheus.GaugeValue, float64(connected), name)
+		m <- prometheus.MustNewConstMetric(clientsConnectedTotalDesc, prometheus.CounterValue,
+			float64(atomic.LoadUint64(&vh.stats.connectedTotal)), name)
+		for _, r := range []struct {
+			reason string
+			count  *uint64
+		}{
+			{refusedAuth, &vh.stats.refusedAuth},
+			{refusedClientID, &vh.stats.refusedClientID},
+			{refusedQuota, &vh.stats.refusedQuota},
+		} {
+			m <- prometheus.MustNewConstMetric(connectionsRefusedDesc, prometheus.CounterValue,
+				float64(atomic.LoadUint64(r.count)), name, r.reason)
+		}
+		m <- prometheus.MustNewConstMetric(messagesReceivedDesc, prometheus.CounterValue,
+			float64(atomic.LoadUint64(&vh.stats.messagesReceived)), name)
+		m <- prometheus.MustNewConstMetric(messagesSentDesc, prometheus.CounterValue,
+			float64(atomic.LoadUint64(&vh.stats.messagesSent)), name)
+	}
+}
@@ -0,0 +1,199 @@
+package vhost
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v2"
+
+	"github.com/DrmagicE/gmqtt/config"
+	"github.com/DrmagicE/gmqtt/plugin/auth"
+	"github.com/DrmagicE/gmqtt/server"
+)
+
+var _ server.Plugin = (*VHost)(nil)
+
+const Name = "vhost"
+
+func init() {
+	server.RegisterPlugin(Name, New)
+	config.RegisterDefaultPluginConfig(Name, &DefaultConfig)
+}
+
+func New(config config.Config) (server.Plugin, error) {
+	cfg := config.Plugins[Name].(*Config)
+	v := &VHost{
+		config:          cfg,
+		configDir:       config.ConfigDir,
+		byListener:      make(map[string]*vhost),
+		byServerName:    make(map[string]*vhost),
+		byDomain:        make(map[string]*vhost),
+		clients:         make(map[server.Client]*vhost),
+		sessions:        make(map[string]*vhost),
+		connectionState: server.TLSConnectionState,
+	}
+	for k := range cfg.VHosts {
+		vh := &vhost{
+			config:     &cfg.VHosts[k],
+			mountPoint: cfg.VHosts[k].mountPoint(),
+		}
+		v.vhosts = append(v.vhosts, vh)
+		for _, l := range vh.config.Listeners {
+			v.byListener[l] = vh
+		}
+		for _, s := range vh.config.ServerNames {
+			v.byServerName[strings.ToLower(s)] = vh
+		}
+		for _, d := range vh.config.Domains {
+			v.byDomain[strings.ToLower(d)] = vh
+		}
+		if vh.config.Name == cfg.Default {
+			v.defaultVHost = vh
+		}
+	}
+	return v, nil
+}
+
+var log *zap.Logger
+
+// vhost is a virtual host.
+type vhost struct {
+	config     *VHostConfig
+	mountPoint string
+	// accounts is the username/hashed password of the auth realm, nil if the realm is not set.
+	accounts map[string]string
+	// connected is the number of the connected clients, guarded by VHost.mu.
+	connected int
+	stats     stats
+}
+
+// VHost serves multiple isolated tenants in one broker process.
+// Each vhost has its own topic space (by the mount point), auth realm, connection quota and metrics.
+type VHost struct {
+	config    *Config
+	configDir string
+
+	vhosts       []*vhost
+	defaultVHost *vhost
+	byListener   map[string]*vhost
+	byServerName map[string]*vhost
+	byDomain     map[string]*vhost
+
+	mu sync.Mutex
+	// clients is the vhost of the connected clients.
+	clients map[server.Client]*vhost
+	// sessions is the vhost of the sessions by the client id,
+	// so that the clients of the other vhosts can not take over the sessions.
+	sessions map[string]*vhost
+
+	connectionState func(conn net.Conn) (tls.ConnectionState, bool)
+}
+
+func (v *VHost) Load(service server.Server) error {
+	log = server.LoggerWithField(zap.String("plugin", Name))
+	for _, vh := range v.vhosts {
+		if vh.config.PasswordFile == "" {
+			continue
+		}
+		file := vh.config.PasswordFile
+		if !path.IsAbs(file) {
+			file = path.Join(v.configDir, file)
+		}
+		accounts, err := loadPasswordFile(file)
+		if err != nil {
+			return fmt.Errorf("failed to load the password file of vhost %s: %s", vh.config.Name, err)
+		}
+		vh.accounts = accounts
+	}
+	if err := prometheus.DefaultRegisterer.Register(v); err != nil {
+		return err
+	}
+	log.Info("vhosts loaded", zap.Int("vhost_nums", len(v.vhosts)))
+	return nil
+}
+
+func (v *VHost) Unload() error {
+	prometheus.DefaultRegisterer.Unregister(v)
+	return nil
+}
+
+func (v *VHost) Name() string {
+	return Name
+}
+
+// loadPasswordFile reads the accounts from the password file of the auth plugin format.
+func loadPasswordFile(file string) (map[string]string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	b, err := ioutil.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	var acts []*auth.Account
+	if err = yaml.Unmarshal(b, &acts); err != nil {
+		return nil, err
+	}
+	accounts := make(map[string]string)
+	for _, v := range acts {
+		if v.Username == "" {
+			return nil, errors.New("detect empty username in password file")
+		}
+		if _, ok := accounts[v.Username]; ok {
+			return nil, fmt.Errorf("detect duplicated username in password file: %s", v.Username)
+		}
+		accounts[v.Username] = v.Password
+	}
+	return accounts, nil
+}
+
+// listenerLabel returns the label of the listener which accepts the client.
+func listenerLabel(ctx context.Context) string {
+	info, _ := server.RequestInfoFromContext(ctx)
+	return info.Listener
+}
+
+// selectVHost returns the vhost of the client, nil if no vhost matches.
+func (v *VHost) selectVHost(listener string, client server.Client, username string) *vhost {
+	if vh, ok := v.byListener[listener]; ok {
+		return vh
+	}
+	if state, ok := v.connectionState(client.Connection()); ok && state.ServerName != "" {
+		if vh, ok := v.byServerName[strings.ToLower(state.ServerName)]; ok {
+			return vh
+		}
+	}
+	if i := strings.LastIndexByte(username, '@'); i != -1 {
+		if vh, ok := v.byDomain[strings.ToLower(username[i+1:])]; ok {
+			return vh
+		}
+	}
+	return v.defaultVHost
+}
+
+// clientVHost returns the vhost of the connected client, nil if the client is not connected.
+func (v *VHost) clientVHost(client server.Client) *vhost {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.clients[client]
+}
+
+func (vh *vhost) validate(username, password, hash string) (bool, error) {
+	hashed, ok := vh.accounts[username]
+	if !ok {
+		return false, nil
+	}
+	return auth.ComparePassword(hash, hashed, password)
+}
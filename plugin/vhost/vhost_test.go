@@ -0,0 +1,168 @@
+package vhost
+
+import (
+	"context"
+	"crypto/tls"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+
+	"github.com/DrmagicE/gmqtt/config"
+	"github.com/DrmagicE/gmqtt/pkg/codes"
+	"github.com/DrmagicE/gmqtt/pkg/packets"
+	"github.com/DrmagicE/gmqtt/plugin/auth"
+	"github.com/DrmagicE/gmqtt/server"
+)
+
+func init() {
+	log = zap.NewNop()
+}
+
+func newVHost(t *testing.T, cfg Config, serverName string) *VHost {
+	c := config.DefaultConfig()
+	c.Plugins[Name] = &cfg
+	p, err := New(c)
+	assert.NoError(t, err)
+	v := p.(*VHost)
+	v.connectionState = func(conn net.Conn) (tls.ConnectionState, bool) {
+		if serverName == "" {
+			return tls.ConnectionState{}, false
+		}
+		return tls.ConnectionState{ServerName: serverName}, true
+	}
+	return v
+}
+
+func newMockClient(ctrl *gomock.Controller, version packets.Version, clientID, username string) *server.MockClient {
+	client := server.NewMockClient(ctrl)
+	client.EXPECT().Connection().Return(nil).AnyTimes()
+	client.EXPECT().Version().Return(version).AnyTimes()
+	client.EXPECT().ClientOptions().Return(&server.ClientOptions{ClientID: clientID, Username: username}).AnyTimes()
+	return client
+}
+
+func TestConfig_Validate(t *testing.T) {
+	a := assert.New(t)
+	a.NoError(DefaultConfig.Validate())
+	a.Error((&Config{Hash: auth.Bcrypt}).Validate())
+	a.Error((&Config{Hash: "unknown", VHosts: []VHostConfig{{Name: "a"}}}).Validate())
+	a.Error((&Config{Hash: auth.Bcrypt, VHosts: []VHostConfig{{Name: "a/b"}}}).Validate())
+	a.Error((&Config{Hash: auth.Bcrypt, VHosts: []VHostConfig{{Name: "a"}, {Name: "a"}}}).Validate())
+	a.Error((&Config{Hash: auth.Bcrypt, VHosts: []VHostConfig{{Name: "a", MountPoint: "t/+/"}}}).Validate())
+	a.Error((&Config{Hash: auth.Bcrypt, VHosts: []VHostConfig{{Name: "a"}}, Default: "b"}).Validate())
+	a.Error((&Config{Hash: auth.Bcrypt, VHosts: []VHostConfig{
+		{Name: "a", Domains: []string{"acme.com"}},
+		{Name: "b", Domains: []string{"ACME.com"}},
+	}}).Validate())
+	a.NoError((&Config{Hash: auth.Bcrypt, VHosts: []VHostConfig{
+		{Name: "a", Domains: []string{"acme.com"}},
+		{Name: "b", Listeners: []string{"acme.com"}},
+	}}).Validate())
+}
+
+func TestVHost_selectVHost(t *testing.T) {
+	a := assert.New(t)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	cfg := Config{
+		Hash: auth.Bcrypt,
+		VHosts: []VHostConfig{
+			{Name: "listener", Listeners: []string{"internal"}},
+			{Name: "sni", ServerNames: []string{"acme.example.com"}},
+			{Name: "domain", Domains: []string{"acme.com"}},
+			{Name: "default"},
+		},
+	}
+	client := newMockClient(ctrl, packets.Version5, "cid", "")
+
+	v := newVHost(t, cfg, "")
+	a.Nil(v.selectVHost(":1883", client, "alice"))
+	a.Equal("listener", v.selectVHost("internal", client, "alice@acme.com").config.Name)
+	a.Equal("domain", v.selectVHost(":1883", client, "alice@ACME.com").config.Name)
+
+	v = newVHost(t, cfg, "Acme.Example.com")
+	a.Equal("sni", v.selectVHost(":8883", client, "alice@acme.com").config.Name)
+
+	cfg.Default = "default"
+	v = newVHost(t, cfg, "")
+	a.Equal("default", v.selectVHost(":1883", client, "alice@other.com").config.Name)
+}
+
+func TestVHost_OnBasicAuthWrapper(t *testing.T) {
+	a := assert.New(t)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	dir, err := ioutil.TempDir("", "gmqtt_vhost")
+	a.NoError(err)
+	defer os.RemoveAll(dir)
+	hashed, err := auth.GeneratePassword(auth.Bcrypt, "password")
+	a.NoError(err)
+	a.NoError(ioutil.WriteFile(path.Join(dir, "acme.yml"), []byte("- username: alice@acme.com\n  password: "+hashed+"\n"), 0666))
+
+	v := newVHost(t, Config{
+		Hash: auth.Bcrypt,
+		VHosts: []VHostConfig{
+			{Name: "acme", Domains: []string{"acme.com"}, PasswordFile: path.Join(dir, "acme.yml"), MaxConnections: 1},
+			{Name: "other", MountPoint: "other/"},
+		},
+		Default: "other",
+	}, "")
+	a.NoError(v.Load(nil))
+	defer v.Unload()
+	fn := v.OnBasicAuthWrapper(func(ctx context.Context, client server.Client, req *server.ConnectRequest) (err error) {
+		return nil
+	})
+	connect := func(version packets.Version, clientID, username, password string) (*server.ConnectRequest, error) {
+		req := &server.ConnectRequest{
+			Connect: &packets.Connect{
+				Version:  version,
+				ClientID: []byte(clientID),
+				Username: []byte(username),
+				Password: []byte(password),
+			},
+			Options: &server.AuthOptions{MountPoint: "{client_id}/"},
+		}
+		return req, fn(context.Background(), newMockClient(ctrl, version, clientID, username), req)
+	}
+
+	_, err = connect(packets.Version311, "cid1", "alice@acme.com", "wrong")
+	a.Equal(&codes.Error{Code: codes.V3NotAuthorized}, err)
+	_, err = connect(packets.Version5, "cid1", "bob@acme.com", "password")
+	a.Equal(&codes.Error{Code: codes.NotAuthorized}, err)
+
+	req, err := connect(packets.Version5, "cid1", "alice@acme.com", "password")
+	a.NoError(err)
+	a.Equal("vhosts/acme/{client_id}/", req.Options.MountPoint)
+	req, err = connect(packets.Version5, "cid2", "bob", "")
+	a.NoError(err)
+	a.Equal("other/{client_id}/", req.Options.MountPoint)
+
+	// connection quota
+	acmeClient := newMockClient(ctrl, packets.Version5, "cid1", "alice@acme.com")
+	v.OnConnectedWrapper(func(ctx context.Context, client server.Client) {})(context.Background(), acmeClient)
+	v.OnSessionCreatedWrapper(func(ctx context.Context, client server.Client) {})(context.Background(), acmeClient)
+	_, err = connect(packets.Version5, "cid3", "alice@acme.com", "password")
+	a.Equal(&codes.Error{Code: codes.QuotaExceeded}, err)
+
+	// the session of the acme vhost can not be taken over by the other vhost.
+	_, err = connect(packets.Version311, "cid1", "bob", "")
+	a.Equal(&codes.Error{Code: codes.V3IdentifierRejected}, err)
+
+	v.OnClosedWrapper(func(ctx context.Context, client server.Client, reason *server.CloseReason) {})(context.Background(), acmeClient, nil)
+	_, err = connect(packets.Version5, "cid3", "alice@acme.com", "password")
+	a.NoError(err)
+	v.OnSessionTerminatedWrapper(func(ctx context.Context, clientID string, reason server.SessionTerminatedReason) {})(context.Background(), "cid1", server.NormalTermination)
+	_, err = connect(packets.Version5, "cid1", "bob", "")
+	a.NoError(err)
+
+	a.EqualValues(1, v.vhosts[0].stats.refusedQuota)
+	a.EqualValues(2, v.vhosts[0].stats.refusedAuth)
+	a.EqualValues(1, v.vhosts[1].stats.refusedClientID)
+}
@@ -44,12 +44,11 @@ func (t *topicNode) newChild() *topicNode {
 // find walk through the tire and return the node that represent the topicName
 // return nil if not found
 func (t *topicTrie) find(topicName string) *topicNode {
-	topicSlice := strings.Split(topicName, "/")
 	var pNode = t
-	for _, lv := range topicSlice {
-		if _, ok := pNode.children[lv]; ok {
-			pNode = pNode.children[lv]
-		} else {
+	for rest, last := topicName, false; !last; {
+		var lv string
+		lv, rest, last = nextLevel(rest)
+		if pNode = pNode.children[lv]; pNode == nil {
 			return nil
 		}
 	}
@@ -59,10 +58,19 @@ func (t *topicTrie) find(topicName string) *topicNode {
 	return nil
 }
 
+// nextLevel returns the first level of the topic and the remaining part.
+// last reports whether the returned level is the last level.
+func nextLevel(topic string) (level, rest string, last bool) {
+	if i := strings.IndexByte(topic, '/'); i >= 0 {
+		return topic[:i], topic[i+1:], false
+	}
+	return topic, "", true
+}
+
 // matchTopic walk through the tire and call the fn callback for each message witch match the topic filter.
-func (t *topicTrie) matchTopic(topicSlice []string, fn retained.IterateFn) {
-	endFlag := len(topicSlice) == 1
-	switch topicSlice[0] {
+func (t *topicTrie) matchTopic(topicFilter string, fn retained.IterateFn) {
+	level, rest, endFlag := nextLevel(topicFilter)
+	switch level {
 	case "#":
 		t.preOrderTraverse(fn)
 	case "+":
@@ -73,26 +81,25 @@ func (t *topicTrie) matchTopic(topicSlice []string, fn retained.IterateFn) {
 					fn(v.msg)
 				}
 			} else {
-				v.matchTopic(topicSlice[1:], fn)
+				v.matchTopic(rest, fn)
 			}
 		}
 	default:
-		if n := t.children[topicSlice[0]]; n != nil {
+		if n := t.children[level]; n != nil {
 			if endFlag {
 				if n.msg != nil {
 					fn(n.msg)
 				}
 			} else {
-				n.matchTopic(topicSlice[1:], fn)
+				n.matchTopic(rest, fn)
 			}
 		}
 	}
 }
 
 func (t *topicTrie) getMatchedMessages(topicFilter string) []*gmqtt.Message {
-	topicLv := strings.Split(topicFilter, "/")
 	var rs []*gmqtt.Message
-	t.matchTopic(topicLv, func(message *gmqtt.Message) bool {
+	t.matchTopic(topicFilter, func(message *gmqtt.Message) bool {
 		rs = append(rs, message.Copy())
 		return true
 	})
@@ -9,6 +9,7 @@ import (
 	"net/http"
 	"strings"
 
+	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware"
 	grpc_zap "github.com/grpc-ecosystem/go-grpc-middleware/logging/zap"
 	grpc_prometheus "github.com/grpc-ecosystem/go-grpc-prometheus"
 	"github.com/grpc-ecosystem/grpc-gateway/runtime"
@@ -28,11 +29,16 @@ type APIRegistrar interface {
 	RegisterHTTPHandler(fn HTTPHandler) error
 	// RegisterService registers a service and its implementation to all gRPC servers.
 	RegisterService(desc *grpc.ServiceDesc, impl interface{})
+	// RegisterUnaryInterceptor registers the unary interceptor to all gRPC servers.
+	// The HTTP requests are also intercepted because they are proxied to the gRPC servers.
+	// The interceptors are invoked in the registering order. It must be called in Plugin.Load.
+	RegisterUnaryInterceptor(interceptor grpc.UnaryServerInterceptor)
 }
 
 type apiRegistrar struct {
-	gRPCServers []*gRPCServer
-	httpServers []*httpServer
+	gRPCServers  []*gRPCServer
+	httpServers  []*httpServer
+	interceptors []grpc.UnaryServerInterceptor
 }
 
 // RegisterService implements APIRegistrar interface
@@ -42,6 +48,19 @@ func (a *apiRegistrar) RegisterService(desc *grpc.ServiceDesc, impl interface{})
 	}
 }
 
+// RegisterUnaryInterceptor implements APIRegistrar interface
+func (a *apiRegistrar) RegisterUnaryInterceptor(interceptor grpc.UnaryServerInterceptor) {
+	a.interceptors = append(a.interceptors, interceptor)
+}
+
+// unaryInterceptor invokes the interceptors registered by plugins.
+func (a *apiRegistrar) unaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if len(a.interceptors) == 0 {
+		return handler(ctx, req)
+	}
+	return grpc_middleware.ChainUnaryServer(a.interceptors...)(ctx, req, info, handler)
+}
+
 // RegisterHTTPHandler implements APIRegistrar interface
 func (a *apiRegistrar) RegisterHTTPHandler(fn HTTPHandler) error {
 	var err error
@@ -89,7 +108,7 @@ func splitEndpoint(endpoint string) (schema string, addr string) {
 	return epParts[0], epParts[1]
 }
 
-func buildTLSConfig(cfg *config.TLSOptions) (*tls.Config, error) {
+func buildTLSConfig(cfg *config.TLSOptions, crypto config.Crypto) (*tls.Config, error) {
 	c, err := tls.LoadX509KeyPair(cfg.Cert, cfg.Key)
 	if err != nil {
 		return nil, err
@@ -111,13 +130,16 @@ func buildTLSConfig(cfg *config.TLSOptions) (*tls.Config, error) {
 		ClientCAs:    certPool,
 		ClientAuth:   cliAuthType,
 	}
+	if err = crypto.ApplyTLS(tlsCfg); err != nil {
+		return nil, err
+	}
 	return tlsCfg, nil
 }
 
-func buildGRPCServer(endpoint *config.Endpoint) (*gRPCServer, error) {
+func buildGRPCServer(endpoint *config.Endpoint, crypto config.Crypto, interceptor grpc.UnaryServerInterceptor) (*gRPCServer, error) {
 	var cred credentials.TransportCredentials
 	if cfg := endpoint.TLS; cfg != nil {
-		tlsCfg, err := buildTLSConfig(cfg)
+		tlsCfg, err := buildTLSConfig(cfg, crypto)
 		if err != nil {
 			return nil, err
 		}
@@ -132,7 +154,8 @@ func buildGRPCServer(endpoint *config.Endpoint) (*gRPCServer, error) {
 				}
 				return grpc_zap.DefaultClientCodeToLevel(code)
 			})),
-			grpc_prometheus.UnaryServerInterceptor),
+			grpc_prometheus.UnaryServerInterceptor,
+			interceptor),
 	)
 	grpc_prometheus.Register(server)
 	shutdown := func() {
@@ -161,11 +184,11 @@ func buildGRPCServer(endpoint *config.Endpoint) (*gRPCServer, error) {
 	}, nil
 }
 
-func buildHTTPServer(endpoint *config.Endpoint) (*httpServer, error) {
+func buildHTTPServer(endpoint *config.Endpoint, crypto config.Crypto) (*httpServer, error) {
 	var tlsCfg *tls.Config
 	var err error
 	if cfg := endpoint.TLS; cfg != nil {
-		tlsCfg, err = buildTLSConfig(cfg)
+		tlsCfg, err = buildTLSConfig(cfg, crypto)
 		if err != nil {
 			return nil, err
 		}
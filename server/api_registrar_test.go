@@ -218,6 +218,34 @@ func TestApiRegistrar_RegisterHTTPHandler(t *testing.T) {
 	}))
 }
 
+func TestApiRegistrar_RegisterUnaryInterceptor(t *testing.T) {
+	a := assert.New(t)
+	reg := &apiRegistrar{}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return req.(string) + "handler", nil
+	}
+	rs, err := reg.unaryInterceptor(context.Background(), "", &grpc.UnaryServerInfo{}, handler)
+	a.NoError(err)
+	a.Equal("handler", rs)
+
+	for _, v := range []string{"1", "2"} {
+		v := v
+		reg.RegisterUnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			return handler(ctx, req.(string)+v)
+		})
+	}
+	rs, err = reg.unaryInterceptor(context.Background(), "", &grpc.UnaryServerInfo{}, handler)
+	a.NoError(err)
+	a.Equal("12handler", rs)
+
+	errDenied := errors.New("denied")
+	reg.RegisterUnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		return nil, errDenied
+	})
+	_, err = reg.unaryInterceptor(context.Background(), "", &grpc.UnaryServerInfo{}, handler)
+	a.Equal(errDenied, err)
+}
+
 func TestBuildTLSConfig(t *testing.T) {
 
 	t.Run("verify_false", func(t *testing.T) {
@@ -228,7 +256,7 @@ func TestBuildTLSConfig(t *testing.T) {
 			Key:    "./testdata/server-key.pem",
 			Verify: false,
 		}
-		tlsCfg, err := buildTLSConfig(cfg)
+		tlsCfg, err := buildTLSConfig(cfg, config.DefaultCrypto())
 		a.NoError(err)
 		a.EqualValues(0, tlsCfg.ClientAuth)
 		a.Len(tlsCfg.Certificates, 1)
@@ -242,12 +270,24 @@ func TestBuildTLSConfig(t *testing.T) {
 			Key:    "./testdata/server-key.pem",
 			Verify: true,
 		}
-		tlsCfg, err := buildTLSConfig(cfg)
+		tlsCfg, err := buildTLSConfig(cfg, config.DefaultCrypto())
 		a.NoError(err)
 		a.EqualValues(tls.RequireAndVerifyClientCert, tlsCfg.ClientAuth)
 		a.Len(tlsCfg.Certificates, 1)
 	})
 
+	t.Run("fips", func(t *testing.T) {
+		a := assert.New(t)
+		cfg := &config.TLSOptions{
+			Cert: "./testdata/server-cert.pem",
+			Key:  "./testdata/server-key.pem",
+		}
+		tlsCfg, err := buildTLSConfig(cfg, config.Crypto{Policy: config.CryptoPolicyFIPS})
+		a.NoError(err)
+		a.EqualValues(tls.VersionTLS12, tlsCfg.MinVersion)
+		a.NotEmpty(tlsCfg.CipherSuites)
+	})
+
 	t.Run("add_cacert", func(t *testing.T) {
 		a := assert.New(t)
 		cfg := &config.TLSOptions{
@@ -255,7 +295,7 @@ func TestBuildTLSConfig(t *testing.T) {
 			Cert:   "./testdata/server-cert.pem",
 			Key:    "./testdata/server-key.pem",
 		}
-		tlsCfg, err := buildTLSConfig(cfg)
+		tlsCfg, err := buildTLSConfig(cfg, config.DefaultCrypto())
 		a.NoError(err)
 		a.Len(tlsCfg.Certificates, 1)
 		opts := x509.VerifyOptions{
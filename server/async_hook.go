@@ -0,0 +1,270 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"sync"
+
+	"go.uber.org/zap"
+
+	"github.com/DrmagicE/gmqtt"
+	"github.com/DrmagicE/gmqtt/config"
+)
+
+// AsyncHookNames is the names of the hooks that can be registered as asynchronous by HookWrapper.AsyncHooks.
+// They are the hooks that have no return value and do not expect the params to be modified.
+var AsyncHookNames = []string{
+	"OnConnected",
+	"OnSessionCreated",
+	"OnSessionResumed",
+	"OnSessionTerminated",
+	"OnSessionExpired",
+	"OnOfflineBacklog",
+	"OnSessionTakenOver",
+	"OnSubscribed",
+	"OnUnsubscribed",
+	"OnMsgDropped",
+	"OnDelivered",
+	"OnDeliveryReport",
+	"OnClosed",
+	"OnWillPublished",
+}
+
+// hookPool is the worker pool that executes the asynchronous hooks.
+// Hooks of the same client are always executed by the same worker to keep the order.
+type hookPool struct {
+	dropOnFull bool
+	workers    []chan func()
+	closing    chan struct{}
+	closeOnce  sync.Once
+	wg         sync.WaitGroup
+}
+
+func newHookPool(cfg config.AsyncHook) *hookPool {
+	p := &hookPool{
+		dropOnFull: cfg.FullPolicy == config.AsyncHookDrop,
+		workers:    make([]chan func(), cfg.Workers),
+		closing:    make(chan struct{}),
+	}
+	p.wg.Add(cfg.Workers)
+	for i := range p.workers {
+		p.workers[i] = make(chan func(), cfg.QueueSize)
+		go p.work(p.workers[i])
+	}
+	return p
+}
+
+func (p *hookPool) work(ch chan func()) {
+	defer p.wg.Done()
+	for {
+		select {
+		case fn := <-ch:
+			fn()
+		case <-p.closing:
+			// drain the pending hook calls
+			for {
+				select {
+				case fn := <-ch:
+					fn()
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// submit sends the hook call to the worker which is responsible for the client.
+// If the queue of the worker is full, it blocks or drops the call according to the full policy.
+func (p *hookPool) submit(hook string, clientID string, fn func()) {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(clientID))
+	ch := p.workers[h.Sum32()%uint32(len(p.workers))]
+	select {
+	case <-p.closing:
+		// the pool is closed, execute it on the caller goroutine.
+		fn()
+		return
+	default:
+	}
+	if p.dropOnFull {
+		select {
+		case ch <- fn:
+		default:
+			zaplog.Warn("async hook dropped: the worker queue is full",
+				zap.String("hook", hook),
+				zap.String("client_id", clientID))
+		}
+		return
+	}
+	select {
+	case ch <- fn:
+	case <-p.closing:
+		// the pool is closed, execute it on the caller goroutine.
+		fn()
+	}
+}
+
+// close stops all workers after the pending hook calls have been executed.
+func (p *hookPool) close() {
+	p.closeOnce.Do(func() {
+		close(p.closing)
+	})
+	p.wg.Wait()
+}
+
+// asyncHookWrapper replaces the wrappers listed in hooks.AsyncHooks with the asynchronous version.
+// The asynchronous version calls the previous hook in the chain synchronously
+// and submits the plugin's own hook to the pool, so the hooks of other plugins are not affected.
+func (p *hookPool) asyncHookWrapper(hooks HookWrapper) (HookWrapper, error) {
+	for _, name := range hooks.AsyncHooks {
+		name := name
+		switch name {
+		case "OnConnected":
+			if w := hooks.OnConnectedWrapper; w != nil {
+				hooks.OnConnectedWrapper = func(pre OnConnected) OnConnected {
+					fn := w(func(ctx context.Context, client Client) {})
+					return func(ctx context.Context, client Client) {
+						pre(ctx, client)
+						p.submit(name, client.ClientOptions().ClientID, func() { fn(ctx, client) })
+					}
+				}
+			}
+		case "OnSessionCreated":
+			if w := hooks.OnSessionCreatedWrapper; w != nil {
+				hooks.OnSessionCreatedWrapper = func(pre OnSessionCreated) OnSessionCreated {
+					fn := w(func(ctx context.Context, client Client) {})
+					return func(ctx context.Context, client Client) {
+						pre(ctx, client)
+						p.submit(name, client.ClientOptions().ClientID, func() { fn(ctx, client) })
+					}
+				}
+			}
+		case "OnSessionResumed":
+			if w := hooks.OnSessionResumedWrapper; w != nil {
+				hooks.OnSessionResumedWrapper = func(pre OnSessionResumed) OnSessionResumed {
+					fn := w(func(ctx context.Context, client Client, queued int) {})
+					return func(ctx context.Context, client Client, queued int) {
+						pre(ctx, client, queued)
+						p.submit(name, client.ClientOptions().ClientID, func() { fn(ctx, client, queued) })
+					}
+				}
+			}
+		case "OnSessionTerminated":
+			if w := hooks.OnSessionTerminatedWrapper; w != nil {
+				hooks.OnSessionTerminatedWrapper = func(pre OnSessionTerminated) OnSessionTerminated {
+					fn := w(func(ctx context.Context, clientID string, reason SessionTerminatedReason) {})
+					return func(ctx context.Context, clientID string, reason SessionTerminatedReason) {
+						pre(ctx, clientID, reason)
+						p.submit(name, clientID, func() { fn(ctx, clientID, reason) })
+					}
+				}
+			}
+		case "OnSessionExpired":
+			if w := hooks.OnSessionExpiredWrapper; w != nil {
+				hooks.OnSessionExpiredWrapper = func(pre OnSessionExpired) OnSessionExpired {
+					fn := w(func(ctx context.Context, clientID string, session *gmqtt.Session) {})
+					return func(ctx context.Context, clientID string, session *gmqtt.Session) {
+						pre(ctx, clientID, session)
+						p.submit(name, clientID, func() { fn(ctx, clientID, session) })
+					}
+				}
+			}
+		case "OnOfflineBacklog":
+			if w := hooks.OnOfflineBacklogWrapper; w != nil {
+				hooks.OnOfflineBacklogWrapper = func(pre OnOfflineBacklog) OnOfflineBacklog {
+					fn := w(func(ctx context.Context, clientID string, queued int) {})
+					return func(ctx context.Context, clientID string, queued int) {
+						pre(ctx, clientID, queued)
+						p.submit(name, clientID, func() { fn(ctx, clientID, queued) })
+					}
+				}
+			}
+		case "OnSessionTakenOver":
+			if w := hooks.OnSessionTakenOverWrapper; w != nil {
+				hooks.OnSessionTakenOverWrapper = func(pre OnSessionTakenOver) OnSessionTakenOver {
+					fn := w(func(ctx context.Context, client Client, info *TakeoverInfo) {})
+					return func(ctx context.Context, client Client, info *TakeoverInfo) {
+						pre(ctx, client, info)
+						p.submit(name, client.ClientOptions().ClientID, func() { fn(ctx, client, info) })
+					}
+				}
+			}
+		case "OnSubscribed":
+			if w := hooks.OnSubscribedWrapper; w != nil {
+				hooks.OnSubscribedWrapper = func(pre OnSubscribed) OnSubscribed {
+					fn := w(func(ctx context.Context, client Client, subscription *gmqtt.Subscription) {})
+					return func(ctx context.Context, client Client, subscription *gmqtt.Subscription) {
+						pre(ctx, client, subscription)
+						p.submit(name, client.ClientOptions().ClientID, func() { fn(ctx, client, subscription) })
+					}
+				}
+			}
+		case "OnUnsubscribed":
+			if w := hooks.OnUnsubscribedWrapper; w != nil {
+				hooks.OnUnsubscribedWrapper = func(pre OnUnsubscribed) OnUnsubscribed {
+					fn := w(func(ctx context.Context, client Client, topicName string) {})
+					return func(ctx context.Context, client Client, topicName string) {
+						pre(ctx, client, topicName)
+						p.submit(name, client.ClientOptions().ClientID, func() { fn(ctx, client, topicName) })
+					}
+				}
+			}
+		case "OnMsgDropped":
+			if w := hooks.OnMsgDroppedWrapper; w != nil {
+				hooks.OnMsgDroppedWrapper = func(pre OnMsgDropped) OnMsgDropped {
+					fn := w(func(ctx context.Context, clientID string, msg *gmqtt.Message, err error) {})
+					return func(ctx context.Context, clientID string, msg *gmqtt.Message, err error) {
+						pre(ctx, clientID, msg, err)
+						p.submit(name, clientID, func() { fn(ctx, clientID, msg, err) })
+					}
+				}
+			}
+		case "OnDelivered":
+			if w := hooks.OnDeliveredWrapper; w != nil {
+				hooks.OnDeliveredWrapper = func(pre OnDelivered) OnDelivered {
+					fn := w(func(ctx context.Context, client Client, msg *gmqtt.Message) {})
+					return func(ctx context.Context, client Client, msg *gmqtt.Message) {
+						pre(ctx, client, msg)
+						p.submit(name, client.ClientOptions().ClientID, func() { fn(ctx, client, msg) })
+					}
+				}
+			}
+		case "OnDeliveryReport":
+			if w := hooks.OnDeliveryReportWrapper; w != nil {
+				hooks.OnDeliveryReportWrapper = func(pre OnDeliveryReport) OnDeliveryReport {
+					fn := w(func(ctx context.Context, client Client, report *DeliveryReport) {})
+					return func(ctx context.Context, client Client, report *DeliveryReport) {
+						pre(ctx, client, report)
+						p.submit(name, client.ClientOptions().ClientID, func() { fn(ctx, client, report) })
+					}
+				}
+			}
+		case "OnClosed":
+			if w := hooks.OnClosedWrapper; w != nil {
+				hooks.OnClosedWrapper = func(pre OnClosed) OnClosed {
+					fn := w(func(ctx context.Context, client Client, reason *CloseReason) {})
+					return func(ctx context.Context, client Client, reason *CloseReason) {
+						pre(ctx, client, reason)
+						p.submit(name, client.ClientOptions().ClientID, func() { fn(ctx, client, reason) })
+					}
+				}
+			}
+		case "OnWillPublished":
+			if w := hooks.OnWillPublishedWrapper; w != nil {
+				hooks.OnWillPublishedWrapper = func(pre OnWillPublished) OnWillPublished {
+					fn := w(func(ctx context.Context, clientID string, msg *gmqtt.Message) {})
+					return func(ctx context.Context, clientID string, msg *gmqtt.Message) {
+						pre(ctx, clientID, msg)
+						p.submit(name, clientID, func() { fn(ctx, clientID, msg) })
+					}
+				}
+			}
+		default:
+			return hooks, fmt.Errorf("hook %s can not be asynchronous, available hooks: %s", name, strings.Join(AsyncHookNames, ","))
+		}
+	}
+	return hooks, nil
+}
@@ -0,0 +1,97 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DrmagicE/gmqtt/config"
+)
+
+func TestHookPool_asyncHookWrapper(t *testing.T) {
+	a := assert.New(t)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	p := newHookPool(config.DefaultAsyncHookConfig)
+	defer p.close()
+
+	client := NewMockClient(ctrl)
+	client.EXPECT().ClientOptions().Return(&ClientOptions{ClientID: "cli"}).AnyTimes()
+
+	release := make(chan struct{})
+	var order []int
+	done := make(chan struct{})
+	hooks, err := p.asyncHookWrapper(HookWrapper{
+		OnConnectedWrapper: func(pre OnConnected) OnConnected {
+			return func(ctx context.Context, client Client) {
+				pre(ctx, client)
+				<-release
+				order = append(order, 1)
+			}
+		},
+		OnClosedWrapper: func(pre OnClosed) OnClosed {
+			return func(ctx context.Context, client Client, reason *CloseReason) {
+				pre(ctx, client, reason)
+				order = append(order, 2)
+				close(done)
+			}
+		},
+		AsyncHooks: []string{"OnConnected", "OnClosed"},
+	})
+	a.Nil(err)
+
+	var preCalled bool
+	onConnected := hooks.OnConnectedWrapper(func(ctx context.Context, client Client) {
+		preCalled = true
+	})
+	onClosed := hooks.OnClosedWrapper(func(ctx context.Context, client Client, reason *CloseReason) {})
+	// the previous hook is called synchronously and the caller is not blocked by the slow hook.
+	onConnected(context.Background(), client)
+	a.True(preCalled)
+	onClosed(context.Background(), client, nil)
+	close(release)
+	<-done
+	// the hooks of the same client are executed in order.
+	a.Equal([]int{1, 2}, order)
+
+	_, err = p.asyncHookWrapper(HookWrapper{AsyncHooks: []string{"OnMsgArrived"}})
+	a.NotNil(err)
+}
+
+func TestHookPool_submit_drop(t *testing.T) {
+	a := assert.New(t)
+	p := newHookPool(config.AsyncHook{
+		Workers:    1,
+		QueueSize:  1,
+		FullPolicy: config.AsyncHookDrop,
+	})
+	release := make(chan struct{})
+	started := make(chan struct{})
+	p.submit("OnConnected", "cli", func() {
+		close(started)
+		<-release
+	})
+	<-started
+	var queued, called bool
+	p.submit("OnConnected", "cli", func() {
+		queued = true
+	})
+	// dropped because the worker is busy and the queue is full.
+	p.submit("OnConnected", "cli", func() {
+		called = true
+	})
+	close(release)
+	p.close()
+	a.True(queued)
+	a.False(called)
+
+	// executed on the caller goroutine after closed.
+	p = newHookPool(config.DefaultAsyncHookConfig)
+	p.close()
+	p.submit("OnConnected", "cli", func() {
+		called = true
+	})
+	a.True(called)
+}
@@ -0,0 +1,66 @@
+package server
+
+import (
+	"errors"
+
+	"go.uber.org/zap"
+)
+
+// ErrSessionNotFound is returned if the session of the given client id is not found.
+var ErrSessionNotFound = errors.New("session not found")
+
+func (client *client) Attributes() map[string]string {
+	attrs, _ := client.attributes.Load().(map[string]string)
+	return attrs
+}
+
+// copyAttributes returns a copy of the attributes, nil if it is empty.
+func copyAttributes(attrs map[string]string) map[string]string {
+	if len(attrs) == 0 {
+		return nil
+	}
+	c := make(map[string]string, len(attrs))
+	for k, v := range attrs {
+		c[k] = v
+	}
+	return c
+}
+
+// mergeAttributes returns a new map which contains the attributes of base overridden by override, nil if it is empty.
+func mergeAttributes(base, override map[string]string) map[string]string {
+	if len(override) == 0 {
+		return copyAttributes(base)
+	}
+	c := copyAttributes(base)
+	if c == nil {
+		c = make(map[string]string, len(override))
+	}
+	for k, v := range override {
+		c[k] = v
+	}
+	return c
+}
+
+// SetAttributes replaces the attributes of the session for the given client id.
+func (c *clientService) SetAttributes(clientID string, attrs map[string]string) error {
+	s := c.srv.registry.shard(clientID)
+	s.lock()
+	defer s.unlock()
+	sess, err := c.sessionStore.Get(clientID)
+	if err != nil {
+		return err
+	}
+	// the redis store returns an empty session if not found.
+	if sess == nil || sess.ClientID == "" {
+		return ErrSessionNotFound
+	}
+	attrs = copyAttributes(attrs)
+	if err = c.sessionStore.SetAttributes(clientID, attrs); err != nil {
+		return err
+	}
+	if cli, ok := s.clients[clientID]; ok {
+		cli.attributes.Store(attrs)
+	}
+	zaplog.Info("attributes updated", zap.String("client_id", clientID), zap.Int("attributes", len(attrs)))
+	return nil
+}
@@ -0,0 +1,54 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DrmagicE/gmqtt"
+	sessmem "github.com/DrmagicE/gmqtt/persistence/session/mem"
+)
+
+func TestMergeAttributes(t *testing.T) {
+	a := assert.New(t)
+	a.Nil(mergeAttributes(nil, nil))
+	a.Nil(mergeAttributes(map[string]string{}, nil))
+	base := map[string]string{"a": "1", "b": "2"}
+	a.Equal(base, mergeAttributes(base, nil))
+	a.Equal(map[string]string{"a": "1", "b": "3", "c": "4"}, mergeAttributes(base, map[string]string{"b": "3", "c": "4"}))
+	// the base is not modified.
+	a.Equal(map[string]string{"a": "1", "b": "2"}, base)
+}
+
+func TestClientService_SetAttributes(t *testing.T) {
+	a := assert.New(t)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	cid := "cli"
+	srv := newTestDeliverMsg(ctrl, cid).srv
+	srv.sessionStore = sessmem.New()
+	c := &clientService{srv: srv, sessionStore: srv.sessionStore}
+
+	a.Equal(ErrSessionNotFound, c.SetAttributes(cid, map[string]string{"a": "1"}))
+
+	a.NoError(srv.sessionStore.Set(&gmqtt.Session{ClientID: cid}))
+	cli, err := srv.newClient(noopConn{})
+	a.NoError(err)
+	cli.opts.ClientID = cid
+	srv.registry.shard(cid).clients[cid] = cli
+
+	attrs := map[string]string{"a": "1"}
+	a.NoError(c.SetAttributes(cid, attrs))
+	attrs["a"] = "2"
+	a.Equal(map[string]string{"a": "1"}, cli.Attributes())
+	sess, err := srv.sessionStore.Get(cid)
+	a.NoError(err)
+	a.Equal(map[string]string{"a": "1"}, sess.Attributes)
+
+	a.NoError(c.SetAttributes(cid, nil))
+	a.Nil(cli.Attributes())
+	sess, err = srv.sessionStore.Get(cid)
+	a.NoError(err)
+	a.Nil(sess.Attributes)
+}
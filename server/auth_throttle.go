@@ -0,0 +1,148 @@
+package server
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/DrmagicE/gmqtt/config"
+)
+
+type authFailures struct {
+	// failures is the time of the failures in the sliding window.
+	failures    []time.Time
+	lockedUntil time.Time
+}
+
+// authThrottle tracks the failed authentications per username and per IP.
+// A nil throttle means the throttling is disabled.
+type authThrottle struct {
+	config  config.AuthThrottling
+	log     config.LogConfig
+	sts     *statsManager
+	mu      sync.Mutex
+	entries map[string]*authFailures
+}
+
+func newAuthThrottle(cfg config.AuthThrottling, logCfg config.LogConfig, sts *statsManager) *authThrottle {
+	return &authThrottle{
+		config:  cfg,
+		log:     logCfg,
+		sts:     sts,
+		entries: make(map[string]*authFailures),
+	}
+}
+
+func authThrottleKeys(username, ip string) []string {
+	var keys []string
+	if username != "" {
+		keys = append(keys, "username:"+username)
+	}
+	if ip != "" {
+		keys = append(keys, "ip:"+ip)
+	}
+	return keys
+}
+
+// redactKey redacts the username or the ip in the key for logging.
+func (a *authThrottle) redactKey(key string) string {
+	if strings.HasPrefix(key, "username:") {
+		return "username:" + redactUsername(a.log, strings.TrimPrefix(key, "username:"))
+	}
+	return "ip:" + redactRemoteAddr(a.log, strings.TrimPrefix(key, "ip:"))
+}
+
+// locked returns whether the username or the ip is locked out.
+func (a *authThrottle) locked(now time.Time, username, ip string) bool {
+	if a == nil {
+		return false
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, k := range authThrottleKeys(username, ip) {
+		if e := a.entries[k]; e != nil && now.Before(e.lockedUntil) {
+			return true
+		}
+	}
+	return false
+}
+
+// failed records a failed authentication and returns the delay before responding to the client.
+func (a *authThrottle) failed(now time.Time, username, ip string) (delay time.Duration) {
+	if a == nil {
+		return 0
+	}
+	atomic.AddUint64(&a.sts.totalStats.ConnectionStats.AuthFailedTotal, 1)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var maxFailures int
+	for _, k := range authThrottleKeys(username, ip) {
+		e := a.entries[k]
+		if e == nil {
+			e = &authFailures{}
+			a.entries[k] = e
+		}
+		e.prune(now, a.config.Window)
+		e.failures = append(e.failures, now)
+		if n := len(e.failures); n > maxFailures {
+			maxFailures = n
+		}
+		if len(e.failures) >= a.config.MaxFailures {
+			e.lockedUntil = now.Add(a.config.LockoutDuration)
+			e.failures = nil
+			atomic.AddUint64(&a.sts.totalStats.ConnectionStats.AuthLockoutTotal, 1)
+			zaplog.Warn("too many authentication failures, lockout",
+				zap.String("key", a.redactKey(k)),
+				zap.Duration("duration", a.config.LockoutDuration))
+		}
+	}
+	delay = a.config.Delay * time.Duration(maxFailures)
+	if delay > a.config.MaxDelay {
+		delay = a.config.MaxDelay
+	}
+	zaplog.Info("authentication failed",
+		zap.String("username", redactUsername(a.log, username)),
+		zap.String("remote_ip", redactRemoteAddr(a.log, ip)),
+		zap.Int("failures", maxFailures),
+		zap.Duration("delay", delay))
+	return delay
+}
+
+// succeeded resets the failures of the username.
+func (a *authThrottle) succeeded(username string) {
+	if a == nil || username == "" {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.entries, "username:"+username)
+}
+
+// prune removes the failures that are out of the sliding window.
+func (e *authFailures) prune(now time.Time, window time.Duration) {
+	i := 0
+	for ; i < len(e.failures); i++ {
+		if now.Sub(e.failures[i]) < window {
+			break
+		}
+	}
+	e.failures = e.failures[i:]
+}
+
+// clean removes the entries that are neither locked out nor having failures in the window.
+func (a *authThrottle) clean(now time.Time) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for k, e := range a.entries {
+		e.prune(now, a.config.Window)
+		if len(e.failures) == 0 && !now.Before(e.lockedUntil) {
+			delete(a.entries, k)
+		}
+	}
+}
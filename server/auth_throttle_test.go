@@ -0,0 +1,75 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DrmagicE/gmqtt/config"
+	"github.com/DrmagicE/gmqtt/persistence/subscription/mem"
+)
+
+func newTestAuthThrottle() *authThrottle {
+	return newAuthThrottle(config.AuthThrottling{
+		Enable:          true,
+		Window:          time.Minute,
+		MaxFailures:     3,
+		LockoutDuration: 10 * time.Minute,
+		Delay:           time.Second,
+		MaxDelay:        2 * time.Second,
+	}, config.LogConfig{}, newStatsManager(mem.NewStore()))
+}
+
+func TestAuthThrottle_failed(t *testing.T) {
+	a := assert.New(t)
+	var nilThrottle *authThrottle
+	a.False(nilThrottle.locked(time.Now(), "user", "127.0.0.1"))
+	a.Zero(nilThrottle.failed(time.Now(), "user", "127.0.0.1"))
+
+	at := newTestAuthThrottle()
+	now := time.Unix(0, 0)
+	a.Equal(time.Second, at.failed(now, "user", "10.0.0.1"))
+	a.Equal(2*time.Second, at.failed(now.Add(30*time.Second), "user", "10.0.0.2"))
+	a.False(at.locked(now, "user", "10.0.0.3"))
+
+	// the first failure is out of the sliding window
+	now = now.Add(time.Minute)
+	a.Equal(2*time.Second, at.failed(now, "user", "10.0.0.3"))
+	a.False(at.locked(now, "user", "10.0.0.4"))
+
+	// lockout
+	a.Equal(2*time.Second, at.failed(now, "user", "10.0.0.4"))
+	a.True(at.locked(now, "user", "10.0.0.5"))
+	a.False(at.locked(now, "user2", "10.0.0.5"))
+	a.False(at.locked(now.Add(10*time.Minute), "user", "10.0.0.5"))
+
+	stats := at.sts.GetGlobalStats().ConnectionStats
+	a.EqualValues(4, stats.AuthFailedTotal)
+	a.EqualValues(1, stats.AuthLockoutTotal)
+}
+
+func TestAuthThrottle_lockoutByIP(t *testing.T) {
+	a := assert.New(t)
+	at := newTestAuthThrottle()
+	now := time.Unix(0, 0)
+	at.failed(now, "user1", "10.0.0.1")
+	at.failed(now, "user2", "10.0.0.1")
+	at.failed(now, "user3", "10.0.0.1")
+	a.True(at.locked(now, "user4", "10.0.0.1"))
+	a.False(at.locked(now, "user1", "10.0.0.2"))
+}
+
+func TestAuthThrottle_succeeded(t *testing.T) {
+	a := assert.New(t)
+	at := newTestAuthThrottle()
+	now := time.Unix(0, 0)
+	at.failed(now, "user", "10.0.0.1")
+	at.failed(now, "user", "10.0.0.2")
+	at.succeeded("user")
+	at.failed(now, "user", "10.0.0.3")
+	a.False(at.locked(now, "user", "10.0.0.4"))
+
+	at.clean(now.Add(time.Minute))
+	a.Empty(at.entries)
+}
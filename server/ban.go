@@ -0,0 +1,107 @@
+package server
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// banList records the client ids which are banned at runtime by the ClientService.
+type banList struct {
+	// n is the number of the bans, it is the fast path for the case that no client is banned.
+	n  int32
+	mu sync.RWMutex
+	// clientIDs is the time when the ban expires by the client id, zero time means never expire.
+	clientIDs map[string]time.Time
+}
+
+func newBanList() *banList {
+	return &banList{
+		clientIDs: make(map[string]time.Time),
+	}
+}
+
+func (b *banList) updateLen() {
+	atomic.StoreInt32(&b.n, int32(len(b.clientIDs)))
+}
+
+// removeExpiredLocked removes the expired bans, must call after mu is locked.
+func (b *banList) removeExpiredLocked(now time.Time) {
+	for k, v := range b.clientIDs {
+		if !v.IsZero() && !now.Before(v) {
+			delete(b.clientIDs, k)
+		}
+	}
+}
+
+// ban bans the client id until the given time, zero time means never expire.
+func (b *banList) ban(clientID string, until time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.removeExpiredLocked(time.Now())
+	b.clientIDs[clientID] = until
+	b.updateLen()
+}
+
+// unban removes the ban of the client id, and returns whether the client id was banned.
+func (b *banList) unban(clientID string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.removeExpiredLocked(time.Now())
+	_, ok := b.clientIDs[clientID]
+	delete(b.clientIDs, clientID)
+	b.updateLen()
+	return ok
+}
+
+// banned returns whether the client id is banned.
+func (b *banList) banned(clientID string, now time.Time) bool {
+	if b == nil || atomic.LoadInt32(&b.n) == 0 {
+		return false
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	until, ok := b.clientIDs[clientID]
+	return ok && (until.IsZero() || now.Before(until))
+}
+
+// list returns the bans which are not expired.
+func (b *banList) list(now time.Time) map[string]time.Time {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	rs := make(map[string]time.Time, len(b.clientIDs))
+	for k, v := range b.clientIDs {
+		if v.IsZero() || now.Before(v) {
+			rs[k] = v
+		}
+	}
+	return rs
+}
+
+// Ban rejects the connections of the client id for the given duration.
+func (c *clientService) Ban(clientID string, duration time.Duration) {
+	var until time.Time
+	if duration > 0 {
+		until = time.Now().Add(duration)
+	}
+	c.srv.bans.ban(clientID, until)
+	zaplog.Info("client banned",
+		zap.String("client_id", clientID),
+		zap.Duration("duration", duration))
+}
+
+// Unban removes the ban of the client id.
+func (c *clientService) Unban(clientID string) bool {
+	ok := c.srv.bans.unban(clientID)
+	if ok {
+		zaplog.Info("client unbanned", zap.String("client_id", clientID))
+	}
+	return ok
+}
+
+// ListBans returns the banned client ids.
+func (c *clientService) ListBans() map[string]time.Time {
+	return c.srv.bans.list(time.Now())
+}
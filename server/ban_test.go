@@ -0,0 +1,44 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBanList(t *testing.T) {
+	a := assert.New(t)
+	var nilList *banList
+	a.False(nilList.banned("a", time.Now()))
+
+	b := newBanList()
+	now := time.Now()
+	a.False(b.banned("a", now))
+	b.ban("a", time.Time{})
+	b.ban("b", now.Add(time.Minute))
+	a.True(b.banned("a", now.Add(time.Hour)))
+	a.True(b.banned("b", now))
+	a.False(b.banned("b", now.Add(time.Minute)))
+	a.Equal(map[string]time.Time{"a": {}}, b.list(now.Add(time.Minute)))
+	a.Len(b.list(now), 2)
+
+	a.True(b.unban("a"))
+	a.False(b.unban("a"))
+	a.False(b.banned("a", now))
+}
+
+func TestClientService_Ban(t *testing.T) {
+	a := assert.New(t)
+	srv := &server{bans: newBanList()}
+	c := &clientService{srv: srv}
+	c.Ban("a", time.Minute)
+	c.Ban("b", 0)
+	bans := c.ListBans()
+	a.Len(bans, 2)
+	a.True(bans["b"].IsZero())
+	a.True(bans["a"].After(time.Now()))
+	a.True(srv.bans.banned("a", time.Now()))
+	a.True(c.Unban("a"))
+	a.False(srv.bans.banned("a", time.Now()))
+}
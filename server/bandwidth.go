@@ -0,0 +1,71 @@
+package server
+
+import (
+	"time"
+
+	"github.com/DrmagicE/gmqtt/pkg/packets"
+)
+
+// tokenBucket limits the byte-rate of the outgoing messages.
+// The bytes of a packet are consumed at once, the bucket goes into debt if the packet is larger than the available tokens,
+// so that a packet larger than the burst can still be sent.
+// It is only used by the writeLoop and is not goroutine-safe.
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// newTokenBucket returns a full token bucket, or nil if the bytesPerSecond is not positive.
+func newTokenBucket(bytesPerSecond, burst int) *tokenBucket {
+	if bytesPerSecond <= 0 {
+		return nil
+	}
+	if burst <= 0 {
+		burst = bytesPerSecond
+	}
+	return &tokenBucket{
+		rate:   float64(bytesPerSecond),
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// reserve consumes n tokens and returns the duration to wait before the n bytes can be sent.
+func (b *tokenBucket) reserve(n int, now time.Time) time.Duration {
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens += elapsed.Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+		b.last = now
+	}
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// throttle is called after the packet is written, it blocks until the bytes of the packet are paid back,
+// so that the next packet is not written until then.
+// It returns false if the client is closed while waiting.
+func (client *client) throttle(packet packets.Packet) bool {
+	if client.bandwidth == nil {
+		return true
+	}
+	d := client.bandwidth.reserve(int(packets.TotalBytes(packet)), time.Now())
+	if d <= 0 {
+		return true
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-client.close:
+		return false
+	case <-t.C:
+		return true
+	}
+}
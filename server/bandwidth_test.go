@@ -0,0 +1,56 @@
+package server
+
+import (
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DrmagicE/gmqtt/pkg/packets"
+)
+
+func TestTokenBucket(t *testing.T) {
+	a := assert.New(t)
+	a.Nil(newTokenBucket(0, 100))
+
+	b := newTokenBucket(100, 200)
+	now := b.last
+	a.Zero(b.reserve(150, now))
+	a.Zero(b.reserve(50, now))
+	a.Equal(500*time.Millisecond, b.reserve(50, now))
+	// the packet larger than the burst is allowed, the bucket goes into debt.
+	a.Equal(3500*time.Millisecond, b.reserve(300, now))
+	a.Equal(500*time.Millisecond, b.reserve(0, now.Add(3*time.Second)))
+	// the tokens never exceed the burst.
+	a.Zero(b.reserve(200, now.Add(time.Hour)))
+	a.Equal(time.Second, b.reserve(100, now.Add(time.Hour)))
+
+	// burst defaults to the rate.
+	b = newTokenBucket(100, 0)
+	a.Zero(b.reserve(100, b.last))
+	a.Equal(time.Second, b.reserve(100, b.last))
+}
+
+func TestClient_throttle(t *testing.T) {
+	a := assert.New(t)
+	pub := &packets.Publish{TopicName: []byte("a"), Payload: make([]byte, 100)}
+	// the size of the packet is known after it is written.
+	a.Nil(pub.Pack(ioutil.Discard))
+	c := &client{close: make(chan struct{})}
+	a.True(c.throttle(pub))
+
+	c.bandwidth = newTokenBucket(1, 150)
+	a.True(c.throttle(pub))
+	done := make(chan bool)
+	go func() {
+		done <- c.throttle(pub)
+	}()
+	close(c.close)
+	select {
+	case ok := <-done:
+		a.False(ok)
+	case <-time.After(time.Second):
+		t.Fatal("throttle is not aborted by close")
+	}
+}
@@ -14,12 +14,12 @@ import (
 	"net"
 	"os"
 	"reflect"
+	"strconv"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"go.uber.org/zap"
-	"go.uber.org/zap/zapcore"
 
 	"github.com/DrmagicE/gmqtt"
 	"github.com/DrmagicE/gmqtt/config"
@@ -29,6 +29,7 @@ import (
 	"github.com/DrmagicE/gmqtt/pkg/bitmap"
 	"github.com/DrmagicE/gmqtt/pkg/codes"
 	"github.com/DrmagicE/gmqtt/pkg/packets"
+	"github.com/DrmagicE/gmqtt/pkg/timingwheel"
 )
 
 // Error
@@ -103,6 +104,8 @@ type ClientOptions struct {
 	ClientID string
 	// Username is the username for the client.
 	Username string
+	// CleanStart is the clean start flag of the CONNECT packet.
+	CleanStart bool
 	// KeepAlive is the keep alive time in seconds for the client.
 	// The server will close the client if no there is no packet has been received for 1.5 times the KeepAlive time.
 	KeepAlive uint16
@@ -153,6 +156,12 @@ type ClientOptions struct {
 	// Only MQTT v5 client can set this value.
 	// See: https://docs.oasis-open.org/mqtt/mqtt/v5.0/os/mqtt-v5.0-os.html#_Toc3901055
 	AuthMethod []byte
+	// MountPoint is the prefix of all topics the client publishes and subscribes, see AuthOptions.MountPoint.
+	MountPoint string
+	// OutboundBytesPerSecond is the bandwidth limit of the messages sent to the client, see AuthOptions.OutboundBytesPerSecond.
+	OutboundBytesPerSecond int
+	// OutboundBurst is the burst of the bandwidth limit, see AuthOptions.OutboundBurst.
+	OutboundBurst int
 }
 
 // Client represent a mqtt client.
@@ -163,6 +172,9 @@ type Client interface {
 	// SessionInfo return a reference of session information of the client. Do not edit.
 	// Session info will be available after the client has passed OnSessionCreated or OnSessionResume.
 	SessionInfo() *gmqtt.Session
+	// Attributes returns the current key-value attributes of the client, nil if there is no attribute. Do not edit.
+	// Unlike SessionInfo().Attributes, it reflects the changes made by ClientService.SetAttributes.
+	Attributes() map[string]string
 	// Version return the protocol version of the used client.
 	Version() packets.Version
 	// ConnectedAt returns the connected time
@@ -177,7 +189,9 @@ type Client interface {
 
 // client represents a MQTT client and implements the Client interface
 type client struct {
-	connectedAt  int64
+	connectedAt int64
+	// lastRead is the unix nano time of the last packet received.
+	lastRead     int64
 	server       *server
 	wg           sync.WaitGroup
 	rwc          net.Conn //raw tcp connection
@@ -185,7 +199,7 @@ type client struct {
 	bufw         *bufio.Writer
 	packetReader *packets.Reader
 	packetWriter *packets.Writer
-	in           chan packets.Packet
+	in           chan *inboundPacket
 	out          chan packets.Packet
 	close        chan struct{}
 	closed       chan struct{}
@@ -214,6 +228,10 @@ type client struct {
 
 	config config.Config
 
+	// stats is the statistics of the client, it is set once the client is registered.
+	// Its counters can be read atomically without the lock of the stats manager.
+	stats *ClientStats
+
 	queueStore    queue.Store
 	unackStore    unack.Store
 	pl            *packetIDLimiter
@@ -224,6 +242,62 @@ type client struct {
 	unregister func(client *client)
 	// deliverMessage
 	deliverMessage func(srcClientID string, msg *gmqtt.Message, options subscription.IterationOptions) (matched bool)
+
+	// poll is the netpoll state, nil if the client is served by the goroutine engine.
+	poll *pollState
+	// keepAliveTimer is the keepalive timer in the server timer wheel, nil if the keepalive is disabled.
+	keepAliveTimer *timingwheel.Timer
+	// quotaAcquired indicates whether the client has taken a slot of the connection quota.
+	quotaAcquired bool
+	// allowAnonymousOverride is the allow anonymous setting of the listener which accepts the client, nil if not set.
+	allowAnonymousOverride *bool
+	// listener is the label of the listener which accepts the client.
+	listener string
+	// listenerAddr is the bound address of the listener which accepts the client, which identifies the clients of the draining listener.
+	listenerAddr string
+	// migrated is set to 1 if the client is disconnected by the draining listener.
+	migrated int32
+	// mountPoint is the mount point of the listener which accepts the client.
+	mountPoint string
+	// retainAsPublishedOverride is the retain as published setting of the listener which accepts the client, nil if not set.
+	retainAsPublishedOverride *bool
+	// noLocalOverride is the no local setting of the listener which accepts the client, nil if not set.
+	noLocalOverride *bool
+	// codec is the connection codec of the listener which accepts the client, nil if not set.
+	codec ConnectionCodec
+	// attributes stores the immutable map[string]string of the client attributes, which is replaced on change.
+	attributes atomic.Value
+	// closeErr is the first error passed to setError, which may be wrapped by packetError or writeError.
+	closeErr error
+	// serverClosed is 1 if the connection is closed by Close().
+	serverClosed int32
+	// reqCtx is the context of the inbound packet which is being handled.
+	reqCtx context.Context
+	// deliveries tracks the outgoing messages for the OnDeliveryReport hook, nil if the hook is not set.
+	deliveries *deliveryTracker
+	// redelivery resends the unacknowledged messages according to the redelivery policy, nil if the redelivery is disabled.
+	redelivery *redeliveryTracker
+	// lanes delivers the messages of the ordered topics in FIFO order, nil if there is no ordered topic.
+	lanes *orderedLanes
+	// bandwidth throttles the outgoing messages, nil if the bandwidth is unlimited.
+	// It is set before the session is registered, so it is safe to be read by the writeLoop when sending PUBLISH.
+	bandwidth *tokenBucket
+	// writeBuffer tracks the bytes waiting to be written, nil if the write buffer is not enabled.
+	writeBuffer *writeBuffer
+	// unsent tracks the inflight messages which have never been written, nil if takeover.dup_flag is not strict.
+	unsent *unsentInflight
+	// takenOver is the previous client closed by the takeover, nil if there is no takeover.
+	takenOver *client
+	// maxSessionExpiry is the maximum session expiry interval in seconds that the client can set in the DISCONNECT packet.
+	maxSessionExpiry uint32
+	// takeoverUnsent is the packet ids of the unsent inflight messages handed off from the previous client.
+	takeoverUnsent map[packets.PacketID]struct{}
+	// retainedMu guards retainedStreams.
+	retainedMu sync.Mutex
+	// retainedStreams is the retained message streams started by sendRetained, keyed by topic filter.
+	retainedStreams map[string]*retainedStream
+	// retainedStreaming is the number of the running retained message streams.
+	retainedStreaming int32
 }
 
 func (client *client) SessionInfo() *gmqtt.Session {
@@ -269,11 +343,10 @@ func (client *client) IsConnected() bool {
 
 func (client *client) setError(err error) {
 	client.errOnce.Do(func() {
+		client.closeErr = err
+		err = unwrapCloseError(err)
 		if err != nil && err != io.EOF {
-			zaplog.Warn("connection lost",
-				zap.String("client_id", client.opts.ClientID),
-				zap.String("remote_addr", client.rwc.RemoteAddr().String()),
-				zap.Error(err))
+			zaplog.Warn("connection lost", client.logFields(zap.Error(err))...)
 			client.err = err
 			if client.version == packets.Version5 {
 				if code, ok := err.(*codes.Error); ok {
@@ -307,10 +380,36 @@ func (client *client) writeLoop() {
 	for {
 		select {
 		case <-client.close:
+			if client.err != nil {
+				client.flushClosing()
+				client.closeConn()
+			}
 			return
 		case packet := <-client.out:
+			if client.writeBuffer.expired() {
+				client.closeSlowConsumer(true)
+				return
+			}
+			// must be counted before the topic name is replaced by the topic alias.
+			buffered := bufferedBytes(packet)
+			if srv.hooks.OnPacketSend != nil {
+				if err = srv.hooks.OnPacketSend(client.baseContext(), client, packet); err != nil {
+					if err == ErrDiscardPacket {
+						client.writeBuffer.done(buffered)
+						err = nil
+						continue
+					}
+					err = &packetError{err: err, packet: packet}
+					return
+				}
+			}
+			var report *DeliveryReport
 			switch p := packet.(type) {
 			case *packets.Publish:
+				// must be called before the topic name is replaced by the topic alias.
+				report = client.deliveries.written(p)
+				client.redelivery.written(p, time.Now())
+				client.unsent.written(p)
 				if client.version == packets.Version5 {
 					if client.opts.ClientTopicAliasMax > 0 {
 						// use alias if exist
@@ -327,9 +426,12 @@ func (client *client) writeLoop() {
 				}
 				// OnDelivered hook
 				if srv.hooks.OnDelivered != nil {
-					srv.hooks.OnDelivered(context.Background(), client, gmqtt.MessageFromPublish(p))
+					srv.hooks.OnDelivered(client.baseContext(), client, gmqtt.MessageFromPublish(p))
 				}
 				srv.statsManager.messageSent(p.Qos, client.opts.ClientID)
+				srv.statsManager.subscriptionIDStats.sent(client.opts.ClientID, p)
+			case *packets.Pubrel:
+				client.redelivery.written(p, time.Now())
 			case *packets.Puback, *packets.Pubcomp:
 				if client.version == packets.Version5 {
 					client.addServerQuota()
@@ -341,27 +443,52 @@ func (client *client) writeLoop() {
 			}
 			err = client.writePacket(packet)
 			if err != nil {
+				if client.writeBuffer.expired() {
+					// the packet may be partially written, the DISCONNECT packet can not be sent.
+					client.closeSlowConsumer(false)
+					return
+				}
+				err = &writeError{err: err}
+				return
+			}
+			client.writeBuffer.done(buffered)
+			if _, ok := packet.(*packets.Publish); ok && !client.throttle(packet) {
 				return
 			}
+			if report != nil {
+				report.Latency = time.Since(report.EnqueuedAt)
+				srv.hooks.OnDeliveryReport(client.baseContext(), client, report)
+			}
 			srv.statsManager.packetSent(packet, client.opts.ClientID)
 			if _, ok := packet.(*packets.Disconnect); ok {
-				_ = client.rwc.Close()
+				client.Close()
 				return
 			}
 		}
 	}
 }
 
-func (client *client) writePacket(packet packets.Packet) error {
-	if client.server.config.Log.DumpPacket {
-		if ce := zaplog.Check(zapcore.DebugLevel, "sending packet"); ce != nil {
-			ce.Write(
-				zap.String("packet", packet.String()),
-				zap.String("remote_addr", client.rwc.RemoteAddr().String()),
-				zap.String("client_id", client.opts.ClientID),
-			)
+// flushClosing writes the pending CONNACK or DISCONNECT packet which tells the client why the connection is closed,
+// the other pending packets are dropped.
+func (client *client) flushClosing() {
+	for {
+		select {
+		case packet := <-client.out:
+			switch packet.(type) {
+			case *packets.Connack, *packets.Disconnect:
+				if err := client.writePacket(packet); err != nil {
+					return
+				}
+				client.server.statsManager.packetSent(packet, client.opts.ClientID)
+			}
+		default:
+			return
 		}
 	}
+}
+
+func (client *client) writePacket(packet packets.Packet) error {
+	client.dumpPacket("sending packet", packet)
 
 	return client.packetWriter.WriteAndFlush(packet)
 }
@@ -386,7 +513,6 @@ func (client *client) tryDecServerQuota() error {
 
 func (client *client) readLoop() {
 	var err error
-	srv := client.server
 	defer func() {
 		if re := recover(); re != nil {
 			err = errors.New(fmt.Sprint(re))
@@ -395,48 +521,90 @@ func (client *client) readLoop() {
 		close(client.in)
 	}()
 	for {
-		var packet packets.Packet
-		if client.IsConnected() {
-			if keepAlive := client.opts.KeepAlive; keepAlive != 0 { //KeepAlive
-				_ = client.rwc.SetReadDeadline(time.Now().Add(time.Duration(keepAlive/2+keepAlive) * time.Second))
-			}
-		}
-		packet, err = client.packetReader.ReadPacket()
+		err = client.readPacket()
 		if err != nil {
-			if err != io.EOF && packet != nil {
-				zaplog.Error("read error", zap.String("packet_type", reflect.TypeOf(packet).String()))
-			}
 			return
 		}
+	}
+}
 
-		if pub, ok := packet.(*packets.Publish); ok {
-			srv.statsManager.messageReceived(pub.Qos, client.opts.ClientID)
-			if client.version == packets.Version5 && pub.Qos > packets.Qos0 {
-				err = client.tryDecServerQuota()
-				if err != nil {
-					return
-				}
+// readPacket reads one packet from the connection and passes it to the readHandle goroutine.
+func (client *client) readPacket() (err error) {
+	srv := client.server
+	var packet packets.Packet
+	defer func() {
+		if err != nil && err != io.EOF && packet != nil {
+			err = &packetError{err: err, packet: packet}
+		}
+	}()
+	packet, err = client.packetReader.ReadPacket()
+	client.touch()
+	if err != nil {
+		if err != io.EOF && packet != nil {
+			zaplog.Error("read error", client.logFields(zap.String("packet_type", reflect.TypeOf(packet).String()))...)
+		}
+		client.refuseConnect(err)
+		return
+	}
+	ctx := client.newRequestContext(packet)
+	if srv.hooks.OnPacketReceived != nil {
+		if err = srv.hooks.OnPacketReceived(ctx, client, packet); err != nil {
+			if err == ErrDiscardPacket {
+				return nil
 			}
+			return
 		}
-		client.in <- packet
-		<-client.connected
-		srv.statsManager.packetReceived(packet, client.opts.ClientID)
-		if client.server.config.Log.DumpPacket {
-			if ce := zaplog.Check(zapcore.DebugLevel, "received packet"); ce != nil {
-				ce.Write(
-					zap.String("packet", packet.String()),
-					zap.String("remote_addr", client.rwc.RemoteAddr().String()),
-					zap.String("client_id", client.opts.ClientID),
-				)
+	}
+
+	if pub, ok := packet.(*packets.Publish); ok {
+		srv.statsManager.messageReceived(pub.Qos, client.opts.ClientID)
+		if client.version == packets.Version5 && pub.Qos > packets.Qos0 {
+			err = client.tryDecServerQuota()
+			if err != nil {
+				return
 			}
 		}
 	}
+	client.in <- &inboundPacket{ctx: ctx, packet: packet}
+	<-client.connected
+	srv.statsManager.packetReceived(packet, client.opts.ClientID)
+	client.dumpPacket("received packet", packet, zap.String("correlation_id", correlationID(ctx)))
+	return nil
+}
+
+// refuseConnect responds to the CONNECT packet which is refused by the decoder with the CONNACK packet,
+// e.g. the unacceptable protocol level [MQTT-3.1.2-2] and the rejected client id [MQTT-3.1.3-9].
+// The CONNACK is in V3 format because the protocol level may be unknown.
+func (client *client) refuseConnect(err error) {
+	ce, ok := err.(*codes.Error)
+	if !ok || client.Status() != Connecting {
+		return
+	}
+	if ce.Code == codes.V3UnacceptableProtocolVersion || ce.Code == codes.V3IdentifierRejected {
+		client.write(&packets.Connack{
+			Version: packets.Version311,
+			Code:    ce.Code,
+		})
+	}
+}
+
+func (client *client) isServerClosed() bool {
+	return atomic.LoadInt32(&client.serverClosed) == 1
 }
 
 // Close closes the client connection. The returned channel will be closed after unregisterClient process has been done
 func (client *client) Close() {
+	atomic.StoreInt32(&client.serverClosed, 1)
+	client.closeConn()
+}
+
+// closeConn closes the network connection after the client is closed due to an error [MQTT-4.8.0-1],
+// it does not mark the connection as closed by the server.
+func (client *client) closeConn() {
 	if client.rwc != nil {
+		client.pollUnregister()
 		_ = client.rwc.Close()
+		client.pollWakeup()
 	}
 }
 
@@ -505,15 +673,77 @@ func convertUint32(u *uint32, defaultValue uint32) uint32 {
 
 func sendErrConnack(cli *client, err error) {
 	codeErr := converError(err)
-	// Override the error code if it is invalid for V3 client.
-	if packets.IsVersion3X(cli.version) && codeErr.Code > codes.V3NotAuthorized {
-		codeErr.Code = codes.NotAuthorized
-	}
+	code := connackCode(cli.version, codeErr.Code)
 	cli.out <- &packets.Connack{
 		Version:    cli.version,
-		Code:       codeErr.Code,
-		Properties: getErrorProperties(cli, &codeErr.ErrorDetails),
+		Code:       code,
+		Properties: reconnectAdvice(cli, code, getErrorProperties(cli, &codeErr.ErrorDetails)),
+	}
+}
+
+// connackCode returns the CONNACK code to refuse the client of the version with.
+// The code is mapped to the closest one which is valid for the version, so that the hooks can refuse
+// with the V5 reason code regardless of the client version.
+func connackCode(version packets.Version, code codes.Code) codes.Code {
+	if packets.IsVersion3X(version) {
+		switch code {
+		case codes.V3UnacceptableProtocolVersion, codes.UnsupportedProtocolVersion:
+			return codes.V3UnacceptableProtocolVersion
+		case codes.V3IdentifierRejected, codes.ClientIdentifierNotValid:
+			return codes.V3IdentifierRejected
+		case codes.V3ServerUnavaliable, codes.ServerUnavailable, codes.ServerBusy, codes.UseAnotherServer, codes.ServerMoved:
+			return codes.V3ServerUnavaliable
+		case codes.V3BadUsernameorPassword, codes.BadUserNameOrPassword:
+			return codes.V3BadUsernameorPassword
+		}
+		return codes.V3NotAuthorized
+	}
+	switch code {
+	case codes.UnspecifiedError, codes.MalformedPacket, codes.ProtocolError, codes.ImplementationSpecificError,
+		codes.UnsupportedProtocolVersion, codes.ClientIdentifierNotValid, codes.BadUserNameOrPassword,
+		codes.NotAuthorized, codes.ServerUnavailable, codes.ServerBusy, codes.Banned, codes.BadAuthMethod,
+		codes.TopicNameInvalid, codes.PacketTooLarge, codes.QuotaExceeded, codes.PayloadFormatInvalid,
+		codes.RetainNotSupported, codes.QoSNotSupported, codes.UseAnotherServer, codes.ServerMoved,
+		codes.ConnectionRateExceeded:
+		return code
+	case codes.V3UnacceptableProtocolVersion:
+		return codes.UnsupportedProtocolVersion
+	case codes.V3IdentifierRejected:
+		return codes.ClientIdentifierNotValid
+	case codes.V3ServerUnavaliable:
+		return codes.ServerUnavailable
+	case codes.V3BadUsernameorPassword:
+		return codes.BadUserNameOrPassword
+	case codes.V3NotAuthorized:
+		return codes.NotAuthorized
+	}
+	return codes.UnspecifiedError
+}
+
+// reconnectAdvice adds the reconnect advice into the CONNACK properties of the refused V5 client.
+func reconnectAdvice(cli *client, code codes.Code, ppt *packets.Properties) *packets.Properties {
+	advice := cli.config.MQTT.ReconnectAdvice
+	if cli.version != packets.Version5 || !advice.Enabled() {
+		return ppt
+	}
+	switch code {
+	case codes.ServerBusy, codes.Banned, codes.QuotaExceeded, codes.ConnectionRateExceeded:
+	default:
+		return ppt
 	}
+	if ppt == nil {
+		ppt = &packets.Properties{}
+	}
+	if advice.RetryAfter > 0 {
+		ppt.User = append(ppt.User, packets.UserProperty{
+			K: []byte(advice.UserProperty),
+			V: []byte(strconv.FormatInt(int64(advice.RetryAfter/time.Second), 10)),
+		})
+	}
+	if advice.ServerReference != "" {
+		ppt.ServerReference = []byte(advice.ServerReference)
+	}
+	return ppt
 }
 
 func (client *client) connectWithTimeOut() (ok bool) {
@@ -528,8 +758,14 @@ func (client *client) connectWithTimeOut() (ok bool) {
 		}
 		close(client.connected)
 	}()
-	timeout := time.NewTimer(5 * time.Second)
+	timeout := time.NewTimer(client.config.Handshake.Timeout)
 	defer timeout.Stop()
+	var limited bool
+	defer func() {
+		if limited {
+			client.server.connLimiter.release()
+		}
+	}()
 	var conn *packets.Connect
 	var authOpts *AuthOptions
 	// for enhanced auth
@@ -537,10 +773,12 @@ func (client *client) connectWithTimeOut() (ok bool) {
 
 	for {
 		select {
-		case p := <-client.in:
-			if p == nil {
+		case in := <-client.in:
+			if in == nil {
 				return
 			}
+			client.reqCtx = in.ctx
+			p := in.packet
 			code := codes.Success
 			var authData []byte
 			switch p.(type) {
@@ -550,6 +788,17 @@ func (client *client) connectWithTimeOut() (ok bool) {
 					break
 				}
 				conn = p.(*packets.Connect)
+				if err = client.server.connLimiter.acquire(client.close); err != nil {
+					client.version = conn.Version
+					zaplog.Debug("connection refused by the handshake limits", client.logFields(zap.Error(err))...)
+					if packets.IsVersion3X(client.version) {
+						err = &codes.Error{
+							Code: codes.V3ServerUnavaliable,
+						}
+					}
+					break
+				}
+				limited = true
 				var resp *EnhancedAuthResponse
 				authOpts, resp, err = client.connectHandler(conn)
 				if err != nil {
@@ -596,7 +845,10 @@ func (client *client) connectWithTimeOut() (ok bool) {
 			}
 			// authentication fail
 			if err != nil {
-				sendErrConnack(client, err)
+				// the first packet is not CONNECT, close the connection without CONNACK [MQTT-3.1.0-1]
+				if conn != nil {
+					sendErrConnack(client, err)
+				}
 				return
 			}
 			// continue authentication (ContinueAuthentication is introduced in V5)
@@ -612,6 +864,7 @@ func (client *client) connectWithTimeOut() (ok bool) {
 			}
 
 			// authentication success
+			client.applySessionExpiryClass(conn, authOpts)
 			client.opts.RetainAvailable = authOpts.RetainAvailable
 			client.opts.WildcardSubAvailable = authOpts.WildcardSubAvailable
 			client.opts.SubIDAvailable = authOpts.SubIDAvailable
@@ -622,7 +875,10 @@ func (client *client) connectWithTimeOut() (ok bool) {
 			client.opts.ClientMaxPacketSize = math.MaxUint32 // unlimited
 			client.opts.ServerMaxPacketSize = authOpts.MaxPacketSize
 			client.opts.ServerTopicAliasMax = authOpts.TopicAliasMax
+			client.opts.OutboundBytesPerSecond = authOpts.OutboundBytesPerSecond
+			client.opts.OutboundBurst = authOpts.OutboundBurst
 			client.opts.Username = string(conn.Username)
+			client.opts.CleanStart = conn.CleanStart
 
 			if len(conn.ClientID) == 0 {
 				if len(authOpts.AssignedClientID) != 0 {
@@ -634,6 +890,18 @@ func (client *client) connectWithTimeOut() (ok bool) {
 			} else {
 				client.opts.ClientID = string(conn.ClientID)
 			}
+			client.attributes.Store(copyAttributes(authOpts.Attributes))
+			if authOpts.MountPoint != "" {
+				var valid bool
+				client.opts.MountPoint, valid = expandMountPoint(authOpts.MountPoint, client.opts.Username, client.opts.ClientID)
+				if !valid {
+					err = &codes.Error{
+						Code: codes.NotAuthorized,
+					}
+					sendErrConnack(client, err)
+					return
+				}
+			}
 
 			var connackPpt *packets.Properties
 			if client.version == packets.Version5 {
@@ -670,10 +938,18 @@ func (client *client) connectWithTimeOut() (ok bool) {
 				client.opts.KeepAlive = conn.KeepAlive
 			}
 
-			if keepAlive := client.opts.KeepAlive; keepAlive != 0 { //KeepAlive
-				_ = client.rwc.SetReadDeadline(time.Now().Add(time.Duration(keepAlive/2+keepAlive) * time.Second))
+			if !client.server.connQuota.acquire(client.opts.Username, remoteIP(client.rwc.RemoteAddr()), client.server.registeredClient(client.opts.ClientID)) {
+				err = &codes.Error{
+					Code: codes.QuotaExceeded,
+				}
+				sendErrConnack(client, err)
+				return
 			}
+			client.quotaAcquired = true
+
+			client.startKeepAlive()
 			client.newPacketIDLimiter(client.opts.MaxInflight)
+			client.bandwidth = newTokenBucket(client.opts.OutboundBytesPerSecond, client.opts.OutboundBurst)
 
 			var sessionResume bool
 			sessionResume, err = client.register(conn, client)
@@ -697,7 +973,7 @@ func (client *client) connectWithTimeOut() (ok bool) {
 func (client *client) basicAuth(conn *packets.Connect, authOpts *AuthOptions) (err error) {
 	srv := client.server
 	if srv.hooks.OnBasicAuth != nil {
-		err = srv.hooks.OnBasicAuth(context.Background(), client, &ConnectRequest{
+		err = srv.hooks.OnBasicAuth(client.requestContext(), client, &ConnectRequest{
 			Connect: conn,
 			Options: authOpts,
 		})
@@ -712,7 +988,7 @@ func (client *client) enhancedAuth(conn *packets.Connect, authOpts *AuthOptions)
 		return nil, errors.New("OnEnhancedAuth hook is nil")
 	}
 
-	resp, err = srv.hooks.OnEnhancedAuth(context.Background(), client, &ConnectRequest{
+	resp, err = srv.hooks.OnEnhancedAuth(client.requestContext(), client, &ConnectRequest{
 		Connect: conn,
 		Options: authOpts,
 	})
@@ -730,6 +1006,33 @@ func (client *client) connectHandler(conn *packets.Connect) (authOpts *AuthOptio
 		return
 	}
 	client.version = conn.Version
+	if client.server.overload.rejectConnection() {
+		err = &codes.Error{
+			Code: codes.ServerBusy,
+		}
+		return
+	}
+	if client.server.bans.banned(string(conn.ClientID), time.Now()) ||
+		client.server.flapping.connect(time.Now(), string(conn.ClientID), remoteIP(client.rwc.RemoteAddr())) {
+		err = &codes.Error{
+			Code: codes.Banned,
+		}
+		return
+	}
+	throttle := client.server.authThrottle
+	ip := remoteIP(client.rwc.RemoteAddr())
+	if throttle.locked(time.Now(), string(conn.Username), ip) {
+		err = &codes.Error{
+			Code: codes.NotAuthorized,
+		}
+		return
+	}
+	if client.isAnonymous(conn) && !client.allowAnonymous() {
+		err = &codes.Error{
+			Code: codes.NotAuthorized,
+		}
+		return
+	}
 	// default auth options
 	authOpts = client.defaultAuthOptions(conn)
 
@@ -739,12 +1042,18 @@ func (client *client) connectHandler(conn *packets.Connect) (authOpts *AuthOptio
 	if client.version == packets.Version5 && conn.Properties.AuthMethod != nil {
 		enhancedResp, err = client.enhancedAuth(conn, authOpts)
 	}
-
+	if err != nil {
+		if delay := throttle.failed(time.Now(), string(conn.Username), ip); delay > 0 {
+			time.Sleep(delay)
+		}
+	} else {
+		throttle.succeeded(string(conn.Username))
+	}
 	return
 }
 
 func (client *client) authHandler(auth *packets.Auth, authOpts *AuthOptions, onAuth OnAuth) (resp *AuthResponse, err error) {
-	authResp, err := onAuth(context.Background(), client, &AuthRequest{
+	authResp, err := onAuth(client.requestContext(), client, &AuthRequest{
 		Auth:    auth,
 		Options: authOpts,
 	})
@@ -764,31 +1073,71 @@ func getErrorProperties(client *client, errDetails *codes.ErrorDetails) *packets
 	return nil
 }
 
+// sessionExpiry returns the session expiry interval in seconds of the client in the session expiry class,
+// class is nil if the client does not belong to any class.
+// The interval requested by the V5 client is capped by the maximum of the class,
+// and the V3 client gets the default of the class.
+func (client *client) sessionExpiry(connect *packets.Connect, class *config.SessionExpiryClass) uint32 {
+	max, def := client.config.MQTT.SessionExpiry, client.config.MQTT.SessionExpiry
+	if class != nil {
+		max, def = class.Max, class.DefaultExpiry()
+	}
+	if client.version != packets.Version5 {
+		return uint32(def.Seconds())
+	}
+	if i := connect.Properties.SessionExpiryInterval; i == nil {
+		return 0
+	} else if *i < uint32(max.Seconds()) {
+		return *i
+	}
+	return uint32(max.Seconds())
+}
+
+// applySessionExpiryClass sets the maximum session expiry interval of the client after the authentication succeeds.
+// If the session expiry class is changed by the hooks, the session expiry interval is recalculated with the selected class.
+func (client *client) applySessionExpiryClass(connect *packets.Connect, authOpts *AuthOptions) {
+	class := client.config.MQTT.SessionExpiryClassOf(string(connect.Username))
+	if name := authOpts.SessionExpiryClass; class == nil && name != "" || class != nil && name != class.Name {
+		class = client.config.MQTT.SessionExpiryClass(name)
+		if class == nil && name != "" {
+			zaplog.Warn("session expiry class not found", client.logFields(zap.String("session_expiry_class", name))...)
+		}
+		authOpts.SessionExpiry = client.sessionExpiry(connect, class)
+	}
+	client.maxSessionExpiry = uint32(client.config.MQTT.SessionExpiry.Seconds())
+	if class != nil {
+		client.maxSessionExpiry = uint32(class.Max.Seconds())
+	}
+	// the hooks may grant a longer session expiry interval than the maximum.
+	if authOpts.SessionExpiry > client.maxSessionExpiry {
+		client.maxSessionExpiry = authOpts.SessionExpiry
+	}
+}
+
 func (client *client) defaultAuthOptions(connect *packets.Connect) *AuthOptions {
+	class := client.config.MQTT.SessionExpiryClassOf(string(connect.Username))
 	opts := &AuthOptions{
-		SessionExpiry:        uint32(client.config.MQTT.SessionExpiry.Seconds()),
-		ReceiveMax:           client.config.MQTT.ReceiveMax,
-		MaximumQoS:           client.config.MQTT.MaximumQoS,
-		MaxPacketSize:        client.config.MQTT.MaxPacketSize,
-		TopicAliasMax:        client.config.MQTT.TopicAliasMax,
-		RetainAvailable:      client.config.MQTT.RetainAvailable,
-		WildcardSubAvailable: client.config.MQTT.WildcardAvailable,
-		SubIDAvailable:       client.config.MQTT.SubscriptionIDAvailable,
-		SharedSubAvailable:   client.config.MQTT.SharedSubAvailable,
-		KeepAlive:            client.config.MQTT.MaxKeepAlive,
-		MaxInflight:          client.config.MQTT.MaxInflight,
+		SessionExpiry:          client.sessionExpiry(connect, class),
+		ReceiveMax:             client.config.MQTT.ReceiveMax,
+		MaximumQoS:             client.config.MQTT.MaximumQoS,
+		MaxPacketSize:          client.config.MQTT.MaxPacketSize,
+		TopicAliasMax:          client.config.MQTT.TopicAliasMax,
+		RetainAvailable:        client.config.MQTT.RetainAvailable,
+		WildcardSubAvailable:   client.config.MQTT.WildcardAvailable,
+		SubIDAvailable:         client.config.MQTT.SubscriptionIDAvailable,
+		SharedSubAvailable:     client.config.MQTT.SharedSubAvailable,
+		KeepAlive:              client.config.MQTT.MaxKeepAlive,
+		MaxInflight:            client.config.MQTT.MaxInflight,
+		MountPoint:             client.mountPoint,
+		OutboundBytesPerSecond: client.config.MQTT.OutboundBandwidth.BytesPerSecond,
+		OutboundBurst:          client.config.MQTT.OutboundBandwidth.Burst,
+	}
+	if class != nil {
+		opts.SessionExpiryClass = class.Name
 	}
 	if connect.KeepAlive < opts.KeepAlive {
 		opts.KeepAlive = connect.KeepAlive
 	}
-	if client.version == packets.Version5 {
-		if i := connect.Properties.SessionExpiryInterval; i == nil {
-			opts.SessionExpiry = 0
-		} else if *i < opts.SessionExpiry {
-			opts.SessionExpiry = *i
-
-		}
-	}
 	return opts
 }
 
@@ -796,11 +1145,14 @@ func (client *client) internalClose() {
 	if client.IsConnected() {
 		// OnClosed hooks
 		if client.server.hooks.OnClosed != nil {
-			client.server.hooks.OnClosed(context.Background(), client, client.err)
+			client.server.hooks.OnClosed(client.baseContext(), client, client.newCloseReason(client.closeErr))
 		}
 		client.unregister(client)
 		client.server.statsManager.clientDisconnected(client.opts.ClientID)
 	}
+	if client.quotaAcquired {
+		client.server.connQuota.release(client.opts.Username, remoteIP(client.rwc.RemoteAddr()))
+	}
 	putBufioReader(client.bufr)
 	putBufioWriter(client.bufw)
 	close(client.closed)
@@ -816,6 +1168,7 @@ func (client *client) checkMaxPacketSize(msg *gmqtt.Message) (valid bool) {
 }
 
 func (client *client) write(packets packets.Packet) {
+	client.writeBuffer.add(bufferedBytes(packets))
 	select {
 	case <-client.close:
 		return
@@ -823,6 +1176,16 @@ func (client *client) write(packets packets.Packet) {
 	}
 }
 
+// writePublish writes the publish packet of the message whose topic name is topic,
+// through the ordered lanes if the topic name matches the ordered topics.
+func (client *client) writePublish(topic string, pub *packets.Publish) {
+	if client.lanes != nil && client.config.MQTT.Ordered(topic) {
+		client.lanes.send(pub)
+		return
+	}
+	client.write(pub)
+}
+
 func (client *client) subscribeHandler(sub *packets.Subscribe) *codes.Error {
 	srv := client.server
 	suback := &packets.Suback{
@@ -832,7 +1195,6 @@ func (client *client) subscribeHandler(sub *packets.Subscribe) *codes.Error {
 		Payload:    make([]codes.Code, len(sub.Topics)),
 	}
 	var subID uint32
-	now := time.Now()
 	if client.version == packets.Version5 {
 		if client.opts.SubIDAvailable && len(sub.Properties.SubscriptionIdentifier) != 0 {
 			subID = sub.Properties.SubscriptionIdentifier[0]
@@ -842,6 +1204,12 @@ func (client *client) subscribeHandler(sub *packets.Subscribe) *codes.Error {
 				Code: codes.SubIDNotSupported,
 			}
 		}
+		for _, v := range sub.Topics {
+			// It is a Protocol Error to set the No Local bit to 1 on a Shared Subscription [MQTT-3.8.3-4].
+			if shareName, _ := subscription.SplitTopic(v.Name); v.NoLocal && shareName != "" {
+				return codes.ErrProtocol
+			}
+		}
 	}
 	subReq := &SubscribeRequest{
 		Subscribe: sub,
@@ -852,15 +1220,23 @@ func (client *client) subscribeHandler(sub *packets.Subscribe) *codes.Error {
 		ID: subID,
 	}
 
+	for k := range sub.Topics {
+		sub.Topics[k].Name = client.mountTopicFilter(sub.Topics[k].Name)
+	}
 	for _, v := range sub.Topics {
+		s := subscription.FromTopic(v, subID)
+		client.overrideSubOptions(s)
+		if client.version == packets.Version5 && sub.Properties != nil && len(sub.Properties.User) != 0 {
+			s.UserProperties = sub.Properties.User
+		}
 		subReq.Subscriptions[v.Name] = &struct {
 			Sub   *gmqtt.Subscription
 			Error error
-		}{Sub: subscription.FromTopic(v, subID), Error: nil}
+		}{Sub: s, Error: nil}
 	}
 
 	if srv.hooks.OnSubscribe != nil {
-		err := srv.hooks.OnSubscribe(context.Background(), client, subReq)
+		err := srv.hooks.OnSubscribe(client.requestContext(), client, subReq)
 		if ce := converError(err); ce != nil {
 			suback.Properties = getErrorProperties(client, &ce.ErrorDetails)
 			for k := range suback.Payload {
@@ -877,8 +1253,18 @@ func (client *client) subscribeHandler(sub *packets.Subscribe) *codes.Error {
 	for k, v := range sub.Topics {
 		sub := subReq.Subscriptions[v.Name].Sub
 		subErr := converError(subReq.Subscriptions[v.Name].Error)
+		if subReq.ID != subID {
+			sub.ID = subReq.ID
+		}
 		var isShared bool
 		code := sub.QoS
+		// the subscription can be modified by OnSubscribe.
+		if sub.QoS > packets.Qos2 || !packets.ValidTopicFilter(true, []byte(sub.TopicFilter)) {
+			code = codes.ImplementationSpecificError
+			if packets.IsVersion3X(client.version) {
+				code = packets.SubscribeFailure
+			}
+		}
 		if client.version == packets.Version5 {
 			if sub.ShareName != "" {
 				isShared = true
@@ -910,72 +1296,61 @@ func (client *client) subscribeHandler(sub *packets.Subscribe) *codes.Error {
 		if code < packets.SubscribeFailure {
 			subRs, err = srv.subscriptionsDB.Subscribe(client.opts.ClientID, sub)
 			if err != nil {
-				zaplog.Error("failed to subscribe topic",
+				zaplog.Error("failed to subscribe topic", client.logFields(
 					zap.String("topic", v.Name),
 					zap.Uint8("qos", v.Qos),
-					zap.String("client_id", client.opts.ClientID),
-					zap.String("remote_addr", client.rwc.RemoteAddr().String()),
-					zap.Error(err))
+					zap.String("correlation_id", correlationID(client.requestContext())),
+					zap.Error(err))...)
 				code = packets.SubscribeFailure
 			}
 		}
 		suback.Payload[k] = code
 		if code < packets.SubscribeFailure {
+			if !isShared {
+				// the retained messages of the previous subscription are no longer needed.
+				client.stopRetainedStream(sub.TopicFilter)
+			}
 			if srv.hooks.OnSubscribed != nil {
-				srv.hooks.OnSubscribed(context.Background(), client, sub)
+				srv.hooks.OnSubscribed(client.requestContext(), client, sub)
 			}
-			zaplog.Info("subscribe succeeded",
+			zaplog.Info("subscribe succeeded", client.logFields(
 				zap.String("topic", sub.TopicFilter),
 				zap.Uint8("qos", sub.QoS),
 				zap.Uint8("retain_handling", sub.RetainHandling),
 				zap.Bool("retain_as_published", sub.RetainAsPublished),
 				zap.Bool("no_local", sub.NoLocal),
 				zap.Uint32("id", sub.ID),
-				zap.String("client_id", client.opts.ClientID),
-				zap.String("remote_addr", client.rwc.RemoteAddr().String()),
-			)
+				zap.String("correlation_id", correlationID(client.requestContext())),
+			)...)
 			// The spec does not specify whether the retain message should follow the 'no-local' option rule.
 			// Gmqtt follows the mosquitto implementation which will send retain messages to no-local subscriptions.
 			// For details: https://github.com/eclipse/mosquitto/issues/1796
 			if !isShared && ((!subRs[0].AlreadyExisted && v.RetainHandling != 2) || v.RetainHandling == 0) {
-				msgs := srv.retainedDB.GetMatchedMessages(sub.TopicFilter)
+				msgs := client.filterPropertyRouted(srv.retainedDB.GetMatchedMessages(sub.TopicFilter), sub)
 				for _, v := range msgs {
 					if v.QoS > subRs[0].Subscription.QoS {
 						v.QoS = subRs[0].Subscription.QoS
 					}
 					v.Dup = false
-					if !sub.RetainAsPublished {
-						v.Retained = false
-					}
-					var expiry time.Time
-					if v.MessageExpiry != 0 {
-						expiry = now.Add(time.Second * time.Duration(v.MessageExpiry))
+					// the retained messages sent as a result of a new subscription are always with the retain flag set
+					// [MQTT-3.3.1-8], the retain as published option only applies to the forwarded messages.
+					v.Retained = true
+				}
+				if err := client.sendRetained(sub.TopicFilter, msgs); err != nil {
+					if codesErr, ok := err.(*codes.Error); ok {
+						return codesErr
 					}
-					err := client.queueStore.Add(&queue.Elem{
-						At:     now,
-						Expiry: expiry,
-						MessageWithID: &queue.Publish{
-							Message: v,
-						},
-					})
-					if err != nil {
-						client.queueNotifier.notifyDropped(v, &queue.InternalError{Err: err})
-						if codesErr, ok := err.(*codes.Error); ok {
-							return codesErr
-						}
-						return &codes.Error{
-							Code: codes.UnspecifiedError,
-						}
+					return &codes.Error{
+						Code: codes.UnspecifiedError,
 					}
 				}
 			}
 		} else {
-			zaplog.Info("subscribe failed",
+			zaplog.Info("subscribe failed", client.logFields(
 				zap.String("topic", sub.TopicFilter),
 				zap.Uint8("qos", suback.Payload[k]),
-				zap.String("client_id", client.opts.ClientID),
-				zap.String("remote_addr", client.rwc.RemoteAddr().String()),
-			)
+				zap.String("correlation_id", correlationID(client.requestContext())),
+			)...)
 		}
 	}
 	client.write(suback)
@@ -985,6 +1360,7 @@ func (client *client) subscribeHandler(sub *packets.Subscribe) *codes.Error {
 func (client *client) publishHandler(pub *packets.Publish) *codes.Error {
 	srv := client.server
 	var dup bool
+	receivedAt := time.Now()
 
 	// check retain available
 	if !client.opts.RetainAvailable && pub.Retain {
@@ -994,6 +1370,9 @@ func (client *client) publishHandler(pub *packets.Publish) *codes.Error {
 	}
 	var msg *gmqtt.Message
 	msg = gmqtt.MessageFromPublish(pub)
+	if client.version != packets.Version5 && client.config.MQTT.PropertyMapping.UnwrapEnvelope {
+		unwrapEnvelope(msg)
+	}
 
 	if client.version == packets.Version5 && pub.Properties.TopicAlias != nil {
 		if *pub.Properties.TopicAlias >= client.opts.ServerTopicAliasMax {
@@ -1015,7 +1394,15 @@ func (client *client) publishHandler(pub *packets.Publish) *codes.Error {
 		}
 
 	}
+	msg.Topic = client.mountTopic(msg.Topic)
+
+	// PacketTooLarge is not a valid reason code of PUBACK and PUBREC,
+	// close the connection as the packets exceeding the maximum packet size.
+	if max := client.config.MQTT.MaxPayloadSize(msg.Topic); max != 0 && uint32(len(msg.Payload)) > max {
+		return codes.NewError(codes.PacketTooLarge)
+	}
 
+	var err error
 	if pub.Qos == packets.Qos2 {
 		exist, err := client.unackStore.Set(pub.PacketID)
 		if err != nil {
@@ -1026,27 +1413,61 @@ func (client *client) publishHandler(pub *packets.Publish) *codes.Error {
 		}
 	}
 
-	if pub.Retain {
-		if len(pub.Payload) == 0 {
-			srv.retainedDB.Remove(string(pub.TopicName))
-		} else {
-			srv.retainedDB.AddOrReplace(msg.Copy())
-		}
-	}
-
-	var err error
 	var topicMatched bool
-	if !dup {
-		opts := defaultIterateOptions(msg.Topic)
-		if srv.hooks.OnMsgArrived != nil {
+	// the messages to the blackhole topics are acknowledged as usual, but never routed or retained.
+	blackhole := client.config.MQTT.Blackhole(msg.Topic)
+	if !dup && blackhole {
+		defaultNotifier(srv.hooks.OnMsgDropped, srv.statsManager, client.opts.ClientID).notifyDropped(msg, queue.ErrDropBlackhole)
+	}
+	if !dup && !blackhole {
+		origin := msg
+		topic := msg.Topic
+		opts := defaultIterateOptions(topic)
+		if pub.Qos == packets.Qos2 && srv.overload.rejectQos2() {
+			err = &codes.Error{
+				Code: codes.QuotaExceeded,
+			}
+		} else if srv.hooks.OnMsgArrived != nil {
 			req := &MsgArrivedRequest{
 				Publish:          pub,
 				Message:          msg,
 				IterationOptions: opts,
 			}
-			err = srv.hooks.OnMsgArrived(context.Background(), client, req)
+			err = srv.hooks.OnMsgArrived(client.requestContext(), client, req)
 			msg = req.Message
 			opts = req.IterationOptions
+			if msg != nil && err == nil {
+				// follow the topic change if the hook does not set the iteration options for it.
+				if msg.Topic != topic && opts.TopicName == topic {
+					opts.TopicName = msg.Topic
+				}
+				if !packets.ValidTopicName(true, []byte(msg.Topic)) || msg.QoS > packets.Qos2 {
+					zaplog.Error("invalid message modified by OnMsgArrived", client.logFields(
+						zap.String("topic", msg.Topic),
+						zap.Uint8("qos", msg.QoS),
+						zap.String("correlation_id", correlationID(client.requestContext())))...)
+					err = &codes.Error{
+						Code: codes.ImplementationSpecificError,
+					}
+				}
+			}
+		}
+		if msg != nil && err == nil {
+			msg = annotateReceive(client.config.MQTT.ReceiveAnnotation(msg.Topic), msg, client.opts.ClientID, client.opts.Username, receivedAt)
+		}
+		// retain the message after OnMsgArrived, so that the message rejected by the hook will not be retained.
+		// The message dropped by the hook is retained as it arrives.
+		if retained := msg; err == nil {
+			if retained == nil {
+				retained = origin
+			}
+			if retained.Retained {
+				if len(retained.Payload) == 0 {
+					srv.retainedDB.Remove(retained.Topic)
+				} else {
+					srv.retainedDB.AddOrReplace(retained.Copy())
+				}
+			}
 		}
 		if msg != nil && err == nil {
 			topicMatched = client.deliverMessage(client.opts.ClientID, msg, opts)
@@ -1090,7 +1511,8 @@ func converError(err error) *codes.Error {
 	if err == nil {
 		return nil
 	}
-	if e, ok := err.(*codes.Error); ok {
+	var e *codes.Error
+	if errors.As(err, &e) {
 		return e
 	}
 	return &codes.Error{
@@ -1107,11 +1529,10 @@ func (client *client) pubackHandler(puback *packets.Puback) *codes.Error {
 		return converError(err)
 	}
 	client.pl.release(puback.PacketID)
-	if ce := zaplog.Check(zapcore.DebugLevel, "unset inflight"); ce != nil {
-		ce.Write(zap.String("clientID", client.opts.ClientID),
-			zap.Uint16("pid", puback.PacketID),
-		)
-	}
+	client.redelivery.acknowledged(puback.PacketID)
+	client.lanes.acknowledged(puback.PacketID)
+	client.acknowledged(puback.PacketID)
+	client.debug("unset inflight", zap.Uint16("pid", puback.PacketID))
 	return nil
 }
 func (client *client) pubrelHandler(pubrel *packets.Pubrel) *codes.Error {
@@ -1124,9 +1545,13 @@ func (client *client) pubrelHandler(pubrel *packets.Pubrel) *codes.Error {
 	return nil
 }
 func (client *client) pubrecHandler(pubrec *packets.Pubrec) {
+	client.redelivery.acknowledged(pubrec.PacketID)
+	client.lanes.acknowledged(pubrec.PacketID)
 	if client.version == packets.Version5 && pubrec.Code >= codes.UnspecifiedError {
 		err := client.queueStore.Remove(pubrec.PacketID)
 		client.pl.release(pubrec.PacketID)
+		client.deliveries.remove(pubrec.PacketID)
+		client.server.receipts.acknowledged(client.opts.ClientID, pubrec.PacketID, true)
 		if err != nil {
 			client.setError(err)
 		}
@@ -1146,6 +1571,8 @@ func (client *client) pubrecHandler(pubrec *packets.Pubrec) {
 func (client *client) pubcompHandler(pubcomp *packets.Pubcomp) {
 	err := client.queueStore.Remove(pubcomp.PacketID)
 	client.pl.release(pubcomp.PacketID)
+	client.redelivery.acknowledged(pubcomp.PacketID)
+	client.acknowledged(pubcomp.PacketID)
 	if err != nil {
 		client.setError(err)
 	}
@@ -1177,6 +1604,9 @@ func (client *client) unsubscribeHandler(unSub *packets.Unsubscribe) {
 		}),
 	}
 
+	for k := range unSub.Topics {
+		unSub.Topics[k] = client.mountTopicFilter(unSub.Topics[k])
+	}
 	for _, v := range unSub.Topics {
 		req.Unsubs[v] = &struct {
 			TopicName string
@@ -1184,7 +1614,7 @@ func (client *client) unsubscribeHandler(unSub *packets.Unsubscribe) {
 		}{TopicName: v}
 	}
 	if srv.hooks.OnUnsubscribe != nil {
-		err := srv.hooks.OnUnsubscribe(context.Background(), client, req)
+		err := srv.hooks.OnUnsubscribe(client.requestContext(), client, req)
 		if ce := converError(err); ce != nil {
 			unSuback.Properties = getErrorProperties(client, &ce.ErrorDetails)
 			for k := range cs {
@@ -1207,20 +1637,19 @@ func (client *client) unsubscribeHandler(unSub *packets.Unsubscribe) {
 			}
 		}
 		if code == codes.Success {
+			client.stopRetainedStream(topicName)
 			if srv.hooks.OnUnsubscribed != nil {
-				srv.hooks.OnUnsubscribed(context.Background(), client, topicName)
+				srv.hooks.OnUnsubscribed(client.requestContext(), client, topicName)
 			}
-			zaplog.Info("unsubscribed succeed",
+			zaplog.Info("unsubscribed succeed", client.logFields(
 				zap.String("topic", topicName),
-				zap.String("client_id", client.opts.ClientID),
-				zap.String("remote_addr", client.rwc.RemoteAddr().String()),
-			)
+				zap.String("correlation_id", correlationID(client.requestContext())),
+			)...)
 		} else {
-			zaplog.Info("unsubscribed failed",
+			zaplog.Info("unsubscribed failed", client.logFields(
 				zap.String("topic", topicName),
-				zap.String("client_id", client.opts.ClientID),
-				zap.String("remote_addr", client.rwc.RemoteAddr().String()),
-				zap.Uint8("code", code))
+				zap.String("correlation_id", correlationID(client.requestContext())),
+				zap.Uint8("code", code))...)
 		}
 		cs[k] = code
 
@@ -1234,7 +1663,7 @@ func (client *client) reAuthHandler(auth *packets.Auth) *codes.Error {
 	var resp *AuthResponse
 	var err error
 	if srv.hooks.OnReAuth != nil {
-		resp, err = srv.hooks.OnReAuth(context.Background(), client, auth)
+		resp, err = srv.hooks.OnReAuth(client.requestContext(), client, auth)
 		ce := converError(err)
 		if ce != nil {
 			return ce
@@ -1272,31 +1701,40 @@ func (client *client) disconnectHandler(dis *packets.Disconnect) *codes.Error {
 				Code: codes.ProtocolError,
 			}
 		}
+		if disExpiry > client.maxSessionExpiry {
+			disExpiry = client.maxSessionExpiry
+		}
 		if disExpiry != 0 {
 			err := client.server.sessionStore.SetSessionExpiry(sess.ClientID, disExpiry)
 			if err != nil {
-				zaplog.Error("fail to set session expiry",
-					zap.String("client_id", client.opts.ClientID),
-					zap.Error(err))
+				zaplog.Error("fail to set session expiry", client.logFields(zap.Error(err))...)
 			}
 		}
 	}
 	client.disconnect = dis
 	// 不发送will message
-	client.cleanWillFlag = true
+	if dis.Code != codes.DisconnectWithWillMessage {
+		client.cleanWillFlag = true
+	}
 	return nil
 }
 
-//读处理
+// 读处理
 func (client *client) readHandle() {
 	var err error
+	var packet packets.Packet
 	defer func() {
 		if re := recover(); re != nil {
 			err = errors.New(fmt.Sprint(re))
 		}
+		if err != nil && packet != nil {
+			err = &packetError{err: err, packet: packet}
+		}
 		client.setError(err)
 	}()
-	for packet := range client.in {
+	for in := range client.in {
+		client.reqCtx = in.ctx
+		packet = in.packet
 		if client.version == packets.Version5 {
 			if client.opts.ServerMaxPacketSize != 0 && packets.TotalBytes(packet) > client.opts.ServerMaxPacketSize {
 				err = codes.NewError(codes.PacketTooLarge)
@@ -1322,7 +1760,9 @@ func (client *client) readHandle() {
 		case *packets.Unsubscribe:
 			client.unsubscribeHandler(packet.(*packets.Unsubscribe))
 		case *packets.Disconnect:
-			codeErr = client.disconnectHandler(packet.(*packets.Disconnect))
+			if codeErr = client.disconnectHandler(packet.(*packets.Disconnect)); codeErr != nil {
+				err = codeErr
+			}
 			return
 		case *packets.Auth:
 			auth := packet.(*packets.Auth)
@@ -1330,14 +1770,17 @@ func (client *client) readHandle() {
 				err = codes.ErrProtocol
 				return
 			}
-			if !bytes.Equal(client.opts.AuthMethod, auth.Properties.AuthData) {
-				codeErr = codes.ErrProtocol
+			// the re-authentication must use the same method as the CONNECT packet [MQTT-4.12.1-1]
+			if !bytes.Equal(client.opts.AuthMethod, auth.Properties.AuthMethod) {
+				err = codes.ErrProtocol
 				return
 			}
 			codeErr = client.reAuthHandler(auth)
 
 		default:
+			// e.g. the second CONNECT packet [MQTT-3.1.0-2]
 			err = codes.ErrProtocol
+			return
 		}
 		if codeErr != nil {
 			err = codeErr
@@ -1370,12 +1813,28 @@ func (client *client) pollInflights() (cont bool, err error) {
 		id := v.MessageWithID.ID()
 		switch m := v.MessageWithID.(type) {
 		case *queue.Publish:
-			m.Dup = true
+			// the message which has never been written to the previous client is not a duplicate.
+			m.Dup = !client.takeUnsent(id)
+			if !m.Dup {
+				client.unsent.add(id)
+			}
 			// https://docs.oasis-open.org/mqtt/mqtt/v5.0/os/mqtt-v5.0-os.html#_Subscription_Options
 			// The Server need not use the same set of Subscription Identifiers in the retransmitted PUBLISH packet.
 			m.SubscriptionIdentifier = nil
+			var msg *gmqtt.Message
+			msg, err = client.mapProperties(m)
+			if err != nil {
+				return false, err
+			}
+			if msg == nil {
+				continue
+			}
 			client.pl.markUsedLocked(id)
-			client.write(gmqtt.MessageToPublish(m.Message, client.version))
+			pub := gmqtt.MessageToPublish(client.unmountMessage(msg), client.version)
+			client.deliveries.track(pub, v.At)
+			client.server.receipts.sent(client.opts.ClientID, m.Message, pub)
+			client.server.statsManager.sharedRedelivered(client.opts.ClientID, m.Topic)
+			client.writePublish(m.Topic, pub)
 		case *queue.Pubrel:
 			client.write(&packets.Pubrel{PacketID: id})
 		}
@@ -1401,12 +1860,49 @@ func (client *client) pollNewMessages(ids []packets.PacketID) (unused []packets.
 				d := uint32(now.Sub(v.At).Seconds())
 				m.Message.MessageExpiry = d
 			}
-			client.write(gmqtt.MessageToPublish(m.Message, client.version))
+			var msg *gmqtt.Message
+			msg, err = client.mapProperties(m)
+			if err != nil {
+				return nil, err
+			}
+			if msg == nil {
+				if m.QoS != packets.Qos0 {
+					client.pl.release(m.PacketID)
+				}
+				continue
+			}
+			if m.QoS != packets.Qos0 {
+				client.unsent.add(m.PacketID)
+			}
+			pub := gmqtt.MessageToPublish(client.unmountMessage(msg), client.version)
+			client.deliveries.track(pub, v.At)
+			client.server.receipts.sent(client.opts.ClientID, m.Message, pub)
+			client.writePublish(m.Topic, pub)
 		case *queue.Pubrel:
 		}
 	}
 	return ids, err
 }
+
+// mapProperties applies the property mapping to the message for the V3 client.
+// It returns nil if the message is rejected, in which case the message is removed from the queue and reported as dropped.
+func (client *client) mapProperties(m *queue.Publish) (*gmqtt.Message, error) {
+	if client.version == packets.Version5 {
+		return m.Message, nil
+	}
+	msg, err := mapPropertiesToV3(client.config.MQTT.PropertyMapping, m.Message)
+	if err != queue.ErrDropIncompatible {
+		return msg, err
+	}
+	if m.QoS != packets.Qos0 {
+		if err = client.queueStore.Remove(m.PacketID); err != nil {
+			return nil, err
+		}
+	}
+	client.queueNotifier.notifyDropped(m.Message, queue.ErrDropIncompatible)
+	return nil, nil
+}
+
 func (client *client) pollMessageHandler() {
 	var err error
 	defer func() {
@@ -1418,6 +1914,9 @@ func (client *client) pollMessageHandler() {
 	// drain all inflight messages
 	cont := true
 	for cont {
+		if !client.writeBuffer.wait(client.close) {
+			return
+		}
 		cont, err = client.pollInflights()
 		if err != nil {
 			return
@@ -1429,6 +1928,10 @@ func (client *client) pollMessageHandler() {
 		if client.opts.MaxInflight < max {
 			max = client.opts.MaxInflight
 		}
+		// pause reading the queue until the client catches up.
+		if !client.writeBuffer.wait(client.close) {
+			return
+		}
 		ids = client.pl.pollPacketIDs(max)
 		if ids == nil {
 			return
@@ -1441,16 +1944,25 @@ func (client *client) pollMessageHandler() {
 	}
 }
 
-//server goroutine结束的条件:1客户端断开连接 或 2发生错误
+// server goroutine结束的条件:1客户端断开连接 或 2发生错误
 func (client *client) serve() {
 	defer client.internalClose()
+	if client.codec != nil {
+		if err := client.handshake(); err != nil {
+			zaplog.Warn("connection handshake failed", client.logFields(zap.Error(err))...)
+			_ = client.rwc.Close()
+			return
+		}
+	}
 	readWg := &sync.WaitGroup{}
 
 	readWg.Add(1)
-	go func() { //read
-		client.readLoop()
-		readWg.Done()
-	}()
+	if !client.pollRead(readWg.Done) {
+		go func() { //read
+			client.readLoop()
+			readWg.Done()
+		}()
+	}
 
 	client.wg.Add(1)
 	go func() { //write
@@ -1468,14 +1980,22 @@ func (client *client) serve() {
 			client.readHandle()
 			client.wg.Done()
 		}()
+		if client.redelivery != nil {
+			client.wg.Add(1)
+			go func() {
+				client.redeliveryLoop()
+				client.wg.Done()
+			}()
+		}
 
 	}
 	readWg.Wait()
+	client.stopKeepAlive()
 
 	if client.queueStore != nil {
 		qerr := client.queueStore.Close()
 		if qerr != nil {
-			zaplog.Error("fail to close message queue", zap.String("client_id", client.opts.ClientID), zap.Error(qerr))
+			zaplog.Error("fail to close message queue", client.logFields(zap.Error(qerr))...)
 		}
 	}
 	if client.pl != nil {
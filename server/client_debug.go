@@ -0,0 +1,152 @@
+package server
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+
+	"github.com/DrmagicE/gmqtt/pkg/packets"
+)
+
+// debugTargets records the client ids and ips which the debug logging is enabled for.
+type debugTargets struct {
+	// n is the number of the targets, it is the fast path for the case that no target is set.
+	n         int32
+	mu        sync.RWMutex
+	clientIDs map[string]time.Time
+	ips       map[string]time.Time
+}
+
+func newDebugTargets() *debugTargets {
+	return &debugTargets{
+		clientIDs: make(map[string]time.Time),
+		ips:       make(map[string]time.Time),
+	}
+}
+
+func (d *debugTargets) updateLen() {
+	atomic.StoreInt32(&d.n, int32(len(d.clientIDs)+len(d.ips)))
+}
+
+// enable enables the debug logging for the client id and the ip until the given time, empty value is ignored.
+func (d *debugTargets) enable(clientID, ip string, until time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := time.Now()
+	for _, m := range []map[string]time.Time{d.clientIDs, d.ips} {
+		for k, v := range m {
+			if !now.Before(v) {
+				delete(m, k)
+			}
+		}
+	}
+	if clientID != "" {
+		d.clientIDs[clientID] = until
+	}
+	if ip != "" {
+		d.ips[ip] = until
+	}
+	d.updateLen()
+}
+
+// disable disables the debug logging for the client id and the ip, empty value is ignored.
+func (d *debugTargets) disable(clientID, ip string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.clientIDs, clientID)
+	delete(d.ips, ip)
+	d.updateLen()
+}
+
+// enabled returns whether the debug logging is enabled for the client id or the ip.
+func (d *debugTargets) enabled(clientID, ip string, now time.Time) bool {
+	if d == nil || atomic.LoadInt32(&d.n) == 0 {
+		return false
+	}
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if until, ok := d.clientIDs[clientID]; ok && now.Before(until) {
+		return true
+	}
+	if until, ok := d.ips[ip]; ok && now.Before(until) {
+		return true
+	}
+	return false
+}
+
+// debugCore writes the entries regardless of the level of the wrapped core.
+type debugCore struct {
+	zapcore.Core
+}
+
+func (c *debugCore) Enabled(zapcore.Level) bool {
+	return true
+}
+
+func (c *debugCore) With(fields []zapcore.Field) zapcore.Core {
+	return &debugCore{Core: c.Core.With(fields)}
+}
+
+func (c *debugCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	return ce.AddCore(ent, c)
+}
+
+// debugLogger returns the logger which writes the debug logs even if the log level is higher than debug.
+func debugLogger() *zap.Logger {
+	return zaplog.WithOptions(zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+		return &debugCore{Core: core}
+	}))
+}
+
+// debugEnabled returns whether the debug logging is enabled for the client at runtime.
+func (client *client) debugEnabled() bool {
+	var ip string
+	if client.rwc != nil {
+		ip = remoteIP(client.rwc.RemoteAddr())
+	}
+	return client.server.debugTargets.enabled(client.opts.ClientID, ip, time.Now())
+}
+
+// debug writes the debug log of the client.
+// If the debug logging is enabled for the client, the log is written regardless of the log level.
+func (client *client) debug(msg string, fields ...zap.Field) {
+	if ce := zaplog.Check(zapcore.DebugLevel, msg); ce != nil {
+		ce.Write(client.logFields(fields...)...)
+		return
+	}
+	if client.debugEnabled() {
+		debugLogger().Debug(msg, client.logFields(fields...)...)
+	}
+}
+
+// dumpPacket dumps the packet of the client.
+// If the debug logging is enabled for the client, all packets are dumped to the main log regardless of
+// the log level and the packet dump setting.
+func (client *client) dumpPacket(msg string, packet packets.Packet, fields ...zap.Field) {
+	if client.debugEnabled() {
+		debugLogger().Debug(msg, client.logFields(append(packetFields(packet), fields...)...)...)
+		return
+	}
+	client.server.packetDumper.dump(client, msg, packet, fields...)
+}
+
+// EnableDebugLogging enables the debug logging for the client id or the ip for the given duration.
+func (c *clientService) EnableDebugLogging(clientID, ip string, duration time.Duration) {
+	until := time.Now().Add(duration)
+	c.srv.debugTargets.enable(clientID, ip, until)
+	zaplog.Info("debug logging enabled",
+		zap.String("client_id", clientID),
+		zap.String("remote_ip", redactRemoteAddr(c.srv.config.Log, ip)),
+		zap.Time("until", until))
+}
+
+// DisableDebugLogging disables the debug logging for the client id or the ip.
+func (c *clientService) DisableDebugLogging(clientID, ip string) {
+	c.srv.debugTargets.disable(clientID, ip)
+	zaplog.Info("debug logging disabled",
+		zap.String("client_id", clientID),
+		zap.String("remote_ip", redactRemoteAddr(c.srv.config.Log, ip)))
+}
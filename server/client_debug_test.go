@@ -0,0 +1,71 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+
+	"github.com/DrmagicE/gmqtt/pkg/packets"
+)
+
+func TestDebugTargets(t *testing.T) {
+	a := assert.New(t)
+	var nilTargets *debugTargets
+	a.False(nilTargets.enabled("cid", "127.0.0.1", time.Now()))
+
+	d := newDebugTargets()
+	now := time.Now()
+	a.False(d.enabled("cid", "127.0.0.1", now))
+	d.enable("cid", "", now.Add(time.Minute))
+	d.enable("", "10.0.0.1", now.Add(time.Minute))
+	a.True(d.enabled("cid", "127.0.0.1", now))
+	a.True(d.enabled("other", "10.0.0.1", now))
+	a.False(d.enabled("other", "127.0.0.1", now))
+	// expired
+	a.False(d.enabled("cid", "127.0.0.1", now.Add(time.Minute)))
+
+	d.disable("cid", "")
+	a.False(d.enabled("cid", "127.0.0.1", now))
+	a.True(d.enabled("cid", "10.0.0.1", now))
+	d.disable("", "10.0.0.1")
+	a.EqualValues(0, d.n)
+}
+
+func TestClient_debug(t *testing.T) {
+	a := assert.New(t)
+	core, logs := observer.New(zapcore.InfoLevel)
+	old := zaplog
+	zaplog = zap.New(core)
+	defer func() {
+		zaplog = old
+	}()
+
+	srv := defaultServer()
+	c, err := srv.newClient(noopConn{})
+	a.NoError(err)
+	c.opts.ClientID = "cid"
+
+	c.debug("unset inflight")
+	c.dumpPacket("received packet", &packets.Pingreq{})
+	a.Equal(0, logs.Len())
+
+	srv.clientService = &clientService{srv: srv}
+	srv.clientService.EnableDebugLogging("cid", "", time.Minute)
+	c.debug("unset inflight", zap.Uint16("pid", 1))
+	c.dumpPacket("received packet", &packets.Pingreq{})
+	entries := logs.FilterMessageSnippet("unset inflight").All()
+	a.Len(entries, 1)
+	a.Equal(zapcore.DebugLevel, entries[0].Level)
+	a.EqualValues(1, entries[0].ContextMap()["pid"])
+	entries = logs.FilterMessageSnippet("received packet").All()
+	a.Len(entries, 1)
+	a.Equal("c000", entries[0].ContextMap()["hex"])
+
+	srv.clientService.DisableDebugLogging("cid", "")
+	c.debug("unset inflight")
+	a.Len(logs.FilterMessageSnippet("unset inflight").All(), 1)
+}
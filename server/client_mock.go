@@ -64,6 +64,20 @@ func (mr *MockClientMockRecorder) SessionInfo() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SessionInfo", reflect.TypeOf((*MockClient)(nil).SessionInfo))
 }
 
+// Attributes mocks base method
+func (m *MockClient) Attributes() map[string]string {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Attributes")
+	ret0, _ := ret[0].(map[string]string)
+	return ret0
+}
+
+// Attributes indicates an expected call of Attributes
+func (mr *MockClientMockRecorder) Attributes() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Attributes", reflect.TypeOf((*MockClient)(nil).Attributes))
+}
+
 // Version mocks base method
 func (m *MockClient) Version() packets.Version {
 	m.ctrl.T.Helper()
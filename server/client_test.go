@@ -5,9 +5,11 @@ import (
 	"container/list"
 	"context"
 	"errors"
+	"fmt"
 	"io"
 	"net"
 	"reflect"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
@@ -19,6 +21,7 @@ import (
 	"github.com/DrmagicE/gmqtt"
 	"github.com/DrmagicE/gmqtt/config"
 	"github.com/DrmagicE/gmqtt/persistence/queue"
+	sessmem "github.com/DrmagicE/gmqtt/persistence/session/mem"
 	"github.com/DrmagicE/gmqtt/persistence/subscription"
 	"github.com/DrmagicE/gmqtt/persistence/subscription/mem"
 	"github.com/DrmagicE/gmqtt/persistence/unack"
@@ -26,6 +29,7 @@ import (
 	"github.com/DrmagicE/gmqtt/pkg/codes"
 	"github.com/DrmagicE/gmqtt/pkg/packets"
 	"github.com/DrmagicE/gmqtt/retained"
+	retained_trie "github.com/DrmagicE/gmqtt/retained/trie"
 )
 
 const testRedeliveryInternal = 10 * time.Second
@@ -270,6 +274,107 @@ func TestClient_subscribeHandler_common(t *testing.T) {
 
 }
 
+func TestClient_subscribeHandler_overrideOptions(t *testing.T) {
+	a := assert.New(t)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	subDB := subscription.NewMockStore(ctrl)
+	srv := defaultServer()
+	srv.subscriptionsDB = subDB
+	srv.hooks.OnSubscribe = func(ctx context.Context, client Client, req *SubscribeRequest) error {
+		req.GrantQoS("a", packets.Qos0).SetNoLocal("a", true)
+		req.Subscriptions["a"].Sub.ID = 10
+		req.SetTopicFilter("b", "$share/g/normalized/b")
+		req.SetTopicFilter("c", "c/#/invalid")
+		return nil
+	}
+	c, er := srv.newClient(noopConn{})
+	a.NoError(er)
+	c.opts.ClientID = "cid"
+	c.opts.SubIDAvailable = true
+	c.opts.SharedSubAvailable = true
+	c.opts.WildcardSubAvailable = true
+	c.version = packets.Version5
+
+	subA := &gmqtt.Subscription{
+		TopicFilter: "a",
+		QoS:         packets.Qos0,
+		NoLocal:     true,
+		ID:          10,
+	}
+	subB := &gmqtt.Subscription{
+		ShareName:   "g",
+		TopicFilter: "normalized/b",
+		QoS:         packets.Qos1,
+	}
+	gomock.InOrder(
+		subDB.EXPECT().Subscribe("cid", subA).Return(subscription.SubscribeResult{
+			{Subscription: subA},
+		}, nil),
+		subDB.EXPECT().Subscribe("cid", subB).Return(subscription.SubscribeResult{
+			{Subscription: subB},
+		}, nil),
+	)
+
+	a.Nil(c.subscribeHandler(&packets.Subscribe{
+		Version:  packets.Version5,
+		PacketID: 1,
+		Topics: []packets.Topic{
+			{Name: "a", SubOptions: packets.SubOptions{Qos: packets.Qos2}},
+			{Name: "b", SubOptions: packets.SubOptions{Qos: packets.Qos1}},
+			{Name: "c", SubOptions: packets.SubOptions{Qos: packets.Qos1}},
+		},
+		Properties: &packets.Properties{},
+	}))
+	suback := (<-c.out).(*packets.Suback)
+	a.Equal([]codes.Code{packets.Qos0, packets.Qos1, codes.ImplementationSpecificError}, suback.Payload)
+}
+
+func TestClient_subscribeHandler_listenerSubOptions(t *testing.T) {
+	a := assert.New(t)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	subDB := subscription.NewMockStore(ctrl)
+	srv := defaultServer()
+	srv.subscriptionsDB = subDB
+	srv.hooks.OnSubscribe = func(ctx context.Context, client Client, req *SubscribeRequest) error {
+		// the hooks see the options overridden by the listener.
+		a.True(req.Subscriptions["a"].Sub.NoLocal)
+		a.False(req.Subscriptions["a"].Sub.RetainAsPublished)
+		return nil
+	}
+	c, er := srv.newClient(noopConn{})
+	a.NoError(er)
+	c.opts.ClientID = "cid"
+	c.version = packets.Version5
+	noLocal, retainAsPublished := true, false
+	c.noLocalOverride = &noLocal
+	c.retainAsPublishedOverride = &retainAsPublished
+
+	subA := &gmqtt.Subscription{
+		TopicFilter:    "a",
+		QoS:            packets.Qos1,
+		NoLocal:        true,
+		RetainHandling: 2,
+	}
+	subDB.EXPECT().Subscribe("cid", subA).Return(subscription.SubscribeResult{
+		{Subscription: subA},
+	}, nil)
+
+	a.Nil(c.subscribeHandler(&packets.Subscribe{
+		Version:  packets.Version5,
+		PacketID: 1,
+		Topics: []packets.Topic{
+			{Name: "a", SubOptions: packets.SubOptions{Qos: packets.Qos1, RetainAsPublished: true, RetainHandling: 2}},
+		},
+		Properties: &packets.Properties{},
+	}))
+	suback := (<-c.out).(*packets.Suback)
+	a.Equal([]codes.Code{packets.Qos1}, suback.Payload)
+}
+
 func TestClient_subscribeHandler_shareSubscription(t *testing.T) {
 	var tt = []struct {
 		name               string
@@ -421,6 +526,39 @@ func TestClient_subscribeHandler_shareSubscription(t *testing.T) {
 
 }
 
+func TestClient_subscribeHandler_shareSubscriptionNoLocal(t *testing.T) {
+	a := assert.New(t)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	srv := &server{
+		config:          config.DefaultConfig(),
+		subscriptionsDB: subscription.NewMockStore(ctrl),
+		retainedDB:      retained.NewMockStore(ctrl),
+	}
+	c, err := srv.newClient(noopConn{})
+	a.Nil(err)
+	c.opts.ClientID = "cid"
+	c.opts.SharedSubAvailable = true
+	c.version = packets.Version5
+	// It is a Protocol Error to set the No Local bit to 1 on a Shared Subscription [MQTT-3.8.3-4].
+	a.Equal(codes.ErrProtocol, c.subscribeHandler(&packets.Subscribe{
+		Version:  packets.Version5,
+		PacketID: 1,
+		Topics: []packets.Topic{
+			{
+				SubOptions: packets.SubOptions{Qos: 1},
+				Name:       "A",
+			}, {
+				SubOptions: packets.SubOptions{Qos: 1, NoLocal: true},
+				Name:       "$share/topic/A",
+			},
+		},
+		Properties: &packets.Properties{},
+	}))
+	a.Empty(c.out)
+}
+
 func TestClient_subscribeHandler_retainedMessage(t *testing.T) {
 	var tt = []struct {
 		name              string
@@ -476,7 +614,7 @@ func TestClient_subscribeHandler_retainedMessage(t *testing.T) {
 			expected: struct {
 				qos      uint8
 				retained bool
-			}{qos: 1, retained: false},
+			}{qos: 1, retained: true},
 			alreadyExisted:     false,
 			shouldSendRetained: true,
 		},
@@ -614,7 +752,7 @@ func TestClient_subscribeHandler_retainedMessage(t *testing.T) {
 			expected: struct {
 				qos      uint8
 				retained bool
-			}{qos: 1, retained: false},
+			}{qos: 1, retained: true},
 			alreadyExisted:     false,
 			shouldSendRetained: true,
 		},
@@ -718,13 +856,13 @@ func TestClient_subscribeHandler_retainedMessage(t *testing.T) {
 				config:          config.DefaultConfig(),
 				subscriptionsDB: subDB,
 				retainedDB:      retainedDB,
+				registry:        newRegistry(),
 			}
 			c, er := srv.newClient(noopConn{})
 			a.Nil(er)
 			c.opts.ClientID = v.clientID
 			c.queueStore = qs
-			srv.queueStore = make(map[string]queue.Store)
-			srv.queueStore[v.clientID] = qs
+			srv.registry.shard(v.clientID).queueStore[v.clientID] = qs
 
 			if v.shouldSendRetained {
 				qs.EXPECT().Add(gomock.Any()).DoAndReturn(func(elem *queue.Elem) error {
@@ -851,6 +989,7 @@ func TestClient_publishHandler_common(t *testing.T) {
 				config:          config.DefaultConfig(),
 				retainedDB:      retainedDB,
 				subscriptionsDB: subscriptionDB,
+				registry:        newRegistry(),
 			}
 
 			var deliverMessageCalled bool
@@ -867,8 +1006,7 @@ func TestClient_publishHandler_common(t *testing.T) {
 			c.unackStore = unack_mem.New(unack_mem.Options{
 				ClientID: v.clientID,
 			})
-			srv.unackStore = make(map[string]unack.Store)
-			srv.unackStore[v.clientID] = c.unackStore
+			srv.registry.shard(v.clientID).unackStore[v.clientID] = c.unackStore
 
 			a.Nil(er)
 			c.opts.ClientID = v.clientID
@@ -901,6 +1039,355 @@ func TestClient_publishHandler_common(t *testing.T) {
 
 }
 
+func TestClient_publishHandler_topicPayloadLimit(t *testing.T) {
+	a := assert.New(t)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	cfg := config.DefaultConfig()
+	cfg.MQTT.TopicPayloadLimits = []config.TopicPayloadLimit{
+		{TopicFilter: "telemetry/#", MaxPayloadSize: 2},
+	}
+	srv := &server{
+		config:   cfg,
+		registry: newRegistry(),
+	}
+	c, er := srv.newClient(noopConn{})
+	a.NoError(er)
+	c.opts.ClientID = "cid"
+	c.version = packets.Version5
+	c.unackStore = unack_mem.New(unack_mem.Options{
+		ClientID: "cid",
+	})
+	var delivered []string
+	c.deliverMessage = func(srcClientID string, msg *gmqtt.Message, options subscription.IterationOptions) (matched bool) {
+		delivered = append(delivered, msg.Topic)
+		return true
+	}
+	for _, v := range []struct {
+		topic   string
+		qos     uint8
+		version packets.Version
+		err     *codes.Error
+	}{
+		{topic: "telemetry/a", qos: packets.Qos1, version: packets.Version5, err: codes.NewError(codes.PacketTooLarge)},
+		{topic: "telemetry/a", qos: packets.Qos2, version: packets.Version5, err: codes.NewError(codes.PacketTooLarge)},
+		{topic: "telemetry/a", qos: packets.Qos1, version: packets.Version311, err: codes.NewError(codes.PacketTooLarge)},
+		{topic: "ota/a", qos: packets.Qos1, version: packets.Version5},
+	} {
+		c.version = v.version
+		pub := &packets.Publish{
+			Version:    v.version,
+			Qos:        v.qos,
+			TopicName:  []byte(v.topic),
+			PacketID:   1,
+			Payload:    []byte("abc"),
+			Properties: &packets.Properties{},
+		}
+		a.Equal(v.err, c.publishHandler(pub))
+		if v.err != nil {
+			// the connection is closed without acknowledging the message.
+			a.Len(c.out, 0)
+			continue
+		}
+		a.Equal(codes.Success, (<-c.out).(*packets.Puback).Code)
+	}
+	a.Equal([]string{"ota/a"}, delivered)
+	// the QoS 2 message exceeding the limit is not stored.
+	exist, err := c.unackStore.Set(1)
+	a.Nil(err)
+	a.False(exist)
+}
+
+func TestSendErrConnack_reconnectAdvice(t *testing.T) {
+	a := assert.New(t)
+	cfg := config.DefaultConfig()
+	srv := &server{
+		config:   cfg,
+		registry: newRegistry(),
+	}
+	newClient := func() *client {
+		c, err := srv.newClient(noopConn{})
+		a.NoError(err)
+		c.version = packets.Version5
+		return c
+	}
+	// disabled
+	c := newClient()
+	sendErrConnack(c, &codes.Error{Code: codes.Banned})
+	a.Nil((<-c.out).(*packets.Connack).Properties)
+
+	srv.config.MQTT.ReconnectAdvice.RetryAfter = time.Minute
+	srv.config.MQTT.ReconnectAdvice.ServerReference = "backup:1883"
+	for _, code := range []codes.Code{codes.ServerBusy, codes.Banned, codes.QuotaExceeded} {
+		c = newClient()
+		sendErrConnack(c, &codes.Error{Code: code})
+		ppt := (<-c.out).(*packets.Connack).Properties
+		a.Equal([]packets.UserProperty{{K: []byte("retry-after"), V: []byte("60")}}, ppt.User)
+		a.Equal([]byte("backup:1883"), ppt.ServerReference)
+	}
+	// not advised
+	c = newClient()
+	sendErrConnack(c, &codes.Error{Code: codes.NotAuthorized})
+	a.Nil((<-c.out).(*packets.Connack).Properties)
+	// V3
+	c = newClient()
+	c.version = packets.Version311
+	sendErrConnack(c, &codes.Error{Code: codes.Banned})
+	connack := (<-c.out).(*packets.Connack)
+	a.EqualValues(codes.V3NotAuthorized, connack.Code)
+	a.Nil(connack.Properties)
+}
+
+func TestSendErrConnack_refusal(t *testing.T) {
+	a := assert.New(t)
+	srv := &server{
+		config:   config.DefaultConfig(),
+		registry: newRegistry(),
+	}
+	c, err := srv.newClient(noopConn{})
+	a.NoError(err)
+	c.version = packets.Version5
+	c.opts.RequestProblemInfo = true
+	refusal := codes.NewError(codes.QuotaExceeded).WithReasonString("payment required").WithUserProperty("plan", "free")
+	sendErrConnack(c, fmt.Errorf("billing: %w", refusal))
+	connack := (<-c.out).(*packets.Connack)
+	a.Equal(codes.QuotaExceeded, connack.Code)
+	a.Equal([]byte("payment required"), connack.Properties.ReasonString)
+	a.Equal([]packets.UserProperty{{K: []byte("plan"), V: []byte("free")}}, connack.Properties.User)
+	// the refusal is not modified for the V3 client.
+	c.version = packets.Version311
+	sendErrConnack(c, refusal)
+	a.EqualValues(codes.V3NotAuthorized, (<-c.out).(*packets.Connack).Code)
+	a.Equal(codes.QuotaExceeded, refusal.Code)
+}
+
+func TestConnackCode(t *testing.T) {
+	a := assert.New(t)
+	var tt = []struct {
+		version packets.Version
+		code    codes.Code
+		want    codes.Code
+	}{
+		{packets.Version5, codes.NotAuthorized, codes.NotAuthorized},
+		{packets.Version5, codes.ServerMoved, codes.ServerMoved},
+		{packets.Version5, codes.V3BadUsernameorPassword, codes.BadUserNameOrPassword},
+		{packets.Version5, codes.V3IdentifierRejected, codes.ClientIdentifierNotValid},
+		// not valid for CONNACK
+		{packets.Version5, codes.Success, codes.UnspecifiedError},
+		{packets.Version5, codes.SessionTakenOver, codes.UnspecifiedError},
+		{packets.Version311, codes.BadUserNameOrPassword, codes.V3BadUsernameorPassword},
+		{packets.Version311, codes.ServerBusy, codes.V3ServerUnavaliable},
+		{packets.Version311, codes.UnsupportedProtocolVersion, codes.V3UnacceptableProtocolVersion},
+		{packets.Version311, codes.V3IdentifierRejected, codes.V3IdentifierRejected},
+		{packets.Version311, codes.QuotaExceeded, codes.V3NotAuthorized},
+		{packets.Version31, codes.Success, codes.V3NotAuthorized},
+	}
+	for _, v := range tt {
+		a.Equal(v.want, connackCode(v.version, v.code), "version %d code %x", v.version, v.code)
+	}
+}
+
+func TestClient_publishHandler_blackhole(t *testing.T) {
+	a := assert.New(t)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	cfg := config.DefaultConfig()
+	cfg.MQTT.BlackholeTopics = []string{"legacy/#"}
+	var dropped []string
+	srv := &server{
+		config:       cfg,
+		registry:     newRegistry(),
+		retainedDB:   retained_trie.NewStore(),
+		statsManager: newStatsManager(mem.NewStore()),
+		hooks: Hooks{
+			OnMsgDropped: func(ctx context.Context, clientID string, msg *gmqtt.Message, err error) {
+				a.Equal("cid", clientID)
+				a.Equal(DropReasonBlackhole, DropReasonOf(err))
+				dropped = append(dropped, msg.Topic)
+			},
+		},
+	}
+	c, er := srv.newClient(noopConn{})
+	a.NoError(er)
+	c.opts.ClientID = "cid"
+	c.opts.RetainAvailable = true
+	c.version = packets.Version5
+	c.unackStore = unack_mem.New(unack_mem.Options{
+		ClientID: "cid",
+	})
+	var delivered []string
+	c.deliverMessage = func(srcClientID string, msg *gmqtt.Message, options subscription.IterationOptions) (matched bool) {
+		delivered = append(delivered, msg.Topic)
+		return true
+	}
+	for _, v := range []struct {
+		topic string
+		code  codes.Code
+	}{
+		{topic: "legacy/a", code: codes.NotMatchingSubscribers},
+		{topic: "a", code: codes.Success},
+	} {
+		pub := &packets.Publish{
+			Version:    packets.Version5,
+			Qos:        packets.Qos1,
+			Retain:     true,
+			TopicName:  []byte(v.topic),
+			PacketID:   1,
+			Payload:    []byte("abc"),
+			Properties: &packets.Properties{},
+		}
+		a.Nil(c.publishHandler(pub))
+		p := <-c.out
+		a.Equal(v.code, p.(*packets.Puback).Code)
+	}
+	a.Equal([]string{"a"}, delivered)
+	a.Equal([]string{"legacy/a"}, dropped)
+	a.Nil(srv.retainedDB.GetRetainedMessage("legacy/a"))
+	a.NotNil(srv.retainedDB.GetRetainedMessage("a"))
+	a.EqualValues(1, srv.statsManager.GetGlobalStats().MessageStats.Qos1.DroppedTotal.Blackhole)
+}
+
+func TestClient_publishHandler_receiveAnnotation(t *testing.T) {
+	a := assert.New(t)
+	srv := defaultServer()
+	srv.config.MQTT.ReceiveAnnotations = []config.ReceiveAnnotation{
+		{TopicFilter: "telemetry/#", ReceivedAt: "received-at", ClientID: "publisher"},
+		{TopicFilter: "devices/#", ClientID: "publisher", Username: "publisher-username"},
+	}
+	c, er := srv.newClient(noopConn{})
+	a.NoError(er)
+	c.opts.ClientID = "cid"
+	c.opts.Username = "user"
+	c.version = packets.Version5
+	var delivered []*gmqtt.Message
+	c.deliverMessage = func(srcClientID string, msg *gmqtt.Message, options subscription.IterationOptions) (matched bool) {
+		delivered = append(delivered, msg)
+		return true
+	}
+	before := time.Now().UnixNano() / int64(time.Millisecond)
+	for _, topic := range []string{"telemetry/a", "a"} {
+		a.Nil(c.publishHandler(&packets.Publish{
+			Version:   packets.Version5,
+			TopicName: []byte(topic),
+			Payload:   []byte("abc"),
+			Properties: &packets.Properties{
+				User: []packets.UserProperty{
+					{K: []byte("publisher"), V: []byte("spoofed")},
+					{K: []byte("k"), V: []byte("v")},
+				},
+			},
+		}))
+	}
+	after := time.Now().UnixNano() / int64(time.Millisecond)
+	a.Len(delivered, 2)
+
+	props := delivered[0].UserProperties
+	a.Len(props, 3)
+	a.Equal(packets.UserProperty{K: []byte("k"), V: []byte("v")}, props[0])
+	a.Equal("received-at", string(props[1].K))
+	receivedAt, err := strconv.ParseInt(string(props[1].V), 10, 64)
+	a.NoError(err)
+	a.True(receivedAt >= before && receivedAt <= after)
+	a.Equal(packets.UserProperty{K: []byte("publisher"), V: []byte("cid")}, props[2])
+
+	// not matched
+	a.Equal([]packets.UserProperty{
+		{K: []byte("publisher"), V: []byte("spoofed")},
+		{K: []byte("k"), V: []byte("v")},
+	}, delivered[1].UserProperties)
+
+	// publisher identity
+	delivered = nil
+	for _, username := range []string{"user", ""} {
+		c.opts.Username = username
+		a.Nil(c.publishHandler(&packets.Publish{
+			Version:   packets.Version5,
+			TopicName: []byte("devices/a"),
+			Payload:   []byte("abc"),
+			Properties: &packets.Properties{
+				User: []packets.UserProperty{
+					{K: []byte("publisher-username"), V: []byte("spoofed")},
+				},
+			},
+		}))
+	}
+	a.Equal([]packets.UserProperty{
+		{K: []byte("publisher"), V: []byte("cid")},
+		{K: []byte("publisher-username"), V: []byte("user")},
+	}, delivered[0].UserProperties)
+	// the username is not stamped for the anonymous publisher.
+	a.Equal([]packets.UserProperty{
+		{K: []byte("publisher"), V: []byte("cid")},
+	}, delivered[1].UserProperties)
+}
+
+func TestClient_publishHandler_modifyMessage(t *testing.T) {
+	a := assert.New(t)
+	srv := defaultServer()
+	srv.hooks.OnMsgArrived = func(ctx context.Context, client Client, req *MsgArrivedRequest) error {
+		if req.Message.Topic == "invalid" {
+			req.Message.Topic = "a/+"
+			return nil
+		}
+		req.Message.Topic = "normalized/" + req.Message.Topic
+		req.Message.Payload = []byte("transformed")
+		req.Message.QoS = packets.Qos0
+		req.Message.Retained = true
+		req.Message.UserProperties = append(req.Message.UserProperties, packets.UserProperty{
+			K: []byte("k"),
+			V: []byte("v"),
+		})
+		return nil
+	}
+	c, er := srv.newClient(noopConn{})
+	a.NoError(er)
+	c.opts.ClientID = "cid"
+	c.opts.RetainAvailable = true
+	c.version = packets.Version5
+	var delivered []*gmqtt.Message
+	var topicNames []string
+	c.deliverMessage = func(srcClientID string, msg *gmqtt.Message, options subscription.IterationOptions) (matched bool) {
+		delivered = append(delivered, msg)
+		topicNames = append(topicNames, options.TopicName)
+		return true
+	}
+
+	a.Nil(c.publishHandler(&packets.Publish{
+		Version:    packets.Version5,
+		Qos:        packets.Qos1,
+		TopicName:  []byte("a"),
+		PacketID:   1,
+		Payload:    []byte("origin"),
+		Properties: &packets.Properties{},
+	}))
+	a.Equal(codes.Success, (<-c.out).(*packets.Puback).Code)
+	a.Len(delivered, 1)
+	msg := delivered[0]
+	a.Equal("normalized/a", msg.Topic)
+	a.Equal([]string{"normalized/a"}, topicNames)
+	a.Equal([]byte("transformed"), msg.Payload)
+	a.Equal(packets.Qos0, msg.QoS)
+	a.Equal([]packets.UserProperty{{K: []byte("k"), V: []byte("v")}}, msg.UserProperties)
+	a.Nil(srv.retainedDB.GetRetainedMessage("a"))
+	retained := srv.retainedDB.GetRetainedMessage("normalized/a")
+	a.NotNil(retained)
+	a.Equal([]byte("transformed"), retained.Payload)
+
+	// the message with invalid topic is rejected.
+	a.Nil(c.publishHandler(&packets.Publish{
+		Version:    packets.Version5,
+		Qos:        packets.Qos1,
+		TopicName:  []byte("invalid"),
+		PacketID:   2,
+		Payload:    []byte("origin"),
+		Properties: &packets.Properties{},
+	}))
+	a.Equal(codes.ImplementationSpecificError, (<-c.out).(*packets.Puback).Code)
+	a.Len(delivered, 1)
+}
+
 func TestClient_publishHandler_retainedMessage(t *testing.T) {
 	var tt = []struct {
 		name              string
@@ -975,6 +1462,7 @@ func TestClient_publishHandler_retainedMessage(t *testing.T) {
 			srv := &server{
 				config:     config.DefaultConfig(),
 				retainedDB: retainedDB,
+				registry:   newRegistry(),
 			}
 
 			c, er := srv.newClient(noopConn{})
@@ -988,8 +1476,7 @@ func TestClient_publishHandler_retainedMessage(t *testing.T) {
 			c.unackStore = unack_mem.New(unack_mem.Options{
 				ClientID: v.clientID,
 			})
-			srv.unackStore = make(map[string]unack.Store)
-			srv.unackStore[v.clientID] = c.unackStore
+			srv.registry.shard(v.clientID).unackStore[v.clientID] = c.unackStore
 			c.opts.ClientID = v.clientID
 			c.version = v.version
 			c.opts.RetainAvailable = v.retainedAvailable
@@ -1183,8 +1670,7 @@ func TestClient_pubrelHandler(t *testing.T) {
 	c.opts.ClientID = "cid"
 	ua := unack.NewMockStore(ctrl)
 	c.unackStore = ua
-	srv.unackStore = make(map[string]unack.Store)
-	srv.unackStore[c.opts.ClientID] = ua
+	srv.registry.shard(c.opts.ClientID).unackStore[c.opts.ClientID] = ua
 
 	ua.EXPECT().Remove(packets.PacketID(1))
 
@@ -1220,8 +1706,7 @@ func TestClient_pubrecHandler_ErrorV5(t *testing.T) {
 	c.newPacketIDLimiter(c.opts.MaxInflight)
 	qs := queue.NewMockStore(ctrl)
 	c.queueStore = qs
-	srv.queueStore = make(map[string]queue.Store)
-	srv.queueStore[c.opts.ClientID] = qs
+	srv.registry.shard(c.opts.ClientID).queueStore[c.opts.ClientID] = qs
 	pubrec := &packets.Pubrec{
 		PacketID:   1,
 		Code:       codes.UnspecifiedError,
@@ -1250,8 +1735,7 @@ func TestClient_pubrecHandler(t *testing.T) {
 	c.newPacketIDLimiter(c.opts.MaxInflight)
 	qs := queue.NewMockStore(ctrl)
 	c.queueStore = qs
-	srv.queueStore = make(map[string]queue.Store)
-	srv.queueStore[c.opts.ClientID] = qs
+	srv.registry.shard(c.opts.ClientID).queueStore[c.opts.ClientID] = qs
 	pubrec := &packets.Pubrec{
 		PacketID:   1,
 		Code:       codes.Success,
@@ -1285,8 +1769,7 @@ func TestClient_pubcompHandler(t *testing.T) {
 	c.newPacketIDLimiter(c.opts.MaxInflight)
 	qs := queue.NewMockStore(ctrl)
 	c.queueStore = qs
-	srv.queueStore = make(map[string]queue.Store)
-	srv.queueStore[c.opts.ClientID] = qs
+	srv.registry.shard(c.opts.ClientID).queueStore[c.opts.ClientID] = qs
 	pubcomp := &packets.Pubcomp{
 		PacketID:   1,
 		Code:       codes.Success,
@@ -1489,6 +1972,216 @@ func TestClient_defaultAuthOptions(t *testing.T) {
 
 }
 
+func TestClient_sessionExpiryClass(t *testing.T) {
+	a := assert.New(t)
+	srv := defaultServer()
+	srv.config.MQTT.SessionExpiry = 20 * time.Second
+	srv.config.MQTT.SessionExpiryClasses = []config.SessionExpiryClass{
+		{Name: "sensor", Username: "sensor-*", Max: 10 * time.Second, Default: 5 * time.Second},
+		{Name: "backend", Max: 100 * time.Second},
+	}
+	c, _ := srv.newClient(noopConn{})
+	c.version = packets.Version5
+	newConnect := func(username string, expiry uint32) *packets.Connect {
+		return &packets.Connect{
+			Version:  packets.Version5,
+			ClientID: []byte("cid"),
+			Username: []byte(username),
+			Properties: &packets.Properties{
+				SessionExpiryInterval: proto.Uint32(expiry),
+			},
+		}
+	}
+
+	// the class matched by the username.
+	conn := newConnect("sensor-1", 50)
+	opts := c.defaultAuthOptions(conn)
+	a.Equal("sensor", opts.SessionExpiryClass)
+	a.EqualValues(10, opts.SessionExpiry)
+	c.applySessionExpiryClass(conn, opts)
+	a.EqualValues(10, opts.SessionExpiry)
+	a.EqualValues(10, c.maxSessionExpiry)
+
+	// the class selected by the hooks.
+	conn = newConnect("sensor-1", 50)
+	opts = c.defaultAuthOptions(conn)
+	opts.SessionExpiryClass = "backend"
+	c.applySessionExpiryClass(conn, opts)
+	a.EqualValues(50, opts.SessionExpiry)
+	a.EqualValues(100, c.maxSessionExpiry)
+
+	// no class, use the global setting.
+	conn = newConnect("user", 50)
+	opts = c.defaultAuthOptions(conn)
+	a.Empty(opts.SessionExpiryClass)
+	c.applySessionExpiryClass(conn, opts)
+	a.EqualValues(20, opts.SessionExpiry)
+	a.EqualValues(20, c.maxSessionExpiry)
+
+	// the class is removed by the hooks.
+	conn = newConnect("sensor-1", 50)
+	opts = c.defaultAuthOptions(conn)
+	opts.SessionExpiryClass = ""
+	c.applySessionExpiryClass(conn, opts)
+	a.EqualValues(20, opts.SessionExpiry)
+
+	// the V3 client gets the default of the class.
+	c.version = packets.Version311
+	conn = &packets.Connect{
+		Version:  packets.Version311,
+		ClientID: []byte("cid"),
+		Username: []byte("sensor-1"),
+	}
+	opts = c.defaultAuthOptions(conn)
+	a.EqualValues(5, opts.SessionExpiry)
+	opts.SessionExpiryClass = "backend"
+	c.applySessionExpiryClass(conn, opts)
+	a.EqualValues(100, opts.SessionExpiry)
+
+	// the session expiry interval in the DISCONNECT packet is capped by the maximum.
+	c.version = packets.Version5
+	c.opts.ClientID = "cid"
+	c.maxSessionExpiry = 10
+	srv.sessionStore = sessmem.New()
+	a.NoError(srv.sessionStore.Set(&gmqtt.Session{ClientID: "cid", ExpiryInterval: 5}))
+	a.Nil(c.disconnectHandler(&packets.Disconnect{
+		Version: packets.Version5,
+		Properties: &packets.Properties{
+			SessionExpiryInterval: proto.Uint32(50),
+		},
+	}))
+	sess, err := srv.sessionStore.Get("cid")
+	a.NoError(err)
+	a.EqualValues(10, sess.ExpiryInterval)
+}
+
+func TestClient_disconnectHandler_will(t *testing.T) {
+	a := assert.New(t)
+	srv := defaultServer()
+	srv.sessionStore = sessmem.New()
+	c, err := srv.newClient(noopConn{})
+	a.Nil(err)
+	c.version = packets.Version5
+	c.opts.ClientID = "cid"
+	a.NoError(srv.sessionStore.Set(&gmqtt.Session{ClientID: "cid"}))
+	a.Nil(c.disconnectHandler(&packets.Disconnect{
+		Version:    packets.Version5,
+		Code:       codes.DisconnectWithWillMessage,
+		Properties: &packets.Properties{},
+	}))
+	a.False(c.cleanWillFlag)
+	a.Nil(c.disconnectHandler(&packets.Disconnect{
+		Version:    packets.Version5,
+		Properties: &packets.Properties{},
+	}))
+	a.True(c.cleanWillFlag)
+}
+
+func TestClient_readHandle_connectTwice(t *testing.T) {
+	a := assert.New(t)
+	srv := defaultServer()
+	c, err := srv.newClient(noopConn{})
+	a.Nil(err)
+	c.version = packets.Version311
+	c.in <- &inboundPacket{ctx: context.Background(), packet: &packets.Connect{Version: packets.Version311}}
+	c.in <- &inboundPacket{ctx: context.Background(), packet: &packets.Pingreq{}}
+	close(c.in)
+	c.readHandle()
+	// the second CONNECT is a protocol violation [MQTT-3.1.0-2], the following packets are not processed.
+	a.Equal(codes.ErrProtocol, c.err)
+	a.Empty(c.out)
+}
+
+func TestClient_readHandle_disconnectError(t *testing.T) {
+	a := assert.New(t)
+	srv := defaultServer()
+	srv.sessionStore = sessmem.New()
+	c, err := srv.newClient(noopConn{})
+	a.Nil(err)
+	c.version = packets.Version5
+	c.opts.ClientID = "cid"
+	a.NoError(srv.sessionStore.Set(&gmqtt.Session{ClientID: "cid"}))
+	expiry := uint32(10)
+	c.in <- &inboundPacket{
+		ctx: context.Background(),
+		packet: &packets.Disconnect{
+			Version: packets.Version5,
+			Properties: &packets.Properties{
+				SessionExpiryInterval: &expiry,
+			},
+		},
+	}
+	close(c.in)
+	c.readHandle()
+	// the session expiry interval can not be changed from 0 to non-zero [MQTT-3.14.2-2].
+	a.Equal(codes.ProtocolError, c.err.(*codes.Error).Code)
+}
+
+func TestClient_writeLoop_closeConn(t *testing.T) {
+	a := assert.New(t)
+	srv := defaultServer()
+	srv.statsManager = newStatsManager(mem.NewStore())
+	conn, peer := net.Pipe()
+	defer peer.Close()
+	c, err := srv.newClient(conn)
+	a.NoError(err)
+	c.opts.ClientID = "cid"
+	c.version = packets.Version311
+	c.out <- &packets.Connack{Version: packets.Version311, Code: codes.V3NotAuthorized}
+	c.setError(codes.NewError(codes.NotAuthorized))
+	go c.writeLoop()
+
+	// the pending CONNACK is sent before the network connection is closed.
+	_ = peer.SetReadDeadline(time.Now().Add(time.Second))
+	r := packets.NewReader(peer)
+	p, err := r.ReadPacket()
+	a.NoError(err)
+	a.EqualValues(codes.V3NotAuthorized, p.(*packets.Connack).Code)
+	_, err = r.ReadPacket()
+	a.Equal(io.EOF, err)
+	a.False(c.isServerClosed())
+}
+
+func TestClient_readHandle_reAuth(t *testing.T) {
+	a := assert.New(t)
+	srv := defaultServer()
+	var reAuth int
+	srv.hooks.OnReAuth = func(ctx context.Context, client Client, auth *packets.Auth) (*AuthResponse, error) {
+		reAuth++
+		return &AuthResponse{AuthData: []byte("resp")}, nil
+	}
+	newAuthClient := func(method string) *client {
+		c, err := srv.newClient(noopConn{})
+		a.Nil(err)
+		c.version = packets.Version5
+		c.opts.AuthMethod = []byte("m")
+		c.in <- &inboundPacket{
+			ctx: context.Background(),
+			packet: &packets.Auth{
+				Code: codes.ReAuthenticate,
+				Properties: &packets.Properties{
+					AuthMethod: []byte(method),
+					AuthData:   []byte("data"),
+				},
+			},
+		}
+		close(c.in)
+		c.readHandle()
+		return c
+	}
+
+	c := newAuthClient("m")
+	a.Nil(c.err)
+	a.Equal(1, reAuth)
+	auth := (<-c.out).(*packets.Auth)
+	a.Equal([]byte("m"), auth.Properties.AuthMethod)
+	a.Equal([]byte("resp"), auth.Properties.AuthData)
+
+	c = newAuthClient("other")
+	a.Equal(codes.ErrProtocol, c.err)
+	a.Equal(1, reAuth)
+}
+
 func TestClient_connectWithTimeOut_BasicAuth(t *testing.T) {
 	var tt = []struct {
 		name           string
@@ -1573,7 +2266,7 @@ func TestClient_connectWithTimeOut_BasicAuth(t *testing.T) {
 			},
 			ok: false,
 			assertConnack: func(a *assert.Assertions, ack *packets.Connack) {
-				a.Equal(codes.NotAuthorized, ack.Code)
+				a.EqualValues(codes.V3ServerUnavaliable, ack.Code)
 			},
 		},
 		{
@@ -1592,7 +2285,7 @@ func TestClient_connectWithTimeOut_BasicAuth(t *testing.T) {
 			},
 			ok: false,
 			assertConnack: func(a *assert.Assertions, ack *packets.Connack) {
-				a.Equal(codes.NotAuthorized, ack.Code)
+				a.EqualValues(codes.V3NotAuthorized, ack.Code)
 			},
 		},
 		{
@@ -1652,7 +2345,7 @@ func TestClient_connectWithTimeOut_BasicAuth(t *testing.T) {
 
 			srv := defaultServer()
 			c, _ := srv.newClient(noopConn{})
-			c.in <- v.connect
+			c.in <- &inboundPacket{ctx: context.Background(), packet: v.connect}
 			c.register = v.register
 			c.server.hooks.OnBasicAuth = v.basicAuth
 			ok := c.connectWithTimeOut()
@@ -1691,6 +2384,47 @@ func TestClient_connectWithTimeOut_Timeout(t *testing.T) {
 	a.Equal(ErrConnectTimeOut, c.err)
 }
 
+func TestClient_connectWithTimeOut_firstPacket(t *testing.T) {
+	a := assert.New(t)
+	srv := defaultServer()
+	c, _ := srv.newClient(noopConn{})
+	c.in <- &inboundPacket{ctx: context.Background(), packet: &packets.Pingreq{}}
+
+	ok := c.connectWithTimeOut()
+	a.False(ok)
+	// the connection is closed without CONNACK [MQTT-3.1.0-1].
+	select {
+	case p := <-c.out:
+		a.FailNow("unexpected send: %v", p)
+	default:
+	}
+	a.Equal(codes.MalformedPacket, c.err.(*codes.Error).Code)
+}
+
+func TestClient_readPacket_refuseConnect(t *testing.T) {
+	a := assert.New(t)
+	srv := defaultServer()
+	srv.statsManager = newStatsManager(mem.NewStore())
+	c, _ := srv.newClient(noopConn{})
+	var buf bytes.Buffer
+	w := packets.NewWriter(&buf)
+	a.NoError(w.WritePacket(&packets.Connect{
+		Version:       packets.Version311,
+		ProtocolName:  []byte("MQTT"),
+		ProtocolLevel: 0x06,
+		CleanStart:    true,
+		ClientID:      []byte("cid"),
+	}))
+	a.NoError(w.Flush())
+	c.packetReader = packets.NewReader(&buf)
+
+	a.Error(c.readPacket())
+	// the unacceptable protocol level is refused with CONNACK [MQTT-3.1.2-2].
+	ack := (<-c.out).(*packets.Connack)
+	a.Equal(packets.Version311, ack.Version)
+	a.EqualValues(codes.V3UnacceptableProtocolVersion, ack.Code)
+}
+
 func TestClient_connectWithTimeOut_EnhancedAuth(t *testing.T) {
 	authMethod := []byte("authMethod")
 	authData := []byte("authData")
@@ -1832,9 +2566,9 @@ func TestClient_connectWithTimeOut_EnhancedAuth(t *testing.T) {
 
 			srv := defaultServer()
 			c, _ := srv.newClient(noopConn{})
-			c.in <- connect
+			c.in <- &inboundPacket{ctx: context.Background(), packet: connect}
 			for _, v := range v.auth {
-				c.in <- v
+				c.in <- &inboundPacket{ctx: context.Background(), packet: v}
 			}
 			c.register = func(connect *packets.Connect, client *client) (sessionResume bool, err error) {
 				return false, nil
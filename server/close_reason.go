@@ -0,0 +1,181 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/DrmagicE/gmqtt/pkg/codes"
+	"github.com/DrmagicE/gmqtt/pkg/packets"
+)
+
+// CloseReasonType is the type of the reason why the client connection is closed.
+type CloseReasonType string
+
+const (
+	// CloseClientDisconnect means the client sent a DISCONNECT packet.
+	CloseClientDisconnect CloseReasonType = "client_disconnect"
+	// CloseConnectionLost means the connection is closed by the client without a DISCONNECT packet or a read error occurs.
+	CloseConnectionLost CloseReasonType = "connection_lost"
+	// CloseKeepAliveTimeout means no packet has been received from the client within the keepalive time.
+	CloseKeepAliveTimeout CloseReasonType = "keepalive_timeout"
+	// CloseConnectTimeout means the client does not complete the CONNECT handshake in time.
+	CloseConnectTimeout CloseReasonType = "connect_timeout"
+	// CloseTakenOver means another client connected with the same client id.
+	CloseTakenOver CloseReasonType = "taken_over"
+	// CloseProtocolError means the client violates the protocol or the packet is rejected by the server.
+	CloseProtocolError CloseReasonType = "protocol_error"
+	// CloseWriteError means an error occurs when writing packets to the client.
+	CloseWriteError CloseReasonType = "write_error"
+	// CloseServerClosed means the connection is closed by the server, e.g. the server is stopping or the client is kicked by the admin API.
+	CloseServerClosed CloseReasonType = "server_closed"
+	// CloseRedeliveryExhausted means the inflight message is not acknowledged after the maximum redelivery attempts,
+	// see the exhausted_action of the redelivery policy.
+	CloseRedeliveryExhausted CloseReasonType = "redelivery_exhausted"
+	// CloseWriteBufferExceeded means the client is too slow to consume the messages,
+	// the write buffer stays above the hard limit for longer than the hard_limit_timeout.
+	CloseWriteBufferExceeded CloseReasonType = "write_buffer_exceeded"
+	// CloseProbeTimeout means the connection is detected dead by the liveness probing of the listener,
+	// i.e. the keepalive probes or the written data are not acknowledged by the peer in time.
+	CloseProbeTimeout CloseReasonType = "probe_timeout"
+	// CloseListenerDrained means the client is disconnected by the migration of the draining listener, see ListenerService.Drain.
+	CloseListenerDrained CloseReasonType = "listener_drained"
+	// CloseInternalError means the connection is closed by other errors, e.g. the errors returned by the hooks.
+	CloseInternalError CloseReasonType = "internal_error"
+)
+
+// CloseReason describes why the client connection is closed.
+type CloseReason struct {
+	Type CloseReasonType
+	// Code is the reason code of the DISCONNECT packet sent by the client for CloseClientDisconnect,
+	// or the reason code of the error for CloseTakenOver, CloseProtocolError and CloseWriteBufferExceeded.
+	Code codes.Code
+	// PacketType is the type of the packet which causes the connection to be closed, e.g. "PUBLISH".
+	// It is empty if the connection is not closed by a packet.
+	PacketType string
+	// Err is the error which causes the connection to be closed, nil if the client disconnects normally.
+	Err error
+}
+
+func (r *CloseReason) String() string {
+	s := string(r.Type)
+	if r.Code != 0 {
+		s += fmt.Sprintf(", code: %#x", r.Code)
+	}
+	if r.PacketType != "" {
+		s += ", packet: " + r.PacketType
+	}
+	if r.Err != nil {
+		s += ", error: " + r.Err.Error()
+	}
+	return s
+}
+
+// packetError wraps the error with the packet which causes the error.
+type packetError struct {
+	err    error
+	packet packets.Packet
+}
+
+func (p *packetError) Error() string {
+	return p.err.Error()
+}
+
+// writeError wraps the error which occurs when writing packets to the client.
+type writeError struct {
+	err error
+}
+
+func (w *writeError) Error() string {
+	return w.err.Error()
+}
+
+func packetType(packet packets.Packet) string {
+	return strings.ToUpper(reflect.TypeOf(packet).Elem().Name())
+}
+
+// unwrapCloseError returns the error wrapped by packetError and writeError.
+func unwrapCloseError(err error) error {
+	switch e := err.(type) {
+	case *packetError:
+		return e.err
+	case *writeError:
+		return e.err
+	}
+	return err
+}
+
+// newCloseReason creates the CloseReason for the error which closes the connection.
+// It must be called after all goroutines of the client have exited.
+func (client *client) newCloseReason(err error) *CloseReason {
+	r := &CloseReason{
+		Err: unwrapCloseError(err),
+	}
+	switch e := err.(type) {
+	case *packetError:
+		r.PacketType = packetType(e.packet)
+	case *writeError:
+		r.Type = CloseWriteError
+		return r
+	}
+	_, isNetErr := r.Err.(net.Error)
+	// the connection may be closed by the client before the DISCONNECT packet is handled.
+	if client.disconnect != nil && (r.Err == nil || r.Err == io.EOF || isNetErr) {
+		r.Type = CloseClientDisconnect
+		r.Code = client.disconnect.Code
+		r.PacketType = packetType(client.disconnect)
+		r.Err = nil
+		return r
+	}
+	if atomic.LoadInt32(&client.migrated) == 1 && (r.Err == nil || client.isServerClosed()) {
+		r.Type = CloseListenerDrained
+		r.Err = nil
+		return r
+	}
+	switch e := r.Err.(type) {
+	case nil:
+		r.Type = CloseServerClosed
+	case *codes.Error:
+		r.Code = e.Code
+		if e.Code == codes.SessionTakenOver {
+			r.Type = CloseTakenOver
+		} else {
+			r.Type = CloseProtocolError
+		}
+	case net.Error:
+		if client.isServerClosed() {
+			r.Type = CloseServerClosed
+		} else if errors.Is(e, syscall.ETIMEDOUT) {
+			r.Type = CloseProbeTimeout
+		} else if e.Timeout() {
+			r.Type = CloseKeepAliveTimeout
+		} else {
+			r.Type = CloseConnectionLost
+		}
+	default:
+		switch r.Err {
+		case io.EOF:
+			r.Type = CloseConnectionLost
+			if client.isServerClosed() {
+				r.Type = CloseServerClosed
+			}
+		case errKeepAliveTimeout:
+			r.Type = CloseKeepAliveTimeout
+		case ErrConnectTimeOut:
+			r.Type = CloseConnectTimeout
+		case errRedeliveryExhausted:
+			r.Type = CloseRedeliveryExhausted
+		case errWriteBufferExceeded:
+			r.Type = CloseWriteBufferExceeded
+			r.Code = codes.MessageRateTooHigh
+		default:
+			r.Type = CloseInternalError
+		}
+	}
+	return r
+}
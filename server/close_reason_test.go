@@ -0,0 +1,142 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DrmagicE/gmqtt/pkg/codes"
+	"github.com/DrmagicE/gmqtt/pkg/packets"
+)
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestClient_newCloseReason(t *testing.T) {
+	a := assert.New(t)
+	errHook := errors.New("hook error")
+	probeErr := &net.OpError{Op: "read", Net: "tcp", Err: os.NewSyscallError("read", syscall.ETIMEDOUT)}
+	var tt = []struct {
+		name         string
+		err          error
+		disconnect   *packets.Disconnect
+		serverClosed bool
+		expected     CloseReason
+	}{
+		{
+			name:       "client_disconnect",
+			disconnect: &packets.Disconnect{Code: codes.DisconnectWithWillMessage},
+			expected: CloseReason{
+				Type:       CloseClientDisconnect,
+				Code:       codes.DisconnectWithWillMessage,
+				PacketType: "DISCONNECT",
+			},
+		},
+		{
+			name:       "client_disconnect_eof",
+			err:        io.EOF,
+			disconnect: &packets.Disconnect{},
+			expected: CloseReason{
+				Type:       CloseClientDisconnect,
+				PacketType: "DISCONNECT",
+			},
+		},
+		{
+			name:     "connection_lost",
+			err:      io.EOF,
+			expected: CloseReason{Type: CloseConnectionLost, Err: io.EOF},
+		},
+		{
+			name:     "keepalive_timeout",
+			err:      timeoutError{},
+			expected: CloseReason{Type: CloseKeepAliveTimeout, Err: timeoutError{}},
+		},
+		{
+			name:     "probe_timeout",
+			err:      probeErr,
+			expected: CloseReason{Type: CloseProbeTimeout, Err: probeErr},
+		},
+		{
+			name:     "connect_timeout",
+			err:      ErrConnectTimeOut,
+			expected: CloseReason{Type: CloseConnectTimeout, Err: ErrConnectTimeOut},
+		},
+		{
+			name: "taken_over",
+			err:  codes.NewError(codes.SessionTakenOver),
+			expected: CloseReason{
+				Type: CloseTakenOver,
+				Code: codes.SessionTakenOver,
+				Err:  codes.NewError(codes.SessionTakenOver),
+			},
+		},
+		{
+			name: "protocol_error",
+			err:  &packetError{err: codes.ErrProtocol, packet: &packets.Auth{}},
+			expected: CloseReason{
+				Type:       CloseProtocolError,
+				Code:       codes.ProtocolError,
+				PacketType: "AUTH",
+				Err:        codes.ErrProtocol,
+			},
+		},
+		{
+			name:     "write_error",
+			err:      &writeError{err: io.ErrClosedPipe},
+			expected: CloseReason{Type: CloseWriteError, Err: io.ErrClosedPipe},
+		},
+		{
+			name: "write_buffer_exceeded",
+			err:  errWriteBufferExceeded,
+			expected: CloseReason{
+				Type: CloseWriteBufferExceeded,
+				Code: codes.MessageRateTooHigh,
+				Err:  errWriteBufferExceeded,
+			},
+		},
+		{
+			name:         "server_closed",
+			err:          io.EOF,
+			serverClosed: true,
+			expected:     CloseReason{Type: CloseServerClosed, Err: io.EOF},
+		},
+		{
+			name: "internal_error",
+			err:  &packetError{err: errHook, packet: &packets.Publish{}},
+			expected: CloseReason{
+				Type:       CloseInternalError,
+				PacketType: "PUBLISH",
+				Err:        errHook,
+			},
+		},
+	}
+	for _, v := range tt {
+		t.Run(v.name, func(t *testing.T) {
+			c := &client{disconnect: v.disconnect}
+			if v.serverClosed {
+				c.serverClosed = 1
+			}
+			a.Equal(&v.expected, c.newCloseReason(v.err))
+		})
+	}
+}
+
+func TestCloseReason_String(t *testing.T) {
+	a := assert.New(t)
+	r := &CloseReason{
+		Type:       CloseProtocolError,
+		Code:       codes.ProtocolError,
+		PacketType: "PUBLISH",
+		Err:        codes.ErrProtocol,
+	}
+	a.Equal("protocol_error, code: 0x82, packet: PUBLISH, error: "+codes.ErrProtocol.Error(), r.String())
+	a.Equal("server_closed", (&CloseReason{Type: CloseServerClosed}).String())
+}
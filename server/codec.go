@@ -0,0 +1,83 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+)
+
+// handshakeTimeout is the timeout of ConnectionCodec.Handshake.
+const handshakeTimeout = 5 * time.Second
+
+// ConnectionCodec handles the proprietary framing or header of the connections before the MQTT packets are parsed,
+// e.g. the prefix bytes added by a gateway or a custom handshake of a device fleet.
+// It is registered by RegisterConnectionCodec and enabled by the codec setting of the TCP listeners.
+type ConnectionCodec interface {
+	// Handshake is called with the accepted connection before reading the CONNECT packet.
+	// It can read and write the proprietary preface, and returns the connection to read and write the MQTT packets,
+	// which can be conn itself, or a net.Conn wrapping conn to decode and encode the proprietary framing.
+	// The wrapped connection must keep the bytes that are read from conn but not consumed by the codec,
+	// and should implement Unwrap() net.Conn to expose conn, see TLSConnectionState.
+	// The returned connection is also returned by Client.Connection, so that the hooks (e.g. OnBasicAuth) can
+	// retrieve the information of the preface from it.
+	// The connection is closed if it returns an error. The ctx is cancelled after 5 seconds,
+	// and the deadline of conn is set accordingly.
+	Handshake(ctx context.Context, conn net.Conn) (net.Conn, error)
+}
+
+var connectionCodecs = make(map[string]ConnectionCodec)
+
+// RegisterConnectionCodec registers a ConnectionCodec with the name, which can be used in the codec setting of the listeners.
+// It is not thread-safe and should be called in init function.
+func RegisterConnectionCodec(name string, codec ConnectionCodec) {
+	if _, ok := connectionCodecs[name]; ok {
+		panic("duplicated connection codec: " + name)
+	}
+	connectionCodecs[name] = codec
+}
+
+// getConnectionCodec returns the codec of the name, nil if the name is empty.
+func getConnectionCodec(name string) (ConnectionCodec, error) {
+	if name == "" {
+		return nil, nil
+	}
+	codec, ok := connectionCodecs[name]
+	if !ok {
+		return nil, fmt.Errorf("connection codec not found: %s", name)
+	}
+	return codec, nil
+}
+
+// unwrapConn returns the innermost connection of the connection wrapped by the codecs.
+func unwrapConn(conn net.Conn) net.Conn {
+	for {
+		u, ok := conn.(interface{ Unwrap() net.Conn })
+		if !ok {
+			return conn
+		}
+		conn = u.Unwrap()
+	}
+}
+
+// handshake calls the Handshake of the codec, and replaces the connection with the returned one.
+func (client *client) handshake() error {
+	deadline := time.Now().Add(handshakeTimeout)
+	ctx, cancel := context.WithDeadline(client.baseContext(), deadline)
+	defer cancel()
+	conn := client.rwc
+	if err := conn.SetDeadline(deadline); err != nil {
+		return err
+	}
+	rwc, err := client.codec.Handshake(ctx, conn)
+	if err != nil {
+		return err
+	}
+	if err = conn.SetDeadline(time.Time{}); err != nil {
+		return err
+	}
+	client.rwc = rwc
+	client.bufr.Reset(rwc)
+	client.bufw.Reset(rwc)
+	return nil
+}
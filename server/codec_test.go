@@ -0,0 +1,136 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DrmagicE/gmqtt/config"
+	"github.com/DrmagicE/gmqtt/pkg/packets"
+)
+
+// gatewayConn carries the device id in the gateway prefix.
+type gatewayConn struct {
+	net.Conn
+	r      *bufio.Reader
+	device string
+}
+
+func (g *gatewayConn) Read(b []byte) (int, error) {
+	return g.r.Read(b)
+}
+
+func (g *gatewayConn) Unwrap() net.Conn {
+	return g.Conn
+}
+
+// gatewayCodec reads the "GW <device id>\n" prefix and replies "OK\n".
+type gatewayCodec struct{}
+
+func (gatewayCodec) Handshake(ctx context.Context, conn net.Conn) (net.Conn, error) {
+	r := bufio.NewReader(conn)
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "GW ") {
+		return nil, errors.New("invalid prefix")
+	}
+	if _, err = conn.Write([]byte("OK\n")); err != nil {
+		return nil, err
+	}
+	return &gatewayConn{Conn: conn, r: r, device: strings.TrimSpace(line[3:])}, nil
+}
+
+func TestRegisterConnectionCodec(t *testing.T) {
+	a := assert.New(t)
+	RegisterConnectionCodec("test_gateway", gatewayCodec{})
+	defer delete(connectionCodecs, "test_gateway")
+	a.Panics(func() {
+		RegisterConnectionCodec("test_gateway", gatewayCodec{})
+	})
+	codec, err := getConnectionCodec("test_gateway")
+	a.NoError(err)
+	a.Equal(gatewayCodec{}, codec)
+	codec, err = getConnectionCodec("")
+	a.NoError(err)
+	a.Nil(codec)
+	_, err = getConnectionCodec("unknown")
+	a.Error(err)
+
+	_, _, err = NewListenerFromConfig(config.ListenerConfig{Address: "127.0.0.1:0", Codec: "unknown"}, config.Crypto{})
+	a.Error(err)
+	_, _, err = NewListenerFromConfig(config.ListenerConfig{
+		Address:   "127.0.0.1:0",
+		Codec:     "test_gateway",
+		Websocket: &config.WebsocketOptions{Path: "/"},
+	}, config.Crypto{})
+	a.Error(err)
+	ln, _, err := NewListenerFromConfig(config.ListenerConfig{Address: "127.0.0.1:0", Codec: "test_gateway"}, config.Crypto{})
+	a.NoError(err)
+	defer ln.Close()
+	a.Equal("test_gateway", ln.(*listener).opts.Codec)
+	a.Equal("test_gateway", newRunningTCPListener(NewListener(ln, ln.(*listener).opts)).config.Codec)
+}
+
+func TestClient_handshake(t *testing.T) {
+	a := assert.New(t)
+	srv := &server{config: config.DefaultConfig()}
+	conn, peer := net.Pipe()
+	defer peer.Close()
+	c, err := srv.newClient(conn)
+	a.NoError(err)
+	c.codec = gatewayCodec{}
+
+	connect := &packets.Connect{
+		Version:       packets.Version311,
+		ProtocolName:  []byte("MQTT"),
+		ProtocolLevel: packets.Version311,
+		CleanStart:    true,
+		ClientID:      []byte("cid"),
+	}
+	buf := &bytes.Buffer{}
+	a.NoError(connect.Pack(buf))
+	go func() {
+		peer.Write(append([]byte("GW dev1\n"), buf.Bytes()...))
+	}()
+	reply := make(chan []byte, 1)
+	go func() {
+		b := make([]byte, 3)
+		n, _ := peer.Read(b)
+		reply <- b[:n]
+	}()
+	a.NoError(c.handshake())
+	a.Equal([]byte("OK\n"), <-reply)
+	a.Equal("dev1", c.Connection().(*gatewayConn).device)
+
+	// the MQTT packets are read from the wrapped connection.
+	p, err := c.packetReader.ReadPacket()
+	a.NoError(err)
+	a.Equal([]byte("cid"), p.(*packets.Connect).ClientID)
+
+	conn, peer = net.Pipe()
+	defer peer.Close()
+	c, err = srv.newClient(conn)
+	a.NoError(err)
+	c.codec = gatewayCodec{}
+	go func() {
+		peer.Write([]byte("XX\n"))
+	}()
+	a.Error(c.handshake())
+}
+
+func TestTLSConnectionState_unwrap(t *testing.T) {
+	a := assert.New(t)
+	_, ok := TLSConnectionState(&gatewayConn{Conn: noopConn{}})
+	a.False(ok)
+	_, ok = TLSConnectionState(&gatewayConn{Conn: tls.Server(noopConn{}, &tls.Config{})})
+	a.True(ok)
+}
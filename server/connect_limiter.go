@@ -0,0 +1,120 @@
+package server
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/DrmagicE/gmqtt/config"
+	"github.com/DrmagicE/gmqtt/pkg/codes"
+)
+
+// connectLimiter limits the concurrent handshakes and the rate of the CONNECT packets, see config.Handshake.
+// A nil limiter means there is no limit.
+type connectLimiter struct {
+	config config.Handshake
+	sts    *statsManager
+	// slots is the semaphore of the concurrent handshakes, nil if it is unlimited.
+	slots chan struct{}
+	mu    sync.Mutex
+	// rate is the token bucket of the CONNECT packets, nil if it is unlimited.
+	rate *tokenBucket
+}
+
+func newConnectLimiter(cfg config.Handshake, sts *statsManager) *connectLimiter {
+	if cfg.MaxConcurrent == 0 && cfg.ConnectRate == 0 {
+		return nil
+	}
+	l := &connectLimiter{
+		config: cfg,
+		sts:    sts,
+		rate:   newTokenBucket(cfg.ConnectRate, cfg.ConnectBurst),
+	}
+	if cfg.MaxConcurrent > 0 {
+		l.slots = make(chan struct{}, cfg.MaxConcurrent)
+	}
+	return l
+}
+
+// acquire waits for a handshake slot and the rate limit for up to the queue timeout.
+// It returns nil if the CONNECT packet can be processed, and release must be called after the handshake.
+// Otherwise it returns the error to refuse the client with.
+func (l *connectLimiter) acquire(done <-chan struct{}) error {
+	if l == nil {
+		return nil
+	}
+	deadline := time.Now().Add(l.config.QueueTimeout)
+	if l.slots != nil && !l.acquireSlot(done, l.config.QueueTimeout) {
+		return l.rejected(codes.ServerBusy)
+	}
+	if l.rate != nil {
+		now := time.Now()
+		l.mu.Lock()
+		d := l.rate.reserve(1, now)
+		if d > 0 && d > deadline.Sub(now) {
+			// give the token back, the CONNECT packet is not processed.
+			l.rate.tokens++
+			d = -1
+		}
+		l.mu.Unlock()
+		if d < 0 || (d > 0 && !sleepUntilDone(done, d)) {
+			l.releaseSlot()
+			return l.rejected(codes.ConnectionRateExceeded)
+		}
+	}
+	return nil
+}
+
+// acquireSlot waits for a handshake slot for up to d.
+func (l *connectLimiter) acquireSlot(done <-chan struct{}, d time.Duration) bool {
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	default:
+	}
+	if d <= 0 {
+		return false
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	case <-t.C:
+	case <-done:
+	}
+	return false
+}
+
+func (l *connectLimiter) rejected(code codes.Code) error {
+	atomic.AddUint64(&l.sts.totalStats.ConnectionStats.HandshakeRejectedTotal, 1)
+	return &codes.Error{
+		Code: code,
+	}
+}
+
+func (l *connectLimiter) releaseSlot() {
+	if l.slots != nil {
+		<-l.slots
+	}
+}
+
+// release releases the handshake slot acquired by acquire.
+func (l *connectLimiter) release() {
+	if l == nil {
+		return
+	}
+	l.releaseSlot()
+}
+
+// sleepUntilDone returns false if done is closed before d elapses.
+func sleepUntilDone(done <-chan struct{}, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-done:
+		return false
+	}
+}
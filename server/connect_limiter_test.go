@@ -0,0 +1,101 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DrmagicE/gmqtt/config"
+	"github.com/DrmagicE/gmqtt/persistence/subscription/mem"
+	"github.com/DrmagicE/gmqtt/pkg/codes"
+	"github.com/DrmagicE/gmqtt/pkg/packets"
+)
+
+func TestConnectLimiter_concurrent(t *testing.T) {
+	a := assert.New(t)
+	sts := newStatsManager(mem.NewStore())
+	done := make(chan struct{})
+	a.Nil(newConnectLimiter(config.DefaultHandshake, sts))
+	var l *connectLimiter
+	a.NoError(l.acquire(done))
+	l.release()
+
+	cfg := config.DefaultHandshake
+	cfg.MaxConcurrent = 1
+	l = newConnectLimiter(cfg, sts)
+	a.NoError(l.acquire(done))
+	err := l.acquire(done)
+	a.Equal(codes.ServerBusy, err.(*codes.Error).Code)
+	a.EqualValues(1, sts.GetGlobalStats().ConnectionStats.HandshakeRejectedTotal)
+	l.release()
+	a.NoError(l.acquire(done))
+
+	// the CONNECT packet waits in the queue until the slot is released.
+	l.config.QueueTimeout = time.Second
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		l.release()
+	}()
+	a.NoError(l.acquire(done))
+
+	l.config.QueueTimeout = 10 * time.Millisecond
+	a.Error(l.acquire(done))
+
+	// the client is closed while waiting.
+	l.config.QueueTimeout = time.Second
+	close(done)
+	a.Error(l.acquire(done))
+}
+
+func TestConnectLimiter_rate(t *testing.T) {
+	a := assert.New(t)
+	sts := newStatsManager(mem.NewStore())
+	done := make(chan struct{})
+	cfg := config.DefaultHandshake
+	cfg.MaxConcurrent = 10
+	cfg.ConnectRate = 10
+	cfg.ConnectBurst = 2
+	l := newConnectLimiter(cfg, sts)
+	a.NoError(l.acquire(done))
+	a.NoError(l.acquire(done))
+	err := l.acquire(done)
+	a.Equal(codes.ConnectionRateExceeded, err.(*codes.Error).Code)
+	// the slot is released if the rate is exceeded.
+	a.Len(l.slots, 2)
+
+	// the CONNECT packet waits for the token for up to the queue timeout.
+	l.config.QueueTimeout = time.Second
+	start := time.Now()
+	a.NoError(l.acquire(done))
+	a.True(time.Since(start) > 10*time.Millisecond)
+	a.Len(l.slots, 3)
+}
+
+func TestClient_connectWithTimeOut_limited(t *testing.T) {
+	a := assert.New(t)
+	cfg := config.DefaultHandshake
+	cfg.MaxConcurrent = 1
+	srv := &server{
+		config:   config.DefaultConfig(),
+		registry: newRegistry(),
+	}
+	srv.connLimiter = newConnectLimiter(cfg, newStatsManager(mem.NewStore()))
+	a.NoError(srv.connLimiter.acquire(nil))
+	for _, v := range []struct {
+		version packets.Version
+		code    codes.Code
+	}{
+		{version: packets.Version5, code: codes.ServerBusy},
+		{version: packets.Version311, code: codes.V3ServerUnavaliable},
+	} {
+		c, err := srv.newClient(noopConn{})
+		a.NoError(err)
+		c.in <- &inboundPacket{packet: &packets.Connect{Version: v.version, ClientID: []byte("cid"), Properties: &packets.Properties{}}}
+		a.False(c.connectWithTimeOut())
+		ack := (<-c.out).(*packets.Connack)
+		a.Equal(v.code, ack.Code)
+	}
+	// the slot is not taken by the refused clients.
+	a.Len(srv.connLimiter.slots, 1)
+}
@@ -0,0 +1,98 @@
+package server
+
+import (
+	"net"
+	"sync"
+
+	"github.com/DrmagicE/gmqtt/config"
+)
+
+// connectionQuota counts the simultaneous connections per username and per remote IP.
+// A nil quota means the connections are not limited.
+type connectionQuota struct {
+	config    config.ConnectionQuota
+	mu        sync.Mutex
+	usernames map[string]int
+	ips       map[string]int
+}
+
+func newConnectionQuota(cfg config.ConnectionQuota) *connectionQuota {
+	if cfg.MaxPerUsername == 0 && cfg.MaxPerIP == 0 {
+		return nil
+	}
+	return &connectionQuota{
+		config:    cfg,
+		usernames: make(map[string]int),
+		ips:       make(map[string]int),
+	}
+}
+
+// remoteIP returns the IP part of the remote address.
+func remoteIP(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
+
+func (q *connectionQuota) limitUsername(username string) bool {
+	return q.config.MaxPerUsername != 0 && username != ""
+}
+
+func (q *connectionQuota) limitIP(ip string) bool {
+	return q.config.MaxPerIP != 0 && ip != ""
+}
+
+// acquire takes a connection slot for the username and ip, returns false if the quota is exceeded.
+// The slot must be returned by release once the connection is closed.
+// The replaced is the registered client with the same client id, which will be taken over by the new connection,
+// its slot is not counted, otherwise a client reconnecting at the limit would be refused
+// because of its own half-open connection.
+func (q *connectionQuota) acquire(username, ip string, replaced *client) bool {
+	if q == nil {
+		return true
+	}
+	var replacedUsername, replacedIP bool
+	if replaced != nil && replaced.quotaAcquired {
+		replacedUsername = replaced.opts.Username == username
+		replacedIP = remoteIP(replaced.rwc.RemoteAddr()) == ip
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if n := q.usernames[username]; q.limitUsername(username) && n >= q.config.MaxPerUsername && !(replacedUsername && n == q.config.MaxPerUsername) {
+		return false
+	}
+	if n := q.ips[ip]; q.limitIP(ip) && n >= q.config.MaxPerIP && !(replacedIP && n == q.config.MaxPerIP) {
+		return false
+	}
+	if q.limitUsername(username) {
+		q.usernames[username]++
+	}
+	if q.limitIP(ip) {
+		q.ips[ip]++
+	}
+	return true
+}
+
+// release returns the connection slot taken by acquire.
+func (q *connectionQuota) release(username, ip string) {
+	if q == nil {
+		return
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.limitUsername(username) {
+		if q.usernames[username]--; q.usernames[username] <= 0 {
+			delete(q.usernames, username)
+		}
+	}
+	if q.limitIP(ip) {
+		if q.ips[ip]--; q.ips[ip] <= 0 {
+			delete(q.ips, ip)
+		}
+	}
+}
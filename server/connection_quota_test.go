@@ -0,0 +1,76 @@
+package server
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DrmagicE/gmqtt/config"
+)
+
+func TestConnectionQuota(t *testing.T) {
+	a := assert.New(t)
+	a.Nil(newConnectionQuota(config.DefaultConnectionQuota))
+	var nilQuota *connectionQuota
+	a.True(nilQuota.acquire("user", "127.0.0.1", nil))
+
+	q := newConnectionQuota(config.ConnectionQuota{
+		MaxPerUsername: 2,
+		MaxPerIP:       3,
+	})
+	a.True(q.acquire("user", "10.0.0.1", nil))
+	a.True(q.acquire("user", "10.0.0.2", nil))
+	// exceeds the username quota
+	a.False(q.acquire("user", "10.0.0.3", nil))
+	// empty username is not limited by the username quota
+	a.True(q.acquire("", "10.0.0.1", nil))
+	a.True(q.acquire("", "10.0.0.1", nil))
+	// exceeds the ip quota
+	a.False(q.acquire("", "10.0.0.1", nil))
+	a.Equal(3, q.ips["10.0.0.1"])
+
+	q.release("user", "10.0.0.1")
+	a.True(q.acquire("user", "10.0.0.3", nil))
+
+	q.release("user", "10.0.0.2")
+	q.release("user", "10.0.0.3")
+	q.release("", "10.0.0.1")
+	q.release("", "10.0.0.1")
+	a.Empty(q.usernames)
+	a.Empty(q.ips)
+}
+
+func TestConnectionQuota_replaced(t *testing.T) {
+	a := assert.New(t)
+	q := newConnectionQuota(config.ConnectionQuota{
+		MaxPerUsername: 1,
+		MaxPerIP:       1,
+	})
+	// the remote ip of noopConn is "dummy".
+	old := &client{
+		opts:          &ClientOptions{Username: "user"},
+		rwc:           noopConn{},
+		quotaAcquired: true,
+	}
+	a.True(q.acquire("user", "dummy", nil))
+	// the slot of the replaced client is not counted.
+	a.True(q.acquire("user", "dummy", old))
+	a.Equal(2, q.usernames["user"])
+	a.Equal(2, q.ips["dummy"])
+	a.False(q.acquire("user", "dummy", old))
+	q.release("user", "dummy")
+	q.release("user", "dummy")
+
+	// the replaced client is counted for a different username.
+	a.True(q.acquire("user", "10.0.0.1", nil))
+	old.opts.Username = "other"
+	a.False(q.acquire("user", "10.0.0.2", old))
+}
+
+func TestRemoteIP(t *testing.T) {
+	a := assert.New(t)
+	a.Equal("127.0.0.1", remoteIP(&net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1883}))
+	a.Equal("::1", remoteIP(&net.TCPAddr{IP: net.IPv6loopback, Port: 1883}))
+	a.Equal("", remoteIP(nil))
+}
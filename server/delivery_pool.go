@@ -0,0 +1,126 @@
+package server
+
+import (
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"github.com/DrmagicE/gmqtt"
+	"github.com/DrmagicE/gmqtt/config"
+)
+
+// delivery represents a message that is going to be added into the queue of the subscriber.
+type delivery struct {
+	now      time.Time
+	clientID string
+	msg      *gmqtt.Message
+	sub      *gmqtt.Subscription
+	ids      []uint32
+}
+
+// deliveryPool is the worker pool that adds messages into subscriber queues.
+// Deliveries of the same subscriber are always handled by the same worker to keep the message order.
+type deliveryPool struct {
+	srv       *server
+	batchSize int
+	workers   []chan []delivery
+	closing   chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+}
+
+func newDeliveryPool(srv *server, cfg config.Delivery) *deliveryPool {
+	p := &deliveryPool{
+		srv:       srv,
+		batchSize: cfg.BatchSize,
+		workers:   make([]chan []delivery, cfg.Workers),
+		closing:   make(chan struct{}),
+	}
+	p.wg.Add(cfg.Workers)
+	for i := range p.workers {
+		p.workers[i] = make(chan []delivery, cfg.QueueSize)
+		go p.work(p.workers[i])
+	}
+	return p
+}
+
+// worker returns the index of the worker which is responsible for the client.
+func (p *deliveryPool) worker(clientID string) int {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(clientID))
+	return int(h.Sum32() % uint32(len(p.workers)))
+}
+
+func (p *deliveryPool) work(ch chan []delivery) {
+	defer p.wg.Done()
+	for {
+		select {
+		case batch := <-ch:
+			p.handle(batch)
+		case <-p.closing:
+			// drain the pending batches
+			for {
+				select {
+				case batch := <-ch:
+					p.handle(batch)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (p *deliveryPool) handle(batch []delivery) {
+	for _, v := range batch {
+		p.srv.addMsgToQueue(v.now, v.clientID, v.msg, v.sub, v.ids)
+	}
+}
+
+// submit sends the batch to the worker, it blocks if the worker queue is full.
+func (p *deliveryPool) submit(worker int, batch []delivery) {
+	select {
+	case p.workers[worker] <- batch:
+	case <-p.closing:
+		// the pool is closed, handle it on the caller goroutine.
+		p.handle(batch)
+	}
+}
+
+// close stops all workers after the pending batches have been handled.
+func (p *deliveryPool) close() {
+	p.closeOnce.Do(func() {
+		close(p.closing)
+	})
+	p.wg.Wait()
+}
+
+// deliveryBatcher collects deliveries of one message into per-worker batches.
+type deliveryBatcher struct {
+	pool    *deliveryPool
+	pending map[int][]delivery
+}
+
+func (p *deliveryPool) newBatcher() *deliveryBatcher {
+	return &deliveryBatcher{
+		pool:    p,
+		pending: make(map[int][]delivery),
+	}
+}
+
+func (b *deliveryBatcher) add(d delivery) {
+	w := b.pool.worker(d.clientID)
+	b.pending[w] = append(b.pending[w], d)
+	if len(b.pending[w]) >= b.pool.batchSize {
+		b.pool.submit(w, b.pending[w])
+		delete(b.pending, w)
+	}
+}
+
+// flush submits all pending batches.
+func (b *deliveryBatcher) flush() {
+	for w, batch := range b.pending {
+		b.pool.submit(w, batch)
+	}
+	b.pending = make(map[int][]delivery)
+}
@@ -0,0 +1,66 @@
+package server
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DrmagicE/gmqtt"
+	"github.com/DrmagicE/gmqtt/config"
+	"github.com/DrmagicE/gmqtt/persistence/queue"
+	"github.com/DrmagicE/gmqtt/persistence/subscription/mem"
+)
+
+func TestServer_deliverMessage_workerPool(t *testing.T) {
+	a := assert.New(t)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	sub := mem.NewStore()
+	srv := &server{
+		subscriptionsDB: sub,
+		registry:        newRegistry(),
+		config:          config.DefaultConfig(),
+		statsManager:    newStatsManager(sub),
+	}
+	srv.deliveryPool = newDeliveryPool(srv, config.Delivery{
+		Workers:   4,
+		BatchSize: 3,
+		QueueSize: 1,
+	})
+
+	received := make([][]string, 20)
+	for i := 0; i < 20; i++ {
+		cid := strconv.Itoa(i)
+		idx := i
+		qs := queue.NewMockStore(ctrl)
+		qs.EXPECT().Add(gomock.Any()).DoAndReturn(func(elem *queue.Elem) error {
+			received[idx] = append(received[idx], string(elem.MessageWithID.(*queue.Publish).Payload))
+			return nil
+		}).Times(10)
+		srv.registry.shard(cid).queueStore[cid] = qs
+		_, err := sub.Subscribe(cid, &gmqtt.Subscription{
+			TopicFilter: "topic",
+			QoS:         1,
+		})
+		a.Nil(err)
+	}
+	for i := 0; i < 10; i++ {
+		msg := &gmqtt.Message{
+			Topic:   "topic",
+			Payload: []byte(strconv.Itoa(i)),
+			QoS:     1,
+		}
+		a.True(srv.deliverMessage("", msg, defaultIterateOptions(msg.Topic)))
+	}
+	srv.deliveryPool.close()
+
+	for _, v := range received {
+		if a.Len(v, 10) {
+			for i, payload := range v {
+				a.Equal(strconv.Itoa(i), payload)
+			}
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package server
+
+import (
+	"sync"
+	"time"
+
+	"github.com/DrmagicE/gmqtt"
+	"github.com/DrmagicE/gmqtt/pkg/packets"
+)
+
+// deliveryTracker tracks the outgoing messages of a client for the OnDeliveryReport hook.
+// All methods are nil-safe, the nil tracker tracks nothing.
+type deliveryTracker struct {
+	mu sync.Mutex
+	// enqueuedAt is the enqueue time of the publish packets which are going to be written.
+	enqueuedAt map[*packets.Publish]time.Time
+	// inflight is the QoS 1 and QoS 2 messages which are written but not acknowledged, key by packet id.
+	inflight map[packets.PacketID]*DeliveryReport
+}
+
+func newDeliveryTracker() *deliveryTracker {
+	return &deliveryTracker{
+		enqueuedAt: make(map[*packets.Publish]time.Time),
+		inflight:   make(map[packets.PacketID]*DeliveryReport),
+	}
+}
+
+// track records the enqueue time of the publish packet, it must be called before the packet is sent to the write loop.
+func (d *deliveryTracker) track(pub *packets.Publish, enqueuedAt time.Time) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	d.enqueuedAt[pub] = enqueuedAt
+	d.mu.Unlock()
+}
+
+// written returns the report of the publish packet that is going to be written,
+// and starts waiting for the acknowledgement if the QoS > 0.
+func (d *deliveryTracker) written(pub *packets.Publish) *DeliveryReport {
+	if d == nil {
+		return nil
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	at, ok := d.enqueuedAt[pub]
+	if !ok {
+		at = time.Now()
+	}
+	delete(d.enqueuedAt, pub)
+	report := &DeliveryReport{
+		Stage:      DeliveryWritten,
+		Message:    gmqtt.MessageFromPublish(pub),
+		EnqueuedAt: at,
+	}
+	if pub.Qos != packets.Qos0 {
+		d.inflight[pub.PacketID] = &DeliveryReport{
+			Stage:      DeliveryAcknowledged,
+			Message:    report.Message,
+			EnqueuedAt: at,
+		}
+	}
+	return report
+}
+
+// acknowledged returns the report of the acknowledged message, nil if the packet id is not tracked.
+func (d *deliveryTracker) acknowledged(id packets.PacketID) *DeliveryReport {
+	if d == nil {
+		return nil
+	}
+	d.mu.Lock()
+	report := d.inflight[id]
+	delete(d.inflight, id)
+	d.mu.Unlock()
+	if report != nil {
+		report.Latency = time.Since(report.EnqueuedAt)
+	}
+	return report
+}
+
+// remove stops tracking the message which is rejected by the client.
+func (d *deliveryTracker) remove(id packets.PacketID) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	delete(d.inflight, id)
+	d.mu.Unlock()
+}
+
+// acknowledged fires the OnDeliveryReport hook for the message acknowledged by PUBACK or PUBCOMP,
+// and completes the delivery receipt of the message.
+func (client *client) acknowledged(id packets.PacketID) {
+	client.server.receipts.acknowledged(client.opts.ClientID, id, false)
+	if report := client.deliveries.acknowledged(id); report != nil {
+		client.server.hooks.OnDeliveryReport(client.requestContext(), client, report)
+	}
+}
@@ -0,0 +1,113 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DrmagicE/gmqtt/persistence/queue"
+	"github.com/DrmagicE/gmqtt/persistence/subscription/mem"
+	"github.com/DrmagicE/gmqtt/pkg/codes"
+	"github.com/DrmagicE/gmqtt/pkg/packets"
+)
+
+// discardConn discards all written data.
+type discardConn struct {
+	noopConn
+}
+
+func (discardConn) Write(b []byte) (n int, err error) {
+	return len(b), nil
+}
+
+func TestClient_OnDeliveryReport(t *testing.T) {
+	a := assert.New(t)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	srv := defaultServer()
+	srv.statsManager = newStatsManager(mem.NewStore())
+	reports := make(chan *DeliveryReport, 10)
+	srv.hooks.OnDeliveryReport = func(ctx context.Context, client Client, report *DeliveryReport) {
+		a.Equal("cid", client.ClientOptions().ClientID)
+		reports <- report
+	}
+	c, err := srv.newClient(discardConn{})
+	a.NoError(err)
+	a.NotNil(c.deliveries)
+	c.opts.ClientID = "cid"
+	c.version = packets.Version311
+	c.opts.MaxInflight = 10
+	c.newPacketIDLimiter(c.opts.MaxInflight)
+	qs := queue.NewMockStore(ctrl)
+	c.queueStore = qs
+	go c.writeLoop()
+	defer c.setError(nil)
+
+	receive := func() *DeliveryReport {
+		select {
+		case r := <-reports:
+			return r
+		case <-time.After(time.Second):
+			t.Fatal("missing delivery report")
+		}
+		return nil
+	}
+	enqueuedAt := time.Now().Add(-time.Second)
+	for _, v := range []*packets.Publish{
+		{Qos: packets.Qos0, TopicName: []byte("qos0"), Payload: []byte("0")},
+		{Qos: packets.Qos1, PacketID: 1, TopicName: []byte("qos1"), Payload: []byte("1")},
+		{Qos: packets.Qos2, PacketID: 2, TopicName: []byte("qos2"), Payload: []byte("2")},
+	} {
+		c.deliveries.track(v, enqueuedAt)
+		c.write(v)
+		r := receive()
+		a.Equal(DeliveryWritten, r.Stage)
+		a.Equal(string(v.TopicName), r.Message.Topic)
+		a.Equal(enqueuedAt, r.EnqueuedAt)
+		a.True(r.Latency >= time.Second)
+	}
+
+	qs.EXPECT().Remove(packets.PacketID(1))
+	a.Nil(c.pubackHandler(&packets.Puback{PacketID: 1}))
+	r := receive()
+	a.Equal(DeliveryAcknowledged, r.Stage)
+	a.Equal("qos1", r.Message.Topic)
+	a.True(r.Latency >= time.Second)
+
+	qs.EXPECT().Remove(packets.PacketID(2))
+	c.pubcompHandler(&packets.Pubcomp{PacketID: 2})
+	r = receive()
+	a.Equal(DeliveryAcknowledged, r.Stage)
+	a.Equal("qos2", r.Message.Topic)
+
+	// unknown packet id
+	qs.EXPECT().Remove(packets.PacketID(2))
+	c.pubcompHandler(&packets.Pubcomp{PacketID: 2})
+
+	// rejected by v5 client
+	c.deliveries.track(&packets.Publish{Qos: packets.Qos1, PacketID: 3, TopicName: []byte("qos1")}, enqueuedAt)
+	c.deliveries.written(&packets.Publish{Qos: packets.Qos1, PacketID: 3, TopicName: []byte("qos1")})
+	c.version = packets.Version5
+	qs.EXPECT().Remove(packets.PacketID(3))
+	c.pubrecHandler(&packets.Pubrec{PacketID: 3, Code: codes.UnspecifiedError})
+	a.Nil(c.deliveries.acknowledged(3))
+
+	select {
+	case r := <-reports:
+		t.Fatalf("unexpected report: %v", r)
+	default:
+	}
+}
+
+func TestDeliveryTracker_nil(t *testing.T) {
+	a := assert.New(t)
+	var d *deliveryTracker
+	pub := &packets.Publish{Qos: packets.Qos1, PacketID: 1}
+	d.track(pub, time.Now())
+	a.Nil(d.written(pub))
+	a.Nil(d.acknowledged(1))
+	d.remove(1)
+}
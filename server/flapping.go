@@ -0,0 +1,117 @@
+package server
+
+import (
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/DrmagicE/gmqtt/config"
+)
+
+type flappingEntry struct {
+	windowStart time.Time
+	connects    int
+	// bans is the number of consecutive bans, it is used to calculate the ban duration.
+	bans     int
+	banUntil time.Time
+}
+
+// flappingDetector bans the clients that connect too frequently.
+// A nil detector means the flapping detection is disabled.
+type flappingDetector struct {
+	config  config.FlappingDetection
+	log     config.LogConfig
+	mu      sync.Mutex
+	entries map[string]*flappingEntry
+}
+
+func newFlappingDetector(cfg config.FlappingDetection, logCfg config.LogConfig) *flappingDetector {
+	return &flappingDetector{
+		config:  cfg,
+		log:     logCfg,
+		entries: make(map[string]*flappingEntry),
+	}
+}
+
+// key returns the key to identify the client.
+func (f *flappingDetector) key(clientID, ip string) string {
+	if f.config.BanBy == config.BanByIP {
+		return ip
+	}
+	return clientID
+}
+
+// banDuration returns the ban duration of the nth ban.
+func (f *flappingDetector) banDuration(n int) time.Duration {
+	d := f.config.BanDuration
+	for i := 1; i < n && d < f.config.MaxBanDuration; i++ {
+		d *= 2
+	}
+	if d > f.config.MaxBanDuration {
+		d = f.config.MaxBanDuration
+	}
+	return d
+}
+
+// connect records a connect attempt and returns whether the client is banned.
+func (f *flappingDetector) connect(now time.Time, clientID, ip string) (banned bool) {
+	if f == nil {
+		return false
+	}
+	key := f.key(clientID, ip)
+	if key == "" {
+		return false
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	e := f.entries[key]
+	if e == nil {
+		e = &flappingEntry{windowStart: now}
+		f.entries[key] = e
+	}
+	if now.Before(e.banUntil) {
+		return true
+	}
+	if now.Sub(e.windowStart) >= f.config.Window {
+		e.windowStart = now
+		e.connects = 0
+	}
+	e.connects++
+	if e.connects <= f.config.MaxConnects {
+		return false
+	}
+	e.bans++
+	d := f.banDuration(e.bans)
+	e.banUntil = now.Add(d)
+	e.windowStart = e.banUntil
+	e.connects = 0
+	logKey := key
+	if f.config.BanBy == config.BanByIP {
+		logKey = redactRemoteAddr(f.log, key)
+	}
+	zaplog.Warn("connection flapping detected, ban the client",
+		zap.String("ban_by", f.config.BanBy),
+		zap.String("key", logKey),
+		zap.Duration("duration", d))
+	return true
+}
+
+// clean removes the entries that are neither banned nor counting in the current window.
+// The ban history is kept until the client has not been banned for MaxBanDuration.
+func (f *flappingDetector) clean(now time.Time) {
+	if f == nil {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for k, e := range f.entries {
+		if now.Sub(e.windowStart) < f.config.Window {
+			continue
+		}
+		if e.bans != 0 && now.Sub(e.banUntil) < f.config.MaxBanDuration {
+			continue
+		}
+		delete(f.entries, k)
+	}
+}
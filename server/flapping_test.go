@@ -0,0 +1,74 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DrmagicE/gmqtt/config"
+)
+
+func TestFlappingDetector_connect(t *testing.T) {
+	a := assert.New(t)
+	var nilDetector *flappingDetector
+	a.False(nilDetector.connect(time.Now(), "cid", "127.0.0.1"))
+
+	f := newFlappingDetector(config.FlappingDetection{
+		Enable:         true,
+		Window:         time.Minute,
+		MaxConnects:    2,
+		BanDuration:    time.Minute,
+		MaxBanDuration: 3 * time.Minute,
+		BanBy:          config.BanByClientID,
+	}, config.LogConfig{})
+	now := time.Unix(0, 0)
+	a.False(f.connect(now, "cid", "127.0.0.1"))
+	a.False(f.connect(now, "cid", "127.0.0.1"))
+	// other clients are not affected
+	a.False(f.connect(now, "cid2", "127.0.0.1"))
+
+	// the first ban
+	a.True(f.connect(now, "cid", "127.0.0.1"))
+	a.True(f.connect(now.Add(59*time.Second), "cid", "127.0.0.1"))
+
+	// the second ban doubles the duration
+	now = now.Add(time.Minute)
+	a.False(f.connect(now, "cid", "127.0.0.1"))
+	a.False(f.connect(now, "cid", "127.0.0.1"))
+	a.True(f.connect(now, "cid", "127.0.0.1"))
+	a.Equal(now.Add(2*time.Minute), f.entries["cid"].banUntil)
+
+	// the third ban is limited by MaxBanDuration
+	now = now.Add(2 * time.Minute)
+	a.False(f.connect(now, "cid", "127.0.0.1"))
+	a.False(f.connect(now, "cid", "127.0.0.1"))
+	a.True(f.connect(now, "cid", "127.0.0.1"))
+	a.Equal(now.Add(3*time.Minute), f.entries["cid"].banUntil)
+}
+
+func TestFlappingDetector_clean(t *testing.T) {
+	a := assert.New(t)
+	f := newFlappingDetector(config.FlappingDetection{
+		Enable:         true,
+		Window:         time.Minute,
+		MaxConnects:    1,
+		BanDuration:    time.Minute,
+		MaxBanDuration: time.Hour,
+		BanBy:          config.BanByIP,
+	}, config.LogConfig{})
+	now := time.Unix(0, 0)
+	a.False(f.connect(now, "cid1", "10.0.0.1"))
+	a.False(f.connect(now, "cid2", "10.0.0.2"))
+	a.True(f.connect(now, "cid3", "10.0.0.2"))
+
+	f.clean(now.Add(time.Minute))
+	a.Len(f.entries, 1)
+	a.Contains(f.entries, "10.0.0.2")
+
+	// the ban history is kept until the client has not been banned for MaxBanDuration.
+	f.clean(now.Add(time.Hour))
+	a.Len(f.entries, 1)
+	f.clean(now.Add(time.Hour + 2*time.Minute))
+	a.Empty(f.entries)
+}
@@ -2,9 +2,12 @@ package server
 
 import (
 	"context"
+	"errors"
 	"net"
+	"time"
 
 	"github.com/DrmagicE/gmqtt"
+	"github.com/DrmagicE/gmqtt/persistence/queue"
 	"github.com/DrmagicE/gmqtt/persistence/subscription"
 	"github.com/DrmagicE/gmqtt/pkg/packets"
 )
@@ -24,21 +27,29 @@ type Hooks struct {
 	OnSessionCreated
 	OnSessionResumed
 	OnSessionTerminated
+	OnSessionExpired
+	OnSessionTakenOver
+	OnOfflineBacklog
 	OnDelivered
+	OnDeliveryReport
 	OnClosed
 	OnMsgDropped
 	OnWillPublish
 	OnWillPublished
+	OnPacketReceived
+	OnPacketSend
+	OnPSKLookup
 }
 
 // WillMsgRequest is the input param for OnWillPublish hook.
 type WillMsgRequest struct {
 	// Message is the message that is going to send.
-	// The caller can edit this field to modify the will message.
+	// The caller can edit or replace this field to modify the topic, payload and properties of the will message.
 	// If nil, the broker will drop the message.
 	Message *gmqtt.Message
 	// IterationOptions is the same as MsgArrivedRequest.IterationOptions,
-	// see MsgArrivedRequest for details
+	// see MsgArrivedRequest for details.
+	// If the topic of the message is changed and the TopicName is left untouched, the TopicName will be set to the new topic.
 	IterationOptions subscription.IterationOptions
 }
 
@@ -48,7 +59,9 @@ func (w *WillMsgRequest) Drop() {
 }
 
 // OnWillPublish will be called before the client with the given clientID sending the will message.
-// It provides the ability to modify the message before sending.
+// It provides the ability to modify the message before sending or suppress it by calling req.Drop(),
+// e.g. suppress the will messages during a planned maintenance.
+// The will message will also be dropped if the modified topic is not a valid topic name.
 type OnWillPublish func(ctx context.Context, clientID string, req *WillMsgRequest)
 
 type OnWillPublishWrapper func(OnWillPublish) OnWillPublish
@@ -59,12 +72,41 @@ type OnWillPublished func(ctx context.Context, clientID string, msg *gmqtt.Messa
 
 type OnWillPublishedWrapper func(OnWillPublished) OnWillPublished
 
+// ErrDiscardPacket can be returned by OnPacketReceived and OnPacketSend to discard the packet silently.
+var ErrDiscardPacket = errors.New("packet discarded")
+
+// OnPacketReceived will be called when a control packet (including CONNECT) is decoded from the client,
+// before it is handled by the broker.
+// Return ErrDiscardPacket to discard the packet, the client may wait for the acknowledgement of the discarded packet.
+// Return other errors to close the client connection,
+// if the error is a *codes.Error, the V5 client will receive a DISCONNECT packet with the code.
+// The packet is immutable, DO NOT EDIT. It is called in the read goroutine of the client, so it should not block.
+type OnPacketReceived func(ctx context.Context, client Client, packet packets.Packet) error
+
+type OnPacketReceivedWrapper func(OnPacketReceived) OnPacketReceived
+
+// OnPacketSend will be called before a control packet is written to the client.
+// Return ErrDiscardPacket to discard the packet, return other errors to close the client connection.
+// For the PUBLISH packet, it is called before the topic name is replaced by the topic alias.
+// The packet is immutable, DO NOT EDIT. It is called in the write goroutine of the client, so it should not block.
+type OnPacketSend func(ctx context.Context, client Client, packet packets.Packet) error
+
+type OnPacketSendWrapper func(OnPacketSend) OnPacketSend
+
 // OnAccept will be called after a new connection established in TCP server.
 // If returns false, the connection will be close directly.
 type OnAccept func(ctx context.Context, conn net.Conn) bool
 
 type OnAcceptWrapper func(OnAccept) OnAccept
 
+// OnPSKLookup will be called in the TLS-PSK handshake of the listeners with psk enabled,
+// to resolve the PSK identity sent by the client to the key, see config.PSKOptions.
+// Return a nil key if the identity is unknown, the handshake will fail with the unknown_psk_identity alert.
+// The ctx carries the RequestInfo with the listener and the remote address.
+type OnPSKLookup func(ctx context.Context, identity string) (key []byte, err error)
+
+type OnPSKLookupWrapper func(OnPSKLookup) OnPSKLookup
+
 // OnStop will be called on server.Stop()
 type OnStop func(ctx context.Context)
 
@@ -76,6 +118,9 @@ type SubscribeRequest struct {
 	Subscribe *packets.Subscribe
 	// Subscriptions wraps all subscriptions by the full topic name.
 	// You can modify the value of the map to edit the subscription. But must not change the length of the map.
+	// The modified options (QoS, topic filter, no local, retain as published, retain handling and subscription id) take effect,
+	// and the modified QoS is returned in the SUBACK packet as the granted QoS.
+	// If the modified topic filter or QoS is invalid, the subscription fails with the ImplementationSpecificError code.
 	Subscriptions map[string]*struct {
 		// Sub is the subscription.
 		Sub *gmqtt.Subscription
@@ -99,6 +144,23 @@ func (s *SubscribeRequest) GrantQoS(topicName string, qos packets.QoS) *Subscrib
 	return s
 }
 
+// SetTopicFilter replaces the topic filter of the subscription for the given topic name.
+// The topic filter can be a shared subscription in the form of "$share/{ShareName}/{filter}".
+func (s *SubscribeRequest) SetTopicFilter(topicName string, topicFilter string) *SubscribeRequest {
+	if sub := s.Subscriptions[topicName]; sub != nil {
+		sub.Sub.ShareName, sub.Sub.TopicFilter = subscription.SplitTopic(topicFilter)
+	}
+	return s
+}
+
+// SetNoLocal sets the no local option of the subscription for the given topic name.
+func (s *SubscribeRequest) SetNoLocal(topicName string, noLocal bool) *SubscribeRequest {
+	if sub := s.Subscriptions[topicName]; sub != nil {
+		sub.Sub.NoLocal = noLocal
+	}
+	return s
+}
+
 // Reject rejects the subscription for the given topic name.
 func (s *SubscribeRequest) Reject(topicName string, err error) {
 	if sub := s.Subscriptions[topicName]; sub != nil {
@@ -106,7 +168,8 @@ func (s *SubscribeRequest) Reject(topicName string, err error) {
 	}
 }
 
-// SetID sets the subscription id for the subscriptions
+// SetID sets the subscription id for all subscriptions in the request.
+// Set the ID field of Subscriptions.Sub to attach the subscription id to a single subscription.
 func (s *SubscribeRequest) SetID(id uint32) *SubscribeRequest {
 	s.ID = id
 	return s
@@ -172,7 +235,11 @@ type MsgArrivedRequest struct {
 	// Publish is the origin MQTT PUBLISH packet, it is immutable. DO NOT EDIT.
 	Publish *packets.Publish
 	// Message is the message that is going to be passed to topic match process.
-	// The caller can modify it.
+	// The caller can edit or replace it to rewrite the topic, payload, QoS, retain flag, user properties and other properties of the message.
+	// The retained message store and the subscribers receive the modified message.
+	// If the topic is changed and IterationOptions.TopicName is left untouched, the TopicName will be set to the new topic.
+	// If the modified topic is not a valid topic name or the QoS is invalid,
+	// the message is rejected with the ImplementationSpecificError code.
 	Message *gmqtt.Message
 	// IterationOptions provides the the ability to change the options of topic matching process.
 	// In most of cases, you don't need to modify it.
@@ -195,8 +262,9 @@ func (m *MsgArrivedRequest) Drop() {
 
 type OnMsgArrivedWrapper func(OnMsgArrived) OnMsgArrived
 
-// OnClosed will be called after the tcp connection of the client has been closed
-type OnClosed func(ctx context.Context, client Client, err error)
+// OnClosed will be called after the tcp connection of the client has been closed.
+// The reason param describes why the connection is closed, see CloseReason for details.
+type OnClosed func(ctx context.Context, client Client, reason *CloseReason)
 
 type OnClosedWrapper func(OnClosed) OnClosed
 
@@ -205,6 +273,10 @@ type OnClosedWrapper func(OnClosed) OnClosed
 type AuthOptions struct {
 	// SessionExpiry is session expired time in seconds.
 	SessionExpiry uint32
+	// SessionExpiryClass is the name of the session expiry class of the client, see config.MQTT.SessionExpiryClasses.
+	// It defaults to the class matched by the username. If the hooks select another class,
+	// SessionExpiry is recalculated with the class after the authentication succeeds.
+	SessionExpiryClass string
 	// ReceiveMax limits the number of QoS 1 and QoS 2 publications that the server is willing to process concurrently for the client.
 	// If the client version is v5, this value will be set into  Receive Maximum property in CONNACK packet.
 	// See: https://docs.oasis-open.org/mqtt/mqtt/v5.0/os/mqtt-v5.0-os.html#_Toc3901083
@@ -250,9 +322,29 @@ type AuthOptions struct {
 	ResponseInfo []byte
 	// MaxInflight limits the number of QoS 1 and QoS 2 publications that the client is willing to process concurrently.
 	MaxInflight uint16
+	// MountPoint is transparently prefixed to all topics the client publishes and subscribes (including the will topic),
+	// and stripped from the topics of the messages sent to the client, e.g. "tenants/{username}/".
+	// The placeholders MountPointUsername and MountPointClientID are replaced after the authentication,
+	// the connection is refused if the replaced value is empty or contains any of '/', '+' and '#'.
+	// It defaults to the mount point of the listener. The hooks after the connection see the mounted topics.
+	MountPoint string
+	// Attributes is the key-value attributes of the client, e.g. the device model read from the auth backend.
+	// They are merged into the attributes of the resumed session, see gmqtt.Session.Attributes.
+	Attributes map[string]string
+	// OutboundBytesPerSecond limits the bytes per second of the messages sent to the client, 0 means unlimited.
+	// It defaults to config.MQTT.OutboundBandwidth.BytesPerSecond.
+	OutboundBytesPerSecond int
+	// OutboundBurst is the maximum bytes that can be sent to the client at once after an idle period,
+	// 0 means OutboundBytesPerSecond. It defaults to config.MQTT.OutboundBandwidth.Burst.
+	OutboundBurst int
 }
 
 // OnBasicAuth will be called when receive v311 connect packet or v5 connect packet with empty auth method property.
+// Return *codes.Error (which can be wrapped) to refuse the connection with the CONNACK reason code, reason string and
+// user properties, e.g. codes.NewError(codes.QuotaExceeded).WithReasonString("payment required").
+// The code is mapped to the closest valid one for the client version, e.g. 0x97 (Quota exceeded) is sent
+// as 0x05 (Not authorized) to the V3 clients, which do not receive the reason string and user properties.
+// Other errors refuse the connection with 0x80 (Unspecified error) and the error message as the reason string.
 type OnBasicAuth func(ctx context.Context, client Client, req *ConnectRequest) (err error)
 
 // ConnectRequest represents a connect request made by a CONNECT packet.
@@ -267,6 +359,7 @@ type ConnectRequest struct {
 type OnBasicAuthWrapper func(OnBasicAuth) OnBasicAuth
 
 // OnEnhancedAuth will be called when receive v5 connect packet with auth method property.
+// The returned error refuses the connection in the same way as OnBasicAuth.
 type OnEnhancedAuth func(ctx context.Context, client Client, req *ConnectRequest) (resp *EnhancedAuthResponse, err error)
 
 type EnhancedAuthResponse struct {
@@ -308,7 +401,10 @@ type OnSessionCreated func(ctx context.Context, client Client)
 type OnSessionCreatedWrapper func(OnSessionCreated) OnSessionCreated
 
 // OnSessionResumed will be called when session resumed.
-type OnSessionResumed func(ctx context.Context, client Client)
+// The queued is the number of messages (including the inflight messages) in the session queue that are going to be replayed to the client.
+// Notice that the number is not accurate for the persistent sessions loaded from the storage after restarting,
+// because the queue length statistic is not persistent.
+type OnSessionResumed func(ctx context.Context, client Client, queued int)
 
 type OnSessionResumedWrapper func(OnSessionResumed) OnSessionResumed
 
@@ -325,14 +421,123 @@ type OnSessionTerminated func(ctx context.Context, clientID string, reason Sessi
 
 type OnSessionTerminatedWrapper func(OnSessionTerminated) OnSessionTerminated
 
+// OnSessionExpired will be called when the offline session is expired and removed by the session expiry checker.
+// The session is nil if it can not be loaded from the session store.
+// It is followed by OnSessionTerminated with ExpiredTermination reason.
+type OnSessionExpired func(ctx context.Context, clientID string, session *gmqtt.Session)
+
+type OnSessionExpiredWrapper func(OnSessionExpired) OnSessionExpired
+
+// OnOfflineBacklog will be called when the number of the messages queued for the offline session since the client disconnected
+// reaches the mqtt.offline_backlog.threshold, e.g. to alert on the dead devices which still have active publishers.
+// It is called once per disconnection, queued is the number of the messages queued since the client disconnected.
+// It is called with the session locked, DO NOT block in it.
+type OnOfflineBacklog func(ctx context.Context, clientID string, queued int)
+
+type OnOfflineBacklogWrapper func(OnOfflineBacklog) OnOfflineBacklog
+
+// OnSessionTakenOver will be called when the client connects with the client id of an online client,
+// after the previous client is closed and the session is resumed or created.
+type OnSessionTakenOver func(ctx context.Context, client Client, info *TakeoverInfo)
+
+type OnSessionTakenOverWrapper func(OnSessionTakenOver) OnSessionTakenOver
+
 // OnDelivered will be called when publishing a message to a client.
 type OnDelivered func(ctx context.Context, client Client, msg *gmqtt.Message)
 
 type OnDeliveredWrapper func(OnDelivered) OnDelivered
 
+// DeliveryStage is the stage of the message delivery.
+type DeliveryStage byte
+
+const (
+	// DeliveryWritten means the message has been written to the client connection.
+	DeliveryWritten DeliveryStage = iota
+	// DeliveryAcknowledged means the message has been acknowledged by the client,
+	// by PUBACK for QoS 1 message and by PUBCOMP for QoS 2 message.
+	DeliveryAcknowledged
+)
+
+// DeliveryReport is the input param for OnDeliveryReport hook.
+type DeliveryReport struct {
+	Stage DeliveryStage
+	// Message is the delivered message, it is immutable. DO NOT EDIT.
+	Message *gmqtt.Message
+	// EnqueuedAt is the time when the message was put into the queue of the client.
+	EnqueuedAt time.Time
+	// Latency is the duration from EnqueuedAt to the Stage.
+	Latency time.Duration
+}
+
+// OnDeliveryReport will be called when a message has been written to the client,
+// and for QoS 1 and QoS 2 message, called again when the message has been acknowledged by the client.
+// If the message is retransmitted after reconnecting, the EnqueuedAt is the time when the message was enqueued at the first time.
+// It is called in the read and write goroutine of the client, so it must not block.
+type OnDeliveryReport func(ctx context.Context, client Client, report *DeliveryReport)
+
+type OnDeliveryReportWrapper func(OnDeliveryReport) OnDeliveryReport
+
+// DropReason is the structured reason of a dropped message, it can be used as a metric label.
+type DropReason string
+
+const (
+	// DropReasonInternal means the message is dropped because of an internal error of the storage backend.
+	DropReasonInternal DropReason = "internal"
+	// DropReasonExpired means the message is expired before being sent.
+	DropReasonExpired DropReason = "expired"
+	// DropReasonInflightExpired means the inflight message is not acknowledged in time.
+	DropReasonInflightExpired DropReason = "inflight_expired"
+	// DropReasonQueueFull means the message queue of the client is full.
+	DropReasonQueueFull DropReason = "queue_full"
+	// DropReasonExceedsMaxSize means the message exceeds the maximum packet size of the client.
+	DropReasonExceedsMaxSize DropReason = "exceeds_max_size"
+	// DropReasonOverloaded means the QoS 0 message is shed because the server is overloaded.
+	DropReasonOverloaded DropReason = "overloaded"
+	// DropReasonQos0NotQueued means the QoS 0 message is not queued for the offline client.
+	DropReasonQos0NotQueued DropReason = "qos0_not_queued"
+	// DropReasonNoSubscriber means there is no matching subscriber for the message.
+	DropReasonNoSubscriber DropReason = "no_subscriber"
+	// DropReasonIncompatible means the V5 message is rejected for the V3 client by the property mapping.
+	DropReasonIncompatible DropReason = "incompatible"
+	// DropReasonRedeliveryExhausted means the inflight message is not acknowledged after the maximum redelivery attempts.
+	DropReasonRedeliveryExhausted DropReason = "redelivery_exhausted"
+	// DropReasonBlackhole means the message is published to a blackhole topic.
+	DropReasonBlackhole DropReason = "blackhole"
+)
+
+// DropReasonOf returns the DropReason of the error passed to OnMsgDropped.
+func DropReasonOf(err error) DropReason {
+	switch err {
+	case queue.ErrDropExpired:
+		return DropReasonExpired
+	case queue.ErrDropExpiredInflight:
+		return DropReasonInflightExpired
+	case queue.ErrDropQueueFull:
+		return DropReasonQueueFull
+	case queue.ErrDropExceedsMaxPacketSize:
+		return DropReasonExceedsMaxSize
+	case queue.ErrDropOverloaded:
+		return DropReasonOverloaded
+	case queue.ErrDropQos0NotQueued:
+		return DropReasonQos0NotQueued
+	case queue.ErrDropNoSubscriber:
+		return DropReasonNoSubscriber
+	case queue.ErrDropIncompatible:
+		return DropReasonIncompatible
+	case queue.ErrDropRedeliveryExhausted:
+		return DropReasonRedeliveryExhausted
+	case queue.ErrDropBlackhole:
+		return DropReasonBlackhole
+	}
+	return DropReasonInternal
+}
+
 // OnMsgDropped will be called after the Msg dropped.
-// The err indicates the reason of dropping.
+// The err indicates the reason of dropping, use DropReasonOf to get the structured reason.
 // See: persistence/queue/error.go
+// The clientID is the subscriber that the message was going to be sent to,
+// except for queue.ErrDropNoSubscriber and queue.ErrDropBlackhole, in which case it is the publisher
+// ("" if the message is published by the Publisher API).
 type OnMsgDropped func(ctx context.Context, clientID string, msg *gmqtt.Message, err error)
 
 type OnMsgDroppedWrapper func(OnMsgDropped) OnMsgDropped
@@ -0,0 +1,230 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/DrmagicE/gmqtt"
+	"github.com/DrmagicE/gmqtt/config"
+	"github.com/DrmagicE/gmqtt/pkg/codes"
+	"github.com/DrmagicE/gmqtt/pkg/packets"
+)
+
+func newHookTimeoutError(code codes.Code) *codes.Error {
+	return &codes.Error{
+		Code: code,
+		ErrorDetails: codes.ErrorDetails{
+			ReasonString: []byte("hook timeout"),
+		},
+	}
+}
+
+// hookTimeout runs the hook chain in a new goroutine and waits for it no longer than the timeout.
+type hookTimeout struct {
+	hook     string
+	timeout  time.Duration
+	failOpen bool
+	total    uint64
+}
+
+// run returns false if the fn is timed out.
+// The ctx passed to fn is cancelled after the timeout.
+func (h *hookTimeout) run(ctx context.Context, fn func(ctx context.Context)) bool {
+	ctx, cancel := context.WithTimeout(ctx, h.timeout)
+	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		fn(ctx)
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-ctx.Done():
+		atomic.AddUint64(&h.total, 1)
+		zaplog.Warn("hook timeout",
+			zap.String("hook", h.hook),
+			zap.Duration("timeout", h.timeout),
+			zap.Bool("fail_open", h.failOpen))
+		return false
+	}
+}
+
+func copyConnectRequest(req *ConnectRequest) *ConnectRequest {
+	opts := *req.Options
+	return &ConnectRequest{
+		Connect: req.Connect,
+		Options: &opts,
+	}
+}
+
+// applyHookTimeouts wraps the hook chains with the given timeouts.
+// The hook chain works on a copy of the request, the modifications are applied to the origin request only if it finishes in time.
+func (srv *server) applyHookTimeouts(timeouts []config.HookTimeout) ([]*hookTimeout, error) {
+	var rs []*hookTimeout
+	for _, v := range timeouts {
+		t := &hookTimeout{
+			hook:     v.Hook,
+			timeout:  v.Timeout,
+			failOpen: v.Policy == config.HookFailOpen,
+		}
+		rs = append(rs, t)
+		switch v.Hook {
+		case "OnAccept":
+			if fn := srv.hooks.OnAccept; fn != nil {
+				srv.hooks.OnAccept = func(ctx context.Context, conn net.Conn) bool {
+					var ok bool
+					if !t.run(ctx, func(ctx context.Context) {
+						ok = fn(ctx, conn)
+					}) {
+						return t.failOpen
+					}
+					return ok
+				}
+			}
+		case "OnBasicAuth":
+			if fn := srv.hooks.OnBasicAuth; fn != nil {
+				srv.hooks.OnBasicAuth = func(ctx context.Context, client Client, req *ConnectRequest) error {
+					var err error
+					r := copyConnectRequest(req)
+					if !t.run(ctx, func(ctx context.Context) {
+						err = fn(ctx, client, r)
+					}) {
+						if t.failOpen {
+							return nil
+						}
+						return newHookTimeoutError(codes.ServerUnavailable)
+					}
+					*req.Options = *r.Options
+					return err
+				}
+			}
+		case "OnEnhancedAuth":
+			if fn := srv.hooks.OnEnhancedAuth; fn != nil {
+				srv.hooks.OnEnhancedAuth = func(ctx context.Context, client Client, req *ConnectRequest) (*EnhancedAuthResponse, error) {
+					var resp *EnhancedAuthResponse
+					var err error
+					r := copyConnectRequest(req)
+					if !t.run(ctx, func(ctx context.Context) {
+						resp, err = fn(ctx, client, r)
+					}) {
+						// the enhanced authentication can not continue without the response, so it always fails.
+						return nil, newHookTimeoutError(codes.ServerUnavailable)
+					}
+					*req.Options = *r.Options
+					return resp, err
+				}
+			}
+		case "OnReAuth":
+			if fn := srv.hooks.OnReAuth; fn != nil {
+				srv.hooks.OnReAuth = func(ctx context.Context, client Client, auth *packets.Auth) (*AuthResponse, error) {
+					var resp *AuthResponse
+					var err error
+					if !t.run(ctx, func(ctx context.Context) {
+						resp, err = fn(ctx, client, auth)
+					}) {
+						// the re-authentication can not continue without the response, so it always fails.
+						return nil, newHookTimeoutError(codes.UnspecifiedError)
+					}
+					return resp, err
+				}
+			}
+		case "OnSubscribe":
+			if fn := srv.hooks.OnSubscribe; fn != nil {
+				srv.hooks.OnSubscribe = func(ctx context.Context, client Client, req *SubscribeRequest) error {
+					var err error
+					r := &SubscribeRequest{
+						Subscribe: req.Subscribe,
+						Subscriptions: make(map[string]*struct {
+							Sub   *gmqtt.Subscription
+							Error error
+						}, len(req.Subscriptions)),
+						ID: req.ID,
+					}
+					for k, v := range req.Subscriptions {
+						sub := *v.Sub
+						r.Subscriptions[k] = &struct {
+							Sub   *gmqtt.Subscription
+							Error error
+						}{Sub: &sub, Error: v.Error}
+					}
+					if !t.run(ctx, func(ctx context.Context) {
+						err = fn(ctx, client, r)
+					}) {
+						if t.failOpen {
+							return nil
+						}
+						return newHookTimeoutError(codes.UnspecifiedError)
+					}
+					for k, v := range r.Subscriptions {
+						if s, ok := req.Subscriptions[k]; ok {
+							*s = *v
+						}
+					}
+					req.ID = r.ID
+					return err
+				}
+			}
+		case "OnUnsubscribe":
+			if fn := srv.hooks.OnUnsubscribe; fn != nil {
+				srv.hooks.OnUnsubscribe = func(ctx context.Context, client Client, req *UnsubscribeRequest) error {
+					var err error
+					r := &UnsubscribeRequest{
+						Unsubscribe: req.Unsubscribe,
+						Unsubs: make(map[string]*struct {
+							TopicName string
+							Error     error
+						}, len(req.Unsubs)),
+					}
+					for k, v := range req.Unsubs {
+						u := *v
+						r.Unsubs[k] = &u
+					}
+					if !t.run(ctx, func(ctx context.Context) {
+						err = fn(ctx, client, r)
+					}) {
+						if t.failOpen {
+							return nil
+						}
+						return newHookTimeoutError(codes.UnspecifiedError)
+					}
+					for k, v := range r.Unsubs {
+						if u, ok := req.Unsubs[k]; ok {
+							*u = *v
+						}
+					}
+					return err
+				}
+			}
+		case "OnMsgArrived":
+			if fn := srv.hooks.OnMsgArrived; fn != nil {
+				srv.hooks.OnMsgArrived = func(ctx context.Context, client Client, req *MsgArrivedRequest) error {
+					var err error
+					r := *req
+					if req.Message != nil {
+						msg := *req.Message
+						r.Message = &msg
+					}
+					if !t.run(ctx, func(ctx context.Context) {
+						err = fn(ctx, client, &r)
+					}) {
+						if t.failOpen {
+							return nil
+						}
+						return newHookTimeoutError(codes.UnspecifiedError)
+					}
+					*req = r
+					return err
+				}
+			}
+		default:
+			return nil, fmt.Errorf("invalid hook_timing.timeouts.hook: %s can not have a timeout", v.Hook)
+		}
+	}
+	return rs, nil
+}
@@ -0,0 +1,402 @@
+package server
+
+import (
+	"context"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/DrmagicE/gmqtt"
+	"github.com/DrmagicE/gmqtt/pkg/packets"
+)
+
+// HookLatencyBuckets is the upper bounds in seconds of the hook latency histogram buckets.
+var HookLatencyBuckets = []float64{0.0001, 0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5}
+
+// HookStats is the latency statistics of a hook of a plugin.
+type HookStats struct {
+	Plugin string
+	Hook   string
+	// Buckets is the cumulative count of the calls whose latency is less than or equal to the corresponding HookLatencyBuckets.
+	Buckets []uint64
+	// Count is the total number of calls.
+	Count uint64
+	// SumSeconds is the total latency of all calls.
+	SumSeconds float64
+}
+
+// hookElapsedKey is the context key of the time spent by the previous hooks in the chain.
+type hookElapsedKey struct{}
+
+// hookLatency records the latency of a hook of a plugin.
+type hookLatency struct {
+	plugin string
+	hook   string
+	// buckets is not cumulative, the last one is for +Inf.
+	buckets  []uint64
+	count    uint64
+	sumNanos uint64
+}
+
+// start starts timing the hook, the returned context must be passed to the hook,
+// so the time spent by the previous hooks in the chain can be excluded.
+func (l *hookLatency) start(ctx context.Context) (context.Context, func()) {
+	parent, _ := ctx.Value(hookElapsedKey{}).(*int64)
+	child := new(int64)
+	begin := time.Now()
+	return context.WithValue(ctx, hookElapsedKey{}, child), func() {
+		total := time.Since(begin)
+		if parent != nil {
+			atomic.AddInt64(parent, int64(total))
+		}
+		l.observe(total - time.Duration(atomic.LoadInt64(child)))
+	}
+}
+
+func (l *hookLatency) observe(d time.Duration) {
+	i := sort.SearchFloat64s(HookLatencyBuckets, d.Seconds())
+	atomic.AddUint64(&l.buckets[i], 1)
+	atomic.AddUint64(&l.count, 1)
+	atomic.AddUint64(&l.sumNanos, uint64(d))
+}
+
+func (l *hookLatency) stats() HookStats {
+	s := HookStats{
+		Plugin:     l.plugin,
+		Hook:       l.hook,
+		Buckets:    make([]uint64, len(HookLatencyBuckets)),
+		Count:      atomic.LoadUint64(&l.count),
+		SumSeconds: time.Duration(atomic.LoadUint64(&l.sumNanos)).Seconds(),
+	}
+	var cumulative uint64
+	for i := range s.Buckets {
+		cumulative += atomic.LoadUint64(&l.buckets[i])
+		s.Buckets[i] = cumulative
+	}
+	return s
+}
+
+// hookTimer records the latency of the hooks of each plugin.
+type hookTimer struct {
+	mu        sync.Mutex
+	latencies []*hookLatency
+}
+
+func (h *hookTimer) latency(plugin, hook string) *hookLatency {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	l := &hookLatency{
+		plugin:  plugin,
+		hook:    hook,
+		buckets: make([]uint64, len(HookLatencyBuckets)+1),
+	}
+	h.latencies = append(h.latencies, l)
+	return l
+}
+
+// getStats returns the latency statistics of all hooks, nil if the metrics is disabled.
+func (h *hookTimer) getStats() []HookStats {
+	if h == nil {
+		return nil
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	stats := make([]HookStats, 0, len(h.latencies))
+	for _, v := range h.latencies {
+		stats = append(stats, v.stats())
+	}
+	return stats
+}
+
+// timedHookWrapper wraps all hooks of the plugin to record the latency of them.
+func (h *hookTimer) timedHookWrapper(plugin string, hooks HookWrapper) HookWrapper {
+	if w := hooks.OnAcceptWrapper; w != nil {
+		l := h.latency(plugin, "OnAccept")
+		hooks.OnAcceptWrapper = func(pre OnAccept) OnAccept {
+			fn := w(pre)
+			return func(ctx context.Context, conn net.Conn) bool {
+				ctx, done := l.start(ctx)
+				defer done()
+				return fn(ctx, conn)
+			}
+		}
+	}
+	if w := hooks.OnStopWrapper; w != nil {
+		l := h.latency(plugin, "OnStop")
+		hooks.OnStopWrapper = func(pre OnStop) OnStop {
+			fn := w(pre)
+			return func(ctx context.Context) {
+				ctx, done := l.start(ctx)
+				defer done()
+				fn(ctx)
+			}
+		}
+	}
+	if w := hooks.OnBasicAuthWrapper; w != nil {
+		l := h.latency(plugin, "OnBasicAuth")
+		hooks.OnBasicAuthWrapper = func(pre OnBasicAuth) OnBasicAuth {
+			fn := w(pre)
+			return func(ctx context.Context, client Client, req *ConnectRequest) error {
+				ctx, done := l.start(ctx)
+				defer done()
+				return fn(ctx, client, req)
+			}
+		}
+	}
+	if w := hooks.OnEnhancedAuthWrapper; w != nil {
+		l := h.latency(plugin, "OnEnhancedAuth")
+		hooks.OnEnhancedAuthWrapper = func(pre OnEnhancedAuth) OnEnhancedAuth {
+			fn := w(pre)
+			return func(ctx context.Context, client Client, req *ConnectRequest) (*EnhancedAuthResponse, error) {
+				ctx, done := l.start(ctx)
+				defer done()
+				return fn(ctx, client, req)
+			}
+		}
+	}
+	if w := hooks.OnReAuthWrapper; w != nil {
+		l := h.latency(plugin, "OnReAuth")
+		hooks.OnReAuthWrapper = func(pre OnReAuth) OnReAuth {
+			fn := w(pre)
+			return func(ctx context.Context, client Client, auth *packets.Auth) (*AuthResponse, error) {
+				ctx, done := l.start(ctx)
+				defer done()
+				return fn(ctx, client, auth)
+			}
+		}
+	}
+	if w := hooks.OnConnectedWrapper; w != nil {
+		l := h.latency(plugin, "OnConnected")
+		hooks.OnConnectedWrapper = func(pre OnConnected) OnConnected {
+			fn := w(pre)
+			return func(ctx context.Context, client Client) {
+				ctx, done := l.start(ctx)
+				defer done()
+				fn(ctx, client)
+			}
+		}
+	}
+	if w := hooks.OnSessionCreatedWrapper; w != nil {
+		l := h.latency(plugin, "OnSessionCreated")
+		hooks.OnSessionCreatedWrapper = func(pre OnSessionCreated) OnSessionCreated {
+			fn := w(pre)
+			return func(ctx context.Context, client Client) {
+				ctx, done := l.start(ctx)
+				defer done()
+				fn(ctx, client)
+			}
+		}
+	}
+	if w := hooks.OnSessionResumedWrapper; w != nil {
+		l := h.latency(plugin, "OnSessionResumed")
+		hooks.OnSessionResumedWrapper = func(pre OnSessionResumed) OnSessionResumed {
+			fn := w(pre)
+			return func(ctx context.Context, client Client, queued int) {
+				ctx, done := l.start(ctx)
+				defer done()
+				fn(ctx, client, queued)
+			}
+		}
+	}
+	if w := hooks.OnSessionTerminatedWrapper; w != nil {
+		l := h.latency(plugin, "OnSessionTerminated")
+		hooks.OnSessionTerminatedWrapper = func(pre OnSessionTerminated) OnSessionTerminated {
+			fn := w(pre)
+			return func(ctx context.Context, clientID string, reason SessionTerminatedReason) {
+				ctx, done := l.start(ctx)
+				defer done()
+				fn(ctx, clientID, reason)
+			}
+		}
+	}
+	if w := hooks.OnSessionExpiredWrapper; w != nil {
+		l := h.latency(plugin, "OnSessionExpired")
+		hooks.OnSessionExpiredWrapper = func(pre OnSessionExpired) OnSessionExpired {
+			fn := w(pre)
+			return func(ctx context.Context, clientID string, session *gmqtt.Session) {
+				ctx, done := l.start(ctx)
+				defer done()
+				fn(ctx, clientID, session)
+			}
+		}
+	}
+	if w := hooks.OnOfflineBacklogWrapper; w != nil {
+		l := h.latency(plugin, "OnOfflineBacklog")
+		hooks.OnOfflineBacklogWrapper = func(pre OnOfflineBacklog) OnOfflineBacklog {
+			fn := w(pre)
+			return func(ctx context.Context, clientID string, queued int) {
+				ctx, done := l.start(ctx)
+				defer done()
+				fn(ctx, clientID, queued)
+			}
+		}
+	}
+	if w := hooks.OnSessionTakenOverWrapper; w != nil {
+		l := h.latency(plugin, "OnSessionTakenOver")
+		hooks.OnSessionTakenOverWrapper = func(pre OnSessionTakenOver) OnSessionTakenOver {
+			fn := w(pre)
+			return func(ctx context.Context, client Client, info *TakeoverInfo) {
+				ctx, done := l.start(ctx)
+				defer done()
+				fn(ctx, client, info)
+			}
+		}
+	}
+	if w := hooks.OnSubscribeWrapper; w != nil {
+		l := h.latency(plugin, "OnSubscribe")
+		hooks.OnSubscribeWrapper = func(pre OnSubscribe) OnSubscribe {
+			fn := w(pre)
+			return func(ctx context.Context, client Client, req *SubscribeRequest) error {
+				ctx, done := l.start(ctx)
+				defer done()
+				return fn(ctx, client, req)
+			}
+		}
+	}
+	if w := hooks.OnSubscribedWrapper; w != nil {
+		l := h.latency(plugin, "OnSubscribed")
+		hooks.OnSubscribedWrapper = func(pre OnSubscribed) OnSubscribed {
+			fn := w(pre)
+			return func(ctx context.Context, client Client, subscription *gmqtt.Subscription) {
+				ctx, done := l.start(ctx)
+				defer done()
+				fn(ctx, client, subscription)
+			}
+		}
+	}
+	if w := hooks.OnUnsubscribeWrapper; w != nil {
+		l := h.latency(plugin, "OnUnsubscribe")
+		hooks.OnUnsubscribeWrapper = func(pre OnUnsubscribe) OnUnsubscribe {
+			fn := w(pre)
+			return func(ctx context.Context, client Client, req *UnsubscribeRequest) error {
+				ctx, done := l.start(ctx)
+				defer done()
+				return fn(ctx, client, req)
+			}
+		}
+	}
+	if w := hooks.OnUnsubscribedWrapper; w != nil {
+		l := h.latency(plugin, "OnUnsubscribed")
+		hooks.OnUnsubscribedWrapper = func(pre OnUnsubscribed) OnUnsubscribed {
+			fn := w(pre)
+			return func(ctx context.Context, client Client, topicName string) {
+				ctx, done := l.start(ctx)
+				defer done()
+				fn(ctx, client, topicName)
+			}
+		}
+	}
+	if w := hooks.OnMsgArrivedWrapper; w != nil {
+		l := h.latency(plugin, "OnMsgArrived")
+		hooks.OnMsgArrivedWrapper = func(pre OnMsgArrived) OnMsgArrived {
+			fn := w(pre)
+			return func(ctx context.Context, client Client, req *MsgArrivedRequest) error {
+				ctx, done := l.start(ctx)
+				defer done()
+				return fn(ctx, client, req)
+			}
+		}
+	}
+	if w := hooks.OnMsgDroppedWrapper; w != nil {
+		l := h.latency(plugin, "OnMsgDropped")
+		hooks.OnMsgDroppedWrapper = func(pre OnMsgDropped) OnMsgDropped {
+			fn := w(pre)
+			return func(ctx context.Context, clientID string, msg *gmqtt.Message, err error) {
+				ctx, done := l.start(ctx)
+				defer done()
+				fn(ctx, clientID, msg, err)
+			}
+		}
+	}
+	if w := hooks.OnDeliveredWrapper; w != nil {
+		l := h.latency(plugin, "OnDelivered")
+		hooks.OnDeliveredWrapper = func(pre OnDelivered) OnDelivered {
+			fn := w(pre)
+			return func(ctx context.Context, client Client, msg *gmqtt.Message) {
+				ctx, done := l.start(ctx)
+				defer done()
+				fn(ctx, client, msg)
+			}
+		}
+	}
+	if w := hooks.OnDeliveryReportWrapper; w != nil {
+		l := h.latency(plugin, "OnDeliveryReport")
+		hooks.OnDeliveryReportWrapper = func(pre OnDeliveryReport) OnDeliveryReport {
+			fn := w(pre)
+			return func(ctx context.Context, client Client, report *DeliveryReport) {
+				ctx, done := l.start(ctx)
+				defer done()
+				fn(ctx, client, report)
+			}
+		}
+	}
+	if w := hooks.OnClosedWrapper; w != nil {
+		l := h.latency(plugin, "OnClosed")
+		hooks.OnClosedWrapper = func(pre OnClosed) OnClosed {
+			fn := w(pre)
+			return func(ctx context.Context, client Client, reason *CloseReason) {
+				ctx, done := l.start(ctx)
+				defer done()
+				fn(ctx, client, reason)
+			}
+		}
+	}
+	if w := hooks.OnWillPublishWrapper; w != nil {
+		l := h.latency(plugin, "OnWillPublish")
+		hooks.OnWillPublishWrapper = func(pre OnWillPublish) OnWillPublish {
+			fn := w(pre)
+			return func(ctx context.Context, clientID string, req *WillMsgRequest) {
+				ctx, done := l.start(ctx)
+				defer done()
+				fn(ctx, clientID, req)
+			}
+		}
+	}
+	if w := hooks.OnWillPublishedWrapper; w != nil {
+		l := h.latency(plugin, "OnWillPublished")
+		hooks.OnWillPublishedWrapper = func(pre OnWillPublished) OnWillPublished {
+			fn := w(pre)
+			return func(ctx context.Context, clientID string, msg *gmqtt.Message) {
+				ctx, done := l.start(ctx)
+				defer done()
+				fn(ctx, clientID, msg)
+			}
+		}
+	}
+	if w := hooks.OnPacketReceivedWrapper; w != nil {
+		l := h.latency(plugin, "OnPacketReceived")
+		hooks.OnPacketReceivedWrapper = func(pre OnPacketReceived) OnPacketReceived {
+			fn := w(pre)
+			return func(ctx context.Context, client Client, packet packets.Packet) error {
+				ctx, done := l.start(ctx)
+				defer done()
+				return fn(ctx, client, packet)
+			}
+		}
+	}
+	if w := hooks.OnPacketSendWrapper; w != nil {
+		l := h.latency(plugin, "OnPacketSend")
+		hooks.OnPacketSendWrapper = func(pre OnPacketSend) OnPacketSend {
+			fn := w(pre)
+			return func(ctx context.Context, client Client, packet packets.Packet) error {
+				ctx, done := l.start(ctx)
+				defer done()
+				return fn(ctx, client, packet)
+			}
+		}
+	}
+	if w := hooks.OnPSKLookupWrapper; w != nil {
+		l := h.latency(plugin, "OnPSKLookup")
+		hooks.OnPSKLookupWrapper = func(pre OnPSKLookup) OnPSKLookup {
+			fn := w(pre)
+			return func(ctx context.Context, identity string) ([]byte, error) {
+				ctx, done := l.start(ctx)
+				defer done()
+				return fn(ctx, identity)
+			}
+		}
+	}
+	return hooks
+}
@@ -0,0 +1,96 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DrmagicE/gmqtt"
+	"github.com/DrmagicE/gmqtt/config"
+	"github.com/DrmagicE/gmqtt/pkg/codes"
+)
+
+func TestHookTimer_timedHookWrapper(t *testing.T) {
+	a := assert.New(t)
+	h := &hookTimer{}
+	sleepWrapper := func(d time.Duration) OnConnectedWrapper {
+		return func(pre OnConnected) OnConnected {
+			return func(ctx context.Context, client Client) {
+				pre(ctx, client)
+				time.Sleep(d)
+			}
+		}
+	}
+	slow := h.timedHookWrapper("slow", HookWrapper{OnConnectedWrapper: sleepWrapper(50 * time.Millisecond)})
+	fast := h.timedHookWrapper("fast", HookWrapper{OnConnectedWrapper: sleepWrapper(0)})
+	// the fast plugin is the outer one, the time spent by the slow plugin must not be counted in.
+	onConnected := fast.OnConnectedWrapper(slow.OnConnectedWrapper(func(ctx context.Context, client Client) {}))
+	onConnected(context.Background(), nil)
+
+	stats := h.getStats()
+	a.Len(stats, 2)
+	a.Equal("slow", stats[0].Plugin)
+	a.Equal("OnConnected", stats[0].Hook)
+	a.EqualValues(1, stats[0].Count)
+	a.True(stats[0].SumSeconds >= 0.05)
+	a.Len(stats[0].Buckets, len(HookLatencyBuckets))
+	a.EqualValues(0, stats[0].Buckets[0])
+	a.EqualValues(1, stats[0].Buckets[len(HookLatencyBuckets)-1])
+
+	a.Equal("fast", stats[1].Plugin)
+	a.EqualValues(1, stats[1].Count)
+	a.True(stats[1].SumSeconds < 0.05)
+
+	var nilTimer *hookTimer
+	a.Nil(nilTimer.getStats())
+}
+
+func TestServer_applyHookTimeouts(t *testing.T) {
+	a := assert.New(t)
+	block := make(chan struct{})
+	defer close(block)
+	srv := &server{}
+	srv.hooks.OnBasicAuth = func(ctx context.Context, client Client, req *ConnectRequest) error {
+		req.Options.MaxInflight = 1
+		if req.Options.SessionExpiry == 0 {
+			<-ctx.Done()
+		}
+		return nil
+	}
+	srv.hooks.OnMsgArrived = func(ctx context.Context, client Client, req *MsgArrivedRequest) error {
+		req.Message.Topic = "modified"
+		<-block
+		return nil
+	}
+	timeouts, err := srv.applyHookTimeouts([]config.HookTimeout{
+		{Hook: "OnBasicAuth", Timeout: 10 * time.Millisecond, Policy: config.HookFailClosed},
+		{Hook: "OnMsgArrived", Timeout: 10 * time.Millisecond, Policy: config.HookFailOpen},
+	})
+	a.Nil(err)
+	a.Len(timeouts, 2)
+
+	// fail closed
+	opts := &AuthOptions{}
+	err = srv.hooks.OnBasicAuth(context.Background(), nil, &ConnectRequest{Options: opts})
+	a.Equal(codes.ServerUnavailable, converError(err).Code)
+	a.EqualValues(0, opts.MaxInflight)
+	a.EqualValues(1, timeouts[0].total)
+
+	// finished in time, the modifications take effect.
+	opts = &AuthOptions{SessionExpiry: 1}
+	a.Nil(srv.hooks.OnBasicAuth(context.Background(), nil, &ConnectRequest{Options: opts}))
+	a.EqualValues(1, opts.MaxInflight)
+
+	// fail open, the modifications are discarded.
+	req := &MsgArrivedRequest{Message: &gmqtt.Message{Topic: "a"}}
+	a.Nil(srv.hooks.OnMsgArrived(context.Background(), nil, req))
+	a.Equal("a", req.Message.Topic)
+	a.EqualValues(1, timeouts[1].total)
+
+	_, err = srv.applyHookTimeouts([]config.HookTimeout{
+		{Hook: "OnConnected", Timeout: time.Second, Policy: config.HookFailOpen},
+	})
+	a.NotNil(err)
+}
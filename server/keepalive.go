@@ -0,0 +1,58 @@
+package server
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// timerWheelTick is the tick duration of the server timer wheel, which is also the precision of the keepalive timeout.
+	timerWheelTick = 100 * time.Millisecond
+	// timerWheelSlots is the number of slots of the server timer wheel.
+	timerWheelSlots = 1024
+)
+
+// keepAliveDuration returns the maximum idle duration of the client.
+// The server will close the client if no packet has been received for 1.5 times the KeepAlive time.
+func (client *client) keepAliveDuration() time.Duration {
+	keepAlive := client.opts.KeepAlive
+	return time.Duration(keepAlive/2+keepAlive) * time.Second
+}
+
+// touch records the time that the last packet was received.
+func (client *client) touch() {
+	atomic.StoreInt64(&client.lastRead, time.Now().UnixNano())
+}
+
+// startKeepAlive starts the keepalive timer of the client.
+// Instead of resetting a timer for every packet, the timer checks the last read time when it expires
+// and reschedules itself if the client is still active.
+func (client *client) startKeepAlive() {
+	if client.opts.KeepAlive == 0 {
+		return
+	}
+	client.touch()
+	client.keepAliveTimer = client.server.timerWheel.NewTimer(client.checkKeepAlive)
+	client.keepAliveTimer.Reset(client.keepAliveDuration())
+}
+
+// stopKeepAlive stops the keepalive timer of the client.
+func (client *client) stopKeepAlive() {
+	if client.keepAliveTimer != nil {
+		client.keepAliveTimer.Stop()
+	}
+}
+
+func (client *client) checkKeepAlive() {
+	d := client.keepAliveDuration()
+	idle := time.Since(time.Unix(0, atomic.LoadInt64(&client.lastRead)))
+	if idle < d {
+		client.keepAliveTimer.Reset(d - idle)
+		return
+	}
+	// unblock the readLoop, or the drain process which may be blocked by a partial packet, with a timeout error.
+	_ = client.rwc.SetReadDeadline(time.Now())
+	if client.poll != nil {
+		client.pollKeepAliveTimeout()
+	}
+}
@@ -0,0 +1,241 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+
+	"github.com/DrmagicE/gmqtt"
+	"github.com/DrmagicE/gmqtt/config"
+	"github.com/DrmagicE/gmqtt/pkg/packets"
+	"github.com/DrmagicE/gmqtt/pkg/tlspsk"
+	"github.com/DrmagicE/gmqtt/pkg/tlsticket"
+)
+
+// ListenerOptions is the listener scoped options which override the global config.
+type ListenerOptions struct {
+	// AllowAnonymous overrides the mqtt.allow_anonymous config for the listener if it is set.
+	AllowAnonymous *bool
+	// Label is the listener label in the RequestInfo, defaults to the listener address.
+	Label string
+	// MountPoint is the default mount point of the clients connected to the listener, see AuthOptions.MountPoint.
+	MountPoint string
+	// RetainAsPublished forces (true) or forbids (false) the Retain As Published subscription option if it is set.
+	RetainAsPublished *bool
+	// NoLocal forces (true) or forbids (false) the No Local subscription option if it is set.
+	NoLocal *bool
+	// Codec is the name of the ConnectionCodec which handles the proprietary framing of the connections, empty means none.
+	Codec string
+	// PSK enables TLS-PSK on the listener if it is set, the PSK identities are resolved by the OnPSKLookup hook.
+	// The listener must not be a TLS listener.
+	PSK *config.PSKOptions
+}
+
+type listener struct {
+	net.Listener
+	opts ListenerOptions
+	// config is the config which the listener is created from, nil if it is created by NewListener.
+	config *config.ListenerConfig
+	// rotator is the session ticket key rotator, nil if the rotation is disabled.
+	rotator *tlsticket.Rotator
+}
+
+// NewListener wraps the net.Listener with the listener scoped options.
+// The returned listener can be passed to WithTCPListener.
+func NewListener(ln net.Listener, opts ListenerOptions) net.Listener {
+	return &listener{
+		Listener: ln,
+		opts:     opts,
+	}
+}
+
+// isAnonymous reports whether the client connects without any credential.
+func (client *client) isAnonymous(conn *packets.Connect) bool {
+	if len(conn.Username) != 0 {
+		return false
+	}
+	return packets.IsVersion3X(conn.Version) || conn.Properties == nil || conn.Properties.AuthMethod == nil
+}
+
+func (client *client) allowAnonymous() bool {
+	if client.allowAnonymousOverride != nil {
+		return *client.allowAnonymousOverride
+	}
+	return client.config.MQTT.AllowAnonymous
+}
+
+// overrideSubOptions applies the subscription options which are forced or forbidden by the listener.
+func (client *client) overrideSubOptions(sub *gmqtt.Subscription) {
+	if client.retainAsPublishedOverride != nil {
+		sub.RetainAsPublished = *client.retainAsPublishedOverride
+	}
+	if client.noLocalOverride != nil {
+		sub.NoLocal = *client.noLocalOverride
+	}
+}
+
+// TLSConnectionState returns the TLS connection state of the client connection,
+// ok is false if the client is not connected via TLS.
+func TLSConnectionState(conn net.Conn) (state tls.ConnectionState, ok bool) {
+	if ws, isWs := conn.(*wsConn); isWs {
+		conn = ws.Conn
+	}
+	conn = unwrapConn(conn)
+	if c, isTLS := conn.(*tls.Conn); isTLS {
+		return c.ConnectionState(), true
+	}
+	return
+}
+
+// PSKIdentity returns the PSK identity of the client connection,
+// ok is false if the client is not connected via TLS-PSK.
+func PSKIdentity(conn net.Conn) (identity string, ok bool) {
+	conn = unwrapConn(conn)
+	if c, isPSK := conn.(*tlspsk.Conn); isPSK {
+		return c.ConnectionState().Identity, true
+	}
+	return
+}
+
+// pskServer wraps the connection with the TLS-PSK server, the identity is resolved by the OnPSKLookup hook.
+func (srv *server) pskServer(conn net.Conn, cfg *tlspsk.Config, label string) net.Conn {
+	ctx := context.WithValue(context.Background(), requestInfoKey{}, RequestInfo{
+		Listener:   label,
+		RemoteAddr: conn.RemoteAddr().String(),
+	})
+	c := *cfg
+	c.GetKey = func(identity string) ([]byte, error) {
+		return srv.hooks.OnPSKLookup(ctx, identity)
+	}
+	return tlspsk.Server(conn, &c)
+}
+
+// newPSKConfig returns the TLS-PSK config of the listener, nil if psk is not enabled.
+func (srv *server) newPSKConfig(opts *config.PSKOptions) (*tlspsk.Config, error) {
+	if opts == nil {
+		return nil, nil
+	}
+	if srv.hooks.OnPSKLookup == nil {
+		return nil, errors.New("psk is enabled, but there is no plugin providing the OnPSKLookup hook")
+	}
+	suites, err := opts.CipherSuiteIDs()
+	if err != nil {
+		return nil, err
+	}
+	return &tlspsk.Config{
+		IdentityHint: opts.IdentityHint,
+		CipherSuites: suites,
+	}, nil
+}
+
+// NewListenerFromConfig creates the listener from the listener config.
+// It returns a TCP listener which can be passed to WithTCPListener,
+// or a websocket server which can be passed to WithWebsocketServer if cfg.Websocket is set.
+func NewListenerFromConfig(cfg config.ListenerConfig, crypto config.Crypto) (ln net.Listener, ws *WsServer, err error) {
+	var tlsCfg *tls.Config
+	var rotator *tlsticket.Rotator
+	if cfg.Codec != "" {
+		if cfg.Websocket != nil {
+			return nil, nil, fmt.Errorf("the codec of listener %s is not supported by websocket", cfg.Address)
+		}
+		if _, err = getConnectionCodec(cfg.Codec); err != nil {
+			return nil, nil, err
+		}
+	}
+	if cfg.PSK != nil && crypto.FIPS() {
+		return nil, nil, fmt.Errorf("the psk of listener %s is not allowed by the fips crypto policy", cfg.Address)
+	}
+	if cfg.TLSOptions != nil {
+		tlsCfg, rotator, err = newListenerTLSConfig(cfg.TLSOptions, crypto)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	if cfg.Websocket != nil {
+		return nil, &WsServer{
+			Server:            &http.Server{Addr: cfg.Address},
+			Path:              cfg.Websocket.Path,
+			TLSConfig:         tlsCfg,
+			AllowAnonymous:    cfg.AllowAnonymous,
+			Label:             cfg.Label,
+			MountPoint:        cfg.MountPoint,
+			RetainAsPublished: cfg.RetainAsPublished,
+			NoLocal:           cfg.NoLocal,
+			config:            &cfg,
+			rotator:           rotator,
+		}, nil
+	}
+	ln, err = net.Listen("tcp", cfg.Address)
+	if err != nil {
+		stopRotator(rotator)
+		return nil, nil, err
+	}
+	if cfg.Probe != nil {
+		ln = newProbeListener(ln, *cfg.Probe)
+	}
+	if tlsCfg != nil {
+		ln = tls.NewListener(ln, tlsCfg)
+	}
+	return &listener{
+		Listener: ln,
+		opts: ListenerOptions{
+			AllowAnonymous:    cfg.AllowAnonymous,
+			Label:             cfg.Label,
+			MountPoint:        cfg.MountPoint,
+			RetainAsPublished: cfg.RetainAsPublished,
+			NoLocal:           cfg.NoLocal,
+			Codec:             cfg.Codec,
+			PSK:               cfg.PSK,
+		},
+		config:  &cfg,
+		rotator: rotator,
+	}, nil, nil
+}
+
+func newListenerTLSConfig(opts *config.TLSOptions, crypto config.Crypto) (*tls.Config, *tlsticket.Rotator, error) {
+	cert, err := tls.LoadX509KeyPair(opts.Cert, opts.Key)
+	if err != nil {
+		return nil, nil, err
+	}
+	tlsCfg := &tls.Config{
+		Certificates:           []tls.Certificate{cert},
+		SessionTicketsDisabled: opts.SessionTicket.Disable,
+	}
+	if opts.CACert != "" {
+		b, err := ioutil.ReadFile(opts.CACert)
+		if err != nil {
+			return nil, nil, err
+		}
+		tlsCfg.ClientCAs = x509.NewCertPool()
+		if !tlsCfg.ClientCAs.AppendCertsFromPEM(b) {
+			return nil, nil, fmt.Errorf("invalid cacert: %s", opts.CACert)
+		}
+		// verify the client certificate if given, so that the plugins can rely on the verified certificate.
+		tlsCfg.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	if opts.Verify {
+		tlsCfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	if err = crypto.ApplyTLS(tlsCfg); err != nil {
+		return nil, nil, err
+	}
+	var rotator *tlsticket.Rotator
+	if !opts.SessionTicket.Disable && opts.SessionTicket.RotationInterval != 0 {
+		rotator, err = tlsticket.NewRotator(tlsCfg, opts.SessionTicket.RotationInterval, opts.SessionTicket.RetainedKeys)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return tlsCfg, rotator, nil
+}
+
+func stopRotator(r *tlsticket.Rotator) {
+	if r != nil {
+		r.Stop()
+	}
+}
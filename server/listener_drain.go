@@ -0,0 +1,196 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/DrmagicE/gmqtt/pkg/codes"
+	"github.com/DrmagicE/gmqtt/pkg/packets"
+)
+
+var (
+	// ErrListenerNotDraining is returned by ListenerService.DrainStatus if the listener is not draining.
+	ErrListenerNotDraining = errors.New("listener is not draining")
+	// ErrListenerMigrating is returned by ListenerService.Drain if the clients of the listener are being migrated.
+	ErrListenerMigrating = errors.New("listener is migrating")
+)
+
+// DrainOptions is the options of draining a listener.
+type DrainOptions struct {
+	// Migrate indicates whether to disconnect the clients of the listener.
+	// If false, the clients stay connected until they disconnect by themselves.
+	Migrate bool
+	// ServerReference is the other server that the migrated V5 clients are advised to use permanently,
+	// carried in the DISCONNECT packet with 0x9D (Server moved). If empty, 0x8B (Server shutting down) is sent.
+	ServerReference string
+	// Rate is the maximum number of the clients disconnected per second, 0 means no limit.
+	// It spreads the reconnections of the migrated clients.
+	Rate int
+}
+
+// DrainStatus is the progress of a draining listener.
+type DrainStatus struct {
+	Address   string
+	StartedAt time.Time
+	// Migrating indicates whether the clients of the listener are being disconnected.
+	Migrating bool
+	// Remaining is the number of the clients which are still connected to the listener.
+	Remaining int
+	// Migrated is the number of the clients which have been disconnected by the migration.
+	Migrated int
+}
+
+type listenerDrain struct {
+	startedAt time.Time
+	migrated  int64
+	// migrating is set to 1 when the migration starts, it is never reset.
+	migrating int32
+	stopOnce  sync.Once
+	stopped   chan struct{}
+}
+
+func newListenerDrain() *listenerDrain {
+	return &listenerDrain{
+		startedAt: time.Now(),
+		stopped:   make(chan struct{}),
+	}
+}
+
+// stop stops the migration, it is nil-safe.
+func (d *listenerDrain) stop() {
+	if d == nil {
+		return
+	}
+	d.stopOnce.Do(func() {
+		close(d.stopped)
+	})
+}
+
+// migrate disconnects the client of the draining listener.
+// The V5 client is sent the DISCONNECT packet and the connection is closed by the write loop once it is written.
+func (client *client) migrate(serverReference string) {
+	if !atomic.CompareAndSwapInt32(&client.migrated, 0, 1) {
+		return
+	}
+	if client.version != packets.Version5 || !client.IsConnected() {
+		client.Close()
+		return
+	}
+	disconnect := &packets.Disconnect{
+		Version: packets.Version5,
+		Code:    codes.ServerShuttingDown,
+	}
+	if serverReference != "" {
+		disconnect.Code = codes.ServerMoved
+		disconnect.Properties = &packets.Properties{
+			ServerReference: []byte(serverReference),
+		}
+	}
+	// the write loop may be blocked by the slow connection.
+	time.AfterFunc(disconnectWriteTimeout, client.Close)
+	client.write(disconnect)
+}
+
+// listenerClients returns the clients accepted by the listener of the bound address.
+func (srv *server) listenerClients(addr string) []*client {
+	var rs []*client
+	srv.registry.iterate(func(s *registryShard) bool {
+		for _, c := range s.clients {
+			if c.listenerAddr == addr {
+				rs = append(rs, c)
+			}
+		}
+		return true
+	})
+	return rs
+}
+
+// migrateClients disconnects the clients of the draining listener at the rate of the options.
+// The clients which are still connecting when the migration starts are not disconnected.
+func (srv *server) migrateClients(addr string, d *listenerDrain, opts DrainOptions) {
+	var interval time.Duration
+	if opts.Rate > 0 {
+		interval = time.Second / time.Duration(opts.Rate)
+	}
+	clients := srv.listenerClients(addr)
+	zaplog.Info("migrating the clients of the draining listener",
+		zap.String("address", addr),
+		zap.Int("clients", len(clients)),
+		zap.String("server_reference", opts.ServerReference))
+	for _, c := range clients {
+		select {
+		case <-d.stopped:
+			return
+		case <-srv.exitChan:
+			return
+		default:
+		}
+		c.migrate(opts.ServerReference)
+		atomic.AddInt64(&d.migrated, 1)
+		if interval > 0 {
+			select {
+			case <-d.stopped:
+				return
+			case <-srv.exitChan:
+				return
+			case <-time.After(interval):
+			}
+		}
+	}
+	zaplog.Info("the clients of the draining listener migrated", zap.String("address", addr))
+}
+
+func (l *listenerService) Drain(address string, opts DrainOptions) error {
+	if opts.Rate < 0 {
+		return errors.New("invalid rate")
+	}
+	l.srv.listenersMu.Lock()
+	defer l.srv.listenersMu.Unlock()
+	if err := l.checkRunning(); err != nil {
+		return err
+	}
+	i := l.indexLocked(address)
+	if i == -1 {
+		return ErrListenerNotFound
+	}
+	rl := l.srv.listeners[i]
+	if rl.drain == nil {
+		rl.drain = newListenerDrain()
+		rl.stopAccepting(context.Background())
+		zaplog.Info("listener draining", zap.String("address", address))
+	}
+	if !opts.Migrate {
+		return nil
+	}
+	if !atomic.CompareAndSwapInt32(&rl.drain.migrating, 0, 1) {
+		return ErrListenerMigrating
+	}
+	go l.srv.migrateClients(rl.addr, rl.drain, opts)
+	return nil
+}
+
+func (l *listenerService) DrainStatus(address string) (DrainStatus, error) {
+	l.srv.listenersMu.Lock()
+	i := l.indexLocked(address)
+	if i == -1 {
+		l.srv.listenersMu.Unlock()
+		return DrainStatus{}, ErrListenerNotFound
+	}
+	d, addr := l.srv.listeners[i].drain, l.srv.listeners[i].addr
+	l.srv.listenersMu.Unlock()
+	if d == nil {
+		return DrainStatus{}, ErrListenerNotDraining
+	}
+	return DrainStatus{
+		Address:   address,
+		StartedAt: d.startedAt,
+		Migrating: atomic.LoadInt32(&d.migrating) == 1,
+		Remaining: len(l.srv.listenerClients(addr)),
+		Migrated:  int(atomic.LoadInt64(&d.migrated)),
+	}, nil
+}
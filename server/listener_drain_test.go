@@ -0,0 +1,101 @@
+package server
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DrmagicE/gmqtt/config"
+	"github.com/DrmagicE/gmqtt/pkg/codes"
+	"github.com/DrmagicE/gmqtt/pkg/packets"
+)
+
+func TestListenerService_Drain(t *testing.T) {
+	a := assert.New(t)
+	srv := defaultServer()
+	ls := srv.ListenerService()
+	atomic.StoreInt32(&srv.status, serverStatusStarted)
+	tcp := config.ListenerConfig{Address: freeAddr(t)}
+	a.Nil(ls.Add(tcp))
+
+	a.Equal(ErrListenerNotFound, ls.Drain("127.0.0.1:1", DrainOptions{}))
+	_, err := ls.DrainStatus(tcp.Address)
+	a.Equal(ErrListenerNotDraining, err)
+
+	// a client accepted by the listener
+	c, err := srv.newClient(noopConn{})
+	a.Nil(err)
+	c.opts.ClientID = "cid"
+	c.version = packets.Version311
+	srv.listenersMu.Lock()
+	c.listenerAddr = srv.listeners[0].addr
+	srv.listenersMu.Unlock()
+	srv.registry.shard("cid").clients["cid"] = c
+
+	a.Nil(ls.Drain(tcp.Address, DrainOptions{}))
+	_, err = net.Dial("tcp", tcp.Address)
+	a.NotNil(err)
+	// the draining listener stays in the list.
+	a.Equal([]config.ListenerConfig{tcp}, ls.List())
+	st, err := ls.DrainStatus(tcp.Address)
+	a.Nil(err)
+	a.False(st.Migrating)
+	a.Equal(1, st.Remaining)
+	a.False(c.isServerClosed())
+
+	a.Nil(ls.Drain(tcp.Address, DrainOptions{Migrate: true}))
+	a.Equal(ErrListenerMigrating, ls.Drain(tcp.Address, DrainOptions{Migrate: true}))
+	a.Eventually(func() bool {
+		st, _ = ls.DrainStatus(tcp.Address)
+		return st.Migrated == 1
+	}, time.Second, 10*time.Millisecond)
+	a.True(st.Migrating)
+	a.True(c.isServerClosed())
+	delete(srv.registry.shard("cid").clients, "cid")
+
+	// update restarts the listener.
+	a.Nil(ls.Update(tcp.Address, tcp))
+	_, err = ls.DrainStatus(tcp.Address)
+	a.Equal(ErrListenerNotDraining, err)
+	conn, err := net.Dial("tcp", tcp.Address)
+	a.Nil(err)
+	conn.Close()
+
+	a.Nil(ls.Drain(tcp.Address, DrainOptions{}))
+	a.Nil(ls.Remove(tcp.Address))
+	a.Nil(srv.Stop(context.Background()))
+}
+
+func TestClient_migrate(t *testing.T) {
+	a := assert.New(t)
+	srv := defaultServer()
+	c, err := srv.newClient(noopConn{})
+	a.Nil(err)
+	c.version = packets.Version5
+	c.setConnected(time.Now())
+
+	c.migrate("other:1883")
+	d := (<-c.out).(*packets.Disconnect)
+	a.Equal(codes.ServerMoved, d.Code)
+	a.Equal([]byte("other:1883"), d.Properties.ServerReference)
+	// migrated only once
+	c.migrate("other:1883")
+	a.Len(c.out, 0)
+
+	c.Close()
+	r := c.newCloseReason(nil)
+	a.Equal(CloseListenerDrained, r.Type)
+
+	c, err = srv.newClient(noopConn{})
+	a.Nil(err)
+	c.version = packets.Version5
+	c.setConnected(time.Now())
+	c.migrate("")
+	d = (<-c.out).(*packets.Disconnect)
+	a.Equal(codes.ServerShuttingDown, d.Code)
+	a.Nil(d.Properties)
+}
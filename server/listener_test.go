@@ -0,0 +1,149 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DrmagicE/gmqtt/config"
+	"github.com/DrmagicE/gmqtt/pkg/codes"
+	"github.com/DrmagicE/gmqtt/pkg/packets"
+	"github.com/DrmagicE/gmqtt/pkg/tlspsk"
+)
+
+func TestNewListener(t *testing.T) {
+	a := assert.New(t)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	a.NoError(err)
+	defer ln.Close()
+	allow := false
+	l := NewListener(ln, ListenerOptions{AllowAnonymous: &allow})
+	a.Equal(ln.Addr(), l.Addr())
+	a.Equal(&allow, l.(*listener).opts.AllowAnonymous)
+}
+
+func TestTLSConnectionState(t *testing.T) {
+	a := assert.New(t)
+	_, ok := TLSConnectionState(noopConn{})
+	a.False(ok)
+	_, ok = TLSConnectionState(&wsConn{Conn: noopConn{}})
+	a.False(ok)
+	_, ok = TLSConnectionState(tls.Server(noopConn{}, &tls.Config{}))
+	a.True(ok)
+	_, ok = TLSConnectionState(&wsConn{Conn: tls.Server(noopConn{}, &tls.Config{})})
+	a.True(ok)
+}
+
+func TestServer_pskServer(t *testing.T) {
+	a := assert.New(t)
+	srv := &server{}
+	_, err := srv.newPSKConfig(&config.PSKOptions{})
+	a.Error(err)
+
+	key := []byte("0123456789abcdef")
+	srv.hooks.OnPSKLookup = func(ctx context.Context, identity string) ([]byte, error) {
+		info, _ := RequestInfoFromContext(ctx)
+		a.Equal("psk", info.Listener)
+		if identity == "dev1" {
+			return key, nil
+		}
+		return nil, nil
+	}
+	cfg, err := srv.newPSKConfig(&config.PSKOptions{})
+	a.NoError(err)
+	a.Nil(cfg.CipherSuites)
+
+	c1, c2 := net.Pipe()
+	conn := srv.pskServer(c1, cfg, "psk")
+	defer conn.Close()
+	client := tlspsk.Client(c2, &tlspsk.Config{Identity: "dev1", Key: key})
+	defer client.Close()
+	go client.Write([]byte("ping"))
+	b := make([]byte, 4)
+	_, err = io.ReadFull(conn, b)
+	a.NoError(err)
+	a.Equal("ping", string(b))
+
+	identity, ok := PSKIdentity(conn)
+	a.True(ok)
+	a.Equal("dev1", identity)
+	_, ok = PSKIdentity(noopConn{})
+	a.False(ok)
+}
+
+func TestClient_connectHandler_allowAnonymous(t *testing.T) {
+	var tt = []struct {
+		name     string
+		global   bool
+		override *bool
+		connect  *packets.Connect
+		allowed  bool
+	}{
+		{
+			name:    "global_allow",
+			global:  true,
+			connect: &packets.Connect{Version: packets.Version311, ClientID: []byte("cid")},
+			allowed: true,
+		},
+		{
+			name:    "global_deny",
+			global:  false,
+			connect: &packets.Connect{Version: packets.Version311, ClientID: []byte("cid")},
+			allowed: false,
+		},
+		{
+			name:     "listener_allow",
+			global:   false,
+			override: func() *bool { b := true; return &b }(),
+			connect:  &packets.Connect{Version: packets.Version311, ClientID: []byte("cid")},
+			allowed:  true,
+		},
+		{
+			name:     "listener_deny",
+			global:   true,
+			override: func() *bool { b := false; return &b }(),
+			connect:  &packets.Connect{Version: packets.Version5, ClientID: []byte("cid"), Properties: &packets.Properties{}},
+			allowed:  false,
+		},
+		{
+			name:    "with_username",
+			global:  false,
+			connect: &packets.Connect{Version: packets.Version311, ClientID: []byte("cid"), Username: []byte("user")},
+			allowed: true,
+		},
+	}
+	for _, v := range tt {
+		t.Run(v.name, func(t *testing.T) {
+			a := assert.New(t)
+			cfg := config.DefaultConfig()
+			cfg.MQTT.AllowAnonymous = v.global
+			var authCalled bool
+			srv := &server{
+				config:   cfg,
+				registry: newRegistry(),
+				hooks: Hooks{
+					OnBasicAuth: func(ctx context.Context, client Client, req *ConnectRequest) error {
+						authCalled = true
+						return nil
+					},
+				},
+			}
+			c, err := srv.newClient(noopConn{})
+			a.NoError(err)
+			c.allowAnonymousOverride = v.override
+			_, _, err = c.connectHandler(v.connect)
+			if v.allowed {
+				a.NoError(err)
+				a.True(authCalled)
+				return
+			}
+			a.Equal(&codes.Error{Code: codes.NotAuthorized}, err)
+			// rejected before any auth plugin runs
+			a.False(authCalled)
+		})
+	}
+}
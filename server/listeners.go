@@ -0,0 +1,262 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+
+	"go.uber.org/zap"
+
+	"github.com/DrmagicE/gmqtt/config"
+)
+
+var (
+	// ErrListenerNotFound is returned by the ListenerService if there is no listener of the address.
+	ErrListenerNotFound = errors.New("listener not found")
+	// ErrListenerExists is returned by the ListenerService if the address is in use by another listener.
+	ErrListenerExists   = errors.New("listener already exists")
+	errServerNotRunning = errors.New("server is not running")
+)
+
+// runningListener is a TCP listener or a websocket server that is serving.
+type runningListener struct {
+	config config.ListenerConfig
+	// fromConfig indicates whether the listener is created from the config,
+	// only these listeners can be restored when the update fails, because the TLS settings of the others are unknown.
+	fromConfig bool
+	tcp        net.Listener
+	ws         *WsServer
+	// wsListener is the bound listener of the websocket server.
+	wsListener net.Listener
+	// addr is the bound address, which is carried by the accepted clients.
+	addr string
+	// closed indicates whether the listener has stopped accepting, it is guarded by the listenersMu of the server.
+	closed bool
+	// drain is the drain state, nil if the listener is not draining.
+	drain *listenerDrain
+}
+
+func newRunningTCPListener(ln net.Listener) *runningListener {
+	rl := &runningListener{tcp: ln}
+	l, ok := ln.(*listener)
+	if !ok {
+		rl.config = config.ListenerConfig{Address: ln.Addr().String()}
+		return rl
+	}
+	if l.config != nil {
+		rl.config = *l.config
+		rl.fromConfig = true
+		return rl
+	}
+	rl.config = config.ListenerConfig{
+		Address:           ln.Addr().String(),
+		AllowAnonymous:    l.opts.AllowAnonymous,
+		Label:             l.opts.Label,
+		MountPoint:        l.opts.MountPoint,
+		RetainAsPublished: l.opts.RetainAsPublished,
+		NoLocal:           l.opts.NoLocal,
+		Codec:             l.opts.Codec,
+		PSK:               l.opts.PSK,
+	}
+	return rl
+}
+
+func newRunningWsListener(ws *WsServer) *runningListener {
+	rl := &runningListener{ws: ws}
+	if ws.config != nil {
+		rl.config = *ws.config
+		rl.fromConfig = true
+		return rl
+	}
+	rl.config = config.ListenerConfig{
+		Address:           ws.Server.Addr,
+		Websocket:         &config.WebsocketOptions{Path: ws.Path},
+		AllowAnonymous:    ws.AllowAnonymous,
+		Label:             ws.Label,
+		MountPoint:        ws.MountPoint,
+		RetainAsPublished: ws.RetainAsPublished,
+		NoLocal:           ws.NoLocal,
+	}
+	return rl
+}
+
+// startListener starts serving the listener, the websocket server is bound before it returns.
+func (srv *server) startListener(l *runningListener) error {
+	if l.tcp != nil {
+		l.addr = l.tcp.Addr().String()
+		go srv.serveTCP(l.tcp)
+		return nil
+	}
+	ln, err := srv.listenWebSocket(l.ws)
+	if err != nil {
+		return err
+	}
+	l.wsListener = ln
+	l.addr = ln.Addr().String()
+	go srv.serveWebSocket(l.ws, ln)
+	return nil
+}
+
+// close stops the listener and the migration of its clients if it is draining.
+func (l *runningListener) close(ctx context.Context) {
+	l.drain.stop()
+	l.stopAccepting(ctx)
+}
+
+// stopAccepting closes the listener, the established connections are not affected.
+func (l *runningListener) stopAccepting(ctx context.Context) {
+	if l.closed {
+		return
+	}
+	l.closed = true
+	if l.tcp != nil {
+		l.tcp.Close()
+		if ln, ok := l.tcp.(*listener); ok {
+			stopRotator(ln.rotator)
+		}
+		return
+	}
+	// the websocket connections have been hijacked from the http server, so they are not closed by the shutdown.
+	l.ws.Server.Shutdown(ctx)
+	// close the listener explicitly in case the http server has not started serving,
+	// so that the address can be bound again once close returns.
+	l.wsListener.Close()
+	stopRotator(l.ws.rotator)
+}
+
+type listenerService struct {
+	srv *server
+}
+
+// newListener creates and starts the listener of the config.
+func (l *listenerService) newListener(cfg config.ListenerConfig) (*runningListener, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	ln, ws, err := NewListenerFromConfig(cfg, l.srv.config.Crypto)
+	if err != nil {
+		return nil, err
+	}
+	rl := &runningListener{config: cfg, fromConfig: true, tcp: ln, ws: ws}
+	if err = l.srv.startListener(rl); err != nil {
+		if ws != nil {
+			stopRotator(ws.rotator)
+		}
+		return nil, err
+	}
+	return rl, nil
+}
+
+// checkRunning must be called with the listenersMu held.
+func (l *listenerService) checkRunning() error {
+	select {
+	case <-l.srv.exitChan:
+		return errServerNotRunning
+	default:
+	}
+	if l.srv.Status() != serverStatusStarted {
+		return errServerNotRunning
+	}
+	return nil
+}
+
+func (l *listenerService) indexLocked(address string) int {
+	for k, v := range l.srv.listeners {
+		if v.config.Address == address {
+			return k
+		}
+	}
+	return -1
+}
+
+func (l *listenerService) List() []config.ListenerConfig {
+	l.srv.listenersMu.Lock()
+	defer l.srv.listenersMu.Unlock()
+	rs := make([]config.ListenerConfig, 0, len(l.srv.listeners))
+	for _, v := range l.srv.listeners {
+		rs = append(rs, v.config)
+	}
+	return rs
+}
+
+func (l *listenerService) Add(cfg config.ListenerConfig) error {
+	l.srv.listenersMu.Lock()
+	defer l.srv.listenersMu.Unlock()
+	if err := l.checkRunning(); err != nil {
+		return err
+	}
+	if l.indexLocked(cfg.Address) != -1 {
+		return ErrListenerExists
+	}
+	rl, err := l.newListener(cfg)
+	if err != nil {
+		return err
+	}
+	l.srv.listeners = append(l.srv.listeners, rl)
+	zaplog.Info("listener added", zap.String("address", cfg.Address))
+	return nil
+}
+
+func (l *listenerService) Update(address string, cfg config.ListenerConfig) error {
+	l.srv.listenersMu.Lock()
+	defer l.srv.listenersMu.Unlock()
+	if err := l.checkRunning(); err != nil {
+		return err
+	}
+	i := l.indexLocked(address)
+	if i == -1 {
+		return ErrListenerNotFound
+	}
+	old := l.srv.listeners[i]
+	if cfg.Address != address {
+		if l.indexLocked(cfg.Address) != -1 {
+			return ErrListenerExists
+		}
+		rl, err := l.newListener(cfg)
+		if err != nil {
+			return err
+		}
+		old.close(context.Background())
+		l.srv.listeners[i] = rl
+		zaplog.Info("listener updated", zap.String("address", address), zap.String("new_address", cfg.Address))
+		return nil
+	}
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	old.close(context.Background())
+	rl, err := l.newListener(cfg)
+	if err != nil {
+		var restored *runningListener
+		rerr := errors.New("the listener is not created from the config")
+		if old.fromConfig {
+			restored, rerr = l.newListener(old.config)
+		}
+		if rerr == nil {
+			l.srv.listeners[i] = restored
+		} else {
+			zaplog.Error("failed to restore the listener", zap.String("address", address), zap.Error(rerr))
+			l.srv.listeners = append(l.srv.listeners[:i], l.srv.listeners[i+1:]...)
+		}
+		return err
+	}
+	l.srv.listeners[i] = rl
+	zaplog.Info("listener updated", zap.String("address", address))
+	return nil
+}
+
+func (l *listenerService) Remove(address string) error {
+	l.srv.listenersMu.Lock()
+	defer l.srv.listenersMu.Unlock()
+	if err := l.checkRunning(); err != nil {
+		return err
+	}
+	i := l.indexLocked(address)
+	if i == -1 {
+		return ErrListenerNotFound
+	}
+	l.srv.listeners[i].close(context.Background())
+	l.srv.listeners = append(l.srv.listeners[:i], l.srv.listeners[i+1:]...)
+	zaplog.Info("listener removed", zap.String("address", address))
+	return nil
+}
@@ -0,0 +1,75 @@
+package server
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DrmagicE/gmqtt/config"
+)
+
+func freeAddr(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	return ln.Addr().String()
+}
+
+func TestListenerService(t *testing.T) {
+	a := assert.New(t)
+	srv := defaultServer()
+	ls := srv.ListenerService()
+	tcp := config.ListenerConfig{Address: freeAddr(t), Label: "tcp"}
+	a.Equal(errServerNotRunning, ls.Add(tcp))
+
+	atomic.StoreInt32(&srv.status, serverStatusStarted)
+	a.Nil(ls.Add(tcp))
+	a.Equal(ErrListenerExists, ls.Add(tcp))
+	a.Equal([]config.ListenerConfig{tcp}, ls.List())
+	_, err := net.Dial("tcp", tcp.Address)
+	a.Nil(err)
+
+	// the address is in use
+	a.NotNil(ls.Add(config.ListenerConfig{Address: tcp.Address, Label: "other"}))
+	a.Len(ls.List(), 1)
+
+	// change to a websocket listener on another address
+	ws := config.ListenerConfig{Address: freeAddr(t), Websocket: &config.WebsocketOptions{Path: "/mqtt"}}
+	a.Equal(ErrListenerNotFound, ls.Update("127.0.0.1:1", ws))
+	a.Nil(ls.Update(tcp.Address, ws))
+	a.Equal([]config.ListenerConfig{ws}, ls.List())
+	_, err = net.Dial("tcp", tcp.Address)
+	a.NotNil(err)
+	resp, err := http.Get("http://" + ws.Address + "/mqtt")
+	a.Nil(err)
+	resp.Body.Close()
+	// not a websocket request
+	a.Equal(http.StatusBadRequest, resp.StatusCode)
+
+	// update in place, the old listener is restored if the new one fails to start.
+	invalid := ws
+	invalid.TLSOptions = &config.TLSOptions{Cert: "not_exist", Key: "not_exist"}
+	a.NotNil(ls.Update(ws.Address, invalid))
+	a.Equal([]config.ListenerConfig{ws}, ls.List())
+	ws.Label = "ws"
+	a.Nil(ls.Update(ws.Address, ws))
+	a.Equal([]config.ListenerConfig{ws}, ls.List())
+	resp, err = http.Get("http://" + ws.Address + "/mqtt")
+	a.Nil(err)
+	resp.Body.Close()
+
+	a.Nil(ls.Remove(ws.Address))
+	a.Equal(ErrListenerNotFound, ls.Remove(ws.Address))
+	a.Empty(ls.List())
+	_, err = http.Get("http://" + ws.Address + "/mqtt")
+	a.NotNil(err)
+
+	a.Nil(srv.Stop(context.Background()))
+	a.Equal(errServerNotRunning, ls.Add(tcp))
+}
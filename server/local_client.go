@@ -0,0 +1,233 @@
+package server
+
+import (
+	"errors"
+	"strings"
+	"sync"
+
+	"github.com/DrmagicE/gmqtt"
+	"github.com/DrmagicE/gmqtt/persistence/subscription"
+	"github.com/DrmagicE/gmqtt/persistence/subscription/mem"
+	"github.com/DrmagicE/gmqtt/pkg/packets"
+)
+
+var (
+	// ErrLocalClientExists is returned by NewLocalClient if the local client with the same client id exists.
+	ErrLocalClientExists = errors.New("local client already exists")
+	// ErrLocalClientClosed is returned if the local client has been closed.
+	ErrLocalClientClosed = errors.New("local client closed")
+	// ErrInvalidTopic is returned if the topic name or topic filter is invalid.
+	ErrInvalidTopic = errors.New("invalid topic")
+)
+
+// MessageHandler handles the message delivered to the LocalClient.
+// It is called in the goroutine of the publisher, so it should not block. The msg param is immutable, DO NOT EDIT.
+type MessageHandler func(msg *gmqtt.Message)
+
+// LocalClient is the in-process client for the programs which embed the broker.
+// It publishes and subscribes without any network connection and the messages are delivered to the MessageHandler.
+// The messages published by the LocalClient do not go through the OnMsgArrived hook.
+type LocalClient interface {
+	// ClientID returns the client id of the local client.
+	ClientID() string
+	// Publish publishes the message to the broker.
+	// If the Retained flag is set, the message is stored as the retained message,
+	// the retained message is removed if the payload is empty.
+	Publish(msg *gmqtt.Message) error
+	// Subscribe subscribes the topic filter, the matched messages are delivered to the handler.
+	// The retained messages that match the topic filter are delivered before it returns.
+	// Subscribe the same topic filter again replaces the qos and the handler.
+	// Shared subscriptions are not supported.
+	Subscribe(topicFilter string, qos packets.QoS, handler MessageHandler) error
+	// SubscribeNoLocal is the same as Subscribe, except that the messages published by the local client itself
+	// are not delivered to the handler, which is the no local option of MQTT 5.
+	SubscribeNoLocal(topicFilter string, qos packets.QoS, handler MessageHandler) error
+	// Unsubscribe unsubscribes the topic filter.
+	Unsubscribe(topicFilter string) error
+	// Close removes all subscriptions and closes the local client.
+	Close()
+}
+
+// localClients manages the local clients and their subscriptions.
+type localClients struct {
+	mu      sync.RWMutex
+	clients map[string]*localClient
+	subs    subscription.Store
+}
+
+func newLocalClients() *localClients {
+	return &localClients{
+		clients: make(map[string]*localClient),
+		subs:    mem.NewStore(),
+	}
+}
+
+// subscribed reports whether any subscription of the local clients matches.
+func (l *localClients) subscribed(options subscription.IterationOptions) (ok bool) {
+	if l == nil {
+		return false
+	}
+	l.subs.Iterate(func(clientID string, sub *gmqtt.Subscription) bool {
+		ok = true
+		return false
+	}, options)
+	return ok
+}
+
+// deliver delivers the message to the matched local clients.
+func (l *localClients) deliver(srcClientID string, msg *gmqtt.Message, options subscription.IterationOptions) (matched bool) {
+	if l == nil {
+		return false
+	}
+	type delivery struct {
+		handler MessageHandler
+		msg     *gmqtt.Message
+	}
+	var ds []delivery
+	l.mu.RLock()
+	l.subs.Iterate(func(clientID string, sub *gmqtt.Subscription) bool {
+		if sub.NoLocal && clientID == srcClientID {
+			return true
+		}
+		c := l.clients[clientID]
+		if c == nil {
+			return true
+		}
+		c.mu.Lock()
+		handler := c.handlers[sub.TopicFilter]
+		c.mu.Unlock()
+		if handler == nil {
+			return true
+		}
+		m := msg.Copy()
+		if m.QoS > sub.QoS {
+			m.QoS = sub.QoS
+		}
+		ds = append(ds, delivery{handler: handler, msg: m})
+		return true
+	}, options)
+	l.mu.RUnlock()
+	for _, v := range ds {
+		v.handler(v.msg)
+	}
+	return len(ds) != 0
+}
+
+type localClient struct {
+	srv      *server
+	clientID string
+	mu       sync.Mutex
+	closed   bool
+	handlers map[string]MessageHandler
+}
+
+// NewLocalClient creates a LocalClient with the given client id.
+// The client id is only required to be unique among the local clients,
+// it is used as the source client id of the published messages, which is checked by the no local option.
+func (srv *server) NewLocalClient(clientID string) (LocalClient, error) {
+	l := srv.localClients
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.clients[clientID]; ok {
+		return nil, ErrLocalClientExists
+	}
+	c := &localClient{
+		srv:      srv,
+		clientID: clientID,
+		handlers: make(map[string]MessageHandler),
+	}
+	l.clients[clientID] = c
+	return c, nil
+}
+
+func (c *localClient) ClientID() string {
+	return c.clientID
+}
+
+func (c *localClient) isClosed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closed
+}
+
+func (c *localClient) Publish(msg *gmqtt.Message) error {
+	if c.isClosed() {
+		return ErrLocalClientClosed
+	}
+	if !packets.ValidTopicName(true, []byte(msg.Topic)) || msg.QoS > packets.Qos2 {
+		return ErrInvalidTopic
+	}
+	srv := c.srv
+	if msg.Retained {
+		if len(msg.Payload) == 0 {
+			srv.retainedDB.Remove(msg.Topic)
+		} else {
+			srv.retainedDB.AddOrReplace(msg.Copy())
+		}
+	}
+	srv.deliverMessage(c.clientID, msg, defaultIterateOptions(msg.Topic))
+	return nil
+}
+
+func (c *localClient) Subscribe(topicFilter string, qos packets.QoS, handler MessageHandler) error {
+	return c.subscribe(topicFilter, qos, false, handler)
+}
+
+func (c *localClient) SubscribeNoLocal(topicFilter string, qos packets.QoS, handler MessageHandler) error {
+	return c.subscribe(topicFilter, qos, true, handler)
+}
+
+func (c *localClient) subscribe(topicFilter string, qos packets.QoS, noLocal bool, handler MessageHandler) error {
+	if !packets.ValidTopicFilter(true, []byte(topicFilter)) || strings.HasPrefix(topicFilter, "$share/") || qos > packets.Qos2 {
+		return ErrInvalidTopic
+	}
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return ErrLocalClientClosed
+	}
+	c.handlers[topicFilter] = handler
+	c.mu.Unlock()
+	_, err := c.srv.localClients.subs.Subscribe(c.clientID, &gmqtt.Subscription{
+		TopicFilter: topicFilter,
+		QoS:         qos,
+		NoLocal:     noLocal,
+	})
+	if err != nil {
+		return err
+	}
+	for _, v := range c.srv.retainedDB.GetMatchedMessages(topicFilter) {
+		if v.QoS > qos {
+			v.QoS = qos
+		}
+		handler(v)
+	}
+	return nil
+}
+
+func (c *localClient) Unsubscribe(topicFilter string) error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return ErrLocalClientClosed
+	}
+	delete(c.handlers, topicFilter)
+	c.mu.Unlock()
+	return c.srv.localClients.subs.Unsubscribe(c.clientID, topicFilter)
+}
+
+func (c *localClient) Close() {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return
+	}
+	c.closed = true
+	c.handlers = make(map[string]MessageHandler)
+	c.mu.Unlock()
+	l := c.srv.localClients
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.clients, c.clientID)
+	_ = l.subs.UnsubscribeAll(c.clientID)
+}
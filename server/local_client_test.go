@@ -0,0 +1,86 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DrmagicE/gmqtt"
+	"github.com/DrmagicE/gmqtt/persistence/subscription/mem"
+	"github.com/DrmagicE/gmqtt/pkg/packets"
+)
+
+func TestLocalClient(t *testing.T) {
+	a := assert.New(t)
+	srv := defaultServer()
+	srv.subscriptionsDB = mem.NewStore()
+	srv.statsManager = newStatsManager(srv.subscriptionsDB)
+
+	sub, err := srv.NewLocalClient("sub")
+	a.NoError(err)
+	pub, err := srv.NewLocalClient("pub")
+	a.NoError(err)
+	_, err = srv.NewLocalClient("sub")
+	a.Equal(ErrLocalClientExists, err)
+
+	a.NoError(pub.Publish(&gmqtt.Message{
+		Topic:    "retained",
+		Payload:  []byte("r"),
+		QoS:      packets.Qos1,
+		Retained: true,
+	}))
+	var received []*gmqtt.Message
+	handler := func(msg *gmqtt.Message) {
+		received = append(received, msg)
+	}
+	a.NoError(sub.Subscribe("retained", packets.Qos0, handler))
+	a.Len(received, 1)
+	a.Equal("retained", received[0].Topic)
+	a.Equal(packets.Qos0, received[0].QoS)
+
+	received = nil
+	a.NoError(sub.Subscribe("a/+", packets.Qos1, handler))
+	a.NoError(pub.Publish(&gmqtt.Message{
+		Topic:   "a/b",
+		Payload: []byte("1"),
+		QoS:     packets.Qos2,
+	}))
+	// the messages from the network clients are delivered too.
+	a.True(srv.deliverMessage("cid", &gmqtt.Message{
+		Topic:   "a/c",
+		Payload: []byte("2"),
+	}, defaultIterateOptions("a/c")))
+	a.False(srv.deliverMessage("cid", &gmqtt.Message{
+		Topic: "b",
+	}, defaultIterateOptions("b")))
+	a.Len(received, 2)
+	a.Equal("a/b", received[0].Topic)
+	a.Equal(packets.Qos1, received[0].QoS)
+	a.Equal("a/c", received[1].Topic)
+
+	received = nil
+	a.NoError(sub.Unsubscribe("a/+"))
+	a.NoError(pub.Publish(&gmqtt.Message{Topic: "a/b"}))
+	a.Len(received, 0)
+
+	// the messages published by the client itself are not delivered with no local.
+	a.NoError(sub.SubscribeNoLocal("c", packets.Qos1, handler))
+	a.NoError(sub.Publish(&gmqtt.Message{Topic: "c", Payload: []byte("self")}))
+	a.Len(received, 0)
+	a.NoError(pub.Publish(&gmqtt.Message{Topic: "c", Payload: []byte("other")}))
+	a.Len(received, 1)
+	received = nil
+	a.NoError(sub.Unsubscribe("c"))
+
+	a.Equal(ErrInvalidTopic, pub.Publish(&gmqtt.Message{Topic: "a/+"}))
+	a.Equal(ErrInvalidTopic, sub.Subscribe("$share/g/a", packets.Qos0, handler))
+	a.Equal(ErrInvalidTopic, sub.Subscribe("a/#/b", packets.Qos0, handler))
+
+	sub.Close()
+	a.NoError(pub.Publish(&gmqtt.Message{Topic: "retained", Payload: []byte("r2")}))
+	a.Len(received, 0)
+	a.Equal(ErrLocalClientClosed, sub.Subscribe("a", packets.Qos0, handler))
+	// the client id can be reused after closed.
+	_, err = srv.NewLocalClient("sub")
+	a.NoError(err)
+}
@@ -0,0 +1,70 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+
+	"go.uber.org/zap"
+
+	"github.com/DrmagicE/gmqtt/config"
+)
+
+// redactSalt is generated on startup, so the redacted values are consistent within the process lifetime
+// and can be used to correlate the log lines, but can not be recovered by brute force across restarts.
+var redactSalt = make([]byte, 16)
+
+func init() {
+	_, _ = rand.Read(redactSalt)
+}
+
+// redact returns the salted hash of s, or s itself if it is empty.
+func redact(s string) string {
+	if s == "" {
+		return s
+	}
+	h := sha256.New()
+	h.Write(redactSalt)
+	h.Write([]byte(s))
+	return "redacted:" + hex.EncodeToString(h.Sum(nil)[:8])
+}
+
+// redactUsername redacts the username if the log.redact_username is set.
+func redactUsername(cfg config.LogConfig, username string) string {
+	if cfg.RedactUsername {
+		return redact(username)
+	}
+	return username
+}
+
+// redactRemoteAddr redacts the remote address or ip if the log.redact_remote_addr is set.
+func redactRemoteAddr(cfg config.LogConfig, addr string) string {
+	if cfg.RedactRemoteAddr {
+		return redact(addr)
+	}
+	return addr
+}
+
+// logFields returns the identity fields of the client followed by the given fields.
+// The username and the remote address are redacted if it is configured.
+func (client *client) logFields(fields ...zap.Field) []zap.Field {
+	var remoteAddr string
+	if client.rwc != nil {
+		remoteAddr = client.rwc.RemoteAddr().String()
+	}
+	logCfg := client.server.config.Log
+	fs := []zap.Field{
+		zap.String("client_id", client.opts.ClientID),
+		zap.String("username", redactUsername(logCfg, client.opts.Username)),
+		zap.String("remote_addr", redactRemoteAddr(logCfg, remoteAddr)),
+	}
+	if len(logCfg.AttributeFields) != 0 {
+		attrs := client.Attributes()
+		for _, k := range logCfg.AttributeFields {
+			if v, ok := attrs[k]; ok {
+				fs = append(fs, zap.String("attributes."+k, v))
+			}
+		}
+	}
+	return append(fs, fields...)
+}
@@ -0,0 +1,42 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func TestClient_logFields(t *testing.T) {
+	a := assert.New(t)
+	srv := defaultServer()
+	c, err := srv.newClient(noopConn{})
+	a.NoError(err)
+	c.opts.ClientID = "cid"
+	c.opts.Username = "user"
+
+	fields := c.logFields(zap.String("topic", "a"))
+	a.Len(fields, 4)
+	a.Equal(zap.String("client_id", "cid"), fields[0])
+	a.Equal(zap.String("username", "user"), fields[1])
+	a.Equal("remote_addr", fields[2].Key)
+	a.Equal(zap.String("topic", "a"), fields[3])
+
+	srv.config.Log.RedactUsername = true
+	srv.config.Log.RedactRemoteAddr = true
+	fields = c.logFields()
+	a.Equal(zap.String("client_id", "cid"), fields[0])
+	a.Equal(zap.String("username", redact("user")), fields[1])
+	a.NotEqual("user", fields[1].String)
+	a.Contains(fields[2].String, "redacted:")
+	// the redacted value is consistent, so the log lines can be correlated.
+	a.Equal(redact("user"), redact("user"))
+	a.NotEqual(redact("user"), redact("user2"))
+	a.Equal("", redact(""))
+
+	srv.config.Log.AttributeFields = []string{"tenant", "missing"}
+	c.attributes.Store(map[string]string{"tenant": "acme", "model": "a"})
+	fields = c.logFields()
+	a.Len(fields, 4)
+	a.Equal(zap.String("attributes.tenant", "acme"), fields[3])
+}
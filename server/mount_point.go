@@ -0,0 +1,71 @@
+package server
+
+import (
+	"strings"
+
+	"github.com/DrmagicE/gmqtt"
+	"github.com/DrmagicE/gmqtt/persistence/subscription"
+)
+
+// The placeholders which can be used in the mount point.
+const (
+	MountPointUsername = "{username}"
+	MountPointClientID = "{client_id}"
+)
+
+// expandMountPoint replaces the placeholders in the mount point.
+// It returns false if the placeholder value is empty or contains any of '/', '+' and '#',
+// which would break the isolation between the clients.
+func expandMountPoint(mountPoint, username, clientID string) (string, bool) {
+	for _, v := range []struct {
+		placeholder string
+		value       string
+	}{
+		{MountPointUsername, username},
+		{MountPointClientID, clientID},
+	} {
+		if !strings.Contains(mountPoint, v.placeholder) {
+			continue
+		}
+		if v.value == "" || strings.ContainsAny(v.value, "/+#") {
+			return "", false
+		}
+		mountPoint = strings.ReplaceAll(mountPoint, v.placeholder, v.value)
+	}
+	if strings.ContainsAny(mountPoint, "+#\x00") {
+		return "", false
+	}
+	return mountPoint, true
+}
+
+// mountTopic prefixes the topic name published by the client with the mount point of the client.
+func (client *client) mountTopic(topic string) string {
+	return client.opts.MountPoint + topic
+}
+
+// mountTopicFilter prefixes the topic filter subscribed by the client with the mount point of the client.
+// For the shared subscriptions, the mount point is inserted after the share name.
+func (client *client) mountTopicFilter(filter string) string {
+	mp := client.opts.MountPoint
+	if mp == "" {
+		return filter
+	}
+	shareName, topicFilter := subscription.SplitTopic(filter)
+	if topicFilter == "" {
+		return filter
+	}
+	return subscription.GetFullTopicName(shareName, mp+topicFilter)
+}
+
+// unmountMessage strips the mount point from the topic of the message which is going to be sent to the client.
+// The message is not modified, the topic is set to the copy.
+// The messages outside the mount point (e.g. the ones routed by the subscriptions added by the API) are sent as is.
+func (client *client) unmountMessage(msg *gmqtt.Message) *gmqtt.Message {
+	mp := client.opts.MountPoint
+	if mp == "" || len(msg.Topic) <= len(mp) || !strings.HasPrefix(msg.Topic, mp) {
+		return msg
+	}
+	m := msg.ShallowCopy()
+	m.Topic = msg.Topic[len(mp):]
+	return m
+}
@@ -0,0 +1,127 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DrmagicE/gmqtt"
+	"github.com/DrmagicE/gmqtt/config"
+	"github.com/DrmagicE/gmqtt/pkg/packets"
+)
+
+func TestExpandMountPoint(t *testing.T) {
+	var tt = []struct {
+		name       string
+		mountPoint string
+		username   string
+		clientID   string
+		expected   string
+		valid      bool
+	}{
+		{
+			name:       "static",
+			mountPoint: "tenants/a/",
+			expected:   "tenants/a/",
+			valid:      true,
+		},
+		{
+			name:       "username",
+			mountPoint: "tenants/{username}/",
+			username:   "alice",
+			clientID:   "cid",
+			expected:   "tenants/alice/",
+			valid:      true,
+		},
+		{
+			name:       "client_id",
+			mountPoint: "{username}/{client_id}/",
+			username:   "alice",
+			clientID:   "cid",
+			expected:   "alice/cid/",
+			valid:      true,
+		},
+		{
+			name:       "empty_username",
+			mountPoint: "tenants/{username}/",
+			clientID:   "cid",
+		},
+		{
+			name:       "slash_in_username",
+			mountPoint: "tenants/{username}/",
+			username:   "alice/bob",
+		},
+		{
+			name:       "wildcard_in_client_id",
+			mountPoint: "devices/{client_id}/",
+			clientID:   "#",
+		},
+	}
+	for _, v := range tt {
+		t.Run(v.name, func(t *testing.T) {
+			a := assert.New(t)
+			mp, valid := expandMountPoint(v.mountPoint, v.username, v.clientID)
+			a.Equal(v.valid, valid)
+			a.Equal(v.expected, mp)
+		})
+	}
+}
+
+func TestClient_mountTopic(t *testing.T) {
+	a := assert.New(t)
+	c := &client{opts: &ClientOptions{}}
+	a.Equal("a/b", c.mountTopic("a/b"))
+	a.Equal("$share/g/a/#", c.mountTopicFilter("$share/g/a/#"))
+
+	c.opts.MountPoint = "tenants/alice/"
+	a.Equal("tenants/alice/a/b", c.mountTopic("a/b"))
+	a.Equal("tenants/alice/a/#", c.mountTopicFilter("a/#"))
+	a.Equal("tenants/alice/#", c.mountTopicFilter("#"))
+	a.Equal("$share/g/tenants/alice/a/+", c.mountTopicFilter("$share/g/a/+"))
+	// invalid shared subscription is left as is, it will be rejected by the validation.
+	a.Equal("$share/g", c.mountTopicFilter("$share/g"))
+}
+
+func TestClient_unmountMessage(t *testing.T) {
+	a := assert.New(t)
+	c := &client{opts: &ClientOptions{MountPoint: "tenants/alice/"}}
+	msg := &gmqtt.Message{Topic: "tenants/alice/a/b", Payload: []byte("payload")}
+	m := c.unmountMessage(msg)
+	a.Equal("a/b", m.Topic)
+	a.Equal(msg.Payload, m.Payload)
+	// the original message is shared by other subscribers.
+	a.Equal("tenants/alice/a/b", msg.Topic)
+
+	for _, topic := range []string{"a/b", "tenants/alice/", "tenants/bob/a"} {
+		msg = &gmqtt.Message{Topic: topic}
+		a.Same(msg, c.unmountMessage(msg))
+	}
+}
+
+func TestClient_connectHandler_mountPoint(t *testing.T) {
+	a := assert.New(t)
+	srv := &server{
+		config:   config.DefaultConfig(),
+		registry: newRegistry(),
+		hooks: Hooks{
+			OnBasicAuth: func(ctx context.Context, client Client, req *ConnectRequest) error {
+				a.Equal("tenants/{username}/", req.Options.MountPoint)
+				if string(req.Connect.Username) == "admin" {
+					req.Options.MountPoint = ""
+				}
+				return nil
+			},
+		},
+	}
+	c, err := srv.newClient(noopConn{})
+	a.NoError(err)
+	c.mountPoint = "tenants/{username}/"
+	authOpts, _, err := c.connectHandler(&packets.Connect{Version: packets.Version311, ClientID: []byte("cid"), Username: []byte("alice")})
+	a.NoError(err)
+	a.Equal("tenants/{username}/", authOpts.MountPoint)
+
+	authOpts, _, err = c.connectHandler(&packets.Connect{Version: packets.Version311, ClientID: []byte("cid"), Username: []byte("admin")})
+	a.NoError(err)
+	a.Equal("", authOpts.MountPoint)
+}
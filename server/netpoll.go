@@ -0,0 +1,125 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"go.uber.org/zap"
+
+	"github.com/DrmagicE/gmqtt/pkg/netpoll"
+)
+
+var errKeepAliveTimeout = errors.New("keepalive timeout")
+
+// pollState holds the netpoll state of a client.
+type pollState struct {
+	poller *netpoll.Poller
+	fd     int
+	// mu serializes the drain process.
+	mu     sync.Mutex
+	exited bool
+	// removed is set to 1 once the fd has been removed from the poller.
+	removed int32
+	// done will be called when the reading process exits.
+	done func()
+}
+
+// pollRead registers the client connection into the server poller.
+// Returns false if the connection can not be served by the netpoll engine, the caller should fall back to readLoop.
+func (client *client) pollRead(done func()) bool {
+	poller := client.server.poller
+	if poller == nil {
+		return false
+	}
+	fd, ok := netpoll.FD(client.rwc)
+	if !ok {
+		return false
+	}
+	client.poll = &pollState{
+		poller: poller,
+		fd:     fd,
+		done:   done,
+	}
+	err := poller.Add(fd, func() {
+		go client.pollDrain()
+	})
+	if err != nil {
+		zaplog.Warn("fail to add connection to poller, fall back to goroutine engine", client.logFields(zap.Error(err))...)
+		client.poll = nil
+		return false
+	}
+	return true
+}
+
+// pollDrain reads all the buffered packets and re-arms the poller.
+func (client *client) pollDrain() {
+	p := client.poll
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.exited {
+		return
+	}
+	var err error
+	defer func() {
+		if re := recover(); re != nil {
+			err = errors.New(fmt.Sprint(re))
+		}
+		if err != nil {
+			client.pollExitLocked(err)
+		}
+	}()
+	for {
+		err = client.readPacket()
+		if err != nil {
+			return
+		}
+		if client.bufr.Buffered() == 0 {
+			break
+		}
+	}
+	if atomic.LoadInt32(&p.removed) == 0 {
+		err = p.poller.Rearm(p.fd)
+	}
+}
+
+func (client *client) pollKeepAliveTimeout() {
+	p := client.poll
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.exited {
+		return
+	}
+	client.pollExitLocked(errKeepAliveTimeout)
+}
+
+// pollExitLocked is the counterpart of the deferred function in readLoop.
+func (client *client) pollExitLocked(err error) {
+	p := client.poll
+	p.exited = true
+	client.pollUnregister()
+	client.setError(err)
+	close(client.in)
+	p.done()
+}
+
+// pollUnregister removes the connection from the poller.
+// It must be called before closing the connection, because the fd may be reused by a new connection once closed.
+func (client *client) pollUnregister() {
+	p := client.poll
+	if p == nil {
+		return
+	}
+	if atomic.CompareAndSwapInt32(&p.removed, 0, 1) {
+		_ = p.poller.Remove(p.fd)
+	}
+}
+
+// pollWakeup starts a drain process to make the reading process aware of the closed connection.
+func (client *client) pollWakeup() {
+	if client.poll == nil {
+		return
+	}
+	go client.pollDrain()
+}
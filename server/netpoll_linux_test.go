@@ -0,0 +1,95 @@
+// +build linux
+
+package server_test
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DrmagicE/gmqtt/config"
+	"github.com/DrmagicE/gmqtt/pkg/packets"
+	"github.com/DrmagicE/gmqtt/server"
+)
+
+// startEngineServer starts a server serving the tcp connections with the given connection engine.
+func startEngineServer(t *testing.T, engine string) (server.Server, string) {
+	cfg := config.DefaultConfig()
+	cfg.ConnectionEngine.Type = engine
+	return startTestServer(t, cfg)
+}
+
+func TestNetpoll_serve(t *testing.T) {
+	a := assert.New(t)
+	srv, addr := startEngineServer(t, config.ConnectionEngineNetpoll)
+
+	sub := dialMQTT(t, addr, "sub", 60)
+	defer sub.conn.Close()
+	sub.write(&packets.Subscribe{
+		Version:  packets.Version311,
+		PacketID: 1,
+		Topics:   []packets.Topic{{Name: "a/b", SubOptions: packets.SubOptions{Qos: packets.Qos1}}},
+	})
+	a.IsType(&packets.Suback{}, sub.read())
+
+	pub := dialMQTT(t, addr, "pub", 60)
+	pub.write(&packets.Publish{
+		Version:   packets.Version311,
+		Qos:       packets.Qos1,
+		PacketID:  1,
+		TopicName: []byte("a/b"),
+		Payload:   []byte("payload"),
+	})
+	a.IsType(&packets.Puback{}, pub.read())
+	p, ok := sub.read().(*packets.Publish)
+	if a.True(ok) {
+		a.Equal("a/b", string(p.TopicName))
+		a.Equal("payload", string(p.Payload))
+		sub.write(&packets.Puback{Version: packets.Version311, PacketID: p.PacketID})
+	}
+	sub.write(&packets.Pingreq{})
+	a.IsType(&packets.Pingresp{}, sub.read())
+
+	// close
+	pub.write(&packets.Disconnect{Version: packets.Version311})
+	_ = pub.conn.Close()
+	a.Eventually(func() bool {
+		return srv.ClientService().GetClient("pub") == nil
+	}, 3*time.Second, 10*time.Millisecond)
+
+	// keepalive, the idle time is counted from the CONNECT packet, which is received after the dial starts.
+	start := time.Now()
+	idle := dialMQTT(t, addr, "idle", 1)
+	defer idle.conn.Close()
+	idle.waitClosed(5 * time.Second)
+	d := time.Since(start)
+	a.True(d >= time.Second, "closed too early: %s", d)
+}
+
+func TestServer_keepAlive_partialPacket(t *testing.T) {
+	for _, engine := range []string{config.ConnectionEngineGoroutine, config.ConnectionEngineNetpoll} {
+		t.Run(engine, func(t *testing.T) {
+			a := assert.New(t)
+			_, addr := startEngineServer(t, engine)
+			// the partial packet does not count as activity, the idle time is counted from the CONNECT packet.
+			start := time.Now()
+			c := dialMQTT(t, addr, "cid", 1)
+			defer c.conn.Close()
+			// send half of a PUBLISH packet and stall.
+			var buf bytes.Buffer
+			w := packets.NewWriter(&buf)
+			a.NoError(w.WriteAndFlush(&packets.Publish{
+				Version:   packets.Version311,
+				TopicName: []byte("a/b"),
+				Payload:   []byte("payload"),
+			}))
+			_, err := c.conn.Write(buf.Bytes()[:buf.Len()/2])
+			a.NoError(err)
+			c.waitClosed(3 * time.Second)
+			d := time.Since(start)
+			a.True(d >= time.Second, "closed too early: %s", d)
+		})
+	}
+}
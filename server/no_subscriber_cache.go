@@ -0,0 +1,93 @@
+package server
+
+import (
+	"sync"
+
+	"github.com/DrmagicE/gmqtt"
+	"github.com/DrmagicE/gmqtt/persistence/subscription"
+)
+
+// noSubscriberCache is the negative cache of the topics which have no subscribers,
+// the publishes to the cached topics skip the subscription matching.
+// Any added subscription may match the cached topics, so the cache is reset whenever a subscription is added.
+type noSubscriberCache struct {
+	size int
+	mu   sync.RWMutex
+	// gen is increased on each reset, so that the result of the matching which races with
+	// the subscribing is not cached.
+	gen    uint64
+	topics map[string]struct{}
+}
+
+func newNoSubscriberCache(size int) *noSubscriberCache {
+	return &noSubscriberCache{
+		size:   size,
+		topics: make(map[string]struct{}),
+	}
+}
+
+// has reports whether the topic has no subscribers, it is nil-safe.
+func (c *noSubscriberCache) has(topic string) bool {
+	if c == nil {
+		return false
+	}
+	c.mu.RLock()
+	_, ok := c.topics[topic]
+	c.mu.RUnlock()
+	return ok
+}
+
+// generation returns the current generation, it must be called before the matching.
+func (c *noSubscriberCache) generation() uint64 {
+	if c == nil {
+		return 0
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.gen
+}
+
+// add caches the topic which matches no subscriptions, unless the cache has been reset since the generation.
+func (c *noSubscriberCache) add(topic string, gen uint64) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.gen != gen {
+		return
+	}
+	if len(c.topics) >= c.size {
+		c.topics = make(map[string]struct{})
+	}
+	c.topics[topic] = struct{}{}
+}
+
+func (c *noSubscriberCache) reset() {
+	c.mu.Lock()
+	c.gen++
+	c.topics = make(map[string]struct{})
+	c.mu.Unlock()
+}
+
+// watch returns the subscription store which resets the cache after the subscriptions are added.
+func (c *noSubscriberCache) watch(store subscription.Store) subscription.Store {
+	return &noSubscriberWatch{Store: store, cache: c}
+}
+
+type noSubscriberWatch struct {
+	subscription.Store
+	cache *noSubscriberCache
+}
+
+func (w *noSubscriberWatch) Init(clientIDs []string) error {
+	err := w.Store.Init(clientIDs)
+	w.cache.reset()
+	return err
+}
+
+func (w *noSubscriberWatch) Subscribe(clientID string, subscriptions ...*gmqtt.Subscription) (subscription.SubscribeResult, error) {
+	rs, err := w.Store.Subscribe(clientID, subscriptions...)
+	w.cache.reset()
+	return rs, err
+}
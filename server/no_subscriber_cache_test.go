@@ -0,0 +1,71 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DrmagicE/gmqtt"
+	"github.com/DrmagicE/gmqtt/persistence/queue"
+	"github.com/DrmagicE/gmqtt/persistence/subscription/mem"
+)
+
+func TestNoSubscriberCache(t *testing.T) {
+	a := assert.New(t)
+	var nilCache *noSubscriberCache
+	a.False(nilCache.has("a"))
+
+	c := newNoSubscriberCache(2)
+	store := c.watch(mem.NewStore())
+	gen := c.generation()
+	c.add("a", gen)
+	c.add("b", gen)
+	a.True(c.has("a"))
+	a.True(c.has("b"))
+	// reset when it is full.
+	c.add("c", gen)
+	a.False(c.has("a"))
+	a.True(c.has("c"))
+
+	// the matching which races with the subscribing is not cached.
+	_, err := store.Subscribe("client", &gmqtt.Subscription{TopicFilter: "#"})
+	a.NoError(err)
+	a.False(c.has("c"))
+	c.add("d", gen)
+	a.False(c.has("d"))
+	c.add("d", c.generation())
+	a.True(c.has("d"))
+}
+
+func TestServer_deliverMessage_noSubscriberCache(t *testing.T) {
+	a := assert.New(t)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	subscriber := "subCli"
+	ts := newTestDeliverMsg(ctrl, subscriber)
+	srv := ts.srv
+	srv.noSubscribers = newNoSubscriberCache(10)
+	srv.subscriptionsDB = srv.noSubscribers.watch(srv.subscriptionsDB)
+
+	msg := &gmqtt.Message{Topic: "a/b"}
+	a.False(srv.deliverMessage("", msg, defaultIterateOptions(msg.Topic)))
+	a.True(srv.noSubscribers.has("a/b"))
+	a.False(srv.deliverMessage("", msg, defaultIterateOptions(msg.Topic)))
+
+	// the topic which is only subscribed with no local by the publisher is not cached.
+	_, err := srv.subscriptionsDB.Subscribe(subscriber, &gmqtt.Subscription{TopicFilter: "local/#", NoLocal: true})
+	a.NoError(err)
+	msg = &gmqtt.Message{Topic: "local/a"}
+	a.False(srv.deliverMessage(subscriber, msg, defaultIterateOptions(msg.Topic)))
+	a.False(srv.noSubscribers.has("local/a"))
+
+	// the cache is reset once the topic is subscribed.
+	_, err = srv.subscriptionsDB.Subscribe(subscriber, &gmqtt.Subscription{TopicFilter: "a/#", QoS: 1})
+	a.NoError(err)
+	mockQueue := srv.registry.shard(subscriber).queueStore[subscriber].(*queue.MockStore)
+	mockQueue.EXPECT().Add(gomock.Any())
+	msg = &gmqtt.Message{Topic: "a/b"}
+	a.True(srv.deliverMessage("", msg, defaultIterateOptions(msg.Topic)))
+	a.False(srv.noSubscribers.has("a/b"))
+}
@@ -0,0 +1,86 @@
+package server
+
+import (
+	"sync"
+
+	"github.com/DrmagicE/gmqtt/pkg/packets"
+)
+
+// orderedLanes delivers the messages of the ordered topics in a single lane per topic name.
+// At most one QoS 1 or QoS 2 message per topic name is inflight, the following messages
+// of the same topic name are held until it is acknowledged, so neither the redelivery
+// nor the interleaving QoS 0 messages can break the FIFO order.
+// All methods are nil-safe, the nil lanes write the packets directly.
+type orderedLanes struct {
+	mu    sync.Mutex
+	write func(packet packets.Packet)
+	// pending is the packet id of the inflight message, key by topic name.
+	pending map[string]packets.PacketID
+	// topics is the topic name of the inflight message, key by packet id.
+	topics map[packets.PacketID]string
+	// held is the messages waiting for the inflight message to be acknowledged, key by topic name.
+	held map[string][]*packets.Publish
+}
+
+func newOrderedLanes(write func(packet packets.Packet)) *orderedLanes {
+	return &orderedLanes{
+		write:   write,
+		pending: make(map[string]packets.PacketID),
+		topics:  make(map[packets.PacketID]string),
+		held:    make(map[string][]*packets.Publish),
+	}
+}
+
+// send writes the publish packet, or holds it if there is an inflight message of the same topic name.
+func (l *orderedLanes) send(pub *packets.Publish) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	// the topic name must be read before writing, it may be replaced by the topic alias in the write loop.
+	topic := string(pub.TopicName)
+	if _, ok := l.pending[topic]; ok {
+		l.held[topic] = append(l.held[topic], pub)
+		return
+	}
+	l.writeLocked(topic, pub)
+}
+
+func (l *orderedLanes) writeLocked(topic string, pub *packets.Publish) {
+	if pub.Qos != packets.Qos0 {
+		l.pending[topic] = pub.PacketID
+		l.topics[pub.PacketID] = topic
+	}
+	l.write(pub)
+}
+
+// acknowledged releases the held messages of the topic name whose inflight message is acknowledged.
+// The held messages are written until the next QoS 1 or QoS 2 message.
+func (l *orderedLanes) acknowledged(id packets.PacketID) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	topic, ok := l.topics[id]
+	if !ok {
+		return
+	}
+	delete(l.topics, id)
+	delete(l.pending, topic)
+	held := l.held[topic]
+	for len(held) != 0 {
+		pub := held[0]
+		held = held[1:]
+		l.writeLocked(topic, pub)
+		if pub.Qos != packets.Qos0 {
+			break
+		}
+	}
+	if len(held) == 0 {
+		delete(l.held, topic)
+	} else {
+		l.held[topic] = held
+	}
+}
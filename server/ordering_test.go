@@ -0,0 +1,59 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DrmagicE/gmqtt/pkg/packets"
+)
+
+func TestOrderedLanes(t *testing.T) {
+	a := assert.New(t)
+	var nilLanes *orderedLanes
+	nilLanes.send(&packets.Publish{Qos: packets.Qos1, PacketID: 1})
+	nilLanes.acknowledged(1)
+
+	var written []packets.Packet
+	l := newOrderedLanes(func(packet packets.Packet) {
+		written = append(written, packet)
+	})
+	pub := func(topic string, qos uint8, id packets.PacketID) *packets.Publish {
+		return &packets.Publish{TopicName: []byte(topic), Qos: qos, PacketID: id}
+	}
+	a1 := pub("a", packets.Qos1, 1)
+	a2 := pub("a", packets.Qos0, 0)
+	a3 := pub("a", packets.Qos2, 2)
+	a4 := pub("a", packets.Qos0, 0)
+	b1 := pub("b", packets.Qos1, 3)
+
+	l.send(a1)
+	// the topic alias set by the write loop must not affect the lane.
+	a1.TopicName = []byte{}
+	l.send(a2)
+	l.send(a3)
+	l.send(b1)
+	l.send(a4)
+	a.Equal([]packets.Packet{a1, b1}, written)
+
+	// the unknown packet id is ignored.
+	l.acknowledged(100)
+	a.Len(written, 2)
+
+	// the QoS 0 message is released with the next QoS 2 message.
+	l.acknowledged(1)
+	a.Equal([]packets.Packet{a1, b1, a2, a3}, written)
+	l.acknowledged(1)
+	a.Len(written, 4)
+
+	l.acknowledged(2)
+	a.Equal([]packets.Packet{a1, b1, a2, a3, a4}, written)
+	a.Empty(l.held)
+	a.Len(l.pending, 1)
+
+	l.acknowledged(3)
+	a.Empty(l.pending)
+	a.Empty(l.topics)
+	l.send(a4)
+	a.Len(written, 6)
+}
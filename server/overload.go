@@ -0,0 +1,91 @@
+package server
+
+import (
+	"sync/atomic"
+
+	"go.uber.org/zap"
+
+	"github.com/DrmagicE/gmqtt/config"
+)
+
+// Overload levels, the load is shed progressively as the level goes up.
+const (
+	overloadNone int32 = iota
+	// overloadRejectConnection stops accepting new connections.
+	overloadRejectConnection
+	// overloadRejectQos2 rejects QoS 2 publishes.
+	overloadRejectQos2
+	// overloadDropQos0 drops QoS 0 messages for slow subscribers.
+	overloadDropQos0
+)
+
+// overloadGuard monitors the heap usage and the total number of queued messages.
+// A nil guard means the overload protection is disabled.
+type overloadGuard struct {
+	config config.OverloadProtection
+	sts    *statsManager
+	level  int32
+}
+
+func newOverloadGuard(cfg config.OverloadProtection, sts *statsManager) *overloadGuard {
+	return &overloadGuard{
+		config: cfg,
+		sts:    sts,
+	}
+}
+
+// usage returns the maximum usage ratio of all limited resources.
+func (o *overloadGuard) usage() float64 {
+	var ratio float64
+	if o.config.MaxHeapInuse != 0 {
+		ratio = float64(heapInuse()) / float64(o.config.MaxHeapInuse)
+	}
+	if o.config.MaxQueuedMessages != 0 {
+		queued := atomic.LoadUint64(&o.sts.totalStats.MessageStats.QueuedCurrent)
+		if r := float64(queued) / float64(o.config.MaxQueuedMessages); r > ratio {
+			ratio = r
+		}
+	}
+	return ratio
+}
+
+// check updates the overload level according to the current resource usage.
+func (o *overloadGuard) check() {
+	ratio := o.usage()
+	level := overloadNone
+	if ratio >= o.config.RejectConnectionRatio {
+		level = overloadRejectConnection
+	}
+	if ratio >= o.config.RejectQos2Ratio {
+		level = overloadRejectQos2
+	}
+	if ratio >= o.config.DropQos0Ratio {
+		level = overloadDropQos0
+	}
+	if old := atomic.SwapInt32(&o.level, level); old != level {
+		if level > old {
+			zaplog.Warn("overload level raised", zap.Int32("level", level), zap.Float64("usage", ratio))
+		} else {
+			zaplog.Info("overload level lowered", zap.Int32("level", level), zap.Float64("usage", ratio))
+		}
+	}
+}
+
+func (o *overloadGuard) reached(level int32) bool {
+	if o == nil {
+		return false
+	}
+	return atomic.LoadInt32(&o.level) >= level
+}
+
+func (o *overloadGuard) rejectConnection() bool {
+	return o.reached(overloadRejectConnection)
+}
+
+func (o *overloadGuard) rejectQos2() bool {
+	return o.reached(overloadRejectQos2)
+}
+
+func (o *overloadGuard) dropQos0() bool {
+	return o.reached(overloadDropQos0)
+}
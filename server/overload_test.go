@@ -0,0 +1,105 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DrmagicE/gmqtt"
+	"github.com/DrmagicE/gmqtt/config"
+	"github.com/DrmagicE/gmqtt/persistence/queue"
+	"github.com/DrmagicE/gmqtt/persistence/subscription/mem"
+	"github.com/DrmagicE/gmqtt/pkg/codes"
+	"github.com/DrmagicE/gmqtt/pkg/packets"
+)
+
+func TestOverloadGuard_check(t *testing.T) {
+	a := assert.New(t)
+	sts := newStatsManager(mem.NewStore())
+	cfg := config.DefaultOverloadProtection
+	cfg.Enable = true
+	cfg.MaxQueuedMessages = 100
+	o := newOverloadGuard(cfg, sts)
+
+	var tt = []struct {
+		queued           uint64
+		rejectConnection bool
+		rejectQos2       bool
+		dropQos0         bool
+	}{
+		{queued: 0},
+		{queued: 80, rejectConnection: true},
+		{queued: 90, rejectConnection: true, rejectQos2: true},
+		{queued: 100, rejectConnection: true, rejectQos2: true, dropQos0: true},
+		{queued: 10},
+	}
+	for _, v := range tt {
+		sts.totalStats.MessageStats.QueuedCurrent = v.queued
+		o.check()
+		a.Equal(v.rejectConnection, o.rejectConnection())
+		a.Equal(v.rejectQos2, o.rejectQos2())
+		a.Equal(v.dropQos0, o.dropQos0())
+	}
+
+	var nilGuard *overloadGuard
+	a.False(nilGuard.rejectConnection())
+}
+
+func TestOverloadGuard_check_heap(t *testing.T) {
+	a := assert.New(t)
+	a.NotZero(heapInuse())
+	cfg := config.DefaultOverloadProtection
+	cfg.Enable = true
+	cfg.MaxHeapInuse = 1
+	o := newOverloadGuard(cfg, newStatsManager(mem.NewStore()))
+	o.check()
+	a.True(o.dropQos0())
+
+	o.config.MaxHeapInuse = 1 << 62
+	o.check()
+	a.False(o.rejectConnection())
+}
+
+func TestServer_addMsgToQueue_overloaded(t *testing.T) {
+	a := assert.New(t)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	subscriber := "subCli"
+	ts := newTestDeliverMsg(ctrl, subscriber)
+	srv := ts.srv
+	srv.overload = newOverloadGuard(config.DefaultOverloadProtection, srv.statsManager)
+	srv.overload.level = overloadDropQos0
+
+	mockQueue := srv.registry.shard(subscriber).queueStore[subscriber].(*queue.MockStore)
+	// qos0 message for the offline subscriber is dropped.
+	srv.addMsgToQueue(time.Now(), subscriber, &gmqtt.Message{Topic: "a", QoS: 0}, &gmqtt.Subscription{QoS: 1}, nil)
+	a.EqualValues(1, srv.statsManager.GetGlobalStats().MessageStats.Qos0.DroppedTotal.Overloaded)
+
+	mockQueue.EXPECT().Add(gomock.Any())
+	srv.addMsgToQueue(time.Now(), subscriber, &gmqtt.Message{Topic: "a", QoS: 1}, &gmqtt.Subscription{QoS: 1}, nil)
+}
+
+func TestClient_connectHandler_overloaded(t *testing.T) {
+	a := assert.New(t)
+	sts := newStatsManager(mem.NewStore())
+	cfg := config.DefaultOverloadProtection
+	cfg.Enable = true
+	cfg.MaxQueuedMessages = 100
+	srv := &server{
+		config:   config.DefaultConfig(),
+		registry: newRegistry(),
+		overload: newOverloadGuard(cfg, sts),
+	}
+	c, err := srv.newClient(noopConn{})
+	a.NoError(err)
+	connect := &packets.Connect{Version: packets.Version5, ClientID: []byte("cid"), Properties: &packets.Properties{}}
+	_, _, err = c.connectHandler(connect)
+	a.NoError(err)
+
+	sts.totalStats.MessageStats.QueuedCurrent = 80
+	srv.overload.check()
+	_, _, err = c.connectHandler(connect)
+	a.Equal(codes.ServerBusy, err.(*codes.Error).Code)
+}
@@ -0,0 +1,84 @@
+package server
+
+import (
+	"bytes"
+	"encoding/hex"
+	"sync/atomic"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+
+	"github.com/DrmagicE/gmqtt/config"
+	"github.com/DrmagicE/gmqtt/pkg/packets"
+)
+
+// packetDumper dumps the sampled packets to the main log or the dedicated dump file.
+// A nil dumper means the packet dump is disabled.
+type packetDumper struct {
+	sampleRate uint64
+	// clientIDs is the allowlist of the client ids, empty means all clients.
+	clientIDs map[string]struct{}
+	counter   uint64
+	// log is the logger of the dedicated dump file, nil means dumping to the main log.
+	log *zap.Logger
+}
+
+func newPacketDumper(cfg config.LogConfig) (*packetDumper, error) {
+	if !cfg.DumpPacket {
+		return nil, nil
+	}
+	l, err := cfg.GetPacketDumpLogger()
+	if err != nil {
+		return nil, err
+	}
+	d := &packetDumper{
+		sampleRate: uint64(cfg.Dump.SampleRate),
+		clientIDs:  make(map[string]struct{}),
+		log:        l,
+	}
+	for _, v := range cfg.Dump.ClientIDs {
+		d.clientIDs[v] = struct{}{}
+	}
+	return d, nil
+}
+
+// sampled returns whether the packet of the client should be dumped.
+func (d *packetDumper) sampled(clientID string) bool {
+	if len(d.clientIDs) != 0 {
+		if _, ok := d.clientIDs[clientID]; !ok {
+			return false
+		}
+	}
+	if d.sampleRate <= 1 {
+		return true
+	}
+	return atomic.AddUint64(&d.counter, 1)%d.sampleRate == 1
+}
+
+// dump dumps the packet if it is sampled.
+func (d *packetDumper) dump(client *client, msg string, packet packets.Packet, fields ...zap.Field) {
+	if d == nil {
+		return
+	}
+	if d.log == nil {
+		if !zaplog.Core().Enabled(zapcore.DebugLevel) || !d.sampled(client.opts.ClientID) {
+			return
+		}
+		zaplog.Debug(msg, client.logFields(append([]zap.Field{zap.String("packet", packet.String())}, fields...)...)...)
+		return
+	}
+	if !d.sampled(client.opts.ClientID) {
+		return
+	}
+	d.log.Info(msg, client.logFields(append(packetFields(packet), fields...)...)...)
+}
+
+// packetFields returns the decoded and hex form of the packet.
+func packetFields(packet packets.Packet) []zap.Field {
+	b := &bytes.Buffer{}
+	_ = packet.Pack(b)
+	return []zap.Field{
+		zap.String("packet", packet.String()),
+		zap.String("hex", hex.EncodeToString(b.Bytes())),
+	}
+}
@@ -0,0 +1,58 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+
+	"github.com/DrmagicE/gmqtt/config"
+	"github.com/DrmagicE/gmqtt/pkg/packets"
+)
+
+func TestPacketDumper(t *testing.T) {
+	a := assert.New(t)
+	d, err := newPacketDumper(config.LogConfig{})
+	a.NoError(err)
+	a.Nil(d)
+
+	d, err = newPacketDumper(config.LogConfig{
+		DumpPacket: true,
+		Dump: config.PacketDump{
+			SampleRate: 2,
+			ClientIDs:  []string{"cid"},
+		},
+	})
+	a.NoError(err)
+	core, logs := observer.New(zap.DebugLevel)
+	d.log = zap.New(core)
+
+	srv := defaultServer()
+	c, err := srv.newClient(noopConn{})
+	a.NoError(err)
+	c.opts.ClientID = "cid"
+	other, err := srv.newClient(noopConn{})
+	a.NoError(err)
+	other.opts.ClientID = "other"
+
+	pingreq := &packets.Pingreq{}
+	for i := 0; i < 4; i++ {
+		d.dump(c, "received packet", pingreq, zap.String("correlation_id", "id"))
+		d.dump(other, "received packet", pingreq)
+	}
+	// 1 in 2 packets of the allowed client are dumped.
+	a.Equal(2, logs.Len())
+	for _, v := range logs.All() {
+		fields := v.ContextMap()
+		a.Equal("received packet", v.Message)
+		a.Equal("cid", fields["client_id"])
+		a.Equal(pingreq.String(), fields["packet"])
+		a.Equal("c000", fields["hex"])
+		a.Equal("id", fields["correlation_id"])
+	}
+
+	// nil dumper is a no-op.
+	var nilDumper *packetDumper
+	nilDumper.dump(c, "received packet", pingreq)
+}
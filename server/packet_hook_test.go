@@ -0,0 +1,118 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DrmagicE/gmqtt/persistence/subscription/mem"
+	"github.com/DrmagicE/gmqtt/pkg/packets"
+)
+
+// recordConn records all written data.
+type recordConn struct {
+	noopConn
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (r *recordConn) Write(b []byte) (n int, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.buf.Write(b)
+}
+
+func (r *recordConn) Len() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.buf.Len()
+}
+
+func TestClient_OnPacketSend(t *testing.T) {
+	a := assert.New(t)
+	srv := defaultServer()
+	srv.statsManager = newStatsManager(mem.NewStore())
+	hookErr := errors.New("hook error")
+	srv.hooks.OnPacketSend = func(ctx context.Context, client Client, packet packets.Packet) error {
+		switch packet.(type) {
+		case *packets.Pingresp:
+			return ErrDiscardPacket
+		case *packets.Unsuback:
+			return hookErr
+		}
+		return nil
+	}
+	conn := &recordConn{}
+	c, err := srv.newClient(conn)
+	a.NoError(err)
+	c.opts.ClientID = "cid"
+	c.version = packets.Version311
+	done := make(chan struct{})
+	go func() {
+		c.writeLoop()
+		close(done)
+	}()
+
+	c.write(&packets.Pingresp{})
+	c.write(&packets.Suback{Version: packets.Version311, PacketID: 1, Payload: []byte{0}})
+	c.write(&packets.Unsuback{Version: packets.Version311, PacketID: 2})
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("writeLoop should exit")
+	}
+	a.Equal(hookErr, c.err)
+
+	// only the suback is written.
+	var buf bytes.Buffer
+	a.NoError(packets.NewWriter(&buf).WriteAndFlush(&packets.Suback{Version: packets.Version311, PacketID: 1, Payload: []byte{0}}))
+	a.Equal(buf.Len(), conn.Len())
+}
+
+func TestClient_OnPacketReceived(t *testing.T) {
+	a := assert.New(t)
+	srv := defaultServer()
+	srv.statsManager = newStatsManager(mem.NewStore())
+	hookErr := errors.New("hook error")
+	srv.hooks.OnPacketReceived = func(ctx context.Context, client Client, packet packets.Packet) error {
+		switch packet.(type) {
+		case *packets.Pingreq:
+			return ErrDiscardPacket
+		case *packets.Unsubscribe:
+			return hookErr
+		}
+		return nil
+	}
+	c, err := srv.newClient(noopConn{})
+	a.NoError(err)
+	c.opts.ClientID = "cid"
+	c.version = packets.Version311
+	close(c.connected)
+
+	var buf bytes.Buffer
+	w := packets.NewWriter(&buf)
+	a.NoError(w.WritePacket(&packets.Pingreq{}))
+	a.NoError(w.WritePacket(&packets.Subscribe{Version: packets.Version311, PacketID: 1, Topics: []packets.Topic{
+		{Name: "a", SubOptions: packets.SubOptions{Qos: packets.Qos0}},
+	}}))
+	a.NoError(w.WritePacket(&packets.Unsubscribe{Version: packets.Version311, PacketID: 2, Topics: []string{"a"}}))
+	a.NoError(w.Flush())
+	c.packetReader = packets.NewReader(&buf)
+	c.packetReader.SetVersion(packets.Version311)
+
+	// the pingreq is discarded
+	a.NoError(c.readPacket())
+	a.Len(c.in, 0)
+	// the subscribe is passed to the readHandle goroutine.
+	a.NoError(c.readPacket())
+	a.Len(c.in, 1)
+	a.IsType(&packets.Subscribe{}, (<-c.in).packet)
+	// the unsubscribe closes the connection.
+	a.Equal(hookErr, unwrapCloseError(c.readPacket()))
+	a.Len(c.in, 0)
+}
@@ -13,6 +13,9 @@ type HookWrapper struct {
 	OnSessionCreatedWrapper    OnSessionCreatedWrapper
 	OnSessionResumedWrapper    OnSessionResumedWrapper
 	OnSessionTerminatedWrapper OnSessionTerminatedWrapper
+	OnSessionExpiredWrapper    OnSessionExpiredWrapper
+	OnOfflineBacklogWrapper    OnOfflineBacklogWrapper
+	OnSessionTakenOverWrapper  OnSessionTakenOverWrapper
 	OnSubscribeWrapper         OnSubscribeWrapper
 	OnSubscribedWrapper        OnSubscribedWrapper
 	OnUnsubscribeWrapper       OnUnsubscribeWrapper
@@ -20,11 +23,20 @@ type HookWrapper struct {
 	OnMsgArrivedWrapper        OnMsgArrivedWrapper
 	OnMsgDroppedWrapper        OnMsgDroppedWrapper
 	OnDeliveredWrapper         OnDeliveredWrapper
+	OnDeliveryReportWrapper    OnDeliveryReportWrapper
 	OnClosedWrapper            OnClosedWrapper
 	OnAcceptWrapper            OnAcceptWrapper
 	OnStopWrapper              OnStopWrapper
 	OnWillPublishWrapper       OnWillPublishWrapper
 	OnWillPublishedWrapper     OnWillPublishedWrapper
+	OnPacketReceivedWrapper    OnPacketReceivedWrapper
+	OnPacketSendWrapper        OnPacketSendWrapper
+	OnPSKLookupWrapper         OnPSKLookupWrapper
+	// AsyncHooks is the names of the hooks that are executed asynchronously in a bounded worker pool,
+	// so a slow hook (e.g. a webhook or DB call) will not block the client.
+	// Only the hooks in AsyncHookNames can be asynchronous, the params of them must be treated as read-only.
+	// The worker pool can be configured by the async_hook setting.
+	AsyncHooks []string
 }
 
 // NewPlugin is the constructor of a plugin.
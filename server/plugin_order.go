@@ -0,0 +1,62 @@
+package server
+
+import (
+	"fmt"
+)
+
+var pluginConstraints = make(map[string]PluginConstraints)
+
+// PluginConstraints declares the dependencies and the ordering constraints of a plugin.
+// The constraints are expressed by the position in plugin_order.
+// The hook wrapper of a plugin wraps the hooks of the plugins after it,
+// so for the plugins which call the previous hook first, the plugins after it run first.
+type PluginConstraints struct {
+	// Requires is the names of the plugins that must be enabled together with the plugin.
+	Requires []string
+	// After is the names of the plugins that must be placed before the plugin in plugin_order if they are enabled.
+	After []string
+	// Before is the names of the plugins that must be placed after the plugin in plugin_order if they are enabled.
+	Before []string
+}
+
+// RegisterPluginConstraints registers the dependencies and the ordering constraints for the plugin with the given name.
+// It is usually called in the init function of the plugin package along with RegisterPlugin.
+func RegisterPluginConstraints(name string, constraints PluginConstraints) {
+	if _, ok := pluginConstraints[name]; ok {
+		panic("duplicated plugin constraints: " + name)
+	}
+	pluginConstraints[name] = constraints
+}
+
+// ValidatePluginOrder validates the plugin_order against the registered plugins and their constraints.
+func ValidatePluginOrder(order []string) error {
+	pos := make(map[string]int)
+	for k, v := range order {
+		if _, ok := plugins[v]; !ok {
+			return fmt.Errorf("invalid plugin_order: plugin %s is not registered", v)
+		}
+		if _, ok := pos[v]; ok {
+			return fmt.Errorf("invalid plugin_order: plugin %s is duplicated", v)
+		}
+		pos[v] = k
+	}
+	for k, name := range order {
+		c := pluginConstraints[name]
+		for _, v := range c.Requires {
+			if _, ok := pos[v]; !ok {
+				return fmt.Errorf("invalid plugin_order: plugin %s requires plugin %s to be enabled", name, v)
+			}
+		}
+		for _, v := range c.After {
+			if p, ok := pos[v]; ok && p > k {
+				return fmt.Errorf("invalid plugin_order: plugin %s must be placed after plugin %s", name, v)
+			}
+		}
+		for _, v := range c.Before {
+			if p, ok := pos[v]; ok && p < k {
+				return fmt.Errorf("invalid plugin_order: plugin %s must be placed before plugin %s", name, v)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DrmagicE/gmqtt/config"
+)
+
+func TestValidatePluginOrder(t *testing.T) {
+	a := assert.New(t)
+	newPlugin := func(config config.Config) (Plugin, error) {
+		return nil, nil
+	}
+	for _, v := range []string{"order_auth", "order_acl", "order_metrics", "order_bridge"} {
+		RegisterPlugin(v, newPlugin)
+	}
+	RegisterPluginConstraints("order_acl", PluginConstraints{
+		Requires: []string{"order_auth"},
+		After:    []string{"order_auth"},
+	})
+	RegisterPluginConstraints("order_bridge", PluginConstraints{
+		Before: []string{"order_acl"},
+	})
+	defer func() {
+		for _, v := range []string{"order_auth", "order_acl", "order_metrics", "order_bridge"} {
+			delete(plugins, v)
+			delete(pluginConstraints, v)
+		}
+	}()
+
+	var tt = []struct {
+		order []string
+		err   string
+	}{
+		{order: nil},
+		{order: []string{"order_metrics"}},
+		{order: []string{"order_auth", "order_acl"}},
+		{order: []string{"order_bridge", "order_auth", "order_metrics", "order_acl"}},
+		// the ordering constraints only apply to the enabled plugins.
+		{order: []string{"order_metrics", "order_bridge"}},
+		{
+			order: []string{"order_auth", "order_unknown"},
+			err:   "invalid plugin_order: plugin order_unknown is not registered",
+		},
+		{
+			order: []string{"order_auth", "order_auth"},
+			err:   "invalid plugin_order: plugin order_auth is duplicated",
+		},
+		{
+			order: []string{"order_acl"},
+			err:   "invalid plugin_order: plugin order_acl requires plugin order_auth to be enabled",
+		},
+		{
+			order: []string{"order_acl", "order_auth"},
+			err:   "invalid plugin_order: plugin order_acl must be placed after plugin order_auth",
+		},
+		{
+			order: []string{"order_auth", "order_acl", "order_bridge"},
+			err:   "invalid plugin_order: plugin order_bridge must be placed before plugin order_acl",
+		},
+	}
+	for _, v := range tt {
+		err := ValidatePluginOrder(v.order)
+		if v.err == "" {
+			a.NoError(err, v.order)
+		} else {
+			a.EqualError(err, v.err, v.order)
+		}
+	}
+	a.Panics(func() {
+		RegisterPluginConstraints("order_acl", PluginConstraints{})
+	})
+}
@@ -0,0 +1,555 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/DrmagicE/gmqtt"
+	"github.com/DrmagicE/gmqtt/config"
+	"github.com/DrmagicE/gmqtt/pkg/codes"
+	"github.com/DrmagicE/gmqtt/pkg/packets"
+)
+
+// PluginFailureStats is the panic statistics of a plugin.
+type PluginFailureStats struct {
+	Plugin string
+	// PanicTotal is the number of the panics raised by the hooks of the plugin.
+	PanicTotal uint64
+	// Disabled indicates whether the plugin is disabled by the circuit breaker.
+	Disabled bool
+}
+
+func newPluginFailureError(code codes.Code) *codes.Error {
+	return &codes.Error{
+		Code: code,
+		ErrorDetails: codes.ErrorDetails{
+			ReasonString: []byte("plugin failure"),
+		},
+	}
+}
+
+// pluginGuard recovers the panics raised by the hooks of a plugin, and disables the plugin after repeated panics.
+type pluginGuard struct {
+	plugin   string
+	config   config.PluginSandbox
+	failOpen bool
+	// now is the clock, it can be replaced in tests.
+	now func() time.Time
+
+	mu sync.Mutex
+	// panics is the time of the recent panics within the window.
+	panics     []time.Time
+	panicTotal uint64
+	disabled   bool
+	// disabledAt is the time when the plugin is disabled.
+	disabledAt time.Time
+}
+
+// pluginSandbox holds the guards of all plugins.
+type pluginSandbox struct {
+	config config.PluginSandbox
+	mu     sync.Mutex
+	guards []*pluginGuard
+}
+
+func newPluginSandbox(cfg config.PluginSandbox) *pluginSandbox {
+	return &pluginSandbox{
+		config: cfg,
+	}
+}
+
+func (s *pluginSandbox) guard(plugin string) *pluginGuard {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	g := &pluginGuard{
+		plugin:   plugin,
+		config:   s.config,
+		failOpen: s.config.Policy == config.HookFailOpen,
+		now:      time.Now,
+	}
+	s.guards = append(s.guards, g)
+	return g
+}
+
+// getStats returns the panic statistics of all plugins, nil if the sandbox is disabled.
+func (s *pluginSandbox) getStats() []PluginFailureStats {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stats := make([]PluginFailureStats, 0, len(s.guards))
+	for _, v := range s.guards {
+		stats = append(stats, v.stats())
+	}
+	return stats
+}
+
+func (g *pluginGuard) stats() PluginFailureStats {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.checkCooldown()
+	return PluginFailureStats{
+		Plugin:     g.plugin,
+		PanicTotal: g.panicTotal,
+		Disabled:   g.disabled,
+	}
+}
+
+// checkCooldown enables the disabled plugin after the cooldown, g.mu must be held.
+func (g *pluginGuard) checkCooldown() {
+	if g.disabled && g.config.Cooldown > 0 && g.now().Sub(g.disabledAt) >= g.config.Cooldown {
+		g.disabled = false
+		g.panics = nil
+		zaplog.Info("plugin enabled after the cooldown", zap.String("plugin", g.plugin))
+	}
+}
+
+// isDisabled returns whether the plugin is disabled by the circuit breaker.
+func (g *pluginGuard) isDisabled() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.checkCooldown()
+	return g.disabled
+}
+
+// recovered records the panic of the hook, and disables the plugin if there are too many panics within the window.
+func (g *pluginGuard) recovered(hook string, r interface{}) {
+	zaplog.Error("plugin hook panic recovered",
+		zap.String("plugin", g.plugin),
+		zap.String("hook", hook),
+		zap.String("panic", fmt.Sprint(r)),
+		zap.Stack("stack"))
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.panicTotal++
+	if g.config.MaxPanics == 0 || g.disabled {
+		return
+	}
+	now := g.now()
+	var i int
+	for i < len(g.panics) && now.Sub(g.panics[i]) >= g.config.Window {
+		i++
+	}
+	g.panics = append(g.panics[i:], now)
+	if len(g.panics) >= g.config.MaxPanics {
+		g.disabled = true
+		g.disabledAt = now
+		zaplog.Error("plugin disabled by too many panics",
+			zap.String("plugin", g.plugin),
+			zap.Int("panics", len(g.panics)),
+			zap.Duration("window", g.config.Window),
+			zap.Duration("cooldown", g.config.Cooldown))
+	}
+}
+
+// run calls fn and returns false if fn panics.
+func (g *pluginGuard) run(hook string, fn func()) (ok bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			g.recovered(hook, r)
+			ok = false
+		}
+	}()
+	fn()
+	return true
+}
+
+// failure returns the result of the hook which decides the result of the connection or packet,
+// when the hook panics or the plugin is disabled.
+func (g *pluginGuard) failure(code codes.Code) error {
+	if g.failOpen {
+		return nil
+	}
+	return newPluginFailureError(code)
+}
+
+// guardedHookWrapper wraps all hooks of the plugin to recover the panics raised by them.
+// Each plugin in the chain has its own guard, so the panic raised by the following plugins is recovered by their guards.
+// If the hook panics before calling the following plugins, they are skipped for that call.
+func (g *pluginGuard) guardedHookWrapper(hooks HookWrapper) HookWrapper {
+	if w := hooks.OnAcceptWrapper; w != nil {
+		hooks.OnAcceptWrapper = func(pre OnAccept) OnAccept {
+			fn := w(pre)
+			return func(ctx context.Context, conn net.Conn) bool {
+				if g.isDisabled() {
+					if g.failOpen {
+						return pre(ctx, conn)
+					}
+					return false
+				}
+				var ok bool
+				if !g.run("OnAccept", func() { ok = fn(ctx, conn) }) {
+					return g.failOpen
+				}
+				return ok
+			}
+		}
+	}
+	if w := hooks.OnStopWrapper; w != nil {
+		hooks.OnStopWrapper = func(pre OnStop) OnStop {
+			fn := w(pre)
+			return func(ctx context.Context) {
+				if g.isDisabled() {
+					pre(ctx)
+					return
+				}
+				g.run("OnStop", func() { fn(ctx) })
+			}
+		}
+	}
+	if w := hooks.OnBasicAuthWrapper; w != nil {
+		hooks.OnBasicAuthWrapper = func(pre OnBasicAuth) OnBasicAuth {
+			fn := w(pre)
+			return func(ctx context.Context, client Client, req *ConnectRequest) error {
+				if g.isDisabled() {
+					if g.failOpen {
+						return pre(ctx, client, req)
+					}
+					return newPluginFailureError(codes.ServerUnavailable)
+				}
+				var err error
+				if !g.run("OnBasicAuth", func() { err = fn(ctx, client, req) }) {
+					return g.failure(codes.ServerUnavailable)
+				}
+				return err
+			}
+		}
+	}
+	if w := hooks.OnEnhancedAuthWrapper; w != nil {
+		hooks.OnEnhancedAuthWrapper = func(pre OnEnhancedAuth) OnEnhancedAuth {
+			fn := w(pre)
+			return func(ctx context.Context, client Client, req *ConnectRequest) (*EnhancedAuthResponse, error) {
+				if g.isDisabled() {
+					if g.failOpen {
+						return pre(ctx, client, req)
+					}
+					return nil, newPluginFailureError(codes.ServerUnavailable)
+				}
+				var resp *EnhancedAuthResponse
+				var err error
+				if !g.run("OnEnhancedAuth", func() { resp, err = fn(ctx, client, req) }) {
+					// the enhanced authentication can not continue without the response, so it always fails.
+					return nil, newPluginFailureError(codes.ServerUnavailable)
+				}
+				return resp, err
+			}
+		}
+	}
+	if w := hooks.OnReAuthWrapper; w != nil {
+		hooks.OnReAuthWrapper = func(pre OnReAuth) OnReAuth {
+			fn := w(pre)
+			return func(ctx context.Context, client Client, auth *packets.Auth) (*AuthResponse, error) {
+				if g.isDisabled() {
+					if g.failOpen {
+						return pre(ctx, client, auth)
+					}
+					return nil, newPluginFailureError(codes.UnspecifiedError)
+				}
+				var resp *AuthResponse
+				var err error
+				if !g.run("OnReAuth", func() { resp, err = fn(ctx, client, auth) }) {
+					// the re-authentication can not continue without the response, so it always fails.
+					return nil, newPluginFailureError(codes.UnspecifiedError)
+				}
+				return resp, err
+			}
+		}
+	}
+	if w := hooks.OnConnectedWrapper; w != nil {
+		hooks.OnConnectedWrapper = func(pre OnConnected) OnConnected {
+			fn := w(pre)
+			return func(ctx context.Context, client Client) {
+				if g.isDisabled() {
+					pre(ctx, client)
+					return
+				}
+				g.run("OnConnected", func() { fn(ctx, client) })
+			}
+		}
+	}
+	if w := hooks.OnSessionCreatedWrapper; w != nil {
+		hooks.OnSessionCreatedWrapper = func(pre OnSessionCreated) OnSessionCreated {
+			fn := w(pre)
+			return func(ctx context.Context, client Client) {
+				if g.isDisabled() {
+					pre(ctx, client)
+					return
+				}
+				g.run("OnSessionCreated", func() { fn(ctx, client) })
+			}
+		}
+	}
+	if w := hooks.OnSessionResumedWrapper; w != nil {
+		hooks.OnSessionResumedWrapper = func(pre OnSessionResumed) OnSessionResumed {
+			fn := w(pre)
+			return func(ctx context.Context, client Client, queued int) {
+				if g.isDisabled() {
+					pre(ctx, client, queued)
+					return
+				}
+				g.run("OnSessionResumed", func() { fn(ctx, client, queued) })
+			}
+		}
+	}
+	if w := hooks.OnSessionTerminatedWrapper; w != nil {
+		hooks.OnSessionTerminatedWrapper = func(pre OnSessionTerminated) OnSessionTerminated {
+			fn := w(pre)
+			return func(ctx context.Context, clientID string, reason SessionTerminatedReason) {
+				if g.isDisabled() {
+					pre(ctx, clientID, reason)
+					return
+				}
+				g.run("OnSessionTerminated", func() { fn(ctx, clientID, reason) })
+			}
+		}
+	}
+	if w := hooks.OnSessionExpiredWrapper; w != nil {
+		hooks.OnSessionExpiredWrapper = func(pre OnSessionExpired) OnSessionExpired {
+			fn := w(pre)
+			return func(ctx context.Context, clientID string, session *gmqtt.Session) {
+				if g.isDisabled() {
+					pre(ctx, clientID, session)
+					return
+				}
+				g.run("OnSessionExpired", func() { fn(ctx, clientID, session) })
+			}
+		}
+	}
+	if w := hooks.OnOfflineBacklogWrapper; w != nil {
+		hooks.OnOfflineBacklogWrapper = func(pre OnOfflineBacklog) OnOfflineBacklog {
+			fn := w(pre)
+			return func(ctx context.Context, clientID string, queued int) {
+				if g.isDisabled() {
+					pre(ctx, clientID, queued)
+					return
+				}
+				g.run("OnOfflineBacklog", func() { fn(ctx, clientID, queued) })
+			}
+		}
+	}
+	if w := hooks.OnSessionTakenOverWrapper; w != nil {
+		hooks.OnSessionTakenOverWrapper = func(pre OnSessionTakenOver) OnSessionTakenOver {
+			fn := w(pre)
+			return func(ctx context.Context, client Client, info *TakeoverInfo) {
+				if g.isDisabled() {
+					pre(ctx, client, info)
+					return
+				}
+				g.run("OnSessionTakenOver", func() { fn(ctx, client, info) })
+			}
+		}
+	}
+	if w := hooks.OnSubscribeWrapper; w != nil {
+		hooks.OnSubscribeWrapper = func(pre OnSubscribe) OnSubscribe {
+			fn := w(pre)
+			return func(ctx context.Context, client Client, req *SubscribeRequest) error {
+				if g.isDisabled() {
+					if g.failOpen {
+						return pre(ctx, client, req)
+					}
+					return newPluginFailureError(codes.UnspecifiedError)
+				}
+				var err error
+				if !g.run("OnSubscribe", func() { err = fn(ctx, client, req) }) {
+					return g.failure(codes.UnspecifiedError)
+				}
+				return err
+			}
+		}
+	}
+	if w := hooks.OnSubscribedWrapper; w != nil {
+		hooks.OnSubscribedWrapper = func(pre OnSubscribed) OnSubscribed {
+			fn := w(pre)
+			return func(ctx context.Context, client Client, subscription *gmqtt.Subscription) {
+				if g.isDisabled() {
+					pre(ctx, client, subscription)
+					return
+				}
+				g.run("OnSubscribed", func() { fn(ctx, client, subscription) })
+			}
+		}
+	}
+	if w := hooks.OnUnsubscribeWrapper; w != nil {
+		hooks.OnUnsubscribeWrapper = func(pre OnUnsubscribe) OnUnsubscribe {
+			fn := w(pre)
+			return func(ctx context.Context, client Client, req *UnsubscribeRequest) error {
+				if g.isDisabled() {
+					if g.failOpen {
+						return pre(ctx, client, req)
+					}
+					return newPluginFailureError(codes.UnspecifiedError)
+				}
+				var err error
+				if !g.run("OnUnsubscribe", func() { err = fn(ctx, client, req) }) {
+					return g.failure(codes.UnspecifiedError)
+				}
+				return err
+			}
+		}
+	}
+	if w := hooks.OnUnsubscribedWrapper; w != nil {
+		hooks.OnUnsubscribedWrapper = func(pre OnUnsubscribed) OnUnsubscribed {
+			fn := w(pre)
+			return func(ctx context.Context, client Client, topicName string) {
+				if g.isDisabled() {
+					pre(ctx, client, topicName)
+					return
+				}
+				g.run("OnUnsubscribed", func() { fn(ctx, client, topicName) })
+			}
+		}
+	}
+	if w := hooks.OnMsgArrivedWrapper; w != nil {
+		hooks.OnMsgArrivedWrapper = func(pre OnMsgArrived) OnMsgArrived {
+			fn := w(pre)
+			return func(ctx context.Context, client Client, req *MsgArrivedRequest) error {
+				if g.isDisabled() {
+					if g.failOpen {
+						return pre(ctx, client, req)
+					}
+					return newPluginFailureError(codes.UnspecifiedError)
+				}
+				var err error
+				if !g.run("OnMsgArrived", func() { err = fn(ctx, client, req) }) {
+					return g.failure(codes.UnspecifiedError)
+				}
+				return err
+			}
+		}
+	}
+	if w := hooks.OnMsgDroppedWrapper; w != nil {
+		hooks.OnMsgDroppedWrapper = func(pre OnMsgDropped) OnMsgDropped {
+			fn := w(pre)
+			return func(ctx context.Context, clientID string, msg *gmqtt.Message, err error) {
+				if g.isDisabled() {
+					pre(ctx, clientID, msg, err)
+					return
+				}
+				g.run("OnMsgDropped", func() { fn(ctx, clientID, msg, err) })
+			}
+		}
+	}
+	if w := hooks.OnDeliveredWrapper; w != nil {
+		hooks.OnDeliveredWrapper = func(pre OnDelivered) OnDelivered {
+			fn := w(pre)
+			return func(ctx context.Context, client Client, msg *gmqtt.Message) {
+				if g.isDisabled() {
+					pre(ctx, client, msg)
+					return
+				}
+				g.run("OnDelivered", func() { fn(ctx, client, msg) })
+			}
+		}
+	}
+	if w := hooks.OnDeliveryReportWrapper; w != nil {
+		hooks.OnDeliveryReportWrapper = func(pre OnDeliveryReport) OnDeliveryReport {
+			fn := w(pre)
+			return func(ctx context.Context, client Client, report *DeliveryReport) {
+				if g.isDisabled() {
+					pre(ctx, client, report)
+					return
+				}
+				g.run("OnDeliveryReport", func() { fn(ctx, client, report) })
+			}
+		}
+	}
+	if w := hooks.OnClosedWrapper; w != nil {
+		hooks.OnClosedWrapper = func(pre OnClosed) OnClosed {
+			fn := w(pre)
+			return func(ctx context.Context, client Client, reason *CloseReason) {
+				if g.isDisabled() {
+					pre(ctx, client, reason)
+					return
+				}
+				g.run("OnClosed", func() { fn(ctx, client, reason) })
+			}
+		}
+	}
+	if w := hooks.OnWillPublishWrapper; w != nil {
+		hooks.OnWillPublishWrapper = func(pre OnWillPublish) OnWillPublish {
+			fn := w(pre)
+			return func(ctx context.Context, clientID string, req *WillMsgRequest) {
+				if g.isDisabled() {
+					pre(ctx, clientID, req)
+					return
+				}
+				g.run("OnWillPublish", func() { fn(ctx, clientID, req) })
+			}
+		}
+	}
+	if w := hooks.OnWillPublishedWrapper; w != nil {
+		hooks.OnWillPublishedWrapper = func(pre OnWillPublished) OnWillPublished {
+			fn := w(pre)
+			return func(ctx context.Context, clientID string, msg *gmqtt.Message) {
+				if g.isDisabled() {
+					pre(ctx, clientID, msg)
+					return
+				}
+				g.run("OnWillPublished", func() { fn(ctx, clientID, msg) })
+			}
+		}
+	}
+	if w := hooks.OnPacketReceivedWrapper; w != nil {
+		hooks.OnPacketReceivedWrapper = func(pre OnPacketReceived) OnPacketReceived {
+			fn := w(pre)
+			return func(ctx context.Context, client Client, packet packets.Packet) error {
+				if g.isDisabled() {
+					if g.failOpen {
+						return pre(ctx, client, packet)
+					}
+					return newPluginFailureError(codes.UnspecifiedError)
+				}
+				var err error
+				if !g.run("OnPacketReceived", func() { err = fn(ctx, client, packet) }) {
+					return g.failure(codes.UnspecifiedError)
+				}
+				return err
+			}
+		}
+	}
+	if w := hooks.OnPacketSendWrapper; w != nil {
+		hooks.OnPacketSendWrapper = func(pre OnPacketSend) OnPacketSend {
+			fn := w(pre)
+			return func(ctx context.Context, client Client, packet packets.Packet) error {
+				if g.isDisabled() {
+					if g.failOpen {
+						return pre(ctx, client, packet)
+					}
+					return newPluginFailureError(codes.UnspecifiedError)
+				}
+				var err error
+				if !g.run("OnPacketSend", func() { err = fn(ctx, client, packet) }) {
+					return g.failure(codes.UnspecifiedError)
+				}
+				return err
+			}
+		}
+	}
+	if w := hooks.OnPSKLookupWrapper; w != nil {
+		hooks.OnPSKLookupWrapper = func(pre OnPSKLookup) OnPSKLookup {
+			fn := w(pre)
+			return func(ctx context.Context, identity string) ([]byte, error) {
+				if g.isDisabled() {
+					if g.failOpen {
+						return pre(ctx, identity)
+					}
+					return nil, newPluginFailureError(codes.ServerUnavailable)
+				}
+				var key []byte
+				var err error
+				if !g.run("OnPSKLookup", func() { key, err = fn(ctx, identity) }) {
+					// the handshake can not continue without the key, so it always fails.
+					return nil, newPluginFailureError(codes.ServerUnavailable)
+				}
+				return key, err
+			}
+		}
+	}
+	return hooks
+}
@@ -0,0 +1,113 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DrmagicE/gmqtt/config"
+	"github.com/DrmagicE/gmqtt/pkg/codes"
+)
+
+func TestPluginGuard_guardedHookWrapper(t *testing.T) {
+	a := assert.New(t)
+	cfg := config.DefaultPluginSandbox
+	cfg.Enable = true
+	cfg.MaxPanics = 2
+	cfg.Window = time.Minute
+	cfg.Cooldown = time.Minute
+	s := newPluginSandbox(cfg)
+
+	var panicking bool
+	var calls []string
+	buggy := s.guard("buggy").guardedHookWrapper(HookWrapper{
+		OnMsgArrivedWrapper: func(pre OnMsgArrived) OnMsgArrived {
+			return func(ctx context.Context, client Client, req *MsgArrivedRequest) error {
+				calls = append(calls, "buggy")
+				if panicking {
+					panic("buggy")
+				}
+				return pre(ctx, client, req)
+			}
+		},
+	})
+	now := time.Now()
+	buggyGuard := s.guards[0]
+	buggyGuard.now = func() time.Time { return now }
+	other := s.guard("other").guardedHookWrapper(HookWrapper{
+		OnMsgArrivedWrapper: func(pre OnMsgArrived) OnMsgArrived {
+			return func(ctx context.Context, client Client, req *MsgArrivedRequest) error {
+				calls = append(calls, "other")
+				return pre(ctx, client, req)
+			}
+		},
+	})
+	onMsgArrived := buggy.OnMsgArrivedWrapper(other.OnMsgArrivedWrapper(func(ctx context.Context, client Client, req *MsgArrivedRequest) error {
+		return nil
+	}))
+
+	a.Nil(onMsgArrived(context.Background(), nil, &MsgArrivedRequest{}))
+	a.Equal([]string{"buggy", "other"}, calls)
+
+	// fail closed on panic
+	panicking = true
+	calls = nil
+	err := onMsgArrived(context.Background(), nil, &MsgArrivedRequest{})
+	a.Equal(codes.UnspecifiedError, err.(*codes.Error).Code)
+	a.Equal([]string{"buggy"}, calls)
+	a.Equal([]PluginFailureStats{
+		{Plugin: "buggy", PanicTotal: 1},
+		{Plugin: "other"},
+	}, s.getStats())
+
+	// the first panic is out of the window.
+	now = now.Add(time.Minute)
+	a.NotNil(onMsgArrived(context.Background(), nil, &MsgArrivedRequest{}))
+	a.False(buggyGuard.isDisabled())
+	a.NotNil(onMsgArrived(context.Background(), nil, &MsgArrivedRequest{}))
+	a.True(buggyGuard.isDisabled())
+	a.Equal(PluginFailureStats{Plugin: "buggy", PanicTotal: 3, Disabled: true}, s.getStats()[0])
+
+	// the decision hooks of the disabled plugin fail closed without calling it.
+	calls = nil
+	a.NotNil(onMsgArrived(context.Background(), nil, &MsgArrivedRequest{}))
+	a.Nil(calls)
+
+	// the disabled plugin is skipped with fail_open.
+	buggyGuard.failOpen = true
+	a.Nil(onMsgArrived(context.Background(), nil, &MsgArrivedRequest{}))
+	a.Equal([]string{"other"}, calls)
+
+	// enabled again after the cooldown
+	now = now.Add(time.Minute)
+	a.False(buggyGuard.isDisabled())
+	calls = nil
+	a.Nil(onMsgArrived(context.Background(), nil, &MsgArrivedRequest{}))
+	a.Equal([]string{"buggy"}, calls)
+
+	var nilSandbox *pluginSandbox
+	a.Nil(nilSandbox.getStats())
+}
+
+func TestPluginGuard_notificationHook(t *testing.T) {
+	a := assert.New(t)
+	cfg := config.DefaultPluginSandbox
+	cfg.Enable = true
+	cfg.MaxPanics = 0
+	s := newPluginSandbox(cfg)
+	hooks := s.guard("buggy").guardedHookWrapper(HookWrapper{
+		OnConnectedWrapper: func(pre OnConnected) OnConnected {
+			return func(ctx context.Context, client Client) {
+				panic("buggy")
+			}
+		},
+	})
+	onConnected := hooks.OnConnectedWrapper(func(ctx context.Context, client Client) {})
+	for i := 0; i < 10; i++ {
+		onConnected(context.Background(), nil)
+	}
+	// never disabled
+	a.Equal([]PluginFailureStats{{Plugin: "buggy", PanicTotal: 10}}, s.getStats())
+}
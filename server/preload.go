@@ -0,0 +1,167 @@
+package server
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/DrmagicE/gmqtt"
+	"github.com/DrmagicE/gmqtt/config"
+	"github.com/DrmagicE/gmqtt/persistence/session"
+)
+
+// preloader tracks the progress of the preloading, see config.Preload.
+type preloader struct {
+	config   config.Preload
+	started  time.Time
+	deadline time.Time
+	// stage is the current stage, e.g. sessions and subscriptions.
+	stage      string
+	total      int
+	loaded     int
+	lastReport time.Time
+	now        func() time.Time
+}
+
+func newPreloader(cfg config.Preload) *preloader {
+	p := &preloader{
+		config: cfg,
+		now:    time.Now,
+	}
+	p.started = p.now()
+	p.lastReport = p.started
+	if cfg.Timeout > 0 {
+		p.deadline = p.started.Add(cfg.Timeout)
+	}
+	return p
+}
+
+// begin starts a new stage, total is -1 if it is unknown.
+func (p *preloader) begin(stage string, total int) {
+	p.stage = stage
+	p.total = total
+	p.loaded = 0
+}
+
+// progress adds n to the loaded items of the current stage, logs the progress every progress interval,
+// and returns an error if the timeout is exceeded.
+func (p *preloader) progress(n int) error {
+	p.loaded += n
+	now := p.now()
+	if !p.deadline.IsZero() && now.After(p.deadline) {
+		return fmt.Errorf("preload timeout: %s loaded %d after %s", p.stage, p.loaded, p.config.Timeout)
+	}
+	if now.Sub(p.lastReport) >= p.config.ProgressInterval {
+		p.lastReport = now
+		fields := []zap.Field{
+			zap.String("stage", p.stage),
+			zap.Int("loaded", p.loaded),
+			zap.Duration("elapsed", now.Sub(p.started)),
+		}
+		if p.total >= 0 {
+			fields = append(fields, zap.Int("total", p.total))
+		}
+		zaplog.Info("preloading", fields...)
+	}
+	return nil
+}
+
+// loadSubscriptions loads the subscriptions of the clients into the subscription trie batch by batch.
+func (srv *server) loadSubscriptions(p *preloader, cids []string) error {
+	p.begin("subscriptions", len(cids))
+	for len(cids) > 0 {
+		n := p.config.BatchSize
+		if n > len(cids) {
+			n = len(cids)
+		}
+		if err := srv.subscriptionsDB.Init(cids[:n]); err != nil {
+			return err
+		}
+		if err := p.progress(n); err != nil {
+			return err
+		}
+		cids = cids[n:]
+	}
+	return nil
+}
+
+var _ session.Store = (*sessionCache)(nil)
+
+// sessionCache is the write-through in-memory cache in front of the session store.
+// It is filled with all sessions at startup, and the server is the only writer of the session store,
+// so that the lookups are answered from the memory, including the lookups of the clients without a session.
+type sessionCache struct {
+	session.Store
+	mu   sync.RWMutex
+	sess map[string]*gmqtt.Session
+}
+
+func newSessionCache(store session.Store) *sessionCache {
+	return &sessionCache{
+		Store: store,
+		sess:  make(map[string]*gmqtt.Session),
+	}
+}
+
+// add adds the session loaded at startup.
+func (s *sessionCache) add(sess *gmqtt.Session) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sess[sess.ClientID] = sess
+}
+
+func (s *sessionCache) Set(sess *gmqtt.Session) error {
+	if err := s.Store.Set(sess); err != nil {
+		return err
+	}
+	s.add(sess)
+	return nil
+}
+
+func (s *sessionCache) Remove(clientID string) error {
+	if err := s.Store.Remove(clientID); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sess, clientID)
+	return nil
+}
+
+func (s *sessionCache) Get(clientID string) (*gmqtt.Session, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.sess[clientID], nil
+}
+
+// SetSessionExpiry replaces the cached session with a copy, because the session may be read by others concurrently.
+func (s *sessionCache) SetSessionExpiry(clientID string, expiry uint32) error {
+	if err := s.Store.SetSessionExpiry(clientID, expiry); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if sess, ok := s.sess[clientID]; ok {
+		c := *sess
+		c.ExpiryInterval = expiry
+		s.sess[clientID] = &c
+	}
+	return nil
+}
+
+// SetAttributes replaces the cached session with a copy, because the session may be read by others concurrently.
+func (s *sessionCache) SetAttributes(clientID string, attrs map[string]string) error {
+	if err := s.Store.SetAttributes(clientID, attrs); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if sess, ok := s.sess[clientID]; ok {
+		c := *sess
+		c.Attributes = attrs
+		s.sess[clientID] = &c
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DrmagicE/gmqtt"
+	"github.com/DrmagicE/gmqtt/config"
+	sessmem "github.com/DrmagicE/gmqtt/persistence/session/mem"
+	"github.com/DrmagicE/gmqtt/persistence/subscription"
+)
+
+func TestPreloader_progress(t *testing.T) {
+	a := assert.New(t)
+	p := newPreloader(config.Preload{
+		Timeout:          time.Minute,
+		ProgressInterval: time.Second,
+		BatchSize:        2,
+	})
+	now := p.started
+	p.now = func() time.Time {
+		return now
+	}
+	p.begin("sessions", -1)
+	a.NoError(p.progress(1))
+	now = now.Add(time.Second)
+	a.NoError(p.progress(1))
+	a.Equal(now, p.lastReport)
+	a.Equal(2, p.loaded)
+
+	p.begin("subscriptions", 10)
+	a.Equal(0, p.loaded)
+	now = now.Add(time.Minute)
+	a.Error(p.progress(1))
+
+	// no timeout
+	p = newPreloader(config.Preload{ProgressInterval: time.Second})
+	p.now = func() time.Time {
+		return now.Add(time.Hour)
+	}
+	a.NoError(p.progress(1))
+}
+
+func TestServer_loadSubscriptions(t *testing.T) {
+	a := assert.New(t)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	subDB := subscription.NewMockStore(ctrl)
+	srv := &server{subscriptionsDB: subDB}
+	p := newPreloader(config.Preload{ProgressInterval: time.Second, BatchSize: 2})
+	gomock.InOrder(
+		subDB.EXPECT().Init([]string{"a", "b"}),
+		subDB.EXPECT().Init([]string{"c"}),
+	)
+	a.NoError(srv.loadSubscriptions(p, []string{"a", "b", "c"}))
+	a.Equal(3, p.loaded)
+}
+
+func TestSessionCache(t *testing.T) {
+	a := assert.New(t)
+	store := sessmem.New()
+	a.NoError(store.Set(&gmqtt.Session{ClientID: "a", ExpiryInterval: 10}))
+	c := newSessionCache(store)
+	a.NoError(store.Iterate(func(sess *gmqtt.Session) bool {
+		c.add(sess)
+		return true
+	}))
+
+	sess, err := c.Get("a")
+	a.NoError(err)
+	a.EqualValues(10, sess.ExpiryInterval)
+	// the lookups of the clients without a session are answered from the cache as well.
+	sess, err = c.Get("b")
+	a.NoError(err)
+	a.Nil(sess)
+
+	a.NoError(c.Set(&gmqtt.Session{ClientID: "b"}))
+	sess, err = c.Get("b")
+	a.NoError(err)
+	a.Equal("b", sess.ClientID)
+	sess, err = store.Get("b")
+	a.NoError(err)
+	a.Equal("b", sess.ClientID)
+
+	old, _ := c.Get("a")
+	a.NoError(c.SetSessionExpiry("a", 20))
+	a.NoError(c.SetAttributes("a", map[string]string{"k": "v"}))
+	sess, _ = c.Get("a")
+	a.EqualValues(20, sess.ExpiryInterval)
+	a.Equal(map[string]string{"k": "v"}, sess.Attributes)
+	// the session read before is not modified.
+	a.Nil(old.Attributes)
+
+	a.NoError(c.Remove("a"))
+	sess, _ = c.Get("a")
+	a.Nil(sess)
+	sess, _ = store.Get("a")
+	a.Nil(sess)
+}
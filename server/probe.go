@@ -0,0 +1,51 @@
+package server
+
+import (
+	"net"
+
+	"go.uber.org/zap"
+
+	"github.com/DrmagicE/gmqtt/config"
+)
+
+// probeListener applies the liveness probing options to the accepted TCP connections.
+// It must wrap the raw TCP listener, before the TLS wrapping.
+type probeListener struct {
+	net.Listener
+	opts config.ProbeOptions
+}
+
+func newProbeListener(ln net.Listener, opts config.ProbeOptions) net.Listener {
+	return &probeListener{
+		Listener: ln,
+		opts:     opts,
+	}
+}
+
+func (l *probeListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if tc, ok := c.(*net.TCPConn); ok {
+		if err := setProbe(tc, l.opts); err != nil {
+			zaplog.Warn("fail to set the probe options",
+				zap.String("remote_addr", c.RemoteAddr().String()),
+				zap.Error(err))
+		}
+	}
+	return c, nil
+}
+
+// setProbe enables the TCP keepalive probes of the connection and applies the platform specific options.
+func setProbe(tc *net.TCPConn, opts config.ProbeOptions) error {
+	if err := tc.SetKeepAlive(true); err != nil {
+		return err
+	}
+	if opts.Idle > 0 {
+		if err := tc.SetKeepAlivePeriod(opts.Idle); err != nil {
+			return err
+		}
+	}
+	return setProbeOptions(tc, opts)
+}
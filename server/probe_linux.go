@@ -0,0 +1,39 @@
+// +build linux
+
+package server
+
+import (
+	"net"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/DrmagicE/gmqtt/config"
+)
+
+func setProbeOptions(tc *net.TCPConn, opts config.ProbeOptions) error {
+	rc, err := tc.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var serr error
+	err = rc.Control(func(fd uintptr) {
+		if opts.Interval > 0 {
+			if serr = unix.SetsockoptInt(int(fd), unix.IPPROTO_TCP, unix.TCP_KEEPINTVL, int(opts.Interval/time.Second)); serr != nil {
+				return
+			}
+		}
+		if opts.Count > 0 {
+			if serr = unix.SetsockoptInt(int(fd), unix.IPPROTO_TCP, unix.TCP_KEEPCNT, opts.Count); serr != nil {
+				return
+			}
+		}
+		if opts.WriteTimeout > 0 {
+			serr = unix.SetsockoptInt(int(fd), unix.IPPROTO_TCP, unix.TCP_USER_TIMEOUT, int(opts.WriteTimeout/time.Millisecond))
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return serr
+}
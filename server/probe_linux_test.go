@@ -0,0 +1,56 @@
+// +build linux
+
+package server
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/unix"
+
+	"github.com/DrmagicE/gmqtt/config"
+)
+
+func TestSetProbe(t *testing.T) {
+	a := assert.New(t)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	a.Nil(err)
+	defer ln.Close()
+	go func() {
+		c, err := net.Dial("tcp", ln.Addr().String())
+		if err == nil {
+			defer c.Close()
+			time.Sleep(100 * time.Millisecond)
+		}
+	}()
+	c, err := ln.Accept()
+	a.Nil(err)
+	defer c.Close()
+	tc := c.(*net.TCPConn)
+	a.Nil(setProbe(tc, config.ProbeOptions{
+		Idle:         20 * time.Second,
+		Interval:     5 * time.Second,
+		Count:        4,
+		WriteTimeout: 15 * time.Second,
+	}))
+
+	rc, err := tc.SyscallConn()
+	a.Nil(err)
+	a.Nil(rc.Control(func(fd uintptr) {
+		for opt, expected := range map[int]int{
+			unix.TCP_KEEPIDLE:     20,
+			unix.TCP_KEEPINTVL:    5,
+			unix.TCP_KEEPCNT:      4,
+			unix.TCP_USER_TIMEOUT: 15000,
+		} {
+			v, err := unix.GetsockoptInt(int(fd), unix.IPPROTO_TCP, opt)
+			a.Nil(err)
+			a.Equal(expected, v)
+		}
+		v, err := unix.GetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_KEEPALIVE)
+		a.Nil(err)
+		a.Equal(1, v)
+	}))
+}
@@ -0,0 +1,19 @@
+// +build !linux
+
+package server
+
+import (
+	"errors"
+	"net"
+
+	"github.com/DrmagicE/gmqtt/config"
+)
+
+var errProbeUnsupported = errors.New("probe.interval, probe.count and probe.write_timeout are only supported on linux")
+
+func setProbeOptions(tc *net.TCPConn, opts config.ProbeOptions) error {
+	if opts.Interval > 0 || opts.Count > 0 || opts.WriteTimeout > 0 {
+		return errProbeUnsupported
+	}
+	return nil
+}
@@ -0,0 +1,31 @@
+package server
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DrmagicE/gmqtt/config"
+)
+
+func TestProbeListener(t *testing.T) {
+	a := assert.New(t)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	a.Nil(err)
+	pl := newProbeListener(ln, config.ProbeOptions{Idle: 10 * time.Second})
+	defer pl.Close()
+
+	go func() {
+		c, err := net.Dial("tcp", ln.Addr().String())
+		if err == nil {
+			defer c.Close()
+			time.Sleep(100 * time.Millisecond)
+		}
+	}()
+	c, err := pl.Accept()
+	a.Nil(err)
+	defer c.Close()
+	a.IsType(&net.TCPConn{}, c)
+}
@@ -0,0 +1,148 @@
+package server
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"unicode/utf8"
+
+	"github.com/DrmagicE/gmqtt"
+	"github.com/DrmagicE/gmqtt/config"
+	"github.com/DrmagicE/gmqtt/persistence/queue"
+	"github.com/DrmagicE/gmqtt/pkg/packets"
+)
+
+const payloadEncodingBase64 = "base64"
+
+// envelope is the JSON payload which carries the V5 properties for the V3 clients.
+type envelope struct {
+	Payload string `json:"payload"`
+	// PayloadEncoding is "base64" if the payload is not a UTF-8 string.
+	PayloadEncoding string                 `json:"payload_encoding,omitempty"`
+	ContentType     string                 `json:"content_type,omitempty"`
+	PayloadFormat   *byte                  `json:"payload_format,omitempty"`
+	ResponseTopic   string                 `json:"response_topic,omitempty"`
+	CorrelationData []byte                 `json:"correlation_data,omitempty"`
+	UserProperties  []envelopeUserProperty `json:"user_properties,omitempty"`
+}
+
+type envelopeUserProperty struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// mapPropertiesToV3 converts the V5 message for the V3 client according to the property mapping.
+// It returns queue.ErrDropIncompatible if the message must not be delivered to the V3 client.
+// The message is not modified, the envelope is set to the payload of the copy.
+func mapPropertiesToV3(pm config.PropertyMapping, msg *gmqtt.Message) (*gmqtt.Message, error) {
+	var env envelope
+	var wrap bool
+	for _, v := range []struct {
+		mode    string
+		present bool
+		set     func()
+	}{
+		{
+			mode:    pm.UserProperties,
+			present: len(msg.UserProperties) != 0,
+			set: func() {
+				for _, p := range msg.UserProperties {
+					env.UserProperties = append(env.UserProperties, envelopeUserProperty{Key: string(p.K), Value: string(p.V)})
+				}
+			},
+		},
+		{
+			mode:    pm.ContentType,
+			present: msg.ContentType != "" || msg.PayloadFormat == packets.PayloadFormatString,
+			set: func() {
+				env.ContentType = msg.ContentType
+				if msg.PayloadFormat == packets.PayloadFormatString {
+					f := msg.PayloadFormat
+					env.PayloadFormat = &f
+				}
+			},
+		},
+		{
+			mode:    pm.ResponseTopic,
+			present: msg.ResponseTopic != "" || len(msg.CorrelationData) != 0,
+			set: func() {
+				env.ResponseTopic = msg.ResponseTopic
+				env.CorrelationData = msg.CorrelationData
+			},
+		},
+	} {
+		if !v.present {
+			continue
+		}
+		switch v.mode {
+		case config.PropertyMappingReject:
+			return nil, queue.ErrDropIncompatible
+		case config.PropertyMappingEnvelope:
+			v.set()
+			wrap = true
+		}
+	}
+	if !wrap {
+		return msg, nil
+	}
+	if utf8.Valid(msg.Payload) {
+		env.Payload = string(msg.Payload)
+	} else {
+		env.Payload = base64.StdEncoding.EncodeToString(msg.Payload)
+		env.PayloadEncoding = payloadEncodingBase64
+	}
+	b, err := json.Marshal(env)
+	if err != nil {
+		return nil, err
+	}
+	m := msg.ShallowCopy()
+	m.Payload = b
+	return m, nil
+}
+
+// unwrapEnvelope converts the envelope published by the V3 client into the V5 properties.
+// The message is not modified if the payload is not a valid envelope.
+func unwrapEnvelope(msg *gmqtt.Message) {
+	if len(msg.Payload) == 0 || msg.Payload[0] != '{' {
+		return
+	}
+	d := json.NewDecoder(bytes.NewReader(msg.Payload))
+	d.DisallowUnknownFields()
+	var env struct {
+		envelope
+		// Payload must be present.
+		Payload *string `json:"payload"`
+	}
+	if err := d.Decode(&env); err != nil || d.More() || env.Payload == nil {
+		return
+	}
+	payload := []byte(*env.Payload)
+	switch env.PayloadEncoding {
+	case "":
+	case payloadEncodingBase64:
+		b, err := base64.StdEncoding.DecodeString(*env.Payload)
+		if err != nil {
+			return
+		}
+		payload = b
+	default:
+		return
+	}
+	if env.PayloadFormat != nil && *env.PayloadFormat > packets.PayloadFormatString {
+		return
+	}
+	if env.ResponseTopic != "" && !packets.ValidTopicName(true, []byte(env.ResponseTopic)) {
+		return
+	}
+	msg.Payload = payload
+	msg.ContentType = env.ContentType
+	if env.PayloadFormat != nil {
+		msg.PayloadFormat = *env.PayloadFormat
+	}
+	msg.ResponseTopic = env.ResponseTopic
+	msg.CorrelationData = env.CorrelationData
+	msg.UserProperties = nil
+	for _, v := range env.UserProperties {
+		msg.UserProperties = append(msg.UserProperties, packets.UserProperty{K: []byte(v.Key), V: []byte(v.Value)})
+	}
+}
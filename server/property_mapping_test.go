@@ -0,0 +1,132 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DrmagicE/gmqtt"
+	"github.com/DrmagicE/gmqtt/config"
+	"github.com/DrmagicE/gmqtt/persistence/queue"
+	"github.com/DrmagicE/gmqtt/persistence/subscription/mem"
+	"github.com/DrmagicE/gmqtt/pkg/packets"
+)
+
+func TestMapPropertiesToV3(t *testing.T) {
+	a := assert.New(t)
+	msg := &gmqtt.Message{
+		Topic:           "a",
+		Payload:         []byte("hello"),
+		ContentType:     "text/plain",
+		PayloadFormat:   packets.PayloadFormatString,
+		ResponseTopic:   "reply",
+		CorrelationData: []byte{1, 2},
+		UserProperties:  []packets.UserProperty{{K: []byte("k"), V: []byte("v")}},
+	}
+	pm := config.DefaultMQTTConfig.PropertyMapping
+	m, err := mapPropertiesToV3(pm, msg)
+	a.NoError(err)
+	a.Equal(msg, m)
+
+	pm.UserProperties = config.PropertyMappingEnvelope
+	m, err = mapPropertiesToV3(pm, msg)
+	a.NoError(err)
+	a.JSONEq(`{"payload":"hello","user_properties":[{"key":"k","value":"v"}]}`, string(m.Payload))
+	a.Equal("hello", string(msg.Payload))
+
+	pm.ContentType = config.PropertyMappingEnvelope
+	pm.ResponseTopic = config.PropertyMappingEnvelope
+	m, err = mapPropertiesToV3(pm, msg)
+	a.NoError(err)
+	a.JSONEq(`{"payload":"hello","content_type":"text/plain","payload_format":1,"response_topic":"reply",`+
+		`"correlation_data":"AQI=","user_properties":[{"key":"k","value":"v"}]}`, string(m.Payload))
+
+	// round trip
+	v3 := &gmqtt.Message{Topic: "a", Payload: m.Payload}
+	unwrapEnvelope(v3)
+	a.Equal(msg, v3)
+
+	bin := &gmqtt.Message{Topic: "a", Payload: []byte{0xff, 0x00}, ResponseTopic: "reply"}
+	m, err = mapPropertiesToV3(pm, bin)
+	a.NoError(err)
+	a.JSONEq(`{"payload":"/wA=","payload_encoding":"base64","response_topic":"reply"}`, string(m.Payload))
+	v3 = &gmqtt.Message{Topic: "a", Payload: m.Payload}
+	unwrapEnvelope(v3)
+	a.Equal(bin, v3)
+
+	// the absent properties are not rejected
+	pm.UserProperties = config.PropertyMappingReject
+	m, err = mapPropertiesToV3(pm, bin)
+	a.NoError(err)
+	a.NotEqual(bin.Payload, m.Payload)
+	_, err = mapPropertiesToV3(pm, msg)
+	a.Equal(queue.ErrDropIncompatible, err)
+
+	// not envelopes
+	for _, v := range []string{
+		``,
+		`hello`,
+		`{}`,
+		`{"payload":1}`,
+		`{"payload":"a","unknown":1}`,
+		`{"payload":"a","payload_encoding":"hex"}`,
+		`{"payload":"!","payload_encoding":"base64"}`,
+		`{"payload":"a","payload_format":2}`,
+		`{"payload":"a","response_topic":"a/#"}`,
+		`{"payload":"a"} {}`,
+	} {
+		m := &gmqtt.Message{Topic: "a", Payload: []byte(v)}
+		unwrapEnvelope(m)
+		a.Equal(&gmqtt.Message{Topic: "a", Payload: []byte(v)}, m, v)
+	}
+}
+
+func TestClient_pollNewMessages_propertyMapping(t *testing.T) {
+	a := assert.New(t)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	srv := defaultServer()
+	srv.statsManager = newStatsManager(mem.NewStore())
+	srv.config.MQTT.PropertyMapping.UserProperties = config.PropertyMappingReject
+	srv.config.MQTT.PropertyMapping.ResponseTopic = config.PropertyMappingEnvelope
+	c, er := srv.newClient(noopConn{})
+	a.Nil(er)
+	c.opts.ClientID = "cid"
+	c.version = packets.Version311
+	c.opts.MaxInflight = 10
+	c.newPacketIDLimiter(c.opts.MaxInflight)
+	qs := queue.NewMockStore(ctrl)
+	c.queueStore = qs
+
+	userProps := []packets.UserProperty{{K: []byte("k"), V: []byte("v")}}
+	ids := c.pl.pollPacketIDs(3)
+	a.Len(ids, 3)
+	qs.EXPECT().Read(ids).Return([]*queue.Elem{
+		{MessageWithID: &queue.Publish{Message: &gmqtt.Message{Topic: "a", QoS: 1, PacketID: ids[0], Payload: []byte("1"), UserProperties: userProps}}},
+		{MessageWithID: &queue.Publish{Message: &gmqtt.Message{Topic: "a", QoS: 0, Payload: []byte("2"), UserProperties: userProps}}},
+		{MessageWithID: &queue.Publish{Message: &gmqtt.Message{Topic: "a", QoS: 1, PacketID: ids[1], Payload: []byte("3"), ResponseTopic: "reply"}}},
+	}, nil)
+	qs.EXPECT().Remove(ids[0]).Return(nil)
+	unused, err := c.pollNewMessages(ids)
+	a.NoError(err)
+	a.Equal(ids[2:], unused)
+	// the packet id of the rejected message is released
+	a.EqualValues(2, c.pl.used)
+
+	select {
+	case p := <-c.out:
+		pub := p.(*packets.Publish)
+		a.Equal(ids[1], pub.PacketID)
+		a.JSONEq(`{"payload":"3","response_topic":"reply"}`, string(pub.Payload))
+	default:
+		t.Fatal("missing output")
+	}
+	select {
+	case p := <-c.out:
+		t.Fatalf("unexpected output: %v", p)
+	default:
+	}
+	a.EqualValues(1, srv.statsManager.GetGlobalStats().MessageStats.Qos1.DroppedTotal.Incompatible)
+	a.EqualValues(1, srv.statsManager.GetGlobalStats().MessageStats.Qos0.DroppedTotal.Incompatible)
+}
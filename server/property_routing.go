@@ -0,0 +1,43 @@
+package server
+
+import (
+	"bytes"
+
+	"github.com/DrmagicE/gmqtt"
+	"github.com/DrmagicE/gmqtt/config"
+)
+
+// propertyRouted returns whether the message is routed to the subscription by the property routing rule of the topic.
+// The subscription which does not declare the user property of the rule receives all messages.
+func propertyRouted(rule *config.PropertyRoutingRule, msg *gmqtt.Message, sub *gmqtt.Subscription) bool {
+	if rule == nil {
+		return true
+	}
+	declared := false
+	for _, sp := range sub.UserProperties {
+		if string(sp.K) != rule.UserProperty {
+			continue
+		}
+		declared = true
+		for _, mp := range msg.UserProperties {
+			if string(mp.K) == rule.UserProperty && bytes.Equal(mp.V, sp.V) {
+				return true
+			}
+		}
+	}
+	return !declared
+}
+
+// filterPropertyRouted returns the retained messages which are routed to the subscription by the property routing rules.
+func (client *client) filterPropertyRouted(msgs []*gmqtt.Message, sub *gmqtt.Subscription) []*gmqtt.Message {
+	if len(client.config.MQTT.PropertyRouting) == 0 || len(sub.UserProperties) == 0 {
+		return msgs
+	}
+	rs := msgs[:0]
+	for _, v := range msgs {
+		if propertyRouted(client.config.MQTT.PropertyRoutingRule(v.Topic), v, sub) {
+			rs = append(rs, v)
+		}
+	}
+	return rs
+}
@@ -0,0 +1,87 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DrmagicE/gmqtt"
+	"github.com/DrmagicE/gmqtt/config"
+	"github.com/DrmagicE/gmqtt/persistence/queue"
+	"github.com/DrmagicE/gmqtt/pkg/packets"
+)
+
+func TestPropertyRouted(t *testing.T) {
+	a := assert.New(t)
+	rule := &config.PropertyRoutingRule{TopicFilter: "telemetry/#", UserProperty: "region"}
+	eu := &gmqtt.Message{Topic: "telemetry/a", UserProperties: []packets.UserProperty{{K: []byte("region"), V: []byte("eu")}}}
+	us := &gmqtt.Message{Topic: "telemetry/a", UserProperties: []packets.UserProperty{{K: []byte("region"), V: []byte("us")}}}
+	none := &gmqtt.Message{Topic: "telemetry/a"}
+
+	euSub := &gmqtt.Subscription{TopicFilter: "telemetry/#", UserProperties: []packets.UserProperty{
+		{K: []byte("app"), V: []byte("dashboard")},
+		{K: []byte("region"), V: []byte("eu")},
+	}}
+	allSub := &gmqtt.Subscription{TopicFilter: "telemetry/#"}
+
+	a.True(propertyRouted(rule, eu, euSub))
+	a.False(propertyRouted(rule, us, euSub))
+	a.False(propertyRouted(rule, none, euSub))
+	a.True(propertyRouted(rule, eu, allSub))
+	a.True(propertyRouted(rule, none, allSub))
+	a.True(propertyRouted(nil, us, euSub))
+
+	// any of the declared values
+	euSub.UserProperties = append(euSub.UserProperties, packets.UserProperty{K: []byte("region"), V: []byte("us")})
+	a.True(propertyRouted(rule, us, euSub))
+}
+
+func TestServer_deliverMessage_propertyRouting(t *testing.T) {
+	a := assert.New(t)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	subscriber := "subCli"
+	ts := newTestDeliverMsg(ctrl, subscriber)
+	srv := ts.srv
+	srv.config.MQTT.PropertyRouting = []config.PropertyRoutingRule{
+		{TopicFilter: "telemetry/#", UserProperty: "region"},
+	}
+	srv.subscriptionsDB.Subscribe(subscriber, &gmqtt.Subscription{
+		TopicFilter:    "telemetry/#",
+		QoS:            1,
+		UserProperties: []packets.UserProperty{{K: []byte("region"), V: []byte("eu")}},
+	})
+	mockQueue := srv.registry.shard(subscriber).queueStore[subscriber].(*queue.MockStore)
+	mockQueue.EXPECT().Add(gomock.Any()).Do(func(elem *queue.Elem) {
+		a.Equal("telemetry/eu", elem.MessageWithID.(*queue.Publish).Topic)
+	})
+
+	msg := &gmqtt.Message{Topic: "telemetry/us", UserProperties: []packets.UserProperty{{K: []byte("region"), V: []byte("us")}}}
+	a.False(srv.deliverMessage("", msg, defaultIterateOptions(msg.Topic)))
+	msg = &gmqtt.Message{Topic: "telemetry/eu", UserProperties: []packets.UserProperty{{K: []byte("region"), V: []byte("eu")}}}
+	a.True(srv.deliverMessage("", msg, defaultIterateOptions(msg.Topic)))
+}
+
+func TestClient_filterPropertyRouted(t *testing.T) {
+	a := assert.New(t)
+	srv := defaultServer()
+	c, err := srv.newClient(noopConn{})
+	a.Nil(err)
+	sub := &gmqtt.Subscription{TopicFilter: "#"}
+	msgs := []*gmqtt.Message{
+		{Topic: "telemetry/a", UserProperties: []packets.UserProperty{{K: []byte("region"), V: []byte("eu")}}},
+		{Topic: "telemetry/b"},
+		{Topic: "cmd/a"},
+	}
+	a.Len(c.filterPropertyRouted(msgs, sub), 3)
+
+	c.config.MQTT.PropertyRouting = []config.PropertyRoutingRule{
+		{TopicFilter: "telemetry/#", UserProperty: "region"},
+	}
+	sub.UserProperties = []packets.UserProperty{{K: []byte("region"), V: []byte("eu")}}
+	rs := c.filterPropertyRouted(msgs, sub)
+	a.Len(rs, 2)
+	a.Equal("telemetry/a", rs[0].Topic)
+	a.Equal("cmd/a", rs[1].Topic)
+}
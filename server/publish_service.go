@@ -7,7 +7,11 @@ type publishService struct {
 }
 
 func (p *publishService) Publish(message *gmqtt.Message) {
-	p.server.mu.Lock()
 	p.server.deliverMessage("", message, defaultIterateOptions(message.Topic))
-	p.server.mu.Unlock()
+}
+
+func (p *publishService) PublishTo(clientID string, message *gmqtt.Message) {
+	options := defaultIterateOptions(message.Topic)
+	options.ClientID = clientID
+	p.server.deliverMessage("", message, options)
 }
@@ -28,7 +28,11 @@ func defaultNotifier(dropHook OnMsgDropped, sts *statsManager, clientID string)
 
 func (q *queueNotifier) notifyDropped(msg *gmqtt.Message, err error) {
 	cid := q.cli.opts.ClientID
-	zaplog.Warn("message dropped", zap.String("client_id", cid), zap.Error(err))
+	zaplog.Warn("message dropped",
+		zap.String("client_id", cid),
+		zap.String("topic", msg.Topic),
+		zap.String("reason", string(DropReasonOf(err))),
+		zap.Error(err))
 	q.sts.messageDropped(msg.QoS, q.cli.opts.ClientID, err)
 	if q.dropHook != nil {
 		q.dropHook(context.Background(), cid, msg, err)
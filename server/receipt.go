@@ -0,0 +1,326 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/DrmagicE/gmqtt"
+	"github.com/DrmagicE/gmqtt/config"
+	"github.com/DrmagicE/gmqtt/pkg/packets"
+)
+
+// The status of the delivery receipts.
+const (
+	// ReceiptDelivered means the message has been acknowledged by all matching subscribers.
+	ReceiptDelivered = "delivered"
+	// ReceiptExpired means the message has been dropped or expired for some of the matching subscribers,
+	// or the acknowledgements are not received before the timeout.
+	ReceiptExpired = "expired"
+	// ReceiptNoSubscriber means there is no matching subscriber for the message.
+	ReceiptNoSubscriber = "no_subscriber"
+)
+
+// Receipt is the payload of the delivery receipt, it is encoded in JSON.
+type Receipt struct {
+	Status string `json:"status"`
+	// Topic is the topic of the message, the mount point of the publisher is stripped.
+	Topic string `json:"topic"`
+	// Subscribers is the number of the subscribers which the message is routed to.
+	Subscribers int `json:"subscribers"`
+	// Acknowledged is the number of the subscribers which have acknowledged the message.
+	Acknowledged int `json:"acknowledged"`
+	// Dropped is the number of the subscribers which the message is dropped or expired for.
+	Dropped int `json:"dropped"`
+}
+
+// receipt is a delivery receipt which is waiting for the acknowledgements.
+type receipt struct {
+	Receipt
+	replyTopic      string
+	correlationData []byte
+	deadline        time.Time
+	// routing is true until the message has been routed to all matching subscribers.
+	routing bool
+	// copies is the messages queued for the subscribers.
+	copies []*gmqtt.Message
+}
+
+type receiptInflightKey struct {
+	clientID string
+	id       packets.PacketID
+}
+
+// receiptTracker tracks the messages queued for each subscriber to publish the delivery receipts.
+// The messages are tracked by the pointers, so the receipts of the messages restored from the persistent queue
+// (e.g. after a restart with redis) can only be completed by the timeout.
+// All methods are nil-safe, the nil tracker tracks nothing.
+type receiptTracker struct {
+	config config.DeliveryReceipts
+	mu     sync.Mutex
+	// copies maps the message queued for a subscriber to the receipt.
+	copies map[*gmqtt.Message]*receipt
+	// inflight maps the packet id of the QoS 1 and QoS 2 message sent to the subscriber to the queued message.
+	inflight map[receiptInflightKey]*gmqtt.Message
+	// pending is the receipts which are waiting for the acknowledgements.
+	pending map[*receipt]struct{}
+	// done is the completed receipts which are waiting to be published.
+	done []*receipt
+	// ready is notified when there are completed receipts.
+	ready chan struct{}
+}
+
+func newReceiptTracker(cfg config.DeliveryReceipts) *receiptTracker {
+	return &receiptTracker{
+		config:   cfg,
+		copies:   make(map[*gmqtt.Message]*receipt),
+		inflight: make(map[receiptInflightKey]*gmqtt.Message),
+		pending:  make(map[*receipt]struct{}),
+		ready:    make(chan struct{}, 1),
+	}
+}
+
+// begin starts a receipt for the QoS 1 or QoS 2 message, and returns the message without the user property
+// which requests the receipt. It returns nil receipt if the receipt is not requested.
+func (r *receiptTracker) begin(now time.Time, msg *gmqtt.Message, srcClientID, mountPoint string) (*gmqtt.Message, *receipt) {
+	if r == nil || msg.QoS == packets.Qos0 {
+		return msg, nil
+	}
+	var replyTopic string
+	if name := r.config.UserProperty; name != "" {
+		var props []packets.UserProperty
+		for _, v := range msg.UserProperties {
+			if string(v.K) != name {
+				props = append(props, v)
+				continue
+			}
+			if replyTopic == "" {
+				replyTopic = mountPoint + string(v.V)
+			}
+		}
+		if len(props) != len(msg.UserProperties) {
+			msg = msg.ShallowCopy()
+			msg.UserProperties = props
+		}
+	}
+	if replyTopic == "" {
+		replyTopic = r.config.ReplyTopic(msg.Topic, srcClientID)
+	}
+	if replyTopic == "" {
+		return msg, nil
+	}
+	if !packets.ValidTopicName(true, []byte(replyTopic)) {
+		zaplog.Warn("invalid receipt reply topic", zap.String("client_id", srcClientID), zap.String("reply_topic", replyTopic))
+		return msg, nil
+	}
+	timeout := r.config.Timeout
+	if d := time.Duration(msg.MessageExpiry) * time.Second; d != 0 && d < timeout {
+		timeout = d
+	}
+	rc := &receipt{
+		Receipt: Receipt{
+			Topic: strings.TrimPrefix(msg.Topic, mountPoint),
+		},
+		replyTopic:      replyTopic,
+		correlationData: msg.CorrelationData,
+		deadline:        now.Add(timeout),
+		routing:         true,
+	}
+	r.mu.Lock()
+	r.pending[rc] = struct{}{}
+	r.mu.Unlock()
+	return msg, rc
+}
+
+// track records the message queued for a subscriber, it must be called before the message is added into the queue.
+func (r *receiptTracker) track(rc *receipt, msg *gmqtt.Message) {
+	if r == nil || rc == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.pending[rc]; !ok {
+		return
+	}
+	r.copies[msg] = rc
+	rc.copies = append(rc.copies, msg)
+	rc.Subscribers++
+}
+
+// routed marks the message has been routed to all matching subscribers.
+func (r *receiptTracker) routed(rc *receipt) {
+	if r == nil || rc == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	rc.routing = false
+	r.tryFinishLocked(rc)
+}
+
+// sent records the packet id of the queued message which is sent to the subscriber.
+// The QoS 0 message (downgraded by the subscription) is regarded as acknowledged once it is sent.
+func (r *receiptTracker) sent(clientID string, msg *gmqtt.Message, pub *packets.Publish) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.copies[msg]; !ok {
+		return
+	}
+	if pub.Qos == packets.Qos0 {
+		r.completeLocked(msg, true)
+		return
+	}
+	r.inflight[receiptInflightKey{clientID: clientID, id: pub.PacketID}] = msg
+}
+
+// acknowledged completes the message acknowledged by PUBACK or PUBCOMP, or rejected by PUBREC.
+func (r *receiptTracker) acknowledged(clientID string, id packets.PacketID, rejected bool) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key := receiptInflightKey{clientID: clientID, id: id}
+	if msg, ok := r.inflight[key]; ok {
+		delete(r.inflight, key)
+		r.completeLocked(msg, !rejected)
+	}
+}
+
+// dropped completes the queued message which is dropped or expired.
+func (r *receiptTracker) dropped(msg *gmqtt.Message) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.completeLocked(msg, false)
+}
+
+func (r *receiptTracker) completeLocked(msg *gmqtt.Message, acknowledged bool) {
+	rc, ok := r.copies[msg]
+	if !ok {
+		return
+	}
+	delete(r.copies, msg)
+	if acknowledged {
+		rc.Acknowledged++
+	} else {
+		rc.Dropped++
+	}
+	r.tryFinishLocked(rc)
+}
+
+func (r *receiptTracker) tryFinishLocked(rc *receipt) {
+	if rc.routing || rc.Acknowledged+rc.Dropped < rc.Subscribers {
+		return
+	}
+	r.finishLocked(rc)
+}
+
+func (r *receiptTracker) finishLocked(rc *receipt) {
+	if _, ok := r.pending[rc]; !ok {
+		return
+	}
+	delete(r.pending, rc)
+	for _, v := range rc.copies {
+		delete(r.copies, v)
+	}
+	rc.copies = nil
+	switch {
+	case rc.Subscribers == 0:
+		rc.Status = ReceiptNoSubscriber
+	case rc.Acknowledged == rc.Subscribers:
+		rc.Status = ReceiptDelivered
+	default:
+		rc.Status = ReceiptExpired
+	}
+	r.done = append(r.done, rc)
+	select {
+	case r.ready <- struct{}{}:
+	default:
+	}
+}
+
+// expire finishes the receipts which are timed out.
+func (r *receiptTracker) expire(now time.Time) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var expired bool
+	for rc := range r.pending {
+		if !rc.routing && now.After(rc.deadline) {
+			r.finishLocked(rc)
+			expired = true
+		}
+	}
+	if !expired {
+		return
+	}
+	for k, v := range r.inflight {
+		if _, ok := r.copies[v]; !ok {
+			delete(r.inflight, k)
+		}
+	}
+}
+
+// flush returns the completed receipts.
+func (r *receiptTracker) flush() []*receipt {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	done := r.done
+	r.done = nil
+	return done
+}
+
+// wrapOnMsgDropped wraps the OnMsgDropped hook to complete the dropped messages.
+func (r *receiptTracker) wrapOnMsgDropped(pre OnMsgDropped) OnMsgDropped {
+	return func(ctx context.Context, clientID string, msg *gmqtt.Message, err error) {
+		r.dropped(msg)
+		if pre != nil {
+			pre(ctx, clientID, msg, err)
+		}
+	}
+}
+
+// mountPointOf returns the mount point of the connected client.
+func (srv *server) mountPointOf(clientID string) string {
+	if clientID == "" {
+		return ""
+	}
+	s := srv.registry.shard(clientID)
+	s.rlock()
+	defer s.runlock()
+	if c := s.clients[clientID]; c != nil {
+		return c.opts.MountPoint
+	}
+	return ""
+}
+
+// publishReceipts publishes the completed receipts.
+func (srv *server) publishReceipts() {
+	for _, v := range srv.receipts.flush() {
+		b, err := json.Marshal(v.Receipt)
+		if err != nil {
+			zaplog.Error("fail to encode receipt", zap.Error(err))
+			continue
+		}
+		msg := &gmqtt.Message{
+			QoS:             packets.Qos1,
+			Topic:           v.replyTopic,
+			Payload:         b,
+			ContentType:     "application/json",
+			CorrelationData: v.correlationData,
+		}
+		srv.routeMessage("", msg, defaultIterateOptions(msg.Topic), nil)
+	}
+}
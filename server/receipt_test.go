@@ -0,0 +1,176 @@
+package server
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DrmagicE/gmqtt"
+	"github.com/DrmagicE/gmqtt/config"
+	"github.com/DrmagicE/gmqtt/persistence/queue"
+	"github.com/DrmagicE/gmqtt/pkg/packets"
+)
+
+func TestReceiptTracker_begin(t *testing.T) {
+	a := assert.New(t)
+	var nilTracker *receiptTracker
+	msg := &gmqtt.Message{Topic: "a", QoS: 1}
+	m, rc := nilTracker.begin(time.Now(), msg, "c", "")
+	a.Equal(msg, m)
+	a.Nil(rc)
+
+	r := newReceiptTracker(config.DeliveryReceipts{
+		UserProperty: "receipt-topic",
+		Rules: []config.ReceiptRule{
+			{TopicFilter: "cmd/#", ReplyTopic: "receipts/{client_id}"},
+		},
+		Timeout: time.Minute,
+	})
+	now := time.Now()
+	// user property
+	msg = &gmqtt.Message{
+		Topic:           "tenant/a",
+		QoS:             1,
+		CorrelationData: []byte("cd"),
+		MessageExpiry:   10,
+		UserProperties: []packets.UserProperty{
+			{K: []byte("k"), V: []byte("v")},
+			{K: []byte("receipt-topic"), V: []byte("reply")},
+		},
+	}
+	m, rc = r.begin(now, msg, "c", "tenant/")
+	a.NotNil(rc)
+	a.Equal([]packets.UserProperty{{K: []byte("k"), V: []byte("v")}}, m.UserProperties)
+	a.Len(msg.UserProperties, 2)
+	a.Equal("tenant/reply", rc.replyTopic)
+	a.Equal("a", rc.Topic)
+	a.Equal([]byte("cd"), rc.correlationData)
+	a.Equal(now.Add(10*time.Second), rc.deadline)
+
+	// rule
+	_, rc = r.begin(now, &gmqtt.Message{Topic: "cmd/a", QoS: 2}, "c", "")
+	a.Equal("receipts/c", rc.replyTopic)
+	a.Equal(now.Add(time.Minute), rc.deadline)
+	_, rc = r.begin(now, &gmqtt.Message{Topic: "cmd/a", QoS: 2}, "", "")
+	a.Nil(rc)
+
+	// not requested
+	for _, v := range []*gmqtt.Message{
+		{Topic: "cmd/a", QoS: 0},
+		{Topic: "b", QoS: 1},
+		{Topic: "b", QoS: 1, UserProperties: []packets.UserProperty{{K: []byte("receipt-topic"), V: []byte("#")}}},
+	} {
+		_, rc = r.begin(now, v, "c", "")
+		a.Nil(rc)
+	}
+}
+
+func TestReceiptTracker(t *testing.T) {
+	a := assert.New(t)
+	r := newReceiptTracker(config.DeliveryReceipts{
+		Rules:   []config.ReceiptRule{{TopicFilter: "#", ReplyTopic: "reply"}},
+		Timeout: time.Minute,
+	})
+	now := time.Now()
+	msg := &gmqtt.Message{Topic: "a", QoS: 1}
+
+	// delivered
+	_, rc := r.begin(now, msg, "c", "")
+	m1, m2 := msg.ShallowCopy(), msg.ShallowCopy()
+	r.track(rc, m1)
+	r.track(rc, m2)
+	r.sent("c1", m1, &packets.Publish{Qos: 1, PacketID: 1})
+	r.sent("c2", m2, &packets.Publish{Qos: 0})
+	r.acknowledged("c1", 1, false)
+	a.Empty(r.flush())
+	r.routed(rc)
+	done := r.flush()
+	a.Len(done, 1)
+	a.Equal(Receipt{Status: ReceiptDelivered, Topic: "a", Subscribers: 2, Acknowledged: 2}, done[0].Receipt)
+	a.Len(r.ready, 1)
+	<-r.ready
+
+	// dropped and rejected
+	_, rc = r.begin(now, msg, "c", "")
+	m1, m2 = msg.ShallowCopy(), msg.ShallowCopy()
+	r.track(rc, m1)
+	r.track(rc, m2)
+	r.routed(rc)
+	r.dropped(m1)
+	r.sent("c2", m2, &packets.Publish{Qos: 2, PacketID: 2})
+	r.acknowledged("c2", 2, true)
+	done = r.flush()
+	a.Len(done, 1)
+	a.Equal(Receipt{Status: ReceiptExpired, Topic: "a", Subscribers: 2, Dropped: 2}, done[0].Receipt)
+
+	// no subscriber
+	_, rc = r.begin(now, msg, "c", "")
+	r.routed(rc)
+	done = r.flush()
+	a.Len(done, 1)
+	a.Equal(ReceiptNoSubscriber, done[0].Status)
+
+	// timeout
+	_, rc = r.begin(now, msg, "c", "")
+	m1 = msg.ShallowCopy()
+	r.track(rc, m1)
+	r.routed(rc)
+	r.sent("c1", m1, &packets.Publish{Qos: 1, PacketID: 3})
+	r.expire(now.Add(time.Minute))
+	a.Empty(r.flush())
+	r.expire(now.Add(time.Minute + time.Second))
+	done = r.flush()
+	a.Len(done, 1)
+	a.Equal(Receipt{Status: ReceiptExpired, Topic: "a", Subscribers: 1}, done[0].Receipt)
+	a.Empty(r.copies)
+	a.Empty(r.inflight)
+	a.Empty(r.pending)
+	// the late acknowledgement is ignored.
+	r.acknowledged("c1", 3, false)
+	a.Empty(r.flush())
+}
+
+func TestServer_deliverMessage_receipt(t *testing.T) {
+	a := assert.New(t)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	srv := newTestDeliverMsg(ctrl, "subCli").srv
+	srv.config.MQTT.DeliveryReceipts.UserProperty = "receipt-topic"
+	srv.receipts = newReceiptTracker(srv.config.MQTT.DeliveryReceipts)
+	srv.hooks.OnMsgDropped = srv.receipts.wrapOnMsgDropped(srv.hooks.OnMsgDropped)
+	srcQueue := queue.NewMockStore(ctrl)
+	srv.registry.shard("srcCli").queueStore["srcCli"] = srcQueue
+	srv.subscriptionsDB.Subscribe("subCli", &gmqtt.Subscription{TopicFilter: "a/#", QoS: 1})
+	srv.subscriptionsDB.Subscribe("srcCli", &gmqtt.Subscription{TopicFilter: "reply/#", QoS: 1})
+
+	var queued *gmqtt.Message
+	subQueue := srv.registry.shard("subCli").queueStore["subCli"].(*queue.MockStore)
+	subQueue.EXPECT().Add(gomock.Any()).Do(func(elem *queue.Elem) {
+		queued = elem.MessageWithID.(*queue.Publish).Message
+	})
+	msg := &gmqtt.Message{
+		Topic:           "a/b",
+		QoS:             1,
+		CorrelationData: []byte("cd"),
+		UserProperties:  []packets.UserProperty{{K: []byte("receipt-topic"), V: []byte("reply/1")}},
+	}
+	a.True(srv.deliverMessage("srcCli", msg, defaultIterateOptions(msg.Topic)))
+	a.Empty(queued.UserProperties)
+
+	srv.receipts.sent("subCli", queued, &packets.Publish{Qos: 1, PacketID: 1})
+	srv.receipts.acknowledged("subCli", 1, false)
+	srcQueue.EXPECT().Add(gomock.Any()).Do(func(elem *queue.Elem) {
+		m := elem.MessageWithID.(*queue.Publish).Message
+		a.Equal("reply/1", m.Topic)
+		a.Equal([]byte("cd"), m.CorrelationData)
+		a.Equal("application/json", m.ContentType)
+		var r Receipt
+		a.Nil(json.Unmarshal(m.Payload, &r))
+		a.Equal(Receipt{Status: ReceiptDelivered, Topic: "a/b", Subscribers: 1, Acknowledged: 1}, r)
+	})
+	srv.publishReceipts()
+	a.Empty(srv.receipts.pending)
+}
@@ -0,0 +1,47 @@
+package server
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/DrmagicE/gmqtt"
+	"github.com/DrmagicE/gmqtt/config"
+	"github.com/DrmagicE/gmqtt/pkg/packets"
+)
+
+// annotateReceive returns the message stamped with the user properties of the receive annotation rule.
+// The user properties of the same names set by the publisher are replaced, so that the consumers
+// can trust them. The message is copied before stamping because it may be shared with the hooks.
+func annotateReceive(rule *config.ReceiveAnnotation, msg *gmqtt.Message, clientID, username string, receivedAt time.Time) *gmqtt.Message {
+	if rule == nil {
+		return msg
+	}
+	props := make([]packets.UserProperty, 0, len(msg.UserProperties)+3)
+	for _, v := range msg.UserProperties {
+		if k := string(v.K); k != "" && (k == rule.ReceivedAt || k == rule.ClientID || k == rule.Username) {
+			continue
+		}
+		props = append(props, v)
+	}
+	if rule.ReceivedAt != "" {
+		props = append(props, packets.UserProperty{
+			K: []byte(rule.ReceivedAt),
+			V: []byte(strconv.FormatInt(receivedAt.UnixNano()/int64(time.Millisecond), 10)),
+		})
+	}
+	if rule.ClientID != "" {
+		props = append(props, packets.UserProperty{
+			K: []byte(rule.ClientID),
+			V: []byte(clientID),
+		})
+	}
+	if rule.Username != "" && username != "" {
+		props = append(props, packets.UserProperty{
+			K: []byte(rule.Username),
+			V: []byte(username),
+		})
+	}
+	msg = msg.ShallowCopy()
+	msg.UserProperties = props
+	return msg
+}
@@ -0,0 +1,191 @@
+package server
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/DrmagicE/gmqtt"
+	"github.com/DrmagicE/gmqtt/config"
+	"github.com/DrmagicE/gmqtt/persistence/queue"
+	"github.com/DrmagicE/gmqtt/pkg/packets"
+)
+
+// errRedeliveryExhausted closes the connection if the redelivery attempts are exhausted and the exhausted_action is disconnect.
+var errRedeliveryExhausted = errors.New("the inflight message is not acknowledged after the maximum redelivery attempts")
+
+// redeliveryTracker tracks the outgoing QoS 1 and QoS 2 messages and the PUBRELs which are waiting for the acknowledgement,
+// and resends them according to the redelivery policy.
+// All methods are nil-safe, the nil tracker tracks nothing.
+type redeliveryTracker struct {
+	mu     sync.Mutex
+	policy config.Redelivery
+	seq    uint64
+	// inflight is the written packets which are not acknowledged, key by packet id.
+	inflight map[packets.PacketID]*redeliveryElem
+}
+
+type redeliveryElem struct {
+	// packet is *packets.Publish or *packets.Pubrel.
+	packet   packets.Packet
+	seq      uint64
+	attempts int
+	next     time.Time
+}
+
+func newRedeliveryTracker(policy config.Redelivery) *redeliveryTracker {
+	return &redeliveryTracker{
+		policy:   policy,
+		inflight: make(map[packets.PacketID]*redeliveryElem),
+	}
+}
+
+// copyPublish returns a copy of the publish packet which can be modified by the write loop (e.g. the topic alias).
+func copyPublish(pub *packets.Publish) *packets.Publish {
+	p := *pub
+	if pub.Properties != nil {
+		props := *pub.Properties
+		props.TopicAlias = nil
+		p.Properties = &props
+	}
+	return &p
+}
+
+// written starts tracking the QoS 1 or QoS 2 PUBLISH or the PUBREL that is going to be written.
+// The redelivered packet is ignored, it must be called before the topic name is replaced by the topic alias.
+func (r *redeliveryTracker) written(packet packets.Packet, now time.Time) {
+	if r == nil {
+		return
+	}
+	var id packets.PacketID
+	switch p := packet.(type) {
+	case *packets.Publish:
+		if p.Qos == packets.Qos0 {
+			return
+		}
+		id = p.PacketID
+		packet = copyPublish(p)
+	case *packets.Pubrel:
+		id = p.PacketID
+	default:
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if e, ok := r.inflight[id]; ok && packetType(e.packet) == packetType(packet) {
+		return
+	}
+	r.seq++
+	r.inflight[id] = &redeliveryElem{
+		packet: packet,
+		seq:    r.seq,
+		next:   now.Add(r.policy.Interval),
+	}
+}
+
+// acknowledged stops tracking the packet id.
+func (r *redeliveryTracker) acknowledged(id packets.PacketID) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	delete(r.inflight, id)
+	r.mu.Unlock()
+}
+
+// due returns the packets to resend and the packets whose redelivery attempts are exhausted at the given time, in the written order.
+// The exhausted packets are not tracked anymore.
+func (r *redeliveryTracker) due(now time.Time) (resend []packets.Packet, exhausted []packets.Packet) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var elems []*redeliveryElem
+	for id, e := range r.inflight {
+		if e.next.After(now) {
+			continue
+		}
+		if r.policy.MaxAttempts != 0 && e.attempts >= r.policy.MaxAttempts {
+			delete(r.inflight, id)
+		}
+		elems = append(elems, e)
+	}
+	sort.Slice(elems, func(i, j int) bool {
+		return elems[i].seq < elems[j].seq
+	})
+	for _, e := range elems {
+		if r.policy.MaxAttempts != 0 && e.attempts >= r.policy.MaxAttempts {
+			exhausted = append(exhausted, e.packet)
+			continue
+		}
+		e.attempts++
+		e.next = now.Add(r.policy.NextInterval(e.attempts))
+		if p, ok := e.packet.(*packets.Publish); ok {
+			pub := copyPublish(p)
+			pub.Dup = true
+			resend = append(resend, pub)
+		} else {
+			resend = append(resend, e.packet)
+		}
+	}
+	return resend, exhausted
+}
+
+// redeliveryLoop resends the unacknowledged packets until the client is closed.
+func (client *client) redeliveryLoop() {
+	interval := client.redelivery.policy.Interval
+	if interval > time.Second {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-client.close:
+			return
+		case now := <-ticker.C:
+			resend, exhausted := client.redelivery.due(now)
+			for _, p := range resend {
+				client.debug("redeliver", zap.String("packet", packetType(p)), zap.Uint16("pid", packetID(p)))
+				client.write(p)
+			}
+			if len(exhausted) != 0 {
+				if client.redelivery.policy.ExhaustedAction == config.RedeliveryExhaustedDisconnect {
+					client.setError(errRedeliveryExhausted)
+					return
+				}
+				client.dropExhausted(exhausted)
+			}
+		}
+	}
+}
+
+// dropExhausted removes the exhausted packets from the session and reports the messages as dropped.
+func (client *client) dropExhausted(exhausted []packets.Packet) {
+	for _, p := range exhausted {
+		id := packetID(p)
+		if err := client.queueStore.Remove(id); err != nil {
+			client.setError(err)
+			return
+		}
+		client.pl.release(id)
+		client.deliveries.remove(id)
+		client.lanes.acknowledged(id)
+		if pub, ok := p.(*packets.Publish); ok {
+			client.queueNotifier.notifyDropped(gmqtt.MessageFromPublish(pub), queue.ErrDropRedeliveryExhausted)
+		} else {
+			zaplog.Warn("pubrel dropped", client.logFields(zap.Uint16("pid", id), zap.Error(queue.ErrDropRedeliveryExhausted))...)
+		}
+	}
+}
+
+func packetID(packet packets.Packet) packets.PacketID {
+	switch p := packet.(type) {
+	case *packets.Publish:
+		return p.PacketID
+	case *packets.Pubrel:
+		return p.PacketID
+	}
+	return 0
+}
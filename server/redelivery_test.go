@@ -0,0 +1,110 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DrmagicE/gmqtt"
+	"github.com/DrmagicE/gmqtt/config"
+	"github.com/DrmagicE/gmqtt/persistence/queue"
+	"github.com/DrmagicE/gmqtt/persistence/subscription/mem"
+	"github.com/DrmagicE/gmqtt/pkg/packets"
+)
+
+func TestRedeliveryTracker(t *testing.T) {
+	a := assert.New(t)
+	var nilTracker *redeliveryTracker
+	nilTracker.written(&packets.Publish{Qos: packets.Qos1, PacketID: 1}, time.Now())
+	nilTracker.acknowledged(1)
+
+	r := newRedeliveryTracker(config.Redelivery{
+		Interval:    time.Second,
+		Backoff:     2,
+		MaxInterval: 3 * time.Second,
+		MaxAttempts: 3,
+	})
+	now := time.Now()
+	alias := uint16(1)
+	pub := &packets.Publish{Qos: packets.Qos1, PacketID: 2, TopicName: []byte("a"), Properties: &packets.Properties{}}
+	r.written(&packets.Publish{Qos: packets.Qos0, TopicName: []byte("qos0")}, now)
+	r.written(pub, now)
+	r.written(&packets.Pubrel{PacketID: 1}, now)
+	// the topic alias set by the write loop must not affect the redelivery.
+	pub.TopicName = []byte{}
+	pub.Properties.TopicAlias = &alias
+
+	resend, exhausted := r.due(now)
+	a.Empty(resend)
+	a.Empty(exhausted)
+
+	resend, exhausted = r.due(now.Add(time.Second))
+	a.Empty(exhausted)
+	a.Len(resend, 2)
+	p := resend[0].(*packets.Publish)
+	a.True(p.Dup)
+	a.Equal([]byte("a"), p.TopicName)
+	a.Nil(p.Properties.TopicAlias)
+	a.Equal(&packets.Pubrel{PacketID: 1}, resend[1])
+	// the redelivered packet does not reset the attempts.
+	r.written(p, now.Add(time.Second))
+
+	// backoff: 2s
+	resend, _ = r.due(now.Add(2 * time.Second))
+	a.Empty(resend)
+	resend, _ = r.due(now.Add(3 * time.Second))
+	a.Len(resend, 2)
+
+	r.acknowledged(1)
+	// capped by the max interval: 3s
+	resend, _ = r.due(now.Add(6 * time.Second))
+	a.Len(resend, 1)
+	resend, exhausted = r.due(now.Add(9 * time.Second))
+	a.Empty(resend)
+	a.Len(exhausted, 1)
+	a.EqualValues(2, exhausted[0].(*packets.Publish).PacketID)
+	a.Empty(r.inflight)
+}
+
+func TestClient_redeliveryLoop(t *testing.T) {
+	a := assert.New(t)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	srv := defaultServer()
+	srv.statsManager = newStatsManager(mem.NewStore())
+	srv.config.MQTT.Redelivery.Interval = 10 * time.Millisecond
+	srv.config.MQTT.Redelivery.MaxAttempts = 1
+	dropped := make(chan *gmqtt.Message, 1)
+	srv.hooks.OnMsgDropped = func(ctx context.Context, clientID string, msg *gmqtt.Message, err error) {
+		a.Equal(queue.ErrDropRedeliveryExhausted, err)
+		a.Equal(DropReasonRedeliveryExhausted, DropReasonOf(err))
+		dropped <- msg
+	}
+	c, err := srv.newClient(discardConn{})
+	a.NoError(err)
+	a.NotNil(c.redelivery)
+	c.opts.ClientID = "cid"
+	c.version = packets.Version311
+	c.opts.MaxInflight = 10
+	c.newPacketIDLimiter(c.opts.MaxInflight)
+	c.queueNotifier.dropHook = srv.hooks.OnMsgDropped
+	qs := queue.NewMockStore(ctrl)
+	c.queueStore = qs
+	go c.writeLoop()
+	go c.redeliveryLoop()
+	defer c.setError(nil)
+
+	qs.EXPECT().Remove(packets.PacketID(1)).Return(nil)
+	c.write(&packets.Publish{Qos: packets.Qos1, PacketID: 1, TopicName: []byte("a"), Payload: []byte("b")})
+	select {
+	case msg := <-dropped:
+		a.Equal("a", msg.Topic)
+		a.Equal([]byte("b"), msg.Payload)
+	case <-time.After(time.Second):
+		t.Fatal("missing dropped message")
+	}
+	a.EqualValues(1, srv.statsManager.GetGlobalStats().MessageStats.Qos1.DroppedTotal.RedeliveryExhausted)
+}
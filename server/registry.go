@@ -0,0 +1,130 @@
+package server
+
+import (
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/DrmagicE/gmqtt/persistence/queue"
+	"github.com/DrmagicE/gmqtt/persistence/unack"
+)
+
+// registryShardNum is the number of shards of the client registry.
+const registryShardNum = 32
+
+// registry stores the online clients and session states.
+// The maps are sharded by the hash of client id, so that CONNECT/DISCONNECT of different clients
+// do not serialize on a single mutex.
+type registry struct {
+	shards []*registryShard
+	stats  lockStats
+}
+
+// registryShard holds the states of the clients that belong to the shard.
+// All maps must be accessed under the shard lock.
+type registryShard struct {
+	mu    sync.RWMutex
+	stats *lockStats
+	// writers and readers are the number of goroutines that are holding or waiting for the lock.
+	writers int32
+	readers int32
+	// clients stores the online clients
+	clients map[string]*client
+	// offlineClients store the expired time of all disconnected clients
+	// with valid session(not expired). Key by clientID
+	offlineClients map[string]time.Time
+	willMessage    map[string]*willMsg
+	queueStore     map[string]queue.Store
+	unackStore     map[string]unack.Store
+	// offlineQueued is the number of the messages queued for the offline sessions since the clients disconnected.
+	offlineQueued map[string]int
+}
+
+// lockStats records the lock contention of the registry.
+type lockStats struct {
+	acquired  uint64
+	contended uint64
+	waitNanos uint64
+}
+
+func (l *lockStats) observe(contended bool, start time.Time) {
+	atomic.AddUint64(&l.acquired, 1)
+	if contended {
+		atomic.AddUint64(&l.contended, 1)
+		atomic.AddUint64(&l.waitNanos, uint64(time.Since(start)))
+	}
+}
+
+func newRegistry() *registry {
+	r := &registry{
+		shards: make([]*registryShard, registryShardNum),
+	}
+	for i := range r.shards {
+		r.shards[i] = &registryShard{
+			stats:          &r.stats,
+			clients:        make(map[string]*client),
+			offlineClients: make(map[string]time.Time),
+			offlineQueued:  make(map[string]int),
+			willMessage:    make(map[string]*willMsg),
+			queueStore:     make(map[string]queue.Store),
+			unackStore:     make(map[string]unack.Store),
+		}
+	}
+	return r
+}
+
+// shard returns the shard that the clientID belongs to.
+func (r *registry) shard(clientID string) *registryShard {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(clientID))
+	return r.shards[h.Sum32()%uint32(len(r.shards))]
+}
+
+// iterate calls fn for each shard with the write lock held.
+// If fn returns false, the iteration will be stopped.
+func (r *registry) iterate(fn func(s *registryShard) bool) {
+	for _, s := range r.shards {
+		s.lock()
+		cont := fn(s)
+		s.unlock()
+		if !cont {
+			return
+		}
+	}
+}
+
+// getStats returns the lock contention statistics of the registry.
+func (r *registry) getStats() RegistryStats {
+	return RegistryStats{
+		Shards:             uint64(len(r.shards)),
+		LockAcquiredTotal:  atomic.LoadUint64(&r.stats.acquired),
+		LockContendedTotal: atomic.LoadUint64(&r.stats.contended),
+		LockWaitSeconds:    float64(atomic.LoadUint64(&r.stats.waitNanos)) / float64(time.Second),
+	}
+}
+
+func (s *registryShard) lock() {
+	start := time.Now()
+	contended := atomic.AddInt32(&s.writers, 1) > 1 || atomic.LoadInt32(&s.readers) > 0
+	s.mu.Lock()
+	s.stats.observe(contended, start)
+}
+
+func (s *registryShard) unlock() {
+	atomic.AddInt32(&s.writers, -1)
+	s.mu.Unlock()
+}
+
+func (s *registryShard) rlock() {
+	start := time.Now()
+	atomic.AddInt32(&s.readers, 1)
+	contended := atomic.LoadInt32(&s.writers) > 0
+	s.mu.RLock()
+	s.stats.observe(contended, start)
+}
+
+func (s *registryShard) runlock() {
+	atomic.AddInt32(&s.readers, -1)
+	s.mu.RUnlock()
+}
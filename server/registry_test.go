@@ -0,0 +1,69 @@
+package server
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegistry_shard(t *testing.T) {
+	a := assert.New(t)
+	r := newRegistry()
+	a.Len(r.shards, registryShardNum)
+	a.True(r.shard("client") == r.shard("client"))
+
+	used := make(map[*registryShard]struct{})
+	for i := 0; i < 1000; i++ {
+		used[r.shard(strconv.Itoa(i))] = struct{}{}
+	}
+	a.Len(used, registryShardNum)
+}
+
+func TestRegistry_iterate(t *testing.T) {
+	a := assert.New(t)
+	r := newRegistry()
+	for i := 0; i < 100; i++ {
+		cid := strconv.Itoa(i)
+		r.shard(cid).clients[cid] = &client{}
+	}
+	var n int
+	r.iterate(func(s *registryShard) bool {
+		n += len(s.clients)
+		return true
+	})
+	a.Equal(100, n)
+
+	var visited int
+	r.iterate(func(s *registryShard) bool {
+		visited++
+		return false
+	})
+	a.Equal(1, visited)
+}
+
+func TestRegistry_getStats(t *testing.T) {
+	a := assert.New(t)
+	r := newRegistry()
+	s := r.shard("client")
+
+	s.lock()
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		s.rlock()
+		s.runlock()
+	}()
+	time.Sleep(10 * time.Millisecond)
+	s.unlock()
+	wg.Wait()
+
+	stats := r.getStats()
+	a.EqualValues(registryShardNum, stats.Shards)
+	a.EqualValues(2, stats.LockAcquiredTotal)
+	a.EqualValues(1, stats.LockContendedTotal)
+	a.True(stats.LockWaitSeconds > 0)
+}
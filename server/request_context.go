@@ -0,0 +1,79 @@
+package server
+
+import (
+	"context"
+
+	"github.com/DrmagicE/gmqtt/pkg/packets"
+)
+
+// RequestInfo is the request scoped information carried by the context which is passed to the hooks.
+// Use RequestInfoFromContext to get it in the hooks.
+type RequestInfo struct {
+	// CorrelationID is the unique id generated for each inbound control packet,
+	// all hooks and log entries triggered by the same packet share the same id.
+	// It is empty if the hook is not triggered by an inbound packet, e.g. OnClosed and OnDelivered.
+	CorrelationID string
+	// Listener is the label of the listener which accepts the client, defaults to the listener address.
+	Listener string
+	// ClientID is the client identifier of the client.
+	// For the CONNECT packet, it is the client identifier in the packet, which is empty if the server assigns it.
+	ClientID string
+	// Username is the username of the client.
+	Username string
+	// RemoteAddr is the remote network address of the client.
+	RemoteAddr string
+}
+
+type requestInfoKey struct{}
+
+// RequestInfoFromContext returns the RequestInfo carried by the context, ok is false if it is not present.
+func RequestInfoFromContext(ctx context.Context) (info RequestInfo, ok bool) {
+	info, ok = ctx.Value(requestInfoKey{}).(RequestInfo)
+	return
+}
+
+// correlationID returns the correlation id carried by the context, or empty string if not present.
+func correlationID(ctx context.Context) string {
+	info, _ := RequestInfoFromContext(ctx)
+	return info.CorrelationID
+}
+
+// inboundPacket is the packet read from the connection with its request scoped context.
+type inboundPacket struct {
+	ctx    context.Context
+	packet packets.Packet
+}
+
+func (client *client) requestInfo() RequestInfo {
+	return RequestInfo{
+		Listener:   client.listener,
+		ClientID:   client.opts.ClientID,
+		Username:   client.opts.Username,
+		RemoteAddr: client.rwc.RemoteAddr().String(),
+	}
+}
+
+// baseContext returns the context for the hooks which are not triggered by an inbound packet.
+func (client *client) baseContext() context.Context {
+	return context.WithValue(context.Background(), requestInfoKey{}, client.requestInfo())
+}
+
+// newRequestContext returns the context with a new correlation id for the inbound packet.
+func (client *client) newRequestContext(packet packets.Packet) context.Context {
+	info := client.requestInfo()
+	info.CorrelationID = getRandomUUID()
+	if conn, ok := packet.(*packets.Connect); ok {
+		info.ClientID = string(conn.ClientID)
+		info.Username = string(conn.Username)
+	}
+	return context.WithValue(context.Background(), requestInfoKey{}, info)
+}
+
+// requestContext returns the context of the inbound packet which is being handled.
+// It must only be called in the goroutine that handles the inbound packets.
+func (client *client) requestContext() context.Context {
+	if client.reqCtx != nil {
+		return client.reqCtx
+	}
+	return client.baseContext()
+}
@@ -0,0 +1,97 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DrmagicE/gmqtt/persistence/subscription"
+	"github.com/DrmagicE/gmqtt/persistence/subscription/mem"
+	"github.com/DrmagicE/gmqtt/pkg/packets"
+)
+
+func TestClient_requestContext(t *testing.T) {
+	a := assert.New(t)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	subDB := subscription.NewMockStore(ctrl)
+	srv := defaultServer()
+	srv.subscriptionsDB = subDB
+	srv.statsManager = newStatsManager(mem.NewStore())
+	var received []RequestInfo
+	srv.hooks.OnPacketReceived = func(ctx context.Context, client Client, packet packets.Packet) error {
+		info, ok := RequestInfoFromContext(ctx)
+		a.True(ok)
+		received = append(received, info)
+		return nil
+	}
+	var unsubscribed []RequestInfo
+	srv.hooks.OnUnsubscribe = func(ctx context.Context, client Client, req *UnsubscribeRequest) error {
+		info, ok := RequestInfoFromContext(ctx)
+		a.True(ok)
+		unsubscribed = append(unsubscribed, info)
+		return nil
+	}
+	c, err := srv.newClient(noopConn{})
+	a.NoError(err)
+	c.opts.ClientID = "cid"
+	c.opts.Username = "user"
+	c.version = packets.Version311
+	c.listener = "internal"
+	close(c.connected)
+
+	var buf bytes.Buffer
+	w := packets.NewWriter(&buf)
+	a.NoError(w.WritePacket(&packets.Unsubscribe{Version: packets.Version311, PacketID: 1, Topics: []string{"a"}}))
+	a.NoError(w.WritePacket(&packets.Unsubscribe{Version: packets.Version311, PacketID: 2, Topics: []string{"b"}}))
+	a.NoError(w.Flush())
+	c.packetReader = packets.NewReader(&buf)
+	c.packetReader.SetVersion(packets.Version311)
+	a.NoError(c.readPacket())
+	a.NoError(c.readPacket())
+	close(c.in)
+
+	subDB.EXPECT().Unsubscribe("cid", "a")
+	subDB.EXPECT().Unsubscribe("cid", "b")
+	c.readHandle()
+
+	a.Len(received, 2)
+	a.Equal(received, unsubscribed)
+	for _, v := range received {
+		a.NotEmpty(v.CorrelationID)
+		a.Equal("internal", v.Listener)
+		a.Equal("cid", v.ClientID)
+		a.Equal("user", v.Username)
+		a.Equal("dummy", v.RemoteAddr)
+	}
+	a.NotEqual(received[0].CorrelationID, received[1].CorrelationID)
+
+	// hooks which are not triggered by an inbound packet have no correlation id.
+	info, ok := RequestInfoFromContext(c.baseContext())
+	a.True(ok)
+	a.Empty(info.CorrelationID)
+	a.Equal("cid", info.ClientID)
+}
+
+func TestClient_newRequestContext_connect(t *testing.T) {
+	a := assert.New(t)
+	srv := defaultServer()
+	c, err := srv.newClient(noopConn{})
+	a.NoError(err)
+	ctx := c.newRequestContext(&packets.Connect{
+		ClientID: []byte("cid"),
+		Username: []byte("user"),
+	})
+	info, ok := RequestInfoFromContext(ctx)
+	a.True(ok)
+	a.NotEmpty(info.CorrelationID)
+	a.Equal("cid", info.ClientID)
+	a.Equal("user", info.Username)
+
+	_, ok = RequestInfoFromContext(context.Background())
+	a.False(ok)
+}
@@ -0,0 +1,241 @@
+package server
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/DrmagicE/gmqtt"
+	"github.com/DrmagicE/gmqtt/persistence/queue"
+)
+
+// retainedBatchSize returns the max number of retained messages that can be added into the queue at once.
+// 0 means no limit.
+func (client *client) retainedBatchSize() int {
+	return int(client.opts.MaxInflight)
+}
+
+// addRetainedMsgs adds the retained messages into the client queue.
+func (client *client) addRetainedMsgs(msgs []*gmqtt.Message) error {
+	now := time.Now()
+	for _, v := range msgs {
+		var expiry time.Time
+		if v.MessageExpiry != 0 {
+			expiry = now.Add(time.Second * time.Duration(v.MessageExpiry))
+		}
+		err := client.queueStore.Add(&queue.Elem{
+			At:     now,
+			Expiry: expiry,
+			MessageWithID: &queue.Publish{
+				Message: v,
+			},
+		})
+		if err != nil {
+			client.queueNotifier.notifyDropped(v, &queue.InternalError{Err: err})
+			return err
+		}
+	}
+	return nil
+}
+
+// retainedStream is the state of the retained messages streamed by streamRetained.
+type retainedStream struct {
+	stop chan struct{}
+	mu   sync.Mutex
+	// pending is the topics of the retained messages that have not been added into the queue.
+	// The topic is removed once a live message of the topic is added into the queue,
+	// so that the retained message superseded by the live message will not be delivered after it.
+	pending map[string]struct{}
+}
+
+// take returns the messages which have not been superseded and removes them from the pending set.
+// It must be called with the mu held.
+func (r *retainedStream) take(msgs []*gmqtt.Message) []*gmqtt.Message {
+	rs := make([]*gmqtt.Message, 0, len(msgs))
+	for _, v := range msgs {
+		if _, ok := r.pending[v.Topic]; ok {
+			delete(r.pending, v.Topic)
+			rs = append(rs, v)
+		}
+	}
+	return rs
+}
+
+// sendRetained sends the matched retained messages of the topic filter to the client.
+// The first batch is added into the queue immediately, the rest of them are streamed by streamRetained
+// to avoid flooding the queue when a broad wildcard subscription matches a large number of retained messages.
+// The retained messages of the ordered topics are always added immediately,
+// otherwise they may be delivered after the live messages of the same topic.
+func (client *client) sendRetained(topicFilter string, msgs []*gmqtt.Message) error {
+	if max := client.config.MQTT.MaxRetainedPerSubscribe; max != 0 && len(msgs) > max {
+		zaplog.Warn("too many retained messages matched, the exceeded messages are ignored",
+			zap.String("client_id", client.opts.ClientID),
+			zap.Int("matched", len(msgs)),
+			zap.Int("max", max))
+		msgs = msgs[:max]
+	}
+	if len(client.config.MQTT.OrderedTopics) != 0 {
+		var ordered, rest []*gmqtt.Message
+		for _, v := range msgs {
+			if client.config.MQTT.Ordered(v.Topic) {
+				ordered = append(ordered, v)
+			} else {
+				rest = append(rest, v)
+			}
+		}
+		if err := client.addRetainedMsgs(ordered); err != nil {
+			return err
+		}
+		msgs = rest
+	}
+	size := client.retainedBatchSize()
+	if size == 0 || len(msgs) <= size {
+		return client.addRetainedMsgs(msgs)
+	}
+	if err := client.addRetainedMsgs(msgs[:size]); err != nil {
+		return err
+	}
+	msgs = msgs[size:]
+	r := &retainedStream{
+		stop:    make(chan struct{}),
+		pending: make(map[string]struct{}, len(msgs)),
+	}
+	for _, v := range msgs {
+		r.pending[v.Topic] = struct{}{}
+	}
+	client.retainedMu.Lock()
+	if client.retainedStreams == nil {
+		client.retainedStreams = make(map[string]*retainedStream)
+	}
+	if old, ok := client.retainedStreams[topicFilter]; ok {
+		close(old.stop)
+	} else {
+		atomic.AddInt32(&client.retainedStreaming, 1)
+	}
+	client.retainedStreams[topicFilter] = r
+	client.retainedMu.Unlock()
+	client.wg.Add(1)
+	go func() {
+		defer client.wg.Done()
+		defer client.removeRetainedStream(topicFilter, r)
+		client.streamRetained(r, msgs, size)
+	}()
+	return nil
+}
+
+// stopRetainedStream stops streaming the retained messages of the topic filter.
+// It is called when the topic filter is unsubscribed or subscribed again.
+func (client *client) stopRetainedStream(topicFilter string) {
+	client.retainedMu.Lock()
+	defer client.retainedMu.Unlock()
+	if r, ok := client.retainedStreams[topicFilter]; ok {
+		close(r.stop)
+		delete(client.retainedStreams, topicFilter)
+		atomic.AddInt32(&client.retainedStreaming, -1)
+	}
+}
+
+func (client *client) removeRetainedStream(topicFilter string, r *retainedStream) {
+	client.retainedMu.Lock()
+	defer client.retainedMu.Unlock()
+	if client.retainedStreams[topicFilter] == r {
+		delete(client.retainedStreams, topicFilter)
+		atomic.AddInt32(&client.retainedStreaming, -1)
+	}
+}
+
+// retainedSuperseded is called before a live message is added into the queue of the client,
+// the pending retained message of the same topic will not be delivered.
+func (client *client) retainedSuperseded(topic string) {
+	if atomic.LoadInt32(&client.retainedStreaming) == 0 {
+		return
+	}
+	client.retainedMu.Lock()
+	defer client.retainedMu.Unlock()
+	for _, r := range client.retainedStreams {
+		r.mu.Lock()
+		delete(r.pending, topic)
+		r.mu.Unlock()
+	}
+}
+
+// streamRetained adds the retained messages into the queue batch by batch.
+// The next batch will not be added until the queue length of the client is not greater than the batch size,
+// which means the client has consumed most of the previous batch, and the write buffer of the client is not paused.
+// The rest of the messages are added into the session queue at once if the client is disconnected,
+// and discarded if the topic filter is unsubscribed or subscribed again.
+func (client *client) streamRetained(r *retainedStream, msgs []*gmqtt.Message, size int) {
+	ticker := time.NewTicker(client.config.MQTT.RetainedBatchInterval)
+	defer ticker.Stop()
+	for len(msgs) != 0 {
+		select {
+		case <-r.stop:
+			return
+		case <-client.close:
+			client.queueRetainedOffline(r, msgs)
+			return
+		case <-ticker.C:
+		}
+		if client.writeBuffer.paused() || client.server.statsManager.queueLen(client.opts.ClientID) > uint64(size) {
+			continue
+		}
+		n := size
+		if n > len(msgs) {
+			n = len(msgs)
+		}
+		// hold the lock until the messages are added, so that a live message of the same topic is always added after them.
+		r.mu.Lock()
+		err := client.addRetainedMsgs(r.take(msgs[:n]))
+		r.mu.Unlock()
+		if err != nil {
+			zaplog.Error("failed to add retained messages",
+				zap.String("client_id", client.opts.ClientID),
+				zap.Error(err))
+			return
+		}
+		msgs = msgs[n:]
+	}
+}
+
+// queueRetainedOffline adds the rest of the streaming retained messages into the session queue after the client is disconnected,
+// so that they will be delivered when the session is resumed.
+func (client *client) queueRetainedOffline(r *retainedStream, msgs []*gmqtt.Message) {
+	r.mu.Lock()
+	msgs = r.take(msgs)
+	r.mu.Unlock()
+	srv := client.server
+	cid := client.opts.ClientID
+	s := srv.registry.shard(cid)
+	s.rlock()
+	defer s.runlock()
+	q := s.queueStore[cid]
+	if q == nil {
+		zaplog.Warn("session terminated, the streaming retained messages are discarded",
+			zap.String("client_id", cid),
+			zap.Int("discarded", len(msgs)))
+		return
+	}
+	now := time.Now()
+	for _, v := range msgs {
+		var expiry time.Time
+		if v.MessageExpiry != 0 {
+			expiry = now.Add(time.Second * time.Duration(v.MessageExpiry))
+		}
+		err := q.Add(&queue.Elem{
+			At:     now,
+			Expiry: expiry,
+			MessageWithID: &queue.Publish{
+				Message: v,
+			},
+		})
+		if err != nil {
+			defaultNotifier(srv.hooks.OnMsgDropped, srv.statsManager, cid).notifyDropped(v, &queue.InternalError{Err: err})
+			continue
+		}
+		if s.clients[cid] == nil {
+			srv.offlineQueued(s, cid)
+		}
+	}
+}
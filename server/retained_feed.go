@@ -0,0 +1,114 @@
+package server
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/DrmagicE/gmqtt"
+	"github.com/DrmagicE/gmqtt/config"
+	"github.com/DrmagicE/gmqtt/retained"
+)
+
+// The actions of the retained change events.
+const (
+	// RetainedSet means a retained message is set on the topic which had no retained message.
+	RetainedSet = "set"
+	// RetainedUpdated means the retained message of the topic is replaced.
+	RetainedUpdated = "updated"
+	// RetainedCleared means the retained message of the topic is removed.
+	RetainedCleared = "cleared"
+	// RetainedClearedAll means all retained messages are removed.
+	RetainedClearedAll = "cleared_all"
+)
+
+// RetainedChange is the payload of the retained change event, it is encoded in JSON.
+type RetainedChange struct {
+	Action string `json:"action"`
+	// Topic is the topic of the retained message, empty for RetainedClearedAll.
+	Topic string `json:"topic,omitempty"`
+	// QoS is the QoS level of the retained message, 0 for RetainedCleared and RetainedClearedAll.
+	QoS uint8 `json:"qos"`
+	// Payload is the payload of the retained message if retained_feed.include_payload is set,
+	// it is encoded in base64 by the JSON encoding.
+	Payload []byte `json:"payload,omitempty"`
+	// Timestamp is the time of the change in unix milliseconds.
+	Timestamp int64 `json:"timestamp"`
+}
+
+// retainedFeed wraps the retained store to publish the change events.
+type retainedFeed struct {
+	retained.Store
+	config config.RetainedFeed
+	// mu serializes the changes, so that the set and updated actions are reported correctly.
+	mu      sync.Mutex
+	publish func(msg *gmqtt.Message)
+}
+
+func newRetainedFeed(srv *server, store retained.Store, cfg config.RetainedFeed) *retainedFeed {
+	return &retainedFeed{
+		Store:  store,
+		config: cfg,
+		publish: func(msg *gmqtt.Message) {
+			srv.routeMessage("", msg, defaultIterateOptions(msg.Topic), nil)
+		},
+	}
+}
+
+func (r *retainedFeed) notify(change RetainedChange) {
+	change.Timestamp = time.Now().UnixNano() / int64(time.Millisecond)
+	b, err := json.Marshal(change)
+	if err != nil {
+		zaplog.Error("fail to encode retained change", zap.Error(err))
+		return
+	}
+	r.publish(&gmqtt.Message{
+		QoS:         r.config.QoS,
+		Topic:       r.config.Topic,
+		Payload:     b,
+		ContentType: "application/json",
+	})
+}
+
+func (r *retainedFeed) AddOrReplace(message *gmqtt.Message) {
+	r.mu.Lock()
+	action := RetainedSet
+	if r.Store.GetRetainedMessage(message.Topic) != nil {
+		action = RetainedUpdated
+	}
+	r.Store.AddOrReplace(message)
+	r.mu.Unlock()
+	change := RetainedChange{
+		Action: action,
+		Topic:  message.Topic,
+		QoS:    message.QoS,
+	}
+	if r.config.IncludePayload {
+		change.Payload = message.Payload
+	}
+	r.notify(change)
+}
+
+func (r *retainedFeed) Remove(topicName string) {
+	r.mu.Lock()
+	exists := r.Store.GetRetainedMessage(topicName) != nil
+	r.Store.Remove(topicName)
+	r.mu.Unlock()
+	if exists {
+		r.notify(RetainedChange{
+			Action: RetainedCleared,
+			Topic:  topicName,
+		})
+	}
+}
+
+func (r *retainedFeed) ClearAll() {
+	r.mu.Lock()
+	r.Store.ClearAll()
+	r.mu.Unlock()
+	r.notify(RetainedChange{
+		Action: RetainedClearedAll,
+	})
+}
@@ -0,0 +1,62 @@
+package server
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DrmagicE/gmqtt"
+	"github.com/DrmagicE/gmqtt/config"
+	"github.com/DrmagicE/gmqtt/pkg/packets"
+	retained_trie "github.com/DrmagicE/gmqtt/retained/trie"
+)
+
+func TestRetainedFeed(t *testing.T) {
+	a := assert.New(t)
+	var published []*gmqtt.Message
+	r := &retainedFeed{
+		Store: retained_trie.NewStore(),
+		config: config.RetainedFeed{
+			Topic:          "$SYS/retained/changes",
+			QoS:            packets.Qos1,
+			IncludePayload: true,
+		},
+		publish: func(msg *gmqtt.Message) {
+			published = append(published, msg)
+		},
+	}
+	changes := func() []RetainedChange {
+		var rs []RetainedChange
+		for _, v := range published {
+			a.Equal("$SYS/retained/changes", v.Topic)
+			a.Equal(packets.Qos1, v.QoS)
+			a.False(v.Retained)
+			var c RetainedChange
+			a.Nil(json.Unmarshal(v.Payload, &c))
+			a.NotZero(c.Timestamp)
+			c.Timestamp = 0
+			rs = append(rs, c)
+		}
+		published = nil
+		return rs
+	}
+
+	r.AddOrReplace(&gmqtt.Message{Topic: "a/b", QoS: packets.Qos1, Payload: []byte("1"), Retained: true})
+	r.AddOrReplace(&gmqtt.Message{Topic: "a/b", Payload: []byte("2"), Retained: true})
+	r.Remove("a/b")
+	// not exist
+	r.Remove("a/b")
+	r.ClearAll()
+	a.Equal([]RetainedChange{
+		{Action: RetainedSet, Topic: "a/b", QoS: packets.Qos1, Payload: []byte("1")},
+		{Action: RetainedUpdated, Topic: "a/b", Payload: []byte("2")},
+		{Action: RetainedCleared, Topic: "a/b"},
+		{Action: RetainedClearedAll},
+	}, changes())
+
+	r.config.IncludePayload = false
+	r.AddOrReplace(&gmqtt.Message{Topic: "a/c", Payload: []byte("1"), Retained: true})
+	a.Equal([]RetainedChange{{Action: RetainedSet, Topic: "a/c"}}, changes())
+	a.Equal([]byte("1"), r.GetRetainedMessage("a/c").Payload)
+}
@@ -0,0 +1,105 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strings"
+
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v2"
+
+	"github.com/DrmagicE/gmqtt"
+	"github.com/DrmagicE/gmqtt/config"
+	"github.com/DrmagicE/gmqtt/pkg/packets"
+)
+
+// retainedSeedMessage is a message in the retained seed file, see config.RetainedSeed.
+type retainedSeedMessage struct {
+	Topic   string `yaml:"topic" json:"topic"`
+	Payload string `yaml:"payload" json:"payload"`
+	// PayloadFile is the file to read the payload from, it is relative to the seed file.
+	PayloadFile string `yaml:"payload_file" json:"payload_file"`
+	QoS         uint8  `yaml:"qos" json:"qos"`
+}
+
+func (m *retainedSeedMessage) validate() error {
+	if !packets.ValidTopicName(true, []byte(m.Topic)) {
+		return fmt.Errorf("invalid topic: %s", m.Topic)
+	}
+	if m.QoS > packets.Qos2 {
+		return fmt.Errorf("invalid qos: %d", m.QoS)
+	}
+	if m.Payload != "" && m.PayloadFile != "" {
+		return errors.New("payload and payload_file cannot be set at the same time")
+	}
+	return nil
+}
+
+// readRetainedSeed reads the messages from the seed file.
+func readRetainedSeed(file string) ([]*gmqtt.Message, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var seeds []*retainedSeedMessage
+	if strings.EqualFold(path.Ext(file), ".json") {
+		err = json.Unmarshal(b, &seeds)
+	} else {
+		err = yaml.Unmarshal(b, &seeds)
+	}
+	if err != nil {
+		return nil, err
+	}
+	msgs := make([]*gmqtt.Message, 0, len(seeds))
+	for k, v := range seeds {
+		if err := v.validate(); err != nil {
+			return nil, fmt.Errorf("message %d: %s", k, err)
+		}
+		payload := []byte(v.Payload)
+		if v.PayloadFile != "" {
+			f := v.PayloadFile
+			if !path.IsAbs(f) {
+				f = path.Join(path.Dir(file), f)
+			}
+			payload, err = ioutil.ReadFile(f)
+			if err != nil {
+				return nil, fmt.Errorf("message %d: %s", k, err)
+			}
+		}
+		msgs = append(msgs, &gmqtt.Message{
+			Topic:    v.Topic,
+			Payload:  payload,
+			QoS:      v.QoS,
+			Retained: true,
+		})
+	}
+	return msgs, nil
+}
+
+// loadRetainedSeed adds the messages in the seed file into the retained store.
+func (srv *server) loadRetainedSeed(seed config.RetainedSeed) error {
+	if seed.File == "" {
+		return nil
+	}
+	file := seed.File
+	if !path.IsAbs(file) {
+		file = path.Join(srv.config.ConfigDir, file)
+	}
+	msgs, err := readRetainedSeed(file)
+	if err != nil {
+		return fmt.Errorf("fail to load retained seed file %s: %w", file, err)
+	}
+	var loaded int
+	for _, v := range msgs {
+		if !seed.Overwrite && srv.retainedDB.GetRetainedMessage(v.Topic) != nil {
+			continue
+		}
+		srv.retainedDB.AddOrReplace(v)
+		loaded++
+	}
+	zaplog.Info("load retained seed succeeded", zap.String("file", file), zap.Int("total", len(msgs)), zap.Int("loaded", loaded))
+	return nil
+}
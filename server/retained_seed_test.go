@@ -0,0 +1,57 @@
+package server
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DrmagicE/gmqtt"
+	"github.com/DrmagicE/gmqtt/config"
+	retained_trie "github.com/DrmagicE/gmqtt/retained/trie"
+)
+
+func TestServer_loadRetainedSeed(t *testing.T) {
+	a := assert.New(t)
+	dir, err := ioutil.TempDir("", "retained_seed")
+	a.Nil(err)
+	defer os.RemoveAll(dir)
+	a.Nil(ioutil.WriteFile(path.Join(dir, "firmware.json"), []byte(`{"version":"1.0"}`), 0644))
+	a.Nil(ioutil.WriteFile(path.Join(dir, "seed.yml"), []byte(`
+- topic: config/site
+  payload: site
+  qos: 1
+- topic: config/firmware
+  payload_file: firmware.json
+`), 0644))
+	a.Nil(ioutil.WriteFile(path.Join(dir, "seed.json"), []byte(`[{"topic":"config/site","payload":"json"}]`), 0644))
+
+	srv := &server{
+		config:     config.Config{ConfigDir: dir},
+		retainedDB: retained_trie.NewStore(),
+	}
+	a.Nil(srv.loadRetainedSeed(config.RetainedSeed{}))
+	a.Nil(srv.loadRetainedSeed(config.RetainedSeed{File: "seed.yml"}))
+	a.Equal(&gmqtt.Message{Topic: "config/site", Payload: []byte("site"), QoS: 1, Retained: true},
+		srv.retainedDB.GetRetainedMessage("config/site"))
+	a.Equal([]byte(`{"version":"1.0"}`), srv.retainedDB.GetRetainedMessage("config/firmware").Payload)
+
+	// the existing messages are kept unless overwrite is set.
+	a.Nil(srv.loadRetainedSeed(config.RetainedSeed{File: path.Join(dir, "seed.json")}))
+	a.Equal([]byte("site"), srv.retainedDB.GetRetainedMessage("config/site").Payload)
+	a.Nil(srv.loadRetainedSeed(config.RetainedSeed{File: "seed.json", Overwrite: true}))
+	a.Equal([]byte("json"), srv.retainedDB.GetRetainedMessage("config/site").Payload)
+
+	a.NotNil(srv.loadRetainedSeed(config.RetainedSeed{File: "not_exist.yml"}))
+	for _, v := range []string{
+		`[{"topic":"config/#"}]`,
+		`[{"topic":"a","qos":3}]`,
+		`[{"topic":"a","payload":"a","payload_file":"firmware.json"}]`,
+		`[{"topic":"a","payload_file":"not_exist"}]`,
+	} {
+		a.Nil(ioutil.WriteFile(path.Join(dir, "invalid.json"), []byte(v), 0644))
+		a.NotNil(srv.loadRetainedSeed(config.RetainedSeed{File: "invalid.json"}), v)
+	}
+}
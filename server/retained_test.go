@@ -0,0 +1,177 @@
+package server
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DrmagicE/gmqtt"
+	"github.com/DrmagicE/gmqtt/config"
+	"github.com/DrmagicE/gmqtt/persistence/queue"
+	"github.com/DrmagicE/gmqtt/persistence/subscription/mem"
+	"github.com/DrmagicE/gmqtt/pkg/packets"
+)
+
+func newRetainedTestClient(t *testing.T, ctrl *gomock.Controller, cfg config.Config) (*client, *[]string) {
+	a := assert.New(t)
+	sub := mem.NewStore()
+	srv := &server{
+		subscriptionsDB: sub,
+		registry:        newRegistry(),
+		config:          cfg,
+		statsManager:    newStatsManager(sub),
+	}
+	c, err := srv.newClient(noopConn{})
+	a.Nil(err)
+	c.opts.ClientID = "cid"
+	c.opts.MaxInflight = 2
+	qs := queue.NewMockStore(ctrl)
+	var received []string
+	qs.EXPECT().Add(gomock.Any()).DoAndReturn(func(elem *queue.Elem) error {
+		received = append(received, string(elem.MessageWithID.(*queue.Publish).Payload))
+		return nil
+	}).AnyTimes()
+	c.queueStore = qs
+	return c, &received
+}
+
+func newRetainedTestMsgs(n int) []*gmqtt.Message {
+	var msgs []*gmqtt.Message
+	for i := 0; i < n; i++ {
+		msgs = append(msgs, &gmqtt.Message{
+			Topic:   "topic/" + strconv.Itoa(i),
+			Payload: []byte(strconv.Itoa(i)),
+			QoS:     1,
+		})
+	}
+	return msgs
+}
+
+func TestClient_sendRetained(t *testing.T) {
+	a := assert.New(t)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	cfg := config.DefaultConfig()
+	cfg.MQTT.RetainedBatchInterval = time.Millisecond
+	cfg.MQTT.MaxRetainedPerSubscribe = 5
+	c, received := newRetainedTestClient(t, ctrl, cfg)
+
+	a.Nil(c.sendRetained("topic/#", newRetainedTestMsgs(7)))
+	// the first batch is added immediately.
+	a.Len(*received, 2)
+	c.wg.Wait()
+	a.Equal([]string{"0", "1", "2", "3", "4"}, *received)
+}
+
+func TestClient_sendRetained_waitForConsuming(t *testing.T) {
+	a := assert.New(t)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	cfg := config.DefaultConfig()
+	cfg.MQTT.RetainedBatchInterval = time.Millisecond
+	c, received := newRetainedTestClient(t, ctrl, cfg)
+	// the client has not consumed the first batch.
+	c.server.statsManager.addQueueLen("cid", 3)
+
+	a.Nil(c.sendRetained("topic/#", newRetainedTestMsgs(4)))
+	time.Sleep(20 * time.Millisecond)
+	close(c.close)
+	c.wg.Wait()
+	a.Equal([]string{"0", "1"}, *received)
+}
+
+func TestClient_sendRetained_unsubscribe(t *testing.T) {
+	a := assert.New(t)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	cfg := config.DefaultConfig()
+	cfg.MQTT.RetainedBatchInterval = time.Millisecond
+	c, received := newRetainedTestClient(t, ctrl, cfg)
+	c.version = packets.Version311
+	c.server.statsManager.addQueueLen("cid", 3)
+	_, err := c.server.subscriptionsDB.Subscribe("cid", &gmqtt.Subscription{TopicFilter: "topic/#"})
+	a.Nil(err)
+
+	a.Nil(c.sendRetained("topic/#", newRetainedTestMsgs(4)))
+	c.unsubscribeHandler(&packets.Unsubscribe{
+		Version:  packets.Version311,
+		PacketID: 1,
+		Topics:   []string{"topic/#"},
+	})
+	// the queue is consumed.
+	c.server.statsManager.addQueueLen("cid", ^uint64(2))
+	c.wg.Wait()
+	a.Equal([]string{"0", "1"}, *received)
+	a.Empty(c.retainedStreams)
+}
+
+func TestClient_sendRetained_resubscribe(t *testing.T) {
+	a := assert.New(t)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	cfg := config.DefaultConfig()
+	cfg.MQTT.RetainedBatchInterval = time.Millisecond
+	c, received := newRetainedTestClient(t, ctrl, cfg)
+	c.server.statsManager.addQueueLen("cid", 3)
+
+	a.Nil(c.sendRetained("topic/#", newRetainedTestMsgs(4)))
+	msgs := newRetainedTestMsgs(4)
+	for _, v := range msgs {
+		v.Payload = append([]byte("new"), v.Payload...)
+	}
+	a.Nil(c.sendRetained("topic/#", msgs))
+	c.server.statsManager.addQueueLen("cid", ^uint64(2))
+	c.wg.Wait()
+	// the stream of the previous subscription is stopped.
+	a.Equal([]string{"0", "1", "new0", "new1", "new2", "new3"}, *received)
+	a.Empty(c.retainedStreams)
+}
+
+func TestClient_sendRetained_superseded(t *testing.T) {
+	a := assert.New(t)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	cfg := config.DefaultConfig()
+	cfg.MQTT.RetainedBatchInterval = time.Millisecond
+	c, received := newRetainedTestClient(t, ctrl, cfg)
+	c.server.statsManager.addQueueLen("cid", 3)
+
+	a.Nil(c.sendRetained("topic/#", newRetainedTestMsgs(4)))
+	// a live message of topic/2 is added into the queue before the retained one.
+	c.retainedSuperseded("topic/2")
+	c.server.statsManager.addQueueLen("cid", ^uint64(2))
+	c.wg.Wait()
+	a.Equal([]string{"0", "1", "3"}, *received)
+}
+
+func TestClient_sendRetained_disconnect(t *testing.T) {
+	a := assert.New(t)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	cfg := config.DefaultConfig()
+	cfg.MQTT.RetainedBatchInterval = time.Millisecond
+
+	t.Run("session", func(t *testing.T) {
+		c, received := newRetainedTestClient(t, ctrl, cfg)
+		c.server.registry.shard("cid").queueStore["cid"] = c.queueStore
+		c.server.statsManager.addQueueLen("cid", 3)
+
+		a.Nil(c.sendRetained("topic/#", newRetainedTestMsgs(4)))
+		close(c.close)
+		c.wg.Wait()
+		// the rest of the messages are added into the session queue.
+		a.Equal([]string{"0", "1", "2", "3"}, *received)
+	})
+	t.Run("no session", func(t *testing.T) {
+		c, received := newRetainedTestClient(t, ctrl, cfg)
+		c.server.statsManager.addQueueLen("cid", 3)
+
+		a.Nil(c.sendRetained("topic/#", newRetainedTestMsgs(4)))
+		close(c.close)
+		c.wg.Wait()
+		a.Equal([]string{"0", "1"}, *received)
+	})
+}
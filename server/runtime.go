@@ -0,0 +1,40 @@
+package server
+
+import (
+	"runtime"
+	"runtime/debug"
+
+	"go.uber.org/zap"
+
+	"github.com/DrmagicE/gmqtt/config"
+	"github.com/DrmagicE/gmqtt/pkg/cgroup"
+)
+
+// applyRuntimeConfig applies the Go runtime settings.
+func applyRuntimeConfig(cfg config.Runtime) {
+	if cfg.GOGC != 0 {
+		old := debug.SetGCPercent(cfg.GOGC)
+		zaplog.Info("set GOGC", zap.Int("gogc", cfg.GOGC), zap.Int("old", old))
+	}
+	if cfg.MemoryLimit != 0 {
+		if setMemoryLimit(cfg.MemoryLimit) {
+			zaplog.Info("set memory limit", zap.Int64("memory_limit", cfg.MemoryLimit))
+		} else {
+			zaplog.Warn("memory limit is not supported by the Go version, ignored",
+				zap.String("go_version", runtime.Version()))
+		}
+	}
+	procs := cfg.MaxProcs
+	if procs == 0 && cfg.ContainerAware {
+		n, err := cgroup.MaxProcs()
+		if err == nil {
+			procs = n
+		} else if err != cgroup.ErrNoQuota {
+			zaplog.Warn("failed to read cpu quota", zap.Error(err))
+		}
+	}
+	if procs != 0 {
+		old := runtime.GOMAXPROCS(procs)
+		zaplog.Info("set GOMAXPROCS", zap.Int("max_procs", procs), zap.Int("old", old))
+	}
+}
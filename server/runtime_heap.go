@@ -0,0 +1,21 @@
+//go:build go1.16
+// +build go1.16
+
+package server
+
+import (
+	"runtime/metrics"
+)
+
+const heapObjectsMetric = "/memory/classes/heap/objects:bytes"
+
+// heapInuse returns the bytes of the heap objects.
+// Unlike runtime.ReadMemStats, reading runtime/metrics does not stop the world.
+func heapInuse() uint64 {
+	s := []metrics.Sample{{Name: heapObjectsMetric}}
+	metrics.Read(s)
+	if s[0].Value.Kind() != metrics.KindUint64 {
+		return 0
+	}
+	return s[0].Value.Uint64()
+}
@@ -0,0 +1,15 @@
+//go:build !go1.16
+// +build !go1.16
+
+package server
+
+import (
+	"runtime"
+)
+
+// heapInuse falls back to runtime.ReadMemStats because runtime/metrics is introduced in go1.16.
+func heapInuse() uint64 {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return m.HeapInuse
+}
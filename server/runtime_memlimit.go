@@ -0,0 +1,13 @@
+//go:build go1.19
+// +build go1.19
+
+package server
+
+import (
+	"runtime/debug"
+)
+
+func setMemoryLimit(limit int64) bool {
+	debug.SetMemoryLimit(limit)
+	return true
+}
@@ -0,0 +1,9 @@
+//go:build !go1.19
+// +build !go1.19
+
+package server
+
+// setMemoryLimit is a no-op because the soft memory limit is introduced in go1.19.
+func setMemoryLimit(limit int64) bool {
+	return false
+}
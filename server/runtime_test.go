@@ -0,0 +1,33 @@
+package server
+
+import (
+	"runtime"
+	"runtime/debug"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DrmagicE/gmqtt/config"
+)
+
+func TestApplyRuntimeConfig(t *testing.T) {
+	a := assert.New(t)
+	oldProcs := runtime.GOMAXPROCS(0)
+	oldGC := debug.SetGCPercent(100)
+	defer func() {
+		runtime.GOMAXPROCS(oldProcs)
+		debug.SetGCPercent(oldGC)
+	}()
+
+	applyRuntimeConfig(config.Runtime{
+		GOGC:     200,
+		MaxProcs: 1,
+	})
+	a.Equal(1, runtime.GOMAXPROCS(0))
+	a.Equal(200, debug.SetGCPercent(100))
+
+	// leave unchanged
+	applyRuntimeConfig(config.DefaultRuntimeConfig)
+	a.Equal(1, runtime.GOMAXPROCS(0))
+	a.Equal(100, debug.SetGCPercent(100))
+}
@@ -2,9 +2,9 @@ package server
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
-	"math/rand"
 	"net"
 	"net/http"
 	"strings"
@@ -21,6 +21,9 @@ import (
 	"github.com/DrmagicE/gmqtt/persistence/session"
 	"github.com/DrmagicE/gmqtt/persistence/unack"
 	"github.com/DrmagicE/gmqtt/pkg/codes"
+	"github.com/DrmagicE/gmqtt/pkg/netpoll"
+	"github.com/DrmagicE/gmqtt/pkg/timingwheel"
+	"github.com/DrmagicE/gmqtt/pkg/tlsticket"
 	retained_trie "github.com/DrmagicE/gmqtt/retained/trie"
 
 	"github.com/DrmagicE/gmqtt/persistence/subscription"
@@ -52,6 +55,8 @@ func RegisterPersistenceFactory(name string, new NewPersistence) {
 	persistenceFactories[name] = new
 }
 
+// RegisterTopicAliasMgrFactory registers the topic alias manager implementation with the given name,
+// which can be selected by the topic_alias_manager.type setting.
 func RegisterTopicAliasMgrFactory(name string, new NewTopicAliasManager) {
 	if _, ok := topicAliasMgrFactory[name]; ok {
 		panic("duplicated topic alias manager factory: " + name)
@@ -102,9 +107,16 @@ type Server interface {
 	SubscriptionService() SubscriptionService
 
 	RetainedService() RetainedService
+	// ListenerService returns the ListenerService which manages the listeners at runtime.
+	ListenerService() ListenerService
 	// Plugins returns all enabled plugins
 	Plugins() []Plugin
 	APIRegistrar() APIRegistrar
+	// NewLocalClient creates an in-process client which publishes and subscribes without network connection.
+	NewLocalClient(clientID string) (LocalClient, error)
+	// WebSocketHandler returns the MQTT over WebSocket handler which can be mounted onto the router of the application,
+	// so that the broker shares the port with the existing web application.
+	WebSocketHandler(opts WsHandlerOptions) http.Handler
 }
 
 type clientService struct {
@@ -117,20 +129,21 @@ func (c *clientService) IterateSession(fn session.IterateFn) error {
 }
 
 func (c *clientService) IterateClient(fn ClientIterateFn) {
-	c.srv.mu.Lock()
-	defer c.srv.mu.Unlock()
-
-	for _, v := range c.srv.clients {
-		if !fn(v) {
-			return
+	c.srv.registry.iterate(func(s *registryShard) bool {
+		for _, v := range s.clients {
+			if !fn(v) {
+				return false
+			}
 		}
-	}
+		return true
+	})
 }
 
 func (c *clientService) GetClient(clientID string) Client {
-	c.srv.mu.Lock()
-	defer c.srv.mu.Unlock()
-	if c, ok := c.srv.clients[clientID]; ok {
+	s := c.srv.registry.shard(clientID)
+	s.rlock()
+	defer s.runlock()
+	if c, ok := s.clients[clientID]; ok {
 		return c
 	}
 	return nil
@@ -141,15 +154,16 @@ func (c *clientService) GetSession(clientID string) (*gmqtt.Session, error) {
 }
 
 func (c *clientService) TerminateSession(clientID string) {
-	c.srv.mu.Lock()
-	defer c.srv.mu.Unlock()
-	if cli, ok := c.srv.clients[clientID]; ok {
+	s := c.srv.registry.shard(clientID)
+	s.lock()
+	defer s.unlock()
+	if cli, ok := s.clients[clientID]; ok {
 		atomic.StoreInt32(&cli.forceRemoveSession, 1)
 		cli.Close()
 		return
 	}
-	if _, ok := c.srv.offlineClients[clientID]; ok {
-		err := c.srv.sessionTerminatedLocked(clientID, NormalTermination)
+	if _, ok := s.offlineClients[clientID]; ok {
+		err := c.srv.sessionTerminatedLocked(s, clientID, NormalTermination)
 		if err != nil {
 			err = fmt.Errorf("session terminated fail: %s", err.Error())
 			zaplog.Error("session terminated fail", zap.Error(err))
@@ -164,16 +178,16 @@ type server struct {
 	wg       sync.WaitGroup
 	initOnce sync.Once
 	stopOnce sync.Once
-	mu       sync.RWMutex //gard clients & offlineClients map
+	mu       sync.RWMutex //gard plugins
 	status   int32        //server status
-	// clients stores the  online clients
-	clients map[string]*client
-	// offlineClients store the expired time of all disconnected clients
-	// with valid session(not expired). Key by clientID
-	offlineClients  map[string]time.Time
-	willMessage     map[string]*willMsg
+	// registry stores the online clients and session states.
+	registry        *registry
 	tcpListener     []net.Listener //tcp listeners
 	websocketServer []*WsServer    //websocket serverStop
+	// listeners are the running listeners, guarded by listenersMu.
+	listenersMu     sync.Mutex
+	listeners       []*runningListener
+	listenerService *listenerService
 	errOnce         sync.Once
 	err             error
 	exitChan        chan struct{}
@@ -181,10 +195,10 @@ type server struct {
 
 	retainedDB      retained.Store
 	subscriptionsDB subscription.Store //store subscriptions
+	// noSubscribers is nil if the no subscriber cache is disabled.
+	noSubscribers *noSubscriberCache
 
 	persistence  Persistence
-	queueStore   map[string]queue.Store
-	unackStore   map[string]unack.Store
 	sessionStore session.Store
 
 	// guards config
@@ -195,9 +209,45 @@ type server struct {
 	statsManager         *statsManager
 	publishService       Publisher
 	newTopicAliasManager NewTopicAliasManager
+	localClients         *localClients
+	// packetDumper is nil if log.dump_packet is disabled.
+	packetDumper *packetDumper
+	// debugTargets records the clients which the debug logging is enabled for at runtime.
+	debugTargets *debugTargets
+	// bans records the clients which are banned by the ClientService.
+	bans *banList
 
 	clientService *clientService
 	apiRegistrar  *apiRegistrar
+	// poller is the netpoll poller, nil if the connection engine is not netpoll.
+	poller *netpoll.Poller
+	// deliveryPool is the delivery worker pool, nil if disabled.
+	deliveryPool *deliveryPool
+	// sharedDispatcher picks the member of the shared subscription group to deliver the message to.
+	sharedDispatcher *sharedDispatcher
+	// hookPool is the worker pool of the asynchronous hooks, nil if no plugin registers asynchronous hooks.
+	hookPool *hookPool
+	// hookTimer records the latency of the hooks, nil if the metrics is disabled.
+	hookTimer    *hookTimer
+	hookTimeouts []*hookTimeout
+	// pluginSandbox recovers the panics of the plugin hooks, nil if disabled.
+	pluginSandbox *pluginSandbox
+	// overload is the overload protection guard, nil if disabled.
+	overload *overloadGuard
+	// connQuota limits the simultaneous connections per username and per IP, nil if disabled.
+	connQuota *connectionQuota
+	// connLimiter limits the concurrent handshakes and the rate of the CONNECT packets, nil if there is no limit.
+	connLimiter *connectLimiter
+	// flapping is the connection flapping detector, nil if disabled.
+	flapping *flappingDetector
+	// authThrottle is the authentication failure throttle, nil if disabled.
+	authThrottle *authThrottle
+	// receipts tracks the messages which request the delivery receipts, nil if disabled.
+	receipts *receiptTracker
+	// statsFile is the file to save the lifetime statistics, empty if the stats persistence is disabled.
+	statsFile string
+	// timerWheel manages the keepalive timers of all clients and the will delay timers.
+	timerWheel *timingwheel.Wheel
 }
 
 func (srv *server) APIRegistrar() APIRegistrar {
@@ -221,6 +271,10 @@ func (srv *server) ClientService() ClientService {
 	return srv.clientService
 }
 
+func (srv *server) ListenerService() ListenerService {
+	return srv.listenerService
+}
+
 func (srv *server) ApplyConfig(config config.Config) {
 	srv.configMu.Lock()
 	defer srv.configMu.Unlock()
@@ -270,8 +324,9 @@ func (srv *server) Status() int32 {
 	return atomic.LoadInt32(&srv.status)
 }
 
-func (srv *server) sessionTerminatedLocked(clientID string, reason SessionTerminatedReason) (err error) {
-	err = srv.removeSessionLocked(clientID)
+// sessionTerminatedLocked terminates the session, this function must be guard by the shard lock of the client.
+func (srv *server) sessionTerminatedLocked(s *registryShard, clientID string, reason SessionTerminatedReason) (err error) {
+	err = srv.removeSessionLocked(s, clientID)
 	if srv.hooks.OnSessionTerminated != nil {
 		srv.hooks.OnSessionTerminated(context.Background(), clientID, reason)
 	}
@@ -304,33 +359,30 @@ func setWillProperties(willPpt *packets.Properties, msg *gmqtt.Message) {
 	}
 }
 
-func (srv *server) lockDuplicatedID(c *client) (oldSession *gmqtt.Session, err error) {
+// lockDuplicatedID closes the online client with the same client id and locks the shard of the client.
+// The shard lock is held on return if err is nil.
+func (srv *server) lockDuplicatedID(s *registryShard, c *client) (oldSession *gmqtt.Session, err error) {
 	for {
-		srv.mu.Lock()
+		s.lock()
 		oldSession, err = srv.sessionStore.Get(c.opts.ClientID)
 		if err != nil {
-			srv.mu.Unlock()
-			zaplog.Error("fail to get session",
-				zap.String("remote_addr", c.rwc.RemoteAddr().String()),
-				zap.String("client_id", c.opts.ClientID))
+			s.unlock()
+			zaplog.Error("fail to get session", c.logFields()...)
 			return
 		}
 		if oldSession != nil {
 			var oldClient *client
-			oldClient = srv.clients[oldSession.ClientID]
-			srv.mu.Unlock()
+			oldClient = s.clients[oldSession.ClientID]
 			if oldClient == nil {
-				srv.mu.Lock()
 				break
 			}
+			s.unlock()
 			// if there is a duplicated online client, close if first.
-			zaplog.Info("logging with duplicate ClientID",
-				zap.String("remote", c.rwc.RemoteAddr().String()),
-				zap.String("client_id", oldSession.ClientID),
-			)
+			zaplog.Info("logging with duplicate ClientID", c.logFields()...)
 			oldClient.setError(codes.NewError(codes.SessionTakenOver))
 			oldClient.Close()
 			<-oldClient.closed
+			c.takenOver = oldClient
 			continue
 		}
 		break
@@ -345,7 +397,8 @@ func (srv *server) registerClient(connect *packets.Connect, client *client) (ses
 	var sess *gmqtt.Session
 	var oldSession *gmqtt.Session
 	now := time.Now()
-	oldSession, err = srv.lockDuplicatedID(client)
+	s := srv.registry.shard(client.opts.ClientID)
+	oldSession, err = srv.lockDuplicatedID(s, client)
 	if err != nil {
 		return
 	}
@@ -356,24 +409,30 @@ func (srv *server) registerClient(connect *packets.Connect, client *client) (ses
 			if connect.WillFlag {
 				willMsg = &gmqtt.Message{
 					QoS:     connect.WillQos,
-					Topic:   string(connect.WillTopic),
+					Topic:   client.mountTopic(string(connect.WillTopic)),
 					Payload: connect.WillMsg,
 				}
 				setWillProperties(connect.WillProperties, willMsg)
 			}
 			// use default expiry if the client version is version3.1.1
 			if packets.IsVersion3X(client.version) && !connect.CleanStart {
-				expiryInterval = uint32(srv.config.MQTT.SessionExpiry.Seconds())
+				expiryInterval = client.opts.SessionExpiry
 			} else if connect.Properties != nil {
 				willDelayInterval = convertUint32(connect.WillProperties.WillDelayInterval, 0)
 				expiryInterval = client.opts.SessionExpiry
 			}
+			attrs := client.Attributes()
+			if sessionResume {
+				attrs = mergeAttributes(oldSession.Attributes, attrs)
+				client.attributes.Store(attrs)
+			}
 			sess = &gmqtt.Session{
 				ClientID:          client.opts.ClientID,
 				Will:              willMsg,
 				ConnectedAt:       time.Now(),
 				WillDelayInterval: willDelayInterval,
 				ExpiryInterval:    expiryInterval,
+				Attributes:        attrs,
 			}
 			err = srv.sessionStore.Set(sess)
 		}
@@ -383,34 +442,55 @@ func (srv *server) registerClient(connect *packets.Connect, client *client) (ses
 			if sessionResume {
 				// If a new Network Connection to this Session is made before the Will Delay Interval has passed,
 				// the Server MUST NOT send the Will Message [MQTT-3.1.3-9].
-				if w, ok := srv.willMessage[client.opts.ClientID]; ok {
+				if w, ok := s.willMessage[client.opts.ClientID]; ok {
 					w.signal(false)
 				}
 				if srv.hooks.OnSessionResumed != nil {
-					srv.hooks.OnSessionResumed(context.Background(), client)
+					srv.hooks.OnSessionResumed(client.requestContext(), client, int(srv.statsManager.queueLen(client.opts.ClientID)))
 				}
 				srv.statsManager.sessionActive(false)
 			} else {
 				if srv.hooks.OnSessionCreated != nil {
-					srv.hooks.OnSessionCreated(context.Background(), client)
+					srv.hooks.OnSessionCreated(client.requestContext(), client)
 				}
 				srv.statsManager.sessionActive(true)
 			}
-			srv.clients[client.opts.ClientID] = client
-			srv.unackStore[client.opts.ClientID] = ua
-			srv.queueStore[client.opts.ClientID] = qs
+			if old := client.takenOver; old != nil {
+				info := &TakeoverInfo{
+					Previous:       old,
+					SessionResumed: sessionResume,
+				}
+				// the previous client is closed, it is safe to hand off the inflight state.
+				if sessionResume {
+					client.takeoverUnsent = old.unsent.handoff()
+					info.Unsent = len(client.takeoverUnsent)
+				}
+				zaplog.Info("session taken over", client.logFields(
+					zap.Bool("session_resumed", sessionResume),
+					zap.Int("unsent_inflight", info.Unsent))...)
+				if srv.hooks.OnSessionTakenOver != nil {
+					srv.hooks.OnSessionTakenOver(client.requestContext(), client, info)
+				}
+				client.takenOver = nil
+			}
+			s.clients[client.opts.ClientID] = client
+			s.unackStore[client.opts.ClientID] = ua
+			s.queueStore[client.opts.ClientID] = qs
 			client.queueStore = qs
 			client.unackStore = ua
+			// the stats of the client id are only removed when its session is terminated,
+			// which happens under the shard lock after the client is removed from the shard.
+			client.stats = srv.statsManager.clientStatsOf(client.opts.ClientID)
 			if client.version == packets.Version5 {
 				client.topicAliasManager = srv.newTopicAliasManager(client.config, client.opts.ClientTopicAliasMax, client.opts.ClientID)
 			}
 		}
-		srv.mu.Unlock()
+		s.unlock()
 	}()
 
 	client.setConnected(time.Now())
 	if srv.hooks.OnConnected != nil {
-		srv.hooks.OnConnected(context.Background(), client)
+		srv.hooks.OnConnected(client.requestContext(), client)
 	}
 	srv.statsManager.clientConnected(client.opts.ClientID)
 
@@ -420,17 +500,17 @@ func (srv *server) registerClient(connect *packets.Connect, client *client) (ses
 		}
 		// clean old session
 		if !sessionResume {
-			err = srv.sessionTerminatedLocked(oldSession.ClientID, TakenOverTermination)
+			err = srv.sessionTerminatedLocked(s, oldSession.ClientID, TakenOverTermination)
 			if err != nil {
 				err = fmt.Errorf("session terminated fail: %w", err)
 				zaplog.Error("session terminated fail", zap.Error(err))
 			}
 			// Send will message because the previous session is ended.
-			if w, ok := srv.willMessage[client.opts.ClientID]; ok {
+			if w, ok := s.willMessage[client.opts.ClientID]; ok {
 				w.signal(true)
 			}
 		} else {
-			qs = srv.queueStore[client.opts.ClientID]
+			qs = s.queueStore[client.opts.ClientID]
 			if qs != nil {
 				err = qs.Init(&queue.InitOptions{
 					CleanStart:     false,
@@ -442,7 +522,7 @@ func (srv *server) registerClient(connect *packets.Connect, client *client) (ses
 					return
 				}
 			}
-			ua = srv.unackStore[client.opts.ClientID]
+			ua = s.unackStore[client.opts.ClientID]
 			if ua != nil {
 				err = ua.Init(false)
 				if err != nil {
@@ -453,13 +533,9 @@ func (srv *server) registerClient(connect *packets.Connect, client *client) (ses
 				// This could happen if backend store loss some data which will bring the session into "inconsistent state".
 				// We should create a new session and prevent the client reuse the inconsistent one.
 				sessionResume = false
-				zaplog.Error("detect inconsistent session state",
-					zap.String("remote_addr", client.rwc.RemoteAddr().String()),
-					zap.String("client_id", client.opts.ClientID))
+				zaplog.Error("detect inconsistent session state", client.logFields()...)
 			} else {
-				zaplog.Info("logged in with session reuse",
-					zap.String("remote_addr", client.rwc.RemoteAddr().String()),
-					zap.String("client_id", client.opts.ClientID))
+				zaplog.Info("logged in with session reuse", client.logFields()...)
 			}
 
 		}
@@ -489,50 +565,74 @@ func (srv *server) registerClient(connect *packets.Connect, client *client) (ses
 		if err != nil {
 			return
 		}
-		zaplog.Info("logged in with new session",
-			zap.String("remote_addr", client.rwc.RemoteAddr().String()),
-			zap.String("client_id", client.opts.ClientID),
-		)
+		zaplog.Info("logged in with new session", client.logFields()...)
 	}
-	delete(srv.offlineClients, client.opts.ClientID)
+	delete(s.offlineClients, client.opts.ClientID)
+	delete(s.offlineQueued, client.opts.ClientID)
 	return
 }
 
 type willMsg struct {
 	msg *gmqtt.Message
-	// If true, send the msg.
-	// If false, discard the msg.
-	send chan bool
+	// sendAt is the time to send the msg when the will delay interval elapses.
+	sendAt time.Time
+	// timer sends the msg when the will delay interval elapses.
+	timer *timingwheel.Timer
+	// done sends the msg if send is true, or discards it.
+	// It is called once, either by the timer or by signal.
+	done func(send bool)
 }
 
+// signal sends or discards the msg before the will delay interval elapses.
+// It does nothing if the timer has already fired.
 func (w *willMsg) signal(send bool) {
-	select {
-	case w.send <- send:
-	default:
+	if w.timer.Stop() {
+		// signal is called with the shard lock held, which done acquires.
+		go w.done(send)
 	}
 }
 
-// sendWillLocked sends the will message for the client, this function must be guard by srv.Lock.
-func (srv *server) sendWillLocked(msg *gmqtt.Message, clientID string) {
+// sendWill sends the will message for the client.
+// It must not be called with any shard lock held, because delivering the message will lock the shards of the subscribers.
+func (srv *server) sendWill(msg *gmqtt.Message, clientID string) {
+	topic := msg.Topic
 	req := &WillMsgRequest{
-		Message: msg,
+		Message:          msg,
+		IterationOptions: defaultIterateOptions(topic),
 	}
 	if srv.hooks.OnWillPublish != nil {
 		srv.hooks.OnWillPublish(context.Background(), clientID, req)
 	}
 	// the will message is dropped
 	if req.Message == nil {
+		zaplog.Info("will message suppressed", zap.String("client_id", clientID), zap.String("topic", topic))
 		return
 	}
-	srv.deliverMessage(clientID, msg, defaultIterateOptions(msg.Topic))
+	opts := req.IterationOptions
+	// follow the topic change if the hook does not set the iteration options for it.
+	if req.Message.Topic != topic && opts.TopicName == topic {
+		opts.TopicName = req.Message.Topic
+	}
+	if !packets.ValidTopicName(true, []byte(req.Message.Topic)) {
+		zaplog.Error("invalid will topic", zap.String("client_id", clientID), zap.String("topic", req.Message.Topic))
+		return
+	}
+	srv.deliverMessage(clientID, req.Message, opts)
 	if srv.hooks.OnWillPublished != nil {
 		srv.hooks.OnWillPublished(context.Background(), clientID, req.Message)
 	}
 }
 
 func (srv *server) unregisterClient(client *client) {
-	srv.mu.Lock()
-	defer srv.mu.Unlock()
+	var will *gmqtt.Message
+	s := srv.registry.shard(client.opts.ClientID)
+	s.lock()
+	defer func() {
+		s.unlock()
+		if will != nil {
+			srv.sendWill(will, client.opts.ClientID)
+		}
+	}()
 	now := time.Now()
 	var storeSession bool
 	if sess, err := srv.sessionStore.Get(client.opts.ClientID); sess != nil {
@@ -553,64 +653,81 @@ func (srv *server) unregisterClient(client *client) {
 			}
 			msg := sess.Will.Copy()
 			if willDelayInterval != 0 && storeSession {
+				clientID := client.opts.ClientID
 				wm := &willMsg{
-					msg:  msg,
-					send: make(chan bool, 1),
+					msg:    msg,
+					sendAt: now.Add(time.Duration(willDelayInterval) * time.Second),
 				}
-				srv.willMessage[client.opts.ClientID] = wm
-				t := time.NewTimer(time.Duration(willDelayInterval) * time.Second)
-				go func(clientID string) {
-					var send bool
-					select {
-					case send = <-wm.send:
-						t.Stop()
-					case <-t.C:
-						send = true
+				wm.done = func(send bool) {
+					s.lock()
+					// the client may have set another will message after this one was sent.
+					if s.willMessage[clientID] == wm {
+						delete(s.willMessage, clientID)
 					}
-					srv.mu.Lock()
-					defer srv.mu.Unlock()
-					delete(srv.willMessage, clientID)
-					if !send {
-						return
+					s.unlock()
+					if send {
+						srv.sendWill(msg, clientID)
 					}
-					srv.sendWillLocked(msg, clientID)
-				}(client.opts.ClientID)
+				}
+				s.willMessage[clientID] = wm
+				wm.timer = srv.timerWheel.AfterFunc(time.Duration(willDelayInterval)*time.Second, func() {
+					wm.done(true)
+				})
 			} else {
-				srv.sendWillLocked(msg, client.opts.ClientID)
+				will = msg
 			}
 		}
 		if storeSession {
 			expiredTime := now.Add(time.Duration(sess.ExpiryInterval) * time.Second)
-			srv.offlineClients[client.opts.ClientID] = expiredTime
-			delete(srv.clients, client.opts.ClientID)
-			zaplog.Info("logged out and storing session",
-				zap.String("remote_addr", client.rwc.RemoteAddr().String()),
-				zap.String("client_id", client.opts.ClientID),
-				zap.Time("expired_at", expiredTime),
-			)
+			s.offlineClients[client.opts.ClientID] = expiredTime
+			delete(s.clients, client.opts.ClientID)
+			zaplog.Info("logged out and storing session", client.logFields(zap.Time("expired_at", expiredTime))...)
 			return
 		}
 	} else {
-		zaplog.Error("fail to get session",
-			zap.String("remote_addr", client.rwc.RemoteAddr().String()),
-			zap.String("client_id", client.opts.ClientID),
-			zap.Error(err))
-	}
-	zaplog.Info("logged out and cleaning session",
-		zap.String("remote_addr", client.rwc.RemoteAddr().String()),
-		zap.String("client_id", client.opts.ClientID),
-	)
-	_ = srv.sessionTerminatedLocked(client.opts.ClientID, NormalTermination)
+		zaplog.Error("fail to get session", client.logFields(zap.Error(err))...)
+	}
+	zaplog.Info("logged out and cleaning session", client.logFields()...)
+	_ = srv.sessionTerminatedLocked(s, client.opts.ClientID, NormalTermination)
+}
+
+// registeredClient returns the registered client of the client id, or nil if not found.
+func (srv *server) registeredClient(clientID string) *client {
+	s := srv.registry.shard(clientID)
+	s.rlock()
+	defer s.runlock()
+	return s.clients[clientID]
 }
 
-func (srv *server) addMsgToQueueLocked(now time.Time, clientID string, msg *gmqtt.Message, sub *gmqtt.Subscription, ids []uint32, q queue.Store) {
+// addMsgToQueue adds the message into the queue of the client.
+// It is a no-op if the client has no session.
+func (srv *server) addMsgToQueue(now time.Time, clientID string, msg *gmqtt.Message, sub *gmqtt.Subscription, ids []uint32) {
+	s := srv.registry.shard(clientID)
+	s.rlock()
+	defer s.runlock()
+	if q := s.queueStore[clientID]; q != nil {
+		srv.addMsgToQueueLocked(s, now, clientID, msg, sub, ids, q)
+	}
+}
+
+// addMsgToQueueLocked adds the message into the queue, this function must be guard by the shard lock of the client.
+func (srv *server) addMsgToQueueLocked(s *registryShard, now time.Time, clientID string, msg *gmqtt.Message, sub *gmqtt.Subscription, ids []uint32, q queue.Store) {
 	mqttCfg := srv.config.MQTT
 	if !mqttCfg.QueueQos0Msg {
 		// If the client with the clientID is not connected, skip qos0 messages.
-		if c := srv.clients[clientID]; c == nil && msg.QoS == packets.Qos0 {
+		if c := s.clients[clientID]; c == nil && msg.QoS == packets.Qos0 {
+			defaultNotifier(srv.hooks.OnMsgDropped, srv.statsManager, clientID).notifyDropped(msg, queue.ErrDropQos0NotQueued)
 			return
 		}
 	}
+	if msg.QoS == packets.Qos0 && srv.overload.dropQos0() && srv.isSlowSubscriber(s, clientID) {
+		if c := s.clients[clientID]; c != nil {
+			c.queueNotifier.notifyDropped(msg, queue.ErrDropOverloaded)
+		} else {
+			defaultNotifier(srv.hooks.OnMsgDropped, srv.statsManager, clientID).notifyDropped(msg, queue.ErrDropOverloaded)
+		}
+		return
+	}
 	if msg.QoS > sub.QoS {
 		msg.QoS = sub.QoS
 	}
@@ -623,15 +740,19 @@ func (srv *server) addMsgToQueueLocked(now time.Time, clientID string, msg *gmqt
 	if !sub.RetainAsPublished {
 		msg.Retained = false
 	}
+	if c := s.clients[clientID]; c != nil {
+		c.retainedSuperseded(msg.Topic)
+	}
 	var expiry time.Time
-	if mqttCfg.MessageExpiry != 0 {
-		if msg.MessageExpiry != 0 && int(msg.MessageExpiry) <= int(mqttCfg.MessageExpiry) {
-			expiry = now.Add(time.Duration(msg.MessageExpiry) * time.Second)
-		} else {
-			expiry = now.Add(mqttCfg.MessageExpiry)
-		}
-	} else if msg.MessageExpiry != 0 {
-		expiry = now.Add(time.Duration(msg.MessageExpiry) * time.Second)
+	lifetime := time.Duration(msg.MessageExpiry) * time.Second
+	if lifetime == 0 {
+		lifetime = mqttCfg.DefaultMessageExpiry(msg.Topic)
+	}
+	if mqttCfg.MessageExpiry != 0 && (lifetime == 0 || lifetime > mqttCfg.MessageExpiry) {
+		lifetime = mqttCfg.MessageExpiry
+	}
+	if lifetime != 0 {
+		expiry = now.Add(lifetime)
 	}
 	err := q.Add(&queue.Elem{
 		At:     now,
@@ -641,17 +762,41 @@ func (srv *server) addMsgToQueueLocked(now time.Time, clientID string, msg *gmqt
 		},
 	})
 	if err != nil {
-		srv.clients[clientID].queueNotifier.notifyDropped(msg, &queue.InternalError{Err: err})
+		if c := s.clients[clientID]; c != nil {
+			c.queueNotifier.notifyDropped(msg, &queue.InternalError{Err: err})
+		}
 		return
 	}
+	if s.clients[clientID] == nil {
+		srv.offlineQueued(s, clientID)
+	}
 }
 
-// sharedList is the subscriber (client id) list of shared subscriptions. (key by topic name).
-type sharedList map[string][]struct {
-	clientID string
-	sub      *gmqtt.Subscription
+// offlineQueued counts the message queued for the offline session,
+// and calls the OnOfflineBacklog hook once the number of the messages queued since the client disconnected reaches the threshold.
+// This function must be guard by the shard lock of the client.
+func (srv *server) offlineQueued(s *registryShard, clientID string) {
+	srv.statsManager.messageQueuedOffline(clientID)
+	n := s.offlineQueued[clientID] + 1
+	s.offlineQueued[clientID] = n
+	if n == srv.config.MQTT.OfflineBacklog.Threshold && srv.hooks.OnOfflineBacklog != nil {
+		srv.hooks.OnOfflineBacklog(context.Background(), clientID, n)
+	}
 }
 
+// isSlowSubscriber returns whether the client is offline or has a backlog exceeding the inflight window.
+// This function must be guard by the shard lock of the client.
+func (srv *server) isSlowSubscriber(s *registryShard, clientID string) bool {
+	c := s.clients[clientID]
+	if c == nil {
+		return true
+	}
+	return srv.statsManager.queueLen(clientID) > uint64(c.opts.MaxInflight)
+}
+
+// sharedList is the subscriber (client id) list of shared subscriptions. (key by topic name).
+type sharedList map[string][]sharedSubscriber
+
 // maxQos records the maximum qos subscription for the non-shared topic. (key by topic name).
 type maxQos map[string]*struct {
 	sub    *gmqtt.Subscription
@@ -660,44 +805,57 @@ type maxQos map[string]*struct {
 
 // deliverHandler controllers the delivery behaviors according to the DeliveryMode config. (overlap or onlyonce)
 type deliverHandler struct {
-	fn      subscription.IterateFn
-	sl      sharedList
-	mq      maxQos
-	matched bool
-	now     time.Time
-	msg     *gmqtt.Message
-	srv     *server
+	fn          subscription.IterateFn
+	srcClientID string
+	sl          sharedList
+	mq          maxQos
+	matched     bool
+	now         time.Time
+	msg         *gmqtt.Message
+	srv         *server
+	// batcher is nil if the delivery worker pool is disabled.
+	batcher *deliveryBatcher
+	// receipt is the delivery receipt of the message, nil if it is not requested.
+	receipt *receipt
+	// seen indicates whether any subscription matches the topic, including those skipped by no local and the property routing.
+	seen bool
 }
 
 func newDeliverHandler(mode string, srcClientID string, msg *gmqtt.Message, now time.Time, srv *server) *deliverHandler {
 	d := &deliverHandler{
-		sl:  make(sharedList),
-		mq:  make(maxQos),
-		msg: msg,
-		srv: srv,
-		now: now,
+		srcClientID: srcClientID,
+		sl:          make(sharedList),
+		mq:          make(maxQos),
+		msg:         msg,
+		srv:         srv,
+		now:         now,
+	}
+	if srv.deliveryPool != nil {
+		d.batcher = srv.deliveryPool.newBatcher()
 	}
 	var iterateFn subscription.IterateFn
+	countMatch := srv.statsManager.topicStats.newMatchCounter()
+	routingRule := srv.config.MQTT.PropertyRoutingRule(msg.Topic)
 	d.fn = func(clientID string, sub *gmqtt.Subscription) bool {
+		d.seen = true
 		if sub.NoLocal && clientID == srcClientID {
 			return true
 		}
+		if !propertyRouted(routingRule, msg, sub) {
+			return true
+		}
 		d.matched = true
+		countMatch(sub)
 		if sub.ShareName != "" {
 			fullTopic := sub.GetFullTopicName()
-			d.sl[fullTopic] = append(d.sl[fullTopic], struct {
-				clientID string
-				sub      *gmqtt.Subscription
-			}{clientID: clientID, sub: sub})
+			d.sl[fullTopic] = append(d.sl[fullTopic], sharedSubscriber{clientID: clientID, sub: sub})
 			return true
 		}
 		return iterateFn(clientID, sub)
 	}
 	if mode == Overlap {
 		iterateFn = func(clientID string, sub *gmqtt.Subscription) bool {
-			if qs := srv.queueStore[clientID]; qs != nil {
-				srv.addMsgToQueueLocked(now, clientID, msg.Copy(), sub, []uint32{sub.ID}, qs)
-			}
+			d.add(clientID, msg.ShallowCopy(), sub, []uint32{sub.ID})
 			return true
 		}
 	} else {
@@ -720,44 +878,88 @@ func newDeliverHandler(mode string, srcClientID string, msg *gmqtt.Message, now
 	return d
 }
 
+// add adds the message into the queue of the client, or passes it to the delivery worker pool if enabled.
+func (d *deliverHandler) add(clientID string, msg *gmqtt.Message, sub *gmqtt.Subscription, ids []uint32) {
+	d.srv.receipts.track(d.receipt, msg)
+	if d.batcher != nil {
+		d.batcher.add(delivery{
+			now:      d.now,
+			clientID: clientID,
+			msg:      msg,
+			sub:      sub,
+			ids:      ids,
+		})
+		return
+	}
+	d.srv.addMsgToQueue(d.now, clientID, msg, sub, ids)
+}
+
 func (d *deliverHandler) flush() {
 	// shared subscription
-	// TODO enable customize balance strategy of shared subscription
-	for _, v := range d.sl {
-		var rs struct {
-			clientID string
-			sub      *gmqtt.Subscription
-		}
-		// random
-		rs = v[rand.Intn(len(v))]
-		if c, ok := d.srv.queueStore[rs.clientID]; ok {
-			d.srv.addMsgToQueueLocked(d.now, rs.clientID, d.msg.Copy(), rs.sub, []uint32{rs.sub.ID}, c)
-		}
+	strategy := d.srv.config.MQTT.SharedSubscriptionStrategy()
+	for fullTopic, v := range d.sl {
+		rs := d.srv.sharedDispatcher.pick(strategy, d.srcClientID, fullTopic, v)
+		d.srv.statsManager.sharedStats.delivered(rs.clientID, rs.sub)
+		d.add(rs.clientID, d.msg.ShallowCopy(), rs.sub, []uint32{rs.sub.ID})
 	}
 	// For onlyonce mode, send the non-shared messages.
 	for clientID, v := range d.mq {
-		if qs := d.srv.queueStore[clientID]; qs != nil {
-			d.srv.addMsgToQueueLocked(d.now, clientID, d.msg.Copy(), v.sub, v.subIDs, qs)
-		}
+		d.add(clientID, d.msg.ShallowCopy(), v.sub, v.subIDs)
+	}
+	if d.batcher != nil {
+		d.batcher.flush()
 	}
 }
 
-// deliverMessage send msg to matched client, must call under srv.mu.Lock
+// deliverMessage send msg to matched client.
+// The payload of msg is shared by all matched clients rather than being copied for each of them.
+// It must not be called with any shard lock held.
 func (srv *server) deliverMessage(srcClientID string, msg *gmqtt.Message, options subscription.IterationOptions) (matched bool) {
+	var rc *receipt
+	if srv.receipts != nil {
+		msg, rc = srv.receipts.begin(time.Now(), msg, srcClientID, srv.mountPointOf(srcClientID))
+	}
+	return srv.routeMessage(srcClientID, msg, options, rc)
+}
+
+// routeMessage routes msg to the matched clients, and tracks the delivery receipt if rc is not nil.
+func (srv *server) routeMessage(srcClientID string, msg *gmqtt.Message, options subscription.IterationOptions, rc *receipt) (matched bool) {
+	// the options which restrict the iteration match a subset of the subscriptions of the topic.
+	if options.MatchType == subscription.MatchFilter && srv.noSubscribers.has(options.TopicName) {
+		srv.receipts.routed(rc)
+		if srv.config.MQTT.ReportNoSubscriber(msg.Topic) {
+			defaultNotifier(srv.hooks.OnMsgDropped, srv.statsManager, srcClientID).notifyDropped(msg, queue.ErrDropNoSubscriber)
+		}
+		return false
+	}
+	gen := srv.noSubscribers.generation()
 	now := time.Now()
 	d := newDeliverHandler(srv.config.MQTT.DeliveryMode, srcClientID, msg, now, srv)
+	d.receipt = rc
 	srv.subscriptionsDB.Iterate(d.fn, options)
 	d.flush()
+	srv.receipts.routed(rc)
+	if srv.localClients.deliver(srcClientID, msg, options) {
+		d.matched = true
+	}
+	if !d.seen && !d.matched && srv.noSubscribers != nil && options == defaultIterateOptions(options.TopicName) &&
+		!srv.localClients.subscribed(options) {
+		srv.noSubscribers.add(options.TopicName, gen)
+	}
+	if !d.matched && srv.config.MQTT.ReportNoSubscriber(msg.Topic) {
+		defaultNotifier(srv.hooks.OnMsgDropped, srv.statsManager, srcClientID).notifyDropped(msg, queue.ErrDropNoSubscriber)
+	}
 	return d.matched
 }
 
-func (srv *server) removeSessionLocked(clientID string) (err error) {
-	delete(srv.clients, clientID)
-	delete(srv.offlineClients, clientID)
+func (srv *server) removeSessionLocked(s *registryShard, clientID string) (err error) {
+	delete(s.clients, clientID)
+	delete(s.offlineClients, clientID)
+	delete(s.offlineQueued, clientID)
 
 	var errs []string
 	var queueErr, sessionErr, subErr error
-	if qs := srv.queueStore[clientID]; qs != nil {
+	if qs := s.queueStore[clientID]; qs != nil {
 		queueErr = qs.Clean()
 		if queueErr != nil {
 			zaplog.Error("fail to clean message queue",
@@ -765,8 +967,9 @@ func (srv *server) removeSessionLocked(clientID string) (err error) {
 				zap.Error(queueErr))
 			errs = append(errs, "fail to clean message queue: "+queueErr.Error())
 		}
-		delete(srv.queueStore, clientID)
+		delete(s.queueStore, clientID)
 	}
+	delete(s.unackStore, clientID)
 	sessionErr = srv.sessionStore.Remove(clientID)
 	if sessionErr != nil {
 		zaplog.Error("fail to remove session",
@@ -794,20 +997,65 @@ func (srv *server) removeSessionLocked(clientID string) (err error) {
 // sessionExpireCheck check and terminate expired sessions
 func (srv *server) sessionExpireCheck() {
 	now := time.Now()
-	srv.mu.Lock()
-	for cid, expiredTime := range srv.offlineClients {
-		if now.After(expiredTime) {
-			zaplog.Info("session expired", zap.String("client_id", cid))
-			_ = srv.sessionTerminatedLocked(cid, ExpiredTermination)
+	srv.registry.iterate(func(s *registryShard) bool {
+		for cid, expiredTime := range s.offlineClients {
+			if now.After(expiredTime) {
+				zaplog.Info("session expired", zap.String("client_id", cid))
+				if srv.hooks.OnSessionExpired != nil {
+					sess, err := srv.sessionStore.Get(cid)
+					if err != nil {
+						zaplog.Error("fail to get session", zap.String("client_id", cid), zap.Error(err))
+					}
+					srv.hooks.OnSessionExpired(context.Background(), cid, sess)
+				}
+				_ = srv.sessionTerminatedLocked(s, cid, ExpiredTermination)
 
+			}
 		}
-	}
-	srv.mu.Unlock()
+		return true
+	})
 }
 
 // server event loop
 func (srv *server) eventLoop() {
 	sessionExpireTimer := time.NewTicker(time.Second * 20)
+	// overloadCheck is nil if the overload protection is disabled.
+	var overloadCheck <-chan time.Time
+	if srv.overload != nil {
+		t := time.NewTicker(srv.config.OverloadProtection.CheckInterval)
+		defer t.Stop()
+		overloadCheck = t.C
+	}
+	// flappingClean is nil if the flapping detection is disabled.
+	var flappingClean <-chan time.Time
+	if srv.flapping != nil {
+		t := time.NewTicker(srv.config.FlappingDetection.Window)
+		defer t.Stop()
+		flappingClean = t.C
+	}
+	// authThrottleClean is nil if the auth throttling is disabled.
+	var authThrottleClean <-chan time.Time
+	if srv.authThrottle != nil {
+		t := time.NewTicker(srv.config.AuthThrottling.Window)
+		defer t.Stop()
+		authThrottleClean = t.C
+	}
+	// statsSave is nil if the stats persistence is disabled.
+	var statsSave <-chan time.Time
+	if srv.statsFile != "" {
+		t := time.NewTicker(srv.config.StatsPersistence.Interval)
+		defer t.Stop()
+		statsSave = t.C
+	}
+	// receiptsReady and receiptsExpire are nil if the delivery receipts are disabled.
+	var receiptsReady <-chan struct{}
+	var receiptsExpire <-chan time.Time
+	if srv.receipts != nil {
+		t := time.NewTicker(time.Second)
+		defer t.Stop()
+		receiptsReady = srv.receipts.ready
+		receiptsExpire = t.C
+	}
 	defer func() {
 		sessionExpireTimer.Stop()
 		srv.wg.Done()
@@ -818,6 +1066,18 @@ func (srv *server) eventLoop() {
 			return
 		case <-sessionExpireTimer.C:
 			srv.sessionExpireCheck()
+		case <-overloadCheck:
+			srv.overload.check()
+		case now := <-flappingClean:
+			srv.flapping.clean(now)
+		case now := <-authThrottleClean:
+			srv.authThrottle.clean(now)
+		case <-receiptsReady:
+			srv.publishReceipts()
+		case now := <-receiptsExpire:
+			srv.receipts.expire(now)
+		case <-statsSave:
+			srv.saveLifetimeStats()
 		}
 
 	}
@@ -829,22 +1089,59 @@ type WsServer struct {
 	Path     string // Url path
 	CertFile string //TLS configration
 	KeyFile  string //TLS configration
+	// TLSConfig is the TLS configuration, CertFile and KeyFile are ignored if it is set.
+	// Unlike http.Server.TLSConfig, it will not be cloned, so that the changes (e.g. session ticket key rotation) take effect.
+	TLSConfig *tls.Config
+	// AllowAnonymous overrides the mqtt.allow_anonymous config for the clients connected to this server if it is set.
+	AllowAnonymous *bool
+	// Label is the listener label in the RequestInfo, defaults to the address of the server.
+	Label string
+	// MountPoint is the default mount point of the clients connected to this server, see AuthOptions.MountPoint.
+	MountPoint string
+	// RetainAsPublished forces (true) or forbids (false) the Retain As Published subscription option if it is set.
+	RetainAsPublished *bool
+	// NoLocal forces (true) or forbids (false) the No Local subscription option if it is set.
+	NoLocal *bool
+
+	// config is the config which the server is created from, nil if it is not created by NewListenerFromConfig.
+	config *config.ListenerConfig
+	// rotator is the session ticket key rotator, nil if the rotation is disabled.
+	rotator *tlsticket.Rotator
+}
+
+// WsHandlerOptions is the options of the handler returned by Server.WebSocketHandler.
+// The TLS and the url path are up to the http server and the router of the application.
+type WsHandlerOptions struct {
+	// AllowAnonymous overrides the mqtt.allow_anonymous config for the clients connected to this handler if it is set.
+	AllowAnonymous *bool
+	// Label is the listener label in the RequestInfo, defaults to the local address of the connection.
+	Label string
+	// MountPoint is the default mount point of the clients connected to this handler, see AuthOptions.MountPoint.
+	MountPoint string
+	// RetainAsPublished forces (true) or forbids (false) the Retain As Published subscription option if it is set.
+	RetainAsPublished *bool
+	// NoLocal forces (true) or forbids (false) the No Local subscription option if it is set.
+	NoLocal *bool
+	// listenerAddr is the bound address of the websocket server, empty if the handler is not created by the server.
+	listenerAddr string
 }
 
 func defaultServer() *server {
 	srv := &server{
-		status:         serverStatusInit,
-		exitChan:       make(chan struct{}),
-		exitedChan:     make(chan struct{}),
-		clients:        make(map[string]*client),
-		offlineClients: make(map[string]time.Time),
-		willMessage:    make(map[string]*willMsg),
-		retainedDB:     retained_trie.NewStore(),
-		config:         config.DefaultConfig(),
-		queueStore:     make(map[string]queue.Store),
-		unackStore:     make(map[string]unack.Store),
-	}
+		status:       serverStatusInit,
+		exitChan:     make(chan struct{}),
+		exitedChan:   make(chan struct{}),
+		registry:     newRegistry(),
+		timerWheel:   timingwheel.New(timerWheelTick, timerWheelSlots),
+		retainedDB:   retained_trie.NewStore(),
+		config:       config.DefaultConfig(),
+		localClients: newLocalClients(),
+		debugTargets: newDebugTargets(),
+		bans:         newBanList(),
+	}
+	srv.sharedDispatcher = newSharedDispatcher(srv)
 	srv.publishService = &publishService{server: srv}
+	srv.listenerService = &listenerService{srv: srv}
 	return srv
 }
 
@@ -861,10 +1158,26 @@ func (srv *server) init(opts ...Options) (err error) {
 	for _, fn := range opts {
 		fn(srv)
 	}
+	applyRuntimeConfig(srv.config.Runtime)
+	srv.packetDumper, err = newPacketDumper(srv.config.Log)
+	if err != nil {
+		return err
+	}
 	err = srv.initPluginHooks()
 	if err != nil {
 		return err
 	}
+	if srv.config.MQTT.DeliveryReceipts.Enabled() {
+		srv.receipts = newReceiptTracker(srv.config.MQTT.DeliveryReceipts)
+		srv.hooks.OnMsgDropped = srv.receipts.wrapOnMsgDropped(srv.hooks.OnMsgDropped)
+	}
+	if srv.config.MQTT.RetainedFeed.Enabled() {
+		srv.retainedDB = newRetainedFeed(srv, srv.retainedDB, srv.config.MQTT.RetainedFeed)
+	}
+	if srv.config.MQTT.NoSubscriberCache.Enabled() {
+		srv.noSubscribers = newNoSubscriberCache(srv.config.MQTT.NoSubscriberCache.Size)
+		srv.localClients.subs = srv.noSubscribers.watch(srv.localClients.subs)
+	}
 	var pe Persistence
 	peType := srv.config.Persistence.Type
 	if newFn := persistenceFactories[peType]; newFn != nil {
@@ -886,25 +1199,65 @@ func (srv *server) init(opts ...Options) (err error) {
 	if err != nil {
 		return err
 	}
+	if srv.noSubscribers != nil {
+		srv.subscriptionsDB = srv.noSubscribers.watch(srv.subscriptionsDB)
+	}
+	srv.subscriptionsDB = srv.sharedDispatcher.watch(srv.subscriptionsDB)
 	st, err := srv.persistence.NewSessionStore(srv.config)
 	if err != nil {
 		return err
 	}
+	var pl *preloader
+	var cache *sessionCache
+	if srv.config.Persistence.Preload.Enable {
+		pl = newPreloader(srv.config.Persistence.Preload)
+		pl.begin("sessions", -1)
+		cache = newSessionCache(st)
+		st = cache
+	}
 	srv.sessionStore = st
 	var sts []*gmqtt.Session
 	var cids []string
-
+	var preloadErr error
 	err = st.Iterate(func(session *gmqtt.Session) bool {
 		sts = append(sts, session)
 		cids = append(cids, session.ClientID)
+		if pl != nil {
+			cache.add(session)
+			if preloadErr = pl.progress(1); preloadErr != nil {
+				return false
+			}
+		}
 		return true
 	})
 	if err != nil {
 		return err
 	}
+	if preloadErr != nil {
+		return preloadErr
+	}
 	zaplog.Info("init session store succeeded", zap.String("type", peType), zap.Int("session_total", len(cids)))
 
 	srv.statsManager = newStatsManager(srv.subscriptionsDB)
+	srv.statsManager.registry = srv.registry
+	srv.statsManager.hookTimer = srv.hookTimer
+	srv.statsManager.hookTimeouts = srv.hookTimeouts
+	srv.statsManager.pluginSandbox = srv.pluginSandbox
+	err = srv.loadLifetimeStats(srv.config.StatsPersistence)
+	if err != nil {
+		return err
+	}
+	if srv.config.OverloadProtection.Enable {
+		srv.overload = newOverloadGuard(srv.config.OverloadProtection, srv.statsManager)
+	}
+	srv.connQuota = newConnectionQuota(srv.config.ConnectionQuota)
+	srv.connLimiter = newConnectLimiter(srv.config.Handshake, srv.statsManager)
+	if srv.config.FlappingDetection.Enable {
+		srv.flapping = newFlappingDetector(srv.config.FlappingDetection, srv.config.Log)
+	}
+	if srv.config.AuthThrottling.Enable {
+		srv.authThrottle = newAuthThrottle(srv.config.AuthThrottling, srv.config.Log, srv.statsManager)
+	}
 	srv.clientService = &clientService{
 		srv:          srv,
 		sessionStore: srv.sessionStore,
@@ -916,21 +1269,36 @@ func (srv *server) init(opts ...Options) (err error) {
 		if err != nil {
 			return err
 		}
-		srv.queueStore[v.ClientID] = q
-		srv.offlineClients[v.ClientID] = time.Now().Add(time.Duration(v.ExpiryInterval) * time.Second)
+		s := srv.registry.shard(v.ClientID)
+		s.queueStore[v.ClientID] = q
+		s.offlineClients[v.ClientID] = time.Now().Add(time.Duration(v.ExpiryInterval) * time.Second)
 
 		ua, err := srv.persistence.NewUnackStore(srv.config, v.ClientID)
 		if err != nil {
 			return err
 		}
-		srv.unackStore[v.ClientID] = ua
+		s.unackStore[v.ClientID] = ua
 	}
 	zaplog.Info("init queue store succeeded", zap.String("type", peType), zap.Int("session_total", len(cids)))
 	zaplog.Info("init subscription store succeeded", zap.String("type", peType), zap.Int("client_total", len(cids)))
-	err = srv.subscriptionsDB.Init(cids)
+	if pl != nil {
+		err = srv.loadSubscriptions(pl, cids)
+	} else {
+		err = srv.subscriptionsDB.Init(cids)
+	}
+	if err != nil {
+		return err
+	}
+	err = srv.loadRetainedSeed(srv.config.MQTT.RetainedSeed)
 	if err != nil {
 		return err
 	}
+	if pl != nil {
+		zaplog.Info("preload succeeded",
+			zap.Int("session_total", len(cids)),
+			zap.Uint64("subscription_total", srv.subscriptionsDB.GetStats().SubscriptionsCurrent),
+			zap.Duration("elapsed", time.Since(pl.started)))
+	}
 
 	topicAliasMgrFactory := topicAliasMgrFactory[srv.config.TopicAliasManager.Type]
 	if topicAliasMgrFactory != nil {
@@ -942,13 +1310,24 @@ func (srv *server) init(opts ...Options) (err error) {
 	if err != nil {
 		return err
 	}
+	if srv.config.ConnectionEngine.Type == config.ConnectionEngineNetpoll {
+		srv.poller, err = netpoll.New()
+		if err != nil {
+			return fmt.Errorf("fail to start netpoll connection engine: %w", err)
+		}
+		zaplog.Info("init netpoll connection engine succeeded")
+	}
+	if srv.config.Delivery.Workers > 0 {
+		srv.deliveryPool = newDeliveryPool(srv, srv.config.Delivery)
+		zaplog.Info("init delivery worker pool succeeded", zap.Int("workers", srv.config.Delivery.Workers))
+	}
 	return srv.loadPlugins()
 }
 
 func (srv *server) initAPIRegistrar() error {
 	registrar := &apiRegistrar{}
 	for _, v := range srv.config.API.HTTP {
-		server, err := buildHTTPServer(v)
+		server, err := buildHTTPServer(v, srv.config.Crypto)
 		if err != nil {
 			return err
 		}
@@ -956,7 +1335,7 @@ func (srv *server) initAPIRegistrar() error {
 
 	}
 	for _, v := range srv.config.API.GRPC {
-		server, err := buildGRPCServer(v)
+		server, err := buildGRPCServer(v, srv.config.Crypto, registrar.unaryInterceptor)
 		if err != nil {
 			return err
 		}
@@ -976,15 +1355,34 @@ func (srv *server) Init(opts ...Options) (err error) {
 
 // Client returns the client for given clientID
 func (srv *server) Client(clientID string) Client {
-	srv.mu.Lock()
-	defer srv.mu.Unlock()
-	return srv.clients[clientID]
+	s := srv.registry.shard(clientID)
+	s.rlock()
+	defer s.runlock()
+	return s.clients[clientID]
 }
 
 func (srv *server) serveTCP(l net.Listener) {
 	defer func() {
 		l.Close()
 	}()
+	var opts ListenerOptions
+	if ln, ok := l.(*listener); ok {
+		opts = ln.opts
+	}
+	label := opts.Label
+	if label == "" {
+		label = l.Addr().String()
+	}
+	codec, err := getConnectionCodec(opts.Codec)
+	if err != nil {
+		zaplog.Error("listener stopped", zap.String("listener", label), zap.Error(err))
+		return
+	}
+	pskCfg, err := srv.newPSKConfig(opts.PSK)
+	if err != nil {
+		zaplog.Error("listener stopped", zap.String("listener", label), zap.Error(err))
+		return
+	}
 	var tempDelay time.Duration
 	for {
 		rw, e := l.Accept()
@@ -1003,8 +1401,21 @@ func (srv *server) serveTCP(l net.Listener) {
 			}
 			return
 		}
+		// if the reconnect advice is enabled, the connection is refused with the advice after reading the CONNECT packet.
+		if srv.overload.rejectConnection() && !srv.config.MQTT.ReconnectAdvice.Enabled() {
+			zaplog.Warn("server overloaded, connection rejected", zap.String("remote_addr", redactRemoteAddr(srv.config.Log, rw.RemoteAddr().String())))
+			rw.Close()
+			continue
+		}
+		if pskCfg != nil {
+			rw = srv.pskServer(rw, pskCfg, label)
+		}
 		if srv.hooks.OnAccept != nil {
-			if !srv.hooks.OnAccept(context.Background(), rw) {
+			ctx := context.WithValue(context.Background(), requestInfoKey{}, RequestInfo{
+				Listener:   label,
+				RemoteAddr: rw.RemoteAddr().String(),
+			})
+			if !srv.hooks.OnAccept(ctx, rw) {
 				rw.Close()
 				continue
 			}
@@ -1014,6 +1425,13 @@ func (srv *server) serveTCP(l net.Listener) {
 			zaplog.Error("new client fail", zap.Error(err))
 			return
 		}
+		client.allowAnonymousOverride = opts.AllowAnonymous
+		client.listener = label
+		client.listenerAddr = l.Addr().String()
+		client.mountPoint = opts.MountPoint
+		client.retainAsPublishedOverride = opts.RetainAsPublished
+		client.noLocalOverride = opts.NoLocal
+		client.codec = codec
 		go client.serve()
 	}
 }
@@ -1041,7 +1459,8 @@ func (ws *wsConn) Close() error {
 }
 
 func (ws *wsConn) Read(p []byte) (n int, err error) {
-	if ws.buf == nil {
+	// skip the empty messages, the reader may fail with io.ErrNoProgress if too many reads return no data.
+	for len(ws.buf) == 0 {
 		msgType, buf, err := ws.c.ReadMessage()
 		if err != nil {
 			return 0, err
@@ -1054,7 +1473,7 @@ func (ws *wsConn) Read(p []byte) (n int, err error) {
 	n = copy(p, ws.buf[ws.r:])
 	ws.r += n
 	// reset reader buffer
-	if ws.r+1 >= len(ws.buf) {
+	if ws.r >= len(ws.buf) {
 		ws.buf = nil
 		ws.r = 0
 	}
@@ -1069,15 +1488,47 @@ func (ws *wsConn) Write(p []byte) (n int, err error) {
 	return len(p), err
 }
 
-func (srv *server) serveWebSocket(ws *WsServer) {
-	var err error
-	if ws.CertFile != "" && ws.KeyFile != "" {
-		err = ws.Server.ListenAndServeTLS(ws.CertFile, ws.KeyFile)
-	} else {
-		err = ws.Server.ListenAndServe()
+// listenWebSocket binds the address of the websocket server.
+func (srv *server) listenWebSocket(ws *WsServer) (net.Listener, error) {
+	tlsCfg := ws.TLSConfig
+	if tlsCfg == nil && ws.CertFile != "" && ws.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(ws.CertFile, ws.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsCfg = &tls.Config{Certificates: []tls.Certificate{cert}}
+	}
+	ln, err := net.Listen("tcp", ws.Server.Addr)
+	if err != nil {
+		return nil, err
+	}
+	if ws.config != nil && ws.config.Probe != nil {
+		ln = newProbeListener(ln, *ws.config.Probe)
 	}
+	if tlsCfg != nil {
+		ln = tls.NewListener(ln, tlsCfg)
+	}
+	mux := http.NewServeMux()
+	label := ws.Label
+	if label == "" {
+		label = ws.Server.Addr
+	}
+	mux.Handle(ws.Path, srv.wsHandler(WsHandlerOptions{
+		AllowAnonymous:    ws.AllowAnonymous,
+		Label:             label,
+		MountPoint:        ws.MountPoint,
+		RetainAsPublished: ws.RetainAsPublished,
+		NoLocal:           ws.NoLocal,
+		listenerAddr:      ln.Addr().String(),
+	}))
+	ws.Server.Handler = mux
+	return ln, nil
+}
+
+func (srv *server) serveWebSocket(ws *WsServer, ln net.Listener) {
+	err := ws.Server.Serve(ln)
 	if err != nil && err != http.ErrServerClosed {
-		srv.setError(fmt.Errorf("serveWebSocket error: %s", err.Error()))
+		zaplog.Error("serveWebSocket error", zap.String("address", ws.Server.Addr), zap.Error(err))
 	}
 }
 
@@ -1086,27 +1537,23 @@ func (srv *server) newClient(c net.Conn) (*client, error) {
 	cfg := srv.config
 	srv.configMu.Unlock()
 	client := &client{
-		server:        srv,
-		rwc:           c,
-		bufr:          newBufioReaderSize(c, readBufferSize),
-		bufw:          newBufioWriterSize(c, writeBufferSize),
-		close:         make(chan struct{}),
-		closed:        make(chan struct{}),
-		connected:     make(chan struct{}),
-		error:         make(chan error, 1),
-		in:            make(chan packets.Packet, 8),
-		out:           make(chan packets.Packet, 8),
-		status:        Connecting,
-		opts:          &ClientOptions{},
-		cleanWillFlag: false,
-		config:        cfg,
-		register:      srv.registerClient,
-		unregister:    srv.unregisterClient,
-		deliverMessage: func(srcClientID string, msg *gmqtt.Message, options subscription.IterationOptions) (matched bool) {
-			srv.mu.Lock()
-			defer srv.mu.Unlock()
-			return srv.deliverMessage(srcClientID, msg, options)
-		},
+		server:         srv,
+		rwc:            c,
+		bufr:           newBufioReaderSize(c, readBufferSize),
+		bufw:           newBufioWriterSize(c, writeBufferSize),
+		close:          make(chan struct{}),
+		closed:         make(chan struct{}),
+		connected:      make(chan struct{}),
+		error:          make(chan error, 1),
+		in:             make(chan *inboundPacket, 8),
+		out:            make(chan packets.Packet, 8),
+		status:         Connecting,
+		opts:           &ClientOptions{},
+		cleanWillFlag:  false,
+		config:         cfg,
+		register:       srv.registerClient,
+		unregister:     srv.unregisterClient,
+		deliverMessage: srv.deliverMessage,
 	}
 	client.packetReader = packets.NewReader(client.bufr)
 	client.packetWriter = packets.NewWriter(client.bufw)
@@ -1115,6 +1562,19 @@ func (srv *server) newClient(c net.Conn) (*client, error) {
 		sts:      srv.statsManager,
 		cli:      client,
 	}
+	if srv.hooks.OnDeliveryReport != nil {
+		client.deliveries = newDeliveryTracker()
+	}
+	if cfg.MQTT.Redelivery.Enabled() {
+		client.redelivery = newRedeliveryTracker(cfg.MQTT.Redelivery)
+	}
+	if len(cfg.MQTT.OrderedTopics) != 0 {
+		client.lanes = newOrderedLanes(client.write)
+	}
+	client.writeBuffer = newWriteBuffer(cfg.MQTT.WriteBuffer, client.writeBufferExpired)
+	if cfg.MQTT.Takeover.DupFlag == config.TakeoverDupStrict {
+		client.unsent = newUnsentInflight()
+	}
 	client.setConnecting()
 
 	return client, nil
@@ -1122,6 +1582,12 @@ func (srv *server) newClient(c net.Conn) (*client, error) {
 
 func (srv *server) initPluginHooks() error {
 	zaplog.Info("init plugin hook wrappers")
+	if srv.config.HookTiming.Metrics {
+		srv.hookTimer = &hookTimer{}
+	}
+	if srv.config.PluginSandbox.Enable {
+		srv.pluginSandbox = newPluginSandbox(srv.config.PluginSandbox)
+	}
 	var (
 		onAcceptWrappers           []OnAcceptWrapper
 		onBasicAuthWrappers        []OnBasicAuthWrapper
@@ -1131,18 +1597,28 @@ func (srv *server) initPluginHooks() error {
 		onSessionCreatedWrapper    []OnSessionCreatedWrapper
 		onSessionResumedWrapper    []OnSessionResumedWrapper
 		onSessionTerminatedWrapper []OnSessionTerminatedWrapper
+		onSessionExpiredWrapper    []OnSessionExpiredWrapper
+		onOfflineBacklogWrapper    []OnOfflineBacklogWrapper
+		onSessionTakenOverWrapper  []OnSessionTakenOverWrapper
 		onSubscribeWrappers        []OnSubscribeWrapper
 		onSubscribedWrappers       []OnSubscribedWrapper
 		onUnsubscribeWrappers      []OnUnsubscribeWrapper
 		onUnsubscribedWrappers     []OnUnsubscribedWrapper
 		onMsgArrivedWrappers       []OnMsgArrivedWrapper
 		OnDeliveredWrappers        []OnDeliveredWrapper
+		onDeliveryReportWrappers   []OnDeliveryReportWrapper
 		OnClosedWrappers           []OnClosedWrapper
 		onStopWrappers             []OnStopWrapper
 		onMsgDroppedWrappers       []OnMsgDroppedWrapper
 		onWillPublishWrappers      []OnWillPublishWrapper
 		onWillPublishedWrappers    []OnWillPublishedWrapper
+		onPacketReceivedWrappers   []OnPacketReceivedWrapper
+		onPacketSendWrappers       []OnPacketSendWrapper
+		onPSKLookupWrappers        []OnPSKLookupWrapper
 	)
+	if err := ValidatePluginOrder(srv.config.PluginOrder); err != nil {
+		return err
+	}
 	for _, v := range srv.config.PluginOrder {
 		plg, err := plugins[v](srv.config)
 		if err != nil {
@@ -1153,6 +1629,24 @@ func (srv *server) initPluginHooks() error {
 
 	for _, p := range srv.plugins {
 		hooks := p.HookWrapper()
+		// guard the hooks before the asynchronous wrapper, so that the panics in the workers are recovered as well.
+		if srv.pluginSandbox != nil {
+			hooks = srv.pluginSandbox.guard(p.Name()).guardedHookWrapper(hooks)
+		}
+		if len(hooks.AsyncHooks) != 0 {
+			if srv.hookPool == nil {
+				srv.hookPool = newHookPool(srv.config.AsyncHook)
+				zaplog.Info("init async hook worker pool succeeded", zap.Int("workers", srv.config.AsyncHook.Workers))
+			}
+			var err error
+			hooks, err = srv.hookPool.asyncHookWrapper(hooks)
+			if err != nil {
+				return fmt.Errorf("plugin %s: %w", p.Name(), err)
+			}
+		}
+		if srv.hookTimer != nil {
+			hooks = srv.hookTimer.timedHookWrapper(p.Name(), hooks)
+		}
 		// init all hook wrappers
 		if hooks.OnAcceptWrapper != nil {
 			onAcceptWrappers = append(onAcceptWrappers, hooks.OnAcceptWrapper)
@@ -1178,6 +1672,15 @@ func (srv *server) initPluginHooks() error {
 		if hooks.OnSessionTerminatedWrapper != nil {
 			onSessionTerminatedWrapper = append(onSessionTerminatedWrapper, hooks.OnSessionTerminatedWrapper)
 		}
+		if hooks.OnSessionExpiredWrapper != nil {
+			onSessionExpiredWrapper = append(onSessionExpiredWrapper, hooks.OnSessionExpiredWrapper)
+		}
+		if hooks.OnOfflineBacklogWrapper != nil {
+			onOfflineBacklogWrapper = append(onOfflineBacklogWrapper, hooks.OnOfflineBacklogWrapper)
+		}
+		if hooks.OnSessionTakenOverWrapper != nil {
+			onSessionTakenOverWrapper = append(onSessionTakenOverWrapper, hooks.OnSessionTakenOverWrapper)
+		}
 		if hooks.OnSubscribeWrapper != nil {
 			onSubscribeWrappers = append(onSubscribeWrappers, hooks.OnSubscribeWrapper)
 		}
@@ -1199,6 +1702,9 @@ func (srv *server) initPluginHooks() error {
 		if hooks.OnDeliveredWrapper != nil {
 			OnDeliveredWrappers = append(OnDeliveredWrappers, hooks.OnDeliveredWrapper)
 		}
+		if hooks.OnDeliveryReportWrapper != nil {
+			onDeliveryReportWrappers = append(onDeliveryReportWrappers, hooks.OnDeliveryReportWrapper)
+		}
 		if hooks.OnClosedWrapper != nil {
 			OnClosedWrappers = append(OnClosedWrappers, hooks.OnClosedWrapper)
 		}
@@ -1211,6 +1717,15 @@ func (srv *server) initPluginHooks() error {
 		if hooks.OnWillPublishedWrapper != nil {
 			onWillPublishedWrappers = append(onWillPublishedWrappers, hooks.OnWillPublishedWrapper)
 		}
+		if hooks.OnPacketReceivedWrapper != nil {
+			onPacketReceivedWrappers = append(onPacketReceivedWrappers, hooks.OnPacketReceivedWrapper)
+		}
+		if hooks.OnPacketSendWrapper != nil {
+			onPacketSendWrappers = append(onPacketSendWrappers, hooks.OnPacketSendWrapper)
+		}
+		if hooks.OnPSKLookupWrapper != nil {
+			onPSKLookupWrappers = append(onPSKLookupWrappers, hooks.OnPSKLookupWrapper)
+		}
 	}
 	if onAcceptWrappers != nil {
 		onAccept := func(ctx context.Context, conn net.Conn) bool {
@@ -1257,7 +1772,7 @@ func (srv *server) initPluginHooks() error {
 		srv.hooks.OnSessionCreated = onSessionCreated
 	}
 	if onSessionResumedWrapper != nil {
-		onSessionResumed := func(ctx context.Context, client Client) {}
+		onSessionResumed := func(ctx context.Context, client Client, queued int) {}
 		for i := len(onSessionResumedWrapper); i > 0; i-- {
 			onSessionResumed = onSessionResumedWrapper[i-1](onSessionResumed)
 		}
@@ -1270,6 +1785,27 @@ func (srv *server) initPluginHooks() error {
 		}
 		srv.hooks.OnSessionTerminated = onSessionTerminated
 	}
+	if onSessionExpiredWrapper != nil {
+		onSessionExpired := func(ctx context.Context, clientID string, session *gmqtt.Session) {}
+		for i := len(onSessionExpiredWrapper); i > 0; i-- {
+			onSessionExpired = onSessionExpiredWrapper[i-1](onSessionExpired)
+		}
+		srv.hooks.OnSessionExpired = onSessionExpired
+	}
+	if onOfflineBacklogWrapper != nil {
+		onOfflineBacklog := func(ctx context.Context, clientID string, queued int) {}
+		for i := len(onOfflineBacklogWrapper); i > 0; i-- {
+			onOfflineBacklog = onOfflineBacklogWrapper[i-1](onOfflineBacklog)
+		}
+		srv.hooks.OnOfflineBacklog = onOfflineBacklog
+	}
+	if onSessionTakenOverWrapper != nil {
+		onSessionTakenOver := func(ctx context.Context, client Client, info *TakeoverInfo) {}
+		for i := len(onSessionTakenOverWrapper); i > 0; i-- {
+			onSessionTakenOver = onSessionTakenOverWrapper[i-1](onSessionTakenOver)
+		}
+		srv.hooks.OnSessionTakenOver = onSessionTakenOver
+	}
 	if onSubscribeWrappers != nil {
 		onSubscribe := func(ctx context.Context, client Client, req *SubscribeRequest) error {
 			return nil
@@ -1318,8 +1854,15 @@ func (srv *server) initPluginHooks() error {
 		}
 		srv.hooks.OnDelivered = OnDelivered
 	}
+	if onDeliveryReportWrappers != nil {
+		onDeliveryReport := func(ctx context.Context, client Client, report *DeliveryReport) {}
+		for i := len(onDeliveryReportWrappers); i > 0; i-- {
+			onDeliveryReport = onDeliveryReportWrappers[i-1](onDeliveryReport)
+		}
+		srv.hooks.OnDeliveryReport = onDeliveryReport
+	}
 	if OnClosedWrappers != nil {
-		OnClosed := func(ctx context.Context, client Client, err error) {}
+		OnClosed := func(ctx context.Context, client Client, reason *CloseReason) {}
 		for i := len(OnClosedWrappers); i > 0; i-- {
 			OnClosed = OnClosedWrappers[i-1](OnClosed)
 		}
@@ -1353,7 +1896,36 @@ func (srv *server) initPluginHooks() error {
 		}
 		srv.hooks.OnWillPublished = onWillPublished
 	}
-	return nil
+	if onPacketReceivedWrappers != nil {
+		onPacketReceived := func(ctx context.Context, client Client, packet packets.Packet) error {
+			return nil
+		}
+		for i := len(onPacketReceivedWrappers); i > 0; i-- {
+			onPacketReceived = onPacketReceivedWrappers[i-1](onPacketReceived)
+		}
+		srv.hooks.OnPacketReceived = onPacketReceived
+	}
+	if onPacketSendWrappers != nil {
+		onPacketSend := func(ctx context.Context, client Client, packet packets.Packet) error {
+			return nil
+		}
+		for i := len(onPacketSendWrappers); i > 0; i-- {
+			onPacketSend = onPacketSendWrappers[i-1](onPacketSend)
+		}
+		srv.hooks.OnPacketSend = onPacketSend
+	}
+	if onPSKLookupWrappers != nil {
+		onPSKLookup := func(ctx context.Context, identity string) ([]byte, error) {
+			return nil, nil
+		}
+		for i := len(onPSKLookupWrappers); i > 0; i-- {
+			onPSKLookup = onPSKLookupWrappers[i-1](onPSKLookup)
+		}
+		srv.hooks.OnPSKLookup = onPSKLookup
+	}
+	var err error
+	srv.hookTimeouts, err = srv.applyHookTimeouts(srv.config.HookTiming.Timeouts)
+	return err
 }
 
 func (srv *server) loadPlugins() error {
@@ -1367,8 +1939,32 @@ func (srv *server) loadPlugins() error {
 	return nil
 }
 
-func (srv *server) wsHandler() http.HandlerFunc {
+// WebSocketHandler returns the MQTT over WebSocket handler.
+// The handler responds 503 Service Unavailable before the server is started or after it is stopped.
+func (srv *server) WebSocketHandler(opts WsHandlerOptions) http.Handler {
+	h := srv.wsHandler(opts)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-srv.exitChan:
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		default:
+		}
+		if srv.Status() != serverStatusStarted {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		h(w, r)
+	})
+}
+
+func (srv *server) wsHandler(opts WsHandlerOptions) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		if srv.overload.rejectConnection() && !srv.config.MQTT.ReconnectAdvice.Enabled() {
+			zaplog.Warn("server overloaded, connection rejected", zap.String("remote_addr", redactRemoteAddr(srv.config.Log, r.RemoteAddr)))
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
 		c, err := defaultUpgrader.Upgrade(w, r, nil)
 		if err != nil {
 			zaplog.Error("websocket upgrade error", zap.String("Msg", err.Error()))
@@ -1381,6 +1977,17 @@ func (srv *server) wsHandler() http.HandlerFunc {
 			zaplog.Error("new client fail", zap.Error(err))
 			return
 		}
+		client.allowAnonymousOverride = opts.AllowAnonymous
+		client.listener = opts.Label
+		client.listenerAddr = opts.listenerAddr
+		client.mountPoint = opts.MountPoint
+		client.retainAsPublishedOverride = opts.RetainAsPublished
+		client.noLocalOverride = opts.NoLocal
+		if client.listener == "" {
+			if addr, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+				client.listener = addr.String()
+			}
+		}
 		client.serve()
 	}
 }
@@ -1408,19 +2015,25 @@ func (srv *server) Run() (err error) {
 	}
 	zaplog.Info("gmqtt server started", zap.Strings("tcp server listen on", tcps), zap.Strings("websocket server listen on", ws))
 
-	srv.status = serverStatusStarted
+	atomic.StoreInt32(&srv.status, serverStatusStarted)
 	srv.wg.Add(2)
 	go srv.eventLoop()
 	go srv.serveAPIServer()
+	srv.listenersMu.Lock()
 	for _, ln := range srv.tcpListener {
-		go srv.serveTCP(ln)
+		l := newRunningTCPListener(ln)
+		srv.startListener(l)
+		srv.listeners = append(srv.listeners, l)
 	}
 	for _, server := range srv.websocketServer {
-		mux := http.NewServeMux()
-		mux.Handle(server.Path, srv.wsHandler())
-		server.Server.Handler = mux
-		go srv.serveWebSocket(server)
+		l := newRunningWsListener(server)
+		if err := srv.startListener(l); err != nil {
+			srv.setError(fmt.Errorf("serveWebSocket error: %s", err.Error()))
+			continue
+		}
+		srv.listeners = append(srv.listeners, l)
 	}
+	srv.listenersMu.Unlock()
 	srv.wg.Wait()
 	<-srv.exitedChan
 	return srv.err
@@ -1437,26 +2050,26 @@ func (srv *server) Stop(ctx context.Context) error {
 		zaplog.Info("stopping gmqtt server")
 		defer func() {
 			defer close(srv.exitedChan)
+			srv.saveLifetimeStats()
 			zaplog.Info("server stopped")
 		}()
 		srv.exit()
 
-		for _, l := range srv.tcpListener {
-			l.Close()
-		}
-		for _, ws := range srv.websocketServer {
-			ws.Server.Shutdown(ctx)
+		srv.listenersMu.Lock()
+		for _, l := range srv.listeners {
+			l.close(ctx)
 		}
+		srv.listeners = nil
+		srv.listenersMu.Unlock()
 		// close all idle clients
-		srv.mu.Lock()
-		chs := make([]chan struct{}, len(srv.clients))
-		i := 0
-		for _, c := range srv.clients {
-			chs[i] = c.closed
-			i++
-			c.Close()
-		}
-		srv.mu.Unlock()
+		var chs []chan struct{}
+		srv.registry.iterate(func(s *registryShard) bool {
+			for _, c := range s.clients {
+				chs = append(chs, c.closed)
+				c.Close()
+			}
+			return true
+		})
 
 		done := make(chan struct{})
 		if len(chs) != 0 {
@@ -1476,6 +2089,12 @@ func (srv *server) Stop(ctx context.Context) error {
 			err = ctx.Err()
 			return
 		case <-done:
+			if srv.deliveryPool != nil {
+				srv.deliveryPool.close()
+			}
+			if srv.hookPool != nil {
+				srv.hookPool.close()
+			}
 			for _, v := range srv.plugins {
 				zaplog.Info("unloading plugin", zap.String("name", v.Name()))
 				err := v.Unload()
@@ -1486,6 +2105,11 @@ func (srv *server) Stop(ctx context.Context) error {
 			if srv.hooks.OnStop != nil {
 				srv.hooks.OnStop(context.Background())
 			}
+			if srv.poller != nil {
+				_ = srv.poller.Close()
+			}
+			srv.timerWheel.Stop()
+
 		}
 	})
 	return err
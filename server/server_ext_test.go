@@ -0,0 +1,148 @@
+package server_test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+
+	"github.com/DrmagicE/gmqtt/config"
+	_ "github.com/DrmagicE/gmqtt/persistence"
+	"github.com/DrmagicE/gmqtt/pkg/codes"
+	"github.com/DrmagicE/gmqtt/pkg/packets"
+	"github.com/DrmagicE/gmqtt/server"
+	_ "github.com/DrmagicE/gmqtt/topicalias/fifo"
+)
+
+// startTestServer starts a server listening on a random tcp port.
+func startTestServer(t *testing.T, cfg config.Config) (server.Server, string) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.API = config.API{}
+	srv := server.New(
+		server.WithConfig(cfg),
+		server.WithTCPListener(ln),
+		server.WithLogger(zap.NewNop()),
+	)
+	go func() {
+		_ = srv.Run()
+	}()
+	for i := 0; ; i++ {
+		if c, err := net.Dial("tcp", ln.Addr().String()); err == nil {
+			_ = c.Close()
+			break
+		}
+		if i == 50 {
+			t.Fatal("server not ready")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = srv.Stop(ctx)
+	})
+	return srv, ln.Addr().String()
+}
+
+type testConn struct {
+	t    *testing.T
+	conn net.Conn
+	r    *packets.Reader
+	w    *packets.Writer
+}
+
+// dialMQTT connects to the server and finishes the CONNECT flow.
+func dialMQTT(t *testing.T, addr string, clientID string, keepAlive uint16) *testConn {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &testConn{t: t, conn: conn, r: packets.NewReader(conn), w: packets.NewWriter(conn)}
+	c.r.SetVersion(packets.Version311)
+	c.write(&packets.Connect{
+		Version:       packets.Version311,
+		ProtocolLevel: packets.Version311,
+		ProtocolName:  []byte("MQTT"),
+		CleanStart:    true,
+		ClientID:      []byte(clientID),
+		KeepAlive:     keepAlive,
+	})
+	ack, ok := c.read().(*packets.Connack)
+	if !ok || ack.Code != 0 {
+		t.Fatalf("unexpected connack: %v", ack)
+	}
+	return c
+}
+
+func (c *testConn) write(p packets.Packet) {
+	if err := c.w.WriteAndFlush(p); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func (c *testConn) read() packets.Packet {
+	_ = c.conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	p, err := c.r.ReadPacket()
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return p
+}
+
+// waitClosed returns the duration until the connection is closed by the server.
+func (c *testConn) waitClosed(timeout time.Duration) time.Duration {
+	start := time.Now()
+	_ = c.conn.SetReadDeadline(start.Add(timeout))
+	for {
+		if _, err := c.r.ReadPacket(); err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				c.t.Fatal("the connection is not closed by the server")
+			}
+			return time.Since(start)
+		}
+	}
+}
+
+func TestServer_connectionQuota_takeover(t *testing.T) {
+	a := assert.New(t)
+	cfg := config.DefaultConfig()
+	cfg.ConnectionQuota.MaxPerIP = 1
+	srv, addr := startTestServer(t, cfg)
+
+	old := dialMQTT(t, addr, "cid", 60)
+	defer old.conn.Close()
+	// the half-open connection is still registered when the client reconnects.
+	c := dialMQTT(t, addr, "cid", 60)
+	defer c.conn.Close()
+	old.waitClosed(3 * time.Second)
+	a.NotNil(srv.ClientService().GetClient("cid"))
+
+	// the slot of the old connection is released.
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	w := packets.NewWriter(conn)
+	a.NoError(w.WriteAndFlush(&packets.Connect{
+		Version:       packets.Version5,
+		ProtocolLevel: packets.Version5,
+		ProtocolName:  []byte("MQTT"),
+		CleanStart:    true,
+		ClientID:      []byte("other"),
+	}))
+	r := packets.NewReader(conn)
+	r.SetVersion(packets.Version5)
+	_ = conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	p, err := r.ReadPacket()
+	a.NoError(err)
+	if ack, ok := p.(*packets.Connack); a.True(ok) {
+		a.Equal(codes.QuotaExceeded, ack.Code)
+	}
+}
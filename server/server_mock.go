@@ -8,6 +8,7 @@ import (
 	context "context"
 	config "github.com/DrmagicE/gmqtt/config"
 	gomock "github.com/golang/mock/gomock"
+	http "net/http"
 	reflect "reflect"
 )
 
@@ -144,6 +145,20 @@ func (mr *MockServerMockRecorder) RetainedService() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RetainedService", reflect.TypeOf((*MockServer)(nil).RetainedService))
 }
 
+// ListenerService mocks base method
+func (m *MockServer) ListenerService() ListenerService {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListenerService")
+	ret0, _ := ret[0].(ListenerService)
+	return ret0
+}
+
+// ListenerService indicates an expected call of ListenerService
+func (mr *MockServerMockRecorder) ListenerService() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListenerService", reflect.TypeOf((*MockServer)(nil).ListenerService))
+}
+
 // Plugins mocks base method
 func (m *MockServer) Plugins() []Plugin {
 	m.ctrl.T.Helper()
@@ -158,6 +173,21 @@ func (mr *MockServerMockRecorder) Plugins() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Plugins", reflect.TypeOf((*MockServer)(nil).Plugins))
 }
 
+// NewLocalClient mocks base method
+func (m *MockServer) NewLocalClient(clientID string) (LocalClient, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "NewLocalClient", clientID)
+	ret0, _ := ret[0].(LocalClient)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// NewLocalClient indicates an expected call of NewLocalClient
+func (mr *MockServerMockRecorder) NewLocalClient(clientID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "NewLocalClient", reflect.TypeOf((*MockServer)(nil).NewLocalClient), clientID)
+}
+
 // APIRegistrar mocks base method
 func (m *MockServer) APIRegistrar() APIRegistrar {
 	m.ctrl.T.Helper()
@@ -171,3 +201,17 @@ func (mr *MockServerMockRecorder) APIRegistrar() *gomock.Call {
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "APIRegistrar", reflect.TypeOf((*MockServer)(nil).APIRegistrar))
 }
+
+// WebSocketHandler mocks base method
+func (m *MockServer) WebSocketHandler(opts WsHandlerOptions) http.Handler {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "WebSocketHandler", opts)
+	ret0, _ := ret[0].(http.Handler)
+	return ret0
+}
+
+// WebSocketHandler indicates an expected call of WebSocketHandler
+func (mr *MockServerMockRecorder) WebSocketHandler(opts interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "WebSocketHandler", reflect.TypeOf((*MockServer)(nil).WebSocketHandler), opts)
+}
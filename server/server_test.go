@@ -1,7 +1,12 @@
 package server
 
 import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/assert"
@@ -9,8 +14,10 @@ import (
 	"github.com/DrmagicE/gmqtt"
 	"github.com/DrmagicE/gmqtt/config"
 	"github.com/DrmagicE/gmqtt/persistence/queue"
+	sessmem "github.com/DrmagicE/gmqtt/persistence/session/mem"
 	"github.com/DrmagicE/gmqtt/persistence/subscription/mem"
 	"github.com/DrmagicE/gmqtt/pkg/packets"
+	"github.com/DrmagicE/gmqtt/pkg/timingwheel"
 )
 
 type testDeliverMsg struct {
@@ -21,12 +28,12 @@ func newTestDeliverMsg(ctrl *gomock.Controller, subscriber string) *testDeliverM
 	sub := mem.NewStore()
 	srv := &server{
 		subscriptionsDB: sub,
-		queueStore:      make(map[string]queue.Store),
+		registry:        newRegistry(),
 		config:          config.DefaultConfig(),
 		statsManager:    newStatsManager(sub),
 	}
 	mockQueue := queue.NewMockStore(ctrl)
-	srv.queueStore[subscriber] = mockQueue
+	srv.registry.shard(subscriber).queueStore[subscriber] = mockQueue
 	return &testDeliverMsg{
 		srv: srv,
 	}
@@ -55,7 +62,7 @@ func TestServer_deliverMessage(t *testing.T) {
 		QoS:         2,
 	})
 
-	mockQueue := srv.queueStore[subscriber].(*queue.MockStore)
+	mockQueue := srv.registry.shard(subscriber).queueStore[subscriber].(*queue.MockStore)
 	// test only once
 	srv.config.MQTT.DeliveryMode = OnlyOnce
 	mockQueue.EXPECT().Add(gomock.Any()).Do(func(elem *queue.Elem) {
@@ -88,6 +95,29 @@ func TestServer_deliverMessage(t *testing.T) {
 
 }
 
+func TestPublishService_PublishTo(t *testing.T) {
+	a := assert.New(t)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	ts := newTestDeliverMsg(ctrl, "cid1")
+	srv := ts.srv
+	other := queue.NewMockStore(ctrl)
+	srv.registry.shard("cid2").queueStore["cid2"] = other
+	for _, v := range []string{"cid1", "cid2"} {
+		srv.subscriptionsDB.Subscribe(v, &gmqtt.Subscription{
+			TopicFilter: "a/#",
+			QoS:         1,
+		})
+	}
+	mockQueue := srv.registry.shard("cid1").queueStore["cid1"].(*queue.MockStore)
+	mockQueue.EXPECT().Add(gomock.Any()).Do(func(elem *queue.Elem) {
+		a.Equal("a/b", elem.MessageWithID.(*queue.Publish).Topic)
+	})
+	p := &publishService{server: srv}
+	p.PublishTo("cid1", &gmqtt.Message{Topic: "a/b"})
+	p.PublishTo("cid1", &gmqtt.Message{Topic: "b"})
+}
+
 func TestServer_deliverMessage_sharedSubscription(t *testing.T) {
 	a := assert.New(t)
 	ctrl := gomock.NewController(t)
@@ -118,7 +148,7 @@ func TestServer_deliverMessage_sharedSubscription(t *testing.T) {
 		QoS:         1,
 	})
 
-	mockQueue := srv.queueStore[subscriber].(*queue.MockStore)
+	mockQueue := srv.registry.shard(subscriber).queueStore[subscriber].(*queue.MockStore)
 	// test only once
 	qos := map[byte]int{
 		packets.Qos1: 0,
@@ -152,3 +182,340 @@ func TestServer_deliverMessage_sharedSubscription(t *testing.T) {
 	a.Equal(2, qos[packets.Qos2])
 
 }
+
+func TestServer_deliverMessage_sharedPayload(t *testing.T) {
+	a := assert.New(t)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	ts := newTestDeliverMsg(ctrl, "sub1")
+	srv := ts.srv
+	srv.registry.shard("sub2").queueStore["sub2"] = queue.NewMockStore(ctrl)
+	msg := &gmqtt.Message{
+		Topic:   "/abc",
+		Payload: make([]byte, 1024),
+		QoS:     1,
+	}
+	var received []*gmqtt.Message
+	for i, cid := range []string{"sub1", "sub2"} {
+		srv.subscriptionsDB.Subscribe(cid, &gmqtt.Subscription{
+			TopicFilter: "/abc",
+			QoS:         1,
+			ID:          uint32(i + 1),
+		})
+		mockQueue := srv.registry.shard(cid).queueStore[cid].(*queue.MockStore)
+		mockQueue.EXPECT().Add(gomock.Any()).Do(func(elem *queue.Elem) {
+			received = append(received, elem.MessageWithID.(*queue.Publish).Message)
+		})
+	}
+	a.True(srv.deliverMessage("srcCli", msg, defaultIterateOptions(msg.Topic)))
+	a.Len(received, 2)
+	a.True(&received[0].Payload[0] == &msg.Payload[0])
+	a.True(&received[1].Payload[0] == &msg.Payload[0])
+	a.Len(received[0].SubscriptionIdentifier, 1)
+	a.Len(received[1].SubscriptionIdentifier, 1)
+	a.NotEqual(received[0].SubscriptionIdentifier[0], received[1].SubscriptionIdentifier[0])
+}
+
+func TestServer_deliverMessage_noSubscriber(t *testing.T) {
+	a := assert.New(t)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	srv := newTestDeliverMsg(ctrl, "subCli").srv
+	var dropped []error
+	srv.hooks.OnMsgDropped = func(ctx context.Context, clientID string, msg *gmqtt.Message, err error) {
+		a.Equal("srcCli", clientID)
+		a.Equal("alarm/a", msg.Topic)
+		dropped = append(dropped, err)
+	}
+	msg := &gmqtt.Message{Topic: "alarm/a", QoS: 1}
+	// not reported if the topic is not configured.
+	a.False(srv.deliverMessage("srcCli", msg, defaultIterateOptions(msg.Topic)))
+	a.Len(dropped, 0)
+
+	srv.config.MQTT.ReportNoSubscriberTopics = []string{"alarm/#"}
+	a.False(srv.deliverMessage("srcCli", msg, defaultIterateOptions(msg.Topic)))
+	a.Equal([]error{queue.ErrDropNoSubscriber}, dropped)
+	a.Equal(DropReasonNoSubscriber, DropReasonOf(dropped[0]))
+	a.EqualValues(1, srv.statsManager.GetGlobalStats().MessageStats.Qos1.DroppedTotal.NoSubscriber)
+	cs, ok := srv.statsManager.GetClientStats("srcCli")
+	a.True(ok)
+	a.EqualValues(1, cs.MessageStats.Qos1.DroppedTotal.NoSubscriber)
+}
+
+func TestServer_addMsgToQueue_qos0NotQueued(t *testing.T) {
+	a := assert.New(t)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	subscriber := "subCli"
+	srv := newTestDeliverMsg(ctrl, subscriber).srv
+	srv.config.MQTT.QueueQos0Msg = false
+	var reason DropReason
+	srv.hooks.OnMsgDropped = func(ctx context.Context, clientID string, msg *gmqtt.Message, err error) {
+		a.Equal(subscriber, clientID)
+		reason = DropReasonOf(err)
+	}
+	srv.addMsgToQueue(time.Now(), subscriber, &gmqtt.Message{Topic: "a", QoS: 0}, &gmqtt.Subscription{QoS: 1}, nil)
+	a.Equal(DropReasonQos0NotQueued, reason)
+	a.EqualValues(1, srv.statsManager.GetGlobalStats().MessageStats.Qos0.DroppedTotal.Qos0NotQueued)
+}
+
+func TestDropReasonOf(t *testing.T) {
+	a := assert.New(t)
+	a.Equal(DropReasonQueueFull, DropReasonOf(queue.ErrDropQueueFull))
+	a.Equal(DropReasonExpired, DropReasonOf(queue.ErrDropExpired))
+	a.Equal(DropReasonInflightExpired, DropReasonOf(queue.ErrDropExpiredInflight))
+	a.Equal(DropReasonExceedsMaxSize, DropReasonOf(queue.ErrDropExceedsMaxPacketSize))
+	a.Equal(DropReasonOverloaded, DropReasonOf(queue.ErrDropOverloaded))
+	a.Equal(DropReasonInternal, DropReasonOf(&queue.InternalError{Err: queue.ErrClosed}))
+}
+
+func TestServer_sendWill(t *testing.T) {
+	a := assert.New(t)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	subscriber := "subCli"
+	srv := newTestDeliverMsg(ctrl, subscriber).srv
+	srv.subscriptionsDB.Subscribe(subscriber, &gmqtt.Subscription{
+		TopicFilter: "offline/+",
+		QoS:         1,
+	})
+	mockQueue := srv.registry.shard(subscriber).queueStore[subscriber].(*queue.MockStore)
+
+	// the topic, payload and properties are modified by the hook.
+	srv.hooks.OnWillPublish = func(ctx context.Context, clientID string, req *WillMsgRequest) {
+		a.Equal("will/a", req.IterationOptions.TopicName)
+		req.Message = &gmqtt.Message{
+			Topic:          "offline/" + clientID,
+			Payload:        []byte("modified"),
+			QoS:            req.Message.QoS,
+			UserProperties: []packets.UserProperty{{K: []byte("k"), V: []byte("v")}},
+		}
+	}
+	mockQueue.EXPECT().Add(gomock.Any()).Do(func(elem *queue.Elem) {
+		msg := elem.MessageWithID.(*queue.Publish).Message
+		a.Equal("offline/cli", msg.Topic)
+		a.Equal([]byte("modified"), msg.Payload)
+		a.Len(msg.UserProperties, 1)
+	})
+	srv.sendWill(&gmqtt.Message{Topic: "will/a", Payload: []byte("will"), QoS: 1}, "cli")
+
+	// suppressed by the hook.
+	srv.hooks.OnWillPublish = func(ctx context.Context, clientID string, req *WillMsgRequest) {
+		req.Drop()
+	}
+	srv.sendWill(&gmqtt.Message{Topic: "offline/a", QoS: 1}, "cli")
+
+	// dropped because of the invalid topic name.
+	srv.hooks.OnWillPublish = func(ctx context.Context, clientID string, req *WillMsgRequest) {
+		req.Message.Topic = "offline/#"
+	}
+	srv.sendWill(&gmqtt.Message{Topic: "offline/a", QoS: 1}, "cli")
+
+	// the iteration options set by the hook take effect.
+	srv.hooks.OnWillPublish = func(ctx context.Context, clientID string, req *WillMsgRequest) {
+		req.IterationOptions.ClientID = "not_exist"
+	}
+	srv.sendWill(&gmqtt.Message{Topic: "offline/a", QoS: 1}, "cli")
+}
+
+func TestServer_willDelay(t *testing.T) {
+	a := assert.New(t)
+	tick := 10 * time.Millisecond
+	srv := &server{
+		registry:     newRegistry(),
+		config:       config.DefaultConfig(),
+		sessionStore: sessmem.New(),
+		timerWheel:   timingwheel.New(tick, 16),
+	}
+	defer srv.timerWheel.Stop()
+	sent := make(chan string, 1)
+	srv.hooks.OnWillPublish = func(ctx context.Context, clientID string, req *WillMsgRequest) {
+		sent <- clientID
+		req.Drop()
+	}
+	s := srv.registry.shard("cli")
+	disconnect := func() *willMsg {
+		a.NoError(srv.sessionStore.Set(&gmqtt.Session{
+			ClientID:          "cli",
+			Will:              &gmqtt.Message{Topic: "will", QoS: packets.Qos1},
+			WillDelayInterval: 1,
+			ExpiryInterval:    10,
+		}))
+		srv.unregisterClient(&client{server: srv, opts: &ClientOptions{ClientID: "cli"}, version: packets.Version5})
+		s.rlock()
+		defer s.runlock()
+		return s.willMessage["cli"]
+	}
+
+	// the will message is sent by the timer wheel after the will delay interval.
+	start := time.Now()
+	w := disconnect()
+	a.NotNil(w)
+	select {
+	case clientID := <-sent:
+		a.Equal("cli", clientID)
+		// the timer wheel is accurate to one tick.
+		a.True(time.Since(start) >= time.Second-tick)
+	case <-time.After(3 * time.Second):
+		t.Fatal("the will message is not sent")
+	}
+	a.False(w.timer.Stop())
+	a.Eventually(func() bool {
+		s.rlock()
+		defer s.runlock()
+		return s.willMessage["cli"] == nil
+	}, time.Second, 10*time.Millisecond)
+
+	// the will message is discarded if the session is resumed.
+	w = disconnect()
+	s.lock()
+	w.signal(false)
+	s.unlock()
+	a.False(w.timer.Stop())
+	a.Eventually(func() bool {
+		s.rlock()
+		defer s.runlock()
+		return s.willMessage["cli"] == nil
+	}, time.Second, 10*time.Millisecond)
+	select {
+	case <-sent:
+		t.Fatal("the will message is sent")
+	case <-time.After(1500 * time.Millisecond):
+	}
+
+	// the will message is sent at once if the session is ended.
+	w = disconnect()
+	s.lock()
+	w.signal(true)
+	s.unlock()
+	select {
+	case <-sent:
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("the will message is not sent")
+	}
+}
+
+func TestServer_sessionExpireCheck(t *testing.T) {
+	a := assert.New(t)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	cid := "cli"
+	srv := newTestDeliverMsg(ctrl, cid).srv
+	srv.sessionStore = sessmem.New()
+	a.Nil(srv.sessionStore.Set(&gmqtt.Session{ClientID: cid, ExpiryInterval: 1}))
+	s := srv.registry.shard(cid)
+	s.offlineClients[cid] = time.Now().Add(-time.Second)
+	s.offlineClients["not_expired"] = time.Now().Add(time.Hour)
+	s.queueStore[cid].(*queue.MockStore).EXPECT().Clean()
+
+	var events []string
+	srv.hooks.OnSessionExpired = func(ctx context.Context, clientID string, session *gmqtt.Session) {
+		a.Equal(cid, clientID)
+		a.Equal(cid, session.ClientID)
+		events = append(events, "expired")
+	}
+	srv.hooks.OnSessionTerminated = func(ctx context.Context, clientID string, reason SessionTerminatedReason) {
+		a.Equal(cid, clientID)
+		a.Equal(ExpiredTermination, reason)
+		events = append(events, "terminated")
+	}
+	srv.sessionExpireCheck()
+	a.Equal([]string{"expired", "terminated"}, events)
+	a.Len(s.offlineClients, 1)
+}
+
+func TestServer_offlineBacklog(t *testing.T) {
+	a := assert.New(t)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	cid := "cli"
+	srv := newTestDeliverMsg(ctrl, cid).srv
+	srv.sessionStore = sessmem.New()
+	srv.config.MQTT.OfflineBacklog.Threshold = 2
+	_, err := srv.subscriptionsDB.Subscribe(cid, &gmqtt.Subscription{TopicFilter: "a", QoS: 1})
+	a.NoError(err)
+	s := srv.registry.shard(cid)
+	s.queueStore[cid].(*queue.MockStore).EXPECT().Add(gomock.Any()).Times(3)
+
+	var alerts []int
+	srv.hooks.OnOfflineBacklog = func(ctx context.Context, clientID string, queued int) {
+		a.Equal(cid, clientID)
+		alerts = append(alerts, queued)
+	}
+	for i := 0; i < 3; i++ {
+		msg := &gmqtt.Message{Topic: "a", QoS: 1}
+		a.True(srv.deliverMessage("", msg, defaultIterateOptions(msg.Topic)))
+	}
+	// called once per disconnection.
+	a.Equal([]int{2}, alerts)
+	a.EqualValues(3, srv.statsManager.GetGlobalStats().MessageStats.OfflineQueuedTotal)
+	sts, _ := srv.statsManager.GetClientStats(cid)
+	a.EqualValues(3, sts.MessageStats.OfflineQueuedTotal)
+
+	s.queueStore[cid].(*queue.MockStore).EXPECT().Clean()
+	a.NoError(srv.removeSessionLocked(s, cid))
+	a.Len(s.offlineQueued, 0)
+}
+
+func TestServer_WebSocketHandler(t *testing.T) {
+	a := assert.New(t)
+	srv := defaultServer()
+	mux := http.NewServeMux()
+	mux.Handle("/mqtt", srv.WebSocketHandler(WsHandlerOptions{}))
+
+	get := func() int {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/mqtt", nil))
+		return rec.Code
+	}
+	// not started
+	a.Equal(http.StatusServiceUnavailable, get())
+	// started, the request reaches the upgrader which rejects the non-websocket request.
+	atomic.StoreInt32(&srv.status, serverStatusStarted)
+	a.Equal(http.StatusBadRequest, get())
+	// stopped
+	srv.exit()
+	a.Equal(http.StatusServiceUnavailable, get())
+}
+
+func TestServer_addMsgToQueue_messageExpiry(t *testing.T) {
+	a := assert.New(t)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	subscriber := "subCli"
+	srv := newTestDeliverMsg(ctrl, subscriber).srv
+	srv.config.MQTT.MessageExpiry = time.Hour
+	srv.config.MQTT.TopicMessageExpiry = []config.TopicMessageExpiry{
+		{TopicFilter: "telemetry/#", Expiry: time.Minute},
+		{TopicFilter: "cmd/#", Expiry: 0},
+		{TopicFilter: "#", Expiry: 2 * time.Hour},
+	}
+	mockQueue := srv.registry.shard(subscriber).queueStore[subscriber].(*queue.MockStore)
+	now := time.Now()
+	sub := &gmqtt.Subscription{TopicFilter: "#", QoS: 1}
+	var tt = []struct {
+		msg    *gmqtt.Message
+		expiry time.Time
+	}{
+		// the default of the topic
+		{msg: &gmqtt.Message{Topic: "telemetry/a", QoS: 1}, expiry: now.Add(time.Minute)},
+		// set by the publisher
+		{msg: &gmqtt.Message{Topic: "telemetry/a", QoS: 1, MessageExpiry: 10}, expiry: now.Add(10 * time.Second)},
+		// no default, limited by the message_expiry
+		{msg: &gmqtt.Message{Topic: "cmd/a", QoS: 1}, expiry: now.Add(time.Hour)},
+		{msg: &gmqtt.Message{Topic: "other", QoS: 1}, expiry: now.Add(time.Hour)},
+		{msg: &gmqtt.Message{Topic: "other", QoS: 1, MessageExpiry: 7200}, expiry: now.Add(time.Hour)},
+	}
+	for _, v := range tt {
+		mockQueue.EXPECT().Add(gomock.Any()).Do(func(elem *queue.Elem) {
+			a.Equal(v.expiry, elem.Expiry, v.msg.Topic)
+		})
+		srv.addMsgToQueue(now, subscriber, v.msg, sub, nil)
+	}
+
+	srv.config.MQTT.MessageExpiry = 0
+	mockQueue.EXPECT().Add(gomock.Any()).Do(func(elem *queue.Elem) {
+		a.True(elem.Expiry.IsZero())
+	})
+	srv.addMsgToQueue(now, subscriber, &gmqtt.Message{Topic: "cmd/a", QoS: 1}, sub, nil)
+}
@@ -1,7 +1,10 @@
 package server
 
 import (
+	"time"
+
 	"github.com/DrmagicE/gmqtt"
+	"github.com/DrmagicE/gmqtt/config"
 	"github.com/DrmagicE/gmqtt/persistence/session"
 	"github.com/DrmagicE/gmqtt/persistence/subscription"
 	"github.com/DrmagicE/gmqtt/retained"
@@ -11,7 +14,11 @@ import (
 type Publisher interface {
 	// Publish Publish a message to broker.
 	// Calling this method will not trigger OnMsgArrived hook.
+	// The payload of the message is shared by all subscribers, the caller must not modify it after publishing.
 	Publish(message *gmqtt.Message)
+	// PublishTo publishes a message to the matching subscriptions of the given client only.
+	// Calling this method will not trigger OnMsgArrived hook.
+	PublishTo(clientID string, message *gmqtt.Message)
 }
 
 // ClientIterateFn is the callback function used by ClientService.IterateClient
@@ -25,6 +32,37 @@ type ClientService interface {
 	GetClient(clientID string) Client
 	IterateClient(fn ClientIterateFn)
 	TerminateSession(clientID string)
+	// EnableDebugLogging enables the debug logging, including the packet dump, for the given client id or ip
+	// for the given duration, regardless of the log level. Empty client id or ip is ignored.
+	EnableDebugLogging(clientID, ip string, duration time.Duration)
+	// DisableDebugLogging disables the debug logging for the given client id or ip. Empty client id or ip is ignored.
+	DisableDebugLogging(clientID, ip string)
+	// InspectSession returns the state of the session for the given client id for the operational debugging,
+	// at most maxQueued non-inflight messages are returned. Return nil if the session is not found.
+	InspectSession(clientID string, maxQueued int) (*SessionState, error)
+	// PurgeQueue removes all non-inflight messages from the queue of the session for the given client id,
+	// and returns the number of the removed messages.
+	PurgeQueue(clientID string) (int, error)
+	// ExportSession returns the state of the offline session for the given client id,
+	// which can be imported by ImportSession of another broker, e.g. to migrate the session between brokers.
+	// If remove is true, the session is removed from this broker along with the export.
+	// Return ErrSessionConnected if the client is connected, ErrSessionNotFound if the session is not found.
+	ExportSession(clientID string, remove bool) (*SessionExport, error)
+	// ImportSession creates the offline session from the exported state.
+	// Return ErrSessionExists if the session of the client id already exists.
+	ImportSession(exp *SessionExport) error
+	// SetAttributes replaces the attributes of the session for the given client id,
+	// the changes take effect on the connected client immediately.
+	// Return ErrSessionNotFound if the session is not found.
+	SetAttributes(clientID string, attrs map[string]string) error
+	// Ban rejects the connections of the given client id with 0x8A (Banned) for the given duration,
+	// 0 means until Unban is called. The connected client is not disconnected.
+	// The bans are kept in memory and do not survive restarts.
+	Ban(clientID string, duration time.Duration)
+	// Unban removes the ban of the given client id, and returns whether the client id was banned.
+	Unban(clientID string) bool
+	// ListBans returns the banned client ids and the time when the bans expire, zero time means never expire.
+	ListBans() map[string]time.Time
 }
 
 // SubscriptionService providers the ability to query and add/delete subscriptions.
@@ -52,3 +90,26 @@ type SubscriptionService interface {
 type RetainedService interface {
 	retained.Store
 }
+
+// ListenerService providers the ability to add, update and remove the listeners at runtime.
+// The listeners are identified by the address, changing a listener does not affect the established connections.
+type ListenerService interface {
+	// List returns the config of all running listeners.
+	// The listeners which are not created by NewListenerFromConfig only report the address and the listener options.
+	List() []config.ListenerConfig
+	// Add starts a new listener, ErrListenerExists is returned if the address is in use by another listener.
+	Add(cfg config.ListenerConfig) error
+	// Update replaces the listener of the address with the new config, e.g. to renew the certificate.
+	// If the address is not changed, the old listener is closed before binding the new one,
+	// and it is restored if the new one fails to start.
+	Update(address string, cfg config.ListenerConfig) error
+	// Remove stops the listener of the address, ErrListenerNotFound is returned if it does not exist.
+	Remove(address string) error
+	// Drain stops accepting on the listener of the address, so that the endpoint can be retired without restarting.
+	// The draining listener stays in the list until it is removed, Update restarts it.
+	// If opts.Migrate is set, the connected clients of the listener are disconnected, which can be started by
+	// calling Drain again on the draining listener, ErrListenerMigrating is returned if the migration has started.
+	Drain(address string, opts DrainOptions) error
+	// DrainStatus returns the progress of the draining listener, ErrListenerNotDraining is returned if it is not draining.
+	DrainStatus(address string) (DrainStatus, error)
+}
@@ -6,11 +6,13 @@ package server
 
 import (
 	gmqtt "github.com/DrmagicE/gmqtt"
+	config "github.com/DrmagicE/gmqtt/config"
 	session "github.com/DrmagicE/gmqtt/persistence/session"
 	subscription "github.com/DrmagicE/gmqtt/persistence/subscription"
 	retained "github.com/DrmagicE/gmqtt/retained"
 	gomock "github.com/golang/mock/gomock"
 	reflect "reflect"
+	time "time"
 )
 
 // MockPublisher is a mock of Publisher interface
@@ -48,6 +50,18 @@ func (mr *MockPublisherMockRecorder) Publish(message interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Publish", reflect.TypeOf((*MockPublisher)(nil).Publish), message)
 }
 
+// PublishTo mocks base method
+func (m *MockPublisher) PublishTo(clientID string, message *gmqtt.Message) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "PublishTo", clientID, message)
+}
+
+// PublishTo indicates an expected call of PublishTo
+func (mr *MockPublisherMockRecorder) PublishTo(clientID, message interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PublishTo", reflect.TypeOf((*MockPublisher)(nil).PublishTo), clientID, message)
+}
+
 // MockClientService is a mock of ClientService interface
 type MockClientService struct {
 	ctrl     *gomock.Controller
@@ -138,6 +152,143 @@ func (mr *MockClientServiceMockRecorder) TerminateSession(clientID interface{})
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "TerminateSession", reflect.TypeOf((*MockClientService)(nil).TerminateSession), clientID)
 }
 
+// EnableDebugLogging mocks base method
+func (m *MockClientService) EnableDebugLogging(clientID, ip string, duration time.Duration) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "EnableDebugLogging", clientID, ip, duration)
+}
+
+// EnableDebugLogging indicates an expected call of EnableDebugLogging
+func (mr *MockClientServiceMockRecorder) EnableDebugLogging(clientID, ip, duration interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "EnableDebugLogging", reflect.TypeOf((*MockClientService)(nil).EnableDebugLogging), clientID, ip, duration)
+}
+
+// DisableDebugLogging mocks base method
+func (m *MockClientService) DisableDebugLogging(clientID, ip string) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "DisableDebugLogging", clientID, ip)
+}
+
+// DisableDebugLogging indicates an expected call of DisableDebugLogging
+func (mr *MockClientServiceMockRecorder) DisableDebugLogging(clientID, ip interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DisableDebugLogging", reflect.TypeOf((*MockClientService)(nil).DisableDebugLogging), clientID, ip)
+}
+
+// InspectSession mocks base method
+func (m *MockClientService) InspectSession(clientID string, maxQueued int) (*SessionState, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "InspectSession", clientID, maxQueued)
+	ret0, _ := ret[0].(*SessionState)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// InspectSession indicates an expected call of InspectSession
+func (mr *MockClientServiceMockRecorder) InspectSession(clientID, maxQueued interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "InspectSession", reflect.TypeOf((*MockClientService)(nil).InspectSession), clientID, maxQueued)
+}
+
+// PurgeQueue mocks base method
+func (m *MockClientService) PurgeQueue(clientID string) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "PurgeQueue", clientID)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// PurgeQueue indicates an expected call of PurgeQueue
+func (mr *MockClientServiceMockRecorder) PurgeQueue(clientID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PurgeQueue", reflect.TypeOf((*MockClientService)(nil).PurgeQueue), clientID)
+}
+
+// ExportSession mocks base method
+func (m *MockClientService) ExportSession(clientID string, remove bool) (*SessionExport, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ExportSession", clientID, remove)
+	ret0, _ := ret[0].(*SessionExport)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ExportSession indicates an expected call of ExportSession
+func (mr *MockClientServiceMockRecorder) ExportSession(clientID, remove interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ExportSession", reflect.TypeOf((*MockClientService)(nil).ExportSession), clientID, remove)
+}
+
+// ImportSession mocks base method
+func (m *MockClientService) ImportSession(exp *SessionExport) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ImportSession", exp)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ImportSession indicates an expected call of ImportSession
+func (mr *MockClientServiceMockRecorder) ImportSession(exp interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ImportSession", reflect.TypeOf((*MockClientService)(nil).ImportSession), exp)
+}
+
+// Ban mocks base method
+func (m *MockClientService) Ban(clientID string, duration time.Duration) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "Ban", clientID, duration)
+}
+
+// Ban indicates an expected call of Ban
+func (mr *MockClientServiceMockRecorder) Ban(clientID, duration interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Ban", reflect.TypeOf((*MockClientService)(nil).Ban), clientID, duration)
+}
+
+// Unban mocks base method
+func (m *MockClientService) Unban(clientID string) bool {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Unban", clientID)
+	ret0, _ := ret[0].(bool)
+	return ret0
+}
+
+// Unban indicates an expected call of Unban
+func (mr *MockClientServiceMockRecorder) Unban(clientID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Unban", reflect.TypeOf((*MockClientService)(nil).Unban), clientID)
+}
+
+// ListBans mocks base method
+func (m *MockClientService) ListBans() map[string]time.Time {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListBans")
+	ret0, _ := ret[0].(map[string]time.Time)
+	return ret0
+}
+
+// ListBans indicates an expected call of ListBans
+func (mr *MockClientServiceMockRecorder) ListBans() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListBans", reflect.TypeOf((*MockClientService)(nil).ListBans))
+}
+
+// SetAttributes mocks base method
+func (m *MockClientService) SetAttributes(clientID string, attrs map[string]string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetAttributes", clientID, attrs)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetAttributes indicates an expected call of SetAttributes
+func (mr *MockClientServiceMockRecorder) SetAttributes(clientID, attrs interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetAttributes", reflect.TypeOf((*MockClientService)(nil).SetAttributes), clientID, attrs)
+}
+
 // MockSubscriptionService is a mock of SubscriptionService interface
 type MockSubscriptionService struct {
 	ctrl     *gomock.Controller
@@ -353,3 +504,111 @@ func (mr *MockRetainedServiceMockRecorder) Iterate(fn interface{}) *gomock.Call
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Iterate", reflect.TypeOf((*MockRetainedService)(nil).Iterate), fn)
 }
+
+// MockListenerService is a mock of ListenerService interface
+type MockListenerService struct {
+	ctrl     *gomock.Controller
+	recorder *MockListenerServiceMockRecorder
+}
+
+// MockListenerServiceMockRecorder is the mock recorder for MockListenerService
+type MockListenerServiceMockRecorder struct {
+	mock *MockListenerService
+}
+
+// NewMockListenerService creates a new mock instance
+func NewMockListenerService(ctrl *gomock.Controller) *MockListenerService {
+	mock := &MockListenerService{ctrl: ctrl}
+	mock.recorder = &MockListenerServiceMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use
+func (m *MockListenerService) EXPECT() *MockListenerServiceMockRecorder {
+	return m.recorder
+}
+
+// List mocks base method
+func (m *MockListenerService) List() []config.ListenerConfig {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "List")
+	ret0, _ := ret[0].([]config.ListenerConfig)
+	return ret0
+}
+
+// List indicates an expected call of List
+func (mr *MockListenerServiceMockRecorder) List() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "List", reflect.TypeOf((*MockListenerService)(nil).List))
+}
+
+// Add mocks base method
+func (m *MockListenerService) Add(cfg config.ListenerConfig) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Add", cfg)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Add indicates an expected call of Add
+func (mr *MockListenerServiceMockRecorder) Add(cfg interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Add", reflect.TypeOf((*MockListenerService)(nil).Add), cfg)
+}
+
+// Update mocks base method
+func (m *MockListenerService) Update(address string, cfg config.ListenerConfig) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Update", address, cfg)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Update indicates an expected call of Update
+func (mr *MockListenerServiceMockRecorder) Update(address, cfg interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Update", reflect.TypeOf((*MockListenerService)(nil).Update), address, cfg)
+}
+
+// Remove mocks base method
+func (m *MockListenerService) Remove(address string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Remove", address)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Remove indicates an expected call of Remove
+func (mr *MockListenerServiceMockRecorder) Remove(address interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Remove", reflect.TypeOf((*MockListenerService)(nil).Remove), address)
+}
+
+// Drain mocks base method
+func (m *MockListenerService) Drain(address string, opts DrainOptions) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Drain", address, opts)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Drain indicates an expected call of Drain
+func (mr *MockListenerServiceMockRecorder) Drain(address, opts interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Drain", reflect.TypeOf((*MockListenerService)(nil).Drain), address, opts)
+}
+
+// DrainStatus mocks base method
+func (m *MockListenerService) DrainStatus(address string) (DrainStatus, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DrainStatus", address)
+	ret0, _ := ret[0].(DrainStatus)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// DrainStatus indicates an expected call of DrainStatus
+func (mr *MockListenerServiceMockRecorder) DrainStatus(address interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DrainStatus", reflect.TypeOf((*MockListenerService)(nil).DrainStatus), address)
+}
@@ -0,0 +1,213 @@
+package server
+
+import (
+	"errors"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/DrmagicE/gmqtt"
+	"github.com/DrmagicE/gmqtt/persistence/queue"
+	"github.com/DrmagicE/gmqtt/persistence/subscription"
+	"github.com/DrmagicE/gmqtt/persistence/unack"
+	"github.com/DrmagicE/gmqtt/pkg/packets"
+)
+
+var (
+	// ErrSessionConnected is returned if the session to export is in use by a connected client.
+	ErrSessionConnected = errors.New("the session is in use by a connected client")
+	// ErrSessionExists is returned if the session to import already exists.
+	ErrSessionExists = errors.New("session already exists")
+)
+
+// SessionExportVersion is the version of the SessionExport format.
+const SessionExportVersion = 1
+
+// SessionExport is the serializable state of an offline session, which is exported by ClientService.ExportSession
+// and can be imported by ClientService.ImportSession of another broker.
+type SessionExport struct {
+	// Version is the version of the format, see SessionExportVersion.
+	Version int
+	// ExportedAt is the time when the session is exported.
+	ExportedAt time.Time
+	Session    *gmqtt.Session
+	// Subscriptions is the subscriptions of the session, including the shared subscriptions.
+	Subscriptions []*gmqtt.Subscription
+	// Queue is the elements of the message queue in order, the inflight ones come first.
+	Queue []*ExportedElem
+	// AwaitingRel is the packet ids of the inbound QoS 2 messages which are waiting for the PUBREL.
+	AwaitingRel []packets.PacketID
+}
+
+// ExportedElem is an element of the exported message queue.
+type ExportedElem struct {
+	// At is the time when the element entered the queue.
+	At time.Time
+	// Expiry is the expiry time of the element, zero time means never expire.
+	Expiry time.Time
+	// Message is the queued message, Message.PacketID is not 0 if it is inflight.
+	// It is nil if the element is the PUBREL which is waiting for the PUBCOMP.
+	Message *gmqtt.Message
+	// PubrelID is the packet id of the PUBREL which is waiting for the PUBCOMP.
+	PubrelID packets.PacketID
+}
+
+func exportElem(e *queue.Elem) *ExportedElem {
+	c := copyElem(e)
+	rs := &ExportedElem{
+		At:     c.At,
+		Expiry: c.Expiry,
+	}
+	switch m := c.MessageWithID.(type) {
+	case *queue.Publish:
+		rs.Message = m.Message
+	case *queue.Pubrel:
+		rs.PubrelID = m.PacketID
+	}
+	return rs
+}
+
+func (e *ExportedElem) elem() *queue.Elem {
+	rs := &queue.Elem{
+		At:     e.At,
+		Expiry: e.Expiry,
+	}
+	if e.Message != nil {
+		rs.MessageWithID = &queue.Publish{Message: e.Message}
+	} else {
+		rs.MessageWithID = &queue.Pubrel{PacketID: e.PubrelID}
+	}
+	return rs
+}
+
+// ExportSession returns the state of the offline session for the given client id.
+func (c *clientService) ExportSession(clientID string, remove bool) (*SessionExport, error) {
+	s := c.srv.registry.shard(clientID)
+	s.lock()
+	defer s.unlock()
+	if _, ok := s.clients[clientID]; ok {
+		return nil, ErrSessionConnected
+	}
+	if _, ok := s.offlineClients[clientID]; !ok {
+		return nil, ErrSessionNotFound
+	}
+	sess, err := c.sessionStore.Get(clientID)
+	if err != nil {
+		return nil, err
+	}
+	// the redis store returns an empty session if not found.
+	if sess == nil || sess.ClientID == "" {
+		return nil, ErrSessionNotFound
+	}
+	exp := &SessionExport{
+		Version:    SessionExportVersion,
+		ExportedAt: time.Now(),
+		Session:    sess,
+	}
+	if us := s.unackStore[clientID]; us != nil {
+		ui, ok := us.(unack.Inspector)
+		if !ok {
+			return nil, ErrInspectionNotSupported
+		}
+		if exp.AwaitingRel, err = ui.PacketIDs(); err != nil {
+			return nil, err
+		}
+	}
+	if qs := s.queueStore[clientID]; qs != nil {
+		qi, ok := qs.(queue.Inspector)
+		if !ok {
+			return nil, ErrInspectionNotSupported
+		}
+		err = qi.Inspect(func(elem *queue.Elem) bool {
+			exp.Queue = append(exp.Queue, exportElem(elem))
+			return true
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	c.srv.subscriptionsDB.Iterate(func(clientID string, sub *gmqtt.Subscription) bool {
+		exp.Subscriptions = append(exp.Subscriptions, sub.Copy())
+		return true
+	}, subscription.IterationOptions{
+		Type:     subscription.TypeAll,
+		ClientID: clientID,
+	})
+	if remove {
+		if err = c.srv.sessionTerminatedLocked(s, clientID, NormalTermination); err != nil {
+			return nil, err
+		}
+	}
+	zaplog.Info("session exported",
+		zap.String("client_id", clientID),
+		zap.Int("subscriptions", len(exp.Subscriptions)),
+		zap.Int("queued", len(exp.Queue)),
+		zap.Bool("removed", remove))
+	return exp, nil
+}
+
+// ImportSession creates the offline session from the exported state.
+func (c *clientService) ImportSession(exp *SessionExport) (err error) {
+	if exp.Version != SessionExportVersion {
+		return errors.New("unsupported session export version")
+	}
+	if exp.Session == nil || exp.Session.ClientID == "" {
+		return errors.New("invalid session export: missing session")
+	}
+	srv := c.srv
+	clientID := exp.Session.ClientID
+	s := srv.registry.shard(clientID)
+	s.lock()
+	defer s.unlock()
+	if _, ok := s.clients[clientID]; ok {
+		return ErrSessionExists
+	}
+	if _, ok := s.offlineClients[clientID]; ok {
+		return ErrSessionExists
+	}
+	notifier := defaultNotifier(srv.hooks.OnMsgDropped, srv.statsManager, clientID)
+	qs, err := srv.persistence.NewQueueStore(srv.config, notifier, clientID)
+	if err != nil {
+		return err
+	}
+	ua, err := srv.persistence.NewUnackStore(srv.config, clientID)
+	if err != nil {
+		return err
+	}
+	s.queueStore[clientID] = qs
+	s.unackStore[clientID] = ua
+	s.offlineClients[clientID] = time.Now().Add(time.Duration(exp.Session.ExpiryInterval) * time.Second)
+	defer func() {
+		// roll back the partially imported session.
+		if err != nil {
+			_ = srv.removeSessionLocked(s, clientID)
+		}
+	}()
+	if err = srv.sessionStore.Set(exp.Session); err != nil {
+		return err
+	}
+	for _, v := range exp.AwaitingRel {
+		if _, err = ua.Set(v); err != nil {
+			return err
+		}
+	}
+	for _, v := range exp.Queue {
+		e := v.elem()
+		if err = qs.Add(e); err != nil {
+			return err
+		}
+		if e.ID() != 0 {
+			notifier.NotifyInflightAdded(1)
+		}
+	}
+	if len(exp.Subscriptions) != 0 {
+		if _, err = srv.subscriptionsDB.Subscribe(clientID, exp.Subscriptions...); err != nil {
+			return err
+		}
+	}
+	zaplog.Info("session imported",
+		zap.String("client_id", clientID),
+		zap.Int("subscriptions", len(exp.Subscriptions)),
+		zap.Int("queued", len(exp.Queue)))
+	return nil
+}
@@ -0,0 +1,147 @@
+package server
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DrmagicE/gmqtt"
+	"github.com/DrmagicE/gmqtt/persistence/queue"
+	sessmem "github.com/DrmagicE/gmqtt/persistence/session/mem"
+	"github.com/DrmagicE/gmqtt/persistence/subscription"
+	unackmem "github.com/DrmagicE/gmqtt/persistence/unack/mem"
+	"github.com/DrmagicE/gmqtt/pkg/packets"
+)
+
+// exportableQueue is an inspectableQueue which supports Add and Clean.
+type exportableQueue struct {
+	inspectableQueue
+}
+
+func (q *exportableQueue) Add(elem *queue.Elem) error {
+	q.elems = append(q.elems, elem)
+	return nil
+}
+
+func (q *exportableQueue) Clean() error {
+	q.elems = nil
+	return nil
+}
+
+func TestClientService_ExportImportSession(t *testing.T) {
+	a := assert.New(t)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	cid := "cli"
+	srv := newTestDeliverMsg(ctrl, cid).srv
+	srv.sessionStore = sessmem.New()
+	c := &clientService{srv: srv, sessionStore: srv.sessionStore}
+
+	_, err := c.ExportSession(cid, false)
+	a.Equal(ErrSessionNotFound, err)
+
+	now := time.Unix(time.Now().Unix(), 0)
+	sess := &gmqtt.Session{
+		ClientID:       cid,
+		Will:           &gmqtt.Message{Topic: "will", Payload: []byte("bye")},
+		ConnectedAt:    now,
+		ExpiryInterval: 100,
+		Attributes:     map[string]string{"tenant": "acme"},
+	}
+	a.NoError(srv.sessionStore.Set(sess))
+	s := srv.registry.shard(cid)
+	s.offlineClients[cid] = now.Add(100 * time.Second)
+	us := unackmem.New(unackmem.Options{ClientID: cid})
+	us.Set(1)
+	s.unackStore[cid] = us
+	elems := []*queue.Elem{
+		{At: now, MessageWithID: &queue.Publish{Message: &gmqtt.Message{Topic: "a", QoS: 1, PacketID: 1, Payload: []byte("a")}}},
+		{At: now, MessageWithID: &queue.Pubrel{PacketID: 2}},
+		{At: now, Expiry: now.Add(time.Minute), MessageWithID: &queue.Publish{Message: &gmqtt.Message{Topic: "b", QoS: 0, Payload: []byte("b")}}},
+	}
+	s.queueStore[cid] = &exportableQueue{inspectableQueue{elems: elems}}
+	subs := []*gmqtt.Subscription{
+		{TopicFilter: "a", QoS: 1},
+		{ShareName: "g", TopicFilter: "b", QoS: 2, NoLocal: true},
+	}
+	_, err = srv.subscriptionsDB.Subscribe(cid, subs...)
+	a.NoError(err)
+
+	s.clients[cid] = &client{}
+	_, err = c.ExportSession(cid, false)
+	a.Equal(ErrSessionConnected, err)
+	delete(s.clients, cid)
+
+	exp, err := c.ExportSession(cid, false)
+	a.NoError(err)
+	a.Equal(SessionExportVersion, exp.Version)
+	a.Equal(sess, exp.Session)
+	a.Equal([]packets.PacketID{1}, exp.AwaitingRel)
+	a.ElementsMatch(subs, exp.Subscriptions)
+	a.Len(exp.Queue, 3)
+	a.EqualValues(2, exp.Queue[1].PubrelID)
+	a.Nil(exp.Queue[1].Message)
+
+	// the exported session is serializable.
+	b, err := json.Marshal(exp)
+	a.NoError(err)
+	imported := &SessionExport{}
+	a.NoError(json.Unmarshal(b, imported))
+
+	exp, err = c.ExportSession(cid, true)
+	a.NoError(err)
+	a.NotNil(exp)
+	rs, err := srv.sessionStore.Get(cid)
+	a.NoError(err)
+	a.Nil(rs)
+	a.NotContains(s.offlineClients, cid)
+	var n int
+	srv.subscriptionsDB.Iterate(func(clientID string, sub *gmqtt.Subscription) bool {
+		n++
+		return true
+	}, subscription.IterationOptions{Type: subscription.TypeAll, ClientID: cid})
+	a.Zero(n)
+
+	// import the session into another broker.
+	dst := newTestDeliverMsg(ctrl, "other").srv
+	dst.sessionStore = sessmem.New()
+	p := NewMockPersistence(ctrl)
+	dst.persistence = p
+	q := &exportableQueue{}
+	ua := unackmem.New(unackmem.Options{ClientID: cid})
+	p.EXPECT().NewQueueStore(gomock.Any(), gomock.Any(), cid).Return(q, nil)
+	p.EXPECT().NewUnackStore(gomock.Any(), cid).Return(ua, nil)
+	dc := &clientService{srv: dst, sessionStore: dst.sessionStore}
+	a.NoError(dc.ImportSession(imported))
+
+	rs, err = dst.sessionStore.Get(cid)
+	a.NoError(err)
+	a.Equal(sess.Will, rs.Will)
+	a.Equal(sess.Attributes, rs.Attributes)
+	a.True(sess.ConnectedAt.Equal(rs.ConnectedAt))
+	ds := dst.registry.shard(cid)
+	a.Contains(ds.offlineClients, cid)
+	a.Equal(q, ds.queueStore[cid])
+	ids, err := ua.PacketIDs()
+	a.NoError(err)
+	a.Equal([]packets.PacketID{1}, ids)
+	a.Len(q.elems, 3)
+	for k, v := range q.elems {
+		a.True(elems[k].At.Equal(v.At))
+		a.True(elems[k].Expiry.Equal(v.Expiry))
+		a.Equal(elems[k].MessageWithID, v.MessageWithID)
+	}
+	var got []*gmqtt.Subscription
+	dst.subscriptionsDB.Iterate(func(clientID string, sub *gmqtt.Subscription) bool {
+		got = append(got, sub)
+		return true
+	}, subscription.IterationOptions{Type: subscription.TypeAll, ClientID: cid})
+	a.ElementsMatch(subs, got)
+
+	a.Equal(ErrSessionExists, dc.ImportSession(imported))
+	imported.Version = 0
+	a.Error(dc.ImportSession(imported))
+}
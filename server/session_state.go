@@ -0,0 +1,125 @@
+package server
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/DrmagicE/gmqtt"
+	"github.com/DrmagicE/gmqtt/persistence/queue"
+	"github.com/DrmagicE/gmqtt/persistence/unack"
+	"github.com/DrmagicE/gmqtt/pkg/packets"
+)
+
+// ErrInspectionNotSupported is returned if the persistence backend does not support the session inspection.
+var ErrInspectionNotSupported = errors.New("the persistence backend does not support the session inspection")
+
+// SessionState is the state of a session for the operational debugging.
+type SessionState struct {
+	Session *gmqtt.Session
+	// Connected indicates whether the client is connected.
+	Connected bool
+	// PendingWill is the will message which is waiting for the will delay interval to elapse, nil if there is none.
+	PendingWill *gmqtt.Message
+	// PendingWillAt is the time to send the PendingWill.
+	PendingWillAt time.Time
+	// AwaitingRel is the packet ids of the inbound QoS 2 messages which are waiting for the PUBREL.
+	AwaitingRel []packets.PacketID
+	// Inflight is the outbound messages which are waiting for the acknowledgement,
+	// including the PUBREL of the QoS 2 messages which are waiting for the PUBCOMP.
+	Inflight []*queue.Elem
+	// Queued is the messages which are waiting to be sent, limited by the maxQueued argument of InspectSession.
+	Queued []*queue.Elem
+	// QueuedTotal is the total number of the messages which are waiting to be sent.
+	QueuedTotal int
+}
+
+// copyElem copies the elem, because the elem passed to queue.Inspector.Inspect is only valid in the callback.
+func copyElem(e *queue.Elem) *queue.Elem {
+	c := *e
+	switch m := e.MessageWithID.(type) {
+	case *queue.Publish:
+		c.MessageWithID = &queue.Publish{Message: m.Message.Copy()}
+	case *queue.Pubrel:
+		c.MessageWithID = &queue.Pubrel{PacketID: m.PacketID}
+	}
+	return &c
+}
+
+// InspectSession returns the state of the session for the given client id.
+func (c *clientService) InspectSession(clientID string, maxQueued int) (*SessionState, error) {
+	sess, err := c.sessionStore.Get(clientID)
+	if err != nil || sess == nil {
+		return nil, err
+	}
+	state := &SessionState{
+		Session: sess,
+	}
+	s := c.srv.registry.shard(clientID)
+	s.rlock()
+	_, state.Connected = s.clients[clientID]
+	if w, ok := s.willMessage[clientID]; ok {
+		state.PendingWill = w.msg.Copy()
+		state.PendingWillAt = w.sendAt
+	}
+	qs := s.queueStore[clientID]
+	us := s.unackStore[clientID]
+	s.runlock()
+
+	if us != nil {
+		ui, ok := us.(unack.Inspector)
+		if !ok {
+			return nil, ErrInspectionNotSupported
+		}
+		if state.AwaitingRel, err = ui.PacketIDs(); err != nil {
+			return nil, err
+		}
+		sort.Slice(state.AwaitingRel, func(i, j int) bool {
+			return state.AwaitingRel[i] < state.AwaitingRel[j]
+		})
+	}
+	if qs != nil {
+		qi, ok := qs.(queue.Inspector)
+		if !ok {
+			return nil, ErrInspectionNotSupported
+		}
+		err = qi.Inspect(func(elem *queue.Elem) bool {
+			if elem.ID() != 0 {
+				state.Inflight = append(state.Inflight, copyElem(elem))
+				return true
+			}
+			if len(state.Queued) < maxQueued {
+				state.Queued = append(state.Queued, copyElem(elem))
+			}
+			state.QueuedTotal++
+			return true
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return state, nil
+}
+
+// PurgeQueue removes all non-inflight messages from the queue of the given client id.
+func (c *clientService) PurgeQueue(clientID string) (int, error) {
+	s := c.srv.registry.shard(clientID)
+	s.rlock()
+	qs := s.queueStore[clientID]
+	s.runlock()
+	if qs == nil {
+		return 0, nil
+	}
+	qi, ok := qs.(queue.Inspector)
+	if !ok {
+		return 0, ErrInspectionNotSupported
+	}
+	n, err := qi.Purge()
+	if err != nil {
+		return 0, err
+	}
+	zaplog.Info("message queue purged", zap.String("client_id", clientID), zap.Int("purged", n))
+	return n, nil
+}
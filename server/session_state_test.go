@@ -0,0 +1,105 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DrmagicE/gmqtt"
+	"github.com/DrmagicE/gmqtt/persistence/queue"
+	sessmem "github.com/DrmagicE/gmqtt/persistence/session/mem"
+	unackmem "github.com/DrmagicE/gmqtt/persistence/unack/mem"
+	"github.com/DrmagicE/gmqtt/pkg/packets"
+)
+
+// inspectableQueue is a queue.Store which implements queue.Inspector.
+type inspectableQueue struct {
+	queue.Store
+	elems []*queue.Elem
+}
+
+func (q *inspectableQueue) Inspect(fn func(elem *queue.Elem) bool) error {
+	for _, v := range q.elems {
+		if !fn(v) {
+			break
+		}
+	}
+	return nil
+}
+
+func (q *inspectableQueue) Purge() (int, error) {
+	var inflight []*queue.Elem
+	for _, v := range q.elems {
+		if v.ID() != 0 {
+			inflight = append(inflight, v)
+		}
+	}
+	n := len(q.elems) - len(inflight)
+	q.elems = inflight
+	return n, nil
+}
+
+func TestClientService_InspectSession(t *testing.T) {
+	a := assert.New(t)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	cid := "cli"
+	srv := newTestDeliverMsg(ctrl, cid).srv
+	srv.sessionStore = sessmem.New()
+	c := &clientService{srv: srv, sessionStore: srv.sessionStore}
+
+	state, err := c.InspectSession(cid, 10)
+	a.NoError(err)
+	a.Nil(state)
+
+	will := &gmqtt.Message{Topic: "will", Payload: []byte("bye")}
+	a.NoError(srv.sessionStore.Set(&gmqtt.Session{ClientID: cid, Will: will, WillDelayInterval: 10, ExpiryInterval: 100}))
+	s := srv.registry.shard(cid)
+	sendAt := time.Now().Add(10 * time.Second)
+	s.willMessage[cid] = &willMsg{msg: will, sendAt: sendAt}
+	us := unackmem.New(unackmem.Options{ClientID: cid})
+	us.Set(2)
+	us.Set(1)
+	s.unackStore[cid] = us
+	q := &inspectableQueue{
+		elems: []*queue.Elem{
+			{MessageWithID: &queue.Publish{Message: &gmqtt.Message{Topic: "a", QoS: 1, PacketID: 1}}},
+			{MessageWithID: &queue.Pubrel{PacketID: 2}},
+			{MessageWithID: &queue.Publish{Message: &gmqtt.Message{Topic: "b", QoS: 1}}},
+			{MessageWithID: &queue.Publish{Message: &gmqtt.Message{Topic: "c", QoS: 0}}},
+		},
+	}
+	s.queueStore[cid] = q
+
+	state, err = c.InspectSession(cid, 1)
+	a.NoError(err)
+	a.False(state.Connected)
+	a.Equal(will, state.Session.Will)
+	a.Equal(will, state.PendingWill)
+	a.Equal(sendAt, state.PendingWillAt)
+	a.Equal([]packets.PacketID{1, 2}, state.AwaitingRel)
+	a.Len(state.Inflight, 2)
+	a.Equal("a", state.Inflight[0].MessageWithID.(*queue.Publish).Topic)
+	a.EqualValues(2, state.Inflight[1].MessageWithID.(*queue.Pubrel).PacketID)
+	a.Len(state.Queued, 1)
+	a.Equal("b", state.Queued[0].MessageWithID.(*queue.Publish).Topic)
+	a.Equal(2, state.QueuedTotal)
+
+	n, err := c.PurgeQueue(cid)
+	a.NoError(err)
+	a.Equal(2, n)
+	state, err = c.InspectSession(cid, 1)
+	a.NoError(err)
+	a.Len(state.Inflight, 2)
+	a.Len(state.Queued, 0)
+	a.Equal(0, state.QueuedTotal)
+
+	// the queue store does not support the inspection.
+	s.queueStore[cid] = queue.NewMockStore(ctrl)
+	_, err = c.InspectSession(cid, 1)
+	a.Equal(ErrInspectionNotSupported, err)
+	_, err = c.PurgeQueue(cid)
+	a.Equal(ErrInspectionNotSupported, err)
+}
@@ -0,0 +1,168 @@
+package server
+
+import (
+	"hash/fnv"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/DrmagicE/gmqtt"
+	"github.com/DrmagicE/gmqtt/config"
+	"github.com/DrmagicE/gmqtt/persistence/subscription"
+)
+
+// sharedSubscriber is a member of the shared subscription group which matches the topic.
+type sharedSubscriber struct {
+	clientID string
+	sub      *gmqtt.Subscription
+}
+
+// sharedDispatcher picks the member of the shared subscription group to deliver the message to,
+// according to the shared_subscription_strategy config.
+type sharedDispatcher struct {
+	srv *server
+	// cursors is the round robin cursors of the groups, key by the full topic filter, value is *uint64.
+	// The cursor is dropped after the last member of the group unsubscribes.
+	cursors sync.Map
+}
+
+func newSharedDispatcher(srv *server) *sharedDispatcher {
+	return &sharedDispatcher{srv: srv}
+}
+
+func (s *sharedDispatcher) cursor(topicFilter string) *uint64 {
+	v, ok := s.cursors.Load(topicFilter)
+	if !ok {
+		v, _ = s.cursors.LoadOrStore(topicFilter, new(uint64))
+	}
+	return v.(*uint64)
+}
+
+// dropCursor drops the round robin cursor of the group if the group has no members.
+// A member which subscribes concurrently only restarts the round robin of the group.
+func (s *sharedDispatcher) dropCursor(store subscription.Store, topicFilter string) {
+	if _, ok := s.cursors.Load(topicFilter); !ok {
+		return
+	}
+	empty := true
+	store.Iterate(func(clientID string, sub *gmqtt.Subscription) bool {
+		empty = false
+		return false
+	}, subscription.IterationOptions{
+		Type:      subscription.TypeShared,
+		TopicName: topicFilter,
+		MatchType: subscription.MatchName,
+	})
+	if empty {
+		s.cursors.Delete(topicFilter)
+	}
+}
+
+// watch returns the subscription store which drops the round robin cursor of the group after its last member unsubscribes.
+func (s *sharedDispatcher) watch(store subscription.Store) subscription.Store {
+	return &sharedCursorWatch{Store: store, dispatcher: s}
+}
+
+type sharedCursorWatch struct {
+	subscription.Store
+	dispatcher *sharedDispatcher
+}
+
+func (w *sharedCursorWatch) Unsubscribe(clientID string, topics ...string) error {
+	err := w.Store.Unsubscribe(clientID, topics...)
+	for _, v := range topics {
+		if shareName, _ := subscription.SplitTopic(v); shareName != "" {
+			w.dispatcher.dropCursor(w.Store, v)
+		}
+	}
+	return err
+}
+
+func (w *sharedCursorWatch) UnsubscribeAll(clientID string) error {
+	var topics []string
+	w.Store.Iterate(func(clientID string, sub *gmqtt.Subscription) bool {
+		topics = append(topics, sub.GetFullTopicName())
+		return true
+	}, subscription.IterationOptions{
+		Type:     subscription.TypeShared,
+		ClientID: clientID,
+	})
+	err := w.Store.UnsubscribeAll(clientID)
+	for _, v := range topics {
+		w.dispatcher.dropCursor(w.Store, v)
+	}
+	return err
+}
+
+// pick returns the member of the group which the message published by srcClientID is delivered to.
+// members must not be empty, it may be reordered.
+func (s *sharedDispatcher) pick(strategy string, srcClientID string, topicFilter string, members []sharedSubscriber) sharedSubscriber {
+	if len(members) == 1 {
+		return members[0]
+	}
+	switch strategy {
+	case config.SharedSubStrategyRoundRobin:
+		// the subscription store does not guarantee the iteration order.
+		sort.Slice(members, func(i, j int) bool {
+			return members[i].clientID < members[j].clientID
+		})
+		n := atomic.AddUint64(s.cursor(topicFilter), 1) - 1
+		return members[n%uint64(len(members))]
+	case config.SharedSubStrategySticky:
+		return sticky(srcClientID, members)
+	case config.SharedSubStrategyLeastInflight:
+		return s.leastInflight(members)
+	default:
+		return members[rand.Intn(len(members))]
+	}
+}
+
+// sticky picks the member by the rendezvous hashing of the publisher client id,
+// so that only the publishers bound to the member which leaves or joins the group are moved to other members.
+// The messages published by the plugins and the API (without client id) are bound to the same member.
+func sticky(srcClientID string, members []sharedSubscriber) (rs sharedSubscriber) {
+	var max uint64
+	for i, v := range members {
+		h := fnv.New64a()
+		_, _ = h.Write([]byte(srcClientID))
+		_, _ = h.Write([]byte{0})
+		_, _ = h.Write([]byte(v.clientID))
+		if sum := h.Sum64(); i == 0 || sum > max || (sum == max && v.clientID < rs.clientID) {
+			rs, max = v, sum
+		}
+	}
+	return rs
+}
+
+// load returns the inflight and queue length of the member, online is false if the member is offline.
+// The counters are read from the client, so that the publish is not serialised on the lock of the stats manager.
+func (s *sharedDispatcher) load(clientID string) (inflight, queued uint64, online bool) {
+	sh := s.srv.registry.shard(clientID)
+	sh.rlock()
+	defer sh.runlock()
+	c := sh.clients[clientID]
+	if c == nil || c.stats == nil {
+		return 0, 0, false
+	}
+	return atomic.LoadUint64(&c.stats.MessageStats.InflightCurrent), atomic.LoadUint64(&c.stats.MessageStats.QueuedCurrent), true
+}
+
+// leastInflight picks the online member which has the fewest inflight messages, the ties are broken by the queue length,
+// and then randomly. If all members are offline, a random one is picked.
+func (s *sharedDispatcher) leastInflight(members []sharedSubscriber) (rs sharedSubscriber) {
+	var minInflight, minQueued uint64
+	offset := rand.Intn(len(members))
+	for i := range members {
+		v := members[(offset+i)%len(members)]
+		inflight, queued, online := s.load(v.clientID)
+		if !online {
+			inflight, queued = math.MaxUint64, math.MaxUint64
+		}
+		if i == 0 || inflight < minInflight || (inflight == minInflight && queued < minQueued) {
+			rs, minInflight, minQueued = v, inflight, queued
+		}
+	}
+	return rs
+}
@@ -0,0 +1,98 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DrmagicE/gmqtt"
+	"github.com/DrmagicE/gmqtt/config"
+	"github.com/DrmagicE/gmqtt/persistence/subscription/mem"
+)
+
+func newSharedSubscribers(clientIDs ...string) []sharedSubscriber {
+	rs := make([]sharedSubscriber, 0, len(clientIDs))
+	for _, v := range clientIDs {
+		rs = append(rs, sharedSubscriber{clientID: v})
+	}
+	return rs
+}
+
+func TestSharedDispatcher_roundRobin(t *testing.T) {
+	a := assert.New(t)
+	s := newSharedDispatcher(defaultServer())
+	var got []string
+	for i := 0; i < 6; i++ {
+		got = append(got, s.pick(config.SharedSubStrategyRoundRobin, "", "$share/g/a", newSharedSubscribers("c3", "c1", "c2")).clientID)
+	}
+	a.Equal([]string{"c1", "c2", "c3", "c1", "c2", "c3"}, got)
+	// the groups have their own cursors.
+	a.Equal("c1", s.pick(config.SharedSubStrategyRoundRobin, "", "$share/g/b", newSharedSubscribers("c1", "c2")).clientID)
+}
+
+func TestSharedDispatcher_watch(t *testing.T) {
+	a := assert.New(t)
+	s := newSharedDispatcher(defaultServer())
+	store := s.watch(mem.NewStore())
+	hasCursor := func(topicFilter string) bool {
+		_, ok := s.cursors.Load(topicFilter)
+		return ok
+	}
+	for _, v := range []string{"c1", "c2"} {
+		_, err := store.Subscribe(v, &gmqtt.Subscription{ShareName: "g", TopicFilter: "a"}, &gmqtt.Subscription{ShareName: "g", TopicFilter: "b"})
+		a.Nil(err)
+	}
+	s.pick(config.SharedSubStrategyRoundRobin, "", "$share/g/a", newSharedSubscribers("c1", "c2"))
+	s.pick(config.SharedSubStrategyRoundRobin, "", "$share/g/b", newSharedSubscribers("c1", "c2"))
+
+	a.Nil(store.Unsubscribe("c1", "$share/g/a"))
+	a.True(hasCursor("$share/g/a"))
+	a.Nil(store.Unsubscribe("c2", "$share/g/a"))
+	a.False(hasCursor("$share/g/a"))
+
+	a.Nil(store.UnsubscribeAll("c1"))
+	a.True(hasCursor("$share/g/b"))
+	a.Nil(store.UnsubscribeAll("c2"))
+	a.False(hasCursor("$share/g/b"))
+}
+
+func TestSharedDispatcher_sticky(t *testing.T) {
+	a := assert.New(t)
+	s := newSharedDispatcher(defaultServer())
+	members := []string{"c1", "c2", "c3", "c4"}
+	picked := make(map[string]string)
+	for _, pub := range []string{"p1", "p2", "p3", "p4", "p5", "p6", "p7", "p8"} {
+		picked[pub] = s.pick(config.SharedSubStrategySticky, pub, "$share/g/a", newSharedSubscribers(members...)).clientID
+		// the order of the members does not matter.
+		a.Equal(picked[pub], s.pick(config.SharedSubStrategySticky, pub, "$share/g/a", newSharedSubscribers("c4", "c3", "c2", "c1")).clientID)
+	}
+	// only the publishers bound to the leaving member are moved.
+	for pub, clientID := range picked {
+		got := s.pick(config.SharedSubStrategySticky, pub, "$share/g/a", newSharedSubscribers("c1", "c3", "c4")).clientID
+		if clientID != "c2" {
+			a.Equal(clientID, got)
+		} else {
+			a.NotEqual("c2", got)
+		}
+	}
+}
+
+func TestSharedDispatcher_leastInflight(t *testing.T) {
+	a := assert.New(t)
+	srv := defaultServer()
+	srv.statsManager = newStatsManager(mem.NewStore())
+	s := newSharedDispatcher(srv)
+	for _, v := range []string{"c1", "c2", "c3"} {
+		srv.registry.shard(v).clients[v] = &client{stats: srv.statsManager.clientStatsOf(v)}
+	}
+	srv.statsManager.addInflight("c1", 2)
+	srv.statsManager.addInflight("c2", 1)
+	srv.statsManager.addInflight("c3", 1)
+	srv.statsManager.addQueueLen("c2", 5)
+	srv.statsManager.addQueueLen("c3", 1)
+	for i := 0; i < 10; i++ {
+		a.Equal("c3", s.pick(config.SharedSubStrategyLeastInflight, "", "$share/g/a", newSharedSubscribers("c1", "c2", "c3", "offline")).clientID)
+	}
+	// the offline member is picked only if all members are offline.
+	a.Contains([]string{"offline1", "offline2"}, s.pick(config.SharedSubStrategyLeastInflight, "", "$share/g/a", newSharedSubscribers("offline1", "offline2")).clientID)
+}
@@ -0,0 +1,133 @@
+package server
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/DrmagicE/gmqtt"
+	"github.com/DrmagicE/gmqtt/persistence/subscription"
+)
+
+// SharedMemberStats is the statistics of a member of a shared subscription group.
+type SharedMemberStats struct {
+	ClientID string
+	// Delivered is the number of the messages that are dispatched to the member.
+	Delivered uint64
+	// Redelivered is the number of the inflight messages that are resent to the member after it reconnects.
+	Redelivered uint64
+}
+
+// SharedGroupStats is the statistics of a shared subscription group.
+type SharedGroupStats struct {
+	// TopicFilter is the full topic filter in the form of "$share/{ShareName}/{filter}".
+	TopicFilter string
+	ShareName   string
+	// Filter is the topic filter without the "$share/{ShareName}/" prefix.
+	Filter string
+	// Members is the members of the group, sorted by the client id.
+	Members []SharedMemberStats
+	// Delivered is the sum of the Delivered of the members.
+	Delivered uint64
+	// Redelivered is the sum of the Redelivered of the members.
+	Redelivered uint64
+}
+
+type sharedMemberKey struct {
+	topicFilter string
+	clientID    string
+}
+
+type sharedMemberCounter struct {
+	delivered   uint64
+	redelivered uint64
+}
+
+// sharedStats counts the messages dispatched to the members of the shared subscription groups.
+type sharedStats struct {
+	// members is the counters of the group members, key by sharedMemberKey, value is *sharedMemberCounter.
+	members sync.Map
+}
+
+func (s *sharedStats) counter(topicFilter, clientID string) *sharedMemberCounter {
+	key := sharedMemberKey{topicFilter: topicFilter, clientID: clientID}
+	v, ok := s.members.Load(key)
+	if !ok {
+		v, _ = s.members.LoadOrStore(key, &sharedMemberCounter{})
+	}
+	return v.(*sharedMemberCounter)
+}
+
+// delivered counts the message that is dispatched to the member of the shared subscription.
+func (s *sharedStats) delivered(clientID string, sub *gmqtt.Subscription) {
+	atomic.AddUint64(&s.counter(sub.GetFullTopicName(), clientID).delivered, 1)
+}
+
+// sharedRedelivered counts the inflight message that is resent to the client after it reconnects.
+// The message is counted for each shared subscription of the client that matches the topic.
+func (s *statsManager) sharedRedelivered(clientID string, topic string) {
+	s.subStore.Iterate(func(_ string, sub *gmqtt.Subscription) bool {
+		atomic.AddUint64(&s.sharedStats.counter(sub.GetFullTopicName(), clientID).redelivered, 1)
+		return true
+	}, subscription.IterationOptions{
+		Type:      subscription.TypeShared,
+		ClientID:  clientID,
+		TopicName: topic,
+		MatchType: subscription.MatchFilter,
+	})
+}
+
+// GetSharedStats returns the statistics of the shared subscription groups, sorted by the topic filter.
+// The counters of the members which have unsubscribed are discarded.
+func (s *statsManager) GetSharedStats() []SharedGroupStats {
+	groups := make(map[string]*SharedGroupStats)
+	members := make(map[sharedMemberKey]struct{})
+	s.subStore.Iterate(func(clientID string, sub *gmqtt.Subscription) bool {
+		name := sub.GetFullTopicName()
+		g, ok := groups[name]
+		if !ok {
+			g = &SharedGroupStats{
+				TopicFilter: name,
+				ShareName:   sub.ShareName,
+				Filter:      sub.TopicFilter,
+			}
+			groups[name] = g
+		}
+		members[sharedMemberKey{topicFilter: name, clientID: clientID}] = struct{}{}
+		return true
+	}, subscription.IterationOptions{
+		Type: subscription.TypeShared,
+	})
+	counters := make(map[sharedMemberKey]*sharedMemberCounter)
+	s.sharedStats.members.Range(func(key, value interface{}) bool {
+		k := key.(sharedMemberKey)
+		if _, ok := members[k]; ok {
+			counters[k] = value.(*sharedMemberCounter)
+		} else {
+			s.sharedStats.members.Delete(key)
+		}
+		return true
+	})
+	for k := range members {
+		g := groups[k.topicFilter]
+		m := SharedMemberStats{ClientID: k.clientID}
+		if c, ok := counters[k]; ok {
+			m.Delivered = atomic.LoadUint64(&c.delivered)
+			m.Redelivered = atomic.LoadUint64(&c.redelivered)
+		}
+		g.Members = append(g.Members, m)
+		g.Delivered += m.Delivered
+		g.Redelivered += m.Redelivered
+	}
+	rs := make([]SharedGroupStats, 0, len(groups))
+	for _, g := range groups {
+		sort.Slice(g.Members, func(i, j int) bool {
+			return g.Members[i].ClientID < g.Members[j].ClientID
+		})
+		rs = append(rs, *g)
+	}
+	sort.Slice(rs, func(i, j int) bool {
+		return rs[i].TopicFilter < rs[j].TopicFilter
+	})
+	return rs
+}
@@ -0,0 +1,61 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DrmagicE/gmqtt"
+	"github.com/DrmagicE/gmqtt/persistence/subscription/mem"
+)
+
+func TestStatsManager_GetSharedStats(t *testing.T) {
+	a := assert.New(t)
+	sub := mem.NewStore()
+	s := newStatsManager(sub)
+	a.Empty(s.GetSharedStats())
+
+	g1 := &gmqtt.Subscription{ShareName: "g1", TopicFilter: "a/+"}
+	g2 := &gmqtt.Subscription{ShareName: "g2", TopicFilter: "a/b"}
+	_, err := sub.Subscribe("c1", g1, g2, &gmqtt.Subscription{TopicFilter: "a/b"})
+	a.NoError(err)
+	_, err = sub.Subscribe("c2", g1)
+	a.NoError(err)
+
+	s.sharedStats.delivered("c1", g1)
+	s.sharedStats.delivered("c1", g1)
+	s.sharedStats.delivered("c2", g1)
+	s.sharedStats.delivered("c1", g2)
+	// the redelivered message is counted for the matched shared subscriptions of the client only.
+	s.sharedRedelivered("c1", "a/b")
+	s.sharedRedelivered("c2", "a/c")
+	s.sharedRedelivered("c2", "b")
+	// the counter of the member which has unsubscribed is discarded.
+	s.sharedStats.delivered("c3", g1)
+
+	a.Equal([]SharedGroupStats{
+		{
+			TopicFilter: "$share/g1/a/+",
+			ShareName:   "g1",
+			Filter:      "a/+",
+			Members: []SharedMemberStats{
+				{ClientID: "c1", Delivered: 2, Redelivered: 1},
+				{ClientID: "c2", Delivered: 1, Redelivered: 1},
+			},
+			Delivered:   3,
+			Redelivered: 2,
+		},
+		{
+			TopicFilter: "$share/g2/a/b",
+			ShareName:   "g2",
+			Filter:      "a/b",
+			Members: []SharedMemberStats{
+				{ClientID: "c1", Delivered: 1, Redelivered: 1},
+			},
+			Delivered:   1,
+			Redelivered: 1,
+		},
+	}, s.GetSharedStats())
+	_, ok := s.sharedStats.members.Load(sharedMemberKey{topicFilter: "$share/g1/a/+", clientID: "c3"})
+	a.False(ok)
+}
@@ -3,6 +3,7 @@ package server
 import (
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/DrmagicE/gmqtt/persistence/queue"
 	"github.com/DrmagicE/gmqtt/persistence/subscription"
@@ -10,15 +11,27 @@ import (
 )
 
 type statsManager struct {
-	subStatsReader subscription.StatsReader
-	totalStats     *GlobalStats
-	clientMu       sync.Mutex
-	clientStats    map[string]*ClientStats
+	subStore     subscription.Store
+	totalStats   *GlobalStats
+	clientMu     sync.Mutex
+	clientStats  map[string]*ClientStats
+	registry     *registry
+	hookTimer    *hookTimer
+	hookTimeouts []*hookTimeout
+	// pluginSandbox provides the panic statistics of the plugins, nil if the plugin sandbox is disabled.
+	pluginSandbox *pluginSandbox
+	topicStats    *topicStats
+	sharedStats   *sharedStats
+	// subscriptionIDStats counts the sent messages by the subscription identifiers.
+	subscriptionIDStats *subscriptionIDStats
+	startedAt           time.Time
+	// lifetimeBase is the lifetime statistics restored at startup, nil if the stats persistence is disabled.
+	lifetimeBase *LifetimeStats
 }
 
 func (s *statsManager) getClientStats(clientID string) (stats *ClientStats) {
 	if stats = s.clientStats[clientID]; stats == nil {
-		subStats, _ := s.subStatsReader.GetClientStats(clientID)
+		subStats, _ := s.subStore.GetClientStats(clientID)
 
 		stats = &ClientStats{
 			SubscriptionStats: subStats,
@@ -85,30 +98,37 @@ func (s *statsManager) sessionTerminated(clientID string, reason SessionTerminat
 	}
 	atomic.AddUint64(i, 1)
 	atomic.AddUint64(&s.totalStats.ConnectionStats.InactiveCurrent, ^uint64(0))
+	s.subscriptionIDStats.remove(clientID)
 	s.clientMu.Lock()
 	defer s.clientMu.Unlock()
 	delete(s.clientStats, clientID)
 }
 
 func (s *statsManager) messageDropped(qos uint8, clientID string, err error) {
+	if qos > packets.Qos2 {
+		return
+	}
+	s.totalStats.MessageStats.droppedTotal(qos).messageDropped(err)
+	// The message published by the Publisher API does not belong to any client.
+	if clientID == "" {
+		return
+	}
+	s.clientMu.Lock()
+	defer s.clientMu.Unlock()
+	s.getClientStats(clientID).MessageStats.droppedTotal(qos).messageDropped(err)
+}
+
+func (m *MessageStats) droppedTotal(qos uint8) *DroppedTotal {
 	switch qos {
 	case packets.Qos0:
-		s.totalStats.MessageStats.Qos0.DroppedTotal.messageDropped(err)
-		s.clientMu.Lock()
-		defer s.clientMu.Unlock()
-		s.getClientStats(clientID).MessageStats.Qos0.DroppedTotal.messageDropped(err)
+		return &m.Qos0.DroppedTotal
 	case packets.Qos1:
-		s.totalStats.MessageStats.Qos1.DroppedTotal.messageDropped(err)
-		s.clientMu.Lock()
-		defer s.clientMu.Unlock()
-		s.getClientStats(clientID).MessageStats.Qos1.DroppedTotal.messageDropped(err)
-	case packets.Qos2:
-		s.totalStats.MessageStats.Qos2.DroppedTotal.messageDropped(err)
-		s.clientMu.Lock()
-		defer s.clientMu.Unlock()
-		s.getClientStats(clientID).MessageStats.Qos2.DroppedTotal.messageDropped(err)
+		return &m.Qos1.DroppedTotal
+	default:
+		return &m.Qos2.DroppedTotal
 	}
 }
+
 func (d *DroppedTotal) messageDropped(err error) {
 	switch err {
 	case queue.ErrDropExceedsMaxPacketSize:
@@ -119,6 +139,18 @@ func (d *DroppedTotal) messageDropped(err error) {
 		atomic.AddUint64(&d.Expired, 1)
 	case queue.ErrDropExpiredInflight:
 		atomic.AddUint64(&d.InflightExpired, 1)
+	case queue.ErrDropOverloaded:
+		atomic.AddUint64(&d.Overloaded, 1)
+	case queue.ErrDropQos0NotQueued:
+		atomic.AddUint64(&d.Qos0NotQueued, 1)
+	case queue.ErrDropNoSubscriber:
+		atomic.AddUint64(&d.NoSubscriber, 1)
+	case queue.ErrDropIncompatible:
+		atomic.AddUint64(&d.Incompatible, 1)
+	case queue.ErrDropRedeliveryExhausted:
+		atomic.AddUint64(&d.RedeliveryExhausted, 1)
+	case queue.ErrDropBlackhole:
+		atomic.AddUint64(&d.Blackhole, 1)
 	default:
 		atomic.AddUint64(&d.Internal, 1)
 	}
@@ -170,6 +202,14 @@ type StatsReader interface {
 	GetGlobalStats() GlobalStats
 	// GetClientStats returns the client statistics for the given client id
 	GetClientStats(clientID string) (sts ClientStats, exist bool)
+	// GetTopicStats returns the statistics of the topic filters which have subscribers and of their top-level namespaces.
+	// This method will walk through all subscriptions, so it is an expensive operation. Do not call it frequently.
+	GetTopicStats() TopicStats
+	// GetSharedStats returns the statistics of the shared subscription groups and of their members.
+	// This method will walk through all shared subscriptions, do not call it frequently.
+	GetSharedStats() []SharedGroupStats
+	// GetSubscriptionIDStats returns the statistics of the subscription identifiers of the client.
+	GetSubscriptionIDStats(clientID string) []SubscriptionIDStats
 }
 
 // PacketStats represents  the statistics of MQTT Packet.
@@ -308,6 +348,12 @@ type ConnectionStats struct {
 	ActiveCurrent uint64
 	// InactiveCurrent is the number of used inactive session.
 	InactiveCurrent uint64
+	// AuthFailedTotal is the number of failed authentications, only counted if the auth throttling is enabled.
+	AuthFailedTotal uint64
+	// AuthLockoutTotal is the number of lockouts caused by too many authentication failures.
+	AuthLockoutTotal uint64
+	// HandshakeRejectedTotal is the number of the CONNECT packets rejected by the handshake limits.
+	HandshakeRejectedTotal uint64
 }
 
 func (c *ConnectionStats) copy() *ConnectionStats {
@@ -324,8 +370,11 @@ func (c *ConnectionStats) copy() *ConnectionStats {
 			Expired:   atomic.LoadUint64(&c.SessionTerminated.Expired),
 			Normal:    atomic.LoadUint64(&c.SessionTerminated.Normal),
 		},
-		ActiveCurrent:   atomic.LoadUint64(&c.ActiveCurrent),
-		InactiveCurrent: atomic.LoadUint64(&c.InactiveCurrent),
+		ActiveCurrent:          atomic.LoadUint64(&c.ActiveCurrent),
+		InactiveCurrent:        atomic.LoadUint64(&c.InactiveCurrent),
+		AuthFailedTotal:        atomic.LoadUint64(&c.AuthFailedTotal),
+		AuthLockoutTotal:       atomic.LoadUint64(&c.AuthLockoutTotal),
+		HandshakeRejectedTotal: atomic.LoadUint64(&c.HandshakeRejectedTotal),
 	}
 }
 
@@ -335,6 +384,12 @@ type DroppedTotal struct {
 	QueueFull            uint64
 	Expired              uint64
 	InflightExpired      uint64
+	Overloaded           uint64
+	Qos0NotQueued        uint64
+	NoSubscriber         uint64
+	Incompatible         uint64
+	RedeliveryExhausted  uint64
+	Blackhole            uint64
 }
 
 type MessageQosStats struct {
@@ -344,7 +399,9 @@ type MessageQosStats struct {
 }
 
 func (m *MessageQosStats) GetDroppedTotal() uint64 {
-	return m.DroppedTotal.Internal + m.DroppedTotal.Expired + m.DroppedTotal.ExceedsMaxPacketSize + m.DroppedTotal.QueueFull + m.DroppedTotal.InflightExpired
+	return m.DroppedTotal.Internal + m.DroppedTotal.Expired + m.DroppedTotal.ExceedsMaxPacketSize + m.DroppedTotal.QueueFull + m.DroppedTotal.InflightExpired + m.DroppedTotal.Overloaded +
+		m.DroppedTotal.Qos0NotQueued + m.DroppedTotal.NoSubscriber + m.DroppedTotal.Incompatible +
+		m.DroppedTotal.RedeliveryExhausted + m.DroppedTotal.Blackhole
 }
 
 // MessageStats represents the statistics of PUBLISH in, separated by QOS.
@@ -354,6 +411,8 @@ type MessageStats struct {
 	Qos2            MessageQosStats
 	InflightCurrent uint64
 	QueuedCurrent   uint64
+	// OfflineQueuedTotal is the number of the messages queued for the offline sessions.
+	OfflineQueuedTotal uint64
 }
 
 func (m *MessageStats) GetDroppedTotal() uint64 {
@@ -381,6 +440,33 @@ func (s *statsManager) decInflight(clientID string, delta uint64) {
 	atomic.AddUint64(&s.totalStats.MessageStats.InflightCurrent, ^uint64(delta-1))
 }
 
+// inflightLen returns the current inflight length of the client.
+func (s *statsManager) inflightLen(clientID string) uint64 {
+	s.clientMu.Lock()
+	defer s.clientMu.Unlock()
+	if sts := s.clientStats[clientID]; sts != nil {
+		return atomic.LoadUint64(&sts.MessageStats.InflightCurrent)
+	}
+	return 0
+}
+
+// clientStatsOf returns the stats of the client, the counters can be read atomically without the lock.
+func (s *statsManager) clientStatsOf(clientID string) *ClientStats {
+	s.clientMu.Lock()
+	defer s.clientMu.Unlock()
+	return s.getClientStats(clientID)
+}
+
+// queueLen returns the current queue length of the client.
+func (s *statsManager) queueLen(clientID string) uint64 {
+	s.clientMu.Lock()
+	defer s.clientMu.Unlock()
+	if sts := s.clientStats[clientID]; sts != nil {
+		return atomic.LoadUint64(&sts.MessageStats.QueuedCurrent)
+	}
+	return 0
+}
+
 func (s *statsManager) addQueueLen(clientID string, delta uint64) {
 	s.clientMu.Lock()
 	defer s.clientMu.Unlock()
@@ -402,6 +488,13 @@ func (s *statsManager) decQueueLen(clientID string, delta uint64) {
 	atomic.AddUint64(&s.totalStats.MessageStats.QueuedCurrent, ^uint64(delta-1))
 }
 
+func (s *statsManager) messageQueuedOffline(clientID string) {
+	atomic.AddUint64(&s.totalStats.MessageStats.OfflineQueuedTotal, 1)
+	s.clientMu.Lock()
+	defer s.clientMu.Unlock()
+	atomic.AddUint64(&s.getClientStats(clientID).MessageStats.OfflineQueuedTotal, 1)
+}
+
 func (m *MessageStats) copy() *MessageStats {
 	return &MessageStats{
 		Qos0: MessageQosStats{
@@ -411,6 +504,12 @@ func (m *MessageStats) copy() *MessageStats {
 				QueueFull:            atomic.LoadUint64(&m.Qos0.DroppedTotal.QueueFull),
 				Expired:              atomic.LoadUint64(&m.Qos0.DroppedTotal.Expired),
 				InflightExpired:      atomic.LoadUint64(&m.Qos0.DroppedTotal.InflightExpired),
+				Overloaded:           atomic.LoadUint64(&m.Qos0.DroppedTotal.Overloaded),
+				Qos0NotQueued:        atomic.LoadUint64(&m.Qos0.DroppedTotal.Qos0NotQueued),
+				NoSubscriber:         atomic.LoadUint64(&m.Qos0.DroppedTotal.NoSubscriber),
+				Incompatible:         atomic.LoadUint64(&m.Qos0.DroppedTotal.Incompatible),
+				RedeliveryExhausted:  atomic.LoadUint64(&m.Qos0.DroppedTotal.RedeliveryExhausted),
+				Blackhole:            atomic.LoadUint64(&m.Qos0.DroppedTotal.Blackhole),
 			},
 			ReceivedTotal: atomic.LoadUint64(&m.Qos0.ReceivedTotal),
 			SentTotal:     atomic.LoadUint64(&m.Qos0.SentTotal),
@@ -422,6 +521,12 @@ func (m *MessageStats) copy() *MessageStats {
 				QueueFull:            atomic.LoadUint64(&m.Qos1.DroppedTotal.QueueFull),
 				Expired:              atomic.LoadUint64(&m.Qos1.DroppedTotal.Expired),
 				InflightExpired:      atomic.LoadUint64(&m.Qos1.DroppedTotal.InflightExpired),
+				Overloaded:           atomic.LoadUint64(&m.Qos1.DroppedTotal.Overloaded),
+				Qos0NotQueued:        atomic.LoadUint64(&m.Qos1.DroppedTotal.Qos0NotQueued),
+				NoSubscriber:         atomic.LoadUint64(&m.Qos1.DroppedTotal.NoSubscriber),
+				Incompatible:         atomic.LoadUint64(&m.Qos1.DroppedTotal.Incompatible),
+				RedeliveryExhausted:  atomic.LoadUint64(&m.Qos1.DroppedTotal.RedeliveryExhausted),
+				Blackhole:            atomic.LoadUint64(&m.Qos1.DroppedTotal.Blackhole),
 			},
 			ReceivedTotal: atomic.LoadUint64(&m.Qos1.ReceivedTotal),
 			SentTotal:     atomic.LoadUint64(&m.Qos1.SentTotal),
@@ -433,12 +538,19 @@ func (m *MessageStats) copy() *MessageStats {
 				QueueFull:            atomic.LoadUint64(&m.Qos2.DroppedTotal.QueueFull),
 				Expired:              atomic.LoadUint64(&m.Qos2.DroppedTotal.Expired),
 				InflightExpired:      atomic.LoadUint64(&m.Qos2.DroppedTotal.InflightExpired),
+				Overloaded:           atomic.LoadUint64(&m.Qos2.DroppedTotal.Overloaded),
+				Qos0NotQueued:        atomic.LoadUint64(&m.Qos2.DroppedTotal.Qos0NotQueued),
+				NoSubscriber:         atomic.LoadUint64(&m.Qos2.DroppedTotal.NoSubscriber),
+				Incompatible:         atomic.LoadUint64(&m.Qos2.DroppedTotal.Incompatible),
+				RedeliveryExhausted:  atomic.LoadUint64(&m.Qos2.DroppedTotal.RedeliveryExhausted),
+				Blackhole:            atomic.LoadUint64(&m.Qos2.DroppedTotal.Blackhole),
 			},
 			ReceivedTotal: atomic.LoadUint64(&m.Qos2.ReceivedTotal),
 			SentTotal:     atomic.LoadUint64(&m.Qos2.SentTotal),
 		},
-		InflightCurrent: atomic.LoadUint64(&m.InflightCurrent),
-		QueuedCurrent:   atomic.LoadUint64(&m.QueuedCurrent),
+		InflightCurrent:    atomic.LoadUint64(&m.InflightCurrent),
+		QueuedCurrent:      atomic.LoadUint64(&m.QueuedCurrent),
+		OfflineQueuedTotal: atomic.LoadUint64(&m.OfflineQueuedTotal),
 	}
 }
 
@@ -448,6 +560,29 @@ type GlobalStats struct {
 	PacketStats       PacketStats
 	MessageStats      MessageStats
 	SubscriptionStats subscription.Stats
+	RegistryStats     RegistryStats
+	// HookStats is the latency statistics of the hooks of each plugin, nil if the hook_timing.metrics is disabled.
+	HookStats []HookStats
+	// HookTimeoutTotal is the number of timeouts of the hook chains, key by the hook name.
+	HookTimeoutTotal map[string]uint64
+	// PluginFailures is the panic statistics of each plugin, nil if the plugin_sandbox is disabled.
+	PluginFailures []PluginFailureStats
+	// StartedAt is the time when the server started, the counters above are counted since then.
+	StartedAt time.Time
+	// Lifetime is the cumulative statistics across restarts, nil if the stats persistence is disabled.
+	Lifetime *LifetimeStats
+}
+
+// RegistryStats provides the lock contention statistics of the sharded client registry.
+type RegistryStats struct {
+	// Shards is the number of the registry shards.
+	Shards uint64
+	// LockAcquiredTotal is the number of times that the shard locks have been acquired.
+	LockAcquiredTotal uint64
+	// LockContendedTotal is the number of lock acquisitions that had to wait for other goroutines.
+	LockContendedTotal uint64
+	// LockWaitSeconds is the total time spent on waiting for the contended locks.
+	LockWaitSeconds float64
 }
 
 // ClientStats is the statistic information of one client.
@@ -463,12 +598,28 @@ func (c ClientStats) GetDroppedTotal() uint64 {
 
 // GetGlobalStats returns the GlobalStats
 func (s *statsManager) GetGlobalStats() GlobalStats {
-	return GlobalStats{
+	g := GlobalStats{
 		PacketStats:       *s.totalStats.PacketStats.copy(),
 		ConnectionStats:   *s.totalStats.ConnectionStats.copy(),
 		MessageStats:      *s.totalStats.MessageStats.copy(),
-		SubscriptionStats: s.subStatsReader.GetStats(),
+		SubscriptionStats: s.subStore.GetStats(),
+		StartedAt:         s.startedAt,
+	}
+	if s.registry != nil {
+		g.RegistryStats = s.registry.getStats()
+	}
+	g.HookStats = s.hookTimer.getStats()
+	if len(s.hookTimeouts) != 0 {
+		g.HookTimeoutTotal = make(map[string]uint64)
+		for _, v := range s.hookTimeouts {
+			g.HookTimeoutTotal[v.hook] = atomic.LoadUint64(&v.total)
+		}
+	}
+	g.PluginFailures = s.pluginSandbox.getStats()
+	if s.lifetimeBase != nil {
+		g.Lifetime = s.lifetime(&g)
 	}
+	return g
 }
 
 // GetClientStats returns the client statistic information for given client id.
@@ -478,7 +629,7 @@ func (s *statsManager) GetClientStats(clientID string) (ClientStats, bool) {
 	if stats := s.clientStats[clientID]; stats == nil {
 		return ClientStats{}, false
 	} else {
-		s, _ := s.subStatsReader.GetClientStats(clientID)
+		s, _ := s.subStore.GetClientStats(clientID)
 		return ClientStats{
 			PacketStats:       *stats.PacketStats.copy(),
 			MessageStats:      *stats.MessageStats.copy(),
@@ -488,11 +639,15 @@ func (s *statsManager) GetClientStats(clientID string) (ClientStats, bool) {
 
 }
 
-func newStatsManager(subStatsReader subscription.StatsReader) *statsManager {
+func newStatsManager(subStore subscription.Store) *statsManager {
 	return &statsManager{
-		subStatsReader: subStatsReader,
-		totalStats:     &GlobalStats{},
-		clientMu:       sync.Mutex{},
-		clientStats:    make(map[string]*ClientStats),
+		subStore:            subStore,
+		totalStats:          &GlobalStats{},
+		clientMu:            sync.Mutex{},
+		clientStats:         make(map[string]*ClientStats),
+		topicStats:          &topicStats{},
+		sharedStats:         &sharedStats{},
+		subscriptionIDStats: &subscriptionIDStats{},
+		startedAt:           time.Now(),
 	}
 }
@@ -60,3 +60,45 @@ func (mr *MockStatsReaderMockRecorder) GetClientStats(clientID interface{}) *gom
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetClientStats", reflect.TypeOf((*MockStatsReader)(nil).GetClientStats), clientID)
 }
+
+// GetTopicStats mocks base method
+func (m *MockStatsReader) GetTopicStats() TopicStats {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetTopicStats")
+	ret0, _ := ret[0].(TopicStats)
+	return ret0
+}
+
+// GetTopicStats indicates an expected call of GetTopicStats
+func (mr *MockStatsReaderMockRecorder) GetTopicStats() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetTopicStats", reflect.TypeOf((*MockStatsReader)(nil).GetTopicStats))
+}
+
+// GetSharedStats mocks base method
+func (m *MockStatsReader) GetSharedStats() []SharedGroupStats {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetSharedStats")
+	ret0, _ := ret[0].([]SharedGroupStats)
+	return ret0
+}
+
+// GetSharedStats indicates an expected call of GetSharedStats
+func (mr *MockStatsReaderMockRecorder) GetSharedStats() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetSharedStats", reflect.TypeOf((*MockStatsReader)(nil).GetSharedStats))
+}
+
+// GetSubscriptionIDStats mocks base method
+func (m *MockStatsReader) GetSubscriptionIDStats(clientID string) []SubscriptionIDStats {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetSubscriptionIDStats", clientID)
+	ret0, _ := ret[0].([]SubscriptionIDStats)
+	return ret0
+}
+
+// GetSubscriptionIDStats indicates an expected call of GetSubscriptionIDStats
+func (mr *MockStatsReaderMockRecorder) GetSubscriptionIDStats(clientID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetSubscriptionIDStats", reflect.TypeOf((*MockStatsReader)(nil).GetSubscriptionIDStats), clientID)
+}
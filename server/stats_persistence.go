@@ -0,0 +1,124 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/DrmagicE/gmqtt/config"
+)
+
+// LifetimeStats is the cumulative statistics across restarts, see config.StatsPersistence.
+// Only the counters are accumulated, the current values (e.g. ActiveCurrent, QueuedCurrent) are always zero.
+type LifetimeStats struct {
+	// Since is the time when the lifetime statistics started to be collected.
+	Since           time.Time
+	ConnectionStats ConnectionStats
+	PacketStats     PacketStats
+	MessageStats    MessageStats
+}
+
+// addCounters adds all uint64 fields of src to dst recursively, dst must be addressable.
+func addCounters(dst, src reflect.Value) {
+	switch dst.Kind() {
+	case reflect.Uint64:
+		dst.SetUint(dst.Uint() + src.Uint())
+	case reflect.Struct:
+		for i := 0; i < dst.NumField(); i++ {
+			addCounters(dst.Field(i), src.Field(i))
+		}
+	}
+}
+
+// lifetime returns the lifetime statistics by adding the statistics since start to the restored ones.
+func (s *statsManager) lifetime(g *GlobalStats) *LifetimeStats {
+	l := *s.lifetimeBase
+	addCounters(reflect.ValueOf(&l.ConnectionStats).Elem(), reflect.ValueOf(g.ConnectionStats))
+	addCounters(reflect.ValueOf(&l.PacketStats).Elem(), reflect.ValueOf(g.PacketStats))
+	addCounters(reflect.ValueOf(&l.MessageStats).Elem(), reflect.ValueOf(g.MessageStats))
+	l.ConnectionStats.ActiveCurrent = 0
+	l.ConnectionStats.InactiveCurrent = 0
+	l.MessageStats.InflightCurrent = 0
+	l.MessageStats.QueuedCurrent = 0
+	return &l
+}
+
+// readLifetimeStats reads the lifetime statistics from the file,
+// a new LifetimeStats starting from now is returned if the file does not exist.
+func readLifetimeStats(file string) (*LifetimeStats, error) {
+	b, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return &LifetimeStats{Since: time.Now()}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	l := &LifetimeStats{}
+	if err = json.Unmarshal(b, l); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// writeLifetimeStats writes the lifetime statistics to a temporary file and renames it to the file,
+// so that the file is never left partially written.
+func writeLifetimeStats(file string, l *LifetimeStats) error {
+	b, err := json.Marshal(l)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(file), filepath.Base(file)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
+
+// loadLifetimeStats restores the lifetime statistics if the stats persistence is enabled.
+func (srv *server) loadLifetimeStats(cfg config.StatsPersistence) error {
+	if !cfg.Enable {
+		return nil
+	}
+	file := cfg.File
+	if !path.IsAbs(file) {
+		file = path.Join(srv.config.ConfigDir, file)
+	}
+	l, err := readLifetimeStats(file)
+	if err != nil {
+		return fmt.Errorf("fail to load stats file %s: %w", file, err)
+	}
+	srv.statsFile = file
+	srv.statsManager.lifetimeBase = l
+	zaplog.Info("load lifetime stats succeeded", zap.String("file", file), zap.Time("since", l.Since))
+	return nil
+}
+
+// saveLifetimeStats saves the lifetime statistics if the stats persistence is enabled.
+func (srv *server) saveLifetimeStats() {
+	if srv.statsFile == "" {
+		return
+	}
+	g := srv.statsManager.GetGlobalStats()
+	if err := writeLifetimeStats(srv.statsFile, g.Lifetime); err != nil {
+		zaplog.Error("fail to save lifetime stats", zap.String("file", srv.statsFile), zap.Error(err))
+	}
+}
@@ -0,0 +1,94 @@
+package server
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DrmagicE/gmqtt/config"
+	"github.com/DrmagicE/gmqtt/persistence/queue"
+	"github.com/DrmagicE/gmqtt/persistence/subscription/mem"
+	"github.com/DrmagicE/gmqtt/pkg/packets"
+)
+
+func TestServer_lifetimeStats(t *testing.T) {
+	a := assert.New(t)
+	dir, err := ioutil.TempDir("", "stats")
+	a.NoError(err)
+	defer os.RemoveAll(dir)
+
+	newServer := func() *server {
+		srv := defaultServer()
+		srv.config.ConfigDir = dir
+		srv.statsManager = newStatsManager(mem.NewStore())
+		a.NoError(srv.loadLifetimeStats(config.StatsPersistence{
+			Enable:   true,
+			File:     "stats.json",
+			Interval: time.Minute,
+		}))
+		return srv
+	}
+	srv := newServer()
+	a.Equal(filepath.Join(dir, "stats.json"), srv.statsFile)
+	since := srv.statsManager.lifetimeBase.Since
+	sts := srv.statsManager
+	sts.clientConnected("a")
+	sts.sessionActive(true)
+	sts.messageReceived(packets.Qos1, "a")
+	sts.messageDropped(packets.Qos1, "a", queue.ErrDropQueueFull)
+	sts.packetReceived(&packets.Pingreq{}, "a")
+	srv.saveLifetimeStats()
+
+	// restart
+	srv = newServer()
+	sts = srv.statsManager
+	sts.clientConnected("a")
+	sts.sessionActive(false)
+	sts.messageReceived(packets.Qos1, "a")
+
+	g := sts.GetGlobalStats()
+	a.EqualValues(1, g.ConnectionStats.ConnectedTotal)
+	a.EqualValues(1, g.MessageStats.Qos1.ReceivedTotal)
+	a.EqualValues(0, g.MessageStats.Qos1.DroppedTotal.QueueFull)
+
+	l := g.Lifetime
+	a.True(since.Equal(l.Since))
+	a.EqualValues(2, l.ConnectionStats.ConnectedTotal)
+	a.EqualValues(1, l.ConnectionStats.SessionCreatedTotal)
+	a.EqualValues(2, l.MessageStats.Qos1.ReceivedTotal)
+	a.EqualValues(1, l.MessageStats.Qos1.DroppedTotal.QueueFull)
+	a.EqualValues(1, l.PacketStats.ReceivedTotal.Pingreq)
+	a.EqualValues(1, l.PacketStats.ReceivedTotal.Total)
+	// the current values are not accumulated.
+	a.Zero(l.ConnectionStats.ActiveCurrent)
+}
+
+func TestServer_lifetimeStatsDisabled(t *testing.T) {
+	a := assert.New(t)
+	srv := defaultServer()
+	srv.statsManager = newStatsManager(mem.NewStore())
+	a.NoError(srv.loadLifetimeStats(config.DefaultStatsPersistence))
+	a.Nil(srv.statsManager.GetGlobalStats().Lifetime)
+	srv.saveLifetimeStats()
+}
+
+func TestServer_lifetimeStatsCorrupted(t *testing.T) {
+	a := assert.New(t)
+	dir, err := ioutil.TempDir("", "stats")
+	a.NoError(err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "stats.json")
+	a.NoError(ioutil.WriteFile(file, []byte("{"), 0644))
+
+	srv := defaultServer()
+	srv.statsManager = newStatsManager(mem.NewStore())
+	a.Error(srv.loadLifetimeStats(config.StatsPersistence{
+		Enable:   true,
+		File:     file,
+		Interval: time.Minute,
+	}))
+}
@@ -0,0 +1,109 @@
+package server
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/DrmagicE/gmqtt"
+	"github.com/DrmagicE/gmqtt/persistence/subscription"
+	"github.com/DrmagicE/gmqtt/pkg/packets"
+)
+
+// SubscriptionIDStats is the statistics of a subscription identifier of a client,
+// which tells the volume of a logical stream when the client multiplexes many of them over one connection.
+type SubscriptionIDStats struct {
+	ID uint32
+	// TopicFilters is the topic filters of the subscriptions with the identifier, sorted.
+	TopicFilters []string
+	// Delivered is the number of the messages sent to the client carrying the identifier.
+	Delivered uint64
+	// DeliveredBytes is the payload bytes of the messages sent to the client carrying the identifier.
+	DeliveredBytes uint64
+}
+
+type subscriptionIDCounter struct {
+	delivered      uint64
+	deliveredBytes uint64
+}
+
+// subscriptionIDStats counts the messages sent to the clients by the subscription identifiers.
+type subscriptionIDStats struct {
+	// clients is the counters of the clients, key by client id, value is *sync.Map of which
+	// the key is the subscription identifier and the value is *subscriptionIDCounter.
+	clients sync.Map
+}
+
+func (s *subscriptionIDStats) counters(clientID string) *sync.Map {
+	v, ok := s.clients.Load(clientID)
+	if !ok {
+		v, _ = s.clients.LoadOrStore(clientID, &sync.Map{})
+	}
+	return v.(*sync.Map)
+}
+
+// sent counts the publish packet sent to the client for each of its subscription identifiers.
+// The inflight messages resent after reconnecting carry no identifier, so they are not counted.
+func (s *subscriptionIDStats) sent(clientID string, pub *packets.Publish) {
+	if pub.Properties == nil || len(pub.Properties.SubscriptionIdentifier) == 0 {
+		return
+	}
+	counters := s.counters(clientID)
+	for _, id := range pub.Properties.SubscriptionIdentifier {
+		v, ok := counters.Load(id)
+		if !ok {
+			v, _ = counters.LoadOrStore(id, &subscriptionIDCounter{})
+		}
+		c := v.(*subscriptionIDCounter)
+		atomic.AddUint64(&c.delivered, 1)
+		atomic.AddUint64(&c.deliveredBytes, uint64(len(pub.Payload)))
+	}
+}
+
+// remove discards the counters of the client whose session is terminated.
+func (s *subscriptionIDStats) remove(clientID string) {
+	s.clients.Delete(clientID)
+}
+
+// GetSubscriptionIDStats returns the statistics of the subscription identifiers of the client, sorted by the identifier.
+// The counters of the identifiers which are no longer used by any subscription of the client are discarded.
+func (s *statsManager) GetSubscriptionIDStats(clientID string) []SubscriptionIDStats {
+	ids := make(map[uint32]*SubscriptionIDStats)
+	s.subStore.Iterate(func(_ string, sub *gmqtt.Subscription) bool {
+		if sub.ID == 0 {
+			return true
+		}
+		st, ok := ids[sub.ID]
+		if !ok {
+			st = &SubscriptionIDStats{ID: sub.ID}
+			ids[sub.ID] = st
+		}
+		st.TopicFilters = append(st.TopicFilters, sub.GetFullTopicName())
+		return true
+	}, subscription.IterationOptions{
+		Type:     subscription.TypeAll,
+		ClientID: clientID,
+	})
+	if v, ok := s.subscriptionIDStats.clients.Load(clientID); ok {
+		v.(*sync.Map).Range(func(key, value interface{}) bool {
+			st, ok := ids[key.(uint32)]
+			if !ok {
+				v.(*sync.Map).Delete(key)
+				return true
+			}
+			c := value.(*subscriptionIDCounter)
+			st.Delivered = atomic.LoadUint64(&c.delivered)
+			st.DeliveredBytes = atomic.LoadUint64(&c.deliveredBytes)
+			return true
+		})
+	}
+	rs := make([]SubscriptionIDStats, 0, len(ids))
+	for _, v := range ids {
+		sort.Strings(v.TopicFilters)
+		rs = append(rs, *v)
+	}
+	sort.Slice(rs, func(i, j int) bool {
+		return rs[i].ID < rs[j].ID
+	})
+	return rs
+}
@@ -0,0 +1,51 @@
+package server
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DrmagicE/gmqtt"
+	"github.com/DrmagicE/gmqtt/persistence/subscription/mem"
+	"github.com/DrmagicE/gmqtt/pkg/packets"
+)
+
+func TestStatsManager_GetSubscriptionIDStats(t *testing.T) {
+	a := assert.New(t)
+	sub := mem.NewStore()
+	s := newStatsManager(sub)
+	a.Empty(s.GetSubscriptionIDStats("c1"))
+
+	_, err := sub.Subscribe("c1",
+		&gmqtt.Subscription{TopicFilter: "a/+", ID: 1},
+		&gmqtt.Subscription{TopicFilter: "b", ID: 1},
+		&gmqtt.Subscription{ShareName: "g", TopicFilter: "c", ID: 2},
+		&gmqtt.Subscription{TopicFilter: "d"},
+	)
+	a.NoError(err)
+	pub := func(payload string, ids ...uint32) *packets.Publish {
+		return &packets.Publish{
+			Payload:    []byte(payload),
+			Properties: &packets.Properties{SubscriptionIdentifier: ids},
+		}
+	}
+	s.subscriptionIDStats.sent("c1", pub("12", 1))
+	s.subscriptionIDStats.sent("c1", pub("1234", 1, 2))
+	s.subscriptionIDStats.sent("c1", pub("1"))
+	s.subscriptionIDStats.sent("c1", &packets.Publish{Payload: []byte("1")})
+	// the counter of the identifier which is no longer used is discarded.
+	s.subscriptionIDStats.sent("c1", pub("1", 3))
+
+	a.Equal([]SubscriptionIDStats{
+		{ID: 1, TopicFilters: []string{"a/+", "b"}, Delivered: 2, DeliveredBytes: 6},
+		{ID: 2, TopicFilters: []string{"$share/g/c"}, Delivered: 1, DeliveredBytes: 4},
+	}, s.GetSubscriptionIDStats("c1"))
+	v, _ := s.subscriptionIDStats.clients.Load("c1")
+	_, ok := v.(*sync.Map).Load(uint32(3))
+	a.False(ok)
+
+	s.sessionTerminated("c1", NormalTermination)
+	_, ok = s.subscriptionIDStats.clients.Load("c1")
+	a.False(ok)
+}
@@ -0,0 +1,76 @@
+package server
+
+import (
+	"sync"
+
+	"github.com/DrmagicE/gmqtt/pkg/packets"
+)
+
+// TakeoverInfo is the details of a session takeover.
+type TakeoverInfo struct {
+	// Previous is the client which has been closed by the takeover.
+	Previous Client
+	// SessionResumed indicates whether the session is resumed by the new client.
+	// If false, the inflight messages of the previous client are discarded with the session.
+	SessionResumed bool
+	// Unsent is the number of the inflight PUBLISH packets which were queued for the previous client but never written to it.
+	// They are resent without the DUP flag if takeover.dup_flag is strict. 0 if the session is not resumed.
+	Unsent int
+}
+
+// unsentInflight tracks the packet ids of the inflight PUBLISH packets which have never been written to any connection,
+// so that they can be resent without the DUP flag after the session takeover.
+// All methods are nil-safe, the nil tracker tracks nothing.
+type unsentInflight struct {
+	mu  sync.Mutex
+	ids map[packets.PacketID]struct{}
+}
+
+func newUnsentInflight() *unsentInflight {
+	return &unsentInflight{
+		ids: make(map[packets.PacketID]struct{}),
+	}
+}
+
+// add is called when the packet id is assigned to the message.
+func (u *unsentInflight) add(id packets.PacketID) {
+	if u == nil {
+		return
+	}
+	u.mu.Lock()
+	u.ids[id] = struct{}{}
+	u.mu.Unlock()
+}
+
+// written is called before the PUBLISH packet is written to the connection.
+// The packet which fails to be written is considered as written, because it may have been partially written.
+func (u *unsentInflight) written(pub *packets.Publish) {
+	if u == nil || pub.Qos == packets.Qos0 {
+		return
+	}
+	u.mu.Lock()
+	delete(u.ids, pub.PacketID)
+	u.mu.Unlock()
+}
+
+// handoff returns the tracked packet ids, it must be called after the client is closed.
+func (u *unsentInflight) handoff() map[packets.PacketID]struct{} {
+	if u == nil {
+		return nil
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	ids := u.ids
+	u.ids = make(map[packets.PacketID]struct{})
+	return ids
+}
+
+// takeUnsent returns whether the inflight message of the packet id was handed off as unsent from the previous client,
+// the id is removed from the handoff.
+func (client *client) takeUnsent(id packets.PacketID) bool {
+	if _, ok := client.takeoverUnsent[id]; ok {
+		delete(client.takeoverUnsent, id)
+		return true
+	}
+	return false
+}
@@ -0,0 +1,71 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DrmagicE/gmqtt"
+	"github.com/DrmagicE/gmqtt/persistence/queue"
+	"github.com/DrmagicE/gmqtt/persistence/subscription/mem"
+	"github.com/DrmagicE/gmqtt/pkg/packets"
+)
+
+func TestUnsentInflight(t *testing.T) {
+	a := assert.New(t)
+	var nilTracker *unsentInflight
+	nilTracker.add(1)
+	a.Nil(nilTracker.handoff())
+
+	u := newUnsentInflight()
+	u.add(1)
+	u.add(2)
+	u.add(3)
+	u.written(&packets.Publish{Qos: packets.Qos1, PacketID: 2})
+	// QoS 0 packets have no packet id.
+	u.written(&packets.Publish{Qos: packets.Qos0})
+	a.Equal(map[packets.PacketID]struct{}{1: {}, 3: {}}, u.handoff())
+	a.Empty(u.handoff())
+}
+
+func TestClient_pollInflights_takeover(t *testing.T) {
+	a := assert.New(t)
+	srv := defaultServer()
+	srv.statsManager = newStatsManager(mem.NewStore())
+	c, err := srv.newClient(noopConn{})
+	a.NoError(err)
+	c.opts.ClientID = "cid"
+	c.opts.MaxInflight = 10
+	c.version = packets.Version5
+	c.newPacketIDLimiter(10)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	q := queue.NewMockStore(ctrl)
+	var elems []*queue.Elem
+	for i := 1; i <= 2; i++ {
+		elems = append(elems, &queue.Elem{
+			MessageWithID: &queue.Publish{
+				Message: &gmqtt.Message{QoS: packets.Qos1, Topic: "a", Payload: []byte("b"), PacketID: packets.PacketID(i)},
+			},
+		})
+	}
+	q.EXPECT().ReadInflight(uint(10)).Return(elems, nil)
+	c.queueStore = q
+	// packet id 2 was never written to the previous client.
+	c.takeoverUnsent = map[packets.PacketID]struct{}{2: {}}
+
+	cont, err := c.pollInflights()
+	a.NoError(err)
+	a.True(cont)
+	p1 := (<-c.out).(*packets.Publish)
+	a.EqualValues(1, p1.PacketID)
+	a.True(p1.Dup)
+	p2 := (<-c.out).(*packets.Publish)
+	a.EqualValues(2, p2.PacketID)
+	a.False(p2.Dup)
+	a.Empty(c.takeoverUnsent)
+	// it is still unsent until written, so it can be handed off again.
+	a.Equal(map[packets.PacketID]struct{}{2: {}}, c.unsent.handoff())
+}
@@ -8,7 +8,7 @@ import (
 type NewTopicAliasManager func(config config.Config, maxAlias uint16, clientID string) TopicAliasManager
 
 // TopicAliasManager manage the topic alias for a V5 client.
-// see topicalias/fifo for more details.
+// see topicalias/fifo, topicalias/lru and topicalias/lfu for more details.
 type TopicAliasManager interface {
 	// Check return the alias number and whether the alias exist.
 	// For examples:
@@ -0,0 +1,137 @@
+package server
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/DrmagicE/gmqtt"
+	"github.com/DrmagicE/gmqtt/persistence/subscription"
+)
+
+// TopicFilterStats is the statistics of a topic filter.
+type TopicFilterStats struct {
+	// TopicFilter is the full topic filter, including the "$share/{ShareName}/" prefix of the shared subscriptions.
+	TopicFilter string
+	// Namespace is the first level of the topic filter, e.g. "sensors" of "sensors/+/temperature".
+	Namespace string
+	// Subscribers is the number of the clients that subscribe to the topic filter.
+	Subscribers uint64
+	// Matched is the number of the published messages that match the topic filter.
+	Matched uint64
+}
+
+// NamespaceStats is the statistics of the topic filters of a top-level namespace.
+type NamespaceStats struct {
+	Namespace string
+	// TopicFilters is the number of the topic filters in the namespace.
+	TopicFilters uint64
+	// Subscribers is the number of the subscriptions in the namespace.
+	Subscribers uint64
+	// Matched is the sum of the Matched of the topic filters in the namespace.
+	Matched uint64
+}
+
+// TopicStats is the statistics of the topic filters that have subscribers, sorted by the number of the subscribers in descending order.
+type TopicStats struct {
+	TopicFilters []TopicFilterStats
+	Namespaces   []NamespaceStats
+}
+
+// TopicNamespace returns the top-level namespace of the topic filter, which is the first level of the topic filter
+// without the "$share/{ShareName}/" prefix.
+func TopicNamespace(topicFilter string) string {
+	_, topicFilter = subscription.SplitTopic(topicFilter)
+	if i := strings.IndexByte(topicFilter, '/'); i != -1 {
+		return topicFilter[:i]
+	}
+	return topicFilter
+}
+
+// topicStats counts the matched messages of the topic filters.
+type topicStats struct {
+	// matched is the matched counter of the topic filters, key by the full topic filter, value is *uint64.
+	matched sync.Map
+}
+
+// newMatchCounter returns a function to count the message that matches the subscription, the subscriptions of the same topic filter
+// are counted only once for each message.
+func (t *topicStats) newMatchCounter() func(sub *gmqtt.Subscription) {
+	var counted map[string]struct{}
+	return func(sub *gmqtt.Subscription) {
+		filter := sub.GetFullTopicName()
+		if _, ok := counted[filter]; ok {
+			return
+		}
+		if counted == nil {
+			counted = make(map[string]struct{})
+		}
+		counted[filter] = struct{}{}
+		v, ok := t.matched.Load(filter)
+		if !ok {
+			v, _ = t.matched.LoadOrStore(filter, new(uint64))
+		}
+		atomic.AddUint64(v.(*uint64), 1)
+	}
+}
+
+// GetTopicStats returns the statistics of the topic filters which have subscribers.
+// The matched counters of the topic filters without subscribers are discarded.
+func (s *statsManager) GetTopicStats() TopicStats {
+	filters := make(map[string]*TopicFilterStats)
+	s.subStore.Iterate(func(clientID string, sub *gmqtt.Subscription) bool {
+		name := sub.GetFullTopicName()
+		f, ok := filters[name]
+		if !ok {
+			f = &TopicFilterStats{
+				TopicFilter: name,
+				Namespace:   TopicNamespace(name),
+			}
+			filters[name] = f
+		}
+		f.Subscribers++
+		return true
+	}, subscription.IterationOptions{
+		Type: subscription.TypeAll,
+	})
+	s.topicStats.matched.Range(func(key, value interface{}) bool {
+		if f, ok := filters[key.(string)]; ok {
+			f.Matched = atomic.LoadUint64(value.(*uint64))
+		} else {
+			s.topicStats.matched.Delete(key)
+		}
+		return true
+	})
+	var rs TopicStats
+	namespaces := make(map[string]*NamespaceStats)
+	for _, f := range filters {
+		rs.TopicFilters = append(rs.TopicFilters, *f)
+		ns, ok := namespaces[f.Namespace]
+		if !ok {
+			ns = &NamespaceStats{Namespace: f.Namespace}
+			namespaces[f.Namespace] = ns
+		}
+		ns.TopicFilters++
+		ns.Subscribers += f.Subscribers
+		ns.Matched += f.Matched
+	}
+	for _, ns := range namespaces {
+		rs.Namespaces = append(rs.Namespaces, *ns)
+	}
+	sort.Slice(rs.TopicFilters, func(i, j int) bool {
+		a, b := rs.TopicFilters[i], rs.TopicFilters[j]
+		if a.Subscribers != b.Subscribers {
+			return a.Subscribers > b.Subscribers
+		}
+		return a.TopicFilter < b.TopicFilter
+	})
+	sort.Slice(rs.Namespaces, func(i, j int) bool {
+		a, b := rs.Namespaces[i], rs.Namespaces[j]
+		if a.Subscribers != b.Subscribers {
+			return a.Subscribers > b.Subscribers
+		}
+		return a.Namespace < b.Namespace
+	})
+	return rs
+}
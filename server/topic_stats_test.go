@@ -0,0 +1,53 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DrmagicE/gmqtt"
+	"github.com/DrmagicE/gmqtt/persistence/subscription/mem"
+)
+
+func TestTopicNamespace(t *testing.T) {
+	a := assert.New(t)
+	a.Equal("sensors", TopicNamespace("sensors/+/temperature"))
+	a.Equal("sensors", TopicNamespace("$share/g/sensors/#"))
+	a.Equal("#", TopicNamespace("#"))
+	a.Equal("$SYS", TopicNamespace("$SYS/brokers"))
+	a.Equal("", TopicNamespace("/a"))
+}
+
+func TestStatsManager_GetTopicStats(t *testing.T) {
+	a := assert.New(t)
+	sub := mem.NewStore()
+	s := newStatsManager(sub)
+	a.Empty(s.GetTopicStats().TopicFilters)
+
+	_, err := sub.Subscribe("c1", &gmqtt.Subscription{TopicFilter: "a/+"}, &gmqtt.Subscription{TopicFilter: "b"})
+	a.NoError(err)
+	_, err = sub.Subscribe("c2", &gmqtt.Subscription{TopicFilter: "a/+"}, &gmqtt.Subscription{ShareName: "g", TopicFilter: "a/b"})
+	a.NoError(err)
+
+	// a message on "a/b" matches a/+ twice, but it is counted once.
+	count := s.topicStats.newMatchCounter()
+	count(&gmqtt.Subscription{TopicFilter: "a/+"})
+	count(&gmqtt.Subscription{TopicFilter: "a/+"})
+	count(&gmqtt.Subscription{ShareName: "g", TopicFilter: "a/b"})
+	s.topicStats.newMatchCounter()(&gmqtt.Subscription{TopicFilter: "a/+"})
+	// the counter of the topic filter without subscribers is discarded.
+	s.topicStats.newMatchCounter()(&gmqtt.Subscription{TopicFilter: "orphan"})
+
+	rs := s.GetTopicStats()
+	a.Equal([]TopicFilterStats{
+		{TopicFilter: "a/+", Namespace: "a", Subscribers: 2, Matched: 2},
+		{TopicFilter: "$share/g/a/b", Namespace: "a", Subscribers: 1, Matched: 1},
+		{TopicFilter: "b", Namespace: "b", Subscribers: 1, Matched: 0},
+	}, rs.TopicFilters)
+	a.Equal([]NamespaceStats{
+		{Namespace: "a", TopicFilters: 2, Subscribers: 3, Matched: 3},
+		{Namespace: "b", TopicFilters: 1, Subscribers: 1, Matched: 0},
+	}, rs.Namespaces)
+	_, ok := s.topicStats.matched.Load("orphan")
+	a.False(ok)
+}
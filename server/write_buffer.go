@@ -0,0 +1,160 @@
+package server
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/DrmagicE/gmqtt/config"
+	"github.com/DrmagicE/gmqtt/pkg/codes"
+	"github.com/DrmagicE/gmqtt/pkg/packets"
+)
+
+// errWriteBufferExceeded closes the connection if the write buffer stays above the hard limit for longer than the hard_limit_timeout.
+var errWriteBufferExceeded = errors.New("the write buffer exceeds the hard limit")
+
+// disconnectWriteTimeout is the write timeout of the DISCONNECT packet sent to the slow consumer.
+const disconnectWriteTimeout = time.Second
+
+// writeBuffer tracks the bytes of the PUBLISH packets which are waiting to be written to the client,
+// including the packets waiting in or blocked on the client.out channel and the packet being written.
+// All methods are nil-safe, the nil buffer tracks nothing.
+type writeBuffer struct {
+	mu     sync.Mutex
+	config config.WriteBuffer
+	size   int
+	// resume is closed when the paused replay resumes, nil if the replay is not paused.
+	resume chan struct{}
+	// timer fires after the hard_limit_timeout since the size exceeds the hard limit, nil if the size does not exceed.
+	timer *time.Timer
+	// isExpired indicates whether the size has stayed above the hard limit for longer than the hard_limit_timeout.
+	isExpired bool
+	// onExpired is called when the timer fires.
+	onExpired func()
+}
+
+// newWriteBuffer returns the writeBuffer, or nil if the write buffer is not enabled.
+func newWriteBuffer(config config.WriteBuffer, onExpired func()) *writeBuffer {
+	if !config.Enabled() {
+		return nil
+	}
+	return &writeBuffer{
+		config:    config,
+		onExpired: onExpired,
+	}
+}
+
+// bufferedBytes returns the approximate bytes of the packet in the write buffer.
+// Only the topic name and the payload of the PUBLISH packets are counted, the other packets are negligible.
+func bufferedBytes(packet packets.Packet) int {
+	if p, ok := packet.(*packets.Publish); ok {
+		return len(p.TopicName) + len(p.Payload)
+	}
+	return 0
+}
+
+// add is called before the packet is passed to the write loop.
+func (w *writeBuffer) add(n int) {
+	if w == nil || n == 0 {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.size += n
+	if w.config.HighWatermark > 0 && w.size >= w.config.HighWatermark && w.resume == nil {
+		w.resume = make(chan struct{})
+	}
+	if w.config.HardLimit > 0 && w.size > w.config.HardLimit && w.timer == nil {
+		w.timer = time.AfterFunc(w.config.HardLimitTimeout, w.expire)
+	}
+}
+
+// done is called after the packet is written or discarded by the write loop.
+func (w *writeBuffer) done(n int) {
+	if w == nil || n == 0 {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.size -= n
+	if w.resume != nil && w.size <= w.config.LowWatermark {
+		close(w.resume)
+		w.resume = nil
+	}
+	if w.timer != nil && w.size <= w.config.HardLimit && !w.isExpired {
+		w.timer.Stop()
+		w.timer = nil
+	}
+}
+
+func (w *writeBuffer) expire() {
+	w.mu.Lock()
+	// the size may fall below the hard limit after the timer fires.
+	if w.timer == nil {
+		w.mu.Unlock()
+		return
+	}
+	w.isExpired = true
+	w.mu.Unlock()
+	w.onExpired()
+}
+
+// expired returns whether the size has stayed above the hard limit for longer than the hard_limit_timeout.
+func (w *writeBuffer) expired() bool {
+	if w == nil {
+		return false
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.isExpired
+}
+
+// paused returns whether the replay is paused.
+func (w *writeBuffer) paused() bool {
+	if w == nil {
+		return false
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.resume != nil
+}
+
+// wait blocks until the paused replay resumes.
+// It returns false if the close channel is closed while waiting.
+func (w *writeBuffer) wait(close <-chan struct{}) bool {
+	if w == nil {
+		return true
+	}
+	w.mu.Lock()
+	resume := w.resume
+	w.mu.Unlock()
+	if resume == nil {
+		return true
+	}
+	select {
+	case <-close:
+		return false
+	case <-resume:
+		return true
+	}
+}
+
+// writeBufferExpired unblocks the write loop which may be blocked by the slow connection.
+func (client *client) writeBufferExpired() {
+	_ = client.rwc.SetWriteDeadline(time.Now())
+}
+
+// closeSlowConsumer closes the client whose write buffer has stayed above the hard limit for too long.
+// If sendDisconnect is true, which means no packet is partially written, the DISCONNECT packet with
+// 0x96 (Message rate too high) is sent to the V5 client before closing.
+func (client *client) closeSlowConsumer(sendDisconnect bool) {
+	if sendDisconnect && client.version == packets.Version5 {
+		_ = client.rwc.SetWriteDeadline(time.Now().Add(disconnectWriteTimeout))
+		_ = client.writePacket(&packets.Disconnect{
+			Version: packets.Version5,
+			Code:    codes.MessageRateTooHigh,
+		})
+	}
+	client.setError(errWriteBufferExceeded)
+	client.Close()
+}
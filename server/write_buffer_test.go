@@ -0,0 +1,104 @@
+package server
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DrmagicE/gmqtt/config"
+	"github.com/DrmagicE/gmqtt/persistence/subscription/mem"
+	"github.com/DrmagicE/gmqtt/pkg/codes"
+	"github.com/DrmagicE/gmqtt/pkg/packets"
+)
+
+func TestWriteBuffer(t *testing.T) {
+	a := assert.New(t)
+	a.Nil(newWriteBuffer(config.WriteBuffer{}, nil))
+
+	expired := make(chan struct{}, 1)
+	w := newWriteBuffer(config.WriteBuffer{
+		LowWatermark:     10,
+		HighWatermark:    100,
+		HardLimit:        150,
+		HardLimitTimeout: 20 * time.Millisecond,
+	}, func() {
+		expired <- struct{}{}
+	})
+	w.add(60)
+	a.False(w.paused())
+	w.add(50)
+	a.True(w.paused())
+	// the replay resumes at the low watermark.
+	w.done(60)
+	a.True(w.paused())
+	done := make(chan bool)
+	go func() {
+		done <- w.wait(nil)
+	}()
+	w.done(45)
+	a.False(w.paused())
+	select {
+	case ok := <-done:
+		a.True(ok)
+	case <-time.After(time.Second):
+		t.Fatal("wait is not resumed")
+	}
+
+	// the timer is stopped if the size falls below the hard limit in time.
+	w.add(200)
+	w.done(200)
+	time.Sleep(50 * time.Millisecond)
+	a.False(w.expired())
+
+	w.add(200)
+	select {
+	case <-expired:
+		a.True(w.expired())
+	case <-time.After(time.Second):
+		t.Fatal("the hard limit is not expired")
+	}
+
+	// wait is aborted by close.
+	w.add(100)
+	a.True(w.paused())
+	closing := make(chan struct{})
+	go func() {
+		done <- w.wait(closing)
+	}()
+	close(closing)
+	a.False(<-done)
+}
+
+func TestClient_closeSlowConsumer(t *testing.T) {
+	a := assert.New(t)
+	srv := defaultServer()
+	srv.statsManager = newStatsManager(mem.NewStore())
+	srv.config.MQTT.WriteBuffer = config.WriteBuffer{
+		HighWatermark:    100,
+		HardLimit:        150,
+		HardLimitTimeout: 20 * time.Millisecond,
+	}
+	// the peer never reads, so the write loop is blocked.
+	conn, peer := net.Pipe()
+	defer peer.Close()
+	c, err := srv.newClient(conn)
+	a.NoError(err)
+	c.opts.ClientID = "cid"
+	c.version = packets.Version5
+	go c.writeLoop()
+
+	c.write(&packets.Publish{Version: packets.Version5, TopicName: []byte("a"), Payload: make([]byte, 100)})
+	c.write(&packets.Publish{Version: packets.Version5, TopicName: []byte("a"), Payload: make([]byte, 100)})
+	a.True(c.writeBuffer.paused())
+	select {
+	case <-c.close:
+	case <-time.After(time.Second):
+		t.Fatal("the slow consumer is not closed")
+	}
+	a.False(c.writeBuffer.wait(c.close))
+	r := c.newCloseReason(c.closeErr)
+	a.Equal(CloseWriteBufferExceeded, r.Type)
+	a.Equal(codes.MessageRateTooHigh, r.Code)
+}
@@ -0,0 +1,142 @@
+//go:build go1.18
+// +build go1.18
+
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"net"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/DrmagicE/gmqtt/pkg/packets"
+)
+
+// fuzzConn is a net.Conn which reads from the given bytes and discards the writes.
+type fuzzConn struct {
+	r *bytes.Reader
+}
+
+func (c *fuzzConn) Read(b []byte) (int, error)         { return c.r.Read(b) }
+func (c *fuzzConn) Write(b []byte) (int, error)        { return len(b), nil }
+func (c *fuzzConn) Close() error                       { return nil }
+func (c *fuzzConn) LocalAddr() net.Addr                { return &net.TCPAddr{} }
+func (c *fuzzConn) RemoteAddr() net.Addr               { return &net.TCPAddr{} }
+func (c *fuzzConn) SetDeadline(t time.Time) error      { return nil }
+func (c *fuzzConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *fuzzConn) SetWriteDeadline(t time.Time) error { return nil }
+
+// hijackWriter is a http.ResponseWriter which hands over the fuzzConn to the upgrader.
+type hijackWriter struct {
+	http.ResponseWriter
+	conn net.Conn
+}
+
+func (w *hijackWriter) Header() http.Header {
+	return http.Header{}
+}
+
+func (w *hijackWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return w.conn, bufio.NewReadWriter(bufio.NewReader(w.conn), bufio.NewWriter(w.conn)), nil
+}
+
+// newFuzzWsConn upgrades the connection whose client frames are the given bytes.
+func newFuzzWsConn(t *testing.T, frames []byte) *wsConn {
+	conn := &fuzzConn{r: bytes.NewReader(frames)}
+	r, _ := http.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Connection", "Upgrade")
+	r.Header.Set("Upgrade", "websocket")
+	r.Header.Set("Sec-Websocket-Version", "13")
+	r.Header.Set("Sec-Websocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	c, err := defaultUpgrader.Upgrade(&hijackWriter{conn: conn}, r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &wsConn{Conn: conn, c: c}
+}
+
+// clientFrame returns the masked websocket frame of the client with the zero masking key.
+func clientFrame(fin bool, opcode byte, payload []byte) []byte {
+	first := opcode
+	if fin {
+		first |= 0x80
+	}
+	b := []byte{first}
+	switch {
+	case len(payload) < 126:
+		b = append(b, 0x80|byte(len(payload)))
+	case len(payload) <= 0xffff:
+		b = append(b, 0x80|126, 0, 0)
+		binary.BigEndian.PutUint16(b[2:], uint16(len(payload)))
+	default:
+		b = append(b, 0x80|127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(b[2:], uint64(len(payload)))
+	}
+	b = append(b, 0, 0, 0, 0)
+	return append(b, payload...)
+}
+
+// readAll reads the packets until any error occurs.
+func readAll(r *packets.Reader) (rs []packets.Packet) {
+	for {
+		p, err := r.ReadPacket()
+		if err != nil {
+			return rs
+		}
+		rs = append(rs, p)
+	}
+}
+
+// FuzzWebsocketFraming feeds arbitrary bytes as the websocket frames of the client.
+func FuzzWebsocketFraming(f *testing.F) {
+	f.Add(clientFrame(true, websocket.BinaryMessage, []byte{0xc0, 0}))
+	f.Add(append(clientFrame(false, websocket.BinaryMessage, []byte{0xc0}), clientFrame(true, 0, []byte{0})...))
+	f.Add(clientFrame(true, websocket.TextMessage, []byte{0xc0, 0}))
+	f.Add(clientFrame(true, websocket.PingMessage, []byte("ping")))
+	f.Fuzz(func(t *testing.T, frames []byte) {
+		readAll(packets.NewReader(newFuzzWsConn(t, frames)))
+	})
+}
+
+// FuzzWebsocketMessages checks that the packets are decoded regardless of how they are split into the
+// binary messages, each byte of sizes is the payload size of the next message.
+func FuzzWebsocketMessages(f *testing.F) {
+	pingreq := []byte{0xc0, 0}
+	connect := []byte{0x10, 0x0d, 0, 4, 'M', 'Q', 'T', 'T', 4, 2, 0, 60, 0, 1, 'c'}
+	f.Add(append(connect, pingreq...), []byte{1, 1, 2})
+	f.Add(append(connect, pingreq...), []byte{16})
+	f.Add(append(connect, pingreq...), []byte{0, 3})
+	f.Add(pingreq, bytes.Repeat([]byte{0}, 128))
+	// the message is 1 byte larger than the read buffer.
+	publish := append([]byte{0x30, 0xed, 0x0f, 0, 1, 'a'}, bytes.Repeat([]byte{'p'}, 2026)...)
+	f.Add(append(append(connect, publish...), pingreq...), []byte{})
+	f.Fuzz(func(t *testing.T, data []byte, sizes []byte) {
+		var frames []byte
+		rest := data
+		for _, s := range sizes {
+			if len(rest) == 0 {
+				break
+			}
+			n := int(s)
+			if n > len(rest) {
+				n = len(rest)
+			}
+			frames = append(frames, clientFrame(true, websocket.BinaryMessage, rest[:n])...)
+			rest = rest[n:]
+		}
+		if len(rest) != 0 {
+			frames = append(frames, clientFrame(true, websocket.BinaryMessage, rest)...)
+		}
+		want := readAll(packets.NewReader(bytes.NewReader(data)))
+		got := readAll(packets.NewReader(newFuzzWsConn(t, frames)))
+		if !reflect.DeepEqual(want, got) {
+			t.Fatalf("expected %d packets, got %d", len(want), len(got))
+		}
+	})
+}
@@ -16,6 +16,9 @@ type Session struct {
 	ConnectedAt time.Time
 	// ExpiryInterval represents the Session Expiry Interval in seconds
 	ExpiryInterval uint32
+	// Attributes is the key-value attributes of the client, e.g. device model, firmware version and tenant.
+	// It is persisted with the session, nil if there is no attribute.
+	Attributes map[string]string
 }
 
 // IsExpired return whether the session is expired
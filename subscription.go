@@ -26,6 +26,9 @@ type Subscription struct {
 	RetainAsPublished bool
 	// RetainHandling the Retain Handling option.
 	RetainHandling byte
+	// UserProperties is the user properties of the V5 SUBSCRIBE packet,
+	// which scope the delivery of the messages by the property_routing rules.
+	UserProperties []packets.UserProperty
 }
 
 // GetFullTopicName returns the full topic name of the subscription.
@@ -38,7 +41,7 @@ func (s *Subscription) GetFullTopicName() string {
 
 // Copy makes a copy of subscription.
 func (s *Subscription) Copy() *Subscription {
-	return &Subscription{
+	sub := &Subscription{
 		ShareName:         s.ShareName,
 		TopicFilter:       s.TopicFilter,
 		ID:                s.ID,
@@ -47,6 +50,16 @@ func (s *Subscription) Copy() *Subscription {
 		RetainAsPublished: s.RetainAsPublished,
 		RetainHandling:    s.RetainHandling,
 	}
+	if len(s.UserProperties) != 0 {
+		sub.UserProperties = make([]packets.UserProperty, len(s.UserProperties))
+		for k, v := range s.UserProperties {
+			sub.UserProperties[k] = packets.UserProperty{
+				K: append([]byte(nil), v.K...),
+				V: append([]byte(nil), v.V...),
+			}
+		}
+	}
+	return sub
 }
 
 // Validate returns whether the subscription is valid.
@@ -0,0 +1,113 @@
+package lfu
+
+import (
+	"container/heap"
+
+	"github.com/DrmagicE/gmqtt/config"
+	"github.com/DrmagicE/gmqtt/pkg/packets"
+	"github.com/DrmagicE/gmqtt/server"
+)
+
+var _ server.TopicAliasManager = (*Cache)(nil)
+
+func init() {
+	server.RegisterTopicAliasMgrFactory(config.TopicAliasMgrTypeLFU, New)
+}
+
+// New is the constructor of Cache.
+func New(config config.Config, maxAlias uint16, clientID string) server.TopicAliasManager {
+	return &Cache{
+		clientID: clientID,
+		max:      int(maxAlias),
+		index:    make(map[string]*aliasElem),
+	}
+}
+
+// Cache is the lfu cache which store all topic alias for one client.
+// When the alias has been exhausted, the alias of the least frequently used topic will be reassigned,
+// the least recently used one is chosen if there are more than one such topics.
+type Cache struct {
+	clientID string
+	max      int
+	// seq is increased on every Check call to record the last used time.
+	seq   uint64
+	alias aliasHeap
+	// topic name => alias element
+	index map[string]*aliasElem
+}
+
+type aliasElem struct {
+	topic string
+	alias uint16
+	// count is the number of times the topic has been published.
+	count uint64
+	// lastUsed is the seq when the topic was last published.
+	lastUsed uint64
+	// index is the position in the heap.
+	index int
+}
+
+// aliasHeap is a min-heap of aliasElem ordered by count and lastUsed.
+type aliasHeap []*aliasElem
+
+func (h aliasHeap) Len() int {
+	return len(h)
+}
+
+func (h aliasHeap) Less(i, j int) bool {
+	if h[i].count == h[j].count {
+		return h[i].lastUsed < h[j].lastUsed
+	}
+	return h[i].count < h[j].count
+}
+
+func (h aliasHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *aliasHeap) Push(x interface{}) {
+	e := x.(*aliasElem)
+	e.index = len(*h)
+	*h = append(*h, e)
+}
+
+func (h *aliasHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	e := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return e
+}
+
+func (c *Cache) Check(publish *packets.Publish) (alias uint16, exist bool) {
+	topicName := string(publish.TopicName)
+	c.seq++
+	// alias exist
+	if e, ok := c.index[topicName]; ok {
+		e.count++
+		e.lastUsed = c.seq
+		heap.Fix(&c.alias, e.index)
+		return e.alias, true
+	}
+	l := c.alias.Len()
+	// alias has been exhausted
+	if l == c.max {
+		elem := heap.Pop(&c.alias).(*aliasElem)
+		delete(c.index, elem.topic)
+		alias = elem.alias
+	} else {
+		alias = uint16(l + 1)
+	}
+	e := &aliasElem{
+		topic:    topicName,
+		alias:    alias,
+		count:    1,
+		lastUsed: c.seq,
+	}
+	heap.Push(&c.alias, e)
+	c.index[topicName] = e
+	return
+}
@@ -0,0 +1,57 @@
+package lfu
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DrmagicE/gmqtt/config"
+	"github.com/DrmagicE/gmqtt/pkg/packets"
+)
+
+func TestCache(t *testing.T) {
+	a := assert.New(t)
+
+	cid := "clientID"
+	max := uint16(3)
+	c := New(config.DefaultConfig(), max, cid).(*Cache)
+	for i := uint16(1); i <= max; i++ {
+		alias, ok := c.Check(&packets.Publish{
+			TopicName: []byte(strconv.Itoa(int(i))),
+		})
+		a.Equal(i, alias)
+		a.False(ok)
+	}
+	// "1" and "3" are published twice, "2" is the least frequently used topic.
+	for _, v := range []string{"1", "3"} {
+		alias, ok := c.Check(&packets.Publish{TopicName: []byte(v)})
+		a.True(ok)
+		a.EqualValues(v, strconv.Itoa(int(alias)))
+	}
+
+	alias, ok := c.Check(&packets.Publish{TopicName: []byte("4")})
+	a.False(ok)
+	a.EqualValues(2, alias)
+
+	// "4" is the least frequently used topic.
+	alias, ok = c.Check(&packets.Publish{TopicName: []byte("5")})
+	a.False(ok)
+	a.EqualValues(2, alias)
+
+	// "1" and "3" have the same frequency, "1" is the least recently used one.
+	alias, ok = c.Check(&packets.Publish{TopicName: []byte("5")})
+	a.True(ok)
+	a.EqualValues(2, alias)
+	alias, ok = c.Check(&packets.Publish{TopicName: []byte("5")})
+	a.True(ok)
+	a.EqualValues(2, alias)
+	alias, ok = c.Check(&packets.Publish{TopicName: []byte("6")})
+	a.False(ok)
+	a.EqualValues(1, alias)
+
+	a.Equal(3, c.alias.Len())
+	a.Len(c.index, 3)
+	_, ok = c.index["1"]
+	a.False(ok)
+}
@@ -0,0 +1,66 @@
+package lru
+
+import (
+	"container/list"
+
+	"github.com/DrmagicE/gmqtt/config"
+	"github.com/DrmagicE/gmqtt/pkg/packets"
+	"github.com/DrmagicE/gmqtt/server"
+)
+
+var _ server.TopicAliasManager = (*Cache)(nil)
+
+func init() {
+	server.RegisterTopicAliasMgrFactory(config.TopicAliasMgrTypeLRU, New)
+}
+
+// New is the constructor of Cache.
+func New(config config.Config, maxAlias uint16, clientID string) server.TopicAliasManager {
+	return &Cache{
+		clientID: clientID,
+		max:      int(maxAlias),
+		alias:    list.New(),
+		index:    make(map[string]*list.Element),
+	}
+}
+
+// Cache is the lru cache which store all topic alias for one client.
+// When the alias has been exhausted, the alias of the least recently used topic will be reassigned.
+type Cache struct {
+	clientID string
+	max      int
+	// the front is the least recently used alias.
+	alias *list.List
+	// topic name => alias element
+	index map[string]*list.Element
+}
+
+type aliasElem struct {
+	topic string
+	alias uint16
+}
+
+func (c *Cache) Check(publish *packets.Publish) (alias uint16, exist bool) {
+	topicName := string(publish.TopicName)
+	// alias exist
+	if e, ok := c.index[topicName]; ok {
+		c.alias.MoveToBack(e)
+		return e.Value.(*aliasElem).alias, true
+	}
+	l := c.alias.Len()
+	// alias has been exhausted
+	if l == c.max {
+		first := c.alias.Front()
+		elem := first.Value.(*aliasElem)
+		c.alias.Remove(first)
+		delete(c.index, elem.topic)
+		alias = elem.alias
+	} else {
+		alias = uint16(l + 1)
+	}
+	c.index[topicName] = c.alias.PushBack(&aliasElem{
+		topic: topicName,
+		alias: alias,
+	})
+	return
+}
@@ -0,0 +1,47 @@
+package lru
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DrmagicE/gmqtt/config"
+	"github.com/DrmagicE/gmqtt/pkg/packets"
+)
+
+func TestCache(t *testing.T) {
+	a := assert.New(t)
+
+	cid := "clientID"
+	max := uint16(10)
+	c := New(config.DefaultConfig(), max, cid).(*Cache)
+	for i := uint16(1); i <= max; i++ {
+		alias, ok := c.Check(&packets.Publish{
+			TopicName: []byte(strconv.Itoa(int(i))),
+		})
+		a.Equal(i, alias)
+		a.False(ok)
+	}
+	a.Equal(10, c.alias.Len())
+
+	// alias exist, "1" becomes the most recently used topic.
+	alias, ok := c.Check(&packets.Publish{TopicName: []byte("1")})
+	a.True(ok)
+	a.EqualValues(1, alias)
+
+	// the alias of "2" is reassigned.
+	alias, ok = c.Check(&packets.Publish{TopicName: []byte("not exist")})
+	a.False(ok)
+	a.EqualValues(2, alias)
+
+	alias, ok = c.Check(&packets.Publish{TopicName: []byte("1")})
+	a.True(ok)
+	a.EqualValues(1, alias)
+
+	alias, ok = c.Check(&packets.Publish{TopicName: []byte("2")})
+	a.False(ok)
+	a.EqualValues(3, alias)
+	a.Equal(10, c.alias.Len())
+	a.Len(c.index, 10)
+}